@@ -17,6 +17,7 @@ import (
 	"github.com/openshift/machine-config-operator/pkg/controller/node"
 	"github.com/openshift/machine-config-operator/pkg/controller/render"
 	"github.com/openshift/machine-config-operator/pkg/controller/template"
+	"github.com/openshift/machine-config-operator/pkg/controller/webhook"
 	"github.com/openshift/machine-config-operator/pkg/version"
 	"github.com/spf13/cobra"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -37,6 +38,8 @@ var (
 		templates                string
 		promMetricsListenAddress string
 		resourceLockNamespace    string
+		webhookListenAddress     string
+		webhookCertDir           string
 	}
 )
 
@@ -45,6 +48,8 @@ func init() {
 	startCmd.PersistentFlags().StringVar(&startOpts.kubeconfig, "kubeconfig", "", "Kubeconfig file to access a remote cluster (testing only)")
 	startCmd.PersistentFlags().StringVar(&startOpts.resourceLockNamespace, "resourcelock-namespace", metav1.NamespaceSystem, "Path to the template files used for creating MachineConfig objects")
 	startCmd.PersistentFlags().StringVar(&startOpts.promMetricsListenAddress, "metrics-listen-address", "127.0.0.1:8797", "Listen address for prometheus metrics listener")
+	startCmd.PersistentFlags().StringVar(&startOpts.webhookListenAddress, "webhook-listen-address", "", "Listen address for the MachineConfig deletion and custom Dockerfile webhooks; disabled if empty")
+	startCmd.PersistentFlags().StringVar(&startOpts.webhookCertDir, "webhook-cert-dir", "/etc/tls/private", "Directory containing the tls.crt and tls.key files for the webhooks")
 }
 
 func runStartCmd(_ *cobra.Command, _ []string) {
@@ -72,6 +77,14 @@ func runStartCmd(_ *cobra.Command, _ []string) {
 		go ctrlcommon.StartMetricsListener(startOpts.promMetricsListenAddress, ctrlctx.Stop, ctrlcommon.RegisterMCCMetrics)
 
 		controllers := createControllers(ctrlctx)
+
+		var webhookServer *webhook.Server
+		if startOpts.webhookListenAddress != "" {
+			webhookServer = webhook.NewServer(
+				ctrlctx.InformerFactory.Machineconfiguration().V1().MachineConfigPools(),
+				ctrlctx.KubeInformerFactory.Core().V1().Nodes(),
+			)
+		}
 		draincontroller := drain.New(
 			drain.DefaultConfig(),
 			ctrlctx.KubeInformerFactory.Core().V1().Nodes(),
@@ -82,6 +95,7 @@ func runStartCmd(_ *cobra.Command, _ []string) {
 		// Start the shared factory informers that you need to use in your controller
 		ctrlctx.InformerFactory.Start(ctrlctx.Stop)
 		ctrlctx.KubeInformerFactory.Start(ctrlctx.Stop)
+		ctrlctx.KubeNamespacedInformerFactory.Start(ctrlctx.Stop)
 		ctrlctx.OpenShiftConfigKubeNamespacedInformerFactory.Start(ctrlctx.Stop)
 		ctrlctx.ConfigInformerFactory.Start(ctrlctx.Stop)
 		ctrlctx.OperatorInformerFactory.Start(ctrlctx.Stop)
@@ -107,6 +121,10 @@ func runStartCmd(_ *cobra.Command, _ []string) {
 		}
 		go draincontroller.Run(5, ctrlctx.Stop)
 
+		if webhookServer != nil {
+			go webhookServer.Run(startOpts.webhookListenAddress, startOpts.webhookCertDir, ctrlctx.Stop)
+		}
+
 		// wait here in this function until the context gets cancelled (which tells us whe were being shut down)
 		<-ctx.Done()
 	}
@@ -179,6 +197,8 @@ func createControllers(ctx *ctrlcommon.ControllerContext) []ctrlcommon.Controlle
 			ctx.InformerFactory.Machineconfiguration().V1().MachineConfigPools(),
 			ctx.InformerFactory.Machineconfiguration().V1().MachineConfigs(),
 			ctx.InformerFactory.Machineconfiguration().V1().ControllerConfigs(),
+			ctx.KubeNamespacedInformerFactory.Core().V1().ConfigMaps(),
+			ctx.KubeNamespacedInformerFactory.Core().V1().Secrets(),
 			ctx.ClientBuilder.KubeClientOrDie("render-controller"),
 			ctx.ClientBuilder.MachineConfigClientOrDie("render-controller"),
 		),
@@ -189,6 +209,7 @@ func createControllers(ctx *ctrlcommon.ControllerContext) []ctrlcommon.Controlle
 			ctx.InformerFactory.Machineconfiguration().V1().MachineConfigPools(),
 			ctx.KubeInformerFactory.Core().V1().Nodes(),
 			ctx.KubeInformerFactory.Core().V1().Pods(),
+			ctx.KubeNamespacedInformerFactory.Core().V1().ConfigMaps(),
 			ctx.ConfigInformerFactory.Config().V1().Schedulers(),
 			ctx.ClientBuilder.KubeClientOrDie("node-update-controller"),
 			ctx.ClientBuilder.MachineConfigClientOrDie("node-update-controller"),
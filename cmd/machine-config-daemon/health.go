@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/openshift/machine-config-operator/internal/clients"
+	"github.com/openshift/machine-config-operator/pkg/daemon"
+	"github.com/spf13/cobra"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+var (
+	healthCmd = &cobra.Command{
+		Use:   "health",
+		Short: "Checks the health of the Machine Config Daemon",
+		Long: `Checks that the API server is reachable, that rpm-ostree and bootc respond and that the daemon keeps
+its node annotations up to date. Meant to be run as an exec probe in the machine-config-daemon container;
+exits non-zero and prints the root cause of each failed check.`,
+		Args: cobra.MaximumNArgs(0),
+		Run:  runHealthCmd,
+	}
+
+	healthOpts struct {
+		kubeconfig string
+		nodeName   string
+		rootMount  string
+		timeout    time.Duration
+		checks     []string
+	}
+)
+
+func init() {
+	rootCmd.AddCommand(healthCmd)
+	healthCmd.PersistentFlags().StringVar(&healthOpts.kubeconfig, "kubeconfig", "", "Kubeconfig file to access a remote cluster (testing only)")
+	healthCmd.PersistentFlags().StringVar(&healthOpts.nodeName, "node-name", "", "kubernetes node name daemon is managing.")
+	healthCmd.PersistentFlags().StringVar(&healthOpts.rootMount, "root-mount", "/rootfs", "where the nodes root filesystem is mounted.")
+	healthCmd.PersistentFlags().DurationVar(&healthOpts.timeout, "timeout", 10*time.Second, "how long each check may take.")
+	healthCmd.PersistentFlags().StringSliceVar(&healthOpts.checks, "check", []string{"api", "annotations", "rpm-ostree", "bootc"}, "checks to run.")
+}
+
+// Points the in-cluster client at the API server from the kubelet kubeconfig,
+// the same way the daemon itself does, since probes do not inherit the
+// environment of the daemon process.
+func overrideAPIServerFromKubeletKubeconfig(rootMount string) error {
+	kubeconfig, err := clientcmd.LoadFromFile(filepath.Join(rootMount, "/etc/kubernetes/kubeconfig"))
+	if err != nil {
+		return fmt.Errorf("failed to load kubelet kubeconfig: %w", err)
+	}
+
+	kubeContext, ok := kubeconfig.Contexts[kubeconfig.CurrentContext]
+	if !ok {
+		return fmt.Errorf("kubelet kubeconfig has no context %q", kubeconfig.CurrentContext)
+	}
+
+	cluster, ok := kubeconfig.Clusters[kubeContext.Cluster]
+	if !ok {
+		return fmt.Errorf("kubelet kubeconfig has no cluster %q", kubeContext.Cluster)
+	}
+
+	url, err := url.Parse(cluster.Server)
+	if err != nil {
+		return fmt.Errorf("failed to parse api url from kubelet kubeconfig: %w", err)
+	}
+
+	os.Setenv("KUBERNETES_SERVICE_HOST", url.Hostname())
+	os.Setenv("KUBERNETES_SERVICE_PORT", url.Port())
+	return nil
+}
+
+func getHealthChecks() ([]daemon.HealthCheck, error) {
+	enabled := map[string]bool{}
+	for _, check := range healthOpts.checks {
+		switch check {
+		case "api", "annotations", "rpm-ostree", "bootc":
+			enabled[check] = true
+		default:
+			return nil, fmt.Errorf("unknown check %q", check)
+		}
+	}
+
+	checks := []daemon.HealthCheck{}
+
+	if enabled["api"] || enabled["annotations"] {
+		if healthOpts.nodeName == "" {
+			healthOpts.nodeName = os.Getenv("NODE_NAME")
+		}
+		if healthOpts.nodeName == "" {
+			return nil, fmt.Errorf("node-name is required")
+		}
+
+		if healthOpts.kubeconfig == "" {
+			if err := overrideAPIServerFromKubeletKubeconfig(healthOpts.rootMount); err != nil {
+				return nil, err
+			}
+		}
+
+		cb, err := clients.NewBuilder(healthOpts.kubeconfig)
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize ClientBuilder: %w", err)
+		}
+
+		kubeClient, err := cb.KubeClient(componentName)
+		if err != nil {
+			return nil, fmt.Errorf("cannot initialize kubeClient: %w", err)
+		}
+
+		if enabled["api"] {
+			checks = append(checks, daemon.NewAPIHealthCheck(kubeClient, healthOpts.nodeName))
+		}
+		if enabled["annotations"] {
+			checks = append(checks, daemon.NewAnnotationsHealthCheck(kubeClient, healthOpts.nodeName))
+		}
+	}
+
+	if enabled["rpm-ostree"] {
+		if check, ok := daemon.NewRpmOstreeHealthCheck(healthOpts.rootMount); ok {
+			checks = append(checks, check)
+		}
+	}
+
+	if enabled["bootc"] {
+		if check, ok := daemon.NewBootcHealthCheck(healthOpts.rootMount); ok {
+			checks = append(checks, check)
+		}
+	}
+
+	return checks, nil
+}
+
+func runHealthCmd(_ *cobra.Command, _ []string) {
+	flag.Parse()
+
+	checks, err := getHealthChecks()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "machine-config-daemon is unhealthy: %s\n", err)
+		os.Exit(1)
+	}
+
+	if err := daemon.RunHealthChecks(context.Background(), checks, healthOpts.timeout); err != nil {
+		// Probe output is shown in the event for a failed probe, so this is
+		// where the root cause is surfaced.
+		fmt.Printf("machine-config-daemon is unhealthy:\n%s\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Println("machine-config-daemon is healthy")
+}
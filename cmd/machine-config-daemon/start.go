@@ -125,6 +125,12 @@ func runStartCmd(_ *cobra.Command, _ []string) {
 	os.Setenv("KUBERNETES_SERVICE_HOST", url.Hostname())
 	os.Setenv("KUBERNETES_SERVICE_PORT", url.Port())
 
+	// The MachineConfigServer sits behind the same load balancer, so it is
+	// used when the apiserver cannot provide a MachineConfig.
+	if err := dn.EnableMachineConfigServerFallback(apiURL); err != nil {
+		klog.Warningf("MachineConfigServer fallback disabled: %v", err)
+	}
+
 	cb, err := clients.NewBuilder(startOpts.kubeconfig)
 	if err != nil {
 		klog.Fatalf("Failed to initialize ClientBuilder: %v", err)
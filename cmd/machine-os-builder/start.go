@@ -74,6 +74,9 @@ func getBuildController(ctx context.Context, cb *clients.Builder) (*build.Contro
 		return build.NewWithImageBuilder(cfg, buildClients), nil
 	}
 
+	// The remote builder, Kaniko, BuildKit and the external build service also
+	// use a build pod. The pods of the remote builder and the external build
+	// service hand the build off to the remote host or service.
 	return build.NewWithCustomPodBuilder(cfg, buildClients), nil
 }
 
@@ -8,179 +8,92 @@ import (
 	"fmt"
 
 	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	"github.com/openshift/machine-config-operator/pkg/apihelpers/conditions"
 	corev1 "k8s.io/api/core/v1"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 // NewMachineConfigPoolCondition creates a new MachineConfigPool condition.
 func NewMachineConfigPoolCondition(condType mcfgv1.MachineConfigPoolConditionType, status corev1.ConditionStatus, reason, message string) *mcfgv1.MachineConfigPoolCondition {
-	return &mcfgv1.MachineConfigPoolCondition{
-		Type:               condType,
-		Status:             status,
-		LastTransitionTime: metav1.Now(),
-		Reason:             reason,
-		Message:            message,
-	}
+	return conditions.MachineConfigPool.New(condType, status, reason, message)
 }
 
 // GetMachineConfigPoolCondition returns the condition with the provided type.
 func GetMachineConfigPoolCondition(status mcfgv1.MachineConfigPoolStatus, condType mcfgv1.MachineConfigPoolConditionType) *mcfgv1.MachineConfigPoolCondition {
 	// in case of sync errors, return the last condition that matches, not the first
 	// this exists for redundancy and potential race conditions.
-	var LatestState *mcfgv1.MachineConfigPoolCondition
-	for i := range status.Conditions {
-		c := status.Conditions[i]
-		if c.Type == condType {
-			LatestState = &c
-		}
-	}
-	return LatestState
+	return conditions.MachineConfigPool.Get(status.Conditions, condType)
 }
 
 // SetMachineConfigPoolCondition updates the MachineConfigPool to include the provided condition. If the condition that
-// we are about to add already exists and has the same status and reason then we are not going to update.
+// we are about to add already exists and has the same status, reason and message then we are not going to update.
 func SetMachineConfigPoolCondition(status *mcfgv1.MachineConfigPoolStatus, condition mcfgv1.MachineConfigPoolCondition) {
-	currentCond := GetMachineConfigPoolCondition(*status, condition.Type)
-	if currentCond != nil && currentCond.Status == condition.Status && currentCond.Reason == condition.Reason && currentCond.Message == condition.Message {
-		return
-	}
-	// Do not update lastTransitionTime if the status of the condition doesn't change.
-	if currentCond != nil && currentCond.Status == condition.Status {
-		condition.LastTransitionTime = currentCond.LastTransitionTime
-	}
-	newConditions := filterOutMachineConfigPoolCondition(status.Conditions, condition.Type)
-	status.Conditions = append(newConditions, condition)
+	conditions.MachineConfigPool.Set(&status.Conditions, condition)
 }
 
 // RemoveMachineConfigPoolCondition removes the MachineConfigPool condition with the provided type.
 func RemoveMachineConfigPoolCondition(status *mcfgv1.MachineConfigPoolStatus, condType mcfgv1.MachineConfigPoolConditionType) {
-	status.Conditions = filterOutMachineConfigPoolCondition(status.Conditions, condType)
-}
-
-// filterOutCondition returns a new slice of MachineConfigPool conditions without conditions with the provided type.
-func filterOutMachineConfigPoolCondition(conditions []mcfgv1.MachineConfigPoolCondition, condType mcfgv1.MachineConfigPoolConditionType) []mcfgv1.MachineConfigPoolCondition {
-	var newConditions []mcfgv1.MachineConfigPoolCondition
-	for _, c := range conditions {
-		if c.Type == condType {
-			continue
-		}
-		newConditions = append(newConditions, c)
-	}
-	return newConditions
+	conditions.MachineConfigPool.Remove(&status.Conditions, condType)
 }
 
 // IsMachineConfigPoolConditionTrue returns true when the conditionType is present and set to `ConditionTrue`
-func IsMachineConfigPoolConditionTrue(conditions []mcfgv1.MachineConfigPoolCondition, conditionType mcfgv1.MachineConfigPoolConditionType) bool {
-	return IsMachineConfigPoolConditionPresentAndEqual(conditions, conditionType, corev1.ConditionTrue)
+func IsMachineConfigPoolConditionTrue(conds []mcfgv1.MachineConfigPoolCondition, conditionType mcfgv1.MachineConfigPoolConditionType) bool {
+	return conditions.MachineConfigPool.IsTrue(conds, conditionType)
 }
 
 // IsMachineConfigPoolConditionFalse returns true when the conditionType is present and set to `ConditionFalse`
-func IsMachineConfigPoolConditionFalse(conditions []mcfgv1.MachineConfigPoolCondition, conditionType mcfgv1.MachineConfigPoolConditionType) bool {
-	return IsMachineConfigPoolConditionPresentAndEqual(conditions, conditionType, corev1.ConditionFalse)
+func IsMachineConfigPoolConditionFalse(conds []mcfgv1.MachineConfigPoolCondition, conditionType mcfgv1.MachineConfigPoolConditionType) bool {
+	return conditions.MachineConfigPool.IsFalse(conds, conditionType)
 }
 
 // IsMachineConfigPoolConditionPresentAndEqual returns true when conditionType is present and equal to status.
-func IsMachineConfigPoolConditionPresentAndEqual(conditions []mcfgv1.MachineConfigPoolCondition, conditionType mcfgv1.MachineConfigPoolConditionType, status corev1.ConditionStatus) bool {
-	for _, condition := range conditions {
-		if condition.Type == conditionType {
-			return condition.Status == status
-		}
-	}
-	return false
+func IsMachineConfigPoolConditionPresentAndEqual(conds []mcfgv1.MachineConfigPoolCondition, conditionType mcfgv1.MachineConfigPoolConditionType, status corev1.ConditionStatus) bool {
+	return conditions.MachineConfigPool.IsPresentAndEqual(conds, conditionType, status)
 }
 
 // NewKubeletConfigCondition returns an instance of a KubeletConfigCondition
 func NewKubeletConfigCondition(condType mcfgv1.KubeletConfigStatusConditionType, status corev1.ConditionStatus, message string) *mcfgv1.KubeletConfigCondition {
-	return &mcfgv1.KubeletConfigCondition{
-		Type:               condType,
-		Status:             status,
-		LastTransitionTime: metav1.Now(),
-		Message:            message,
-	}
+	return conditions.KubeletConfig.New(condType, status, "", message)
 }
 
 // NewContainerRuntimeConfigCondition returns an instance of a ContainerRuntimeConfigCondition
 func NewContainerRuntimeConfigCondition(condType mcfgv1.ContainerRuntimeConfigStatusConditionType, status corev1.ConditionStatus, message string) *mcfgv1.ContainerRuntimeConfigCondition {
-	return &mcfgv1.ContainerRuntimeConfigCondition{
-		Type:               condType,
-		Status:             status,
-		LastTransitionTime: metav1.Now(),
-		Message:            message,
-	}
+	return conditions.ContainerRuntimeConfig.New(condType, status, "", message)
 }
 
 // NewControllerConfigStatusCondition creates a new ControllerConfigStatus condition.
 func NewControllerConfigStatusCondition(condType mcfgv1.ControllerConfigStatusConditionType, status corev1.ConditionStatus, reason, message string) *mcfgv1.ControllerConfigStatusCondition {
-	return &mcfgv1.ControllerConfigStatusCondition{
-		Type:               condType,
-		Status:             status,
-		LastTransitionTime: metav1.Now(),
-		Reason:             reason,
-		Message:            message,
-	}
+	return conditions.ControllerConfig.New(condType, status, reason, message)
 }
 
 // GetControllerConfigStatusCondition returns the condition with the provided type.
 func GetControllerConfigStatusCondition(status mcfgv1.ControllerConfigStatus, condType mcfgv1.ControllerConfigStatusConditionType) *mcfgv1.ControllerConfigStatusCondition {
-	for i := range status.Conditions {
-		c := status.Conditions[i]
-		if c.Type == condType {
-			return &c
-		}
-	}
-	return nil
+	return conditions.ControllerConfig.Get(status.Conditions, condType)
 }
 
 // SetControllerConfigStatusCondition updates the ControllerConfigStatus to include the provided condition. If the condition that
-// we are about to add already exists and has the same status and reason then we are not going to update.
+// we are about to add already exists and has the same status, reason and message then we are not going to update.
 func SetControllerConfigStatusCondition(status *mcfgv1.ControllerConfigStatus, condition mcfgv1.ControllerConfigStatusCondition) {
-	currentCond := GetControllerConfigStatusCondition(*status, condition.Type)
-	if currentCond != nil && currentCond.Status == condition.Status && currentCond.Reason == condition.Reason {
-		return
-	}
-	// Do not update lastTransitionTime if the status of the condition doesn't change.
-	if currentCond != nil && currentCond.Status == condition.Status {
-		condition.LastTransitionTime = currentCond.LastTransitionTime
-	}
-	newConditions := filterOutControllerConfigStatusCondition(status.Conditions, condition.Type)
-	status.Conditions = append(newConditions, condition)
+	conditions.ControllerConfig.Set(&status.Conditions, condition)
 }
 
 // RemoveControllerConfigStatusCondition removes the ControllerConfigStatus condition with the provided type.
 func RemoveControllerConfigStatusCondition(status *mcfgv1.ControllerConfigStatus, condType mcfgv1.ControllerConfigStatusConditionType) {
-	status.Conditions = filterOutControllerConfigStatusCondition(status.Conditions, condType)
-}
-
-// filterOutCondition returns a new slice of ControllerConfigStatus conditions without conditions with the provided type.
-func filterOutControllerConfigStatusCondition(conditions []mcfgv1.ControllerConfigStatusCondition, condType mcfgv1.ControllerConfigStatusConditionType) []mcfgv1.ControllerConfigStatusCondition {
-	var newConditions []mcfgv1.ControllerConfigStatusCondition
-	for _, c := range conditions {
-		if c.Type == condType {
-			continue
-		}
-		newConditions = append(newConditions, c)
-	}
-	return newConditions
+	conditions.ControllerConfig.Remove(&status.Conditions, condType)
 }
 
 // IsControllerConfigStatusConditionTrue returns true when the conditionType is present and set to `ConditionTrue`
-func IsControllerConfigStatusConditionTrue(conditions []mcfgv1.ControllerConfigStatusCondition, conditionType mcfgv1.ControllerConfigStatusConditionType) bool {
-	return IsControllerConfigStatusConditionPresentAndEqual(conditions, conditionType, corev1.ConditionTrue)
+func IsControllerConfigStatusConditionTrue(conds []mcfgv1.ControllerConfigStatusCondition, conditionType mcfgv1.ControllerConfigStatusConditionType) bool {
+	return conditions.ControllerConfig.IsTrue(conds, conditionType)
 }
 
 // IsControllerConfigStatusConditionFalse returns true when the conditionType is present and set to `ConditionFalse`
-func IsControllerConfigStatusConditionFalse(conditions []mcfgv1.ControllerConfigStatusCondition, conditionType mcfgv1.ControllerConfigStatusConditionType) bool {
-	return IsControllerConfigStatusConditionPresentAndEqual(conditions, conditionType, corev1.ConditionFalse)
+func IsControllerConfigStatusConditionFalse(conds []mcfgv1.ControllerConfigStatusCondition, conditionType mcfgv1.ControllerConfigStatusConditionType) bool {
+	return conditions.ControllerConfig.IsFalse(conds, conditionType)
 }
 
 // IsControllerConfigStatusConditionPresentAndEqual returns true when conditionType is present and equal to status.
-func IsControllerConfigStatusConditionPresentAndEqual(conditions []mcfgv1.ControllerConfigStatusCondition, conditionType mcfgv1.ControllerConfigStatusConditionType, status corev1.ConditionStatus) bool {
-	for _, condition := range conditions {
-		if condition.Type == conditionType {
-			return condition.Status == status
-		}
-	}
-	return false
+func IsControllerConfigStatusConditionPresentAndEqual(conds []mcfgv1.ControllerConfigStatusCondition, conditionType mcfgv1.ControllerConfigStatusConditionType, status corev1.ConditionStatus) bool {
+	return conditions.ControllerConfig.IsPresentAndEqual(conds, conditionType, status)
 }
 
 // IsControllerConfigCompleted checks whether a ControllerConfig is completed by the Template Controller
@@ -0,0 +1,179 @@
+// Package conditions provides typed helpers to read and write the status
+// conditions of the MCO CRDs and of nodes. Each API type has its own condition
+// struct, so the helpers are generic over the condition type and share one
+// implementation of the rules for setting, replacing and recording conditions.
+package conditions
+
+import (
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// fields holds pointers to the fields which all condition types have in common.
+type fields[C ~string] struct {
+	conditionType      *C
+	status             *corev1.ConditionStatus
+	reason             *string
+	message            *string
+	lastTransitionTime *metav1.Time
+}
+
+// Helper reads and writes conditions of type T, whose type field is of type C.
+type Helper[T any, C ~string] struct {
+	fields func(*T) fields[C]
+}
+
+var (
+	// MachineConfigPool handles the conditions of MachineConfigPools, including
+	// their build conditions.
+	MachineConfigPool = Helper[mcfgv1.MachineConfigPoolCondition, mcfgv1.MachineConfigPoolConditionType]{
+		fields: func(c *mcfgv1.MachineConfigPoolCondition) fields[mcfgv1.MachineConfigPoolConditionType] {
+			return fields[mcfgv1.MachineConfigPoolConditionType]{&c.Type, &c.Status, &c.Reason, &c.Message, &c.LastTransitionTime}
+		},
+	}
+
+	// ControllerConfig handles the conditions of ControllerConfigs.
+	ControllerConfig = Helper[mcfgv1.ControllerConfigStatusCondition, mcfgv1.ControllerConfigStatusConditionType]{
+		fields: func(c *mcfgv1.ControllerConfigStatusCondition) fields[mcfgv1.ControllerConfigStatusConditionType] {
+			return fields[mcfgv1.ControllerConfigStatusConditionType]{&c.Type, &c.Status, &c.Reason, &c.Message, &c.LastTransitionTime}
+		},
+	}
+
+	// KubeletConfig handles the conditions of KubeletConfigs.
+	KubeletConfig = Helper[mcfgv1.KubeletConfigCondition, mcfgv1.KubeletConfigStatusConditionType]{
+		fields: func(c *mcfgv1.KubeletConfigCondition) fields[mcfgv1.KubeletConfigStatusConditionType] {
+			return fields[mcfgv1.KubeletConfigStatusConditionType]{&c.Type, &c.Status, &c.Reason, &c.Message, &c.LastTransitionTime}
+		},
+	}
+
+	// ContainerRuntimeConfig handles the conditions of ContainerRuntimeConfigs.
+	ContainerRuntimeConfig = Helper[mcfgv1.ContainerRuntimeConfigCondition, mcfgv1.ContainerRuntimeConfigStatusConditionType]{
+		fields: func(c *mcfgv1.ContainerRuntimeConfigCondition) fields[mcfgv1.ContainerRuntimeConfigStatusConditionType] {
+			return fields[mcfgv1.ContainerRuntimeConfigStatusConditionType]{&c.Type, &c.Status, &c.Reason, &c.Message, &c.LastTransitionTime}
+		},
+	}
+
+	// Node handles the conditions of nodes. Nodes are owned by the kubelet, so
+	// this is mostly useful to read them.
+	Node = Helper[corev1.NodeCondition, corev1.NodeConditionType]{
+		fields: func(c *corev1.NodeCondition) fields[corev1.NodeConditionType] {
+			return fields[corev1.NodeConditionType]{&c.Type, &c.Status, &c.Reason, &c.Message, &c.LastTransitionTime}
+		},
+	}
+)
+
+// New creates a condition which transitioned now.
+func (h Helper[T, C]) New(condType C, status corev1.ConditionStatus, reason, message string) *T {
+	var cond T
+	f := h.fields(&cond)
+	*f.conditionType = condType
+	*f.status = status
+	*f.reason = reason
+	*f.message = message
+	*f.lastTransitionTime = metav1.Now()
+	return &cond
+}
+
+// Get returns a copy of the condition with the given type, or nil if there is
+// none. Should there be several, the last one wins.
+func (h Helper[T, C]) Get(conditions []T, condType C) *T {
+	var found *T
+	for i := range conditions {
+		if *h.fields(&conditions[i]).conditionType == condType {
+			cond := conditions[i]
+			found = &cond
+		}
+	}
+	return found
+}
+
+// Set adds the condition, replacing any condition of the same type. Nothing
+// changes if the current condition has the same status, reason and message.
+// The last transition time is only updated when the status changes.
+func (h Helper[T, C]) Set(conditions *[]T, condition T) {
+	newFields := h.fields(&condition)
+
+	if current := h.Get(*conditions, *newFields.conditionType); current != nil {
+		currentFields := h.fields(current)
+		if *currentFields.status == *newFields.status && *currentFields.reason == *newFields.reason && *currentFields.message == *newFields.message {
+			return
+		}
+
+		if *currentFields.status == *newFields.status {
+			*newFields.lastTransitionTime = *currentFields.lastTransitionTime
+		}
+	}
+
+	*conditions = append(h.filterOut(*conditions, *newFields.conditionType), condition)
+}
+
+// Remove removes all conditions with the given type.
+func (h Helper[T, C]) Remove(conditions *[]T, condType C) {
+	*conditions = h.filterOut(*conditions, condType)
+}
+
+// Record appends the condition to a history of conditions, as kept by
+// KubeletConfigs and ContainerRuntimeConfigs, unless the last recorded
+// condition has the same message, in which case it is replaced instead. If
+// limit is positive, only the last limit conditions are kept.
+func (h Helper[T, C]) Record(conditions *[]T, condition T, limit int) {
+	if n := len(*conditions); n > 0 && *h.fields(&(*conditions)[n-1]).message == *h.fields(&condition).message {
+		(*conditions)[n-1] = condition
+	} else {
+		*conditions = append(*conditions, condition)
+	}
+
+	if limit > 0 && len(*conditions) > limit {
+		*conditions = (*conditions)[len(*conditions)-limit:]
+	}
+}
+
+// IsTrue returns true when the condition with the given type is present and true.
+func (h Helper[T, C]) IsTrue(conditions []T, condType C) bool {
+	return h.IsPresentAndEqual(conditions, condType, corev1.ConditionTrue)
+}
+
+// IsFalse returns true when the condition with the given type is present and false.
+func (h Helper[T, C]) IsFalse(conditions []T, condType C) bool {
+	return h.IsPresentAndEqual(conditions, condType, corev1.ConditionFalse)
+}
+
+// IsPresentAndEqual returns true when the condition with the given type is
+// present and has the given status.
+func (h Helper[T, C]) IsPresentAndEqual(conditions []T, condType C, status corev1.ConditionStatus) bool {
+	cond := h.Get(conditions, condType)
+	return cond != nil && *h.fields(cond).status == status
+}
+
+// Reason returns the reason of the condition with the given type, or an empty
+// string if there is none.
+func (h Helper[T, C]) Reason(conditions []T, condType C) string {
+	cond := h.Get(conditions, condType)
+	if cond == nil {
+		return ""
+	}
+	return *h.fields(cond).reason
+}
+
+func (h Helper[T, C]) filterOut(conditions []T, condType C) []T {
+	var newConditions []T
+	for i := range conditions {
+		if *h.fields(&conditions[i]).conditionType == condType {
+			continue
+		}
+		newConditions = append(newConditions, conditions[i])
+	}
+	return newConditions
+}
+
+// SetObservedGeneration records that the status of obj reflects its current
+// generation. It returns whether the observed generation changed.
+func SetObservedGeneration(obj metav1.Object, observedGeneration *int64) bool {
+	if *observedGeneration == obj.GetGeneration() {
+		return false
+	}
+
+	*observedGeneration = obj.GetGeneration()
+	return true
+}
@@ -0,0 +1,136 @@
+package conditions
+
+import (
+	"testing"
+	"time"
+
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestSet(t *testing.T) {
+	t.Parallel()
+
+	past := metav1.NewTime(time.Now().Add(-time.Hour))
+
+	newConditions := func() []mcfgv1.MachineConfigPoolCondition {
+		return []mcfgv1.MachineConfigPoolCondition{
+			{Type: mcfgv1.MachineConfigPoolUpdated, Status: corev1.ConditionTrue, LastTransitionTime: past},
+			{Type: mcfgv1.MachineConfigPoolDegraded, Status: corev1.ConditionFalse, Reason: "Fine", LastTransitionTime: past},
+		}
+	}
+
+	testCases := []struct {
+		name               string
+		condition          *mcfgv1.MachineConfigPoolCondition
+		expectedTransition bool
+		expectedUnchanged  bool
+	}{
+		{
+			name:              "same status, reason and message",
+			condition:         MachineConfigPool.New(mcfgv1.MachineConfigPoolDegraded, corev1.ConditionFalse, "Fine", ""),
+			expectedUnchanged: true,
+		},
+		{
+			name:      "same status with a new message",
+			condition: MachineConfigPool.New(mcfgv1.MachineConfigPoolDegraded, corev1.ConditionFalse, "Fine", "all good"),
+		},
+		{
+			name:               "new status",
+			condition:          MachineConfigPool.New(mcfgv1.MachineConfigPoolDegraded, corev1.ConditionTrue, "Broken", "not good"),
+			expectedTransition: true,
+		},
+		{
+			name:               "new condition",
+			condition:          MachineConfigPool.New(mcfgv1.MachineConfigPoolUpdating, corev1.ConditionTrue, "", ""),
+			expectedTransition: true,
+		},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			conds := newConditions()
+			MachineConfigPool.Set(&conds, *testCase.condition)
+
+			if testCase.expectedUnchanged {
+				assert.Equal(t, newConditions(), conds)
+				return
+			}
+
+			cond := MachineConfigPool.Get(conds, testCase.condition.Type)
+			require.NotNil(t, cond)
+			assert.Equal(t, testCase.condition.Status, cond.Status)
+			assert.Equal(t, testCase.condition.Reason, cond.Reason)
+			assert.Equal(t, testCase.condition.Message, cond.Message)
+			assert.Equal(t, testCase.expectedTransition, !cond.LastTransitionTime.Equal(&past))
+			assert.True(t, MachineConfigPool.IsTrue(conds, mcfgv1.MachineConfigPoolUpdated))
+		})
+	}
+}
+
+func TestGetRemoveAndIsStatus(t *testing.T) {
+	t.Parallel()
+
+	conds := []corev1.NodeCondition{
+		{Type: corev1.NodeReady, Status: corev1.ConditionFalse, Reason: "KubeletNotReady"},
+		{Type: corev1.NodeDiskPressure, Status: corev1.ConditionFalse},
+		{Type: corev1.NodeReady, Status: corev1.ConditionTrue, Reason: "KubeletReady"},
+	}
+
+	// The last condition of a type wins.
+	assert.True(t, Node.IsTrue(conds, corev1.NodeReady))
+	assert.Equal(t, "KubeletReady", Node.Reason(conds, corev1.NodeReady))
+	assert.True(t, Node.IsFalse(conds, corev1.NodeDiskPressure))
+
+	assert.Nil(t, Node.Get(conds, corev1.NodeMemoryPressure))
+	assert.False(t, Node.IsTrue(conds, corev1.NodeMemoryPressure))
+	assert.False(t, Node.IsFalse(conds, corev1.NodeMemoryPressure))
+	assert.Equal(t, "", Node.Reason(conds, corev1.NodeMemoryPressure))
+
+	Node.Remove(&conds, corev1.NodeReady)
+	assert.Len(t, conds, 1)
+	assert.Nil(t, Node.Get(conds, corev1.NodeReady))
+}
+
+func TestRecord(t *testing.T) {
+	t.Parallel()
+
+	conds := []mcfgv1.KubeletConfigCondition{}
+
+	KubeletConfig.Record(&conds, *KubeletConfig.New(mcfgv1.KubeletConfigFailure, corev1.ConditionFalse, "", "first"), 2)
+	KubeletConfig.Record(&conds, *KubeletConfig.New(mcfgv1.KubeletConfigFailure, corev1.ConditionFalse, "", "second"), 2)
+	assert.Len(t, conds, 2)
+
+	// A condition with the same message as the last one replaces it.
+	KubeletConfig.Record(&conds, *KubeletConfig.New(mcfgv1.KubeletConfigSuccess, corev1.ConditionTrue, "", "second"), 2)
+	assert.Len(t, conds, 2)
+	assert.Equal(t, mcfgv1.KubeletConfigSuccess, conds[1].Type)
+
+	// Only the last conditions are kept.
+	KubeletConfig.Record(&conds, *KubeletConfig.New(mcfgv1.KubeletConfigSuccess, corev1.ConditionTrue, "", "third"), 2)
+	assert.Equal(t, []string{"second", "third"}, []string{conds[0].Message, conds[1].Message})
+
+	// Without a limit, the history keeps growing.
+	crcConds := []mcfgv1.ContainerRuntimeConfigCondition{}
+	for _, msg := range []string{"a", "b", "c", "d"} {
+		ContainerRuntimeConfig.Record(&crcConds, *ContainerRuntimeConfig.New(mcfgv1.ContainerRuntimeConfigSuccess, corev1.ConditionTrue, "", msg), 0)
+	}
+	assert.Len(t, crcConds, 4)
+}
+
+func TestSetObservedGeneration(t *testing.T) {
+	t.Parallel()
+
+	cc := &mcfgv1.ControllerConfig{ObjectMeta: metav1.ObjectMeta{Generation: 2}}
+	cc.Status.ObservedGeneration = 1
+
+	assert.True(t, SetObservedGeneration(cc, &cc.Status.ObservedGeneration))
+	assert.Equal(t, int64(2), cc.Status.ObservedGeneration)
+	assert.False(t, SetObservedGeneration(cc, &cc.Status.ObservedGeneration))
+}
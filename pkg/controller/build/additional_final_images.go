@@ -0,0 +1,244 @@
+package build
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/containers/image/v5/docker/reference"
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	clientset "k8s.io/client-go/kubernetes"
+)
+
+const (
+	// The on-cluster-build-config ConfigMap key which contains a JSON list of
+	// additional destinations that the final OS image is copied to once it is
+	// pushed (e.g., an external mirror for disaster recovery), for example:
+	// [{"pullspec": "mirror.example.com/org/os-image:latest", "pushSecretName": "mirror-push-secret"}]
+	AdditionalFinalImagesConfigKey = "additionalFinalImages"
+
+	// The key in the digest ConfigMap which holds the digested pullspecs of
+	// the copies, one per line.
+	additionalFinalImagesDigestConfigMapKey = "additional-pullspecs"
+
+	additionalFinalImagesVolumeName = "additional-final-image-push-creds"
+	additionalFinalImagesMountpoint = "/tmp/additional-final-image-push-creds"
+)
+
+// An additional destination for the final OS image.
+type additionalFinalImage struct {
+	// The tagged pullspec the final image is copied to.
+	Pullspec string `json:"pullspec"`
+	// The name of a Secret in the MCO namespace with the credentials needed to
+	// push to the pullspec.
+	PushSecretName string `json:"pushSecretName"`
+}
+
+// Gets the repository of the pullspec, which is where the copy may be pulled
+// from by digest.
+func (a additionalFinalImage) repository() string {
+	named, err := reference.ParseNamed(a.Pullspec)
+	if err != nil {
+		return a.Pullspec
+	}
+
+	return named.Name()
+}
+
+// Gets and validates the additional final image destinations, if any.
+func getAdditionalFinalImages(cm *corev1.ConfigMap) ([]additionalFinalImage, error) {
+	if cm == nil || cm.Data[AdditionalFinalImagesConfigKey] == "" {
+		return nil, nil
+	}
+
+	out := []additionalFinalImage{}
+	if err := json.Unmarshal([]byte(cm.Data[AdditionalFinalImagesConfigKey]), &out); err != nil {
+		return nil, fmt.Errorf("could not parse %s: %w", AdditionalFinalImagesConfigKey, err)
+	}
+
+	repositories := sets.NewString()
+	if named, err := reference.ParseNamed(cm.Data[FinalImagePullspecConfigKey]); err == nil {
+		repositories.Insert(named.Name())
+	}
+
+	for _, image := range out {
+		named, err := reference.ParseNamed(image.Pullspec)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse %s pullspec %q: %w", AdditionalFinalImagesConfigKey, image.Pullspec, err)
+		}
+
+		if _, ok := named.(reference.Canonical); ok {
+			return nil, fmt.Errorf("%s pullspec %q may not have a digest", AdditionalFinalImagesConfigKey, image.Pullspec)
+		}
+
+		if image.PushSecretName == "" {
+			return nil, fmt.Errorf("%s pullspec %q requires pushSecretName to be set", AdditionalFinalImagesConfigKey, image.Pullspec)
+		}
+
+		if repositories.Has(named.Name()) {
+			return nil, fmt.Errorf("%s repository %q is the final image repository or is already in use", AdditionalFinalImagesConfigKey, named.Name())
+		}
+
+		repositories.Insert(named.Name())
+	}
+
+	return out, nil
+}
+
+// Validates the additional final image destinations from the
+// on-cluster-build-config ConfigMap. The copies are made by the build pod, so
+// the OpenShift Image Builder is unable to make them.
+func validateAdditionalFinalImagesConfig(kubeclient clientset.Interface, cm *corev1.ConfigMap) error {
+	images, err := getAdditionalFinalImages(cm)
+	if err != nil {
+		return err
+	}
+
+	if len(images) == 0 {
+		return nil
+	}
+
+	builderType, err := GetImageBuilderType(cm)
+	if err != nil {
+		return err
+	}
+
+	if builderType == OpenshiftImageBuilder {
+		return fmt.Errorf("%s is not supported by %s %q", AdditionalFinalImagesConfigKey, ImageBuilderTypeConfigMapKey, OpenshiftImageBuilder)
+	}
+
+	for _, image := range images {
+		if err := validateSecret(kubeclient, image.PushSecretName); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Gets the path to the push credentials for the given additional final image
+// within the wait-for-done container.
+func getAdditionalFinalImageAuthfile(idx int) string {
+	return filepath.Join(additionalFinalImagesMountpoint, strconv.Itoa(idx), "config.json")
+}
+
+// Adds the additional final image destinations to the wait-for-done
+// container, which copies the final image to each of them before it reports
+// the digest. Since the build only succeeds once the digest is reported, a
+// successful build means that every copy exists.
+func (i ImageBuildRequest) addAdditionalFinalImages(pod *corev1.Pod) {
+	if len(i.AdditionalFinalImages) == 0 {
+		return
+	}
+
+	destinations := []string{}
+	sources := []corev1.VolumeProjection{}
+
+	for idx, image := range i.AdditionalFinalImages {
+		destinations = append(destinations, strings.Join([]string{image.Pullspec, image.repository(), getAdditionalFinalImageAuthfile(idx)}, " "))
+		sources = append(sources, corev1.VolumeProjection{
+			Secret: &corev1.SecretProjection{
+				LocalObjectReference: corev1.LocalObjectReference{
+					Name: image.PushSecretName,
+				},
+				Items: []corev1.KeyToPath{
+					{
+						Key:  corev1.DockerConfigJsonKey,
+						Path: filepath.Join(strconv.Itoa(idx), "config.json"),
+					},
+				},
+			},
+		})
+	}
+
+	finalImageRepository := i.FinalImage.Pullspec
+	if named, err := reference.ParseNamed(i.FinalImage.Pullspec); err == nil {
+		finalImageRepository = named.Name()
+	}
+
+	for idx := range pod.Spec.Containers {
+		container := &pod.Spec.Containers[idx]
+		if container.Name != "wait-for-done" {
+			continue
+		}
+
+		container.Env = append(container.Env,
+			corev1.EnvVar{
+				Name:  "FINAL_IMAGE_REPOSITORY",
+				Value: finalImageRepository,
+			},
+			corev1.EnvVar{
+				Name:  "ADDITIONAL_FINAL_IMAGES",
+				Value: strings.Join(destinations, "\n"),
+			},
+		)
+		container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+			Name:      additionalFinalImagesVolumeName,
+			MountPath: additionalFinalImagesMountpoint,
+		})
+	}
+
+	pod.Spec.Volumes = append(pod.Spec.Volumes, corev1.Volume{
+		Name: additionalFinalImagesVolumeName,
+		VolumeSource: corev1.VolumeSource{
+			Projected: &corev1.ProjectedVolumeSource{
+				Sources: sources,
+			},
+		},
+	})
+}
+
+// Parses the digested pullspecs of the additional final image copies from the
+// digest ConfigMap.
+func parseAdditionalFinalPullspecs(digestConfigMap *corev1.ConfigMap) ([]string, error) {
+	out := []string{}
+
+	for _, line := range strings.Split(digestConfigMap.Data[additionalFinalImagesDigestConfigMapKey], "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		if err := ctrlcommon.ValidateDigestedPullspec(line); err != nil {
+			return nil, fmt.Errorf("invalid additional final image pullspec in %s: %w", digestConfigMap.Name, err)
+		}
+
+		out = append(out, line)
+	}
+
+	return out, nil
+}
+
+// Gets the digested pullspecs of the additional final image copies for the
+// given pool, if any are configured.
+func (ctrl *Controller) getAdditionalFinalPullspecs(pool *mcfgv1.MachineConfigPool) ([]string, error) {
+	cm, err := ctrl.configMapLister.ConfigMaps(ctrlcommon.MCONamespace).Get(OnClusterBuildConfigMapName)
+	if err != nil {
+		return nil, fmt.Errorf("could not get build controller config %q: %w", OnClusterBuildConfigMapName, err)
+	}
+
+	images, err := getAdditionalFinalImages(cm)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(images) == 0 {
+		return nil, nil
+	}
+
+	ibr := newImageBuildRequest(pool)
+
+	digestConfigMap, err := ctrl.kubeclient.CoreV1().ConfigMaps(ctrlcommon.MCONamespace).Get(context.TODO(), ibr.getDigestConfigMapName(), metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("could not get digest ConfigMap %s: %w", ibr.getDigestConfigMapName(), err)
+	}
+
+	return parseAdditionalFinalPullspecs(digestConfigMap)
+}
@@ -0,0 +1,222 @@
+package build
+
+import (
+	"testing"
+
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	fakecorev1client "k8s.io/client-go/kubernetes/fake"
+)
+
+func TestGetAdditionalFinalImages(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name        string
+		value       string
+		expected    []additionalFinalImage
+		errExpected bool
+	}{
+		{
+			name: "not set",
+		},
+		{
+			name:  "valid",
+			value: `[{"pullspec": "mirror.example.com:5000/org/repo:latest", "pushSecretName": "mirror-push-secret"}]`,
+			expected: []additionalFinalImage{
+				{Pullspec: "mirror.example.com:5000/org/repo:latest", PushSecretName: "mirror-push-secret"},
+			},
+		},
+		{
+			name:        "invalid JSON",
+			value:       `{"pullspec": "mirror.example.com/org/repo:latest"}`,
+			errExpected: true,
+		},
+		{
+			name:        "invalid pullspec",
+			value:       `[{"pullspec": "mirror.example.com/org/Repo:latest", "pushSecretName": "mirror-push-secret"}]`,
+			errExpected: true,
+		},
+		{
+			name:        "digested pullspec",
+			value:       `[{"pullspec": "mirror.example.com/org/repo@` + expectedImageSHA + `", "pushSecretName": "mirror-push-secret"}]`,
+			errExpected: true,
+		},
+		{
+			name:        "missing push secret",
+			value:       `[{"pullspec": "mirror.example.com/org/repo:latest"}]`,
+			errExpected: true,
+		},
+		{
+			name:        "final image repository",
+			value:       `[{"pullspec": "registry.hostname.com/org/repo:mirror", "pushSecretName": "mirror-push-secret"}]`,
+			errExpected: true,
+		},
+		{
+			name: "duplicate repository",
+			value: `[{"pullspec": "mirror.example.com/org/repo:latest", "pushSecretName": "mirror-push-secret"},
+				{"pullspec": "mirror.example.com/org/repo:other", "pushSecretName": "mirror-push-secret"}]`,
+			errExpected: true,
+		},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			cm := getOnClusterBuildConfigMap()
+			if testCase.value != "" {
+				cm.Data[AdditionalFinalImagesConfigKey] = testCase.value
+			}
+
+			images, err := getAdditionalFinalImages(cm)
+			if testCase.errExpected {
+				assert.Error(t, err)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, testCase.expected, images)
+		})
+	}
+}
+
+func TestValidateAdditionalFinalImagesConfig(t *testing.T) {
+	t.Parallel()
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "mirror-push-secret",
+			Namespace: ctrlcommon.MCONamespace,
+		},
+		Data: map[string][]byte{
+			corev1.DockerConfigJsonKey: []byte(`{"auths":{}}`),
+		},
+		Type: corev1.SecretTypeDockerConfigJson,
+	}
+
+	testCases := []struct {
+		name        string
+		builderType string
+		secret      *corev1.Secret
+		errExpected bool
+	}{
+		{
+			name:        "valid",
+			builderType: CustomPodImageBuilder,
+			secret:      secret,
+		},
+		{
+			name:        "unsupported builder",
+			builderType: OpenshiftImageBuilder,
+			secret:      secret,
+			errExpected: true,
+		},
+		{
+			name:        "missing secret",
+			builderType: KanikoImageBuilder,
+			errExpected: true,
+		},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			cm := getOnClusterBuildConfigMap()
+			cm.Data[ImageBuilderTypeConfigMapKey] = testCase.builderType
+			cm.Data[AdditionalFinalImagesConfigKey] = `[{"pullspec": "mirror.example.com/org/repo:latest", "pushSecretName": "mirror-push-secret"}]`
+
+			kubeclient := fakecorev1client.NewSimpleClientset()
+			if testCase.secret != nil {
+				kubeclient = fakecorev1client.NewSimpleClientset(testCase.secret)
+			}
+
+			err := validateAdditionalFinalImagesConfig(kubeclient, cm)
+			if testCase.errExpected {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+
+	// Additional final images are optional.
+	assert.NoError(t, validateAdditionalFinalImagesConfig(fakecorev1client.NewSimpleClientset(), getOnClusterBuildConfigMap()))
+}
+
+func TestAdditionalFinalImagesBuildPod(t *testing.T) {
+	t.Parallel()
+
+	ibr := newImageBuildRequest(newMachineConfigPool("worker"))
+	ibr.FinalImage.Pullspec = expectedImagePullspecWithTag
+	ibr.AdditionalFinalImages = []additionalFinalImage{
+		{Pullspec: "mirror.example.com/org/repo:latest", PushSecretName: "mirror-push-secret"},
+		{Pullspec: "dr.example.com/org/repo:latest", PushSecretName: "dr-push-secret"},
+	}
+
+	pod := ibr.toBuildPod()
+
+	for _, container := range pod.Spec.Containers {
+		env := map[string]string{}
+		for _, envVar := range container.Env {
+			env[envVar.Name] = envVar.Value
+		}
+
+		mounts := map[string]string{}
+		for _, mount := range container.VolumeMounts {
+			mounts[mount.Name] = mount.MountPath
+		}
+
+		// Only the wait-for-done container copies the image.
+		if container.Name != "wait-for-done" {
+			assert.NotContains(t, env, "ADDITIONAL_FINAL_IMAGES")
+			assert.NotContains(t, mounts, additionalFinalImagesVolumeName)
+			continue
+		}
+
+		assert.Equal(t, "registry.hostname.com/org/repo", env["FINAL_IMAGE_REPOSITORY"])
+		assert.Equal(t, "mirror.example.com/org/repo:latest mirror.example.com/org/repo /tmp/additional-final-image-push-creds/0/config.json\n"+
+			"dr.example.com/org/repo:latest dr.example.com/org/repo /tmp/additional-final-image-push-creds/1/config.json", env["ADDITIONAL_FINAL_IMAGES"])
+		assert.Equal(t, additionalFinalImagesMountpoint, mounts[additionalFinalImagesVolumeName])
+	}
+
+	var volume *corev1.Volume
+	for idx := range pod.Spec.Volumes {
+		if pod.Spec.Volumes[idx].Name == additionalFinalImagesVolumeName {
+			volume = &pod.Spec.Volumes[idx]
+		}
+	}
+
+	require.NotNil(t, volume)
+	require.NotNil(t, volume.Projected)
+	require.Len(t, volume.Projected.Sources, 2)
+	assert.Equal(t, "dr-push-secret", volume.Projected.Sources[1].Secret.Name)
+	assert.Equal(t, "1/config.json", volume.Projected.Sources[1].Secret.Items[0].Path)
+}
+
+func TestParseAdditionalFinalPullspecs(t *testing.T) {
+	t.Parallel()
+
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "digest-rendered-worker-1"},
+		Data: map[string]string{
+			"digest": expectedImageSHA,
+			additionalFinalImagesDigestConfigMapKey: "mirror.example.com/org/repo@" + expectedImageSHA + "\n" +
+				"dr.example.com/org/repo@" + expectedImageSHA + "\n",
+		},
+	}
+
+	pullspecs, err := parseAdditionalFinalPullspecs(cm)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"mirror.example.com/org/repo@" + expectedImageSHA, "dr.example.com/org/repo@" + expectedImageSHA}, pullspecs)
+
+	cm.Data[additionalFinalImagesDigestConfigMapKey] = "mirror.example.com/org/repo:latest"
+	_, err = parseAdditionalFinalPullspecs(cm)
+	assert.Error(t, err)
+}
@@ -14,16 +14,85 @@ mkdir -p "$build_context/machineconfig"
 cp /tmp/dockerfile/Dockerfile "$build_context"
 cp /tmp/machineconfig/machineconfig.json.gz "$build_context/machineconfig/"
 
+# Mount any optional Secrets and ConfigMaps (e.g., RHEL entitlements) into
+# each RUN instruction. These are not added to the final image.
+volume_args=()
+for mountpoint in ${BUILD_VOLUME_MOUNTPOINTS:-}; do
+	volume_args+=("--volume=$mountpoint:$mountpoint:z")
+done
+
+# Pass any build arguments to Buildah by name so that it reads their values
+# from the environment instead of them being logged here.
+build_args=()
+for build_arg in ${BUILD_ARG_NAMES:-}; do
+	build_args+=("--build-arg=$build_arg")
+done
+
+# Squash the layers added on top of the base image into a single layer, if
+# requested. Buildah commits each stage as a single layer unless it caches
+# intermediate layers, so make sure that it does not. Unlike --squash, this
+# keeps the layers of the base image, which nodes already have.
+squash_args=()
+if [[ "${SQUASH_LAYERS:-}" == "true" ]]; then
+	squash_args+=("--layers=false")
+fi
+
 # Build our image using Buildah.
 buildah bud \
 	--storage-driver vfs \
 	--authfile="$BASE_IMAGE_PULL_CREDS" \
 	--tag "$TAG" \
+	${volume_args[@]+"${volume_args[@]}"} \
+	${build_args[@]+"${build_args[@]}"} \
+	${squash_args[@]+"${squash_args[@]}"} \
 	--file="$build_context/Dockerfile" "$build_context"
 
+# Run the post-build test command, if any, in a container from our built image
+# so that an image which fails it is never pushed.
+if [[ -n "${POST_BUILD_TEST_COMMAND:-}" ]]; then
+	test_container="$(buildah from --storage-driver vfs --pull=never "$TAG")"
+	buildah run --storage-driver vfs "$test_container" -- /bin/sh -c "$POST_BUILD_TEST_COMMAND"
+	buildah rm --storage-driver vfs "$test_container"
+fi
+
+# Lint our built image, if requested, by running the lint script in a container
+# from it. The findings are reported alongside the digest so that the build
+# controller decides whether the image is rolled out.
+if [[ -n "${IMAGE_LINT_SCRIPT:-}" ]]; then
+	lint_container="$(buildah from --storage-driver vfs --pull=never "$TAG")"
+	buildah run --storage-driver vfs "$lint_container" -- /bin/sh -c "$IMAGE_LINT_SCRIPT" > /tmp/done/lintfile
+	buildah rm --storage-driver vfs "$lint_container"
+
+	# Images without the label are not recognized as bootable by rpm-ostree.
+	if [[ "$(buildah inspect --storage-driver vfs --format '{{index .OCIv1.Config.Labels "ostree.bootable"}}' "$TAG")" != "true" ]]; then
+		echo "warning labels the ostree.bootable label is not set to true" >> /tmp/done/lintfile
+	fi
+fi
+
+# Sign our image with a sigstore signature if we were given a cosign key pair.
+# The signature is stored alongside the image in the registry as
+# <repo>:sha256-<digest>.sig, which is where cosign expects it.
+push_args=()
+if [[ -n "${IMAGE_SIGNING_KEY_DIR:-}" ]]; then
+	mkdir -p "$HOME/.config/containers/registries.d"
+	printf 'default-docker:\n  use-sigstore-attachments: true\n' > "$HOME/.config/containers/registries.d/sigstore-attachments.yaml"
+
+	push_args+=("--sign-by-sigstore-private-key=$IMAGE_SIGNING_KEY_DIR/cosign.key")
+	if [[ -f "$IMAGE_SIGNING_KEY_DIR/cosign.password" ]]; then
+		push_args+=("--sign-passphrase-file=$IMAGE_SIGNING_KEY_DIR/cosign.password")
+	fi
+fi
+
+# Allow pushing to a final image registry which is served over plain HTTP or
+# whose certificate cannot be verified, if explicitly requested.
+if [[ "${FINAL_IMAGE_INSECURE_REGISTRY:-}" == "true" ]]; then
+	push_args+=("--tls-verify=false")
+fi
+
 # Push our built image.
 buildah push \
 	--storage-driver vfs \
 	--authfile="$FINAL_IMAGE_PUSH_CREDS" \
 	--digestfile="/tmp/done/digestfile" \
+	${push_args[@]+"${push_args[@]}"} \
 	--cert-dir /var/run/secrets/kubernetes.io/serviceaccount "$TAG"
@@ -0,0 +1,58 @@
+#!/bin/sh
+#
+# This script is not meant to be directly executed. Instead, it is embedded
+# within the Build Controller binary (see //go:embed) and injected into a
+# BuildKit build pod. The registry credentials were merged into
+# $DOCKER_CONFIG/config.json by the merge-registry-creds init container.
+set -xeu
+
+build_context="$HOME/context"
+
+# Create a directory to hold our build context.
+mkdir -p "$build_context/machineconfig"
+
+# Copy the Dockerfile and Machineconfigs from configmaps into our build context.
+cp /tmp/dockerfile/Dockerfile "$build_context"
+cp /tmp/machineconfig/machineconfig.json.gz "$build_context/machineconfig/"
+
+# Import the build cache from and export it to the cache image, if any. The
+# cache is exported with all intermediate layers so that builds which only
+# change a later instruction can reuse the earlier ones.
+set --
+if [ -n "${BUILD_CACHE_IMAGE:-}" ]; then
+	set -- "$@" \
+		--import-cache "type=registry,ref=$BUILD_CACHE_IMAGE" \
+		--export-cache "type=registry,ref=$BUILD_CACHE_IMAGE,mode=max"
+fi
+
+# Allow pushing to a final image registry which is served over plain HTTP or
+# whose certificate cannot be verified, if explicitly requested.
+output="type=image,name=$TAG,push=true"
+if [ "${FINAL_IMAGE_INSECURE_REGISTRY:-}" = "true" ]; then
+	output="$output,registry.insecure=true"
+fi
+
+# BuildKit needs the values of the build arguments on its command line, so
+# stop tracing here to keep them out of the build log.
+set +x
+for build_arg in ${BUILD_ARG_NAMES:-}; do
+	set -- "$@" --opt "build-arg:$build_arg=$(printenv "$build_arg")"
+done
+echo "Building $TAG with build arguments: ${BUILD_ARG_NAMES:-none}"
+
+# Build and push our image using a BuildKit daemon which only lives as long as
+# this build.
+buildctl-daemonless.sh build \
+	--frontend dockerfile.v0 \
+	--local context="$build_context" \
+	--local dockerfile="$build_context" \
+	--output "$output" \
+	--metadata-file "$HOME/metadata.json" \
+	"$@"
+set -x
+
+# Write the digest of the pushed image to where the wait-for-done container
+# expects it. It must only appear once it is complete.
+sed -n 's/.*"containerimage.digest": *"\([^"]*\)".*/\1/p' "$HOME/metadata.json" > /tmp/done/digestfile.tmp
+test -s /tmp/done/digestfile.tmp
+mv /tmp/done/digestfile.tmp /tmp/done/digestfile
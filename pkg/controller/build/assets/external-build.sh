@@ -0,0 +1,73 @@
+#!/usr/bin/env bash
+#
+# This script is not meant to be directly executed. Instead, it is embedded
+# within the Build Controller binary (see //go:embed) and injected into an
+# external build pod. The build and push are performed by the external build
+# service; this script only submits the build request and waits for it.
+set -xeuo pipefail
+
+curl_args=(
+	--silent
+	--show-error
+	--fail
+	--header "Content-Type: application/json"
+)
+
+if [[ -f "$EXTERNAL_BUILD_SERVICE_CREDS_DIR/ca.crt" ]]; then
+	curl_args+=(--cacert "$EXTERNAL_BUILD_SERVICE_CREDS_DIR/ca.crt")
+fi
+
+# Read the bearer token from a file so that it is not logged here.
+printf 'Authorization: Bearer %s\n' "$(cat "$EXTERNAL_BUILD_SERVICE_CREDS_DIR/token")" > "$HOME/auth-header"
+curl_args+=(--header "@$HOME/auth-header")
+
+# Build the request from the rendered Dockerfile and MachineConfig. The build
+# arguments are collected without tracing so that their values are not logged.
+set +x
+build_args="{}"
+for build_arg in ${BUILD_ARG_NAMES:-}; do
+	build_args="$(jq -c --arg name "$build_arg" --arg value "$(printenv "$build_arg")" '. + {($name): $value}' <<< "$build_args")"
+done
+
+jq -n \
+	--arg pool "$POOL_NAME" \
+	--arg renderedConfig "$RENDERED_CONFIG" \
+	--arg baseImage "$BASE_IMAGE" \
+	--arg finalImage "$TAG" \
+	--argjson buildArgs "$build_args" \
+	--rawfile dockerfile /tmp/dockerfile/Dockerfile \
+	--rawfile machineConfig /tmp/machineconfig/machineconfig.json.gz \
+	'{pool: $pool, renderedConfig: $renderedConfig, baseImage: $baseImage, finalImage: $finalImage, buildArgs: $buildArgs, dockerfile: $dockerfile, machineConfig: $machineConfig}' \
+	> "$HOME/request.json"
+set -x
+
+# Submit the build request. The service responds with the ID of the build.
+build_id="$(curl "${curl_args[@]}" --data "@$HOME/request.json" "$EXTERNAL_BUILD_SERVICE_URL" | jq -r '.id')"
+if [[ -z "$build_id" || "$build_id" == "null" ]]; then
+	echo "External build service did not return a build ID"
+	exit 1
+fi
+
+# Wait for the build to finish. The build controller gives up on the build
+# once the build timeout is reached.
+while true; do
+	status="$(curl "${curl_args[@]}" "$EXTERNAL_BUILD_SERVICE_URL/$build_id")"
+	state="$(jq -r '.state' <<< "$status")"
+
+	case "$state" in
+		succeeded)
+			jq -r '.digest' <<< "$status" > /tmp/done/digestfile.tmp
+			break
+			;;
+		failed)
+			echo "External build $build_id failed: $(jq -r '.message // "no message"' <<< "$status")"
+			exit 1
+			;;
+	esac
+
+	sleep "$EXTERNAL_BUILD_SERVICE_POLL_SECONDS"
+done
+
+# The digestfile must only appear once it is complete.
+grep -q '^sha256:' /tmp/done/digestfile.tmp
+mv /tmp/done/digestfile.tmp /tmp/done/digestfile
@@ -0,0 +1,47 @@
+#!/bin/sh
+#
+# This script is not meant to be directly executed. Instead, it is embedded
+# within the Build Controller binary (see //go:embed) and run in a container
+# from the built image by the custom build pod.
+#
+# Each finding is printed on its own line as "<severity> <check> <message>",
+# where the severity is either "error" or "warning". Errors mean that the
+# image cannot be booted or updated correctly. Warnings mean that the image
+# probably does not do what its author intended.
+
+# The image must contain exactly one kernel, which ostree deploys.
+kernels="$(ls -d /usr/lib/modules/*/vmlinuz 2>/dev/null | wc -l)"
+if [ "$kernels" -ne 1 ]; then
+	echo "error kernel found $kernels kernels in /usr/lib/modules, expected 1"
+fi
+
+# ostree stores the default /etc in /usr/etc when deploying the image, so the
+# image may not contain one itself.
+if [ -e /usr/etc ]; then
+	echo "error usr-etc /usr/etc must not exist, put default configuration in /etc instead"
+fi
+
+# /var/run is shared with the host's /run, which only works as a symlink.
+if [ ! -L /var/run ]; then
+	echo "error var-run /var/run must be a symlink to /run"
+fi
+
+# The image must be deployable by ostree.
+if [ ! -d /usr/lib/ostree ] && [ ! -d /usr/lib/ostree-boot ]; then
+	echo "error ostree /usr/lib/ostree is missing, the image is not an ostree-based image"
+fi
+
+# The contents of /var are only unpacked when a node is first installed, so
+# files written to /var by the build never reach existing nodes.
+var_files="$(find /var -xdev ! -type d ! -path /var/run 2>/dev/null | head -n 5 | tr '\n' ' ')"
+if [ -n "$var_files" ]; then
+	echo "warning var-files files in /var are not updated on existing nodes, use systemd-tmpfiles instead: ${var_files% }"
+fi
+
+# Content in /usr/local is on /var on the nodes, so it is not updated either.
+usr_local_files="$(find /usr/local -xdev ! -type d ! -type l 2>/dev/null | head -n 5 | tr '\n' ' ')"
+if [ -n "$usr_local_files" ]; then
+	echo "warning usr-local files in /usr/local are not updated on existing nodes, use /usr instead: ${usr_local_files% }"
+fi
+
+exit 0
@@ -0,0 +1,51 @@
+#!/busybox/sh
+#
+# This script is not meant to be directly executed. Instead, it is embedded
+# within the Build Controller binary (see //go:embed) and injected into a
+# Kaniko build pod. The registry credentials were merged into
+# /kaniko/.docker/config.json by the merge-registry-creds init container.
+set -xeu
+
+build_context="/workspace/context"
+
+# Create a directory to hold our build context.
+mkdir -p "$build_context/machineconfig"
+
+# Copy the Dockerfile and Machineconfigs from configmaps into our build context.
+cp /tmp/dockerfile/Dockerfile "$build_context"
+cp /tmp/machineconfig/machineconfig.json.gz "$build_context/machineconfig/"
+
+# The optional Secrets and ConfigMaps (e.g., RHEL entitlements) are mounted
+# into this container, so each RUN instruction sees them. Keep them out of
+# the final image.
+set --
+for mountpoint in ${BUILD_VOLUME_MOUNTPOINTS:-}; do
+	set -- "$@" "--ignore-path=$mountpoint"
+done
+
+# Pass any build arguments to Kaniko by name so that it reads their values
+# from the environment instead of them being logged here.
+for build_arg in ${BUILD_ARG_NAMES:-}; do
+	set -- "$@" "--build-arg=$build_arg"
+done
+
+# Squash the layers added on top of the base image into a single layer, if
+# requested, by taking a single snapshot at the end of the build.
+if [ "${SQUASH_LAYERS:-}" = "true" ]; then
+	set -- "$@" --single-snapshot
+fi
+
+# Allow pushing to a final image registry which is served over plain HTTP or
+# whose certificate cannot be verified, if explicitly requested. The base
+# image is still pulled securely.
+if [ "${FINAL_IMAGE_INSECURE_REGISTRY:-}" = "true" ]; then
+	set -- "$@" --insecure --skip-tls-verify
+fi
+
+# Build and push our image using Kaniko. The digestfile is written here.
+/kaniko/executor \
+	--context="dir://$build_context" \
+	--dockerfile="$build_context/Dockerfile" \
+	--destination="$TAG" \
+	--digest-file="/tmp/done/digestfile" \
+	"$@"
@@ -0,0 +1,69 @@
+#!/usr/bin/env bash
+#
+# This script is not meant to be directly executed. Instead, it is embedded
+# within the Build Controller binary (see //go:embed) and injected into a
+# remote build pod. The build and push are performed by the remote Podman API
+# service; this script only uploads the build context and the credentials.
+set -xeuo
+
+build_context="$HOME/context"
+
+# Create a directory to hold our build context.
+mkdir -p "$build_context/machineconfig"
+
+# Copy the Dockerfile and Machineconfigs from configmaps into our build context.
+cp /tmp/dockerfile/Dockerfile "$build_context"
+cp /tmp/machineconfig/machineconfig.json.gz "$build_context/machineconfig/"
+
+remote_args=(
+	--remote
+	--url="$REMOTE_BUILDER_URL"
+	--tls-ca="$REMOTE_BUILDER_TLS_DIR/ca.crt"
+	--tls-cert="$REMOTE_BUILDER_TLS_DIR/tls.crt"
+	--tls-key="$REMOTE_BUILDER_TLS_DIR/tls.key"
+)
+
+# Pass any build arguments to Podman by name so that it reads their values
+# from the environment instead of them being logged here.
+build_args=()
+for build_arg in ${BUILD_ARG_NAMES:-}; do
+	build_args+=("--build-arg=$build_arg")
+done
+
+# Squash the layers added on top of the base image into a single layer, if
+# requested. Unlike --squash-all, this keeps the layers of the base image.
+squash_args=()
+if [[ "${SQUASH_LAYERS:-}" == "true" ]]; then
+	squash_args+=("--squash")
+fi
+
+# Build our image on the remote builder. The build context is uploaded to it.
+podman "${remote_args[@]}" build \
+	--authfile="$BASE_IMAGE_PULL_CREDS" \
+	--tag "$TAG" \
+	${build_args[@]+"${build_args[@]}"} \
+	${squash_args[@]+"${squash_args[@]}"} \
+	--file="$build_context/Dockerfile" "$build_context"
+
+# Run the post-build test command, if any, in a container from our built image
+# so that an image which fails it is never pushed.
+if [[ -n "${POST_BUILD_TEST_COMMAND:-}" ]]; then
+	podman "${remote_args[@]}" run --rm --pull=never "$TAG" /bin/sh -c "$POST_BUILD_TEST_COMMAND"
+fi
+
+# Allow pushing to a final image registry which is served over plain HTTP or
+# whose certificate cannot be verified, if explicitly requested.
+push_args=()
+if [[ "${FINAL_IMAGE_INSECURE_REGISTRY:-}" == "true" ]]; then
+	push_args+=("--tls-verify=false")
+fi
+
+# Push our built image from the remote builder and remove it from there
+# afterwards. The digestfile is written here.
+podman "${remote_args[@]}" push \
+	--authfile="$FINAL_IMAGE_PUSH_CREDS" \
+	--digestfile="/tmp/done/digestfile" \
+	${push_args[@]+"${push_args[@]}"} \
+	"$TAG"
+
+podman "${remote_args[@]}" rmi "$TAG" || true
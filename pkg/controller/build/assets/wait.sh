@@ -10,7 +10,47 @@ do
 	sleep 1
 done
 
+configmap_args=("--from-file=digest=/tmp/done/digestfile")
+
+# Report the lint findings for the image, if it was linted.
+if [ -f "/tmp/done/lintfile" ]; then
+	configmap_args+=("--from-file=lint=/tmp/done/lintfile")
+fi
+
+# Copy the final image to each additional destination, if any, before
+# reporting the digest so that the build only succeeds once every copy exists.
+# Each line of ADDITIONAL_FINAL_IMAGES contains the destination pullspec, its
+# repository, and the path to its push credentials.
+if [ -n "${ADDITIONAL_FINAL_IMAGES:-}" ]; then
+	digest="$(cat /tmp/done/digestfile)"
+	touch /tmp/done/additional-pullspecs
+
+	# The final image registry may be insecure, if explicitly requested. The
+	# additional destinations are always verified.
+	src_tls_verify="true"
+	if [ "${FINAL_IMAGE_INSECURE_REGISTRY:-}" = "true" ]; then
+		src_tls_verify="false"
+	fi
+
+	while read -r destination repository authfile; do
+		skopeo copy \
+			--all \
+			--preserve-digests \
+			--retry-times 3 \
+			--src-authfile "$FINAL_IMAGE_PUSH_CREDS" \
+			--src-tls-verify="$src_tls_verify" \
+			--dest-authfile "$authfile" \
+			--digestfile /tmp/done/additional-digestfile \
+			"docker://$FINAL_IMAGE_REPOSITORY@$digest" \
+			"docker://$destination" || exit 1
+
+		echo "$repository@$(cat /tmp/done/additional-digestfile)" >> /tmp/done/additional-pullspecs
+	done <<< "$ADDITIONAL_FINAL_IMAGES"
+
+	configmap_args+=("--from-file=additional-pullspecs=/tmp/done/additional-pullspecs")
+fi
+
 oc create configmap \
 	"$DIGEST_CONFIGMAP_NAME" \
 	--namespace openshift-machine-config-operator \
-	--from-file=digest=/tmp/done/digestfile
+	"${configmap_args[@]}"
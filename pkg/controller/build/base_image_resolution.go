@@ -0,0 +1,210 @@
+package build
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/containers/image/v5/docker"
+	"github.com/containers/image/v5/docker/reference"
+	"github.com/containers/image/v5/pkg/sysregistriesv2"
+	"github.com/containers/image/v5/types"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// Condition and event reason used when the base image cannot be pulled
+	// through the cluster's image mirrors.
+	unresolvableBaseImageReason = "BaseImageUnresolvable"
+
+	baseImageResolveTimeout = time.Minute
+)
+
+// Checks that images can be pulled before a build is started.
+type imageResolver interface {
+	ResolveImage(ctx context.Context, pullspec string, opts imageResolveOptions) error
+}
+
+// Describes how an image is pulled, which matches how the build pulls it.
+type imageResolveOptions struct {
+	// The dockerconfigjson credentials to pull the image with.
+	Authfile []byte
+	// The registries config, which contains the cluster's image mirrors.
+	RegistriesConfig string
+	// The registry CAs, keyed by registry in host..port form.
+	RegistryCAs map[string]string
+	// Whether TLS verification is skipped.
+	Insecure bool
+}
+
+// Writes the files which the given options refer to into the given directory
+// and returns the SystemContext which uses them.
+func (o imageResolveOptions) toSystemContext(dir string) (*types.SystemContext, error) {
+	sys := &types.SystemContext{
+		// Neither the controller's own registries config drop-ins nor its
+		// certificates apply to the build.
+		SystemRegistriesConfPath:    filepath.Join(dir, "registries.conf"),
+		SystemRegistriesConfDirPath: filepath.Join(dir, "registries.conf.d"),
+		DockerPerHostCertDirPath:    filepath.Join(dir, "certs.d"),
+	}
+
+	if o.Insecure {
+		sys.DockerInsecureSkipTLSVerify = types.OptionalBoolTrue
+	}
+
+	if err := os.WriteFile(sys.SystemRegistriesConfPath, []byte(o.RegistriesConfig), 0o600); err != nil {
+		return nil, fmt.Errorf("could not write registries config: %w", err)
+	}
+
+	for registry, ca := range o.RegistryCAs {
+		certDir := filepath.Join(sys.DockerPerHostCertDirPath, getRegistryCertDirName(registry))
+		if err := os.MkdirAll(certDir, 0o700); err != nil {
+			return nil, fmt.Errorf("could not create cert dir for registry %s: %w", registry, err)
+		}
+
+		if err := os.WriteFile(filepath.Join(certDir, "ca.crt"), []byte(ca), 0o600); err != nil {
+			return nil, fmt.Errorf("could not write CA for registry %s: %w", registry, err)
+		}
+	}
+
+	if len(o.Authfile) != 0 {
+		sys.AuthFilePath = filepath.Join(dir, "auth.json")
+		if err := os.WriteFile(sys.AuthFilePath, o.Authfile, 0o600); err != nil {
+			return nil, fmt.Errorf("could not write authfile: %w", err)
+		}
+	}
+
+	return sys, nil
+}
+
+// Gets the registries which the given image is pulled from according to the
+// registries config, with the mirrors first and the source registry last.
+func getImagePullSources(pullspec, registriesConfig string) ([]string, error) {
+	named, err := reference.ParseNormalizedNamed(pullspec)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse image %q: %w", pullspec, err)
+	}
+
+	dir, err := os.MkdirTemp("", "build-image-sources-*")
+	if err != nil {
+		return nil, err
+	}
+
+	defer os.RemoveAll(dir)
+
+	sys, err := imageResolveOptions{RegistriesConfig: registriesConfig}.toSystemContext(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	// The parsed registries config is cached by its path, which is never
+	// reused.
+	defer sysregistriesv2.InvalidateCache()
+
+	registry, err := sysregistriesv2.FindRegistry(sys, named.Name())
+	if err != nil {
+		return nil, fmt.Errorf("could not parse registries config: %w", err)
+	}
+
+	if registry == nil {
+		return []string{reference.Domain(named)}, nil
+	}
+
+	pullSources, err := registry.PullSourcesFromReference(named)
+	if err != nil {
+		return nil, err
+	}
+
+	out := []string{}
+	for _, pullSource := range pullSources {
+		out = append(out, reference.Domain(pullSource.Reference))
+	}
+
+	return out, nil
+}
+
+// Pulls the manifest of images from their registries with containers/image,
+// which Buildah uses too.
+type registryImageResolver struct{}
+
+func (registryImageResolver) ResolveImage(ctx context.Context, pullspec string, opts imageResolveOptions) error {
+	ref, err := docker.ParseReference("//" + pullspec)
+	if err != nil {
+		return fmt.Errorf("could not parse image %q: %w", pullspec, err)
+	}
+
+	dir, err := os.MkdirTemp("", "build-image-resolve-*")
+	if err != nil {
+		return err
+	}
+
+	defer os.RemoveAll(dir)
+
+	sys, err := opts.toSystemContext(dir)
+	if err != nil {
+		return err
+	}
+
+	defer sysregistriesv2.InvalidateCache()
+
+	src, err := ref.NewImageSource(ctx, sys)
+	if err != nil {
+		return err
+	}
+
+	return src.Close()
+}
+
+// Checks that the base image can be pulled through the cluster's image
+// mirrors before the build is started, since a build pod which cannot pull it
+// only fails with an image pull error. Base images without mirrors are not
+// checked. The returned error names the registries which were tried.
+func (ctrl *Controller) validateBaseImageResolvable(inputs *buildInputs) error {
+	if inputs.registriesConfig == "" {
+		return nil
+	}
+
+	baseImage := newBaseImageInfo(inputs)
+
+	sources, err := getImagePullSources(baseImage.Pullspec, inputs.registriesConfig)
+	if err != nil {
+		return fmt.Errorf("could not get registries for base image %s: %w", baseImage.Pullspec, err)
+	}
+
+	if len(sources) < 2 {
+		return nil
+	}
+
+	secret, err := ctrl.kubeclient.CoreV1().Secrets(ctrlcommon.MCONamespace).Get(context.TODO(), baseImage.PullSecret.Name, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("could not get base image pull secret %s: %w", baseImage.PullSecret.Name, err)
+	}
+
+	canonical, err := canonicalizePullSecret(secret)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.TODO(), baseImageResolveTimeout)
+	defer cancel()
+
+	opts := imageResolveOptions{
+		Authfile:         canonical.Data[corev1.DockerConfigJsonKey],
+		RegistriesConfig: inputs.registriesConfig,
+		RegistryCAs:      inputs.registryCAs,
+	}
+
+	if err := ctrl.imageResolver.ResolveImage(ctx, baseImage.Pullspec, opts); err != nil {
+		return fmt.Errorf("base image %s could not be pulled from any of its registries (%s); check that they are reachable from the cluster, trusted and accessible with pull secret %s: %w", baseImage.Pullspec, strings.Join(sources, ", "), baseImage.PullSecret.Name, err)
+	}
+
+	klog.Infof("Base image %s can be pulled through its mirrors", baseImage.Pullspec)
+
+	return nil
+}
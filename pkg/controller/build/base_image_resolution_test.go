@@ -0,0 +1,198 @@
+package build
+
+import (
+	"context"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	fakecorev1client "k8s.io/client-go/kubernetes/fake"
+)
+
+const testManifest = `{
+  "schemaVersion": 2,
+  "mediaType": "application/vnd.docker.distribution.manifest.v2+json",
+  "config": {
+    "mediaType": "application/vnd.docker.container.image.v1+json",
+    "size": 2,
+    "digest": "sha256:44136fa355b3678a1146ad16f7e8649e94fb4fc21fe77e8310c060f61caaff8a"
+  },
+  "layers": []
+}`
+
+// Gets a registries config which mirrors the given source to the given
+// mirror.
+func getMirroredRegistriesConfig(source, mirror string) string {
+	return fmt.Sprintf(`[[registry]]
+  prefix = ""
+  location = %q
+
+  [[registry.mirror]]
+    location = %q
+`, source, mirror)
+}
+
+// Serves the manifest of the org/os:latest image.
+func newFakeRegistry(t *testing.T) (*httptest.Server, string) {
+	t.Helper()
+
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v2/":
+			w.WriteHeader(http.StatusOK)
+		case "/v2/org/os/manifests/latest":
+			w.Header().Set("Content-Type", "application/vnd.docker.distribution.manifest.v2+json")
+			w.Write([]byte(testManifest))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	u, err := url.Parse(srv.URL)
+	require.NoError(t, err)
+
+	return srv, u.Host
+}
+
+func TestGetImagePullSources(t *testing.T) {
+	t.Parallel()
+
+	sources, err := getImagePullSources("quay.io/openshift-release-dev/ocp-v4.0-art-dev@"+expectedImageSHA, testRegistriesConfig)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"mirror.example.com", "quay.io"}, sources)
+
+	// The mirror is only used for digested pullspecs.
+	sources, err = getImagePullSources("quay.io/openshift-release-dev/ocp-v4.0-art-dev:latest", testRegistriesConfig)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"quay.io"}, sources)
+
+	sources, err = getImagePullSources("registry.hostname.com/org/repo:latest", testRegistriesConfig)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"registry.hostname.com"}, sources)
+}
+
+func TestRegistryImageResolver(t *testing.T) {
+	t.Parallel()
+
+	srv, host := newFakeRegistry(t)
+
+	ca := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}))
+	registryCAs := map[string]string{
+		strings.ReplaceAll(host, ":", ".."): ca,
+	}
+
+	resolver := registryImageResolver{}
+
+	// Nothing listens on these ports.
+	unreachable := "127.0.0.1:1"
+	alsoUnreachable := "127.0.0.1:2"
+
+	// The source is unreachable, but the mirror is not.
+	err := resolver.ResolveImage(context.TODO(), unreachable+"/org/os:latest", imageResolveOptions{
+		RegistriesConfig: getMirroredRegistriesConfig(unreachable+"/org/os", host+"/org/os"),
+		RegistryCAs:      registryCAs,
+	})
+	assert.NoError(t, err)
+
+	// The mirror is not trusted without its CA.
+	err = resolver.ResolveImage(context.TODO(), unreachable+"/org/os:latest", imageResolveOptions{
+		RegistriesConfig: getMirroredRegistriesConfig(unreachable+"/org/os", host+"/org/os"),
+	})
+	assert.ErrorContains(t, err, "certificate")
+
+	// Neither the source nor the mirror are reachable.
+	err = resolver.ResolveImage(context.TODO(), unreachable+"/org/os:latest", imageResolveOptions{
+		RegistriesConfig: getMirroredRegistriesConfig(unreachable+"/org/os", alsoUnreachable+"/org/os"),
+		RegistryCAs:      registryCAs,
+	})
+	assert.ErrorContains(t, err, alsoUnreachable)
+}
+
+type fakeImageResolver struct {
+	pullspecs []string
+	err       error
+}
+
+func (f *fakeImageResolver) ResolveImage(_ context.Context, pullspec string, _ imageResolveOptions) error {
+	f.pullspecs = append(f.pullspecs, pullspec)
+	return f.err
+}
+
+// Tests that only base images with mirrors are checked and that the error
+// names the registries which were tried and the pull secret.
+func TestValidateBaseImageResolvable(t *testing.T) {
+	t.Parallel()
+
+	baseImage := "quay.io/openshift-release-dev/ocp-v4.0-art-dev@" + expectedImageSHA
+
+	newInputs := func(registriesConfig string) *buildInputs {
+		osImageURL := getOSImageURLConfigMap()
+		osImageURL.Data[baseOSContainerImageConfigKey] = baseImage
+
+		return &buildInputs{
+			onClusterBuildConfig: getOnClusterBuildConfigMap(),
+			osImageURL:           osImageURL,
+			registriesConfig:     registriesConfig,
+		}
+	}
+
+	newController := func(resolver imageResolver) *Controller {
+		return &Controller{
+			Clients: &Clients{
+				kubeclient: fakecorev1client.NewSimpleClientset(&corev1.Secret{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "base-image-pull-secret",
+						Namespace: ctrlcommon.MCONamespace,
+					},
+					Data: map[string][]byte{
+						corev1.DockerConfigJsonKey: []byte(`{"auths":{"quay.io":{"auth":"czAwcGVyczNrcjF0"}}}`),
+					},
+					Type: corev1.SecretTypeDockerConfigJson,
+				}),
+			},
+			imageResolver: resolver,
+		}
+	}
+
+	t.Run("No mirrors", func(t *testing.T) {
+		t.Parallel()
+
+		resolver := &fakeImageResolver{}
+		ctrl := newController(resolver)
+
+		assert.NoError(t, ctrl.validateBaseImageResolvable(newInputs("")))
+		assert.NoError(t, ctrl.validateBaseImageResolvable(newInputs(getMirroredRegistriesConfig("registry.hostname.com/org/os", "mirror.example.com/org/os"))))
+		assert.Empty(t, resolver.pullspecs)
+	})
+
+	t.Run("Resolvable", func(t *testing.T) {
+		t.Parallel()
+
+		resolver := &fakeImageResolver{}
+		ctrl := newController(resolver)
+
+		assert.NoError(t, ctrl.validateBaseImageResolvable(newInputs(testRegistriesConfig)))
+		assert.Equal(t, []string{baseImage}, resolver.pullspecs)
+	})
+
+	t.Run("Unresolvable", func(t *testing.T) {
+		t.Parallel()
+
+		ctrl := newController(&fakeImageResolver{err: fmt.Errorf("connection refused")})
+
+		err := ctrl.validateBaseImageResolvable(newInputs(testRegistriesConfig))
+		assert.ErrorContains(t, err, "(mirror.example.com, quay.io)")
+		assert.ErrorContains(t, err, "base-image-pull-secret")
+		assert.ErrorContains(t, err, "connection refused")
+	})
+}
@@ -0,0 +1,158 @@
+package build
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/containers/image/v5/docker/reference"
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// Annotation on a MachineConfigPool naming another layered pool whose
+	// newest image the configs stage of its builds is based on instead of the
+	// osImageURL. This chains builds: the pool is rebuilt whenever the other
+	// pool gets a new image.
+	BaseImagePoolAnnotationKey = "machineconfiguration.openshift.io/base-image-pool"
+
+	// Annotation on a MachineConfigPool with an image pullspec which the
+	// configs stage of its builds is based on instead of the osImageURL.
+	BaseImagePullspecAnnotationKey = "machineconfiguration.openshift.io/base-image-pullspec"
+
+	baseImageSourceUnavailableReason = "BaseImageSourceUnavailable"
+)
+
+// Gets the image which the configs stage of the build for the given pool is
+// based on, if it is overridden. The newest image of a source pool is only
+// available once that pool has built one. An empty string means that the
+// osImageURL is used.
+func getConfigsBaseImage(pool *mcfgv1.MachineConfigPool, pools []*mcfgv1.MachineConfigPool) (string, error) {
+	sourcePool := pool.Annotations[BaseImagePoolAnnotationKey]
+	pullspec := pool.Annotations[BaseImagePullspecAnnotationKey]
+
+	if sourcePool != "" && pullspec != "" {
+		return "", fmt.Errorf("only one of %s and %s may be set", BaseImagePoolAnnotationKey, BaseImagePullspecAnnotationKey)
+	}
+
+	if pullspec != "" {
+		if _, err := reference.ParseNamed(pullspec); err != nil {
+			return "", fmt.Errorf("could not parse %s %q: %w", BaseImagePullspecAnnotationKey, pullspec, err)
+		}
+
+		return pullspec, nil
+	}
+
+	if sourcePool == "" {
+		return "", nil
+	}
+
+	byName := map[string]*mcfgv1.MachineConfigPool{}
+	for _, p := range pools {
+		byName[p.Name] = p
+	}
+
+	// A cycle would rebuild each of its pools whenever one of them has a new
+	// image, forever.
+	chain := []string{pool.Name}
+	for name := sourcePool; name != ""; name = byName[name].Annotations[BaseImagePoolAnnotationKey] {
+		for _, seen := range chain {
+			if name == seen {
+				return "", fmt.Errorf("%s forms a cycle: %v", BaseImagePoolAnnotationKey, append(chain, name))
+			}
+		}
+
+		if _, ok := byName[name]; !ok {
+			return "", fmt.Errorf("MachineConfigPool %s from %s does not exist", name, BaseImagePoolAnnotationKey)
+		}
+
+		chain = append(chain, name)
+	}
+
+	lps := ctrlcommon.NewLayeredPoolState(byName[sourcePool])
+	if !lps.IsLayered() {
+		return "", fmt.Errorf("MachineConfigPool %s from %s is not opted into on-cluster builds", sourcePool, BaseImagePoolAnnotationKey)
+	}
+
+	if !lps.HasOSImage() {
+		return "", fmt.Errorf("MachineConfigPool %s from %s has not built an image yet", sourcePool, BaseImagePoolAnnotationKey)
+	}
+
+	return lps.GetOSImage(), nil
+}
+
+// Gets the image which the configs stage of the build for the given pool is
+// based on, if it is overridden.
+func (ctrl *Controller) getConfigsBaseImage(pool *mcfgv1.MachineConfigPool) (string, error) {
+	pools, err := ctrl.mcpLister.List(labels.Everything())
+	if err != nil {
+		return "", fmt.Errorf("could not list MachineConfigPools: %w", err)
+	}
+
+	return getConfigsBaseImage(pool, pools)
+}
+
+// Gets the layered pools whose configs stage is based on the newest image of
+// the given pool.
+func getDependentPools(pool *mcfgv1.MachineConfigPool, pools []*mcfgv1.MachineConfigPool) []*mcfgv1.MachineConfigPool {
+	dependents := []*mcfgv1.MachineConfigPool{}
+
+	for _, p := range pools {
+		if p.Name != pool.Name && p.Annotations[BaseImagePoolAnnotationKey] == pool.Name && ctrlcommon.IsLayeredPool(p) {
+			dependents = append(dependents, p)
+		}
+	}
+
+	return dependents
+}
+
+// Requests a rebuild of each pool whose configs stage is based on the newest
+// image of the given pool, now that it has a new image. The image pullspec is
+// used as the rebuild request so that each image is only acted upon once.
+func (ctrl *Controller) rebuildDependentPools(pool *mcfgv1.MachineConfigPool) error {
+	image := ctrlcommon.NewLayeredPoolState(pool).GetOSImage()
+	if image == "" {
+		return nil
+	}
+
+	pools, err := ctrl.mcpLister.List(labels.Everything())
+	if err != nil {
+		return fmt.Errorf("could not list MachineConfigPools: %w", err)
+	}
+
+	for _, dependent := range getDependentPools(pool, pools) {
+		if dependent.Annotations[RebuildAnnotationKey] == image {
+			continue
+		}
+
+		err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+			mcp, err := ctrl.mcfgclient.MachineconfigurationV1().MachineConfigPools().Get(context.TODO(), dependent.Name, metav1.GetOptions{})
+			if err != nil {
+				return err
+			}
+
+			if mcp.Annotations == nil {
+				mcp.Annotations = map[string]string{}
+			}
+
+			mcp.Annotations[RebuildAnnotationKey] = image
+
+			_, err = ctrl.mcfgclient.MachineconfigurationV1().MachineConfigPools().Update(context.TODO(), mcp, metav1.UpdateOptions{})
+			return err
+		})
+
+		if err != nil {
+			return fmt.Errorf("could not request rebuild of MachineConfigPool %s: %w", dependent.Name, err)
+		}
+
+		klog.Infof("Requested rebuild of MachineConfigPool %s on new image %s of MachineConfigPool %s", dependent.Name, image, pool.Name)
+		ctrl.eventRecorder.Eventf(dependent, corev1.EventTypeNormal, "BaseImageChanged", "Rebuilding on new image %s of MachineConfigPool %s", image, pool.Name)
+	}
+
+	return nil
+}
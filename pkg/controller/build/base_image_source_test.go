@@ -0,0 +1,169 @@
+package build
+
+import (
+	"testing"
+
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetConfigsBaseImage(t *testing.T) {
+	t.Parallel()
+
+	newPool := func(name string, layered bool, annos map[string]string) *mcfgv1.MachineConfigPool {
+		mcp := newMachineConfigPool(name)
+		if layered {
+			mcp.Labels[ctrlcommon.LayeringEnabledPoolLabel] = ""
+		}
+
+		if mcp.Annotations == nil {
+			mcp.Annotations = map[string]string{}
+		}
+
+		for key, val := range annos {
+			mcp.Annotations[key] = val
+		}
+
+		return mcp
+	}
+
+	sourceImage := "registry.hostname.com/org/repo@sha256:e1992c2a48ae43ee8fcd1f0b8e9b9e6da6d6a4b8fa6d3a2c6a69c3c3b3f7e7c6"
+
+	testCases := []struct {
+		name        string
+		pool        *mcfgv1.MachineConfigPool
+		pools       []*mcfgv1.MachineConfigPool
+		expected    string
+		errExpected bool
+	}{
+		{
+			name: "Defaults to the osImageURL",
+			pool: newPool("worker", true, nil),
+		},
+		{
+			name:     "Explicit pullspec",
+			pool:     newPool("worker", true, map[string]string{BaseImagePullspecAnnotationKey: "registry.hostname.com/org/base:latest"}),
+			expected: "registry.hostname.com/org/base:latest",
+		},
+		{
+			name:        "Invalid pullspec",
+			pool:        newPool("worker", true, map[string]string{BaseImagePullspecAnnotationKey: "Not a pullspec"}),
+			errExpected: true,
+		},
+		{
+			name: "Both pool and pullspec",
+			pool: newPool("worker", true, map[string]string{
+				BaseImagePoolAnnotationKey:     "infra",
+				BaseImagePullspecAnnotationKey: "registry.hostname.com/org/base:latest",
+			}),
+			pools:       []*mcfgv1.MachineConfigPool{newPool("infra", true, map[string]string{ctrlcommon.ExperimentalNewestLayeredImageEquivalentConfigAnnotationKey: sourceImage})},
+			errExpected: true,
+		},
+		{
+			name:     "Newest image of another pool",
+			pool:     newPool("worker", true, map[string]string{BaseImagePoolAnnotationKey: "infra"}),
+			pools:    []*mcfgv1.MachineConfigPool{newPool("infra", true, map[string]string{ctrlcommon.ExperimentalNewestLayeredImageEquivalentConfigAnnotationKey: sourceImage})},
+			expected: sourceImage,
+		},
+		{
+			name:        "Other pool has no image yet",
+			pool:        newPool("worker", true, map[string]string{BaseImagePoolAnnotationKey: "infra"}),
+			pools:       []*mcfgv1.MachineConfigPool{newPool("infra", true, nil)},
+			errExpected: true,
+		},
+		{
+			name:        "Other pool is not layered",
+			pool:        newPool("worker", true, map[string]string{BaseImagePoolAnnotationKey: "infra"}),
+			pools:       []*mcfgv1.MachineConfigPool{newPool("infra", false, map[string]string{ctrlcommon.ExperimentalNewestLayeredImageEquivalentConfigAnnotationKey: sourceImage})},
+			errExpected: true,
+		},
+		{
+			name:        "Other pool does not exist",
+			pool:        newPool("worker", true, map[string]string{BaseImagePoolAnnotationKey: "infra"}),
+			errExpected: true,
+		},
+		{
+			name: "Cycle",
+			pool: newPool("worker", true, map[string]string{BaseImagePoolAnnotationKey: "infra"}),
+			pools: []*mcfgv1.MachineConfigPool{
+				newPool("infra", true, map[string]string{
+					BaseImagePoolAnnotationKey: "worker",
+					ctrlcommon.ExperimentalNewestLayeredImageEquivalentConfigAnnotationKey: sourceImage,
+				}),
+				newPool("worker", true, map[string]string{BaseImagePoolAnnotationKey: "infra"}),
+			},
+			errExpected: true,
+		},
+		{
+			name:        "Self-reference",
+			pool:        newPool("worker", true, map[string]string{BaseImagePoolAnnotationKey: "worker"}),
+			pools:       []*mcfgv1.MachineConfigPool{newPool("worker", true, map[string]string{BaseImagePoolAnnotationKey: "worker"})},
+			errExpected: true,
+		},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			image, err := getConfigsBaseImage(testCase.pool, testCase.pools)
+			if testCase.errExpected {
+				assert.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, testCase.expected, image)
+		})
+	}
+}
+
+func TestGetDependentPools(t *testing.T) {
+	t.Parallel()
+
+	infra := newMachineConfigPool("infra")
+
+	dependent := newMachineConfigPool("worker")
+	dependent.Labels[ctrlcommon.LayeringEnabledPoolLabel] = ""
+	dependent.Annotations = map[string]string{BaseImagePoolAnnotationKey: "infra"}
+
+	notLayered := newMachineConfigPool("edge")
+	notLayered.Annotations = map[string]string{BaseImagePoolAnnotationKey: "infra"}
+
+	other := newMachineConfigPool("gpu")
+	other.Labels[ctrlcommon.LayeringEnabledPoolLabel] = ""
+	other.Annotations = map[string]string{BaseImagePoolAnnotationKey: "worker"}
+
+	pools := []*mcfgv1.MachineConfigPool{infra, dependent, notLayered, other}
+
+	assert.Equal(t, []*mcfgv1.MachineConfigPool{dependent}, getDependentPools(infra, pools))
+	assert.Equal(t, []*mcfgv1.MachineConfigPool{other}, getDependentPools(dependent, pools))
+	assert.Empty(t, getDependentPools(other, pools))
+}
+
+func TestImageBuildRequestConfigsBaseImage(t *testing.T) {
+	t.Parallel()
+
+	inputs := &buildInputs{
+		pool:                 newMachineConfigPool("worker", "rendered-worker-1"),
+		osImageURL:           getOSImageURLConfigMap(),
+		onClusterBuildConfig: getOnClusterBuildConfigMap(),
+	}
+
+	ibr := newImageBuildRequestFromBuildInputs(inputs)
+
+	dockerfile, err := ibr.renderDockerfile()
+	require.NoError(t, err)
+	assert.Contains(t, dockerfile, "FROM "+ibr.BaseImage.Pullspec+" AS configs")
+
+	inputs.configsBaseImage = "registry.hostname.com/org/base:latest"
+	ibr = newImageBuildRequestFromBuildInputs(inputs)
+
+	dockerfile, err = ibr.renderDockerfile()
+	require.NoError(t, err)
+	assert.Contains(t, dockerfile, "FROM registry.hostname.com/org/base:latest AS configs")
+	assert.Equal(t, "registry.hostname.com/org/base:latest", ibr.Provenance[ProvenanceBaseImageLabel])
+}
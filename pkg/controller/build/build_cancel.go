@@ -0,0 +1,126 @@
+package build
+
+import (
+	"context"
+	"fmt"
+
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	"github.com/openshift/machine-config-operator/pkg/apihelpers"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// Annotation on the MachineConfigPool which requests that its pending or
+	// running build is cancelled when set to a new value, e.g., the current
+	// time. The pool stays opted into on-cluster builds and keeps its current
+	// image.
+	CancelBuildAnnotationKey = "machineconfiguration.openshift.io/cancel-build"
+
+	// Annotation on the MachineConfigPool which records the last value of the
+	// cancel build annotation that was acted upon.
+	LastCancelBuildAnnotationKey = "machineconfiguration.openshift.io/last-cancel-build"
+
+	// Condition and event reason used when a build is cancelled.
+	buildCancelledReason = "BuildCancelled"
+)
+
+// Determines if a build cancellation was requested for the given
+// MachineConfigPool that has not been acted upon yet.
+func isBuildCancelRequested(pool *mcfgv1.MachineConfigPool) bool {
+	requested := pool.Annotations[CancelBuildAnnotationKey]
+	return requested != "" && requested != pool.Annotations[LastCancelBuildAnnotationKey]
+}
+
+// Determines if the build of the given MachineConfigPool was cancelled.
+func isBuildCancelled(pool *mcfgv1.MachineConfigPool) bool {
+	cond := apihelpers.GetMachineConfigPoolCondition(pool.Status, mcfgv1.MachineConfigPoolBuildFailed)
+	return cond != nil && cond.Status == corev1.ConditionTrue && cond.Reason == buildCancelledReason
+}
+
+// Cancels the pending or running build of the given MachineConfigPool, if it
+// has one. The pool is marked as a failed build first so that the build
+// object terminating is not handled as a build failure, which could be
+// retried. The cancelled build is not retried and does not degrade the pool;
+// a rebuild or a new rendered MachineConfig starts a new build.
+func (ctrl *Controller) cancelBuildForMachineConfigPool(ps *poolState) error {
+	pool := ps.MachineConfigPool()
+	requested := pool.Annotations[CancelBuildAnnotationKey]
+
+	// A cancellation which could not delete the build object is retried.
+	inProgress := ps.IsBuildPending() || ps.IsBuilding() || isBuildCancelled(pool)
+
+	klog.Infof("Build cancellation %q requested for MachineConfigPool %s, config %s", requested, ps.Name(), ps.CurrentMachineConfig())
+
+	msg := fmt.Sprintf("Build for config %s was cancelled", ps.CurrentMachineConfig())
+
+	if ps.IsBuildPending() || ps.IsBuilding() {
+		ctrl.setBuildStatusFailed(pool, msg)
+
+		err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+			mcp, err := ctrl.mcfgclient.MachineconfigurationV1().MachineConfigPools().Get(context.TODO(), ps.Name(), metav1.GetOptions{})
+			if err != nil {
+				return err
+			}
+
+			ps := newPoolState(mcp)
+			ps.SetBuildConditions([]mcfgv1.MachineConfigPoolCondition{
+				{
+					Type:    mcfgv1.MachineConfigPoolBuildFailed,
+					Reason:  buildCancelledReason,
+					Message: msg,
+					Status:  corev1.ConditionTrue,
+				},
+				{
+					Type:   mcfgv1.MachineConfigPoolBuildSuccess,
+					Status: corev1.ConditionFalse,
+				},
+				{
+					Type:   mcfgv1.MachineConfigPoolBuilding,
+					Status: corev1.ConditionFalse,
+				},
+				{
+					Type:   mcfgv1.MachineConfigPoolBuildPending,
+					Status: corev1.ConditionFalse,
+				},
+			})
+
+			_, err = ctrl.mcfgclient.MachineconfigurationV1().MachineConfigPools().UpdateStatus(context.TODO(), ps.MachineConfigPool(), metav1.UpdateOptions{})
+			return err
+		})
+
+		if err != nil {
+			return fmt.Errorf("could not mark build for pool %s as cancelled: %w", ps.Name(), err)
+		}
+	}
+
+	if inProgress {
+		if err := ignoreIsNotFoundErr(ctrl.imageBuilder.DeleteBuildObject(pool)); err != nil {
+			return fmt.Errorf("could not delete cancelled build for pool %s: %w", ps.Name(), err)
+		}
+
+		ctrl.eventRecorder.Eventf(pool, corev1.EventTypeNormal, buildCancelledReason, msg)
+	} else {
+		klog.Infof("MachineConfigPool %s has no pending or running build to cancel", ps.Name())
+	}
+
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		mcp, err := ctrl.mcfgclient.MachineconfigurationV1().MachineConfigPools().Get(context.TODO(), ps.Name(), metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+
+		mcp.Annotations[LastCancelBuildAnnotationKey] = requested
+
+		_, err = ctrl.mcfgclient.MachineconfigurationV1().MachineConfigPools().Update(context.TODO(), mcp, metav1.UpdateOptions{})
+		return err
+	})
+
+	if err != nil {
+		return fmt.Errorf("could not record build cancellation for pool %s: %w", ps.Name(), err)
+	}
+
+	return nil
+}
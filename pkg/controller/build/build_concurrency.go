@@ -0,0 +1,285 @@
+package build
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// The on-cluster-build-config ConfigMap key which contains the maximum
+	// number of builds which may be pending or running at the same time across
+	// all layered MachineConfigPools. Builds are not limited when this is not
+	// set or is zero.
+	MaxConcurrentBuildsConfigKey = "maxConcurrentBuilds"
+
+	// Annotation on the MachineConfigPool which records when its build was
+	// queued because the maximum number of concurrent builds was reached.
+	// Queued builds are started in the order they were queued.
+	BuildQueuedAnnotationKey = "machineconfiguration.openshift.io/build-queued"
+
+	// How often a queued build checks whether it may start.
+	buildQueueRequeueInterval = 15 * time.Second
+)
+
+// Gets the maximum number of concurrent builds from the on-cluster-build-config
+// ConfigMap. Zero means builds are not limited.
+func getMaxConcurrentBuilds(cm *corev1.ConfigMap) (int, error) {
+	if cm == nil {
+		return 0, nil
+	}
+
+	val, ok := cm.Data[MaxConcurrentBuildsConfigKey]
+	if !ok || val == "" {
+		return 0, nil
+	}
+
+	maxBuilds, err := strconv.Atoi(val)
+	if err != nil {
+		return 0, fmt.Errorf("could not parse %s %q: %w", MaxConcurrentBuildsConfigKey, val, err)
+	}
+
+	if maxBuilds < 0 {
+		return 0, fmt.Errorf("%s %q must not be negative", MaxConcurrentBuildsConfigKey, val)
+	}
+
+	return maxBuilds, nil
+}
+
+// Gets the time the build for the given MachineConfigPool was queued. Returns
+// false if the build is not queued.
+func getBuildQueuedTime(pool *mcfgv1.MachineConfigPool) (time.Time, bool) {
+	val, ok := pool.Annotations[BuildQueuedAnnotationKey]
+	if !ok {
+		return time.Time{}, false
+	}
+
+	queued, err := time.Parse(time.RFC3339Nano, val)
+	if err != nil {
+		// An unparseable value is treated as queued as early as possible so
+		// that the pool is not starved.
+		return time.Time{}, true
+	}
+
+	return queued, true
+}
+
+// Determines whether the given MachineConfigPool has a pending or running
+// build, which counts towards the maximum number of concurrent builds.
+func hasActiveBuild(pool *mcfgv1.MachineConfigPool) bool {
+	lps := ctrlcommon.NewLayeredPoolState(pool)
+	return lps.IsLayered() && (lps.IsBuildPending() || lps.IsBuilding())
+}
+
+// Determines whether the build for the given MachineConfigPool may start
+// without exceeding the maximum number of concurrent builds. Pools whose
+// builds were admitted but not marked pending yet count as active. Builds
+// which were queued earlier are started first; a pool which is not queued yet
+// goes to the back of the queue.
+func canStartBuild(pool *mcfgv1.MachineConfigPool, pools []*mcfgv1.MachineConfigPool, admitted sets.String, maxBuilds int, now time.Time) bool {
+	if maxBuilds == 0 {
+		return true
+	}
+
+	type queuedPool struct {
+		name   string
+		queued time.Time
+	}
+
+	active := 0
+	queue := []queuedPool{}
+
+	for _, p := range pools {
+		if p.Name == pool.Name || !ctrlcommon.IsLayeredPool(p) {
+			continue
+		}
+
+		if hasActiveBuild(p) || admitted.Has(p.Name) {
+			active++
+			continue
+		}
+
+		if queued, ok := getBuildQueuedTime(p); ok {
+			queue = append(queue, queuedPool{name: p.Name, queued: queued})
+		}
+	}
+
+	available := maxBuilds - active
+	if available <= 0 {
+		return false
+	}
+
+	queued, ok := getBuildQueuedTime(pool)
+	if !ok {
+		queued = now
+	}
+
+	sort.Slice(queue, func(i, j int) bool {
+		if queue[i].queued.Equal(queue[j].queued) {
+			return queue[i].name < queue[j].name
+		}
+
+		return queue[i].queued.Before(queue[j].queued)
+	})
+
+	ahead := 0
+	for _, q := range queue {
+		if q.queued.After(queued) || (q.queued.Equal(queued) && q.name > pool.Name) {
+			break
+		}
+
+		ahead++
+	}
+
+	return ahead < available
+}
+
+// Determines whether the build for the given MachineConfigPool may start. If
+// the maximum number of concurrent builds has been reached, the pool is marked
+// as queued and requeued to check again later. Once the build may start, the
+// pool is removed from the queue and recorded as admitted until
+// releaseBuildAdmission is called, since the workers sync pools concurrently
+// and a pool only counts as active once its build is marked pending.
+func (ctrl *Controller) admitBuild(ps *poolState) (bool, error) {
+	ctrl.admissionLock.Lock()
+	defer ctrl.admissionLock.Unlock()
+
+	cm, err := ctrl.configMapLister.ConfigMaps(ctrlcommon.MCONamespace).Get(OnClusterBuildConfigMapName)
+	if err != nil && !k8serrors.IsNotFound(err) {
+		return false, fmt.Errorf("could not get build controller config %q: %w", OnClusterBuildConfigMapName, err)
+	}
+
+	if k8serrors.IsNotFound(err) {
+		cm = nil
+	}
+
+	maxBuilds, err := getMaxConcurrentBuilds(cm)
+	if err != nil {
+		return false, fmt.Errorf("could not get maximum concurrent builds: %w", err)
+	}
+
+	pool := ps.MachineConfigPool()
+
+	if maxBuilds == 0 {
+		return ctrl.admit(ps)
+	}
+
+	// The lister may lag behind builds which were just started, so the API
+	// server is consulted directly.
+	poolList, err := ctrl.mcfgclient.MachineconfigurationV1().MachineConfigPools().List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return false, fmt.Errorf("could not list MachineConfigPools: %w", err)
+	}
+
+	pools := []*mcfgv1.MachineConfigPool{}
+	for i := range poolList.Items {
+		pools = append(pools, &poolList.Items[i])
+	}
+
+	if canStartBuild(pool, pools, ctrl.admittedBuilds, maxBuilds, time.Now()) {
+		return ctrl.admit(ps)
+	}
+
+	if _, ok := getBuildQueuedTime(pool); !ok {
+		klog.Infof("Maximum of %d concurrent builds reached, queueing build for MachineConfigPool %s", maxBuilds, ps.Name())
+		ctrl.eventRecorder.Eventf(pool, corev1.EventTypeNormal, "BuildQueued", "Build queued; maximum of %d concurrent builds reached", maxBuilds)
+	}
+
+	if err := ctrl.setBuildQueued(ps, true); err != nil {
+		return false, err
+	}
+
+	ctrl.enqueueAfter(pool, buildQueueRequeueInterval)
+	return false, nil
+}
+
+// Removes the MachineConfigPool from the build queue and records its build as
+// admitted. Must be called with the admission lock held.
+func (ctrl *Controller) admit(ps *poolState) (bool, error) {
+	if err := ctrl.setBuildQueued(ps, false); err != nil {
+		return false, err
+	}
+
+	if ctrl.admittedBuilds == nil {
+		ctrl.admittedBuilds = sets.NewString()
+	}
+
+	ctrl.admittedBuilds.Insert(ps.Name())
+	return true, nil
+}
+
+// Releases the admission of the build for the MachineConfigPool once its build
+// is marked pending, or failed to start.
+func (ctrl *Controller) releaseBuildAdmission(ps *poolState) {
+	ctrl.admissionLock.Lock()
+	defer ctrl.admissionLock.Unlock()
+
+	ctrl.admittedBuilds.Delete(ps.Name())
+}
+
+// Enqueues each MachineConfigPool with a queued build so that the next one in
+// line starts as soon as a running build finishes, rather than waiting for its
+// periodic requeue.
+func (ctrl *Controller) enqueueQueuedBuilds() {
+	pools, err := ctrl.mcpLister.List(labels.Everything())
+	if err != nil {
+		utilruntime.HandleError(fmt.Errorf("could not list MachineConfigPools: %w", err))
+		return
+	}
+
+	for _, pool := range pools {
+		if _, ok := getBuildQueuedTime(pool); ok && ctrlcommon.IsLayeredPool(pool) {
+			ctrl.enqueueMachineConfigPool(pool)
+		}
+	}
+}
+
+// Adds or removes the build queued annotation on the MachineConfigPool. An
+// existing queued time is kept so that the pool does not lose its place in
+// the queue.
+func (ctrl *Controller) setBuildQueued(ps *poolState, queued bool) error {
+	if _, ok := getBuildQueuedTime(ps.MachineConfigPool()); ok == queued {
+		return nil
+	}
+
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		mcp, err := ctrl.mcfgclient.MachineconfigurationV1().MachineConfigPools().Get(context.TODO(), ps.Name(), metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+
+		if _, ok := getBuildQueuedTime(mcp); ok == queued {
+			return nil
+		}
+
+		ps := newPoolState(mcp)
+
+		if queued {
+			ps.SetBuildQueuedTime(time.Now())
+		} else {
+			ps.SetBuildQueuedTime(time.Time{})
+		}
+
+		_, err = ctrl.mcfgclient.MachineconfigurationV1().MachineConfigPools().Update(context.TODO(), ps.pool, metav1.UpdateOptions{})
+		return err
+	})
+
+	if err != nil {
+		return fmt.Errorf("could not update build queued annotation for MachineConfigPool %s: %w", ps.Name(), err)
+	}
+
+	return nil
+}
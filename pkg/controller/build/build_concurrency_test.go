@@ -0,0 +1,296 @@
+package build
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	fakeclientmachineconfigv1 "github.com/openshift/client-go/machineconfiguration/clientset/versioned/fake"
+	"github.com/openshift/machine-config-operator/pkg/apihelpers"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/sets"
+	fakecorev1client "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
+)
+
+// Tests that the maximum number of concurrent builds is read from the
+// on-cluster-build-config ConfigMap.
+func TestGetMaxConcurrentBuilds(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name        string
+		data        map[string]string
+		expected    int
+		expectError bool
+	}{
+		{
+			name:     "not set",
+			expected: 0,
+		},
+		{
+			name:     "empty value",
+			data:     map[string]string{MaxConcurrentBuildsConfigKey: ""},
+			expected: 0,
+		},
+		{
+			name:     "set",
+			data:     map[string]string{MaxConcurrentBuildsConfigKey: "2"},
+			expected: 2,
+		},
+		{
+			name:        "invalid",
+			data:        map[string]string{MaxConcurrentBuildsConfigKey: "two"},
+			expectError: true,
+		},
+		{
+			name:        "negative",
+			data:        map[string]string{MaxConcurrentBuildsConfigKey: "-1"},
+			expectError: true,
+		},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			cm := getOnClusterBuildConfigMap()
+			for k, v := range testCase.data {
+				cm.Data[k] = v
+			}
+
+			maxBuilds, err := getMaxConcurrentBuilds(cm)
+			if testCase.expectError {
+				assert.Error(t, err)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, testCase.expected, maxBuilds)
+		})
+	}
+
+	maxBuilds, err := getMaxConcurrentBuilds(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, maxBuilds)
+}
+
+// Tests that builds are started in the order they were queued once the
+// number of pending or running builds drops below the maximum.
+func TestCanStartBuild(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+
+	newPool := func(name string, layered bool, condType mcfgv1.MachineConfigPoolConditionType, queued time.Time) *mcfgv1.MachineConfigPool {
+		pool := newMachineConfigPool(name)
+
+		if layered {
+			pool.Labels[ctrlcommon.LayeringEnabledPoolLabel] = ""
+		}
+
+		if condType != "" {
+			cond := apihelpers.NewMachineConfigPoolCondition(condType, corev1.ConditionTrue, "", "")
+			apihelpers.SetMachineConfigPoolCondition(&pool.Status, *cond)
+		}
+
+		if !queued.IsZero() {
+			ps := newPoolState(pool)
+			ps.SetBuildQueuedTime(queued)
+			pool = ps.MachineConfigPool()
+		}
+
+		return pool
+	}
+
+	building := func(name string) *mcfgv1.MachineConfigPool {
+		return newPool(name, true, mcfgv1.MachineConfigPoolBuilding, time.Time{})
+	}
+
+	queued := func(name string, at time.Time) *mcfgv1.MachineConfigPool {
+		return newPool(name, true, "", at)
+	}
+
+	testCases := []struct {
+		name      string
+		pool      *mcfgv1.MachineConfigPool
+		pools     []*mcfgv1.MachineConfigPool
+		admitted  []string
+		maxBuilds int
+		expected  bool
+	}{
+		{
+			name:      "unlimited",
+			pool:      queued("worker", time.Time{}),
+			pools:     []*mcfgv1.MachineConfigPool{building("infra"), building("gpu")},
+			maxBuilds: 0,
+			expected:  true,
+		},
+		{
+			name:      "below limit",
+			pool:      queued("worker", time.Time{}),
+			pools:     []*mcfgv1.MachineConfigPool{building("infra")},
+			maxBuilds: 2,
+			expected:  true,
+		},
+		{
+			name:      "at limit",
+			pool:      queued("worker", time.Time{}),
+			pools:     []*mcfgv1.MachineConfigPool{building("infra"), newPool("gpu", true, mcfgv1.MachineConfigPoolBuildPending, time.Time{})},
+			maxBuilds: 2,
+			expected:  false,
+		},
+		{
+			name:      "admitted builds which are not pending yet are counted",
+			pool:      queued("worker", time.Time{}),
+			pools:     []*mcfgv1.MachineConfigPool{queued("infra", time.Time{}), building("gpu")},
+			admitted:  []string{"infra"},
+			maxBuilds: 2,
+			expected:  false,
+		},
+		{
+			name:      "pool itself is not counted",
+			pool:      building("worker"),
+			pools:     []*mcfgv1.MachineConfigPool{building("worker")},
+			maxBuilds: 1,
+			expected:  true,
+		},
+		{
+			name: "finished and non-layered builds are not counted",
+			pool: queued("worker", time.Time{}),
+			pools: []*mcfgv1.MachineConfigPool{
+				newPool("infra", true, mcfgv1.MachineConfigPoolBuildSuccess, time.Time{}),
+				newPool("gpu", true, mcfgv1.MachineConfigPoolBuildFailed, time.Time{}),
+				newPool("master", false, mcfgv1.MachineConfigPoolBuilding, time.Time{}),
+			},
+			maxBuilds: 1,
+			expected:  true,
+		},
+		{
+			name:      "earlier queued pool goes first",
+			pool:      queued("worker", now),
+			pools:     []*mcfgv1.MachineConfigPool{queued("infra", now.Add(-time.Minute))},
+			maxBuilds: 1,
+			expected:  false,
+		},
+		{
+			name:      "later queued pool goes after",
+			pool:      queued("worker", now.Add(-time.Minute)),
+			pools:     []*mcfgv1.MachineConfigPool{queued("infra", now)},
+			maxBuilds: 1,
+			expected:  true,
+		},
+		{
+			name:      "unqueued pool goes to the back of the queue",
+			pool:      queued("worker", time.Time{}),
+			pools:     []*mcfgv1.MachineConfigPool{queued("infra", now.Add(-time.Minute))},
+			maxBuilds: 1,
+			expected:  false,
+		},
+		{
+			name:      "ties are broken by name",
+			pool:      queued("worker", now),
+			pools:     []*mcfgv1.MachineConfigPool{queued("infra", now)},
+			maxBuilds: 1,
+			expected:  false,
+		},
+		{
+			name:      "enough slots for queued pools ahead",
+			pool:      queued("worker", now),
+			pools:     []*mcfgv1.MachineConfigPool{queued("infra", now.Add(-time.Minute)), building("gpu")},
+			maxBuilds: 3,
+			expected:  true,
+		},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, testCase.expected, canStartBuild(testCase.pool, testCase.pools, sets.NewString(testCase.admitted...), testCase.maxBuilds, now))
+		})
+	}
+}
+
+// Tests that concurrent syncs do not admit more builds than allowed, even
+// before the admitted builds are marked pending.
+func TestAdmitBuildConcurrently(t *testing.T) {
+	t.Parallel()
+
+	cm := getOnClusterBuildConfigMap()
+	cm.Data[MaxConcurrentBuildsConfigKey] = "1"
+
+	pools := []*mcfgv1.MachineConfigPool{}
+	mcfgObjects := []runtime.Object{}
+	for _, name := range []string{"worker", "infra", "gpu"} {
+		pool := newMachineConfigPool(name)
+		pool.Labels[ctrlcommon.LayeringEnabledPoolLabel] = ""
+		pools = append(pools, pool)
+		mcfgObjects = append(mcfgObjects, pool)
+	}
+
+	ctrl := &Controller{
+		Clients: &Clients{
+			kubeclient: fakecorev1client.NewSimpleClientset(cm),
+			mcfgclient: fakeclientmachineconfigv1.NewSimpleClientset(mcfgObjects...),
+		},
+		eventRecorder:   record.NewFakeRecorder(10),
+		queue:           workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "test"),
+		configMapLister: newConfigMapLister(t, cm),
+	}
+	defer ctrl.queue.ShutDown()
+
+	admitted := make(chan *poolState, len(pools))
+	var wg sync.WaitGroup
+	for _, pool := range pools {
+		wg.Add(1)
+		go func(ps *poolState) {
+			defer wg.Done()
+			ok, err := ctrl.admitBuild(ps)
+			assert.NoError(t, err)
+			if ok {
+				admitted <- ps
+			}
+		}(newPoolState(pool))
+	}
+	wg.Wait()
+	close(admitted)
+
+	admittedPools := []*poolState{}
+	for ps := range admitted {
+		admittedPools = append(admittedPools, ps)
+	}
+	require.Len(t, admittedPools, 1)
+
+	// Once the admitted build is released without having started, the next
+	// queued build may start.
+	ctrl.releaseBuildAdmission(admittedPools[0])
+
+	started := 0
+	for _, pool := range pools {
+		if pool.Name == admittedPools[0].Name() {
+			continue
+		}
+
+		mcp, err := ctrl.mcfgclient.MachineconfigurationV1().MachineConfigPools().Get(context.TODO(), pool.Name, metav1.GetOptions{})
+		require.NoError(t, err)
+
+		ok, err := ctrl.admitBuild(newPoolState(mcp))
+		require.NoError(t, err)
+		if ok {
+			started++
+		}
+	}
+
+	assert.Equal(t, 1, started)
+}
@@ -0,0 +1,182 @@
+package build
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// The on-cluster-build-config ConfigMap key which, when set to "true",
+	// makes the build controller copy the build context of a failed build into
+	// a "<pool>-build-context" ConfigMap so that the build can be reproduced
+	// with podman outside of the cluster.
+	ExportBuildContextConfigKey = "exportBuildContext"
+
+	// The key of the exported build context ConfigMap which contains the
+	// script that reproduces the build.
+	buildContextScriptKey = "build.sh"
+
+	buildContextExportedReason = "BuildContextExported"
+)
+
+// Gets whether the build context of failed builds is exported.
+func getExportBuildContext(cm *corev1.ConfigMap) (bool, error) {
+	if cm == nil {
+		return false, nil
+	}
+
+	val, ok := cm.Data[ExportBuildContextConfigKey]
+	if !ok || val == "" {
+		return false, nil
+	}
+
+	enabled, err := strconv.ParseBool(val)
+	if err != nil {
+		return false, fmt.Errorf("could not parse %s %q: %w", ExportBuildContextConfigKey, val, err)
+	}
+
+	return enabled, nil
+}
+
+// Computes the name of the exported build context ConfigMap for the given
+// MachineConfigPool name.
+func getBuildContextConfigMapName(poolName string) string {
+	return fmt.Sprintf("%s-build-context", poolName)
+}
+
+// Renders the script which reproduces a build from the exported build
+// context. Build volumes are mounted from directories next to the script
+// since their Secrets and ConfigMaps are not exported.
+func renderBuildContextScript(ibr ImageBuildRequest, buildArgs []corev1.EnvVar, buildVolumes []buildVolume) string {
+	cmName := getBuildContextConfigMapName(ibr.Pool.Name)
+
+	out := &strings.Builder{}
+	fmt.Fprintf(out, "#!/usr/bin/env bash\n")
+	fmt.Fprintf(out, "#\n")
+	fmt.Fprintf(out, "# Reproduces the build of %s for MachineConfigPool %s with podman.\n", ibr.Pool.Spec.Configuration.Name, ibr.Pool.Name)
+	fmt.Fprintf(out, "# Extract the build context into an empty directory and run this script there:\n")
+	fmt.Fprintf(out, "#\n")
+	fmt.Fprintf(out, "#   oc extract -n %s configmap/%s --to=.\n", ctrlcommon.MCONamespace, cmName)
+	fmt.Fprintf(out, "#   bash %s\n", buildContextScriptKey)
+	fmt.Fprintf(out, "#\n")
+	fmt.Fprintf(out, "# The base image must be pullable with your podman credentials.\n")
+
+	if len(buildVolumes) != 0 {
+		fmt.Fprintf(out, "#\n")
+		fmt.Fprintf(out, "# The following build volumes are not exported. Extract them from the cluster first:\n")
+		fmt.Fprintf(out, "#\n")
+		for _, volume := range buildVolumes {
+			kind := "configmap"
+			if volume.IsSecret {
+				kind = "secret"
+			}
+			fmt.Fprintf(out, "#   oc extract -n %s %s/%s --to=volumes/%s\n", ctrlcommon.MCONamespace, kind, volume.Name, volume.Name)
+		}
+	}
+
+	fmt.Fprintf(out, "set -xeuo pipefail\n\n")
+	fmt.Fprintf(out, "mkdir -p machineconfig\n")
+	fmt.Fprintf(out, "cp %s machineconfig/%s\n\n", machineConfigJSONFilename, machineConfigJSONFilename)
+	fmt.Fprintf(out, "podman build \\\n")
+	for _, arg := range buildArgs {
+		fmt.Fprintf(out, "  --build-arg %s \\\n", strconv.Quote(arg.Name+"="+arg.Value))
+	}
+	for _, volume := range buildVolumes {
+		fmt.Fprintf(out, "  --volume \"$PWD/volumes/%s:%s:ro,Z\" \\\n", volume.Name, volume.Mountpoint)
+	}
+	fmt.Fprintf(out, "  --tag localhost/%s:%s \\\n", ibr.Pool.Name, ibr.Pool.Spec.Configuration.Name)
+	fmt.Fprintf(out, "  --file Dockerfile .\n")
+
+	return out.String()
+}
+
+// Copies the rendered Dockerfile and MachineConfig of the failed build for the
+// given MachineConfigPool into its build context ConfigMap, along with a
+// script to reproduce the build, if exporting the build context is enabled.
+// This is best-effort since the build inputs may already be gone.
+func (ctrl *Controller) exportBuildContext(ps *poolState) {
+	onClusterBuildConfig, err := ctrl.configMapLister.ConfigMaps(ctrlcommon.MCONamespace).Get(OnClusterBuildConfigMapName)
+	if err != nil {
+		klog.Errorf("Could not get build controller config %q: %s", OnClusterBuildConfigMapName, err)
+		return
+	}
+
+	enabled, err := getExportBuildContext(onClusterBuildConfig)
+	if err != nil {
+		klog.Errorf("Could not determine whether to export the build context: %s", err)
+		return
+	}
+
+	if !enabled {
+		return
+	}
+
+	if err := ctrl.writeBuildContext(ps, onClusterBuildConfig); err != nil {
+		klog.Errorf("Could not export build context for pool %s: %s", ps.Name(), err)
+	}
+}
+
+func (ctrl *Controller) writeBuildContext(ps *poolState, onClusterBuildConfig *corev1.ConfigMap) error {
+	ibr := newImageBuildRequest(ps.MachineConfigPool())
+
+	dockerfileConfigMap, err := ctrl.kubeclient.CoreV1().ConfigMaps(ctrlcommon.MCONamespace).Get(context.TODO(), ibr.getDockerfileConfigMapName(), metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("could not get Dockerfile ConfigMap: %w", err)
+	}
+
+	mcConfigMap, err := ctrl.kubeclient.CoreV1().ConfigMaps(ctrlcommon.MCONamespace).Get(context.TODO(), ibr.getMCConfigMapName(), metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("could not get MachineConfig ConfigMap: %w", err)
+	}
+
+	buildArgs, err := getBuildArgs(onClusterBuildConfig)
+	if err != nil {
+		return fmt.Errorf("could not get build args: %w", err)
+	}
+
+	buildVolumes, err := ctrl.getBuildVolumes(onClusterBuildConfig)
+	if err != nil {
+		return fmt.Errorf("could not get build volumes: %w", err)
+	}
+
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      getBuildContextConfigMapName(ps.Name()),
+			Namespace: ctrlcommon.MCONamespace,
+			Labels: map[string]string{
+				targetMachineConfigPoolLabel: ps.Name(),
+				desiredConfigLabel:           ps.CurrentMachineConfig(),
+			},
+			OwnerReferences: getPoolOwnerReference(ps.MachineConfigPool()),
+		},
+		Data: map[string]string{
+			"Dockerfile":              dockerfileConfigMap.Data["Dockerfile"],
+			machineConfigJSONFilename: mcConfigMap.Data[machineConfigJSONFilename],
+			buildContextScriptKey:     renderBuildContextScript(ibr, buildArgs, buildVolumes),
+		},
+	}
+
+	configMaps := ctrl.kubeclient.CoreV1().ConfigMaps(ctrlcommon.MCONamespace)
+	if _, err := configMaps.Update(context.TODO(), cm, metav1.UpdateOptions{}); err != nil {
+		if !k8serrors.IsNotFound(err) {
+			return err
+		}
+
+		if _, err := configMaps.Create(context.TODO(), cm, metav1.CreateOptions{}); err != nil {
+			return err
+		}
+	}
+
+	klog.Infof("Exported build context for pool %s to ConfigMap %s", ps.Name(), cm.Name)
+	ctrl.eventRecorder.Eventf(ps.MachineConfigPool(), corev1.EventTypeNormal, buildContextExportedReason, "Exported build context of %s to ConfigMap %s", ps.CurrentMachineConfig(), cm.Name)
+
+	return nil
+}
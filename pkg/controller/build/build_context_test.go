@@ -0,0 +1,104 @@
+package build
+
+import (
+	"context"
+	"testing"
+
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	fakecorev1client "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+)
+
+// Tests that the build context of a failed build is only exported when enabled
+// and that it contains everything needed to reproduce the build.
+func TestExportBuildContext(t *testing.T) {
+	t.Parallel()
+
+	pool := newMachineConfigPool("worker", "rendered-worker-1")
+	ibr := newImageBuildRequest(pool)
+
+	newController := func(enabled string) *Controller {
+		onClusterBuildConfigMap := getOnClusterBuildConfigMap()
+		onClusterBuildConfigMap.Data[ExportBuildContextConfigKey] = enabled
+		onClusterBuildConfigMap.Data[BuildArgsConfigKey] = "HTTP_PROXY=http://proxy.example.com:3128"
+
+		dockerfileConfigMap := &corev1.ConfigMap{
+			ObjectMeta: ibr.getObjectMeta(ibr.getDockerfileConfigMapName()),
+			Data:       map[string]string{"Dockerfile": "FROM base AS configs"},
+		}
+
+		mcConfigMap := &corev1.ConfigMap{
+			ObjectMeta: ibr.getObjectMeta(ibr.getMCConfigMapName()),
+			Data:       map[string]string{machineConfigJSONFilename: "H4sI"},
+		}
+
+		entitlement := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: EtcPkiEntitlementSecretName, Namespace: ctrlcommon.MCONamespace},
+		}
+
+		return &Controller{
+			Clients: &Clients{
+				kubeclient: fakecorev1client.NewSimpleClientset(onClusterBuildConfigMap, dockerfileConfigMap, mcConfigMap, entitlement),
+			},
+			eventRecorder:   record.NewFakeRecorder(10),
+			configMapLister: newConfigMapLister(t, onClusterBuildConfigMap),
+		}
+	}
+
+	t.Run("Disabled", func(t *testing.T) {
+		t.Parallel()
+
+		ctrl := newController("")
+		ctrl.exportBuildContext(newPoolState(pool))
+
+		_, err := ctrl.kubeclient.CoreV1().ConfigMaps(ctrlcommon.MCONamespace).Get(context.TODO(), "worker-build-context", metav1.GetOptions{})
+		assert.Error(t, err)
+	})
+
+	t.Run("Enabled", func(t *testing.T) {
+		t.Parallel()
+
+		ctrl := newController("true")
+		ctrl.exportBuildContext(newPoolState(pool))
+
+		cm, err := ctrl.kubeclient.CoreV1().ConfigMaps(ctrlcommon.MCONamespace).Get(context.TODO(), "worker-build-context", metav1.GetOptions{})
+		require.NoError(t, err)
+
+		assert.Equal(t, "rendered-worker-1", cm.Labels[desiredConfigLabel])
+		assert.NotContains(t, cm.Labels, ctrlcommon.OSImageBuildPodLabel)
+		assert.Equal(t, "FROM base AS configs", cm.Data["Dockerfile"])
+		assert.Equal(t, "H4sI", cm.Data[machineConfigJSONFilename])
+
+		script := cm.Data[buildContextScriptKey]
+		assert.Contains(t, script, "oc extract -n openshift-machine-config-operator configmap/worker-build-context --to=.")
+		assert.Contains(t, script, `--build-arg "HTTP_PROXY=http://proxy.example.com:3128"`)
+		assert.Contains(t, script, "oc extract -n openshift-machine-config-operator secret/etc-pki-entitlement --to=volumes/etc-pki-entitlement")
+		assert.Contains(t, script, `--volume "$PWD/volumes/etc-pki-entitlement:/etc/pki/entitlement:ro,Z"`)
+		assert.Contains(t, script, "--tag localhost/worker:rendered-worker-1")
+
+		// A later failure overwrites the exported build context.
+		ctrl.exportBuildContext(newPoolState(pool))
+	})
+}
+
+func TestGetExportBuildContext(t *testing.T) {
+	t.Parallel()
+
+	enabled, err := getExportBuildContext(nil)
+	assert.NoError(t, err)
+	assert.False(t, enabled)
+
+	cm := getOnClusterBuildConfigMap()
+	cm.Data[ExportBuildContextConfigKey] = "true"
+	enabled, err = getExportBuildContext(cm)
+	assert.NoError(t, err)
+	assert.True(t, enabled)
+
+	cm.Data[ExportBuildContextConfigKey] = "sometimes"
+	_, err = getExportBuildContext(cm)
+	assert.Error(t, err)
+}
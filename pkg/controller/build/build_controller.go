@@ -5,6 +5,7 @@ import (
 	"context"
 	"fmt"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/containers/image/v5/docker/reference"
@@ -15,6 +16,7 @@ import (
 	corev1 "k8s.io/api/core/v1"
 	aggerrors "k8s.io/apimachinery/pkg/util/errors"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/apimachinery/pkg/util/wait"
 	clientset "k8s.io/client-go/kubernetes"
 	coreclientsetv1 "k8s.io/client-go/kubernetes/typed/core/v1"
@@ -38,6 +40,7 @@ import (
 
 	coreinformers "k8s.io/client-go/informers"
 	coreinformersv1 "k8s.io/client-go/informers/core/v1"
+	corelistersv1 "k8s.io/client-go/listers/core/v1"
 
 	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -55,13 +58,15 @@ const (
 
 // on-cluster-build-custom-dockerfile ConfigMap name.
 const (
-	customDockerfileConfigMapName = "on-cluster-build-custom-dockerfile"
+	// Name of the ConfigMap which contains the custom Dockerfile for each
+	// MachineConfigPool, keyed by pool name.
+	CustomDockerfileConfigMapName = "on-cluster-build-custom-dockerfile"
 )
 
 // on-cluster-build-config ConfigMap keys.
 const (
 	// Name of ConfigMap which contains knobs for configuring the build controller.
-	OnClusterBuildConfigMapName = "on-cluster-build-config"
+	OnClusterBuildConfigMapName = ctrlcommon.OnClusterBuildConfigMapName
 
 	// The on-cluster-build-config ConfigMap key which contains a K8s secret capable of pulling of the base OS image.
 	BaseImagePullSecretNameConfigKey = "baseImagePullSecretName"
@@ -102,6 +107,24 @@ const (
 
 	// CustomPodImageBuilder is the constant indicating use of the custom pod image builder.
 	CustomPodImageBuilder string = "custom-pod-builder"
+
+	// RemoteImageBuilder is the constant indicating use of an external Podman
+	// API service, driven by an unprivileged build pod, to build the image.
+	RemoteImageBuilder string = "remote-builder"
+
+	// KanikoImageBuilder is the constant indicating use of Kaniko, which builds
+	// the image in an unprivileged build pod.
+	KanikoImageBuilder string = "kaniko"
+
+	// BuildKitImageBuilder is the constant indicating use of a rootless
+	// BuildKit daemon, which can reuse a build cache from a registry, to build
+	// the image.
+	BuildKitImageBuilder string = "buildkit"
+
+	// ExternalBuildServiceImageBuilder is the constant indicating use of an
+	// external build service, which an unprivileged build pod submits the
+	// build to, to build the image.
+	ExternalBuildServiceImageBuilder string = "external-build-service"
 )
 
 var (
@@ -144,17 +167,34 @@ type Controller struct {
 	syncHandler              func(mcp string) error
 	enqueueMachineConfigPool func(*mcfgv1.MachineConfigPool)
 
-	ccLister  mcfglistersv1.ControllerConfigLister
-	mcpLister mcfglistersv1.MachineConfigPoolLister
+	ccLister        mcfglistersv1.ControllerConfigLister
+	mcpLister       mcfglistersv1.MachineConfigPoolLister
+	configMapLister corelistersv1.ConfigMapLister
 
-	ccListerSynced  cache.InformerSynced
-	mcpListerSynced cache.InformerSynced
-	podListerSynced cache.InformerSynced
+	ccListerSynced        cache.InformerSynced
+	mcpListerSynced       cache.InformerSynced
+	podListerSynced       cache.InformerSynced
+	configMapListerSynced cache.InformerSynced
 
 	queue workqueue.RateLimitingInterface
 
-	config       BuildControllerConfig
-	imageBuilder ImageBuilder
+	config         BuildControllerConfig
+	imageBuilder   ImageBuilder
+	imagePruner    imagePruner
+	imageScanner   imageScanner
+	imageResolver  imageResolver
+	imageInspector imageInspector
+
+	pushCredentialsProviders map[string]pushCredentialsProvider
+
+	// Guards the admission of builds and the pools whose builds were
+	// admitted but not marked pending yet.
+	admissionLock  sync.Mutex
+	admittedBuilds sets.String
+
+	// Guards the pools whose built images are being scanned.
+	imageScanLock     sync.Mutex
+	runningImageScans sets.String
 }
 
 // Creates a BuildControllerConfig with sensible production defaults.
@@ -186,11 +226,13 @@ func NewClients(cb *clients.Builder) *Clients {
 
 // Holds and starts each of the infomrers used by the Build Controller and its subcontrollers.
 type informers struct {
-	ccInformer    mcfginformersv1.ControllerConfigInformer
-	mcpInformer   mcfginformersv1.MachineConfigPoolInformer
-	buildInformer buildinformersv1.BuildInformer
-	podInformer   coreinformersv1.PodInformer
-	toStart       []interface{ Start(<-chan struct{}) }
+	ccInformer        mcfginformersv1.ControllerConfigInformer
+	mcpInformer       mcfginformersv1.MachineConfigPoolInformer
+	buildInformer     buildinformersv1.BuildInformer
+	podInformer       coreinformersv1.PodInformer
+	secretInformer    coreinformersv1.SecretInformer
+	configMapInformer coreinformersv1.ConfigMapInformer
+	toStart           []interface{ Start(<-chan struct{}) }
 }
 
 // Starts the informers, wiring them up to the provided context.
@@ -208,10 +250,12 @@ func newInformers(bcc *Clients) *informers {
 	podInformer := coreinformers.NewSharedInformerFactoryWithOptions(bcc.kubeclient, 0, coreinformers.WithNamespace(ctrlcommon.MCONamespace))
 
 	return &informers{
-		ccInformer:    ccInformer.Machineconfiguration().V1().ControllerConfigs(),
-		mcpInformer:   mcpInformer.Machineconfiguration().V1().MachineConfigPools(),
-		buildInformer: buildInformer.Build().V1().Builds(),
-		podInformer:   podInformer.Core().V1().Pods(),
+		ccInformer:        ccInformer.Machineconfiguration().V1().ControllerConfigs(),
+		mcpInformer:       mcpInformer.Machineconfiguration().V1().MachineConfigPools(),
+		buildInformer:     buildInformer.Build().V1().Builds(),
+		podInformer:       podInformer.Core().V1().Pods(),
+		secretInformer:    podInformer.Core().V1().Secrets(),
+		configMapInformer: podInformer.Core().V1().ConfigMaps(),
 		toStart: []interface{ Start(<-chan struct{}) }{
 			ccInformer,
 			mcpInformer,
@@ -231,11 +275,17 @@ func newBuildController(
 	eventBroadcaster.StartRecordingToSink(&coreclientsetv1.EventSinkImpl{Interface: clients.kubeclient.CoreV1().Events("")})
 
 	ctrl := &Controller{
-		informers:     newInformers(clients),
-		Clients:       clients,
-		eventRecorder: eventBroadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: "machineosbuilder-buildcontroller"}),
-		queue:         workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "machineosbuilder-buildcontroller"),
-		config:        ctrlConfig,
+		informers:      newInformers(clients),
+		Clients:        clients,
+		eventRecorder:  eventBroadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: "machineosbuilder-buildcontroller"}),
+		queue:          workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "machineosbuilder-buildcontroller"),
+		config:         ctrlConfig,
+		imagePruner:    registryImagePruner{},
+		imageScanner:   webhookImageScanner{},
+		imageResolver:  registryImageResolver{},
+		imageInspector: registryImageResolver{},
+
+		pushCredentialsProviders: getPushCredentialsProviders(),
 	}
 
 	ctrl.mcpInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
@@ -244,14 +294,20 @@ func newBuildController(
 		DeleteFunc: ctrl.deleteMachineConfigPool,
 	})
 
+	ctrl.secretInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		UpdateFunc: ctrl.updateSecret,
+	})
+
 	ctrl.syncHandler = ctrl.syncMachineConfigPool
 	ctrl.enqueueMachineConfigPool = ctrl.enqueueDefault
 
 	ctrl.ccLister = ctrl.ccInformer.Lister()
 	ctrl.mcpLister = ctrl.mcpInformer.Lister()
+	ctrl.configMapLister = ctrl.configMapInformer.Lister()
 
 	ctrl.ccListerSynced = ctrl.ccInformer.Informer().HasSynced
 	ctrl.mcpListerSynced = ctrl.mcpInformer.Informer().HasSynced
+	ctrl.configMapListerSynced = ctrl.configMapInformer.Informer().HasSynced
 
 	return ctrl
 }
@@ -292,12 +348,14 @@ func (ctrl *Controller) Run(parentCtx context.Context, workers int) {
 
 	ctrl.informers.start(ctx)
 
-	if !cache.WaitForCacheSync(ctx.Done(), ctrl.mcpListerSynced, ctrl.ccListerSynced) {
+	if !cache.WaitForCacheSync(ctx.Done(), ctrl.mcpListerSynced, ctrl.ccListerSynced, ctrl.configMapListerSynced) {
 		return
 	}
 
 	go ctrl.imageBuilder.Run(ctx, workers)
 
+	go ctrl.runBuildGC(ctx)
+
 	for i := 0; i < workers; i++ {
 		go wait.Until(ctrl.worker, time.Second, ctx.Done())
 	}
@@ -371,6 +429,13 @@ func (ctrl *Controller) imageBuildUpdater(build *buildv1.Build) error {
 
 	klog.Infof("Build (%s) is %s", build.Name, build.Status.Phase)
 
+	// The build is cancelled when the pool opts out of layering, so there is
+	// nothing left to reconcile.
+	if !ctrlcommon.IsLayeredPool(pool) {
+		klog.V(4).Infof("MachineConfigPool %s is not opted-in for layering, ignoring build status", pool.Name)
+		return nil
+	}
+
 	objRef := toObjectRef(build)
 
 	ps := newPoolState(pool)
@@ -414,6 +479,13 @@ func (ctrl *Controller) customBuildPodUpdater(pod *corev1.Pod) error {
 
 	klog.Infof("Build pod (%s) is %s", pod.Name, pod.Status.Phase)
 
+	// The build is cancelled when the pool opts out of layering, so there is
+	// nothing left to reconcile.
+	if !ctrlcommon.IsLayeredPool(pool) {
+		klog.V(4).Infof("MachineConfigPool %s is not opted-in for layering, ignoring build status", pool.Name)
+		return nil
+	}
+
 	ps := newPoolState(pool)
 
 	switch pod.Status.Phase {
@@ -504,6 +576,41 @@ func (ctrl *Controller) syncMachineConfigPool(key string) error {
 		return nil
 	}
 
+	// A rebuild discards any failed build, so it is handled before the pool
+	// state is considered.
+	if isRebuildRequested(pool) {
+		return ctrl.rebuildMachineConfigPool(ps)
+	}
+
+	// A cancellation only affects the pending or running build, so it is
+	// handled after any rebuild which was requested before it.
+	if isBuildCancelRequested(pool) {
+		return ctrl.cancelBuildForMachineConfigPool(ps)
+	}
+
+	// A finished build whose image was being verified is completed once the
+	// verification has finished.
+	if ps.IsBuildPending() || ps.IsBuilding() {
+		status, err := ctrl.getBuildStatus(pool)
+		if err != nil {
+			return err
+		}
+
+		if status != nil && status.Phase == ctrlcommon.ImageBuildPhaseVerifying {
+			klog.V(4).Infof("MachineConfigPool %s has a build whose image is being verified", pool.Name)
+			return ctrl.markBuildSucceeded(ps)
+		}
+	}
+
+	// A build which used since-rotated credentials is restarted since it
+	// either failed or would fail because of them.
+	if ps.IsBuildPending() || ps.IsBuilding() || ps.IsBuildFailure() {
+		restarted, err := ctrl.restartBuildIfSecretsRotated(ps)
+		if err != nil || restarted {
+			return err
+		}
+	}
+
 	switch {
 	case ps.IsDegraded():
 		klog.V(4).Infof("MachineConfigPool %s is degraded, requeueing", pool.Name)
@@ -515,13 +622,16 @@ func (ctrl *Controller) syncMachineConfigPool(key string) error {
 		return nil
 	case ps.IsBuildPending():
 		klog.V(4).Infof("MachineConfigPool %s is build pending", pool.Name)
-		return nil
+		return ctrl.checkBuildTimeout(ps)
 	case ps.IsBuilding():
 		klog.V(4).Infof("MachineConfigPool %s is building", pool.Name)
-		return nil
+		return ctrl.checkBuildTimeout(ps)
 	case ps.IsBuildSuccess():
 		klog.V(4).Infof("MachineConfigPool %s has successfully built", pool.Name)
 		return nil
+	case ps.IsBuildFailure() && isBuildRetryPending(pool):
+		klog.V(4).Infof("MachineConfigPool %s has a failed build pending retry", pool.Name)
+		return ctrl.retryBuildForMachineConfigPool(ps)
 	default:
 		shouldBuild, err := shouldWeDoABuild(ctrl.imageBuilder, pool, pool)
 		if err != nil {
@@ -539,10 +649,89 @@ func (ctrl *Controller) syncMachineConfigPool(key string) error {
 	return ctrl.syncAvailableStatus(pool)
 }
 
-// Marks a given MachineConfigPool as a failed build.
+// Marks a given MachineConfigPool as a failed build. If the build retry
+// policy allows it, the failed build is cleaned up and a retry is scheduled
+// instead.
 func (ctrl *Controller) markBuildFailed(ps *poolState) error {
 	klog.Errorf("Build failed for pool %s", ps.Name())
 
+	// The log and build context must be captured before a retry cleans up the
+	// build pod and its inputs.
+	decisiveErrors := ctrl.captureBuildLogTail(ps)
+	ctrl.exportBuildContext(ps)
+
+	return ctrl.retryOrMarkBuildFailed(ps, "BuildFailed", decisiveErrors)
+}
+
+// Schedules a retry of the failed build for the given MachineConfigPool if
+// the build retry policy allows it. Otherwise, the pool is marked as a failed
+// build with the given reason and details.
+func (ctrl *Controller) retryOrMarkBuildFailed(ps *poolState, reason, details string) error {
+	retryCount := getBuildRetryCount(ps.MachineConfigPool())
+
+	policy, err := ctrl.getBuildRetryPolicy()
+	if err != nil {
+		klog.Errorf("Could not get build retry policy for pool %s, will not retry: %s", ps.Name(), err)
+	} else if policy.canRetry(retryCount) {
+		return ctrl.markBuildFailedWithRetry(ps, policy, retryCount)
+	}
+
+	msg := ""
+	if retryCount > 0 {
+		msg = fmt.Sprintf("Build failed after %d retries", retryCount)
+	}
+
+	if details != "" {
+		if msg == "" {
+			msg = details
+		} else {
+			msg = fmt.Sprintf("%s:\n%s", msg, details)
+		}
+	}
+
+	return ctrl.markBuildFailedWithReason(ps, reason, msg)
+}
+
+// Terminates a build which has exceeded its timeout and fails it like any
+// other build, so that it is retried if the build retry policy allows it.
+// Since the terminated build cannot be inspected, its inputs are cleaned up
+// even when it is not retried.
+func (ctrl *Controller) markBuildTimedOut(ps *poolState, timeout time.Duration) error {
+	klog.Errorf("Build for pool %s did not complete within %s, terminating", ps.Name(), timeout)
+
+	pool := ps.MachineConfigPool()
+
+	msg := fmt.Sprintf("Build did not complete within %s", timeout)
+	ctrl.eventRecorder.Eventf(pool, corev1.EventTypeWarning, buildTimedOutReason, msg)
+
+	// The log and build context must be captured before the build pod and its
+	// inputs are removed.
+	ctrl.captureBuildLogTail(ps)
+	ctrl.exportBuildContext(ps)
+
+	if err := ctrl.postBuildCleanup(pool, true); err != nil {
+		return fmt.Errorf("could not terminate timed out build for pool %s: %w", ps.Name(), err)
+	}
+
+	return ctrl.retryOrMarkBuildFailed(ps, buildTimedOutReason, msg)
+}
+
+// Marks a given MachineConfigPool as a failed build with the given reason
+// without starting a build because its build inputs are invalid. This is not
+// retried since the inputs have to be fixed first.
+func (ctrl *Controller) markBuildInvalid(ps *poolState, reason string, err error) error {
+	klog.Errorf("Not building pool %s: %s", ps.Name(), err)
+
+	ctrl.eventRecorder.Eventf(ps.MachineConfigPool(), corev1.EventTypeWarning, reason, err.Error())
+
+	return ctrl.markBuildFailedWithReason(ps, reason, err.Error())
+}
+
+// Marks a given MachineConfigPool as a failed build with the given reason and
+// message.
+func (ctrl *Controller) markBuildFailedWithReason(ps *poolState, reason, msg string) error {
+	ctrl.setBuildStatusFailed(ps.MachineConfigPool(), msg)
+
 	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
 		mcp, err := ctrl.mcfgclient.MachineconfigurationV1().MachineConfigPools().Get(context.TODO(), ps.Name(), metav1.GetOptions{})
 		if err != nil {
@@ -552,9 +741,10 @@ func (ctrl *Controller) markBuildFailed(ps *poolState) error {
 		ps := newPoolState(mcp)
 		ps.SetBuildConditions([]mcfgv1.MachineConfigPoolCondition{
 			{
-				Type:   mcfgv1.MachineConfigPoolBuildFailed,
-				Reason: "BuildFailed",
-				Status: corev1.ConditionTrue,
+				Type:    mcfgv1.MachineConfigPoolBuildFailed,
+				Reason:  reason,
+				Message: msg,
+				Status:  corev1.ConditionTrue,
 			},
 			{
 				Type:   mcfgv1.MachineConfigPoolBuildSuccess,
@@ -574,7 +764,135 @@ func (ctrl *Controller) markBuildFailed(ps *poolState) error {
 			},
 		})
 
-		return ctrl.syncFailingStatus(ps.MachineConfigPool(), fmt.Errorf("build failed"))
+		if msg == "" {
+			return ctrl.syncFailingStatus(ps.MachineConfigPool(), fmt.Errorf("build failed"))
+		}
+
+		return ctrl.syncFailingStatus(ps.MachineConfigPool(), fmt.Errorf("build failed: %s", msg))
+	})
+}
+
+// Marks a given MachineConfigPool as a failed build which will be retried.
+// The failed build object and its inputs are removed so that the retry can
+// recreate them, and the pool is requeued once the backoff has elapsed.
+func (ctrl *Controller) markBuildFailedWithRetry(ps *poolState, policy buildRetryPolicy, retryCount int) error {
+	attempt := retryCount + 1
+	delay := policy.backoffForAttempt(attempt)
+
+	klog.Infof("Build for pool %s will be retried in %s (retry %d of %d)", ps.Name(), delay, attempt, policy.maxRetries)
+
+	if err := ctrl.postBuildCleanup(ps.MachineConfigPool(), true); err != nil {
+		return fmt.Errorf("could not clean up failed build for pool %s: %w", ps.Name(), err)
+	}
+
+	ctrl.setBuildStatusFailed(ps.MachineConfigPool(), fmt.Sprintf("Build failed, retrying in %s (retry %d of %d)", delay, attempt, policy.maxRetries))
+
+	var pool *mcfgv1.MachineConfigPool
+
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		mcp, err := ctrl.mcfgclient.MachineconfigurationV1().MachineConfigPools().Get(context.TODO(), ps.Name(), metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+
+		ps := newPoolState(mcp)
+		ps.DeleteBuildRefForCurrentMachineConfig()
+		ps.SetBuildConditions([]mcfgv1.MachineConfigPoolCondition{
+			{
+				Type:    mcfgv1.MachineConfigPoolBuildFailed,
+				Reason:  buildFailedRetryingReason,
+				Message: fmt.Sprintf("Build failed, retrying in %s (retry %d of %d)", delay, attempt, policy.maxRetries),
+				Status:  corev1.ConditionTrue,
+			},
+			{
+				Type:   mcfgv1.MachineConfigPoolBuildSuccess,
+				Status: corev1.ConditionFalse,
+			},
+			{
+				Type:   mcfgv1.MachineConfigPoolBuilding,
+				Status: corev1.ConditionFalse,
+			},
+			{
+				Type:   mcfgv1.MachineConfigPoolBuildPending,
+				Status: corev1.ConditionFalse,
+			},
+		})
+
+		pool = ps.MachineConfigPool()
+		return ctrl.updatePoolAndSyncAvailableStatus(pool)
+	})
+
+	if err != nil {
+		return err
+	}
+
+	ctrl.eventRecorder.Eventf(pool, corev1.EventTypeWarning, buildFailedRetryingReason, "Build failed, retrying in %s (retry %d of %d)", delay, attempt, policy.maxRetries)
+	ctrl.enqueueAfter(pool, delay)
+	return nil
+}
+
+// Starts the next build attempt for a pool whose previous build failed, once
+// the backoff for that attempt has elapsed.
+func (ctrl *Controller) retryBuildForMachineConfigPool(ps *poolState) error {
+	pool := ps.MachineConfigPool()
+
+	policy, err := ctrl.getBuildRetryPolicy()
+	if err != nil {
+		return fmt.Errorf("could not get build retry policy for pool %s: %w", pool.Name, err)
+	}
+
+	retryCount := getBuildRetryCount(pool)
+
+	// The policy may have changed since the retry was scheduled.
+	if !policy.canRetry(retryCount) {
+		return ctrl.markBuildFailed(ps)
+	}
+
+	if delay := getBuildRetryDelay(pool, policy, time.Now()); delay > 0 {
+		klog.V(4).Infof("Build retry for pool %s not due for another %s", pool.Name, delay)
+		ctrl.enqueueAfter(pool, delay)
+		return nil
+	}
+
+	attempt := retryCount + 1
+	klog.Infof("Retrying build for pool %s (retry %d of %d)", pool.Name, attempt, policy.maxRetries)
+	ctrl.eventRecorder.Eventf(pool, corev1.EventTypeNormal, "BuildRetrying", "Retrying build (retry %d of %d)", attempt, policy.maxRetries)
+
+	return ctrl.startBuild(ps, attempt)
+}
+
+// Gets the build retry policy from the on-cluster-build-config ConfigMap.
+func (ctrl *Controller) getBuildRetryPolicy() (buildRetryPolicy, error) {
+	cm, err := ctrl.configMapLister.ConfigMaps(ctrlcommon.MCONamespace).Get(OnClusterBuildConfigMapName)
+	if err != nil && !k8serrors.IsNotFound(err) {
+		return buildRetryPolicy{}, fmt.Errorf("could not get build controller config %q: %w", OnClusterBuildConfigMapName, err)
+	}
+
+	if k8serrors.IsNotFound(err) {
+		cm = nil
+	}
+
+	return getBuildRetryPolicy(cm)
+}
+
+// Records the number of times the build for the current rendered
+// MachineConfig has been retried on the MachineConfigPool.
+func (ctrl *Controller) setBuildRetryCount(ps *poolState, count int) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		mcp, err := ctrl.mcfgclient.MachineconfigurationV1().MachineConfigPools().Get(context.TODO(), ps.Name(), metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+
+		if getBuildRetryCount(mcp) == count {
+			return nil
+		}
+
+		ps := newPoolState(mcp)
+		ps.SetBuildRetryCount(count)
+
+		_, err = ctrl.mcfgclient.MachineconfigurationV1().MachineConfigPools().Update(context.TODO(), ps.pool, metav1.UpdateOptions{})
+		return err
 	})
 }
 
@@ -582,6 +900,8 @@ func (ctrl *Controller) markBuildFailed(ps *poolState) error {
 func (ctrl *Controller) markBuildInProgress(ps *poolState) error {
 	klog.Infof("Build in progress for MachineConfigPool %s, config %s", ps.Name(), ps.CurrentMachineConfig())
 
+	ctrl.setBuildStatusRunning(ps.MachineConfigPool())
+
 	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
 		mcp, err := ctrl.mcfgclient.MachineconfigurationV1().MachineConfigPools().Get(context.TODO(), ps.Name(), metav1.GetOptions{})
 		if err != nil {
@@ -671,7 +991,10 @@ func (ctrl *Controller) postBuildCleanup(pool *mcfgv1.MachineConfigPool, ignoreM
 	)
 }
 
-// Marks a given MachineConfigPool as build successful and cleans up after itself.
+// Marks a given MachineConfigPool as build successful and cleans up after
+// itself once the built image passed its verification. While the image is
+// being scanned, the pool stays building and is synced again once the scan
+// has finished.
 func (ctrl *Controller) markBuildSucceeded(ps *poolState) error {
 	klog.Infof("Build succeeded for MachineConfigPool %s, config %s", ps.Name(), ps.CurrentMachineConfig())
 
@@ -687,13 +1010,74 @@ func (ctrl *Controller) markBuildSucceeded(ps *poolState) error {
 		return fmt.Errorf("image pullspec empty for pool %s", ps.Name())
 	}
 
+	// Nodes are only ever rolled out to the image by digest, so that a tag
+	// which is moved after the push cannot change what they run.
+	if err := ctrlcommon.ValidateDigestedPullspec(imagePullspec); err != nil {
+		return fmt.Errorf("could not get digested image pullspec for pool %s: %w", ps.Name(), err)
+	}
+
+	// If the image was signed, record where its signature is. The node
+	// controller hands the public key from the build status to the MCD, which
+	// verifies the signature before applying the image.
+	signature, err := ctrl.getImageSignature(pool, imagePullspec)
+	if err != nil {
+		return fmt.Errorf("could not get image signature for pool %s: %w", ps.Name(), err)
+	}
+
+	ctrl.setBuildStatusVerifying(pool, imagePullspec, signature)
+
+	// Record where the image was copied to, if anywhere, before the digest
+	// ConfigMap listing the copies is cleaned up.
+	additionalPullspecs, err := ctrl.getAdditionalFinalPullspecs(pool)
+	if err != nil {
+		return fmt.Errorf("could not get additional image pullspecs for pool %s: %w", ps.Name(), err)
+	}
+
+	// Get the lint findings for the image before the digest ConfigMap holding
+	// them is cleaned up. An image with lint errors is never rolled out when
+	// the lint is enforced.
+	lintCondition, err := ctrl.lintImage(pool, imagePullspec)
+	if err != nil {
+		return fmt.Errorf("could not lint image for pool %s: %w", ps.Name(), err)
+	}
+
+	if lintCondition != nil && lintCondition.Status == corev1.ConditionFalse {
+		if err := ctrl.postBuildCleanup(pool, false); err != nil {
+			return fmt.Errorf("could not do post-build cleanup: %w", err)
+		}
+
+		return ctrl.markImageRolloutBlocked(ps, *lintCondition)
+	}
+
+	// Scan the image before it is rolled out. A failed scan is retried, but an
+	// image with findings over the threshold is never rolled out.
+	scanCondition, scanned, err := ctrl.getImageScanCondition(pool, imagePullspec)
+	if err != nil {
+		return fmt.Errorf("could not scan image for pool %s: %w", ps.Name(), err)
+	}
+
+	if !scanned {
+		return ctrl.markImageScanPending(ps, imagePullspec)
+	}
+
+	if scanCondition != nil && scanCondition.Status == corev1.ConditionFalse {
+		if err := ctrl.postBuildCleanup(pool, false); err != nil {
+			return fmt.Errorf("could not do post-build cleanup: %w", err)
+		}
+
+		return ctrl.markImageScanFailed(ps, *scanCondition)
+	}
+
+	// Record how large the image is once its layers were squashed.
+	squashedSize := ctrl.getSquashedImageSize(pool, imagePullspec)
+
 	// Perform the post-build cleanup.
 	if err := ctrl.postBuildCleanup(pool, false); err != nil {
 		return fmt.Errorf("could not do post-build cleanup: %w", err)
 	}
 
 	// Perform the MachineConfigPool update.
-	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+	err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
 		mcp, err := ctrl.mcfgclient.MachineconfigurationV1().MachineConfigPools().Get(context.TODO(), ps.Name(), metav1.GetOptions{})
 		if err != nil {
 			return err
@@ -704,11 +1088,32 @@ func (ctrl *Controller) markBuildSucceeded(ps *poolState) error {
 		// Set the annotation or field to point to the newly-built container image.
 		klog.V(4).Infof("Setting new image pullspec for %s to %s", ps.Name(), imagePullspec)
 		ps.SetImagePullspec(imagePullspec)
+		ps.SetAdditionalImagePullspecs(additionalPullspecs)
+		ps.SetImageSize(squashedSize)
 
 		// Remove the build object reference from the MachineConfigPool since we're
 		// not using it anymore.
 		ps.DeleteBuildRefForCurrentMachineConfig()
 
+		// Reset the retry count for the next rendered MachineConfig.
+		ps.SetBuildRetryCount(0)
+
+		successReason := "BuildSucceeded"
+		successMessage := ""
+		if ctrlcommon.NewLayeredPoolState(mcp).IsValidateOnly() {
+			successReason = "BuildValidated"
+			successMessage = fmt.Sprintf("Image %s was built in validate-only mode and will not be rolled out", imagePullspec)
+		}
+
+		if squashedSize != nil {
+			squashedMessage := fmt.Sprintf("Squashed image %s has %s", imagePullspec, squashedSize)
+			if successMessage == "" {
+				successMessage = squashedMessage
+			} else {
+				successMessage = fmt.Sprintf("%s; %s", successMessage, squashedMessage)
+			}
+		}
+
 		// Adjust the MachineConfigPool status to indicate success.
 		ps.SetBuildConditions([]mcfgv1.MachineConfigPoolCondition{
 			{
@@ -716,9 +1121,10 @@ func (ctrl *Controller) markBuildSucceeded(ps *poolState) error {
 				Status: corev1.ConditionFalse,
 			},
 			{
-				Type:   mcfgv1.MachineConfigPoolBuildSuccess,
-				Reason: "BuildSucceeded",
-				Status: corev1.ConditionTrue,
+				Type:    mcfgv1.MachineConfigPoolBuildSuccess,
+				Reason:  successReason,
+				Message: successMessage,
+				Status:  corev1.ConditionTrue,
 			},
 			{
 				Type:   mcfgv1.MachineConfigPoolBuilding,
@@ -730,8 +1136,37 @@ func (ctrl *Controller) markBuildSucceeded(ps *poolState) error {
 			},
 		})
 
+		if scanCondition != nil {
+			ps.SetBuildConditions([]mcfgv1.MachineConfigPoolCondition{*scanCondition})
+		} else {
+			ps.RemoveBuildCondition(MachineConfigPoolImageScanPassed)
+		}
+
+		if lintCondition != nil {
+			ps.SetBuildConditions([]mcfgv1.MachineConfigPoolCondition{*lintCondition})
+		} else {
+			ps.RemoveBuildCondition(MachineConfigPoolImageLintPassed)
+		}
+
 		return ctrl.updatePoolAndSyncAvailableStatus(ps.MachineConfigPool())
 	})
+
+	if err != nil {
+		return err
+	}
+
+	ctrl.setBuildStatusSucceeded(pool, imagePullspec)
+
+	if ctrlcommon.NewLayeredPoolState(pool).IsValidateOnly() {
+		ctrl.eventRecorder.Eventf(pool, corev1.EventTypeNormal, "BuildValidated", "Image %s was built in validate-only mode and will not be rolled out", imagePullspec)
+	}
+
+	// The build succeeded regardless of whether its history could be recorded.
+	if err := ctrl.recordBuildHistory(pool, imagePullspec); err != nil {
+		klog.Errorf("Could not record build history for pool %s: %v", ps.Name(), err)
+	}
+
+	return nil
 }
 
 // Marks a given MachineConfigPool as build pending.
@@ -778,6 +1213,9 @@ func (ctrl *Controller) markBuildPendingWithObjectRef(ps *poolState, objRef core
 			return err
 		}
 
+		// The log of any previous failed build no longer applies.
+		ps.SetBuildLogTail("")
+
 		return ctrl.updatePoolAndSyncAvailableStatus(ps.MachineConfigPool())
 	})
 }
@@ -809,14 +1247,68 @@ func (ctrl *Controller) getBuildInputs(ps *poolState) (*buildInputs, error) {
 		return nil, fmt.Errorf("could not get OS image URL: %w", err)
 	}
 
+	// This must happen before the push secret is validated below. Since the
+	// build inputs are only fetched once the build is admitted, a build which
+	// was queued does not start with credentials minted when it was queued.
+	if err := ctrl.refreshPushCredentials(); err != nil {
+		return nil, fmt.Errorf("could not refresh final image push credentials: %w", err)
+	}
+
 	onClusterBuildConfig, err := ctrl.getOnClusterBuildConfig(ps)
 	if err != nil {
 		return nil, fmt.Errorf("could not get configmap %q: %w", OnClusterBuildConfigMapName, err)
 	}
 
-	customDockerfiles, err := ctrl.kubeclient.CoreV1().ConfigMaps(ctrlcommon.MCONamespace).Get(context.TODO(), customDockerfileConfigMapName, metav1.GetOptions{})
+	customDockerfiles, err := ctrl.kubeclient.CoreV1().ConfigMaps(ctrlcommon.MCONamespace).Get(context.TODO(), CustomDockerfileConfigMapName, metav1.GetOptions{})
 	if err != nil && !k8serrors.IsNotFound(err) {
-		return nil, fmt.Errorf("could not retrieve %s ConfigMap: %w", customDockerfileConfigMapName, err)
+		return nil, fmt.Errorf("could not retrieve %s ConfigMap: %w", CustomDockerfileConfigMapName, err)
+	}
+
+	buildVolumes, err := ctrl.getBuildVolumes(onClusterBuildConfig)
+	if err != nil {
+		return nil, fmt.Errorf("could not get build volumes: %w", err)
+	}
+
+	buildArgs, err := getBuildArgs(onClusterBuildConfig)
+	if err != nil {
+		return nil, fmt.Errorf("could not get build args: %w", err)
+	}
+
+	buildResources, err := getBuildResources(onClusterBuildConfig)
+	if err != nil {
+		return nil, fmt.Errorf("could not get build resources: %w", err)
+	}
+
+	buildScheduling, err := getBuildScheduling(onClusterBuildConfig)
+	if err != nil {
+		return nil, fmt.Errorf("could not get build scheduling constraints: %w", err)
+	}
+
+	// The OpenShift Image Builder is the default image builder.
+	imageBuilderType := onClusterBuildConfig.Data[ImageBuilderTypeConfigMapKey]
+	usesOpenshiftImageBuilder := imageBuilderType == "" || imageBuilderType == OpenshiftImageBuilder
+	if usesOpenshiftImageBuilder && buildScheduling.hasPodOnlyConstraints() {
+		klog.Warningf("%s and %s are not supported by the %s; configure them in the cluster-wide build overrides instead", BuildTolerationsConfigKey, BuildAffinityConfigKey, OpenshiftImageBuilder)
+	}
+
+	buildProxy, err := ctrl.getBuildProxy()
+	if err != nil {
+		return nil, fmt.Errorf("could not get build proxy: %w", err)
+	}
+
+	remoteBuilder, err := getRemoteBuilder(onClusterBuildConfig)
+	if err != nil {
+		return nil, fmt.Errorf("could not get remote builder: %w", err)
+	}
+
+	buildKitBuilder, err := getBuildKitBuilder(onClusterBuildConfig)
+	if err != nil {
+		return nil, fmt.Errorf("could not get BuildKit builder: %w", err)
+	}
+
+	externalBuildService, err := getExternalBuildService(onClusterBuildConfig)
+	if err != nil {
+		return nil, fmt.Errorf("could not get external build service: %w", err)
 	}
 
 	currentMC := ps.CurrentMachineConfig()
@@ -826,12 +1318,76 @@ func (ctrl *Controller) getBuildInputs(ps *poolState) (*buildInputs, error) {
 		return nil, fmt.Errorf("could not get MachineConfig %s: %w", currentMC, err)
 	}
 
+	imageSigningPublicKey, err := getImageSigningConfig(ctrl.kubeclient, onClusterBuildConfig)
+	if err != nil {
+		return nil, fmt.Errorf("could not validate image signing config: %w", err)
+	}
+
+	if err := validateImageLintConfig(onClusterBuildConfig); err != nil {
+		return nil, fmt.Errorf("could not validate image lint config: %w", err)
+	}
+
+	if err := validateAdditionalFinalImagesConfig(ctrl.kubeclient, onClusterBuildConfig); err != nil {
+		return nil, fmt.Errorf("could not validate additional final images config: %w", err)
+	}
+
+	additionalFinalImages, err := getAdditionalFinalImages(onClusterBuildConfig)
+	if err != nil {
+		return nil, fmt.Errorf("could not get additional final images: %w", err)
+	}
+
+	if err := validateRPMRepositoriesConfig(ctrl.kubeclient, onClusterBuildConfig); err != nil {
+		return nil, fmt.Errorf("could not validate RPM repositories config: %w", err)
+	}
+
+	if err := validateImageTagTemplateConfig(onClusterBuildConfig); err != nil {
+		return nil, fmt.Errorf("could not validate image tag template config: %w", err)
+	}
+
+	if err := validateInsecureRegistryConfig(onClusterBuildConfig); err != nil {
+		return nil, fmt.Errorf("could not validate insecure registry config: %w", err)
+	}
+
+	insecureRegistry, err := getInsecureRegistry(onClusterBuildConfig)
+	if err != nil {
+		return nil, fmt.Errorf("could not get insecure registry config: %w", err)
+	}
+
+	registriesConfig, err := getBuildRegistriesConfig(mc)
+	if err != nil {
+		return nil, fmt.Errorf("could not get registries config: %w", err)
+	}
+
+	registryCAs, err := ctrl.getBuildRegistryCAs()
+	if err != nil {
+		return nil, fmt.Errorf("could not get registry CAs: %w", err)
+	}
+
+	secretVersions, err := ctrl.getBuildSecretVersions(onClusterBuildConfig)
+	if err != nil {
+		return nil, fmt.Errorf("could not get build secret versions: %w", err)
+	}
+
 	inputs := &buildInputs{
-		onClusterBuildConfig: onClusterBuildConfig,
-		osImageURL:           osImageURL,
-		customDockerfiles:    customDockerfiles,
-		pool:                 ps.MachineConfigPool(),
-		machineConfig:        mc,
+		onClusterBuildConfig:  onClusterBuildConfig,
+		osImageURL:            osImageURL,
+		customDockerfiles:     customDockerfiles,
+		buildVolumes:          buildVolumes,
+		buildArgs:             buildArgs,
+		buildResources:        buildResources,
+		buildScheduling:       buildScheduling,
+		buildProxy:            buildProxy,
+		remoteBuilder:         remoteBuilder,
+		buildKitBuilder:       buildKitBuilder,
+		externalBuildService:  externalBuildService,
+		additionalFinalImages: additionalFinalImages,
+		insecureRegistry:      insecureRegistry,
+		registriesConfig:      registriesConfig,
+		registryCAs:           registryCAs,
+		secretVersions:        secretVersions,
+		imageSigningPublicKey: imageSigningPublicKey,
+		pool:                  ps.MachineConfigPool(),
+		machineConfig:         mc,
 	}
 
 	return inputs, nil
@@ -872,11 +1428,101 @@ func (ctrl *Controller) prepareForBuild(inputs *buildInputs) (ImageBuildRequest,
 // build, and updates the MachineConfigPool with an object reference for the
 // build pod.
 func (ctrl *Controller) startBuildForMachineConfigPool(ps *poolState) error {
+	return ctrl.startBuild(ps, 0)
+}
+
+// Starts a build for the given MachineConfigPool, recording how many times
+// the build has been retried.
+func (ctrl *Controller) startBuild(ps *poolState, retryCount int) error {
+	admitted, err := ctrl.admitBuild(ps)
+	if err != nil {
+		return fmt.Errorf("could not determine if build for MachineConfigPool %s may start: %w", ps.Name(), err)
+	}
+
+	if !admitted {
+		return nil
+	}
+
+	defer ctrl.releaseBuildAdmission(ps)
+
+	if getBuildRetryCount(ps.MachineConfigPool()) != retryCount {
+		if err := ctrl.setBuildRetryCount(ps, retryCount); err != nil {
+			return fmt.Errorf("could not set build retry count for MachineConfigPool %s: %w", ps.Name(), err)
+		}
+	}
+
 	inputs, err := ctrl.getBuildInputs(ps)
 	if err != nil {
 		return fmt.Errorf("could not fetch build inputs: %w", err)
 	}
 
+	inputs.retryCount = retryCount
+
+	// Reject an invalid custom Containerfile before any build objects are created.
+	if err := validatePoolCustomDockerfile(inputs.customDockerfiles, ps.Name()); err != nil {
+		return ctrl.markBuildInvalid(ps, invalidContainerfileReason, err)
+	}
+
+	// Reject a rendered MachineConfig which cannot be applied through the image
+	// when image-only config updates are enabled.
+	if err := validateImageOnlyConfig(inputs); err != nil {
+		return ctrl.markBuildInvalid(ps, perNodeConfigReason, err)
+	}
+
+	// Extensions are installed from the extensions image, so it must be known.
+	if err := validateExtensionsConfig(inputs); err != nil {
+		return ctrl.markBuildInvalid(ps, missingExtensionsImageReason, err)
+	}
+
+	// Kaniko cannot build everything that Buildah can.
+	if err := validateKanikoBuildInputs(inputs); err != nil {
+		return ctrl.markBuildInvalid(ps, unsupportedByImageBuilderReason, err)
+	}
+
+	// Not every image builder can squash layers.
+	if err := validateSquashLayersBuildInputs(inputs); err != nil {
+		return ctrl.markBuildInvalid(ps, unsupportedByImageBuilderReason, err)
+	}
+
+	// The configs stage may be based on the image of another pool, which must
+	// have been built first.
+	if inputs.configsBaseImage, err = ctrl.getConfigsBaseImage(inputs.pool); err != nil {
+		return ctrl.markBuildInvalid(ps, baseImageSourceUnavailableReason, err)
+	}
+
+	// A base image which cannot be pulled through the cluster's image mirrors
+	// would only fail the build pod with an image pull error.
+	if err := ctrl.validateBaseImageResolvable(inputs); err != nil {
+		return ctrl.markBuildInvalid(ps, unresolvableBaseImageReason, err)
+	}
+
+	// The final image may be tagged from the tag template, in which case the
+	// tag must not move away from an image built for another config.
+	tag, err := ctrl.getFinalImageTag(inputs)
+	if err != nil {
+		return ctrl.markBuildInvalid(ps, invalidImageTagReason, err)
+	}
+
+	if tag != "" {
+		if err := setFinalImageTag(inputs, tag); err != nil {
+			return ctrl.markBuildInvalid(ps, invalidImageTagReason, err)
+		}
+	}
+
+	if err := ctrl.setBuildImageTag(ps, tag); err != nil {
+		return err
+	}
+
+	// This allows a build which used since-rotated credentials to be detected.
+	if err := ctrl.setBuildSecretVersions(ps, inputs.secretVersions); err != nil {
+		return fmt.Errorf("could not record build secret versions for MachineConfigPool %s: %w", ps.Name(), err)
+	}
+
+	// The push credentials must not expire while the build is starting up.
+	if err := ctrl.ensurePushCredentialsLifetime(); err != nil {
+		return fmt.Errorf("could not refresh final image push credentials: %w", err)
+	}
+
 	ibr, err := ctrl.prepareForBuild(inputs)
 	if err != nil {
 		return fmt.Errorf("could not start build for MachineConfigPool %s: %w", ps.Name(), err)
@@ -888,6 +1534,8 @@ func (ctrl *Controller) startBuildForMachineConfigPool(ps *poolState) error {
 		return err
 	}
 
+	ctrl.setBuildStatusStarted(inputs, *objRef)
+
 	return ctrl.markBuildPendingWithObjectRef(ps, *objRef)
 }
 
@@ -1032,26 +1680,49 @@ func (ctrl *Controller) handleCanonicalizedPullSecret(secret *corev1.Secret) (*c
 	return out, nil
 }
 
-// If one wants to opt out, this removes all of the statuses and object
-// references from a given MachineConfigPool.
+// If one wants to opt out, this cancels any in-progress build, cleans up its
+// artifacts, and removes all of the statuses and object references from a
+// given MachineConfigPool. Once the image pullspec is removed, the node
+// controller reverts the pool's nodes to the non-layered OS image.
 func (ctrl *Controller) finalizeOptOut(ps *poolState) error {
-	if err := ctrl.postBuildCleanup(ps.MachineConfigPool(), true); err != nil {
+	pool := ps.MachineConfigPool()
+
+	if ps.IsBuildPending() || ps.IsBuilding() {
+		klog.Infof("MachineConfigPool %s opted out of layering while building, cancelling build %s", ps.Name(), newImageBuildRequest(pool).getBuildName())
+	}
+
+	if err := ctrl.postBuildCleanup(pool, true); err != nil {
 		return err
 	}
 
-	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
-		mcp, err := ctrl.mcfgclient.MachineconfigurationV1().MachineConfigPools().Get(context.TODO(), ps.MachineConfigPool().Name, metav1.GetOptions{})
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		mcp, err := ctrl.mcfgclient.MachineconfigurationV1().MachineConfigPools().Get(context.TODO(), ps.Name(), metav1.GetOptions{})
 		if err != nil {
 			return err
 		}
 
 		ps := newPoolState(mcp)
-		ps.DeleteBuildRefForCurrentMachineConfig()
+
+		ps.ClearBuildFailure()
+
+		// Builds for previous MachineConfigs are no longer relevant either.
+		ps.DeleteAllBuildRefs()
 		ps.ClearImagePullspec()
 		ps.ClearAllBuildConditions()
+		ps.SetBuildRetryCount(0)
+		ps.SetBuildQueuedTime(time.Time{})
+		ps.SetBuildLogTail("")
 
 		return ctrl.updatePoolAndSyncAvailableStatus(ps.MachineConfigPool())
 	})
+
+	if err != nil {
+		return err
+	}
+
+	ctrl.eventRecorder.Eventf(pool, corev1.EventTypeNormal, "OptedOut", "MachineConfigPool %s has opted out of layering", ps.Name())
+
+	return nil
 }
 
 // Fires whenever a MachineConfigPool is updated.
@@ -1061,6 +1732,19 @@ func (ctrl *Controller) updateMachineConfigPool(old, cur interface{}) {
 
 	klog.V(4).Infof("Updating MachineConfigPool %s", oldPool.Name)
 
+	// A finished build frees up a slot for a queued build.
+	if hasActiveBuild(oldPool) && !hasActiveBuild(curPool) {
+		ctrl.enqueueQueuedBuilds()
+	}
+
+	// Pools whose builds are based on this pool's image are rebuilt on its
+	// new image.
+	if ctrlcommon.NewLayeredPoolState(oldPool).GetOSImage() != ctrlcommon.NewLayeredPoolState(curPool).GetOSImage() {
+		if err := ctrl.rebuildDependentPools(curPool); err != nil {
+			klog.Errorln(err)
+		}
+	}
+
 	doABuild, err := shouldWeDoABuild(ctrl.imageBuilder, oldPool, curPool)
 	if err != nil {
 		klog.Errorln(err)
@@ -1110,6 +1794,10 @@ func (ctrl *Controller) deleteMachineConfigPool(obj interface{}) {
 		}
 	}
 	klog.V(4).Infof("Deleting MachineConfigPool %s", pool.Name)
+
+	if hasActiveBuild(pool) {
+		ctrl.enqueueQueuedBuilds()
+	}
 }
 
 func (ctrl *Controller) syncAvailableStatus(pool *mcfgv1.MachineConfigPool) error {
@@ -6,6 +6,7 @@ import (
 	"os"
 	"reflect"
 	"strings"
+	"time"
 
 	ign3types "github.com/coreos/ignition/v2/config/v3_4/types"
 	buildv1 "github.com/openshift/api/build/v1"
@@ -96,6 +97,12 @@ func TestBuildControllerSingleOptedInPool(t *testing.T) {
 				mcp := optInMCP(ctx, t, cs, pool)
 				assertMCPFollowsBuildPodStatus(ctx, t, cs, mcp, corev1.PodFailed)
 				assertMachineConfigPoolReachesStateWithMsg(ctx, t, cs, pool, isMCPBuildFailure, isMCPBuildFailureMsg)
+				// The fake clientset returns "fake logs" for every pod.
+				assertMachineConfigPoolReachesState(ctx, t, cs, pool, func(mcp *mcfgv1.MachineConfigPool) bool {
+					cond := apihelpers.GetMachineConfigPoolCondition(mcp.Status, mcfgv1.MachineConfigPoolBuildFailed)
+					return mcp.Annotations[BuildLogTailAnnotationKey] == "fake logs" &&
+						cond != nil && cond.Message == "fake logs"
+				})
 			},
 		})
 	})
@@ -109,6 +116,70 @@ func TestBuildControllerSingleOptedInPool(t *testing.T) {
 		})
 	})
 
+	t.Run("Build Timeout", func(t *testing.T) {
+		t.Parallel()
+
+		newBuildControllerTestFixture(t).runTestFuncs(t, testFuncs{
+			imageBuilder:     testBuildTimesOut,
+			customPodBuilder: testBuildTimesOut,
+		})
+	})
+
+	t.Run("Build Timeout Retried", func(t *testing.T) {
+		t.Parallel()
+
+		newBuildControllerTestFixture(t).runTestFuncs(t, testFuncs{
+			imageBuilder:     testBuildTimeoutIsRetried,
+			customPodBuilder: testBuildTimeoutIsRetried,
+		})
+	})
+
+	t.Run("Rebuild After Build Failure", func(t *testing.T) {
+		t.Parallel()
+
+		newBuildControllerTestFixture(t).runTestFuncs(t, testFuncs{
+			imageBuilder: func(ctx context.Context, t *testing.T, cs *Clients) {
+				mcp := optInMCP(ctx, t, cs, pool)
+				assertMCPFollowsImageBuildStatus(ctx, t, cs, mcp, buildv1.BuildPhaseFailed)
+				assertMachineConfigPoolReachesStateWithMsg(ctx, t, cs, pool, isMCPBuildFailure, isMCPBuildFailureMsg)
+				testRebuildRequested(ctx, t, cs, pool)
+			},
+			customPodBuilder: func(ctx context.Context, t *testing.T, cs *Clients) {
+				mcp := optInMCP(ctx, t, cs, pool)
+				assertMCPFollowsBuildPodStatus(ctx, t, cs, mcp, corev1.PodFailed)
+				assertMachineConfigPoolReachesStateWithMsg(ctx, t, cs, pool, isMCPBuildFailure, isMCPBuildFailureMsg)
+				testRebuildRequested(ctx, t, cs, pool)
+			},
+		})
+	})
+
+	t.Run("Build Cancelled", func(t *testing.T) {
+		t.Parallel()
+
+		newBuildControllerTestFixture(t).runTestFuncs(t, testFuncs{
+			imageBuilder:     testBuildCancelled,
+			customPodBuilder: testBuildCancelled,
+		})
+	})
+
+	t.Run("Build Secret Rotated", func(t *testing.T) {
+		t.Parallel()
+
+		newBuildControllerTestFixture(t).runTestFuncs(t, testFuncs{
+			imageBuilder:     testBuildSecretRotated,
+			customPodBuilder: testBuildSecretRotated,
+		})
+	})
+
+	t.Run("Invalid Containerfile", func(t *testing.T) {
+		t.Parallel()
+
+		newBuildControllerTestFixture(t).runTestFuncs(t, testFuncs{
+			imageBuilder:     testInvalidContainerfile,
+			customPodBuilder: testInvalidContainerfile,
+		})
+	})
+
 	t.Run("Opted-in pool opts out", func(t *testing.T) {
 		t.Parallel()
 
@@ -122,6 +193,42 @@ func TestBuildControllerSingleOptedInPool(t *testing.T) {
 		})
 	})
 
+	t.Run("Opted-in pool opts out mid-build", func(t *testing.T) {
+		t.Parallel()
+
+		testFunc := func(ctx context.Context, t *testing.T, cs *Clients) {
+			optInMCP(ctx, t, cs, pool)
+			assertMachineConfigPoolReachesState(ctx, t, cs, pool, func(mcp *mcfgv1.MachineConfigPool) bool {
+				return newPoolState(mcp).IsBuildPending()
+			})
+			testOptedInMCPOptsOutMidBuild(ctx, t, cs)
+		}
+
+		newBuildControllerTestFixture(t).runTestFuncs(t, testFuncs{
+			imageBuilder:     testFunc,
+			customPodBuilder: testFunc,
+		})
+	})
+
+	t.Run("Opted-in pool opts out after build failure", func(t *testing.T) {
+		t.Parallel()
+
+		newBuildControllerTestFixture(t).runTestFuncs(t, testFuncs{
+			imageBuilder: func(ctx context.Context, t *testing.T, cs *Clients) {
+				mcp := optInMCP(ctx, t, cs, pool)
+				assertMCPFollowsImageBuildStatus(ctx, t, cs, mcp, buildv1.BuildPhaseFailed)
+				assertMachineConfigPoolReachesStateWithMsg(ctx, t, cs, pool, isMCPBuildFailure, isMCPBuildFailureMsg)
+				testOptedInMCPOptsOutMidBuild(ctx, t, cs)
+			},
+			customPodBuilder: func(ctx context.Context, t *testing.T, cs *Clients) {
+				mcp := optInMCP(ctx, t, cs, pool)
+				assertMCPFollowsBuildPodStatus(ctx, t, cs, mcp, corev1.PodFailed)
+				assertMachineConfigPoolReachesStateWithMsg(ctx, t, cs, pool, isMCPBuildFailure, isMCPBuildFailureMsg)
+				testOptedInMCPOptsOutMidBuild(ctx, t, cs)
+			},
+		})
+	})
+
 	t.Run("Built pool gets unrelated update", func(t *testing.T) {
 		t.Parallel()
 
@@ -134,6 +241,43 @@ func TestBuildControllerSingleOptedInPool(t *testing.T) {
 			},
 		})
 	})
+
+	t.Run("Validate-only Build", func(t *testing.T) {
+		t.Parallel()
+
+		newBuildControllerTestFixture(t).runTestFuncs(t, testFuncs{
+			imageBuilder: func(ctx context.Context, t *testing.T, cs *Clients) {
+				testValidateOnlyBuild(ctx, t, cs, pool, testOptInMCPImageBuilder)
+			},
+			customPodBuilder: func(ctx context.Context, t *testing.T, cs *Clients) {
+				testValidateOnlyBuild(ctx, t, cs, pool, testOptInMCPCustomBuildPod)
+			},
+		})
+	})
+}
+
+// Puts the given MachineConfigPool into validate-only mode, opts it into
+// layering and asserts that the build success is marked as a validation.
+func testValidateOnlyBuild(ctx context.Context, t *testing.T, cs *Clients, poolName string, optInFunc func(context.Context, *testing.T, *Clients, string)) {
+	mcp, err := cs.mcfgclient.MachineconfigurationV1().MachineConfigPools().Get(ctx, poolName, metav1.GetOptions{})
+	require.NoError(t, err)
+
+	if mcp.Annotations == nil {
+		mcp.Annotations = map[string]string{}
+	}
+	mcp.Annotations[ctrlcommon.BuildValidateOnlyAnnotationKey] = "true"
+
+	_, err = cs.mcfgclient.MachineconfigurationV1().MachineConfigPools().Update(ctx, mcp, metav1.UpdateOptions{})
+	require.NoError(t, err)
+
+	optInFunc(ctx, t, cs, poolName)
+
+	mcp, err = cs.mcfgclient.MachineconfigurationV1().MachineConfigPools().Get(ctx, poolName, metav1.GetOptions{})
+	require.NoError(t, err)
+
+	cond := apihelpers.GetMachineConfigPoolCondition(mcp.Status, mcfgv1.MachineConfigPoolBuildSuccess)
+	require.NotNil(t, cond)
+	assert.Equal(t, "BuildValidated", cond.Reason)
 }
 
 func TestBuildControllerMultipleOptedInPools(t *testing.T) {
@@ -211,6 +355,24 @@ func TestBuildControllerMultipleOptedInPools(t *testing.T) {
 			})
 		}
 	})
+	// Tests that only the maximum number of concurrent builds run at once and
+	// that a queued build starts once the running build finishes.
+	t.Run("Max Concurrent Builds", func(t *testing.T) {
+		t.Parallel()
+
+		newBuildControllerTestFixture(t).runTestFuncs(t, testFuncs{
+			imageBuilder: func(ctx context.Context, t *testing.T, cs *Clients) {
+				testMaxConcurrentBuilds(ctx, t, cs, func(mcp *mcfgv1.MachineConfigPool) {
+					assertMCPFollowsImageBuildStatus(ctx, t, cs, mcp, buildv1.BuildPhaseComplete)
+				})
+			},
+			customPodBuilder: func(ctx context.Context, t *testing.T, cs *Clients) {
+				testMaxConcurrentBuilds(ctx, t, cs, func(mcp *mcfgv1.MachineConfigPool) {
+					assertMCPFollowsBuildPodStatus(ctx, t, cs, mcp, corev1.PodSucceeded)
+				})
+			},
+		})
+	})
 }
 
 // Holds a name and function to implement a given BuildController test.
@@ -541,6 +703,73 @@ func testOptedInMCPOptsOut(ctx context.Context, t *testing.T, cs *Clients, optIn
 	assertMachineConfigPoolReachesStateWithMsg(ctx, t, cs, "worker", checkFunc, msgFunc)
 }
 
+// Tests that a MachineConfigPool which opts out of layering while its build is
+// pending or failed has its build cancelled and returns to a clean
+// non-layered state.
+func testOptedInMCPOptsOutMidBuild(ctx context.Context, t *testing.T, cs *Clients) {
+	optOutMCP(ctx, t, cs, "worker")
+
+	checkFunc := func(mcp *mcfgv1.MachineConfigPool) bool {
+		ps := newPoolState(mcp)
+		lps := ctrlcommon.NewLayeredPoolState(mcp)
+
+		return !ps.IsLayered() &&
+			!lps.HasOSImage() &&
+			!lps.HasBuildConditions() &&
+			!ps.IsDegraded() &&
+			len(ps.GetBuildObjectRefs()) == 0 &&
+			assertNoBuildPods(ctx, t, cs) &&
+			assertNoBuilds(ctx, t, cs)
+	}
+
+	msgFunc := func(mcp *mcfgv1.MachineConfigPool) string {
+		sb := &strings.Builder{}
+
+		ps := newPoolState(mcp)
+		fmt.Fprintf(sb, "Is layered? %v\n", ps.IsLayered())
+		fmt.Fprintf(sb, "Is degraded? %v\n", ps.IsDegraded())
+		fmt.Fprintf(sb, "Build objects: %v\n", ps.GetBuildObjectRefs())
+		fmt.Fprintf(sb, "Build conditions: %v\n", ps.GetAllBuildConditions())
+		return sb.String()
+	}
+
+	assertMachineConfigPoolReachesStateWithMsg(ctx, t, cs, "worker", checkFunc, msgFunc)
+}
+
+// Sets the maximum number of concurrent builds to one and opts both pools in,
+// asserting that the second build is queued until the first one succeeds.
+func testMaxConcurrentBuilds(ctx context.Context, t *testing.T, cs *Clients, followBuild func(*mcfgv1.MachineConfigPool)) {
+	cm, err := cs.kubeclient.CoreV1().ConfigMaps(ctrlcommon.MCONamespace).Get(ctx, OnClusterBuildConfigMapName, metav1.GetOptions{})
+	require.NoError(t, err)
+
+	cm.Data[MaxConcurrentBuildsConfigKey] = "1"
+
+	_, err = cs.kubeclient.CoreV1().ConfigMaps(ctrlcommon.MCONamespace).Update(ctx, cm, metav1.UpdateOptions{})
+	require.NoError(t, err)
+
+	master := optInMCP(ctx, t, cs, "master")
+	assertMachineConfigPoolReachesState(ctx, t, cs, "master", func(mcp *mcfgv1.MachineConfigPool) bool {
+		return newPoolState(mcp).IsBuildPending()
+	})
+
+	worker := optInMCP(ctx, t, cs, "worker")
+	assertMachineConfigPoolReachesState(ctx, t, cs, "worker", func(mcp *mcfgv1.MachineConfigPool) bool {
+		_, queued := getBuildQueuedTime(mcp)
+		ps := newPoolState(mcp)
+		return queued && !ps.HasBuildConditions() && len(ps.GetBuildObjectRefs()) == 0
+	})
+
+	followBuild(master)
+	assertMachineConfigPoolReachesStateWithMsg(ctx, t, cs, "master", isMCPBuildSuccess, isMCPBuildSuccessMsg)
+
+	followBuild(worker)
+	assertMachineConfigPoolReachesStateWithMsg(ctx, t, cs, "worker", isMCPBuildSuccess, isMCPBuildSuccessMsg)
+	assertMachineConfigPoolReachesState(ctx, t, cs, "worker", func(mcp *mcfgv1.MachineConfigPool) bool {
+		_, queued := getBuildQueuedTime(mcp)
+		return !queued
+	})
+}
+
 // Tests that if a MachineConfigPool is degraded, that a build (object / pod) is not created.
 func testMCPIsDegraded(ctx context.Context, t *testing.T, cs *Clients) {
 	mcp, err := cs.mcfgclient.MachineconfigurationV1().MachineConfigPools().Get(ctx, "worker", metav1.GetOptions{})
@@ -568,6 +797,210 @@ func testMCPIsDegraded(ctx context.Context, t *testing.T, cs *Clients) {
 	})
 }
 
+// Tests that a build which exceeds the pool build timeout is terminated and
+// the pool is marked as failed with the timeout reason.
+func testBuildTimesOut(ctx context.Context, t *testing.T, cs *Clients) {
+	ibr := startBuildAndTimeItOut(ctx, t, cs)
+
+	assertMachineConfigPoolReachesState(ctx, t, cs, "worker", func(mcp *mcfgv1.MachineConfigPool) bool {
+		cond := apihelpers.GetMachineConfigPoolCondition(mcp.Status, mcfgv1.MachineConfigPoolBuildFailed)
+		return cond != nil &&
+			cond.Status == corev1.ConditionTrue &&
+			cond.Reason == buildTimedOutReason &&
+			newPoolState(mcp).IsDegraded() &&
+			buildConfigMapsDeleted(ctx, t, cs, ibr) &&
+			assertNoBuildPods(ctx, t, cs) &&
+			assertNoBuilds(ctx, t, cs)
+	})
+}
+
+// Tests that a build which exceeds the pool build timeout is retried like
+// any other failed build when the build retry policy allows it.
+func testBuildTimeoutIsRetried(ctx context.Context, t *testing.T, cs *Clients) {
+	cm, err := cs.kubeclient.CoreV1().ConfigMaps(ctrlcommon.MCONamespace).Get(ctx, OnClusterBuildConfigMapName, metav1.GetOptions{})
+	require.NoError(t, err)
+
+	// The backoff keeps the retry from starting before the test finishes.
+	cm.Data[BuildMaxRetriesConfigKey] = "1"
+	cm.Data[BuildRetryBackoffConfigKey] = "1h"
+
+	_, err = cs.kubeclient.CoreV1().ConfigMaps(ctrlcommon.MCONamespace).Update(ctx, cm, metav1.UpdateOptions{})
+	require.NoError(t, err)
+
+	ibr := startBuildAndTimeItOut(ctx, t, cs)
+
+	assertMachineConfigPoolReachesState(ctx, t, cs, "worker", func(mcp *mcfgv1.MachineConfigPool) bool {
+		cond := apihelpers.GetMachineConfigPoolCondition(mcp.Status, mcfgv1.MachineConfigPoolBuildFailed)
+		return cond != nil &&
+			cond.Status == corev1.ConditionTrue &&
+			cond.Reason == buildFailedRetryingReason &&
+			isBuildRetryPending(mcp) &&
+			!newPoolState(mcp).IsDegraded() &&
+			buildConfigMapsDeleted(ctx, t, cs, ibr) &&
+			assertNoBuildPods(ctx, t, cs) &&
+			assertNoBuilds(ctx, t, cs)
+	})
+}
+
+// Opts the worker pool into layering with a build timeout and backdates the
+// resulting build object so that the build controller times it out.
+func startBuildAndTimeItOut(ctx context.Context, t *testing.T, cs *Clients) ImageBuildRequest {
+	t.Helper()
+
+	mcp, err := cs.mcfgclient.MachineconfigurationV1().MachineConfigPools().Get(ctx, "worker", metav1.GetOptions{})
+	require.NoError(t, err)
+
+	mcp.Labels[ctrlcommon.LayeringEnabledPoolLabel] = ""
+	if mcp.Annotations == nil {
+		mcp.Annotations = map[string]string{}
+	}
+	mcp.Annotations[BuildTimeoutAnnotationKey] = "1m"
+
+	mcp, err = cs.mcfgclient.MachineconfigurationV1().MachineConfigPools().Update(ctx, mcp, metav1.UpdateOptions{})
+	require.NoError(t, err)
+
+	ibr := newImageBuildRequest(mcp)
+
+	assertMachineConfigPoolReachesState(ctx, t, cs, "worker", func(mcp *mcfgv1.MachineConfigPool) bool {
+		return newPoolState(mcp).IsBuildPending()
+	})
+
+	// The fake clients do not set a creation timestamp, so we backdate the
+	// build object to simulate a build which has been running for too long.
+	backdated := metav1.NewTime(time.Now().Add(-time.Hour))
+
+	pod, err := cs.kubeclient.CoreV1().Pods(ctrlcommon.MCONamespace).Get(ctx, ibr.getBuildName(), metav1.GetOptions{})
+	if err == nil {
+		pod.CreationTimestamp = backdated
+		_, err = cs.kubeclient.CoreV1().Pods(ctrlcommon.MCONamespace).Update(ctx, pod, metav1.UpdateOptions{})
+		require.NoError(t, err)
+	} else {
+		build, err := cs.buildclient.BuildV1().Builds(ctrlcommon.MCONamespace).Get(ctx, ibr.getBuildName(), metav1.GetOptions{})
+		require.NoError(t, err)
+		build.CreationTimestamp = backdated
+		_, err = cs.buildclient.BuildV1().Builds(ctrlcommon.MCONamespace).Update(ctx, build, metav1.UpdateOptions{})
+		require.NoError(t, err)
+	}
+
+	return ibr
+}
+
+// Determines whether the MachineConfig and Dockerfile ConfigMaps of the given
+// build have been deleted.
+func buildConfigMapsDeleted(ctx context.Context, t *testing.T, cs *Clients, ibr ImageBuildRequest) bool {
+	t.Helper()
+
+	for _, name := range []string{ibr.getMCConfigMapName(), ibr.getDockerfileConfigMapName()} {
+		_, err := cs.kubeclient.CoreV1().ConfigMaps(ctrlcommon.MCONamespace).Get(ctx, name, metav1.GetOptions{})
+		if !k8serrors.IsNotFound(err) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Tests that an invalid custom Containerfile fails the build before any build
+// objects are created.
+func testInvalidContainerfile(ctx context.Context, t *testing.T, cs *Clients) {
+	cm := getCustomDockerfileConfigMap(map[string]string{
+		"worker": "FROM configs AS final\nRUN dnf install -y python3\nENTRYPOINT [\"/bin/bash\"]",
+	})
+
+	_, err := cs.kubeclient.CoreV1().ConfigMaps(ctrlcommon.MCONamespace).Create(ctx, cm, metav1.CreateOptions{})
+	require.NoError(t, err)
+
+	optInMCP(ctx, t, cs, "worker")
+
+	assertMachineConfigPoolReachesState(ctx, t, cs, "worker", func(mcp *mcfgv1.MachineConfigPool) bool {
+		cond := apihelpers.GetMachineConfigPoolCondition(mcp.Status, mcfgv1.MachineConfigPoolBuildFailed)
+		return cond != nil &&
+			cond.Status == corev1.ConditionTrue &&
+			cond.Reason == invalidContainerfileReason &&
+			strings.Contains(cond.Message, "line 3: ENTRYPOINT is not allowed") &&
+			assertNoBuildPods(ctx, t, cs) &&
+			assertNoBuilds(ctx, t, cs)
+	})
+}
+
+// Requests a rebuild of the given MachineConfigPool and asserts that the
+// previous build is discarded and a new one is started.
+func testRebuildRequested(ctx context.Context, t *testing.T, cs *Clients, poolName string) {
+	mcp, err := cs.mcfgclient.MachineconfigurationV1().MachineConfigPools().Get(ctx, poolName, metav1.GetOptions{})
+	require.NoError(t, err)
+
+	if mcp.Annotations == nil {
+		mcp.Annotations = map[string]string{}
+	}
+	mcp.Annotations[RebuildAnnotationKey] = "1"
+
+	_, err = cs.mcfgclient.MachineconfigurationV1().MachineConfigPools().Update(ctx, mcp, metav1.UpdateOptions{})
+	require.NoError(t, err)
+
+	assertMachineConfigPoolReachesState(ctx, t, cs, poolName, func(mcp *mcfgv1.MachineConfigPool) bool {
+		ps := newPoolState(mcp)
+		return mcp.Annotations[LastRebuildAnnotationKey] == "1" &&
+			!ps.IsDegraded() &&
+			!ps.IsBuildFailure() &&
+			ps.IsBuildPending()
+	})
+}
+
+// Tests that cancelling a pending build deletes the build object and marks the
+// build as failed without degrading the pool or opting it out of layering.
+func testBuildCancelled(ctx context.Context, t *testing.T, cs *Clients) {
+	optInMCP(ctx, t, cs, "worker")
+
+	assertMachineConfigPoolReachesState(ctx, t, cs, "worker", func(mcp *mcfgv1.MachineConfigPool) bool {
+		return newPoolState(mcp).IsBuildPending()
+	})
+
+	mcp, err := cs.mcfgclient.MachineconfigurationV1().MachineConfigPools().Get(ctx, "worker", metav1.GetOptions{})
+	require.NoError(t, err)
+
+	if mcp.Annotations == nil {
+		mcp.Annotations = map[string]string{}
+	}
+	mcp.Annotations[CancelBuildAnnotationKey] = "1"
+
+	_, err = cs.mcfgclient.MachineconfigurationV1().MachineConfigPools().Update(ctx, mcp, metav1.UpdateOptions{})
+	require.NoError(t, err)
+
+	assertMachineConfigPoolReachesState(ctx, t, cs, "worker", func(mcp *mcfgv1.MachineConfigPool) bool {
+		ps := newPoolState(mcp)
+		return mcp.Annotations[LastCancelBuildAnnotationKey] == "1" &&
+			isBuildCancelled(mcp) &&
+			ps.IsLayered() &&
+			!ps.IsDegraded() &&
+			assertNoBuildPods(ctx, t, cs) &&
+			assertNoBuilds(ctx, t, cs)
+	})
+}
+
+// Tests that rotating a Secret which a pending build uses restarts the build
+// with the current credentials.
+func testBuildSecretRotated(ctx context.Context, t *testing.T, cs *Clients) {
+	optInMCP(ctx, t, cs, "worker")
+
+	assertMachineConfigPoolReachesState(ctx, t, cs, "worker", func(mcp *mcfgv1.MachineConfigPool) bool {
+		return newPoolState(mcp).IsBuildPending() &&
+			getRecordedBuildSecretVersions(mcp)["base-image-pull-secret"] == ""
+	})
+
+	secret, err := cs.kubeclient.CoreV1().Secrets(ctrlcommon.MCONamespace).Get(ctx, "base-image-pull-secret", metav1.GetOptions{})
+	require.NoError(t, err)
+
+	// The fake clients do not set a resourceVersion.
+	secret.ResourceVersion = "2"
+	_, err = cs.kubeclient.CoreV1().Secrets(ctrlcommon.MCONamespace).Update(ctx, secret, metav1.UpdateOptions{})
+	require.NoError(t, err)
+
+	assertMachineConfigPoolReachesState(ctx, t, cs, "worker", func(mcp *mcfgv1.MachineConfigPool) bool {
+		return newPoolState(mcp).IsBuildPending() &&
+			getRecordedBuildSecretVersions(mcp)["base-image-pull-secret"] == "2"
+	})
+}
+
 // Tests that a label update or similar does not cause a build to occur.
 func testBuiltPoolGetsUnrelatedUpdate(ctx context.Context, t *testing.T, cs *Clients, optInFunc optInFunc) {
 	optInFunc(ctx, t, cs, "worker")
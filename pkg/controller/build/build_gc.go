@@ -0,0 +1,151 @@
+package build
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+)
+
+// How often the build controller looks for build objects which were left
+// behind by a deleted or opted-out MachineConfigPool.
+const buildGCInterval = 10 * time.Minute
+
+// Gets the owner reference which ties a build object to its
+// MachineConfigPool, so that it is garbage collected when the pool is deleted.
+// BlockOwnerDeletion is not set since that requires permission to update the
+// pool's finalizers.
+func getPoolOwnerReference(pool *mcfgv1.MachineConfigPool) []metav1.OwnerReference {
+	if pool.UID == "" {
+		return nil
+	}
+
+	return []metav1.OwnerReference{
+		{
+			APIVersion: mcfgv1.SchemeGroupVersion.String(),
+			Kind:       "MachineConfigPool",
+			Name:       pool.Name,
+			UID:        pool.UID,
+		},
+	}
+}
+
+// Finds the builds whose objects are orphaned, i.e., whose MachineConfigPool
+// no longer exists or is no longer opted into layering. Each build is
+// returned as a stub MachineConfigPool which is sufficient to derive the names
+// of its objects.
+func getOrphanedBuilds(pools []*mcfgv1.MachineConfigPool, objects []metav1.Object) []*mcfgv1.MachineConfigPool {
+	layered := sets.New[string]()
+	for _, pool := range pools {
+		if ctrlcommon.IsLayeredPool(pool) {
+			layered.Insert(pool.Name)
+		}
+	}
+
+	orphaned := map[string]*mcfgv1.MachineConfigPool{}
+	for _, obj := range objects {
+		poolName := obj.GetLabels()[targetMachineConfigPoolLabel]
+		renderedConfig := obj.GetLabels()[desiredConfigLabel]
+		if poolName == "" || renderedConfig == "" || layered.Has(poolName) {
+			continue
+		}
+
+		stub := &mcfgv1.MachineConfigPool{}
+		stub.Name = poolName
+		stub.Spec.Configuration.Name = renderedConfig
+		orphaned[newImageBuildRequest(stub).getBuildName()] = stub
+	}
+
+	out := []*mcfgv1.MachineConfigPool{}
+	for _, stub := range orphaned {
+		out = append(out, stub)
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Spec.Configuration.Name < out[j].Spec.Configuration.Name
+	})
+
+	return out
+}
+
+// Lists every object in the MCO namespace which the build controller created
+// for a build.
+func (ctrl *Controller) listBuildObjects() ([]metav1.Object, error) {
+	selector := labels.SelectorFromSet(labels.Set{ctrlcommon.OSImageBuildPodLabel: ""}).String()
+	opts := metav1.ListOptions{LabelSelector: selector}
+
+	objects := []metav1.Object{}
+
+	configMaps, err := ctrl.kubeclient.CoreV1().ConfigMaps(ctrlcommon.MCONamespace).List(context.TODO(), opts)
+	if err != nil {
+		return nil, fmt.Errorf("could not list build ConfigMaps: %w", err)
+	}
+
+	for i := range configMaps.Items {
+		objects = append(objects, &configMaps.Items[i])
+	}
+
+	pods, err := ctrl.kubeclient.CoreV1().Pods(ctrlcommon.MCONamespace).List(context.TODO(), opts)
+	if err != nil {
+		return nil, fmt.Errorf("could not list build pods: %w", err)
+	}
+
+	for i := range pods.Items {
+		objects = append(objects, &pods.Items[i])
+	}
+
+	// The Build API is not available on clusters without the OpenShift Image
+	// Builder, which is fine when builds are done with the custom pod builder.
+	if ctrl.buildclient != nil {
+		builds, err := ctrl.buildclient.BuildV1().Builds(ctrlcommon.MCONamespace).List(context.TODO(), opts)
+		if err != nil {
+			klog.V(4).Infof("Could not list builds: %s", err)
+		} else {
+			for i := range builds.Items {
+				objects = append(objects, &builds.Items[i])
+			}
+		}
+	}
+
+	return objects, nil
+}
+
+// Deletes the build objects, pods and ConfigMaps which were left behind when
+// their MachineConfigPool was deleted or opted out of layering mid-build.
+func (ctrl *Controller) reapOrphanedBuildObjects() error {
+	pools, err := ctrl.mcpLister.List(labels.Everything())
+	if err != nil {
+		return fmt.Errorf("could not list MachineConfigPools: %w", err)
+	}
+
+	objects, err := ctrl.listBuildObjects()
+	if err != nil {
+		return err
+	}
+
+	for _, stub := range getOrphanedBuilds(pools, objects) {
+		klog.Infof("Deleting orphaned objects for build %s of MachineConfigPool %s", newImageBuildRequest(stub).getBuildName(), stub.Name)
+		if err := ctrl.postBuildCleanup(stub, true); err != nil {
+			return fmt.Errorf("could not delete orphaned objects for build %s: %w", newImageBuildRequest(stub).getBuildName(), err)
+		}
+	}
+
+	return nil
+}
+
+// Periodically reaps orphaned build objects until the context is cancelled.
+func (ctrl *Controller) runBuildGC(ctx context.Context) {
+	wait.UntilWithContext(ctx, func(_ context.Context) {
+		if err := ctrl.reapOrphanedBuildObjects(); err != nil {
+			klog.Errorf("Could not reap orphaned build objects: %s", err)
+		}
+	}, buildGCInterval)
+}
@@ -0,0 +1,111 @@
+package build
+
+import (
+	"context"
+	"testing"
+
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	fakeclientmachineconfigv1 "github.com/openshift/client-go/machineconfiguration/clientset/versioned/fake"
+	mcfginformers "github.com/openshift/client-go/machineconfiguration/informers/externalversions"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	fakecorev1client "k8s.io/client-go/kubernetes/fake"
+)
+
+// Creates the objects the custom pod builder leaves behind for a build.
+func newBuildObjects(pool *mcfgv1.MachineConfigPool) []runtime.Object {
+	ibr := newImageBuildRequest(pool)
+
+	return []runtime.Object{
+		&corev1.ConfigMap{ObjectMeta: ibr.getObjectMeta(ibr.getMCConfigMapName())},
+		&corev1.ConfigMap{ObjectMeta: ibr.getObjectMeta(ibr.getDockerfileConfigMapName())},
+		&corev1.Pod{ObjectMeta: ibr.getObjectMeta(ibr.getBuildName())},
+		// Created by the build pod itself, so it is not labeled.
+		&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: ibr.getDigestConfigMapName(), Namespace: ctrlcommon.MCONamespace}},
+	}
+}
+
+func TestGetPoolOwnerReference(t *testing.T) {
+	t.Parallel()
+
+	pool := newMachineConfigPool("worker")
+	pool.UID = "1234"
+
+	ibr := newImageBuildRequest(pool)
+	assert.Equal(t, []metav1.OwnerReference{
+		{
+			APIVersion: "machineconfiguration.openshift.io/v1",
+			Kind:       "MachineConfigPool",
+			Name:       "worker",
+			UID:        "1234",
+		},
+	}, ibr.getObjectMeta(ibr.getBuildName()).OwnerReferences)
+
+	pool.UID = ""
+	assert.Nil(t, getPoolOwnerReference(pool))
+}
+
+// Tests that the objects of builds whose pool was deleted or opted out of
+// layering are reaped, while those of layered pools are kept.
+func TestReapOrphanedBuildObjects(t *testing.T) {
+	t.Parallel()
+
+	layeredPool := newMachineConfigPool("worker", "rendered-worker-1")
+	layeredPool.Labels[ctrlcommon.LayeringEnabledPoolLabel] = ""
+
+	optedOutPool := newMachineConfigPool("infra", "rendered-infra-1")
+	deletedPool := newMachineConfigPool("deleted", "rendered-deleted-1")
+
+	kubeObjects := []runtime.Object{}
+	for _, pool := range []*mcfgv1.MachineConfigPool{layeredPool, optedOutPool, deletedPool} {
+		kubeObjects = append(kubeObjects, newBuildObjects(pool)...)
+	}
+
+	mcfgclient := fakeclientmachineconfigv1.NewSimpleClientset(layeredPool, optedOutPool)
+	clients := &Clients{
+		kubeclient: fakecorev1client.NewSimpleClientset(kubeObjects...),
+		mcfgclient: mcfgclient,
+	}
+
+	mcpInformer := mcfginformers.NewSharedInformerFactory(mcfgclient, 0).Machineconfiguration().V1().MachineConfigPools()
+	require.NoError(t, mcpInformer.Informer().GetIndexer().Add(layeredPool))
+	require.NoError(t, mcpInformer.Informer().GetIndexer().Add(optedOutPool))
+
+	ctrl := &Controller{
+		Clients:      clients,
+		mcpLister:    mcpInformer.Lister(),
+		imageBuilder: newPodBuildController(DefaultBuildControllerConfig(), clients, nil),
+	}
+
+	objects, err := ctrl.listBuildObjects()
+	require.NoError(t, err)
+
+	orphaned := getOrphanedBuilds([]*mcfgv1.MachineConfigPool{layeredPool, optedOutPool}, objects)
+	require.Len(t, orphaned, 2)
+	assert.Equal(t, "rendered-deleted-1", orphaned[0].Spec.Configuration.Name)
+	assert.Equal(t, "rendered-infra-1", orphaned[1].Spec.Configuration.Name)
+
+	require.NoError(t, ctrl.reapOrphanedBuildObjects())
+
+	configMaps, err := clients.kubeclient.CoreV1().ConfigMaps(ctrlcommon.MCONamespace).List(context.TODO(), metav1.ListOptions{})
+	require.NoError(t, err)
+
+	configMapNames := []string{}
+	for _, cm := range configMaps.Items {
+		configMapNames = append(configMapNames, cm.Name)
+	}
+
+	assert.ElementsMatch(t, []string{"mc-rendered-worker-1", "dockerfile-rendered-worker-1", "digest-rendered-worker-1"}, configMapNames)
+
+	pods, err := clients.kubeclient.CoreV1().Pods(ctrlcommon.MCONamespace).List(context.TODO(), metav1.ListOptions{})
+	require.NoError(t, err)
+	require.Len(t, pods.Items, 1)
+	assert.Equal(t, "build-rendered-worker-1", pods.Items[0].Name)
+
+	// Reaping is idempotent.
+	assert.NoError(t, ctrl.reapOrphanedBuildObjects())
+}
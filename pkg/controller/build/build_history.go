@@ -0,0 +1,522 @@
+package build
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/containers/image/v5/docker"
+	"github.com/containers/image/v5/types"
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	daemonconsts "github.com/openshift/machine-config-operator/pkg/daemon/constants"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// The on-cluster-build-config ConfigMap key which contains the number of
+	// successful builds to keep in the build history of each pool.
+	BuildHistoryLimitConfigKey = "buildHistoryLimit"
+
+	// The on-cluster-build-config ConfigMap key which contains the number of
+	// most recent images to keep for each pool. Older images from the build
+	// history are deleted from the registry. Defaults to zero, meaning images
+	// are never deleted.
+	ImageRetentionCountConfigKey = "imageRetentionCount"
+
+	// The key in the build history ConfigMap which contains the history.
+	buildHistoryConfigMapKey = "history"
+
+	defaultBuildHistoryLimit = 10
+)
+
+// Describes how much build history is kept and which images are deleted.
+type buildHistoryPolicy struct {
+	historyLimit        int
+	imageRetentionCount int
+}
+
+// A successful build in the build history of a pool.
+type buildHistoryEntry struct {
+	// The name of the build object which produced the image.
+	BuildName string `json:"buildName"`
+	// The rendered MachineConfig the image was built from.
+	MachineConfig string `json:"machineConfig"`
+	// The digested pullspec of the image.
+	Image string `json:"image"`
+	// When the build succeeded.
+	Completed metav1.Time `json:"completed"`
+	// Whether the image has been deleted from the registry.
+	Pruned bool `json:"pruned,omitempty"`
+	// The number of the build within the pool.
+	BuildNumber int `json:"buildNumber,omitempty"`
+	// The tag rendered from the tag template the image was pushed with, if any.
+	Tag string `json:"tag,omitempty"`
+}
+
+// Deletes images from a container registry.
+type imagePruner interface {
+	DeleteImage(ctx context.Context, pullspec string, pushSecret *corev1.Secret, insecure bool) error
+}
+
+// Deletes images from a container registry using the final image push secret.
+type registryImagePruner struct{}
+
+func (registryImagePruner) DeleteImage(ctx context.Context, pullspec string, pushSecret *corev1.Secret, insecure bool) error {
+	ref, err := docker.ParseReference("//" + pullspec)
+	if err != nil {
+		return fmt.Errorf("could not parse image %q: %w", pullspec, err)
+	}
+
+	sys := &types.SystemContext{}
+
+	if insecure {
+		sys.DockerInsecureSkipTLSVerify = types.OptionalBoolTrue
+	}
+
+	if pushSecret != nil {
+		canonical, err := canonicalizePullSecret(pushSecret)
+		if err != nil {
+			return err
+		}
+
+		authfile, err := os.CreateTemp("", "build-history-auth-*.json")
+		if err != nil {
+			return fmt.Errorf("could not create authfile: %w", err)
+		}
+
+		defer os.Remove(authfile.Name())
+
+		if _, err := authfile.Write(canonical.Data[corev1.DockerConfigJsonKey]); err != nil {
+			authfile.Close()
+			return fmt.Errorf("could not write authfile: %w", err)
+		}
+
+		if err := authfile.Close(); err != nil {
+			return fmt.Errorf("could not write authfile: %w", err)
+		}
+
+		sys.AuthFilePath = authfile.Name()
+	}
+
+	return ref.DeleteImage(ctx, sys)
+}
+
+// Gets the build history policy from the on-cluster-build-config ConfigMap,
+// falling back to the defaults for any keys that are not set. The history is
+// always long enough to keep track of the retained images.
+func getBuildHistoryPolicy(cm *corev1.ConfigMap) (buildHistoryPolicy, error) {
+	policy := buildHistoryPolicy{
+		historyLimit:        defaultBuildHistoryLimit,
+		imageRetentionCount: 0,
+	}
+
+	if cm == nil {
+		return policy, nil
+	}
+
+	parse := func(key string, min int) (int, bool, error) {
+		val, ok := cm.Data[key]
+		if !ok || val == "" {
+			return 0, false, nil
+		}
+
+		parsed, err := strconv.Atoi(val)
+		if err != nil {
+			return 0, false, fmt.Errorf("could not parse %s %q: %w", key, val, err)
+		}
+
+		if parsed < min {
+			return 0, false, fmt.Errorf("%s %q must be at least %d", key, val, min)
+		}
+
+		return parsed, true, nil
+	}
+
+	historyLimit, ok, err := parse(BuildHistoryLimitConfigKey, 1)
+	if err != nil {
+		return policy, err
+	}
+	if ok {
+		policy.historyLimit = historyLimit
+	}
+
+	imageRetentionCount, ok, err := parse(ImageRetentionCountConfigKey, 0)
+	if err != nil {
+		return policy, err
+	}
+	if ok {
+		policy.imageRetentionCount = imageRetentionCount
+	}
+
+	if policy.imageRetentionCount > policy.historyLimit {
+		policy.historyLimit = policy.imageRetentionCount
+	}
+
+	return policy, nil
+}
+
+// Computes the build history ConfigMap name based upon the MachineConfigPool name.
+func getBuildHistoryConfigMapName(pool *mcfgv1.MachineConfigPool) string {
+	return fmt.Sprintf("build-history-%s", pool.Name)
+}
+
+// Parses the build history from the build history ConfigMap, newest first.
+func parseBuildHistory(cm *corev1.ConfigMap) ([]buildHistoryEntry, error) {
+	history := []buildHistoryEntry{}
+
+	if cm == nil || cm.Data[buildHistoryConfigMapKey] == "" {
+		return history, nil
+	}
+
+	if err := json.Unmarshal([]byte(cm.Data[buildHistoryConfigMapKey]), &history); err != nil {
+		return nil, fmt.Errorf("could not parse build history from ConfigMap %s: %w", cm.Name, err)
+	}
+
+	return history, nil
+}
+
+// Returns the digest of an image pullspec, or the pullspec itself if it does
+// not have one.
+func getImageDigest(pullspec string) string {
+	if _, dgst, ok := strings.Cut(pullspec, "@"); ok {
+		return dgst
+	}
+
+	return pullspec
+}
+
+// Returns the indices of the history entries whose images should be deleted:
+// those beyond the retention count which have not been deleted yet, are not
+// in use and are not shared with a retained entry.
+func getBuildHistoryEntriesToPrune(history []buildHistoryEntry, policy buildHistoryPolicy, imagesInUse sets.String) []int {
+	toPrune := []int{}
+
+	if policy.imageRetentionCount == 0 {
+		return toPrune
+	}
+
+	retained := sets.NewString()
+	for i := 0; i < len(history) && i < policy.imageRetentionCount; i++ {
+		retained.Insert(getImageDigest(history[i].Image))
+	}
+
+	for i := policy.imageRetentionCount; i < len(history); i++ {
+		entry := history[i]
+		if entry.Pruned {
+			continue
+		}
+
+		digest := getImageDigest(entry.Image)
+		if retained.Has(digest) || imagesInUse.Has(digest) {
+			continue
+		}
+
+		toPrune = append(toPrune, i)
+	}
+
+	return toPrune
+}
+
+// Trims the build history to the history limit. When images are being
+// deleted, entries beyond the limit whose images have not been deleted yet are
+// kept so that their deletion can be retried.
+func trimBuildHistory(history []buildHistoryEntry, policy buildHistoryPolicy) []buildHistoryEntry {
+	if len(history) <= policy.historyLimit {
+		return history
+	}
+
+	out := append([]buildHistoryEntry{}, history[:policy.historyLimit]...)
+
+	if policy.imageRetentionCount == 0 {
+		return out
+	}
+
+	// Entries which share an image with a kept entry do not need to be kept.
+	kept := sets.NewString()
+	for _, entry := range out {
+		kept.Insert(getImageDigest(entry.Image))
+	}
+
+	for _, entry := range history[policy.historyLimit:] {
+		digest := getImageDigest(entry.Image)
+		if !entry.Pruned && !kept.Has(digest) {
+			kept.Insert(digest)
+			out = append(out, entry)
+		}
+	}
+
+	return out
+}
+
+// Gets the entries of the build history whose images the pool may be rolled
+// back to, newest first. These are the images within the image retention
+// count, or all of them when images are never deleted, along with the image
+// the pool is rolled back to, which is in use and so is never deleted.
+func getRetainedImages(history []buildHistoryEntry, policy buildHistoryPolicy, rollbackImage string) []buildHistoryEntry {
+	retained := []buildHistoryEntry{}
+	seen := sets.NewString()
+
+	for i, entry := range history {
+		if entry.Pruned || seen.Has(entry.Image) {
+			continue
+		}
+
+		if policy.imageRetentionCount != 0 && i >= policy.imageRetentionCount && entry.Image != rollbackImage {
+			continue
+		}
+
+		seen.Insert(entry.Image)
+		retained = append(retained, entry)
+	}
+
+	return retained
+}
+
+// Gets the digests of the images which are either in use by a node, about to
+// be used by a node, or the newest or rolled back to image for a
+// MachineConfigPool.
+func (ctrl *Controller) getImagesInUse() (sets.String, error) {
+	inUse := sets.NewString()
+
+	nodes, err := ctrl.kubeclient.CoreV1().Nodes().List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("could not list nodes: %w", err)
+	}
+
+	for _, node := range nodes.Items {
+		for _, key := range []string{daemonconsts.CurrentImageAnnotationKey, daemonconsts.DesiredImageAnnotationKey} {
+			if image := node.Annotations[key]; image != "" {
+				inUse.Insert(getImageDigest(image))
+			}
+		}
+	}
+
+	pools, err := ctrl.mcfgclient.MachineconfigurationV1().MachineConfigPools().List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("could not list MachineConfigPools: %w", err)
+	}
+
+	for i := range pools.Items {
+		pool := &pools.Items[i]
+		// The image named by the rollback annotation is kept even while it
+		// cannot be rolled out, so that the rollback can still complete.
+		rollbackImage, _ := ctrlcommon.NewLayeredPoolState(pool).GetRequestedRollbackImage()
+		for _, image := range []string{pool.Annotations[ctrlcommon.ExperimentalNewestLayeredImageEquivalentConfigAnnotationKey], rollbackImage} {
+			if image != "" {
+				inUse.Insert(getImageDigest(image))
+			}
+		}
+	}
+
+	return inUse, nil
+}
+
+// Deletes the images of the given history entries from the registry and
+// returns the ones which were deleted. Failures are reported through events
+// and retried after the next build.
+func (ctrl *Controller) pruneBuildHistoryImages(pool *mcfgv1.MachineConfigPool, toPrune []buildHistoryEntry, onClusterBuildConfig *corev1.ConfigMap) sets.String {
+	pruned := sets.NewString()
+
+	if len(toPrune) == 0 {
+		return pruned
+	}
+
+	var pushSecret *corev1.Secret
+	if name := onClusterBuildConfig.Data[FinalImagePushSecretNameConfigKey]; name != "" {
+		secret, err := ctrl.kubeclient.CoreV1().Secrets(ctrlcommon.MCONamespace).Get(context.TODO(), name, metav1.GetOptions{})
+		if err != nil {
+			klog.Errorf("Could not get final image push secret %s to prune images for pool %s: %v", name, pool.Name, err)
+			return pruned
+		}
+		pushSecret = secret
+	}
+
+	// The images were pushed to the final image registry, so they are deleted
+	// from it the same way.
+	insecure, err := getInsecureRegistry(onClusterBuildConfig)
+	if err != nil {
+		klog.Errorf("Could not prune images for pool %s: %v", pool.Name, err)
+		return pruned
+	}
+
+	for _, entry := range toPrune {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		err := ctrl.imagePruner.DeleteImage(ctx, entry.Image, pushSecret, insecure)
+		cancel()
+
+		if err != nil {
+			klog.Errorf("Could not delete image %s from build %s for pool %s: %v", entry.Image, entry.BuildName, pool.Name, err)
+			ctrl.eventRecorder.Eventf(pool, corev1.EventTypeWarning, "ImagePruneFailed", "Could not delete image %s from build %s: %v", entry.Image, entry.BuildName, err)
+			continue
+		}
+
+		klog.Infof("Deleted image %s from build %s for pool %s", entry.Image, entry.BuildName, pool.Name)
+		ctrl.eventRecorder.Eventf(pool, corev1.EventTypeNormal, "ImagePruned", "Deleted image %s from build %s", entry.Image, entry.BuildName)
+		pruned.Insert(entry.Image)
+	}
+
+	return pruned
+}
+
+// Records a successful build in the build history of the given
+// MachineConfigPool, deletes any images which are no longer retained and
+// trims the history to its limit. The images which remain are recorded on the
+// pool so that it may be rolled back to them. The images are deleted once the
+// new entry is persisted, outside of the conflict retries, so that a conflict
+// never deletes an image twice and the build worker is only held up once.
+func (ctrl *Controller) recordBuildHistory(pool *mcfgv1.MachineConfigPool, imagePullspec string) error {
+	onClusterBuildConfig, err := ctrl.configMapLister.ConfigMaps(ctrlcommon.MCONamespace).Get(OnClusterBuildConfigMapName)
+	if err != nil && !k8serrors.IsNotFound(err) {
+		return fmt.Errorf("could not get build controller config %q: %w", OnClusterBuildConfigMapName, err)
+	}
+
+	if k8serrors.IsNotFound(err) {
+		onClusterBuildConfig = nil
+	}
+
+	policy, err := getBuildHistoryPolicy(onClusterBuildConfig)
+	if err != nil {
+		return err
+	}
+
+	imagesInUse := sets.NewString()
+	if policy.imageRetentionCount != 0 {
+		imagesInUse, err = ctrl.getImagesInUse()
+		if err != nil {
+			return err
+		}
+	}
+
+	entry := buildHistoryEntry{
+		BuildName:     newImageBuildRequest(pool).getBuildName(),
+		MachineConfig: pool.Spec.Configuration.Name,
+		Image:         imagePullspec,
+		Completed:     metav1.Now(),
+		Tag:           pool.Annotations[BuildImageTagAnnotationKey],
+	}
+
+	rollbackImage, _ := ctrlcommon.NewLayeredPoolState(pool).GetRequestedRollbackImage()
+
+	toPrune := []buildHistoryEntry{}
+	retained := []buildHistoryEntry{}
+
+	err = ctrl.updateBuildHistory(pool, func(history []buildHistoryEntry) []buildHistoryEntry {
+		if len(history) == 0 || history[0].Image != entry.Image {
+			entry.BuildNumber = getNextBuildNumber(history)
+			history = append([]buildHistoryEntry{entry}, history...)
+		}
+
+		toPrune = []buildHistoryEntry{}
+		for _, i := range getBuildHistoryEntriesToPrune(history, policy, imagesInUse) {
+			toPrune = append(toPrune, history[i])
+		}
+
+		history = trimBuildHistory(history, policy)
+		retained = getRetainedImages(history, policy, rollbackImage)
+		return history
+	})
+
+	if err != nil {
+		return err
+	}
+
+	if err := ctrl.setRetainedImages(pool, retained); err != nil {
+		return err
+	}
+
+	pruned := ctrl.pruneBuildHistoryImages(pool, toPrune, onClusterBuildConfig)
+	if pruned.Len() == 0 {
+		return nil
+	}
+
+	// Mark the deleted images in the history as it is now, which may have
+	// changed while they were being deleted.
+	return ctrl.updateBuildHistory(pool, func(history []buildHistoryEntry) []buildHistoryEntry {
+		for i := range history {
+			if pruned.Has(history[i].Image) {
+				history[i].Pruned = true
+			}
+		}
+
+		return trimBuildHistory(history, policy)
+	})
+}
+
+// Reads the build history of the given MachineConfigPool, applies the given
+// mutation to it and persists the result, retrying on conflicts. The mutation
+// may therefore be called more than once and must not have side effects other
+// than on the history it is given.
+func (ctrl *Controller) updateBuildHistory(pool *mcfgv1.MachineConfigPool, mutate func([]buildHistoryEntry) []buildHistoryEntry) error {
+	cmName := getBuildHistoryConfigMapName(pool)
+
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		cm, err := ctrl.kubeclient.CoreV1().ConfigMaps(ctrlcommon.MCONamespace).Get(context.TODO(), cmName, metav1.GetOptions{})
+		if err != nil && !k8serrors.IsNotFound(err) {
+			return err
+		}
+
+		isNotFound := k8serrors.IsNotFound(err)
+		if isNotFound {
+			cm = &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      cmName,
+					Namespace: ctrlcommon.MCONamespace,
+					Labels: map[string]string{
+						targetMachineConfigPoolLabel: pool.Name,
+					},
+				},
+			}
+		}
+
+		history, err := parseBuildHistory(cm)
+		if err != nil {
+			// A corrupt history cannot be used to find images to delete, so start over.
+			klog.Warningf("Resetting build history for pool %s: %v", pool.Name, err)
+			history = []buildHistoryEntry{}
+		}
+
+		out, err := json.Marshal(mutate(history))
+		if err != nil {
+			return err
+		}
+
+		cm.Data = map[string]string{
+			buildHistoryConfigMapKey: string(out),
+		}
+
+		if isNotFound {
+			_, err = ctrl.kubeclient.CoreV1().ConfigMaps(ctrlcommon.MCONamespace).Create(context.TODO(), cm, metav1.CreateOptions{})
+		} else {
+			_, err = ctrl.kubeclient.CoreV1().ConfigMaps(ctrlcommon.MCONamespace).Update(context.TODO(), cm, metav1.UpdateOptions{})
+		}
+
+		return err
+	})
+}
+
+// Sets the annotations listing the retained images and the rendered
+// MachineConfigs they were built from on the MachineConfigPool.
+func (ctrl *Controller) setRetainedImages(pool *mcfgv1.MachineConfigPool, retained []buildHistoryEntry) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		mcp, err := ctrl.mcfgclient.MachineconfigurationV1().MachineConfigPools().Get(context.TODO(), pool.Name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+
+		ps := newPoolState(mcp)
+		ps.SetRetainedImages(retained)
+
+		_, err = ctrl.mcfgclient.MachineconfigurationV1().MachineConfigPools().Update(context.TODO(), ps.pool, metav1.UpdateOptions{})
+		return err
+	})
+}
@@ -0,0 +1,269 @@
+package build
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	fakeclientmachineconfigv1 "github.com/openshift/client-go/machineconfiguration/clientset/versioned/fake"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	daemonconsts "github.com/openshift/machine-config-operator/pkg/daemon/constants"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/sets"
+	fakecorev1client "k8s.io/client-go/kubernetes/fake"
+	core "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/record"
+)
+
+type fakeImagePruner struct {
+	deleted []string
+	failFor sets.String
+}
+
+func (f *fakeImagePruner) DeleteImage(_ context.Context, pullspec string, _ *corev1.Secret, _ bool) error {
+	if f.failFor.Has(pullspec) {
+		return fmt.Errorf("unauthorized")
+	}
+
+	f.deleted = append(f.deleted, pullspec)
+	return nil
+}
+
+func getHistoryImage(n int) string {
+	return fmt.Sprintf("registry.hostname.com/org/repo@sha256:%064d", n)
+}
+
+// Creates a build history, newest first, with one entry per image number.
+func newBuildHistory(images ...int) []buildHistoryEntry {
+	history := []buildHistoryEntry{}
+	for _, n := range images {
+		history = append(history, buildHistoryEntry{
+			BuildName:     fmt.Sprintf("build-rendered-worker-%d", n),
+			MachineConfig: fmt.Sprintf("rendered-worker-%d", n),
+			Image:         getHistoryImage(n),
+		})
+	}
+	return history
+}
+
+func TestGetBuildHistoryPolicy(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name           string
+		data           map[string]string
+		expectedPolicy buildHistoryPolicy
+		errExpected    bool
+	}{
+		{
+			name:           "defaults",
+			expectedPolicy: buildHistoryPolicy{historyLimit: defaultBuildHistoryLimit},
+		},
+		{
+			name: "configured",
+			data: map[string]string{
+				BuildHistoryLimitConfigKey:   "20",
+				ImageRetentionCountConfigKey: "3",
+			},
+			expectedPolicy: buildHistoryPolicy{historyLimit: 20, imageRetentionCount: 3},
+		},
+		{
+			name: "history covers retained images",
+			data: map[string]string{
+				BuildHistoryLimitConfigKey:   "2",
+				ImageRetentionCountConfigKey: "5",
+			},
+			expectedPolicy: buildHistoryPolicy{historyLimit: 5, imageRetentionCount: 5},
+		},
+		{
+			name:        "zero history limit",
+			data:        map[string]string{BuildHistoryLimitConfigKey: "0"},
+			errExpected: true,
+		},
+		{
+			name:        "negative retention count",
+			data:        map[string]string{ImageRetentionCountConfigKey: "-1"},
+			errExpected: true,
+		},
+		{
+			name:        "unparseable retention count",
+			data:        map[string]string{ImageRetentionCountConfigKey: "three"},
+			errExpected: true,
+		},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			cm := getOnClusterBuildConfigMap()
+			for k, v := range testCase.data {
+				cm.Data[k] = v
+			}
+
+			policy, err := getBuildHistoryPolicy(cm)
+			if testCase.errExpected {
+				assert.Error(t, err)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, testCase.expectedPolicy, policy)
+		})
+	}
+}
+
+func TestGetBuildHistoryEntriesToPrune(t *testing.T) {
+	t.Parallel()
+
+	policy := buildHistoryPolicy{historyLimit: 10, imageRetentionCount: 2}
+
+	history := newBuildHistory(5, 4, 3, 2, 1, 0)
+	history[4].Pruned = true
+
+	// An older build which produced the same image as a retained build.
+	history[5].Image = getHistoryImage(4)
+
+	// Image 2 is still in use by a node.
+	inUse := sets.NewString(getImageDigest(getHistoryImage(2)))
+
+	assert.Equal(t, []int{2}, getBuildHistoryEntriesToPrune(history, policy, inUse))
+	assert.Empty(t, getBuildHistoryEntriesToPrune(history, buildHistoryPolicy{historyLimit: 10}, inUse))
+}
+
+func TestTrimBuildHistory(t *testing.T) {
+	t.Parallel()
+
+	history := newBuildHistory(4, 3, 2, 1, 0)
+	history[3].Pruned = true
+
+	assert.Equal(t, newBuildHistory(4, 3), trimBuildHistory(history, buildHistoryPolicy{historyLimit: 2}))
+
+	// Images which could not be deleted yet are kept around.
+	trimmed := trimBuildHistory(history, buildHistoryPolicy{historyLimit: 2, imageRetentionCount: 2})
+	assert.Equal(t, append(newBuildHistory(4, 3, 2), newBuildHistory(0)...), trimmed)
+}
+
+func TestGetRetainedImages(t *testing.T) {
+	t.Parallel()
+
+	history := newBuildHistory(4, 3, 2, 1, 0)
+	history[3].Pruned = true
+
+	// Images are never deleted, so every image which was not deleted before is retained.
+	assert.Equal(t, newBuildHistory(4, 3, 2, 0), getRetainedImages(history, buildHistoryPolicy{historyLimit: 10}, ""))
+
+	policy := buildHistoryPolicy{historyLimit: 10, imageRetentionCount: 2}
+	assert.Equal(t, newBuildHistory(4, 3), getRetainedImages(history, policy, ""))
+
+	// The image the pool is rolled back to stays retained.
+	assert.Equal(t, newBuildHistory(4, 3, 0), getRetainedImages(history, policy, getHistoryImage(0)))
+}
+
+func TestRecordBuildHistory(t *testing.T) {
+	t.Parallel()
+
+	onClusterBuildConfigMap := getOnClusterBuildConfigMap()
+	onClusterBuildConfigMap.Data[BuildHistoryLimitConfigKey] = "3"
+	onClusterBuildConfigMap.Data[ImageRetentionCountConfigKey] = "2"
+
+	pool := newMachineConfigPool("worker", "rendered-worker-5")
+	pool.Annotations = map[string]string{
+		ctrlcommon.ExperimentalNewestLayeredImageEquivalentConfigAnnotationKey: getHistoryImage(5),
+	}
+
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "worker-0",
+			Annotations: map[string]string{
+				daemonconsts.CurrentImageAnnotationKey: getHistoryImage(2),
+				daemonconsts.DesiredImageAnnotationKey: getHistoryImage(4),
+			},
+		},
+	}
+
+	out, err := json.Marshal(newBuildHistory(4, 3, 2, 1))
+	require.NoError(t, err)
+
+	historyConfigMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      getBuildHistoryConfigMapName(pool),
+			Namespace: ctrlcommon.MCONamespace,
+		},
+		Data: map[string]string{
+			buildHistoryConfigMapKey: string(out),
+		},
+	}
+
+	pushSecret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "final-image-push-secret",
+			Namespace: ctrlcommon.MCONamespace,
+		},
+		Data: map[string][]byte{
+			corev1.DockerConfigJsonKey: []byte(`{"auths":{"registry.hostname.com":{"auth":"dXNlcjpwYXNz"}}}`),
+		},
+		Type: corev1.SecretTypeDockerConfigJson,
+	}
+
+	pruner := &fakeImagePruner{failFor: sets.NewString(getHistoryImage(1))}
+
+	kubeclient := fakecorev1client.NewSimpleClientset(onClusterBuildConfigMap, historyConfigMap, pushSecret, node)
+
+	// Another writer updates the history first, so the update is retried.
+	conflicted := false
+	kubeclient.PrependReactor("update", "configmaps", func(action core.Action) (bool, runtime.Object, error) {
+		if conflicted {
+			return false, nil, nil
+		}
+
+		conflicted = true
+		return true, nil, k8serrors.NewConflict(corev1.Resource("configmaps"), historyConfigMap.Name, fmt.Errorf("conflict"))
+	})
+
+	ctrl := &Controller{
+		Clients: &Clients{
+			kubeclient: kubeclient,
+			mcfgclient: fakeclientmachineconfigv1.NewSimpleClientset(pool),
+		},
+		eventRecorder:   record.NewFakeRecorder(10),
+		imagePruner:     pruner,
+		configMapLister: newConfigMapLister(t, onClusterBuildConfigMap),
+	}
+
+	require.NoError(t, ctrl.recordBuildHistory(pool, getHistoryImage(5)))
+
+	// Image 2 is in use and image 1 could not be deleted, so only image 3 is
+	// gone. It is only deleted once, despite the conflict.
+	assert.True(t, conflicted)
+	assert.Equal(t, []string{getHistoryImage(3)}, pruner.deleted)
+
+	cm, err := ctrl.kubeclient.CoreV1().ConfigMaps(ctrlcommon.MCONamespace).Get(context.TODO(), historyConfigMap.Name, metav1.GetOptions{})
+	require.NoError(t, err)
+
+	history, err := parseBuildHistory(cm)
+	require.NoError(t, err)
+
+	images := []string{}
+	for _, entry := range history {
+		images = append(images, entry.Image)
+	}
+
+	assert.Equal(t, []string{getHistoryImage(5), getHistoryImage(4), getHistoryImage(3), getHistoryImage(2), getHistoryImage(1)}, images)
+	assert.Equal(t, "build-rendered-worker-5", history[0].BuildName)
+	assert.Equal(t, 1, history[0].BuildNumber)
+
+	mcp, err := ctrl.mcfgclient.MachineconfigurationV1().MachineConfigPools().Get(context.TODO(), pool.Name, metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{getHistoryImage(5), getHistoryImage(4)}, ctrlcommon.NewLayeredPoolState(mcp).GetRetainedImages())
+	assert.Equal(t, map[string]string{getHistoryImage(5): "rendered-worker-5", getHistoryImage(4): "rendered-worker-4"}, ctrlcommon.NewLayeredPoolState(mcp).GetRetainedImageMachineConfigs())
+	assert.True(t, history[2].Pruned)
+	assert.False(t, history[4].Pruned)
+}
@@ -0,0 +1,217 @@
+package build
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	buildv1 "github.com/openshift/api/build/v1"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// The on-cluster-build-config ConfigMap key which contains how many bytes
+	// from the end of the log of a failed build are kept on the
+	// MachineConfigPool (e.g., "8192"). Setting it to "0" disables capturing
+	// the build log.
+	BuildLogTailBytesConfigKey = "buildLogTailBytes"
+
+	// Annotation on the MachineConfigPool which holds the tail of the log of
+	// its last failed build. It is removed once a new build starts.
+	BuildLogTailAnnotationKey = "machineconfiguration.openshift.io/build-log-tail"
+
+	defaultBuildLogTailBytes = 4 * 1024
+
+	// Annotations are limited to 256 KiB in total across the object, so only a
+	// fraction of that is used for the log tail.
+	maxBuildLogTailBytes = 32 * 1024
+
+	// How many lines are fetched from the end of the build log before it is
+	// trimmed to the configured size.
+	buildLogTailLines = 500
+
+	// How many of the decisive error lines are included in the BuildFailed
+	// condition and event.
+	maxDecisiveErrorLines = 5
+
+	// The name of the container which performs the build in the pod created
+	// for an OpenShift Image Builder build using the Docker strategy.
+	openshiftBuildContainerName = "docker-build"
+)
+
+// Gets how many bytes from the end of the log of a failed build are kept,
+// from the on-cluster-build-config ConfigMap.
+func getBuildLogTailBytes(cm *corev1.ConfigMap) (int64, error) {
+	if cm == nil {
+		return defaultBuildLogTailBytes, nil
+	}
+
+	val, ok := cm.Data[BuildLogTailBytesConfigKey]
+	if !ok || val == "" {
+		return defaultBuildLogTailBytes, nil
+	}
+
+	tailBytes, err := strconv.ParseInt(val, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("could not parse %s %q: %w", BuildLogTailBytesConfigKey, val, err)
+	}
+
+	if tailBytes < 0 || tailBytes > maxBuildLogTailBytes {
+		return 0, fmt.Errorf("%s %q must be between 0 and %d", BuildLogTailBytesConfigKey, val, maxBuildLogTailBytes)
+	}
+
+	return tailBytes, nil
+}
+
+// Trims the build log to at most the given number of bytes from its end,
+// starting at a line boundary where possible.
+func trimBuildLog(log string, tailBytes int64) string {
+	log = strings.TrimRight(log, "\n")
+
+	if int64(len(log)) <= tailBytes {
+		return log
+	}
+
+	log = log[int64(len(log))-tailBytes:]
+
+	if idx := strings.Index(log, "\n"); idx != -1 && idx < len(log)-1 {
+		return log[idx+1:]
+	}
+
+	return log
+}
+
+// Finds the lines of the build log which most likely explain why the build
+// failed, i.e., the last lines which mention an error. Falls back to the last
+// lines of the log if none do.
+func getDecisiveErrorLines(log string) []string {
+	lines := []string{}
+	for _, line := range strings.Split(log, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+
+	errorLines := []string{}
+	for _, line := range lines {
+		lower := strings.ToLower(line)
+		if strings.Contains(lower, "error") || strings.Contains(lower, "fatal") || strings.Contains(lower, "failed") {
+			errorLines = append(errorLines, line)
+		}
+	}
+
+	if len(errorLines) == 0 {
+		errorLines = lines
+	}
+
+	if len(errorLines) > maxDecisiveErrorLines {
+		errorLines = errorLines[len(errorLines)-maxDecisiveErrorLines:]
+	}
+
+	return errorLines
+}
+
+// Gets the name of the pod and container which ran the failed build for the
+// current rendered MachineConfig.
+func (ctrl *Controller) getBuildPodAndContainer(ps *poolState) (string, string, error) {
+	buildName := newImageBuildRequest(ps.MachineConfigPool()).getBuildName()
+
+	for _, objRef := range ps.GetBuildObjectRefs() {
+		if objRef.Name != buildName {
+			continue
+		}
+
+		switch objRef.Kind {
+		case "Pod":
+			return objRef.Name, "image-build", nil
+		case "Build":
+			build, err := ctrl.buildclient.BuildV1().Builds(ctrlcommon.MCONamespace).Get(context.TODO(), objRef.Name, metav1.GetOptions{})
+			if err != nil {
+				return "", "", fmt.Errorf("could not get Build %s: %w", objRef.Name, err)
+			}
+
+			return build.Annotations[buildv1.BuildPodNameAnnotation], openshiftBuildContainerName, nil
+		}
+	}
+
+	return "", "", nil
+}
+
+// Captures the tail of the log of the failed build for the given
+// MachineConfigPool, storing it on the pool and emitting an event with the
+// decisive error lines. This is best-effort since the build pod may already be
+// gone; the decisive error lines are returned so that they can be included in
+// the BuildFailed condition.
+func (ctrl *Controller) captureBuildLogTail(ps *poolState) string {
+	cm, err := ctrl.configMapLister.ConfigMaps(ctrlcommon.MCONamespace).Get(OnClusterBuildConfigMapName)
+	if err != nil && !k8serrors.IsNotFound(err) {
+		klog.Errorf("Could not get build controller config %q: %s", OnClusterBuildConfigMapName, err)
+		return ""
+	}
+
+	if k8serrors.IsNotFound(err) {
+		cm = nil
+	}
+
+	tailBytes, err := getBuildLogTailBytes(cm)
+	if err != nil {
+		klog.Errorf("Could not get build log tail size: %s", err)
+		return ""
+	}
+
+	if tailBytes == 0 {
+		return ""
+	}
+
+	podName, containerName, err := ctrl.getBuildPodAndContainer(ps)
+	if err != nil || podName == "" {
+		klog.V(4).Infof("Could not find build pod for pool %s to capture its log: %v", ps.Name(), err)
+		return ""
+	}
+
+	tailLines := int64(buildLogTailLines)
+	raw, err := ctrl.kubeclient.CoreV1().Pods(ctrlcommon.MCONamespace).GetLogs(podName, &corev1.PodLogOptions{
+		Container: containerName,
+		TailLines: &tailLines,
+	}).DoRaw(context.TODO())
+	if err != nil {
+		klog.V(4).Infof("Could not get log of build pod %s for pool %s: %v", podName, ps.Name(), err)
+		return ""
+	}
+
+	logTail := trimBuildLog(string(raw), tailBytes)
+	if logTail == "" {
+		return ""
+	}
+
+	if err := ctrl.setBuildLogTail(ps, logTail); err != nil {
+		klog.Errorf("Could not store build log tail for pool %s: %s", ps.Name(), err)
+	}
+
+	decisive := strings.Join(getDecisiveErrorLines(logTail), "\n")
+	ctrl.eventRecorder.Eventf(ps.MachineConfigPool(), corev1.EventTypeWarning, "BuildFailed", "Build %s failed:\n%s", podName, decisive)
+
+	return decisive
+}
+
+// Stores the build log tail on the MachineConfigPool.
+func (ctrl *Controller) setBuildLogTail(ps *poolState, logTail string) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		mcp, err := ctrl.mcfgclient.MachineconfigurationV1().MachineConfigPools().Get(context.TODO(), ps.Name(), metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+
+		ps := newPoolState(mcp)
+		ps.SetBuildLogTail(logTail)
+
+		_, err = ctrl.mcfgclient.MachineconfigurationV1().MachineConfigPools().Update(context.TODO(), ps.pool, metav1.UpdateOptions{})
+		return err
+	})
+}
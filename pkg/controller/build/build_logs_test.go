@@ -0,0 +1,109 @@
+package build
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Tests that the build log tail size is read from the on-cluster-build-config
+// ConfigMap with the appropriate default.
+func TestGetBuildLogTailBytes(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name        string
+		data        map[string]string
+		expected    int64
+		expectError bool
+	}{
+		{
+			name:     "default",
+			expected: defaultBuildLogTailBytes,
+		},
+		{
+			name:     "set",
+			data:     map[string]string{BuildLogTailBytesConfigKey: "8192"},
+			expected: 8192,
+		},
+		{
+			name:     "disabled",
+			data:     map[string]string{BuildLogTailBytesConfigKey: "0"},
+			expected: 0,
+		},
+		{
+			name:        "invalid",
+			data:        map[string]string{BuildLogTailBytesConfigKey: "8k"},
+			expectError: true,
+		},
+		{
+			name:        "negative",
+			data:        map[string]string{BuildLogTailBytesConfigKey: "-1"},
+			expectError: true,
+		},
+		{
+			name:        "too large",
+			data:        map[string]string{BuildLogTailBytesConfigKey: "1048576"},
+			expectError: true,
+		},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			cm := getOnClusterBuildConfigMap()
+			for k, v := range testCase.data {
+				cm.Data[k] = v
+			}
+
+			tailBytes, err := getBuildLogTailBytes(cm)
+			if testCase.expectError {
+				assert.Error(t, err)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, testCase.expected, tailBytes)
+		})
+	}
+}
+
+// Tests that the build log is trimmed to a line boundary near its end.
+func TestTrimBuildLog(t *testing.T) {
+	t.Parallel()
+
+	log := "STEP 1/3: FROM base\nSTEP 2/3: RUN dnf install foo\nError: no package foo\n"
+
+	assert.Equal(t, strings.TrimSuffix(log, "\n"), trimBuildLog(log, 1024))
+	assert.Equal(t, "Error: no package foo", trimBuildLog(log, 30))
+	assert.Equal(t, "foo", trimBuildLog("no newline foo", 3))
+}
+
+// Tests that the lines mentioning errors are picked out of the build log.
+func TestGetDecisiveErrorLines(t *testing.T) {
+	t.Parallel()
+
+	log := strings.Join([]string{
+		"STEP 1/3: FROM base",
+		"STEP 2/3: RUN dnf install foo",
+		"Error: Unable to find a match: foo",
+		"",
+		"error building at STEP \"RUN dnf install foo\": exit status 1",
+	}, "\n")
+
+	assert.Equal(t, []string{
+		"Error: Unable to find a match: foo",
+		"error building at STEP \"RUN dnf install foo\": exit status 1",
+	}, getDecisiveErrorLines(log))
+
+	// Falls back to the last lines when none mention an error.
+	lines := []string{}
+	for i := 0; i < 10; i++ {
+		lines = append(lines, strings.Repeat("x", i+1))
+	}
+
+	assert.Equal(t, lines[5:], getDecisiveErrorLines(strings.Join(lines, "\n")))
+}
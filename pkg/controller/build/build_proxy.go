@@ -0,0 +1,146 @@
+package build
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	configv1 "github.com/openshift/api/config/v1"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// The name of the ConfigMap which the build controller keeps in sync with
+	// the cluster's additional trusted CA bundle so that it can be mounted into
+	// custom build pods.
+	BuildTrustedCAConfigMapName = "machine-os-builder-trusted-ca"
+
+	buildTrustedCAConfigMapKey = "ca-bundle.crt"
+	buildTrustedCAVolumeName   = "trusted-ca"
+	buildTrustedCAMountpoint   = "/tmp/trusted-ca"
+)
+
+// Describes the cluster-wide proxy and the additional trusted CA bundle which
+// builds need to reach registries and package repositories.
+type buildProxy struct {
+	Proxy configv1.ProxyStatus
+	// Whether the cluster has an additional trusted CA bundle, which is stored
+	// in the BuildTrustedCAConfigMapName ConfigMap.
+	HasTrustedCA bool
+}
+
+// Gets the proxy environment variables for the build. Both the upper and
+// lower case variants are set since tools differ in which they honor.
+func (b buildProxy) env() []corev1.EnvVar {
+	var env []corev1.EnvVar
+
+	for _, v := range []struct {
+		name  string
+		value string
+	}{
+		{name: "HTTP_PROXY", value: b.Proxy.HTTPProxy},
+		{name: "HTTPS_PROXY", value: b.Proxy.HTTPSProxy},
+		{name: "NO_PROXY", value: b.Proxy.NoProxy},
+	} {
+		if v.value == "" {
+			continue
+		}
+
+		env = append(env, corev1.EnvVar{Name: v.name, Value: v.value})
+	}
+
+	// The lower case variants go after all of the upper case ones to keep the
+	// order stable.
+	var lower []corev1.EnvVar
+	for _, e := range env {
+		lower = append(lower, corev1.EnvVar{Name: strings.ToLower(e.Name), Value: e.Value})
+	}
+
+	return append(env, lower...)
+}
+
+// Gets the cluster-wide proxy and additional trusted CA bundle from the
+// ControllerConfig, which the operator populates from the Proxy object and
+// its trusted CA ConfigMap. The trusted CA bundle is copied into the
+// BuildTrustedCAConfigMapName ConfigMap in the MCO namespace so that build
+// pods can mount it.
+func (ctrl *Controller) getBuildProxy() (buildProxy, error) {
+	out := buildProxy{}
+
+	cc, err := ctrl.mcfgclient.MachineconfigurationV1().ControllerConfigs().Get(context.TODO(), ctrlcommon.ControllerConfigName, metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		klog.V(4).Infof("ControllerConfig %s not found, builds will not use a proxy or additional trusted CA bundle", ctrlcommon.ControllerConfigName)
+		return out, nil
+	}
+
+	if err != nil {
+		return out, fmt.Errorf("could not get ControllerConfig %s: %w", ctrlcommon.ControllerConfigName, err)
+	}
+
+	if cc.Spec.Proxy != nil {
+		out.Proxy = *cc.Spec.Proxy
+	}
+
+	if len(cc.Spec.AdditionalTrustBundle) == 0 {
+		return out, nil
+	}
+
+	if err := ctrl.syncBuildTrustedCA(cc.Spec.AdditionalTrustBundle); err != nil {
+		return out, err
+	}
+
+	out.HasTrustedCA = true
+
+	return out, nil
+}
+
+// Creates or updates the BuildTrustedCAConfigMapName ConfigMap with the given
+// CA bundle.
+func (ctrl *Controller) syncBuildTrustedCA(bundle []byte) error {
+	cmClient := ctrl.kubeclient.CoreV1().ConfigMaps(ctrlcommon.MCONamespace)
+
+	cm, err := cmClient.Get(context.TODO(), BuildTrustedCAConfigMapName, metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		cm = &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      BuildTrustedCAConfigMapName,
+				Namespace: ctrlcommon.MCONamespace,
+			},
+			Data: map[string]string{
+				buildTrustedCAConfigMapKey: string(bundle),
+			},
+		}
+
+		if _, err := cmClient.Create(context.TODO(), cm, metav1.CreateOptions{}); err != nil {
+			return fmt.Errorf("could not create ConfigMap %s: %w", BuildTrustedCAConfigMapName, err)
+		}
+
+		klog.Infof("Created ConfigMap %s with the additional trusted CA bundle", BuildTrustedCAConfigMapName)
+		return nil
+	}
+
+	if err != nil {
+		return fmt.Errorf("could not get ConfigMap %s: %w", BuildTrustedCAConfigMapName, err)
+	}
+
+	if cm.Data[buildTrustedCAConfigMapKey] == string(bundle) {
+		return nil
+	}
+
+	cm = cm.DeepCopy()
+	if cm.Data == nil {
+		cm.Data = map[string]string{}
+	}
+	cm.Data[buildTrustedCAConfigMapKey] = string(bundle)
+
+	if _, err := cmClient.Update(context.TODO(), cm, metav1.UpdateOptions{}); err != nil {
+		return fmt.Errorf("could not update ConfigMap %s: %w", BuildTrustedCAConfigMapName, err)
+	}
+
+	klog.Infof("Updated ConfigMap %s with the additional trusted CA bundle", BuildTrustedCAConfigMapName)
+	return nil
+}
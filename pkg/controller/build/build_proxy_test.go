@@ -0,0 +1,111 @@
+package build
+
+import (
+	"context"
+	"testing"
+
+	configv1 "github.com/openshift/api/config/v1"
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	fakeclientmachineconfigv1 "github.com/openshift/client-go/machineconfiguration/clientset/versioned/fake"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	fakecorev1client "k8s.io/client-go/kubernetes/fake"
+)
+
+// Tests that the proxy environment variables are set in both cases and that
+// unset proxies are left out.
+func TestBuildProxyEnv(t *testing.T) {
+	t.Parallel()
+
+	assert.Empty(t, buildProxy{}.env())
+
+	bp := buildProxy{
+		Proxy: configv1.ProxyStatus{
+			HTTPSProxy: "http://proxy.example.com:3128",
+			NoProxy:    ".cluster.local,.svc,10.0.0.0/16",
+		},
+	}
+
+	assert.Equal(t, []corev1.EnvVar{
+		{Name: "HTTPS_PROXY", Value: "http://proxy.example.com:3128"},
+		{Name: "NO_PROXY", Value: ".cluster.local,.svc,10.0.0.0/16"},
+		{Name: "https_proxy", Value: "http://proxy.example.com:3128"},
+		{Name: "no_proxy", Value: ".cluster.local,.svc,10.0.0.0/16"},
+	}, bp.env())
+}
+
+// Tests that the proxy and additional trusted CA bundle are read from the
+// ControllerConfig and that the trusted CA bundle is kept in sync.
+func TestGetBuildProxy(t *testing.T) {
+	t.Parallel()
+
+	newController := func(objects ...runtime.Object) *Controller {
+		return &Controller{
+			Clients: &Clients{
+				kubeclient: fakecorev1client.NewSimpleClientset(),
+				mcfgclient: fakeclientmachineconfigv1.NewSimpleClientset(objects...),
+			},
+		}
+	}
+
+	newControllerConfig := func(proxy *configv1.ProxyStatus, bundle string) *mcfgv1.ControllerConfig {
+		return &mcfgv1.ControllerConfig{
+			ObjectMeta: metav1.ObjectMeta{Name: ctrlcommon.ControllerConfigName},
+			Spec: mcfgv1.ControllerConfigSpec{
+				Proxy:                 proxy,
+				AdditionalTrustBundle: []byte(bundle),
+			},
+		}
+	}
+
+	getTrustedCA := func(t *testing.T, ctrl *Controller) string {
+		cm, err := ctrl.kubeclient.CoreV1().ConfigMaps(ctrlcommon.MCONamespace).Get(context.TODO(), BuildTrustedCAConfigMapName, metav1.GetOptions{})
+		require.NoError(t, err)
+		return cm.Data[buildTrustedCAConfigMapKey]
+	}
+
+	t.Run("No ControllerConfig", func(t *testing.T) {
+		t.Parallel()
+
+		bp, err := newController().getBuildProxy()
+		assert.NoError(t, err)
+		assert.Equal(t, buildProxy{}, bp)
+	})
+
+	t.Run("No proxy or trusted CA", func(t *testing.T) {
+		t.Parallel()
+
+		ctrl := newController(newControllerConfig(nil, ""))
+		bp, err := ctrl.getBuildProxy()
+		assert.NoError(t, err)
+		assert.Equal(t, buildProxy{}, bp)
+
+		_, err = ctrl.kubeclient.CoreV1().ConfigMaps(ctrlcommon.MCONamespace).Get(context.TODO(), BuildTrustedCAConfigMapName, metav1.GetOptions{})
+		assert.Error(t, err)
+	})
+
+	t.Run("Proxy and trusted CA", func(t *testing.T) {
+		t.Parallel()
+
+		proxy := &configv1.ProxyStatus{HTTPProxy: "http://proxy.example.com:3128"}
+		ctrl := newController(newControllerConfig(proxy, "ca-1"))
+
+		bp, err := ctrl.getBuildProxy()
+		assert.NoError(t, err)
+		assert.Equal(t, buildProxy{Proxy: *proxy, HasTrustedCA: true}, bp)
+		assert.Equal(t, "ca-1", getTrustedCA(t, ctrl))
+
+		// The trusted CA bundle is updated when it changes.
+		cc := newControllerConfig(proxy, "ca-2")
+		_, err = ctrl.mcfgclient.MachineconfigurationV1().ControllerConfigs().Update(context.TODO(), cc, metav1.UpdateOptions{})
+		require.NoError(t, err)
+
+		_, err = ctrl.getBuildProxy()
+		assert.NoError(t, err)
+		assert.Equal(t, "ca-2", getTrustedCA(t, ctrl))
+	})
+}
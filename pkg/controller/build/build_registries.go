@@ -0,0 +1,198 @@
+package build
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// The path of the registries config that the container runtime config
+	// controller renders the cluster's ImageDigestMirrorSets,
+	// ImageTagMirrorSets and ImageContentSourcePolicies into.
+	buildRegistriesConfigPath = "/etc/containers/registries.conf"
+
+	// The key in the Dockerfile ConfigMap which holds the registries config,
+	// which the image-build container sees in the Dockerfile mountpoint.
+	buildRegistriesConfigMapKey = "registries.conf"
+	buildRegistriesConfigFile   = "/tmp/dockerfile/" + buildRegistriesConfigMapKey
+
+	// The name of the ConfigMap which the build controller keeps in sync with
+	// the cluster's registry CAs so that they can be mounted into custom build
+	// pods. Its keys are the registries in host..port form.
+	BuildRegistryCAsConfigMapName = "machine-os-builder-registry-cas"
+
+	buildRegistryCAsVolumeName = "registry-cas"
+	buildRegistryCAsMountpoint = "/etc/containers/certs.d"
+)
+
+// Gets the registries config from the rendered MachineConfig, if there is one.
+// Building with the same registries config as the nodes means that the FROM
+// lines resolve through the cluster's mirrors, so that builds work in
+// disconnected clusters.
+func getBuildRegistriesConfig(mc *mcfgv1.MachineConfig) (string, error) {
+	if mc == nil || mc.Spec.Config.Raw == nil {
+		return "", nil
+	}
+
+	ignCfg, err := ctrlcommon.ParseAndConvertConfig(mc.Spec.Config.Raw)
+	if err != nil {
+		return "", fmt.Errorf("could not parse MachineConfig %s: %w", mc.Name, err)
+	}
+
+	data, err := ctrlcommon.GetIgnitionFileDataByPath(&ignCfg, buildRegistriesConfigPath)
+	if err != nil {
+		return "", fmt.Errorf("could not get %s from MachineConfig %s: %w", buildRegistriesConfigPath, mc.Name, err)
+	}
+
+	return string(data), nil
+}
+
+// Gets the environment variable which points the builder at the registries
+// config, if there is one. Buildah and Podman read it from
+// CONTAINERS_REGISTRIES_CONF instead of /etc/containers/registries.conf.
+func (i ImageBuildRequest) registriesConfigEnv() []corev1.EnvVar {
+	if i.RegistriesConfig == "" {
+		return nil
+	}
+
+	return []corev1.EnvVar{
+		{
+			Name:  "CONTAINERS_REGISTRIES_CONF",
+			Value: buildRegistriesConfigFile,
+		},
+	}
+}
+
+// Gets the registry CAs from the ControllerConfig, which the operator
+// populates from the image registry operator's CA and the additionalTrustedCA
+// of the cluster image config. They are copied into the
+// BuildRegistryCAsConfigMapName ConfigMap in the MCO namespace so that build
+// pods can mount them. Returns the CAs keyed by registry in the host..port
+// form of the ConfigMap keys.
+func (ctrl *Controller) getBuildRegistryCAs() (map[string]string, error) {
+	cc, err := ctrl.mcfgclient.MachineconfigurationV1().ControllerConfigs().Get(context.TODO(), ctrlcommon.ControllerConfigName, metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		klog.V(4).Infof("ControllerConfig %s not found, builds will not use any registry CAs", ctrlcommon.ControllerConfigName)
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("could not get ControllerConfig %s: %w", ctrlcommon.ControllerConfigName, err)
+	}
+
+	// The user-provided CAs take precedence, as they do on the nodes.
+	cas := map[string]string{}
+	for _, bundle := range append(cc.Spec.ImageRegistryBundleData, cc.Spec.ImageRegistryBundleUserData...) {
+		cas[bundle.File] = string(bundle.Data)
+	}
+
+	if len(cas) == 0 {
+		return nil, nil
+	}
+
+	if err := ctrl.syncBuildRegistryCAs(cas); err != nil {
+		return nil, err
+	}
+
+	return cas, nil
+}
+
+// Creates or updates the BuildRegistryCAsConfigMapName ConfigMap with the
+// given registry CAs.
+func (ctrl *Controller) syncBuildRegistryCAs(cas map[string]string) error {
+	cmClient := ctrl.kubeclient.CoreV1().ConfigMaps(ctrlcommon.MCONamespace)
+
+	cm, err := cmClient.Get(context.TODO(), BuildRegistryCAsConfigMapName, metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		cm = &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      BuildRegistryCAsConfigMapName,
+				Namespace: ctrlcommon.MCONamespace,
+			},
+			Data: cas,
+		}
+
+		if _, err := cmClient.Create(context.TODO(), cm, metav1.CreateOptions{}); err != nil {
+			return fmt.Errorf("could not create ConfigMap %s: %w", BuildRegistryCAsConfigMapName, err)
+		}
+
+		klog.Infof("Created ConfigMap %s with the registry CAs", BuildRegistryCAsConfigMapName)
+		return nil
+	}
+
+	if err != nil {
+		return fmt.Errorf("could not get ConfigMap %s: %w", BuildRegistryCAsConfigMapName, err)
+	}
+
+	if reflect.DeepEqual(cm.Data, cas) {
+		return nil
+	}
+
+	cm = cm.DeepCopy()
+	cm.Data = cas
+
+	if _, err := cmClient.Update(context.TODO(), cm, metav1.UpdateOptions{}); err != nil {
+		return fmt.Errorf("could not update ConfigMap %s: %w", BuildRegistryCAsConfigMapName, err)
+	}
+
+	klog.Infof("Updated ConfigMap %s with the registry CAs", BuildRegistryCAsConfigMapName)
+	return nil
+}
+
+// Gets the host[:port] directory name which Buildah and skopeo look up the CA
+// of a registry in from the host..port form used by the ConfigMap keys.
+func getRegistryCertDirName(registry string) string {
+	return strings.ReplaceAll(registry, "..", ":")
+}
+
+// Mounts the registry CAs into the image-build container, which pulls the
+// base images, and the wait-for-done container, which copies the final image
+// to any additional registries. Both look up the CA of each registry in
+// /etc/containers/certs.d/<host[:port]>/ca.crt.
+func (i ImageBuildRequest) addRegistryCAs(pod *corev1.Pod) {
+	if len(i.RegistryCAs) == 0 {
+		return
+	}
+
+	items := []corev1.KeyToPath{}
+	for _, registry := range i.RegistryCAs {
+		items = append(items, corev1.KeyToPath{
+			Key:  registry,
+			Path: filepath.Join(getRegistryCertDirName(registry), "ca.crt"),
+		})
+	}
+
+	for idx := range pod.Spec.Containers {
+		container := &pod.Spec.Containers[idx]
+		if container.Name != "image-build" && container.Name != "wait-for-done" {
+			continue
+		}
+
+		container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+			Name:      buildRegistryCAsVolumeName,
+			MountPath: buildRegistryCAsMountpoint,
+		})
+	}
+
+	pod.Spec.Volumes = append(pod.Spec.Volumes, corev1.Volume{
+		Name: buildRegistryCAsVolumeName,
+		VolumeSource: corev1.VolumeSource{
+			ConfigMap: &corev1.ConfigMapVolumeSource{
+				LocalObjectReference: corev1.LocalObjectReference{
+					Name: BuildRegistryCAsConfigMapName,
+				},
+				Items: items,
+			},
+		},
+	})
+}
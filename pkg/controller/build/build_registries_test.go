@@ -0,0 +1,179 @@
+package build
+
+import (
+	"context"
+	"testing"
+
+	ign3types "github.com/coreos/ignition/v2/config/v3_4/types"
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	fakeclientmachineconfigv1 "github.com/openshift/client-go/machineconfiguration/clientset/versioned/fake"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	testhelpers "github.com/openshift/machine-config-operator/test/helpers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	fakecorev1client "k8s.io/client-go/kubernetes/fake"
+)
+
+const testRegistriesConfig = `unqualified-search-registries = ["registry.access.redhat.com", "docker.io"]
+
+[[registry]]
+  prefix = ""
+  location = "quay.io/openshift-release-dev/ocp-v4.0-art-dev"
+
+  [[registry.mirror]]
+    location = "mirror.example.com/ocp-v4.0-art-dev"
+    pull-from-mirror = "digest-only"
+`
+
+func TestGetBuildRegistriesConfig(t *testing.T) {
+	t.Parallel()
+
+	withRegistries := testhelpers.NewMachineConfig("rendered-worker-1", nil, "", []ign3types.File{
+		ctrlcommon.NewIgnFile(buildRegistriesConfigPath, testRegistriesConfig),
+	})
+
+	registriesConfig, err := getBuildRegistriesConfig(withRegistries)
+	assert.NoError(t, err)
+	assert.Equal(t, testRegistriesConfig, registriesConfig)
+
+	withoutRegistries := testhelpers.NewMachineConfig("rendered-worker-1", nil, "", []ign3types.File{
+		ctrlcommon.NewIgnFile("/etc/hello", "hello"),
+	})
+
+	registriesConfig, err = getBuildRegistriesConfig(withoutRegistries)
+	assert.NoError(t, err)
+	assert.Empty(t, registriesConfig)
+}
+
+func TestBuildRegistriesConfig(t *testing.T) {
+	t.Parallel()
+
+	getEnv := func(ibr ImageBuildRequest, name string) map[string]string {
+		out := map[string]string{}
+		for _, container := range ibr.toBuildPod().Spec.Containers {
+			if container.Name != name {
+				continue
+			}
+
+			for _, env := range container.Env {
+				out[env.Name] = env.Value
+			}
+		}
+		return out
+	}
+
+	ibr := newImageBuildRequest(newMachineConfigPool("worker"))
+
+	cm, err := ibr.dockerfileToConfigMap()
+	require.NoError(t, err)
+	assert.NotContains(t, cm.Data, buildRegistriesConfigMapKey)
+	assert.NotContains(t, getEnv(ibr, "image-build"), "CONTAINERS_REGISTRIES_CONF")
+
+	ibr.RegistriesConfig = testRegistriesConfig
+
+	cm, err = ibr.dockerfileToConfigMap()
+	require.NoError(t, err)
+	assert.Equal(t, testRegistriesConfig, cm.Data[buildRegistriesConfigMapKey])
+	assert.Equal(t, buildRegistriesConfigFile, getEnv(ibr, "image-build")["CONTAINERS_REGISTRIES_CONF"])
+	assert.NotContains(t, getEnv(ibr, "wait-for-done"), "CONTAINERS_REGISTRIES_CONF")
+}
+
+// Tests that the registry CAs are read from the ControllerConfig, with the
+// user-provided CAs taking precedence, and that they are kept in sync.
+func TestGetBuildRegistryCAs(t *testing.T) {
+	t.Parallel()
+
+	ctrl := &Controller{
+		Clients: &Clients{
+			kubeclient: fakecorev1client.NewSimpleClientset(),
+			mcfgclient: fakeclientmachineconfigv1.NewSimpleClientset(),
+		},
+	}
+
+	cas, err := ctrl.getBuildRegistryCAs()
+	assert.NoError(t, err)
+	assert.Empty(t, cas)
+
+	cc := &mcfgv1.ControllerConfig{
+		ObjectMeta: metav1.ObjectMeta{Name: ctrlcommon.ControllerConfigName},
+		Spec: mcfgv1.ControllerConfigSpec{
+			ImageRegistryBundleData: []mcfgv1.ImageRegistryBundle{
+				{File: "image-registry.openshift-image-registry.svc..5000", Data: []byte("image-registry-ca")},
+				{File: "mirror.example.com", Data: []byte("old-mirror-ca")},
+			},
+			ImageRegistryBundleUserData: []mcfgv1.ImageRegistryBundle{
+				{File: "mirror.example.com", Data: []byte("mirror-ca")},
+			},
+		},
+	}
+
+	_, err = ctrl.mcfgclient.MachineconfigurationV1().ControllerConfigs().Create(context.TODO(), cc, metav1.CreateOptions{})
+	require.NoError(t, err)
+
+	expected := map[string]string{
+		"image-registry.openshift-image-registry.svc..5000": "image-registry-ca",
+		"mirror.example.com": "mirror-ca",
+	}
+
+	getConfigMapData := func() map[string]string {
+		cm, err := ctrl.kubeclient.CoreV1().ConfigMaps(ctrlcommon.MCONamespace).Get(context.TODO(), BuildRegistryCAsConfigMapName, metav1.GetOptions{})
+		require.NoError(t, err)
+		return cm.Data
+	}
+
+	cas, err = ctrl.getBuildRegistryCAs()
+	assert.NoError(t, err)
+	assert.Equal(t, expected, cas)
+	assert.Equal(t, expected, getConfigMapData())
+
+	// Removed CAs are removed from the ConfigMap.
+	cc.Spec.ImageRegistryBundleUserData = nil
+	cc.Spec.ImageRegistryBundleData = cc.Spec.ImageRegistryBundleData[:1]
+	_, err = ctrl.mcfgclient.MachineconfigurationV1().ControllerConfigs().Update(context.TODO(), cc, metav1.UpdateOptions{})
+	require.NoError(t, err)
+
+	expected = map[string]string{
+		"image-registry.openshift-image-registry.svc..5000": "image-registry-ca",
+	}
+
+	cas, err = ctrl.getBuildRegistryCAs()
+	assert.NoError(t, err)
+	assert.Equal(t, expected, cas)
+	assert.Equal(t, expected, getConfigMapData())
+}
+
+// Tests that the registry CAs are mounted into the containers which talk to
+// registries, in the layout which Buildah and skopeo expect.
+func TestImageBuildRequestRegistryCAs(t *testing.T) {
+	t.Parallel()
+
+	ibr := newImageBuildRequest(newMachineConfigPool("worker"))
+	ibr.RegistryCAs = []string{"image-registry.openshift-image-registry.svc..5000", "mirror.example.com"}
+
+	pod := ibr.toBuildPod()
+
+	for _, container := range pod.Spec.Containers {
+		mountpoints := []string{}
+		for _, volumeMount := range container.VolumeMounts {
+			mountpoints = append(mountpoints, volumeMount.MountPath)
+		}
+
+		assert.Contains(t, mountpoints, buildRegistryCAsMountpoint, container.Name)
+	}
+
+	var volume *corev1.Volume
+	for idx := range pod.Spec.Volumes {
+		if pod.Spec.Volumes[idx].Name == buildRegistryCAsVolumeName {
+			volume = &pod.Spec.Volumes[idx]
+		}
+	}
+
+	require.NotNil(t, volume)
+	assert.Equal(t, BuildRegistryCAsConfigMapName, volume.ConfigMap.Name)
+	assert.Equal(t, []corev1.KeyToPath{
+		{Key: "image-registry.openshift-image-registry.svc..5000", Path: "image-registry.openshift-image-registry.svc:5000/ca.crt"},
+		{Key: "mirror.example.com", Path: "mirror.example.com/ca.crt"},
+	}, volume.ConfigMap.Items)
+}
@@ -0,0 +1,77 @@
+package build
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// The on-cluster-build-config ConfigMap keys which contain the compute
+// resources (e.g., "500m" or "4Gi") to request for and limit the build to.
+// These apply to the image build container of the custom build pod as well as
+// to the OpenShift Image Builder build. Unset values are left to the cluster
+// defaults.
+const (
+	BuildCPURequestConfigKey    = "buildCPURequest"
+	BuildMemoryRequestConfigKey = "buildMemoryRequest"
+	BuildCPULimitConfigKey      = "buildCPULimit"
+	BuildMemoryLimitConfigKey   = "buildMemoryLimit"
+)
+
+// Gets the build resource requests and limits from the on-cluster-build-config
+// ConfigMap.
+func getBuildResources(cm *corev1.ConfigMap) (corev1.ResourceRequirements, error) {
+	out := corev1.ResourceRequirements{}
+
+	if cm == nil {
+		return out, nil
+	}
+
+	keys := []struct {
+		key          string
+		resourceName corev1.ResourceName
+		isLimit      bool
+	}{
+		{BuildCPURequestConfigKey, corev1.ResourceCPU, false},
+		{BuildMemoryRequestConfigKey, corev1.ResourceMemory, false},
+		{BuildCPULimitConfigKey, corev1.ResourceCPU, true},
+		{BuildMemoryLimitConfigKey, corev1.ResourceMemory, true},
+	}
+
+	for _, k := range keys {
+		val, ok := cm.Data[k.key]
+		if !ok || val == "" {
+			continue
+		}
+
+		quantity, err := resource.ParseQuantity(val)
+		if err != nil {
+			return corev1.ResourceRequirements{}, fmt.Errorf("could not parse %s %q: %w", k.key, val, err)
+		}
+
+		if quantity.Sign() <= 0 {
+			return corev1.ResourceRequirements{}, fmt.Errorf("%s %q must be positive", k.key, val)
+		}
+
+		list := &out.Requests
+		if k.isLimit {
+			list = &out.Limits
+		}
+
+		if *list == nil {
+			*list = corev1.ResourceList{}
+		}
+
+		(*list)[k.resourceName] = quantity
+	}
+
+	for resourceName, request := range out.Requests {
+		limit, ok := out.Limits[resourceName]
+		if ok && request.Cmp(limit) > 0 {
+			return corev1.ResourceRequirements{}, fmt.Errorf("build %s request %s must not exceed its limit %s", resourceName, request.String(), limit.String())
+		}
+	}
+
+	return out, nil
+}
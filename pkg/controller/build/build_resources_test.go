@@ -0,0 +1,95 @@
+package build
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// Tests that the build resource requests and limits are read from the
+// on-cluster-build-config ConfigMap.
+func TestGetBuildResources(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name        string
+		data        map[string]string
+		expected    corev1.ResourceRequirements
+		expectError bool
+	}{
+		{
+			name: "unset",
+		},
+		{
+			name: "requests and limits set",
+			data: map[string]string{
+				BuildCPURequestConfigKey:    "500m",
+				BuildMemoryRequestConfigKey: "2Gi",
+				BuildCPULimitConfigKey:      "2",
+				BuildMemoryLimitConfigKey:   "8Gi",
+			},
+			expected: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{
+					corev1.ResourceCPU:    resource.MustParse("500m"),
+					corev1.ResourceMemory: resource.MustParse("2Gi"),
+				},
+				Limits: corev1.ResourceList{
+					corev1.ResourceCPU:    resource.MustParse("2"),
+					corev1.ResourceMemory: resource.MustParse("8Gi"),
+				},
+			},
+		},
+		{
+			name: "only memory limit set",
+			data: map[string]string{
+				BuildMemoryLimitConfigKey: "8Gi",
+			},
+			expected: corev1.ResourceRequirements{
+				Limits: corev1.ResourceList{
+					corev1.ResourceMemory: resource.MustParse("8Gi"),
+				},
+			},
+		},
+		{
+			name:        "invalid quantity",
+			data:        map[string]string{BuildMemoryRequestConfigKey: "lots"},
+			expectError: true,
+		},
+		{
+			name:        "zero quantity",
+			data:        map[string]string{BuildCPULimitConfigKey: "0"},
+			expectError: true,
+		},
+		{
+			name: "request exceeds limit",
+			data: map[string]string{
+				BuildMemoryRequestConfigKey: "8Gi",
+				BuildMemoryLimitConfigKey:   "4Gi",
+			},
+			expectError: true,
+		},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			cm := getOnClusterBuildConfigMap()
+			for k, v := range testCase.data {
+				cm.Data[k] = v
+			}
+
+			resources, err := getBuildResources(cm)
+			if testCase.expectError {
+				assert.Error(t, err)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, testCase.expected, resources)
+		})
+	}
+}
@@ -0,0 +1,143 @@
+package build
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	"github.com/openshift/machine-config-operator/pkg/apihelpers"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// The on-cluster-build-config ConfigMap key which contains the maximum
+	// number of times a failed build will be automatically retried. Defaults to
+	// zero, meaning failed builds are not retried.
+	BuildMaxRetriesConfigKey = "buildMaxRetries"
+
+	// The on-cluster-build-config ConfigMap key which contains the initial delay
+	// before a failed build is retried (e.g., "30s"). The delay doubles with
+	// each subsequent retry.
+	BuildRetryBackoffConfigKey = "buildRetryBackoff"
+
+	// Annotation on the MachineConfigPool which records how many times the build
+	// for the current rendered MachineConfig has been retried.
+	BuildRetryCountAnnotationKey = "machineconfiguration.openshift.io/build-retry-count"
+
+	// Condition reason used on the BuildFailed condition while a retry is
+	// pending.
+	buildFailedRetryingReason = "BuildFailedRetrying"
+
+	defaultBuildRetryBackoff = 30 * time.Second
+	maxBuildRetryBackoff     = 10 * time.Minute
+)
+
+// Describes how failed builds are retried.
+type buildRetryPolicy struct {
+	maxRetries int
+	backoff    time.Duration
+}
+
+// Gets the build retry policy from the on-cluster-build-config ConfigMap,
+// falling back to the defaults for any keys that are not set.
+func getBuildRetryPolicy(cm *corev1.ConfigMap) (buildRetryPolicy, error) {
+	policy := buildRetryPolicy{
+		maxRetries: 0,
+		backoff:    defaultBuildRetryBackoff,
+	}
+
+	if cm == nil {
+		return policy, nil
+	}
+
+	if val, ok := cm.Data[BuildMaxRetriesConfigKey]; ok && val != "" {
+		maxRetries, err := strconv.Atoi(val)
+		if err != nil {
+			return policy, fmt.Errorf("could not parse %s %q: %w", BuildMaxRetriesConfigKey, val, err)
+		}
+
+		if maxRetries < 0 {
+			return policy, fmt.Errorf("%s %q must not be negative", BuildMaxRetriesConfigKey, val)
+		}
+
+		policy.maxRetries = maxRetries
+	}
+
+	if val, ok := cm.Data[BuildRetryBackoffConfigKey]; ok && val != "" {
+		backoff, err := time.ParseDuration(val)
+		if err != nil {
+			return policy, fmt.Errorf("could not parse %s %q: %w", BuildRetryBackoffConfigKey, val, err)
+		}
+
+		if backoff <= 0 {
+			return policy, fmt.Errorf("%s %q must be positive", BuildRetryBackoffConfigKey, val)
+		}
+
+		policy.backoff = backoff
+	}
+
+	return policy, nil
+}
+
+// Determines whether another retry is allowed after the given number of
+// retries have already been attempted.
+func (b buildRetryPolicy) canRetry(retryCount int) bool {
+	return retryCount < b.maxRetries
+}
+
+// Computes the delay before the given retry attempt (starting at 1). The
+// delay doubles with each attempt and is capped at maxBuildRetryBackoff.
+func (b buildRetryPolicy) backoffForAttempt(attempt int) time.Duration {
+	backoff := b.backoff
+	for i := 1; i < attempt; i++ {
+		backoff *= 2
+		if backoff >= maxBuildRetryBackoff {
+			return maxBuildRetryBackoff
+		}
+	}
+
+	if backoff > maxBuildRetryBackoff {
+		return maxBuildRetryBackoff
+	}
+
+	return backoff
+}
+
+// Gets the number of times the build for the current rendered MachineConfig
+// has been retried.
+func getBuildRetryCount(pool *mcfgv1.MachineConfigPool) int {
+	val, ok := pool.Annotations[BuildRetryCountAnnotationKey]
+	if !ok {
+		return 0
+	}
+
+	count, err := strconv.Atoi(val)
+	if err != nil || count < 0 {
+		return 0
+	}
+
+	return count
+}
+
+// Determines whether the pool has a failed build that is waiting to be
+// retried.
+func isBuildRetryPending(pool *mcfgv1.MachineConfigPool) bool {
+	cond := apihelpers.GetMachineConfigPoolCondition(pool.Status, mcfgv1.MachineConfigPoolBuildFailed)
+	return cond != nil && cond.Status == corev1.ConditionTrue && cond.Reason == buildFailedRetryingReason
+}
+
+// Computes how long to wait until the pending retry may start.
+func getBuildRetryDelay(pool *mcfgv1.MachineConfigPool, policy buildRetryPolicy, now time.Time) time.Duration {
+	cond := apihelpers.GetMachineConfigPoolCondition(pool.Status, mcfgv1.MachineConfigPoolBuildFailed)
+	if cond == nil {
+		return 0
+	}
+
+	retryAt := cond.LastTransitionTime.Add(policy.backoffForAttempt(getBuildRetryCount(pool) + 1))
+	if delay := retryAt.Sub(now); delay > 0 {
+		return delay
+	}
+
+	return 0
+}
@@ -0,0 +1,173 @@
+package build
+
+import (
+	"testing"
+	"time"
+
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	"github.com/openshift/machine-config-operator/pkg/apihelpers"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	fakecorev1client "k8s.io/client-go/kubernetes/fake"
+)
+
+// Tests that the build retry policy is read from the on-cluster-build-config
+// ConfigMap with the appropriate defaults.
+func TestGetBuildRetryPolicy(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name           string
+		data           map[string]string
+		expectedPolicy buildRetryPolicy
+		expectError    bool
+	}{
+		{
+			name:           "defaults",
+			expectedPolicy: buildRetryPolicy{maxRetries: 0, backoff: defaultBuildRetryBackoff},
+		},
+		{
+			name: "max retries and backoff set",
+			data: map[string]string{
+				BuildMaxRetriesConfigKey:   "3",
+				BuildRetryBackoffConfigKey: "1m",
+			},
+			expectedPolicy: buildRetryPolicy{maxRetries: 3, backoff: time.Minute},
+		},
+		{
+			name: "empty values use defaults",
+			data: map[string]string{
+				BuildMaxRetriesConfigKey:   "",
+				BuildRetryBackoffConfigKey: "",
+			},
+			expectedPolicy: buildRetryPolicy{maxRetries: 0, backoff: defaultBuildRetryBackoff},
+		},
+		{
+			name:        "invalid max retries",
+			data:        map[string]string{BuildMaxRetriesConfigKey: "three"},
+			expectError: true,
+		},
+		{
+			name:        "negative max retries",
+			data:        map[string]string{BuildMaxRetriesConfigKey: "-1"},
+			expectError: true,
+		},
+		{
+			name:        "invalid backoff",
+			data:        map[string]string{BuildRetryBackoffConfigKey: "soon"},
+			expectError: true,
+		},
+		{
+			name:        "zero backoff",
+			data:        map[string]string{BuildRetryBackoffConfigKey: "0s"},
+			expectError: true,
+		},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			cm := getOnClusterBuildConfigMap()
+			for k, v := range testCase.data {
+				cm.Data[k] = v
+			}
+
+			policy, err := getBuildRetryPolicy(cm)
+			if testCase.expectError {
+				assert.Error(t, err)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, testCase.expectedPolicy, policy)
+		})
+	}
+}
+
+// Tests that the build retry policy is read from the ConfigMap lister instead
+// of the API server, and that a missing ConfigMap yields the defaults.
+func TestGetBuildRetryPolicyFromLister(t *testing.T) {
+	t.Parallel()
+
+	cm := getOnClusterBuildConfigMap()
+	cm.Data[BuildMaxRetriesConfigKey] = "3"
+
+	kubeclient := fakecorev1client.NewSimpleClientset()
+
+	ctrl := &Controller{
+		Clients:         &Clients{kubeclient: kubeclient},
+		configMapLister: newConfigMapLister(t, cm),
+	}
+
+	policy, err := ctrl.getBuildRetryPolicy()
+	assert.NoError(t, err)
+	assert.Equal(t, buildRetryPolicy{maxRetries: 3, backoff: defaultBuildRetryBackoff}, policy)
+	assert.Empty(t, kubeclient.Actions())
+
+	ctrl.configMapLister = newConfigMapLister(t)
+
+	policy, err = ctrl.getBuildRetryPolicy()
+	assert.NoError(t, err)
+	assert.Equal(t, buildRetryPolicy{maxRetries: 0, backoff: defaultBuildRetryBackoff}, policy)
+}
+
+// Tests that the backoff doubles with each attempt and is capped.
+func TestBuildRetryPolicyBackoff(t *testing.T) {
+	t.Parallel()
+
+	policy := buildRetryPolicy{maxRetries: 10, backoff: time.Minute}
+
+	assert.Equal(t, time.Minute, policy.backoffForAttempt(1))
+	assert.Equal(t, 2*time.Minute, policy.backoffForAttempt(2))
+	assert.Equal(t, 4*time.Minute, policy.backoffForAttempt(3))
+	assert.Equal(t, 8*time.Minute, policy.backoffForAttempt(4))
+	assert.Equal(t, maxBuildRetryBackoff, policy.backoffForAttempt(5))
+	assert.Equal(t, maxBuildRetryBackoff, policy.backoffForAttempt(50))
+
+	assert.Equal(t, maxBuildRetryBackoff, buildRetryPolicy{backoff: time.Hour}.backoffForAttempt(1))
+
+	assert.True(t, policy.canRetry(0))
+	assert.True(t, policy.canRetry(9))
+	assert.False(t, policy.canRetry(10))
+	assert.False(t, buildRetryPolicy{}.canRetry(0))
+}
+
+// Tests that the retry count and pending retry state are read from the
+// MachineConfigPool.
+func TestBuildRetryPoolState(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	policy := buildRetryPolicy{maxRetries: 3, backoff: time.Minute}
+
+	pool := newMachineConfigPool("worker")
+	assert.Equal(t, 0, getBuildRetryCount(pool))
+	assert.False(t, isBuildRetryPending(pool))
+	assert.Equal(t, time.Duration(0), getBuildRetryDelay(pool, policy, now))
+
+	ps := newPoolState(pool)
+	ps.SetBuildRetryCount(1)
+
+	cond := apihelpers.NewMachineConfigPoolCondition(mcfgv1.MachineConfigPoolBuildFailed, corev1.ConditionTrue, buildFailedRetryingReason, "")
+	cond.LastTransitionTime = metav1.NewTime(now.Add(-30 * time.Second))
+	apihelpers.SetMachineConfigPoolCondition(&ps.pool.Status, *cond)
+
+	pool = ps.MachineConfigPool()
+	assert.Equal(t, 1, getBuildRetryCount(pool))
+	assert.True(t, isBuildRetryPending(pool))
+	// The second retry waits for twice the backoff after the failure.
+	assert.Equal(t, 90*time.Second, getBuildRetryDelay(pool, policy, now))
+	assert.Equal(t, time.Duration(0), getBuildRetryDelay(pool, policy, now.Add(5*time.Minute)))
+
+	ps.SetBuildRetryCount(0)
+	_, ok := ps.MachineConfigPool().Annotations[BuildRetryCountAnnotationKey]
+	assert.False(t, ok)
+
+	// A terminal build failure is not pending retry.
+	terminal := apihelpers.NewMachineConfigPoolCondition(mcfgv1.MachineConfigPoolBuildFailed, corev1.ConditionTrue, "BuildFailed", "")
+	apihelpers.SetMachineConfigPoolCondition(&ps.pool.Status, *terminal)
+	assert.False(t, isBuildRetryPending(ps.MachineConfigPool()))
+}
@@ -0,0 +1,110 @@
+package build
+
+import (
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// The on-cluster-build-config ConfigMap key which contains the node
+	// selector for the build, one "<key>=<value>" pair per line (e.g.,
+	// "node-role.kubernetes.io/builder="). This applies to both image builders.
+	BuildNodeSelectorConfigKey = "buildNodeSelector"
+
+	// The on-cluster-build-config ConfigMap key which contains a YAML or JSON
+	// list of tolerations for the custom build pod. The OpenShift Image Builder
+	// does not support per-build tolerations; use the cluster-wide build
+	// overrides (builds.config.openshift.io/cluster) for it instead.
+	BuildTolerationsConfigKey = "buildTolerations"
+
+	// The on-cluster-build-config ConfigMap key which contains the YAML or JSON
+	// affinity for the custom build pod. As with BuildTolerationsConfigKey, this
+	// does not apply to the OpenShift Image Builder.
+	BuildAffinityConfigKey = "buildAffinity"
+)
+
+// Describes where the build may be scheduled.
+type buildScheduling struct {
+	NodeSelector map[string]string
+	Tolerations  []corev1.Toleration
+	Affinity     *corev1.Affinity
+}
+
+// Determines whether any pod-only scheduling constraints are set, which the
+// OpenShift Image Builder cannot apply.
+func (b buildScheduling) hasPodOnlyConstraints() bool {
+	return len(b.Tolerations) != 0 || b.Affinity != nil
+}
+
+// Gets the build scheduling constraints from the on-cluster-build-config
+// ConfigMap.
+func getBuildScheduling(cm *corev1.ConfigMap) (buildScheduling, error) {
+	out := buildScheduling{}
+
+	if cm == nil {
+		return out, nil
+	}
+
+	nodeSelector, err := parseBuildNodeSelector(cm.Data[BuildNodeSelectorConfigKey])
+	if err != nil {
+		return out, err
+	}
+
+	out.NodeSelector = nodeSelector
+
+	if val := cm.Data[BuildTolerationsConfigKey]; strings.TrimSpace(val) != "" {
+		if err := yaml.UnmarshalStrict([]byte(val), &out.Tolerations); err != nil {
+			return buildScheduling{}, fmt.Errorf("could not parse %s: %w", BuildTolerationsConfigKey, err)
+		}
+	}
+
+	if val := cm.Data[BuildAffinityConfigKey]; strings.TrimSpace(val) != "" {
+		out.Affinity = &corev1.Affinity{}
+		if err := yaml.UnmarshalStrict([]byte(val), out.Affinity); err != nil {
+			return buildScheduling{}, fmt.Errorf("could not parse %s: %w", BuildAffinityConfigKey, err)
+		}
+	}
+
+	return out, nil
+}
+
+// Parses the node selector, returning nil when none is set so that the
+// OpenShift Image Builder falls back to its cluster-wide default.
+func parseBuildNodeSelector(val string) (map[string]string, error) {
+	var out map[string]string
+
+	for _, line := range strings.Split(val, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("could not parse %s entry %q: expected <key>=<value>", BuildNodeSelectorConfigKey, line)
+		}
+
+		key = strings.TrimSpace(key)
+		value = strings.TrimSpace(value)
+
+		if errs := validation.IsQualifiedName(key); len(errs) != 0 {
+			return nil, fmt.Errorf("invalid %s key %q: %s", BuildNodeSelectorConfigKey, key, strings.Join(errs, ", "))
+		}
+
+		if errs := validation.IsValidLabelValue(value); len(errs) != 0 {
+			return nil, fmt.Errorf("invalid %s value %q for %q: %s", BuildNodeSelectorConfigKey, value, key, strings.Join(errs, ", "))
+		}
+
+		if out == nil {
+			out = map[string]string{}
+		}
+
+		out[key] = value
+	}
+
+	return out, nil
+}
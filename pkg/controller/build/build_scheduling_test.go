@@ -0,0 +1,104 @@
+package build
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Tests that the build scheduling constraints are read from the
+// on-cluster-build-config ConfigMap.
+func TestGetBuildScheduling(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name        string
+		data        map[string]string
+		expected    buildScheduling
+		expectError bool
+	}{
+		{
+			name: "unset",
+		},
+		{
+			name: "all set",
+			data: map[string]string{
+				BuildNodeSelectorConfigKey: "node-role.kubernetes.io/builder=\n  disktype = ssd\n",
+				BuildTolerationsConfigKey:  "- key: node-role.kubernetes.io/builder\n  operator: Exists\n  effect: NoSchedule\n",
+				BuildAffinityConfigKey:     `{"nodeAffinity": {"requiredDuringSchedulingIgnoredDuringExecution": {"nodeSelectorTerms": [{"matchExpressions": [{"key": "kubernetes.io/arch", "operator": "In", "values": ["amd64"]}]}]}}}`,
+			},
+			expected: buildScheduling{
+				NodeSelector: map[string]string{
+					"node-role.kubernetes.io/builder": "",
+					"disktype":                        "ssd",
+				},
+				Tolerations: []corev1.Toleration{
+					{
+						Key:      "node-role.kubernetes.io/builder",
+						Operator: corev1.TolerationOpExists,
+						Effect:   corev1.TaintEffectNoSchedule,
+					},
+				},
+				Affinity: &corev1.Affinity{
+					NodeAffinity: &corev1.NodeAffinity{
+						RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{
+							NodeSelectorTerms: []corev1.NodeSelectorTerm{
+								{
+									MatchExpressions: []corev1.NodeSelectorRequirement{
+										{
+											Key:      "kubernetes.io/arch",
+											Operator: corev1.NodeSelectorOpIn,
+											Values:   []string{"amd64"},
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			name:        "node selector missing value",
+			data:        map[string]string{BuildNodeSelectorConfigKey: "disktype"},
+			expectError: true,
+		},
+		{
+			name:        "node selector invalid key",
+			data:        map[string]string{BuildNodeSelectorConfigKey: "disk type=ssd"},
+			expectError: true,
+		},
+		{
+			name:        "tolerations unknown field",
+			data:        map[string]string{BuildTolerationsConfigKey: "- key: builder\n  operater: Exists\n"},
+			expectError: true,
+		},
+		{
+			name:        "affinity not an object",
+			data:        map[string]string{BuildAffinityConfigKey: "- nodeAffinity"},
+			expectError: true,
+		},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			cm := getOnClusterBuildConfigMap()
+			for k, v := range testCase.data {
+				cm.Data[k] = v
+			}
+
+			scheduling, err := getBuildScheduling(cm)
+			if testCase.expectError {
+				assert.Error(t, err)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, testCase.expected, scheduling)
+		})
+	}
+}
@@ -0,0 +1,266 @@
+package build
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// Annotation on the MachineConfigPool and its build object which records
+	// the resourceVersion of each Secret the build was started with as a JSON
+	// object of Secret name to resourceVersion.
+	BuildSecretVersionsAnnotationKey = "machineconfiguration.openshift.io/build-secret-versions"
+
+	// Event reason used when a build is restarted because a Secret it uses
+	// was rotated.
+	buildSecretRotatedReason = "BuildSecretRotated"
+)
+
+// Gets the names of the Secrets which the build authenticates with from the
+// on-cluster-build-config ConfigMap. A generated push secret is left out since
+// the build controller refreshes it before each build and kubelet updates it
+// within running builds.
+func getBuildSecretNames(cm *corev1.ConfigMap) []string {
+	names := sets.NewString()
+
+	keys := []string{
+		BaseImagePullSecretNameConfigKey,
+		ImageSigningSecretNameConfigKey,
+		RemoteBuilderTLSSecretNameConfigKey,
+		ExternalBuildServiceSecretNameConfigKey,
+	}
+
+	if cm.Data[FinalImagePushCredentialsProviderConfigKey] == "" {
+		keys = append(keys, FinalImagePushSecretNameConfigKey)
+	}
+
+	for _, key := range keys {
+		if name := cm.Data[key]; name != "" {
+			names.Insert(name)
+		}
+	}
+
+	// An invalid config is reported when the build is started.
+	if additionalFinalImages, err := getAdditionalFinalImages(cm); err == nil {
+		for _, image := range additionalFinalImages {
+			names.Insert(image.PushSecretName)
+		}
+	}
+
+	return names.List()
+}
+
+// Gets the current resourceVersion of each Secret the build authenticates
+// with. Secrets which do not exist are left out; a build cannot be started
+// without them.
+func (ctrl *Controller) getBuildSecretVersions(cm *corev1.ConfigMap) (map[string]string, error) {
+	out := map[string]string{}
+
+	for _, name := range getBuildSecretNames(cm) {
+		secret, err := ctrl.kubeclient.CoreV1().Secrets(ctrlcommon.MCONamespace).Get(context.TODO(), name, metav1.GetOptions{})
+		if k8serrors.IsNotFound(err) {
+			continue
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("could not get secret %s: %w", name, err)
+		}
+
+		out[name] = secret.ResourceVersion
+	}
+
+	return out, nil
+}
+
+// Encodes the Secret resourceVersions for the build secret versions
+// annotation.
+func encodeBuildSecretVersions(versions map[string]string) string {
+	if len(versions) == 0 {
+		return ""
+	}
+
+	// A map of strings always serializes.
+	out, _ := json.Marshal(versions)
+	return string(out)
+}
+
+// Gets the Secret resourceVersions which the current build for the pool was
+// started with. Returns nil if they were not recorded.
+func getRecordedBuildSecretVersions(pool *mcfgv1.MachineConfigPool) map[string]string {
+	val, ok := pool.Annotations[BuildSecretVersionsAnnotationKey]
+	if !ok || val == "" {
+		return nil
+	}
+
+	out := map[string]string{}
+	if err := json.Unmarshal([]byte(val), &out); err != nil {
+		klog.Warningf("Could not parse %s annotation on pool %s: %s", BuildSecretVersionsAnnotationKey, pool.Name, err)
+		return nil
+	}
+
+	return out
+}
+
+// Gets the names of the Secrets which were rotated since the build was
+// started. Secrets which the build did not use are ignored.
+func getRotatedBuildSecrets(recorded, current map[string]string) []string {
+	rotated := []string{}
+
+	for name, version := range recorded {
+		if currentVersion, ok := current[name]; ok && currentVersion != version {
+			rotated = append(rotated, name)
+		}
+	}
+
+	sort.Strings(rotated)
+
+	return rotated
+}
+
+// Records the Secret resourceVersions which the build for the pool is started
+// with.
+func (ctrl *Controller) setBuildSecretVersions(ps *poolState, versions map[string]string) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		mcp, err := ctrl.mcfgclient.MachineconfigurationV1().MachineConfigPools().Get(context.TODO(), ps.Name(), metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+
+		ps := newPoolState(mcp)
+		ps.SetBuildSecretVersions(versions)
+
+		_, err = ctrl.mcfgclient.MachineconfigurationV1().MachineConfigPools().Update(context.TODO(), ps.pool, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+// Determines whether any Secret the pending, running, or failed build for the
+// given pool used was rotated since it started and, if so, discards the build
+// so that it is started again with the current credentials. Returns true if
+// the build was discarded.
+func (ctrl *Controller) restartBuildIfSecretsRotated(ps *poolState) (bool, error) {
+	pool := ps.MachineConfigPool()
+
+	recorded := getRecordedBuildSecretVersions(pool)
+	if recorded == nil {
+		return false, nil
+	}
+
+	cm, err := ctrl.configMapLister.ConfigMaps(ctrlcommon.MCONamespace).Get(OnClusterBuildConfigMapName)
+	if err != nil {
+		return false, fmt.Errorf("could not get build controller config %q: %w", OnClusterBuildConfigMapName, err)
+	}
+
+	current, err := ctrl.getBuildSecretVersions(cm)
+	if err != nil {
+		return false, err
+	}
+
+	rotated := getRotatedBuildSecrets(recorded, current)
+	if len(rotated) == 0 {
+		return false, nil
+	}
+
+	klog.Infof("Secret(s) %s used by the build for MachineConfigPool %s were rotated, restarting build", strings.Join(rotated, ", "), ps.Name())
+
+	if err := ctrl.postBuildCleanup(pool, true); err != nil {
+		return false, fmt.Errorf("could not clean up build for pool %s: %w", ps.Name(), err)
+	}
+
+	err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		mcp, err := ctrl.mcfgclient.MachineconfigurationV1().MachineConfigPools().Get(context.TODO(), ps.Name(), metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+
+		ps := newPoolState(mcp)
+
+		ps.ClearBuildFailure()
+		ps.DeleteBuildRefForCurrentMachineConfig()
+		ps.ClearAllBuildConditions()
+		ps.SetBuildRetryCount(0)
+		ps.SetBuildLogTail("")
+		ps.SetBuildSecretVersions(nil)
+
+		return ctrl.updatePoolAndSyncAvailableStatus(ps.MachineConfigPool())
+	})
+
+	if err != nil {
+		return false, fmt.Errorf("could not reset MachineConfigPool %s for restarted build: %w", ps.Name(), err)
+	}
+
+	ctrl.eventRecorder.Eventf(pool, corev1.EventTypeNormal, buildSecretRotatedReason, "Restarting build for config %s since secret(s) %s were rotated", ps.CurrentMachineConfig(), strings.Join(rotated, ", "))
+
+	// The next sync starts the build since the pool no longer has a build.
+	ctrl.enqueueMachineConfigPool(pool)
+
+	return true, nil
+}
+
+// Fires whenever a Secret in the MCO namespace is updated. When a Secret the
+// builds authenticate with is rotated, every layered pool with a pending,
+// running, or failed build is requeued so that builds which used the old
+// credentials are restarted. When the cloud credentials for the push
+// credentials provider are rotated, the push secret is minted again right away
+// since its credentials were minted from the old ones.
+func (ctrl *Controller) updateSecret(old, cur interface{}) {
+	oldSecret := old.(*corev1.Secret)
+	curSecret := cur.(*corev1.Secret)
+
+	// Periodic resyncs do not change anything.
+	if oldSecret.ResourceVersion == curSecret.ResourceVersion {
+		return
+	}
+
+	cm, err := ctrl.configMapLister.ConfigMaps(ctrlcommon.MCONamespace).Get(OnClusterBuildConfigMapName)
+	if k8serrors.IsNotFound(err) {
+		return
+	}
+
+	if err != nil {
+		utilruntime.HandleError(fmt.Errorf("could not get build controller config %q: %w", OnClusterBuildConfigMapName, err))
+		return
+	}
+
+	if cm.Data[FinalImagePushCredentialsProviderConfigKey] != "" && curSecret.Name == cm.Data[FinalImagePushCloudCredentialsSecretNameConfigKey] {
+		klog.Infof("Cloud credentials secret %s was rotated, refreshing push secret", curSecret.Name)
+		if err := ctrl.refreshPushCredentials(); err != nil {
+			utilruntime.HandleError(fmt.Errorf("could not refresh final image push credentials: %w", err))
+		}
+
+		return
+	}
+
+	if !sets.NewString(getBuildSecretNames(cm)...).Has(curSecret.Name) {
+		return
+	}
+
+	klog.Infof("Build secret %s was rotated (resourceVersion %s -> %s)", curSecret.Name, oldSecret.ResourceVersion, curSecret.ResourceVersion)
+
+	pools, err := ctrl.mcpLister.List(labels.Everything())
+	if err != nil {
+		utilruntime.HandleError(fmt.Errorf("could not list MachineConfigPools: %w", err))
+		return
+	}
+
+	for _, pool := range pools {
+		ps := newPoolState(pool)
+		if ps.IsLayered() && (ps.IsBuildPending() || ps.IsBuilding() || ps.IsBuildFailure()) {
+			ctrl.enqueueMachineConfigPool(pool)
+		}
+	}
+}
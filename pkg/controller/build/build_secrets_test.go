@@ -0,0 +1,76 @@
+package build
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetBuildSecretNames(t *testing.T) {
+	t.Parallel()
+
+	cm := getOnClusterBuildConfigMap()
+	cm.Data[ImageSigningSecretNameConfigKey] = "image-signing-secret"
+	cm.Data[AdditionalFinalImagesConfigKey] = `[{"pullspec": "mirror.hostname.com/org/repo:latest", "pushSecretName": "mirror-push-secret"}, {"pullspec": "backup.hostname.com/org/repo:latest", "pushSecretName": "final-image-push-secret"}]`
+
+	assert.Equal(t, []string{"base-image-pull-secret", "final-image-push-secret", "image-signing-secret", "mirror-push-secret"}, getBuildSecretNames(cm))
+
+	// The generated push secret is refreshed by the build controller.
+	cm.Data[FinalImagePushCredentialsProviderConfigKey] = AWSECRPushCredentialsProvider
+	cm.Data[FinalImagePushCloudCredentialsSecretNameConfigKey] = "aws-creds"
+
+	assert.Equal(t, []string{"base-image-pull-secret", "final-image-push-secret", "image-signing-secret", "mirror-push-secret"}, getBuildSecretNames(cm))
+
+	delete(cm.Data, AdditionalFinalImagesConfigKey)
+
+	assert.Equal(t, []string{"base-image-pull-secret", "image-signing-secret"}, getBuildSecretNames(cm))
+}
+
+func TestGetRotatedBuildSecrets(t *testing.T) {
+	t.Parallel()
+
+	recorded := map[string]string{
+		"base-image-pull-secret":  "1",
+		"final-image-push-secret": "2",
+		"image-signing-secret":    "3",
+	}
+
+	current := map[string]string{
+		"base-image-pull-secret":  "4",
+		"final-image-push-secret": "2",
+		"mirror-push-secret":      "5",
+	}
+
+	assert.Equal(t, []string{"base-image-pull-secret"}, getRotatedBuildSecrets(recorded, current))
+	assert.Empty(t, getRotatedBuildSecrets(nil, current))
+}
+
+func TestBuildSecretVersionsAnnotation(t *testing.T) {
+	t.Parallel()
+
+	pool := newMachineConfigPool("worker")
+	assert.Nil(t, getRecordedBuildSecretVersions(pool))
+
+	versions := map[string]string{
+		"base-image-pull-secret":  "1",
+		"final-image-push-secret": "2",
+	}
+
+	ps := newPoolState(pool)
+	ps.SetBuildSecretVersions(versions)
+	pool = ps.MachineConfigPool()
+	assert.Equal(t, versions, getRecordedBuildSecretVersions(pool))
+
+	// The build object is annotated with the same versions.
+	ibr := newImageBuildRequest(pool)
+	ibr.SecretVersions = versions
+	assert.Equal(t, pool.Annotations[BuildSecretVersionsAnnotationKey], ibr.toBuildPod().Annotations[BuildSecretVersionsAnnotationKey])
+	assert.Equal(t, pool.Annotations[BuildSecretVersionsAnnotationKey], ibr.toBuild().Annotations[BuildSecretVersionsAnnotationKey])
+
+	ps.SetBuildSecretVersions(nil)
+	pool = ps.MachineConfigPool()
+	assert.NotContains(t, pool.Annotations, BuildSecretVersionsAnnotationKey)
+
+	pool.Annotations[BuildSecretVersionsAnnotationKey] = "not json"
+	assert.Nil(t, getRecordedBuildSecretVersions(pool))
+}
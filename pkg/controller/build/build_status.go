@@ -0,0 +1,255 @@
+package build
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// Label on the ConfigMaps which hold the status of each build, so that
+	// they can be listed and watched.
+	BuildStatusLabel = "machineconfiguration.openshift.io/build-status"
+)
+
+// Computes the build status ConfigMap name based upon the MachineConfigPool name.
+func (i ImageBuildRequest) getBuildStatusConfigMapName() string {
+	return ctrlcommon.GetBuildStatusConfigMapName(i.Pool)
+}
+
+// Gets the status of the build for the current rendered MachineConfig of the
+// given pool. Returns nil if there is no status.
+func (ctrl *Controller) getBuildStatus(pool *mcfgv1.MachineConfigPool) (*ctrlcommon.ImageBuildStatus, error) {
+	name := newImageBuildRequest(pool).getBuildStatusConfigMapName()
+
+	cm, err := ctrl.kubeclient.CoreV1().ConfigMaps(ctrlcommon.MCONamespace).Get(context.TODO(), name, metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("could not get build status %s: %w", name, err)
+	}
+
+	return ctrlcommon.ParseImageBuildStatus(cm)
+}
+
+// Gets the label selector which matches the build status ConfigMaps of the
+// given MachineConfigPool.
+func GetBuildStatusSelector(poolName string) labels.Selector {
+	return labels.SelectorFromSet(labels.Set{
+		BuildStatusLabel:             "",
+		targetMachineConfigPoolLabel: poolName,
+	})
+}
+
+// Creates or updates the status of the build for the current rendered
+// MachineConfig of the given pool. A missing status is created from scratch,
+// e.g., for a build which was never started because its inputs are invalid.
+// The status is informational, so a failure to update it is only logged.
+func (ctrl *Controller) updateBuildStatus(pool *mcfgv1.MachineConfigPool, mutate func(*ctrlcommon.ImageBuildStatus)) {
+	ibr := newImageBuildRequest(pool)
+	name := ibr.getBuildStatusConfigMapName()
+
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		cm, err := ctrl.kubeclient.CoreV1().ConfigMaps(ctrlcommon.MCONamespace).Get(context.TODO(), name, metav1.GetOptions{})
+		if err != nil && !k8serrors.IsNotFound(err) {
+			return err
+		}
+
+		isNotFound := k8serrors.IsNotFound(err)
+		status := &ctrlcommon.ImageBuildStatus{}
+
+		if isNotFound {
+			cm = &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      name,
+					Namespace: ctrlcommon.MCONamespace,
+					Labels: map[string]string{
+						BuildStatusLabel:             "",
+						targetMachineConfigPoolLabel: pool.Name,
+						desiredConfigLabel:           pool.Spec.Configuration.Name,
+					},
+					OwnerReferences: getPoolOwnerReference(pool),
+				},
+			}
+		} else if status, err = ctrlcommon.ParseImageBuildStatus(cm); err != nil {
+			klog.Warningf("Resetting build status for pool %s: %v", pool.Name, err)
+			status = &ctrlcommon.ImageBuildStatus{}
+		}
+
+		status.Name = ibr.getBuildName()
+		status.Pool = pool.Name
+		status.MachineConfig = pool.Spec.Configuration.Name
+
+		mutate(status)
+
+		out, err := json.Marshal(status)
+		if err != nil {
+			return err
+		}
+
+		cm.Data = map[string]string{
+			ctrlcommon.BuildStatusConfigMapKey: string(out),
+		}
+
+		if isNotFound {
+			_, err = ctrl.kubeclient.CoreV1().ConfigMaps(ctrlcommon.MCONamespace).Create(context.TODO(), cm, metav1.CreateOptions{})
+		} else {
+			_, err = ctrl.kubeclient.CoreV1().ConfigMaps(ctrlcommon.MCONamespace).Update(context.TODO(), cm, metav1.UpdateOptions{})
+		}
+
+		return err
+	})
+
+	if err != nil {
+		klog.Errorf("Could not update build status %s for pool %s: %v", name, pool.Name, err)
+	}
+}
+
+// Records that a build was started for the given pool, replacing the status of
+// any earlier build of the same rendered MachineConfig, and deletes the
+// statuses of builds which are no longer in the build history.
+func (ctrl *Controller) setBuildStatusStarted(inputs *buildInputs, objRef corev1.ObjectReference) {
+	builderType, err := GetImageBuilderType(inputs.onClusterBuildConfig)
+	if err != nil {
+		klog.Errorf("Could not get image builder type for pool %s: %v", inputs.pool.Name, err)
+	}
+
+	now := metav1.Now()
+
+	ctrl.updateBuildStatus(inputs.pool, func(status *ctrlcommon.ImageBuildStatus) {
+		*status = ctrlcommon.ImageBuildStatus{
+			Name:           status.Name,
+			Pool:           status.Pool,
+			MachineConfig:  status.MachineConfig,
+			Phase:          ctrlcommon.ImageBuildPhasePending,
+			BuilderType:    builderType,
+			Retries:        inputs.retryCount,
+			SecretVersions: inputs.secretVersions,
+			LogRef:         &objRef,
+			StartTime:      &now,
+			Signature:      newImageSignatureStatus(inputs),
+		}
+	})
+
+	policy, err := getBuildHistoryPolicy(inputs.onClusterBuildConfig)
+	if err != nil {
+		klog.Errorf("Could not prune build statuses for pool %s: %v", inputs.pool.Name, err)
+		return
+	}
+
+	if err := ctrl.pruneBuildStatuses(inputs.pool, policy.historyLimit); err != nil {
+		klog.Errorf("Could not prune build statuses for pool %s: %v", inputs.pool.Name, err)
+	}
+}
+
+// Records that the build of the given pool is running.
+func (ctrl *Controller) setBuildStatusRunning(pool *mcfgv1.MachineConfigPool) {
+	ctrl.updateBuildStatus(pool, func(status *ctrlcommon.ImageBuildStatus) {
+		status.Phase = ctrlcommon.ImageBuildPhaseBuilding
+	})
+}
+
+// Records that the build of the given pool finished with the given image,
+// which is being verified, along with where its signature is, if it was
+// signed.
+func (ctrl *Controller) setBuildStatusVerifying(pool *mcfgv1.MachineConfigPool, imagePullspec string, signature *ctrlcommon.ImageSignatureStatus) {
+	ctrl.updateBuildStatus(pool, func(status *ctrlcommon.ImageBuildStatus) {
+		status.Phase = ctrlcommon.ImageBuildPhaseVerifying
+		status.Image = imagePullspec
+		status.Message = ""
+		status.Signature = signature
+	})
+}
+
+// Records that the build of the given pool succeeded with the given image,
+// which passed its verification.
+func (ctrl *Controller) setBuildStatusSucceeded(pool *mcfgv1.MachineConfigPool, imagePullspec string) {
+	now := metav1.Now()
+
+	ctrl.updateBuildStatus(pool, func(status *ctrlcommon.ImageBuildStatus) {
+		status.Phase = ctrlcommon.ImageBuildPhaseSucceeded
+		status.Image = imagePullspec
+		status.Message = ""
+		status.CompletionTime = &now
+	})
+}
+
+// Records the state of the scan of the built image of the given pool.
+func (ctrl *Controller) setBuildStatusScan(pool *mcfgv1.MachineConfigPool, scan *ctrlcommon.ImageScanStatus) {
+	ctrl.updateBuildStatus(pool, func(status *ctrlcommon.ImageBuildStatus) {
+		status.Scan = scan
+	})
+}
+
+// Records that the build of the given pool failed.
+func (ctrl *Controller) setBuildStatusFailed(pool *mcfgv1.MachineConfigPool, msg string) {
+	now := metav1.Now()
+
+	ctrl.updateBuildStatus(pool, func(status *ctrlcommon.ImageBuildStatus) {
+		status.Phase = ctrlcommon.ImageBuildPhaseFailed
+		status.Message = msg
+		status.CompletionTime = &now
+	})
+}
+
+// Gets the names of the build status ConfigMaps to delete so that only the
+// given number of the most recently started builds are kept. Statuses which
+// cannot be parsed are deleted first.
+func getBuildStatusesToPrune(cms []corev1.ConfigMap, limit int) []string {
+	type startedBuild struct {
+		name    string
+		started metav1.Time
+	}
+
+	builds := []startedBuild{}
+	for i := range cms {
+		build := startedBuild{name: cms[i].Name}
+		if status, err := ctrlcommon.ParseImageBuildStatus(&cms[i]); err == nil && status.StartTime != nil {
+			build.started = *status.StartTime
+		}
+
+		builds = append(builds, build)
+	}
+
+	sort.SliceStable(builds, func(i, j int) bool {
+		return builds[j].started.Before(&builds[i].started)
+	})
+
+	toPrune := []string{}
+	for i := limit; i < len(builds); i++ {
+		toPrune = append(toPrune, builds[i].name)
+	}
+
+	return toPrune
+}
+
+// Deletes the build statuses of the given pool beyond the given limit.
+func (ctrl *Controller) pruneBuildStatuses(pool *mcfgv1.MachineConfigPool, limit int) error {
+	cmList, err := ctrl.kubeclient.CoreV1().ConfigMaps(ctrlcommon.MCONamespace).List(context.TODO(), metav1.ListOptions{
+		LabelSelector: GetBuildStatusSelector(pool.Name).String(),
+	})
+	if err != nil {
+		return fmt.Errorf("could not list build statuses: %w", err)
+	}
+
+	for _, name := range getBuildStatusesToPrune(cmList.Items, limit) {
+		err := ctrl.kubeclient.CoreV1().ConfigMaps(ctrlcommon.MCONamespace).Delete(context.TODO(), name, metav1.DeleteOptions{})
+		if err != nil && !k8serrors.IsNotFound(err) {
+			return fmt.Errorf("could not delete build status %s: %w", name, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,115 @@
+package build
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	fakeclientmachineconfigv1 "github.com/openshift/client-go/machineconfiguration/clientset/versioned/fake"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	fakecorev1client "k8s.io/client-go/kubernetes/fake"
+)
+
+func TestGetBuildStatusesToPrune(t *testing.T) {
+	t.Parallel()
+
+	newConfigMap := func(name string, started time.Time) corev1.ConfigMap {
+		start := metav1.NewTime(started)
+		out, err := json.Marshal(ctrlcommon.ImageBuildStatus{StartTime: &start})
+		require.NoError(t, err)
+
+		return corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Data:       map[string]string{ctrlcommon.BuildStatusConfigMapKey: string(out)},
+		}
+	}
+
+	now := time.Now()
+
+	cms := []corev1.ConfigMap{
+		newConfigMap("oldest", now.Add(-3*time.Hour)),
+		newConfigMap("newest", now),
+		{ObjectMeta: metav1.ObjectMeta{Name: "unparseable"}},
+		newConfigMap("older", now.Add(-2*time.Hour)),
+	}
+
+	assert.Equal(t, []string{"oldest", "unparseable"}, getBuildStatusesToPrune(cms, 2))
+	assert.Empty(t, getBuildStatusesToPrune(cms, 10))
+}
+
+func TestBuildStatusLifecycle(t *testing.T) {
+	t.Parallel()
+
+	onClusterBuildConfigMap := getOnClusterBuildConfigMap()
+	onClusterBuildConfigMap.Data[BuildHistoryLimitConfigKey] = "1"
+	onClusterBuildConfigMap.Data[ImageBuilderTypeConfigMapKey] = CustomPodImageBuilder
+
+	pool := newMachineConfigPool("worker", "rendered-worker-2")
+	oldPool := newMachineConfigPool("worker", "rendered-worker-1")
+
+	ctrl := &Controller{
+		Clients: &Clients{
+			kubeclient: fakecorev1client.NewSimpleClientset(onClusterBuildConfigMap),
+			mcfgclient: fakeclientmachineconfigv1.NewSimpleClientset(pool),
+		},
+	}
+
+	getStatus := func(pool string) *ctrlcommon.ImageBuildStatus {
+		t.Helper()
+
+		cm, err := ctrl.kubeclient.CoreV1().ConfigMaps(ctrlcommon.MCONamespace).Get(context.TODO(), "build-status-"+pool, metav1.GetOptions{})
+		require.NoError(t, err)
+
+		assert.Contains(t, cm.Labels, BuildStatusLabel)
+
+		status, err := ctrlcommon.ParseImageBuildStatus(cm)
+		require.NoError(t, err)
+
+		return status
+	}
+
+	// A build which failed validation.
+	ctrl.setBuildStatusFailed(oldPool, "invalid Containerfile")
+	status := getStatus("rendered-worker-1")
+	assert.Equal(t, ctrlcommon.ImageBuildPhaseFailed, status.Phase)
+	assert.Equal(t, "invalid Containerfile", status.Message)
+
+	inputs := &buildInputs{
+		onClusterBuildConfig: onClusterBuildConfigMap,
+		secretVersions:       map[string]string{"final-image-push-secret": "1"},
+		retryCount:           2,
+		pool:                 pool,
+	}
+
+	objRef := corev1.ObjectReference{Kind: "Pod", Name: "build-rendered-worker-2", Namespace: ctrlcommon.MCONamespace}
+
+	ctrl.setBuildStatusStarted(inputs, objRef)
+	status = getStatus("rendered-worker-2")
+	assert.Equal(t, "build-rendered-worker-2", status.Name)
+	assert.Equal(t, "worker", status.Pool)
+	assert.Equal(t, ctrlcommon.ImageBuildPhasePending, status.Phase)
+	assert.Equal(t, CustomPodImageBuilder, status.BuilderType)
+	assert.Equal(t, 2, status.Retries)
+	assert.Equal(t, inputs.secretVersions, status.SecretVersions)
+	assert.Equal(t, &objRef, status.LogRef)
+	assert.NotNil(t, status.StartTime)
+	assert.Nil(t, status.CompletionTime)
+
+	// Only one build is kept in the history.
+	_, err := ctrl.kubeclient.CoreV1().ConfigMaps(ctrlcommon.MCONamespace).Get(context.TODO(), "build-status-rendered-worker-1", metav1.GetOptions{})
+	assert.Error(t, err)
+
+	ctrl.setBuildStatusRunning(pool)
+	assert.Equal(t, ctrlcommon.ImageBuildPhaseBuilding, getStatus("rendered-worker-2").Phase)
+
+	ctrl.setBuildStatusSucceeded(pool, expectedImagePullspecWithSHA)
+	status = getStatus("rendered-worker-2")
+	assert.Equal(t, ctrlcommon.ImageBuildPhaseSucceeded, status.Phase)
+	assert.Equal(t, expectedImagePullspecWithSHA, status.Image)
+	assert.NotNil(t, status.CompletionTime)
+}
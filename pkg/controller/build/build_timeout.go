@@ -0,0 +1,147 @@
+package build
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// The on-cluster-build-config ConfigMap key which contains the default
+	// amount of time a build may take (e.g., "1h") before it is terminated and
+	// marked as failed. Builds do not time out when this is not set.
+	BuildTimeoutConfigKey = "buildTimeout"
+
+	// Annotation on the MachineConfigPool which overrides the build timeout
+	// from the on-cluster-build-config ConfigMap for that pool. Setting it to
+	// "0s" disables the timeout for the pool.
+	BuildTimeoutAnnotationKey = "machineconfiguration.openshift.io/build-timeout"
+
+	// Condition reason used on the BuildFailed condition when a build was
+	// terminated because it exceeded its timeout.
+	buildTimedOutReason = "BuildTimedOut"
+)
+
+// Parses a build timeout value.
+func parseBuildTimeout(name, val string) (time.Duration, error) {
+	timeout, err := time.ParseDuration(val)
+	if err != nil {
+		return 0, fmt.Errorf("could not parse %s %q: %w", name, val, err)
+	}
+
+	if timeout < 0 {
+		return 0, fmt.Errorf("%s %q must not be negative", name, val)
+	}
+
+	return timeout, nil
+}
+
+// Gets the build timeout for the given MachineConfigPool. The pool annotation
+// takes precedence over the on-cluster-build-config ConfigMap. A zero
+// duration means the build does not time out.
+func getBuildTimeout(pool *mcfgv1.MachineConfigPool, cm *corev1.ConfigMap) (time.Duration, error) {
+	if val, ok := pool.Annotations[BuildTimeoutAnnotationKey]; ok && val != "" {
+		return parseBuildTimeout(BuildTimeoutAnnotationKey, val)
+	}
+
+	if cm == nil {
+		return 0, nil
+	}
+
+	if val, ok := cm.Data[BuildTimeoutConfigKey]; ok && val != "" {
+		return parseBuildTimeout(BuildTimeoutConfigKey, val)
+	}
+
+	return 0, nil
+}
+
+// Gets the time the build object for the current rendered MachineConfig was
+// created. Returns a zero time if there is no build object.
+func (ctrl *Controller) getBuildObjectCreationTime(ps *poolState) (time.Time, error) {
+	for _, objRef := range ps.GetBuildObjectRefs() {
+		if objRef.Name != newImageBuildRequest(ps.MachineConfigPool()).getBuildName() {
+			continue
+		}
+
+		var (
+			created metav1.Time
+			err     error
+		)
+
+		switch objRef.Kind {
+		case "Pod":
+			pod, getErr := ctrl.kubeclient.CoreV1().Pods(ctrlcommon.MCONamespace).Get(context.TODO(), objRef.Name, metav1.GetOptions{})
+			if getErr == nil {
+				created = pod.CreationTimestamp
+			}
+			err = getErr
+		case "Build":
+			build, getErr := ctrl.buildclient.BuildV1().Builds(ctrlcommon.MCONamespace).Get(context.TODO(), objRef.Name, metav1.GetOptions{})
+			if getErr == nil {
+				created = build.CreationTimestamp
+			}
+			err = getErr
+		}
+
+		if k8serrors.IsNotFound(err) {
+			return time.Time{}, nil
+		}
+
+		if err != nil {
+			return time.Time{}, fmt.Errorf("could not get %s %s: %w", objRef.Kind, objRef.Name, err)
+		}
+
+		return created.Time, nil
+	}
+
+	return time.Time{}, nil
+}
+
+// Terminates the build for the given MachineConfigPool if it has exceeded its
+// timeout. Otherwise, the pool is requeued for when the timeout would elapse.
+func (ctrl *Controller) checkBuildTimeout(ps *poolState) error {
+	pool := ps.MachineConfigPool()
+
+	cm, err := ctrl.configMapLister.ConfigMaps(ctrlcommon.MCONamespace).Get(OnClusterBuildConfigMapName)
+	if err != nil && !k8serrors.IsNotFound(err) {
+		return fmt.Errorf("could not get build controller config %q: %w", OnClusterBuildConfigMapName, err)
+	}
+
+	if k8serrors.IsNotFound(err) {
+		cm = nil
+	}
+
+	timeout, err := getBuildTimeout(pool, cm)
+	if err != nil {
+		return fmt.Errorf("could not get build timeout for pool %s: %w", pool.Name, err)
+	}
+
+	if timeout == 0 {
+		return nil
+	}
+
+	created, err := ctrl.getBuildObjectCreationTime(ps)
+	if err != nil {
+		return err
+	}
+
+	if created.IsZero() {
+		return nil
+	}
+
+	remaining := timeout - time.Since(created)
+	if remaining > 0 {
+		klog.V(4).Infof("Build for pool %s will time out in %s", pool.Name, remaining)
+		ctrl.enqueueAfter(pool, remaining)
+		return nil
+	}
+
+	return ctrl.markBuildTimedOut(ps, timeout)
+}
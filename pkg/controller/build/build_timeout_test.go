@@ -0,0 +1,92 @@
+package build
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Tests that the build timeout is read from the pool annotation, falling back
+// to the on-cluster-build-config ConfigMap.
+func TestGetBuildTimeout(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name            string
+		annotation      string
+		configMapValue  string
+		expectedTimeout time.Duration
+		expectError     bool
+	}{
+		{
+			name:            "no timeout",
+			expectedTimeout: 0,
+		},
+		{
+			name:            "ConfigMap default",
+			configMapValue:  "2h",
+			expectedTimeout: 2 * time.Hour,
+		},
+		{
+			name:            "pool annotation",
+			annotation:      "45m",
+			expectedTimeout: 45 * time.Minute,
+		},
+		{
+			name:            "pool annotation overrides ConfigMap",
+			annotation:      "30m",
+			configMapValue:  "2h",
+			expectedTimeout: 30 * time.Minute,
+		},
+		{
+			name:            "pool annotation disables ConfigMap timeout",
+			annotation:      "0s",
+			configMapValue:  "2h",
+			expectedTimeout: 0,
+		},
+		{
+			name:        "invalid pool annotation",
+			annotation:  "an hour",
+			expectError: true,
+		},
+		{
+			name:           "negative ConfigMap value",
+			configMapValue: "-1h",
+			expectError:    true,
+		},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			pool := newMachineConfigPool("worker")
+			if testCase.annotation != "" {
+				pool.Annotations = map[string]string{BuildTimeoutAnnotationKey: testCase.annotation}
+			}
+
+			cm := getOnClusterBuildConfigMap()
+			if testCase.configMapValue != "" {
+				cm.Data[BuildTimeoutConfigKey] = testCase.configMapValue
+			}
+
+			timeout, err := getBuildTimeout(pool, cm)
+			if testCase.expectError {
+				assert.Error(t, err)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, testCase.expectedTimeout, timeout)
+
+			// Without the ConfigMap, only the pool annotation applies.
+			if testCase.annotation == "" {
+				timeout, err = getBuildTimeout(pool, nil)
+				assert.NoError(t, err)
+				assert.Equal(t, time.Duration(0), timeout)
+			}
+		})
+	}
+}
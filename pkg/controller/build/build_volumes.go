@@ -0,0 +1,416 @@
+package build
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	buildv1 "github.com/openshift/api/build/v1"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/validation"
+	clientset "k8s.io/client-go/kubernetes"
+)
+
+// Optional Secrets and ConfigMaps in the MCO namespace which, when present,
+// are mounted into every RUN instruction of the build so that RHEL content
+// can be installed with rpm-ostree. These mirror the names used by the
+// OpenShift Builds entitlement flow. None of their contents end up in the
+// final image.
+const (
+	// Secret containing the RHEL entitlement certificate and key (e.g., a
+	// copy of the etc-pki-entitlement Secret in openshift-config-managed).
+	EtcPkiEntitlementSecretName = "etc-pki-entitlement"
+
+	// ConfigMap containing yum repository definitions (e.g., redhat.repo).
+	EtcYumReposDConfigMapName = "etc-yum-repos-d"
+
+	// Secret containing the GPG keys used to verify RPMs from the above repositories.
+	EtcPkiRpmGpgSecretName = "etc-pki-rpm-gpg"
+
+	etcPkiEntitlementMountpoint = "/etc/pki/entitlement"
+	etcYumReposDMountpoint      = "/etc/yum.repos.d"
+	etcPkiRpmGpgMountpoint      = "/etc/pki/rpm-gpg"
+)
+
+const (
+	// The on-cluster-build-config ConfigMap key which contains additional
+	// Secrets in the MCO namespace to mount into each RUN instruction of the
+	// build, one "<name>:<mountpoint>" pair per line (e.g.,
+	// "repo-token:/run/secrets/repo-token").
+	BuildSecretsConfigKey = "buildSecrets"
+
+	// The on-cluster-build-config ConfigMap key which contains additional
+	// ConfigMaps in the MCO namespace to mount into each RUN instruction of the
+	// build, using the same format as BuildSecretsConfigKey.
+	BuildConfigMapsConfigKey = "buildConfigMaps"
+
+	// The on-cluster-build-config ConfigMap key which contains build arguments
+	// to pass to the builder, one "<name>=<value>" pair per line (e.g.,
+	// "HTTP_PROXY=http://proxy.example.com:3128").
+	BuildArgsConfigKey = "buildArgs"
+)
+
+// Volume names used by the build pod itself, which user-supplied build
+// volumes may not reuse.
+var reservedBuildVolumeNames = sets.NewString(
+	"machineconfig",
+	"dockerfile",
+	"base-image-pull-creds",
+	"final-image-push-creds",
+	"done",
+	imageSigningVolumeName,
+	additionalFinalImagesVolumeName,
+	buildRegistryCAsVolumeName,
+	EtcPkiEntitlementSecretName,
+	EtcYumReposDConfigMapName,
+	EtcPkiRpmGpgSecretName,
+)
+
+// Environment variables used by the build pod itself, which build arguments
+// may not reuse since they are passed to Buildah through the environment.
+var reservedBuildArgNames = sets.NewString(
+	// Set by the build controller or read by the build scripts.
+	"ADDITIONAL_FINAL_IMAGES",
+	"BASE_IMAGE",
+	"BASE_IMAGE_PULL_CREDS",
+	"BUILD_ARG_NAMES",
+	"BUILD_CACHE_IMAGE",
+	"BUILD_VOLUME_MOUNTPOINTS",
+	"DIGEST_CONFIGMAP_NAME",
+	"DOCKER_CONFIG",
+	"EXTERNAL_BUILD_SERVICE_CREDS_DIR",
+	"EXTERNAL_BUILD_SERVICE_POLL_SECONDS",
+	"EXTERNAL_BUILD_SERVICE_URL",
+	"FINAL_IMAGE_INSECURE_REGISTRY",
+	"FINAL_IMAGE_PUSH_CREDS",
+	"FINAL_IMAGE_REPOSITORY",
+	"IMAGE_LINT_SCRIPT",
+	"IMAGE_SIGNING_KEY_DIR",
+	"POOL_NAME",
+	"POST_BUILD_TEST_COMMAND",
+	"REMOTE_BUILDER_TLS_DIR",
+	"REMOTE_BUILDER_URL",
+	"RENDERED_CONFIG",
+	"SQUASH_LAYERS",
+	"TAG",
+	// Read by the shell, the dynamic linker, or the container tools.
+	"BASH_ENV",
+	"CONTAINER_CONNECTION",
+	"CONTAINER_HOST",
+	"ENV",
+	"HOME",
+	"IFS",
+	"PATH",
+	"REGISTRY_AUTH_FILE",
+	"SHELL",
+	"SSL_CERT_DIR",
+	"SSL_CERT_FILE",
+	"TMPDIR",
+	"XDG_CONFIG_HOME",
+	"XDG_DATA_HOME",
+	"XDG_RUNTIME_DIR",
+)
+
+// Prefixes of the environment variables which configure the dynamic linker
+// and the container tools; build arguments may not start with any of them.
+var reservedBuildArgPrefixes = []string{
+	"BUILDAH_",
+	"BUILDKIT_",
+	"CONTAINERS_",
+	"LD_",
+	"PODMAN_",
+	"STORAGE_",
+}
+
+// Determines whether the given name would override an environment variable
+// the build pod relies on.
+func isReservedBuildArgName(name string) bool {
+	if reservedBuildArgNames.Has(name) {
+		return true
+	}
+
+	for _, prefix := range reservedBuildArgPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+var buildArgNameRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Describes a Secret or ConfigMap which is mounted into the build.
+type buildVolume struct {
+	// Name of the Secret or ConfigMap; also used as the volume name.
+	Name string
+	// Where the volume is mounted in the build pod and in each RUN instruction.
+	Mountpoint string
+	// Whether the volume is a Secret instead of a ConfigMap.
+	IsSecret bool
+}
+
+// All of the optional build volumes the build controller knows about.
+func getOptionalBuildVolumes() []buildVolume {
+	return []buildVolume{
+		{
+			Name:       EtcPkiEntitlementSecretName,
+			Mountpoint: etcPkiEntitlementMountpoint,
+			IsSecret:   true,
+		},
+		{
+			Name:       EtcYumReposDConfigMapName,
+			Mountpoint: etcYumReposDMountpoint,
+		},
+		{
+			Name:       EtcPkiRpmGpgSecretName,
+			Mountpoint: etcPkiRpmGpgMountpoint,
+			IsSecret:   true,
+		},
+	}
+}
+
+// Converts the build volume into a pod volume.
+func (b buildVolume) toVolume() corev1.Volume {
+	if b.IsSecret {
+		return corev1.Volume{
+			Name: b.Name,
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{
+					SecretName: b.Name,
+				},
+			},
+		}
+	}
+
+	return corev1.Volume{
+		Name: b.Name,
+		VolumeSource: corev1.VolumeSource{
+			ConfigMap: &corev1.ConfigMapVolumeSource{
+				LocalObjectReference: corev1.LocalObjectReference{
+					Name: b.Name,
+				},
+			},
+		},
+	}
+}
+
+// Converts the build volume into an OpenShift Image Builder build volume.
+func (b buildVolume) toBuildVolume() buildv1.BuildVolume {
+	source := buildv1.BuildVolumeSource{}
+
+	volume := b.toVolume()
+	if b.IsSecret {
+		source.Type = buildv1.BuildVolumeSourceTypeSecret
+		source.Secret = volume.Secret
+	} else {
+		source.Type = buildv1.BuildVolumeSourceTypeConfigMap
+		source.ConfigMap = volume.ConfigMap
+	}
+
+	return buildv1.BuildVolume{
+		Name:   b.Name,
+		Source: source,
+		Mounts: []buildv1.BuildVolumeMount{
+			{
+				DestinationPath: b.Mountpoint,
+			},
+		},
+	}
+}
+
+// Joins the mountpoints of the given build volumes so that the build script
+// can pass them to Buildah.
+func getBuildVolumeMountpoints(volumes []buildVolume) string {
+	mountpoints := []string{}
+	for _, volume := range volumes {
+		mountpoints = append(mountpoints, volume.Mountpoint)
+	}
+
+	return strings.Join(mountpoints, " ")
+}
+
+// Parses the "<name>:<mountpoint>" lines from the given on-cluster-build-config
+// ConfigMap key.
+func parseBuildVolumes(cm *corev1.ConfigMap, key string, isSecret bool) ([]buildVolume, error) {
+	out := []buildVolume{}
+
+	if cm == nil {
+		return out, nil
+	}
+
+	for _, line := range strings.Split(cm.Data[key], "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		name, mountpoint, ok := strings.Cut(line, ":")
+		if !ok {
+			return nil, fmt.Errorf("could not parse %s entry %q: expected <name>:<mountpoint>", key, line)
+		}
+
+		name = strings.TrimSpace(name)
+		mountpoint = strings.TrimSpace(mountpoint)
+
+		// The name is also used as the volume name in the build pod, which is
+		// more restrictive than Secret and ConfigMap names.
+		if errs := validation.IsDNS1123Label(name); len(errs) != 0 {
+			return nil, fmt.Errorf("invalid %s name %q: %s", key, name, strings.Join(errs, ", "))
+		}
+
+		if !filepath.IsAbs(mountpoint) || filepath.Clean(mountpoint) != mountpoint || mountpoint == "/" {
+			return nil, fmt.Errorf("invalid %s mountpoint %q for %q: must be a clean, absolute path", key, mountpoint, name)
+		}
+
+		out = append(out, buildVolume{
+			Name:       name,
+			Mountpoint: mountpoint,
+			IsSecret:   isSecret,
+		})
+	}
+
+	return out, nil
+}
+
+// Gets the additional Secrets and ConfigMaps the user wants mounted into the
+// build from the on-cluster-build-config ConfigMap, including the RPM
+// repositories and GPG keys to install in the configs stage.
+func getUserBuildVolumes(cm *corev1.ConfigMap) ([]buildVolume, error) {
+	secrets, err := parseBuildVolumes(cm, BuildSecretsConfigKey, true)
+	if err != nil {
+		return nil, err
+	}
+
+	configMaps, err := parseBuildVolumes(cm, BuildConfigMapsConfigKey, false)
+	if err != nil {
+		return nil, err
+	}
+
+	rpmRepositoryVolumes, err := getRPMRepositoryVolumes(cm)
+	if err != nil {
+		return nil, err
+	}
+
+	out := append(secrets, configMaps...)
+	out = append(out, rpmRepositoryVolumes...)
+
+	names := sets.NewString()
+	mountpoints := sets.NewString()
+	for _, volume := range getOptionalBuildVolumes() {
+		mountpoints.Insert(volume.Mountpoint)
+	}
+
+	for _, volume := range out {
+		if reservedBuildVolumeNames.Has(volume.Name) || names.Has(volume.Name) {
+			return nil, fmt.Errorf("build volume name %q is reserved or already in use", volume.Name)
+		}
+
+		if mountpoints.Has(volume.Mountpoint) {
+			return nil, fmt.Errorf("build volume mountpoint %q for %q is reserved or already in use", volume.Mountpoint, volume.Name)
+		}
+
+		names.Insert(volume.Name)
+		mountpoints.Insert(volume.Mountpoint)
+	}
+
+	return out, nil
+}
+
+// Gets the build arguments from the on-cluster-build-config ConfigMap.
+func getBuildArgs(cm *corev1.ConfigMap) ([]corev1.EnvVar, error) {
+	out := []corev1.EnvVar{}
+
+	if cm == nil {
+		return out, nil
+	}
+
+	names := sets.NewString()
+
+	for _, line := range strings.Split(cm.Data[BuildArgsConfigKey], "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		// Only leading whitespace is trimmed so that values are passed verbatim.
+		name, value, ok := strings.Cut(strings.TrimLeft(line, " \t"), "=")
+		if !ok {
+			return nil, fmt.Errorf("could not parse %s entry %q: expected <name>=<value>", BuildArgsConfigKey, line)
+		}
+
+		if !buildArgNameRegex.MatchString(name) {
+			return nil, fmt.Errorf("invalid %s name %q", BuildArgsConfigKey, name)
+		}
+
+		if isReservedBuildArgName(name) || names.Has(name) {
+			return nil, fmt.Errorf("%s name %q is reserved or already in use", BuildArgsConfigKey, name)
+		}
+
+		names.Insert(name)
+		out = append(out, corev1.EnvVar{Name: name, Value: value})
+	}
+
+	return out, nil
+}
+
+// Gets the names of the given build arguments so that the build script can
+// pass them to Buildah, which reads their values from the environment.
+func getBuildArgNames(buildArgs []corev1.EnvVar) string {
+	names := []string{}
+	for _, buildArg := range buildArgs {
+		names = append(names, buildArg.Name)
+	}
+
+	return strings.Join(names, " ")
+}
+
+// Determines which of the optional build volumes exist in the MCO namespace
+// and appends the ones configured by the user, which must exist.
+func (ctrl *Controller) getBuildVolumes(onClusterBuildConfig *corev1.ConfigMap) ([]buildVolume, error) {
+	out := []buildVolume{}
+
+	for _, volume := range getOptionalBuildVolumes() {
+		err := getBuildVolumeSource(ctrl.kubeclient, volume)
+		if k8serrors.IsNotFound(err) {
+			continue
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("could not get %s: %w", volume.Name, err)
+		}
+
+		out = append(out, volume)
+	}
+
+	userVolumes, err := getUserBuildVolumes(onClusterBuildConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, volume := range userVolumes {
+		if err := getBuildVolumeSource(ctrl.kubeclient, volume); err != nil {
+			return nil, fmt.Errorf("could not get %s: %w", volume.Name, err)
+		}
+
+		out = append(out, volume)
+	}
+
+	return out, nil
+}
+
+// Gets the Secret or ConfigMap backing the build volume to ensure it exists.
+func getBuildVolumeSource(kubeclient clientset.Interface, volume buildVolume) error {
+	if volume.IsSecret {
+		_, err := kubeclient.CoreV1().Secrets(ctrlcommon.MCONamespace).Get(context.TODO(), volume.Name, metav1.GetOptions{})
+		return err
+	}
+
+	_, err := kubeclient.CoreV1().ConfigMaps(ctrlcommon.MCONamespace).Get(context.TODO(), volume.Name, metav1.GetOptions{})
+	return err
+}
@@ -0,0 +1,279 @@
+package build
+
+import (
+	"testing"
+
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	fakecorev1client "k8s.io/client-go/kubernetes/fake"
+)
+
+// Tests that only the optional build volumes which exist are used.
+func TestGetBuildVolumes(t *testing.T) {
+	t.Parallel()
+
+	entitlement := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      EtcPkiEntitlementSecretName,
+			Namespace: ctrlcommon.MCONamespace,
+		},
+		Data: map[string][]byte{
+			"entitlement.pem":     []byte("cert"),
+			"entitlement-key.pem": []byte("key"),
+		},
+	}
+
+	ctrl := &Controller{
+		Clients: &Clients{
+			kubeclient: fakecorev1client.NewSimpleClientset(entitlement),
+		},
+	}
+
+	volumes, err := ctrl.getBuildVolumes(nil)
+	require.NoError(t, err)
+	assert.Equal(t, []buildVolume{{Name: EtcPkiEntitlementSecretName, Mountpoint: etcPkiEntitlementMountpoint, IsSecret: true}}, volumes)
+
+	repos := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      EtcYumReposDConfigMapName,
+			Namespace: ctrlcommon.MCONamespace,
+		},
+		Data: map[string]string{
+			"redhat.repo": "[rhel-9-for-x86_64-baseos-rpms]",
+		},
+	}
+
+	ctrl.kubeclient = fakecorev1client.NewSimpleClientset(entitlement, repos)
+
+	volumes, err = ctrl.getBuildVolumes(nil)
+	require.NoError(t, err)
+	assert.Len(t, volumes, 2)
+	assert.Equal(t, "/etc/pki/entitlement /etc/yum.repos.d", getBuildVolumeMountpoints(volumes))
+}
+
+func TestGetUserBuildVolumes(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name            string
+		data            map[string]string
+		expectedVolumes []buildVolume
+		errExpected     bool
+	}{
+		{
+			name:            "none configured",
+			expectedVolumes: []buildVolume{},
+		},
+		{
+			name: "secrets and configmaps",
+			data: map[string]string{
+				BuildSecretsConfigKey:    "repo-token:/run/secrets/repo-token\n\n proxy-creds : /etc/proxy \n",
+				BuildConfigMapsConfigKey: "internal-repos:/etc/internal-repos",
+			},
+			expectedVolumes: []buildVolume{
+				{Name: "repo-token", Mountpoint: "/run/secrets/repo-token", IsSecret: true},
+				{Name: "proxy-creds", Mountpoint: "/etc/proxy", IsSecret: true},
+				{Name: "internal-repos", Mountpoint: "/etc/internal-repos"},
+			},
+		},
+		{
+			name:        "missing mountpoint",
+			data:        map[string]string{BuildSecretsConfigKey: "repo-token"},
+			errExpected: true,
+		},
+		{
+			name:        "relative mountpoint",
+			data:        map[string]string{BuildSecretsConfigKey: "repo-token:run/secrets"},
+			errExpected: true,
+		},
+		{
+			name:        "unclean mountpoint",
+			data:        map[string]string{BuildSecretsConfigKey: "repo-token:/run/../etc"},
+			errExpected: true,
+		},
+		{
+			name:        "invalid volume name",
+			data:        map[string]string{BuildConfigMapsConfigKey: "internal.repos:/etc/internal-repos"},
+			errExpected: true,
+		},
+		{
+			name:        "reserved name",
+			data:        map[string]string{BuildSecretsConfigKey: "done:/etc/done"},
+			errExpected: true,
+		},
+		{
+			name:        "reserved mountpoint",
+			data:        map[string]string{BuildConfigMapsConfigKey: "my-repos:/etc/yum.repos.d"},
+			errExpected: true,
+		},
+		{
+			name: "duplicate name",
+			data: map[string]string{
+				BuildSecretsConfigKey:    "repo-token:/run/secrets/repo-token",
+				BuildConfigMapsConfigKey: "repo-token:/etc/repo-token",
+			},
+			errExpected: true,
+		},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			cm := getOnClusterBuildConfigMap()
+			for k, v := range testCase.data {
+				cm.Data[k] = v
+			}
+
+			volumes, err := getUserBuildVolumes(cm)
+			if testCase.errExpected {
+				assert.Error(t, err)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, testCase.expectedVolumes, volumes)
+		})
+	}
+}
+
+func TestGetBuildArgs(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name         string
+		buildArgs    string
+		expectedArgs []corev1.EnvVar
+		errExpected  bool
+	}{
+		{
+			name:         "none configured",
+			expectedArgs: []corev1.EnvVar{},
+		},
+		{
+			name:      "configured",
+			buildArgs: "HTTP_PROXY=http://user:p@ss=word@proxy.example.com:3128\n  NO_PROXY=.cluster.local\nEMPTY=\n",
+			expectedArgs: []corev1.EnvVar{
+				{Name: "HTTP_PROXY", Value: "http://user:p@ss=word@proxy.example.com:3128"},
+				{Name: "NO_PROXY", Value: ".cluster.local"},
+				{Name: "EMPTY", Value: ""},
+			},
+		},
+		{
+			name:        "missing value",
+			buildArgs:   "HTTP_PROXY",
+			errExpected: true,
+		},
+		{
+			name:        "invalid name",
+			buildArgs:   "HTTP-PROXY=http://proxy.example.com",
+			errExpected: true,
+		},
+		{
+			name:        "reserved name",
+			buildArgs:   "TAG=latest",
+			errExpected: true,
+		},
+		{
+			name:        "reserved name PATH",
+			buildArgs:   "PATH=/tmp/bin",
+			errExpected: true,
+		},
+		{
+			name:        "reserved name LD_PRELOAD",
+			buildArgs:   "LD_PRELOAD=/tmp/evil.so",
+			errExpected: true,
+		},
+		{
+			name:        "reserved name LD_LIBRARY_PATH",
+			buildArgs:   "LD_LIBRARY_PATH=/tmp/lib",
+			errExpected: true,
+		},
+		{
+			name:        "reserved name HOME",
+			buildArgs:   "HOME=/tmp",
+			errExpected: true,
+		},
+		{
+			name:        "reserved name REGISTRY_AUTH_FILE",
+			buildArgs:   "REGISTRY_AUTH_FILE=/tmp/auth.json",
+			errExpected: true,
+		},
+		{
+			name:        "reserved name BUILDAH_ISOLATION",
+			buildArgs:   "BUILDAH_ISOLATION=rootless",
+			errExpected: true,
+		},
+		{
+			name:        "reserved name STORAGE_DRIVER",
+			buildArgs:   "STORAGE_DRIVER=vfs",
+			errExpected: true,
+		},
+		{
+			name:        "reserved name CONTAINERS_CONF",
+			buildArgs:   "CONTAINERS_CONF=/tmp/containers.conf",
+			errExpected: true,
+		},
+		{
+			name:        "reserved name FINAL_IMAGE_REPOSITORY",
+			buildArgs:   "FINAL_IMAGE_REPOSITORY=registry.example.com/other",
+			errExpected: true,
+		},
+		{
+			name:        "duplicate name",
+			buildArgs:   "A=1\nA=2",
+			errExpected: true,
+		},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			cm := getOnClusterBuildConfigMap()
+			cm.Data[BuildArgsConfigKey] = testCase.buildArgs
+
+			buildArgs, err := getBuildArgs(cm)
+			if testCase.errExpected {
+				assert.Error(t, err)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, testCase.expectedArgs, buildArgs)
+		})
+	}
+}
+
+// Tests that user-configured build volumes must exist.
+func TestGetBuildVolumesUserConfigured(t *testing.T) {
+	t.Parallel()
+
+	onClusterBuildConfigMap := getOnClusterBuildConfigMap()
+	onClusterBuildConfigMap.Data[BuildSecretsConfigKey] = "repo-token:/run/secrets/repo-token"
+
+	ctrl := &Controller{
+		Clients: &Clients{
+			kubeclient: fakecorev1client.NewSimpleClientset(),
+		},
+	}
+
+	_, err := ctrl.getBuildVolumes(onClusterBuildConfigMap)
+	assert.Error(t, err)
+
+	ctrl.kubeclient = fakecorev1client.NewSimpleClientset(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "repo-token",
+			Namespace: ctrlcommon.MCONamespace,
+		},
+	})
+
+	volumes, err := ctrl.getBuildVolumes(onClusterBuildConfigMap)
+	require.NoError(t, err)
+	assert.Equal(t, []buildVolume{{Name: "repo-token", Mountpoint: "/run/secrets/repo-token", IsSecret: true}}, volumes)
+}
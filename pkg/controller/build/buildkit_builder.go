@@ -0,0 +1,139 @@
+package build
+
+import (
+	"fmt"
+
+	"github.com/containers/image/v5/docker/reference"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// The on-cluster-build-config ConfigMap key which contains the pullspec of
+	// the image (e.g., registry.hostname.com/org/repo:buildcache) which BuildKit
+	// imports its build cache from and exports it to. Clusters which share it
+	// share the cache. The final image push secret must be able to push to it.
+	BuildCacheImagePullspecConfigKey = "buildCacheImagePullspec"
+
+	// The rootless BuildKit image runs buildkitd as this user.
+	buildKitImagePullspec string = "docker.io/moby/buildkit:rootless"
+	buildKitHome          string = "/home/user"
+
+	buildKitStateVolumeName = "buildkit-state"
+	buildKitStateMountpoint = buildKitHome + "/.local/share/buildkit"
+
+	// BuildKit reads the merged registry credentials from config.json in the
+	// directory which DOCKER_CONFIG points at.
+	buildKitDockerConfigMountpoint = "/tmp/merged-registry-creds"
+)
+
+// Describes the BuildKit builder, which builds the image in a rootless
+// BuildKit daemon within the build pod and reuses the layers of previous
+// builds from a registry cache.
+type buildKitBuilder struct {
+	// The optional pullspec of the registry build cache.
+	CacheImage string
+}
+
+// Gets the BuildKit builder from the on-cluster-build-config ConfigMap.
+// Returns nil if BuildKit is not the configured image builder.
+func getBuildKitBuilder(cm *corev1.ConfigMap) (*buildKitBuilder, error) {
+	if cm == nil || cm.Data[ImageBuilderTypeConfigMapKey] != BuildKitImageBuilder {
+		return nil, nil
+	}
+
+	cacheImage := cm.Data[BuildCacheImagePullspecConfigKey]
+	if cacheImage != "" {
+		if _, err := reference.ParseNamed(cacheImage); err != nil {
+			return nil, fmt.Errorf("could not parse %s %q: %w", BuildCacheImagePullspecConfigKey, cacheImage, err)
+		}
+	}
+
+	// BuildKit only builds the image, it cannot run containers from it.
+	if getPostBuildTestCommand(cm) != "" {
+		return nil, fmt.Errorf("%s is not supported by %s %q", PostBuildTestCommandConfigKey, ImageBuilderTypeConfigMapKey, BuildKitImageBuilder)
+	}
+
+	// BuildKit only mounts Secrets into RUN instructions which ask for them.
+	userVolumes, err := getUserBuildVolumes(cm)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(userVolumes) != 0 {
+		return nil, fmt.Errorf("additional build Secrets and ConfigMaps are not supported by %s %q", ImageBuilderTypeConfigMapKey, BuildKitImageBuilder)
+	}
+
+	return &buildKitBuilder{
+		CacheImage: cacheImage,
+	}, nil
+}
+
+// Validates the BuildKit builder configuration from the on-cluster-build-config
+// ConfigMap.
+func validateBuildKitBuilderConfig(cm *corev1.ConfigMap) error {
+	_, err := getBuildKitBuilder(cm)
+	return err
+}
+
+// Creates a build pod which builds the image with a rootless BuildKit daemon
+// and pushes it along with the build cache. The pod otherwise matches the
+// Buildah build pod, so the wait-for-done container reports the digest of the
+// pushed image the same way.
+func (i ImageBuildRequest) toBuildKitBuildPod() *corev1.Pod {
+	pod := i.toBuildahPod()
+
+	for idx := range pod.Spec.Containers {
+		container := &pod.Spec.Containers[idx]
+		if container.Name != "image-build" {
+			continue
+		}
+
+		// buildkitd keeps its state below the home directory of its user.
+		for envIdx := range container.Env {
+			if container.Env[envIdx].Name == "HOME" {
+				container.Env[envIdx].Value = buildKitHome
+			}
+		}
+
+		container.Image = buildKitImagePullspec
+		container.Command = []string{"/bin/sh", "-c", buildKitBuildScript}
+		container.Env = append(container.Env,
+			corev1.EnvVar{
+				Name:  "BUILD_CACHE_IMAGE",
+				Value: i.BuildKit.CacheImage,
+			},
+			corev1.EnvVar{
+				Name:  "DOCKER_CONFIG",
+				Value: buildKitDockerConfigMountpoint,
+			},
+			corev1.EnvVar{
+				// Rootless BuildKit cannot create a PID namespace for each RUN
+				// instruction without a privileged container.
+				Name:  "BUILDKITD_FLAGS",
+				Value: "--oci-worker-no-process-sandbox",
+			},
+		)
+		// Rootless BuildKit needs the unshare and mount syscalls, which the
+		// default seccomp profile blocks. The security context is shared with
+		// the wait-for-done container, which does not need them.
+		container.SecurityContext = container.SecurityContext.DeepCopy()
+		container.SecurityContext.SeccompProfile = &corev1.SeccompProfile{
+			Type: corev1.SeccompProfileTypeUnconfined,
+		}
+		container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+			Name:      buildKitStateVolumeName,
+			MountPath: buildKitStateMountpoint,
+		})
+	}
+
+	pod.Spec.Volumes = append(pod.Spec.Volumes, corev1.Volume{
+		Name: buildKitStateVolumeName,
+		VolumeSource: corev1.VolumeSource{
+			EmptyDir: &corev1.EmptyDirVolumeSource{},
+		},
+	})
+
+	i.mergeRegistryCreds(pod, buildKitDockerConfigMountpoint)
+
+	return pod
+}
@@ -0,0 +1,129 @@
+package build
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestGetBuildKitBuilder(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name        string
+		data        map[string]string
+		expected    *buildKitBuilder
+		errExpected bool
+	}{
+		{
+			name:     "without a build cache",
+			expected: &buildKitBuilder{},
+		},
+		{
+			name: "with a build cache",
+			data: map[string]string{
+				BuildCacheImagePullspecConfigKey: "registry.hostname.com/org/repo:buildcache",
+			},
+			expected: &buildKitBuilder{CacheImage: "registry.hostname.com/org/repo:buildcache"},
+		},
+		{
+			name: "invalid build cache",
+			data: map[string]string{
+				BuildCacheImagePullspecConfigKey: "registry.hostname.com/org/REPO",
+			},
+			errExpected: true,
+		},
+		{
+			name: "unsupported post-build test command",
+			data: map[string]string{
+				PostBuildTestCommandConfigKey: "rpm -q python3",
+			},
+			errExpected: true,
+		},
+		{
+			name: "unsupported build volumes",
+			data: map[string]string{
+				BuildSecretsConfigKey: "repo-token:/run/secrets/repo-token",
+			},
+			errExpected: true,
+		},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			cm := getOnClusterBuildConfigMap()
+			cm.Data[ImageBuilderTypeConfigMapKey] = BuildKitImageBuilder
+			for k, v := range testCase.data {
+				cm.Data[k] = v
+			}
+
+			bk, err := getBuildKitBuilder(cm)
+			if testCase.errExpected {
+				assert.Error(t, err)
+				assert.Error(t, validateBuildKitBuilderConfig(cm))
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, testCase.expected, bk)
+		})
+	}
+
+	// BuildKit is only used when it is the configured image builder.
+	bk, err := getBuildKitBuilder(getOnClusterBuildConfigMap())
+	assert.NoError(t, err)
+	assert.Nil(t, bk)
+}
+
+// Tests that the BuildKit build pod builds with rootless BuildKit, reads the
+// merged registry credentials and knows about the build cache.
+func TestImageBuildRequestWithBuildKit(t *testing.T) {
+	t.Parallel()
+
+	onClusterBuildConfigMap := getOnClusterBuildConfigMap()
+	onClusterBuildConfigMap.Data[ImageBuilderTypeConfigMapKey] = BuildKitImageBuilder
+	onClusterBuildConfigMap.Data[BuildCacheImagePullspecConfigKey] = "registry.hostname.com/org/repo:buildcache"
+
+	bk, err := getBuildKitBuilder(onClusterBuildConfigMap)
+	require.NoError(t, err)
+
+	ibr := newImageBuildRequestFromBuildInputs(&buildInputs{
+		pool:                 newMachineConfigPool("worker", "rendered-worker-1"),
+		osImageURL:           getOSImageURLConfigMap(),
+		onClusterBuildConfig: onClusterBuildConfigMap,
+		buildKitBuilder:      bk,
+	})
+
+	pod := ibr.toBuildPod()
+
+	require.Len(t, pod.Spec.InitContainers, 1)
+	assert.Equal(t, "merge-registry-creds", pod.Spec.InitContainers[0].Name)
+	assert.Contains(t, pod.Spec.InitContainers[0].VolumeMounts, corev1.VolumeMount{Name: mergedRegistryCredsVolumeName, MountPath: buildKitDockerConfigMountpoint})
+
+	buildContainer := pod.Spec.Containers[0]
+	assert.Equal(t, "image-build", buildContainer.Name)
+	assert.Equal(t, buildKitImagePullspec, buildContainer.Image)
+	assert.Equal(t, buildKitBuildScript, buildContainer.Command[len(buildContainer.Command)-1])
+	assert.Contains(t, buildContainer.Env, corev1.EnvVar{Name: "HOME", Value: buildKitHome})
+	assert.Contains(t, buildContainer.Env, corev1.EnvVar{Name: "BUILD_CACHE_IMAGE", Value: "registry.hostname.com/org/repo:buildcache"})
+	assert.Contains(t, buildContainer.Env, corev1.EnvVar{Name: "DOCKER_CONFIG", Value: buildKitDockerConfigMountpoint})
+	assert.Contains(t, buildContainer.VolumeMounts, corev1.VolumeMount{Name: mergedRegistryCredsVolumeName, MountPath: buildKitDockerConfigMountpoint})
+	assert.Contains(t, buildContainer.VolumeMounts, corev1.VolumeMount{Name: buildKitStateVolumeName, MountPath: buildKitStateMountpoint})
+	assert.Equal(t, corev1.SeccompProfileTypeUnconfined, buildContainer.SecurityContext.SeccompProfile.Type)
+
+	// The wait-for-done container still creates the digest ConfigMap and keeps
+	// the default seccomp profile.
+	waitContainer := pod.Spec.Containers[1]
+	assert.Equal(t, "wait-for-done", waitContainer.Name)
+	assert.Nil(t, waitContainer.SecurityContext.SeccompProfile)
+	assert.Contains(t, waitContainer.Env, corev1.EnvVar{Name: "HOME", Value: "/home/build"})
+
+	// Without BuildKit, Buildah builds the image in the pod.
+	ibr.BuildKit = nil
+	assert.Equal(t, buildahImagePullspec, ibr.toBuildPod().Spec.Containers[0].Image)
+}
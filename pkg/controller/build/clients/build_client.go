@@ -0,0 +1,461 @@
+// Package clients provides a small Go API for configuring, triggering,
+// watching, and cancelling on-cluster builds. It wraps the ConfigMap, label,
+// and annotation mechanics the build controller consumes so that e2e tests and
+// external tooling do not need to re-implement them.
+package clients
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	mcfgclientv1 "github.com/openshift/client-go/machineconfiguration/clientset/versioned/typed/machineconfiguration/v1"
+	"github.com/openshift/machine-config-operator/pkg/apihelpers"
+	"github.com/openshift/machine-config-operator/pkg/controller/build"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/watch"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/util/retry"
+)
+
+// BuildPhase describes the state of the build for a MachineConfigPool.
+type BuildPhase string
+
+const (
+	// No build has been started for the current rendered MachineConfig.
+	BuildPhaseNone BuildPhase = ""
+	// The build is waiting for another pool's build to finish because the
+	// maximum number of concurrent builds was reached.
+	BuildPhaseQueued BuildPhase = "Queued"
+	// The build has been created but has not started running yet.
+	BuildPhasePending BuildPhase = "Pending"
+	// The build is running.
+	BuildPhaseBuilding BuildPhase = "Building"
+	// The build succeeded and its image is available.
+	BuildPhaseSucceeded BuildPhase = "Succeeded"
+	// The build failed.
+	BuildPhaseFailed BuildPhase = "Failed"
+)
+
+// BuildStatus is a snapshot of the build state of a MachineConfigPool.
+type BuildStatus struct {
+	// The name of the MachineConfigPool.
+	Pool string
+	// The rendered MachineConfig being built.
+	MachineConfig string
+	// Whether the MachineConfigPool is opted into on-cluster builds.
+	Layered bool
+	// The phase of the build.
+	Phase BuildPhase
+	// The pullspec of the built image, once the build has succeeded.
+	Image string
+	// The reason the build failed, if any.
+	Message string
+	// The tail of the log of the last failed build, if it was captured.
+	LogTail string
+}
+
+// IsDone determines whether the build has either succeeded or failed.
+func (b BuildStatus) IsDone() bool {
+	return b.Phase == BuildPhaseFailed || (b.Phase == BuildPhaseSucceeded && b.Image != "")
+}
+
+// OnClusterBuildConfig holds the contents of the on-cluster-build-config
+// ConfigMap.
+type OnClusterBuildConfig struct {
+	// The Secret used to pull the base OS image.
+	BaseImagePullSecretName string
+	// The Secret used to push the final image.
+	FinalImagePushSecretName string
+	// Where the final image is pushed to.
+	FinalImagePullspec string
+	// The image builder to use; defaults to the build controller default.
+	ImageBuilderType string
+	// Any of the optional on-cluster-build-config keys (e.g., buildTimeout).
+	AdditionalConfig map[string]string
+}
+
+func (o OnClusterBuildConfig) toData() map[string]string {
+	data := map[string]string{}
+
+	for k, v := range o.AdditionalConfig {
+		data[k] = v
+	}
+
+	data[build.BaseImagePullSecretNameConfigKey] = o.BaseImagePullSecretName
+	data[build.FinalImagePushSecretNameConfigKey] = o.FinalImagePushSecretName
+	data[build.FinalImagePullspecConfigKey] = o.FinalImagePullspec
+
+	if o.ImageBuilderType != "" {
+		data[build.ImageBuilderTypeConfigMapKey] = o.ImageBuilderType
+	}
+
+	return data
+}
+
+// BuildClient configures, triggers, watches, and cancels on-cluster builds.
+type BuildClient struct {
+	kubeclient corev1client.ConfigMapsGetter
+	mcfgclient mcfgclientv1.MachineConfigPoolsGetter
+}
+
+// NewBuildClient constructs a BuildClient. Both the typed clients returned by
+// a full clientset (e.g., kubeclient.CoreV1()) and the e2e framework ClientSet
+// satisfy the required interfaces.
+func NewBuildClient(kubeclient corev1client.ConfigMapsGetter, mcfgclient mcfgclientv1.MachineConfigPoolsGetter) *BuildClient {
+	return &BuildClient{
+		kubeclient: kubeclient,
+		mcfgclient: mcfgclient,
+	}
+}
+
+// ConfigureOnClusterBuilds creates or updates the on-cluster-build-config
+// ConfigMap. Keys not described by the given config are left untouched.
+func (c *BuildClient) ConfigureOnClusterBuilds(ctx context.Context, cfg OnClusterBuildConfig) error {
+	return c.applyConfigMapData(ctx, build.OnClusterBuildConfigMapName, cfg.toData())
+}
+
+// SetCustomDockerfile creates or updates the custom Dockerfile for the given
+// MachineConfigPool in the on-cluster-build-custom-dockerfile ConfigMap.
+func (c *BuildClient) SetCustomDockerfile(ctx context.Context, poolName, dockerfile string) error {
+	return c.applyConfigMapData(ctx, build.CustomDockerfileConfigMapName, map[string]string{poolName: dockerfile})
+}
+
+func (c *BuildClient) applyConfigMapData(ctx context.Context, name string, data map[string]string) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		cm, err := c.kubeclient.ConfigMaps(ctrlcommon.MCONamespace).Get(ctx, name, metav1.GetOptions{})
+		if k8serrors.IsNotFound(err) {
+			cm = &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      name,
+					Namespace: ctrlcommon.MCONamespace,
+				},
+				Data: data,
+			}
+
+			_, err = c.kubeclient.ConfigMaps(ctrlcommon.MCONamespace).Create(ctx, cm, metav1.CreateOptions{})
+			return err
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if cm.Data == nil {
+			cm.Data = map[string]string{}
+		}
+
+		for k, v := range data {
+			cm.Data[k] = v
+		}
+
+		_, err = c.kubeclient.ConfigMaps(ctrlcommon.MCONamespace).Update(ctx, cm, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+// TriggerBuild starts a build for the current rendered MachineConfig of the
+// given MachineConfigPool. A pool which is not opted into on-cluster builds is
+// opted in, which starts its first build; otherwise a rebuild is requested.
+func (c *BuildClient) TriggerBuild(ctx context.Context, poolName string) error {
+	return c.updatePool(ctx, poolName, func(pool *mcfgv1.MachineConfigPool) {
+		if !ctrlcommon.IsLayeredPool(pool) {
+			if pool.Labels == nil {
+				pool.Labels = map[string]string{}
+			}
+
+			pool.Labels[ctrlcommon.LayeringEnabledPoolLabel] = ""
+			return
+		}
+
+		if pool.Annotations == nil {
+			pool.Annotations = map[string]string{}
+		}
+
+		pool.Annotations[build.RebuildAnnotationKey] = time.Now().UTC().Format(time.RFC3339Nano)
+	})
+}
+
+// CancelBuild cancels any pending or running build for the given
+// MachineConfigPool. The build controller deletes the build object and marks
+// the build as failed without retrying it. The pool stays opted into
+// on-cluster builds and its nodes keep their current image; TriggerBuild
+// starts a new build.
+func (c *BuildClient) CancelBuild(ctx context.Context, poolName string) error {
+	return c.updatePool(ctx, poolName, func(pool *mcfgv1.MachineConfigPool) {
+		if pool.Annotations == nil {
+			pool.Annotations = map[string]string{}
+		}
+
+		pool.Annotations[build.CancelBuildAnnotationKey] = time.Now().UTC().Format(time.RFC3339Nano)
+	})
+}
+
+func (c *BuildClient) updatePool(ctx context.Context, poolName string, mutate func(*mcfgv1.MachineConfigPool)) error {
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		pool, err := c.mcfgclient.MachineConfigPools().Get(ctx, poolName, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+
+		mutate(pool)
+
+		_, err = c.mcfgclient.MachineConfigPools().Update(ctx, pool, metav1.UpdateOptions{})
+		return err
+	})
+
+	if err != nil {
+		return fmt.Errorf("could not update MachineConfigPool %s: %w", poolName, err)
+	}
+
+	return nil
+}
+
+// GetBuildStatus gets the current build status of the given MachineConfigPool.
+func (c *BuildClient) GetBuildStatus(ctx context.Context, poolName string) (BuildStatus, error) {
+	pool, err := c.mcfgclient.MachineConfigPools().Get(ctx, poolName, metav1.GetOptions{})
+	if err != nil {
+		return BuildStatus{}, err
+	}
+
+	return getBuildStatus(pool), nil
+}
+
+// WatchBuild streams the build status of the given MachineConfigPool,
+// starting with its current status and followed by every change to it. The
+// returned channel is closed once the context is cancelled or the underlying
+// watch ends.
+func (c *BuildClient) WatchBuild(ctx context.Context, poolName string) (<-chan BuildStatus, error) {
+	pool, err := c.mcfgclient.MachineConfigPools().Get(ctx, poolName, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	w, err := c.mcfgclient.MachineConfigPools().Watch(ctx, metav1.ListOptions{
+		FieldSelector:   fields.OneTermEqualSelector("metadata.name", poolName).String(),
+		ResourceVersion: pool.ResourceVersion,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not watch MachineConfigPool %s: %w", poolName, err)
+	}
+
+	out := make(chan BuildStatus)
+
+	go func() {
+		defer close(out)
+		defer w.Stop()
+
+		last := getBuildStatus(pool)
+
+		select {
+		case out <- last:
+		case <-ctx.Done():
+			return
+		}
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case event, ok := <-w.ResultChan():
+				if !ok {
+					return
+				}
+
+				pool, ok := event.Object.(*mcfgv1.MachineConfigPool)
+				if !ok || pool.Name != poolName {
+					continue
+				}
+
+				status := getBuildStatus(pool)
+				if status == last {
+					continue
+				}
+
+				last = status
+
+				select {
+				case out <- status:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	return out, nil
+}
+
+// WaitForBuild waits for the build of the given MachineConfigPool to finish
+// and returns its final status. An error is returned if the build failed.
+func (c *BuildClient) WaitForBuild(ctx context.Context, poolName string) (BuildStatus, error) {
+	statuses, err := c.WatchBuild(ctx, poolName)
+	if err != nil {
+		return BuildStatus{}, err
+	}
+
+	var last BuildStatus
+
+	for status := range statuses {
+		last = status
+
+		if !status.IsDone() {
+			continue
+		}
+
+		if status.Phase == BuildPhaseFailed {
+			return status, fmt.Errorf("build for MachineConfigPool %s failed: %s", poolName, status.Message)
+		}
+
+		return status, nil
+	}
+
+	if ctx.Err() != nil {
+		return last, ctx.Err()
+	}
+
+	return last, fmt.Errorf("watch for MachineConfigPool %s ended before its build finished", poolName)
+}
+
+func getBuildStatus(pool *mcfgv1.MachineConfigPool) BuildStatus {
+	lps := ctrlcommon.NewLayeredPoolState(pool)
+
+	status := BuildStatus{
+		Pool:          pool.Name,
+		MachineConfig: pool.Spec.Configuration.Name,
+		Layered:       lps.IsLayered(),
+	}
+
+	switch {
+	case lps.IsBuildFailure():
+		status.Phase = BuildPhaseFailed
+		status.LogTail = pool.Annotations[build.BuildLogTailAnnotationKey]
+		if cond := apihelpers.GetMachineConfigPoolCondition(pool.Status, mcfgv1.MachineConfigPoolBuildFailed); cond != nil {
+			status.Message = cond.Message
+		}
+	case lps.IsBuildSuccess():
+		status.Phase = BuildPhaseSucceeded
+		status.Image = pool.Annotations[ctrlcommon.ExperimentalNewestLayeredImageEquivalentConfigAnnotationKey]
+	case lps.IsBuilding():
+		status.Phase = BuildPhaseBuilding
+	case lps.IsBuildPending():
+		status.Phase = BuildPhasePending
+	case pool.Annotations[build.BuildQueuedAnnotationKey] != "":
+		status.Phase = BuildPhaseQueued
+	}
+
+	return status
+}
+
+// GetBuilds gets the status of each build of the given MachineConfigPool which
+// is still in its build history, most recently started first.
+func (c *BuildClient) GetBuilds(ctx context.Context, poolName string) ([]ctrlcommon.ImageBuildStatus, error) {
+	cmList, err := c.kubeclient.ConfigMaps(ctrlcommon.MCONamespace).List(ctx, metav1.ListOptions{
+		LabelSelector: build.GetBuildStatusSelector(poolName).String(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not list builds of MachineConfigPool %s: %w", poolName, err)
+	}
+
+	builds := []ctrlcommon.ImageBuildStatus{}
+	for i := range cmList.Items {
+		status, err := ctrlcommon.ParseImageBuildStatus(&cmList.Items[i])
+		if err != nil {
+			return nil, err
+		}
+
+		builds = append(builds, *status)
+	}
+
+	sort.SliceStable(builds, func(i, j int) bool {
+		if builds[i].StartTime == nil || builds[j].StartTime == nil {
+			return builds[j].StartTime == nil && builds[i].StartTime != nil
+		}
+
+		return builds[j].StartTime.Before(builds[i].StartTime)
+	})
+
+	return builds, nil
+}
+
+// WatchBuilds streams the status of each build of the given MachineConfigPool
+// whenever it changes, starting with the current status of each build in its
+// build history. The returned channel is closed once the context is cancelled
+// or the underlying watch ends.
+func (c *BuildClient) WatchBuilds(ctx context.Context, poolName string) (<-chan ctrlcommon.ImageBuildStatus, error) {
+	selector := build.GetBuildStatusSelector(poolName).String()
+
+	cmList, err := c.kubeclient.ConfigMaps(ctrlcommon.MCONamespace).List(ctx, metav1.ListOptions{
+		LabelSelector: selector,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not list builds of MachineConfigPool %s: %w", poolName, err)
+	}
+
+	w, err := c.kubeclient.ConfigMaps(ctrlcommon.MCONamespace).Watch(ctx, metav1.ListOptions{
+		LabelSelector:   selector,
+		ResourceVersion: cmList.ResourceVersion,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not watch builds of MachineConfigPool %s: %w", poolName, err)
+	}
+
+	out := make(chan ctrlcommon.ImageBuildStatus)
+
+	go func() {
+		defer close(out)
+		defer w.Stop()
+
+		send := func(cm *corev1.ConfigMap) bool {
+			status, err := ctrlcommon.ParseImageBuildStatus(cm)
+			if err != nil {
+				return true
+			}
+
+			select {
+			case out <- *status:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		for i := range cmList.Items {
+			if !send(&cmList.Items[i]) {
+				return
+			}
+		}
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case event, ok := <-w.ResultChan():
+				if !ok {
+					return
+				}
+
+				if event.Type != watch.Added && event.Type != watch.Modified {
+					continue
+				}
+
+				cm, ok := event.Object.(*corev1.ConfigMap)
+				if !ok {
+					continue
+				}
+
+				if !send(cm) {
+					return
+				}
+			}
+		}
+	}()
+
+	return out, nil
+}
@@ -0,0 +1,271 @@
+package clients
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	fakemcfgclientset "github.com/openshift/client-go/machineconfiguration/clientset/versioned/fake"
+	"github.com/openshift/machine-config-operator/pkg/apihelpers"
+	"github.com/openshift/machine-config-operator/pkg/controller/build"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	fakeclientset "k8s.io/client-go/kubernetes/fake"
+)
+
+func newPool(name string, layered bool) *mcfgv1.MachineConfigPool {
+	pool := &mcfgv1.MachineConfigPool{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   name,
+			Labels: map[string]string{},
+		},
+		Spec: mcfgv1.MachineConfigPoolSpec{
+			Configuration: mcfgv1.MachineConfigPoolStatusConfiguration{
+				ObjectReference: corev1.ObjectReference{Name: "rendered-" + name + "-1"},
+			},
+		},
+	}
+
+	if layered {
+		pool.Labels[ctrlcommon.LayeringEnabledPoolLabel] = ""
+	}
+
+	return pool
+}
+
+func newTestBuildClient(t *testing.T, pools ...*mcfgv1.MachineConfigPool) (*BuildClient, *fakeclientset.Clientset, *fakemcfgclientset.Clientset) {
+	kubeclient := fakeclientset.NewSimpleClientset()
+
+	mcfgclient := fakemcfgclientset.NewSimpleClientset()
+	for _, pool := range pools {
+		require.NoError(t, mcfgclient.Tracker().Add(pool))
+	}
+
+	return NewBuildClient(kubeclient.CoreV1(), mcfgclient.MachineconfigurationV1()), kubeclient, mcfgclient
+}
+
+func TestConfigureOnClusterBuilds(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	c, kubeclient, _ := newTestBuildClient(t)
+
+	cfg := OnClusterBuildConfig{
+		BaseImagePullSecretName:  "base-pull-secret",
+		FinalImagePushSecretName: "final-push-secret",
+		FinalImagePullspec:       "registry.hostname.com/org/repo:latest",
+		AdditionalConfig: map[string]string{
+			build.BuildTimeoutConfigKey: "1h",
+		},
+	}
+
+	require.NoError(t, c.ConfigureOnClusterBuilds(ctx, cfg))
+	require.NoError(t, c.SetCustomDockerfile(ctx, "worker", "FROM configs AS final"))
+
+	cm, err := kubeclient.CoreV1().ConfigMaps(ctrlcommon.MCONamespace).Get(ctx, build.OnClusterBuildConfigMapName, metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		build.BaseImagePullSecretNameConfigKey:  "base-pull-secret",
+		build.FinalImagePushSecretNameConfigKey: "final-push-secret",
+		build.FinalImagePullspecConfigKey:       "registry.hostname.com/org/repo:latest",
+		build.BuildTimeoutConfigKey:             "1h",
+	}, cm.Data)
+
+	// Updates keep the keys which are not described by the config.
+	cfg.AdditionalConfig = nil
+	cfg.ImageBuilderType = build.CustomPodImageBuilder
+	require.NoError(t, c.ConfigureOnClusterBuilds(ctx, cfg))
+
+	cm, err = kubeclient.CoreV1().ConfigMaps(ctrlcommon.MCONamespace).Get(ctx, build.OnClusterBuildConfigMapName, metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "1h", cm.Data[build.BuildTimeoutConfigKey])
+	assert.Equal(t, build.CustomPodImageBuilder, cm.Data[build.ImageBuilderTypeConfigMapKey])
+
+	cm, err = kubeclient.CoreV1().ConfigMaps(ctrlcommon.MCONamespace).Get(ctx, build.CustomDockerfileConfigMapName, metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"worker": "FROM configs AS final"}, cm.Data)
+}
+
+func TestTriggerAndCancelBuild(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	c, _, mcfgclient := newTestBuildClient(t, newPool("worker", false))
+
+	getPool := func() *mcfgv1.MachineConfigPool {
+		pool, err := mcfgclient.MachineconfigurationV1().MachineConfigPools().Get(ctx, "worker", metav1.GetOptions{})
+		require.NoError(t, err)
+		return pool
+	}
+
+	// The first build opts the pool in.
+	require.NoError(t, c.TriggerBuild(ctx, "worker"))
+	pool := getPool()
+	assert.True(t, ctrlcommon.IsLayeredPool(pool))
+	assert.NotContains(t, pool.Annotations, build.RebuildAnnotationKey)
+
+	// Subsequent builds request a rebuild.
+	require.NoError(t, c.TriggerBuild(ctx, "worker"))
+	pool = getPool()
+	assert.True(t, ctrlcommon.IsLayeredPool(pool))
+	assert.NotEmpty(t, pool.Annotations[build.RebuildAnnotationKey])
+
+	// Cancelling a build leaves the pool opted in.
+	require.NoError(t, c.CancelBuild(ctx, "worker"))
+	pool = getPool()
+	assert.True(t, ctrlcommon.IsLayeredPool(pool))
+	assert.NotEmpty(t, pool.Annotations[build.CancelBuildAnnotationKey])
+
+	assert.Error(t, c.TriggerBuild(ctx, "missing"))
+}
+
+func TestWatchBuild(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	t.Cleanup(cancel)
+
+	c, _, mcfgclient := newTestBuildClient(t, newPool("worker", true))
+
+	statuses, err := c.WatchBuild(ctx, "worker")
+	require.NoError(t, err)
+
+	// The current status is sent first.
+	status := <-statuses
+	assert.Equal(t, BuildStatus{Pool: "worker", MachineConfig: "rendered-worker-1", Layered: true}, status)
+	assert.False(t, status.IsDone())
+
+	setCondition := func(condType mcfgv1.MachineConfigPoolConditionType, msg string) {
+		pool, err := mcfgclient.MachineconfigurationV1().MachineConfigPools().Get(ctx, "worker", metav1.GetOptions{})
+		require.NoError(t, err)
+
+		cond := apihelpers.NewMachineConfigPoolCondition(condType, corev1.ConditionTrue, "", msg)
+		apihelpers.SetMachineConfigPoolCondition(&pool.Status, *cond)
+
+		_, err = mcfgclient.MachineconfigurationV1().MachineConfigPools().UpdateStatus(ctx, pool, metav1.UpdateOptions{})
+		require.NoError(t, err)
+	}
+
+	setCondition(mcfgv1.MachineConfigPoolBuilding, "")
+	status = <-statuses
+	assert.Equal(t, BuildPhaseBuilding, status.Phase)
+
+	setCondition(mcfgv1.MachineConfigPoolBuildFailed, "out of memory")
+	status = <-statuses
+	assert.Equal(t, BuildPhaseFailed, status.Phase)
+	assert.Equal(t, "out of memory", status.Message)
+	assert.True(t, status.IsDone())
+
+	cancel()
+
+	// The channel is closed once the context is cancelled.
+	_, ok := <-statuses
+	assert.False(t, ok)
+}
+
+func TestWaitForBuild(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	t.Cleanup(cancel)
+
+	pool := newPool("worker", true)
+	pool.Annotations = map[string]string{
+		ctrlcommon.ExperimentalNewestLayeredImageEquivalentConfigAnnotationKey: "registry.hostname.com/org/repo@sha256:abc",
+	}
+	cond := apihelpers.NewMachineConfigPoolCondition(mcfgv1.MachineConfigPoolBuildSuccess, corev1.ConditionTrue, "", "")
+	apihelpers.SetMachineConfigPoolCondition(&pool.Status, *cond)
+
+	c, _, _ := newTestBuildClient(t, pool)
+
+	status, err := c.WaitForBuild(ctx, "worker")
+	require.NoError(t, err)
+	assert.Equal(t, BuildPhaseSucceeded, status.Phase)
+	assert.Equal(t, "registry.hostname.com/org/repo@sha256:abc", status.Image)
+}
+
+func newBuildStatusConfigMap(t *testing.T, status ctrlcommon.ImageBuildStatus) *corev1.ConfigMap {
+	out, err := json.Marshal(status)
+	require.NoError(t, err)
+
+	return &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "build-status-" + status.MachineConfig,
+			Namespace: ctrlcommon.MCONamespace,
+			Labels: map[string]string{
+				build.BuildStatusLabel: "",
+				"machineconfiguration.openshift.io/targetMachineConfigPool": status.Pool,
+			},
+		},
+		Data: map[string]string{
+			ctrlcommon.BuildStatusConfigMapKey: string(out),
+		},
+	}
+}
+
+func TestGetBuilds(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	c, kubeclient, _ := newTestBuildClient(t)
+
+	older := metav1.NewTime(time.Now().Add(-time.Hour))
+	newer := metav1.Now()
+
+	for _, status := range []ctrlcommon.ImageBuildStatus{
+		{Pool: "worker", MachineConfig: "rendered-worker-1", Phase: ctrlcommon.ImageBuildPhaseSucceeded, StartTime: &older},
+		{Pool: "worker", MachineConfig: "rendered-worker-2", Phase: ctrlcommon.ImageBuildPhaseBuilding, StartTime: &newer},
+		{Pool: "infra", MachineConfig: "rendered-infra-1", Phase: ctrlcommon.ImageBuildPhasePending, StartTime: &newer},
+	} {
+		_, err := kubeclient.CoreV1().ConfigMaps(ctrlcommon.MCONamespace).Create(ctx, newBuildStatusConfigMap(t, status), metav1.CreateOptions{})
+		require.NoError(t, err)
+	}
+
+	builds, err := c.GetBuilds(ctx, "worker")
+	require.NoError(t, err)
+	require.Len(t, builds, 2)
+	assert.Equal(t, "rendered-worker-2", builds[0].MachineConfig)
+	assert.Equal(t, "rendered-worker-1", builds[1].MachineConfig)
+}
+
+func TestWatchBuilds(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	t.Cleanup(cancel)
+
+	c, kubeclient, _ := newTestBuildClient(t)
+
+	cm := newBuildStatusConfigMap(t, ctrlcommon.ImageBuildStatus{Pool: "worker", MachineConfig: "rendered-worker-1", Phase: ctrlcommon.ImageBuildPhasePending})
+	cm, err := kubeclient.CoreV1().ConfigMaps(ctrlcommon.MCONamespace).Create(ctx, cm, metav1.CreateOptions{})
+	require.NoError(t, err)
+
+	statuses, err := c.WatchBuilds(ctx, "worker")
+	require.NoError(t, err)
+
+	// The current status of each build is sent first.
+	status := <-statuses
+	assert.Equal(t, ctrlcommon.ImageBuildPhasePending, status.Phase)
+
+	cm.Data = newBuildStatusConfigMap(t, ctrlcommon.ImageBuildStatus{Pool: "worker", MachineConfig: "rendered-worker-1", Phase: ctrlcommon.ImageBuildPhaseSucceeded, Image: "registry.hostname.com/org/repo@sha256:abc"}).Data
+	_, err = kubeclient.CoreV1().ConfigMaps(ctrlcommon.MCONamespace).Update(ctx, cm, metav1.UpdateOptions{})
+	require.NoError(t, err)
+
+	status = <-statuses
+	assert.Equal(t, ctrlcommon.ImageBuildPhaseSucceeded, status.Phase)
+	assert.Equal(t, "registry.hostname.com/org/repo@sha256:abc", status.Image)
+
+	cancel()
+
+	// The channel is closed once the context is cancelled.
+	_, ok := <-statuses
+	assert.False(t, ok)
+}
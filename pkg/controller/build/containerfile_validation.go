@@ -0,0 +1,275 @@
+package build
+
+import (
+	"bufio"
+	"fmt"
+	"regexp"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+const (
+	// The name of the stage in the on-cluster build Containerfile which
+	// contains the rendered MachineConfig. Custom Containerfiles must build
+	// upon it, otherwise the MachineConfig will not be part of the final image.
+	configsStageName = "configs"
+
+	// The maximum size of a custom Containerfile for a single pool.
+	maxCustomDockerfileSize = 64 * 1024
+
+	// Condition reason used on the BuildFailed condition when the custom
+	// Containerfile for a pool is rejected before the build starts.
+	invalidContainerfileReason = "InvalidContainerfile"
+)
+
+// Instructions which may not be used in custom Containerfiles, along with why.
+// These either have no meaning for an OS image or change how the image is
+// run in ways that break the node.
+var disallowedContainerfileInstructions = map[string]string{
+	"CMD":         "OS images are not run as containers",
+	"ENTRYPOINT":  "OS images are not run as containers",
+	"EXPOSE":      "OS images are not run as containers",
+	"HEALTHCHECK": "OS images are not run as containers",
+	"ONBUILD":     "OS images are not used as base images by the build",
+	"STOPSIGNAL":  "OS images are not run as containers",
+	"VOLUME":      "volumes are not part of the OS image",
+}
+
+// All of the instructions understood by Buildah.
+var knownContainerfileInstructions = map[string]bool{
+	"ADD":         true,
+	"ARG":         true,
+	"CMD":         true,
+	"COPY":        true,
+	"ENTRYPOINT":  true,
+	"ENV":         true,
+	"EXPOSE":      true,
+	"FROM":        true,
+	"HEALTHCHECK": true,
+	"LABEL":       true,
+	"MAINTAINER":  true,
+	"ONBUILD":     true,
+	"RUN":         true,
+	"SHELL":       true,
+	"STOPSIGNAL":  true,
+	"USER":        true,
+	"VOLUME":      true,
+	"WORKDIR":     true,
+}
+
+// Matches heredocs (e.g., RUN <<EOF) so that their bodies are not mistaken
+// for instructions.
+var containerfileHeredocRegex = regexp.MustCompile(`<<-?["']?([A-Za-z0-9_]+)["']?`)
+
+// An instruction in a Containerfile along with the line it starts on.
+type containerfileInstruction struct {
+	line int
+	name string
+	args []string
+}
+
+// Splits a Containerfile into its instructions, joining continuation lines and
+// skipping comments and heredoc bodies.
+func parseContainerfileInstructions(containerfile string) ([]containerfileInstruction, error) {
+	instructions := []containerfileInstruction{}
+
+	scanner := bufio.NewScanner(strings.NewReader(containerfile))
+	scanner.Buffer(make([]byte, 0, 64*1024), maxCustomDockerfileSize)
+
+	lineNum := 0
+	startLine := 0
+	current := ""
+	heredoc := ""
+
+	for scanner.Scan() {
+		lineNum++
+		line := scanner.Text()
+
+		if heredoc != "" {
+			if strings.TrimSpace(line) == heredoc {
+				heredoc = ""
+			}
+			continue
+		}
+
+		trimmed := strings.TrimSpace(line)
+		if current == "" && (trimmed == "" || strings.HasPrefix(trimmed, "#")) {
+			continue
+		}
+
+		if current == "" {
+			startLine = lineNum
+		}
+
+		if strings.HasSuffix(trimmed, "\\") {
+			current += strings.TrimSuffix(trimmed, "\\") + " "
+			continue
+		}
+
+		current += trimmed
+
+		if match := containerfileHeredocRegex.FindStringSubmatch(current); match != nil {
+			heredoc = match[1]
+		}
+
+		fields := strings.Fields(current)
+		instructions = append(instructions, containerfileInstruction{
+			line: startLine,
+			name: strings.ToUpper(fields[0]),
+			args: fields[1:],
+		})
+
+		current = ""
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if current != "" {
+		return nil, fmt.Errorf("line %d: unterminated line continuation", startLine)
+	}
+
+	if heredoc != "" {
+		return nil, fmt.Errorf("unterminated heredoc %q", heredoc)
+	}
+
+	return instructions, nil
+}
+
+// Validates a custom Containerfile for the given pool before it is appended
+// to the on-cluster build Containerfile. The custom Containerfile must start
+// its final stage from the configs stage (or a stage based on it), may not use
+// instructions which do not make sense for an OS image, and must be within the
+// size limit.
+func validateCustomDockerfile(poolName, containerfile string) error {
+	if len(containerfile) > maxCustomDockerfileSize {
+		return fmt.Errorf("custom Containerfile for pool %s is %d bytes, which exceeds the limit of %d bytes", poolName, len(containerfile), maxCustomDockerfileSize)
+	}
+
+	instructions, err := parseContainerfileInstructions(containerfile)
+	if err != nil {
+		return fmt.Errorf("could not parse custom Containerfile for pool %s: %w", poolName, err)
+	}
+
+	errs := []error{}
+
+	// Tracks which stages are based on the configs stage.
+	basedOnConfigs := map[string]bool{configsStageName: true}
+	finalStageBasedOnConfigs := false
+	hasFrom := false
+
+	for _, instruction := range instructions {
+		if !knownContainerfileInstructions[instruction.name] {
+			errs = append(errs, fmt.Errorf("line %d: unknown instruction %s", instruction.line, instruction.name))
+			continue
+		}
+
+		if reason, ok := disallowedContainerfileInstructions[instruction.name]; ok {
+			errs = append(errs, fmt.Errorf("line %d: %s is not allowed since %s", instruction.line, instruction.name, reason))
+			continue
+		}
+
+		switch instruction.name {
+		case "FROM":
+			hasFrom = true
+
+			image := ""
+			for _, arg := range instruction.args {
+				if !strings.HasPrefix(arg, "--") {
+					image = strings.ToLower(arg)
+					break
+				}
+			}
+
+			finalStageBasedOnConfigs = basedOnConfigs[image]
+
+			for i, arg := range instruction.args {
+				if strings.EqualFold(arg, "AS") && i+1 < len(instruction.args) {
+					basedOnConfigs[strings.ToLower(instruction.args[i+1])] = finalStageBasedOnConfigs
+				}
+			}
+		case "USER":
+			// The OS image must be committed by root.
+			user := ""
+			if len(instruction.args) == 1 {
+				user = strings.SplitN(instruction.args[0], ":", 2)[0]
+			}
+			if user != "root" && user != "0" {
+				errs = append(errs, fmt.Errorf("line %d: USER must be root", instruction.line))
+			}
+		}
+	}
+
+	if !hasFrom {
+		errs = append(errs, fmt.Errorf("missing FROM %s", configsStageName))
+	} else if !finalStageBasedOnConfigs {
+		errs = append(errs, fmt.Errorf("final stage must be based on the %s stage (FROM %s)", configsStageName, configsStageName))
+	}
+
+	if len(errs) != 0 {
+		return fmt.Errorf("invalid custom Containerfile for pool %s: %w", poolName, kerrors.NewAggregate(errs))
+	}
+
+	return nil
+}
+
+// Validates the entries of the on-cluster-build-custom-dockerfile ConfigMap.
+// Every key must name one of the given MachineConfigPools, may only be set
+// once and must hold a valid, non-empty custom Containerfile. Builds only read
+// the data of the ConfigMap, so a pool set in its binaryData is rejected.
+func ValidateCustomDockerfileConfigMap(cm *corev1.ConfigMap, poolNames []string) error {
+	if cm == nil {
+		return nil
+	}
+
+	pools := sets.New(poolNames...)
+
+	errs := []error{}
+	for _, poolName := range sets.List(sets.KeySet(cm.Data).Union(sets.KeySet(cm.BinaryData))) {
+		if !pools.Has(poolName) {
+			if _, ok := cm.Data[poolName]; ok {
+				errs = append(errs, fmt.Errorf("key %q does not name a MachineConfigPool", poolName))
+				continue
+			}
+		}
+
+		if err := validatePoolCustomDockerfile(cm, poolName); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if len(errs) != 0 {
+		return fmt.Errorf("invalid %s ConfigMap: %w", CustomDockerfileConfigMapName, kerrors.NewAggregate(errs))
+	}
+
+	return nil
+}
+
+// Validates the entry of the given MachineConfigPool in the
+// on-cluster-build-custom-dockerfile ConfigMap, if it has one. Only the build
+// of that pool depends on it, so an invalid entry only fails that build.
+func validatePoolCustomDockerfile(cm *corev1.ConfigMap, poolName string) error {
+	if cm == nil {
+		return nil
+	}
+
+	containerfile, inData := cm.Data[poolName]
+	_, inBinaryData := cm.BinaryData[poolName]
+
+	switch {
+	case inData && inBinaryData:
+		return fmt.Errorf("pool %s is set in both data and binaryData", poolName)
+	case inBinaryData:
+		return fmt.Errorf("custom Containerfile for pool %s must be set in data, not binaryData", poolName)
+	case !inData:
+		return nil
+	case strings.TrimSpace(containerfile) == "":
+		return fmt.Errorf("custom Containerfile for pool %s is empty, remove the key instead", poolName)
+	}
+
+	return validateCustomDockerfile(poolName, containerfile)
+}
@@ -0,0 +1,204 @@
+package build
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Tests that custom Containerfiles are validated before they are built.
+func TestValidateCustomDockerfile(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name          string
+		containerfile string
+		errContains   []string
+	}{
+		{
+			name:          "final stage from configs",
+			containerfile: "FROM configs AS final\nRUN dnf install -y python3",
+		},
+		{
+			name: "final stage from a stage based on configs",
+			containerfile: strings.Join([]string{
+				"# Build a binary in a separate stage.",
+				"FROM quay.io/fedora/fedora:latest AS builder",
+				"RUN make",
+				"from configs as intermediate",
+				"COPY --from=builder /bin/app /usr/bin/app",
+				"FROM --platform=linux/amd64 intermediate AS final",
+				"USER root",
+				"RUN rpm-ostree install \\",
+				"    python3 \\",
+				"    && ostree container commit",
+			}, "\n"),
+		},
+		{
+			name: "heredoc bodies are not instructions",
+			containerfile: strings.Join([]string{
+				"FROM configs AS final",
+				"RUN <<EOF",
+				"CMD is just text here",
+				"EOF",
+			}, "\n"),
+		},
+		{
+			name:          "missing FROM",
+			containerfile: "RUN dnf install -y python3",
+			errContains:   []string{"missing FROM configs"},
+		},
+		{
+			name:          "final stage not based on configs",
+			containerfile: "FROM configs AS final\nRUN echo hello\nFROM quay.io/fedora/fedora:latest",
+			errContains:   []string{"final stage must be based on the configs stage"},
+		},
+		{
+			name:          "disallowed instructions",
+			containerfile: "FROM configs AS final\nENTRYPOINT [\"/bin/bash\"]\nvolume /data",
+			errContains: []string{
+				"line 2: ENTRYPOINT is not allowed",
+				"line 3: VOLUME is not allowed",
+			},
+		},
+		{
+			name:          "non-root user",
+			containerfile: "FROM configs AS final\nUSER core",
+			errContains:   []string{"line 2: USER must be root"},
+		},
+		{
+			name:          "unknown instruction",
+			containerfile: "FROM configs AS final\nRUNN echo hello",
+			errContains:   []string{"line 2: unknown instruction RUNN"},
+		},
+		{
+			name:          "unterminated continuation",
+			containerfile: "FROM configs AS final\nRUN echo hello \\",
+			errContains:   []string{"line 2: unterminated line continuation"},
+		},
+		{
+			name:          "too large",
+			containerfile: "FROM configs AS final\n" + strings.Repeat("RUN echo hello\n", maxCustomDockerfileSize/10),
+			errContains:   []string{"exceeds the limit"},
+		},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			err := validateCustomDockerfile("worker", testCase.containerfile)
+			if len(testCase.errContains) == 0 {
+				assert.NoError(t, err)
+				return
+			}
+
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), "pool worker")
+			for _, msg := range testCase.errContains {
+				assert.Contains(t, err.Error(), msg)
+			}
+		})
+	}
+}
+
+// Tests that every entry of the custom Dockerfile ConfigMap is validated.
+func TestValidateCustomDockerfileConfigMap(t *testing.T) {
+	t.Parallel()
+
+	poolNames := []string{"master", "worker", "infra"}
+
+	assert.NoError(t, ValidateCustomDockerfileConfigMap(nil, poolNames))
+
+	cm := getCustomDockerfileConfigMap(map[string]string{
+		"worker": "FROM configs AS final\nRUN dnf install -y python3",
+	})
+	assert.NoError(t, ValidateCustomDockerfileConfigMap(cm, poolNames))
+
+	testCases := []struct {
+		name        string
+		data        map[string]string
+		binaryData  map[string][]byte
+		errContains string
+	}{
+		{
+			name:        "invalid Containerfile",
+			data:        map[string]string{"infra": "RUN dnf install -y python3"},
+			errContains: "pool infra",
+		},
+		{
+			name:        "nonexistent pool",
+			data:        map[string]string{"workers": "FROM configs AS final"},
+			errContains: `key "workers" does not name a MachineConfigPool`,
+		},
+		{
+			name:        "empty Containerfile",
+			data:        map[string]string{"master": " \n"},
+			errContains: "custom Containerfile for pool master is empty",
+		},
+		{
+			name:        "duplicate pool",
+			data:        map[string]string{"master": "FROM configs AS final"},
+			binaryData:  map[string][]byte{"master": []byte("FROM configs AS final")},
+			errContains: "pool master is set in both data and binaryData",
+		},
+		{
+			name:        "binaryData only",
+			binaryData:  map[string][]byte{"master": []byte("FROM configs AS final")},
+			errContains: "pool master must be set in data",
+		},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			cm := getCustomDockerfileConfigMap(map[string]string{
+				"worker": "FROM configs AS final\nRUN dnf install -y python3",
+			})
+			for key, val := range testCase.data {
+				cm.Data[key] = val
+			}
+			cm.BinaryData = testCase.binaryData
+
+			err := ValidateCustomDockerfileConfigMap(cm, poolNames)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), testCase.errContains)
+			assert.NotContains(t, err.Error(), "pool worker")
+		})
+	}
+}
+
+func TestValidatePoolCustomDockerfile(t *testing.T) {
+	t.Parallel()
+
+	assert.NoError(t, validatePoolCustomDockerfile(nil, "worker"))
+
+	cm := getCustomDockerfileConfigMap(map[string]string{
+		"worker": "FROM configs AS final\nRUN dnf install -y python3",
+		"infra":  "FROM configs AS final\nENTRYPOINT [\"/bin/bash\"]",
+		"master": " \n",
+		"stale":  "FROM configs AS final",
+	})
+	cm.BinaryData = map[string][]byte{"edge": []byte("FROM configs AS final")}
+
+	// An invalid entry for another pool does not affect this one.
+	assert.NoError(t, validatePoolCustomDockerfile(cm, "worker"))
+	assert.NoError(t, validatePoolCustomDockerfile(cm, "gpu"))
+
+	err := validatePoolCustomDockerfile(cm, "infra")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "ENTRYPOINT is not allowed")
+
+	err = validatePoolCustomDockerfile(cm, "master")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "custom Containerfile for pool master is empty")
+
+	err = validatePoolCustomDockerfile(cm, "edge")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "must be set in data, not binaryData")
+}
@@ -0,0 +1,62 @@
+package build
+
+import (
+	"fmt"
+	"strings"
+
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+)
+
+const (
+	missingExtensionsImageReason = "MissingExtensionsImage"
+)
+
+// Gets the packages which make up the extensions enabled in the given rendered
+// MachineConfig, in the order the extensions are listed. The MCD does not
+// install extensions onto layered nodes, so they are installed into the image
+// instead. Extensions which are not known to be RHCOS extensions are installed
+// as a package of the same name, which is what the MCD does on FCOS.
+func getExtensionPackages(mc *mcfgv1.MachineConfig) []string {
+	if mc == nil {
+		return nil
+	}
+
+	supported := ctrlcommon.SupportedExtensions()
+
+	seen := map[string]struct{}{}
+	pkgs := []string{}
+
+	for _, ext := range mc.Spec.Extensions {
+		extPkgs, ok := supported[ext]
+		if !ok {
+			extPkgs = []string{ext}
+		}
+
+		for _, pkg := range extPkgs {
+			if _, ok := seen[pkg]; ok {
+				continue
+			}
+
+			seen[pkg] = struct{}{}
+			pkgs = append(pkgs, pkg)
+		}
+	}
+
+	return pkgs
+}
+
+// Validates that the extensions enabled in the rendered MachineConfig can be
+// installed into the image, which requires the extensions image from the
+// machine-config-osimageurl ConfigMap.
+func validateExtensionsConfig(inputs *buildInputs) error {
+	if len(inputs.machineConfig.Spec.Extensions) == 0 {
+		return nil
+	}
+
+	if inputs.osImageURL.Data[baseOSExtensionsContainerImageConfigKey] != "" {
+		return nil
+	}
+
+	return fmt.Errorf("MachineConfig %s enables extensions %s but %s does not have %s set", inputs.machineConfig.Name, strings.Join(inputs.machineConfig.Spec.Extensions, ", "), machineConfigOSImageURLConfigMapName, baseOSExtensionsContainerImageConfigKey)
+}
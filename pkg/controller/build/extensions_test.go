@@ -0,0 +1,81 @@
+package build
+
+import (
+	"strings"
+	"testing"
+
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func newExtensionsMachineConfig(extensions ...string) *mcfgv1.MachineConfig {
+	return &mcfgv1.MachineConfig{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "rendered-worker-1",
+		},
+		Spec: mcfgv1.MachineConfigSpec{
+			Extensions: extensions,
+		},
+	}
+}
+
+func TestGetExtensionPackages(t *testing.T) {
+	t.Parallel()
+
+	assert.Nil(t, getExtensionPackages(nil))
+	assert.Empty(t, getExtensionPackages(newExtensionsMachineConfig()))
+
+	// Known extensions map to their packages, unknown ones are installed as a
+	// package of the same name and duplicate packages are only installed once.
+	pkgs := getExtensionPackages(newExtensionsMachineConfig("kerberos", "usbguard", "kernel-rt", "usbguard"))
+	assert.Equal(t, []string{"krb5-workstation", "libkadm5", "usbguard", "kernel-rt"}, pkgs)
+}
+
+func TestValidateExtensionsConfig(t *testing.T) {
+	t.Parallel()
+
+	inputs := &buildInputs{
+		osImageURL:    getOSImageURLConfigMap(),
+		machineConfig: newExtensionsMachineConfig("usbguard"),
+	}
+
+	assert.NoError(t, validateExtensionsConfig(inputs))
+
+	delete(inputs.osImageURL.Data, baseOSExtensionsContainerImageConfigKey)
+	assert.Error(t, validateExtensionsConfig(inputs))
+
+	inputs.machineConfig = newExtensionsMachineConfig()
+	assert.NoError(t, validateExtensionsConfig(inputs))
+}
+
+// Tests that the extensions of the rendered MachineConfig are installed from
+// the extensions image after the custom Dockerfile.
+func TestImageBuildRequestWithExtensions(t *testing.T) {
+	t.Parallel()
+
+	customDockerfile := "FROM configs AS final\nRUN dnf install -y python3"
+
+	ibr := newImageBuildRequestFromBuildInputs(&buildInputs{
+		pool:                 newMachineConfigPool("worker", "rendered-worker-1"),
+		osImageURL:           getOSImageURLConfigMap(),
+		onClusterBuildConfig: getOnClusterBuildConfigMap(),
+		customDockerfiles:    getCustomDockerfileConfigMap(map[string]string{"worker": customDockerfile}),
+		machineConfig:        newExtensionsMachineConfig("usbguard", "kerberos"),
+	})
+
+	dockerfile, err := ibr.renderDockerfile()
+	require.NoError(t, err)
+
+	assert.Contains(t, dockerfile, "AS extensions")
+	assert.Contains(t, dockerfile, "--mount=type=bind,from=extensions")
+	assert.Contains(t, dockerfile, "rpm-ostree install usbguard krb5-workstation libkadm5 &&")
+	assert.Greater(t, strings.Index(dockerfile, "rpm-ostree install"), strings.Index(dockerfile, customDockerfile))
+
+	// Without extensions, nothing is installed.
+	ibr.ExtensionPackages = nil
+	dockerfile, err = ibr.renderDockerfile()
+	require.NoError(t, err)
+	assert.NotContains(t, dockerfile, "rpm-ostree install")
+}
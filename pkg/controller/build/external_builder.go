@@ -0,0 +1,165 @@
+package build
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clientset "k8s.io/client-go/kubernetes"
+)
+
+const (
+	// The on-cluster-build-config ConfigMap key which contains the https URL
+	// of the external build service API (e.g.,
+	// "https://builds.example.com/api/v1/builds"). Build requests are POSTed to
+	// it and their status is read from "<URL>/<build ID>".
+	ExternalBuildServiceURLConfigKey = "externalBuildServiceURL"
+
+	// The on-cluster-build-config ConfigMap key which contains the name of the
+	// Secret with the bearer token (token) and, optionally, the CA certificate
+	// (ca.crt) used to talk to the external build service.
+	ExternalBuildServiceSecretNameConfigKey = "externalBuildServiceSecretName"
+
+	externalBuildServiceTokenKey    = "token"
+	externalBuildServiceVolumeName  = "external-build-service-creds"
+	externalBuildServiceMountpoint  = "/tmp/external-build-service-creds"
+	externalBuildServicePollSeconds = "10"
+)
+
+// Describes the external build service (e.g., an adapter for Quay build
+// triggers or Konflux) which builds and pushes the image for the external
+// build service builder. The build pod only submits the build request and
+// waits for the digest of the pushed image.
+type externalBuildService struct {
+	URL    string
+	Secret string
+}
+
+// Gets the external build service from the on-cluster-build-config ConfigMap.
+// Returns nil if the external build service is not the configured image
+// builder.
+func getExternalBuildService(cm *corev1.ConfigMap) (*externalBuildService, error) {
+	if cm == nil || cm.Data[ImageBuilderTypeConfigMapKey] != ExternalBuildServiceImageBuilder {
+		return nil, nil
+	}
+
+	for _, key := range []string{ExternalBuildServiceURLConfigKey, ExternalBuildServiceSecretNameConfigKey} {
+		if cm.Data[key] == "" {
+			return nil, fmt.Errorf("%s %q requires %s to be set", ImageBuilderTypeConfigMapKey, ExternalBuildServiceImageBuilder, key)
+		}
+	}
+
+	u, err := url.Parse(cm.Data[ExternalBuildServiceURLConfigKey])
+	if err != nil {
+		return nil, fmt.Errorf("could not parse %s %q: %w", ExternalBuildServiceURLConfigKey, cm.Data[ExternalBuildServiceURLConfigKey], err)
+	}
+
+	if u.Scheme != "https" || u.Host == "" {
+		return nil, fmt.Errorf("%s %q must be an https:// URL", ExternalBuildServiceURLConfigKey, cm.Data[ExternalBuildServiceURLConfigKey])
+	}
+
+	// The external build service runs the build, so it is the one to test the
+	// image and to provide any Secrets the build needs.
+	if getPostBuildTestCommand(cm) != "" {
+		return nil, fmt.Errorf("%s is not supported by %s %q", PostBuildTestCommandConfigKey, ImageBuilderTypeConfigMapKey, ExternalBuildServiceImageBuilder)
+	}
+
+	userVolumes, err := getUserBuildVolumes(cm)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(userVolumes) != 0 {
+		return nil, fmt.Errorf("additional build Secrets and ConfigMaps are not supported by %s %q", ImageBuilderTypeConfigMapKey, ExternalBuildServiceImageBuilder)
+	}
+
+	return &externalBuildService{
+		URL:    cm.Data[ExternalBuildServiceURLConfigKey],
+		Secret: cm.Data[ExternalBuildServiceSecretNameConfigKey],
+	}, nil
+}
+
+// Validates the external build service configuration from the
+// on-cluster-build-config ConfigMap, including that the Secret has a token.
+func validateExternalBuildServiceConfig(kubeclient clientset.Interface, cm *corev1.ConfigMap) error {
+	ebs, err := getExternalBuildService(cm)
+	if err != nil {
+		return err
+	}
+
+	if ebs == nil {
+		return nil
+	}
+
+	secret, err := kubeclient.CoreV1().Secrets(ctrlcommon.MCONamespace).Get(context.TODO(), ebs.Secret, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("could not get external build service secret %s from %s: %w", ebs.Secret, OnClusterBuildConfigMapName, err)
+	}
+
+	if len(secret.Data[externalBuildServiceTokenKey]) == 0 {
+		return fmt.Errorf("external build service secret %s is missing key %q", ebs.Secret, externalBuildServiceTokenKey)
+	}
+
+	return nil
+}
+
+// Creates a build pod which submits the build to the external build service
+// and waits for it to push the final image. The base OS image contains curl
+// and jq, which is all it needs.
+func (i ImageBuildRequest) toExternalBuildPod() *corev1.Pod {
+	pod := i.toBuildahPod()
+
+	for idx := range pod.Spec.Containers {
+		container := &pod.Spec.Containers[idx]
+		if container.Name != "image-build" {
+			continue
+		}
+
+		container.Image = i.BaseImage.Pullspec
+		container.Command = []string{"/bin/bash", "-c", externalBuildScript}
+		container.Env = append(container.Env,
+			corev1.EnvVar{
+				Name:  "EXTERNAL_BUILD_SERVICE_URL",
+				Value: i.ExternalBuildService.URL,
+			},
+			corev1.EnvVar{
+				Name:  "EXTERNAL_BUILD_SERVICE_CREDS_DIR",
+				Value: externalBuildServiceMountpoint,
+			},
+			corev1.EnvVar{
+				Name:  "EXTERNAL_BUILD_SERVICE_POLL_SECONDS",
+				Value: externalBuildServicePollSeconds,
+			},
+			corev1.EnvVar{
+				Name:  "POOL_NAME",
+				Value: i.Pool.Name,
+			},
+			corev1.EnvVar{
+				Name:  "RENDERED_CONFIG",
+				Value: i.Pool.Spec.Configuration.Name,
+			},
+			corev1.EnvVar{
+				Name:  "BASE_IMAGE",
+				Value: i.BaseImage.Pullspec,
+			},
+		)
+		container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+			Name:      externalBuildServiceVolumeName,
+			MountPath: externalBuildServiceMountpoint,
+		})
+	}
+
+	pod.Spec.Volumes = append(pod.Spec.Volumes, corev1.Volume{
+		Name: externalBuildServiceVolumeName,
+		VolumeSource: corev1.VolumeSource{
+			Secret: &corev1.SecretVolumeSource{
+				SecretName: i.ExternalBuildService.Secret,
+			},
+		},
+	})
+
+	return pod
+}
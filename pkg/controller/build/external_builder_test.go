@@ -0,0 +1,187 @@
+package build
+
+import (
+	"testing"
+
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	fakecorev1client "k8s.io/client-go/kubernetes/fake"
+)
+
+func TestValidateExternalBuildServiceConfig(t *testing.T) {
+	t.Parallel()
+
+	newSecret := func(keys ...string) *corev1.Secret {
+		secret := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "external-build-service",
+				Namespace: ctrlcommon.MCONamespace,
+			},
+			Data: map[string][]byte{},
+		}
+
+		for _, key := range keys {
+			secret.Data[key] = []byte(key)
+		}
+
+		return secret
+	}
+
+	validSecret := newSecret("token")
+
+	testCases := []struct {
+		name        string
+		data        map[string]string
+		secret      *corev1.Secret
+		errExpected bool
+	}{
+		{
+			name: "valid",
+			data: map[string]string{
+				ExternalBuildServiceURLConfigKey: "https://builds.example.com/api/v1/builds",
+			},
+			secret: validSecret,
+		},
+		{
+			name: "valid with CA certificate",
+			data: map[string]string{
+				ExternalBuildServiceURLConfigKey: "https://builds.example.com/api/v1/builds",
+			},
+			secret: newSecret("token", "ca.crt"),
+		},
+		{
+			name: "missing URL",
+			data: map[string]string{
+				ExternalBuildServiceURLConfigKey: "",
+			},
+			secret:      validSecret,
+			errExpected: true,
+		},
+		{
+			name: "plain http URL",
+			data: map[string]string{
+				ExternalBuildServiceURLConfigKey: "http://builds.example.com/api/v1/builds",
+			},
+			secret:      validSecret,
+			errExpected: true,
+		},
+		{
+			name: "missing secret",
+			data: map[string]string{
+				ExternalBuildServiceURLConfigKey: "https://builds.example.com/api/v1/builds",
+			},
+			errExpected: true,
+		},
+		{
+			name: "missing token",
+			data: map[string]string{
+				ExternalBuildServiceURLConfigKey: "https://builds.example.com/api/v1/builds",
+			},
+			secret:      newSecret("ca.crt"),
+			errExpected: true,
+		},
+		{
+			name: "unsupported post-build test command",
+			data: map[string]string{
+				ExternalBuildServiceURLConfigKey: "https://builds.example.com/api/v1/builds",
+				PostBuildTestCommandConfigKey:    "rpm -q python3",
+			},
+			secret:      validSecret,
+			errExpected: true,
+		},
+		{
+			name: "unsupported build volumes",
+			data: map[string]string{
+				ExternalBuildServiceURLConfigKey: "https://builds.example.com/api/v1/builds",
+				BuildSecretsConfigKey:            "repo-token:/run/secrets/repo-token",
+			},
+			secret:      validSecret,
+			errExpected: true,
+		},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			cm := getOnClusterBuildConfigMap()
+			cm.Data[ImageBuilderTypeConfigMapKey] = ExternalBuildServiceImageBuilder
+			cm.Data[ExternalBuildServiceSecretNameConfigKey] = "external-build-service"
+			for k, v := range testCase.data {
+				cm.Data[k] = v
+			}
+
+			kubeclient := fakecorev1client.NewSimpleClientset()
+			if testCase.secret != nil {
+				kubeclient = fakecorev1client.NewSimpleClientset(testCase.secret)
+			}
+
+			err := validateExternalBuildServiceConfig(kubeclient, cm)
+			if testCase.errExpected {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+
+	// The external build service is only used when it is the configured image
+	// builder.
+	ebs, err := getExternalBuildService(getOnClusterBuildConfigMap())
+	assert.NoError(t, err)
+	assert.Nil(t, ebs)
+}
+
+// Tests that the external build pod submits the build to the external build
+// service instead of building with Buildah.
+func TestImageBuildRequestWithExternalBuildService(t *testing.T) {
+	t.Parallel()
+
+	onClusterBuildConfigMap := getOnClusterBuildConfigMap()
+	onClusterBuildConfigMap.Data[ImageBuilderTypeConfigMapKey] = ExternalBuildServiceImageBuilder
+	onClusterBuildConfigMap.Data[ExternalBuildServiceURLConfigKey] = "https://builds.example.com/api/v1/builds"
+	onClusterBuildConfigMap.Data[ExternalBuildServiceSecretNameConfigKey] = "external-build-service"
+
+	ebs, err := getExternalBuildService(onClusterBuildConfigMap)
+	require.NoError(t, err)
+
+	ibr := newImageBuildRequestFromBuildInputs(&buildInputs{
+		pool:                 newMachineConfigPool("worker", "rendered-worker-1"),
+		osImageURL:           getOSImageURLConfigMap(),
+		onClusterBuildConfig: onClusterBuildConfigMap,
+		externalBuildService: ebs,
+	})
+
+	pod := ibr.toBuildPod()
+
+	buildContainer := pod.Spec.Containers[0]
+	assert.Equal(t, "image-build", buildContainer.Name)
+	assert.Equal(t, ibr.BaseImage.Pullspec, buildContainer.Image)
+	assert.Equal(t, externalBuildScript, buildContainer.Command[len(buildContainer.Command)-1])
+	assert.Contains(t, buildContainer.Env, corev1.EnvVar{Name: "EXTERNAL_BUILD_SERVICE_URL", Value: "https://builds.example.com/api/v1/builds"})
+	assert.Contains(t, buildContainer.Env, corev1.EnvVar{Name: "POOL_NAME", Value: "worker"})
+	assert.Contains(t, buildContainer.Env, corev1.EnvVar{Name: "RENDERED_CONFIG", Value: "rendered-worker-1"})
+	assert.Contains(t, buildContainer.Env, corev1.EnvVar{Name: "TAG", Value: ibr.FinalImage.Pullspec})
+	assert.Contains(t, buildContainer.VolumeMounts, corev1.VolumeMount{Name: externalBuildServiceVolumeName, MountPath: externalBuildServiceMountpoint})
+
+	// The wait-for-done container still creates the digest ConfigMap.
+	assert.Equal(t, "wait-for-done", pod.Spec.Containers[1].Name)
+	assert.NotContains(t, pod.Spec.Containers[1].VolumeMounts, corev1.VolumeMount{Name: externalBuildServiceVolumeName, MountPath: externalBuildServiceMountpoint})
+
+	assert.Contains(t, pod.Spec.Volumes, corev1.Volume{
+		Name: externalBuildServiceVolumeName,
+		VolumeSource: corev1.VolumeSource{
+			Secret: &corev1.SecretVolumeSource{
+				SecretName: "external-build-service",
+			},
+		},
+	})
+
+	// Without an external build service, Buildah builds the image in the pod.
+	ibr.ExternalBuildService = nil
+	assert.Equal(t, buildahImagePullspec, ibr.toBuildPod().Spec.Containers[0].Image)
+}
@@ -21,6 +21,8 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/util/wait"
+	corelistersv1 "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
 )
 
 const (
@@ -32,7 +34,7 @@ const (
 func getCustomDockerfileConfigMap(poolToDockerfile map[string]string) *corev1.ConfigMap {
 	return &corev1.ConfigMap{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      customDockerfileConfigMapName,
+			Name:      CustomDockerfileConfigMapName,
 			Namespace: ctrlcommon.MCONamespace,
 		},
 		Data: poolToDockerfile,
@@ -70,6 +72,19 @@ func getOnClusterBuildConfigMap() *corev1.ConfigMap {
 	}
 }
 
+// Creates a ConfigMap lister which serves the given ConfigMaps, for tests which
+// construct the Build Controller without starting its informers.
+func newConfigMapLister(t *testing.T, configMaps ...*corev1.ConfigMap) corelistersv1.ConfigMapLister {
+	t.Helper()
+
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	for _, cm := range configMaps {
+		require.NoError(t, indexer.Add(cm))
+	}
+
+	return corelistersv1.NewConfigMapLister(indexer)
+}
+
 // Creates a new MachineConfigPool and the corresponding MachineConfigs.
 func newMachineConfigPoolAndConfigs(name string, params ...string) []runtime.Object {
 	mcp := newMachineConfigPool(name, params...)
@@ -9,7 +9,6 @@ import (
 	"fmt"
 	"io"
 
-	"github.com/containers/image/v5/docker"
 	"github.com/containers/image/v5/docker/reference"
 	"github.com/opencontainers/go-digest"
 	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
@@ -74,22 +73,6 @@ func compress(r io.Reader, w io.Writer) error {
 	return nil
 }
 
-func validateImageHasDigestedPullspec(pullspec string) error {
-	tagged, err := docker.ParseReference("//" + pullspec)
-	if err != nil {
-		return err
-	}
-
-	switch tagged.DockerReference().(type) {
-	case reference.Tagged:
-		return fmt.Errorf("expected a pullspec with a SHA256 digest, got %q", pullspec)
-	case reference.Digested:
-		return nil
-	default:
-		return fmt.Errorf("unknown image reference spec %q", pullspec)
-	}
-}
-
 // Replaces any tags on the image pullspec with the provided image digest.
 func parseImagePullspecWithDigest(pullspec string, imageDigest digest.Digest) (string, error) {
 	named, err := reference.ParseNamed(pullspec)
@@ -257,6 +240,21 @@ func ValidateOnClusterBuildConfig(kubeclient clientset.Interface) error {
 	secretNames := []string{BaseImagePullSecretNameConfigKey, FinalImagePushSecretNameConfigKey}
 	imagePullspecs := []string{FinalImagePullspecConfigKey}
 
+	// Validate the push credentials provider from the ConfigMap. When one is
+	// configured, the push secret is generated before each build so it may not
+	// exist yet; the cloud credentials secret must exist instead.
+	pushCredentialsProvider, err := getPushCredentialsProviderName(cm)
+	if err != nil {
+		return err
+	}
+
+	if pushCredentialsProvider != "" {
+		name := cm.Data[FinalImagePushCloudCredentialsSecretNameConfigKey]
+		if _, err := kubeclient.CoreV1().Secrets(ctrlcommon.MCONamespace).Get(context.TODO(), name, metav1.GetOptions{}); err != nil {
+			return fmt.Errorf("could not get cloud credentials secret %s from %s: %w", name, OnClusterBuildConfigMapName, err)
+		}
+	}
+
 	// Validate the presence of secrets it refers to
 	for _, key := range secretNames {
 		val, ok := cm.Data[key]
@@ -268,6 +266,10 @@ func ValidateOnClusterBuildConfig(kubeclient clientset.Interface) error {
 			return fmt.Errorf("key %q in configmap %s has an empty value", key, OnClusterBuildConfigMapName)
 		}
 
+		if key == FinalImagePushSecretNameConfigKey && pushCredentialsProvider != "" {
+			continue
+		}
+
 		if err := validateSecret(kubeclient, val); err != nil {
 			return err
 		}
@@ -294,6 +296,118 @@ func ValidateOnClusterBuildConfig(kubeclient clientset.Interface) error {
 		return err
 	}
 
+	// Validate the build retry policy from the ConfigMap
+	if _, err := getBuildRetryPolicy(cm); err != nil {
+		return err
+	}
+
+	// Validate the build history policy from the ConfigMap
+	if _, err := getBuildHistoryPolicy(cm); err != nil {
+		return err
+	}
+
+	// Validate the image scan policy from the ConfigMap
+	if _, err := getImageScanPolicy(cm); err != nil {
+		return err
+	}
+
+	// Validate the default build timeout from the ConfigMap
+	if val, ok := cm.Data[BuildTimeoutConfigKey]; ok && val != "" {
+		if _, err := parseBuildTimeout(BuildTimeoutConfigKey, val); err != nil {
+			return err
+		}
+	}
+
+	// Validate the build resource requests and limits from the ConfigMap
+	if _, err := getBuildResources(cm); err != nil {
+		return err
+	}
+
+	// Validate the build scheduling constraints from the ConfigMap
+	if _, err := getBuildScheduling(cm); err != nil {
+		return err
+	}
+
+	// Validate the maximum number of concurrent builds from the ConfigMap
+	if _, err := getMaxConcurrentBuilds(cm); err != nil {
+		return err
+	}
+
+	// Validate the build log tail size from the ConfigMap
+	if _, err := getBuildLogTailBytes(cm); err != nil {
+		return err
+	}
+
+	// Validate the image-only config updates toggle from the ConfigMap
+	if _, err := getImageOnlyConfigUpdates(cm); err != nil {
+		return err
+	}
+
+	// Validate the remote builder URL and TLS Secret, if any
+	if err := validateRemoteBuilderConfig(kubeclient, cm); err != nil {
+		return err
+	}
+
+	// Validate that Kaniko, if used, supports the rest of the configuration
+	if err := validateKanikoBuilderConfig(cm); err != nil {
+		return err
+	}
+
+	// Validate the BuildKit cache image, if any
+	if err := validateBuildKitBuilderConfig(cm); err != nil {
+		return err
+	}
+
+	// Validate the external build service URL and Secret, if any
+	if err := validateExternalBuildServiceConfig(kubeclient, cm); err != nil {
+		return err
+	}
+
+	// Validate the image lint policy, if any
+	if err := validateImageLintConfig(cm); err != nil {
+		return err
+	}
+
+	// Validate the image signing Secret, if any
+	if err := validateImageSigningConfig(kubeclient, cm); err != nil {
+		return err
+	}
+
+	// Validate the additional final image destinations and their Secrets, if any
+	if err := validateAdditionalFinalImagesConfig(kubeclient, cm); err != nil {
+		return err
+	}
+
+	// Validate the RPM repositories and GPG keys to install, if any
+	if err := validateRPMRepositoriesConfig(kubeclient, cm); err != nil {
+		return err
+	}
+
+	// Validate the insecure registry toggle, if any
+	if err := validateInsecureRegistryConfig(cm); err != nil {
+		return err
+	}
+
+	// Validate the build arguments and the additional build Secrets and ConfigMaps
+	if _, err := getBuildArgs(cm); err != nil {
+		return err
+	}
+
+	userVolumes, err := getUserBuildVolumes(cm)
+	if err != nil {
+		return err
+	}
+
+	for _, volume := range userVolumes {
+		if err := getBuildVolumeSource(kubeclient, volume); err != nil {
+			return fmt.Errorf("could not get %s referenced by %s: %w", volume.Name, OnClusterBuildConfigMapName, err)
+		}
+	}
+
+	// The custom Containerfiles are not validated here since each one only
+	// affects the build of its own pool. The build controller validates them
+	// when the build of that pool starts.
+
 	return nil
 }
 
@@ -331,7 +445,7 @@ func GetImageBuilderType(cm *corev1.ConfigMap) (string, error) {
 		return defaultBuilder, nil
 	}
 
-	validImageBuilderTypes := sets.NewString(OpenshiftImageBuilder, CustomPodImageBuilder)
+	validImageBuilderTypes := sets.NewString(OpenshiftImageBuilder, CustomPodImageBuilder, RemoteImageBuilder, KanikoImageBuilder, BuildKitImageBuilder, ExternalBuildServiceImageBuilder)
 	if !validImageBuilderTypes.Has(configMapImageBuilder) {
 		return "", fmt.Errorf("invalid image builder type %q, valid types: %v", configMapImageBuilder, validImageBuilderTypes.List())
 	}
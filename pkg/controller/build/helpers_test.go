@@ -3,6 +3,7 @@ package build
 import (
 	"testing"
 
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
 	"github.com/stretchr/testify/assert"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -18,7 +19,7 @@ func TestValidateImagePullspecHasDigest(t *testing.T) {
 	}
 
 	for _, pullspec := range validPullspecs {
-		assert.NoError(t, validateImageHasDigestedPullspec(pullspec))
+		assert.NoError(t, ctrlcommon.ValidateDigestedPullspec(pullspec))
 	}
 
 	invalidPullspecs := []string{
@@ -26,7 +27,7 @@ func TestValidateImagePullspecHasDigest(t *testing.T) {
 	}
 
 	for _, pullspec := range invalidPullspecs {
-		assert.Error(t, validateImageHasDigestedPullspec(pullspec))
+		assert.Error(t, ctrlcommon.ValidateDigestedPullspec(pullspec))
 	}
 }
 
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"strings"
 	"text/template"
+	"time"
 
 	buildv1 "github.com/openshift/api/build/v1"
 	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
@@ -13,12 +14,16 @@ import (
 	"github.com/openshift/machine-config-operator/test/helpers"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
 )
 
 const (
 	mcPoolAnnotation          string = "machineconfiguration.openshift.io/pool"
 	machineConfigJSONFilename string = "machineconfig.json.gz"
 	buildahImagePullspec      string = "quay.io/buildah/stable:latest"
+
+	mergedRegistryCredsVolumeName = "merged-registry-creds"
+	mergeRegistryCredsScript      = `jq -s '{auths: ((.[0].auths // {}) + (.[1].auths // {}))}' "$BASE_IMAGE_PULL_CREDS" "$FINAL_IMAGE_PUSH_CREDS" > "%s/config.json"`
 )
 
 //go:embed assets/Dockerfile.on-cluster-build-template
@@ -33,6 +38,21 @@ var buildahBuildScript string
 //go:embed assets/podman-build.sh
 var podmanBuildScript string
 
+//go:embed assets/podman-remote-build.sh
+var podmanRemoteBuildScript string
+
+//go:embed assets/kaniko-build.sh
+var kanikoBuildScript string
+
+//go:embed assets/buildkit-build.sh
+var buildKitBuildScript string
+
+//go:embed assets/external-build.sh
+var externalBuildScript string
+
+//go:embed assets/image-lint.sh
+var imageLintScript string
+
 // Represents a given image pullspec and the location of the pull secret.
 type ImageInfo struct {
 	// The pullspec for a given image (e.g., registry.hostname.com/orp/repo:tag)
@@ -47,6 +67,10 @@ type ImageBuildRequest struct {
 	Pool *mcfgv1.MachineConfigPool
 	// The base OS image (derived from the machine-config-osimageurl ConfigMap)
 	BaseImage ImageInfo
+	// An optional image which the configs stage is based on instead of the
+	// base OS image, e.g., the newest image of another pool. It is pulled with
+	// the base image pull secret.
+	ConfigsBaseImage string
 	// The extensions image (derived from the machine-config-osimageurl ConfigMap)
 	ExtensionsImage ImageInfo
 	// The final OS image (desired from the on-cluster-build-config ConfigMap)
@@ -55,14 +79,87 @@ type ImageBuildRequest struct {
 	ReleaseVersion string
 	// An optional user-supplied Dockerfile that gets injected into the build.
 	CustomDockerfile string
+	// The packages of the extensions enabled in the rendered MachineConfig,
+	// which get installed from the extensions image.
+	ExtensionPackages []string
+	// Optional Secrets and ConfigMaps (e.g., RHEL entitlements) that get
+	// mounted into the build.
+	BuildVolumes []buildVolume
+	// Where the RPM repositories and GPG keys to install in the configs stage
+	// are mounted, if any.
+	RPMRepositories rpmRepositories
+	// Optional build arguments that get passed to the builder.
+	BuildArgs []corev1.EnvVar
+	// Optional compute resource requests and limits for the build.
+	Resources corev1.ResourceRequirements
+	// Optional constraints on which nodes the build may run.
+	Scheduling buildScheduling
+	// The name of an optional Secret containing a cosign key pair used to sign
+	// the final image.
+	SigningSecret string
+	// The public key of the cosign key pair in SigningSecret when the build
+	// was started.
+	SigningPublicKey string
+	// The cluster-wide proxy and additional trusted CA bundle for the build.
+	Proxy buildProxy
+	// An optional shell command which is run in a container from the built
+	// image before it is pushed.
+	PostBuildTestCommand string
+	// The external builder which performs the build when the remote builder
+	// is used.
+	RemoteBuilder *remoteBuilder
+	// Whether Kaniko builds the image instead of Buildah.
+	Kaniko bool
+	// The BuildKit builder, when BuildKit builds the image instead of Buildah.
+	BuildKit *buildKitBuilder
+	// The external build service which performs the build when the external
+	// build service builder is used.
+	ExternalBuildService *externalBuildService
+	// Optional additional destinations which the final image is copied to
+	// once it is pushed.
+	AdditionalFinalImages []additionalFinalImage
+	// The registries config from the rendered MachineConfig, which contains
+	// the cluster's image mirrors.
+	RegistriesConfig string
+	// The registries, in host..port form, whose CAs are in the
+	// BuildRegistryCAsConfigMapName ConfigMap.
+	RegistryCAs []string
+	// Whether the built image is linted before it is pushed.
+	ImageLint bool
+	// Whether TLS verification is skipped for the final image registry.
+	InsecureRegistry bool
+	// Whether the layers added on top of the base image are squashed into a
+	// single layer.
+	SquashLayers bool
+	// The resourceVersion of each Secret the build authenticates with, keyed
+	// by Secret name.
+	SecretVersions map[string]string
+	// The labels recording where the final image came from.
+	Provenance map[string]string
 }
 
 type buildInputs struct {
-	onClusterBuildConfig *corev1.ConfigMap
-	osImageURL           *corev1.ConfigMap
-	customDockerfiles    *corev1.ConfigMap
-	pool                 *mcfgv1.MachineConfigPool
-	machineConfig        *mcfgv1.MachineConfig
+	onClusterBuildConfig  *corev1.ConfigMap
+	osImageURL            *corev1.ConfigMap
+	customDockerfiles     *corev1.ConfigMap
+	buildVolumes          []buildVolume
+	buildArgs             []corev1.EnvVar
+	buildResources        corev1.ResourceRequirements
+	buildScheduling       buildScheduling
+	buildProxy            buildProxy
+	remoteBuilder         *remoteBuilder
+	buildKitBuilder       *buildKitBuilder
+	externalBuildService  *externalBuildService
+	additionalFinalImages []additionalFinalImage
+	insecureRegistry      bool
+	registriesConfig      string
+	registryCAs           map[string]string
+	secretVersions        map[string]string
+	imageSigningPublicKey string
+	configsBaseImage      string
+	retryCount            int
+	pool                  *mcfgv1.MachineConfigPool
+	machineConfig         *mcfgv1.MachineConfig
 }
 
 // Constructs a simple ImageBuildRequest.
@@ -112,12 +209,37 @@ func newImageBuildRequestFromBuildInputs(inputs *buildInputs) ImageBuildRequest
 	}
 
 	return ImageBuildRequest{
-		Pool:             inputs.pool.DeepCopy(),
-		BaseImage:        newBaseImageInfo(inputs),
-		FinalImage:       newFinalImageInfo(inputs),
-		ExtensionsImage:  newExtensionsImageInfo(inputs),
-		ReleaseVersion:   inputs.osImageURL.Data[releaseVersionConfigKey],
-		CustomDockerfile: customDockerfile,
+		Pool:              inputs.pool.DeepCopy(),
+		BaseImage:         newBaseImageInfo(inputs),
+		ConfigsBaseImage:  inputs.configsBaseImage,
+		FinalImage:        newFinalImageInfo(inputs),
+		ExtensionsImage:   newExtensionsImageInfo(inputs),
+		ReleaseVersion:    inputs.osImageURL.Data[releaseVersionConfigKey],
+		CustomDockerfile:  customDockerfile,
+		ExtensionPackages: getExtensionPackages(inputs.machineConfig),
+		BuildVolumes:      inputs.buildVolumes,
+		RPMRepositories:   getRPMRepositories(inputs.buildVolumes),
+		BuildArgs:         inputs.buildArgs,
+		Resources:         inputs.buildResources,
+		Scheduling:        inputs.buildScheduling,
+		SigningSecret:     getImageSigningSecretName(inputs.onClusterBuildConfig),
+		SigningPublicKey:  inputs.imageSigningPublicKey,
+		Proxy:             inputs.buildProxy,
+
+		PostBuildTestCommand: getPostBuildTestCommand(inputs.onClusterBuildConfig),
+		RemoteBuilder:        inputs.remoteBuilder,
+		Kaniko:               inputs.onClusterBuildConfig.Data[ImageBuilderTypeConfigMapKey] == KanikoImageBuilder,
+		BuildKit:             inputs.buildKitBuilder,
+		ExternalBuildService: inputs.externalBuildService,
+
+		AdditionalFinalImages: inputs.additionalFinalImages,
+		RegistriesConfig:      inputs.registriesConfig,
+		RegistryCAs:           sets.StringKeySet(inputs.registryCAs).List(),
+		ImageLint:             isImageLintEnabled(inputs.onClusterBuildConfig),
+		InsecureRegistry:      inputs.insecureRegistry,
+		SquashLayers:          ctrlcommon.NewLayeredPoolState(inputs.pool).IsSquashLayers(),
+		SecretVersions:        inputs.secretVersions,
+		Provenance:            getImageProvenance(inputs, time.Now()),
 	}
 }
 
@@ -136,6 +258,10 @@ func (i ImageBuildRequest) dockerfileToConfigMap() (*corev1.ConfigMap, error) {
 		},
 	}
 
+	if i.RegistriesConfig != "" {
+		configmap.Data[buildRegistriesConfigMapKey] = i.RegistriesConfig
+	}
+
 	return configmap, nil
 }
 
@@ -200,6 +326,17 @@ func (i ImageBuildRequest) toBuild() *buildv1.Build {
 	// override it via a ConfigMap.
 	dockerfile := "FROM scratch"
 
+	buildVolumes := []buildv1.BuildVolume{}
+	for _, volume := range i.BuildVolumes {
+		buildVolumes = append(buildVolumes, volume.toBuildVolume())
+	}
+
+	// The OpenShift Image Builder mounts the cluster's trusted CA bundle itself.
+	var mountTrustedCA *bool
+	if i.Proxy.HasTrustedCA {
+		mountTrustedCA = &i.Proxy.HasTrustedCA
+	}
+
 	return &buildv1.Build{
 		TypeMeta: metav1.TypeMeta{
 			Kind: "Build",
@@ -234,9 +371,22 @@ func (i ImageBuildRequest) toBuild() *buildv1.Build {
 						// Squashing layers is good as long as it doesn't cause problems with what
 						// the users want to do. It says "some syntax is not supported"
 						ImageOptimizationPolicy: &skipLayers,
+						// Mounts the optional Secrets and ConfigMaps into each RUN
+						// instruction without adding them to the image.
+						Volumes:   buildVolumes,
+						BuildArgs: i.BuildArgs,
+						Env:       i.Proxy.env(),
 					},
 					Type: buildv1.DockerBuildStrategyType,
 				},
+				// Runs the post-build test command in a container from the built
+				// image before it is pushed.
+				PostCommit: buildv1.BuildPostCommitSpec{
+					Script: i.PostBuildTestCommand,
+				},
+				Resources:      i.Resources,
+				NodeSelector:   i.Scheduling.NodeSelector,
+				MountTrustedCA: mountTrustedCA,
 				Output: buildv1.BuildOutput{
 					To: &corev1.ObjectReference{
 						Name: i.FinalImage.Pullspec,
@@ -255,6 +405,22 @@ func (i ImageBuildRequest) toBuild() *buildv1.Build {
 // Creates a custom image build pod to build the final OS image with all
 // ConfigMaps / Secrets / etc. wired into it.
 func (i ImageBuildRequest) toBuildPod() *corev1.Pod {
+	if i.RemoteBuilder != nil {
+		return i.toRemoteBuildPod()
+	}
+
+	if i.Kaniko {
+		return i.toKanikoBuildPod()
+	}
+
+	if i.BuildKit != nil {
+		return i.toBuildKitBuildPod()
+	}
+
+	if i.ExternalBuildService != nil {
+		return i.toExternalBuildPod()
+	}
+
 	return i.toBuildahPod()
 }
 
@@ -455,6 +621,10 @@ func (i ImageBuildRequest) toBuildahPod() *corev1.Pod {
 			Name:  "FINAL_IMAGE_PUSH_CREDS",
 			Value: "/tmp/final-image-push-creds/config.json",
 		},
+		{
+			Name:  "BUILD_VOLUME_MOUNTPOINTS",
+			Value: getBuildVolumeMountpoints(i.BuildVolumes),
+		},
 	}
 
 	var uid int64 = 1000
@@ -490,12 +660,103 @@ func (i ImageBuildRequest) toBuildahPod() *corev1.Pod {
 		},
 	}
 
+	// Only the image-build container needs the build arguments, which Buildah
+	// reads from its environment.
+	buildEnv := append([]corev1.EnvVar{}, env...)
+	buildEnv = append(buildEnv, corev1.EnvVar{
+		Name:  "BUILD_ARG_NAMES",
+		Value: getBuildArgNames(i.BuildArgs),
+	})
+	buildEnv = append(buildEnv, i.BuildArgs...)
+
+	// Only the image-build container needs the optional build volumes.
+	buildVolumeMounts := append([]corev1.VolumeMount{}, volumeMounts...)
+	buildVolumes := []corev1.Volume{}
+	for _, volume := range i.BuildVolumes {
+		buildVolumeMounts = append(buildVolumeMounts, corev1.VolumeMount{
+			Name:      volume.Name,
+			MountPath: volume.Mountpoint,
+		})
+		buildVolumes = append(buildVolumes, volume.toVolume())
+	}
+
+	// Only the image-build container needs the image signing key, if any.
+	if i.SigningSecret != "" {
+		buildEnv = append(buildEnv, corev1.EnvVar{
+			Name:  "IMAGE_SIGNING_KEY_DIR",
+			Value: imageSigningMountpoint,
+		})
+		buildVolumeMounts = append(buildVolumeMounts, corev1.VolumeMount{
+			Name:      imageSigningVolumeName,
+			MountPath: imageSigningMountpoint,
+		})
+		buildVolumes = append(buildVolumes, corev1.Volume{
+			Name: imageSigningVolumeName,
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{
+					SecretName: i.SigningSecret,
+				},
+			},
+		})
+	}
+
+	// Only the image-build container talks to registries and package
+	// repositories, so only it needs the cluster-wide proxy, the image mirrors
+	// and the trusted CA bundle. Buildah trusts the certificates in
+	// SSL_CERT_DIR in addition to the system bundle.
+	buildEnv = append(buildEnv, i.Proxy.env()...)
+	buildEnv = append(buildEnv, i.registriesConfigEnv()...)
+	if i.Proxy.HasTrustedCA {
+		buildEnv = append(buildEnv, corev1.EnvVar{
+			Name:  "SSL_CERT_DIR",
+			Value: buildTrustedCAMountpoint,
+		})
+		buildVolumeMounts = append(buildVolumeMounts, corev1.VolumeMount{
+			Name:      buildTrustedCAVolumeName,
+			MountPath: buildTrustedCAMountpoint,
+		})
+		buildVolumes = append(buildVolumes, corev1.Volume{
+			Name: buildTrustedCAVolumeName,
+			VolumeSource: corev1.VolumeSource{
+				ConfigMap: &corev1.ConfigMapVolumeSource{
+					LocalObjectReference: corev1.LocalObjectReference{
+						Name: BuildTrustedCAConfigMapName,
+					},
+				},
+			},
+		})
+	}
+
+	// Only the image-build container runs the post-build test command.
+	if i.PostBuildTestCommand != "" {
+		buildEnv = append(buildEnv, corev1.EnvVar{
+			Name:  "POST_BUILD_TEST_COMMAND",
+			Value: i.PostBuildTestCommand,
+		})
+	}
+
+	// Only the image-build container lints the built image.
+	if i.ImageLint {
+		buildEnv = append(buildEnv, corev1.EnvVar{
+			Name:  "IMAGE_LINT_SCRIPT",
+			Value: imageLintScript,
+		})
+	}
+
+	// Only the image-build container commits the image layers.
+	if i.SquashLayers {
+		buildEnv = append(buildEnv, corev1.EnvVar{
+			Name:  "SQUASH_LAYERS",
+			Value: "true",
+		})
+	}
+
 	// TODO: We need pull creds with permissions to pull the base image. By
 	// default, none of the MCO pull secrets can directly pull it. We can use the
 	// pull-secret creds from openshift-config to do that, though we'll need to
 	// mirror those creds into the MCO namespace. The operator portion of the MCO
 	// has some logic to detect whenever that secret changes.
-	return &corev1.Pod{
+	pod := &corev1.Pod{
 		TypeMeta: metav1.TypeMeta{
 			APIVersion: "v1",
 			Kind:       "Pod",
@@ -509,11 +770,12 @@ func (i ImageBuildRequest) toBuildahPod() *corev1.Pod {
 					Name: "image-build",
 					// TODO: Figure out how to not hard-code this here.
 					Image:           buildahImagePullspec,
-					Env:             env,
+					Env:             buildEnv,
 					Command:         append(command, buildahBuildScript),
 					ImagePullPolicy: corev1.PullAlways,
 					SecurityContext: securityContext,
-					VolumeMounts:    volumeMounts,
+					VolumeMounts:    buildVolumeMounts,
+					Resources:       i.Resources,
 				},
 				{
 					// This container waits for the aforementioned container to finish
@@ -599,11 +861,23 @@ func (i ImageBuildRequest) toBuildahPod() *corev1.Pod {
 			},
 		},
 	}
+
+	pod.Spec.Volumes = append(pod.Spec.Volumes, buildVolumes...)
+
+	pod.Spec.NodeSelector = i.Scheduling.NodeSelector
+	pod.Spec.Tolerations = i.Scheduling.Tolerations
+	pod.Spec.Affinity = i.Scheduling.Affinity
+
+	i.addAdditionalFinalImages(pod)
+	i.addRegistryCAs(pod)
+	i.addInsecureRegistry(pod)
+
+	return pod
 }
 
 // Constructs a common metav1.ObjectMeta object with the namespace, labels, and annotations set.
 func (i ImageBuildRequest) getObjectMeta(name string) metav1.ObjectMeta {
-	return metav1.ObjectMeta{
+	objMeta := metav1.ObjectMeta{
 		Name:      name,
 		Namespace: ctrlcommon.MCONamespace,
 		Labels: map[string]string{
@@ -614,7 +888,15 @@ func (i ImageBuildRequest) getObjectMeta(name string) metav1.ObjectMeta {
 		Annotations: map[string]string{
 			mcPoolAnnotation: "",
 		},
+		OwnerReferences: getPoolOwnerReference(i.Pool),
 	}
+
+	// Records which credentials the build was started with.
+	if versions := encodeBuildSecretVersions(i.SecretVersions); versions != "" {
+		objMeta.Annotations[BuildSecretVersionsAnnotationKey] = versions
+	}
+
+	return objMeta
 }
 
 // Computes the Dockerfile ConfigMap name based upon the MachineConfigPool name.
@@ -635,3 +917,63 @@ func (i ImageBuildRequest) getBuildName() string {
 func (i ImageBuildRequest) getDigestConfigMapName() string {
 	return fmt.Sprintf("digest-%s", i.Pool.Spec.Configuration.Name)
 }
+
+// Replaces the base image pull and final image push credentials of the
+// image-build container with a single config.json in the given directory.
+// Builders other than Buildah read the credentials for all registries from one
+// file, so an init container merges both secrets into it, with the push secret
+// winning for registries found in both. The base OS image contains jq.
+func (i ImageBuildRequest) mergeRegistryCreds(pod *corev1.Pod, mountpoint string) {
+	mergedCredsMount := corev1.VolumeMount{
+		Name:      mergedRegistryCredsVolumeName,
+		MountPath: mountpoint,
+	}
+
+	for idx := range pod.Spec.Containers {
+		container := &pod.Spec.Containers[idx]
+		if container.Name != "image-build" {
+			continue
+		}
+
+		credsMounts := []corev1.VolumeMount{}
+		volumeMounts := []corev1.VolumeMount{}
+		for _, volumeMount := range container.VolumeMounts {
+			if volumeMount.Name == "base-image-pull-creds" || volumeMount.Name == "final-image-push-creds" {
+				credsMounts = append(credsMounts, volumeMount)
+				continue
+			}
+			volumeMounts = append(volumeMounts, volumeMount)
+		}
+		container.VolumeMounts = append(volumeMounts, mergedCredsMount)
+
+		credsEnv := []corev1.EnvVar{}
+		for _, env := range container.Env {
+			if env.Name == "BASE_IMAGE_PULL_CREDS" || env.Name == "FINAL_IMAGE_PUSH_CREDS" {
+				credsEnv = append(credsEnv, env)
+			}
+		}
+
+		var uid int64 = 1000
+		var gid int64 = 1000
+
+		pod.Spec.InitContainers = append(pod.Spec.InitContainers, corev1.Container{
+			Name:            "merge-registry-creds",
+			Image:           i.BaseImage.Pullspec,
+			Env:             credsEnv,
+			Command:         []string{"/bin/bash", "-c", fmt.Sprintf(mergeRegistryCredsScript, mountpoint)},
+			ImagePullPolicy: corev1.PullAlways,
+			SecurityContext: &corev1.SecurityContext{
+				RunAsUser:  &uid,
+				RunAsGroup: &gid,
+			},
+			VolumeMounts: append(credsMounts, mergedCredsMount),
+		})
+	}
+
+	pod.Spec.Volumes = append(pod.Spec.Volumes, corev1.Volume{
+		Name: mergedRegistryCredsVolumeName,
+		VolumeSource: corev1.VolumeSource{
+			EmptyDir: &corev1.EmptyDirVolumeSource{},
+		},
+	})
+}
@@ -3,7 +3,12 @@ package build
 import (
 	"testing"
 
+	buildv1 "github.com/openshift/api/build/v1"
+	configv1 "github.com/openshift/api/config/v1"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 )
 
 // Tests that Image Build Requests is constructed as expected and does a
@@ -121,3 +126,212 @@ func TestImageBuildRequestWithCustomDockerfile(t *testing.T) {
 		assert.Contains(t, dockerfile, content)
 	}
 }
+
+// Tests that the optional build volumes (e.g., RHEL entitlements) and build
+// arguments are wired into both the OpenShift Image Builder build and the custom build pod.
+func TestImageBuildRequestWithBuildVolumes(t *testing.T) {
+	t.Parallel()
+
+	volumes := getOptionalBuildVolumes()[:2]
+
+	ibr := newImageBuildRequestFromBuildInputs(&buildInputs{
+		pool:                 newMachineConfigPool("worker", "rendered-worker-1"),
+		osImageURL:           getOSImageURLConfigMap(),
+		onClusterBuildConfig: getOnClusterBuildConfigMap(),
+		buildVolumes:         volumes,
+		buildArgs:            []corev1.EnvVar{{Name: "HTTP_PROXY", Value: "http://proxy.example.com:3128"}},
+	})
+
+	build := ibr.toBuild()
+	buildVolumes := build.Spec.Strategy.DockerStrategy.Volumes
+	assert.Len(t, buildVolumes, 2)
+	assert.Equal(t, EtcPkiEntitlementSecretName, buildVolumes[0].Source.Secret.SecretName)
+	assert.Equal(t, etcPkiEntitlementMountpoint, buildVolumes[0].Mounts[0].DestinationPath)
+	assert.Equal(t, EtcYumReposDConfigMapName, buildVolumes[1].Source.ConfigMap.Name)
+	assert.Equal(t, etcYumReposDMountpoint, buildVolumes[1].Mounts[0].DestinationPath)
+	assert.Equal(t, ibr.BuildArgs, build.Spec.Strategy.DockerStrategy.BuildArgs)
+
+	pod := ibr.toBuildPod()
+
+	volumeNames := []string{}
+	for _, volume := range pod.Spec.Volumes {
+		volumeNames = append(volumeNames, volume.Name)
+	}
+	assert.Contains(t, volumeNames, EtcPkiEntitlementSecretName)
+	assert.Contains(t, volumeNames, EtcYumReposDConfigMapName)
+
+	buildContainer := pod.Spec.Containers[0]
+	assert.Contains(t, buildContainer.Env, corev1.EnvVar{Name: "BUILD_VOLUME_MOUNTPOINTS", Value: "/etc/pki/entitlement /etc/yum.repos.d"})
+	assert.Contains(t, buildContainer.VolumeMounts, corev1.VolumeMount{Name: EtcPkiEntitlementSecretName, MountPath: etcPkiEntitlementMountpoint})
+	assert.Contains(t, buildContainer.Env, corev1.EnvVar{Name: "BUILD_ARG_NAMES", Value: "HTTP_PROXY"})
+	assert.Contains(t, buildContainer.Env, corev1.EnvVar{Name: "HTTP_PROXY", Value: "http://proxy.example.com:3128"})
+	assert.NotContains(t, pod.Spec.Containers[1].Env, corev1.EnvVar{Name: "HTTP_PROXY", Value: "http://proxy.example.com:3128"})
+
+	// The wait-for-done container does not need the build volumes.
+	assert.NotContains(t, pod.Spec.Containers[1].VolumeMounts, corev1.VolumeMount{Name: EtcPkiEntitlementSecretName, MountPath: etcPkiEntitlementMountpoint})
+}
+
+func TestImageBuildRequestWithSigningSecret(t *testing.T) {
+	t.Parallel()
+
+	onClusterBuildConfigMap := getOnClusterBuildConfigMap()
+	onClusterBuildConfigMap.Data[ImageSigningSecretNameConfigKey] = "image-signing-secret"
+
+	ibr := newImageBuildRequestFromBuildInputs(&buildInputs{
+		pool:                 newMachineConfigPool("worker", "rendered-worker-1"),
+		osImageURL:           getOSImageURLConfigMap(),
+		onClusterBuildConfig: onClusterBuildConfigMap,
+	})
+
+	assert.Equal(t, "image-signing-secret", ibr.SigningSecret)
+
+	pod := ibr.toBuildPod()
+
+	assert.Contains(t, pod.Spec.Volumes, corev1.Volume{
+		Name: imageSigningVolumeName,
+		VolumeSource: corev1.VolumeSource{
+			Secret: &corev1.SecretVolumeSource{SecretName: "image-signing-secret"},
+		},
+	})
+
+	buildContainer := pod.Spec.Containers[0]
+	assert.Contains(t, buildContainer.Env, corev1.EnvVar{Name: "IMAGE_SIGNING_KEY_DIR", Value: imageSigningMountpoint})
+	assert.Contains(t, buildContainer.VolumeMounts, corev1.VolumeMount{Name: imageSigningVolumeName, MountPath: imageSigningMountpoint})
+
+	// The wait-for-done container does not need the signing key.
+	assert.NotContains(t, pod.Spec.Containers[1].VolumeMounts, corev1.VolumeMount{Name: imageSigningVolumeName, MountPath: imageSigningMountpoint})
+}
+
+// Tests that the build resources are applied to both the OpenShift Image
+// Builder build and the image build container of the custom build pod.
+func TestImageBuildRequestWithBuildResources(t *testing.T) {
+	t.Parallel()
+
+	resources := corev1.ResourceRequirements{
+		Requests: corev1.ResourceList{
+			corev1.ResourceMemory: resource.MustParse("2Gi"),
+		},
+		Limits: corev1.ResourceList{
+			corev1.ResourceMemory: resource.MustParse("8Gi"),
+		},
+	}
+
+	ibr := newImageBuildRequestFromBuildInputs(&buildInputs{
+		pool:                 newMachineConfigPool("worker", "rendered-worker-1"),
+		osImageURL:           getOSImageURLConfigMap(),
+		onClusterBuildConfig: getOnClusterBuildConfigMap(),
+		buildResources:       resources,
+	})
+
+	assert.Equal(t, resources, ibr.toBuild().Spec.Resources)
+
+	pod := ibr.toBuildPod()
+	assert.Equal(t, resources, pod.Spec.Containers[0].Resources)
+
+	// The wait-for-done container does not build anything.
+	assert.Equal(t, corev1.ResourceRequirements{}, pod.Spec.Containers[1].Resources)
+}
+
+// Tests that the build scheduling constraints are applied to the custom build
+// pod and that the node selector is applied to the OpenShift Image Builder
+// build.
+func TestImageBuildRequestWithBuildScheduling(t *testing.T) {
+	t.Parallel()
+
+	scheduling := buildScheduling{
+		NodeSelector: map[string]string{"node-role.kubernetes.io/builder": ""},
+		Tolerations: []corev1.Toleration{
+			{Key: "node-role.kubernetes.io/builder", Operator: corev1.TolerationOpExists},
+		},
+		Affinity: &corev1.Affinity{},
+	}
+
+	ibr := newImageBuildRequestFromBuildInputs(&buildInputs{
+		pool:                 newMachineConfigPool("worker", "rendered-worker-1"),
+		osImageURL:           getOSImageURLConfigMap(),
+		onClusterBuildConfig: getOnClusterBuildConfigMap(),
+		buildScheduling:      scheduling,
+	})
+
+	assert.Equal(t, buildv1.OptionalNodeSelector(scheduling.NodeSelector), ibr.toBuild().Spec.NodeSelector)
+
+	pod := ibr.toBuildPod()
+	assert.Equal(t, scheduling.NodeSelector, pod.Spec.NodeSelector)
+	assert.Equal(t, scheduling.Tolerations, pod.Spec.Tolerations)
+	assert.Equal(t, scheduling.Affinity, pod.Spec.Affinity)
+
+	// Without a node selector, the OpenShift Image Builder default is used.
+	ibr.Scheduling = buildScheduling{}
+	assert.Nil(t, ibr.toBuild().Spec.NodeSelector)
+}
+
+// Tests that the cluster-wide proxy and trusted CA bundle are injected into
+// both the OpenShift Image Builder build and the custom build pod.
+func TestImageBuildRequestWithBuildProxy(t *testing.T) {
+	t.Parallel()
+
+	bp := buildProxy{
+		Proxy: configv1.ProxyStatus{
+			HTTPProxy:  "http://proxy.example.com:3128",
+			HTTPSProxy: "http://proxy.example.com:3128",
+			NoProxy:    ".cluster.local,.svc",
+		},
+		HasTrustedCA: true,
+	}
+
+	ibr := newImageBuildRequestFromBuildInputs(&buildInputs{
+		pool:                 newMachineConfigPool("worker", "rendered-worker-1"),
+		osImageURL:           getOSImageURLConfigMap(),
+		onClusterBuildConfig: getOnClusterBuildConfigMap(),
+		buildProxy:           bp,
+	})
+
+	build := ibr.toBuild()
+	assert.Equal(t, bp.env(), build.Spec.Strategy.DockerStrategy.Env)
+	require.NotNil(t, build.Spec.MountTrustedCA)
+	assert.True(t, *build.Spec.MountTrustedCA)
+
+	pod := ibr.toBuildPod()
+	for _, env := range bp.env() {
+		assert.Contains(t, pod.Spec.Containers[0].Env, env)
+	}
+	assert.Contains(t, pod.Spec.Containers[0].Env, corev1.EnvVar{Name: "SSL_CERT_DIR", Value: buildTrustedCAMountpoint})
+	assert.Contains(t, pod.Spec.Containers[0].VolumeMounts, corev1.VolumeMount{Name: buildTrustedCAVolumeName, MountPath: buildTrustedCAMountpoint})
+
+	// The wait-for-done container only talks to the API server.
+	assert.NotContains(t, pod.Spec.Containers[1].Env, bp.env()[0])
+
+	// Without a proxy or trusted CA bundle, nothing is injected.
+	ibr.Proxy = buildProxy{}
+	assert.Nil(t, ibr.toBuild().Spec.MountTrustedCA)
+	assert.NotContains(t, ibr.toBuildPod().Spec.Volumes, corev1.Volume{Name: buildTrustedCAVolumeName})
+}
+
+// Tests that the post-build test command is run by both the OpenShift Image
+// Builder build and the custom build pod.
+func TestImageBuildRequestWithPostBuildTestCommand(t *testing.T) {
+	t.Parallel()
+
+	onClusterBuildConfigMap := getOnClusterBuildConfigMap()
+	onClusterBuildConfigMap.Data[PostBuildTestCommandConfigKey] = "  bootc container lint\n"
+
+	ibr := newImageBuildRequestFromBuildInputs(&buildInputs{
+		pool:                 newMachineConfigPool("worker", "rendered-worker-1"),
+		osImageURL:           getOSImageURLConfigMap(),
+		onClusterBuildConfig: onClusterBuildConfigMap,
+	})
+
+	assert.Equal(t, "bootc container lint", ibr.PostBuildTestCommand)
+	assert.Equal(t, "bootc container lint", ibr.toBuild().Spec.PostCommit.Script)
+
+	pod := ibr.toBuildPod()
+	assert.Contains(t, pod.Spec.Containers[0].Env, corev1.EnvVar{Name: "POST_BUILD_TEST_COMMAND", Value: "bootc container lint"})
+	assert.NotContains(t, pod.Spec.Containers[1].Env, corev1.EnvVar{Name: "POST_BUILD_TEST_COMMAND", Value: "bootc container lint"})
+
+	// Without a post-build test command, none is run.
+	ibr.PostBuildTestCommand = ""
+	assert.Empty(t, ibr.toBuild().Spec.PostCommit.Script)
+	for _, env := range ibr.toBuildPod().Spec.Containers[0].Env {
+		assert.NotEqual(t, "POST_BUILD_TEST_COMMAND", env.Name)
+	}
+}
@@ -0,0 +1,209 @@
+package build
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// The on-cluster-build-config ConfigMap key which controls whether the
+	// built image is statically validated before it is pushed. Valid values
+	// are Disabled (the default), Warn, which records the findings on the
+	// pool, and Enforce, which also blocks images with errors from being
+	// rolled out.
+	ImageLintPolicyConfigKey = "imageLintPolicy"
+
+	imageLintPolicyDisabled = "Disabled"
+	imageLintPolicyWarn     = "Warn"
+	imageLintPolicyEnforce  = "Enforce"
+
+	// Pool condition recording the result of the last image lint.
+	MachineConfigPoolImageLintPassed mcfgv1.MachineConfigPoolConditionType = "ImageLintPassed"
+
+	// Condition and event reasons used for the image lint.
+	imageLintFailedReason   = "ImageLintFailed"
+	imageLintWarningsReason = "ImageLintWarnings"
+
+	// The key in the digest ConfigMap which holds the lint findings.
+	imageLintDigestConfigMapKey = "lint"
+
+	imageLintSeverityError   = "error"
+	imageLintSeverityWarning = "warning"
+)
+
+var imageLintPolicies = sets.NewString(imageLintPolicyDisabled, imageLintPolicyWarn, imageLintPolicyEnforce)
+
+// A single finding reported by the image lint script.
+type imageLintFinding struct {
+	Severity string
+	Check    string
+	Message  string
+}
+
+func (f imageLintFinding) String() string {
+	return fmt.Sprintf("[%s] %s: %s", f.Severity, f.Check, f.Message)
+}
+
+// Gets the image lint policy from the on-cluster-build-config ConfigMap.
+func getImageLintPolicy(cm *corev1.ConfigMap) (string, error) {
+	if cm == nil || cm.Data[ImageLintPolicyConfigKey] == "" {
+		return imageLintPolicyDisabled, nil
+	}
+
+	policy := cm.Data[ImageLintPolicyConfigKey]
+	if !imageLintPolicies.Has(policy) {
+		return "", fmt.Errorf("invalid %s %q, valid values are %s", ImageLintPolicyConfigKey, policy, strings.Join(imageLintPolicies.List(), ", "))
+	}
+
+	return policy, nil
+}
+
+// Determines whether the built image is linted.
+func isImageLintEnabled(cm *corev1.ConfigMap) bool {
+	policy, err := getImageLintPolicy(cm)
+	return err == nil && policy != imageLintPolicyDisabled
+}
+
+// Validates the image lint policy from the on-cluster-build-config ConfigMap.
+// Only the custom pod builder is able to lint images since it runs the lint
+// script in a container from the built image.
+func validateImageLintConfig(cm *corev1.ConfigMap) error {
+	policy, err := getImageLintPolicy(cm)
+	if err != nil {
+		return err
+	}
+
+	if policy == imageLintPolicyDisabled {
+		return nil
+	}
+
+	builderType, err := GetImageBuilderType(cm)
+	if err != nil {
+		return err
+	}
+
+	if builderType != CustomPodImageBuilder {
+		return fmt.Errorf("%s requires %s to be %q", ImageLintPolicyConfigKey, ImageBuilderTypeConfigMapKey, CustomPodImageBuilder)
+	}
+
+	return nil
+}
+
+// Parses the findings written by the image lint script, one per line.
+// Findings with an unknown severity are treated as warnings.
+func parseImageLintFindings(data string) []imageLintFinding {
+	out := []imageLintFinding{}
+
+	for _, line := range strings.Split(data, "\n") {
+		fields := strings.SplitN(strings.TrimSpace(line), " ", 3)
+		if len(fields) < 2 {
+			continue
+		}
+
+		finding := imageLintFinding{
+			Severity: fields[0],
+			Check:    fields[1],
+		}
+
+		if len(fields) == 3 {
+			finding.Message = fields[2]
+		}
+
+		if finding.Severity != imageLintSeverityError {
+			finding.Severity = imageLintSeverityWarning
+		}
+
+		out = append(out, finding)
+	}
+
+	return out
+}
+
+// Gets the ImageLintPassed condition for the given findings. Errors only fail
+// the condition when the policy is Enforce.
+func getImageLintCondition(policy, imagePullspec string, findings []imageLintFinding) *mcfgv1.MachineConfigPoolCondition {
+	if len(findings) == 0 {
+		return &mcfgv1.MachineConfigPoolCondition{
+			Type:    MachineConfigPoolImageLintPassed,
+			Status:  corev1.ConditionTrue,
+			Reason:  "ImageLintPassed",
+			Message: fmt.Sprintf("Image %s has no lint findings", imagePullspec),
+		}
+	}
+
+	errors := 0
+	messages := []string{}
+	for _, finding := range findings {
+		if finding.Severity == imageLintSeverityError {
+			errors++
+		}
+
+		messages = append(messages, finding.String())
+	}
+
+	message := fmt.Sprintf("Image %s has %d lint error(s) and %d warning(s): %s", imagePullspec, errors, len(findings)-errors, strings.Join(messages, "; "))
+
+	if errors != 0 && policy == imageLintPolicyEnforce {
+		return &mcfgv1.MachineConfigPoolCondition{
+			Type:    MachineConfigPoolImageLintPassed,
+			Status:  corev1.ConditionFalse,
+			Reason:  imageLintFailedReason,
+			Message: message,
+		}
+	}
+
+	return &mcfgv1.MachineConfigPoolCondition{
+		Type:    MachineConfigPoolImageLintPassed,
+		Status:  corev1.ConditionTrue,
+		Reason:  imageLintWarningsReason,
+		Message: message,
+	}
+}
+
+// Gets the lint findings for the built image from the digest ConfigMap, if
+// image linting is enabled. Returns the ImageLintPassed condition to set on
+// the pool, which is nil if image linting is not enabled.
+func (ctrl *Controller) lintImage(pool *mcfgv1.MachineConfigPool, imagePullspec string) (*mcfgv1.MachineConfigPoolCondition, error) {
+	cm, err := ctrl.configMapLister.ConfigMaps(ctrlcommon.MCONamespace).Get(OnClusterBuildConfigMapName)
+	if err != nil {
+		return nil, fmt.Errorf("could not get build controller config %q: %w", OnClusterBuildConfigMapName, err)
+	}
+
+	policy, err := getImageLintPolicy(cm)
+	if err != nil {
+		return nil, err
+	}
+
+	if policy == imageLintPolicyDisabled {
+		return nil, nil
+	}
+
+	ibr := newImageBuildRequest(pool)
+
+	digestConfigMap, err := ctrl.kubeclient.CoreV1().ConfigMaps(ctrlcommon.MCONamespace).Get(context.TODO(), ibr.getDigestConfigMapName(), metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("could not get digest ConfigMap %s: %w", ibr.getDigestConfigMapName(), err)
+	}
+
+	// The build may have started before image linting was enabled.
+	data, ok := digestConfigMap.Data[imageLintDigestConfigMapKey]
+	if !ok {
+		klog.Infof("Image %s for pool %s was not linted", imagePullspec, pool.Name)
+		return nil, nil
+	}
+
+	cond := getImageLintCondition(policy, imagePullspec, parseImageLintFindings(data))
+	if cond.Reason == imageLintWarningsReason {
+		ctrl.eventRecorder.Eventf(pool, corev1.EventTypeWarning, imageLintWarningsReason, cond.Message)
+	}
+
+	return cond, nil
+}
@@ -0,0 +1,224 @@
+package build
+
+import (
+	"context"
+	"testing"
+
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	fakeclientmachineconfigv1 "github.com/openshift/client-go/machineconfiguration/clientset/versioned/fake"
+	"github.com/openshift/machine-config-operator/pkg/apihelpers"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	testhelpers "github.com/openshift/machine-config-operator/test/helpers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	fakecorev1client "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestValidateImageLintConfig(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name        string
+		builderType string
+		policy      string
+		errExpected bool
+	}{
+		{
+			name:        "Not set",
+			builderType: OpenshiftImageBuilder,
+		},
+		{
+			name:        "Disabled",
+			builderType: OpenshiftImageBuilder,
+			policy:      imageLintPolicyDisabled,
+		},
+		{
+			name:        "Enforce",
+			builderType: CustomPodImageBuilder,
+			policy:      imageLintPolicyEnforce,
+		},
+		{
+			name:        "Unsupported builder",
+			builderType: KanikoImageBuilder,
+			policy:      imageLintPolicyWarn,
+			errExpected: true,
+		},
+		{
+			name:        "Invalid policy",
+			builderType: CustomPodImageBuilder,
+			policy:      "enforce",
+			errExpected: true,
+		},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			cm := getOnClusterBuildConfigMap()
+			cm.Data[ImageBuilderTypeConfigMapKey] = testCase.builderType
+			cm.Data[ImageLintPolicyConfigKey] = testCase.policy
+
+			err := validateImageLintConfig(cm)
+			if testCase.errExpected {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestParseImageLintFindings(t *testing.T) {
+	t.Parallel()
+
+	findings := parseImageLintFindings("error kernel found 2 kernels in /usr/lib/modules, expected 1\n\nwarning var-files files in /var are not updated: /var/lib/foo\nnotice usr-local\n")
+
+	assert.Equal(t, []imageLintFinding{
+		{Severity: imageLintSeverityError, Check: "kernel", Message: "found 2 kernels in /usr/lib/modules, expected 1"},
+		{Severity: imageLintSeverityWarning, Check: "var-files", Message: "files in /var are not updated: /var/lib/foo"},
+		{Severity: imageLintSeverityWarning, Check: "usr-local"},
+	}, findings)
+}
+
+func TestImageBuildRequestImageLint(t *testing.T) {
+	t.Parallel()
+
+	ibr := newImageBuildRequest(newMachineConfigPool("worker"))
+	ibr.ImageLint = true
+
+	for _, container := range ibr.toBuildPod().Spec.Containers {
+		env := map[string]string{}
+		for _, envVar := range container.Env {
+			env[envVar.Name] = envVar.Value
+		}
+
+		if container.Name == "image-build" {
+			assert.Equal(t, imageLintScript, env["IMAGE_LINT_SCRIPT"])
+		} else {
+			assert.NotContains(t, env, "IMAGE_LINT_SCRIPT")
+		}
+	}
+}
+
+// Tests that the lint findings are recorded on the pool and that an image
+// with lint errors is only blocked when the lint is enforced.
+func TestImageLintBlocksRollout(t *testing.T) {
+	t.Parallel()
+
+	image := "registry.hostname.com/org/repo@" + expectedImageSHA
+
+	testCases := []struct {
+		name           string
+		policy         string
+		lint           *string
+		expectedStatus corev1.ConditionStatus
+		expectedReason string
+	}{
+		{
+			name:   "Disabled",
+			policy: imageLintPolicyDisabled,
+			lint:   testhelpers.StrToPtr("error kernel found 0 kernels"),
+		},
+		{
+			name:   "Image was not linted",
+			policy: imageLintPolicyEnforce,
+		},
+		{
+			name:           "No findings",
+			policy:         imageLintPolicyEnforce,
+			lint:           testhelpers.StrToPtr(""),
+			expectedStatus: corev1.ConditionTrue,
+			expectedReason: "ImageLintPassed",
+		},
+		{
+			name:           "Errors are warnings when not enforced",
+			policy:         imageLintPolicyWarn,
+			lint:           testhelpers.StrToPtr("error kernel found 0 kernels\nwarning var-files /var/lib/foo"),
+			expectedStatus: corev1.ConditionTrue,
+			expectedReason: imageLintWarningsReason,
+		},
+		{
+			name:           "Warnings do not block",
+			policy:         imageLintPolicyEnforce,
+			lint:           testhelpers.StrToPtr("warning var-files /var/lib/foo"),
+			expectedStatus: corev1.ConditionTrue,
+			expectedReason: imageLintWarningsReason,
+		},
+		{
+			name:           "Errors block when enforced",
+			policy:         imageLintPolicyEnforce,
+			lint:           testhelpers.StrToPtr("error kernel found 0 kernels\nwarning var-files /var/lib/foo"),
+			expectedStatus: corev1.ConditionFalse,
+			expectedReason: imageLintFailedReason,
+		},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			cm := getOnClusterBuildConfigMap()
+			cm.Data[ImageBuilderTypeConfigMapKey] = CustomPodImageBuilder
+			cm.Data[ImageLintPolicyConfigKey] = testCase.policy
+
+			pool := newMachineConfigPool("worker", "rendered-worker-1")
+
+			digestConfigMap := &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      newImageBuildRequest(pool).getDigestConfigMapName(),
+					Namespace: ctrlcommon.MCONamespace,
+				},
+				Data: map[string]string{
+					"digest": expectedImageSHA,
+				},
+			}
+
+			if testCase.lint != nil {
+				digestConfigMap.Data[imageLintDigestConfigMapKey] = *testCase.lint
+			}
+
+			ctrl := &Controller{
+				Clients: &Clients{
+					kubeclient: fakecorev1client.NewSimpleClientset(cm, digestConfigMap),
+					mcfgclient: fakeclientmachineconfigv1.NewSimpleClientset(pool),
+				},
+				eventRecorder:   record.NewFakeRecorder(10),
+				configMapLister: newConfigMapLister(t, cm),
+			}
+
+			cond, err := ctrl.lintImage(pool, image)
+			require.NoError(t, err)
+
+			if testCase.expectedReason == "" {
+				assert.Nil(t, cond)
+				return
+			}
+
+			require.NotNil(t, cond)
+			assert.Equal(t, testCase.expectedStatus, cond.Status)
+			assert.Equal(t, testCase.expectedReason, cond.Reason)
+
+			if testCase.expectedStatus == corev1.ConditionTrue {
+				return
+			}
+
+			assert.Contains(t, cond.Message, "1 lint error(s) and 1 warning(s)")
+			assert.Contains(t, cond.Message, "[error] kernel: found 0 kernels")
+
+			// Failing builds are reported as a sync error.
+			assert.ErrorContains(t, ctrl.markImageRolloutBlocked(newPoolState(pool), *cond), "kernel")
+
+			mcp, err := ctrl.mcfgclient.MachineconfigurationV1().MachineConfigPools().Get(context.TODO(), pool.Name, metav1.GetOptions{})
+			require.NoError(t, err)
+
+			assert.True(t, apihelpers.IsMachineConfigPoolConditionFalse(mcp.Status.Conditions, MachineConfigPoolImageLintPassed))
+			assert.True(t, apihelpers.IsMachineConfigPoolConditionTrue(mcp.Status.Conditions, mcfgv1.MachineConfigPoolBuildFailed))
+		})
+	}
+}
@@ -0,0 +1,126 @@
+package build
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// The on-cluster-build-config ConfigMap key which, when set to "true",
+	// requires every change to the rendered MachineConfig of a layered pool to
+	// be baked into its image. Builds for rendered MachineConfigs which contain
+	// anything that neither the image nor the MCD can apply are rejected
+	// instead of being rolled out partially.
+	ImageOnlyConfigUpdatesConfigKey = "imageOnlyConfigUpdates"
+
+	perNodeConfigReason = "PerNodeConfig"
+)
+
+// Paths which are backed by a tmpfs on the node, so that content written there
+// by the image build is never seen and content written on the node does not
+// survive a reboot.
+var volatilePathPrefixes = []string{"/run/", "/tmp/", "/var/run/"}
+
+// Gets whether image-only config updates are enabled.
+func getImageOnlyConfigUpdates(cm *corev1.ConfigMap) (bool, error) {
+	if cm == nil {
+		return false, nil
+	}
+
+	val, ok := cm.Data[ImageOnlyConfigUpdatesConfigKey]
+	if !ok || val == "" {
+		return false, nil
+	}
+
+	enabled, err := strconv.ParseBool(val)
+	if err != nil {
+		return false, fmt.Errorf("could not parse %s %q: %w", ImageOnlyConfigUpdatesConfigKey, val, err)
+	}
+
+	return enabled, nil
+}
+
+func isVolatilePath(path string) bool {
+	for _, prefix := range volatilePathPrefixes {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Finds the parts of the given rendered MachineConfig which cannot be applied
+// to a layered node, either because they cannot be baked into the image or
+// because the MCD does not apply them when it rebases onto it. Files under the
+// paths ostree keeps on the node, SSH keys and kernel arguments are not
+// included since the MCD applies them after the rebase. Extensions are not
+// included either since they are installed into the image.
+func getUnappliableConfig(mc *mcfgv1.MachineConfig) ([]string, error) {
+	ignConfig, err := ctrlcommon.ParseAndConvertConfig(mc.Spec.Config.Raw)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse Ignition config of MachineConfig %s: %w", mc.Name, err)
+	}
+
+	out := []string{}
+
+	for _, file := range ignConfig.Storage.Files {
+		if isVolatilePath(file.Path) {
+			out = append(out, fmt.Sprintf("file %s", file.Path))
+		}
+	}
+
+	for _, dir := range ignConfig.Storage.Directories {
+		if isVolatilePath(dir.Path) {
+			out = append(out, fmt.Sprintf("directory %s", dir.Path))
+		}
+	}
+
+	for _, link := range ignConfig.Storage.Links {
+		if isVolatilePath(link.Path) {
+			out = append(out, fmt.Sprintf("link %s", link.Path))
+		}
+	}
+
+	sort.Strings(out)
+
+	if mc.Spec.KernelType != "" && mc.Spec.KernelType != ctrlcommon.KernelTypeDefault {
+		out = append(out, fmt.Sprintf("kernelType %s", mc.Spec.KernelType))
+	}
+
+	if mc.Spec.FIPS {
+		out = append(out, "fips")
+	}
+
+	return out, nil
+}
+
+// Validates that all of the given rendered MachineConfig can be applied to a
+// layered node when image-only config updates are enabled.
+func validateImageOnlyConfig(inputs *buildInputs) error {
+	enabled, err := getImageOnlyConfigUpdates(inputs.onClusterBuildConfig)
+	if err != nil {
+		return err
+	}
+
+	if !enabled {
+		return nil
+	}
+
+	unappliable, err := getUnappliableConfig(inputs.machineConfig)
+	if err != nil {
+		return err
+	}
+
+	if len(unappliable) == 0 {
+		return nil
+	}
+
+	return fmt.Errorf("%s is enabled but MachineConfig %s contains config which cannot be applied through the image: %s", ImageOnlyConfigUpdatesConfigKey, inputs.machineConfig.Name, strings.Join(unappliable, "; "))
+}
@@ -0,0 +1,118 @@
+package build
+
+import (
+	"testing"
+
+	ign3types "github.com/coreos/ignition/v2/config/v3_4/types"
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	"github.com/openshift/machine-config-operator/test/helpers"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Tests that rendered MachineConfigs are only rejected when image-only config
+// updates are enabled and they contain config which cannot be applied through
+// the image.
+func TestValidateImageOnlyConfig(t *testing.T) {
+	t.Parallel()
+
+	newMC := func(files ...string) *mcfgv1.MachineConfig {
+		ignFiles := []ign3types.File{}
+		for _, file := range files {
+			ignFiles = append(ignFiles, ctrlcommon.NewIgnFile(file, "contents"))
+		}
+
+		return helpers.NewMachineConfig("rendered-worker-1", nil, "", ignFiles)
+	}
+
+	testCases := []struct {
+		name           string
+		enabled        string
+		mc             func() *mcfgv1.MachineConfig
+		errorExpected  bool
+		errContains    []string
+		errNotContains []string
+	}{
+		{
+			name: "Disabled",
+			mc: func() *mcfgv1.MachineConfig {
+				mc := newMC("/run/foo")
+				mc.Spec.KernelType = ctrlcommon.KernelTypeRealtime
+				return mc
+			},
+		},
+		{
+			name:    "Invalid toggle",
+			enabled: "sometimes",
+			mc: func() *mcfgv1.MachineConfig {
+				return newMC()
+			},
+			errorExpected: true,
+		},
+		{
+			name:    "Files in the image and on the node",
+			enabled: "true",
+			mc: func() *mcfgv1.MachineConfig {
+				mc := newMC("/etc/foo", "/var/lib/kubelet/config.json", "/home/core/.bashrc")
+				mc.Spec.KernelArguments = []string{"nosmt"}
+				mc.Spec.KernelType = ctrlcommon.KernelTypeDefault
+				return mc
+			},
+		},
+		{
+			name:    "Volatile files",
+			enabled: "true",
+			mc: func() *mcfgv1.MachineConfig {
+				return newMC("/etc/foo", "/tmp/foo", "/run/bar")
+			},
+			errorExpected: true,
+			errContains:   []string{"file /run/bar; file /tmp/foo"},
+		},
+		{
+			name:    "Kernel type and FIPS, but not extensions",
+			enabled: "true",
+			mc: func() *mcfgv1.MachineConfig {
+				mc := newMC()
+				mc.Spec.KernelType = ctrlcommon.KernelTypeRealtime
+				mc.Spec.FIPS = true
+				mc.Spec.Extensions = []string{"usbguard", "kerberos"}
+				return mc
+			},
+			errorExpected:  true,
+			errContains:    []string{"kernelType realtime", "fips"},
+			errNotContains: []string{"extensions"},
+		},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			inputs := &buildInputs{
+				onClusterBuildConfig: &corev1.ConfigMap{
+					Data: map[string]string{
+						ImageOnlyConfigUpdatesConfigKey: testCase.enabled,
+					},
+				},
+				machineConfig: testCase.mc(),
+			}
+
+			err := validateImageOnlyConfig(inputs)
+			if !testCase.errorExpected {
+				assert.NoError(t, err)
+				return
+			}
+
+			assert.Error(t, err)
+			for _, s := range testCase.errContains {
+				assert.Contains(t, err.Error(), s)
+			}
+
+			for _, s := range testCase.errNotContains {
+				assert.NotContains(t, err.Error(), s)
+			}
+		})
+	}
+}
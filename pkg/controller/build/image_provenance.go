@@ -0,0 +1,80 @@
+package build
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/openshift/machine-config-operator/pkg/version"
+	"k8s.io/klog/v2"
+)
+
+// The labels stamped onto every final image which record where it came from,
+// so that inspecting an image (e.g., with skopeo inspect) tells which config
+// it contains without access to the cluster.
+const (
+	// The name of the MachineConfigPool the image was built for.
+	ProvenancePoolLabel = "machineconfiguration.openshift.io/pool"
+	// The name of the rendered MachineConfig the image was built from.
+	ProvenanceRenderedConfigLabel = "machineconfiguration.openshift.io/rendered-config"
+	// The SHA-256 of the rendered MachineConfig JSON, which is the content
+	// of /etc/machine-config-daemon/currentconfig in the image.
+	ProvenanceRenderedConfigHashLabel = "machineconfiguration.openshift.io/rendered-config-sha256"
+	// The version of the controller which built the image.
+	ProvenanceControllerVersionLabel = "machineconfiguration.openshift.io/controller-version"
+	// The image the configs stage was based on; the osImageURL of the release
+	// unless overridden for the pool.
+	ProvenanceBaseImageLabel = "org.opencontainers.image.base.name"
+	// When the build was started, in RFC 3339 format.
+	ProvenanceCreatedLabel = "org.opencontainers.image.created"
+)
+
+// Gets the provenance labels for the final image of the given build inputs.
+func getImageProvenance(inputs *buildInputs, now time.Time) map[string]string {
+	baseImage := inputs.configsBaseImage
+	if baseImage == "" {
+		baseImage = newBaseImageInfo(inputs).Pullspec
+	}
+
+	provenance := map[string]string{
+		ProvenancePoolLabel:              inputs.pool.Name,
+		ProvenanceRenderedConfigLabel:    inputs.pool.Spec.Configuration.Name,
+		ProvenanceControllerVersionLabel: version.Raw,
+		ProvenanceBaseImageLabel:         baseImage,
+		ProvenanceCreatedLabel:           now.UTC().Format(time.RFC3339),
+	}
+
+	if inputs.machineConfig != nil {
+		// This is encoded the same way as for the MachineConfig ConfigMap
+		// which the image's currentconfig is extracted from.
+		if out, err := json.Marshal(inputs.machineConfig); err == nil {
+			provenance[ProvenanceRenderedConfigHashLabel] = fmt.Sprintf("%x", sha256.Sum256(out))
+		} else {
+			klog.Warningf("Could not hash MachineConfig %s for image provenance: %v", inputs.machineConfig.Name, err)
+		}
+	}
+
+	return provenance
+}
+
+// Gets the provenance labels as space-separated key="value" pairs, sorted by
+// key, for the LABEL instruction of the Dockerfile.
+func (i ImageBuildRequest) ProvenanceLabels() string {
+	keys := make([]string, 0, len(i.Provenance))
+	for key := range i.Provenance {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	out := make([]string, 0, len(keys))
+	for _, key := range keys {
+		out = append(out, fmt.Sprintf("%s=%s", strconv.Quote(key), strconv.Quote(i.Provenance[key])))
+	}
+
+	return strings.Join(out, " ")
+}
@@ -0,0 +1,79 @@
+package build
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	"github.com/openshift/machine-config-operator/pkg/version"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestGetImageProvenance(t *testing.T) {
+	t.Parallel()
+
+	mc := &mcfgv1.MachineConfig{ObjectMeta: metav1.ObjectMeta{Name: "rendered-worker-1"}}
+	out, err := json.Marshal(mc)
+	require.NoError(t, err)
+
+	inputs := &buildInputs{
+		pool:                 newMachineConfigPool("worker", "rendered-worker-1"),
+		osImageURL:           getOSImageURLConfigMap(),
+		onClusterBuildConfig: getOnClusterBuildConfigMap(),
+		machineConfig:        mc,
+	}
+
+	now := time.Date(2023, time.November, 2, 12, 34, 56, 0, time.FixedZone("EST", -5*60*60))
+
+	assert.Equal(t, map[string]string{
+		ProvenancePoolLabel:               "worker",
+		ProvenanceRenderedConfigLabel:     "rendered-worker-1",
+		ProvenanceRenderedConfigHashLabel: fmt.Sprintf("%x", sha256.Sum256(out)),
+		ProvenanceControllerVersionLabel:  version.Raw,
+		ProvenanceBaseImageLabel:          inputs.osImageURL.Data[baseOSContainerImageConfigKey],
+		ProvenanceCreatedLabel:            "2023-11-02T17:34:56Z",
+	}, getImageProvenance(inputs, now))
+
+	// Without the MachineConfig, there is nothing to hash.
+	inputs.machineConfig = nil
+	assert.NotContains(t, getImageProvenance(inputs, now), ProvenanceRenderedConfigHashLabel)
+}
+
+func TestImageBuildRequestProvenanceLabels(t *testing.T) {
+	t.Parallel()
+
+	ibr := newImageBuildRequestFromBuildInputs(&buildInputs{
+		pool:                 newMachineConfigPool("worker", "rendered-worker-1"),
+		osImageURL:           getOSImageURLConfigMap(),
+		onClusterBuildConfig: getOnClusterBuildConfigMap(),
+		customDockerfiles: getCustomDockerfileConfigMap(map[string]string{
+			"worker": "FROM configs AS final\nRUN dnf install -y python3",
+		}),
+	})
+
+	ibr.Provenance = map[string]string{
+		ProvenanceRenderedConfigLabel: "rendered-worker-1",
+		ProvenancePoolLabel:           `wor"ker`,
+	}
+
+	expected := `LABEL "machineconfiguration.openshift.io/pool"="wor\"ker" "machineconfiguration.openshift.io/rendered-config"="rendered-worker-1"`
+
+	dockerfile, err := ibr.renderDockerfile()
+	require.NoError(t, err)
+
+	// The labels are applied to the final stage, after the custom Dockerfile.
+	assert.Contains(t, dockerfile, expected)
+	assert.Greater(t, strings.Index(dockerfile, expected), strings.Index(dockerfile, "RUN dnf install -y python3"))
+
+	ibr.Provenance = nil
+
+	dockerfile, err = ibr.renderDockerfile()
+	require.NoError(t, err)
+	assert.NotContains(t, dockerfile, "machineconfiguration.openshift.io/rendered-config")
+}
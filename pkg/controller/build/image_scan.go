@@ -0,0 +1,428 @@
+package build
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// The on-cluster-build-config ConfigMap key which contains the URL of a
+	// scanning webhook. When set, each built image is scanned before it is
+	// rolled out and images with findings at or above the severity threshold
+	// are not rolled out.
+	ImageScanWebhookURLConfigKey = "imageScanWebhookURL"
+
+	// The on-cluster-build-config ConfigMap key which contains the lowest
+	// severity (Low, Medium, High or Critical) which blocks an image from being
+	// rolled out. Defaults to Critical.
+	ImageScanSeverityThresholdConfigKey = "imageScanSeverityThreshold"
+
+	// The on-cluster-build-config ConfigMap key which contains the PEM-encoded
+	// CA bundle used to verify the scanning webhook's serving certificate.
+	// Defaults to the system trust store.
+	ImageScanWebhookCABundleConfigKey = "imageScanWebhookCABundle"
+
+	// Pool condition recording the result of the last image scan.
+	MachineConfigPoolImageScanPassed mcfgv1.MachineConfigPoolConditionType = "ImageScanPassed"
+
+	// Condition and event reason used when an image is blocked by its scan.
+	imageScanFailedReason = "ImageScanFailed"
+
+	// Condition reason used while an image is being scanned.
+	imageScanPendingReason = "ImageScanPending"
+
+	defaultImageScanSeverityThreshold = "Critical"
+
+	imageScanTimeout = 10 * time.Minute
+
+	// How long to wait before scanning an image again after its scan failed.
+	imageScanRetryDelay = time.Minute
+)
+
+// Vulnerability severities, from least to most severe.
+var imageScanSeverities = []string{"Low", "Medium", "High", "Critical"}
+
+// Scans images for vulnerabilities.
+type imageScanner interface {
+	ScanImage(ctx context.Context, policy *imageScanPolicy, pool, pullspec string) (*imageScanResult, error)
+}
+
+// The findings reported by the scanner.
+type imageScanResult struct {
+	Vulnerabilities []ctrlcommon.ImageScanVulnerability `json:"vulnerabilities"`
+}
+
+// Describes when an image is blocked by its scan.
+type imageScanPolicy struct {
+	webhookURL string
+	// Trusted CAs for the webhook, or nil for the system trust store.
+	rootCAs *x509.CertPool
+	// Index into imageScanSeverities.
+	threshold int
+}
+
+// Gets the image scan policy from the on-cluster-build-config ConfigMap.
+// Returns nil if image scanning is not configured.
+func getImageScanPolicy(cm *corev1.ConfigMap) (*imageScanPolicy, error) {
+	if cm == nil || cm.Data[ImageScanWebhookURLConfigKey] == "" {
+		return nil, nil
+	}
+
+	webhookURL := cm.Data[ImageScanWebhookURLConfigKey]
+	parsed, err := url.Parse(webhookURL)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse %s %q: %w", ImageScanWebhookURLConfigKey, webhookURL, err)
+	}
+
+	if (parsed.Scheme != "https" && parsed.Scheme != "http") || parsed.Host == "" {
+		return nil, fmt.Errorf("invalid %s %q: must be an http or https URL", ImageScanWebhookURLConfigKey, webhookURL)
+	}
+
+	threshold := defaultImageScanSeverityThreshold
+	if val, ok := cm.Data[ImageScanSeverityThresholdConfigKey]; ok && val != "" {
+		threshold = val
+	}
+
+	index := getImageScanSeverityIndex(threshold)
+	if index == -1 {
+		return nil, fmt.Errorf("invalid %s %q, valid values are %s", ImageScanSeverityThresholdConfigKey, threshold, strings.Join(imageScanSeverities, ", "))
+	}
+
+	var rootCAs *x509.CertPool
+	if caBundle := cm.Data[ImageScanWebhookCABundleConfigKey]; caBundle != "" {
+		rootCAs = x509.NewCertPool()
+		if !rootCAs.AppendCertsFromPEM([]byte(caBundle)) {
+			return nil, fmt.Errorf("invalid %s: no PEM-encoded certificates found", ImageScanWebhookCABundleConfigKey)
+		}
+	}
+
+	return &imageScanPolicy{
+		webhookURL: webhookURL,
+		rootCAs:    rootCAs,
+		threshold:  index,
+	}, nil
+}
+
+// Gets the index of the given severity, ignoring case. Returns -1 for unknown
+// severities.
+func getImageScanSeverityIndex(severity string) int {
+	for i, s := range imageScanSeverities {
+		if strings.EqualFold(s, severity) {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// Gets the findings which block the image from being rolled out, sorted by ID.
+// Findings with an unknown severity do not block the image.
+func (p *imageScanPolicy) getBlockingVulnerabilities(result *imageScanResult) []string {
+	out := []string{}
+
+	for _, vuln := range result.Vulnerabilities {
+		if getImageScanSeverityIndex(vuln.Severity) >= p.threshold {
+			out = append(out, vuln.ID)
+		}
+	}
+
+	sort.Strings(out)
+
+	return out
+}
+
+// Gets the ImageScanPassed condition for the given scan findings.
+func (p *imageScanPolicy) getCondition(imagePullspec string, vulns []ctrlcommon.ImageScanVulnerability) mcfgv1.MachineConfigPoolCondition {
+	threshold := imageScanSeverities[p.threshold]
+
+	blocking := p.getBlockingVulnerabilities(&imageScanResult{Vulnerabilities: vulns})
+	if len(blocking) == 0 {
+		return mcfgv1.MachineConfigPoolCondition{
+			Type:    MachineConfigPoolImageScanPassed,
+			Status:  corev1.ConditionTrue,
+			Reason:  "ImageScanPassed",
+			Message: fmt.Sprintf("Image %s has no findings at or above %s severity", imagePullspec, threshold),
+		}
+	}
+
+	return mcfgv1.MachineConfigPoolCondition{
+		Type:    MachineConfigPoolImageScanPassed,
+		Status:  corev1.ConditionFalse,
+		Reason:  imageScanFailedReason,
+		Message: fmt.Sprintf("Image %s has %d finding(s) at or above %s severity: %s", imagePullspec, len(blocking), threshold, strings.Join(blocking, ", ")),
+	}
+}
+
+// Gets the result of the scan of the built image, if image scanning is
+// configured. Returns the ImageScanPassed condition to set on the pool, which
+// is nil if image scanning is not configured, and whether the scan has
+// finished. The scan runs in the background so that it does not block a
+// worker. It is started if it is not running yet, e.g., because the controller
+// restarted, and is started again a while after it failed. Its state is kept
+// in the build status so that a finished scan is not repeated when the rest of
+// the post-build work is retried.
+func (ctrl *Controller) getImageScanCondition(pool *mcfgv1.MachineConfigPool, imagePullspec string) (*mcfgv1.MachineConfigPoolCondition, bool, error) {
+	cm, err := ctrl.configMapLister.ConfigMaps(ctrlcommon.MCONamespace).Get(OnClusterBuildConfigMapName)
+	if err != nil {
+		return nil, false, fmt.Errorf("could not get build controller config %q: %w", OnClusterBuildConfigMapName, err)
+	}
+
+	policy, err := getImageScanPolicy(cm)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if policy == nil {
+		return nil, true, nil
+	}
+
+	status, err := ctrl.getBuildStatus(pool)
+	if err != nil {
+		return nil, false, err
+	}
+
+	var scan *ctrlcommon.ImageScanStatus
+	if status != nil && status.Scan != nil && status.Scan.Image == imagePullspec {
+		scan = status.Scan
+	}
+
+	switch {
+	case scan != nil && scan.Phase == ctrlcommon.ImageScanPhaseCompleted:
+		cond := policy.getCondition(imagePullspec, scan.Vulnerabilities)
+		return &cond, true, nil
+	case scan != nil && scan.Phase == ctrlcommon.ImageScanPhasePending && ctrl.isImageScanRunning(pool.Name):
+		klog.V(4).Infof("Image %s for pool %s is being scanned", imagePullspec, pool.Name)
+		return nil, false, nil
+	case scan != nil && scan.Phase == ctrlcommon.ImageScanPhaseFailed && scan.CompletionTime != nil:
+		if remaining := imageScanRetryDelay - time.Since(scan.CompletionTime.Time); remaining > 0 {
+			klog.V(4).Infof("Scanning image %s for pool %s again in %s", imagePullspec, pool.Name, remaining)
+			ctrl.enqueueAfter(pool, remaining)
+			return nil, false, nil
+		}
+	}
+
+	ctrl.startImageScan(pool, policy, imagePullspec)
+
+	return nil, false, nil
+}
+
+// Starts scanning the built image in the background unless it is being
+// scanned already. The pool is synced again once the scan has finished.
+func (ctrl *Controller) startImageScan(pool *mcfgv1.MachineConfigPool, policy *imageScanPolicy, imagePullspec string) {
+	ctrl.imageScanLock.Lock()
+	defer ctrl.imageScanLock.Unlock()
+
+	if ctrl.runningImageScans == nil {
+		ctrl.runningImageScans = sets.NewString()
+	}
+
+	if ctrl.runningImageScans.Has(pool.Name) {
+		return
+	}
+
+	ctrl.runningImageScans.Insert(pool.Name)
+
+	klog.Infof("Scanning image %s for pool %s", imagePullspec, pool.Name)
+
+	now := metav1.Now()
+	ctrl.setBuildStatusScan(pool, &ctrlcommon.ImageScanStatus{
+		Image:     imagePullspec,
+		Phase:     ctrlcommon.ImageScanPhasePending,
+		StartTime: &now,
+	})
+
+	go func() {
+		defer ctrl.enqueueMachineConfigPool(pool)
+		defer ctrl.finishImageScan(pool.Name)
+
+		ctrl.runImageScan(pool, policy, imagePullspec, now)
+	}()
+}
+
+// Scans the built image and records the result in the build status.
+func (ctrl *Controller) runImageScan(pool *mcfgv1.MachineConfigPool, policy *imageScanPolicy, imagePullspec string, started metav1.Time) {
+	ctx, cancel := context.WithTimeout(context.Background(), imageScanTimeout)
+	defer cancel()
+
+	scan := &ctrlcommon.ImageScanStatus{
+		Image:     imagePullspec,
+		StartTime: &started,
+	}
+
+	result, err := ctrl.imageScanner.ScanImage(ctx, policy, pool.Name, imagePullspec)
+
+	now := metav1.Now()
+	scan.CompletionTime = &now
+
+	if err != nil {
+		klog.Errorf("Could not scan image %s for pool %s, retrying in %s: %v", imagePullspec, pool.Name, imageScanRetryDelay, err)
+		scan.Phase = ctrlcommon.ImageScanPhaseFailed
+		scan.Message = err.Error()
+	} else {
+		klog.Infof("Scanned image %s for pool %s: %d finding(s)", imagePullspec, pool.Name, len(result.Vulnerabilities))
+		scan.Phase = ctrlcommon.ImageScanPhaseCompleted
+		scan.Vulnerabilities = result.Vulnerabilities
+	}
+
+	ctrl.setBuildStatusScan(pool, scan)
+}
+
+// Records that the scan of the built image of the given pool is no longer
+// running.
+func (ctrl *Controller) finishImageScan(poolName string) {
+	ctrl.imageScanLock.Lock()
+	defer ctrl.imageScanLock.Unlock()
+
+	ctrl.runningImageScans.Delete(poolName)
+}
+
+// Determines whether the built image of the given pool is being scanned.
+func (ctrl *Controller) isImageScanRunning(poolName string) bool {
+	ctrl.imageScanLock.Lock()
+	defer ctrl.imageScanLock.Unlock()
+
+	return ctrl.runningImageScans.Has(poolName)
+}
+
+// Sets the ImageScanPassed condition of the given pool to Unknown while its
+// built image is being scanned.
+func (ctrl *Controller) markImageScanPending(ps *poolState, imagePullspec string) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		mcp, err := ctrl.mcfgclient.MachineconfigurationV1().MachineConfigPools().Get(context.TODO(), ps.Name(), metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+
+		ps := newPoolState(mcp)
+		ps.SetBuildConditions([]mcfgv1.MachineConfigPoolCondition{
+			{
+				Type:    MachineConfigPoolImageScanPassed,
+				Status:  corev1.ConditionUnknown,
+				Reason:  imageScanPendingReason,
+				Message: fmt.Sprintf("Image %s is being scanned", imagePullspec),
+			},
+		})
+
+		_, err = ctrl.mcfgclient.MachineconfigurationV1().MachineConfigPools().UpdateStatus(context.TODO(), ps.MachineConfigPool(), metav1.UpdateOptions{})
+		return err
+	})
+}
+
+// Marks the given MachineConfigPool as a failed build because its image was
+// blocked by its scan. The image is not rolled out and the build is not
+// retried since rebuilding the same config would produce the same findings.
+func (ctrl *Controller) markImageScanFailed(ps *poolState, cond mcfgv1.MachineConfigPoolCondition) error {
+	return ctrl.markImageRolloutBlocked(ps, cond)
+}
+
+// Marks the given MachineConfigPool as a failed build because its image was
+// blocked by the given condition, whose reason is used for the event and the
+// build failure.
+func (ctrl *Controller) markImageRolloutBlocked(ps *poolState, cond mcfgv1.MachineConfigPoolCondition) error {
+	klog.Errorf("Not rolling out image for pool %s: %s", ps.Name(), cond.Message)
+
+	ctrl.eventRecorder.Eventf(ps.MachineConfigPool(), corev1.EventTypeWarning, cond.Reason, cond.Message)
+
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		mcp, err := ctrl.mcfgclient.MachineconfigurationV1().MachineConfigPools().Get(context.TODO(), ps.Name(), metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+
+		ps := newPoolState(mcp)
+		ps.SetBuildConditions([]mcfgv1.MachineConfigPoolCondition{cond})
+
+		_, err = ctrl.mcfgclient.MachineconfigurationV1().MachineConfigPools().UpdateStatus(context.TODO(), ps.MachineConfigPool(), metav1.UpdateOptions{})
+		return err
+	})
+
+	if err != nil {
+		return err
+	}
+
+	return ctrl.markBuildFailedWithReason(ps, cond.Reason, cond.Message)
+}
+
+// Scans images by POSTing {"pool": ..., "image": ...} to a webhook, which
+// responds with {"vulnerabilities": [{"id": ..., "severity": ...}]}.
+type webhookImageScanner struct {
+	client *http.Client
+}
+
+func (w webhookImageScanner) ScanImage(ctx context.Context, policy *imageScanPolicy, pool, pullspec string) (*imageScanResult, error) {
+	body, err := json.Marshal(map[string]string{
+		"pool":  pool,
+		"image": pullspec,
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, policy.webhookURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	client := w.client
+	if client == nil {
+		client = newImageScanClient(policy.rootCAs)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, 10<<20))
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("scanning webhook returned status %d: %s", resp.StatusCode, strings.TrimSpace(string(respBody)))
+	}
+
+	result := &imageScanResult{}
+	if err := json.Unmarshal(respBody, result); err != nil {
+		return nil, fmt.Errorf("could not parse scanning webhook response: %w", err)
+	}
+
+	return result, nil
+}
+
+// Creates an HTTP client which trusts the given CAs, or the system trust store
+// if none are given.
+func newImageScanClient(rootCAs *x509.CertPool) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if rootCAs != nil {
+		transport.TLSClientConfig = &tls.Config{
+			RootCAs:    rootCAs,
+			MinVersion: tls.VersionTLS12,
+		}
+	}
+
+	return &http.Client{Transport: transport}
+}
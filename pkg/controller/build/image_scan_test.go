@@ -0,0 +1,259 @@
+package build
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	fakeclientmachineconfigv1 "github.com/openshift/client-go/machineconfiguration/clientset/versioned/fake"
+	"github.com/openshift/machine-config-operator/pkg/apihelpers"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	fakecorev1client "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+)
+
+type fakeImageScanner struct {
+	result *imageScanResult
+	err    error
+}
+
+func (f *fakeImageScanner) ScanImage(_ context.Context, _ *imageScanPolicy, _, _ string) (*imageScanResult, error) {
+	return f.result, f.err
+}
+
+func TestGetImageScanPolicy(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name              string
+		data              map[string]string
+		expectedThreshold string
+		errExpected       bool
+	}{
+		{
+			name: "Not configured",
+		},
+		{
+			name:              "Defaults to critical",
+			data:              map[string]string{ImageScanWebhookURLConfigKey: "https://scanner.example.com/scan"},
+			expectedThreshold: "Critical",
+		},
+		{
+			name: "Case-insensitive threshold",
+			data: map[string]string{
+				ImageScanWebhookURLConfigKey:        "https://scanner.example.com/scan",
+				ImageScanSeverityThresholdConfigKey: "high",
+			},
+			expectedThreshold: "High",
+		},
+		{
+			name: "Invalid threshold",
+			data: map[string]string{
+				ImageScanWebhookURLConfigKey:        "https://scanner.example.com/scan",
+				ImageScanSeverityThresholdConfigKey: "severe",
+			},
+			errExpected: true,
+		},
+		{
+			name: "Invalid CA bundle",
+			data: map[string]string{
+				ImageScanWebhookURLConfigKey:      "https://scanner.example.com/scan",
+				ImageScanWebhookCABundleConfigKey: "not a certificate",
+			},
+			errExpected: true,
+		},
+		{
+			name:        "Invalid URL",
+			data:        map[string]string{ImageScanWebhookURLConfigKey: "scanner.example.com/scan"},
+			errExpected: true,
+		},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			policy, err := getImageScanPolicy(&corev1.ConfigMap{Data: testCase.data})
+			if testCase.errExpected {
+				assert.Error(t, err)
+				return
+			}
+
+			assert.NoError(t, err)
+
+			if testCase.expectedThreshold == "" {
+				assert.Nil(t, policy)
+				return
+			}
+
+			assert.Equal(t, testCase.expectedThreshold, imageScanSeverities[policy.threshold])
+		})
+	}
+}
+
+func TestGetBlockingVulnerabilities(t *testing.T) {
+	t.Parallel()
+
+	policy := &imageScanPolicy{threshold: getImageScanSeverityIndex("High")}
+
+	result := &imageScanResult{
+		Vulnerabilities: []ctrlcommon.ImageScanVulnerability{
+			{ID: "CVE-3", Severity: "CRITICAL"},
+			{ID: "CVE-2", Severity: "Medium"},
+			{ID: "CVE-1", Severity: "high"},
+			{ID: "CVE-4", Severity: "Unknown"},
+		},
+	}
+
+	assert.Equal(t, []string{"CVE-1", "CVE-3"}, policy.getBlockingVulnerabilities(result))
+}
+
+func TestWebhookImageScanner(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := map[string]string{}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		if req["image"] == "registry.hostname.com/org/repo:broken" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		json.NewEncoder(w).Encode(imageScanResult{
+			Vulnerabilities: []ctrlcommon.ImageScanVulnerability{{ID: "CVE-1", Severity: "High"}},
+		})
+	}))
+
+	defer server.Close()
+
+	caBundle := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+
+	policy, err := getImageScanPolicy(&corev1.ConfigMap{
+		Data: map[string]string{
+			ImageScanWebhookURLConfigKey:      server.URL,
+			ImageScanWebhookCABundleConfigKey: string(caBundle),
+		},
+	})
+	require.NoError(t, err)
+
+	scanner := webhookImageScanner{}
+
+	result, err := scanner.ScanImage(context.TODO(), policy, "worker", "registry.hostname.com/org/repo:latest")
+	require.NoError(t, err)
+	assert.Equal(t, []ctrlcommon.ImageScanVulnerability{{ID: "CVE-1", Severity: "High"}}, result.Vulnerabilities)
+
+	_, err = scanner.ScanImage(context.TODO(), policy, "worker", "registry.hostname.com/org/repo:broken")
+	assert.Error(t, err)
+
+	// The serving certificate is not trusted without the CA bundle.
+	_, err = scanner.ScanImage(context.TODO(), &imageScanPolicy{webhookURL: server.URL}, "worker", "registry.hostname.com/org/repo:latest")
+	assert.Error(t, err)
+}
+
+// Tests that an image with findings over the threshold is not rolled out and
+// that the scan result is recorded on the pool.
+func TestImageScanBlocksRollout(t *testing.T) {
+	t.Parallel()
+
+	image := "registry.hostname.com/org/repo@sha256:e1a88e4e6eba4e1dfbf5ac8a18a3e6e9ac3e1e02a5ae7e2ff81cd8ac6b5e3e43"
+
+	testCases := []struct {
+		name           string
+		vulns          []ctrlcommon.ImageScanVulnerability
+		expectedStatus corev1.ConditionStatus
+	}{
+		{
+			name:           "Findings below threshold",
+			vulns:          []ctrlcommon.ImageScanVulnerability{{ID: "CVE-1", Severity: "Medium"}},
+			expectedStatus: corev1.ConditionTrue,
+		},
+		{
+			name:           "Findings at threshold",
+			vulns:          []ctrlcommon.ImageScanVulnerability{{ID: "CVE-1", Severity: "Medium"}, {ID: "CVE-2", Severity: "High"}},
+			expectedStatus: corev1.ConditionFalse,
+		},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			cm := getOnClusterBuildConfigMap()
+			cm.Data[ImageScanWebhookURLConfigKey] = "https://scanner.example.com/scan"
+			cm.Data[ImageScanSeverityThresholdConfigKey] = "High"
+
+			pool := newMachineConfigPool("worker", "rendered-worker-1")
+
+			ctrl := &Controller{
+				Clients: &Clients{
+					kubeclient: fakecorev1client.NewSimpleClientset(cm),
+					mcfgclient: fakeclientmachineconfigv1.NewSimpleClientset(pool),
+				},
+				eventRecorder:   record.NewFakeRecorder(10),
+				imageScanner:    &fakeImageScanner{result: &imageScanResult{Vulnerabilities: testCase.vulns}},
+				configMapLister: newConfigMapLister(t, cm),
+			}
+
+			ctrl.enqueueMachineConfigPool = func(*mcfgv1.MachineConfigPool) {}
+			ctrl.setBuildStatusVerifying(pool, image, nil)
+
+			// The image is scanned in the background.
+			cond, scanned, err := ctrl.getImageScanCondition(pool, image)
+			require.NoError(t, err)
+			assert.False(t, scanned)
+			assert.Nil(t, cond)
+
+			require.Eventually(t, func() bool {
+				return !ctrl.isImageScanRunning(pool.Name)
+			}, time.Second*5, time.Millisecond*10)
+
+			status, err := ctrl.getBuildStatus(pool)
+			require.NoError(t, err)
+			assert.Equal(t, ctrlcommon.ImageBuildPhaseVerifying, status.Phase)
+			assert.Equal(t, ctrlcommon.ImageScanPhaseCompleted, status.Scan.Phase)
+
+			// The finished scan is not repeated.
+			ctrl.imageScanner = &fakeImageScanner{err: fmt.Errorf("scanned twice")}
+
+			cond, scanned, err = ctrl.getImageScanCondition(pool, image)
+			require.NoError(t, err)
+			assert.True(t, scanned)
+			require.NotNil(t, cond)
+			assert.Equal(t, testCase.expectedStatus, cond.Status)
+
+			if testCase.expectedStatus == corev1.ConditionTrue {
+				return
+			}
+
+			assert.Contains(t, cond.Message, "CVE-2")
+			assert.NotContains(t, cond.Message, "CVE-1")
+
+			// Failing builds are reported as a sync error.
+			assert.ErrorContains(t, ctrl.markImageScanFailed(newPoolState(pool), *cond), "CVE-2")
+
+			mcp, err := ctrl.mcfgclient.MachineconfigurationV1().MachineConfigPools().Get(context.TODO(), pool.Name, metav1.GetOptions{})
+			require.NoError(t, err)
+
+			assert.True(t, apihelpers.IsMachineConfigPoolConditionFalse(mcp.Status.Conditions, MachineConfigPoolImageScanPassed))
+			assert.True(t, apihelpers.IsMachineConfigPoolConditionTrue(mcp.Status.Conditions, mcfgv1.MachineConfigPoolBuildFailed))
+
+			assert.NotContains(t, mcp.Annotations, ctrlcommon.ExperimentalNewestLayeredImageEquivalentConfigAnnotationKey)
+		})
+	}
+}
@@ -0,0 +1,161 @@
+package build
+
+import (
+	"context"
+	"encoding/pem"
+	"fmt"
+	"strings"
+
+	"github.com/containers/image/v5/docker/reference"
+	digest "github.com/opencontainers/go-digest"
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clientset "k8s.io/client-go/kubernetes"
+)
+
+const (
+	// The on-cluster-build-config ConfigMap key which contains the name of a
+	// Secret in the MCO namespace holding a cosign key pair. When set, the
+	// final OS image is signed with a sigstore signature when it is pushed.
+	// The Secret uses the same keys as "cosign generate-key-pair k8s://...".
+	ImageSigningSecretNameConfigKey = ctrlcommon.ImageSigningSecretNameConfigKey
+
+	imageSigningPrivateKeySecretKey = "cosign.key"
+	imageSigningPasswordSecretKey   = "cosign.password"
+	imageSigningPublicKeySecretKey  = "cosign.pub"
+
+	imageSigningVolumeName = "image-signing-key"
+	imageSigningMountpoint = "/tmp/image-signing-key"
+)
+
+// Gets the name of the image signing Secret, if one is configured.
+func getImageSigningSecretName(cm *corev1.ConfigMap) string {
+	if cm == nil {
+		return ""
+	}
+
+	return cm.Data[ImageSigningSecretNameConfigKey]
+}
+
+// Ensures that the image signing Secret exists and contains a cosign key pair,
+// returning the public key.
+func getImageSigningPublicKey(kubeclient clientset.Interface, name string) (string, error) {
+	secret, err := kubeclient.CoreV1().Secrets(ctrlcommon.MCONamespace).Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		return "", fmt.Errorf("could not get image signing secret %s: %w", name, err)
+	}
+
+	for _, key := range []string{imageSigningPrivateKeySecretKey, imageSigningPublicKeySecretKey} {
+		if len(secret.Data[key]) == 0 {
+			return "", fmt.Errorf("image signing secret %s is missing key %q", name, key)
+		}
+	}
+
+	if block, _ := pem.Decode(secret.Data[imageSigningPublicKeySecretKey]); block == nil {
+		return "", fmt.Errorf("could not decode %s from image signing secret %s", imageSigningPublicKeySecretKey, name)
+	}
+
+	return string(secret.Data[imageSigningPublicKeySecretKey]), nil
+}
+
+// Validates the image signing configuration from the on-cluster-build-config
+// ConfigMap. Only the custom pod builder is able to sign images.
+func validateImageSigningConfig(kubeclient clientset.Interface, cm *corev1.ConfigMap) error {
+	_, err := getImageSigningConfig(kubeclient, cm)
+	return err
+}
+
+// Validates the image signing configuration from the on-cluster-build-config
+// ConfigMap, returning the public key images are signed with. Returns an
+// empty string if image signing is not configured.
+func getImageSigningConfig(kubeclient clientset.Interface, cm *corev1.ConfigMap) (string, error) {
+	name := getImageSigningSecretName(cm)
+	if name == "" {
+		return "", nil
+	}
+
+	builderType, err := GetImageBuilderType(cm)
+	if err != nil {
+		return "", err
+	}
+
+	if builderType != CustomPodImageBuilder {
+		return "", fmt.Errorf("%s requires %s to be %q", ImageSigningSecretNameConfigKey, ImageBuilderTypeConfigMapKey, CustomPodImageBuilder)
+	}
+
+	return getImageSigningPublicKey(kubeclient, name)
+}
+
+// Captures how the image of a build is signed from its inputs. Returns nil if
+// image signing is not configured.
+func newImageSignatureStatus(inputs *buildInputs) *ctrlcommon.ImageSignatureStatus {
+	name := getImageSigningSecretName(inputs.onClusterBuildConfig)
+	if name == "" {
+		return nil
+	}
+
+	return &ctrlcommon.ImageSignatureStatus{
+		SecretName: name,
+		PublicKey:  inputs.imageSigningPublicKey,
+	}
+}
+
+// Gets the reference to the sigstore signature attached to the given image
+// (e.g., registry.hostname.com/org/repo:sha256-<digest>.sig), which is where
+// cosign and Buildah store it.
+func getImageSignatureReference(pullspec string) (string, error) {
+	named, err := reference.ParseNamed(pullspec)
+	if err != nil {
+		return "", fmt.Errorf("could not parse image %q: %w", pullspec, err)
+	}
+
+	canonical, ok := named.(reference.Canonical)
+	if !ok {
+		return "", fmt.Errorf("image %q does not have a digest", pullspec)
+	}
+
+	dgst := canonical.Digest()
+	if err := dgst.Validate(); err != nil {
+		return "", fmt.Errorf("invalid digest in image %q: %w", pullspec, err)
+	}
+
+	if dgst.Algorithm() != digest.SHA256 {
+		return "", fmt.Errorf("unsupported digest algorithm %s in image %q", dgst.Algorithm(), pullspec)
+	}
+
+	tag := strings.Replace(dgst.String(), ":", "-", 1) + ".sig"
+
+	return fmt.Sprintf("%s:%s", named.Name(), tag), nil
+}
+
+// Gets how the given image built for the given pool is signed, as captured in
+// the build status when the build started, along with where its signature is.
+// Returns nil if the image is not signed.
+func (ctrl *Controller) getImageSignature(pool *mcfgv1.MachineConfigPool, imagePullspec string) (*ctrlcommon.ImageSignatureStatus, error) {
+	status, err := ctrl.getBuildStatus(pool)
+	if err != nil {
+		return nil, err
+	}
+
+	// Without the build status, there is no telling whether the image was
+	// signed, so the image must not be rolled out unverified.
+	if status == nil {
+		return nil, fmt.Errorf("build status %s not found", ctrlcommon.GetBuildStatusConfigMapName(pool))
+	}
+
+	if status.Signature == nil {
+		return nil, nil
+	}
+
+	signatureRef, err := getImageSignatureReference(imagePullspec)
+	if err != nil {
+		return nil, err
+	}
+
+	signature := *status.Signature
+	signature.Reference = signatureRef
+
+	return &signature, nil
+}
@@ -0,0 +1,173 @@
+package build
+
+import (
+	"testing"
+
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	fakecorev1client "k8s.io/client-go/kubernetes/fake"
+)
+
+const testImageSigningPublicKey = `-----BEGIN PUBLIC KEY-----
+MFkwEwYHKoZIzj0CAQYIKoZIzj0DAQcDQgAEZV0B0lFzj0mCpYkZyfl1tK6lVtB8
+VkvQ6V9bmjwKXXuU4Ku16gD7GpcTsm3oSBYuaNjZfyg0p9W0YHKAyYp1Xw==
+-----END PUBLIC KEY-----
+`
+
+func TestGetImageSignatureReference(t *testing.T) {
+	t.Parallel()
+
+	sha := "sha256:e1a88e4e6eba4e1dfbf5ac8a18a3e6e9ac3e1e02a5ae7e2ff81cd8ac6b5e3e43"
+
+	ref, err := getImageSignatureReference("registry.hostname.com/org/repo@" + sha)
+	assert.NoError(t, err)
+	assert.Equal(t, "registry.hostname.com/org/repo:sha256-e1a88e4e6eba4e1dfbf5ac8a18a3e6e9ac3e1e02a5ae7e2ff81cd8ac6b5e3e43.sig", ref)
+
+	_, err = getImageSignatureReference("registry.hostname.com/org/repo:latest")
+	assert.Error(t, err)
+}
+
+func TestValidateImageSigningConfig(t *testing.T) {
+	t.Parallel()
+
+	newSecret := func(data map[string]string) *corev1.Secret {
+		secret := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "image-signing-secret",
+				Namespace: ctrlcommon.MCONamespace,
+			},
+			Data: map[string][]byte{},
+		}
+
+		for k, v := range data {
+			secret.Data[k] = []byte(v)
+		}
+
+		return secret
+	}
+
+	validSecret := newSecret(map[string]string{
+		imageSigningPrivateKeySecretKey: "private-key",
+		imageSigningPasswordSecretKey:   "password",
+		imageSigningPublicKeySecretKey:  testImageSigningPublicKey,
+	})
+
+	testCases := []struct {
+		name        string
+		builderType string
+		secret      *corev1.Secret
+		errExpected bool
+	}{
+		{
+			name:        "valid",
+			builderType: CustomPodImageBuilder,
+			secret:      validSecret,
+		},
+		{
+			name:        "unsupported builder",
+			builderType: OpenshiftImageBuilder,
+			secret:      validSecret,
+			errExpected: true,
+		},
+		{
+			name:        "missing secret",
+			builderType: CustomPodImageBuilder,
+			errExpected: true,
+		},
+		{
+			name:        "missing private key",
+			builderType: CustomPodImageBuilder,
+			secret:      newSecret(map[string]string{imageSigningPublicKeySecretKey: testImageSigningPublicKey}),
+			errExpected: true,
+		},
+		{
+			name:        "invalid public key",
+			builderType: CustomPodImageBuilder,
+			secret: newSecret(map[string]string{
+				imageSigningPrivateKeySecretKey: "private-key",
+				imageSigningPublicKeySecretKey:  "not-a-key",
+			}),
+			errExpected: true,
+		},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			cm := getOnClusterBuildConfigMap()
+			cm.Data[ImageBuilderTypeConfigMapKey] = testCase.builderType
+			cm.Data[ImageSigningSecretNameConfigKey] = "image-signing-secret"
+
+			kubeclient := fakecorev1client.NewSimpleClientset()
+			if testCase.secret != nil {
+				kubeclient = fakecorev1client.NewSimpleClientset(testCase.secret)
+			}
+
+			err := validateImageSigningConfig(kubeclient, cm)
+			if testCase.errExpected {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+
+	// Image signing is optional.
+	assert.NoError(t, validateImageSigningConfig(fakecorev1client.NewSimpleClientset(), getOnClusterBuildConfigMap()))
+}
+
+func TestGetImageSignature(t *testing.T) {
+	t.Parallel()
+
+	sha := "sha256:e1a88e4e6eba4e1dfbf5ac8a18a3e6e9ac3e1e02a5ae7e2ff81cd8ac6b5e3e43"
+	image := "registry.hostname.com/org/repo@" + sha
+
+	pool := newMachineConfigPool("worker", "rendered-worker-1")
+
+	ctrl := &Controller{
+		Clients: &Clients{
+			kubeclient: fakecorev1client.NewSimpleClientset(),
+		},
+	}
+
+	// Without a build status, there is no telling whether the image was signed.
+	_, err := ctrl.getImageSignature(pool, image)
+	assert.Error(t, err)
+
+	// The image of a build started without image signing is not signed.
+	cm := getOnClusterBuildConfigMap()
+	ctrl.setBuildStatusStarted(&buildInputs{onClusterBuildConfig: cm, pool: pool}, corev1.ObjectReference{})
+
+	signature, err := ctrl.getImageSignature(pool, image)
+	require.NoError(t, err)
+	assert.Nil(t, signature)
+
+	// The signing configuration is captured when the build starts.
+	cm.Data[ImageSigningSecretNameConfigKey] = "image-signing-secret"
+	ctrl.setBuildStatusStarted(&buildInputs{onClusterBuildConfig: cm, pool: pool, imageSigningPublicKey: testImageSigningPublicKey}, corev1.ObjectReference{})
+	cm.Data[ImageSigningSecretNameConfigKey] = "rotated-image-signing-secret"
+
+	signature, err = ctrl.getImageSignature(pool, image)
+	require.NoError(t, err)
+	assert.Equal(t, &ctrlcommon.ImageSignatureStatus{
+		SecretName: "image-signing-secret",
+		PublicKey:  testImageSigningPublicKey,
+		Reference:  "registry.hostname.com/org/repo:sha256-e1a88e4e6eba4e1dfbf5ac8a18a3e6e9ac3e1e02a5ae7e2ff81cd8ac6b5e3e43.sig",
+	}, signature)
+
+	// The signature is recorded in the build status, where the public key is
+	// read from for the image which was built.
+	ctrl.setBuildStatusVerifying(pool, image, signature)
+
+	status, err := ctrl.getBuildStatus(pool)
+	require.NoError(t, err)
+	assert.Equal(t, signature, status.Signature)
+	assert.Equal(t, testImageSigningPublicKey, ctrlcommon.GetImageSigningPublicKey(status, image))
+	assert.Equal(t, "", ctrlcommon.GetImageSigningPublicKey(status, "registry.hostname.com/org/repo@sha256:0000"))
+	assert.Equal(t, "", ctrlcommon.GetImageSigningPublicKey(nil, image))
+}
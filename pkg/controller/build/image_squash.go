@@ -0,0 +1,169 @@
+package build
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/containers/image/v5/docker"
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/pkg/sysregistriesv2"
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// Annotation set on a MachineConfigPool with squashed layers to the
+	// compressed size in bytes of the newest layered image.
+	ImageSizeAnnotationKey = "machineconfiguration.openshift.io/newestImageSize"
+
+	imageInspectTimeout = time.Minute
+)
+
+// The size of an image as stored in its registry.
+type imageSize struct {
+	// The sum of the compressed sizes of the image's layers.
+	Bytes int64
+	// The number of layers of the image, including those of its base image.
+	Layers int
+}
+
+func (s imageSize) String() string {
+	return fmt.Sprintf("%d layers, %.1f MiB compressed", s.Layers, float64(s.Bytes)/(1<<20))
+}
+
+// Gets the size of images from their registries.
+type imageInspector interface {
+	GetImageSize(ctx context.Context, pullspec string, opts imageResolveOptions) (imageSize, error)
+}
+
+func (registryImageResolver) GetImageSize(ctx context.Context, pullspec string, opts imageResolveOptions) (imageSize, error) {
+	ref, err := docker.ParseReference("//" + pullspec)
+	if err != nil {
+		return imageSize{}, fmt.Errorf("could not parse image %q: %w", pullspec, err)
+	}
+
+	dir, err := os.MkdirTemp("", "build-image-inspect-*")
+	if err != nil {
+		return imageSize{}, err
+	}
+
+	defer os.RemoveAll(dir)
+
+	sys, err := opts.toSystemContext(dir)
+	if err != nil {
+		return imageSize{}, err
+	}
+
+	defer sysregistriesv2.InvalidateCache()
+
+	src, err := ref.NewImageSource(ctx, sys)
+	if err != nil {
+		return imageSize{}, err
+	}
+
+	defer src.Close()
+
+	blob, mimeType, err := src.GetManifest(ctx, nil)
+	if err != nil {
+		return imageSize{}, fmt.Errorf("could not get manifest of image %q: %w", pullspec, err)
+	}
+
+	if manifest.MIMETypeIsMultiImage(mimeType) {
+		return imageSize{}, fmt.Errorf("image %q is a manifest list", pullspec)
+	}
+
+	m, err := manifest.FromBlob(blob, mimeType)
+	if err != nil {
+		return imageSize{}, fmt.Errorf("could not parse manifest of image %q: %w", pullspec, err)
+	}
+
+	size := imageSize{}
+	for _, layer := range m.LayerInfos() {
+		size.Bytes += layer.Size
+		size.Layers++
+	}
+
+	return size, nil
+}
+
+// Validates that the configured image builder is able to squash the layers of
+// the image for pools which request it. Buildah and the OpenShift Image
+// Builder commit each stage as a single layer, Podman and Kaniko are told to,
+// and BuildKit and the external build service have no way to.
+func validateSquashLayersBuildInputs(inputs *buildInputs) error {
+	if !ctrlcommon.NewLayeredPoolState(inputs.pool).IsSquashLayers() {
+		return nil
+	}
+
+	builderType, err := GetImageBuilderType(inputs.onClusterBuildConfig)
+	if err != nil {
+		return err
+	}
+
+	if builderType == BuildKitImageBuilder || builderType == ExternalBuildServiceImageBuilder {
+		return fmt.Errorf("%s is not supported by %s %q", ctrlcommon.SquashLayersAnnotationKey, ImageBuilderTypeConfigMapKey, builderType)
+	}
+
+	return nil
+}
+
+// Gets the size of the squashed image of the given pool so that the benefit of
+// squashing can be seen. Pools which do not squash their layers are skipped.
+// Failing to get the size does not fail the build.
+func (ctrl *Controller) getSquashedImageSize(pool *mcfgv1.MachineConfigPool, imagePullspec string) *imageSize {
+	if !ctrlcommon.NewLayeredPoolState(pool).IsSquashLayers() {
+		return nil
+	}
+
+	size, err := ctrl.inspectFinalImage(imagePullspec)
+	if err != nil {
+		klog.Errorf("Could not get size of image %s for pool %s: %v", imagePullspec, pool.Name, err)
+		ctrl.eventRecorder.Eventf(pool, corev1.EventTypeWarning, "ImageSizeUnknown", "Could not get size of image %s: %v", imagePullspec, err)
+		return nil
+	}
+
+	klog.Infof("Squashed image %s for pool %s has %s", imagePullspec, pool.Name, size)
+	return &size
+}
+
+// Gets the size of the final image with the final image push secret.
+func (ctrl *Controller) inspectFinalImage(imagePullspec string) (imageSize, error) {
+	onClusterBuildConfig, err := ctrl.configMapLister.ConfigMaps(ctrlcommon.MCONamespace).Get(OnClusterBuildConfigMapName)
+	if err != nil {
+		return imageSize{}, fmt.Errorf("could not get build controller config %q: %w", OnClusterBuildConfigMapName, err)
+	}
+
+	opts := imageResolveOptions{}
+
+	if name := onClusterBuildConfig.Data[FinalImagePushSecretNameConfigKey]; name != "" {
+		secret, err := ctrl.kubeclient.CoreV1().Secrets(ctrlcommon.MCONamespace).Get(context.TODO(), name, metav1.GetOptions{})
+		if err != nil {
+			return imageSize{}, fmt.Errorf("could not get final image push secret %s: %w", name, err)
+		}
+
+		canonical, err := canonicalizePullSecret(secret)
+		if err != nil {
+			return imageSize{}, err
+		}
+
+		opts.Authfile = canonical.Data[corev1.DockerConfigJsonKey]
+	}
+
+	if opts.RegistryCAs, err = ctrl.getBuildRegistryCAs(); err != nil {
+		return imageSize{}, fmt.Errorf("could not get registry CAs: %w", err)
+	}
+
+	if opts.Insecure, err = getInsecureRegistry(onClusterBuildConfig); err != nil {
+		return imageSize{}, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.TODO(), imageInspectTimeout)
+	defer cancel()
+
+	return ctrl.imageInspector.GetImageSize(ctx, imagePullspec, opts)
+}
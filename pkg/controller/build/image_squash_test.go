@@ -0,0 +1,175 @@
+package build
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	fakeclientmachineconfigv1 "github.com/openshift/client-go/machineconfiguration/clientset/versioned/fake"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	fakecorev1client "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+)
+
+type fakeImageInspector struct {
+	opts imageResolveOptions
+	size imageSize
+	err  error
+}
+
+func (f *fakeImageInspector) GetImageSize(_ context.Context, _ string, opts imageResolveOptions) (imageSize, error) {
+	f.opts = opts
+	return f.size, f.err
+}
+
+func TestValidateSquashLayersBuildInputs(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		builderType string
+		errExpected bool
+	}{
+		{
+			builderType: OpenshiftImageBuilder,
+		},
+		{
+			builderType: CustomPodImageBuilder,
+		},
+		{
+			builderType: RemoteImageBuilder,
+		},
+		{
+			builderType: KanikoImageBuilder,
+		},
+		{
+			builderType: BuildKitImageBuilder,
+			errExpected: true,
+		},
+		{
+			builderType: ExternalBuildServiceImageBuilder,
+			errExpected: true,
+		},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+		t.Run(testCase.builderType, func(t *testing.T) {
+			t.Parallel()
+
+			inputs := &buildInputs{
+				onClusterBuildConfig: getOnClusterBuildConfigMap(),
+				pool:                 newMachineConfigPool("worker"),
+			}
+			inputs.onClusterBuildConfig.Data[ImageBuilderTypeConfigMapKey] = testCase.builderType
+
+			// Pools do not squash their layers by default.
+			assert.NoError(t, validateSquashLayersBuildInputs(inputs))
+
+			inputs.pool.Annotations = map[string]string{ctrlcommon.SquashLayersAnnotationKey: "true"}
+
+			err := validateSquashLayersBuildInputs(inputs)
+			if testCase.errExpected {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestImageBuildRequestSquashLayers(t *testing.T) {
+	t.Parallel()
+
+	ibr := newImageBuildRequest(newMachineConfigPool("worker"))
+	ibr.SquashLayers = true
+
+	for _, container := range ibr.toBuildPod().Spec.Containers {
+		env := map[string]string{}
+		for _, envVar := range container.Env {
+			env[envVar.Name] = envVar.Value
+		}
+
+		if container.Name == "image-build" {
+			assert.Equal(t, "true", env["SQUASH_LAYERS"])
+		} else {
+			assert.NotContains(t, env, "SQUASH_LAYERS")
+		}
+	}
+}
+
+func TestGetSquashedImageSize(t *testing.T) {
+	t.Parallel()
+
+	image := "registry.hostname.com/org/repo@" + expectedImageSHA
+
+	newController := func(inspector imageInspector) *Controller {
+		cm := getOnClusterBuildConfigMap()
+		cm.Data[InsecureRegistryConfigKey] = "true"
+
+		return &Controller{
+			Clients: &Clients{
+				kubeclient: fakecorev1client.NewSimpleClientset(cm, &corev1.Secret{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "final-image-push-secret",
+						Namespace: ctrlcommon.MCONamespace,
+					},
+					Data: map[string][]byte{
+						corev1.DockerConfigJsonKey: []byte(`{"auths":{"registry.hostname.com":{"auth":"dXNlcjpwYXNz"}}}`),
+					},
+					Type: corev1.SecretTypeDockerConfigJson,
+				}),
+				mcfgclient: fakeclientmachineconfigv1.NewSimpleClientset(),
+			},
+			eventRecorder:   record.NewFakeRecorder(10),
+			imageInspector:  inspector,
+			configMapLister: newConfigMapLister(t, cm),
+		}
+	}
+
+	t.Run("Not squashed", func(t *testing.T) {
+		t.Parallel()
+
+		inspector := &fakeImageInspector{err: fmt.Errorf("should not be called")}
+		ctrl := newController(inspector)
+
+		assert.Nil(t, ctrl.getSquashedImageSize(newMachineConfigPool("worker"), image))
+	})
+
+	t.Run("Squashed", func(t *testing.T) {
+		t.Parallel()
+
+		inspector := &fakeImageInspector{size: imageSize{Bytes: 3 << 20, Layers: 4}}
+		ctrl := newController(inspector)
+
+		pool := newMachineConfigPool("worker")
+		pool.Annotations = map[string]string{ctrlcommon.SquashLayersAnnotationKey: "true"}
+
+		size := ctrl.getSquashedImageSize(pool, image)
+		require.NotNil(t, size)
+		assert.Equal(t, "4 layers, 3.0 MiB compressed", size.String())
+		assert.True(t, inspector.opts.Insecure)
+		assert.Contains(t, string(inspector.opts.Authfile), "registry.hostname.com")
+
+		ps := newPoolState(pool)
+		ps.SetImageSize(size)
+		assert.Equal(t, "3145728", ps.MachineConfigPool().Annotations[ImageSizeAnnotationKey])
+
+		ps.ClearImagePullspec()
+		assert.NotContains(t, ps.MachineConfigPool().Annotations, ImageSizeAnnotationKey)
+	})
+
+	t.Run("Inspection fails", func(t *testing.T) {
+		t.Parallel()
+
+		ctrl := newController(&fakeImageInspector{err: fmt.Errorf("connection refused")})
+
+		pool := newMachineConfigPool("worker")
+		pool.Annotations = map[string]string{ctrlcommon.SquashLayersAnnotationKey: "true"}
+
+		assert.Nil(t, ctrl.getSquashedImageSize(pool, image))
+	})
+}
@@ -0,0 +1,296 @@
+package build
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"text/template"
+
+	"github.com/containers/image/v5/docker/reference"
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/util/retry"
+)
+
+const (
+	// The on-cluster-build-config ConfigMap key which contains a Go template
+	// the tag of the final image is rendered from instead of the name of the
+	// rendered MachineConfig, for example:
+	// {{.Pool}}-{{.RenderedConfigShortHash}}-{{.BuildNumber}}
+	FinalImageTagTemplateConfigKey = "finalImageTagTemplate"
+
+	// Annotation on the MachineConfigPool which records the tag rendered from
+	// the tag template for its current build, so that it can be recorded in
+	// the build history once the build succeeds.
+	BuildImageTagAnnotationKey = "machineconfiguration.openshift.io/build-image-tag"
+
+	invalidImageTagReason = "InvalidImageTag"
+
+	// The number of characters of the rendered MachineConfig hash in
+	// RenderedConfigShortHash.
+	renderedConfigShortHashLength = 10
+)
+
+// The fields available to the tag template.
+type imageTagTemplateData struct {
+	// The name of the MachineConfigPool.
+	Pool string
+	// The name of the rendered MachineConfig the image is built from.
+	RenderedConfig string
+	// The leading characters of the hash of the rendered MachineConfig.
+	RenderedConfigShortHash string
+	// The number of the build within the pool, starting at 1.
+	BuildNumber int
+}
+
+// Populates the tag template fields for the given MachineConfigPool.
+func newImageTagTemplateData(pool *mcfgv1.MachineConfigPool, buildNumber int) imageTagTemplateData {
+	renderedConfig := pool.Spec.Configuration.Name
+
+	hash := strings.TrimPrefix(renderedConfig, fmt.Sprintf("rendered-%s-", pool.Name))
+	if len(hash) > renderedConfigShortHashLength {
+		hash = hash[:renderedConfigShortHashLength]
+	}
+
+	return imageTagTemplateData{
+		Pool:                    pool.Name,
+		RenderedConfig:          renderedConfig,
+		RenderedConfigShortHash: hash,
+		BuildNumber:             buildNumber,
+	}
+}
+
+// Gets the tag template from the on-cluster-build-config ConfigMap. Returns
+// nil if none is set.
+func getImageTagTemplate(cm *corev1.ConfigMap) (*template.Template, error) {
+	if cm == nil || cm.Data[FinalImageTagTemplateConfigKey] == "" {
+		return nil, nil
+	}
+
+	tmpl, err := template.New(FinalImageTagTemplateConfigKey).Option("missingkey=error").Parse(cm.Data[FinalImageTagTemplateConfigKey])
+	if err != nil {
+		return nil, fmt.Errorf("could not parse %s: %w", FinalImageTagTemplateConfigKey, err)
+	}
+
+	return tmpl, nil
+}
+
+// Renders the tag template and ensures the result is a valid image tag.
+func renderImageTag(tmpl *template.Template, data imageTagTemplateData) (string, error) {
+	buf := bytes.NewBuffer([]byte{})
+	if err := tmpl.Execute(buf, data); err != nil {
+		return "", fmt.Errorf("could not render %s: %w", FinalImageTagTemplateConfigKey, err)
+	}
+
+	tag := buf.String()
+
+	named, err := reference.ParseNamed("registry.invalid/image")
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := reference.WithTag(named, tag); err != nil {
+		return "", fmt.Errorf("%s rendered invalid tag %q: %w", FinalImageTagTemplateConfigKey, tag, err)
+	}
+
+	return tag, nil
+}
+
+// Validates the tag template from the on-cluster-build-config ConfigMap. Since
+// all pools push to the same repository, the tag must differ between pools and
+// between successive builds of a pool, or their images would overwrite each
+// other.
+func validateImageTagTemplateConfig(cm *corev1.ConfigMap) error {
+	tmpl, err := getImageTagTemplate(cm)
+	if err != nil {
+		return err
+	}
+
+	if tmpl == nil {
+		return nil
+	}
+
+	newData := func(poolName, hash string, buildNumber int) imageTagTemplateData {
+		pool := &mcfgv1.MachineConfigPool{ObjectMeta: metav1.ObjectMeta{Name: poolName}}
+		pool.Spec.Configuration.Name = fmt.Sprintf("rendered-%s-%s", poolName, hash)
+		return newImageTagTemplateData(pool, buildNumber)
+	}
+
+	tag, err := renderImageTag(tmpl, newData("worker", "0123456789abcdef0123456789abcdef", 1))
+	if err != nil {
+		return err
+	}
+
+	otherPoolTag, err := renderImageTag(tmpl, newData("infra", "0123456789abcdef0123456789abcdef", 1))
+	if err != nil {
+		return err
+	}
+
+	if tag == otherPoolTag {
+		return fmt.Errorf("%s renders the same tag for different pools, it must include {{.Pool}}", FinalImageTagTemplateConfigKey)
+	}
+
+	nextBuildTag, err := renderImageTag(tmpl, newData("worker", "fedcba9876543210fedcba9876543210", 2))
+	if err != nil {
+		return err
+	}
+
+	if tag == nextBuildTag {
+		return fmt.Errorf("%s renders the same tag for different builds, it must include {{.RenderedConfig}}, {{.RenderedConfigShortHash}} or {{.BuildNumber}}", FinalImageTagTemplateConfigKey)
+	}
+
+	return nil
+}
+
+// Gets the number of the next build from the build history of a pool.
+func getNextBuildNumber(history []buildHistoryEntry) int {
+	next := 1
+	for _, entry := range history {
+		if entry.BuildNumber >= next {
+			next = entry.BuildNumber + 1
+		}
+	}
+
+	return next
+}
+
+// Finds whether the tag is already in use by an image built for a different
+// pool or from a different rendered MachineConfig, either in the build history
+// of a pool or by a build in progress. Pushing the image would move the tag
+// away from that image.
+func findImageTagCollision(pool *mcfgv1.MachineConfigPool, tag string, pools []*mcfgv1.MachineConfigPool, histories map[string][]buildHistoryEntry) error {
+	for _, p := range pools {
+		if p.Name == pool.Name {
+			continue
+		}
+
+		if hasActiveBuild(p) && p.Annotations[BuildImageTagAnnotationKey] == tag {
+			return fmt.Errorf("tag %q is in use by the build of pool %s for %s", tag, p.Name, p.Spec.Configuration.Name)
+		}
+	}
+
+	for poolName, history := range histories {
+		for _, entry := range history {
+			if entry.Pruned || entry.Tag != tag {
+				continue
+			}
+
+			if poolName != pool.Name || entry.MachineConfig != pool.Spec.Configuration.Name {
+				return fmt.Errorf("tag %q is in use by image %s of pool %s built from %s", tag, entry.Image, poolName, entry.MachineConfig)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Gets the build histories of all layered MachineConfigPools, keyed by pool
+// name.
+func (ctrl *Controller) getBuildHistories(pools []*mcfgv1.MachineConfigPool) (map[string][]buildHistoryEntry, error) {
+	histories := map[string][]buildHistoryEntry{}
+
+	for _, pool := range pools {
+		if !ctrlcommon.IsLayeredPool(pool) {
+			continue
+		}
+
+		cm, err := ctrl.kubeclient.CoreV1().ConfigMaps(ctrlcommon.MCONamespace).Get(context.TODO(), getBuildHistoryConfigMapName(pool), metav1.GetOptions{})
+		if err != nil && !k8serrors.IsNotFound(err) {
+			return nil, fmt.Errorf("could not get build history for pool %s: %w", pool.Name, err)
+		}
+
+		if k8serrors.IsNotFound(err) {
+			continue
+		}
+
+		history, err := parseBuildHistory(cm)
+		if err != nil {
+			return nil, err
+		}
+
+		histories[pool.Name] = history
+	}
+
+	return histories, nil
+}
+
+// Renders the tag template, if any, for the build of the given pool and
+// ensures that the tag does not collide with that of another image. Returns
+// an empty tag when no template is set.
+func (ctrl *Controller) getFinalImageTag(inputs *buildInputs) (string, error) {
+	tmpl, err := getImageTagTemplate(inputs.onClusterBuildConfig)
+	if err != nil || tmpl == nil {
+		return "", err
+	}
+
+	pools, err := ctrl.mcpLister.List(labels.Everything())
+	if err != nil {
+		return "", fmt.Errorf("could not list MachineConfigPools: %w", err)
+	}
+
+	histories, err := ctrl.getBuildHistories(pools)
+	if err != nil {
+		return "", err
+	}
+
+	tag, err := renderImageTag(tmpl, newImageTagTemplateData(inputs.pool, getNextBuildNumber(histories[inputs.pool.Name])))
+	if err != nil {
+		return "", err
+	}
+
+	if err := findImageTagCollision(inputs.pool, tag, pools, histories); err != nil {
+		return "", err
+	}
+
+	return tag, nil
+}
+
+// Replaces the tag of the final image pullspec in the build inputs with the
+// given tag.
+func setFinalImageTag(inputs *buildInputs, tag string) error {
+	pullspec := inputs.onClusterBuildConfig.Data[FinalImagePullspecConfigKey]
+
+	named, err := reference.ParseNamed(pullspec)
+	if err != nil {
+		return fmt.Errorf("could not parse %s with %q: %w", FinalImagePullspecConfigKey, pullspec, err)
+	}
+
+	tagged, err := reference.WithTag(reference.TrimNamed(named), tag)
+	if err != nil {
+		return fmt.Errorf("could not add tag %s to image pullspec %s: %w", tag, pullspec, err)
+	}
+
+	inputs.onClusterBuildConfig.Data[FinalImagePullspecConfigKey] = tagged.String()
+	return nil
+}
+
+// Adds or removes the build image tag annotation on the MachineConfigPool.
+func (ctrl *Controller) setBuildImageTag(ps *poolState, tag string) error {
+	if ps.MachineConfigPool().Annotations[BuildImageTagAnnotationKey] == tag {
+		return nil
+	}
+
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		mcp, err := ctrl.mcfgclient.MachineconfigurationV1().MachineConfigPools().Get(context.TODO(), ps.Name(), metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+
+		ps := newPoolState(mcp)
+		ps.SetBuildImageTag(tag)
+
+		_, err = ctrl.mcfgclient.MachineconfigurationV1().MachineConfigPools().Update(context.TODO(), ps.pool, metav1.UpdateOptions{})
+		return err
+	})
+
+	if err != nil {
+		return fmt.Errorf("could not update build image tag annotation for MachineConfigPool %s: %w", ps.Name(), err)
+	}
+
+	return nil
+}
@@ -0,0 +1,146 @@
+package build
+
+import (
+	"testing"
+
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestValidateImageTagTemplateConfig(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name        string
+		value       string
+		errExpected bool
+	}{
+		{
+			name: "not set",
+		},
+		{
+			name:  "pool, short hash and build number",
+			value: "{{.Pool}}-{{.RenderedConfigShortHash}}-{{.BuildNumber}}",
+		},
+		{
+			name:  "rendered config",
+			value: "os-{{.RenderedConfig}}",
+		},
+		{
+			name:        "unparseable",
+			value:       "{{.Pool",
+			errExpected: true,
+		},
+		{
+			name:        "unknown field",
+			value:       "{{.Pool}}-{{.Hash}}",
+			errExpected: true,
+		},
+		{
+			name:        "invalid tag",
+			value:       "{{.Pool}}/{{.BuildNumber}}",
+			errExpected: true,
+		},
+		{
+			name:        "same tag for every pool",
+			value:       "build-{{.BuildNumber}}",
+			errExpected: true,
+		},
+		{
+			name:        "same tag for every build",
+			value:       "{{.Pool}}-latest",
+			errExpected: true,
+		},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			cm := getOnClusterBuildConfigMap()
+			cm.Data[FinalImageTagTemplateConfigKey] = testCase.value
+
+			err := validateImageTagTemplateConfig(cm)
+			if testCase.errExpected {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestRenderImageTag(t *testing.T) {
+	t.Parallel()
+
+	cm := &corev1.ConfigMap{
+		Data: map[string]string{
+			FinalImageTagTemplateConfigKey: "{{.Pool}}-{{.RenderedConfigShortHash}}-{{.BuildNumber}}",
+		},
+	}
+
+	tmpl, err := getImageTagTemplate(cm)
+	require.NoError(t, err)
+
+	pool := newMachineConfigPool("worker", "rendered-worker-0123456789abcdef0123456789abcdef")
+
+	tag, err := renderImageTag(tmpl, newImageTagTemplateData(pool, 7))
+	require.NoError(t, err)
+	assert.Equal(t, "worker-0123456789-7", tag)
+
+	history := []buildHistoryEntry{{BuildNumber: 3}, {BuildNumber: 5}, {}}
+	assert.Equal(t, 6, getNextBuildNumber(history))
+	assert.Equal(t, 1, getNextBuildNumber(nil))
+}
+
+func TestFindImageTagCollision(t *testing.T) {
+	t.Parallel()
+
+	worker := newMachineConfigPool("worker", "rendered-worker-2")
+
+	building := newMachineConfigPool("infra", "rendered-infra-2")
+	building.Labels[ctrlcommon.LayeringEnabledPoolLabel] = ""
+	building.Annotations = map[string]string{BuildImageTagAnnotationKey: "building"}
+	ps := newPoolState(building)
+	ps.SetBuildConditions([]mcfgv1.MachineConfigPoolCondition{
+		{
+			Type:   mcfgv1.MachineConfigPoolBuilding,
+			Status: corev1.ConditionTrue,
+		},
+	})
+
+	pools := []*mcfgv1.MachineConfigPool{worker, ps.MachineConfigPool()}
+
+	histories := map[string][]buildHistoryEntry{
+		"worker": {
+			{MachineConfig: "rendered-worker-2", Tag: "current"},
+			{MachineConfig: "rendered-worker-1", Tag: "previous"},
+			{MachineConfig: "rendered-worker-0", Tag: "pruned", Pruned: true},
+		},
+		"infra": {
+			{MachineConfig: "rendered-infra-1", Tag: "infra"},
+		},
+	}
+
+	assert.NoError(t, findImageTagCollision(worker, "new", pools, histories))
+	assert.NoError(t, findImageTagCollision(worker, "current", pools, histories), "rebuilding the same config may reuse its tag")
+	assert.NoError(t, findImageTagCollision(worker, "pruned", pools, histories), "the tag of a deleted image may be reused")
+	assert.Error(t, findImageTagCollision(worker, "previous", pools, histories))
+	assert.Error(t, findImageTagCollision(worker, "infra", pools, histories))
+	assert.Error(t, findImageTagCollision(worker, "building", pools, histories))
+}
+
+func TestSetFinalImageTag(t *testing.T) {
+	t.Parallel()
+
+	inputs := &buildInputs{
+		onClusterBuildConfig: getOnClusterBuildConfigMap(),
+	}
+
+	require.NoError(t, setFinalImageTag(inputs, "worker-0123456789-7"))
+	assert.Equal(t, "registry.hostname.com/org/repo:worker-0123456789-7", newFinalImageInfo(inputs).Pullspec)
+}
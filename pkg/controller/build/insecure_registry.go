@@ -0,0 +1,81 @@
+package build
+
+import (
+	"fmt"
+	"strconv"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// The on-cluster-build-config ConfigMap key which, when set to "true",
+	// allows the final image to be pushed to and copied from a registry which
+	// is served over plain HTTP or with a certificate that cannot be verified.
+	// TLS is verified for every other registry the build talks to.
+	InsecureRegistryConfigKey = "insecureRegistry"
+)
+
+// Gets whether the final image registry is insecure.
+func getInsecureRegistry(cm *corev1.ConfigMap) (bool, error) {
+	if cm == nil {
+		return false, nil
+	}
+
+	val, ok := cm.Data[InsecureRegistryConfigKey]
+	if !ok || val == "" {
+		return false, nil
+	}
+
+	insecure, err := strconv.ParseBool(val)
+	if err != nil {
+		return false, fmt.Errorf("could not parse %s %q: %w", InsecureRegistryConfigKey, val, err)
+	}
+
+	return insecure, nil
+}
+
+// Validates the insecure registry toggle from the on-cluster-build-config
+// ConfigMap. The OpenShift Image Builder and the external build service push
+// the final image themselves, so they cannot be told to skip TLS verification.
+func validateInsecureRegistryConfig(cm *corev1.ConfigMap) error {
+	insecure, err := getInsecureRegistry(cm)
+	if err != nil {
+		return err
+	}
+
+	if !insecure {
+		return nil
+	}
+
+	builderType, err := GetImageBuilderType(cm)
+	if err != nil {
+		return err
+	}
+
+	if builderType == OpenshiftImageBuilder || builderType == ExternalBuildServiceImageBuilder {
+		return fmt.Errorf("%s is not supported by %s %q", InsecureRegistryConfigKey, ImageBuilderTypeConfigMapKey, builderType)
+	}
+
+	return nil
+}
+
+// Tells the image-build container, which pushes the final image, and the
+// wait-for-done container, which copies it to the additional final image
+// destinations, to skip TLS verification for the final image registry.
+func (i ImageBuildRequest) addInsecureRegistry(pod *corev1.Pod) {
+	if !i.InsecureRegistry {
+		return
+	}
+
+	for idx := range pod.Spec.Containers {
+		container := &pod.Spec.Containers[idx]
+		if container.Name != "image-build" && container.Name != "wait-for-done" {
+			continue
+		}
+
+		container.Env = append(container.Env, corev1.EnvVar{
+			Name:  "FINAL_IMAGE_INSECURE_REGISTRY",
+			Value: "true",
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package build
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetInsecureRegistry(t *testing.T) {
+	t.Parallel()
+
+	insecure, err := getInsecureRegistry(nil)
+	assert.NoError(t, err)
+	assert.False(t, insecure)
+
+	cm := getOnClusterBuildConfigMap()
+	insecure, err = getInsecureRegistry(cm)
+	assert.NoError(t, err)
+	assert.False(t, insecure)
+
+	cm.Data[InsecureRegistryConfigKey] = "true"
+	insecure, err = getInsecureRegistry(cm)
+	assert.NoError(t, err)
+	assert.True(t, insecure)
+
+	cm.Data[InsecureRegistryConfigKey] = "yes please"
+	_, err = getInsecureRegistry(cm)
+	assert.Error(t, err)
+}
+
+func TestValidateInsecureRegistryConfig(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		builderType string
+		errExpected bool
+	}{
+		{
+			builderType: CustomPodImageBuilder,
+		},
+		{
+			builderType: KanikoImageBuilder,
+		},
+		{
+			builderType: BuildKitImageBuilder,
+		},
+		{
+			builderType: RemoteImageBuilder,
+		},
+		{
+			builderType: OpenshiftImageBuilder,
+			errExpected: true,
+		},
+		{
+			builderType: ExternalBuildServiceImageBuilder,
+			errExpected: true,
+		},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+		t.Run(testCase.builderType, func(t *testing.T) {
+			t.Parallel()
+
+			cm := getOnClusterBuildConfigMap()
+			cm.Data[ImageBuilderTypeConfigMapKey] = testCase.builderType
+			cm.Data[InsecureRegistryConfigKey] = "true"
+
+			err := validateInsecureRegistryConfig(cm)
+			if testCase.errExpected {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+
+			// TLS verification is enabled by default for every builder.
+			cm.Data[InsecureRegistryConfigKey] = "false"
+			assert.NoError(t, validateInsecureRegistryConfig(cm))
+		})
+	}
+}
+
+func TestImageBuildRequestInsecureRegistry(t *testing.T) {
+	t.Parallel()
+
+	getEnv := func(ibr ImageBuildRequest) map[string]map[string]string {
+		out := map[string]map[string]string{}
+		for _, container := range ibr.toBuildPod().Spec.Containers {
+			out[container.Name] = map[string]string{}
+			for _, envVar := range container.Env {
+				out[container.Name][envVar.Name] = envVar.Value
+			}
+		}
+		return out
+	}
+
+	ibr := newImageBuildRequest(newMachineConfigPool("worker"))
+	for _, env := range getEnv(ibr) {
+		assert.NotContains(t, env, "FINAL_IMAGE_INSECURE_REGISTRY")
+	}
+
+	ibr.InsecureRegistry = true
+	for name, env := range getEnv(ibr) {
+		assert.Equal(t, "true", env["FINAL_IMAGE_INSECURE_REGISTRY"], name)
+	}
+}
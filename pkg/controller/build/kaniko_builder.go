@@ -0,0 +1,96 @@
+package build
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// The debug variant of the Kaniko executor image contains a busybox shell,
+	// which the build script needs.
+	kanikoImagePullspec string = "gcr.io/kaniko-project/executor:debug"
+
+	// Kaniko reads the merged registry credentials from config.json in this
+	// directory.
+	kanikoDockerConfigMountpoint = "/kaniko/.docker"
+
+	// Kaniko unpacks the base image over the root filesystem of its own
+	// container, so the build context lives on a separate mount which it
+	// leaves alone.
+	kanikoContextVolumeName = "kaniko-context"
+	kanikoContextMountpoint = "/workspace"
+
+	unsupportedByImageBuilderReason = "UnsupportedByImageBuilder"
+)
+
+// Validates the on-cluster-build-config ConfigMap against what Kaniko can do.
+// Kaniko cannot run containers, so it cannot run the post-build test command.
+// Image signing is already limited to the custom pod builder.
+func validateKanikoBuilderConfig(cm *corev1.ConfigMap) error {
+	if cm == nil || cm.Data[ImageBuilderTypeConfigMapKey] != KanikoImageBuilder {
+		return nil
+	}
+
+	if getPostBuildTestCommand(cm) != "" {
+		return fmt.Errorf("%s is not supported by %s %q", PostBuildTestCommandConfigKey, ImageBuilderTypeConfigMapKey, KanikoImageBuilder)
+	}
+
+	return nil
+}
+
+// Validates that Kaniko is able to build the rendered MachineConfig. The
+// extensions are installed with RUN --mount, which Kaniko does not support.
+func validateKanikoBuildInputs(inputs *buildInputs) error {
+	if inputs.onClusterBuildConfig.Data[ImageBuilderTypeConfigMapKey] != KanikoImageBuilder {
+		return nil
+	}
+
+	if len(inputs.machineConfig.Spec.Extensions) != 0 {
+		return fmt.Errorf("MachineConfig %s enables extensions %v, which are not supported by %s %q", inputs.machineConfig.Name, inputs.machineConfig.Spec.Extensions, ImageBuilderTypeConfigMapKey, KanikoImageBuilder)
+	}
+
+	return nil
+}
+
+// Creates a build pod which builds and pushes the image with Kaniko. Kaniko
+// runs as root within its container, but does not need it to be privileged.
+// The pod otherwise matches the Buildah build pod, so the wait-for-done
+// container reports the digest of the pushed image the same way.
+func (i ImageBuildRequest) toKanikoBuildPod() *corev1.Pod {
+	pod := i.toBuildahPod()
+
+	var root int64 = 0
+	privileged := false
+
+	for idx := range pod.Spec.Containers {
+		container := &pod.Spec.Containers[idx]
+		if container.Name != "image-build" {
+			continue
+		}
+
+		container.Image = kanikoImagePullspec
+		container.Command = []string{"/busybox/sh", "-c", kanikoBuildScript}
+		container.SecurityContext = &corev1.SecurityContext{
+			RunAsUser:                &root,
+			RunAsGroup:               &root,
+			Privileged:               &privileged,
+			AllowPrivilegeEscalation: &privileged,
+		}
+		container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+			Name:      kanikoContextVolumeName,
+			MountPath: kanikoContextMountpoint,
+		})
+	}
+
+	pod.Spec.Volumes = append(pod.Spec.Volumes, corev1.Volume{
+		Name: kanikoContextVolumeName,
+		VolumeSource: corev1.VolumeSource{
+			EmptyDir: &corev1.EmptyDirVolumeSource{},
+		},
+	})
+
+	i.mergeRegistryCreds(pod, kanikoDockerConfigMountpoint)
+
+	return pod
+}
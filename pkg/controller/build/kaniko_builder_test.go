@@ -0,0 +1,103 @@
+package build
+
+import (
+	"testing"
+
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestValidateKanikoBuilderConfig(t *testing.T) {
+	t.Parallel()
+
+	cm := getOnClusterBuildConfigMap()
+	cm.Data[PostBuildTestCommandConfigKey] = "rpm -q python3"
+
+	// The post-build test command is only rejected for Kaniko.
+	assert.NoError(t, validateKanikoBuilderConfig(cm))
+
+	cm.Data[ImageBuilderTypeConfigMapKey] = KanikoImageBuilder
+	assert.Error(t, validateKanikoBuilderConfig(cm))
+
+	delete(cm.Data, PostBuildTestCommandConfigKey)
+	assert.NoError(t, validateKanikoBuilderConfig(cm))
+
+	builderType, err := GetImageBuilderType(cm)
+	assert.NoError(t, err)
+	assert.Equal(t, KanikoImageBuilder, builderType)
+}
+
+func TestValidateKanikoBuildInputs(t *testing.T) {
+	t.Parallel()
+
+	cm := getOnClusterBuildConfigMap()
+	cm.Data[ImageBuilderTypeConfigMapKey] = KanikoImageBuilder
+
+	inputs := &buildInputs{
+		onClusterBuildConfig: cm,
+		machineConfig:        &mcfgv1.MachineConfig{ObjectMeta: metav1.ObjectMeta{Name: "rendered-worker-1"}},
+	}
+
+	assert.NoError(t, validateKanikoBuildInputs(inputs))
+
+	inputs.machineConfig.Spec.Extensions = []string{"usbguard"}
+	err := validateKanikoBuildInputs(inputs)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "usbguard")
+
+	// Buildah installs extensions just fine.
+	cm.Data[ImageBuilderTypeConfigMapKey] = CustomPodImageBuilder
+	assert.NoError(t, validateKanikoBuildInputs(inputs))
+}
+
+// Tests that the Kaniko build pod builds with an unprivileged Kaniko container
+// and reads the merged registry credentials.
+func TestImageBuildRequestWithKaniko(t *testing.T) {
+	t.Parallel()
+
+	onClusterBuildConfigMap := getOnClusterBuildConfigMap()
+	onClusterBuildConfigMap.Data[ImageBuilderTypeConfigMapKey] = KanikoImageBuilder
+
+	ibr := newImageBuildRequestFromBuildInputs(&buildInputs{
+		pool:                 newMachineConfigPool("worker", "rendered-worker-1"),
+		osImageURL:           getOSImageURLConfigMap(),
+		onClusterBuildConfig: onClusterBuildConfigMap,
+		buildArgs:            []corev1.EnvVar{{Name: "HTTP_PROXY", Value: "http://proxy.example.com:3128"}},
+	})
+
+	assert.True(t, ibr.Kaniko)
+
+	pod := ibr.toBuildPod()
+
+	require.Len(t, pod.Spec.InitContainers, 1)
+	initContainer := pod.Spec.InitContainers[0]
+	assert.Equal(t, "merge-registry-creds", initContainer.Name)
+	assert.Equal(t, ibr.BaseImage.Pullspec, initContainer.Image)
+	assert.Contains(t, initContainer.VolumeMounts, corev1.VolumeMount{Name: "base-image-pull-creds", MountPath: "/tmp/base-image-pull-creds"})
+	assert.Contains(t, initContainer.VolumeMounts, corev1.VolumeMount{Name: "final-image-push-creds", MountPath: "/tmp/final-image-push-creds"})
+	assert.NotContains(t, initContainer.Env, corev1.EnvVar{Name: "HTTP_PROXY", Value: "http://proxy.example.com:3128"})
+
+	buildContainer := pod.Spec.Containers[0]
+	assert.Equal(t, "image-build", buildContainer.Name)
+	assert.Equal(t, kanikoImagePullspec, buildContainer.Image)
+	assert.Equal(t, kanikoBuildScript, buildContainer.Command[len(buildContainer.Command)-1])
+	assert.Equal(t, int64(0), *buildContainer.SecurityContext.RunAsUser)
+	assert.False(t, *buildContainer.SecurityContext.Privileged)
+	assert.Contains(t, buildContainer.Env, corev1.EnvVar{Name: "TAG", Value: ibr.FinalImage.Pullspec})
+	assert.Contains(t, buildContainer.Env, corev1.EnvVar{Name: "HTTP_PROXY", Value: "http://proxy.example.com:3128"})
+	assert.Contains(t, buildContainer.VolumeMounts, corev1.VolumeMount{Name: mergedRegistryCredsVolumeName, MountPath: kanikoDockerConfigMountpoint})
+	assert.Contains(t, buildContainer.VolumeMounts, corev1.VolumeMount{Name: "done", MountPath: "/tmp/done"})
+	assert.NotContains(t, buildContainer.VolumeMounts, corev1.VolumeMount{Name: "final-image-push-creds", MountPath: "/tmp/final-image-push-creds"})
+
+	// The wait-for-done container still creates the digest ConfigMap.
+	assert.Equal(t, "wait-for-done", pod.Spec.Containers[1].Name)
+	assert.Equal(t, waitScript, pod.Spec.Containers[1].Command[len(pod.Spec.Containers[1].Command)-1])
+
+	// Without Kaniko, Buildah builds the image in the pod.
+	ibr.Kaniko = false
+	assert.Equal(t, buildahImagePullspec, ibr.toBuildPod().Spec.Containers[0].Image)
+	assert.Empty(t, ibr.toBuildPod().Spec.InitContainers)
+}
@@ -2,9 +2,13 @@ package build
 
 import (
 	"fmt"
+	"strconv"
+	"strings"
+	"time"
 
 	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
 	"github.com/openshift/machine-config-operator/pkg/apihelpers"
+	"github.com/openshift/machine-config-operator/pkg/apihelpers/conditions"
 	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
 	corev1 "k8s.io/api/core/v1"
 )
@@ -49,13 +53,142 @@ func (p *poolState) SetImagePullspec(pullspec string) {
 	p.pool.Annotations[ctrlcommon.ExperimentalNewestLayeredImageEquivalentConfigAnnotationKey] = pullspec
 }
 
-// Clears the image pullspec annotation.
+// Clears the image pullspec annotation along with any additional image
+// pullspec annotations.
 func (p *poolState) ClearImagePullspec() {
 	if p.pool.Annotations == nil {
 		return
 	}
 
 	delete(p.pool.Annotations, ctrlcommon.ExperimentalNewestLayeredImageEquivalentConfigAnnotationKey)
+	p.SetAdditionalImagePullspecs(nil)
+	p.SetImageSize(nil)
+}
+
+// Sets the annotation listing the digested pullspecs the image was copied to,
+// removing it when the image was not copied anywhere.
+func (p *poolState) SetAdditionalImagePullspecs(pullspecs []string) {
+	if len(pullspecs) == 0 {
+		delete(p.pool.Annotations, ctrlcommon.AdditionalImagePullspecsAnnotationKey)
+		return
+	}
+
+	if p.pool.Annotations == nil {
+		p.pool.Annotations = map[string]string{}
+	}
+
+	p.pool.Annotations[ctrlcommon.AdditionalImagePullspecsAnnotationKey] = strings.Join(pullspecs, ",")
+}
+
+// Sets the annotations listing the digested pullspecs of the retained images
+// and the rendered MachineConfigs they were built from, removing them when
+// there are none.
+func (p *poolState) SetRetainedImages(retained []buildHistoryEntry) {
+	if len(retained) == 0 {
+		delete(p.pool.Annotations, ctrlcommon.RetainedImagePullspecsAnnotationKey)
+		delete(p.pool.Annotations, ctrlcommon.RetainedImageMachineConfigsAnnotationKey)
+		return
+	}
+
+	if p.pool.Annotations == nil {
+		p.pool.Annotations = map[string]string{}
+	}
+
+	pullspecs := []string{}
+	machineConfigs := []string{}
+
+	for _, entry := range retained {
+		pullspecs = append(pullspecs, entry.Image)
+		machineConfigs = append(machineConfigs, entry.Image+"="+entry.MachineConfig)
+	}
+
+	p.pool.Annotations[ctrlcommon.RetainedImagePullspecsAnnotationKey] = strings.Join(pullspecs, ",")
+	p.pool.Annotations[ctrlcommon.RetainedImageMachineConfigsAnnotationKey] = strings.Join(machineConfigs, ",")
+}
+
+// Sets the image size annotation, removing it when the size is unknown.
+func (p *poolState) SetImageSize(size *imageSize) {
+	if size == nil {
+		delete(p.pool.Annotations, ImageSizeAnnotationKey)
+		return
+	}
+
+	if p.pool.Annotations == nil {
+		p.pool.Annotations = map[string]string{}
+	}
+
+	p.pool.Annotations[ImageSizeAnnotationKey] = strconv.FormatInt(size.Bytes, 10)
+}
+
+// Sets the build retry count annotation, removing it when the count is zero.
+func (p *poolState) SetBuildRetryCount(count int) {
+	if count == 0 {
+		delete(p.pool.Annotations, BuildRetryCountAnnotationKey)
+		return
+	}
+
+	if p.pool.Annotations == nil {
+		p.pool.Annotations = map[string]string{}
+	}
+
+	p.pool.Annotations[BuildRetryCountAnnotationKey] = strconv.Itoa(count)
+}
+
+// Sets the build queued annotation, removing it when the time is zero.
+func (p *poolState) SetBuildQueuedTime(queued time.Time) {
+	if queued.IsZero() {
+		delete(p.pool.Annotations, BuildQueuedAnnotationKey)
+		return
+	}
+
+	if p.pool.Annotations == nil {
+		p.pool.Annotations = map[string]string{}
+	}
+
+	p.pool.Annotations[BuildQueuedAnnotationKey] = queued.UTC().Format(time.RFC3339Nano)
+}
+
+// Sets the build log tail annotation, removing it when the log is empty.
+func (p *poolState) SetBuildLogTail(logTail string) {
+	if logTail == "" {
+		delete(p.pool.Annotations, BuildLogTailAnnotationKey)
+		return
+	}
+
+	if p.pool.Annotations == nil {
+		p.pool.Annotations = map[string]string{}
+	}
+
+	p.pool.Annotations[BuildLogTailAnnotationKey] = logTail
+}
+
+// Sets the build secret versions annotation, removing it when there are no
+// versions.
+func (p *poolState) SetBuildSecretVersions(versions map[string]string) {
+	if len(versions) == 0 {
+		delete(p.pool.Annotations, BuildSecretVersionsAnnotationKey)
+		return
+	}
+
+	if p.pool.Annotations == nil {
+		p.pool.Annotations = map[string]string{}
+	}
+
+	p.pool.Annotations[BuildSecretVersionsAnnotationKey] = encodeBuildSecretVersions(versions)
+}
+
+// Sets the build image tag annotation, removing it when there is no tag.
+func (p *poolState) SetBuildImageTag(tag string) {
+	if tag == "" {
+		delete(p.pool.Annotations, BuildImageTagAnnotationKey)
+		return
+	}
+
+	if p.pool.Annotations == nil {
+		p.pool.Annotations = map[string]string{}
+	}
+
+	p.pool.Annotations[BuildImageTagAnnotationKey] = tag
 }
 
 // Deletes a given build object reference by its name.
@@ -150,19 +283,42 @@ func (p *poolState) AddBuildObjectRef(objRef corev1.ObjectReference) error {
 // Clears all build object conditions.
 func (p *poolState) ClearAllBuildConditions() {
 	p.pool.Status.Conditions = clearAllBuildConditions(p.pool.Status.Conditions)
+	p.RemoveBuildCondition(MachineConfigPoolImageScanPassed)
+	p.RemoveBuildCondition(MachineConfigPoolImageLintPassed)
 }
 
-// Idempotently sets the supplied build conditions.
-func (p *poolState) SetBuildConditions(conditions []mcfgv1.MachineConfigPoolCondition) {
-	for _, condition := range conditions {
-		condition := condition
-		currentCondition := apihelpers.GetMachineConfigPoolCondition(p.pool.Status, condition.Type)
-		if currentCondition != nil && isConditionEqual(*currentCondition, condition) {
-			continue
-		}
+// Clears the BuildFailed condition, along with the RenderDegraded condition
+// which syncFailingStatus sets for it, and recomputes the Degraded condition
+// without them. Degraded nodes still degrade the pool, so the pool stays
+// degraded while the NodeDegraded condition is true.
+func (p *poolState) ClearBuildFailure() {
+	if !p.IsBuildFailure() {
+		return
+	}
+
+	p.RemoveBuildCondition(mcfgv1.MachineConfigPoolBuildFailed)
 
-		mcpCondition := apihelpers.NewMachineConfigPoolCondition(condition.Type, condition.Status, condition.Reason, condition.Message)
-		apihelpers.SetMachineConfigPoolCondition(&p.pool.Status, *mcpCondition)
+	renderDegraded := apihelpers.NewMachineConfigPoolCondition(mcfgv1.MachineConfigPoolRenderDegraded, corev1.ConditionFalse, "", "")
+	apihelpers.SetMachineConfigPoolCondition(&p.pool.Status, *renderDegraded)
+
+	if p.IsNodeDegraded() {
+		return
+	}
+
+	degraded := apihelpers.NewMachineConfigPoolCondition(mcfgv1.MachineConfigPoolDegraded, corev1.ConditionFalse, "", "")
+	apihelpers.SetMachineConfigPoolCondition(&p.pool.Status, *degraded)
+}
+
+// Removes the given condition, if present.
+func (p *poolState) RemoveBuildCondition(condType mcfgv1.MachineConfigPoolConditionType) {
+	conditions.MachineConfigPool.Remove(&p.pool.Status.Conditions, condType)
+}
+
+// Idempotently sets the supplied build conditions.
+func (p *poolState) SetBuildConditions(conds []mcfgv1.MachineConfigPoolCondition) {
+	for _, condition := range conds {
+		mcpCondition := conditions.MachineConfigPool.New(condition.Type, condition.Status, condition.Reason, condition.Message)
+		conditions.MachineConfigPool.Set(&p.pool.Status.Conditions, *mcpCondition)
 	}
 }
 
@@ -213,15 +369,6 @@ func (p *poolState) getFilteredObjectRefs(filterFunc func(objRef corev1.ObjectRe
 	return refs
 }
 
-// Determines if two conditions are equal. Note: I purposely do not include the
-// timestamp in the equality test, since we do not directly set it.
-func isConditionEqual(cond1, cond2 mcfgv1.MachineConfigPoolCondition) bool {
-	return cond1.Type == cond2.Type &&
-		cond1.Status == cond2.Status &&
-		cond1.Message == cond2.Message &&
-		cond1.Reason == cond2.Reason
-}
-
 func clearAllBuildConditions(inConditions []mcfgv1.MachineConfigPoolCondition) []mcfgv1.MachineConfigPoolCondition {
 	conditions := []mcfgv1.MachineConfigPoolCondition{}
 
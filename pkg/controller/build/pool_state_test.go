@@ -4,6 +4,8 @@ import (
 	"testing"
 
 	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	"github.com/openshift/machine-config-operator/pkg/apihelpers"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
 	"github.com/openshift/machine-config-operator/test/helpers"
 	"github.com/stretchr/testify/assert"
 	corev1 "k8s.io/api/core/v1"
@@ -31,6 +33,13 @@ func TestPoolState(t *testing.T) {
 	ps.SetImagePullspec("registry.host.com/org/repo:tag")
 	assert.True(t, ps.HasOSImage())
 	assert.Equal(t, "registry.host.com/org/repo:tag", ps.GetOSImage())
+
+	ps.SetAdditionalImagePullspecs([]string{"mirror.host.com/org/repo@sha256:abc"})
+	assert.Equal(t, "mirror.host.com/org/repo@sha256:abc", ps.MachineConfigPool().Annotations[ctrlcommon.AdditionalImagePullspecsAnnotationKey])
+
+	ps.ClearImagePullspec()
+	assert.False(t, ps.HasOSImage())
+	assert.NotContains(t, ps.MachineConfigPool().Annotations, ctrlcommon.AdditionalImagePullspecsAnnotationKey)
 }
 
 func TestPoolStateBuildRefs(t *testing.T) {
@@ -186,3 +195,68 @@ func TestPoolStateConditions(t *testing.T) {
 		}
 	}
 }
+
+func TestPoolStateClearBuildFailure(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name             string
+		conditions       []mcfgv1.MachineConfigPoolCondition
+		expectedDegraded corev1.ConditionStatus
+	}{
+		{
+			name: "Failed build was the only cause",
+			conditions: []mcfgv1.MachineConfigPoolCondition{
+				{Type: mcfgv1.MachineConfigPoolBuildFailed, Status: corev1.ConditionTrue},
+				{Type: mcfgv1.MachineConfigPoolDegraded, Status: corev1.ConditionTrue},
+			},
+			expectedDegraded: corev1.ConditionFalse,
+		},
+		{
+			name: "Nodes are still degraded",
+			conditions: []mcfgv1.MachineConfigPoolCondition{
+				{Type: mcfgv1.MachineConfigPoolBuildFailed, Status: corev1.ConditionTrue},
+				{Type: mcfgv1.MachineConfigPoolNodeDegraded, Status: corev1.ConditionTrue},
+				{Type: mcfgv1.MachineConfigPoolDegraded, Status: corev1.ConditionTrue},
+			},
+			expectedDegraded: corev1.ConditionTrue,
+		},
+		{
+			name: "Build failure reported as a render failure",
+			conditions: []mcfgv1.MachineConfigPoolCondition{
+				{Type: mcfgv1.MachineConfigPoolBuildFailed, Status: corev1.ConditionTrue},
+				{Type: mcfgv1.MachineConfigPoolRenderDegraded, Status: corev1.ConditionTrue},
+				{Type: mcfgv1.MachineConfigPoolDegraded, Status: corev1.ConditionTrue},
+			},
+			expectedDegraded: corev1.ConditionFalse,
+		},
+		{
+			name: "No failed build leaves Degraded alone",
+			conditions: []mcfgv1.MachineConfigPoolCondition{
+				{Type: mcfgv1.MachineConfigPoolBuildSuccess, Status: corev1.ConditionTrue},
+				{Type: mcfgv1.MachineConfigPoolDegraded, Status: corev1.ConditionTrue},
+			},
+			expectedDegraded: corev1.ConditionTrue,
+		},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			mcp := helpers.NewMachineConfigPoolBuilder("worker").WithMachineConfig("rendered-worker-1").MachineConfigPool()
+			mcp.Status.Conditions = testCase.conditions
+
+			ps := newPoolState(mcp)
+			ps.ClearBuildFailure()
+
+			assert.False(t, ps.IsBuildFailure())
+			assert.False(t, ps.IsRenderDegraded())
+
+			degraded := apihelpers.GetMachineConfigPoolCondition(ps.MachineConfigPool().Status, mcfgv1.MachineConfigPoolDegraded)
+			assert.NotNil(t, degraded)
+			assert.Equal(t, testCase.expectedDegraded, degraded.Status)
+		})
+	}
+}
@@ -0,0 +1,24 @@
+package build
+
+import (
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// The on-cluster-build-config ConfigMap key which contains a shell command
+	// that is run in a container from the built image before it is pushed,
+	// e.g., "bootc container lint". The build fails and the image is not rolled
+	// out if the command exits non-zero.
+	PostBuildTestCommandConfigKey = "postBuildTestCommand"
+)
+
+// Gets the post-build test command, if one is configured.
+func getPostBuildTestCommand(cm *corev1.ConfigMap) string {
+	if cm == nil {
+		return ""
+	}
+
+	return strings.TrimSpace(cm.Data[PostBuildTestCommandConfigKey])
+}
@@ -0,0 +1,465 @@
+package build
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/endpoints"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ecr"
+	"github.com/containers/image/v5/docker/reference"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// The on-cluster-build-config ConfigMap key which selects a cloud provider
+	// used to mint short-lived credentials for pushing the final OS image. When
+	// set, the Secret named by FinalImagePushSecretNameConfigKey is generated
+	// and refreshed by the build controller before each build.
+	FinalImagePushCredentialsProviderConfigKey = "finalImagePushCredentialsProvider"
+
+	// The on-cluster-build-config ConfigMap key which contains the name of a
+	// Secret in the MCO namespace holding the cloud credentials used by the
+	// push credentials provider (e.g., one minted by the cloud-credential-operator
+	// from a CredentialsRequest).
+	FinalImagePushCloudCredentialsSecretNameConfigKey = "finalImagePushCloudCredentialsSecretName"
+
+	// Mints ECR authorization tokens using AWS access keys or, with AWS STS, a
+	// role assumed with a web identity token.
+	AWSECRPushCredentialsProvider = "aws-ecr"
+
+	// Mints OAuth2 access tokens for Artifact Registry and Container Registry
+	// using a GCP service account key or workload identity federation.
+	GCPArtifactRegistryPushCredentialsProvider = "gcp-artifact-registry"
+
+	// Annotation placed on the generated push Secret so that the build
+	// controller never overwrites a Secret it does not own.
+	pushCredentialsProviderAnnotationKey = "machineconfiguration.openshift.io/push-credentials-provider"
+
+	// Annotation placed on the generated push Secret recording when the
+	// credentials expire.
+	pushCredentialsExpiryAnnotationKey = "machineconfiguration.openshift.io/push-credentials-expiry"
+)
+
+// Cloud credential Secret keys, matching the ones written by the
+// cloud-credential-operator.
+const (
+	awsAccessKeyIDSecretKey     = "aws_access_key_id"
+	awsSecretAccessKeySecretKey = "aws_secret_access_key"
+	awsSessionTokenSecretKey    = "aws_session_token"
+	// With AWS STS, the Secret contains a credentials file with a role_arn
+	// and a web_identity_token_file in its default profile.
+	awsCredentialsSecretKey          = "credentials"
+	awsRoleARNSecretKey              = "role_arn"
+	awsWebIdentityTokenFileSecretKey = "web_identity_token_file"
+	gcpServiceAccountSecretKey       = "service_account.json"
+)
+
+const (
+	// The session name used when assuming an AWS role.
+	pushCredentialsSessionName = "machine-os-builder"
+
+	gcpCloudPlatformScope = "https://www.googleapis.com/auth/cloud-platform"
+
+	// How long the generated push credentials must still be valid for when a
+	// build object is created. GCP access tokens are only valid for an hour.
+	minPushCredentialsLifetime = 30 * time.Minute
+)
+
+var ecrRegistryRegex = regexp.MustCompile(`^[0-9]{12}\.dkr\.ecr(-fips)?\.([a-z0-9-]+)\.amazonaws\.com(\.cn)?$`)
+
+// Mints short-lived registry credentials from cloud credentials.
+type pushCredentialsProvider interface {
+	GetCredentials(ctx context.Context, registry string, cloudCreds *corev1.Secret) (*registryCredentials, error)
+}
+
+// Short-lived credentials for a container registry.
+type registryCredentials struct {
+	Username  string
+	Password  string
+	ExpiresAt time.Time
+}
+
+// All of the push credentials providers the build controller knows about.
+func getPushCredentialsProviders() map[string]pushCredentialsProvider {
+	return map[string]pushCredentialsProvider{
+		AWSECRPushCredentialsProvider:              ecrCredentialsProvider{},
+		GCPArtifactRegistryPushCredentialsProvider: gcpCredentialsProvider{},
+	}
+}
+
+// Validates the push credentials provider configuration, returning the name
+// of the provider or an empty string if none is configured.
+func getPushCredentialsProviderName(cm *corev1.ConfigMap) (string, error) {
+	name := cm.Data[FinalImagePushCredentialsProviderConfigKey]
+	if name == "" {
+		return "", nil
+	}
+
+	if _, ok := getPushCredentialsProviders()[name]; !ok {
+		return "", fmt.Errorf("invalid %s %q, valid values are %q and %q", FinalImagePushCredentialsProviderConfigKey, name, AWSECRPushCredentialsProvider, GCPArtifactRegistryPushCredentialsProvider)
+	}
+
+	if cm.Data[FinalImagePushCloudCredentialsSecretNameConfigKey] == "" {
+		return "", fmt.Errorf("%s must be set when %s is set", FinalImagePushCloudCredentialsSecretNameConfigKey, FinalImagePushCredentialsProviderConfigKey)
+	}
+
+	if cm.Data[FinalImagePushSecretNameConfigKey] == cm.Data[FinalImagePushCloudCredentialsSecretNameConfigKey] {
+		return "", fmt.Errorf("%s and %s must name different Secrets", FinalImagePushSecretNameConfigKey, FinalImagePushCloudCredentialsSecretNameConfigKey)
+	}
+
+	return name, nil
+}
+
+// Generates or refreshes the final image push secret using the configured
+// push credentials provider, if any. This is done before each build since the
+// credentials are only valid for a short time.
+func (ctrl *Controller) refreshPushCredentials() error {
+	cm, err := ctrl.configMapLister.ConfigMaps(ctrlcommon.MCONamespace).Get(OnClusterBuildConfigMapName)
+	if err != nil {
+		return fmt.Errorf("could not get build controller config %q: %w", OnClusterBuildConfigMapName, err)
+	}
+
+	providerName, err := getPushCredentialsProviderName(cm)
+	if err != nil {
+		return err
+	}
+
+	if providerName == "" {
+		return nil
+	}
+
+	provider := ctrl.pushCredentialsProviders[providerName]
+	if provider == nil {
+		return fmt.Errorf("push credentials provider %q is not available", providerName)
+	}
+
+	named, err := reference.ParseNamed(cm.Data[FinalImagePullspecConfigKey])
+	if err != nil {
+		return fmt.Errorf("could not parse %s with %q: %w", FinalImagePullspecConfigKey, cm.Data[FinalImagePullspecConfigKey], err)
+	}
+
+	registry := reference.Domain(named)
+
+	cloudCredsName := cm.Data[FinalImagePushCloudCredentialsSecretNameConfigKey]
+	cloudCreds, err := ctrl.kubeclient.CoreV1().Secrets(ctrlcommon.MCONamespace).Get(context.TODO(), cloudCredsName, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("could not get cloud credentials secret %s: %w", cloudCredsName, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.TODO(), time.Minute)
+	defer cancel()
+
+	creds, err := provider.GetCredentials(ctx, registry, cloudCreds)
+	if err != nil {
+		return fmt.Errorf("could not get %s credentials for registry %s: %w", providerName, registry, err)
+	}
+
+	secret, err := newPushCredentialsSecret(cm.Data[FinalImagePushSecretNameConfigKey], providerName, registry, creds)
+	if err != nil {
+		return err
+	}
+
+	return ctrl.applyPushCredentialsSecret(secret)
+}
+
+// Mints the generated push credentials again if they expire within
+// minPushCredentialsLifetime. They are minted once the build is admitted, but
+// validating the build inputs may take long enough for them to be close to
+// expiring by the time the build object is created.
+func (ctrl *Controller) ensurePushCredentialsLifetime() error {
+	cm, err := ctrl.configMapLister.ConfigMaps(ctrlcommon.MCONamespace).Get(OnClusterBuildConfigMapName)
+	if err != nil {
+		return fmt.Errorf("could not get build controller config %q: %w", OnClusterBuildConfigMapName, err)
+	}
+
+	providerName, err := getPushCredentialsProviderName(cm)
+	if err != nil {
+		return err
+	}
+
+	if providerName == "" {
+		return nil
+	}
+
+	name := cm.Data[FinalImagePushSecretNameConfigKey]
+
+	secret, err := ctrl.kubeclient.CoreV1().Secrets(ctrlcommon.MCONamespace).Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil && !k8serrors.IsNotFound(err) {
+		return fmt.Errorf("could not get push secret %s: %w", name, err)
+	}
+
+	if err == nil {
+		expiry, err := time.Parse(time.RFC3339, secret.Annotations[pushCredentialsExpiryAnnotationKey])
+		if err == nil && time.Until(expiry) >= minPushCredentialsLifetime {
+			return nil
+		}
+	}
+
+	klog.Infof("Push secret %s expires within %s, refreshing", name, minPushCredentialsLifetime)
+	return ctrl.refreshPushCredentials()
+}
+
+// Creates or updates the generated push secret, refusing to overwrite a
+// Secret which was not generated by the build controller.
+func (ctrl *Controller) applyPushCredentialsSecret(secret *corev1.Secret) error {
+	secretsClient := ctrl.kubeclient.CoreV1().Secrets(ctrlcommon.MCONamespace)
+
+	existing, err := secretsClient.Get(context.TODO(), secret.Name, metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		if _, err := secretsClient.Create(context.TODO(), secret, metav1.CreateOptions{}); err != nil {
+			return fmt.Errorf("could not create push secret %s: %w", secret.Name, err)
+		}
+
+		klog.Infof("Created push secret %s, expires at %s", secret.Name, secret.Annotations[pushCredentialsExpiryAnnotationKey])
+		return nil
+	}
+
+	if err != nil {
+		return fmt.Errorf("could not get push secret %s: %w", secret.Name, err)
+	}
+
+	if _, ok := existing.Annotations[pushCredentialsProviderAnnotationKey]; !ok {
+		return fmt.Errorf("push secret %s already exists and was not generated by the build controller", secret.Name)
+	}
+
+	existing = existing.DeepCopy()
+	existing.Annotations = secret.Annotations
+	existing.Data = secret.Data
+	existing.Type = secret.Type
+
+	if _, err := secretsClient.Update(context.TODO(), existing, metav1.UpdateOptions{}); err != nil {
+		return fmt.Errorf("could not update push secret %s: %w", secret.Name, err)
+	}
+
+	klog.Infof("Refreshed push secret %s, expires at %s", secret.Name, secret.Annotations[pushCredentialsExpiryAnnotationKey])
+	return nil
+}
+
+// Builds a dockerconfigjson Secret for the given registry credentials.
+func newPushCredentialsSecret(name, providerName, registry string, creds *registryCredentials) (*corev1.Secret, error) {
+	auth := base64.StdEncoding.EncodeToString([]byte(creds.Username + ":" + creds.Password))
+
+	out, err := json.Marshal(map[string]interface{}{
+		"auths": map[string]interface{}{
+			registry: map[string]string{
+				"auth": auth,
+			},
+		},
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: ctrlcommon.MCONamespace,
+			Annotations: map[string]string{
+				pushCredentialsProviderAnnotationKey: providerName,
+				pushCredentialsExpiryAnnotationKey:   creds.ExpiresAt.UTC().Format(time.RFC3339),
+			},
+		},
+		Data: map[string][]byte{
+			corev1.DockerConfigJsonKey: out,
+		},
+		Type: corev1.SecretTypeDockerConfigJson,
+	}, nil
+}
+
+// Gets ECR authorization tokens by calling the GetAuthorizationToken API with
+// either AWS access keys or a role assumed with a web identity token.
+type ecrCredentialsProvider struct {
+	// Overrides the ECR and STS API endpoints; only used for testing.
+	endpoint string
+	client   *http.Client
+}
+
+func (e ecrCredentialsProvider) GetCredentials(ctx context.Context, registry string, cloudCreds *corev1.Secret) (*registryCredentials, error) {
+	matches := ecrRegistryRegex.FindStringSubmatch(registry)
+	if matches == nil {
+		return nil, fmt.Errorf("%q is not an ECR registry", registry)
+	}
+
+	cfg := aws.NewConfig().WithRegion(matches[2])
+	if matches[1] != "" {
+		cfg.UseFIPSEndpoint = endpoints.FIPSEndpointStateEnabled
+	}
+
+	if e.endpoint != "" {
+		cfg = cfg.WithEndpoint(e.endpoint)
+	}
+
+	if e.client != nil {
+		cfg = cfg.WithHTTPClient(e.client)
+	}
+
+	sess, err := session.NewSessionWithOptions(session.Options{
+		Config:            *cfg,
+		SharedConfigState: session.SharedConfigDisable,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not create AWS session: %w", err)
+	}
+
+	creds, err := getAWSCredentials(sess, cloudCreds)
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := ecr.New(sess, aws.NewConfig().WithCredentials(creds)).GetAuthorizationTokenWithContext(ctx, &ecr.GetAuthorizationTokenInput{})
+	if err != nil {
+		return nil, fmt.Errorf("could not get ECR authorization token: %w", err)
+	}
+
+	if len(out.AuthorizationData) == 0 {
+		return nil, fmt.Errorf("ECR response did not contain an authorization token")
+	}
+
+	token, err := base64.StdEncoding.DecodeString(aws.StringValue(out.AuthorizationData[0].AuthorizationToken))
+	if err != nil {
+		return nil, fmt.Errorf("could not decode ECR authorization token: %w", err)
+	}
+
+	username, password, ok := strings.Cut(string(token), ":")
+	if !ok {
+		return nil, fmt.Errorf("ECR authorization token is not in <username>:<password> form")
+	}
+
+	return &registryCredentials{
+		Username:  username,
+		Password:  password,
+		ExpiresAt: aws.TimeValue(out.AuthorizationData[0].ExpiresAt),
+	}, nil
+}
+
+// Gets the AWS credentials from the cloud credentials Secret. The
+// cloud-credential-operator writes either access keys or, when the cluster
+// uses AWS STS, a credentials file naming the role to assume with the
+// projected service account token.
+func getAWSCredentials(sess *session.Session, cloudCreds *corev1.Secret) (*credentials.Credentials, error) {
+	values := map[string]string{}
+	for key, val := range cloudCreds.Data {
+		values[key] = string(val)
+	}
+
+	if file, ok := cloudCreds.Data[awsCredentialsSecretKey]; ok {
+		for key, val := range parseAWSCredentialsFile(file) {
+			values[key] = val
+		}
+	}
+
+	if roleARN := values[awsRoleARNSecretKey]; roleARN != "" {
+		tokenFile := values[awsWebIdentityTokenFileSecretKey]
+		if tokenFile == "" {
+			return nil, fmt.Errorf("secret %s must contain %s when %s is set", cloudCreds.Name, awsWebIdentityTokenFileSecretKey, awsRoleARNSecretKey)
+		}
+
+		return stscreds.NewWebIdentityCredentials(sess, roleARN, pushCredentialsSessionName, tokenFile), nil
+	}
+
+	accessKeyID := values[awsAccessKeyIDSecretKey]
+	secretAccessKey := values[awsSecretAccessKeySecretKey]
+	if accessKeyID == "" || secretAccessKey == "" {
+		return nil, fmt.Errorf("secret %s must contain either %s and %s, or %s and %s", cloudCreds.Name, awsAccessKeyIDSecretKey, awsSecretAccessKeySecretKey, awsRoleARNSecretKey, awsWebIdentityTokenFileSecretKey)
+	}
+
+	return credentials.NewStaticCredentials(accessKeyID, secretAccessKey, values[awsSessionTokenSecretKey]), nil
+}
+
+// Gets the settings of the default profile of an AWS credentials file.
+func parseAWSCredentialsFile(file []byte) map[string]string {
+	out := map[string]string{}
+
+	profile := ""
+	for _, line := range strings.Split(string(file), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			profile = strings.TrimSpace(strings.Trim(line, "[]"))
+			continue
+		}
+
+		if profile != "default" {
+			continue
+		}
+
+		if key, val, ok := strings.Cut(line, "="); ok {
+			out[strings.TrimSpace(key)] = strings.TrimSpace(val)
+		}
+	}
+
+	return out
+}
+
+// Gets OAuth2 access tokens for Artifact Registry (and Container Registry)
+// using either a GCP service account key or workload identity federation
+// credentials.
+type gcpCredentialsProvider struct {
+	client *http.Client
+}
+
+func (g gcpCredentialsProvider) GetCredentials(ctx context.Context, registry string, cloudCreds *corev1.Secret) (*registryCredentials, error) {
+	if !strings.HasSuffix(registry, "-docker.pkg.dev") && registry != "gcr.io" && !strings.HasSuffix(registry, ".gcr.io") {
+		return nil, fmt.Errorf("%q is not an Artifact Registry or Container Registry registry", registry)
+	}
+
+	keyBytes, ok := cloudCreds.Data[gcpServiceAccountSecretKey]
+	if !ok {
+		return nil, fmt.Errorf("secret %s must contain %s", cloudCreds.Name, gcpServiceAccountSecretKey)
+	}
+
+	key := struct {
+		Type string `json:"type"`
+	}{}
+
+	if err := json.Unmarshal(keyBytes, &key); err != nil {
+		return nil, fmt.Errorf("could not parse %s from secret %s: %w", gcpServiceAccountSecretKey, cloudCreds.Name, err)
+	}
+
+	// The cloud-credential-operator writes external_account credentials when
+	// the cluster uses GCP workload identity federation.
+	if key.Type != "service_account" && key.Type != "external_account" {
+		return nil, fmt.Errorf("unsupported GCP credentials type %q in secret %s, only service_account and external_account credentials are supported", key.Type, cloudCreds.Name)
+	}
+
+	if g.client != nil {
+		ctx = context.WithValue(ctx, oauth2.HTTPClient, g.client)
+	}
+
+	creds, err := google.CredentialsFromJSON(ctx, keyBytes, gcpCloudPlatformScope)
+	if err != nil {
+		return nil, fmt.Errorf("could not load GCP credentials from secret %s: %w", cloudCreds.Name, err)
+	}
+
+	token, err := creds.TokenSource.Token()
+	if err != nil {
+		return nil, fmt.Errorf("could not get GCP access token: %w", err)
+	}
+
+	return &registryCredentials{
+		// This is the username Artifact Registry expects for access tokens.
+		Username:  "oauth2accesstoken",
+		Password:  token.AccessToken,
+		ExpiresAt: token.Expiry,
+	}, nil
+}
@@ -0,0 +1,447 @@
+package build
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	fakecorev1client "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+)
+
+type fakePushCredentialsProvider struct {
+	registry  string
+	password  string
+	expiresAt time.Time
+}
+
+func (f *fakePushCredentialsProvider) GetCredentials(_ context.Context, registry string, _ *corev1.Secret) (*registryCredentials, error) {
+	f.registry = registry
+
+	expiresAt := f.expiresAt
+	if expiresAt.IsZero() {
+		expiresAt = time.Unix(0, 0)
+	}
+
+	return &registryCredentials{Username: "user", Password: f.password, ExpiresAt: expiresAt}, nil
+}
+
+func TestGetPushCredentialsProviderName(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name             string
+		data             map[string]string
+		expectedProvider string
+		errExpected      bool
+	}{
+		{
+			name: "not configured",
+		},
+		{
+			name: "configured",
+			data: map[string]string{
+				FinalImagePushCredentialsProviderConfigKey:        AWSECRPushCredentialsProvider,
+				FinalImagePushCloudCredentialsSecretNameConfigKey: "cloud-creds",
+			},
+			expectedProvider: AWSECRPushCredentialsProvider,
+		},
+		{
+			name:        "unknown provider",
+			data:        map[string]string{FinalImagePushCredentialsProviderConfigKey: "azure"},
+			errExpected: true,
+		},
+		{
+			name:        "missing cloud credentials secret",
+			data:        map[string]string{FinalImagePushCredentialsProviderConfigKey: GCPArtifactRegistryPushCredentialsProvider},
+			errExpected: true,
+		},
+		{
+			name: "cloud credentials secret is the push secret",
+			data: map[string]string{
+				FinalImagePushCredentialsProviderConfigKey:        GCPArtifactRegistryPushCredentialsProvider,
+				FinalImagePushCloudCredentialsSecretNameConfigKey: "final-image-push-secret",
+			},
+			errExpected: true,
+		},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			cm := getOnClusterBuildConfigMap()
+			for k, v := range testCase.data {
+				cm.Data[k] = v
+			}
+
+			provider, err := getPushCredentialsProviderName(cm)
+			if testCase.errExpected {
+				assert.Error(t, err)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, testCase.expectedProvider, provider)
+		})
+	}
+}
+
+func TestRefreshPushCredentials(t *testing.T) {
+	t.Parallel()
+
+	newController := func(objects ...*corev1.Secret) (*Controller, *fakePushCredentialsProvider) {
+		cm := getOnClusterBuildConfigMap()
+		cm.Data[FinalImagePushCredentialsProviderConfigKey] = AWSECRPushCredentialsProvider
+		cm.Data[FinalImagePushCloudCredentialsSecretNameConfigKey] = "cloud-creds"
+
+		kubeclient := fakecorev1client.NewSimpleClientset(cm, &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "cloud-creds", Namespace: ctrlcommon.MCONamespace},
+		})
+
+		for _, obj := range objects {
+			require.NoError(t, kubeclient.Tracker().Add(obj))
+		}
+
+		provider := &fakePushCredentialsProvider{password: "first"}
+
+		return &Controller{
+			Clients:         &Clients{kubeclient: kubeclient},
+			eventRecorder:   record.NewFakeRecorder(10),
+			configMapLister: newConfigMapLister(t, cm),
+			pushCredentialsProviders: map[string]pushCredentialsProvider{
+				AWSECRPushCredentialsProvider: provider,
+			},
+		}, provider
+	}
+
+	getPushSecretAuth := func(t *testing.T, ctrl *Controller) string {
+		secret, err := ctrl.kubeclient.CoreV1().Secrets(ctrlcommon.MCONamespace).Get(context.TODO(), "final-image-push-secret", metav1.GetOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, corev1.SecretTypeDockerConfigJson, secret.Type)
+		assert.Equal(t, AWSECRPushCredentialsProvider, secret.Annotations[pushCredentialsProviderAnnotationKey])
+		return string(secret.Data[corev1.DockerConfigJsonKey])
+	}
+
+	t.Run("creates and refreshes the push secret", func(t *testing.T) {
+		t.Parallel()
+
+		ctrl, provider := newController()
+
+		require.NoError(t, ctrl.refreshPushCredentials())
+		assert.Equal(t, "registry.hostname.com", provider.registry)
+		assert.Equal(t, `{"auths":{"registry.hostname.com":{"auth":"dXNlcjpmaXJzdA=="}}}`, getPushSecretAuth(t, ctrl))
+
+		provider.password = "second"
+		require.NoError(t, ctrl.refreshPushCredentials())
+		assert.Equal(t, `{"auths":{"registry.hostname.com":{"auth":"dXNlcjpzZWNvbmQ="}}}`, getPushSecretAuth(t, ctrl))
+	})
+
+	t.Run("refreshes the push secret before it expires", func(t *testing.T) {
+		t.Parallel()
+
+		ctrl, provider := newController()
+		provider.expiresAt = time.Now().Add(time.Hour)
+
+		// A missing push secret is created.
+		require.NoError(t, ctrl.ensurePushCredentialsLifetime())
+		assert.Equal(t, `{"auths":{"registry.hostname.com":{"auth":"dXNlcjpmaXJzdA=="}}}`, getPushSecretAuth(t, ctrl))
+
+		// Credentials which remain valid long enough are kept.
+		provider.password = "second"
+		require.NoError(t, ctrl.ensurePushCredentialsLifetime())
+		assert.Equal(t, `{"auths":{"registry.hostname.com":{"auth":"dXNlcjpmaXJzdA=="}}}`, getPushSecretAuth(t, ctrl))
+
+		// Credentials which are about to expire, e.g. since the build was
+		// admitted a while ago, are minted again.
+		secret, err := ctrl.kubeclient.CoreV1().Secrets(ctrlcommon.MCONamespace).Get(context.TODO(), "final-image-push-secret", metav1.GetOptions{})
+		require.NoError(t, err)
+		secret.Annotations[pushCredentialsExpiryAnnotationKey] = time.Now().Add(5 * time.Minute).UTC().Format(time.RFC3339)
+		_, err = ctrl.kubeclient.CoreV1().Secrets(ctrlcommon.MCONamespace).Update(context.TODO(), secret, metav1.UpdateOptions{})
+		require.NoError(t, err)
+
+		require.NoError(t, ctrl.ensurePushCredentialsLifetime())
+		assert.Equal(t, `{"auths":{"registry.hostname.com":{"auth":"dXNlcjpzZWNvbmQ="}}}`, getPushSecretAuth(t, ctrl))
+	})
+
+	t.Run("does not overwrite a user-supplied push secret", func(t *testing.T) {
+		t.Parallel()
+
+		ctrl, _ := newController(&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "final-image-push-secret", Namespace: ctrlcommon.MCONamespace},
+			Type:       corev1.SecretTypeDockerConfigJson,
+		})
+
+		err := ctrl.refreshPushCredentials()
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "was not generated by the build controller")
+	})
+}
+
+func TestECRCredentialsProvider(t *testing.T) {
+	t.Parallel()
+
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	require.NoError(t, os.WriteFile(tokenFile, []byte("web-identity-token"), 0o600))
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// STS uses the query protocol while ECR uses JSON RPC.
+		if r.Header.Get("X-Amz-Target") == "" {
+			require.NoError(t, r.ParseForm())
+			assert.Equal(t, "AssumeRoleWithWebIdentity", r.Form.Get("Action"))
+			assert.Equal(t, "arn:aws:iam::123456789012:role/builder", r.Form.Get("RoleArn"))
+			assert.Equal(t, "web-identity-token", r.Form.Get("WebIdentityToken"))
+
+			fmt.Fprint(w, `<AssumeRoleWithWebIdentityResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <AssumeRoleWithWebIdentityResult>
+    <Credentials>
+      <AccessKeyId>ASIAROLE</AccessKeyId>
+      <SecretAccessKey>secret</SecretAccessKey>
+      <SessionToken>role-session</SessionToken>
+      <Expiration>2099-01-01T00:00:00Z</Expiration>
+    </Credentials>
+  </AssumeRoleWithWebIdentityResult>
+</AssumeRoleWithWebIdentityResponse>`)
+			return
+		}
+
+		assert.Equal(t, "AmazonEC2ContainerRegistry_V20150921.GetAuthorizationToken", r.Header.Get("X-Amz-Target"))
+
+		// Echo the credentials used to sign the request in the token so that
+		// they can be checked.
+		authz := r.Header.Get("Authorization")
+		assert.Contains(t, authz, "/us-east-2/ecr/aws4_request")
+		accessKeyID := strings.Split(strings.TrimPrefix(authz, "AWS4-HMAC-SHA256 Credential="), "/")[0]
+		token := fmt.Sprintf("%s:%s", accessKeyID, r.Header.Get("X-Amz-Security-Token"))
+
+		fmt.Fprintf(w, `{"authorizationData":[{"authorizationToken":%q,"expiresAt":1.6974E9}]}`, base64.StdEncoding.EncodeToString([]byte(token)))
+	}))
+	defer server.Close()
+
+	provider := ecrCredentialsProvider{
+		endpoint: server.URL,
+		client:   server.Client(),
+	}
+
+	testCases := []struct {
+		name             string
+		data             map[string][]byte
+		expectedUsername string
+		expectedPassword string
+		errExpected      bool
+	}{
+		{
+			name: "Access keys",
+			data: map[string][]byte{
+				awsAccessKeyIDSecretKey:     []byte("AKID"),
+				awsSecretAccessKeySecretKey: []byte("secret"),
+				awsSessionTokenSecretKey:    []byte("session"),
+			},
+			expectedUsername: "AKID",
+			expectedPassword: "session",
+		},
+		{
+			name: "STS credentials file",
+			data: map[string][]byte{
+				awsCredentialsSecretKey: []byte(fmt.Sprintf("[default]\nsts_regional_endpoints = regional\nrole_arn = arn:aws:iam::123456789012:role/builder\nweb_identity_token_file = %s\n", tokenFile)),
+			},
+			expectedUsername: "ASIAROLE",
+			expectedPassword: "role-session",
+		},
+		{
+			name: "Role without token file",
+			data: map[string][]byte{
+				awsRoleARNSecretKey: []byte("arn:aws:iam::123456789012:role/builder"),
+			},
+			errExpected: true,
+		},
+		{
+			name:        "No credentials",
+			data:        map[string][]byte{},
+			errExpected: true,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			cloudCreds := &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "cloud-creds"},
+				Data:       testCase.data,
+			}
+
+			creds, err := provider.GetCredentials(context.TODO(), "123456789012.dkr.ecr.us-east-2.amazonaws.com", cloudCreds)
+			if testCase.errExpected {
+				assert.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, testCase.expectedUsername, creds.Username)
+			assert.Equal(t, testCase.expectedPassword, creds.Password)
+			assert.True(t, creds.ExpiresAt.Equal(time.Unix(1697400000, 0)))
+
+			_, err = provider.GetCredentials(context.TODO(), "quay.io", cloudCreds)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestParseAWSCredentialsFile(t *testing.T) {
+	t.Parallel()
+
+	file := []byte(`# Written by the cloud-credential-operator
+[profile other]
+role_arn = arn:aws:iam::123456789012:role/other
+
+[default]
+role_arn = arn:aws:iam::123456789012:role/builder
+web_identity_token_file = /var/run/secrets/openshift/serviceaccount/token
+`)
+
+	assert.Equal(t, map[string]string{
+		"role_arn":                "arn:aws:iam::123456789012:role/builder",
+		"web_identity_token_file": "/var/run/secrets/openshift/serviceaccount/token",
+	}, parseAWSCredentialsFile(file))
+}
+
+func TestGCPCredentialsProvider(t *testing.T) {
+	t.Parallel()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	keyBytes, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	require.NoError(t, os.WriteFile(tokenFile, []byte("projected-token"), 0o600))
+
+	mux := http.NewServeMux()
+
+	// The OAuth2 token endpoint for service account keys.
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		assert.Equal(t, "urn:ietf:params:oauth:grant-type:jwt-bearer", r.Form.Get("grant_type"))
+
+		parts := strings.Split(r.Form.Get("assertion"), ".")
+		require.Len(t, parts, 3)
+
+		signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+		require.NoError(t, err)
+
+		sum := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+		assert.NoError(t, rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, sum[:], signature))
+
+		claims, err := base64.RawURLEncoding.DecodeString(parts[1])
+		require.NoError(t, err)
+		assert.Contains(t, string(claims), `"iss":"builder@project.iam.gserviceaccount.com"`)
+
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"access_token":"service-account-token","token_type":"Bearer","expires_in":3600}`)
+	})
+
+	// The STS token exchange endpoint for workload identity federation.
+	mux.HandleFunc("/sts", func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		assert.Equal(t, "urn:ietf:params:oauth:grant-type:token-exchange", r.Form.Get("grant_type"))
+		assert.Equal(t, "projected-token", r.Form.Get("subject_token"))
+
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"access_token":"federated-token","issued_token_type":"urn:ietf:params:oauth:token-type:access_token","token_type":"Bearer","expires_in":3600}`)
+	})
+
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	serviceAccount, err := json.Marshal(map[string]string{
+		"type":           "service_account",
+		"client_email":   "builder@project.iam.gserviceaccount.com",
+		"private_key_id": "1",
+		"private_key":    string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyBytes})),
+		"token_uri":      server.URL + "/token",
+	})
+	require.NoError(t, err)
+
+	externalAccount, err := json.Marshal(map[string]interface{}{
+		"type":               "external_account",
+		"audience":           "//iam.googleapis.com/projects/1/locations/global/workloadIdentityPools/pool/providers/openshift",
+		"subject_token_type": "urn:ietf:params:oauth:token-type:jwt",
+		"token_url":          server.URL + "/sts",
+		"credential_source": map[string]string{
+			"file": tokenFile,
+		},
+	})
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name             string
+		credentials      []byte
+		expectedPassword string
+		errExpected      bool
+	}{
+		{
+			name:             "Service account key",
+			credentials:      serviceAccount,
+			expectedPassword: "service-account-token",
+		},
+		{
+			name:             "Workload identity federation",
+			credentials:      externalAccount,
+			expectedPassword: "federated-token",
+		},
+		{
+			name:        "Unsupported credentials",
+			credentials: []byte(`{"type":"authorized_user"}`),
+			errExpected: true,
+		},
+	}
+
+	provider := gcpCredentialsProvider{
+		client: server.Client(),
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			cloudCreds := &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "cloud-creds"},
+				Data: map[string][]byte{
+					gcpServiceAccountSecretKey: testCase.credentials,
+				},
+			}
+
+			creds, err := provider.GetCredentials(context.TODO(), "us-central1-docker.pkg.dev", cloudCreds)
+			if testCase.errExpected {
+				assert.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, "oauth2accesstoken", creds.Username)
+			assert.Equal(t, testCase.expectedPassword, creds.Password)
+			assert.WithinDuration(t, time.Now().Add(time.Hour), creds.ExpiresAt, time.Minute)
+
+			_, err = provider.GetCredentials(context.TODO(), "quay.io", cloudCreds)
+			assert.Error(t, err)
+		})
+	}
+}
@@ -0,0 +1,77 @@
+package build
+
+import (
+	"context"
+	"fmt"
+
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// Annotation on the MachineConfigPool which requests a rebuild of the
+	// current rendered MachineConfig when set to a new value, e.g., the current
+	// time. This is useful to pick up a fixed base image without a config
+	// change.
+	RebuildAnnotationKey = "machineconfiguration.openshift.io/rebuild"
+
+	// Annotation on the MachineConfigPool which records the last value of the
+	// rebuild annotation that was acted upon.
+	LastRebuildAnnotationKey = "machineconfiguration.openshift.io/last-rebuild"
+)
+
+// Determines if a rebuild was requested for the given MachineConfigPool that
+// has not been acted upon yet.
+func isRebuildRequested(pool *mcfgv1.MachineConfigPool) bool {
+	requested := pool.Annotations[RebuildAnnotationKey]
+	return requested != "" && requested != pool.Annotations[LastRebuildAnnotationKey]
+}
+
+// Discards the current image and any in-progress or failed build for the
+// given MachineConfigPool so that a new build is started for its current
+// rendered MachineConfig.
+func (ctrl *Controller) rebuildMachineConfigPool(ps *poolState) error {
+	pool := ps.MachineConfigPool()
+	requested := pool.Annotations[RebuildAnnotationKey]
+
+	klog.Infof("Rebuild %q requested for MachineConfigPool %s, config %s", requested, ps.Name(), ps.CurrentMachineConfig())
+
+	if err := ctrl.postBuildCleanup(pool, true); err != nil {
+		return fmt.Errorf("could not clean up build for pool %s: %w", ps.Name(), err)
+	}
+
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		mcp, err := ctrl.mcfgclient.MachineconfigurationV1().MachineConfigPools().Get(context.TODO(), ps.Name(), metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+
+		ps := newPoolState(mcp)
+
+		ps.ClearBuildFailure()
+		ps.DeleteBuildRefForCurrentMachineConfig()
+		ps.ClearImagePullspec()
+		ps.ClearAllBuildConditions()
+		ps.SetBuildRetryCount(0)
+		ps.SetBuildLogTail("")
+
+		mcp = ps.MachineConfigPool()
+		mcp.Annotations[LastRebuildAnnotationKey] = requested
+
+		return ctrl.updatePoolAndSyncAvailableStatus(mcp)
+	})
+
+	if err != nil {
+		return fmt.Errorf("could not reset MachineConfigPool %s for rebuild: %w", ps.Name(), err)
+	}
+
+	ctrl.eventRecorder.Eventf(pool, corev1.EventTypeNormal, "RebuildRequested", "Rebuilding image for config %s", ps.CurrentMachineConfig())
+
+	// The next sync starts the build since the pool no longer has an image.
+	ctrl.enqueueMachineConfigPool(pool)
+
+	return nil
+}
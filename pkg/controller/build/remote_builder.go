@@ -0,0 +1,145 @@
+package build
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clientset "k8s.io/client-go/kubernetes"
+)
+
+const (
+	// The on-cluster-build-config ConfigMap key which contains the URL of the
+	// Podman API service which performs builds for the remote builder (e.g.,
+	// "tcp://builder.example.com:8888").
+	RemoteBuilderURLConfigKey = "remoteBuilderURL"
+
+	// The on-cluster-build-config ConfigMap key which contains the name of the
+	// Secret with the TLS client certificate and key (tls.crt and tls.key) and
+	// the CA certificate (ca.crt) used to connect to the remote builder.
+	RemoteBuilderTLSSecretNameConfigKey = "remoteBuilderTLSSecretName"
+
+	remoteBuilderTLSVolumeName = "remote-builder-tls"
+	remoteBuilderTLSMountpoint = "/tmp/remote-builder-tls"
+
+	podmanImagePullspec string = "quay.io/podman/stable:latest"
+)
+
+// The keys which the remote builder TLS Secret must contain.
+var remoteBuilderTLSSecretKeys = []string{"ca.crt", corev1.TLSCertKey, corev1.TLSPrivateKeyKey}
+
+// Describes the external Podman API service which builds and pushes the image
+// for the remote builder. The build pod only uploads the build context to it
+// and waits for the digest of the pushed image, so it does not need to be
+// privileged.
+type remoteBuilder struct {
+	URL       string
+	TLSSecret string
+}
+
+// Gets the remote builder from the on-cluster-build-config ConfigMap. Returns
+// nil if the remote builder is not the configured image builder.
+func getRemoteBuilder(cm *corev1.ConfigMap) (*remoteBuilder, error) {
+	if cm == nil || cm.Data[ImageBuilderTypeConfigMapKey] != RemoteImageBuilder {
+		return nil, nil
+	}
+
+	for _, key := range []string{RemoteBuilderURLConfigKey, RemoteBuilderTLSSecretNameConfigKey} {
+		if cm.Data[key] == "" {
+			return nil, fmt.Errorf("%s %q requires %s to be set", ImageBuilderTypeConfigMapKey, RemoteImageBuilder, key)
+		}
+	}
+
+	u, err := url.Parse(cm.Data[RemoteBuilderURLConfigKey])
+	if err != nil {
+		return nil, fmt.Errorf("could not parse %s %q: %w", RemoteBuilderURLConfigKey, cm.Data[RemoteBuilderURLConfigKey], err)
+	}
+
+	if u.Scheme != "tcp" || u.Host == "" {
+		return nil, fmt.Errorf("%s %q must be a tcp://<host>:<port> URL", RemoteBuilderURLConfigKey, cm.Data[RemoteBuilderURLConfigKey])
+	}
+
+	userVolumes, err := getUserBuildVolumes(cm)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(userVolumes) != 0 {
+		return nil, fmt.Errorf("additional build Secrets and ConfigMaps are not supported by %s %q", ImageBuilderTypeConfigMapKey, RemoteImageBuilder)
+	}
+
+	return &remoteBuilder{
+		URL:       cm.Data[RemoteBuilderURLConfigKey],
+		TLSSecret: cm.Data[RemoteBuilderTLSSecretNameConfigKey],
+	}, nil
+}
+
+// Validates the remote builder configuration from the on-cluster-build-config
+// ConfigMap, including that the TLS Secret has all of the required keys.
+func validateRemoteBuilderConfig(kubeclient clientset.Interface, cm *corev1.ConfigMap) error {
+	rb, err := getRemoteBuilder(cm)
+	if err != nil {
+		return err
+	}
+
+	if rb == nil {
+		return nil
+	}
+
+	secret, err := kubeclient.CoreV1().Secrets(ctrlcommon.MCONamespace).Get(context.TODO(), rb.TLSSecret, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("could not get remote builder TLS secret %s from %s: %w", rb.TLSSecret, OnClusterBuildConfigMapName, err)
+	}
+
+	for _, key := range remoteBuilderTLSSecretKeys {
+		if len(secret.Data[key]) == 0 {
+			return fmt.Errorf("remote builder TLS secret %s is missing key %q", rb.TLSSecret, key)
+		}
+	}
+
+	return nil
+}
+
+// Creates a build pod which hands the build off to the remote builder using
+// podman-remote and waits for it to push the final image.
+func (i ImageBuildRequest) toRemoteBuildPod() *corev1.Pod {
+	pod := i.toBuildahPod()
+
+	for idx := range pod.Spec.Containers {
+		container := &pod.Spec.Containers[idx]
+		if container.Name != "image-build" {
+			continue
+		}
+
+		container.Image = podmanImagePullspec
+		container.Command = []string{"/bin/bash", "-c", podmanRemoteBuildScript}
+		container.Env = append(container.Env,
+			corev1.EnvVar{
+				Name:  "REMOTE_BUILDER_URL",
+				Value: i.RemoteBuilder.URL,
+			},
+			corev1.EnvVar{
+				Name:  "REMOTE_BUILDER_TLS_DIR",
+				Value: remoteBuilderTLSMountpoint,
+			},
+		)
+		container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+			Name:      remoteBuilderTLSVolumeName,
+			MountPath: remoteBuilderTLSMountpoint,
+		})
+	}
+
+	pod.Spec.Volumes = append(pod.Spec.Volumes, corev1.Volume{
+		Name: remoteBuilderTLSVolumeName,
+		VolumeSource: corev1.VolumeSource{
+			Secret: &corev1.SecretVolumeSource{
+				SecretName: i.RemoteBuilder.TLSSecret,
+			},
+		},
+	})
+
+	return pod
+}
@@ -0,0 +1,167 @@
+package build
+
+import (
+	"testing"
+
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	fakecorev1client "k8s.io/client-go/kubernetes/fake"
+)
+
+func TestValidateRemoteBuilderConfig(t *testing.T) {
+	t.Parallel()
+
+	newSecret := func(keys ...string) *corev1.Secret {
+		secret := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "remote-builder-tls",
+				Namespace: ctrlcommon.MCONamespace,
+			},
+			Data: map[string][]byte{},
+		}
+
+		for _, key := range keys {
+			secret.Data[key] = []byte(key)
+		}
+
+		return secret
+	}
+
+	validSecret := newSecret("ca.crt", corev1.TLSCertKey, corev1.TLSPrivateKeyKey)
+
+	testCases := []struct {
+		name        string
+		data        map[string]string
+		secret      *corev1.Secret
+		errExpected bool
+	}{
+		{
+			name: "valid",
+			data: map[string]string{
+				RemoteBuilderURLConfigKey: "tcp://builder.example.com:8888",
+			},
+			secret: validSecret,
+		},
+		{
+			name: "missing URL",
+			data: map[string]string{
+				RemoteBuilderURLConfigKey: "",
+			},
+			secret:      validSecret,
+			errExpected: true,
+		},
+		{
+			name: "unsupported URL scheme",
+			data: map[string]string{
+				RemoteBuilderURLConfigKey: "ssh://core@builder.example.com/run/podman/podman.sock",
+			},
+			secret:      validSecret,
+			errExpected: true,
+		},
+		{
+			name: "missing secret",
+			data: map[string]string{
+				RemoteBuilderURLConfigKey: "tcp://builder.example.com:8888",
+			},
+			errExpected: true,
+		},
+		{
+			name: "missing client key",
+			data: map[string]string{
+				RemoteBuilderURLConfigKey: "tcp://builder.example.com:8888",
+			},
+			secret:      newSecret("ca.crt", corev1.TLSCertKey),
+			errExpected: true,
+		},
+		{
+			name: "unsupported build volumes",
+			data: map[string]string{
+				RemoteBuilderURLConfigKey: "tcp://builder.example.com:8888",
+				BuildSecretsConfigKey:     "repo-token:/run/secrets/repo-token",
+			},
+			secret:      validSecret,
+			errExpected: true,
+		},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			cm := getOnClusterBuildConfigMap()
+			cm.Data[ImageBuilderTypeConfigMapKey] = RemoteImageBuilder
+			cm.Data[RemoteBuilderTLSSecretNameConfigKey] = "remote-builder-tls"
+			for k, v := range testCase.data {
+				cm.Data[k] = v
+			}
+
+			kubeclient := fakecorev1client.NewSimpleClientset()
+			if testCase.secret != nil {
+				kubeclient = fakecorev1client.NewSimpleClientset(testCase.secret)
+			}
+
+			err := validateRemoteBuilderConfig(kubeclient, cm)
+			if testCase.errExpected {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+
+	// The remote builder is only used when it is the configured image builder.
+	rb, err := getRemoteBuilder(getOnClusterBuildConfigMap())
+	assert.NoError(t, err)
+	assert.Nil(t, rb)
+}
+
+// Tests that the remote build pod runs podman-remote against the remote
+// builder instead of building with Buildah.
+func TestImageBuildRequestWithRemoteBuilder(t *testing.T) {
+	t.Parallel()
+
+	onClusterBuildConfigMap := getOnClusterBuildConfigMap()
+	onClusterBuildConfigMap.Data[ImageBuilderTypeConfigMapKey] = RemoteImageBuilder
+	onClusterBuildConfigMap.Data[RemoteBuilderURLConfigKey] = "tcp://builder.example.com:8888"
+	onClusterBuildConfigMap.Data[RemoteBuilderTLSSecretNameConfigKey] = "remote-builder-tls"
+
+	rb, err := getRemoteBuilder(onClusterBuildConfigMap)
+	require.NoError(t, err)
+
+	ibr := newImageBuildRequestFromBuildInputs(&buildInputs{
+		pool:                 newMachineConfigPool("worker", "rendered-worker-1"),
+		osImageURL:           getOSImageURLConfigMap(),
+		onClusterBuildConfig: onClusterBuildConfigMap,
+		remoteBuilder:        rb,
+	})
+
+	pod := ibr.toBuildPod()
+
+	buildContainer := pod.Spec.Containers[0]
+	assert.Equal(t, "image-build", buildContainer.Name)
+	assert.Equal(t, podmanImagePullspec, buildContainer.Image)
+	assert.Equal(t, podmanRemoteBuildScript, buildContainer.Command[len(buildContainer.Command)-1])
+	assert.Contains(t, buildContainer.Env, corev1.EnvVar{Name: "REMOTE_BUILDER_URL", Value: "tcp://builder.example.com:8888"})
+	assert.Contains(t, buildContainer.VolumeMounts, corev1.VolumeMount{Name: remoteBuilderTLSVolumeName, MountPath: remoteBuilderTLSMountpoint})
+
+	// The wait-for-done container still creates the digest ConfigMap.
+	assert.Equal(t, "wait-for-done", pod.Spec.Containers[1].Name)
+	assert.NotContains(t, pod.Spec.Containers[1].VolumeMounts, corev1.VolumeMount{Name: remoteBuilderTLSVolumeName, MountPath: remoteBuilderTLSMountpoint})
+
+	assert.Contains(t, pod.Spec.Volumes, corev1.Volume{
+		Name: remoteBuilderTLSVolumeName,
+		VolumeSource: corev1.VolumeSource{
+			Secret: &corev1.SecretVolumeSource{
+				SecretName: "remote-builder-tls",
+			},
+		},
+	})
+
+	// Without a remote builder, Buildah builds the image in the pod.
+	ibr.RemoteBuilder = nil
+	assert.Equal(t, buildahImagePullspec, ibr.toBuildPod().Spec.Containers[0].Image)
+}
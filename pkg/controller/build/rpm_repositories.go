@@ -0,0 +1,142 @@
+package build
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	clientset "k8s.io/client-go/kubernetes"
+)
+
+const (
+	// The on-cluster-build-config ConfigMap key which contains the name of a
+	// ConfigMap in the MCO namespace whose .repo files are installed into
+	// /etc/yum.repos.d in the configs stage, so that custom Dockerfiles can
+	// install packages from them.
+	RPMRepositoriesConfigMapNameConfigKey = "rpmRepositoriesConfigMapName"
+
+	// The on-cluster-build-config ConfigMap key which contains the name of a
+	// Secret in the MCO namespace whose GPG keys are installed into
+	// /etc/pki/rpm-gpg in the configs stage.
+	RPMGPGKeysSecretNameConfigKey = "rpmGpgKeysSecretName"
+
+	rpmRepositoriesMountpoint = "/tmp/rpm-repositories"
+	rpmGPGKeysMountpoint      = "/tmp/rpm-gpg-keys"
+)
+
+// Where the RPM repositories and GPG keys referenced by the
+// on-cluster-build-config ConfigMap are mounted in the build, if they are
+// configured.
+type rpmRepositories struct {
+	ReposDir   string
+	GPGKeysDir string
+}
+
+// Gets the build volumes for the RPM repositories and GPG keys referenced by
+// the on-cluster-build-config ConfigMap.
+func getRPMRepositoryVolumes(cm *corev1.ConfigMap) ([]buildVolume, error) {
+	out := []buildVolume{}
+
+	if cm == nil {
+		return out, nil
+	}
+
+	volumes := []struct {
+		key string
+		buildVolume
+	}{
+		{
+			key:         RPMRepositoriesConfigMapNameConfigKey,
+			buildVolume: buildVolume{Mountpoint: rpmRepositoriesMountpoint},
+		},
+		{
+			key:         RPMGPGKeysSecretNameConfigKey,
+			buildVolume: buildVolume{Mountpoint: rpmGPGKeysMountpoint, IsSecret: true},
+		},
+	}
+
+	for _, volume := range volumes {
+		name := strings.TrimSpace(cm.Data[volume.key])
+		if name == "" {
+			continue
+		}
+
+		// The name is also used as the volume name in the build pod.
+		if errs := validation.IsDNS1123Label(name); len(errs) != 0 {
+			return nil, fmt.Errorf("invalid %s %q: %s", volume.key, name, strings.Join(errs, ", "))
+		}
+
+		volume.Name = name
+		out = append(out, volume.buildVolume)
+	}
+
+	return out, nil
+}
+
+// Gets where the RPM repositories and GPG keys are mounted in the build so
+// that the Dockerfile can install them.
+func getRPMRepositories(volumes []buildVolume) rpmRepositories {
+	out := rpmRepositories{}
+
+	for _, volume := range volumes {
+		switch volume.Mountpoint {
+		case rpmRepositoriesMountpoint:
+			out.ReposDir = volume.Mountpoint
+		case rpmGPGKeysMountpoint:
+			out.GPGKeysDir = volume.Mountpoint
+		}
+	}
+
+	return out
+}
+
+// Validates that the RPM repositories ConfigMap and GPG keys Secret
+// referenced by the on-cluster-build-config ConfigMap, if any, exist and only
+// contain files the build can install.
+func validateRPMRepositoriesConfig(kubeclient clientset.Interface, cm *corev1.ConfigMap) error {
+	volumes, err := getRPMRepositoryVolumes(cm)
+	if err != nil {
+		return err
+	}
+
+	for _, volume := range volumes {
+		if volume.IsSecret {
+			secret, err := kubeclient.CoreV1().Secrets(ctrlcommon.MCONamespace).Get(context.TODO(), volume.Name, metav1.GetOptions{})
+			if err != nil {
+				return fmt.Errorf("could not get %s %q: %w", RPMGPGKeysSecretNameConfigKey, volume.Name, err)
+			}
+
+			if len(secret.Data) == 0 {
+				return fmt.Errorf("%s %q contains no GPG keys", RPMGPGKeysSecretNameConfigKey, volume.Name)
+			}
+
+			continue
+		}
+
+		repos, err := kubeclient.CoreV1().ConfigMaps(ctrlcommon.MCONamespace).Get(context.TODO(), volume.Name, metav1.GetOptions{})
+		if err != nil {
+			return fmt.Errorf("could not get %s %q: %w", RPMRepositoriesConfigMapNameConfigKey, volume.Name, err)
+		}
+
+		if len(repos.Data) == 0 {
+			return fmt.Errorf("%s %q contains no .repo files", RPMRepositoriesConfigMapNameConfigKey, volume.Name)
+		}
+
+		// Only .repo files are installed, so anything else is likely a mistake.
+		for key := range repos.Data {
+			if !strings.HasSuffix(key, ".repo") {
+				return fmt.Errorf("%s %q contains %q, which is not a .repo file", RPMRepositoriesConfigMapNameConfigKey, volume.Name, key)
+			}
+		}
+
+		if len(repos.BinaryData) != 0 {
+			return fmt.Errorf("%s %q may not contain binary data", RPMRepositoriesConfigMapNameConfigKey, volume.Name)
+		}
+	}
+
+	return nil
+}
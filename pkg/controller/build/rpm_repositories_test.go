@@ -0,0 +1,168 @@
+package build
+
+import (
+	"strings"
+	"testing"
+
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	fakecorev1client "k8s.io/client-go/kubernetes/fake"
+)
+
+func TestValidateRPMRepositoriesConfig(t *testing.T) {
+	t.Parallel()
+
+	newRepos := func(data map[string]string) *corev1.ConfigMap {
+		return &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "rpm-repos",
+				Namespace: ctrlcommon.MCONamespace,
+			},
+			Data: data,
+		}
+	}
+
+	newKeys := func(data map[string][]byte) *corev1.Secret {
+		return &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "rpm-gpg-keys",
+				Namespace: ctrlcommon.MCONamespace,
+			},
+			Data: data,
+		}
+	}
+
+	validRepos := newRepos(map[string]string{"example.repo": "[example]\nbaseurl=https://example.com/repo\n"})
+	validKeys := newKeys(map[string][]byte{"RPM-GPG-KEY-example": []byte("key")})
+
+	testCases := []struct {
+		name        string
+		data        map[string]string
+		objects     []runtime.Object
+		errExpected bool
+	}{
+		{
+			name: "not configured",
+		},
+		{
+			name: "repositories and keys",
+			data: map[string]string{
+				RPMRepositoriesConfigMapNameConfigKey: "rpm-repos",
+				RPMGPGKeysSecretNameConfigKey:         "rpm-gpg-keys",
+			},
+			objects: []runtime.Object{validRepos, validKeys},
+		},
+		{
+			name:    "only repositories",
+			data:    map[string]string{RPMRepositoriesConfigMapNameConfigKey: "rpm-repos"},
+			objects: []runtime.Object{validRepos},
+		},
+		{
+			name:        "missing repositories",
+			data:        map[string]string{RPMRepositoriesConfigMapNameConfigKey: "rpm-repos"},
+			errExpected: true,
+		},
+		{
+			name:        "missing keys",
+			data:        map[string]string{RPMGPGKeysSecretNameConfigKey: "rpm-gpg-keys"},
+			errExpected: true,
+		},
+		{
+			name:        "invalid name",
+			data:        map[string]string{RPMRepositoriesConfigMapNameConfigKey: "RPM_Repos"},
+			errExpected: true,
+		},
+		{
+			name:        "not a repo file",
+			data:        map[string]string{RPMRepositoriesConfigMapNameConfigKey: "rpm-repos"},
+			objects:     []runtime.Object{newRepos(map[string]string{"example.conf": "[example]"})},
+			errExpected: true,
+		},
+		{
+			name:        "no repo files",
+			data:        map[string]string{RPMRepositoriesConfigMapNameConfigKey: "rpm-repos"},
+			objects:     []runtime.Object{newRepos(nil)},
+			errExpected: true,
+		},
+		{
+			name:        "no keys",
+			data:        map[string]string{RPMGPGKeysSecretNameConfigKey: "rpm-gpg-keys"},
+			objects:     []runtime.Object{newKeys(nil)},
+			errExpected: true,
+		},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			cm := getOnClusterBuildConfigMap()
+			for k, v := range testCase.data {
+				cm.Data[k] = v
+			}
+
+			err := validateRPMRepositoriesConfig(fakecorev1client.NewSimpleClientset(testCase.objects...), cm)
+			if testCase.errExpected {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+// Tests that the RPM repositories and GPG keys are mounted into the build and
+// installed in the configs stage, before the custom Dockerfile.
+func TestImageBuildRequestWithRPMRepositories(t *testing.T) {
+	t.Parallel()
+
+	customDockerfile := "FROM configs AS final\nRUN rpm-ostree install cowsay"
+
+	onClusterBuildConfig := getOnClusterBuildConfigMap()
+	onClusterBuildConfig.Data[RPMRepositoriesConfigMapNameConfigKey] = "rpm-repos"
+	onClusterBuildConfig.Data[RPMGPGKeysSecretNameConfigKey] = "rpm-gpg-keys"
+
+	buildVolumes, err := getUserBuildVolumes(onClusterBuildConfig)
+	require.NoError(t, err)
+	assert.Contains(t, buildVolumes, buildVolume{Name: "rpm-repos", Mountpoint: rpmRepositoriesMountpoint})
+	assert.Contains(t, buildVolumes, buildVolume{Name: "rpm-gpg-keys", Mountpoint: rpmGPGKeysMountpoint, IsSecret: true})
+
+	ibr := newImageBuildRequestFromBuildInputs(&buildInputs{
+		pool:                 newMachineConfigPool("worker", "rendered-worker-1"),
+		osImageURL:           getOSImageURLConfigMap(),
+		onClusterBuildConfig: onClusterBuildConfig,
+		customDockerfiles:    getCustomDockerfileConfigMap(map[string]string{"worker": customDockerfile}),
+		buildVolumes:         buildVolumes,
+	})
+
+	dockerfile, err := ibr.renderDockerfile()
+	require.NoError(t, err)
+
+	assert.Contains(t, dockerfile, "cp /tmp/rpm-repositories/*.repo /etc/yum.repos.d/")
+	assert.Contains(t, dockerfile, "cp /tmp/rpm-gpg-keys/* /etc/pki/rpm-gpg/")
+	assert.Less(t, strings.Index(dockerfile, "AS configs"), strings.Index(dockerfile, "/etc/yum.repos.d/"))
+	assert.Less(t, strings.Index(dockerfile, "/etc/yum.repos.d/"), strings.Index(dockerfile, customDockerfile))
+
+	// Without the GPG keys, only the repositories are installed.
+	ibr.RPMRepositories = getRPMRepositories(buildVolumes[:1])
+	dockerfile, err = ibr.renderDockerfile()
+	require.NoError(t, err)
+	assert.Contains(t, dockerfile, "cp /tmp/rpm-repositories/*.repo /etc/yum.repos.d/")
+	assert.NotContains(t, dockerfile, "/etc/pki/rpm-gpg")
+
+	// Without either, nothing is installed.
+	ibr.RPMRepositories = rpmRepositories{}
+	dockerfile, err = ibr.renderDockerfile()
+	require.NoError(t, err)
+	assert.NotContains(t, dockerfile, "/etc/yum.repos.d/")
+
+	// A user volume may not reuse the RPM repositories mountpoint.
+	onClusterBuildConfig.Data[BuildConfigMapsConfigKey] = "my-repos:" + rpmRepositoriesMountpoint
+	_, err = getUserBuildVolumes(onClusterBuildConfig)
+	assert.Error(t, err)
+}
@@ -0,0 +1,159 @@
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// Name of the ConfigMap which contains knobs for configuring the build controller.
+	OnClusterBuildConfigMapName = "on-cluster-build-config"
+
+	// The on-cluster-build-config ConfigMap key which contains the name of a
+	// Secret in the MCO namespace holding a cosign key pair. When set, the
+	// final OS image is signed with a sigstore signature when it is pushed.
+	// The Secret uses the same keys as "cosign generate-key-pair k8s://...".
+	ImageSigningSecretNameConfigKey = "imageSigningSecretName"
+
+	// The key in the build status ConfigMap which contains the status.
+	BuildStatusConfigMapKey = "status"
+)
+
+// ImageBuildPhase describes the state of a single build.
+type ImageBuildPhase string
+
+const (
+	// The build object has been created but has not started running yet.
+	ImageBuildPhasePending ImageBuildPhase = "Pending"
+	// The build is running.
+	ImageBuildPhaseBuilding ImageBuildPhase = "Building"
+	// The build finished and its image is being verified, e.g., scanned,
+	// before it is rolled out.
+	ImageBuildPhaseVerifying ImageBuildPhase = "Verifying"
+	// The build succeeded, its image was pushed and passed its verification.
+	ImageBuildPhaseSucceeded ImageBuildPhase = "Succeeded"
+	// The build failed or could not be started.
+	ImageBuildPhaseFailed ImageBuildPhase = "Failed"
+)
+
+// ImageBuildStatus is the status of a single build of a MachineConfigPool,
+// which the build controller keeps in its own ConfigMap for as long as the
+// build is in the build history of the pool.
+type ImageBuildStatus struct {
+	// The name of the build.
+	Name string `json:"name"`
+	// The name of the MachineConfigPool.
+	Pool string `json:"pool"`
+	// The rendered MachineConfig being built.
+	MachineConfig string `json:"machineConfig"`
+	// The phase of the build.
+	Phase ImageBuildPhase `json:"phase"`
+	// The image builder which performs the build.
+	BuilderType string `json:"builderType,omitempty"`
+	// How many times the build of the rendered MachineConfig was retried
+	// before this attempt.
+	Retries int `json:"retries,omitempty"`
+	// The resource versions of the Secrets the build consumes, keyed by name.
+	SecretVersions map[string]string `json:"secretVersions,omitempty"`
+	// The build object whose log is the build log, while it exists.
+	LogRef *corev1.ObjectReference `json:"logRef,omitempty"`
+	// When the build was started.
+	StartTime *metav1.Time `json:"startTime,omitempty"`
+	// When the build succeeded or failed.
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+	// The digested pullspec of the built image, once the build has succeeded.
+	Image string `json:"image,omitempty"`
+	// Why the build failed, if it did.
+	Message string `json:"message,omitempty"`
+	// The scan of the built image, if image scanning is configured.
+	Scan *ImageScanStatus `json:"scan,omitempty"`
+	// How the built image is signed, if image signing was configured when the
+	// build started.
+	Signature *ImageSignatureStatus `json:"signature,omitempty"`
+}
+
+// ImageSignatureStatus is how the image of a build is signed. It is captured
+// when the build starts, so that changing the image signing configuration
+// while the build runs does not change what its image is verified with.
+type ImageSignatureStatus struct {
+	// The Secret holding the cosign key pair the image is signed with.
+	SecretName string `json:"secretName"`
+	// The PEM-encoded public key the signature is verified with.
+	PublicKey string `json:"publicKey"`
+	// The reference of the sigstore signature attached to the image, once the
+	// build has finished.
+	Reference string `json:"reference,omitempty"`
+}
+
+// ImageScanPhase describes the state of the scan of a built image.
+type ImageScanPhase string
+
+const (
+	// The image is being scanned.
+	ImageScanPhasePending ImageScanPhase = "Pending"
+	// The scanner reported its findings.
+	ImageScanPhaseCompleted ImageScanPhase = "Completed"
+	// The image could not be scanned. The scan is retried.
+	ImageScanPhaseFailed ImageScanPhase = "Failed"
+)
+
+// ImageScanStatus is the state of the scan of a built image. It is kept in the
+// build status so that a scan which is running or finished is not started
+// again when the build controller syncs the pool or restarts.
+type ImageScanStatus struct {
+	// The digested pullspec of the scanned image.
+	Image string `json:"image"`
+	// The phase of the scan.
+	Phase ImageScanPhase `json:"phase"`
+	// When the scan was started.
+	StartTime *metav1.Time `json:"startTime,omitempty"`
+	// When the scan completed or failed.
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+	// The findings reported by the scanner, once the scan has completed.
+	Vulnerabilities []ImageScanVulnerability `json:"vulnerabilities,omitempty"`
+	// Why the image could not be scanned, if it could not.
+	Message string `json:"message,omitempty"`
+}
+
+// ImageScanVulnerability is a single finding reported by the scanner.
+type ImageScanVulnerability struct {
+	ID       string `json:"id"`
+	Severity string `json:"severity"`
+}
+
+// Gets the name of the ConfigMap holding the status of the build for the
+// current rendered MachineConfig of the given pool.
+func GetBuildStatusConfigMapName(pool *mcfgv1.MachineConfigPool) string {
+	return fmt.Sprintf("build-status-%s", pool.Spec.Configuration.Name)
+}
+
+// Parses the build status from a build status ConfigMap.
+func ParseImageBuildStatus(cm *corev1.ConfigMap) (*ImageBuildStatus, error) {
+	status := &ImageBuildStatus{}
+	if err := json.Unmarshal([]byte(cm.Data[BuildStatusConfigMapKey]), status); err != nil {
+		return nil, fmt.Errorf("could not parse build status from ConfigMap %s: %w", cm.Name, err)
+	}
+
+	return status, nil
+}
+
+// Determines whether the build controller signs the images it builds, given
+// its on-cluster-build-config ConfigMap.
+func IsImageSigningEnabled(onClusterBuildConfig *corev1.ConfigMap) bool {
+	return onClusterBuildConfig != nil && onClusterBuildConfig.Data[ImageSigningSecretNameConfigKey] != ""
+}
+
+// GetImageSigningPublicKey gets the public key the given image is verified
+// with from the status of the build which built it. Returns an empty string
+// if the image was not signed or was not built by that build.
+func GetImageSigningPublicKey(status *ImageBuildStatus, imagePullspec string) string {
+	if status == nil || status.Signature == nil || status.Image != imagePullspec {
+		return ""
+	}
+
+	return status.Signature.PublicKey
+}
@@ -0,0 +1,171 @@
+package common
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+
+	ign3types "github.com/coreos/ignition/v2/config/v3_4/types"
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	daemonconsts "github.com/openshift/machine-config-operator/pkg/daemon/constants"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// The system users of the node, whose SSH keys are never reconciled since
+// they are not meant to log in. Giving root SSH keys would also grant root
+// access to the node without a drain or reboot.
+var systemUsers = sets.NewString(
+	"root", "bin", "daemon", "adm", "lp", "sync", "shutdown", "halt", "mail",
+	"operator", "games", "ftp", "nobody",
+)
+
+// Returns a description of each change between the two rendered
+// MachineConfigs which requires the MCD to drain or reboot the node. An empty
+// list means the change can be applied live. Files are classified by
+// CalculatePostConfigChangeActions and IsDrainRequired, like the MCD does,
+// with the disruption policy of the pool.
+func GetDisruptiveConfigChanges(oldConfig, newConfig *mcfgv1.MachineConfig, policy *DisruptionPolicy) ([]string, error) {
+	oldIgn, err := ParseAndConvertConfig(oldConfig.Spec.Config.Raw)
+	if err != nil {
+		return nil, fmt.Errorf("parsing old Ignition config failed with error: %w", err)
+	}
+
+	newIgn, err := ParseAndConvertConfig(newConfig.Spec.Config.Raw)
+	if err != nil {
+		return nil, fmt.Errorf("parsing new Ignition config failed with error: %w", err)
+	}
+
+	changes := []string{}
+
+	if oldConfig.Spec.OSImageURL != newConfig.Spec.OSImageURL {
+		changes = append(changes, "OS image")
+	}
+
+	if (len(oldConfig.Spec.KernelArguments) != 0 || len(newConfig.Spec.KernelArguments) != 0) &&
+		!reflect.DeepEqual(oldConfig.Spec.KernelArguments, newConfig.Spec.KernelArguments) {
+		changes = append(changes, "kernel arguments")
+	}
+
+	if oldConfig.Spec.FIPS != newConfig.Spec.FIPS {
+		changes = append(changes, "FIPS")
+	}
+
+	if canonicalizeKernelType(oldConfig.Spec.KernelType) != canonicalizeKernelType(newConfig.Spec.KernelType) {
+		changes = append(changes, "kernel type")
+	}
+
+	if (len(oldConfig.Spec.Extensions) != 0 || len(newConfig.Spec.Extensions) != 0) &&
+		!reflect.DeepEqual(oldConfig.Spec.Extensions, newConfig.Spec.Extensions) {
+		changes = append(changes, "extensions")
+	}
+
+	if !reflect.DeepEqual(oldIgn.Systemd.Units, newIgn.Systemd.Units) {
+		changes = append(changes, "systemd units")
+	}
+
+	// The MCD updates the SSH keys of all users and the password of the core
+	// user in place, and refuses any other change to users or groups.
+	if !reflect.DeepEqual(oldIgn.Passwd.Groups, newIgn.Passwd.Groups) {
+		changes = append(changes, "groups")
+	}
+
+	if !reflect.DeepEqual(oldIgn.Passwd.Users, newIgn.Passwd.Users) {
+		for _, user := range newIgn.Passwd.Users {
+			if err := VerifyUserFields(user); err != nil {
+				changes = append(changes, "users")
+				break
+			}
+		}
+	}
+
+	if !reflect.DeepEqual(oldIgn.KernelArguments, newIgn.KernelArguments) {
+		changes = append(changes, "Ignition kernel arguments")
+	}
+
+	if !reflect.DeepEqual(oldIgn.Storage.Disks, newIgn.Storage.Disks) ||
+		!reflect.DeepEqual(oldIgn.Storage.Filesystems, newIgn.Storage.Filesystems) ||
+		!reflect.DeepEqual(oldIgn.Storage.Raid, newIgn.Storage.Raid) ||
+		!reflect.DeepEqual(oldIgn.Storage.Directories, newIgn.Storage.Directories) ||
+		!reflect.DeepEqual(oldIgn.Storage.Links, newIgn.Storage.Links) {
+		changes = append(changes, "storage")
+	}
+
+	diffFileSet := CalculateConfigFileDiffs(&oldIgn, &newIgn)
+	sort.Strings(diffFileSet)
+
+	for _, path := range diffFileSet {
+		drain, err := isDisruptiveFileChange(path, policy, oldIgn, newIgn)
+		if err != nil {
+			return nil, err
+		}
+
+		if drain {
+			changes = append(changes, fmt.Sprintf("file %s", path))
+		}
+	}
+
+	return changes, nil
+}
+
+// Determines whether the MCD drains or reboots the node to apply the change to
+// the file.
+func isDisruptiveFileChange(path string, policy *DisruptionPolicy, oldIgn, newIgn ign3types.Config) (bool, error) {
+	diffFileSet := []string{path}
+
+	actions, err := CheckContainerStorageConfChanges(CalculatePostConfigChangeActions(diffFileSet, policy), diffFileSet, oldIgn, newIgn)
+	if err != nil {
+		return false, fmt.Errorf("could not classify change to %s: %w", path, err)
+	}
+
+	drain, err := IsDrainRequired(actions, diffFileSet, oldIgn, newIgn)
+	if err != nil {
+		return false, fmt.Errorf("could not classify change to %s: %w", path, err)
+	}
+
+	return drain, nil
+}
+
+// VerifyUserFields returns nil for the user Name = "core" if 1 or more SSHKeys exist for
+// this user or if a password exists for this user and if all other fields in User are empty.
+// Other users may only have SSHKeys, which are written for them if they exist on the node,
+// unless they are root or another system user.
+// Otherwise, an error will be returned and the proposed config will not be reconcilable.
+// At this time we do not support any changes to users outside of SSHAuthorizedKeys, and
+// passwordHash for "core".
+func VerifyUserFields(pwdUser ign3types.PasswdUser) error {
+	emptyUser := ign3types.PasswdUser{}
+	tempUser := pwdUser
+	if tempUser.Name != daemonconsts.CoreUserName {
+		if tempUser.Name == "" {
+			return fmt.Errorf("ignition passwd user section contains unsupported changes: user without name")
+		}
+		if systemUsers.Has(tempUser.Name) {
+			return fmt.Errorf("ignition passwd user section contains unsupported changes: SSH keys are not reconcilable for system user %s", pwdUser.Name)
+		}
+		tempUser.Name = ""
+		tempUser.SSHAuthorizedKeys = nil
+		if !reflect.DeepEqual(emptyUser, tempUser) {
+			return fmt.Errorf("ignition passwd user section contains unsupported changes: only SSH keys are reconcilable for user %s", pwdUser.Name)
+		}
+		return nil
+	}
+	if (tempUser.PasswordHash) != nil || len(tempUser.SSHAuthorizedKeys) >= 1 {
+		tempUser.Name = ""
+		tempUser.SSHAuthorizedKeys = nil
+		tempUser.PasswordHash = nil
+		if !reflect.DeepEqual(emptyUser, tempUser) {
+			return fmt.Errorf("SSH keys and password hash are not reconcilable")
+		}
+	} else {
+		return fmt.Errorf("ignition passwd user section contains unsupported changes: user must be core and have 1 or more sshKeys")
+	}
+	return nil
+}
+
+func canonicalizeKernelType(kernelType string) string {
+	if kernelType == KernelTypeRealtime {
+		return KernelTypeRealtime
+	}
+
+	return KernelTypeDefault
+}
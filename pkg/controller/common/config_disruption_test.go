@@ -0,0 +1,201 @@
+package common
+
+import (
+	"testing"
+
+	ign3types "github.com/coreos/ignition/v2/config/v3_4/types"
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	daemonconsts "github.com/openshift/machine-config-operator/pkg/daemon/constants"
+	"github.com/openshift/machine-config-operator/test/helpers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetDisruptiveConfigChanges(t *testing.T) {
+	t.Parallel()
+
+	type configOpts struct {
+		files      []ign3types.File
+		units      []ign3types.Unit
+		sshkeys    []ign3types.SSHAuthorizedKey
+		extensions []string
+		kargs      []string
+		kernelType string
+		osurl      string
+		users      []ign3types.PasswdUser
+	}
+
+	newConfig := func(t *testing.T, opts configOpts) *mcfgv1.MachineConfig {
+		mc := helpers.NewMachineConfigExtended("rendered-worker", nil, nil, opts.files, opts.units, opts.sshkeys, opts.extensions, false, opts.kargs, opts.kernelType, opts.osurl)
+		if len(opts.users) == 0 {
+			return mc
+		}
+
+		ignCfg, err := ParseAndConvertConfig(mc.Spec.Config.Raw)
+		require.NoError(t, err)
+		ignCfg.Passwd.Users = append(ignCfg.Passwd.Users, opts.users...)
+		mc.Spec.Config.Raw = helpers.MarshalOrDie(ignCfg)
+		return mc
+	}
+
+	base := configOpts{
+		files:   []ign3types.File{NewIgnFile("/etc/kubernetes/kubelet-ca.crt", "ca")},
+		sshkeys: []ign3types.SSHAuthorizedKey{"key-1"},
+		osurl:   "registry.hostname.com/os:1",
+		users:   []ign3types.PasswdUser{{Name: "admin", SSHAuthorizedKeys: []ign3types.SSHAuthorizedKey{"admin-key-1"}}},
+	}
+
+	policy, err := ParseDisruptionPolicy(`{"files":[{"path":"/etc/agent.d/","actions":[{"type":"RunUnit","unit":"agent-reload.service"}]}]}`)
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name     string
+		mutate   func(*configOpts)
+		expected []string
+	}{
+		{
+			name:     "no changes",
+			mutate:   func(*configOpts) {},
+			expected: []string{},
+		},
+		{
+			name: "SSH keys",
+			mutate: func(o *configOpts) {
+				o.sshkeys = []ign3types.SSHAuthorizedKey{"key-1", "key-2"}
+			},
+			expected: []string{},
+		},
+		{
+			name: "SSH keys of another user",
+			mutate: func(o *configOpts) {
+				o.users = []ign3types.PasswdUser{{Name: "admin", SSHAuthorizedKeys: []ign3types.SSHAuthorizedKey{"admin-key-1", "admin-key-2"}}}
+			},
+			expected: []string{},
+		},
+		{
+			name: "SSH keys of root",
+			mutate: func(o *configOpts) {
+				o.users = append(o.users, ign3types.PasswdUser{Name: "root", SSHAuthorizedKeys: []ign3types.SSHAuthorizedKey{"root-key-1"}})
+			},
+			expected: []string{"users"},
+		},
+		{
+			name: "other changes to another user",
+			mutate: func(o *configOpts) {
+				shell := "/bin/zsh"
+				o.users = []ign3types.PasswdUser{{Name: "admin", Shell: &shell, SSHAuthorizedKeys: []ign3types.SSHAuthorizedKey{"admin-key-1"}}}
+			},
+			expected: []string{"users"},
+		},
+		{
+			name: "live-applyable files",
+			mutate: func(o *configOpts) {
+				o.files = []ign3types.File{
+					NewIgnFile("/etc/kubernetes/kubelet-ca.crt", "new-ca"),
+					NewIgnFile("/var/lib/kubelet/config.json", "{}"),
+					NewIgnFile("/etc/containers/policy.json", "{}"),
+					NewIgnFile("/etc/NetworkManager/conf.d/99-mco-dns.conf", "[global-dns]"),
+				}
+			},
+			expected: []string{},
+		},
+		{
+			name: "other files",
+			mutate: func(o *configOpts) {
+				o.files = []ign3types.File{
+					NewIgnFile("/etc/kubernetes/kubelet-ca.crt", "ca"),
+					NewIgnFile("/etc/containers/registries.conf", "[[registry]]\nlocation = \"quay.io\"\nblocked = true"),
+					NewIgnFile("/etc/sysctl.d/99-tuning.conf", "vm.swappiness = 10"),
+				}
+			},
+			expected: []string{"file /etc/containers/registries.conf", "file /etc/sysctl.d/99-tuning.conf"},
+		},
+		{
+			name: "live-applied service config and SSH keys",
+			mutate: func(o *configOpts) {
+				o.files = []ign3types.File{
+					NewIgnFile("/etc/kubernetes/kubelet-ca.crt", "ca"),
+					NewIgnFile(ChronyConfPath, "pool 2.rhel.pool.ntp.org iburst"),
+					NewIgnFile(KubeletLogLevelConfPath, "[Service]\nEnvironment=KUBELET_LOG_LEVEL=4"),
+					NewIgnFile("/home/admin/.ssh/authorized_keys.d/bastion", "ssh-ed25519 AAAA"),
+				}
+			},
+			expected: []string{},
+		},
+		{
+			name: "storage.conf",
+			mutate: func(o *configOpts) {
+				o.files = []ign3types.File{
+					NewIgnFile("/etc/kubernetes/kubelet-ca.crt", "ca"),
+					NewIgnFile("/etc/containers/storage.conf", "[storage]\ndriver = \"overlay\""),
+				}
+			},
+			// Adding storage.conf may change anything.
+			expected: []string{"file /etc/containers/storage.conf"},
+		},
+		{
+			name: "files covered by the disruption policy",
+			mutate: func(o *configOpts) {
+				o.files = []ign3types.File{
+					NewIgnFile("/etc/kubernetes/kubelet-ca.crt", "ca"),
+					NewIgnFile("/etc/agent.d/agent.conf", "level = debug"),
+				}
+			},
+			expected: []string{},
+		},
+		{
+			name: "OS image, kernel and extensions",
+			mutate: func(o *configOpts) {
+				o.osurl = "registry.hostname.com/os:2"
+				o.kargs = []string{"nosmt"}
+				o.kernelType = KernelTypeRealtime
+				o.extensions = []string{"usbguard"}
+			},
+			expected: []string{"OS image", "kernel arguments", "kernel type", "extensions"},
+		},
+		{
+			name: "default kernel type is equivalent to unset",
+			mutate: func(o *configOpts) {
+				o.kernelType = KernelTypeDefault
+			},
+			expected: []string{},
+		},
+		{
+			name: "systemd units",
+			mutate: func(o *configOpts) {
+				enabled := true
+				o.units = []ign3types.Unit{{Name: "foo.service", Enabled: &enabled}}
+			},
+			expected: []string{"systemd units"},
+		},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			newOpts := base
+			testCase.mutate(&newOpts)
+
+			changes, err := GetDisruptiveConfigChanges(newConfig(t, base), newConfig(t, newOpts), policy)
+			assert.NoError(t, err)
+			assert.Equal(t, testCase.expected, changes)
+		})
+	}
+}
+
+func TestVerifyUserFields(t *testing.T) {
+	t.Parallel()
+
+	keys := []ign3types.SSHAuthorizedKey{"key-1"}
+
+	assert.NoError(t, VerifyUserFields(ign3types.PasswdUser{Name: daemonconsts.CoreUserName, SSHAuthorizedKeys: keys}))
+	assert.NoError(t, VerifyUserFields(ign3types.PasswdUser{Name: "admin", SSHAuthorizedKeys: keys}))
+
+	// Root and the other system users must not be given SSH keys.
+	assert.Error(t, VerifyUserFields(ign3types.PasswdUser{Name: "root", SSHAuthorizedKeys: keys}))
+	assert.Error(t, VerifyUserFields(ign3types.PasswdUser{Name: "daemon", SSHAuthorizedKeys: keys}))
+
+	assert.Error(t, VerifyUserFields(ign3types.PasswdUser{SSHAuthorizedKeys: keys}))
+}
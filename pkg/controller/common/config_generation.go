@@ -0,0 +1,98 @@
+package common
+
+import (
+	"strconv"
+
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	daemonconsts "github.com/openshift/machine-config-operator/pkg/daemon/constants"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Every time a MachineConfigPool targets a different rendered MachineConfig,
+// its config generation is incremented. Nodes are stamped with the generation
+// they were asked to move to and the generation they are running, so that
+// stale nodes can be found by comparing two integers instead of rendered
+// MachineConfig names. A generation of 0 means that it is unknown (e.g., the
+// pool has not targeted a new rendered MachineConfig since this was
+// introduced).
+
+// Reads a config generation from the given annotations. Missing or invalid
+// values are treated as 0.
+func getConfigGeneration(annotations map[string]string, key string) int64 {
+	val, ok := annotations[key]
+	if !ok {
+		return 0
+	}
+
+	generation, err := strconv.ParseInt(val, 10, 64)
+	if err != nil || generation < 0 {
+		return 0
+	}
+
+	return generation
+}
+
+// Returns the config generation of the MachineConfigPool.
+func GetPoolConfigGeneration(pool *mcfgv1.MachineConfigPool) int64 {
+	return getConfigGeneration(pool.Annotations, ConfigGenerationAnnotationKey)
+}
+
+// Increments the config generation of the MachineConfigPool. This should be
+// called whenever the pool targets a different rendered MachineConfig.
+func IncrementPoolConfigGeneration(pool *mcfgv1.MachineConfigPool) int64 {
+	generation := GetPoolConfigGeneration(pool) + 1
+
+	if pool.Annotations == nil {
+		pool.Annotations = map[string]string{}
+	}
+
+	pool.Annotations[ConfigGenerationAnnotationKey] = strconv.FormatInt(generation, 10)
+	return generation
+}
+
+// Returns the config generation the node is currently running.
+func GetNodeCurrentConfigGeneration(node *corev1.Node) int64 {
+	return getConfigGeneration(node.Annotations, daemonconsts.CurrentConfigGenerationAnnotationKey)
+}
+
+// Returns the config generation the node is updating to.
+func GetNodeDesiredConfigGeneration(node *corev1.Node) int64 {
+	return getConfigGeneration(node.Annotations, daemonconsts.DesiredConfigGenerationAnnotationKey)
+}
+
+// Determines whether the node is running an older config generation than the
+// one targeted by its MachineConfigPool.
+func IsNodeConfigGenerationStale(node *corev1.Node, pool *mcfgv1.MachineConfigPool) bool {
+	return GetNodeCurrentConfigGeneration(node) < GetPoolConfigGeneration(pool)
+}
+
+// Returns the config generation annotations which need to be set on the node
+// so that they reflect the MachineConfigPool and the node's progress. The
+// desired generation follows the pool once the node targets the pool's
+// rendered MachineConfig and the current generation follows the desired
+// generation once the node has finished updating to it. Returns nil if
+// nothing needs to change.
+func GetConfigGenerationAnnotationUpdates(node *corev1.Node, pool *mcfgv1.MachineConfigPool) map[string]string {
+	poolGeneration := GetPoolConfigGeneration(pool)
+	if poolGeneration == 0 {
+		return nil
+	}
+
+	updates := map[string]string{}
+
+	desiredGeneration := GetNodeDesiredConfigGeneration(node)
+	if node.Annotations[daemonconsts.DesiredMachineConfigAnnotationKey] == pool.Spec.Configuration.Name && desiredGeneration != poolGeneration {
+		desiredGeneration = poolGeneration
+		updates[daemonconsts.DesiredConfigGenerationAnnotationKey] = strconv.FormatInt(desiredGeneration, 10)
+	}
+
+	if desiredGeneration != 0 && isNodeDone(node) && GetNodeCurrentConfigGeneration(node) != desiredGeneration {
+		updates[daemonconsts.CurrentConfigGenerationAnnotationKey] = strconv.FormatInt(desiredGeneration, 10)
+	}
+
+	if len(updates) == 0 {
+		return nil
+	}
+
+	return updates
+}
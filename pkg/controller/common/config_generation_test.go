@@ -0,0 +1,125 @@
+package common
+
+import (
+	"testing"
+
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	daemonconsts "github.com/openshift/machine-config-operator/pkg/daemon/constants"
+	"github.com/openshift/machine-config-operator/test/helpers"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func newConfigGenerationPool(config, generation string) *mcfgv1.MachineConfigPool {
+	pb := helpers.NewMachineConfigPoolBuilder("").WithMachineConfig(config)
+	if generation != "" {
+		pb.WithAnnotations(map[string]string{ConfigGenerationAnnotationKey: generation})
+	}
+	return pb.MachineConfigPool()
+}
+
+func newConfigGenerationNode(current, desired, state string, annos map[string]string) *corev1.Node {
+	return helpers.NewNodeBuilder("").WithConfigs(current, desired).WithMCDState(state).WithAnnotations(annos).Node()
+}
+
+func TestIncrementPoolConfigGeneration(t *testing.T) {
+	t.Parallel()
+
+	pool := newConfigGenerationPool(machineConfigV0, "")
+	pool.Annotations = nil
+	assert.Equal(t, int64(0), GetPoolConfigGeneration(pool))
+
+	assert.Equal(t, int64(1), IncrementPoolConfigGeneration(pool))
+	assert.Equal(t, int64(2), IncrementPoolConfigGeneration(pool))
+	assert.Equal(t, "2", pool.Annotations[ConfigGenerationAnnotationKey])
+
+	pool.Annotations[ConfigGenerationAnnotationKey] = "not-a-number"
+	assert.Equal(t, int64(0), GetPoolConfigGeneration(pool))
+}
+
+func TestGetConfigGenerationAnnotationUpdates(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		node     *corev1.Node
+		pool     *mcfgv1.MachineConfigPool
+		expected map[string]string
+		stale    bool
+	}{
+		{
+			name: "Pool without config generation",
+			node: newConfigGenerationNode(machineConfigV0, machineConfigV0, daemonconsts.MachineConfigDaemonStateDone, nil),
+			pool: newConfigGenerationPool(machineConfigV0, ""),
+		},
+		{
+			name: "Updated node without config generation",
+			node: newConfigGenerationNode(machineConfigV0, machineConfigV0, daemonconsts.MachineConfigDaemonStateDone, nil),
+			pool: newConfigGenerationPool(machineConfigV0, "3"),
+			expected: map[string]string{
+				daemonconsts.DesiredConfigGenerationAnnotationKey: "3",
+				daemonconsts.CurrentConfigGenerationAnnotationKey: "3",
+			},
+			stale: true,
+		},
+		{
+			name: "Updating node",
+			node: newConfigGenerationNode(machineConfigV0, machineConfigV1, daemonconsts.MachineConfigDaemonStateWorking, map[string]string{
+				daemonconsts.CurrentConfigGenerationAnnotationKey: "3",
+				daemonconsts.DesiredConfigGenerationAnnotationKey: "4",
+			}),
+			pool:  newConfigGenerationPool(machineConfigV1, "4"),
+			stale: true,
+		},
+		{
+			name: "Node finished updating",
+			node: newConfigGenerationNode(machineConfigV1, machineConfigV1, daemonconsts.MachineConfigDaemonStateDone, map[string]string{
+				daemonconsts.CurrentConfigGenerationAnnotationKey: "3",
+				daemonconsts.DesiredConfigGenerationAnnotationKey: "4",
+			}),
+			pool: newConfigGenerationPool(machineConfigV1, "4"),
+			expected: map[string]string{
+				daemonconsts.CurrentConfigGenerationAnnotationKey: "4",
+			},
+			stale: true,
+		},
+		{
+			name: "Node not yet targeted",
+			node: newConfigGenerationNode(machineConfigV0, machineConfigV0, daemonconsts.MachineConfigDaemonStateDone, map[string]string{
+				daemonconsts.CurrentConfigGenerationAnnotationKey: "3",
+				daemonconsts.DesiredConfigGenerationAnnotationKey: "3",
+			}),
+			pool:  newConfigGenerationPool(machineConfigV1, "4"),
+			stale: true,
+		},
+		{
+			name: "Up-to-date node",
+			node: newConfigGenerationNode(machineConfigV1, machineConfigV1, daemonconsts.MachineConfigDaemonStateDone, map[string]string{
+				daemonconsts.CurrentConfigGenerationAnnotationKey: "4",
+				daemonconsts.DesiredConfigGenerationAnnotationKey: "4",
+			}),
+			pool: newConfigGenerationPool(machineConfigV1, "4"),
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, test.expected, GetConfigGenerationAnnotationUpdates(test.node, test.pool))
+			assert.Equal(t, test.stale, IsNodeConfigGenerationStale(test.node, test.pool))
+		})
+	}
+}
+
+func TestSetDesiredStateFromPoolConfigGeneration(t *testing.T) {
+	t.Parallel()
+
+	lns := NewLayeredNodeState(newNode(machineConfigV0, machineConfigV0))
+	lns.SetDesiredStateFromPool(newConfigGenerationPool(machineConfigV1, "7"), "")
+	assert.Equal(t, int64(7), GetNodeDesiredConfigGeneration(lns.Node()))
+
+	lns.SetDesiredStateFromPool(newConfigGenerationPool(machineConfigV1, ""), "")
+	assert.NotContains(t, lns.Node().Annotations, daemonconsts.DesiredConfigGenerationAnnotationKey)
+}
@@ -52,6 +52,184 @@ const (
 
 	OSImageBuildPodLabel = "machineconfiguration.openshift.io/buildPod"
 
+	// BuildValidateOnlyAnnotationKey may be set to "true" on a layered MachineConfigPool to have the build controller build
+	// and push images as usual while the node controller never rolls them out to the pool's nodes.
+	BuildValidateOnlyAnnotationKey = "machineconfiguration.openshift.io/build-validate-only"
+
+	// SquashLayersAnnotationKey may be set to "true" on a layered MachineConfigPool to have the build controller flatten
+	// the layers added on top of the base image into a single layer before the image is pushed.
+	SquashLayersAnnotationKey = "machineconfiguration.openshift.io/squash-layers"
+
+	// AdditionalImagePullspecsAnnotationKey is set on a MachineConfigPool by the build controller to a comma-separated list
+	// of the digested pullspecs that the newest layered image was copied to, in addition to the final image pullspec.
+	AdditionalImagePullspecsAnnotationKey = "machineconfiguration.openshift.io/additionalImagePullspecs"
+
+	// RetainedImagePullspecsAnnotationKey is set on a MachineConfigPool by the build controller to a comma-separated list
+	// of the digested pullspecs of the previously built images which are kept in the registry, newest first.
+	RetainedImagePullspecsAnnotationKey = "machineconfiguration.openshift.io/retainedImagePullspecs"
+
+	// RetainedImageMachineConfigsAnnotationKey is set on a MachineConfigPool by the build controller to a comma-separated
+	// list of <pullspec>=<rendered MachineConfig> pairs which record the rendered MachineConfig each retained image was
+	// built from.
+	RetainedImageMachineConfigsAnnotationKey = "machineconfiguration.openshift.io/retainedImageMachineConfigs"
+
+	// RollbackImageAnnotationKey may be set on a layered MachineConfigPool to one of its retained images, either by
+	// digested pullspec or by digest, to have the node controller roll its nodes back to that image without a rebuild.
+	// Only images built from the pool's current rendered MachineConfig may be rolled back to.
+	RollbackImageAnnotationKey = "machineconfiguration.openshift.io/rollback-image"
+
+	// CriticalWindowPodAnnotationKey is set to "true" on a pod to signal that it is in a critical window (e.g. a database
+	// performing a backup) and that the node it runs on should not be selected for an update until the window closes.
+	CriticalWindowPodAnnotationKey = "machineconfiguration.openshift.io/critical-window"
+
+	// CriticalWindowMaxDeferAnnotationKey may be set on a MachineConfigPool to a duration (e.g. "30m") to bound how long
+	// critical window pods can defer node updates once the pool has started updating.
+	CriticalWindowMaxDeferAnnotationKey = "machineconfiguration.openshift.io/critical-window-max-defer"
+
+	// SafeModeAnnotationKey may be set to "true" on a MachineConfigPool to only allow changes which the MCD can apply
+	// without draining or rebooting nodes. Other changes are deferred until safe mode is turned off.
+	SafeModeAnnotationKey = "machineconfiguration.openshift.io/safe-mode"
+
+	// HugepagesAnnotationKey may be set on a MachineConfigPool to a JSON hugepages configuration (page sizes, counts and
+	// optional NUMA nodes) which the kubelet config controller renders into a generated MachineConfig for the pool.
+	HugepagesAnnotationKey = "machineconfiguration.openshift.io/hugepages"
+
+	// SandboxedContainersAnnotationKey may be set on a MachineConfigPool to a JSON sandboxed containers configuration
+	// (kata or peer-pods runtime, optional TEE, extra kernel arguments and modules) which the container runtime config
+	// controller renders into a generated MachineConfig for the pool.
+	SandboxedContainersAnnotationKey = "machineconfiguration.openshift.io/sandboxed-containers"
+
+	// ContainerStorageAnnotationKey may be set on a MachineConfigPool to a JSON container storage configuration
+	// (overlay options, image stores and pull options) which the container runtime config controller renders into
+	// a generated storage.conf for the pool.
+	ContainerStorageAnnotationKey = "machineconfiguration.openshift.io/container-storage"
+
+	// DNSAnnotationKey may be set on a MachineConfigPool to a JSON DNS configuration (name servers and search
+	// domains) which the kubelet config controller renders into a generated NetworkManager configuration for the pool.
+	DNSAnnotationKey = "machineconfiguration.openshift.io/dns"
+
+	// FileContentReferencesAnnotationKey may be set on a MachineConfig to a JSON list of file paths whose contents are
+	// filled in by the render controller from a ConfigMap or Secret key in the MCO namespace.
+	FileContentReferencesAnnotationKey = "machineconfiguration.openshift.io/file-content-references"
+
+	// ConfigGenerationAnnotationKey is set on a MachineConfigPool by the render controller to a monotonically increasing
+	// integer which is bumped every time the pool targets a different rendered MachineConfig.
+	ConfigGenerationAnnotationKey = "machineconfiguration.openshift.io/configGeneration"
+
+	// ForceDeleteAnnotationKey may be set to "true" on a MachineConfig to allow deleting it even though it is an in-use
+	// rendered MachineConfig or a base MachineConfig generated by the template controller.
+	ForceDeleteAnnotationKey = "machineconfiguration.openshift.io/force-delete"
+
+	// MinFreeDiskAnnotationKey may be set on a MachineConfigPool to a quantity (e.g. "10Gi") of disk space which must be
+	// free on /sysroot and /var of a node before it is selected for an update. When the pool has preflight checks, the
+	// MCD checks it again before it starts updating the node.
+	MinFreeDiskAnnotationKey = "machineconfiguration.openshift.io/min-free-disk"
+
+	// MaxConcurrentImagePullsAnnotationKey may be set on a MachineConfigPool to the maximum number of nodes which may
+	// be pulling a new OS image at once, so that a large pool does not saturate the registry or WAN links.
+	MaxConcurrentImagePullsAnnotationKey = "machineconfiguration.openshift.io/max-concurrent-image-pulls"
+
+	// DegradedGracePeriodAnnotationKey may be set on a MachineConfigPool to a JSON object mapping the NodeDegraded and
+	// RenderDegraded condition types to durations (e.g. {"NodeDegraded":"10m"}) for which the condition must be true
+	// before the pool reports Degraded.
+	DegradedGracePeriodAnnotationKey = "machineconfiguration.openshift.io/degraded-grace-period"
+
+	// CanarySoakAnnotationKey may be set on a layered MachineConfigPool to a duration (e.g. "30m") for which a single
+	// canary node must be Ready on a newly built image before the rest of the pool is updated to it.
+	CanarySoakAnnotationKey = "machineconfiguration.openshift.io/canary-soak"
+
+	// CanaryNodeAnnotationKey may be set to "true" on a node to pick it as the canary node of its pool.
+	CanaryNodeAnnotationKey = "machineconfiguration.openshift.io/canary"
+
+	// PeriodicRebootIntervalAnnotationKey may be set on a MachineConfigPool to a duration (e.g. "720h") after which
+	// a node which has not rebooted since is drained and rebooted, even without a config change.
+	PeriodicRebootIntervalAnnotationKey = "machineconfiguration.openshift.io/periodic-reboot-interval"
+
+	// PeriodicRebootWindowAnnotationKey may be set on a MachineConfigPool to a daily time window in UTC
+	// (e.g. "22:00-04:00") outside of which periodic reboots are not started.
+	PeriodicRebootWindowAnnotationKey = "machineconfiguration.openshift.io/periodic-reboot-window"
+
+	// UnmanagedPathsAnnotationKey may be set on a MachineConfigPool to a comma-separated list of paths under /etc
+	// (e.g. "/etc/agent.conf,/etc/agent.d/") which are managed by something other than the MCO. A path ending in
+	// "/" covers everything below it. Drift in these paths is ignored and existing files are never overwritten.
+	UnmanagedPathsAnnotationKey = "machineconfiguration.openshift.io/unmanaged-paths"
+
+	// MaintenanceWindowsAnnotationKey may be set on a MachineConfigPool to semicolon-separated weekly windows in UTC
+	// (e.g. "Mon-Fri 22:00-04:00; Sat,Sun 00:00-24:00") outside of which the MCD does not apply updates which
+	// reboot nodes. Such updates are still handed to the nodes right away and wait on them for the next window.
+	MaintenanceWindowsAnnotationKey = "machineconfiguration.openshift.io/maintenance-windows"
+
+	// PreflightChecksAnnotationKey may be set on a MachineConfigPool to a JSON object of checks which must pass on a
+	// node before the MCD starts updating it (e.g. {"ostreeDeployments":true,"script":"/usr/local/bin/preflight"}).
+	// Nodes which fail them are left untouched and retried later. The free disk space required by MinFreeDiskAnnotationKey
+	// is checked along with them.
+	PreflightChecksAnnotationKey = "machineconfiguration.openshift.io/preflight-checks"
+
+	// PostUpdateVerificationAnnotationKey may be set on a MachineConfigPool to a JSON object of probes which must pass on
+	// a node after it rebooted into a new config (e.g. {"units":["kubelet.service"],"httpGet":"http://localhost:8080/healthz","timeout":"10m"}).
+	// Nodes on which they fail are rolled back to their previous config and degraded.
+	PostUpdateVerificationAnnotationKey = "machineconfiguration.openshift.io/post-update-verification"
+
+	// DisruptionPolicyAnnotationKey may be set on a MachineConfigPool to a JSON object of files whose changes admins know
+	// to be safe to apply without a reboot, along with the actions which apply them
+	// (e.g. {"files":[{"path":"/etc/agent.d/","actions":[{"type":"RunUnit","unit":"agent-reload.service"}]}]}).
+	DisruptionPolicyAnnotationKey = "machineconfiguration.openshift.io/disruption-policy"
+
+	// SwapAnnotationKey may be set on a MachineConfigPool to a JSON object of the swap the MCD sets up on its nodes
+	// (e.g. {"type":"Zram","size":"4Gi","swappiness":10}). The kubelet of the pool must allow swap, i.e. a
+	// KubeletConfig must set failSwapOn to false.
+	SwapAnnotationKey = "machineconfiguration.openshift.io/swap"
+
+	// StagedUpdatesAnnotationKey may be set on a MachineConfigPool to a JSON object (e.g. {"finalization":"OnReboot"}) to have
+	// the MCD stage updates which reboot its nodes instead of applying them: files are written and the new OS deployment is
+	// staged without draining or rebooting. Staged updates are finalized within the pool's maintenance windows, on request
+	// or, with the OnReboot finalization, whenever the node reboots.
+	StagedUpdatesAnnotationKey = "machineconfiguration.openshift.io/staged-updates"
+
+	// ConfigDriftRemediationAnnotationKey may be set on a MachineConfigPool to ConfigDriftRemediationRemediate to
+	// have the MCD rewrite drifted files and units back to the contents of the current config instead of degrading
+	// the node. Defaults to ConfigDriftRemediationDegrade.
+	ConfigDriftRemediationAnnotationKey = "machineconfiguration.openshift.io/config-drift-remediation"
+
+	// ConfigDriftRemediationRemediate has the MCD remediate config drift, only degrading the node when that fails.
+	ConfigDriftRemediationRemediate = "Remediate"
+
+	// ConfigDriftRemediationDegrade has the MCD degrade the node when config drift is detected.
+	ConfigDriftRemediationDegrade = "Degrade"
+
+	// RebootStrategyAnnotationKey may be set on a MachineConfigPool to RebootStrategyKexec or RebootStrategySoftReboot
+	// to have the MCD skip the firmware of the pool's nodes when an update only changes userspace, that is when it
+	// changes neither the OS image, the kernel, its arguments nor the extensions. Defaults to RebootStrategyReboot.
+	RebootStrategyAnnotationKey = "machineconfiguration.openshift.io/reboot-strategy"
+
+	// RebootStrategyReboot has the MCD fully reboot the node for every update which requires a reboot.
+	RebootStrategyReboot = "Reboot"
+
+	// RebootStrategyKexec has the MCD boot straight into the current kernel with kexec for userspace-only updates.
+	RebootStrategyKexec = "Kexec"
+
+	// RebootStrategySoftReboot has the MCD only restart userspace with a systemd soft-reboot for userspace-only updates.
+	RebootStrategySoftReboot = "SoftReboot"
+
+	// ExportConfigAnnotationKey may be set to "true" on a MachineConfigPool to publish the pool's config in a
+	// "<pool>-config-export" ConfigMap, from which fleet management tools can replicate it to other clusters.
+	ExportConfigAnnotationKey = "machineconfiguration.openshift.io/export-config"
+
+	// ConfigExportLabelKey is set on config export ConfigMaps to the name of the exported MachineConfigPool.
+	ConfigExportLabelKey = "machineconfiguration.openshift.io/config-export"
+
+	// ConfigImportLabelKey may be set on a ConfigMap holding a config export from another cluster to the name of the
+	// MachineConfigPool to import the config into.
+	ConfigImportLabelKey = "machineconfiguration.openshift.io/config-import"
+
+	// ImportedFromAnnotationKey is set on imported MachineConfigs to the name of the rendered MachineConfig in the
+	// source cluster they were imported from.
+	ImportedFromAnnotationKey = "machineconfiguration.openshift.io/imported-from"
+
+	// PipelineLabelKey is set on the "<pool>-pipeline" ConfigMaps, which describe how the config of a
+	// MachineConfigPool is rolled out, to the name of the MachineConfigPool.
+	PipelineLabelKey = "machineconfiguration.openshift.io/pipeline"
+
 	// InternalMCOIgnitionVersion is the ignition version that the MCO converts everything to internally. The intent here is that
 	// we should be able to update this constant when we bump the internal ignition version instead of having to hunt down all of
 	// the version references and figure out "was this supposed to be explicitly 3.4.0 or just the default version which happens
@@ -0,0 +1,157 @@
+package common
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// DisruptionPolicyActionType is what the MCD does to apply a change to a file
+// covered by the disruption policy instead of rebooting the node.
+type DisruptionPolicyActionType string
+
+const (
+	// DisruptionPolicyActionNone only writes the file.
+	DisruptionPolicyActionNone DisruptionPolicyActionType = "None"
+	// DisruptionPolicyActionRestartCrio restarts crio. Running containers are
+	// not affected.
+	DisruptionPolicyActionRestartCrio DisruptionPolicyActionType = "RestartCrio"
+	// DisruptionPolicyActionRestartKubelet reloads the systemd units and
+	// restarts the kubelet. Running pods are not affected.
+	DisruptionPolicyActionRestartKubelet DisruptionPolicyActionType = "RestartKubelet"
+	// DisruptionPolicyActionDaemonReexec re-executes systemd, e.g. to apply
+	// changes to its own configuration.
+	DisruptionPolicyActionDaemonReexec DisruptionPolicyActionType = "DaemonReexec"
+	// DisruptionPolicyActionRunUnit reloads the systemd units and restarts the
+	// given unit, e.g. a oneshot service which applies the file.
+	DisruptionPolicyActionRunUnit DisruptionPolicyActionType = "RunUnit"
+)
+
+// DisruptionPolicy declares how changes to files which are known to be safe
+// to apply without a reboot are applied, as set in
+// DisruptionPolicyAnnotationKey.
+type DisruptionPolicy struct {
+	Files []DisruptionPolicyFile `json:"files"`
+}
+
+// DisruptionPolicyFile declares how changes to a file, or to the files below
+// a directory, are applied.
+type DisruptionPolicyFile struct {
+	// Path is the absolute path of a file, or of a directory ending in "/"
+	// which covers everything below it.
+	Path string `json:"path"`
+	// Actions are run in order after the file was written.
+	Actions []DisruptionPolicyAction `json:"actions"`
+}
+
+// DisruptionPolicyAction is one action of a DisruptionPolicyFile.
+type DisruptionPolicyAction struct {
+	Type DisruptionPolicyActionType `json:"type"`
+	// Unit is the systemd unit restarted by DisruptionPolicyActionRunUnit.
+	Unit string `json:"unit,omitempty"`
+}
+
+// validate checks that the action is known and has the fields it needs.
+func (a DisruptionPolicyAction) validate() error {
+	switch a.Type {
+	case DisruptionPolicyActionNone, DisruptionPolicyActionRestartCrio, DisruptionPolicyActionRestartKubelet, DisruptionPolicyActionDaemonReexec:
+		if a.Unit != "" {
+			return fmt.Errorf("action %s does not take a unit", a.Type)
+		}
+	case DisruptionPolicyActionRunUnit:
+		if a.Unit == "" || filepath.Base(a.Unit) != a.Unit || !strings.Contains(a.Unit, ".") {
+			return fmt.Errorf("action %s needs a unit name, e.g. apply-config.service, got %q", a.Type, a.Unit)
+		}
+	default:
+		return fmt.Errorf("unknown action %q", a.Type)
+	}
+
+	return nil
+}
+
+// ParseDisruptionPolicy parses and validates the disruption policy of
+// DisruptionPolicyAnnotationKey. An empty value has no policy.
+func ParseDisruptionPolicy(val string) (*DisruptionPolicy, error) {
+	if val == "" {
+		return nil, nil
+	}
+
+	policy := &DisruptionPolicy{}
+
+	dec := json.NewDecoder(bytes.NewBufferString(val))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(policy); err != nil {
+		return nil, err
+	}
+
+	if len(policy.Files) == 0 {
+		return nil, fmt.Errorf("at least one file is required")
+	}
+
+	seen := map[string]bool{}
+	for _, file := range policy.Files {
+		if !filepath.IsAbs(file.Path) || filepath.Clean(file.Path) != strings.TrimSuffix(file.Path, "/") || file.Path == "/" {
+			return nil, fmt.Errorf("path %q must be a clean absolute path", file.Path)
+		}
+		if seen[file.Path] {
+			return nil, fmt.Errorf("path %q is listed more than once", file.Path)
+		}
+		seen[file.Path] = true
+
+		if len(file.Actions) == 0 {
+			return nil, fmt.Errorf("path %q has no actions", file.Path)
+		}
+		for _, action := range file.Actions {
+			if err := action.validate(); err != nil {
+				return nil, fmt.Errorf("path %q: %w", file.Path, err)
+			}
+		}
+	}
+
+	return policy, nil
+}
+
+// GetActions returns the actions which apply changes to the file at path, and
+// false if the policy does not cover it. A file listed by its path takes
+// precedence over the directories above it, and deeper directories over
+// shallower ones. A nil policy covers no files.
+func (p *DisruptionPolicy) GetActions(path string) ([]DisruptionPolicyAction, bool) {
+	if p == nil {
+		return nil, false
+	}
+
+	var match *DisruptionPolicyFile
+
+	for i := range p.Files {
+		file := &p.Files[i]
+
+		if file.Path == path {
+			return file.Actions, true
+		}
+
+		if strings.HasSuffix(file.Path, "/") && strings.HasPrefix(path, file.Path) {
+			if match == nil || len(file.Path) > len(match.Path) {
+				match = file
+			}
+		}
+	}
+
+	if match == nil {
+		return nil, false
+	}
+
+	return match.Actions, true
+}
+
+// String returns the policy in the format of DisruptionPolicyAnnotationKey.
+func (p *DisruptionPolicy) String() string {
+	out, err := json.Marshal(p)
+	if err != nil {
+		// Not reached, since the policy only holds strings.
+		return ""
+	}
+
+	return string(out)
+}
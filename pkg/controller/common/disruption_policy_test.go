@@ -0,0 +1,88 @@
+package common
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseDisruptionPolicy(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		val         string
+		errExpected bool
+	}{
+		{val: `{"files":[{"path":"/etc/agent.conf","actions":[{"type":"None"}]}]}`},
+		{val: `{"files":[{"path":"/etc/agent.d/","actions":[{"type":"DaemonReexec"},{"type":"RunUnit","unit":"agent-reload.service"}]}]}`},
+		{val: `{"files":[{"path":"/etc/crio/crio.conf.d/99-custom","actions":[{"type":"RestartCrio"},{"type":"RestartKubelet"}]}]}`},
+		{val: `{"files":[]}`, errExpected: true},
+		{val: `{"files":[{"path":"etc/agent.conf","actions":[{"type":"None"}]}]}`, errExpected: true},
+		{val: `{"files":[{"path":"/etc/../agent.conf","actions":[{"type":"None"}]}]}`, errExpected: true},
+		{val: `{"files":[{"path":"/","actions":[{"type":"None"}]}]}`, errExpected: true},
+		{val: `{"files":[{"path":"/etc/agent.conf","actions":[]}]}`, errExpected: true},
+		{val: `{"files":[{"path":"/etc/agent.conf","actions":[{"type":"None"}]},{"path":"/etc/agent.conf","actions":[{"type":"None"}]}]}`, errExpected: true},
+		{val: `{"files":[{"path":"/etc/agent.conf","actions":[{"type":"Reboot"}]}]}`, errExpected: true},
+		{val: `{"files":[{"path":"/etc/agent.conf","actions":[{"type":"RunUnit"}]}]}`, errExpected: true},
+		{val: `{"files":[{"path":"/etc/agent.conf","actions":[{"type":"RunUnit","unit":"../agent.service"}]}]}`, errExpected: true},
+		{val: `{"files":[{"path":"/etc/agent.conf","actions":[{"type":"RestartCrio","unit":"crio.service"}]}]}`, errExpected: true},
+		{val: `{"paths":["/etc/agent.conf"]}`, errExpected: true},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+		t.Run(testCase.val, func(t *testing.T) {
+			t.Parallel()
+
+			policy, err := ParseDisruptionPolicy(testCase.val)
+			if testCase.errExpected {
+				assert.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+
+			// The formatted policy parses back into the same policy.
+			reparsed, err := ParseDisruptionPolicy(policy.String())
+			require.NoError(t, err)
+			assert.Equal(t, policy, reparsed)
+		})
+	}
+
+	policy, err := ParseDisruptionPolicy("")
+	assert.NoError(t, err)
+	assert.Nil(t, policy)
+}
+
+func TestDisruptionPolicyGetActions(t *testing.T) {
+	t.Parallel()
+
+	policy, err := ParseDisruptionPolicy(`{"files":[
+		{"path":"/etc/agent.d/","actions":[{"type":"RunUnit","unit":"agent-reload.service"}]},
+		{"path":"/etc/agent.d/tls/","actions":[{"type":"RestartKubelet"}]},
+		{"path":"/etc/agent.d/tls/ca.pem","actions":[{"type":"None"}]}
+	]}`)
+	require.NoError(t, err)
+
+	testCases := []struct {
+		path    string
+		actions []DisruptionPolicyAction
+	}{
+		{path: "/etc/agent.d/agent.conf", actions: []DisruptionPolicyAction{{Type: DisruptionPolicyActionRunUnit, Unit: "agent-reload.service"}}},
+		{path: "/etc/agent.d/tls/cert.pem", actions: []DisruptionPolicyAction{{Type: DisruptionPolicyActionRestartKubelet}}},
+		{path: "/etc/agent.d/tls/ca.pem", actions: []DisruptionPolicyAction{{Type: DisruptionPolicyActionNone}}},
+		{path: "/etc/agent.d"},
+		{path: "/etc/agent.conf"},
+	}
+
+	for _, testCase := range testCases {
+		actions, ok := policy.GetActions(testCase.path)
+		assert.Equal(t, testCase.actions != nil, ok, testCase.path)
+		assert.Equal(t, testCase.actions, actions, testCase.path)
+	}
+
+	var nilPolicy *DisruptionPolicy
+	_, ok := nilPolicy.GetActions("/etc/agent.conf")
+	assert.False(t, ok)
+}
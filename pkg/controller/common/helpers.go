@@ -18,6 +18,8 @@ import (
 	"text/template"
 
 	"github.com/clarketm/json"
+	"github.com/containers/image/v5/docker"
+	dockerref "github.com/containers/image/v5/docker/reference"
 	fcctbase "github.com/coreos/fcct/base/v0_1"
 	"github.com/coreos/ign-converter/translate/v23tov30"
 	"github.com/coreos/ign-converter/translate/v32tov22"
@@ -69,7 +71,7 @@ func boolToPtr(b bool) *bool {
 // MergeMachineConfigs combines multiple machineconfig objects into one object.
 // It sorts all the configs in increasing order of their name.
 // It uses the Ignition config from first object as base and appends all the rest.
-// Kernel arguments are concatenated.
+// Kernel arguments are concatenated, without duplicates and the ones removed by later configs.
 // It defaults to the OSImageURL provided by the CVO but allows a MC provided OSImageURL to take precedence.
 func MergeMachineConfigs(configs []*mcfgv1.MachineConfig, cconfig *mcfgv1.ControllerConfig) (*mcfgv1.MachineConfig, error) {
 	if len(configs) == 0 {
@@ -135,9 +137,9 @@ func MergeMachineConfigs(configs []*mcfgv1.MachineConfig, cconfig *mcfgv1.Contro
 		kernelType = KernelTypeDefault
 	}
 
-	kargs := []string{}
-	for _, cfg := range configs {
-		kargs = append(kargs, cfg.Spec.KernelArguments...)
+	kargs, err := MergeKernelArguments(configs)
+	if err != nil {
+		return nil, err
 	}
 
 	extensions := []string{}
@@ -557,6 +559,50 @@ func DecodeIgnitionFileContents(source, compression *string) ([]byte, error) {
 	return contentsBytes, nil
 }
 
+// SupportedExtensions returns the extensions possible to install on a RHCOS
+// based system, along with the packages which make up each extension.
+func SupportedExtensions() map[string][]string {
+	// In future when list of extensions grow, it will make
+	// more sense to populate it in a dynamic way.
+
+	// These are RHCOS supported extensions.
+	// Each extension keeps a list of packages required to get enabled on host.
+	return map[string][]string{
+		"wasm":                 {"crun-wasm"},
+		"ipsec":                {"NetworkManager-libreswan", "libreswan"},
+		"usbguard":             {"usbguard"},
+		"kerberos":             {"krb5-workstation", "libkadm5"},
+		"kernel-devel":         {"kernel-devel", "kernel-headers"},
+		"sandboxed-containers": {"kata-containers"},
+	}
+}
+
+// ValidateExtensions returns an error listing the given extensions which are not
+// available in the extensions image of RHCOS based systems, along with the
+// extensions which are.
+func ValidateExtensions(exts []string) error {
+	supported := SupportedExtensions()
+
+	invalid := []string{}
+	for _, ext := range exts {
+		if _, ok := supported[ext]; !ok {
+			invalid = append(invalid, ext)
+		}
+	}
+
+	if len(invalid) == 0 {
+		return nil
+	}
+
+	available := []string{}
+	for ext := range supported {
+		available = append(available, ext)
+	}
+	sort.Strings(available)
+
+	return fmt.Errorf("invalid extensions found: %v, available extensions are: %v", invalid, available)
+}
+
 // InSlice search for an element in slice and return true if found, otherwise return false
 func InSlice(elem string, slice []string) bool {
 	for _, k := range slice {
@@ -567,6 +613,79 @@ func InSlice(elem string, slice []string) bool {
 	return false
 }
 
+// exclusiveKernelArguments are kernel arguments for which only a single value takes effect. When more than one
+// MachineConfig sets one of these with different values, the resulting behavior depends on the order of the
+// arguments on the kernel command line, so we refuse to render such a combination.
+var exclusiveKernelArguments = map[string]bool{
+	"amd_iommu":          true,
+	"crashkernel":        true,
+	"default_hugepagesz": true,
+	"enforcing":          true,
+	"intel_iommu":        true,
+	"iommu":              true,
+	"irqaffinity":        true,
+	"isolcpus":           true,
+	"mitigations":        true,
+	"nohz_full":          true,
+	"rcu_nocbs":          true,
+	"selinux":            true,
+}
+
+// ValidateKernelArgumentConflicts ensures that no two MachineConfigs set conflicting values for a kernel argument
+// that only accepts a single value. The returned error names the conflicting MachineConfigs.
+func ValidateKernelArgumentConflicts(configs []*mcfgv1.MachineConfig) error {
+	type kargSource struct {
+		karg   string
+		value  string
+		config string
+	}
+
+	// Check the configs in the same order they are merged in so that the result is stable.
+	sorted := make([]*mcfgv1.MachineConfig, len(configs))
+	copy(sorted, configs)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Name < sorted[j].Name })
+
+	seen := map[string]kargSource{}
+	conflicts := []string{}
+
+	for _, config := range sorted {
+		for _, entry := range config.Spec.KernelArguments {
+			// A single entry may contain several space-separated kernel arguments.
+			for _, karg := range SplitKernelArguments(entry) {
+				// A removed value no longer conflicts with the ones set after it.
+				if isKernelArgumentRemoval(karg) {
+					key := kernelArgumentKey(strings.TrimPrefix(karg, KernelArgumentRemovalPrefix))
+					if prev, ok := seen[key]; ok && matchesKernelArgumentRemoval(karg, prev.karg) {
+						delete(seen, key)
+					}
+					continue
+				}
+
+				key, value, _ := strings.Cut(karg, "=")
+				if !exclusiveKernelArguments[key] {
+					continue
+				}
+
+				prev, ok := seen[key]
+				if !ok {
+					seen[key] = kargSource{karg: karg, value: value, config: config.Name}
+					continue
+				}
+
+				if prev.value != value {
+					conflicts = append(conflicts, fmt.Sprintf("%s=%s (from %s) conflicts with %s=%s (from %s)", key, prev.value, prev.config, key, value, config.Name))
+				}
+			}
+		}
+	}
+
+	if len(conflicts) != 0 {
+		return fmt.Errorf("conflicting kernel arguments: %s", strings.Join(conflicts, "; "))
+	}
+
+	return nil
+}
+
 // ValidateMachineConfig validates that given MachineConfig Spec is valid.
 func ValidateMachineConfig(cfg mcfgv1.MachineConfigSpec) error {
 	if !(cfg.KernelType == "" || cfg.KernelType == KernelTypeDefault || cfg.KernelType == KernelTypeRealtime) {
@@ -1056,6 +1175,25 @@ func GetDefaultBaseImageContainer(cconfigspec *mcfgv1.ControllerConfigSpec) stri
 	return cconfigspec.BaseOSContainerImage
 }
 
+// ValidateDigestedPullspec ensures that the given image pullspec references the
+// image by its SHA256 digest rather than by a tag, which may be moved to another
+// image at any time.
+func ValidateDigestedPullspec(pullspec string) error {
+	tagged, err := docker.ParseReference("//" + pullspec)
+	if err != nil {
+		return err
+	}
+
+	switch tagged.DockerReference().(type) {
+	case dockerref.Tagged:
+		return fmt.Errorf("expected a pullspec with a SHA256 digest, got %q", pullspec)
+	case dockerref.Digested:
+		return nil
+	default:
+		return fmt.Errorf("unknown image reference spec %q", pullspec)
+	}
+}
+
 // Configures common template FuncMaps used across all renderers.
 func GetTemplateFuncMap() template.FuncMap {
 	return template.FuncMap{
@@ -1155,6 +1293,22 @@ func IsLayeredPool(pool *mcfgv1.MachineConfigPool) bool {
 	return false
 }
 
+// Paths which are not part of an OS image because ostree keeps them on the
+// node across deployments. /home and /root are symlinks into /var on CoreOS.
+var perNodePathPrefixes = []string{"/var/", "/home/", "/root/"}
+
+// IsPerNodePath determines whether the given path is kept on the node rather
+// than in the OS image, so that an image-based update cannot change it.
+func IsPerNodePath(path string) bool {
+	for _, prefix := range perNodePathPrefixes {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
 // DockerConfigJSON represents ~/.docker/config.json file info
 type DockerConfigJSON struct {
 	Auths DockerConfig `json:"auths"`
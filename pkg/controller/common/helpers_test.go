@@ -12,6 +12,7 @@ import (
 	validate3 "github.com/coreos/ignition/v2/config/validate"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 
 	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
@@ -401,6 +402,108 @@ func TestMergeMachineConfigs(t *testing.T) {
 	assert.Equal(t, *mergedMachineConfig, *expectedMachineConfig)
 }
 
+func TestValidateKernelArgumentConflicts(t *testing.T) {
+	newMC := func(name string, kargs ...string) *mcfgv1.MachineConfig {
+		return &mcfgv1.MachineConfig{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec:       mcfgv1.MachineConfigSpec{KernelArguments: kargs},
+		}
+	}
+
+	testCases := []struct {
+		name          string
+		configs       []*mcfgv1.MachineConfig
+		errorContains []string
+	}{
+		{
+			name: "no kernel arguments",
+			configs: []*mcfgv1.MachineConfig{
+				newMC("00-worker"),
+				newMC("01-worker"),
+			},
+		},
+		{
+			name: "non-exclusive arguments may repeat",
+			configs: []*mcfgv1.MachineConfig{
+				newMC("50-a", "hugepagesz=1G", "hugepages=4"),
+				newMC("60-b", "hugepagesz=2M", "hugepages=512", "console=ttyS0"),
+			},
+		},
+		{
+			name: "exclusive argument with the same value",
+			configs: []*mcfgv1.MachineConfig{
+				newMC("50-a", "default_hugepagesz=1G"),
+				newMC("60-b", "default_hugepagesz=1G"),
+			},
+		},
+		{
+			name: "exclusive argument with different values",
+			configs: []*mcfgv1.MachineConfig{
+				newMC("60-b", "default_hugepagesz=2M"),
+				newMC("50-a", "default_hugepagesz=1G"),
+			},
+			errorContains: []string{"default_hugepagesz=1G (from 50-a) conflicts with default_hugepagesz=2M (from 60-b)"},
+		},
+		{
+			name: "space-separated arguments within a single entry",
+			configs: []*mcfgv1.MachineConfig{
+				newMC("50-a", "isolcpus=1-3 nohz_full=1-3"),
+				newMC("60-b", "nohz_full=2-3"),
+			},
+			errorContains: []string{"nohz_full=1-3 (from 50-a)", "nohz_full=2-3 (from 60-b)"},
+		},
+		{
+			name: "multiple conflicts",
+			configs: []*mcfgv1.MachineConfig{
+				newMC("50-a", "selinux=0", "mitigations=off"),
+				newMC("60-b", "selinux=1", "mitigations=auto"),
+			},
+			errorContains: []string{"selinux=0 (from 50-a)", "mitigations=off (from 50-a)"},
+		},
+		{
+			name: "exclusive argument removed before it is set again",
+			configs: []*mcfgv1.MachineConfig{
+				newMC("50-a", "isolcpus=1-3"),
+				newMC("60-b", "-isolcpus", "isolcpus=2-3"),
+			},
+		},
+		{
+			name: "removing another value does not resolve a conflict",
+			configs: []*mcfgv1.MachineConfig{
+				newMC("50-a", "isolcpus=1-3"),
+				newMC("60-b", "-isolcpus=0-1", "isolcpus=2-3"),
+			},
+			errorContains: []string{"isolcpus=1-3 (from 50-a) conflicts with isolcpus=2-3 (from 60-b)"},
+		},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+		t.Run(testCase.name, func(t *testing.T) {
+			err := ValidateKernelArgumentConflicts(testCase.configs)
+			if len(testCase.errorContains) == 0 {
+				assert.NoError(t, err)
+				return
+			}
+
+			assert.Error(t, err)
+			for _, msg := range testCase.errorContains {
+				assert.Contains(t, err.Error(), msg)
+			}
+		})
+	}
+}
+
+func TestValidateExtensions(t *testing.T) {
+	assert.NoError(t, ValidateExtensions(nil))
+	assert.NoError(t, ValidateExtensions([]string{"usbguard", "kernel-devel"}))
+
+	err := ValidateExtensions([]string{"usbguard", "foo"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid extensions found: [foo]")
+	assert.Contains(t, err.Error(), "available extensions are: [ipsec kerberos kernel-devel sandboxed-containers usbguard wasm]")
+}
+
 func TestRemoveIgnDuplicateFilesAndUnits(t *testing.T) {
 	mode := 420
 	testDataOld := "data:,old"
@@ -0,0 +1,12 @@
+package common
+
+import (
+	daemonconsts "github.com/openshift/machine-config-operator/pkg/daemon/constants"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// IsNodeHeld returns whether an admin holds the node at its current config
+// with HoldAnnotationKey.
+func IsNodeHeld(node *corev1.Node) bool {
+	return node.Annotations[daemonconsts.HoldAnnotationKey] == "true"
+}
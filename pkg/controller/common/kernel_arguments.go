@@ -0,0 +1,191 @@
+package common
+
+import (
+	"fmt"
+	"strings"
+
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+)
+
+// KernelArgumentRemovalPrefix starts a kernel argument in a MachineConfig which
+// removes a kernel argument set by a MachineConfig merged before it: "-key"
+// removes every value of key, and "-key=value" only that value.
+const KernelArgumentRemovalPrefix = "-"
+
+// positionalKernelArguments apply to the kernel arguments before them, e.g.
+// hugepages=4 sets the number of pages of the preceding hugepagesz, so
+// repeating them with the same value is meaningful and they are never
+// deduplicated.
+var positionalKernelArguments = map[string]bool{
+	"hugepages":  true,
+	"hugepagesz": true,
+}
+
+// checks for white-space characters in "C" and "POSIX" locales.
+func isSpace(b byte) bool {
+	return b == ' ' || b == '\f' || b == '\n' || b == '\r' || b == '\t' || b == '\v'
+}
+
+// You can use " around spaces, but can't escape ". See next_arg() in kernel code /lib/cmdline.c
+// Gives the start and stop index for the next arg in the string, beyond the provided `begin` index
+func nextArg(args string, begin int) (int, int) {
+	var (
+		start, stop int
+		inQuote     bool
+	)
+	// Skip leading spaces
+	for start = begin; start < len(args) && isSpace(args[start]); {
+		start++
+	}
+	stop = start
+	for ; stop < len(args); stop++ {
+		if isSpace(args[stop]) && !inQuote {
+			break
+		}
+
+		if args[stop] == '"' {
+			inQuote = !inQuote
+		}
+	}
+
+	return start, stop
+}
+
+// SplitKernelArguments splits a kernel command line, or a single entry of
+// MachineConfig kernelArguments, into its kernel arguments the way the kernel
+// does.
+func SplitKernelArguments(args string) []string {
+	var (
+		start, stop int
+		split       []string
+	)
+	for stop < len(args) {
+		start, stop = nextArg(args, stop)
+		if start != stop {
+			split = append(split, args[start:stop])
+		}
+	}
+	return split
+}
+
+// isKernelArgumentRemoval returns whether the kernel argument is a removal
+// directive. "--" separates the arguments of init on the kernel command line
+// and is kept as is.
+func isKernelArgumentRemoval(karg string) bool {
+	return strings.HasPrefix(karg, KernelArgumentRemovalPrefix) && !strings.HasPrefix(karg, "--")
+}
+
+// kernelArgumentKey returns the key of a kernel argument, e.g. "console" for
+// "console=ttyS0".
+func kernelArgumentKey(karg string) string {
+	key, _, _ := strings.Cut(karg, "=")
+	return key
+}
+
+// matchesKernelArgumentRemoval returns whether the removal directive removes
+// the kernel argument.
+func matchesKernelArgumentRemoval(removal, karg string) bool {
+	target := strings.TrimPrefix(removal, KernelArgumentRemovalPrefix)
+	if strings.Contains(target, "=") {
+		return karg == target
+	}
+	return kernelArgumentKey(karg) == target
+}
+
+// validateKernelArgumentRemoval checks that the removal directive names a
+// kernel argument.
+func validateKernelArgumentRemoval(removal string) error {
+	if kernelArgumentKey(strings.TrimPrefix(removal, KernelArgumentRemovalPrefix)) == "" {
+		return fmt.Errorf("invalid kernel argument removal %q: must be -key or -key=value", removal)
+	}
+	return nil
+}
+
+// MergeKernelArguments merges the kernel arguments of the configs in the order
+// given, which MergeMachineConfigs sorts by name:
+//   - removal directives ("-key" or "-key=value") remove the matching kernel
+//     arguments merged before them and are dropped themselves
+//   - a kernel argument which is already set with the same value is dropped,
+//     except for positional ones like hugepages
+//
+// Entries which keep all their kernel arguments are returned unchanged, so
+// configs without duplicates or removals merge into the same list as before.
+// Dropping duplicates changes the rendered config of pools which had them, so
+// these pools reboot once after an upgrade, see docs/MachineConfiguration.md.
+func MergeKernelArguments(configs []*mcfgv1.MachineConfig) ([]string, error) {
+	type mergedEntry struct {
+		entry   string
+		kargs   []string
+		removed []bool
+	}
+
+	entries := []*mergedEntry{}
+
+	// removeMatching removes the kernel arguments merged so far which match
+	// the removal directive.
+	removeMatching := func(removal string) {
+		for _, e := range entries {
+			for i, karg := range e.kargs {
+				if !e.removed[i] && matchesKernelArgumentRemoval(removal, karg) {
+					e.removed[i] = true
+				}
+			}
+		}
+	}
+
+	// isSet returns whether the kernel argument was merged already and not
+	// removed since.
+	isSet := func(karg string) bool {
+		for _, e := range entries {
+			for i, k := range e.kargs {
+				if !e.removed[i] && k == karg {
+					return true
+				}
+			}
+		}
+		return false
+	}
+
+	for _, cfg := range configs {
+		for _, entry := range cfg.Spec.KernelArguments {
+			e := &mergedEntry{entry: entry}
+			entries = append(entries, e)
+
+			for _, karg := range SplitKernelArguments(entry) {
+				if isKernelArgumentRemoval(karg) {
+					if err := validateKernelArgumentRemoval(karg); err != nil {
+						return nil, fmt.Errorf("MachineConfig %s: %w", cfg.Name, err)
+					}
+					removeMatching(karg)
+					e.kargs = append(e.kargs, karg)
+					e.removed = append(e.removed, true)
+					continue
+				}
+
+				duplicate := !positionalKernelArguments[kernelArgumentKey(karg)] && isSet(karg)
+				e.kargs = append(e.kargs, karg)
+				e.removed = append(e.removed, duplicate)
+			}
+		}
+	}
+
+	kargs := []string{}
+	for _, e := range entries {
+		kept := []string{}
+		for i, karg := range e.kargs {
+			if !e.removed[i] {
+				kept = append(kept, karg)
+			}
+		}
+
+		switch {
+		case len(kept) == len(e.kargs):
+			kargs = append(kargs, e.entry)
+		case len(kept) == 0:
+		default:
+			kargs = append(kargs, strings.Join(kept, " "))
+		}
+	}
+
+	return kargs, nil
+}
@@ -0,0 +1,128 @@
+package common
+
+import (
+	"testing"
+
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestSplitKernelArguments(t *testing.T) {
+	t.Parallel()
+
+	assert.Nil(t, SplitKernelArguments(""))
+	assert.Equal(t, []string{"foo", "bar=1"}, SplitKernelArguments("  foo\tbar=1 "))
+	assert.Equal(t, []string{`dyndbg="file drivers/foo.c +p"`, "quiet"}, SplitKernelArguments(`dyndbg="file drivers/foo.c +p" quiet`))
+}
+
+func TestMergeKernelArguments(t *testing.T) {
+	t.Parallel()
+
+	newMC := func(name string, kargs ...string) *mcfgv1.MachineConfig {
+		return &mcfgv1.MachineConfig{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec:       mcfgv1.MachineConfigSpec{KernelArguments: kargs},
+		}
+	}
+
+	testCases := []struct {
+		name        string
+		configs     []*mcfgv1.MachineConfig
+		expected    []string
+		errExpected bool
+	}{
+		{
+			name:     "no kernel arguments",
+			configs:  []*mcfgv1.MachineConfig{newMC("00-worker")},
+			expected: []string{},
+		},
+		{
+			name: "entries without duplicates are kept as they are",
+			configs: []*mcfgv1.MachineConfig{
+				newMC("50-a", "foo", " bar=1  baz"),
+				newMC("60-b", `dyndbg="file foo.c +p"`),
+			},
+			expected: []string{"foo", " bar=1  baz", `dyndbg="file foo.c +p"`},
+		},
+		{
+			name: "duplicates keep the first position",
+			configs: []*mcfgv1.MachineConfig{
+				newMC("50-a", "nosmt", "console=tty0"),
+				newMC("60-b", "console=ttyS0 nosmt", "console=tty0"),
+			},
+			expected: []string{"nosmt", "console=tty0", "console=ttyS0"},
+		},
+		{
+			name: "positional arguments are not deduplicated",
+			configs: []*mcfgv1.MachineConfig{
+				newMC("50-a", "hugepagesz=1G hugepages=4"),
+				newMC("60-b", "hugepagesz=2M hugepages=4"),
+			},
+			expected: []string{"hugepagesz=1G hugepages=4", "hugepagesz=2M hugepages=4"},
+		},
+		{
+			name: "removing every value of a key",
+			configs: []*mcfgv1.MachineConfig{
+				newMC("50-a", "console=tty0 console=ttyS0,115200", "quiet"),
+				newMC("60-b", "-console", "console=ttyS1"),
+			},
+			expected: []string{"quiet", "console=ttyS1"},
+		},
+		{
+			name: "removing a single value",
+			configs: []*mcfgv1.MachineConfig{
+				newMC("50-a", "console=tty0", "console=ttyS0"),
+				newMC("60-b", "-console=tty0 loglevel=7"),
+			},
+			expected: []string{"console=ttyS0", "loglevel=7"},
+		},
+		{
+			name: "removals only apply to the arguments merged before them",
+			configs: []*mcfgv1.MachineConfig{
+				newMC("50-a", "-mitigations"),
+				newMC("60-b", "mitigations=off"),
+			},
+			expected: []string{"mitigations=off"},
+		},
+		{
+			name: "a removed argument can be set again",
+			configs: []*mcfgv1.MachineConfig{
+				newMC("50-a", "nosmt"),
+				newMC("60-b", "-nosmt"),
+				newMC("70-c", "nosmt"),
+			},
+			expected: []string{"nosmt"},
+		},
+		{
+			name: "init arguments are not removals",
+			configs: []*mcfgv1.MachineConfig{
+				newMC("50-a", "quiet -- single"),
+			},
+			expected: []string{"quiet -- single"},
+		},
+		{
+			name: "invalid removal",
+			configs: []*mcfgv1.MachineConfig{
+				newMC("50-a", "-=foo"),
+			},
+			errExpected: true,
+		},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			kargs, err := MergeKernelArguments(testCase.configs)
+			if testCase.errExpected {
+				assert.Error(t, err)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, testCase.expected, kargs)
+		})
+	}
+}
@@ -2,8 +2,10 @@ package common
 
 import (
 	"fmt"
+	"strconv"
 
 	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	"github.com/openshift/machine-config-operator/pkg/apihelpers/conditions"
 	daemonconsts "github.com/openshift/machine-config-operator/pkg/daemon/constants"
 	corev1 "k8s.io/api/core/v1"
 )
@@ -90,15 +92,19 @@ func (l *LayeredNodeState) isImageAnnotationEqualToPool(anno string, mcp *mcfgv1
 // following rules:
 //
 // 1. The desired MachineConfig annotation will always be set to match the one
-// specified in the MachineConfigPool.
+// specified in the MachineConfigPool, along with the pool's config generation
+// (if it has one).
 // 2. If the pool is layered and has the OS image available, it will set the
 // desired image annotation.
 // 3. If the pool is not layered and does not have the OS image available, it
 // will remove the desired image annotation.
+// 4. If the desired image annotation is set and the image was signed, it will
+// set the public key the image's signature is verified with, as recorded in
+// the status of the build which built the image. Otherwise, it will remove it.
 //
 // Note: This will create a deep copy of the node object first to avoid
 // mutating any underlying caches.
-func (l *LayeredNodeState) SetDesiredStateFromPool(mcp *mcfgv1.MachineConfigPool) {
+func (l *LayeredNodeState) SetDesiredStateFromPool(mcp *mcfgv1.MachineConfigPool, imageSigningPublicKey string) {
 	node := l.Node()
 	if node.Annotations == nil {
 		node.Annotations = map[string]string{}
@@ -106,6 +112,12 @@ func (l *LayeredNodeState) SetDesiredStateFromPool(mcp *mcfgv1.MachineConfigPool
 
 	node.Annotations[daemonconsts.DesiredMachineConfigAnnotationKey] = mcp.Spec.Configuration.Name
 
+	if generation := GetPoolConfigGeneration(mcp); generation != 0 {
+		node.Annotations[daemonconsts.DesiredConfigGenerationAnnotationKey] = strconv.FormatInt(generation, 10)
+	} else {
+		delete(node.Annotations, daemonconsts.DesiredConfigGenerationAnnotationKey)
+	}
+
 	lps := NewLayeredPoolState(mcp)
 
 	if lps.IsLayered() && lps.HasOSImage() {
@@ -114,6 +126,12 @@ func (l *LayeredNodeState) SetDesiredStateFromPool(mcp *mcfgv1.MachineConfigPool
 		delete(node.Annotations, daemonconsts.DesiredImageAnnotationKey)
 	}
 
+	if imageSigningPublicKey != "" && lps.IsLayered() && lps.HasOSImage() {
+		node.Annotations[daemonconsts.DesiredImageSigningPublicKeyAnnotationKey] = imageSigningPublicKey
+	} else {
+		delete(node.Annotations, daemonconsts.DesiredImageSigningPublicKeyAnnotationKey)
+	}
+
 	l.node = node
 }
 
@@ -159,6 +177,11 @@ func isNodeUnavailable(node *corev1.Node) bool {
 	if isNodeDone(node) {
 		return false
 	}
+	// Nodes whose update is staged keep running their workloads until the
+	// update is finalized, which sets the MCD state to working.
+	if IsUpdateStaged(node) && isNodeMCDState(node, daemonconsts.MachineConfigDaemonStateDone) {
+		return false
+	}
 	// Now we know the node isn't ready - the current config must not
 	// equal target.  We want to further filter down on the MCD state.
 	// If a MCD is in a terminal (failing) state then we can safely retarget it.
@@ -178,21 +201,18 @@ func isNodeMCDState(node *corev1.Node, state string) bool {
 }
 
 func checkNodeReady(node *corev1.Node) error {
-	for i := range node.Status.Conditions {
-		cond := &node.Status.Conditions[i]
-		// We consider the node for scheduling only when its:
-		// - NodeReady condition status is ConditionTrue,
-		// - NodeDiskPressure condition status is ConditionFalse,
-		// - NodeNetworkUnavailable condition status is ConditionFalse.
-		if cond.Type == corev1.NodeReady && cond.Status != corev1.ConditionTrue {
-			return fmt.Errorf("node %s is reporting NotReady=%v", node.Name, cond.Status)
-		}
-		if cond.Type == corev1.NodeDiskPressure && cond.Status != corev1.ConditionFalse {
-			return fmt.Errorf("node %s is reporting OutOfDisk=%v", node.Name, cond.Status)
-		}
-		if cond.Type == corev1.NodeNetworkUnavailable && cond.Status != corev1.ConditionFalse {
-			return fmt.Errorf("node %s is reporting NetworkUnavailable=%v", node.Name, cond.Status)
-		}
+	// We consider the node for scheduling only when its:
+	// - NodeReady condition status is ConditionTrue,
+	// - NodeDiskPressure condition status is ConditionFalse,
+	// - NodeNetworkUnavailable condition status is ConditionFalse.
+	if cond := conditions.Node.Get(node.Status.Conditions, corev1.NodeReady); cond != nil && cond.Status != corev1.ConditionTrue {
+		return fmt.Errorf("node %s is reporting NotReady=%v", node.Name, cond.Status)
+	}
+	if cond := conditions.Node.Get(node.Status.Conditions, corev1.NodeDiskPressure); cond != nil && cond.Status != corev1.ConditionFalse {
+		return fmt.Errorf("node %s is reporting OutOfDisk=%v", node.Name, cond.Status)
+	}
+	if cond := conditions.Node.Get(node.Status.Conditions, corev1.NodeNetworkUnavailable); cond != nil && cond.Status != corev1.ConditionFalse {
+		return fmt.Errorf("node %s is reporting NetworkUnavailable=%v", node.Name, cond.Status)
 	}
 	// Ignore nodes that are marked unschedulable
 	if node.Spec.Unschedulable {
@@ -205,11 +225,13 @@ func isNodeReady(node *corev1.Node) bool {
 	return checkNodeReady(node) == nil
 }
 
-// isNodeMCDFailing checks if the MCD has unsuccessfully applied an update
+// isNodeMCDFailing checks if the MCD has unsuccessfully applied an update, or
+// skipped it because the preflight checks failed
 func isNodeMCDFailing(node *corev1.Node) bool {
 	if node.Annotations[daemonconsts.CurrentMachineConfigAnnotationKey] == node.Annotations[daemonconsts.DesiredMachineConfigAnnotationKey] {
 		return false
 	}
 	return isNodeMCDState(node, daemonconsts.MachineConfigDaemonStateDegraded) ||
-		isNodeMCDState(node, daemonconsts.MachineConfigDaemonStateUnreconcilable)
+		isNodeMCDState(node, daemonconsts.MachineConfigDaemonStateUnreconcilable) ||
+		HasFailedPreflightChecks(node)
 }
@@ -1,6 +1,7 @@
 package common
 
 import (
+	"fmt"
 	"testing"
 
 	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
@@ -28,6 +29,15 @@ func newLayeredNode(currentConfig, desiredConfig, currentImage, desiredImage str
 	return nb.Node()
 }
 
+func newStagedNode(current, desired string) *corev1.Node {
+	nb := helpers.NewNodeBuilder("").WithCurrentConfig(current).WithDesiredConfig(desired)
+	nb.WithMCDState(daemonconsts.MachineConfigDaemonStateDone).WithNodeReady()
+	nb.WithAnnotations(map[string]string{
+		daemonconsts.StagedUpdateAnnotationKey: fmt.Sprintf(`{"config":%q,"from":%q,"finalization":"Locked","time":"2024-01-01T00:00:00Z"}`, desired, current),
+	})
+	return nb.Node()
+}
+
 func newMachineConfigPool(currentConfig string) *mcfgv1.MachineConfigPool {
 	return helpers.NewMachineConfigPoolBuilder("").WithMachineConfig(currentConfig).MachineConfigPool()
 }
@@ -96,6 +106,12 @@ func TestLayeredNodeState(t *testing.T) {
 			isUnavailable:        true,
 			isDesiredEqualToPool: true,
 		},
+		{
+			name:                 "Node with a staged update",
+			node:                 newStagedNode(machineConfigV0, machineConfigV1),
+			pool:                 newMachineConfigPool(machineConfigV1),
+			isDesiredEqualToPool: true,
+		},
 	}
 
 	for _, test := range tests {
@@ -172,7 +188,7 @@ func TestLayeredNodeStateIsMutated(t *testing.T) {
 		t.Run(test.name, func(t *testing.T) {
 			t.Parallel()
 			lns := NewLayeredNodeState(test.node)
-			lns.SetDesiredStateFromPool(test.pool)
+			lns.SetDesiredStateFromPool(test.pool, "")
 
 			updatedNode := lns.Node()
 
@@ -191,3 +207,21 @@ func TestLayeredNodeStateIsMutated(t *testing.T) {
 		})
 	}
 }
+
+func TestLayeredNodeStateImageSigningPublicKey(t *testing.T) {
+	t.Parallel()
+
+	pool := newLayeredMachineConfigPoolWithImage(machineConfigV0, imageV1)
+
+	lns := NewLayeredNodeState(newLayeredNode(machineConfigV0, machineConfigV0, imageV0, imageV0))
+	lns.SetDesiredStateFromPool(pool, "public-key")
+	assert.Equal(t, "public-key", lns.Node().Annotations[daemonconsts.DesiredImageSigningPublicKeyAnnotationKey])
+
+	// The public key is removed once the pool's image is no longer signed.
+	lns.SetDesiredStateFromPool(pool, "")
+	assert.NotContains(t, lns.Node().Annotations, daemonconsts.DesiredImageSigningPublicKeyAnnotationKey)
+
+	// The public key is only set along with the image.
+	lns.SetDesiredStateFromPool(newMachineConfigPool(machineConfigV1), "public-key")
+	assert.NotContains(t, lns.Node().Annotations, daemonconsts.DesiredImageSigningPublicKeyAnnotationKey)
+}
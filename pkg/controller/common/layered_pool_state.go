@@ -1,6 +1,9 @@
 package common
 
 import (
+	"fmt"
+	"strings"
+
 	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
 	"github.com/openshift/machine-config-operator/pkg/apihelpers"
 )
@@ -32,12 +35,89 @@ func (l *LayeredPoolState) IsLayered() bool {
 	return IsLayeredPool(l.pool)
 }
 
-// Returns the OS image, if one is present.
+// Returns the OS image, if one is present. If the pool is rolled back to one
+// of its retained images, that image is returned instead of the newest one.
 func (l *LayeredPoolState) GetOSImage() string {
+	if rollbackImage, err := l.GetRollbackImage(); err == nil && rollbackImage != "" {
+		return rollbackImage
+	}
+
 	osImage := l.pool.Annotations[ExperimentalNewestLayeredImageEquivalentConfigAnnotationKey]
 	return osImage
 }
 
+// Returns the digested pullspecs of the previously built images which are
+// kept in the registry, newest first.
+func (l *LayeredPoolState) GetRetainedImages() []string {
+	val := l.pool.Annotations[RetainedImagePullspecsAnnotationKey]
+	if val == "" {
+		return nil
+	}
+
+	return strings.Split(val, ",")
+}
+
+// Returns the rendered MachineConfig each retained image was built from,
+// keyed by the digested pullspec of the image.
+func (l *LayeredPoolState) GetRetainedImageMachineConfigs() map[string]string {
+	out := map[string]string{}
+
+	val := l.pool.Annotations[RetainedImageMachineConfigsAnnotationKey]
+	if val == "" {
+		return out
+	}
+
+	for _, pair := range strings.Split(val, ",") {
+		if image, mc, ok := strings.Cut(pair, "="); ok {
+			out[image] = mc
+		}
+	}
+
+	return out
+}
+
+// Returns the retained image the rollback annotation names, if any, without
+// checking which rendered MachineConfig it was built from. The image may be
+// given by digested pullspec or by digest. An error is returned if it is not
+// one of the retained images, since the image may no longer exist.
+func (l *LayeredPoolState) GetRequestedRollbackImage() (string, error) {
+	val := l.pool.Annotations[RollbackImageAnnotationKey]
+	if val == "" {
+		return "", nil
+	}
+
+	for _, image := range l.GetRetainedImages() {
+		if image == val || strings.HasSuffix(image, "@"+val) {
+			return image, nil
+		}
+	}
+
+	return "", fmt.Errorf("%s %q is not one of the retained images of MachineConfigPool %s", RollbackImageAnnotationKey, val, l.pool.Name)
+}
+
+// Returns the retained image the pool is rolled back to, if any. Only the
+// image is rolled back, and the nodes are validated against the pool's
+// current rendered MachineConfig once they boot into it. An error is
+// therefore also returned if the image was built from a different rendered
+// MachineConfig, since its files would not match.
+func (l *LayeredPoolState) GetRollbackImage() (string, error) {
+	image, err := l.GetRequestedRollbackImage()
+	if err != nil || image == "" {
+		return image, err
+	}
+
+	builtFrom, ok := l.GetRetainedImageMachineConfigs()[image]
+	if !ok {
+		return "", fmt.Errorf("rendered MachineConfig of rollback image %s of MachineConfigPool %s is unknown", image, l.pool.Name)
+	}
+
+	if builtFrom != l.pool.Spec.Configuration.Name {
+		return "", fmt.Errorf("rollback image %s of MachineConfigPool %s was built from %s, not from the current rendered MachineConfig %s", image, l.pool.Name, builtFrom, l.pool.Spec.Configuration.Name)
+	}
+
+	return image, nil
+}
+
 // Determines if a given MachineConfigPool has an available OS image. Returns
 // false if the annotation is missing or set to an empty string.
 func (l *LayeredPoolState) HasOSImage() bool {
@@ -49,6 +129,18 @@ func (l *LayeredPoolState) HasOSImage() bool {
 	return ok && val != ""
 }
 
+// Determines if the MachineConfigPool is in validate-only mode, where images
+// are built but never rolled out.
+func (l *LayeredPoolState) IsValidateOnly() bool {
+	return l.pool.Annotations[BuildValidateOnlyAnnotationKey] == "true"
+}
+
+// Determines if the layers added on top of the base image are squashed into a
+// single layer.
+func (l *LayeredPoolState) IsSquashLayers() bool {
+	return l.pool.Annotations[SquashLayersAnnotationKey] == "true"
+}
+
 // Determines if an OS image build is a success.
 func (l *LayeredPoolState) IsBuildSuccess() bool {
 	return apihelpers.IsMachineConfigPoolConditionTrue(l.pool.Status.Conditions, mcfgv1.MachineConfigPoolBuildSuccess)
@@ -96,3 +188,23 @@ func (l *LayeredPoolState) IsNodeDegraded() bool {
 func (l *LayeredPoolState) IsRenderDegraded() bool {
 	return apihelpers.IsMachineConfigPoolConditionTrue(l.pool.Status.Conditions, mcfgv1.MachineConfigPoolRenderDegraded)
 }
+
+// Determines if a MachineConfigPool has any OS image build conditions,
+// regardless of their status. A pool which has opted out of layering should
+// not have any.
+func (l *LayeredPoolState) HasBuildConditions() bool {
+	condTypes := []mcfgv1.MachineConfigPoolConditionType{
+		mcfgv1.MachineConfigPoolBuildPending,
+		mcfgv1.MachineConfigPoolBuilding,
+		mcfgv1.MachineConfigPoolBuildSuccess,
+		mcfgv1.MachineConfigPoolBuildFailed,
+	}
+
+	for _, condType := range condTypes {
+		if apihelpers.GetMachineConfigPoolCondition(l.pool.Status, condType) != nil {
+			return true
+		}
+	}
+
+	return false
+}
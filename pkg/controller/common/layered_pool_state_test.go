@@ -6,6 +6,7 @@ import (
 	"github.com/davecgh/go-spew/spew"
 	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
 	"github.com/openshift/machine-config-operator/pkg/apihelpers"
+	"github.com/openshift/machine-config-operator/test/helpers"
 	"github.com/stretchr/testify/assert"
 	corev1 "k8s.io/api/core/v1"
 )
@@ -22,6 +23,7 @@ func TestLayeredPoolState(t *testing.T) {
 		isBuildPending bool
 		isBuilding     bool
 		isBuildFailure bool
+		isValidateOnly bool
 		buildCondition mcfgv1.MachineConfigPoolConditionType
 	}{
 		{
@@ -63,6 +65,15 @@ func TestLayeredPoolState(t *testing.T) {
 			buildCondition: mcfgv1.MachineConfigPoolBuildSuccess,
 			isBuildSuccess: true,
 		},
+		{
+			name:           "layered pool, with OS image, validate-only build success",
+			pool:           helpers.NewMachineConfigPoolBuilder("").WithImage(imageV1).WithAnnotations(map[string]string{BuildValidateOnlyAnnotationKey: "true"}).MachineConfigPool(),
+			isLayered:      true,
+			hasOSImage:     true,
+			buildCondition: mcfgv1.MachineConfigPoolBuildSuccess,
+			isBuildSuccess: true,
+			isValidateOnly: true,
+		},
 		{
 			name:           "layered pool, with OS image, build failed",
 			pool:           newLayeredMachineConfigPoolWithImage("", imageV1),
@@ -91,6 +102,94 @@ func TestLayeredPoolState(t *testing.T) {
 			assert.Equal(t, test.isBuildPending, lps.IsBuildPending(), "is build pending mismatch %s", spew.Sdump(test.pool.Status))
 			assert.Equal(t, test.isBuilding, lps.IsBuilding(), "is building mismatch %s", spew.Sdump(test.pool.Status))
 			assert.Equal(t, test.isBuildFailure, lps.IsBuildFailure(), "is build failure mismatch %s", spew.Sdump(test.pool.Status))
+			assert.Equal(t, test.isValidateOnly, lps.IsValidateOnly(), "is validate-only mismatch %s", spew.Sdump(test.pool.Annotations))
+			assert.Equal(t, test.buildCondition != "", lps.HasBuildConditions(), "has build conditions mismatch %s", spew.Sdump(test.pool.Status))
+		})
+	}
+}
+
+func TestLayeredPoolStateRollbackImage(t *testing.T) {
+	t.Parallel()
+
+	newest := "registry.host.com/org/repo@sha256:3333333333333333333333333333333333333333333333333333333333333333"
+	previous := "registry.host.com/org/repo@sha256:2222222222222222222222222222222222222222222222222222222222222222"
+	older := "registry.host.com/org/repo@sha256:1111111111111111111111111111111111111111111111111111111111111111"
+
+	// The newest two images were built from the current rendered
+	// MachineConfig, e.g. after a Containerfile change.
+	newPool := func(rollback string) *mcfgv1.MachineConfigPool {
+		annos := map[string]string{
+			RetainedImagePullspecsAnnotationKey:      newest + "," + previous + "," + older,
+			RetainedImageMachineConfigsAnnotationKey: newest + "=rendered-worker-1," + previous + "=rendered-worker-1," + older + "=rendered-worker-0",
+		}
+
+		if rollback != "" {
+			annos[RollbackImageAnnotationKey] = rollback
+		}
+
+		return helpers.NewMachineConfigPoolBuilder("worker").WithMachineConfig("rendered-worker-1").WithImage(newest).WithAnnotations(annos).MachineConfigPool()
+	}
+
+	tests := []struct {
+		name          string
+		rollback      string
+		expected      string
+		expectedImage string
+		errExpected   bool
+	}{
+		{
+			name:          "not rolled back",
+			expectedImage: newest,
+		},
+		{
+			name:          "rolled back by pullspec",
+			rollback:      previous,
+			expected:      previous,
+			expectedImage: previous,
+		},
+		{
+			name:          "rolled back by digest",
+			rollback:      "sha256:2222222222222222222222222222222222222222222222222222222222222222",
+			expected:      previous,
+			expectedImage: previous,
+		},
+		{
+			name:          "image was built from another rendered MachineConfig",
+			rollback:      older,
+			expectedImage: newest,
+			errExpected:   true,
+		},
+		{
+			name:          "image is not retained",
+			rollback:      "registry.host.com/org/repo@sha256:9999999999999999999999999999999999999999999999999999999999999999",
+			expectedImage: newest,
+			errExpected:   true,
+		},
+		{
+			name:          "same digest in another repository",
+			rollback:      "registry.host.com/org/other@sha256:2222222222222222222222222222222222222222222222222222222222222222",
+			expectedImage: newest,
+			errExpected:   true,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			lps := NewLayeredPoolState(newPool(test.rollback))
+
+			rollback, err := lps.GetRollbackImage()
+			if test.errExpected {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+
+			assert.Equal(t, test.expected, rollback)
+			assert.Equal(t, test.expectedImage, lps.GetOSImage())
+			assert.Equal(t, []string{newest, previous, older}, lps.GetRetainedImages())
 		})
 	}
 }
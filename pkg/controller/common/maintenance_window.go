@@ -0,0 +1,245 @@
+package common
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// The three-letter weekday names used in maintenance windows, in the order of
+// time.Weekday.
+var maintenanceWindowDays = []string{"Sun", "Mon", "Tue", "Wed", "Thu", "Fri", "Sat"}
+
+// MaintenanceWindow is a weekly recurring time window in UTC during which
+// updates which reboot nodes may be applied. The window wraps around midnight
+// when its end is before its start, in which case it belongs to the day it
+// opens on.
+type MaintenanceWindow struct {
+	days  [7]bool
+	start time.Duration
+	end   time.Duration
+}
+
+// Parses a weekday name such as "Mon".
+func parseMaintenanceWindowDay(val string) (time.Weekday, error) {
+	for i, day := range maintenanceWindowDays {
+		if strings.EqualFold(val, day) {
+			return time.Weekday(i), nil
+		}
+	}
+
+	return 0, fmt.Errorf("unknown day %q: must be one of %s", val, strings.Join(maintenanceWindowDays, ", "))
+}
+
+// Parses the days of a maintenance window, such as "*", "Sat,Sun" or
+// "Mon-Fri". Ranges may wrap around the end of the week, e.g. "Fri-Mon".
+func parseMaintenanceWindowDays(val string) ([7]bool, error) {
+	days := [7]bool{}
+
+	if val == "*" {
+		for i := range days {
+			days[i] = true
+		}
+		return days, nil
+	}
+
+	for _, item := range strings.Split(val, ",") {
+		firstVal, lastVal, isRange := strings.Cut(strings.TrimSpace(item), "-")
+
+		first, err := parseMaintenanceWindowDay(firstVal)
+		if err != nil {
+			return days, err
+		}
+
+		last := first
+		if isRange {
+			if last, err = parseMaintenanceWindowDay(lastVal); err != nil {
+				return days, err
+			}
+		}
+
+		for day := first; ; day = (day + 1) % 7 {
+			days[day] = true
+			if day == last {
+				break
+			}
+		}
+	}
+
+	return days, nil
+}
+
+// Parses a time of day such as "22:00" into the offset from midnight.
+func parseMaintenanceWindowTime(val string) (time.Duration, error) {
+	t, err := time.Parse("15:04", val)
+	if err != nil {
+		return 0, err
+	}
+
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// Parses a single maintenance window such as "Sat,Sun 00:00-06:00". Windows
+// without days open every day.
+func parseMaintenanceWindow(val string) (MaintenanceWindow, error) {
+	window := MaintenanceWindow{}
+
+	fields := strings.Fields(val)
+	switch len(fields) {
+	case 1:
+		fields = []string{"*", fields[0]}
+	case 2:
+	default:
+		return window, fmt.Errorf("must be of the form [DAYS ]HH:MM-HH:MM")
+	}
+
+	var err error
+	if window.days, err = parseMaintenanceWindowDays(fields[0]); err != nil {
+		return window, err
+	}
+
+	startVal, endVal, ok := strings.Cut(fields[1], "-")
+	if !ok {
+		return window, fmt.Errorf("must be of the form [DAYS ]HH:MM-HH:MM")
+	}
+
+	if window.start, err = parseMaintenanceWindowTime(startVal); err != nil {
+		return window, err
+	}
+
+	// Allow windows which last until the end of the day.
+	if endVal == "24:00" {
+		window.end = 24 * time.Hour
+	} else if window.end, err = parseMaintenanceWindowTime(endVal); err != nil {
+		return window, err
+	}
+
+	if window.start == window.end {
+		return window, fmt.Errorf("window must not be empty")
+	}
+
+	return window, nil
+}
+
+// ParseMaintenanceWindows parses the semicolon-separated maintenance windows
+// of MaintenanceWindowsAnnotationKey, e.g. "Mon-Fri 22:00-04:00; Sat,Sun
+// 00:00-24:00". An empty value has no windows.
+func ParseMaintenanceWindows(val string) ([]MaintenanceWindow, error) {
+	windows := []MaintenanceWindow{}
+
+	for _, item := range strings.Split(val, ";") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		window, err := parseMaintenanceWindow(item)
+		if err != nil {
+			return nil, fmt.Errorf("invalid maintenance window %q: %w", item, err)
+		}
+
+		windows = append(windows, window)
+	}
+
+	return windows, nil
+}
+
+// FormatMaintenanceWindows formats the maintenance windows the way
+// ParseMaintenanceWindows parses them, e.g. "Mon,Tue,Wed,Thu,Fri 22:00-04:00".
+func FormatMaintenanceWindows(windows []MaintenanceWindow) string {
+	out := []string{}
+	for _, window := range windows {
+		out = append(out, window.String())
+	}
+
+	return strings.Join(out, "; ")
+}
+
+// String returns the window in the format of MaintenanceWindowsAnnotationKey.
+func (w MaintenanceWindow) String() string {
+	formatTime := func(d time.Duration) string {
+		return fmt.Sprintf("%02d:%02d", int(d.Hours()), int(d.Minutes())%60)
+	}
+
+	days := []string{}
+	for i, ok := range w.days {
+		if ok {
+			days = append(days, maintenanceWindowDays[i])
+		}
+	}
+
+	daysVal := strings.Join(days, ",")
+	if len(days) == len(w.days) {
+		daysVal = "*"
+	}
+
+	return fmt.Sprintf("%s %s-%s", daysVal, formatTime(w.start), formatTime(w.end))
+}
+
+// Returns the UTC midnight at the start of the day of t.
+func maintenanceWindowMidnight(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// Contains returns whether t is within the window.
+func (w MaintenanceWindow) Contains(t time.Time) bool {
+	midnight := maintenanceWindowMidnight(t)
+	now := t.Sub(midnight)
+	day := midnight.Weekday()
+
+	if w.start < w.end {
+		return w.days[day] && now >= w.start && now < w.end
+	}
+
+	// The window opened the day before and is still open.
+	return (w.days[day] && now >= w.start) || (w.days[(day+6)%7] && now < w.end)
+}
+
+// untilOpen returns how long it is from t until the window next opens.
+func (w MaintenanceWindow) untilOpen(t time.Time) time.Duration {
+	midnight := maintenanceWindowMidnight(t)
+
+	for i := 0; i <= 7; i++ {
+		opens := midnight.AddDate(0, 0, i).Add(w.start)
+		if opens.After(t) && w.days[opens.Weekday()] {
+			return opens.Sub(t)
+		}
+	}
+
+	// Not reached, since windows are open on at least one day.
+	return 7 * 24 * time.Hour
+}
+
+// InMaintenanceWindow returns whether t is within any of the maintenance
+// windows. Without windows, updates may be applied at any time.
+func InMaintenanceWindow(windows []MaintenanceWindow, t time.Time) bool {
+	if len(windows) == 0 {
+		return true
+	}
+
+	for _, window := range windows {
+		if window.Contains(t) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// UntilMaintenanceWindow returns how long it is from t until any of the
+// maintenance windows opens, or zero if one is open already.
+func UntilMaintenanceWindow(windows []MaintenanceWindow, t time.Time) time.Duration {
+	if InMaintenanceWindow(windows, t) {
+		return 0
+	}
+
+	var until time.Duration
+	for _, window := range windows {
+		if d := window.untilOpen(t); until == 0 || d < until {
+			until = d
+		}
+	}
+
+	return until
+}
@@ -0,0 +1,121 @@
+package common
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseMaintenanceWindows(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		val         string
+		expected    string
+		errExpected bool
+	}{
+		{val: "", expected: ""},
+		{val: "22:00-04:00", expected: "* 22:00-04:00"},
+		{val: "Mon-Fri 22:00-04:00; sat,SUN 00:00-24:00", expected: "Mon,Tue,Wed,Thu,Fri 22:00-04:00; Sun,Sat 00:00-24:00"},
+		{val: "Fri-Mon 01:30-02:30", expected: "Sun,Mon,Fri,Sat 01:30-02:30"},
+		{val: "Mon-Sun 01:00-02:00", expected: "* 01:00-02:00"},
+		{val: "Mon 22:00", errExpected: true},
+		{val: "Someday 22:00-04:00", errExpected: true},
+		{val: "Mon 25:00-04:00", errExpected: true},
+		{val: "Mon 04:00-04:00", errExpected: true},
+		{val: "Mon Tue 04:00-05:00", errExpected: true},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+		t.Run(testCase.val, func(t *testing.T) {
+			t.Parallel()
+
+			windows, err := ParseMaintenanceWindows(testCase.val)
+			if testCase.errExpected {
+				assert.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, testCase.expected, FormatMaintenanceWindows(windows))
+
+			// The formatted windows parse back into the same windows.
+			reparsed, err := ParseMaintenanceWindows(FormatMaintenanceWindows(windows))
+			require.NoError(t, err)
+			assert.Equal(t, windows, reparsed)
+		})
+	}
+}
+
+func TestMaintenanceWindows(t *testing.T) {
+	t.Parallel()
+
+	// Weeknights from 22:00 to 04:00 the next morning and all of Sunday.
+	windows, err := ParseMaintenanceWindows("Mon-Fri 22:00-04:00; Sun 00:00-24:00")
+	require.NoError(t, err)
+
+	// 2024-01-01 is a Monday.
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2024, time.January, day, hour, minute, 0, 0, time.UTC)
+	}
+
+	testCases := []struct {
+		name  string
+		t     time.Time
+		until time.Duration
+	}{
+		{
+			name: "Monday night",
+			t:    at(1, 23, 0),
+		},
+		{
+			name: "early Tuesday morning",
+			t:    at(2, 3, 59),
+		},
+		{
+			name:  "Tuesday afternoon",
+			t:     at(2, 14, 0),
+			until: 8 * time.Hour,
+		},
+		{
+			name: "early Saturday morning after Friday night",
+			t:    at(6, 2, 0),
+		},
+		{
+			name:  "Saturday afternoon",
+			t:     at(6, 14, 0),
+			until: 10 * time.Hour,
+		},
+		{
+			name: "Sunday",
+			t:    at(7, 12, 0),
+		},
+		{
+			name:  "early Monday morning after Sunday",
+			t:     at(8, 2, 0),
+			until: 20 * time.Hour,
+		},
+		{
+			name:  "in another time zone",
+			t:     at(2, 14, 0).In(time.FixedZone("UTC+10", 10*60*60)),
+			until: 8 * time.Hour,
+		},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, testCase.until == 0, InMaintenanceWindow(windows, testCase.t))
+			assert.Equal(t, testCase.until, UntilMaintenanceWindow(windows, testCase.t))
+		})
+	}
+
+	// Without windows, updates may be applied at any time.
+	assert.True(t, InMaintenanceWindow(nil, at(2, 14, 0)))
+	assert.Zero(t, UntilMaintenanceWindow(nil, at(2, 14, 0)))
+}
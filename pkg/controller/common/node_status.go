@@ -0,0 +1,132 @@
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	daemonconsts "github.com/openshift/machine-config-operator/pkg/daemon/constants"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+)
+
+// The informational annotations which the MCD reports in the
+// NodeStatusAnnotationKey annotation instead. Nodes whose MCD predates it may
+// still have them set separately until the MCD is updated.
+var nodeStatusAnnotationKeys = []string{
+	daemonconsts.LastBootTimeAnnotationKey,
+	daemonconsts.RpmOstreeRecoveryAnnotationKey,
+	daemonconsts.ComponentVersionsAnnotationKey,
+	daemonconsts.FreeDiskAnnotationKey,
+}
+
+// Gets the name under which the value of the given annotation is kept in the
+// NodeStatusAnnotationKey annotation, e.g. "lastBootTime".
+func nodeStatusFieldName(key string) string {
+	return strings.TrimPrefix(key, "machineconfiguration.openshift.io/")
+}
+
+// Parses the NodeStatusAnnotationKey annotation of the given node. Nodes
+// without it have an empty status.
+func parseNodeStatus(node *corev1.Node) (map[string]json.RawMessage, error) {
+	status := map[string]json.RawMessage{}
+
+	val, ok := node.Annotations[daemonconsts.NodeStatusAnnotationKey]
+	if !ok || val == "" {
+		return status, nil
+	}
+
+	if err := json.Unmarshal([]byte(val), &status); err != nil {
+		return nil, fmt.Errorf("could not parse %s annotation: %w", daemonconsts.NodeStatusAnnotationKey, err)
+	}
+
+	return status, nil
+}
+
+// JSON objects and arrays, such as the component versions or the kernel
+// arguments, are embedded as they are so that the annotation stays readable;
+// anything else is kept as a string.
+func encodeNodeStatusValue(val string) (json.RawMessage, error) {
+	trimmed := strings.TrimSpace(val)
+	if (strings.HasPrefix(trimmed, "{") || strings.HasPrefix(trimmed, "[")) && json.Valid([]byte(val)) {
+		return json.RawMessage(val), nil
+	}
+
+	return json.Marshal(val)
+}
+
+func decodeNodeStatusValue(raw json.RawMessage) string {
+	var val string
+	if err := json.Unmarshal(raw, &val); err == nil {
+		return val
+	}
+
+	return string(raw)
+}
+
+// GetNodeStatus gets the value of the given informational annotation (e.g.
+// LastBootTimeAnnotationKey) from the NodeStatusAnnotationKey annotation of
+// the node. Nodes whose MCD has not moved the value there yet fall back to the
+// annotation itself.
+func GetNodeStatus(node *corev1.Node, key string) (string, bool) {
+	status, err := parseNodeStatus(node)
+	if err != nil {
+		klog.V(4).Infof("Could not get node status of node %s: %v", node.Name, err)
+	} else if raw, ok := status[nodeStatusFieldName(key)]; ok {
+		return decodeNodeStatusValue(raw), true
+	}
+
+	val, ok := node.Annotations[key]
+	return val, ok
+}
+
+// SetNodeStatus sets the values of the given informational annotations (e.g.
+// LastBootTimeAnnotationKey) in the NodeStatusAnnotationKey annotation of the
+// node. The values of those annotations which are still set separately are
+// moved into it first, and the separate annotations are removed.
+func SetNodeStatus(node *corev1.Node, values map[string]string) error {
+	status, err := parseNodeStatus(node)
+	if err != nil {
+		// The annotation is rebuilt from the separate annotations and the new
+		// values rather than wedging every later update.
+		klog.Warningf("Replacing node status of node %s: %v", node.Name, err)
+		status = map[string]json.RawMessage{}
+	}
+
+	migrated := map[string]string{}
+	for _, key := range nodeStatusAnnotationKeys {
+		if val, ok := node.Annotations[key]; ok {
+			if _, ok := status[nodeStatusFieldName(key)]; !ok {
+				migrated[key] = val
+			}
+		}
+	}
+
+	for _, values := range []map[string]string{migrated, values} {
+		for key, val := range values {
+			raw, err := encodeNodeStatusValue(val)
+			if err != nil {
+				return fmt.Errorf("could not encode %s: %w", key, err)
+			}
+
+			status[nodeStatusFieldName(key)] = raw
+		}
+	}
+
+	out, err := json.Marshal(status)
+	if err != nil {
+		return err
+	}
+
+	if node.Annotations == nil {
+		node.Annotations = map[string]string{}
+	}
+
+	for _, key := range nodeStatusAnnotationKeys {
+		delete(node.Annotations, key)
+	}
+
+	node.Annotations[daemonconsts.NodeStatusAnnotationKey] = string(out)
+
+	return nil
+}
@@ -0,0 +1,171 @@
+package common
+
+import (
+	"testing"
+
+	daemonconsts "github.com/openshift/machine-config-operator/pkg/daemon/constants"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestGetNodeStatus(t *testing.T) {
+	t.Parallel()
+
+	newNode := func(annos map[string]string) *corev1.Node {
+		return &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node", Annotations: annos}}
+	}
+
+	testCases := []struct {
+		name       string
+		annos      map[string]string
+		expected   string
+		expectedOK bool
+		key        string
+	}{
+		{
+			name: "Not reported",
+			key:  daemonconsts.LastBootTimeAnnotationKey,
+		},
+		{
+			name: "Separate annotation",
+			annos: map[string]string{
+				daemonconsts.LastBootTimeAnnotationKey: "2023-11-02T12:34:56Z",
+			},
+			key:        daemonconsts.LastBootTimeAnnotationKey,
+			expected:   "2023-11-02T12:34:56Z",
+			expectedOK: true,
+		},
+		{
+			name: "Node status takes precedence",
+			annos: map[string]string{
+				daemonconsts.LastBootTimeAnnotationKey: "2023-11-02T12:34:56Z",
+				daemonconsts.NodeStatusAnnotationKey:   `{"lastBootTime":"2023-11-03T00:00:00Z"}`,
+			},
+			key:        daemonconsts.LastBootTimeAnnotationKey,
+			expected:   "2023-11-03T00:00:00Z",
+			expectedOK: true,
+		},
+		{
+			name: "JSON object",
+			annos: map[string]string{
+				daemonconsts.NodeStatusAnnotationKey: `{"freeDisk":{"/sysroot":1,"/var":2}}`,
+			},
+			key:        daemonconsts.FreeDiskAnnotationKey,
+			expected:   `{"/sysroot":1,"/var":2}`,
+			expectedOK: true,
+		},
+		{
+			name: "Falls back when missing from node status",
+			annos: map[string]string{
+				daemonconsts.FreeDiskAnnotationKey:   `{"/sysroot":1,"/var":2}`,
+				daemonconsts.NodeStatusAnnotationKey: `{"lastBootTime":"2023-11-03T00:00:00Z"}`,
+			},
+			key:        daemonconsts.FreeDiskAnnotationKey,
+			expected:   `{"/sysroot":1,"/var":2}`,
+			expectedOK: true,
+		},
+		{
+			name: "Falls back when node status is invalid",
+			annos: map[string]string{
+				daemonconsts.LastBootTimeAnnotationKey: "2023-11-02T12:34:56Z",
+				daemonconsts.NodeStatusAnnotationKey:   `{"lastBootTime":`,
+			},
+			key:        daemonconsts.LastBootTimeAnnotationKey,
+			expected:   "2023-11-02T12:34:56Z",
+			expectedOK: true,
+		},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			val, ok := GetNodeStatus(newNode(testCase.annos), testCase.key)
+			assert.Equal(t, testCase.expectedOK, ok)
+			assert.Equal(t, testCase.expected, val)
+		})
+	}
+}
+
+func TestSetNodeStatus(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Migrates separate annotations", func(t *testing.T) {
+		t.Parallel()
+
+		node := &corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "node",
+				Annotations: map[string]string{
+					daemonconsts.CurrentMachineConfigAnnotationKey: "rendered-worker-1",
+					daemonconsts.LastBootTimeAnnotationKey:         "2023-11-02T12:34:56Z",
+					daemonconsts.ComponentVersionsAnnotationKey:    `{"kubelet":"v1.28.3"}`,
+				},
+			},
+		}
+
+		require.NoError(t, SetNodeStatus(node, map[string]string{
+			daemonconsts.FreeDiskAnnotationKey: `{"/sysroot": 1, "/var": 2}`,
+		}))
+
+		assert.Equal(t, map[string]string{
+			daemonconsts.CurrentMachineConfigAnnotationKey: "rendered-worker-1",
+			daemonconsts.NodeStatusAnnotationKey:           `{"componentVersions":{"kubelet":"v1.28.3"},"freeDisk":{"/sysroot":1,"/var":2},"lastBootTime":"2023-11-02T12:34:56Z"}`,
+		}, node.Annotations)
+
+		// Values in the node status are replaced.
+		require.NoError(t, SetNodeStatus(node, map[string]string{
+			daemonconsts.LastBootTimeAnnotationKey: "2023-11-03T00:00:00Z",
+		}))
+
+		val, ok := GetNodeStatus(node, daemonconsts.LastBootTimeAnnotationKey)
+		assert.True(t, ok)
+		assert.Equal(t, "2023-11-03T00:00:00Z", val)
+
+		val, ok = GetNodeStatus(node, daemonconsts.FreeDiskAnnotationKey)
+		assert.True(t, ok)
+		assert.Equal(t, `{"/sysroot":1,"/var":2}`, val)
+	})
+
+	t.Run("Node status takes precedence over separate annotations", func(t *testing.T) {
+		t.Parallel()
+
+		node := &corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "node",
+				Annotations: map[string]string{
+					daemonconsts.LastBootTimeAnnotationKey: "2023-11-02T12:34:56Z",
+					daemonconsts.NodeStatusAnnotationKey:   `{"lastBootTime":"2023-11-03T00:00:00Z"}`,
+				},
+			},
+		}
+
+		require.NoError(t, SetNodeStatus(node, map[string]string{}))
+
+		assert.Equal(t, map[string]string{
+			daemonconsts.NodeStatusAnnotationKey: `{"lastBootTime":"2023-11-03T00:00:00Z"}`,
+		}, node.Annotations)
+	})
+
+	t.Run("Replaces invalid node status", func(t *testing.T) {
+		t.Parallel()
+
+		node := &corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "node",
+				Annotations: map[string]string{
+					daemonconsts.NodeStatusAnnotationKey: `{"lastBootTime":`,
+				},
+			},
+		}
+
+		require.NoError(t, SetNodeStatus(node, map[string]string{
+			daemonconsts.LastBootTimeAnnotationKey: "2023-11-03T00:00:00Z",
+		}))
+
+		assert.Equal(t, `{"lastBootTime":"2023-11-03T00:00:00Z"}`, node.Annotations[daemonconsts.NodeStatusAnnotationKey])
+	})
+}
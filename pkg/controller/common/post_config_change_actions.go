@@ -0,0 +1,475 @@
+package common
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/containers/image/v5/pkg/sysregistriesv2"
+	ign3types "github.com/coreos/ignition/v2/config/v3_4/types"
+	daemonconsts "github.com/openshift/machine-config-operator/pkg/daemon/constants"
+	"k8s.io/klog/v2"
+)
+
+// These are the actions for a node to take after applying config changes. They
+// are shared by the MCD, which performs them, and the node controller, which
+// needs to know whether an update drains or reboots nodes.
+const (
+	// "None" means no special action needs to be taken. This happens for
+	// example when SSH keys or the pull secret are changed.
+	PostConfigChangeActionNone = "none"
+	// The "reload crio" action runs "systemctl reload crio".
+	PostConfigChangeActionReloadCrio = "reload crio"
+	// The "restart crio" action runs "systemctl restart crio", for config which
+	// crio only reads on startup.
+	PostConfigChangeActionRestartCrio = "restart crio"
+	// The "reload NetworkManager" action runs "systemctl reload NetworkManager",
+	// which may be combined with the crio actions.
+	PostConfigChangeActionReloadNetworkManager = "reload NetworkManager"
+	// The "restart chronyd" action runs "systemctl restart chronyd", which may
+	// be combined with the crio actions.
+	PostConfigChangeActionRestartChronyd = "restart chronyd"
+	// The "restart kubelet" action runs "systemctl daemon-reload" and
+	// "systemctl restart kubelet", which may be combined with the crio actions.
+	// Running pods are not affected by the restart.
+	PostConfigChangeActionRestartKubelet = "restart kubelet"
+	// The "reexec systemd" action runs "systemctl daemon-reexec" for files
+	// covered by the disruption policy.
+	PostConfigChangeActionDaemonReexec = "reexec systemd"
+	// The "run <unit>" actions run "systemctl daemon-reload" and "systemctl
+	// restart <unit>" for files covered by the disruption policy.
+	PostConfigChangeActionRunUnitPrefix = "run "
+	// Rebooting is still the default scenario for any other change.
+	PostConfigChangeActionReboot = "reboot"
+)
+
+// Files whose changes are applied without draining or rebooting the node.
+const (
+	KubeletCABundlePath            = "/etc/kubernetes/kubelet-ca.crt"
+	InternalRegistryPullSecretPath = "/etc/mco/internal-registry-pull-secret.json"
+	KubeletPullSecretPath          = "/var/lib/kubelet/config.json"
+	// GPGNoRebootPath is the path MCO expects will contain GPG key updates.
+	// MCO will attempt to only reload crio for changes to this path. Note that
+	// other files added to the parent directory will not be handled specially.
+	GPGNoRebootPath = "/etc/machine-config-daemon/no-reboot/containers-gpg.pub"
+	ChronyConfPath  = "/etc/chrony.conf"
+	// KubeletLogLevelConfPath is the drop-in which sets the kubelet log level.
+	// It is written as a file rather than as a unit drop-in, so the MCD reloads
+	// the systemd units itself before restarting the kubelet.
+	KubeletLogLevelConfPath = "/etc/systemd/system/kubelet.service.d/20-logging.conf"
+)
+
+// PostConfigChangeServiceActions are the actions which reload or restart a
+// single service, in the order in which they are performed. They are
+// performed after, and in addition to, anything done for crio.
+var PostConfigChangeServiceActions = []string{
+	PostConfigChangeActionReloadNetworkManager,
+	PostConfigChangeActionRestartChronyd,
+	PostConfigChangeActionRestartKubelet,
+}
+
+// The service action which applies changes to each file.
+var postConfigChangeServiceActionPaths = map[string]string{
+	daemonconsts.NetworkManagerDNSConfPath: PostConfigChangeActionReloadNetworkManager,
+	ChronyConfPath:                         PostConfigChangeActionRestartChronyd,
+	KubeletLogLevelConfPath:                PostConfigChangeActionRestartKubelet,
+}
+
+// Options in storage.conf which only affect images pulled from now on, so that
+// changing them only requires restarting crio.
+var liveContainerStorageOptions = []string{"pull_options", "additionalimagestores"}
+
+// GetSSHDirOfAuthorizedKeysPath returns the .ssh directory of an authorized
+// keys file, i.e. ~/.ssh/authorized_keys or a fragment in
+// ~/.ssh/authorized_keys.d, and false for any other path.
+func GetSSHDirOfAuthorizedKeysPath(path string) (string, bool) {
+	dir, name := filepath.Dir(path), filepath.Base(path)
+
+	if name == "authorized_keys" && filepath.Base(dir) == ".ssh" {
+		return dir, true
+	}
+
+	if filepath.Base(dir) == "authorized_keys.d" && filepath.Base(filepath.Dir(dir)) == ".ssh" {
+		return filepath.Dir(dir), true
+	}
+
+	return "", false
+}
+
+// IsAuthorizedKeysPath returns whether the path is an authorized keys file,
+// which sshd reads on each login so that changes to it apply without a
+// reboot.
+func IsAuthorizedKeysPath(path string) bool {
+	_, ok := GetSSHDirOfAuthorizedKeysPath(path)
+	return ok
+}
+
+// getDisruptionPolicyPostConfigChangeActions returns the post config change
+// actions which apply the policy actions. Restarting crio or the kubelet maps
+// onto the existing actions for them.
+func getDisruptionPolicyPostConfigChangeActions(policyActions []DisruptionPolicyAction) []string {
+	actions := []string{}
+	for _, policyAction := range policyActions {
+		switch policyAction.Type {
+		case DisruptionPolicyActionRestartCrio:
+			actions = append(actions, PostConfigChangeActionRestartCrio)
+		case DisruptionPolicyActionRestartKubelet:
+			actions = append(actions, PostConfigChangeActionRestartKubelet)
+		case DisruptionPolicyActionDaemonReexec:
+			actions = append(actions, PostConfigChangeActionDaemonReexec)
+		case DisruptionPolicyActionRunUnit:
+			actions = append(actions, PostConfigChangeActionRunUnitPrefix+policyAction.Unit)
+		}
+	}
+	return actions
+}
+
+// CalculatePostConfigChangeActions returns the actions which apply the changes
+// to the files. Changes to files which would otherwise reboot the node are
+// applied with the actions of the disruption policy if it covers them.
+// Whether storage.conf changes can be applied by restarting crio depends on
+// what changed, see CheckContainerStorageConfChanges.
+func CalculatePostConfigChangeActions(diffFileSet []string, policy *DisruptionPolicy) (actions []string) {
+	filesPostConfigChangeActionNone := []string{
+		KubeletCABundlePath,
+		InternalRegistryPullSecretPath,
+		KubeletPullSecretPath,
+	}
+	filesPostConfigChangeActionReloadCrio := []string{
+		daemonconsts.ContainerRegistryConfPath,
+		GPGNoRebootPath,
+		"/etc/containers/policy.json",
+	}
+	filesPostConfigChangeActionRestartCrio := []string{
+		daemonconsts.ContainerStorageConfPath,
+	}
+
+	serviceActions := map[string]bool{}
+	// The actions only run for the disruption policy. systemd is re-executed before the units are run in the order
+	// they are first asked for
+	policyActions := []string{}
+	actions = []string{PostConfigChangeActionNone}
+	for _, path := range diffFileSet {
+		if InSlice(path, filesPostConfigChangeActionNone) || IsAuthorizedKeysPath(path) {
+			// sshd reads authorized keys on each login
+			continue
+		} else if serviceAction, ok := postConfigChangeServiceActionPaths[path]; ok {
+			serviceActions[serviceAction] = true
+		} else if InSlice(path, filesPostConfigChangeActionRestartCrio) {
+			// a restart also picks up config that a reload would
+			actions = []string{PostConfigChangeActionRestartCrio}
+		} else if InSlice(path, filesPostConfigChangeActionReloadCrio) {
+			if !InSlice(PostConfigChangeActionRestartCrio, actions) {
+				actions = []string{PostConfigChangeActionReloadCrio}
+			}
+		} else if fileActions, ok := policy.GetActions(path); ok {
+			for _, action := range getDisruptionPolicyPostConfigChangeActions(fileActions) {
+				switch {
+				case action == PostConfigChangeActionRestartCrio:
+					actions = []string{PostConfigChangeActionRestartCrio}
+				case action == PostConfigChangeActionRestartKubelet:
+					serviceActions[action] = true
+				case InSlice(action, policyActions):
+				case action == PostConfigChangeActionDaemonReexec:
+					policyActions = append([]string{action}, policyActions...)
+				default:
+					policyActions = append(policyActions, action)
+				}
+			}
+		} else {
+			actions = []string{PostConfigChangeActionReboot}
+			return
+		}
+	}
+
+	// Services are reloaded or restarted in addition to anything done for crio,
+	// and the disruption policy actions after them
+	if len(serviceActions) != 0 || len(policyActions) != 0 {
+		if InSlice(PostConfigChangeActionNone, actions) {
+			actions = []string{}
+		}
+		for _, serviceAction := range PostConfigChangeServiceActions {
+			if serviceActions[serviceAction] {
+				actions = append(actions, serviceAction)
+			}
+		}
+		actions = append(actions, policyActions...)
+	}
+	return
+}
+
+// CheckContainerStorageConfChanges falls back to rebooting the node for
+// storage.conf changes which affect existing images and containers, such as
+// the driver, its mount options or the image store.
+func CheckContainerStorageConfChanges(actions, diffFileSet []string, oldIgnConfig, newIgnConfig ign3types.Config) ([]string, error) {
+	if !InSlice(PostConfigChangeActionRestartCrio, actions) || !InSlice(daemonconsts.ContainerStorageConfPath, diffFileSet) {
+		return actions, nil
+	}
+
+	oldData, err := GetIgnitionFileDataByPath(&oldIgnConfig, daemonconsts.ContainerStorageConfPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed decoding Data URL scheme string: %w", err)
+	}
+
+	newData, err := GetIgnitionFileDataByPath(&newIgnConfig, daemonconsts.ContainerStorageConfPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed decoding Data URL scheme string: %w", err)
+	}
+
+	// Adding or removing the file entirely may change anything
+	if oldData == nil || newData == nil {
+		return []string{PostConfigChangeActionReboot}, nil
+	}
+
+	oldConf := map[string]interface{}{}
+	if _, err := toml.Decode(string(oldData), &oldConf); err != nil {
+		return nil, fmt.Errorf("failed decoding TOML content from file %s: %w", daemonconsts.ContainerStorageConfPath, err)
+	}
+
+	newConf := map[string]interface{}{}
+	if _, err := toml.Decode(string(newData), &newConf); err != nil {
+		return nil, fmt.Errorf("failed decoding TOML content from file %s: %w", daemonconsts.ContainerStorageConfPath, err)
+	}
+
+	for _, conf := range []map[string]interface{}{oldConf, newConf} {
+		storage, ok := conf["storage"].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		options, ok := storage["options"].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		for _, option := range liveContainerStorageOptions {
+			delete(options, option)
+		}
+	}
+
+	if !reflect.DeepEqual(oldConf, newConf) {
+		klog.Infof("%s: changes affect existing images and containers, rebooting", daemonconsts.ContainerStorageConfPath)
+		return []string{PostConfigChangeActionReboot}, nil
+	}
+
+	return actions, nil
+}
+
+// hasPostConfigChangeServiceAction returns whether any of the given actions is a service action.
+func hasPostConfigChangeServiceAction(actions []string) bool {
+	for _, serviceAction := range PostConfigChangeServiceActions {
+		if InSlice(serviceAction, actions) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasDisruptionPolicyAction returns whether any of the given actions only
+// exists for the disruption policy.
+func hasDisruptionPolicyAction(actions []string) bool {
+	for _, action := range actions {
+		if action == PostConfigChangeActionDaemonReexec || strings.HasPrefix(action, PostConfigChangeActionRunUnitPrefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsDrainRequired determines whether node drain is required or not to apply config changes.
+func IsDrainRequired(actions, diffFileSet []string, oldIgnConfig, newIgnConfig ign3types.Config) (bool, error) {
+	if InSlice(PostConfigChangeActionReboot, actions) {
+		// Node is going to reboot, we definitely want to perform drain
+		return true, nil
+	} else if InSlice(PostConfigChangeActionRestartCrio, actions) {
+		// Only storage.conf changes which do not affect existing images and
+		// containers restart crio, and running containers survive the restart.
+		return false, nil
+	} else if InSlice(PostConfigChangeActionReloadCrio, actions) {
+		// Drain may or may not be necessary in case of container registry config changes.
+		if InSlice(daemonconsts.ContainerRegistryConfPath, diffFileSet) {
+			isSafe, err := isSafeContainerRegistryConfChanges(oldIgnConfig, newIgnConfig)
+			if err != nil {
+				return false, err
+			}
+			return !isSafe, nil
+		}
+		return false, nil
+	} else if hasPostConfigChangeServiceAction(actions) {
+		// Reloading NetworkManager only applies its DNS configuration, and
+		// restarting chronyd or the kubelet does not affect running pods.
+		return false, nil
+	} else if hasDisruptionPolicyAction(actions) {
+		// Admins declared the changes safe to apply without a reboot in the
+		// disruption policy of the pool.
+		return false, nil
+	} else if InSlice(PostConfigChangeActionNone, actions) {
+		return false, nil
+	}
+	// For any unhandled cases, default to drain
+	return true, nil
+}
+
+// isSafeContainerRegistryConfChanges looks inside old and new versions of registries.conf file.
+// It compares the content and determines whether changes made are safe or not. This will
+// help MCD to decide whether we can skip node drain for applied changes into container
+// registry.
+// Currently, we consider following container registry config changes as safe to skip node drain:
+// 1. A new mirror that has 'pull-from-mirror=digest-only' is added
+// 2. A new registry has been added that has all mirrors with 'pull-from-mirror=digest-only'
+// See https://bugzilla.redhat.com/show_bug.cgi?id=1943315
+//
+//nolint:gocyclo
+func isSafeContainerRegistryConfChanges(oldIgnConfig, newIgnConfig ign3types.Config) (bool, error) {
+	// /etc/containers/registries.conf contains config in toml format. Parse the file
+	oldData, err := GetIgnitionFileDataByPath(&oldIgnConfig, daemonconsts.ContainerRegistryConfPath)
+	if err != nil {
+		return false, fmt.Errorf("failed decoding Data URL scheme string: %w", err)
+	}
+
+	newData, err := GetIgnitionFileDataByPath(&newIgnConfig, daemonconsts.ContainerRegistryConfPath)
+	if err != nil {
+		return false, fmt.Errorf("failed decoding Data URL scheme string %w", err)
+	}
+
+	tomlConfOldReg := sysregistriesv2.V2RegistriesConf{}
+	if _, err := toml.Decode(string(oldData), &tomlConfOldReg); err != nil {
+		return false, fmt.Errorf("failed decoding TOML content from file %s: %w", daemonconsts.ContainerRegistryConfPath, err)
+	}
+
+	tomlConfNewReg := sysregistriesv2.V2RegistriesConf{}
+	if _, err := toml.Decode(string(newData), &tomlConfNewReg); err != nil {
+		return false, fmt.Errorf("failed decoding TOML content from file %s: %w", daemonconsts.ContainerRegistryConfPath, err)
+	}
+
+	// Ensure that any unqualified-search-registries has not been deleted
+	if len(tomlConfOldReg.UnqualifiedSearchRegistries) > len(tomlConfNewReg.UnqualifiedSearchRegistries) {
+		return false, nil
+	}
+	for i, regURL := range tomlConfOldReg.UnqualifiedSearchRegistries {
+		// Order of UnqualifiedSearchRegistries matters since image lookup occurs in order
+		if tomlConfNewReg.UnqualifiedSearchRegistries[i] != regURL {
+			return false, nil
+		}
+	}
+
+	oldRegHashMap := make(map[string]sysregistriesv2.Registry)
+	for _, reg := range tomlConfOldReg.Registries {
+		scope := reg.Location
+		if reg.Prefix != "" {
+			scope = reg.Prefix
+		}
+		oldRegHashMap[scope] = reg
+	}
+
+	newRegHashMap := make(map[string]sysregistriesv2.Registry)
+	for _, reg := range tomlConfNewReg.Registries {
+		scope := reg.Location
+		if reg.Prefix != "" {
+			scope = reg.Prefix
+		}
+		newRegHashMap[scope] = reg
+	}
+
+	// Check for removed registry
+	for regLoc := range oldRegHashMap {
+		_, ok := newRegHashMap[regLoc]
+		if !ok {
+			klog.Infof("%s: registry %s has been removed", daemonconsts.ContainerRegistryConfPath, regLoc)
+			return false, nil
+		}
+	}
+
+	// Check for modified registry
+	for regLoc, newReg := range newRegHashMap {
+		oldReg, ok := oldRegHashMap[regLoc]
+		if ok {
+			// Registry is available in both old and new config.
+			if !reflect.DeepEqual(oldReg, newReg) {
+				// Registry has been changed in the new config.
+				// Check that changes made are safe or not.
+				if oldReg.Prefix != newReg.Prefix {
+					klog.Infof("%s: prefix value for registry %s has changed from %s to %s",
+						daemonconsts.ContainerRegistryConfPath, regLoc, oldReg.Prefix, newReg.Prefix)
+					return false, nil
+				}
+				if oldReg.Location != newReg.Location {
+					klog.Infof("%s: location value for registry %s has changed from %s to %s",
+						daemonconsts.ContainerRegistryConfPath, regLoc, oldReg.Location, newReg.Location)
+					return false, nil
+				}
+				if oldReg.Blocked != newReg.Blocked {
+					klog.Infof("%s: blocked value for registry %s has changed from %t to %t",
+						daemonconsts.ContainerRegistryConfPath, regLoc, oldReg.Blocked, newReg.Blocked)
+					return false, nil
+				}
+				if oldReg.Insecure != newReg.Insecure {
+					klog.Infof("%s: insecure value for registry %s has changed from %t to %t",
+						daemonconsts.ContainerRegistryConfPath, regLoc, oldReg.Insecure, newReg.Insecure)
+					return false, nil
+				}
+
+				// Ensure that all the old mirrors are present
+				for _, m := range oldReg.Mirrors {
+					if found, _ := searchRegistryMirror(m.Location, newReg.Mirrors); !found {
+						klog.Infof("%s: mirror %s has been removed in registry %s",
+							daemonconsts.ContainerRegistryConfPath, m.Location, regLoc)
+						return false, nil
+					}
+				}
+				for _, m := range newReg.Mirrors {
+					// Ensure that any change to current does not unset pull-from-mirror="digest-only"
+					if found, oldMirror := searchRegistryMirror(m.Location, oldReg.Mirrors); found {
+						if m.PullFromMirror != oldMirror.PullFromMirror && m.PullFromMirror != sysregistriesv2.MirrorByDigestOnly {
+							klog.Infof("%s: pull-from-mirror value for mirror %s has changed from %s to %s ",
+								daemonconsts.ContainerRegistryConfPath, m.Location, oldMirror.PullFromMirror, m.PullFromMirror)
+							return false, nil
+						}
+					}
+					// Ensure that any added mirror has set pull-from-mirror="digest-only"
+					if found, _ := searchRegistryMirror(m.Location, oldReg.Mirrors); !found {
+						if m.PullFromMirror != sysregistriesv2.MirrorByDigestOnly && !newReg.MirrorByDigestOnly {
+							klog.Infof("%s: mirror %s has been added in registry %s that has pull-from-mirror set to %s ",
+								daemonconsts.ContainerRegistryConfPath, m.Location, regLoc, m.PullFromMirror)
+							return false, nil
+						}
+
+					}
+				}
+			}
+		} else if !allDigestOnlyMirror(newReg) {
+			// Ensure that each mirror under the newReg has pull-from-mirror=digest-only
+			klog.Infof("%s: registry %s has been added with mirror does not set pull-from-mirror=digest-only",
+				daemonconsts.ContainerRegistryConfPath, regLoc)
+			return false, nil
+		}
+	}
+
+	klog.Infof("%s: changes made are safe to skip drain", daemonconsts.ContainerRegistryConfPath)
+	return true, nil
+}
+
+// searchRegistryMirror does lookup of a mirror in the mirrorList specified for a registry
+// Returns true if found
+func searchRegistryMirror(loc string, mirrors []sysregistriesv2.Endpoint) (bool, sysregistriesv2.Endpoint) {
+	found := false
+	for _, m := range mirrors {
+		if m.Location == loc {
+			found = true
+			return found, m
+		}
+	}
+	return found, sysregistriesv2.Endpoint{}
+}
+
+func allDigestOnlyMirror(reg sysregistriesv2.Registry) bool {
+	if len(reg.Mirrors) == 0 {
+		return reg.MirrorByDigestOnly
+	}
+	for _, m := range reg.Mirrors {
+		if m.PullFromMirror != sysregistriesv2.MirrorByDigestOnly {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,36 @@
+package common
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetSSHDirOfAuthorizedKeysPath(t *testing.T) {
+	tests := []struct {
+		path   string
+		sshDir string
+	}{
+		{path: "/home/core/.ssh/authorized_keys", sshDir: "/home/core/.ssh"},
+		{path: "/home/core/.ssh/authorized_keys.d/ignition", sshDir: "/home/core/.ssh"},
+		{path: "/var/home/admin/.ssh/authorized_keys.d/bastion", sshDir: "/var/home/admin/.ssh"},
+		{path: "/root/.ssh/authorized_keys", sshDir: "/root/.ssh"},
+		{path: "/home/core/.ssh/config"},
+		{path: "/home/core/.ssh/authorized_keys.d"},
+		{path: "/home/core/authorized_keys"},
+		{path: "/etc/ssh/authorized_keys.d/core"},
+	}
+
+	for _, test := range tests {
+		sshDir, ok := GetSSHDirOfAuthorizedKeysPath(test.path)
+		assert.Equal(t, test.sshDir != "", ok, test.path)
+		assert.Equal(t, test.sshDir, sshDir, test.path)
+	}
+}
+
+func TestAuthorizedKeysChangesSkipReboot(t *testing.T) {
+	assert.Equal(t, []string{PostConfigChangeActionNone},
+		CalculatePostConfigChangeActions([]string{"/home/admin/.ssh/authorized_keys.d/bastion", "/root/.ssh/authorized_keys"}, nil))
+	assert.Equal(t, []string{PostConfigChangeActionReboot},
+		CalculatePostConfigChangeActions([]string{"/home/admin/.ssh/authorized_keys.d/bastion", "/home/admin/.ssh/config"}, nil))
+}
@@ -0,0 +1,158 @@
+package common
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"time"
+
+	daemonconsts "github.com/openshift/machine-config-operator/pkg/daemon/constants"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+)
+
+// DefaultPostUpdateVerificationTimeout is how long the probes have to pass
+// after the node rebooted when the verification does not set a timeout.
+const DefaultPostUpdateVerificationTimeout = 5 * time.Minute
+
+// PostUpdateVerification are the probes which must pass on a node after it
+// rebooted into a new config, as set in PostUpdateVerificationAnnotationKey.
+type PostUpdateVerification struct {
+	// Units are the systemd units which must be active.
+	Units []string `json:"units,omitempty"`
+	// Script is the path of an executable provided by a MachineConfig which
+	// must exit successfully.
+	Script string `json:"script,omitempty"`
+	// HTTPGet is an http or https URL which must answer a GET request with a
+	// 2xx status code.
+	HTTPGet string `json:"httpGet,omitempty"`
+	// Timeout is how long the probes have to pass, e.g. "10m". Defaults to
+	// DefaultPostUpdateVerificationTimeout.
+	Timeout string `json:"timeout,omitempty"`
+}
+
+// ParsePostUpdateVerification parses and validates the probes of
+// PostUpdateVerificationAnnotationKey. An empty value has no probes.
+func ParsePostUpdateVerification(val string) (*PostUpdateVerification, error) {
+	if val == "" {
+		return nil, nil
+	}
+
+	verification := &PostUpdateVerification{}
+
+	dec := json.NewDecoder(bytes.NewBufferString(val))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(verification); err != nil {
+		return nil, err
+	}
+
+	if len(verification.Units) == 0 && verification.Script == "" && verification.HTTPGet == "" {
+		return nil, fmt.Errorf("at least one of units, script or httpGet is required")
+	}
+
+	for _, unit := range verification.Units {
+		if unit == "" || filepath.Base(unit) != unit {
+			return nil, fmt.Errorf("invalid unit name %q", unit)
+		}
+	}
+
+	if verification.Script != "" && (!filepath.IsAbs(verification.Script) || filepath.Clean(verification.Script) != verification.Script) {
+		return nil, fmt.Errorf("script %q must be a clean absolute path", verification.Script)
+	}
+
+	if verification.HTTPGet != "" {
+		u, err := url.Parse(verification.HTTPGet)
+		if err != nil {
+			return nil, fmt.Errorf("invalid httpGet: %w", err)
+		}
+		if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("invalid httpGet %q: must be an http or https URL", verification.HTTPGet)
+		}
+	}
+
+	if _, err := verification.GetTimeout(); err != nil {
+		return nil, err
+	}
+
+	return verification, nil
+}
+
+// GetTimeout returns how long the probes have to pass.
+func (v *PostUpdateVerification) GetTimeout() (time.Duration, error) {
+	if v.Timeout == "" {
+		return DefaultPostUpdateVerificationTimeout, nil
+	}
+
+	timeout, err := time.ParseDuration(v.Timeout)
+	if err != nil {
+		return 0, fmt.Errorf("invalid timeout %q: %w", v.Timeout, err)
+	}
+
+	if timeout <= 0 {
+		return 0, fmt.Errorf("invalid timeout %q: must be positive", v.Timeout)
+	}
+
+	return timeout, nil
+}
+
+// String returns the probes in the format of
+// PostUpdateVerificationAnnotationKey.
+func (v *PostUpdateVerification) String() string {
+	out, err := json.Marshal(v)
+	if err != nil {
+		// Not reached, since the probes only hold strings.
+		return ""
+	}
+
+	return string(out)
+}
+
+// PostUpdateVerificationFailure is what the MCD reports under
+// PostUpdateVerificationFailureAnnotationKey when it rolled a node back
+// because the post-update verification failed.
+type PostUpdateVerificationFailure struct {
+	// Config is the config the node was rolled back from.
+	Config string `json:"config"`
+	// Image is the layered OS image the node was rolled back from, if any.
+	Image string `json:"image,omitempty"`
+	// RolledBackTo is the config the node was rolled back to.
+	RolledBackTo string `json:"rolledBackTo"`
+	// Output is the output of each probe which failed.
+	Output []string `json:"output"`
+	// Time is when the verification failed.
+	Time string `json:"time"`
+}
+
+// GetPostUpdateVerificationFailure returns the post-update verification
+// failure reported by the MCD of the node, if any.
+func GetPostUpdateVerificationFailure(node *corev1.Node) *PostUpdateVerificationFailure {
+	val, ok := GetNodeStatus(node, daemonconsts.PostUpdateVerificationFailureAnnotationKey)
+	if !ok || val == "" {
+		return nil
+	}
+
+	failure := &PostUpdateVerificationFailure{}
+	if err := json.Unmarshal([]byte(val), failure); err != nil {
+		klog.V(4).Infof("Could not parse post-update verification failure %q of node %s: %v", val, node.Name, err)
+		return nil
+	}
+
+	return failure
+}
+
+// HasRolledBackUpdate returns whether the MCD rolled the node back from its
+// desired config because the post-update verification failed. The node is
+// not updated to that config again unless a resync is requested.
+func HasRolledBackUpdate(node *corev1.Node) bool {
+	desired := node.Annotations[daemonconsts.DesiredMachineConfigAnnotationKey]
+	desiredImage := node.Annotations[daemonconsts.DesiredImageAnnotationKey]
+	if desired == node.Annotations[daemonconsts.CurrentMachineConfigAnnotationKey] &&
+		desiredImage == node.Annotations[daemonconsts.CurrentImageAnnotationKey] {
+		return false
+	}
+
+	failure := GetPostUpdateVerificationFailure(node)
+	return failure != nil && failure.Config == desired && failure.Image == desiredImage
+}
@@ -0,0 +1,128 @@
+package common
+
+import (
+	"testing"
+	"time"
+
+	daemonconsts "github.com/openshift/machine-config-operator/pkg/daemon/constants"
+	"github.com/openshift/machine-config-operator/test/helpers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePostUpdateVerification(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		val         string
+		timeout     time.Duration
+		errExpected bool
+	}{
+		{val: `{"units":["kubelet.service","crio.service"]}`, timeout: DefaultPostUpdateVerificationTimeout},
+		{val: `{"script":"/usr/local/bin/verify.sh","httpGet":"https://localhost:8443/healthz","timeout":"10m"}`, timeout: 10 * time.Minute},
+		{val: `{}`, errExpected: true},
+		{val: `{"timeout":"10m"}`, errExpected: true},
+		{val: `{"units":["../kubelet.service"]}`, errExpected: true},
+		{val: `{"units":[""]}`, errExpected: true},
+		{val: `{"script":"verify.sh"}`, errExpected: true},
+		{val: `{"httpGet":"localhost:8080/healthz"}`, errExpected: true},
+		{val: `{"httpGet":"ftp://localhost/healthz"}`, errExpected: true},
+		{val: `{"units":["kubelet.service"],"timeout":"-1m"}`, errExpected: true},
+		{val: `{"unit":"kubelet.service"}`, errExpected: true},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+		t.Run(testCase.val, func(t *testing.T) {
+			t.Parallel()
+
+			verification, err := ParsePostUpdateVerification(testCase.val)
+			if testCase.errExpected {
+				assert.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+
+			timeout, err := verification.GetTimeout()
+			require.NoError(t, err)
+			assert.Equal(t, testCase.timeout, timeout)
+
+			// The formatted probes parse back into the same probes.
+			reparsed, err := ParsePostUpdateVerification(verification.String())
+			require.NoError(t, err)
+			assert.Equal(t, verification, reparsed)
+		})
+	}
+
+	verification, err := ParsePostUpdateVerification("")
+	assert.NoError(t, err)
+	assert.Nil(t, verification)
+}
+
+func TestHasRolledBackUpdate(t *testing.T) {
+	t.Parallel()
+
+	failure := `{"config":"v2","rolledBackTo":"v1","output":["unit kubelet.service is failed"],"time":"2024-01-01T22:00:00Z"}`
+	imageFailure := `{"config":"v1","image":"registry/os@sha256:2","rolledBackTo":"v1","output":["unit kubelet.service is failed"],"time":"2024-01-01T22:00:00Z"}`
+
+	testCases := []struct {
+		name          string
+		currentConfig string
+		desiredConfig string
+		desiredImage  string
+		failure       string
+		expected      bool
+	}{
+		{
+			name:          "rolled back from the desired config",
+			currentConfig: "v1",
+			desiredConfig: "v2",
+			failure:       failure,
+			expected:      true,
+		},
+		{
+			name:          "rolled back from the desired image",
+			currentConfig: "v1",
+			desiredConfig: "v1",
+			desiredImage:  "registry/os@sha256:2",
+			failure:       imageFailure,
+			expected:      true,
+		},
+		{
+			name:          "rolled back from a superseded config",
+			currentConfig: "v1",
+			desiredConfig: "v3",
+			failure:       failure,
+		},
+		{
+			name:          "updated after a resync",
+			currentConfig: "v2",
+			desiredConfig: "v2",
+			failure:       failure,
+		},
+		{
+			name:          "never rolled back",
+			currentConfig: "v1",
+			desiredConfig: "v2",
+		},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			nb := helpers.NewNodeBuilder("node").WithCurrentConfig(testCase.currentConfig).WithDesiredConfig(testCase.desiredConfig)
+			if testCase.desiredImage != "" {
+				nb.WithDesiredImage(testCase.desiredImage)
+			}
+			node := nb.Node()
+			if testCase.failure != "" {
+				require.NoError(t, SetNodeStatus(node, map[string]string{daemonconsts.PostUpdateVerificationFailureAnnotationKey: testCase.failure}))
+			}
+
+			assert.Equal(t, testCase.expected, HasRolledBackUpdate(node))
+		})
+	}
+}
@@ -0,0 +1,231 @@
+package common
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	daemonconsts "github.com/openshift/machine-config-operator/pkg/daemon/constants"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/klog/v2"
+)
+
+// DefaultPreflightScriptTimeout is how long the preflight script may run when
+// the preflight checks do not set a timeout.
+const DefaultPreflightScriptTimeout = 5 * time.Minute
+
+// PreflightChecks are the checks which must pass on a node before the MCD
+// starts updating it, as handed to the node in the PreflightChecksAnnotationKey
+// node annotation.
+type PreflightChecks struct {
+	// MinFreeDisk is how much space must be free on /sysroot and /var, e.g.
+	// "10Gi". It is taken from the MinFreeDiskAnnotationKey annotation of the
+	// pool.
+	MinFreeDisk string `json:"minFreeDisk,omitempty"`
+	// MinFreeInodes is how many inodes must be free on /sysroot and /var.
+	MinFreeInodes int64 `json:"minFreeInodes,omitempty"`
+	// ImageDiskSpace checks that /sysroot and /var have room for the new OS
+	// image of the update, on top of MinFreeDisk.
+	ImageDiskSpace bool `json:"imageDiskSpace,omitempty"`
+	// PruneImages prunes the unused container images of the node when it
+	// does not have enough free disk space or inodes, before checking again.
+	PruneImages bool `json:"pruneImages,omitempty"`
+	// OSTreeDeployments checks that rpm-ostree is idle and the booted
+	// deployment is not unlocked.
+	OSTreeDeployments bool `json:"ostreeDeployments,omitempty"`
+	// Script is the path of an executable provided by a MachineConfig which
+	// must exit successfully.
+	Script string `json:"script,omitempty"`
+	// ScriptTimeout is how long the script may run, e.g. "1m". Defaults to
+	// DefaultPreflightScriptTimeout.
+	ScriptTimeout string `json:"scriptTimeout,omitempty"`
+}
+
+// ParsePreflightChecks parses and validates the preflight checks of the
+// PreflightChecksAnnotationKey node annotation. An empty value has no checks.
+func ParsePreflightChecks(val string) (*PreflightChecks, error) {
+	if val == "" {
+		return nil, nil
+	}
+
+	checks, err := decodePreflightChecks(val)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checks.validate(); err != nil {
+		return nil, err
+	}
+
+	return checks, nil
+}
+
+// ParsePoolPreflightChecks parses and validates the preflight checks of the
+// PreflightChecksAnnotationKey pool annotation. The minimum free disk space
+// is not part of them, since the pool sets it with the
+// MinFreeDiskAnnotationKey annotation, whose value is passed in as
+// minFreeDisk. An empty value has no checks.
+func ParsePoolPreflightChecks(val, minFreeDisk string) (*PreflightChecks, error) {
+	if val == "" {
+		return nil, nil
+	}
+
+	checks, err := decodePreflightChecks(val)
+	if err != nil {
+		return nil, err
+	}
+
+	if checks.MinFreeDisk != "" {
+		return nil, fmt.Errorf("minFreeDisk is not a preflight check, set the %s annotation instead", MinFreeDiskAnnotationKey)
+	}
+
+	checks.MinFreeDisk = minFreeDisk
+
+	if err := checks.validate(); err != nil {
+		return nil, err
+	}
+
+	return checks, nil
+}
+
+func decodePreflightChecks(val string) (*PreflightChecks, error) {
+	checks := &PreflightChecks{}
+
+	dec := json.NewDecoder(bytes.NewBufferString(val))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(checks); err != nil {
+		return nil, err
+	}
+
+	return checks, nil
+}
+
+func (c *PreflightChecks) validate() error {
+	if c.MinFreeDisk != "" {
+		if _, err := c.GetMinFreeDisk(); err != nil {
+			return err
+		}
+	}
+
+	if c.MinFreeInodes < 0 {
+		return fmt.Errorf("invalid minFreeInodes %d: must not be negative", c.MinFreeInodes)
+	}
+
+	if c.PruneImages && !c.ChecksDiskSpace() {
+		return fmt.Errorf("pruneImages requires a minimum free disk space, minFreeInodes or imageDiskSpace")
+	}
+
+	if c.Script != "" && (!filepath.IsAbs(c.Script) || filepath.Clean(c.Script) != c.Script) {
+		return fmt.Errorf("script %q must be a clean absolute path", c.Script)
+	}
+
+	if c.ScriptTimeout != "" {
+		if c.Script == "" {
+			return fmt.Errorf("scriptTimeout requires a script")
+		}
+
+		if _, err := c.GetScriptTimeout(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetMinFreeDisk returns how many bytes must be free on /sysroot and /var,
+// or zero if the free disk space is not checked.
+func (c *PreflightChecks) GetMinFreeDisk() (int64, error) {
+	if c.MinFreeDisk == "" {
+		return 0, nil
+	}
+
+	q, err := resource.ParseQuantity(c.MinFreeDisk)
+	if err != nil {
+		return 0, fmt.Errorf("invalid minFreeDisk %q: %w", c.MinFreeDisk, err)
+	}
+
+	if q.Sign() < 0 {
+		return 0, fmt.Errorf("invalid minFreeDisk %q: must not be negative", c.MinFreeDisk)
+	}
+
+	return q.Value(), nil
+}
+
+// ChecksDiskSpace returns whether the free disk space or inodes of the node
+// are checked.
+func (c *PreflightChecks) ChecksDiskSpace() bool {
+	return c.MinFreeDisk != "" || c.MinFreeInodes > 0 || c.ImageDiskSpace
+}
+
+// GetScriptTimeout returns how long the script may run.
+func (c *PreflightChecks) GetScriptTimeout() (time.Duration, error) {
+	if c.ScriptTimeout == "" {
+		return DefaultPreflightScriptTimeout, nil
+	}
+
+	timeout, err := time.ParseDuration(c.ScriptTimeout)
+	if err != nil {
+		return 0, fmt.Errorf("invalid scriptTimeout %q: %w", c.ScriptTimeout, err)
+	}
+
+	if timeout <= 0 {
+		return 0, fmt.Errorf("invalid scriptTimeout %q: must be positive", c.ScriptTimeout)
+	}
+
+	return timeout, nil
+}
+
+// String returns the checks in the format of PreflightChecksAnnotationKey.
+func (c *PreflightChecks) String() string {
+	out, err := json.Marshal(c)
+	if err != nil {
+		// Not reached, since the checks only hold strings, integers and bools.
+		return ""
+	}
+
+	return string(out)
+}
+
+// PreflightCheckFailure is what the MCD reports under
+// PreflightCheckFailureAnnotationKey when the preflight checks fail.
+type PreflightCheckFailure struct {
+	// Config is the config the node was not updated to.
+	Config string `json:"config"`
+	// Failures describes each check which failed.
+	Failures []string `json:"failures"`
+	// Time is when the checks failed.
+	Time string `json:"time"`
+}
+
+// GetPreflightCheckFailure returns the preflight check failure reported by
+// the MCD of the node, if any.
+func GetPreflightCheckFailure(node *corev1.Node) *PreflightCheckFailure {
+	val, ok := GetNodeStatus(node, daemonconsts.PreflightCheckFailureAnnotationKey)
+	if !ok || val == "" {
+		return nil
+	}
+
+	failure := &PreflightCheckFailure{}
+	if err := json.Unmarshal([]byte(val), failure); err != nil {
+		klog.V(4).Infof("Could not parse preflight check failure %q of node %s: %v", val, node.Name, err)
+		return nil
+	}
+
+	return failure
+}
+
+// HasFailedPreflightChecks returns whether the MCD skipped updating the node
+// to its desired config because the preflight checks failed. Such nodes have
+// not been cordoned or changed.
+func HasFailedPreflightChecks(node *corev1.Node) bool {
+	desired := node.Annotations[daemonconsts.DesiredMachineConfigAnnotationKey]
+	if desired == node.Annotations[daemonconsts.CurrentMachineConfigAnnotationKey] {
+		return false
+	}
+
+	failure := GetPreflightCheckFailure(node)
+	return failure != nil && failure.Config == desired
+}
@@ -0,0 +1,174 @@
+package common
+
+import (
+	"testing"
+	"time"
+
+	daemonconsts "github.com/openshift/machine-config-operator/pkg/daemon/constants"
+	"github.com/openshift/machine-config-operator/test/helpers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePreflightChecks(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		val           string
+		minFreeDisk   int64
+		scriptTimeout time.Duration
+		errExpected   bool
+	}{
+		{val: `{"minFreeDisk":"10Gi","ostreeDeployments":true}`, minFreeDisk: 10 * 1024 * 1024 * 1024, scriptTimeout: DefaultPreflightScriptTimeout},
+		{val: `{"script":"/usr/local/bin/check.sh","scriptTimeout":"30s"}`, scriptTimeout: 30 * time.Second},
+		{val: `{"minFreeDisk":"10Gi","minFreeInodes":100000,"imageDiskSpace":true,"pruneImages":true}`, minFreeDisk: 10 * 1024 * 1024 * 1024, scriptTimeout: DefaultPreflightScriptTimeout},
+		{val: `{"imageDiskSpace":true,"pruneImages":true}`, scriptTimeout: DefaultPreflightScriptTimeout},
+		{val: `{"minFreeDisk":"lots"}`, errExpected: true},
+		{val: `{"minFreeInodes":-1}`, errExpected: true},
+		{val: `{"minFreeInodes":"100k"}`, errExpected: true},
+		{val: `{"pruneImages":true}`, errExpected: true},
+		{val: `{"ostreeDeployments":true,"pruneImages":true}`, errExpected: true},
+		{val: `{"minFreeDisk":"-1Gi"}`, errExpected: true},
+		{val: `{"script":"check.sh"}`, errExpected: true},
+		{val: `{"script":"/usr/local/bin/../check.sh"}`, errExpected: true},
+		{val: `{"scriptTimeout":"30s"}`, errExpected: true},
+		{val: `{"script":"/usr/local/bin/check.sh","scriptTimeout":"0s"}`, errExpected: true},
+		{val: `{"minFreeDisks":"10Gi"}`, errExpected: true},
+		{val: `10Gi`, errExpected: true},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+		t.Run(testCase.val, func(t *testing.T) {
+			t.Parallel()
+
+			checks, err := ParsePreflightChecks(testCase.val)
+			if testCase.errExpected {
+				assert.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+
+			minFreeDisk, err := checks.GetMinFreeDisk()
+			require.NoError(t, err)
+			assert.Equal(t, testCase.minFreeDisk, minFreeDisk)
+
+			scriptTimeout, err := checks.GetScriptTimeout()
+			require.NoError(t, err)
+			assert.Equal(t, testCase.scriptTimeout, scriptTimeout)
+
+			// The formatted checks parse back into the same checks.
+			reparsed, err := ParsePreflightChecks(checks.String())
+			require.NoError(t, err)
+			assert.Equal(t, checks, reparsed)
+		})
+	}
+
+	checks, err := ParsePreflightChecks("")
+	assert.NoError(t, err)
+	assert.Nil(t, checks)
+}
+
+func TestParsePoolPreflightChecks(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		val         string
+		minFreeDisk string
+		expected    *PreflightChecks
+		errExpected bool
+	}{
+		{val: `{"ostreeDeployments":true}`, minFreeDisk: "10Gi", expected: &PreflightChecks{MinFreeDisk: "10Gi", OSTreeDeployments: true}},
+		{val: `{"ostreeDeployments":true}`, expected: &PreflightChecks{OSTreeDeployments: true}},
+		{val: `{"pruneImages":true}`, minFreeDisk: "2Gi", expected: &PreflightChecks{MinFreeDisk: "2Gi", PruneImages: true}},
+		{val: `{"pruneImages":true}`, errExpected: true},
+		{val: `{"minFreeDisk":"10Gi"}`, errExpected: true},
+		{val: `{"ostreeDeployments":true}`, minFreeDisk: "lots", errExpected: true},
+		{val: ``, minFreeDisk: "10Gi"},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+		t.Run(testCase.val+"/"+testCase.minFreeDisk, func(t *testing.T) {
+			t.Parallel()
+
+			checks, err := ParsePoolPreflightChecks(testCase.val, testCase.minFreeDisk)
+			if testCase.errExpected {
+				assert.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, testCase.expected, checks)
+
+			if checks == nil {
+				return
+			}
+
+			// The checks handed to the node parse back into the same checks.
+			reparsed, err := ParsePreflightChecks(checks.String())
+			require.NoError(t, err)
+			assert.Equal(t, checks, reparsed)
+		})
+	}
+}
+
+func TestHasFailedPreflightChecks(t *testing.T) {
+	t.Parallel()
+
+	failure := `{"config":"v2","failures":["ostree: rpm-ostree transaction deploy is in progress"],"time":"2024-01-01T22:00:00Z"}`
+
+	testCases := []struct {
+		name          string
+		currentConfig string
+		desiredConfig string
+		failure       string
+		expected      bool
+	}{
+		{
+			name:          "failed for the desired config",
+			currentConfig: "v1",
+			desiredConfig: "v2",
+			failure:       failure,
+			expected:      true,
+		},
+		{
+			name:          "failed for a superseded config",
+			currentConfig: "v1",
+			desiredConfig: "v3",
+			failure:       failure,
+		},
+		{
+			name:          "updated after the checks passed",
+			currentConfig: "v2",
+			desiredConfig: "v2",
+			failure:       failure,
+		},
+		{
+			name:          "cleared",
+			currentConfig: "v1",
+			desiredConfig: "v2",
+		},
+		{
+			name:          "never failed",
+			currentConfig: "v1",
+			desiredConfig: "v2",
+			failure:       "-",
+		},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			node := helpers.NewNodeBuilder("node").WithCurrentConfig(testCase.currentConfig).WithDesiredConfig(testCase.desiredConfig).Node()
+			if testCase.failure != "-" {
+				require.NoError(t, SetNodeStatus(node, map[string]string{daemonconsts.PreflightCheckFailureAnnotationKey: testCase.failure}))
+			}
+
+			assert.Equal(t, testCase.expected, HasFailedPreflightChecks(node))
+		})
+	}
+}
@@ -0,0 +1,108 @@
+package common
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	daemonconsts "github.com/openshift/machine-config-operator/pkg/daemon/constants"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+)
+
+// StagedUpdateFinalization is what finalizes an update staged on a node,
+// besides a maintenance window or a finalize request.
+type StagedUpdateFinalization string
+
+const (
+	// StagedUpdateFinalizationLocked locks the finalization of the staged OS
+	// deployment, so that a reboot of the node which the MCD did not start
+	// boots back into the current OS. The update is staged again afterwards.
+	StagedUpdateFinalizationLocked StagedUpdateFinalization = "Locked"
+	// StagedUpdateFinalizationOnReboot finalizes the staged update whenever
+	// the node reboots, whatever rebooted it.
+	StagedUpdateFinalizationOnReboot StagedUpdateFinalization = "OnReboot"
+)
+
+// StagedUpdatesConfig is how the MCD stages updates which reboot the nodes of
+// a pool, as set in StagedUpdatesAnnotationKey.
+type StagedUpdatesConfig struct {
+	// Finalization defaults to StagedUpdateFinalizationLocked.
+	Finalization StagedUpdateFinalization `json:"finalization,omitempty"`
+}
+
+// ParseStagedUpdatesConfig parses and validates the staged updates of
+// StagedUpdatesAnnotationKey. An empty value does not stage updates.
+func ParseStagedUpdatesConfig(val string) (*StagedUpdatesConfig, error) {
+	if val == "" {
+		return nil, nil
+	}
+
+	config := &StagedUpdatesConfig{}
+
+	dec := json.NewDecoder(bytes.NewBufferString(val))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(config); err != nil {
+		return nil, err
+	}
+
+	switch config.Finalization {
+	case "":
+		config.Finalization = StagedUpdateFinalizationLocked
+	case StagedUpdateFinalizationLocked, StagedUpdateFinalizationOnReboot:
+	default:
+		return nil, fmt.Errorf("unknown finalization %q, must be %s or %s", config.Finalization, StagedUpdateFinalizationLocked, StagedUpdateFinalizationOnReboot)
+	}
+
+	return config, nil
+}
+
+// String returns the staged updates in the format of
+// StagedUpdatesAnnotationKey.
+func (c *StagedUpdatesConfig) String() string {
+	out, err := json.Marshal(c)
+	if err != nil {
+		// Not reached, since the staged updates only hold strings.
+		return ""
+	}
+
+	return string(out)
+}
+
+// StagedUpdate is what the MCD reports under StagedUpdateAnnotationKey after
+// staging an update on the node.
+type StagedUpdate struct {
+	// Config is the config the update was staged to.
+	Config string `json:"config"`
+	// From is the config the node runs until the update is finalized.
+	From string `json:"from"`
+	// Deployment is the ID of the staged OS deployment. It is empty when the
+	// update does not change the OS.
+	Deployment   string                   `json:"deployment,omitempty"`
+	Finalization StagedUpdateFinalization `json:"finalization"`
+	Time         string                   `json:"time"`
+}
+
+// GetStagedUpdate returns the update staged on the node as reported by its
+// MCD, if any.
+func GetStagedUpdate(node *corev1.Node) *StagedUpdate {
+	val, ok := GetNodeStatus(node, daemonconsts.StagedUpdateAnnotationKey)
+	if !ok || val == "" {
+		return nil
+	}
+
+	staged := &StagedUpdate{}
+	if err := json.Unmarshal([]byte(val), staged); err != nil {
+		klog.V(4).Infof("Could not parse staged update %q of node %s: %v", val, node.Name, err)
+		return nil
+	}
+
+	return staged
+}
+
+// IsUpdateStaged returns whether the update of the node to its desired config
+// is staged and waits to be finalized.
+func IsUpdateStaged(node *corev1.Node) bool {
+	staged := GetStagedUpdate(node)
+	return staged != nil && staged.Config == node.Annotations[daemonconsts.DesiredMachineConfigAnnotationKey]
+}
@@ -0,0 +1,77 @@
+package common
+
+import (
+	"testing"
+
+	daemonconsts "github.com/openshift/machine-config-operator/pkg/daemon/constants"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestParseStagedUpdatesConfig(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		val          string
+		finalization StagedUpdateFinalization
+		errExpected  bool
+	}{
+		{val: `{}`, finalization: StagedUpdateFinalizationLocked},
+		{val: `{"finalization":"Locked"}`, finalization: StagedUpdateFinalizationLocked},
+		{val: `{"finalization":"OnReboot"}`, finalization: StagedUpdateFinalizationOnReboot},
+		{val: `{"finalization":"Never"}`, errExpected: true},
+		{val: `{"finalize":"OnReboot"}`, errExpected: true},
+		{val: `true`, errExpected: true},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+		t.Run(testCase.val, func(t *testing.T) {
+			t.Parallel()
+
+			config, err := ParseStagedUpdatesConfig(testCase.val)
+			if testCase.errExpected {
+				assert.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, testCase.finalization, config.Finalization)
+
+			// The formatted staged updates parse back into the same staged updates.
+			reparsed, err := ParseStagedUpdatesConfig(config.String())
+			require.NoError(t, err)
+			assert.Equal(t, config, reparsed)
+		})
+	}
+
+	config, err := ParseStagedUpdatesConfig("")
+	assert.NoError(t, err)
+	assert.Nil(t, config)
+}
+
+func TestIsUpdateStaged(t *testing.T) {
+	t.Parallel()
+
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "node-0",
+			Annotations: map[string]string{
+				daemonconsts.DesiredMachineConfigAnnotationKey: "rendered-worker-2",
+			},
+		},
+	}
+	assert.False(t, IsUpdateStaged(node))
+
+	require.NoError(t, SetNodeStatus(node, map[string]string{
+		daemonconsts.StagedUpdateAnnotationKey: `{"config":"rendered-worker-2","from":"rendered-worker-1","finalization":"Locked","time":"2024-01-01T00:00:00Z"}`,
+	}))
+	assert.True(t, IsUpdateStaged(node))
+	assert.Equal(t, "rendered-worker-1", GetStagedUpdate(node).From)
+
+	// An update staged to an older config is not the update of the node.
+	node.Annotations[daemonconsts.DesiredMachineConfigAnnotationKey] = "rendered-worker-3"
+	assert.False(t, IsUpdateStaged(node))
+}
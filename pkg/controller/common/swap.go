@@ -0,0 +1,121 @@
+package common
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	daemonconsts "github.com/openshift/machine-config-operator/pkg/daemon/constants"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/klog/v2"
+)
+
+// SwapType is the kind of swap device the MCD sets up.
+type SwapType string
+
+const (
+	// SwapTypeZram compresses swapped out pages in memory with zram, set up by
+	// zram-generator.
+	SwapTypeZram SwapType = "Zram"
+	// SwapTypeFile swaps to a file on the filesystem of /var.
+	SwapTypeFile SwapType = "File"
+)
+
+// MaxSwappiness is the highest vm.swappiness the kernel accepts.
+const MaxSwappiness = 200
+
+// SwapConfig is the swap the MCD sets up on the nodes of a pool, as set in
+// SwapAnnotationKey.
+type SwapConfig struct {
+	Type SwapType `json:"type"`
+	// Size is the size of the swap device, e.g. "4Gi".
+	Size string `json:"size"`
+	// Swappiness sets vm.swappiness. The kernel default is kept when unset.
+	Swappiness *int `json:"swappiness,omitempty"`
+}
+
+// ParseSwapConfig parses and validates the swap of SwapAnnotationKey. An
+// empty value has no swap.
+func ParseSwapConfig(val string) (*SwapConfig, error) {
+	if val == "" {
+		return nil, nil
+	}
+
+	config := &SwapConfig{}
+
+	dec := json.NewDecoder(bytes.NewBufferString(val))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(config); err != nil {
+		return nil, err
+	}
+
+	switch config.Type {
+	case SwapTypeZram, SwapTypeFile:
+	default:
+		return nil, fmt.Errorf("unknown type %q, must be %s or %s", config.Type, SwapTypeZram, SwapTypeFile)
+	}
+
+	if _, err := config.GetSize(); err != nil {
+		return nil, err
+	}
+
+	if config.Swappiness != nil && (*config.Swappiness < 0 || *config.Swappiness > MaxSwappiness) {
+		return nil, fmt.Errorf("invalid swappiness %d: must be between 0 and %d", *config.Swappiness, MaxSwappiness)
+	}
+
+	return config, nil
+}
+
+// GetSize returns the size of the swap device in bytes.
+func (c *SwapConfig) GetSize() (int64, error) {
+	size, err := resource.ParseQuantity(c.Size)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q: %w", c.Size, err)
+	}
+
+	if size.Value() < 1024*1024 {
+		return 0, fmt.Errorf("invalid size %q: must be at least 1Mi", c.Size)
+	}
+
+	return size.Value(), nil
+}
+
+// String returns the swap in the format of SwapAnnotationKey.
+func (c *SwapConfig) String() string {
+	out, err := json.Marshal(c)
+	if err != nil {
+		// Not reached, since the swap only holds strings and ints.
+		return ""
+	}
+
+	return string(out)
+}
+
+// SwapStatus is what the MCD reports under SwapStatusAnnotationKey after
+// reconciling the swap of the node.
+type SwapStatus struct {
+	// Config is the swap the MCD was handed, in the format of
+	// SwapAnnotationKey. It is empty once the swap was removed.
+	Config string `json:"config"`
+	// Error describes why the swap could not be set up, e.g. because the
+	// kubelet does not allow swap. The node is left without swap then.
+	Error string `json:"error,omitempty"`
+}
+
+// GetSwapStatus returns the swap status reported by the MCD of the node, if
+// any.
+func GetSwapStatus(node *corev1.Node) *SwapStatus {
+	val, ok := GetNodeStatus(node, daemonconsts.SwapStatusAnnotationKey)
+	if !ok || val == "" {
+		return nil
+	}
+
+	status := &SwapStatus{}
+	if err := json.Unmarshal([]byte(val), status); err != nil {
+		klog.V(4).Infof("Could not parse swap status %q of node %s: %v", val, node.Name, err)
+		return nil
+	}
+
+	return status
+}
@@ -0,0 +1,57 @@
+package common
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSwapConfig(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		val         string
+		size        int64
+		errExpected bool
+	}{
+		{val: `{"type":"Zram","size":"4Gi","swappiness":10}`, size: 4 * 1024 * 1024 * 1024},
+		{val: `{"type":"File","size":"512Mi"}`, size: 512 * 1024 * 1024},
+		{val: `{"type":"File","size":"1Gi","swappiness":0}`, size: 1024 * 1024 * 1024},
+		{val: `{"type":"Partition","size":"4Gi"}`, errExpected: true},
+		{val: `{"type":"Zram"}`, errExpected: true},
+		{val: `{"type":"Zram","size":"lots"}`, errExpected: true},
+		{val: `{"type":"Zram","size":"1Ki"}`, errExpected: true},
+		{val: `{"type":"Zram","size":"4Gi","swappiness":201}`, errExpected: true},
+		{val: `{"type":"Zram","size":"4Gi","swappiness":-1}`, errExpected: true},
+		{val: `{"type":"Zram","size":"4Gi","priority":10}`, errExpected: true},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+		t.Run(testCase.val, func(t *testing.T) {
+			t.Parallel()
+
+			config, err := ParseSwapConfig(testCase.val)
+			if testCase.errExpected {
+				assert.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+
+			size, err := config.GetSize()
+			require.NoError(t, err)
+			assert.Equal(t, testCase.size, size)
+
+			// The formatted swap parses back into the same swap.
+			reparsed, err := ParseSwapConfig(config.String())
+			require.NoError(t, err)
+			assert.Equal(t, config, reparsed)
+		})
+	}
+
+	config, err := ParseSwapConfig("")
+	assert.NoError(t, err)
+	assert.Nil(t, config)
+}
@@ -0,0 +1,204 @@
+package common
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/coreos/go-systemd/v22/unit"
+	ign3types "github.com/coreos/ignition/v2/config/v3_4/types"
+	kerrors "k8s.io/apimachinery/pkg/util/errors"
+)
+
+// validSystemdUnitSuffixes are the unit types that systemd knows how to load from a unit file.
+var validSystemdUnitSuffixes = map[string]bool{
+	".automount": true,
+	".mount":     true,
+	".path":      true,
+	".scope":     true,
+	".service":   true,
+	".slice":     true,
+	".socket":    true,
+	".swap":      true,
+	".target":    true,
+	".timer":     true,
+}
+
+// requiredSystemdUnitSections maps unit types to the section that systemd requires in their unit files.
+var requiredSystemdUnitSections = map[string]string{
+	".automount": "Automount",
+	".mount":     "Mount",
+	".path":      "Path",
+	".socket":    "Socket",
+	".swap":      "Swap",
+	".timer":     "Timer",
+}
+
+// ValidateSystemdUnits checks that the systemd units and dropins in the given Ignition config are something
+// systemd will be able to load, in the spirit of systemd-analyze verify. Ignition only checks that unit contents
+// are syntactically valid, which means problems such as a service without any command to run or a dropin
+// which systemd will silently ignore otherwise only surface on the node after the update has been applied.
+//
+// The render controller passes the merged config of the pool, so units and dropins from all of its
+// MachineConfigs are checked together. Which units the OS image ships cannot be known at render time, so a
+// unit which the config only adds dropins to is assumed to exist, and its options are not checked. References
+// to other units are only checked against the units of the config: Requires=, Requisite=, BindsTo=,
+// WantedBy= and RequiredBy= of a unit or dropin must not refer to a unit which the config masks, either
+// directly or through its template. The checks are listed in docs/MachineConfiguration.md.
+func ValidateSystemdUnits(cfg ign3types.Config) error {
+	errs := []error{}
+
+	units := map[string]ign3types.Unit{}
+	for _, u := range cfg.Systemd.Units {
+		units[u.Name] = u
+	}
+
+	for _, u := range cfg.Systemd.Units {
+		errs = append(errs, validateSystemdUnit(u, units)...)
+	}
+
+	return kerrors.NewAggregate(errs)
+}
+
+func validateSystemdUnit(u ign3types.Unit, units map[string]ign3types.Unit) []error {
+	suffix := filepath.Ext(u.Name)
+	if !validSystemdUnitSuffixes[suffix] {
+		return []error{fmt.Errorf("systemd unit %q: unknown unit type %q", u.Name, suffix)}
+	}
+
+	if strings.HasPrefix(u.Name, "@") || strings.Contains(u.Name, "/") {
+		return []error{fmt.Errorf("systemd unit %q: invalid unit name", u.Name)}
+	}
+
+	errs := []error{}
+	masked := u.Mask != nil && *u.Mask
+
+	// Dropins are applied on top of the unit contents, so the unit is checked with the options from both.
+	allOpts := []*unit.UnitOption{}
+
+	for _, dropin := range u.Dropins {
+		if masked {
+			errs = append(errs, fmt.Errorf("systemd unit %q: dropin %q has no effect since the unit is masked", u.Name, dropin.Name))
+			continue
+		}
+
+		if filepath.Ext(dropin.Name) != ".conf" {
+			errs = append(errs, fmt.Errorf("systemd unit %q: dropin %q will be ignored by systemd since its name does not end in .conf", u.Name, dropin.Name))
+			continue
+		}
+
+		if dropin.Contents == nil || *dropin.Contents == "" {
+			continue
+		}
+
+		opts, err := unit.Deserialize(strings.NewReader(*dropin.Contents))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("systemd unit %q: could not parse dropin %q: %w", u.Name, dropin.Name, err))
+			continue
+		}
+
+		errs = append(errs, validateSystemdUnitReferences(fmt.Sprintf("systemd unit %q dropin %q", u.Name, dropin.Name), opts, units)...)
+		allOpts = append(allOpts, opts...)
+	}
+
+	if u.Contents == nil || *u.Contents == "" {
+		return errs
+	}
+
+	opts, err := unit.Deserialize(strings.NewReader(*u.Contents))
+	if err != nil {
+		return append(errs, fmt.Errorf("systemd unit %q: could not parse contents: %w", u.Name, err))
+	}
+
+	errs = append(errs, validateSystemdUnitReferences(fmt.Sprintf("systemd unit %q", u.Name), opts, units)...)
+
+	if err := validateSystemdUnitOptions(u.Name, suffix, append(opts, allOpts...)); err != nil {
+		errs = append(errs, err)
+	}
+
+	return errs
+}
+
+// validateSystemdUnitOptions checks that a unit contains what systemd needs to load it.
+func validateSystemdUnitOptions(name, suffix string, opts []*unit.UnitOption) error {
+	sections := map[string]bool{}
+	for _, opt := range opts {
+		sections[opt.Section] = true
+	}
+
+	if section, ok := requiredSystemdUnitSections[suffix]; ok && !sections[section] {
+		return fmt.Errorf("systemd unit %q: missing [%s] section", name, section)
+	}
+
+	if suffix == ".service" && !hasSystemdUnitOption(opts, "Service", "ExecStart", "ExecStop") && !hasSystemdUnitOption(opts, "Unit", "SuccessAction") {
+		return fmt.Errorf("systemd unit %q: service has no ExecStart=, ExecStop=, or SuccessAction=", name)
+	}
+
+	if suffix == ".mount" && !hasSystemdUnitOption(opts, "Mount", "Where") {
+		return fmt.Errorf("systemd unit %q: mount has no Where=", name)
+	}
+
+	return nil
+}
+
+// validateSystemdUnitReferences checks that units which are configured to be triggered by or are bound to another
+// unit from this config refer to a unit that the config does not mask.
+func validateSystemdUnitReferences(source string, opts []*unit.UnitOption, units map[string]ign3types.Unit) []error {
+	errs := []error{}
+
+	for _, opt := range opts {
+		if opt.Section != "Unit" && opt.Section != "Install" {
+			continue
+		}
+
+		switch opt.Name {
+		case "Requires", "Requisite", "BindsTo", "WantedBy", "RequiredBy":
+		default:
+			continue
+		}
+
+		for _, ref := range strings.Fields(opt.Value) {
+			if isSystemdUnitMasked(ref, units) {
+				errs = append(errs, fmt.Errorf("%s: %s=%s refers to a masked unit", source, opt.Name, ref))
+			}
+		}
+	}
+
+	return errs
+}
+
+// isSystemdUnitMasked returns whether the config masks the given unit. Instances of a template unit, e.g.
+// foo@bar.service, are masked along with their template, foo@.service.
+func isSystemdUnitMasked(name string, units map[string]ign3types.Unit) bool {
+	isMasked := func(name string) bool {
+		u, ok := units[name]
+		return ok && u.Mask != nil && *u.Mask
+	}
+
+	if isMasked(name) {
+		return true
+	}
+
+	prefix, instance, ok := strings.Cut(strings.TrimSuffix(name, filepath.Ext(name)), "@")
+	if !ok || instance == "" {
+		return false
+	}
+
+	return isMasked(prefix + "@" + filepath.Ext(name))
+}
+
+func hasSystemdUnitOption(opts []*unit.UnitOption, section string, names ...string) bool {
+	for _, opt := range opts {
+		if opt.Section != section {
+			continue
+		}
+
+		for _, name := range names {
+			if opt.Name == name {
+				return true
+			}
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,173 @@
+package common
+
+import (
+	"testing"
+
+	ign3types "github.com/coreos/ignition/v2/config/v3_4/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateSystemdUnits(t *testing.T) {
+	newUnit := func(name, contents string, dropins ...ign3types.Dropin) ign3types.Unit {
+		u := ign3types.Unit{Name: name, Dropins: dropins}
+		if contents != "" {
+			u.Contents = strToPtr(contents)
+		}
+		return u
+	}
+
+	newDropin := func(name, contents string) ign3types.Dropin {
+		return ign3types.Dropin{Name: name, Contents: strToPtr(contents)}
+	}
+
+	maskedUnit := func(name string, dropins ...ign3types.Dropin) ign3types.Unit {
+		return ign3types.Unit{Name: name, Mask: boolToPtr(true), Dropins: dropins}
+	}
+
+	testCases := []struct {
+		name          string
+		units         []ign3types.Unit
+		errorContains []string
+	}{
+		{
+			name: "valid units and dropins",
+			units: []ign3types.Unit{
+				newUnit("foo.service", "[Unit]\nDescription=foo\n[Service]\nExecStart=/usr/bin/foo\n[Install]\nWantedBy=multi-user.target\n"),
+				newUnit("bar@.service", "[Service]\nType=oneshot\nExecStart=/usr/bin/bar %i\n"),
+				newUnit("var-lib-foo.mount", "[Mount]\nWhat=/dev/sdb\nWhere=/var/lib/foo\n"),
+				newUnit("foo.timer", "[Timer]\nOnCalendar=daily\n"),
+				newUnit("kubelet.service", "", newDropin("10-env.conf", "[Service]\nEnvironment=FOO=bar\n")),
+				newUnit("foo.target", "[Unit]\nDescription=foo\n"),
+				maskedUnit("bar.service"),
+			},
+		},
+		{
+			name: "service command from a dropin",
+			units: []ign3types.Unit{
+				newUnit("foo.service", "[Unit]\nDescription=foo\n", newDropin("10-exec.conf", "[Service]\nExecStart=/usr/bin/foo\n")),
+			},
+		},
+		{
+			name: "unknown unit type",
+			units: []ign3types.Unit{
+				newUnit("foo.conf", "[Service]\nExecStart=/usr/bin/foo\n"),
+			},
+			errorContains: []string{`systemd unit "foo.conf": unknown unit type ".conf"`},
+		},
+		{
+			name: "unparseable contents",
+			units: []ign3types.Unit{
+				newUnit("foo.service", "[Service\nExecStart=/usr/bin/foo\n"),
+			},
+			errorContains: []string{`systemd unit "foo.service": could not parse contents`},
+		},
+		{
+			name: "service without a command",
+			units: []ign3types.Unit{
+				newUnit("foo.service", "[Unit]\nDescription=foo\n[Service]\nType=oneshot\n"),
+			},
+			errorContains: []string{`systemd unit "foo.service": service has no ExecStart=`},
+		},
+		{
+			name: "missing type-specific section",
+			units: []ign3types.Unit{
+				newUnit("foo.timer", "[Unit]\nDescription=foo\n"),
+			},
+			errorContains: []string{`systemd unit "foo.timer": missing [Timer] section`},
+		},
+		{
+			name: "mount without a mount point",
+			units: []ign3types.Unit{
+				newUnit("var-lib-foo.mount", "[Mount]\nWhat=/dev/sdb\n"),
+			},
+			errorContains: []string{`systemd unit "var-lib-foo.mount": mount has no Where=`},
+		},
+		{
+			name: "dropin without the .conf suffix",
+			units: []ign3types.Unit{
+				newUnit("kubelet.service", "", newDropin("10-env", "[Service]\nEnvironment=FOO=bar\n")),
+			},
+			errorContains: []string{`systemd unit "kubelet.service": dropin "10-env" will be ignored by systemd`},
+		},
+		{
+			name: "unparseable dropin",
+			units: []ign3types.Unit{
+				newUnit("kubelet.service", "", newDropin("10-env.conf", "[Service]\nEnvironment\n")),
+			},
+			errorContains: []string{`systemd unit "kubelet.service": could not parse dropin "10-env.conf"`},
+		},
+		{
+			name: "dropin for a masked unit",
+			units: []ign3types.Unit{
+				maskedUnit("foo.service", newDropin("10-env.conf", "[Service]\nEnvironment=FOO=bar\n")),
+			},
+			errorContains: []string{`systemd unit "foo.service": dropin "10-env.conf" has no effect since the unit is masked`},
+		},
+		{
+			name: "dependency on a masked unit",
+			units: []ign3types.Unit{
+				maskedUnit("bar.service"),
+				maskedUnit("bar.target"),
+				newUnit("foo.service", "[Unit]\nRequires=baz.service bar.service\n[Service]\nExecStart=/usr/bin/foo\n[Install]\nWantedBy=bar.target\n"),
+			},
+			errorContains: []string{
+				`systemd unit "foo.service": Requires=bar.service refers to a masked unit`,
+				`systemd unit "foo.service": WantedBy=bar.target refers to a masked unit`,
+			},
+		},
+		{
+			name: "dependency from a dropin on a masked unit",
+			units: []ign3types.Unit{
+				maskedUnit("bar.service"),
+				newUnit("kubelet.service", "", newDropin("10-bar.conf", "[Unit]\nRequires=bar.service\n")),
+			},
+			errorContains: []string{`systemd unit "kubelet.service" dropin "10-bar.conf": Requires=bar.service refers to a masked unit`},
+		},
+		{
+			name: "dependency on an instance of a masked template",
+			units: []ign3types.Unit{
+				maskedUnit("bar@.service"),
+				newUnit("foo.service", "[Unit]\nBindsTo=bar@foo.service\n[Service]\nExecStart=/usr/bin/foo\n"),
+			},
+			errorContains: []string{`systemd unit "foo.service": BindsTo=bar@foo.service refers to a masked unit`},
+		},
+		{
+			name: "dependencies on units of the OS image",
+			units: []ign3types.Unit{
+				maskedUnit("bar@foo.service"),
+				newUnit("foo.service", "[Unit]\nRequires=crio.service bar@baz.service\nAfter=bar@foo.service\n[Service]\nExecStart=/usr/bin/foo\n[Install]\nWantedBy=multi-user.target\n"),
+				newUnit("crio.service", "", newDropin("10-foo.conf", "[Unit]\nWants=foo.service\n")),
+			},
+		},
+		{
+			name: "errors from multiple units are aggregated",
+			units: []ign3types.Unit{
+				newUnit("foo.service", "[Unit]\nDescription=foo\n"),
+				newUnit("foo.socket", "[Unit]\nDescription=foo\n"),
+			},
+			errorContains: []string{
+				`systemd unit "foo.service": service has no ExecStart=`,
+				`systemd unit "foo.socket": missing [Socket] section`,
+			},
+		},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+		t.Run(testCase.name, func(t *testing.T) {
+			cfg := NewIgnConfig()
+			cfg.Systemd.Units = testCase.units
+
+			err := ValidateSystemdUnits(cfg)
+			if len(testCase.errorContains) == 0 {
+				assert.NoError(t, err)
+				return
+			}
+
+			assert.Error(t, err)
+			for _, msg := range testCase.errorContains {
+				assert.Contains(t, err.Error(), msg)
+			}
+		})
+	}
+}
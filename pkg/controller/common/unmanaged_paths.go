@@ -0,0 +1,69 @@
+package common
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// ParseUnmanagedPaths parses a comma-separated list of unmanaged paths, as found in the
+// UnmanagedPathsAnnotationKey annotation. The paths must be absolute and below /etc. A path ending
+// in "/" covers everything below it. The returned paths are cleaned, deduplicated and sorted so
+// that they can be compared and written back out with strings.Join.
+func ParseUnmanagedPaths(val string) ([]string, error) {
+	seen := map[string]bool{}
+	out := []string{}
+
+	for _, p := range strings.Split(val, ",") {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+
+		if !path.IsAbs(p) {
+			return nil, fmt.Errorf("unmanaged path %q is not absolute", p)
+		}
+
+		isDir := strings.HasSuffix(p, "/")
+
+		cleaned := path.Clean(p)
+		if cleaned == "/etc" || !strings.HasPrefix(cleaned, "/etc/") {
+			return nil, fmt.Errorf("unmanaged path %q is not below /etc", p)
+		}
+
+		if isDir {
+			cleaned += "/"
+		}
+
+		if !seen[cleaned] {
+			seen[cleaned] = true
+			out = append(out, cleaned)
+		}
+	}
+
+	sort.Strings(out)
+
+	return out, nil
+}
+
+// IsUnmanagedPath returns whether the given file path is covered by any of the given unmanaged
+// paths, as returned by ParseUnmanagedPaths.
+func IsUnmanagedPath(filePath string, unmanagedPaths []string) bool {
+	filePath = path.Clean(filePath)
+
+	for _, p := range unmanagedPaths {
+		if strings.HasSuffix(p, "/") {
+			if strings.HasPrefix(filePath, p) {
+				return true
+			}
+			continue
+		}
+
+		if filePath == p {
+			return true
+		}
+	}
+
+	return false
+}
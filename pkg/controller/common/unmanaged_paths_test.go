@@ -0,0 +1,84 @@
+package common
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseUnmanagedPaths(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name        string
+		val         string
+		expected    []string
+		errExpected bool
+	}{
+		{
+			name:     "Empty",
+			val:      "",
+			expected: []string{},
+		},
+		{
+			name:     "Files and directories",
+			val:      "/etc/agent.d/, /etc/agent.conf",
+			expected: []string{"/etc/agent.conf", "/etc/agent.d/"},
+		},
+		{
+			name:     "Cleaned and deduplicated",
+			val:      "/etc/agent.d//,/etc/./agent.conf,/etc/agent.d/,,",
+			expected: []string{"/etc/agent.conf", "/etc/agent.d/"},
+		},
+		{
+			name:        "Relative",
+			val:         "etc/agent.conf",
+			errExpected: true,
+		},
+		{
+			name:        "Outside of /etc",
+			val:         "/var/lib/agent",
+			errExpected: true,
+		},
+		{
+			name:        "Escapes /etc",
+			val:         "/etc/../var/lib/agent",
+			errExpected: true,
+		},
+		{
+			name:        "All of /etc",
+			val:         "/etc/",
+			errExpected: true,
+		},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			paths, err := ParseUnmanagedPaths(testCase.val)
+			if testCase.errExpected {
+				assert.Error(t, err)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, testCase.expected, paths)
+		})
+	}
+}
+
+func TestIsUnmanagedPath(t *testing.T) {
+	t.Parallel()
+
+	unmanaged := []string{"/etc/agent.conf", "/etc/agent.d/"}
+
+	assert.True(t, IsUnmanagedPath("/etc/agent.conf", unmanaged))
+	assert.True(t, IsUnmanagedPath("/etc/agent.d/plugin.conf", unmanaged))
+	assert.True(t, IsUnmanagedPath("/etc/agent.d/nested/plugin.conf", unmanaged))
+	assert.False(t, IsUnmanagedPath("/etc/agent.conf.bak", unmanaged))
+	assert.False(t, IsUnmanagedPath("/etc/agent.d", unmanaged))
+	assert.False(t, IsUnmanagedPath("/etc/agent.dir/plugin.conf", unmanaged))
+	assert.False(t, IsUnmanagedPath("/etc/agent.conf", nil))
+}
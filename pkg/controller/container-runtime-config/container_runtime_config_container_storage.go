@@ -0,0 +1,408 @@
+package containerruntimeconfig
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/clarketm/json"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/klog/v2"
+
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	"github.com/openshift/machine-config-operator/pkg/version"
+)
+
+const (
+	// The storage.conf graphroot and runroot, which the image stores must not overlap with.
+	containerStorageGraphRoot = "/var/lib/containers/storage"
+	containerStorageRunRoot   = "/var/run/containers/storage"
+
+	// The overlay force_mask values which are not an octal mode.
+	containerStorageForceMaskPrivate = "private"
+	containerStorageForceMaskShared  = "shared"
+)
+
+var (
+	overlayMountOptionRegex = regexp.MustCompile(`^[a-zA-Z0-9_]+(=[a-zA-Z0-9_./:-]+)?$`)
+	octalModeRegex          = regexp.MustCompile(`^0?[0-7]{3,4}$`)
+
+	// The containers/storage pull options which can be set, all of which take "true" or "false".
+	containerStoragePullOptions = []string{"enable_partial_images", "use_hard_links", "convert_images"}
+)
+
+// containerStorageConfig is the container storage configuration for a MachineConfigPool, read from the
+// machineconfiguration.openshift.io/container-storage annotation.
+type containerStorageConfig struct {
+	// Overlay configures the overlay storage driver.
+	Overlay *containerStorageOverlayConfig `json:"overlay,omitempty"`
+	// ImageStore stores images separately from the containers in the graphroot.
+	ImageStore string `json:"imageStore,omitempty"`
+	// AdditionalImageStores are read-only image stores, e.g. on a shared filesystem.
+	AdditionalImageStores []string `json:"additionalImageStores,omitempty"`
+	// PullOptions are passed to containers/storage when pulling images.
+	PullOptions map[string]string `json:"pullOptions,omitempty"`
+}
+
+type containerStorageOverlayConfig struct {
+	// MountOptions are added to the options of every overlay mount.
+	MountOptions []string `json:"mountOptions,omitempty"`
+	// Size is the maximum size of a container's writable layer.
+	Size string `json:"size,omitempty"`
+	// ForceMask is the permissions mask for new files and directories, either an octal mode, private or shared.
+	ForceMask string `json:"forceMask,omitempty"`
+}
+
+// getContainerStorageConfig parses the container storage configuration of a pool. It returns nil if the pool does
+// not configure container storage.
+func getContainerStorageConfig(pool *mcfgv1.MachineConfigPool) (*containerStorageConfig, error) {
+	raw, ok := pool.Annotations[ctrlcommon.ContainerStorageAnnotationKey]
+	if !ok {
+		return nil, nil
+	}
+
+	cfg := &containerStorageConfig{}
+	if err := json.Unmarshal([]byte(raw), cfg); err != nil {
+		return nil, fmt.Errorf("could not parse %s annotation: %w", ctrlcommon.ContainerStorageAnnotationKey, err)
+	}
+
+	if err := validateContainerStorageConfig(cfg); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// validateContainerStorageConfig ensures that a container storage configuration is well formed and does not point
+// CRI-O at locations which would break the node.
+func validateContainerStorageConfig(cfg *containerStorageConfig) error {
+	if cfg.Overlay != nil {
+		for _, opt := range cfg.Overlay.MountOptions {
+			if !overlayMountOptionRegex.MatchString(opt) {
+				return fmt.Errorf("invalid overlay mount option %q", opt)
+			}
+		}
+
+		if cfg.Overlay.Size != "" {
+			size, err := resource.ParseQuantity(cfg.Overlay.Size)
+			if err != nil {
+				return fmt.Errorf("invalid overlay size %q: %w", cfg.Overlay.Size, err)
+			}
+			if size.Sign() <= 0 {
+				return fmt.Errorf("invalid overlay size %q: the size should be larger than 0", cfg.Overlay.Size)
+			}
+		}
+
+		if cfg.Overlay.ForceMask != "" && cfg.Overlay.ForceMask != containerStorageForceMaskPrivate &&
+			cfg.Overlay.ForceMask != containerStorageForceMaskShared && !octalModeRegex.MatchString(cfg.Overlay.ForceMask) {
+			return fmt.Errorf("invalid overlay force mask %q, must be an octal mode, %s or %s", cfg.Overlay.ForceMask, containerStorageForceMaskPrivate, containerStorageForceMaskShared)
+		}
+	}
+
+	stores := []string{}
+	if cfg.ImageStore != "" {
+		stores = append(stores, cfg.ImageStore)
+	}
+	stores = append(stores, cfg.AdditionalImageStores...)
+
+	seen := map[string]bool{}
+	for _, store := range stores {
+		if !filepath.IsAbs(store) || filepath.Clean(store) != store {
+			return fmt.Errorf("invalid image store %q, must be a clean absolute path", store)
+		}
+		if seen[store] {
+			return fmt.Errorf("image store %q is set more than once", store)
+		}
+		seen[store] = true
+
+		for _, root := range []string{containerStorageGraphRoot, containerStorageRunRoot} {
+			if pathsOverlap(store, root) {
+				return fmt.Errorf("image store %q overlaps with the container storage in %s", store, root)
+			}
+		}
+	}
+
+	for key, value := range cfg.PullOptions {
+		if !ctrlcommon.InSlice(key, containerStoragePullOptions) {
+			return fmt.Errorf("invalid pull option %q, must be one of %s", key, strings.Join(containerStoragePullOptions, ", "))
+		}
+		if value != "true" && value != "false" {
+			return fmt.Errorf("invalid value %q for pull option %s, must be true or false", value, key)
+		}
+	}
+
+	return nil
+}
+
+// pathsOverlap returns whether one of the paths is within the other.
+func pathsOverlap(a, b string) bool {
+	return a == b || strings.HasPrefix(a, b+"/") || strings.HasPrefix(b, a+"/")
+}
+
+// generateContainerStorageConfig merges the container storage configuration of a pool into storage.conf as rendered
+// from the templates. The overlaySize of ContainerRuntimeConfigs for the pool is merged in first, since the generated
+// storage.conf replaces the one of the ContainerRuntimeConfig MachineConfigs.
+func generateContainerStorageConfig(data []byte, cfg *containerStorageConfig, ctrcfgs []*mcfgv1.ContainerRuntimeConfig) ([]byte, error) {
+	for _, ctrcfg := range ctrcfgs {
+		if ctrcfg.Spec.ContainerRuntimeConfig == nil || ctrcfg.Spec.ContainerRuntimeConfig.OverlaySize.IsZero() {
+			continue
+		}
+
+		var err error
+		data, err = updateStorageConfig(data, ctrcfg.Spec.ContainerRuntimeConfig)
+		if err != nil {
+			return nil, fmt.Errorf("could not merge ContainerRuntimeConfig %s: %w", ctrcfg.Name, err)
+		}
+	}
+
+	tomlConf := new(tomlConfigStorage)
+	if _, err := toml.NewDecoder(bytes.NewBuffer(data)).Decode(tomlConf); err != nil {
+		return nil, fmt.Errorf("error decoding storage config: %w", err)
+	}
+
+	if cfg.Overlay != nil {
+		if len(cfg.Overlay.MountOptions) != 0 {
+			tomlConf.Storage.Options.Overlay.MountOpt = strings.Join(cfg.Overlay.MountOptions, ",")
+		}
+		if cfg.Overlay.Size != "" {
+			tomlConf.Storage.Options.Size = cfg.Overlay.Size
+		}
+		if cfg.Overlay.ForceMask != "" {
+			tomlConf.Storage.Options.Overlay.ForceMask = cfg.Overlay.ForceMask
+		}
+	}
+
+	if cfg.ImageStore != "" {
+		tomlConf.Storage.Options.ImageStore = cfg.ImageStore
+	}
+
+	if len(cfg.AdditionalImageStores) != 0 {
+		tomlConf.Storage.Options.AdditionalImageStores = cfg.AdditionalImageStores
+	}
+
+	if len(cfg.PullOptions) != 0 {
+		tomlConf.Storage.Options.PullOptions = cfg.PullOptions
+	}
+
+	var newData bytes.Buffer
+	if err := toml.NewEncoder(&newData).Encode(*tomlConf); err != nil {
+		return nil, fmt.Errorf("error encoding storage config: %w", err)
+	}
+
+	return newData.Bytes(), nil
+}
+
+// getManagedContainerStorageKey returns the name of the generated MachineConfig, which sorts after the
+// ContainerRuntimeConfig MachineConfigs so that its storage.conf wins.
+func getManagedContainerStorageKey(pool *mcfgv1.MachineConfigPool) string {
+	return fmt.Sprintf("99-%s-generated-storage", pool.Name)
+}
+
+// getContainerRuntimeConfigsForPool returns the ContainerRuntimeConfigs which apply to the pool, sorted by name.
+func (ctrl *Controller) getContainerRuntimeConfigsForPool(pool *mcfgv1.MachineConfigPool) ([]*mcfgv1.ContainerRuntimeConfig, error) {
+	ctrcfgs, err := ctrl.mccrLister.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+
+	matching := []*mcfgv1.ContainerRuntimeConfig{}
+	for _, ctrcfg := range ctrcfgs {
+		if ctrcfg.DeletionTimestamp != nil {
+			continue
+		}
+
+		pools, err := ctrl.getPoolsForContainerRuntimeConfig(ctrcfg)
+		if err != nil {
+			// The ContainerRuntimeConfig reports its own invalid selector.
+			continue
+		}
+		for _, p := range pools {
+			if p.Name == pool.Name {
+				matching = append(matching, ctrcfg)
+				break
+			}
+		}
+	}
+
+	sort.Slice(matching, func(i, j int) bool { return matching[i].Name < matching[j].Name })
+
+	return matching, nil
+}
+
+func (ctrl *Controller) storageWorker() {
+	for ctrl.processNextStorageWorkItem() {
+	}
+}
+
+func (ctrl *Controller) processNextStorageWorkItem() bool {
+	key, quit := ctrl.storageQueue.Get()
+	if quit {
+		return false
+	}
+	defer ctrl.storageQueue.Done(key)
+
+	err := ctrl.syncContainerStorageHandler(key.(string))
+	ctrl.handleStorageErr(err, key)
+
+	return true
+}
+
+func (ctrl *Controller) handleStorageErr(err error, key interface{}) {
+	if err == nil {
+		ctrl.storageQueue.Forget(key)
+		return
+	}
+
+	if ctrl.storageQueue.NumRequeues(key) < maxRetries {
+		klog.V(2).Infof("Error syncing container storage for pool %v: %v", key, err)
+		ctrl.storageQueue.AddRateLimited(key)
+		return
+	}
+
+	utilruntime.HandleError(err)
+	klog.V(2).Infof("Dropping container storage for pool %q out of the queue: %v", key, err)
+	ctrl.storageQueue.Forget(key)
+	ctrl.storageQueue.AddAfter(key, 1*time.Minute)
+}
+
+// syncContainerStorageHandler renders the container storage configuration of a pool into a generated MachineConfig,
+// or deletes it once the pool no longer configures container storage. An invalid configuration is reported through
+// an event and leaves the existing MachineConfig in place.
+func (ctrl *Controller) syncContainerStorageHandler(key string) error {
+	startTime := time.Now()
+	klog.V(4).Infof("Started syncing container storage for pool %q (%v)", key, startTime)
+	defer func() {
+		klog.V(4).Infof("Finished syncing container storage for pool %q (%v)", key, time.Since(startTime))
+	}()
+
+	pool, err := ctrl.mcpLister.Get(key)
+	if errors.IsNotFound(err) {
+		klog.V(2).Infof("MachineConfigPool %v has been deleted", key)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	managedKey := getManagedContainerStorageKey(pool)
+
+	cfg, err := getContainerStorageConfig(pool)
+	if err != nil {
+		ctrl.eventRecorder.Eventf(pool, corev1.EventTypeWarning, "InvalidContainerStorageConfig", "Invalid container storage configuration: %v", err)
+		klog.Warningf("Invalid container storage configuration for pool %s: %v", pool.Name, err)
+		return nil
+	}
+
+	if cfg == nil {
+		err := ctrl.client.MachineconfigurationV1().MachineConfigs().Delete(context.TODO(), managedKey, metav1.DeleteOptions{})
+		if err != nil && !errors.IsNotFound(err) {
+			return fmt.Errorf("could not delete container storage MachineConfig %s: %w", managedKey, err)
+		}
+		return nil
+	}
+
+	controllerConfig, err := ctrl.ccLister.Get(ctrlcommon.ControllerConfigName)
+	if err != nil {
+		return fmt.Errorf("could not get ControllerConfig %w", err)
+	}
+
+	originalStorageIgn, _, _, err := generateOriginalContainerRuntimeConfigs(ctrl.templatesDir, controllerConfig, pool.Name, ctrl.featureGateAccess)
+	if err != nil {
+		return fmt.Errorf("could not generate origin ContainerRuntime Configs: %w", err)
+	}
+	if originalStorageIgn.Contents.Source == nil {
+		return fmt.Errorf("original storage config is empty")
+	}
+	originalStorage, err := ctrlcommon.DecodeIgnitionFileContents(originalStorageIgn.Contents.Source, originalStorageIgn.Contents.Compression)
+	if err != nil {
+		return fmt.Errorf("could not decode original storage config: %w", err)
+	}
+
+	ctrcfgs, err := ctrl.getContainerRuntimeConfigsForPool(pool)
+	if err != nil {
+		return fmt.Errorf("could not list ContainerRuntimeConfigs for pool %s: %w", pool.Name, err)
+	}
+
+	storageTOML, err := generateContainerStorageConfig(originalStorage, cfg, ctrcfgs)
+	if err != nil {
+		ctrl.eventRecorder.Eventf(pool, corev1.EventTypeWarning, "InvalidContainerStorageConfig", "Invalid container storage configuration: %v", err)
+		klog.Warningf("Invalid container storage configuration for pool %s: %v", pool.Name, err)
+		return nil
+	}
+
+	rawIgn, err := json.Marshal(createNewIgnition([]generatedConfigFile{{filePath: storageConfigPath, data: storageTOML}}))
+	if err != nil {
+		return err
+	}
+
+	mc, err := ctrl.client.MachineconfigurationV1().MachineConfigs().Get(context.TODO(), managedKey, metav1.GetOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	isNotFound := errors.IsNotFound(err)
+	if isNotFound {
+		mc, err = ctrlcommon.MachineConfigFromIgnConfig(pool.Name, managedKey, ctrlcommon.NewIgnConfig())
+		if err != nil {
+			return err
+		}
+	}
+
+	mc.Spec.Config.Raw = rawIgn
+	mc.ObjectMeta.Annotations = map[string]string{
+		ctrlcommon.GeneratedByControllerVersionAnnotationKey: version.Hash,
+	}
+
+	// Create or Update, on conflict retry
+	if err := retry.RetryOnConflict(updateBackoff, func() error {
+		var err error
+		if isNotFound {
+			_, err = ctrl.client.MachineconfigurationV1().MachineConfigs().Create(context.TODO(), mc, metav1.CreateOptions{})
+		} else {
+			_, err = ctrl.client.MachineconfigurationV1().MachineConfigs().Update(context.TODO(), mc, metav1.UpdateOptions{})
+		}
+		return err
+	}); err != nil {
+		return fmt.Errorf("could not Create/Update MachineConfig: %w", err)
+	}
+
+	klog.Infof("Applied container storage configuration on MachineConfigPool %v", pool.Name)
+	return nil
+}
+
+func (ctrl *Controller) enqueueContainerStorage(pool *mcfgv1.MachineConfigPool) {
+	key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(pool)
+	if err != nil {
+		utilruntime.HandleError(fmt.Errorf("couldn't get key for object %#v: %w", pool, err))
+		return
+	}
+	ctrl.storageQueue.Add(key)
+}
+
+// enqueueContainerStorageForContainerRuntimeConfig resyncs the container storage of the pools the
+// ContainerRuntimeConfig applies to, since their generated storage.conf carries its overlaySize.
+func (ctrl *Controller) enqueueContainerStorageForContainerRuntimeConfig(cfg *mcfgv1.ContainerRuntimeConfig) {
+	pools, err := ctrl.getPoolsForContainerRuntimeConfig(cfg)
+	if err != nil {
+		return
+	}
+
+	for _, pool := range pools {
+		if _, ok := pool.Annotations[ctrlcommon.ContainerStorageAnnotationKey]; ok {
+			ctrl.enqueueContainerStorage(pool)
+		}
+	}
+}
@@ -0,0 +1,253 @@
+package containerruntimeconfig
+
+import (
+	"context"
+	"testing"
+
+	"github.com/BurntSushi/toml"
+	apicfgv1 "github.com/openshift/api/config/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	"github.com/openshift/machine-config-operator/test/helpers"
+)
+
+func TestValidateContainerStorageConfig(t *testing.T) {
+	testCases := []struct {
+		name        string
+		cfg         containerStorageConfig
+		errContains string
+	}{
+		{
+			name: "all options",
+			cfg: containerStorageConfig{
+				Overlay:               &containerStorageOverlayConfig{MountOptions: []string{"nodev", "metacopy=on"}, Size: "10G", ForceMask: "0755"},
+				ImageStore:            "/var/lib/images",
+				AdditionalImageStores: []string{"/mnt/shared-images"},
+				PullOptions:           map[string]string{"enable_partial_images": "true", "use_hard_links": "false"},
+			},
+		},
+		{
+			name: "shared force mask",
+			cfg:  containerStorageConfig{Overlay: &containerStorageOverlayConfig{ForceMask: "shared"}},
+		},
+		{
+			name:        "invalid mount option",
+			cfg:         containerStorageConfig{Overlay: &containerStorageOverlayConfig{MountOptions: []string{"nodev,suid"}}},
+			errContains: `invalid overlay mount option "nodev,suid"`,
+		},
+		{
+			name:        "invalid size",
+			cfg:         containerStorageConfig{Overlay: &containerStorageOverlayConfig{Size: "ten gigs"}},
+			errContains: `invalid overlay size "ten gigs"`,
+		},
+		{
+			name:        "negative size",
+			cfg:         containerStorageConfig{Overlay: &containerStorageOverlayConfig{Size: "-1G"}},
+			errContains: "the size should be larger than 0",
+		},
+		{
+			name:        "invalid force mask",
+			cfg:         containerStorageConfig{Overlay: &containerStorageOverlayConfig{ForceMask: "0999"}},
+			errContains: `invalid overlay force mask "0999"`,
+		},
+		{
+			name:        "relative image store",
+			cfg:         containerStorageConfig{ImageStore: "images"},
+			errContains: `invalid image store "images"`,
+		},
+		{
+			name:        "image store in the graphroot",
+			cfg:         containerStorageConfig{ImageStore: "/var/lib/containers/storage/images"},
+			errContains: "overlaps with the container storage in /var/lib/containers/storage",
+		},
+		{
+			name:        "additional image store containing the graphroot",
+			cfg:         containerStorageConfig{AdditionalImageStores: []string{"/var/lib"}},
+			errContains: "overlaps with the container storage in /var/lib/containers/storage",
+		},
+		{
+			name:        "duplicate image store",
+			cfg:         containerStorageConfig{ImageStore: "/var/lib/images", AdditionalImageStores: []string{"/var/lib/images"}},
+			errContains: `image store "/var/lib/images" is set more than once`,
+		},
+		{
+			name:        "unknown pull option",
+			cfg:         containerStorageConfig{PullOptions: map[string]string{"ostree_repos": "/ostree/repo"}},
+			errContains: `invalid pull option "ostree_repos"`,
+		},
+		{
+			name:        "invalid pull option value",
+			cfg:         containerStorageConfig{PullOptions: map[string]string{"use_hard_links": "yes"}},
+			errContains: `invalid value "yes" for pull option use_hard_links`,
+		},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+		t.Run(testCase.name, func(t *testing.T) {
+			err := validateContainerStorageConfig(&testCase.cfg)
+			if testCase.errContains == "" {
+				assert.NoError(t, err)
+				return
+			}
+
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), testCase.errContains)
+		})
+	}
+}
+
+func TestGenerateContainerStorageConfig(t *testing.T) {
+	original := []byte(`[storage]
+driver = "overlay"
+runroot = "/var/run/containers/storage"
+graphroot = "/var/lib/containers/storage"
+
+[storage.options]
+additionalimagestores = []
+size = ""
+
+[storage.options.overlay]
+skip_mount_home = "true"
+`)
+
+	ctrcfg := newContainerRuntimeConfig("overlay-size", &mcfgv1.ContainerRuntimeConfiguration{OverlaySize: resource.MustParse("3G")}, nil)
+
+	t.Run("merges the ContainerRuntimeConfig overlaySize", func(t *testing.T) {
+		cfg := &containerStorageConfig{
+			Overlay:               &containerStorageOverlayConfig{MountOptions: []string{"nodev", "metacopy=on"}},
+			AdditionalImageStores: []string{"/mnt/shared-images"},
+			PullOptions:           map[string]string{"enable_partial_images": "true"},
+		}
+
+		data, err := generateContainerStorageConfig(original, cfg, []*mcfgv1.ContainerRuntimeConfig{ctrcfg})
+		require.NoError(t, err)
+
+		tomlConf := tomlConfigStorage{}
+		_, err = toml.Decode(string(data), &tomlConf)
+		require.NoError(t, err)
+		assert.Equal(t, "overlay", tomlConf.Storage.Driver)
+		assert.Equal(t, "3G", tomlConf.Storage.Options.Size)
+		assert.Equal(t, "nodev,metacopy=on", tomlConf.Storage.Options.Overlay.MountOpt)
+		assert.Equal(t, "true", tomlConf.Storage.Options.Overlay.SkipMountHome)
+		assert.Equal(t, []string{"/mnt/shared-images"}, tomlConf.Storage.Options.AdditionalImageStores)
+		assert.Equal(t, map[string]string{"enable_partial_images": "true"}, tomlConf.Storage.Options.PullOptions)
+		assert.Empty(t, tomlConf.Storage.Options.ImageStore)
+	})
+
+	t.Run("overlay size overrides the ContainerRuntimeConfig", func(t *testing.T) {
+		cfg := &containerStorageConfig{
+			Overlay:    &containerStorageOverlayConfig{Size: "10G", ForceMask: "private"},
+			ImageStore: "/var/lib/images",
+		}
+
+		data, err := generateContainerStorageConfig(original, cfg, []*mcfgv1.ContainerRuntimeConfig{ctrcfg})
+		require.NoError(t, err)
+
+		tomlConf := tomlConfigStorage{}
+		_, err = toml.Decode(string(data), &tomlConf)
+		require.NoError(t, err)
+		assert.Equal(t, "10G", tomlConf.Storage.Options.Size)
+		assert.Equal(t, "private", tomlConf.Storage.Options.Overlay.ForceMask)
+		assert.Equal(t, "/var/lib/images", tomlConf.Storage.Options.ImageStore)
+	})
+}
+
+func TestContainerStorageSync(t *testing.T) {
+	managedKey := "99-worker-generated-storage"
+
+	newPool := func(annotation string) *mcfgv1.MachineConfigPool {
+		pool := helpers.NewMachineConfigPool("worker", nil, helpers.WorkerSelector, "v0")
+		if annotation != "" {
+			pool.Annotations = map[string]string{ctrlcommon.ContainerStorageAnnotationKey: annotation}
+		}
+		return pool
+	}
+
+	newController := func(f *fixture, pool *mcfgv1.MachineConfigPool) *Controller {
+		f.ccLister = append(f.ccLister, newControllerConfig(ctrlcommon.ControllerConfigName, apicfgv1.AWSPlatformType))
+		f.mcpLister = append(f.mcpLister, pool)
+		return f.newController()
+	}
+
+	existingMC := func() *mcfgv1.MachineConfig {
+		return helpers.NewMachineConfig(managedKey, map[string]string{mcfgv1.MachineConfigRoleLabelKey: "worker"}, "", nil)
+	}
+
+	getStorageConf := func(t *testing.T, f *fixture) *tomlConfigStorage {
+		mc, err := f.client.MachineconfigurationV1().MachineConfigs().Get(context.TODO(), managedKey, metav1.GetOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, "worker", mc.Labels[mcfgv1.MachineConfigRoleLabelKey])
+
+		ignCfg, err := ctrlcommon.ParseAndConvertConfig(mc.Spec.Config.Raw)
+		require.NoError(t, err)
+		data, err := ctrlcommon.GetIgnitionFileDataByPath(&ignCfg, storageConfigPath)
+		require.NoError(t, err)
+		require.NotNil(t, data)
+
+		tomlConf := &tomlConfigStorage{}
+		_, err = toml.Decode(string(data), tomlConf)
+		require.NoError(t, err)
+		return tomlConf
+	}
+
+	t.Run("creates the generated MachineConfig", func(t *testing.T) {
+		f := newFixture(t)
+		pool := newPool(`{"pullOptions":{"enable_partial_images":"true"},"additionalImageStores":["/mnt/shared-images"]}`)
+		c := newController(f, pool)
+
+		require.NoError(t, c.syncContainerStorageHandler(pool.Name))
+
+		tomlConf := getStorageConf(t, f)
+		assert.Equal(t, "/var/lib/containers/storage", tomlConf.Storage.GraphRoot)
+		assert.Equal(t, []string{"/mnt/shared-images"}, tomlConf.Storage.Options.AdditionalImageStores)
+		assert.Equal(t, "true", tomlConf.Storage.Options.PullOptions["enable_partial_images"])
+	})
+
+	t.Run("carries the ContainerRuntimeConfig overlaySize", func(t *testing.T) {
+		f := newFixture(t)
+		ctrcfg := newContainerRuntimeConfig("overlay-size", &mcfgv1.ContainerRuntimeConfiguration{OverlaySize: resource.MustParse("3G")}, metav1.AddLabelToSelector(&metav1.LabelSelector{}, "pools.operator.machineconfiguration.openshift.io/worker", ""))
+		f.mccrLister = append(f.mccrLister, ctrcfg)
+		pool := newPool(`{"overlay":{"mountOptions":["nodev"]}}`)
+		c := newController(f, pool)
+
+		require.NoError(t, c.syncContainerStorageHandler(pool.Name))
+
+		tomlConf := getStorageConf(t, f)
+		assert.Equal(t, "3G", tomlConf.Storage.Options.Size)
+		assert.Equal(t, "nodev", tomlConf.Storage.Options.Overlay.MountOpt)
+	})
+
+	t.Run("invalid config leaves the generated MachineConfig alone", func(t *testing.T) {
+		f := newFixture(t)
+		f.objects = append(f.objects, existingMC())
+		pool := newPool(`{"imageStore":"/var/lib/containers/storage"}`)
+		c := newController(f, pool)
+
+		require.NoError(t, c.syncContainerStorageHandler(pool.Name))
+
+		mc, err := f.client.MachineconfigurationV1().MachineConfigs().Get(context.TODO(), managedKey, metav1.GetOptions{})
+		require.NoError(t, err)
+		ignCfg, err := ctrlcommon.ParseAndConvertConfig(mc.Spec.Config.Raw)
+		require.NoError(t, err)
+		assert.Empty(t, ignCfg.Storage.Files)
+	})
+
+	t.Run("removing the annotation deletes the generated MachineConfig", func(t *testing.T) {
+		f := newFixture(t)
+		f.objects = append(f.objects, existingMC())
+		pool := newPool("")
+		c := newController(f, pool)
+
+		require.NoError(t, c.syncContainerStorageHandler(pool.Name))
+
+		_, err := f.client.MachineconfigurationV1().MachineConfigs().Get(context.TODO(), managedKey, metav1.GetOptions{})
+		assert.True(t, errors.IsNotFound(err))
+	})
+}
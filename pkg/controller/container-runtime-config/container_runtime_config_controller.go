@@ -41,6 +41,7 @@ import (
 	"github.com/openshift/client-go/machineconfiguration/clientset/versioned/scheme"
 	mcfginformersv1 "github.com/openshift/client-go/machineconfiguration/informers/externalversions/machineconfiguration/v1"
 	mcfglistersv1 "github.com/openshift/client-go/machineconfiguration/listers/machineconfiguration/v1"
+	"github.com/openshift/machine-config-operator/pkg/apihelpers/conditions"
 	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
 	mtmpl "github.com/openshift/machine-config-operator/pkg/controller/template"
 	"github.com/openshift/machine-config-operator/pkg/version"
@@ -74,6 +75,7 @@ type Controller struct {
 
 	client        mcfgclientset.Interface
 	configClient  configclientset.Interface
+	kubeClient    clientset.Interface
 	eventRecorder record.EventRecorder
 
 	syncHandler                   func(mcp string) error
@@ -106,8 +108,10 @@ type Controller struct {
 
 	featureGateAccess featuregates.FeatureGateAccess
 
-	queue    workqueue.RateLimitingInterface
-	imgQueue workqueue.RateLimitingInterface
+	queue          workqueue.RateLimitingInterface
+	imgQueue       workqueue.RateLimitingInterface
+	sandboxedQueue workqueue.RateLimitingInterface
+	storageQueue   workqueue.RateLimitingInterface
 }
 
 // New returns a new container runtime config controller
@@ -131,13 +135,21 @@ func New(
 	eventBroadcaster.StartRecordingToSink(&coreclientsetv1.EventSinkImpl{Interface: kubeClient.CoreV1().Events("")})
 
 	ctrl := &Controller{
-		templatesDir:  templatesDir,
-		client:        mcfgClient,
-		configClient:  configClient,
-		eventRecorder: ctrlcommon.NamespacedEventRecorder(eventBroadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: "machineconfigcontroller-containerruntimeconfigcontroller"})),
-		queue:         workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "machineconfigcontroller-containerruntimeconfigcontroller"),
-		imgQueue:      workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()),
-	}
+		templatesDir:   templatesDir,
+		client:         mcfgClient,
+		configClient:   configClient,
+		kubeClient:     kubeClient,
+		eventRecorder:  ctrlcommon.NamespacedEventRecorder(eventBroadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: "machineconfigcontroller-containerruntimeconfigcontroller"})),
+		queue:          workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "machineconfigcontroller-containerruntimeconfigcontroller"),
+		imgQueue:       workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()),
+		sandboxedQueue: workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "machineconfigcontroller-sandboxedcontainerscontroller"),
+		storageQueue:   workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "machineconfigcontroller-containerstoragecontroller"),
+	}
+
+	mcpInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    ctrl.addMachineConfigPool,
+		UpdateFunc: ctrl.updateMachineConfigPool,
+	})
 
 	mcrInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
 		AddFunc:    ctrl.addContainerRuntimeConfig,
@@ -207,6 +219,8 @@ func (ctrl *Controller) Run(workers int, stopCh <-chan struct{}) {
 	defer utilruntime.HandleCrash()
 	defer ctrl.queue.ShutDown()
 	defer ctrl.imgQueue.ShutDown()
+	defer ctrl.sandboxedQueue.ShutDown()
+	defer ctrl.storageQueue.ShutDown()
 
 	if !cache.WaitForCacheSync(stopCh, ctrl.mcpListerSynced, ctrl.mccrListerSynced, ctrl.ccListerSynced,
 		ctrl.imgListerSynced, ctrl.icspListerSynced, ctrl.idmsListerSynced, ctrl.itmsListerSynced, ctrl.clusterVersionListerSynced) {
@@ -223,6 +237,12 @@ func (ctrl *Controller) Run(workers int, stopCh <-chan struct{}) {
 	// Just need one worker for the image config
 	go wait.Until(ctrl.imgWorker, time.Second, stopCh)
 
+	// Sandboxed containers only change with pool annotations, so a single worker is enough
+	go wait.Until(ctrl.sandboxedWorker, time.Second, stopCh)
+
+	// The same goes for container storage
+	go wait.Until(ctrl.storageWorker, time.Second, stopCh)
+
 	<-stopCh
 }
 
@@ -479,20 +499,13 @@ func (ctrl *Controller) syncStatusOnly(cfg *mcfgv1.ContainerRuntimeConfig, err e
 		if getErr != nil {
 			return getErr
 		}
-		// Update the observedGeneration
-		if newcfg.GetGeneration() != newcfg.Status.ObservedGeneration {
-			newcfg.Status.ObservedGeneration = newcfg.GetGeneration()
-		}
+		conditions.SetObservedGeneration(newcfg, &newcfg.Status.ObservedGeneration)
 		// To avoid a long list of same statuses, only append a status if it is the first status
 		// or if the status message is different from the message of the last status recorded
 		// If the last status message is the same as the new one, then update the last status to
 		// reflect the latest time stamp from the new status message.
 		newStatusCondition := wrapErrorWithCondition(err, args...)
-		if len(newcfg.Status.Conditions) == 0 || newStatusCondition.Message != newcfg.Status.Conditions[len(newcfg.Status.Conditions)-1].Message {
-			newcfg.Status.Conditions = append(newcfg.Status.Conditions, newStatusCondition)
-		} else if newcfg.Status.Conditions[len(newcfg.Status.Conditions)-1].Message == newStatusCondition.Message {
-			newcfg.Status.Conditions[len(newcfg.Status.Conditions)-1] = newStatusCondition
-		}
+		conditions.ContainerRuntimeConfig.Record(&newcfg.Status.Conditions, newStatusCondition, 0)
 		_, updateErr := ctrl.client.MachineconfigurationV1().ContainerRuntimeConfigs().UpdateStatus(context.TODO(), newcfg, metav1.UpdateOptions{})
 		return updateErr
 	})
@@ -553,6 +566,7 @@ func (ctrl *Controller) syncContainerRuntimeConfig(key string) error {
 
 	// Check for Deleted ContainerRuntimeConfig and optionally delete finalizers
 	if cfg.DeletionTimestamp != nil {
+		ctrl.enqueueContainerStorageForContainerRuntimeConfig(cfg)
 		if len(cfg.GetFinalizers()) > 0 {
 			return ctrl.cascadeDelete(cfg)
 		}
@@ -684,11 +698,14 @@ func (ctrl *Controller) syncContainerRuntimeConfig(key string) error {
 			return ctrl.syncStatusOnly(cfg, err, "could not add finalizers to ContainerRuntimeConfig: %v", err)
 		}
 		klog.Infof("Applied ContainerRuntimeConfig %v on MachineConfigPool %v", key, pool.Name)
+
 	}
 	if err := ctrl.cleanUpDuplicatedMC(); err != nil {
 		return err
 	}
 
+	ctrl.enqueueContainerStorageForContainerRuntimeConfig(cfg)
+
 	return ctrl.syncStatusOnly(cfg, nil)
 }
 
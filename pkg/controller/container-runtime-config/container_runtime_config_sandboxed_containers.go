@@ -0,0 +1,435 @@
+package containerruntimeconfig
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/clarketm/json"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/klog/v2"
+
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	"github.com/openshift/machine-config-operator/pkg/version"
+)
+
+const (
+	// sandboxedContainersRuntimeKata runs pods in a local VM using kata containers.
+	sandboxedContainersRuntimeKata = "kata"
+	// sandboxedContainersRuntimePeerPods runs pods in a VM created by the cloud provider (peer-pods).
+	sandboxedContainersRuntimePeerPods = "peer-pods"
+
+	// sandboxedContainersTEETDX runs kata VMs as Intel TDX confidential VMs.
+	sandboxedContainersTEETDX = "tdx"
+	// sandboxedContainersTEESNP runs kata VMs as AMD SEV-SNP confidential VMs.
+	sandboxedContainersTEESNP = "snp"
+
+	// sandboxedContainersExtension is the RHCOS extension which ships the kata shim and hypervisor.
+	sandboxedContainersExtension = "sandboxed-containers"
+
+	kataShimPath         = "/usr/bin/containerd-shim-kata-v2"
+	kataRuntimeRoot      = "/run/vc"
+	kataDefaultsDir      = "/usr/share/kata-containers/defaults"
+	crioDropInDirPath    = "/etc/crio/crio.conf.d"
+	sandboxedModulesPath = "/etc/modules-load.d/sandboxed-containers.conf"
+
+	// Node Feature Discovery labels used to verify that the nodes of a pool can run sandboxed containers.
+	nfdVMXLabel = "feature.node.kubernetes.io/cpu-cpuid.VMX"
+	nfdSVMLabel = "feature.node.kubernetes.io/cpu-cpuid.SVM"
+	nfdTDXLabel = "feature.node.kubernetes.io/cpu-security.tdx.enabled"
+	nfdSNPLabel = "feature.node.kubernetes.io/cpu-security.sev.snp.enabled"
+)
+
+var kernelModuleNameRegex = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+// sandboxedContainersConfig is the sandboxed containers configuration for a MachineConfigPool, read from the
+// machineconfiguration.openshift.io/sandboxed-containers annotation.
+type sandboxedContainersConfig struct {
+	// Runtime is either kata or peer-pods.
+	Runtime string `json:"runtime"`
+	// TEE optionally runs kata VMs as confidential VMs, either tdx or snp.
+	TEE string `json:"tee,omitempty"`
+	// KernelArguments are added to the kernel arguments required by the runtime.
+	KernelArguments []string `json:"kernelArguments,omitempty"`
+	// Modules are kernel modules to load in addition to the ones required by the runtime.
+	Modules []string `json:"modules,omitempty"`
+}
+
+// sandboxedContainersProfile describes what a node needs to run a sandboxed containers runtime.
+type sandboxedContainersProfile struct {
+	// handler is the name of the CRI-O runtime handler, referenced by RuntimeClasses.
+	handler         string
+	runtimeType     string
+	configPath      string
+	kernelArguments []string
+	modules         []string
+	// nodeLabels are Node Feature Discovery labels, one of which must be "true" on every node in the pool.
+	nodeLabels []string
+}
+
+// tomlConfigCRIORuntimeHandler is used to render the CRI-O runtime table for a sandboxed containers runtime.
+type tomlConfigCRIORuntimeHandler struct {
+	Crio struct {
+		Runtime struct {
+			Runtimes map[string]tomlCRIORuntimeHandler `toml:"runtimes"`
+		} `toml:"runtime"`
+	} `toml:"crio"`
+}
+
+type tomlCRIORuntimeHandler struct {
+	RuntimePath                  string `toml:"runtime_path"`
+	RuntimeType                  string `toml:"runtime_type"`
+	RuntimeRoot                  string `toml:"runtime_root"`
+	RuntimeConfigPath            string `toml:"runtime_config_path"`
+	PrivilegedWithoutHostDevices bool   `toml:"privileged_without_host_devices"`
+}
+
+// getSandboxedContainersProfile returns what the given configuration requires on the nodes of a pool.
+func getSandboxedContainersProfile(cfg *sandboxedContainersConfig) sandboxedContainersProfile {
+	if cfg.Runtime == sandboxedContainersRuntimePeerPods {
+		// The pod VM runs outside of the node, so neither virtualization support nor vhost is needed locally.
+		return sandboxedContainersProfile{
+			handler:     "kata-remote",
+			runtimeType: "vm",
+			configPath:  kataDefaultsDir + "/configuration-remote.toml",
+		}
+	}
+
+	profile := sandboxedContainersProfile{
+		handler:     "kata",
+		runtimeType: "vm",
+		configPath:  kataDefaultsDir + "/configuration.toml",
+		modules:     []string{"vhost", "vhost_net", "vhost_vsock"},
+		nodeLabels:  []string{nfdVMXLabel, nfdSVMLabel},
+	}
+
+	switch cfg.TEE {
+	case sandboxedContainersTEETDX:
+		profile.handler = "kata-tdx"
+		profile.configPath = kataDefaultsDir + "/configuration-qemu-tdx.toml"
+		profile.kernelArguments = []string{"kvm_intel.tdx=1"}
+		profile.nodeLabels = []string{nfdTDXLabel}
+	case sandboxedContainersTEESNP:
+		profile.handler = "kata-snp"
+		profile.configPath = kataDefaultsDir + "/configuration-qemu-snp.toml"
+		profile.kernelArguments = []string{"mem_encrypt=on", "kvm_amd.sev=1", "kvm_amd.sev_snp=1"}
+		profile.nodeLabels = []string{nfdSNPLabel}
+	}
+
+	return profile
+}
+
+// getSandboxedContainersConfig parses the sandboxed containers configuration of a pool. It returns nil if the pool
+// does not enable sandboxed containers.
+func getSandboxedContainersConfig(pool *mcfgv1.MachineConfigPool) (*sandboxedContainersConfig, error) {
+	raw, ok := pool.Annotations[ctrlcommon.SandboxedContainersAnnotationKey]
+	if !ok {
+		return nil, nil
+	}
+
+	cfg := &sandboxedContainersConfig{}
+	if err := json.Unmarshal([]byte(raw), cfg); err != nil {
+		return nil, fmt.Errorf("could not parse %s annotation: %w", ctrlcommon.SandboxedContainersAnnotationKey, err)
+	}
+
+	if err := validateSandboxedContainersConfig(cfg); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// validateSandboxedContainersConfig ensures that a sandboxed containers configuration is well formed.
+func validateSandboxedContainersConfig(cfg *sandboxedContainersConfig) error {
+	switch cfg.Runtime {
+	case sandboxedContainersRuntimeKata:
+		if cfg.TEE != "" && cfg.TEE != sandboxedContainersTEETDX && cfg.TEE != sandboxedContainersTEESNP {
+			return fmt.Errorf("invalid TEE %q, must be %s or %s", cfg.TEE, sandboxedContainersTEETDX, sandboxedContainersTEESNP)
+		}
+	case sandboxedContainersRuntimePeerPods:
+		if cfg.TEE != "" {
+			return fmt.Errorf("a TEE cannot be set for %s, confidential pod VMs are configured through the cloud provider", sandboxedContainersRuntimePeerPods)
+		}
+	default:
+		return fmt.Errorf("invalid runtime %q, must be %s or %s", cfg.Runtime, sandboxedContainersRuntimeKata, sandboxedContainersRuntimePeerPods)
+	}
+
+	for _, karg := range cfg.KernelArguments {
+		if karg == "" || strings.ContainsAny(karg, " \t\n") {
+			return fmt.Errorf("invalid kernel argument %q", karg)
+		}
+	}
+
+	for _, module := range cfg.Modules {
+		if !kernelModuleNameRegex.MatchString(module) {
+			return fmt.Errorf("invalid kernel module name %q", module)
+		}
+	}
+
+	return nil
+}
+
+// validateSandboxedContainersNodes ensures that every node in the pool reports, through Node Feature Discovery, the
+// virtualization capabilities needed by the runtime.
+func validateSandboxedContainersNodes(profile sandboxedContainersProfile, nodes []corev1.Node) error {
+	if len(profile.nodeLabels) == 0 {
+		return nil
+	}
+
+	unsupported := []string{}
+	for _, node := range nodes {
+		supported := false
+		for _, label := range profile.nodeLabels {
+			if node.Labels[label] == "true" {
+				supported = true
+				break
+			}
+		}
+		if !supported {
+			unsupported = append(unsupported, node.Name)
+		}
+	}
+
+	if len(unsupported) != 0 {
+		sort.Strings(unsupported)
+		return fmt.Errorf("node(s) %s do not report the virtualization support required by runtime handler %s (one of the labels %s must be \"true\")",
+			strings.Join(unsupported, ", "), profile.handler, strings.Join(profile.nodeLabels, ", "))
+	}
+
+	return nil
+}
+
+// generateSandboxedContainersMachineConfigSpec returns the kernel arguments, extensions and Ignition config to
+// apply to the pool for the given sandboxed containers configuration.
+func generateSandboxedContainersMachineConfigSpec(cfg *sandboxedContainersConfig, nodes []corev1.Node) ([]string, []string, []byte, error) {
+	profile := getSandboxedContainersProfile(cfg)
+
+	if err := validateSandboxedContainersNodes(profile, nodes); err != nil {
+		return nil, nil, nil, err
+	}
+
+	tomlConf := tomlConfigCRIORuntimeHandler{}
+	tomlConf.Crio.Runtime.Runtimes = map[string]tomlCRIORuntimeHandler{
+		profile.handler: {
+			RuntimePath:                  kataShimPath,
+			RuntimeType:                  profile.runtimeType,
+			RuntimeRoot:                  kataRuntimeRoot,
+			RuntimeConfigPath:            profile.configPath,
+			PrivilegedWithoutHostDevices: true,
+		},
+	}
+
+	var runtimeData bytes.Buffer
+	if err := toml.NewEncoder(&runtimeData).Encode(tomlConf); err != nil {
+		return nil, nil, nil, fmt.Errorf("error encoding toml for CRI-O runtime handler %s: %w", profile.handler, err)
+	}
+
+	configs := []generatedConfigFile{
+		{filePath: fmt.Sprintf("%s/50-%s", crioDropInDirPath, profile.handler), data: runtimeData.Bytes()},
+	}
+
+	modules := dedupeStrings(append(profile.modules, cfg.Modules...))
+	if len(modules) != 0 {
+		configs = append(configs, generatedConfigFile{filePath: sandboxedModulesPath, data: []byte(strings.Join(modules, "\n") + "\n")})
+	}
+
+	rawIgn, err := json.Marshal(createNewIgnition(configs))
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	kargs := dedupeStrings(append(profile.kernelArguments, cfg.KernelArguments...))
+
+	return kargs, []string{sandboxedContainersExtension}, rawIgn, nil
+}
+
+// dedupeStrings removes duplicates while keeping the order of the first occurrences.
+func dedupeStrings(in []string) []string {
+	seen := map[string]bool{}
+	out := []string{}
+	for _, s := range in {
+		if !seen[s] {
+			seen[s] = true
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+func getManagedSandboxedContainersKey(pool *mcfgv1.MachineConfigPool) string {
+	return fmt.Sprintf("99-%s-generated-sandboxed-containers", pool.Name)
+}
+
+func (ctrl *Controller) sandboxedWorker() {
+	for ctrl.processNextSandboxedWorkItem() {
+	}
+}
+
+func (ctrl *Controller) processNextSandboxedWorkItem() bool {
+	key, quit := ctrl.sandboxedQueue.Get()
+	if quit {
+		return false
+	}
+	defer ctrl.sandboxedQueue.Done(key)
+
+	err := ctrl.syncSandboxedContainersHandler(key.(string))
+	ctrl.handleSandboxedErr(err, key)
+
+	return true
+}
+
+func (ctrl *Controller) handleSandboxedErr(err error, key interface{}) {
+	if err == nil {
+		ctrl.sandboxedQueue.Forget(key)
+		return
+	}
+
+	if ctrl.sandboxedQueue.NumRequeues(key) < maxRetries {
+		klog.V(2).Infof("Error syncing sandboxed containers for pool %v: %v", key, err)
+		ctrl.sandboxedQueue.AddRateLimited(key)
+		return
+	}
+
+	utilruntime.HandleError(err)
+	klog.V(2).Infof("Dropping sandboxed containers for pool %q out of the queue: %v", key, err)
+	ctrl.sandboxedQueue.Forget(key)
+	ctrl.sandboxedQueue.AddAfter(key, 1*time.Minute)
+}
+
+// syncSandboxedContainersHandler renders the sandboxed containers configuration of a pool into a generated
+// MachineConfig, or deletes it once the pool no longer enables sandboxed containers. An invalid configuration is
+// reported through an event and leaves the existing MachineConfig in place. Only a malformed annotation is not
+// retried, since the nodes may still gain the virtualization support the configuration needs.
+func (ctrl *Controller) syncSandboxedContainersHandler(key string) error {
+	startTime := time.Now()
+	klog.V(4).Infof("Started syncing sandboxed containers for pool %q (%v)", key, startTime)
+	defer func() {
+		klog.V(4).Infof("Finished syncing sandboxed containers for pool %q (%v)", key, time.Since(startTime))
+	}()
+
+	pool, err := ctrl.mcpLister.Get(key)
+	if errors.IsNotFound(err) {
+		klog.V(2).Infof("MachineConfigPool %v has been deleted", key)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	managedKey := getManagedSandboxedContainersKey(pool)
+
+	cfg, err := getSandboxedContainersConfig(pool)
+	if err != nil {
+		ctrl.eventRecorder.Eventf(pool, corev1.EventTypeWarning, "InvalidSandboxedContainersConfig", "Invalid sandboxed containers configuration: %v", err)
+		klog.Warningf("Invalid sandboxed containers configuration for pool %s: %v", pool.Name, err)
+		return nil
+	}
+
+	if cfg == nil {
+		err := ctrl.client.MachineconfigurationV1().MachineConfigs().Delete(context.TODO(), managedKey, metav1.DeleteOptions{})
+		if err != nil && !errors.IsNotFound(err) {
+			return fmt.Errorf("could not delete sandboxed containers MachineConfig %s: %w", managedKey, err)
+		}
+		return nil
+	}
+
+	nodeSelector, err := metav1.LabelSelectorAsSelector(pool.Spec.NodeSelector)
+	if err != nil {
+		return fmt.Errorf("invalid node selector for pool %s: %w", pool.Name, err)
+	}
+
+	nodes, err := ctrl.kubeClient.CoreV1().Nodes().List(context.TODO(), metav1.ListOptions{LabelSelector: nodeSelector.String()})
+	if err != nil {
+		return fmt.Errorf("could not list nodes for pool %s: %w", pool.Name, err)
+	}
+
+	// The Node Feature Discovery labels can be added without the pool being updated, e.g. once NFD has labeled a new
+	// node. Nodes are not watched, so requeue with backoff to pick these changes up instead of forgetting the pool.
+	kargs, extensions, rawIgn, err := generateSandboxedContainersMachineConfigSpec(cfg, nodes.Items)
+	if err != nil {
+		ctrl.eventRecorder.Eventf(pool, corev1.EventTypeWarning, "InvalidSandboxedContainersConfig", "Invalid sandboxed containers configuration: %v", err)
+		return err
+	}
+
+	mc, err := ctrl.client.MachineconfigurationV1().MachineConfigs().Get(context.TODO(), managedKey, metav1.GetOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	isNotFound := errors.IsNotFound(err)
+	if isNotFound {
+		mc, err = ctrlcommon.MachineConfigFromIgnConfig(pool.Name, managedKey, ctrlcommon.NewIgnConfig())
+		if err != nil {
+			return err
+		}
+	}
+
+	mc.Spec.Config.Raw = rawIgn
+	mc.Spec.KernelArguments = kargs
+	mc.Spec.Extensions = extensions
+	mc.ObjectMeta.Annotations = map[string]string{
+		ctrlcommon.GeneratedByControllerVersionAnnotationKey: version.Hash,
+	}
+
+	// Create or Update, on conflict retry
+	if err := retry.RetryOnConflict(updateBackoff, func() error {
+		var err error
+		if isNotFound {
+			_, err = ctrl.client.MachineconfigurationV1().MachineConfigs().Create(context.TODO(), mc, metav1.CreateOptions{})
+		} else {
+			_, err = ctrl.client.MachineconfigurationV1().MachineConfigs().Update(context.TODO(), mc, metav1.UpdateOptions{})
+		}
+		return err
+	}); err != nil {
+		return fmt.Errorf("could not Create/Update MachineConfig: %w", err)
+	}
+
+	klog.Infof("Applied sandboxed containers configuration on MachineConfigPool %v", pool.Name)
+	return nil
+}
+
+func (ctrl *Controller) enqueueSandboxedContainers(pool *mcfgv1.MachineConfigPool) {
+	key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(pool)
+	if err != nil {
+		utilruntime.HandleError(fmt.Errorf("couldn't get key for object %#v: %w", pool, err))
+		return
+	}
+	ctrl.sandboxedQueue.Add(key)
+}
+
+func (ctrl *Controller) addMachineConfigPool(obj interface{}) {
+	pool := obj.(*mcfgv1.MachineConfigPool)
+	if _, ok := pool.Annotations[ctrlcommon.SandboxedContainersAnnotationKey]; ok {
+		klog.V(4).Infof("Adding MachineConfigPool %s with sandboxed containers", pool.Name)
+		ctrl.enqueueSandboxedContainers(pool)
+	}
+	if _, ok := pool.Annotations[ctrlcommon.ContainerStorageAnnotationKey]; ok {
+		klog.V(4).Infof("Adding MachineConfigPool %s with container storage", pool.Name)
+		ctrl.enqueueContainerStorage(pool)
+	}
+}
+
+func (ctrl *Controller) updateMachineConfigPool(old, cur interface{}) {
+	oldPool := old.(*mcfgv1.MachineConfigPool)
+	curPool := cur.(*mcfgv1.MachineConfigPool)
+
+	if oldPool.Annotations[ctrlcommon.SandboxedContainersAnnotationKey] != curPool.Annotations[ctrlcommon.SandboxedContainersAnnotationKey] {
+		klog.V(4).Infof("Update sandboxed containers for MachineConfigPool %s", curPool.Name)
+		ctrl.enqueueSandboxedContainers(curPool)
+	}
+
+	if oldPool.Annotations[ctrlcommon.ContainerStorageAnnotationKey] != curPool.Annotations[ctrlcommon.ContainerStorageAnnotationKey] {
+		klog.V(4).Infof("Update container storage for MachineConfigPool %s", curPool.Name)
+		ctrl.enqueueContainerStorage(curPool)
+	}
+}
@@ -0,0 +1,233 @@
+package containerruntimeconfig
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	"github.com/openshift/machine-config-operator/test/helpers"
+)
+
+func newSandboxedNode(name string, labels map[string]string) *corev1.Node {
+	nodeLabels := map[string]string{"node-role/worker": ""}
+	for k, v := range labels {
+		nodeLabels[k] = v
+	}
+	return &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: nodeLabels}}
+}
+
+func TestValidateSandboxedContainersConfig(t *testing.T) {
+	testCases := []struct {
+		name        string
+		cfg         sandboxedContainersConfig
+		errContains string
+	}{
+		{
+			name: "kata",
+			cfg:  sandboxedContainersConfig{Runtime: "kata", KernelArguments: []string{"kvm.nx_huge_pages=off"}, Modules: []string{"kvm_intel"}},
+		},
+		{
+			name: "confidential kata",
+			cfg:  sandboxedContainersConfig{Runtime: "kata", TEE: "tdx"},
+		},
+		{
+			name: "peer-pods",
+			cfg:  sandboxedContainersConfig{Runtime: "peer-pods"},
+		},
+		{
+			name:        "unknown runtime",
+			cfg:         sandboxedContainersConfig{Runtime: "gvisor"},
+			errContains: `invalid runtime "gvisor"`,
+		},
+		{
+			name:        "unknown TEE",
+			cfg:         sandboxedContainersConfig{Runtime: "kata", TEE: "sgx"},
+			errContains: `invalid TEE "sgx"`,
+		},
+		{
+			name:        "TEE with peer-pods",
+			cfg:         sandboxedContainersConfig{Runtime: "peer-pods", TEE: "snp"},
+			errContains: "a TEE cannot be set for peer-pods",
+		},
+		{
+			name:        "invalid kernel argument",
+			cfg:         sandboxedContainersConfig{Runtime: "kata", KernelArguments: []string{"a=1 b=2"}},
+			errContains: `invalid kernel argument "a=1 b=2"`,
+		},
+		{
+			name:        "invalid module",
+			cfg:         sandboxedContainersConfig{Runtime: "kata", Modules: []string{"../vhost"}},
+			errContains: `invalid kernel module name "../vhost"`,
+		},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+		t.Run(testCase.name, func(t *testing.T) {
+			err := validateSandboxedContainersConfig(&testCase.cfg)
+			if testCase.errContains == "" {
+				assert.NoError(t, err)
+				return
+			}
+
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), testCase.errContains)
+		})
+	}
+}
+
+func TestGenerateSandboxedContainersMachineConfigSpec(t *testing.T) {
+	vmxNode := newSandboxedNode("node-0", map[string]string{nfdVMXLabel: "true"})
+	svmNode := newSandboxedNode("node-1", map[string]string{nfdSVMLabel: "true"})
+	plainNode := newSandboxedNode("node-2", nil)
+
+	t.Run("kata", func(t *testing.T) {
+		cfg := &sandboxedContainersConfig{Runtime: "kata", Modules: []string{"vhost_vsock", "kvm_intel"}}
+		kargs, extensions, rawIgn, err := generateSandboxedContainersMachineConfigSpec(cfg, []corev1.Node{*vmxNode, *svmNode})
+		require.NoError(t, err)
+		assert.Empty(t, kargs)
+		assert.Equal(t, []string{"sandboxed-containers"}, extensions)
+
+		ignCfg, err := ctrlcommon.ParseAndConvertConfig(rawIgn)
+		require.NoError(t, err)
+		require.Len(t, ignCfg.Storage.Files, 2)
+
+		assert.Equal(t, "/etc/crio/crio.conf.d/50-kata", ignCfg.Storage.Files[0].Path)
+		runtimeData, err := ctrlcommon.DecodeIgnitionFileContents(ignCfg.Storage.Files[0].Contents.Source, ignCfg.Storage.Files[0].Contents.Compression)
+		require.NoError(t, err)
+		assert.Contains(t, string(runtimeData), "[crio.runtime.runtimes.kata]")
+		assert.Contains(t, string(runtimeData), `runtime_config_path = "/usr/share/kata-containers/defaults/configuration.toml"`)
+
+		assert.Equal(t, sandboxedModulesPath, ignCfg.Storage.Files[1].Path)
+		modulesData, err := ctrlcommon.DecodeIgnitionFileContents(ignCfg.Storage.Files[1].Contents.Source, ignCfg.Storage.Files[1].Contents.Compression)
+		require.NoError(t, err)
+		assert.Equal(t, "vhost\nvhost_net\nvhost_vsock\nkvm_intel\n", string(modulesData))
+	})
+
+	t.Run("kata without virtualization support", func(t *testing.T) {
+		cfg := &sandboxedContainersConfig{Runtime: "kata"}
+		_, _, _, err := generateSandboxedContainersMachineConfigSpec(cfg, []corev1.Node{*vmxNode, *plainNode})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "node(s) node-2 do not report the virtualization support required by runtime handler kata")
+	})
+
+	t.Run("confidential kata", func(t *testing.T) {
+		cfg := &sandboxedContainersConfig{Runtime: "kata", TEE: "snp", KernelArguments: []string{"kvm_amd.sev=1", "iommu=pt"}}
+		_, _, _, err := generateSandboxedContainersMachineConfigSpec(cfg, []corev1.Node{*svmNode})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), nfdSNPLabel)
+
+		snpNode := newSandboxedNode("node-3", map[string]string{nfdSVMLabel: "true", nfdSNPLabel: "true"})
+		kargs, _, rawIgn, err := generateSandboxedContainersMachineConfigSpec(cfg, []corev1.Node{*snpNode})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"mem_encrypt=on", "kvm_amd.sev=1", "kvm_amd.sev_snp=1", "iommu=pt"}, kargs)
+
+		ignCfg, err := ctrlcommon.ParseAndConvertConfig(rawIgn)
+		require.NoError(t, err)
+		assert.Equal(t, "/etc/crio/crio.conf.d/50-kata-snp", ignCfg.Storage.Files[0].Path)
+	})
+
+	t.Run("peer-pods", func(t *testing.T) {
+		cfg := &sandboxedContainersConfig{Runtime: "peer-pods"}
+		kargs, extensions, rawIgn, err := generateSandboxedContainersMachineConfigSpec(cfg, []corev1.Node{*plainNode})
+		require.NoError(t, err)
+		assert.Empty(t, kargs)
+		assert.Equal(t, []string{"sandboxed-containers"}, extensions)
+
+		ignCfg, err := ctrlcommon.ParseAndConvertConfig(rawIgn)
+		require.NoError(t, err)
+		require.Len(t, ignCfg.Storage.Files, 1)
+		assert.Equal(t, "/etc/crio/crio.conf.d/50-kata-remote", ignCfg.Storage.Files[0].Path)
+	})
+}
+
+func TestSandboxedContainersSync(t *testing.T) {
+	managedKey := "99-worker-generated-sandboxed-containers"
+
+	newPool := func(annotation string) *mcfgv1.MachineConfigPool {
+		pool := helpers.NewMachineConfigPool("worker", nil, helpers.WorkerSelector, "v0")
+		if annotation != "" {
+			pool.Annotations = map[string]string{ctrlcommon.SandboxedContainersAnnotationKey: annotation}
+		}
+		return pool
+	}
+
+	newController := func(f *fixture, pool *mcfgv1.MachineConfigPool, nodes ...*corev1.Node) *Controller {
+		f.mcpLister = append(f.mcpLister, pool)
+		c := f.newController()
+		kubeClient := k8sfake.NewSimpleClientset()
+		for _, node := range nodes {
+			require.NoError(t, kubeClient.Tracker().Add(node))
+		}
+		c.kubeClient = kubeClient
+		return c
+	}
+
+	existingMC := func() *mcfgv1.MachineConfig {
+		mc := helpers.NewMachineConfig(managedKey, map[string]string{mcfgv1.MachineConfigRoleLabelKey: "worker"}, "", nil)
+		mc.Spec.Extensions = []string{"sandboxed-containers"}
+		return mc
+	}
+
+	t.Run("creates the generated MachineConfig", func(t *testing.T) {
+		f := newFixture(t)
+		pool := newPool(`{"runtime":"kata","tee":"tdx"}`)
+		c := newController(f, pool, newSandboxedNode("node-0", map[string]string{nfdTDXLabel: "true"}))
+
+		require.NoError(t, c.syncSandboxedContainersHandler(pool.Name))
+
+		mc, err := f.client.MachineconfigurationV1().MachineConfigs().Get(context.TODO(), managedKey, metav1.GetOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, "worker", mc.Labels[mcfgv1.MachineConfigRoleLabelKey])
+		assert.Equal(t, []string{"kvm_intel.tdx=1"}, mc.Spec.KernelArguments)
+		assert.Equal(t, []string{"sandboxed-containers"}, mc.Spec.Extensions)
+	})
+
+	t.Run("unsupported nodes leave the generated MachineConfig alone and are retried", func(t *testing.T) {
+		f := newFixture(t)
+		f.objects = append(f.objects, existingMC())
+		pool := newPool(`{"runtime":"kata","tee":"tdx"}`)
+		c := newController(f, pool, newSandboxedNode("node-0", map[string]string{nfdVMXLabel: "true"}))
+
+		err := c.syncSandboxedContainersHandler(pool.Name)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "do not report the virtualization support")
+
+		mc, err := f.client.MachineconfigurationV1().MachineConfigs().Get(context.TODO(), managedKey, metav1.GetOptions{})
+		require.NoError(t, err)
+		assert.Empty(t, mc.Spec.KernelArguments)
+	})
+
+	t.Run("a malformed annotation is not retried", func(t *testing.T) {
+		f := newFixture(t)
+		f.objects = append(f.objects, existingMC())
+		pool := newPool(`{"runtime":"gvisor"}`)
+		c := newController(f, pool)
+
+		require.NoError(t, c.syncSandboxedContainersHandler(pool.Name))
+
+		mc, err := f.client.MachineconfigurationV1().MachineConfigs().Get(context.TODO(), managedKey, metav1.GetOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"sandboxed-containers"}, mc.Spec.Extensions)
+	})
+
+	t.Run("removing the annotation deletes the generated MachineConfig", func(t *testing.T) {
+		f := newFixture(t)
+		f.objects = append(f.objects, existingMC())
+		pool := newPool("")
+		c := newController(f, pool)
+
+		require.NoError(t, c.syncSandboxedContainersHandler(pool.Name))
+
+		_, err := f.client.MachineconfigurationV1().MachineConfigs().Get(context.TODO(), managedKey, metav1.GetOptions{})
+		assert.True(t, errors.IsNotFound(err))
+	})
+}
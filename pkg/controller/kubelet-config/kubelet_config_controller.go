@@ -42,6 +42,7 @@ import (
 	"github.com/openshift/library-go/pkg/crypto"
 	"github.com/openshift/library-go/pkg/operator/configobserver/featuregates"
 	"github.com/openshift/machine-config-operator/pkg/apihelpers"
+	"github.com/openshift/machine-config-operator/pkg/apihelpers/conditions"
 	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
 	mtmpl "github.com/openshift/machine-config-operator/pkg/controller/template"
 	"github.com/openshift/machine-config-operator/pkg/version"
@@ -78,6 +79,7 @@ type Controller struct {
 	templatesDir string
 
 	client        mcfgclientset.Interface
+	kubeClient    clientset.Interface
 	configClient  configclientset.Interface
 	eventRecorder record.EventRecorder
 
@@ -105,6 +107,8 @@ type Controller struct {
 	queue           workqueue.RateLimitingInterface
 	featureQueue    workqueue.RateLimitingInterface
 	nodeConfigQueue workqueue.RateLimitingInterface
+	hugepagesQueue  workqueue.RateLimitingInterface
+	dnsQueue        workqueue.RateLimitingInterface
 
 	featureGateAccess featuregates.FeatureGateAccess
 }
@@ -130,14 +134,22 @@ func New(
 	ctrl := &Controller{
 		templatesDir:      templatesDir,
 		client:            mcfgClient,
+		kubeClient:        kubeClient,
 		configClient:      configclient,
 		eventRecorder:     ctrlcommon.NamespacedEventRecorder(eventBroadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: "machineconfigcontroller-kubeletconfigcontroller"})),
 		queue:             workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "machineconfigcontroller-kubeletconfigcontroller"),
 		featureQueue:      workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "machineconfigcontroller-featurecontroller"),
 		nodeConfigQueue:   workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "machineconfigcontroller-nodeConfigcontroller"),
+		hugepagesQueue:    workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "machineconfigcontroller-hugepagescontroller"),
+		dnsQueue:          workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "machineconfigcontroller-dnscontroller"),
 		featureGateAccess: fgAccess,
 	}
 
+	mcpInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    ctrl.addMachineConfigPool,
+		UpdateFunc: ctrl.updateMachineConfigPool,
+	})
+
 	mkuInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
 		AddFunc:    ctrl.addKubeletConfig,
 		UpdateFunc: ctrl.updateKubeletConfig,
@@ -186,6 +198,8 @@ func (ctrl *Controller) Run(workers int, stopCh <-chan struct{}) {
 	defer ctrl.queue.ShutDown()
 	defer ctrl.featureQueue.ShutDown()
 	defer ctrl.nodeConfigQueue.ShutDown()
+	defer ctrl.hugepagesQueue.ShutDown()
+	defer ctrl.dnsQueue.ShutDown()
 
 	if !cache.WaitForCacheSync(stopCh, ctrl.mcpListerSynced, ctrl.mckListerSynced, ctrl.ccListerSynced, ctrl.featListerSynced, ctrl.apiserverListerSynced) {
 		return
@@ -206,6 +220,13 @@ func (ctrl *Controller) Run(workers int, stopCh <-chan struct{}) {
 		go wait.Until(ctrl.nodeConfigWorker, time.Second, stopCh)
 	}
 
+	for i := 0; i < workers; i++ {
+		go wait.Until(ctrl.hugepagesWorker, time.Second, stopCh)
+	}
+
+	// DNS only changes with pool annotations, so a single worker is enough
+	go wait.Until(ctrl.dnsWorker, time.Second, stopCh)
+
 	<-stopCh
 }
 
@@ -226,6 +247,7 @@ func (ctrl *Controller) updateKubeletConfig(old, cur interface{}) {
 	if kubeletConfigTriggerObjectChange(oldConfig, newConfig) {
 		klog.V(4).Infof("Update KubeletConfig %s", oldConfig.Name)
 		ctrl.enqueueKubeletConfig(newConfig)
+		ctrl.enqueueHugepagesForKubeletConfig(newConfig)
 	}
 }
 
@@ -233,6 +255,7 @@ func (ctrl *Controller) addKubeletConfig(obj interface{}) {
 	cfg := obj.(*mcfgv1.KubeletConfig)
 	klog.V(4).Infof("Adding KubeletConfig %s", cfg.Name)
 	ctrl.enqueueKubeletConfig(cfg)
+	ctrl.enqueueHugepagesForKubeletConfig(cfg)
 }
 
 func (ctrl *Controller) deleteKubeletConfig(obj interface{}) {
@@ -431,16 +454,7 @@ func (ctrl *Controller) syncStatusOnly(cfg *mcfgv1.KubeletConfig, err error, arg
 
 // cleanUpStatusConditions keeps at most three conditions of different timestamps for the kubelet config object
 func cleanUpStatusConditions(statusConditions *[]mcfgv1.KubeletConfigCondition, newStatusCondition mcfgv1.KubeletConfigCondition) {
-	statusLimit := 3
-	statusLen := len(*statusConditions)
-	if statusLen > 0 && (*statusConditions)[statusLen-1].Message == newStatusCondition.Message {
-		(*statusConditions)[statusLen-1].LastTransitionTime = newStatusCondition.LastTransitionTime
-	} else {
-		*statusConditions = append(*statusConditions, newStatusCondition)
-	}
-	if len(*statusConditions) > statusLimit {
-		*statusConditions = (*statusConditions)[len(*statusConditions)-statusLimit:]
-	}
+	conditions.KubeletConfig.Record(statusConditions, newStatusCondition, 3)
 }
 
 // addAnnotation adds the annotions for a kubeletconfig object with the given annotationKey and annotationVal
@@ -0,0 +1,258 @@
+package kubeletconfig
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/clarketm/json"
+	ign3types "github.com/coreos/ignition/v2/config/v3_4/types"
+	corev1 "k8s.io/api/core/v1"
+	macherrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/klog/v2"
+
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	daemonconsts "github.com/openshift/machine-config-operator/pkg/daemon/constants"
+	"github.com/openshift/machine-config-operator/pkg/version"
+)
+
+const (
+	// The resolver only uses the first three name servers in resolv.conf (MAXNS).
+	maxDNSServers = 3
+	// The kubelet rejects pods which would get more search domains than this.
+	maxDNSSearches = 32
+)
+
+// dnsConfig is the DNS configuration for a MachineConfigPool, read from the machineconfiguration.openshift.io/dns
+// annotation.
+type dnsConfig struct {
+	// Servers are the name servers, in order of preference.
+	Servers []string `json:"servers"`
+	// Searches are the search domains, in order of preference.
+	Searches []string `json:"searches,omitempty"`
+}
+
+func getDNSConfig(pool *mcfgv1.MachineConfigPool) (*dnsConfig, error) {
+	val, ok := pool.Annotations[ctrlcommon.DNSAnnotationKey]
+	if !ok || val == "" {
+		return nil, nil
+	}
+
+	cfg := &dnsConfig{}
+	if err := json.Unmarshal([]byte(val), cfg); err != nil {
+		return nil, fmt.Errorf("could not parse %s annotation: %w", ctrlcommon.DNSAnnotationKey, err)
+	}
+
+	if err := validateDNSConfig(cfg); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// validateDNSConfig checks that the DNS configuration is well formed and fits within the limits of the resolver.
+func validateDNSConfig(cfg *dnsConfig) error {
+	// NetworkManager ignores a global DNS configuration without name servers for the default domain.
+	if len(cfg.Servers) == 0 {
+		return fmt.Errorf("DNS configuration must have at least one name server")
+	}
+
+	if len(cfg.Servers) > maxDNSServers {
+		return fmt.Errorf("DNS configuration may have at most %d name servers, got %d", maxDNSServers, len(cfg.Servers))
+	}
+
+	seen := map[string]bool{}
+	for _, server := range cfg.Servers {
+		if net.ParseIP(server) == nil {
+			return fmt.Errorf("invalid name server %q, must be an IP address", server)
+		}
+		if seen[server] {
+			return fmt.Errorf("name server %s is set more than once", server)
+		}
+		seen[server] = true
+	}
+
+	if len(cfg.Searches) > maxDNSSearches {
+		return fmt.Errorf("DNS configuration may have at most %d search domains, got %d", maxDNSSearches, len(cfg.Searches))
+	}
+
+	seen = map[string]bool{}
+	for _, search := range cfg.Searches {
+		if errs := validation.IsDNS1123Subdomain(search); len(errs) != 0 {
+			return fmt.Errorf("invalid search domain %q: %s", search, strings.Join(errs, ", "))
+		}
+		if seen[search] {
+			return fmt.Errorf("search domain %s is set more than once", search)
+		}
+		seen[search] = true
+	}
+
+	return nil
+}
+
+// generateDNSConfig renders the DNS configuration into a NetworkManager global DNS configuration, which takes
+// precedence over the DNS settings of all connections, including those received through DHCP.
+func generateDNSConfig(cfg *dnsConfig) string {
+	var sb strings.Builder
+
+	sb.WriteString("# Generated from the " + ctrlcommon.DNSAnnotationKey + " annotation of the MachineConfigPool.\n")
+	sb.WriteString("[global-dns]\n")
+	if len(cfg.Searches) != 0 {
+		sb.WriteString("searches=" + strings.Join(cfg.Searches, ",") + "\n")
+	}
+
+	sb.WriteString("\n[global-dns-domain-*]\n")
+	sb.WriteString("servers=" + strings.Join(cfg.Servers, ",") + "\n")
+
+	return sb.String()
+}
+
+// generateDNSIgnition renders the DNS configuration into the Ignition config of the generated MachineConfig.
+func generateDNSIgnition(cfg *dnsConfig) ([]byte, error) {
+	ignConfig := ctrlcommon.NewIgnConfig()
+	ignConfig.Storage.Files = []ign3types.File{
+		ctrlcommon.NewIgnFile(daemonconsts.NetworkManagerDNSConfPath, generateDNSConfig(cfg)),
+	}
+
+	rawIgn, err := json.Marshal(ignConfig)
+	if err != nil {
+		return nil, fmt.Errorf("could not marshal DNS Ignition: %w", err)
+	}
+
+	return rawIgn, nil
+}
+
+func getManagedDNSKey(pool *mcfgv1.MachineConfigPool) string {
+	return fmt.Sprintf("%s-%s-generated-dns", managedKubeletConfigKeyPrefix, pool.Name)
+}
+
+func (ctrl *Controller) dnsWorker() {
+	for ctrl.processNextDNSWorkItem() {
+	}
+}
+
+func (ctrl *Controller) processNextDNSWorkItem() bool {
+	key, quit := ctrl.dnsQueue.Get()
+	if quit {
+		return false
+	}
+	defer ctrl.dnsQueue.Done(key)
+
+	err := ctrl.syncDNSHandler(key.(string))
+	ctrl.handleDNSErr(err, key)
+	return true
+}
+
+func (ctrl *Controller) handleDNSErr(err error, key interface{}) {
+	if err == nil {
+		ctrl.dnsQueue.Forget(key)
+		return
+	}
+
+	if _, ok := err.(*forgetError); ok {
+		klog.V(2).Infof("Not retrying DNS for pool %v: %v", key, err)
+		ctrl.dnsQueue.Forget(key)
+		return
+	}
+
+	if ctrl.dnsQueue.NumRequeues(key) < maxRetries {
+		klog.V(2).Infof("Error syncing DNS for pool %v: %v", key, err)
+		ctrl.dnsQueue.AddRateLimited(key)
+		return
+	}
+
+	utilruntime.HandleError(err)
+	klog.V(2).Infof("Dropping DNS for pool %q out of the queue: %v", key, err)
+	ctrl.dnsQueue.Forget(key)
+	ctrl.dnsQueue.AddAfter(key, 1*time.Minute)
+}
+
+// syncDNSHandler renders the DNS configuration of the given MachineConfigPool into a generated MachineConfig, or
+// removes the generated MachineConfig when the pool no longer configures DNS.
+func (ctrl *Controller) syncDNSHandler(key string) error {
+	startTime := time.Now()
+	klog.V(4).Infof("Started syncing DNS for pool %q (%v)", key, startTime)
+	defer func() {
+		klog.V(4).Infof("Finished syncing DNS for pool %q (%v)", key, time.Since(startTime))
+	}()
+
+	pool, err := ctrl.mcpLister.Get(key)
+	if macherrors.IsNotFound(err) {
+		klog.V(2).Infof("MachineConfigPool %v has been deleted", key)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	managedKey := getManagedDNSKey(pool)
+
+	cfg, err := getDNSConfig(pool)
+	if err != nil {
+		ctrl.eventRecorder.Eventf(pool, corev1.EventTypeWarning, "InvalidDNSConfig", "Invalid DNS configuration: %v", err)
+		return newForgetError(err)
+	}
+
+	if cfg == nil {
+		err := ctrl.client.MachineconfigurationV1().MachineConfigs().Delete(context.TODO(), managedKey, metav1.DeleteOptions{})
+		if err != nil && !macherrors.IsNotFound(err) {
+			return fmt.Errorf("could not delete DNS MachineConfig %s: %w", managedKey, err)
+		}
+		return nil
+	}
+
+	rawIgn, err := generateDNSIgnition(cfg)
+	if err != nil {
+		return err
+	}
+
+	mc, err := ctrl.client.MachineconfigurationV1().MachineConfigs().Get(context.TODO(), managedKey, metav1.GetOptions{})
+	if err != nil && !macherrors.IsNotFound(err) {
+		return err
+	}
+	isNotFound := macherrors.IsNotFound(err)
+	if isNotFound {
+		mc, err = ctrlcommon.MachineConfigFromIgnConfig(pool.Name, managedKey, ctrlcommon.NewIgnConfig())
+		if err != nil {
+			return err
+		}
+	}
+
+	mc.Spec.Config.Raw = rawIgn
+	mc.ObjectMeta.Annotations = map[string]string{
+		ctrlcommon.GeneratedByControllerVersionAnnotationKey: version.Hash,
+	}
+
+	// Create or Update, on conflict retry
+	if err := retry.RetryOnConflict(updateBackoff, func() error {
+		var err error
+		if isNotFound {
+			_, err = ctrl.client.MachineconfigurationV1().MachineConfigs().Create(context.TODO(), mc, metav1.CreateOptions{})
+		} else {
+			_, err = ctrl.client.MachineconfigurationV1().MachineConfigs().Update(context.TODO(), mc, metav1.UpdateOptions{})
+		}
+		return err
+	}); err != nil {
+		return fmt.Errorf("could not Create/Update MachineConfig: %w", err)
+	}
+
+	klog.Infof("Applied DNS configuration on MachineConfigPool %v", pool.Name)
+	return nil
+}
+
+func (ctrl *Controller) enqueueDNS(pool *mcfgv1.MachineConfigPool) {
+	key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(pool)
+	if err != nil {
+		utilruntime.HandleError(fmt.Errorf("couldn't get key for object %#v: %w", pool, err))
+		return
+	}
+	ctrl.dnsQueue.Add(key)
+}
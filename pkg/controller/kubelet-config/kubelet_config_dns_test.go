@@ -0,0 +1,175 @@
+package kubeletconfig
+
+import (
+	"context"
+	"testing"
+
+	ign3types "github.com/coreos/ignition/v2/config/v3_4/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	macherrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	daemonconsts "github.com/openshift/machine-config-operator/pkg/daemon/constants"
+	"github.com/openshift/machine-config-operator/test/helpers"
+)
+
+func TestValidateDNSConfig(t *testing.T) {
+	testCases := []struct {
+		name        string
+		cfg         dnsConfig
+		errContains string
+	}{
+		{
+			name: "servers and search domains",
+			cfg: dnsConfig{
+				Servers:  []string{"10.0.0.53", "fd00::53"},
+				Searches: []string{"edge.example.com", "example.com"},
+			},
+		},
+		{
+			name:        "no servers",
+			cfg:         dnsConfig{Searches: []string{"example.com"}},
+			errContains: "at least one name server",
+		},
+		{
+			name:        "too many servers",
+			cfg:         dnsConfig{Servers: []string{"10.0.0.1", "10.0.0.2", "10.0.0.3", "10.0.0.4"}},
+			errContains: "at most 3 name servers",
+		},
+		{
+			name:        "invalid server",
+			cfg:         dnsConfig{Servers: []string{"dns.example.com"}},
+			errContains: `invalid name server "dns.example.com"`,
+		},
+		{
+			name:        "duplicate server",
+			cfg:         dnsConfig{Servers: []string{"10.0.0.53", "10.0.0.53"}},
+			errContains: "set more than once",
+		},
+		{
+			name:        "invalid search domain",
+			cfg:         dnsConfig{Servers: []string{"10.0.0.53"}, Searches: []string{"example.com,evil"}},
+			errContains: `invalid search domain "example.com,evil"`,
+		},
+		{
+			name:        "duplicate search domain",
+			cfg:         dnsConfig{Servers: []string{"10.0.0.53"}, Searches: []string{"example.com", "example.com"}},
+			errContains: "set more than once",
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			err := validateDNSConfig(&testCase.cfg)
+			if testCase.errContains == "" {
+				assert.NoError(t, err)
+				return
+			}
+
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), testCase.errContains)
+		})
+	}
+}
+
+func TestGenerateDNSConfig(t *testing.T) {
+	expected := `# Generated from the machineconfiguration.openshift.io/dns annotation of the MachineConfigPool.
+[global-dns]
+searches=edge.example.com,example.com
+
+[global-dns-domain-*]
+servers=10.0.0.53,fd00::53
+`
+
+	assert.Equal(t, expected, generateDNSConfig(&dnsConfig{
+		Servers:  []string{"10.0.0.53", "fd00::53"},
+		Searches: []string{"edge.example.com", "example.com"},
+	}))
+
+	expected = `# Generated from the machineconfiguration.openshift.io/dns annotation of the MachineConfigPool.
+[global-dns]
+
+[global-dns-domain-*]
+servers=10.0.0.53
+`
+
+	assert.Equal(t, expected, generateDNSConfig(&dnsConfig{Servers: []string{"10.0.0.53"}}))
+}
+
+func TestDNSSync(t *testing.T) {
+	managedKey := "99-worker-generated-dns"
+
+	newPool := func(annotation string) *mcfgv1.MachineConfigPool {
+		pool := helpers.NewMachineConfigPool("worker", nil, metav1.AddLabelToSelector(&metav1.LabelSelector{}, "node-role/worker", ""), "v0")
+		if annotation != "" {
+			pool.Annotations = map[string]string{ctrlcommon.DNSAnnotationKey: annotation}
+		}
+		return pool
+	}
+
+	newController := func(f *fixture, pool *mcfgv1.MachineConfigPool) *Controller {
+		f.mcpLister = append(f.mcpLister, pool)
+		return f.newController(nil)
+	}
+
+	existingMC := func() *mcfgv1.MachineConfig {
+		return helpers.NewMachineConfig(managedKey, map[string]string{mcfgv1.MachineConfigRoleLabelKey: "worker"}, "",
+			[]ign3types.File{ctrlcommon.NewIgnFile(daemonconsts.NetworkManagerDNSConfPath, "existing")})
+	}
+
+	getDNSFile := func(t *testing.T, f *fixture) string {
+		t.Helper()
+
+		mc, err := f.client.MachineconfigurationV1().MachineConfigs().Get(context.TODO(), managedKey, metav1.GetOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, "worker", mc.Labels[mcfgv1.MachineConfigRoleLabelKey])
+
+		ignConfig, err := ctrlcommon.ParseAndConvertConfig(mc.Spec.Config.Raw)
+		require.NoError(t, err)
+
+		data, err := ctrlcommon.GetIgnitionFileDataByPath(&ignConfig, daemonconsts.NetworkManagerDNSConfPath)
+		require.NoError(t, err)
+
+		return string(data)
+	}
+
+	t.Run("creates the generated MachineConfig", func(t *testing.T) {
+		f := newFixture(t)
+		pool := newPool(`{"servers":["10.0.0.53"],"searches":["example.com"]}`)
+		c := newController(f, pool)
+
+		require.NoError(t, c.syncDNSHandler(pool.Name))
+
+		dnsFile := getDNSFile(t, f)
+		assert.Contains(t, dnsFile, "searches=example.com\n")
+		assert.Contains(t, dnsFile, "servers=10.0.0.53\n")
+	})
+
+	t.Run("invalid configuration leaves the generated MachineConfig alone", func(t *testing.T) {
+		f := newFixture(t)
+		f.objects = append(f.objects, existingMC())
+		pool := newPool(`{"servers":["dns.example.com"]}`)
+		c := newController(f, pool)
+
+		err := c.syncDNSHandler(pool.Name)
+		require.Error(t, err)
+		assert.IsType(t, &forgetError{}, err)
+
+		assert.Equal(t, "existing", getDNSFile(t, f))
+	})
+
+	t.Run("removing the annotation deletes the generated MachineConfig", func(t *testing.T) {
+		f := newFixture(t)
+		f.objects = append(f.objects, existingMC())
+		pool := newPool("")
+		c := newController(f, pool)
+
+		require.NoError(t, c.syncDNSHandler(pool.Name))
+
+		_, err := f.client.MachineconfigurationV1().MachineConfigs().Get(context.TODO(), managedKey, metav1.GetOptions{})
+		assert.True(t, macherrors.IsNotFound(err))
+	})
+}
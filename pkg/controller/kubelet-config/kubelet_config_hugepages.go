@@ -0,0 +1,559 @@
+package kubeletconfig
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/clarketm/json"
+	ign3types "github.com/coreos/ignition/v2/config/v3_4/types"
+	corev1 "k8s.io/api/core/v1"
+	macherrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/klog/v2"
+
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	"github.com/openshift/machine-config-operator/pkg/version"
+)
+
+// hugepagesSizeRegex matches the page sizes accepted by the kernel hugepagesz= argument, e.g. 2M or 1G.
+var hugepagesSizeRegex = regexp.MustCompile(`^([1-9][0-9]*)([KMG])$`)
+
+var hugepagesSizeUnits = map[string]int64{
+	"K": 1024,
+	"M": 1024 * 1024,
+	"G": 1024 * 1024 * 1024,
+}
+
+// hugepagesConfig is the hugepages configuration for a MachineConfigPool, read from the
+// machineconfiguration.openshift.io/hugepages annotation.
+type hugepagesConfig struct {
+	// DefaultSize is the default hugepage size (default_hugepagesz) for the pool.
+	DefaultSize string `json:"defaultSize,omitempty"`
+	// Pages are the hugepages to allocate.
+	Pages []hugepagesAllocation `json:"pages"`
+}
+
+// hugepagesAllocation describes a number of hugepages of the given size to allocate, either at boot across all NUMA
+// nodes or at runtime on a single NUMA node.
+type hugepagesAllocation struct {
+	Size  string `json:"size"`
+	Count int32  `json:"count"`
+	Node  *int32 `json:"node,omitempty"`
+}
+
+// hugepageSizeBytes returns the size of a hugepage in bytes.
+func hugepageSizeBytes(size string) (int64, error) {
+	match := hugepagesSizeRegex.FindStringSubmatch(size)
+	if match == nil {
+		return 0, fmt.Errorf("invalid hugepage size %q, expected a size such as 2M or 1G", size)
+	}
+
+	val, err := strconv.ParseInt(match[1], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid hugepage size %q: %w", size, err)
+	}
+
+	bytes := val * hugepagesSizeUnits[match[2]]
+	if bytes&(bytes-1) != 0 {
+		return 0, fmt.Errorf("invalid hugepage size %q, must be a power of two", size)
+	}
+
+	return bytes, nil
+}
+
+// hugepagesResourceName returns the node resource the kubelet reports for hugepages of the given size, e.g. hugepages-2Mi.
+func hugepagesResourceName(sizeBytes int64) corev1.ResourceName {
+	return corev1.ResourceName(corev1.ResourceHugePagesPrefix + resource.NewQuantity(sizeBytes, resource.BinarySI).String())
+}
+
+func getHugepagesConfig(pool *mcfgv1.MachineConfigPool) (*hugepagesConfig, error) {
+	val, ok := pool.Annotations[ctrlcommon.HugepagesAnnotationKey]
+	if !ok || val == "" {
+		return nil, nil
+	}
+
+	cfg := &hugepagesConfig{}
+	if err := json.Unmarshal([]byte(val), cfg); err != nil {
+		return nil, fmt.Errorf("could not parse %s annotation: %w", ctrlcommon.HugepagesAnnotationKey, err)
+	}
+
+	if err := validateHugepagesConfig(cfg); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// validateHugepagesConfig checks that the hugepages configuration is well formed and unambiguous.
+func validateHugepagesConfig(cfg *hugepagesConfig) error {
+	if len(cfg.Pages) == 0 {
+		return fmt.Errorf("hugepages configuration must allocate at least one page size")
+	}
+
+	type allocationKey struct {
+		size string
+		node int32
+	}
+
+	seen := map[allocationKey]bool{}
+	global := map[string]bool{}
+	perNode := map[string]bool{}
+
+	for _, page := range cfg.Pages {
+		if _, err := hugepageSizeBytes(page.Size); err != nil {
+			return err
+		}
+
+		if page.Count <= 0 {
+			return fmt.Errorf("hugepages count for size %s must be positive, got %d", page.Size, page.Count)
+		}
+
+		key := allocationKey{size: page.Size, node: -1}
+		if page.Node != nil {
+			if *page.Node < 0 {
+				return fmt.Errorf("hugepages NUMA node for size %s must not be negative, got %d", page.Size, *page.Node)
+			}
+			key.node = *page.Node
+			perNode[page.Size] = true
+		} else {
+			global[page.Size] = true
+		}
+
+		if seen[key] {
+			if page.Node != nil {
+				return fmt.Errorf("hugepages of size %s are allocated more than once on NUMA node %d", page.Size, *page.Node)
+			}
+			return fmt.Errorf("hugepages of size %s are allocated more than once", page.Size)
+		}
+		seen[key] = true
+
+		// The kernel spreads boot time allocations across NUMA nodes, so mixing them with per-node allocations of the
+		// same size makes the resulting per-node counts unpredictable.
+		if global[page.Size] && perNode[page.Size] {
+			return fmt.Errorf("hugepages of size %s cannot be allocated both per NUMA node and across all NUMA nodes", page.Size)
+		}
+	}
+
+	if cfg.DefaultSize != "" {
+		if _, err := hugepageSizeBytes(cfg.DefaultSize); err != nil {
+			return err
+		}
+
+		if !global[cfg.DefaultSize] && !perNode[cfg.DefaultSize] {
+			return fmt.Errorf("default hugepage size %s is not allocated", cfg.DefaultSize)
+		}
+	}
+
+	return nil
+}
+
+// generateHugepagesKernelArguments returns the kernel arguments which allocate the hugepages that are not bound to a
+// NUMA node at boot.
+func generateHugepagesKernelArguments(cfg *hugepagesConfig) []string {
+	kargs := []string{}
+
+	if cfg.DefaultSize != "" {
+		kargs = append(kargs, "default_hugepagesz="+cfg.DefaultSize)
+	}
+
+	for _, page := range cfg.Pages {
+		if page.Node != nil {
+			continue
+		}
+		kargs = append(kargs, "hugepagesz="+page.Size, fmt.Sprintf("hugepages=%d", page.Count))
+	}
+
+	return kargs
+}
+
+// generateHugepagesUnits returns the systemd units which allocate hugepages on specific NUMA nodes. The kernel command
+// line cannot target a NUMA node, so these are allocated through sysfs before the kubelet starts. The units fail if
+// the kernel could not allocate all of the requested pages.
+func generateHugepagesUnits(cfg *hugepagesConfig) ([]ign3types.Unit, error) {
+	units := []ign3types.Unit{}
+
+	for _, page := range cfg.Pages {
+		if page.Node == nil {
+			continue
+		}
+
+		sizeBytes, err := hugepageSizeBytes(page.Size)
+		if err != nil {
+			return nil, err
+		}
+		sizeKB := sizeBytes / 1024
+
+		path := fmt.Sprintf("/sys/devices/system/node/node%d/hugepages/hugepages-%dkB/nr_hugepages", *page.Node, sizeKB)
+		contents := fmt.Sprintf(`[Unit]
+Description=Hugepages-%[1]dkB allocation on NUMA node %[2]d
+Before=kubelet.service
+
+[Service]
+Type=oneshot
+RemainAfterExit=true
+ExecStart=/bin/sh -c 'echo %[3]d > %[4]s && test "$$(cat %[4]s)" -eq %[3]d'
+
+[Install]
+WantedBy=multi-user.target
+`, sizeKB, *page.Node, page.Count, path)
+
+		enabled := true
+		units = append(units, ign3types.Unit{
+			Name:     fmt.Sprintf("hugepages-allocation-%dkB-NUMA%d.service", sizeKB, *page.Node),
+			Enabled:  &enabled,
+			Contents: &contents,
+		})
+	}
+
+	sort.Slice(units, func(i, j int) bool {
+		return units[i].Name < units[j].Name
+	})
+
+	return units, nil
+}
+
+// validateHugepagesNodeCapacity checks that the kernel of every node in the pool supports the requested page sizes,
+// as reported by the kubelet in the node capacity.
+func validateHugepagesNodeCapacity(cfg *hugepagesConfig, nodes []corev1.Node) error {
+	sizes := []string{}
+	seen := map[string]bool{}
+	for _, page := range cfg.Pages {
+		if !seen[page.Size] {
+			sizes = append(sizes, page.Size)
+			seen[page.Size] = true
+		}
+	}
+
+	unsupported := []string{}
+	for _, size := range sizes {
+		sizeBytes, err := hugepageSizeBytes(size)
+		if err != nil {
+			return err
+		}
+
+		resourceName := hugepagesResourceName(sizeBytes)
+		for _, node := range nodes {
+			if _, ok := node.Status.Capacity[resourceName]; !ok {
+				unsupported = append(unsupported, fmt.Sprintf("%s does not support hugepages of size %s", node.Name, size))
+			}
+		}
+	}
+
+	if len(unsupported) != 0 {
+		return fmt.Errorf("nodes do not support the requested hugepages: %s", strings.Join(unsupported, ", "))
+	}
+
+	return nil
+}
+
+// validateHugepagesReservedMemory checks that the hugepages reserved by KubeletConfigs for the pool through
+// reservedMemory fit within the hugepages allocated by the pool's hugepages configuration. The kubelet refuses to
+// start when it reserves more hugepages than are available on a NUMA node.
+func validateHugepagesReservedMemory(cfg *hugepagesConfig, kubeletConfigs []*mcfgv1.KubeletConfig) error {
+	// Bytes allocated per resource, per NUMA node. Allocations which are not bound to a NUMA node are tracked under -1
+	// since the kernel decides how they are spread.
+	allocated := map[corev1.ResourceName]map[int32]int64{}
+	for _, page := range cfg.Pages {
+		sizeBytes, err := hugepageSizeBytes(page.Size)
+		if err != nil {
+			return err
+		}
+
+		resourceName := hugepagesResourceName(sizeBytes)
+		if allocated[resourceName] == nil {
+			allocated[resourceName] = map[int32]int64{}
+		}
+
+		node := int32(-1)
+		if page.Node != nil {
+			node = *page.Node
+		}
+		allocated[resourceName][node] += sizeBytes * int64(page.Count)
+	}
+
+	for _, kc := range kubeletConfigs {
+		if kc.Spec.KubeletConfig == nil || kc.Spec.KubeletConfig.Raw == nil {
+			continue
+		}
+
+		decoded, err := decodeKubeletConfig(kc.Spec.KubeletConfig.Raw)
+		if err != nil {
+			return fmt.Errorf("could not decode KubeletConfig %s: %w", kc.Name, err)
+		}
+
+		for _, reservation := range decoded.ReservedMemory {
+			for resourceName, quantity := range reservation.Limits {
+				if !strings.HasPrefix(string(resourceName), corev1.ResourceHugePagesPrefix) {
+					continue
+				}
+
+				byNode, ok := allocated[resourceName]
+				if !ok {
+					return fmt.Errorf("KubeletConfig %s reserves %s of %s on NUMA node %d, but the pool does not allocate hugepages of that size", kc.Name, quantity.String(), resourceName, reservation.NumaNode)
+				}
+
+				// Without a per-node allocation we cannot know how many pages end up on the NUMA node, so only
+				// check against the total.
+				available, ok := byNode[reservation.NumaNode]
+				if !ok {
+					available = byNode[-1]
+				}
+
+				if quantity.Value() > available {
+					return fmt.Errorf("KubeletConfig %s reserves %s of %s on NUMA node %d, but only %s are allocated", kc.Name, quantity.String(), resourceName, reservation.NumaNode, resource.NewQuantity(available, resource.BinarySI).String())
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// generateHugepagesMachineConfigSpec validates the hugepages configuration against the nodes and KubeletConfigs of the
+// pool and renders it into the kernel arguments and Ignition config of the generated MachineConfig.
+func generateHugepagesMachineConfigSpec(cfg *hugepagesConfig, nodes []corev1.Node, kubeletConfigs []*mcfgv1.KubeletConfig) ([]string, []byte, error) {
+	if err := validateHugepagesNodeCapacity(cfg, nodes); err != nil {
+		return nil, nil, err
+	}
+
+	if err := validateHugepagesReservedMemory(cfg, kubeletConfigs); err != nil {
+		return nil, nil, err
+	}
+
+	units, err := generateHugepagesUnits(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ignConfig := ctrlcommon.NewIgnConfig()
+	ignConfig.Systemd.Units = units
+
+	rawIgn, err := json.Marshal(ignConfig)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not marshal hugepages Ignition: %w", err)
+	}
+
+	return generateHugepagesKernelArguments(cfg), rawIgn, nil
+}
+
+func getManagedHugepagesKey(pool *mcfgv1.MachineConfigPool) string {
+	return fmt.Sprintf("%s-%s-generated-hugepages", managedKubeletConfigKeyPrefix, pool.Name)
+}
+
+func (ctrl *Controller) hugepagesWorker() {
+	for ctrl.processNextHugepagesWorkItem() {
+	}
+}
+
+func (ctrl *Controller) processNextHugepagesWorkItem() bool {
+	key, quit := ctrl.hugepagesQueue.Get()
+	if quit {
+		return false
+	}
+	defer ctrl.hugepagesQueue.Done(key)
+
+	err := ctrl.syncHugepagesHandler(key.(string))
+	ctrl.handleHugepagesErr(err, key)
+	return true
+}
+
+func (ctrl *Controller) handleHugepagesErr(err error, key interface{}) {
+	if err == nil {
+		ctrl.hugepagesQueue.Forget(key)
+		return
+	}
+
+	if _, ok := err.(*forgetError); ok {
+		klog.V(2).Infof("Not retrying hugepages for pool %v: %v", key, err)
+		ctrl.hugepagesQueue.Forget(key)
+		return
+	}
+
+	if ctrl.hugepagesQueue.NumRequeues(key) < maxRetries {
+		klog.V(2).Infof("Error syncing hugepages for pool %v: %v", key, err)
+		ctrl.hugepagesQueue.AddRateLimited(key)
+		return
+	}
+
+	utilruntime.HandleError(err)
+	klog.V(2).Infof("Dropping hugepages for pool %q out of the queue: %v", key, err)
+	ctrl.hugepagesQueue.Forget(key)
+	ctrl.hugepagesQueue.AddAfter(key, 1*time.Minute)
+}
+
+// syncHugepagesHandler renders the hugepages configuration of the given MachineConfigPool into a generated
+// MachineConfig, or removes the generated MachineConfig when the pool no longer configures hugepages.
+func (ctrl *Controller) syncHugepagesHandler(key string) error {
+	startTime := time.Now()
+	klog.V(4).Infof("Started syncing hugepages for pool %q (%v)", key, startTime)
+	defer func() {
+		klog.V(4).Infof("Finished syncing hugepages for pool %q (%v)", key, time.Since(startTime))
+	}()
+
+	pool, err := ctrl.mcpLister.Get(key)
+	if macherrors.IsNotFound(err) {
+		klog.V(2).Infof("MachineConfigPool %v has been deleted", key)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	managedKey := getManagedHugepagesKey(pool)
+
+	cfg, err := getHugepagesConfig(pool)
+	if err != nil {
+		ctrl.eventRecorder.Eventf(pool, corev1.EventTypeWarning, "InvalidHugepagesConfig", "Invalid hugepages configuration: %v", err)
+		return newForgetError(err)
+	}
+
+	if cfg == nil {
+		err := ctrl.client.MachineconfigurationV1().MachineConfigs().Delete(context.TODO(), managedKey, metav1.DeleteOptions{})
+		if err != nil && !macherrors.IsNotFound(err) {
+			return fmt.Errorf("could not delete hugepages MachineConfig %s: %w", managedKey, err)
+		}
+		return nil
+	}
+
+	nodeSelector, err := metav1.LabelSelectorAsSelector(pool.Spec.NodeSelector)
+	if err != nil {
+		return newForgetError(fmt.Errorf("invalid node selector for pool %s: %w", pool.Name, err))
+	}
+
+	nodes, err := ctrl.kubeClient.CoreV1().Nodes().List(context.TODO(), metav1.ListOptions{LabelSelector: nodeSelector.String()})
+	if err != nil {
+		return fmt.Errorf("could not list nodes for pool %s: %w", pool.Name, err)
+	}
+
+	kubeletConfigs, err := ctrl.getKubeletConfigsForPool(pool)
+	if err != nil {
+		return err
+	}
+
+	// Unlike the annotation, the node capacity can change without the pool being updated, e.g. once a node's kernel
+	// supports a page size or a new node joins the pool. Nodes are not watched, so requeue with backoff to pick these
+	// changes up instead of forgetting the pool.
+	kargs, rawIgn, err := generateHugepagesMachineConfigSpec(cfg, nodes.Items, kubeletConfigs)
+	if err != nil {
+		ctrl.eventRecorder.Eventf(pool, corev1.EventTypeWarning, "InvalidHugepagesConfig", "Invalid hugepages configuration: %v", err)
+		return err
+	}
+
+	mc, err := ctrl.client.MachineconfigurationV1().MachineConfigs().Get(context.TODO(), managedKey, metav1.GetOptions{})
+	if err != nil && !macherrors.IsNotFound(err) {
+		return err
+	}
+	isNotFound := macherrors.IsNotFound(err)
+	if isNotFound {
+		mc, err = ctrlcommon.MachineConfigFromIgnConfig(pool.Name, managedKey, ctrlcommon.NewIgnConfig())
+		if err != nil {
+			return err
+		}
+	}
+
+	mc.Spec.Config.Raw = rawIgn
+	mc.Spec.KernelArguments = kargs
+	mc.ObjectMeta.Annotations = map[string]string{
+		ctrlcommon.GeneratedByControllerVersionAnnotationKey: version.Hash,
+	}
+
+	// Create or Update, on conflict retry
+	if err := retry.RetryOnConflict(updateBackoff, func() error {
+		var err error
+		if isNotFound {
+			_, err = ctrl.client.MachineconfigurationV1().MachineConfigs().Create(context.TODO(), mc, metav1.CreateOptions{})
+		} else {
+			_, err = ctrl.client.MachineconfigurationV1().MachineConfigs().Update(context.TODO(), mc, metav1.UpdateOptions{})
+		}
+		return err
+	}); err != nil {
+		return fmt.Errorf("could not Create/Update MachineConfig: %w", err)
+	}
+
+	klog.Infof("Applied hugepages configuration on MachineConfigPool %v", pool.Name)
+	return nil
+}
+
+// getKubeletConfigsForPool returns the KubeletConfigs which select the given MachineConfigPool.
+func (ctrl *Controller) getKubeletConfigsForPool(pool *mcfgv1.MachineConfigPool) ([]*mcfgv1.KubeletConfig, error) {
+	kcs, err := ctrl.mckLister.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+
+	out := []*mcfgv1.KubeletConfig{}
+	for _, kc := range kcs {
+		selector, err := metav1.LabelSelectorAsSelector(kc.Spec.MachineConfigPoolSelector)
+		if err != nil {
+			continue
+		}
+		if selector.Empty() || !selector.Matches(labels.Set(pool.Labels)) {
+			continue
+		}
+		out = append(out, kc)
+	}
+
+	return out, nil
+}
+
+func (ctrl *Controller) enqueueHugepages(pool *mcfgv1.MachineConfigPool) {
+	key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(pool)
+	if err != nil {
+		utilruntime.HandleError(fmt.Errorf("couldn't get key for object %#v: %w", pool, err))
+		return
+	}
+	ctrl.hugepagesQueue.Add(key)
+}
+
+// enqueueHugepagesForKubeletConfig enqueues the pools selected by the given KubeletConfig which configure hugepages,
+// so that changes to reservedMemory are validated against their hugepages configuration.
+func (ctrl *Controller) enqueueHugepagesForKubeletConfig(cfg *mcfgv1.KubeletConfig) {
+	pools, err := ctrl.getPoolsForKubeletConfig(cfg)
+	if err != nil {
+		return
+	}
+
+	for _, pool := range pools {
+		if _, ok := pool.Annotations[ctrlcommon.HugepagesAnnotationKey]; ok {
+			ctrl.enqueueHugepages(pool)
+		}
+	}
+}
+
+func (ctrl *Controller) addMachineConfigPool(obj interface{}) {
+	pool := obj.(*mcfgv1.MachineConfigPool)
+	if _, ok := pool.Annotations[ctrlcommon.HugepagesAnnotationKey]; ok {
+		klog.V(4).Infof("Adding MachineConfigPool %s with hugepages", pool.Name)
+		ctrl.enqueueHugepages(pool)
+	}
+	if _, ok := pool.Annotations[ctrlcommon.DNSAnnotationKey]; ok {
+		klog.V(4).Infof("Adding MachineConfigPool %s with DNS", pool.Name)
+		ctrl.enqueueDNS(pool)
+	}
+}
+
+func (ctrl *Controller) updateMachineConfigPool(old, cur interface{}) {
+	oldPool := old.(*mcfgv1.MachineConfigPool)
+	curPool := cur.(*mcfgv1.MachineConfigPool)
+
+	if oldPool.Annotations[ctrlcommon.HugepagesAnnotationKey] != curPool.Annotations[ctrlcommon.HugepagesAnnotationKey] {
+		klog.V(4).Infof("Update hugepages for MachineConfigPool %s", curPool.Name)
+		ctrl.enqueueHugepages(curPool)
+	}
+
+	if oldPool.Annotations[ctrlcommon.DNSAnnotationKey] != curPool.Annotations[ctrlcommon.DNSAnnotationKey] {
+		klog.V(4).Infof("Update DNS for MachineConfigPool %s", curPool.Name)
+		ctrl.enqueueDNS(curPool)
+	}
+}
@@ -0,0 +1,260 @@
+package kubeletconfig
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	macherrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	kubeletconfigv1beta1 "k8s.io/kubelet/config/v1beta1"
+	"k8s.io/utils/pointer"
+
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	"github.com/openshift/machine-config-operator/test/helpers"
+)
+
+func newHugepagesNode(name string, labels map[string]string, sizes ...string) *corev1.Node {
+	capacity := corev1.ResourceList{}
+	for _, size := range sizes {
+		capacity[corev1.ResourceName(corev1.ResourceHugePagesPrefix+size)] = resource.MustParse("0")
+	}
+
+	return &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels},
+		Status:     corev1.NodeStatus{Capacity: capacity},
+	}
+}
+
+func TestValidateHugepagesConfig(t *testing.T) {
+	testCases := []struct {
+		name        string
+		cfg         hugepagesConfig
+		errContains string
+	}{
+		{
+			name: "boot time and per NUMA node allocations",
+			cfg: hugepagesConfig{
+				DefaultSize: "1G",
+				Pages: []hugepagesAllocation{
+					{Size: "1G", Count: 4, Node: pointer.Int32(0)},
+					{Size: "1G", Count: 2, Node: pointer.Int32(1)},
+					{Size: "2M", Count: 512},
+				},
+			},
+		},
+		{
+			name:        "no pages",
+			cfg:         hugepagesConfig{DefaultSize: "1G"},
+			errContains: "at least one page size",
+		},
+		{
+			name:        "invalid size",
+			cfg:         hugepagesConfig{Pages: []hugepagesAllocation{{Size: "1Gi", Count: 1}}},
+			errContains: `invalid hugepage size "1Gi"`,
+		},
+		{
+			name:        "size not a power of two",
+			cfg:         hugepagesConfig{Pages: []hugepagesAllocation{{Size: "3M", Count: 1}}},
+			errContains: "must be a power of two",
+		},
+		{
+			name:        "zero count",
+			cfg:         hugepagesConfig{Pages: []hugepagesAllocation{{Size: "2M", Count: 0}}},
+			errContains: "must be positive",
+		},
+		{
+			name:        "negative NUMA node",
+			cfg:         hugepagesConfig{Pages: []hugepagesAllocation{{Size: "2M", Count: 1, Node: pointer.Int32(-1)}}},
+			errContains: "must not be negative",
+		},
+		{
+			name: "duplicate NUMA node allocation",
+			cfg: hugepagesConfig{Pages: []hugepagesAllocation{
+				{Size: "2M", Count: 1, Node: pointer.Int32(0)},
+				{Size: "2M", Count: 2, Node: pointer.Int32(0)},
+			}},
+			errContains: "allocated more than once on NUMA node 0",
+		},
+		{
+			name: "mixed boot time and per NUMA node allocations of the same size",
+			cfg: hugepagesConfig{Pages: []hugepagesAllocation{
+				{Size: "1G", Count: 1, Node: pointer.Int32(0)},
+				{Size: "1G", Count: 2},
+			}},
+			errContains: "both per NUMA node and across all NUMA nodes",
+		},
+		{
+			name: "default size not allocated",
+			cfg: hugepagesConfig{
+				DefaultSize: "1G",
+				Pages:       []hugepagesAllocation{{Size: "2M", Count: 512}},
+			},
+			errContains: "default hugepage size 1G is not allocated",
+		},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+		t.Run(testCase.name, func(t *testing.T) {
+			err := validateHugepagesConfig(&testCase.cfg)
+			if testCase.errContains == "" {
+				assert.NoError(t, err)
+				return
+			}
+
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), testCase.errContains)
+		})
+	}
+}
+
+func TestGenerateHugepagesMachineConfigSpec(t *testing.T) {
+	cfg := &hugepagesConfig{
+		DefaultSize: "1G",
+		Pages: []hugepagesAllocation{
+			{Size: "1G", Count: 4, Node: pointer.Int32(1)},
+			{Size: "1G", Count: 2, Node: pointer.Int32(0)},
+			{Size: "2M", Count: 512},
+		},
+	}
+
+	nodes := []corev1.Node{*newHugepagesNode("node-0", nil, "2Mi", "1Gi")}
+
+	kargs, rawIgn, err := generateHugepagesMachineConfigSpec(cfg, nodes, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"default_hugepagesz=1G", "hugepagesz=2M", "hugepages=512"}, kargs)
+
+	ignCfg, err := ctrlcommon.ParseAndConvertConfig(rawIgn)
+	require.NoError(t, err)
+	require.Len(t, ignCfg.Systemd.Units, 2)
+	assert.Equal(t, "hugepages-allocation-1048576kB-NUMA0.service", ignCfg.Systemd.Units[0].Name)
+	assert.Equal(t, "hugepages-allocation-1048576kB-NUMA1.service", ignCfg.Systemd.Units[1].Name)
+	assert.Contains(t, *ignCfg.Systemd.Units[1].Contents, "echo 4 > /sys/devices/system/node/node1/hugepages/hugepages-1048576kB/nr_hugepages")
+	assert.NoError(t, ctrlcommon.ValidateSystemdUnits(ignCfg))
+
+	t.Run("unsupported page size", func(t *testing.T) {
+		nodes := []corev1.Node{
+			*newHugepagesNode("node-0", nil, "2Mi", "1Gi"),
+			*newHugepagesNode("node-1", nil, "2Mi"),
+		}
+		_, _, err := generateHugepagesMachineConfigSpec(cfg, nodes, nil)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "node-1 does not support hugepages of size 1G")
+	})
+
+	t.Run("reserved memory", func(t *testing.T) {
+		newReservation := func(numaNode int32, resourceName, quantity string) *mcfgv1.KubeletConfig {
+			return newKubeletConfig("reserved", &kubeletconfigv1beta1.KubeletConfiguration{
+				ReservedMemory: []kubeletconfigv1beta1.MemoryReservation{
+					{
+						NumaNode: numaNode,
+						Limits:   corev1.ResourceList{corev1.ResourceName(resourceName): resource.MustParse(quantity)},
+					},
+				},
+			}, nil)
+		}
+
+		_, _, err := generateHugepagesMachineConfigSpec(cfg, nodes, []*mcfgv1.KubeletConfig{newReservation(1, "hugepages-1Gi", "4Gi")})
+		assert.NoError(t, err)
+
+		_, _, err = generateHugepagesMachineConfigSpec(cfg, nodes, []*mcfgv1.KubeletConfig{newReservation(0, "hugepages-2Mi", "512Mi")})
+		assert.NoError(t, err)
+
+		_, _, err = generateHugepagesMachineConfigSpec(cfg, nodes, []*mcfgv1.KubeletConfig{newReservation(0, "hugepages-1Gi", "4Gi")})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "reserves 4Gi of hugepages-1Gi on NUMA node 0, but only 2Gi are allocated")
+
+		_, _, err = generateHugepagesMachineConfigSpec(cfg, nodes, []*mcfgv1.KubeletConfig{newReservation(0, "hugepages-64Ki", "1Mi")})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "does not allocate hugepages of that size")
+	})
+}
+
+func TestHugepagesSync(t *testing.T) {
+	nodeLabels := map[string]string{"node-role/worker": ""}
+	managedKey := "99-worker-generated-hugepages"
+
+	newPool := func(annotation string) *mcfgv1.MachineConfigPool {
+		pool := helpers.NewMachineConfigPool("worker", nil, metav1.AddLabelToSelector(&metav1.LabelSelector{}, "node-role/worker", ""), "v0")
+		if annotation != "" {
+			pool.Annotations = map[string]string{ctrlcommon.HugepagesAnnotationKey: annotation}
+		}
+		return pool
+	}
+
+	newController := func(f *fixture, pool *mcfgv1.MachineConfigPool) *Controller {
+		f.mcpLister = append(f.mcpLister, pool)
+		c := f.newController(nil)
+		c.kubeClient = k8sfake.NewSimpleClientset(newHugepagesNode("node-0", nodeLabels, "2Mi", "1Gi"))
+		return c
+	}
+
+	existingMC := func() *mcfgv1.MachineConfig {
+		mc := helpers.NewMachineConfig(managedKey, map[string]string{mcfgv1.MachineConfigRoleLabelKey: "worker"}, "", nil)
+		mc.Spec.KernelArguments = []string{"hugepagesz=2M", "hugepages=512"}
+		return mc
+	}
+
+	t.Run("creates the generated MachineConfig", func(t *testing.T) {
+		f := newFixture(t)
+		pool := newPool(`{"defaultSize":"1G","pages":[{"size":"1G","count":4}]}`)
+		c := newController(f, pool)
+
+		require.NoError(t, c.syncHugepagesHandler(pool.Name))
+
+		mc, err := f.client.MachineconfigurationV1().MachineConfigs().Get(context.TODO(), managedKey, metav1.GetOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, "worker", mc.Labels[mcfgv1.MachineConfigRoleLabelKey])
+		assert.Equal(t, []string{"default_hugepagesz=1G", "hugepagesz=1G", "hugepages=4"}, mc.Spec.KernelArguments)
+	})
+
+	t.Run("invalid configuration leaves the generated MachineConfig alone", func(t *testing.T) {
+		f := newFixture(t)
+		f.objects = append(f.objects, existingMC())
+		pool := newPool(`{"pages":[{"size":"1G","count":0}]}`)
+		c := newController(f, pool)
+
+		err := c.syncHugepagesHandler(pool.Name)
+		require.Error(t, err)
+		assert.IsType(t, &forgetError{}, err)
+
+		mc, err := f.client.MachineconfigurationV1().MachineConfigs().Get(context.TODO(), managedKey, metav1.GetOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"hugepagesz=2M", "hugepages=512"}, mc.Spec.KernelArguments)
+	})
+
+	t.Run("unsupported page size is retried", func(t *testing.T) {
+		f := newFixture(t)
+		f.objects = append(f.objects, existingMC())
+		pool := newPool(`{"pages":[{"size":"16G","count":1}]}`)
+		c := newController(f, pool)
+
+		err := c.syncHugepagesHandler(pool.Name)
+		require.Error(t, err)
+		_, isForgetError := err.(*forgetError)
+		assert.False(t, isForgetError)
+		assert.Contains(t, err.Error(), "does not support hugepages of size 16G")
+
+		mc, err := f.client.MachineconfigurationV1().MachineConfigs().Get(context.TODO(), managedKey, metav1.GetOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"hugepagesz=2M", "hugepages=512"}, mc.Spec.KernelArguments)
+	})
+
+	t.Run("removing the annotation deletes the generated MachineConfig", func(t *testing.T) {
+		f := newFixture(t)
+		f.objects = append(f.objects, existingMC())
+		pool := newPool("")
+		c := newController(f, pool)
+
+		require.NoError(t, c.syncHugepagesHandler(pool.Name))
+
+		_, err := f.client.MachineconfigurationV1().MachineConfigs().Get(context.TODO(), managedKey, metav1.GetOptions{})
+		assert.True(t, macherrors.IsNotFound(err))
+	})
+}
@@ -0,0 +1,221 @@
+package node
+
+import (
+	"fmt"
+	"time"
+
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	"github.com/openshift/machine-config-operator/pkg/apihelpers"
+	"github.com/openshift/machine-config-operator/pkg/apihelpers/conditions"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	daemonconsts "github.com/openshift/machine-config-operator/pkg/daemon/constants"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+)
+
+// MachineConfigPoolCanaryFailed is true when the canary node of a layered pool failed to update to
+// or run the pool's new image, which halts the rollout of the image to the rest of the pool.
+const MachineConfigPoolCanaryFailed mcfgv1.MachineConfigPoolConditionType = "CanaryFailed"
+
+// canaryFailedReason is the reason of the CanaryFailed and Degraded conditions when the canary node
+// of a layered pool failed.
+const canaryFailedReason = "CanaryNodeFailed"
+
+// canaryStatus describes the progress of the canary node of a layered pool on the pool's image.
+type canaryStatus struct {
+	// node is the canary node, if one has been targeted at the pool's image yet.
+	node *corev1.Node
+	// passed is whether a node has been Ready on the image for the soak period.
+	passed bool
+	// failure describes why the canary node failed, if it did.
+	failure string
+	// remaining is how much longer the canary node must be Ready before it passes.
+	remaining time.Duration
+}
+
+// getCanarySoak returns how long the canary node of the pool must be Ready on a new image before
+// the rest of the pool is updated to it, and whether canary rollouts are enabled for the pool.
+func getCanarySoak(pool *mcfgv1.MachineConfigPool) (time.Duration, bool, error) {
+	val, ok := pool.Annotations[ctrlcommon.CanarySoakAnnotationKey]
+	if !ok {
+		return 0, false, nil
+	}
+
+	soak, err := time.ParseDuration(val)
+	if err != nil {
+		return 0, false, fmt.Errorf("invalid %s annotation %q: %w", ctrlcommon.CanarySoakAnnotationKey, val, err)
+	}
+
+	if soak < 0 {
+		return 0, false, fmt.Errorf("invalid %s annotation %q: must not be negative", ctrlcommon.CanarySoakAnnotationKey, val)
+	}
+
+	return soak, true, nil
+}
+
+// isCanaryPool determines whether new images of the pool are rolled out to a canary node first.
+func isCanaryPool(pool *mcfgv1.MachineConfigPool) (time.Duration, bool) {
+	lps := ctrlcommon.NewLayeredPoolState(pool)
+	if !lps.IsLayered() || !lps.HasOSImage() {
+		return 0, false
+	}
+
+	soak, enabled, err := getCanarySoak(pool)
+	if err != nil {
+		klog.V(4).Infof("Could not get canary soak period of pool %s: %v", pool.Name, err)
+		return 0, false
+	}
+
+	return soak, enabled
+}
+
+// getNodeReadySince returns whether the node is Ready and since when it has been in its current
+// Ready state. Nodes which do not report a Ready condition are considered Ready since forever.
+func getNodeReadySince(node *corev1.Node) (time.Time, bool) {
+	cond := conditions.Node.Get(node.Status.Conditions, corev1.NodeReady)
+	if cond == nil {
+		return time.Time{}, true
+	}
+
+	return cond.LastTransitionTime.Time, cond.Status == corev1.ConditionTrue
+}
+
+// getCanaryStatus determines the progress of the canary node of the pool on the pool's image. The
+// canary node is the first node targeted at the image, so no node may be targeted at it yet.
+func getCanaryStatus(pool *mcfgv1.MachineConfigPool, nodes []*corev1.Node, soak time.Duration, now time.Time) *canaryStatus {
+	status := &canaryStatus{}
+
+	for _, node := range nodes {
+		lns := ctrlcommon.NewLayeredNodeState(node)
+		if !lns.IsDesiredEqualToPool(pool) {
+			continue
+		}
+
+		if status.node == nil {
+			status.node = node
+		}
+
+		if !lns.IsDoneAt(pool) {
+			if isNodeMCDState(node, daemonconsts.MachineConfigDaemonStateDegraded) || isNodeMCDState(node, daemonconsts.MachineConfigDaemonStateUnreconcilable) {
+				status.node = node
+				status.failure = fmt.Sprintf("node %s failed to update to image %s: %s", node.Name, ctrlcommon.NewLayeredPoolState(pool).GetOSImage(), node.Annotations[daemonconsts.MachineConfigDaemonReasonAnnotationKey])
+			}
+			continue
+		}
+
+		readySince, ready := getNodeReadySince(node)
+		elapsed := now.Sub(readySince)
+
+		if !ready {
+			if elapsed > soak {
+				status.node = node
+				status.failure = fmt.Sprintf("node %s has not been Ready for %s since it updated to image %s", node.Name, elapsed.Round(time.Second), ctrlcommon.NewLayeredPoolState(pool).GetOSImage())
+			}
+			continue
+		}
+
+		if elapsed >= soak {
+			return &canaryStatus{node: node, passed: true}
+		}
+
+		if remaining := soak - elapsed; status.remaining == 0 || remaining < status.remaining {
+			status.node = node
+			status.remaining = remaining
+		}
+	}
+
+	return status
+}
+
+// filterCanaryCandidateNodes only lets a single canary node of a layered pool update to a new image
+// until it has been Ready on it for the pool's soak period. The canary node is the first candidate
+// annotated as a canary, or the first candidate in update order. If the canary node fails to update
+// or does not become Ready again, the rollout of the image is halted.
+func (ctrl *Controller) filterCanaryCandidateNodes(pool *mcfgv1.MachineConfigPool, candidates []*corev1.Node, selection *candidateSelection) ([]*corev1.Node, error) {
+	soak, enabled := isCanaryPool(pool)
+	if !enabled || len(candidates) == 0 {
+		return candidates, nil
+	}
+
+	nodes, err := ctrl.getNodesForPool(pool)
+	if err != nil {
+		return nil, err
+	}
+
+	image := ctrlcommon.NewLayeredPoolState(pool).GetOSImage()
+	status := getCanaryStatus(pool, nodes, soak, time.Now())
+
+	switch {
+	case status.passed:
+		return candidates, nil
+	case status.failure != "":
+		ctrl.eventRecorder.Eventf(pool, corev1.EventTypeWarning, "CanaryFailed", "Halting rollout of image %s: %s", image, status.failure)
+		klog.Infof("Halting rollout of image %s to pool %s: %s", image, pool.Name, status.failure)
+		for _, node := range candidates {
+			selection.skip(node, "rollout halted because canary %s", status.failure)
+		}
+		return nil, nil
+	case status.node != nil:
+		for _, node := range candidates {
+			selection.skip(node, "waiting for canary node %s to be Ready on image %s for %s", status.node.Name, image, soak)
+		}
+		if status.remaining > 0 {
+			ctrl.enqueueAfter(pool, status.remaining)
+		}
+		return nil, nil
+	}
+
+	var canary *corev1.Node
+	for _, node := range nodes {
+		if node.Annotations[ctrlcommon.CanaryNodeAnnotationKey] == "true" {
+			canary = node
+			break
+		}
+	}
+
+	if canary == nil {
+		canary = sortNodeList(candidates)[0]
+	}
+
+	var newCandidates []*corev1.Node
+	for _, node := range candidates {
+		if node.Name == canary.Name {
+			newCandidates = append(newCandidates, node)
+			continue
+		}
+		selection.skip(node, "waiting for canary node %s to be Ready on image %s for %s", canary.Name, image, soak)
+	}
+
+	if len(newCandidates) == 0 {
+		klog.Infof("Waiting for canary node %s of pool %s to become an update candidate", canary.Name, pool.Name)
+		return nil, nil
+	}
+
+	ctrl.eventRecorder.Eventf(pool, corev1.EventTypeNormal, "CanaryNodeSelected", "Updating canary node %s to image %s before the rest of the pool", canary.Name, image)
+	return newCandidates, nil
+}
+
+// setCanaryFailedCondition sets the CanaryFailed condition of a pool which rolls out new images to
+// a canary node first, and marks the pool as degraded while the canary node has failed.
+func (ctrl *Controller) setCanaryFailedCondition(pool *mcfgv1.MachineConfigPool, nodes []*corev1.Node, status *mcfgv1.MachineConfigPoolStatus) {
+	soak, enabled := isCanaryPool(pool)
+	if !enabled {
+		apihelpers.RemoveMachineConfigPoolCondition(status, MachineConfigPoolCanaryFailed)
+		return
+	}
+
+	canary := getCanaryStatus(pool, nodes, soak, time.Now())
+	if canary.failure == "" {
+		cond := apihelpers.NewMachineConfigPoolCondition(MachineConfigPoolCanaryFailed, corev1.ConditionFalse, "", "")
+		apihelpers.SetMachineConfigPoolCondition(status, *cond)
+		return
+	}
+
+	msg := fmt.Sprintf("Rollout of image %s is halted: %s", ctrlcommon.NewLayeredPoolState(pool).GetOSImage(), canary.failure)
+
+	cond := apihelpers.NewMachineConfigPoolCondition(MachineConfigPoolCanaryFailed, corev1.ConditionTrue, canaryFailedReason, msg)
+	apihelpers.SetMachineConfigPoolCondition(status, *cond)
+
+	degraded := apihelpers.NewMachineConfigPoolCondition(mcfgv1.MachineConfigPoolDegraded, corev1.ConditionTrue, canaryFailedReason, msg)
+	apihelpers.SetMachineConfigPoolCondition(status, *degraded)
+}
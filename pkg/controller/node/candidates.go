@@ -0,0 +1,155 @@
+package node
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	"github.com/openshift/machine-config-operator/pkg/apihelpers"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// MachineConfigPoolUpdateCandidates carries, in its message, how many nodes of the pool are not
+// targeted at the pool's config yet and why some of them were not selected for an update during
+// the last sync. It is removed once all nodes are targeted.
+const MachineConfigPoolUpdateCandidates mcfgv1.MachineConfigPoolConditionType = "UpdateCandidates"
+
+// nodesWaitingForUpdateReason is the reason of the UpdateCandidates condition.
+const nodesWaitingForUpdateReason = "NodesWaitingForUpdate"
+
+// maxUpdateCandidatesListed caps how many nodes the message of the UpdateCandidates condition
+// lists, so that it stays short in large pools.
+const maxUpdateCandidatesListed = 5
+
+// selectedForUpdateReason is recorded for the nodes which were selected for an update.
+const selectedForUpdateReason = "selected for update"
+
+// candidateSelection records why the node controller did or did not select the nodes of a pool
+// as update candidates during a sync, so that it can be published in the pool's status.
+type candidateSelection struct {
+	// poolReason applies to every node without a reason of its own, e.g. when the pool is paused.
+	poolReason string
+	// nodes maps node names to why they were or were not selected.
+	nodes map[string]string
+}
+
+// skipAll records why none of the nodes of the pool were considered for an update.
+func (c *candidateSelection) skipAll(format string, args ...interface{}) {
+	c.poolReason = fmt.Sprintf(format, args...)
+}
+
+// skip records why the given node was not selected for an update.
+func (c *candidateSelection) skip(node *corev1.Node, format string, args ...interface{}) {
+	c.nodes[node.Name] = fmt.Sprintf(format, args...)
+}
+
+// selected records that the given node was selected for an update.
+func (c *candidateSelection) selected(node *corev1.Node) {
+	c.nodes[node.Name] = selectedForUpdateReason
+}
+
+// reasonFor returns why the given node was or was not selected, if known.
+func (c *candidateSelection) reasonFor(node *corev1.Node) string {
+	if reason, ok := c.nodes[node.Name]; ok {
+		return reason
+	}
+
+	return c.poolReason
+}
+
+// newCandidateSelection starts recording the candidate selection of a sync of the given pool,
+// replacing the one recorded during the previous sync.
+func (ctrl *Controller) newCandidateSelection(pool *mcfgv1.MachineConfigPool) *candidateSelection {
+	selection := &candidateSelection{nodes: map[string]string{}}
+
+	ctrl.candidateSelectionsLock.Lock()
+	defer ctrl.candidateSelectionsLock.Unlock()
+
+	if ctrl.candidateSelections == nil {
+		ctrl.candidateSelections = map[string]*candidateSelection{}
+	}
+
+	ctrl.candidateSelections[pool.Name] = selection
+	return selection
+}
+
+// getCandidateSelection returns the candidate selection recorded during the last sync of the
+// given pool, if any.
+func (ctrl *Controller) getCandidateSelection(pool *mcfgv1.MachineConfigPool) *candidateSelection {
+	ctrl.candidateSelectionsLock.Lock()
+	defer ctrl.candidateSelectionsLock.Unlock()
+
+	return ctrl.candidateSelections[pool.Name]
+}
+
+// deleteCandidateSelection forgets the candidate selection of a pool which no longer exists.
+func (ctrl *Controller) deleteCandidateSelection(name string) {
+	ctrl.candidateSelectionsLock.Lock()
+	defer ctrl.candidateSelectionsLock.Unlock()
+
+	delete(ctrl.candidateSelections, name)
+}
+
+// setUpdateCandidatesCondition publishes why the nodes of the pool which are not targeted at its
+// config yet were not selected for an update, so that it can be seen without reading the logs.
+func (ctrl *Controller) setUpdateCandidatesCondition(pool *mcfgv1.MachineConfigPool, nodes []*corev1.Node, status *mcfgv1.MachineConfigPoolStatus) {
+	selection := ctrl.getCandidateSelection(pool)
+	if selection == nil {
+		return
+	}
+
+	reasons := map[string]string{}
+	for _, node := range nodes {
+		if ctrlcommon.NewLayeredNodeState(node).IsDesiredEqualToPool(pool) {
+			continue
+		}
+
+		if reason := selection.reasonFor(node); reason != "" {
+			reasons[node.Name] = reason
+		}
+	}
+
+	if len(reasons) == 0 {
+		apihelpers.RemoveMachineConfigPoolCondition(status, MachineConfigPoolUpdateCandidates)
+		return
+	}
+
+	cond := apihelpers.NewMachineConfigPoolCondition(MachineConfigPoolUpdateCandidates, corev1.ConditionTrue, nodesWaitingForUpdateReason, getUpdateCandidatesMessage(reasons))
+	apihelpers.SetMachineConfigPoolCondition(status, *cond)
+}
+
+// getUpdateCandidatesMessage summarizes why the given nodes were or were not selected for an
+// update. The nodes which were not selected are listed first, up to maxUpdateCandidatesListed
+// nodes in total.
+func getUpdateCandidatesMessage(reasons map[string]string) string {
+	names := make([]string, 0, len(reasons))
+	selected := 0
+	for name, reason := range reasons {
+		names = append(names, name)
+		if reason == selectedForUpdateReason {
+			selected++
+		}
+	}
+
+	sort.Slice(names, func(i, j int) bool {
+		iSelected := reasons[names[i]] == selectedForUpdateReason
+		jSelected := reasons[names[j]] == selectedForUpdateReason
+		if iSelected != jSelected {
+			return jSelected
+		}
+		return names[i] < names[j]
+	})
+
+	listed := []string{}
+	for _, name := range names {
+		if len(listed) == maxUpdateCandidatesListed {
+			listed = append(listed, fmt.Sprintf("and %d more", len(names)-maxUpdateCandidatesListed))
+			break
+		}
+		listed = append(listed, fmt.Sprintf("%s: %s", name, reasons[name]))
+	}
+
+	return fmt.Sprintf("%d nodes are waiting for an update, %d of which were selected: %s", len(names), selected, strings.Join(listed, "; "))
+}
@@ -0,0 +1,33 @@
+package node
+
+import (
+	"fmt"
+
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+)
+
+// getConfigDriftRemediation returns whether the pool has the MCD remediate config drift instead of
+// degrading the node.
+func getConfigDriftRemediation(pool *mcfgv1.MachineConfigPool) (bool, error) {
+	switch mode := pool.Annotations[ctrlcommon.ConfigDriftRemediationAnnotationKey]; mode {
+	case ctrlcommon.ConfigDriftRemediationRemediate:
+		return true, nil
+	case "", ctrlcommon.ConfigDriftRemediationDegrade:
+		return false, nil
+	default:
+		return false, fmt.Errorf("invalid %s annotation %q: must be %q or %q", ctrlcommon.ConfigDriftRemediationAnnotationKey, mode,
+			ctrlcommon.ConfigDriftRemediationRemediate, ctrlcommon.ConfigDriftRemediationDegrade)
+	}
+}
+
+// getConfigDriftRemediationNodeAnnotation returns the value of the node annotation which hands the pool's config drift remediation mode to
+// the MCD, or "" if the annotation is to be removed.
+func getConfigDriftRemediationNodeAnnotation(pool *mcfgv1.MachineConfigPool) (string, error) {
+	remediate, err := getConfigDriftRemediation(pool)
+	if err != nil || !remediate {
+		return "", err
+	}
+
+	return ctrlcommon.ConfigDriftRemediationRemediate, nil
+}
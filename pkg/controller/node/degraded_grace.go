@@ -0,0 +1,179 @@
+package node
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	"github.com/openshift/machine-config-operator/pkg/apihelpers"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+)
+
+// MachineConfigPoolDegradedPending is true while conditions which make the pool Degraded are true,
+// but have not been true for longer than their grace period yet.
+const MachineConfigPoolDegradedPending mcfgv1.MachineConfigPoolConditionType = "DegradedPending"
+
+const (
+	// degradedGracePeriodReason is the reason of the DegradedPending condition while the pool is
+	// within the grace period of a condition.
+	degradedGracePeriodReason = "DegradedGracePeriod"
+
+	// invalidDegradedGracePeriodReason is the reason of the DegradedPending condition when the
+	// grace periods of the pool cannot be parsed, in which case none are applied.
+	invalidDegradedGracePeriodReason = "InvalidDegradedGracePeriod"
+)
+
+// The conditions which make the pool Degraded, in the order in which they are reported.
+var degradedConditionTypes = []mcfgv1.MachineConfigPoolConditionType{
+	mcfgv1.MachineConfigPoolRenderDegraded,
+	mcfgv1.MachineConfigPoolNodeDegraded,
+}
+
+// pendingDegradedCondition is a condition which is true, but is not reported as Degraded yet.
+type pendingDegradedCondition struct {
+	conditionType mcfgv1.MachineConfigPoolConditionType
+	since         time.Time
+	reportAt      time.Time
+}
+
+// getDegradedGracePeriods returns how long each condition which makes the pool Degraded must be
+// true before it does, and whether the pool sets grace periods at all.
+func getDegradedGracePeriods(pool *mcfgv1.MachineConfigPool) (map[mcfgv1.MachineConfigPoolConditionType]time.Duration, bool, error) {
+	val, ok := pool.Annotations[ctrlcommon.DegradedGracePeriodAnnotationKey]
+	if !ok {
+		return nil, false, nil
+	}
+
+	raw := map[mcfgv1.MachineConfigPoolConditionType]string{}
+	if err := json.Unmarshal([]byte(val), &raw); err != nil {
+		return nil, true, fmt.Errorf("invalid %s annotation %q: %w", ctrlcommon.DegradedGracePeriodAnnotationKey, val, err)
+	}
+
+	periods := map[mcfgv1.MachineConfigPoolConditionType]time.Duration{}
+	for condType, duration := range raw {
+		if !conditionTypeInSlice(condType, degradedConditionTypes) {
+			return nil, true, fmt.Errorf("invalid %s annotation %q: unknown condition type %s, must be one of %s", ctrlcommon.DegradedGracePeriodAnnotationKey, val, condType, joinConditionTypes(degradedConditionTypes))
+		}
+
+		period, err := time.ParseDuration(duration)
+		if err != nil {
+			return nil, true, fmt.Errorf("invalid %s annotation %q: %w", ctrlcommon.DegradedGracePeriodAnnotationKey, val, err)
+		}
+
+		if period < 0 {
+			return nil, true, fmt.Errorf("invalid %s annotation %q: grace period of %s must not be negative", ctrlcommon.DegradedGracePeriodAnnotationKey, val, condType)
+		}
+
+		periods[condType] = period
+	}
+
+	return periods, true, nil
+}
+
+// getDegradedConditions returns the conditions of the given status which make the pool Degraded,
+// split into those which have been true for longer than their grace period and those which have
+// not, sorted by when they will be reported.
+func getDegradedConditions(status *mcfgv1.MachineConfigPoolStatus, periods map[mcfgv1.MachineConfigPoolConditionType]time.Duration, now time.Time) ([]mcfgv1.MachineConfigPoolConditionType, []pendingDegradedCondition) {
+	degraded := []mcfgv1.MachineConfigPoolConditionType{}
+	pending := []pendingDegradedCondition{}
+
+	for _, condType := range degradedConditionTypes {
+		cond := apihelpers.GetMachineConfigPoolCondition(*status, condType)
+		if cond == nil || cond.Status != corev1.ConditionTrue {
+			continue
+		}
+
+		reportAt := cond.LastTransitionTime.Add(periods[condType])
+		if !now.Before(reportAt) {
+			degraded = append(degraded, condType)
+			continue
+		}
+
+		pending = append(pending, pendingDegradedCondition{
+			conditionType: condType,
+			since:         cond.LastTransitionTime.Time,
+			reportAt:      reportAt,
+		})
+	}
+
+	sort.SliceStable(pending, func(i, j int) bool {
+		return pending[i].reportAt.Before(pending[j].reportAt)
+	})
+
+	return degraded, pending
+}
+
+// setDegradedConditions sets the Degraded condition of the given status from the conditions which
+// make the pool Degraded, once they have been true for longer than their grace period. The
+// DegradedPending condition reports those which have not yet.
+func setDegradedConditions(pool *mcfgv1.MachineConfigPool, status *mcfgv1.MachineConfigPoolStatus, now time.Time) {
+	periods, enabled, err := getDegradedGracePeriods(pool)
+	if err != nil {
+		// Without valid grace periods, problems are reported right away.
+		klog.Warningf("Pool %s: %v", pool.Name, err)
+	}
+
+	degraded, pending := getDegradedConditions(status, periods, now)
+
+	if len(degraded) != 0 {
+		sdegraded := apihelpers.NewMachineConfigPoolCondition(mcfgv1.MachineConfigPoolDegraded, corev1.ConditionTrue, "", "")
+		apihelpers.SetMachineConfigPoolCondition(status, *sdegraded)
+	} else {
+		sdegraded := apihelpers.NewMachineConfigPoolCondition(mcfgv1.MachineConfigPoolDegraded, corev1.ConditionFalse, "", "")
+		apihelpers.SetMachineConfigPoolCondition(status, *sdegraded)
+	}
+
+	switch {
+	case !enabled:
+		apihelpers.RemoveMachineConfigPoolCondition(status, MachineConfigPoolDegradedPending)
+	case err != nil:
+		cond := apihelpers.NewMachineConfigPoolCondition(MachineConfigPoolDegradedPending, corev1.ConditionFalse, invalidDegradedGracePeriodReason, err.Error())
+		apihelpers.SetMachineConfigPoolCondition(status, *cond)
+	case len(pending) == 0:
+		cond := apihelpers.NewMachineConfigPoolCondition(MachineConfigPoolDegradedPending, corev1.ConditionFalse, "", "")
+		apihelpers.SetMachineConfigPoolCondition(status, *cond)
+	default:
+		msgs := []string{}
+		for _, p := range pending {
+			msgs = append(msgs, fmt.Sprintf("%s has been true since %s; the pool becomes Degraded at %s unless it recovers", p.conditionType, p.since.UTC().Format(time.RFC3339), p.reportAt.UTC().Format(time.RFC3339)))
+		}
+
+		cond := apihelpers.NewMachineConfigPoolCondition(MachineConfigPoolDegradedPending, corev1.ConditionTrue, degradedGracePeriodReason, strings.Join(msgs, "; "))
+		apihelpers.SetMachineConfigPoolCondition(status, *cond)
+	}
+}
+
+// requeueForDegradedGracePeriod requeues the pool for when the next condition within its grace
+// period is due to make the pool Degraded, since nothing else may trigger a sync by then.
+func (ctrl *Controller) requeueForDegradedGracePeriod(pool *mcfgv1.MachineConfigPool, status *mcfgv1.MachineConfigPoolStatus) {
+	periods, _, _ := getDegradedGracePeriods(pool)
+
+	now := time.Now()
+	if _, pending := getDegradedConditions(status, periods, now); len(pending) != 0 {
+		ctrl.enqueueAfter(pool, pending[0].reportAt.Sub(now))
+	}
+}
+
+func conditionTypeInSlice(condType mcfgv1.MachineConfigPoolConditionType, condTypes []mcfgv1.MachineConfigPoolConditionType) bool {
+	for _, c := range condTypes {
+		if c == condType {
+			return true
+		}
+	}
+
+	return false
+}
+
+func joinConditionTypes(condTypes []mcfgv1.MachineConfigPoolConditionType) string {
+	out := []string{}
+	for _, c := range condTypes {
+		out = append(out, string(c))
+	}
+
+	return strings.Join(out, ", ")
+}
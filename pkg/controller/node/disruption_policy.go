@@ -0,0 +1,82 @@
+package node
+
+import (
+	"fmt"
+	"strings"
+
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	"github.com/openshift/machine-config-operator/pkg/apihelpers"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	daemonconsts "github.com/openshift/machine-config-operator/pkg/daemon/constants"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// MachineConfigPoolDisruptionPolicy lists the files whose changes the pool applies without a reboot
+// in its message. It is false when the pool's disruption policy is invalid, in which case the
+// message describes the problem instead and the nodes keep the policy they were given before.
+const MachineConfigPoolDisruptionPolicy mcfgv1.MachineConfigPoolConditionType = "DisruptionPolicy"
+
+// invalidDisruptionPolicyReason is the reason of the DisruptionPolicy condition when the pool's
+// disruption policy cannot be parsed.
+const invalidDisruptionPolicyReason = "InvalidDisruptionPolicy"
+
+// getDisruptionPolicy returns the pool's disruption policy, or nil if there is none.
+func getDisruptionPolicy(pool *mcfgv1.MachineConfigPool) (*ctrlcommon.DisruptionPolicy, error) {
+	val := pool.Annotations[ctrlcommon.DisruptionPolicyAnnotationKey]
+
+	policy, err := ctrlcommon.ParseDisruptionPolicy(val)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s annotation %q: %w", ctrlcommon.DisruptionPolicyAnnotationKey, val, err)
+	}
+
+	return policy, nil
+}
+
+// getDisruptionPolicyNodeAnnotation returns the value of the node annotation which hands the pool's disruption policy to
+// the MCD, or "" if the annotation is to be removed.
+func getDisruptionPolicyNodeAnnotation(pool *mcfgv1.MachineConfigPool) (string, error) {
+	policy, err := getDisruptionPolicy(pool)
+	if err != nil || policy == nil {
+		return "", err
+	}
+
+	return policy.String(), nil
+}
+
+// setDisruptionPolicyCondition reports the files whose changes the pool applies without a reboot
+// along with the nodes which have not been handed the policy yet.
+func setDisruptionPolicyCondition(pool *mcfgv1.MachineConfigPool, nodes []*corev1.Node, status *mcfgv1.MachineConfigPoolStatus) {
+	policy, err := getDisruptionPolicy(pool)
+	if err != nil {
+		cond := apihelpers.NewMachineConfigPoolCondition(MachineConfigPoolDisruptionPolicy, corev1.ConditionFalse, invalidDisruptionPolicyReason, err.Error())
+		apihelpers.SetMachineConfigPoolCondition(status, *cond)
+		return
+	}
+
+	if policy == nil {
+		apihelpers.RemoveMachineConfigPoolCondition(status, MachineConfigPoolDisruptionPolicy)
+		return
+	}
+
+	desired := policy.String()
+
+	pending := []string{}
+	for _, node := range nodes {
+		if node.Annotations[daemonconsts.DisruptionPolicyAnnotationKey] != desired {
+			pending = append(pending, node.Name)
+		}
+	}
+
+	paths := make([]string, 0, len(policy.Files))
+	for _, file := range policy.Files {
+		paths = append(paths, file.Path)
+	}
+
+	msg := fmt.Sprintf("Applied without a reboot: %s", strings.Join(paths, ", "))
+	if len(pending) != 0 {
+		msg = fmt.Sprintf("%s; not yet applied on %d nodes: %s", msg, len(pending), strings.Join(pending, ", "))
+	}
+
+	cond := apihelpers.NewMachineConfigPoolCondition(MachineConfigPoolDisruptionPolicy, corev1.ConditionTrue, "", msg)
+	apihelpers.SetMachineConfigPoolCondition(status, *cond)
+}
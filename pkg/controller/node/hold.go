@@ -0,0 +1,77 @@
+package node
+
+import (
+	"fmt"
+	"strings"
+
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	"github.com/openshift/machine-config-operator/pkg/apihelpers"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	daemonconsts "github.com/openshift/machine-config-operator/pkg/daemon/constants"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// MachineConfigPoolHeldNodes is true when an admin holds some of the pool's nodes at their current
+// config, in which case its message lists them. It is removed once no node is held.
+const MachineConfigPoolHeldNodes mcfgv1.MachineConfigPoolConditionType = "HeldNodes"
+
+// nodesHeldReason is the reason of the HeldNodes condition.
+const nodesHeldReason = "NodesHeld"
+
+// getHeldNodes returns the nodes which are held at their current config.
+func getHeldNodes(nodes []*corev1.Node) []*corev1.Node {
+	held := []*corev1.Node{}
+	for _, node := range nodes {
+		if ctrlcommon.IsNodeHeld(node) {
+			held = append(held, node)
+		}
+	}
+
+	return held
+}
+
+// getOutdatedHeldNodes returns the held nodes which are not updated to the pool's config.
+func getOutdatedHeldNodes(pool *mcfgv1.MachineConfigPool, nodes []*corev1.Node) []*corev1.Node {
+	outdated := []*corev1.Node{}
+	for _, node := range getHeldNodes(nodes) {
+		if !ctrlcommon.NewLayeredNodeState(node).IsDoneAt(pool) {
+			outdated = append(outdated, node)
+		}
+	}
+
+	return outdated
+}
+
+// filterHeldCandidateNodes leaves out the candidate nodes which an admin holds at their current
+// config. The pool is synced again when the hold is lifted, since that updates the node.
+func (ctrl *Controller) filterHeldCandidateNodes(pool *mcfgv1.MachineConfigPool, candidates []*corev1.Node, selection *candidateSelection) []*corev1.Node {
+	var newCandidates []*corev1.Node
+	for _, node := range candidates {
+		if !ctrlcommon.IsNodeHeld(node) {
+			newCandidates = append(newCandidates, node)
+			continue
+		}
+		ctrl.logPool(pool, "Not updating node %s, it is held by the %s annotation", node.Name, daemonconsts.HoldAnnotationKey)
+		selection.skip(node, "held by the %s annotation", daemonconsts.HoldAnnotationKey)
+	}
+
+	return newCandidates
+}
+
+// setHeldNodesCondition reports the nodes of the pool which are held at their current config.
+func setHeldNodesCondition(pool *mcfgv1.MachineConfigPool, nodes []*corev1.Node, status *mcfgv1.MachineConfigPoolStatus) {
+	held := getHeldNodes(nodes)
+	if len(held) == 0 {
+		apihelpers.RemoveMachineConfigPoolCondition(status, MachineConfigPoolHeldNodes)
+		return
+	}
+
+	names := []string{}
+	for _, node := range held {
+		names = append(names, node.Name)
+	}
+
+	msg := fmt.Sprintf("%d nodes are held at their current config, %d of which are not updated to %s: %s", len(held), len(getOutdatedHeldNodes(pool, held)), getPoolUpdateLine(pool), strings.Join(names, ", "))
+	cond := apihelpers.NewMachineConfigPoolCondition(MachineConfigPoolHeldNodes, corev1.ConditionTrue, nodesHeldReason, msg)
+	apihelpers.SetMachineConfigPoolCondition(status, *cond)
+}
@@ -0,0 +1,97 @@
+package node
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	"github.com/openshift/machine-config-operator/pkg/apihelpers"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	daemonconsts "github.com/openshift/machine-config-operator/pkg/daemon/constants"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+)
+
+// MachineConfigPoolMaintenanceWindows lists the windows during which updates which reboot the
+// pool's nodes are applied in its message, along with the nodes whose update waits for the next
+// one. It is false when the pool's maintenance windows are invalid, in which case the message
+// describes the problem instead and the nodes keep the windows they were given before.
+const MachineConfigPoolMaintenanceWindows mcfgv1.MachineConfigPoolConditionType = "MaintenanceWindows"
+
+// invalidMaintenanceWindowsReason is the reason of the MaintenanceWindows condition when the
+// pool's maintenance windows cannot be parsed.
+const invalidMaintenanceWindowsReason = "InvalidMaintenanceWindows"
+
+// getMaintenanceWindows returns the windows during which updates which reboot the pool's nodes
+// are applied. Without windows, they are applied right away.
+func getMaintenanceWindows(pool *mcfgv1.MachineConfigPool) ([]ctrlcommon.MaintenanceWindow, error) {
+	windows, err := ctrlcommon.ParseMaintenanceWindows(pool.Annotations[ctrlcommon.MaintenanceWindowsAnnotationKey])
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s annotation: %w", ctrlcommon.MaintenanceWindowsAnnotationKey, err)
+	}
+
+	return windows, nil
+}
+
+// getNodePendingUpdate returns the config of the update which waits on the node for its next
+// maintenance window, as reported by the MCD.
+func getNodePendingUpdate(node *corev1.Node) string {
+	val, ok := ctrlcommon.GetNodeStatus(node, daemonconsts.PendingUpdateAnnotationKey)
+	if !ok || val == "" {
+		return ""
+	}
+
+	pending := struct {
+		Config string `json:"config"`
+	}{}
+	if err := json.Unmarshal([]byte(val), &pending); err != nil {
+		klog.V(4).Infof("Could not parse pending update %q of node %s: %v", val, node.Name, err)
+		return ""
+	}
+
+	return pending.Config
+}
+
+// getMaintenanceWindowsNodeAnnotation returns the value of the node annotation which hands the pool's maintenance windows to
+// the MCD, or "" if the annotation is to be removed.
+func getMaintenanceWindowsNodeAnnotation(pool *mcfgv1.MachineConfigPool) (string, error) {
+	windows, err := getMaintenanceWindows(pool)
+	if err != nil {
+		return "", err
+	}
+
+	return ctrlcommon.FormatMaintenanceWindows(windows), nil
+}
+
+// setMaintenanceWindowsCondition reports the pool's maintenance windows along with the nodes
+// whose update waits for the next one.
+func setMaintenanceWindowsCondition(pool *mcfgv1.MachineConfigPool, nodes []*corev1.Node, status *mcfgv1.MachineConfigPoolStatus) {
+	windows, err := getMaintenanceWindows(pool)
+	if err != nil {
+		cond := apihelpers.NewMachineConfigPoolCondition(MachineConfigPoolMaintenanceWindows, corev1.ConditionFalse, invalidMaintenanceWindowsReason, err.Error())
+		apihelpers.SetMachineConfigPoolCondition(status, *cond)
+		return
+	}
+
+	if len(windows) == 0 {
+		apihelpers.RemoveMachineConfigPoolCondition(status, MachineConfigPoolMaintenanceWindows)
+		return
+	}
+
+	pending := []string{}
+	for _, node := range nodes {
+		// Updates which were superseded by another desired config no longer wait.
+		if config := getNodePendingUpdate(node); config != "" && config == node.Annotations[daemonconsts.DesiredMachineConfigAnnotationKey] {
+			pending = append(pending, node.Name)
+		}
+	}
+
+	msg := fmt.Sprintf("Updates which reboot nodes are applied during: %s UTC", ctrlcommon.FormatMaintenanceWindows(windows))
+	if len(pending) != 0 {
+		msg = fmt.Sprintf("%s; waiting for the next window on %d nodes: %s", msg, len(pending), strings.Join(pending, ", "))
+	}
+
+	cond := apihelpers.NewMachineConfigPoolCondition(MachineConfigPoolMaintenanceWindows, corev1.ConditionTrue, "", msg)
+	apihelpers.SetMachineConfigPoolCondition(status, *cond)
+}
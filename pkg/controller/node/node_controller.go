@@ -6,6 +6,9 @@ import (
 	"fmt"
 	"reflect"
 	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	configv1 "github.com/openshift/api/config/v1"
@@ -24,12 +27,14 @@ import (
 	daemonconsts "github.com/openshift/machine-config-operator/pkg/daemon/constants"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/types"
 	kubeErrs "k8s.io/apimachinery/pkg/util/errors"
 	intstrutil "k8s.io/apimachinery/pkg/util/intstr"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/apimachinery/pkg/util/strategicpatch"
 	"k8s.io/apimachinery/pkg/util/wait"
 	coreinformersv1 "k8s.io/client-go/informers/core/v1"
@@ -66,8 +71,61 @@ const (
 
 	// schedulerCRName that we're interested in watching.
 	schedulerCRName = "cluster"
+
+	// defaultCriticalWindowMaxDefer is how long critical window pods may defer a node update
+	// when the pool does not set the critical-window-max-defer annotation.
+	defaultCriticalWindowMaxDefer = time.Hour
+
+	// criticalWindowRecheckInterval is how often a pool with deferred nodes is requeued so that
+	// closed critical windows are noticed; pod updates do not trigger a pool sync on their own.
+	criticalWindowRecheckInterval = time.Minute
+
+	// staleProvisionedMachineWindow is how long after provisioning a node is considered when
+	// checking whether new nodes were served a stale rendered MachineConfig.
+	staleProvisionedMachineWindow = 24 * time.Hour
+
+	// staleProvisionedMachinesReason is the reason of the StaleProvisionedMachines condition
+	// when recently provisioned nodes were served a stale rendered MachineConfig.
+	staleProvisionedMachinesReason = "NodesProvisionedWithStaleConfig"
+
+	// safeModeBlockedReason is the reason of the SafeModeBlocked condition when the pool is in
+	// safe mode and its update requires draining or rebooting nodes.
+	safeModeBlockedReason = "DisruptiveUpdateDeferred"
+
+	// componentVersionSkewReason is the reason of the ComponentVersionSkew condition when nodes
+	// on the same rendered MachineConfig report different component versions.
+	componentVersionSkewReason = "ComponentVersionMismatch"
+
+	// lowDiskNodesReason is the reason of the LowDiskNodes condition when nodes which still need
+	// to be updated do not have the free disk space required by the pool.
+	lowDiskNodesReason = "InsufficientFreeDisk"
+
+	// revertingFromLayeringReason is the reason of the RevertingFromLayering condition while nodes
+	// of a pool which was opted out of layering are still on a layered OS image.
+	revertingFromLayeringReason = "RevertingToStockImage"
 )
 
+// MachineConfigPoolStaleProvisionedMachines means that recently provisioned nodes in the pool
+// were served an older rendered MachineConfig than the pool's current one.
+const MachineConfigPoolStaleProvisionedMachines mcfgv1.MachineConfigPoolConditionType = "StaleProvisionedMachines"
+
+// MachineConfigPoolSafeModeBlocked means that the pool is in safe mode and its update is deferred
+// because it requires draining or rebooting nodes.
+const MachineConfigPoolSafeModeBlocked mcfgv1.MachineConfigPoolConditionType = "SafeModeBlocked"
+
+// MachineConfigPoolComponentVersionSkew summarizes the kubelet, CRI-O, rpm-ostree and OS versions
+// reported by the nodes in the pool, and is true when nodes on the same rendered MachineConfig
+// report different versions.
+const MachineConfigPoolComponentVersionSkew mcfgv1.MachineConfigPoolConditionType = "ComponentVersionSkew"
+
+// MachineConfigPoolLowDiskNodes means that nodes in the pool are not selected for an update because
+// they reported less free disk space than the pool requires.
+const MachineConfigPoolLowDiskNodes mcfgv1.MachineConfigPoolConditionType = "LowDiskNodes"
+
+// MachineConfigPoolRevertingFromLayering means that the pool was opted out of layering and its
+// nodes are being rolled back from the layered OS image onto the stock OS image.
+const MachineConfigPoolRevertingFromLayering mcfgv1.MachineConfigPoolConditionType = "RevertingFromLayering"
+
 // Controller defines the node controller.
 type Controller struct {
 	client        mcfgclientset.Interface
@@ -82,11 +140,13 @@ type Controller struct {
 	mcpLister  mcfglistersv1.MachineConfigPoolLister
 	nodeLister corelisterv1.NodeLister
 	podLister  corelisterv1.PodLister
+	cmLister   corelisterv1.ConfigMapLister
 
 	ccListerSynced   cache.InformerSynced
 	mcListerSynced   cache.InformerSynced
 	mcpListerSynced  cache.InformerSynced
 	nodeListerSynced cache.InformerSynced
+	cmListerSynced   cache.InformerSynced
 
 	schedulerList         cligolistersv1.SchedulerLister
 	schedulerListerSynced cache.InformerSynced
@@ -96,6 +156,16 @@ type Controller struct {
 	// updateDelay is a pause to deal with churn in MachineConfigs; see
 	// https://github.com/openshift/machine-config-operator/issues/301
 	updateDelay time.Duration
+
+	// candidateSelections records, per pool, why nodes were or were not selected as update
+	// candidates during the last sync.
+	candidateSelectionsLock sync.Mutex
+	candidateSelections     map[string]*candidateSelection
+
+	// criticalWindowDeferredNodes records, per pool, the nodes whose update is deferred by
+	// critical window pods, so that an event is only emitted when a node becomes deferred.
+	criticalWindowDeferredNodesLock sync.Mutex
+	criticalWindowDeferredNodes     map[string]sets.String
 }
 
 func New(
@@ -104,6 +174,7 @@ func New(
 	mcpInformer mcfginformersv1.MachineConfigPoolInformer,
 	nodeInformer coreinformersv1.NodeInformer,
 	podInformer coreinformersv1.PodInformer,
+	cmInformer coreinformersv1.ConfigMapInformer,
 	schedulerInformer cligoinformersv1.SchedulerInformer,
 	kubeClient clientset.Interface,
 	mcfgClient mcfgclientset.Interface,
@@ -114,6 +185,7 @@ func New(
 		mcpInformer,
 		nodeInformer,
 		podInformer,
+		cmInformer,
 		schedulerInformer,
 		kubeClient,
 		mcfgClient,
@@ -127,6 +199,7 @@ func NewWithCustomUpdateDelay(
 	mcpInformer mcfginformersv1.MachineConfigPoolInformer,
 	nodeInformer coreinformersv1.NodeInformer,
 	podInformer coreinformersv1.PodInformer,
+	cmInformer coreinformersv1.ConfigMapInformer,
 	schedulerInformer cligoinformersv1.SchedulerInformer,
 	kubeClient clientset.Interface,
 	mcfgClient mcfgclientset.Interface,
@@ -138,6 +211,7 @@ func NewWithCustomUpdateDelay(
 		mcpInformer,
 		nodeInformer,
 		podInformer,
+		cmInformer,
 		schedulerInformer,
 		kubeClient,
 		mcfgClient,
@@ -152,6 +226,7 @@ func newController(
 	mcpInformer mcfginformersv1.MachineConfigPoolInformer,
 	nodeInformer coreinformersv1.NodeInformer,
 	podInformer coreinformersv1.PodInformer,
+	cmInformer coreinformersv1.ConfigMapInformer,
 	schedulerInformer cligoinformersv1.SchedulerInformer,
 	kubeClient clientset.Interface,
 	mcfgClient mcfgclientset.Interface,
@@ -192,10 +267,12 @@ func newController(
 	ctrl.mcpLister = mcpInformer.Lister()
 	ctrl.nodeLister = nodeInformer.Lister()
 	ctrl.podLister = podInformer.Lister()
+	ctrl.cmLister = cmInformer.Lister()
 	ctrl.ccListerSynced = ccInformer.Informer().HasSynced
 	ctrl.mcListerSynced = mcInformer.Informer().HasSynced
 	ctrl.mcpListerSynced = mcpInformer.Informer().HasSynced
 	ctrl.nodeListerSynced = nodeInformer.Informer().HasSynced
+	ctrl.cmListerSynced = cmInformer.Informer().HasSynced
 
 	ctrl.schedulerList = schedulerInformer.Lister()
 	ctrl.schedulerListerSynced = schedulerInformer.Informer().HasSynced
@@ -208,7 +285,7 @@ func (ctrl *Controller) Run(workers int, stopCh <-chan struct{}) {
 	defer utilruntime.HandleCrash()
 	defer ctrl.queue.ShutDown()
 
-	if !cache.WaitForCacheSync(stopCh, ctrl.ccListerSynced, ctrl.mcListerSynced, ctrl.mcpListerSynced, ctrl.nodeListerSynced, ctrl.schedulerListerSynced) {
+	if !cache.WaitForCacheSync(stopCh, ctrl.ccListerSynced, ctrl.mcListerSynced, ctrl.mcpListerSynced, ctrl.nodeListerSynced, ctrl.cmListerSynced, ctrl.schedulerListerSynced) {
 		return
 	}
 
@@ -553,6 +630,7 @@ func (ctrl *Controller) updateNode(old, cur interface{}) {
 			daemonconsts.MachineConfigDaemonReasonAnnotationKey,
 			daemonconsts.CurrentImageAnnotationKey,
 			daemonconsts.DesiredImageAnnotationKey,
+			daemonconsts.ComponentVersionsAnnotationKey,
 		}
 
 		for _, anno := range annos {
@@ -560,7 +638,7 @@ func (ctrl *Controller) updateNode(old, cur interface{}) {
 				continue
 			}
 
-			newValue, ok := curNode.Annotations[anno]
+			newValue, ok := getNodeAnnotation(curNode, anno)
 
 			var changedMsg string
 			var controlPlaneChangedMsg string
@@ -586,6 +664,21 @@ func (ctrl *Controller) updateNode(old, cur interface{}) {
 			ctrl.logPoolNode(pool, curNode, "changed taints")
 			changed = true
 		}
+		// The free disk space changes too often to be worth logging, but nodes which were
+		// deferred for lack of space may now be updated.
+		if hasNodeAnnotationChanged(oldNode, curNode, daemonconsts.FreeDiskAnnotationKey) {
+			changed = true
+		}
+		// Nodes which failed their preflight checks no longer count as unavailable, and the
+		// pool reports them.
+		if hasNodeAnnotationChanged(oldNode, curNode, daemonconsts.PreflightCheckFailureAnnotationKey) {
+			ctrl.logPoolNode(pool, curNode, "changed preflight check failure")
+			changed = true
+		}
+		if hasNodeAnnotationChanged(oldNode, curNode, daemonconsts.PostUpdateVerificationFailureAnnotationKey) {
+			ctrl.logPoolNode(pool, curNode, "changed post-update verification failure")
+			changed = true
+		}
 	}
 
 	if !changed {
@@ -605,9 +698,15 @@ func (ctrl *Controller) updateNode(old, cur interface{}) {
 	}
 }
 
+// Gets the value of the given annotation of the node. The informational
+// annotations which the MCD now reports in its node status are read from there.
+func getNodeAnnotation(node *corev1.Node, key string) (string, bool) {
+	return ctrlcommon.GetNodeStatus(node, key)
+}
+
 func hasNodeAnnotationChanged(oldNode, curNode *corev1.Node, key string) bool {
-	oldValue, oldOK := oldNode.Annotations[key]
-	newValue, newOK := curNode.Annotations[key]
+	oldValue, oldOK := getNodeAnnotation(oldNode, key)
+	newValue, newOK := getNodeAnnotation(curNode, key)
 
 	// If we had an annotation, but no longer have it, we've changed.
 	if oldOK != newOK {
@@ -837,7 +936,28 @@ func (ctrl *Controller) canLayeredPoolContinue(pool *mcfgv1.MachineConfigPool) (
 		return fmt.Sprintf("Image annotation %s is not set", ctrlcommon.ExperimentalNewestLayeredImageEquivalentConfigAnnotationKey), false, nil
 	}
 
+	// Only roll out images by digest, since a tag may be moved to a different
+	// image while nodes are updating.
+	if err := ctrlcommon.ValidateDigestedPullspec(pullspec); err != nil {
+		return "Image is not referenced by digest", false, fmt.Errorf("refusing to roll out image for MachineConfigPool %s: %w", pool.Name, err)
+	}
+
+	// A rollback retargets the nodes at a retained image, so it does not wait
+	// for the build of the newest config. The nodes keep the pool's rendered
+	// MachineConfig, so the image must have been built from it.
+	rollbackImage, err := lps.GetRollbackImage()
+	if err != nil {
+		return "Rollback image cannot be rolled out", false, fmt.Errorf("refusing to roll back MachineConfigPool %s: %w", pool.Name, err)
+	}
+
 	switch {
+	case rollbackImage != "":
+		msg := fmt.Sprintf("Rolled back to retained image, pullspec: %s", rollbackImage)
+		return msg, true, nil
+	// In validate-only mode, the image is only built to verify that it can be.
+	case lps.IsBuildSuccess() && hasImage && lps.IsValidateOnly():
+		msg := fmt.Sprintf("Image built successfully in validate-only mode, not rolling out pullspec: %s", pullspec)
+		return msg, false, nil
 	// If the build is successful and we have the image pullspec, we can proceed
 	// with rolling out the new OS image.
 	case lps.IsBuildSuccess() && hasImage:
@@ -870,6 +990,8 @@ func (ctrl *Controller) syncMachineConfigPool(key string) error {
 	machineconfigpool, err := ctrl.mcpLister.Get(name)
 	if errors.IsNotFound(err) {
 		klog.V(2).Infof("MachineConfigPool %v has been deleted", key)
+		ctrl.deleteCandidateSelection(name)
+		ctrl.setCriticalWindowDeferredNodes(name, nil)
 		return nil
 	}
 	if err != nil {
@@ -899,10 +1021,13 @@ func (ctrl *Controller) syncMachineConfigPool(key string) error {
 		return ctrl.syncStatusOnly(pool)
 	}
 
+	selection := ctrl.newCandidateSelection(pool)
+
 	if pool.Spec.Paused {
 		if apihelpers.IsMachineConfigPoolConditionTrue(pool.Status.Conditions, mcfgv1.MachineConfigPoolUpdating) {
 			klog.Infof("Pool %s is paused and will not update.", pool.Name)
 		}
+		selection.skipAll("pool is paused")
 		return ctrl.syncStatusOnly(pool)
 	}
 
@@ -916,6 +1041,7 @@ func (ctrl *Controller) syncMachineConfigPool(key string) error {
 		if !canApplyUpdates {
 			// The MachineConfigPool is not ready to continue, so requeue.
 			klog.Infof("Requeueing layered pool %s: %s", pool.Name, reason)
+			selection.skipAll("waiting for the pool's OS image: %s", reason)
 			return ctrl.syncStatusOnly(pool)
 		}
 
@@ -942,9 +1068,27 @@ func (ctrl *Controller) syncMachineConfigPool(key string) error {
 		return err
 	}
 
+	blockedChanges, err := ctrl.getSafeModeBlockedChanges(pool, nodes)
+	if err != nil {
+		if syncErr := ctrl.syncStatusOnly(pool); syncErr != nil {
+			errs := kubeErrs.NewAggregate([]error{syncErr, err})
+			return fmt.Errorf("error checking safe mode for pool %q, sync error: %w", pool.Name, errs)
+		}
+		return err
+	}
+
+	if len(blockedChanges) != 0 {
+		ctrl.logPool(pool, "Safe mode is enabled, deferring update to %s which requires draining or rebooting nodes: %s", pool.Spec.Configuration.Name, strings.Join(blockedChanges, ", "))
+		selection.skipAll("safe mode defers changes which require draining or rebooting nodes: %s", strings.Join(blockedChanges, ", "))
+		return ctrl.syncStatusOnly(pool)
+	}
+
 	if err := ctrl.setClusterConfigAnnotation(nodes); err != nil {
 		return fmt.Errorf("error setting clusterConfig Annotation for node in pool %q, error: %w", pool.Name, err)
 	}
+	if err := ctrl.setPoolNodeAnnotations(pool, nodes); err != nil {
+		return fmt.Errorf("error setting pool annotations for node in pool %q, error: %w", pool.Name, err)
+	}
 	// Taint all the nodes in the node pool, irrespective of their upgrade status.
 	ctx := context.TODO()
 	for _, node := range nodes {
@@ -971,6 +1115,10 @@ func (ctrl *Controller) syncMachineConfigPool(key string) error {
 		}
 	}
 	candidates, capacity := getAllCandidateMachines(pool, nodes, maxunavail)
+	if capacity == 0 {
+		selection.skipAll("maxUnavailable is %d and that many nodes are already unavailable, updating or failing to update", maxunavail)
+	}
+	candidates = ctrl.filterHeldCandidateNodes(pool, candidates, selection)
 	if len(candidates) > 0 {
 		zones := make(map[string]bool)
 		for _, candidate := range candidates {
@@ -980,13 +1128,28 @@ func (ctrl *Controller) syncMachineConfigPool(key string) error {
 			}
 		}
 		ctrl.logPool(pool, "%d candidate nodes in %d zones for update, capacity: %d", len(candidates), len(zones), capacity)
-		if err := ctrl.updateCandidateMachines(pool, candidates, capacity); err != nil {
+		if err := ctrl.updateCandidateMachines(pool, candidates, capacity, selection); err != nil {
 			if syncErr := ctrl.syncStatusOnly(pool); syncErr != nil {
 				errs := kubeErrs.NewAggregate([]error{syncErr, err})
 				return fmt.Errorf("error setting annotations for pool %q, sync error: %w", pool.Name, errs)
 			}
 			return err
 		}
+	} else if capacity > 0 {
+		if err := ctrl.requestStagedUpdateFinalizations(pool, nodes, capacity); err != nil {
+			if syncErr := ctrl.syncStatusOnly(pool); syncErr != nil {
+				errs := kubeErrs.NewAggregate([]error{syncErr, err})
+				return fmt.Errorf("error requesting staged update finalizations for pool %q, sync error: %w", pool.Name, errs)
+			}
+			return err
+		}
+		if err := ctrl.requestPeriodicReboots(pool, nodes, capacity); err != nil {
+			if syncErr := ctrl.syncStatusOnly(pool); syncErr != nil {
+				errs := kubeErrs.NewAggregate([]error{syncErr, err})
+				return fmt.Errorf("error requesting periodic reboots for pool %q, sync error: %w", pool.Name, errs)
+			}
+			return err
+		}
 	}
 	return ctrl.syncStatusOnly(pool)
 }
@@ -1054,7 +1217,57 @@ func (ctrl *Controller) setClusterConfigAnnotation(nodes []*corev1.Node) error {
 	return nil
 }
 
+// getImageSigningPublicKey returns the public key the pool's layered image is verified with, as recorded in the
+// status of the build which built it, or "" if the pool has no layered image or the image was not signed. While the
+// build controller signs images, an image whose signature cannot be found is not rolled out, so that the MCD never
+// skips verifying it.
+func (ctrl *Controller) getImageSigningPublicKey(pool *mcfgv1.MachineConfigPool) (string, error) {
+	lps := ctrlcommon.NewLayeredPoolState(pool)
+	if !lps.IsLayered() || !lps.HasOSImage() {
+		return "", nil
+	}
+
+	onClusterBuildConfig, err := ctrl.cmLister.ConfigMaps(ctrlcommon.MCONamespace).Get(ctrlcommon.OnClusterBuildConfigMapName)
+	if err != nil && !errors.IsNotFound(err) {
+		return "", fmt.Errorf("could not get build controller config %s: %w", ctrlcommon.OnClusterBuildConfigMapName, err)
+	}
+
+	signingEnabled := err == nil && ctrlcommon.IsImageSigningEnabled(onClusterBuildConfig)
+	image := lps.GetOSImage()
+
+	name := ctrlcommon.GetBuildStatusConfigMapName(pool)
+	cm, err := ctrl.cmLister.ConfigMaps(ctrlcommon.MCONamespace).Get(name)
+	if errors.IsNotFound(err) {
+		if signingEnabled {
+			return "", fmt.Errorf("refusing to roll out image %s for MachineConfigPool %s: image signing is enabled, but build status %s was not found", image, pool.Name, name)
+		}
+
+		return "", nil
+	}
+
+	if err != nil {
+		return "", fmt.Errorf("could not get build status %s: %w", name, err)
+	}
+
+	status, err := ctrlcommon.ParseImageBuildStatus(cm)
+	if err != nil {
+		return "", err
+	}
+
+	publicKey := ctrlcommon.GetImageSigningPublicKey(status, image)
+	if publicKey == "" && signingEnabled {
+		return "", fmt.Errorf("refusing to roll out image %s for MachineConfigPool %s: image signing is enabled, but build status %s does not record a signature for it", image, pool.Name, name)
+	}
+
+	return publicKey, nil
+}
+
 func (ctrl *Controller) updateCandidateNode(nodeName string, pool *mcfgv1.MachineConfigPool) error {
+	imageSigningPublicKey, err := ctrl.getImageSigningPublicKey(pool)
+	if err != nil {
+		return err
+	}
+
 	return clientretry.RetryOnConflict(constants.NodeUpdateBackoff, func() error {
 		oldNode, err := ctrl.kubeClient.CoreV1().Nodes().Get(context.TODO(), nodeName, metav1.GetOptions{})
 		if err != nil {
@@ -1073,7 +1286,7 @@ func (ctrl *Controller) updateCandidateNode(nodeName string, pool *mcfgv1.Machin
 		}
 
 		// Set the desired state to match the pool.
-		lns.SetDesiredStateFromPool(pool)
+		lns.SetDesiredStateFromPool(pool, imageSigningPublicKey)
 
 		newData, err := json.Marshal(lns.Node())
 		if err != nil {
@@ -1150,7 +1363,7 @@ func (ctrl *Controller) getOperatorNodeName() (string, error) {
 // filterControlPlaneCandidateNodes adjusts the candidates and capacity specifically
 // for the control plane, e.g. based on which node is running the operator node at the time.
 // nolint:unparam
-func (ctrl *Controller) filterControlPlaneCandidateNodes(pool *mcfgv1.MachineConfigPool, candidates []*corev1.Node, capacity uint) ([]*corev1.Node, uint, error) {
+func (ctrl *Controller) filterControlPlaneCandidateNodes(pool *mcfgv1.MachineConfigPool, candidates []*corev1.Node, capacity uint, selection *candidateSelection) ([]*corev1.Node, uint, error) {
 	if len(candidates) <= 1 {
 		return candidates, capacity, nil
 	}
@@ -1163,6 +1376,7 @@ func (ctrl *Controller) filterControlPlaneCandidateNodes(pool *mcfgv1.MachineCon
 		if node.Name == operatorNodeName {
 			ctrl.eventRecorder.Eventf(pool, corev1.EventTypeNormal, "DeferringOperatorNodeUpdate", "Deferring update of machine config operator node %s", node.Name)
 			klog.Infof("Deferring update of machine config operator node: %s", node.Name)
+			selection.skip(node, "runs the machine-config-operator, which is updated last")
 			continue
 		}
 		newCandidates = append(newCandidates, node)
@@ -1170,11 +1384,468 @@ func (ctrl *Controller) filterControlPlaneCandidateNodes(pool *mcfgv1.MachineCon
 	return newCandidates, capacity, nil
 }
 
-// updateCandidateMachines sets the desiredConfig annotation the candidate machines
-func (ctrl *Controller) updateCandidateMachines(pool *mcfgv1.MachineConfigPool, candidates []*corev1.Node, capacity uint) error {
+// getCriticalWindowMaxDefer returns the maximum amount of time a node update may be deferred by
+// critical window pods, as configured on the pool.
+func getCriticalWindowMaxDefer(pool *mcfgv1.MachineConfigPool) (time.Duration, error) {
+	val, ok := pool.Annotations[ctrlcommon.CriticalWindowMaxDeferAnnotationKey]
+	if !ok {
+		return defaultCriticalWindowMaxDefer, nil
+	}
+
+	maxDefer, err := time.ParseDuration(val)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s annotation %q: %w", ctrlcommon.CriticalWindowMaxDeferAnnotationKey, val, err)
+	}
+
+	if maxDefer < 0 {
+		return 0, fmt.Errorf("invalid %s annotation %q: must not be negative", ctrlcommon.CriticalWindowMaxDeferAnnotationKey, val)
+	}
+
+	return maxDefer, nil
+}
+
+// getPoolUpdatingSince returns the time the pool started updating, or now if the pool
+// has not been marked as updating yet.
+func getPoolUpdatingSince(pool *mcfgv1.MachineConfigPool, now time.Time) time.Time {
+	cond := apihelpers.GetMachineConfigPoolCondition(pool.Status, mcfgv1.MachineConfigPoolUpdating)
+	if cond == nil || cond.Status != corev1.ConditionTrue || cond.LastTransitionTime.IsZero() {
+		return now
+	}
+	return cond.LastTransitionTime.Time
+}
+
+// isPodInCriticalWindow determines whether a running pod has declared a critical window.
+func isPodInCriticalWindow(pod *corev1.Pod) bool {
+	if pod.DeletionTimestamp != nil {
+		return false
+	}
+	if pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+		return false
+	}
+	return pod.Annotations[ctrlcommon.CriticalWindowPodAnnotationKey] == "true"
+}
+
+// filterCriticalWindowCandidates removes candidate nodes that are running pods in a critical window.
+// Nodes are only deferred until maxDefer has elapsed since the pool started updating, after which
+// they are eligible again regardless of their pods. It returns the remaining candidates, the deferred
+// nodes mapped to the critical pods they run and how long the deferral may last at most.
+func filterCriticalWindowCandidates(candidates []*corev1.Node, pods []*corev1.Pod, updatingSince, now time.Time, maxDefer time.Duration) ([]*corev1.Node, map[string][]string, time.Duration) {
+	remaining := updatingSince.Add(maxDefer).Sub(now)
+	if remaining <= 0 {
+		return candidates, nil, 0
+	}
+
+	criticalPodsByNode := map[string][]string{}
+	for _, pod := range pods {
+		if pod.Spec.NodeName == "" || !isPodInCriticalWindow(pod) {
+			continue
+		}
+		criticalPodsByNode[pod.Spec.NodeName] = append(criticalPodsByNode[pod.Spec.NodeName], pod.Namespace+"/"+pod.Name)
+	}
+
+	if len(criticalPodsByNode) == 0 {
+		return candidates, nil, 0
+	}
+
+	deferred := map[string][]string{}
+	var newCandidates []*corev1.Node
+	for _, node := range candidates {
+		if podNames, ok := criticalPodsByNode[node.Name]; ok {
+			sort.Strings(podNames)
+			deferred[node.Name] = podNames
+			continue
+		}
+		newCandidates = append(newCandidates, node)
+	}
+
+	if len(deferred) == 0 {
+		return candidates, nil, 0
+	}
+
+	return newCandidates, deferred, remaining
+}
+
+// filterCriticalWindowCandidateNodes defers the update of candidate nodes running critical window pods
+// and requeues the pool so that the nodes are reconsidered once the window closes or the maximum defer
+// time for the pool elapses.
+func (ctrl *Controller) filterCriticalWindowCandidateNodes(pool *mcfgv1.MachineConfigPool, candidates []*corev1.Node, selection *candidateSelection) ([]*corev1.Node, error) {
+	if len(candidates) == 0 {
+		return candidates, nil
+	}
+
+	maxDefer, err := getCriticalWindowMaxDefer(pool)
+	if err != nil {
+		return nil, err
+	}
+
+	pods, err := ctrl.podLister.List(labels.Everything())
+	if err != nil {
+		return nil, fmt.Errorf("could not list pods for critical window check: %w", err)
+	}
+
+	now := time.Now()
+	updatingSince := getPoolUpdatingSince(pool, now)
+	newCandidates, deferred, remaining := filterCriticalWindowCandidates(candidates, pods, updatingSince, now, maxDefer)
+	previouslyDeferred := ctrl.setCriticalWindowDeferredNodes(pool.Name, deferred)
+	if len(deferred) == 0 {
+		return newCandidates, nil
+	}
+
+	for _, node := range candidates {
+		podNames, ok := deferred[node.Name]
+		if !ok {
+			continue
+		}
+		// The pool is rechecked every minute while nodes are deferred, so the event is only
+		// emitted when the node becomes deferred.
+		if !previouslyDeferred.Has(node.Name) {
+			ctrl.eventRecorder.Eventf(pool, corev1.EventTypeNormal, "DeferringCriticalWindowNodeUpdate", "Deferring update of node %s for up to %s: pods in critical window: %v", node.Name, remaining.Round(time.Second), podNames)
+			klog.Infof("Deferring update of node %s for up to %s due to critical window pods: %v", node.Name, remaining.Round(time.Second), podNames)
+		} else {
+			klog.V(4).Infof("Still deferring update of node %s for up to %s due to critical window pods: %v", node.Name, remaining.Round(time.Second), podNames)
+		}
+		selection.skip(node, "deferred for up to %s by pods in critical window: %v", remaining.Round(time.Second), podNames)
+	}
+
+	recheck := criticalWindowRecheckInterval
+	if remaining < recheck {
+		recheck = remaining
+	}
+	ctrl.enqueueAfter(pool, recheck)
+
+	return newCandidates, nil
+}
+
+// setCriticalWindowDeferredNodes records the nodes of the given pool whose update is deferred by
+// critical window pods and returns the ones recorded before.
+func (ctrl *Controller) setCriticalWindowDeferredNodes(poolName string, deferred map[string][]string) sets.String {
+	ctrl.criticalWindowDeferredNodesLock.Lock()
+	defer ctrl.criticalWindowDeferredNodesLock.Unlock()
+
+	previous := ctrl.criticalWindowDeferredNodes[poolName]
+
+	if len(deferred) == 0 {
+		delete(ctrl.criticalWindowDeferredNodes, poolName)
+		return previous
+	}
+
+	if ctrl.criticalWindowDeferredNodes == nil {
+		ctrl.criticalWindowDeferredNodes = map[string]sets.String{}
+	}
+
+	nodes := sets.NewString()
+	for name := range deferred {
+		nodes.Insert(name)
+	}
+	ctrl.criticalWindowDeferredNodes[poolName] = nodes
+
+	return previous
+}
+
+// isSafeModePool determines whether the pool only allows changes which can be applied without
+// draining or rebooting nodes.
+func isSafeModePool(pool *mcfgv1.MachineConfigPool) bool {
+	return pool.Annotations[ctrlcommon.SafeModeAnnotationKey] == "true"
+}
+
+// getSafeModeBlockedChanges returns the changes which safe mode defers for the pool, i.e. those
+// between the config the pool last completed updating to and its target config which require
+// draining or rebooting nodes. For layered pools, a new OS image is always such a change.
+func (ctrl *Controller) getSafeModeBlockedChanges(pool *mcfgv1.MachineConfigPool, nodes []*corev1.Node) ([]string, error) {
+	if !isSafeModePool(pool) {
+		return nil, nil
+	}
+
+	changes := []string{}
+
+	if pool.Status.Configuration.Name != "" && pool.Status.Configuration.Name != pool.Spec.Configuration.Name {
+		oldConfig, err := ctrl.mcLister.Get(pool.Status.Configuration.Name)
+		if err != nil {
+			return nil, fmt.Errorf("could not get MachineConfig %s: %w", pool.Status.Configuration.Name, err)
+		}
+
+		newConfig, err := ctrl.mcLister.Get(pool.Spec.Configuration.Name)
+		if err != nil {
+			return nil, fmt.Errorf("could not get MachineConfig %s: %w", pool.Spec.Configuration.Name, err)
+		}
+
+		// An invalid disruption policy is reported by the DisruptionPolicy condition. Without
+		// it, the changes it would cover count as disruptive.
+		policy, err := getDisruptionPolicy(pool)
+		if err != nil {
+			klog.V(4).Infof("Ignoring disruption policy of pool %s for safe mode: %v", pool.Name, err)
+		}
+
+		configChanges, err := ctrlcommon.GetDisruptiveConfigChanges(oldConfig, newConfig, policy)
+		if err != nil {
+			return nil, fmt.Errorf("could not compare MachineConfigs %s and %s: %w", oldConfig.Name, newConfig.Name, err)
+		}
+
+		changes = append(changes, configChanges...)
+	}
+
+	if lps := ctrlcommon.NewLayeredPoolState(pool); lps.IsLayered() && lps.HasOSImage() {
+		for _, node := range nodes {
+			if node.Annotations[daemonconsts.CurrentImageAnnotationKey] != lps.GetOSImage() {
+				changes = append(changes, "layered OS image")
+				break
+			}
+		}
+	}
+
+	return changes, nil
+}
+
+// getMinFreeDisk returns how many bytes must be free on /sysroot and /var of a node before it is
+// selected for an update, as configured on the pool. Zero means the free disk space is not checked.
+func getMinFreeDisk(pool *mcfgv1.MachineConfigPool) (int64, error) {
+	val, ok := pool.Annotations[ctrlcommon.MinFreeDiskAnnotationKey]
+	if !ok {
+		return 0, nil
+	}
+
+	minFree, err := resource.ParseQuantity(val)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s annotation %q: %w", ctrlcommon.MinFreeDiskAnnotationKey, val, err)
+	}
+
+	if minFree.Sign() < 0 {
+		return 0, fmt.Errorf("invalid %s annotation %q: must not be negative", ctrlcommon.MinFreeDiskAnnotationKey, val)
+	}
+
+	return minFree.Value(), nil
+}
+
+// getLowDiskNodes returns the nodes which reported less free disk space than minFree on any
+// filesystem, mapped to a description of those filesystems. Nodes which have not reported their
+// free disk space are never considered low on disk.
+func getLowDiskNodes(nodes []*corev1.Node, minFree int64) map[string][]string {
+	lowDisk := map[string][]string{}
+	if minFree == 0 {
+		return lowDisk
+	}
+
+	for _, node := range nodes {
+		val, ok := ctrlcommon.GetNodeStatus(node, daemonconsts.FreeDiskAnnotationKey)
+		if !ok {
+			continue
+		}
+
+		freeDisk := map[string]int64{}
+		if err := json.Unmarshal([]byte(val), &freeDisk); err != nil {
+			klog.V(4).Infof("Could not parse free disk space of node %s: %v", node.Name, err)
+			continue
+		}
+
+		paths := []string{}
+		for path := range freeDisk {
+			paths = append(paths, path)
+		}
+		sort.Strings(paths)
+
+		for _, path := range paths {
+			if freeDisk[path] < minFree {
+				lowDisk[node.Name] = append(lowDisk[node.Name], fmt.Sprintf("%s has %dMi free", path, freeDisk[path]/(1024*1024)))
+			}
+		}
+	}
+
+	return lowDisk
+}
+
+// filterLowDiskCandidateNodes defers the update of candidate nodes which do not have the free disk
+// space required by the pool, so that updates do not fail halfway through pulling images. The pool
+// is synced again when the MCD reports a change in free disk space.
+func (ctrl *Controller) filterLowDiskCandidateNodes(pool *mcfgv1.MachineConfigPool, candidates []*corev1.Node, selection *candidateSelection) ([]*corev1.Node, error) {
+	if len(candidates) == 0 {
+		return candidates, nil
+	}
+
+	minFree, err := getMinFreeDisk(pool)
+	if err != nil {
+		return nil, err
+	}
+
+	lowDisk := getLowDiskNodes(candidates, minFree)
+	if len(lowDisk) == 0 {
+		return candidates, nil
+	}
+
+	var newCandidates []*corev1.Node
+	for _, node := range candidates {
+		filesystems, ok := lowDisk[node.Name]
+		if !ok {
+			newCandidates = append(newCandidates, node)
+			continue
+		}
+		ctrl.eventRecorder.Eventf(pool, corev1.EventTypeWarning, "DeferringLowDiskNodeUpdate", "Deferring update of node %s until %s is free: %s", node.Name, pool.Annotations[ctrlcommon.MinFreeDiskAnnotationKey], strings.Join(filesystems, ", "))
+		klog.Infof("Deferring update of node %s due to low free disk space: %s", node.Name, strings.Join(filesystems, ", "))
+		selection.skip(node, "deferred until %s is free: %s", pool.Annotations[ctrlcommon.MinFreeDiskAnnotationKey], strings.Join(filesystems, ", "))
+	}
+
+	return newCandidates, nil
+}
+
+// getMaxConcurrentImagePulls returns how many nodes of the pool may be pulling a new OS image at
+// once, as configured on the pool. Zero means the number of nodes pulling is not limited.
+func getMaxConcurrentImagePulls(pool *mcfgv1.MachineConfigPool) (int, error) {
+	val, ok := pool.Annotations[ctrlcommon.MaxConcurrentImagePullsAnnotationKey]
+	if !ok {
+		return 0, nil
+	}
+
+	maxPulls, err := strconv.Atoi(val)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s annotation %q: %w", ctrlcommon.MaxConcurrentImagePullsAnnotationKey, val, err)
+	}
+
+	if maxPulls < 1 {
+		return 0, fmt.Errorf("invalid %s annotation %q: must be at least 1", ctrlcommon.MaxConcurrentImagePullsAnnotationKey, val)
+	}
+
+	return maxPulls, nil
+}
+
+// getPoolOSImage returns the OS image the pool is updating its nodes to, i.e. the built image for
+// a layered pool or the OS image of the target rendered MachineConfig otherwise.
+func (ctrl *Controller) getPoolOSImage(pool *mcfgv1.MachineConfigPool) (string, error) {
+	if lps := ctrlcommon.NewLayeredPoolState(pool); lps.IsLayered() && lps.HasOSImage() {
+		return lps.GetOSImage(), nil
+	}
+
+	mc, err := ctrl.mcLister.Get(pool.Spec.Configuration.Name)
+	if err != nil {
+		return "", fmt.Errorf("could not get MachineConfig %s: %w", pool.Spec.Configuration.Name, err)
+	}
+
+	return mc.Spec.OSImageURL, nil
+}
+
+// getNodeOSImage returns the OS image the node is currently running. An empty string is returned
+// if it cannot be determined, e.g. because the node has not reported its current config yet.
+func (ctrl *Controller) getNodeOSImage(node *corev1.Node) string {
+	if image := node.Annotations[daemonconsts.CurrentImageAnnotationKey]; image != "" {
+		return image
+	}
+
+	mc, err := ctrl.mcLister.Get(node.Annotations[daemonconsts.CurrentMachineConfigAnnotationKey])
+	if err != nil {
+		return ""
+	}
+
+	return mc.Spec.OSImageURL
+}
+
+// filterImagePullCandidateNodes defers the update of candidate nodes which would have to pull the
+// new OS image of the pool while the configured number of nodes are already pulling it, so that
+// large pools do not saturate the registry or the links to it. Candidates which already run the
+// new OS image are never deferred. The pool is synced again when an updating node is done.
+func (ctrl *Controller) filterImagePullCandidateNodes(pool *mcfgv1.MachineConfigPool, candidates []*corev1.Node, selection *candidateSelection) ([]*corev1.Node, error) {
+	if len(candidates) == 0 {
+		return candidates, nil
+	}
+
+	maxPulls, err := getMaxConcurrentImagePulls(pool)
+	if err != nil {
+		return nil, err
+	}
+
+	if maxPulls == 0 {
+		return candidates, nil
+	}
+
+	osImage, err := ctrl.getPoolOSImage(pool)
+	if err != nil {
+		return nil, err
+	}
+
+	nodes, err := ctrl.getNodesForPool(pool)
+	if err != nil {
+		return nil, err
+	}
+
+	pulling := 0
+	for _, node := range nodes {
+		lns := ctrlcommon.NewLayeredNodeState(node)
+		if lns.IsDesiredEqualToPool(pool) && !lns.IsDoneAt(pool) && ctrl.getNodeOSImage(node) != osImage {
+			pulling++
+		}
+	}
+
+	var newCandidates []*corev1.Node
+	deferred := 0
+	// Candidates are sorted so that the same nodes are picked as when the capacity of the pool is
+	// applied afterwards.
+	for _, node := range sortNodeList(candidates) {
+		if ctrl.getNodeOSImage(node) == osImage {
+			newCandidates = append(newCandidates, node)
+			continue
+		}
+
+		if pulling < maxPulls {
+			newCandidates = append(newCandidates, node)
+			pulling++
+			continue
+		}
+
+		deferred++
+		klog.Infof("Deferring update of node %s: %d nodes are already pulling OS image %s", node.Name, pulling, osImage)
+		selection.skip(node, "deferred until fewer than %d nodes are pulling OS image %s", maxPulls, osImage)
+	}
+
+	if deferred != 0 {
+		ctrl.eventRecorder.Eventf(pool, corev1.EventTypeNormal, "DeferringImagePull", "Deferring update of %d nodes until fewer than %d nodes are pulling OS image %s", deferred, maxPulls, osImage)
+	}
+
+	return newCandidates, nil
+}
+
+// updateCandidateMachines sets the desiredConfig annotation the candidate machines and records why
+// candidates were or were not selected in the given candidate selection.
+func (ctrl *Controller) updateCandidateMachines(pool *mcfgv1.MachineConfigPool, candidates []*corev1.Node, capacity uint, selection *candidateSelection) error {
+	var err error
+	candidates, err = ctrl.filterCriticalWindowCandidateNodes(pool, candidates, selection)
+	if err != nil {
+		return err
+	}
+
+	if len(candidates) == 0 {
+		ctrl.logPool(pool, "all candidate nodes are deferred by critical window pods")
+		return nil
+	}
+
+	candidates, err = ctrl.filterLowDiskCandidateNodes(pool, candidates, selection)
+	if err != nil {
+		return err
+	}
+
+	if len(candidates) == 0 {
+		ctrl.logPool(pool, "all candidate nodes are deferred by low free disk space")
+		return nil
+	}
+
+	candidates, err = ctrl.filterImagePullCandidateNodes(pool, candidates, selection)
+	if err != nil {
+		return err
+	}
+
+	if len(candidates) == 0 {
+		ctrl.logPool(pool, "all candidate nodes are deferred by concurrent OS image pulls")
+		return nil
+	}
+
+	candidates, err = ctrl.filterCanaryCandidateNodes(pool, candidates, selection)
+	if err != nil {
+		return err
+	}
+
+	if len(candidates) == 0 {
+		ctrl.logPool(pool, "all candidate nodes are deferred by the canary rollout")
+		return nil
+	}
+
 	if pool.Name == ctrlcommon.MachineConfigPoolMaster {
-		var err error
-		candidates, capacity, err = ctrl.filterControlPlaneCandidateNodes(pool, candidates, capacity)
+		candidates, capacity, err = ctrl.filterControlPlaneCandidateNodes(pool, candidates, capacity, selection)
 		if err != nil {
 			return err
 		}
@@ -1187,9 +1858,18 @@ func (ctrl *Controller) updateCandidateMachines(pool *mcfgv1.MachineConfigPool,
 		// across multiple zones that run the same types of pods resulting in an outage in HA clusters
 		candidates = sortNodeList(candidates)
 
+		for _, node := range candidates[capacity:] {
+			selection.skip(node, "waiting for one of the %d nodes which may update at once (maxUnavailable), nodes are updated in zone order", capacity)
+		}
+		ctrl.eventRecorder.Eventf(pool, corev1.EventTypeNormal, "WaitingForUpdateCapacity", "%d nodes are waiting for one of the %d nodes which may update at once", len(candidates)-int(capacity), capacity)
+
 		candidates = candidates[:capacity]
 	}
 
+	for _, node := range candidates {
+		selection.selected(node)
+	}
+
 	return ctrl.setDesiredAnnotations(pool, candidates)
 }
 
@@ -9,6 +9,7 @@ import (
 	"time"
 
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
@@ -18,10 +19,13 @@ import (
 	"k8s.io/apimachinery/pkg/util/uuid"
 	kubeinformers "k8s.io/client-go/informers"
 	k8sfake "k8s.io/client-go/kubernetes/fake"
+	corelisterv1 "k8s.io/client-go/listers/core/v1"
 	core "k8s.io/client-go/testing"
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
 
+	ign3types "github.com/coreos/ignition/v2/config/v3_4/types"
 	"github.com/davecgh/go-spew/spew"
 	apicfgv1 "github.com/openshift/api/config/v1"
 	configv1 "github.com/openshift/api/config/v1"
@@ -30,6 +34,7 @@ import (
 	configv1informer "github.com/openshift/client-go/config/informers/externalversions"
 	"github.com/openshift/client-go/machineconfiguration/clientset/versioned/fake"
 	informers "github.com/openshift/client-go/machineconfiguration/informers/externalversions"
+	"github.com/openshift/machine-config-operator/pkg/apihelpers"
 	"github.com/openshift/machine-config-operator/pkg/constants"
 	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
 	daemonconsts "github.com/openshift/machine-config-operator/pkg/daemon/constants"
@@ -44,8 +49,8 @@ const (
 	machineConfigV0 string = "rendered-machine-config-v0"
 	machineConfigV1 string = "rendered-machine-config-v1"
 	machineConfigV2 string = "rendered-machine-config-v2"
-	imageV0         string = "registry.com/org/repo@sha256:12345"
-	imageV1         string = "registry.com/org/repo@sha256:54321"
+	imageV0         string = "registry.com/org/repo@sha256:1111111111111111111111111111111111111111111111111111111111111111"
+	imageV1         string = "registry.com/org/repo@sha256:2222222222222222222222222222222222222222222222222222222222222222"
 )
 
 var (
@@ -91,11 +96,12 @@ func (f *fixture) newControllerWithStopChan(stopCh <-chan struct{}) *Controller
 	k8sI := kubeinformers.NewSharedInformerFactory(f.kubeclient, noResyncPeriodFunc())
 	ci := configv1informer.NewSharedInformerFactory(f.schedulerClient, noResyncPeriodFunc())
 	c := NewWithCustomUpdateDelay(i.Machineconfiguration().V1().ControllerConfigs(), i.Machineconfiguration().V1().MachineConfigs(), i.Machineconfiguration().V1().MachineConfigPools(), k8sI.Core().V1().Nodes(),
-		k8sI.Core().V1().Pods(), ci.Config().V1().Schedulers(), f.kubeclient, f.client, time.Millisecond)
+		k8sI.Core().V1().Pods(), k8sI.Core().V1().ConfigMaps(), ci.Config().V1().Schedulers(), f.kubeclient, f.client, time.Millisecond)
 
 	c.ccListerSynced = alwaysReady
 	c.mcpListerSynced = alwaysReady
 	c.nodeListerSynced = alwaysReady
+	c.cmListerSynced = alwaysReady
 	c.schedulerListerSynced = alwaysReady
 	c.eventRecorder = &record.FakeRecorder{}
 
@@ -110,6 +116,9 @@ func (f *fixture) newControllerWithStopChan(stopCh <-chan struct{}) *Controller
 	for _, c := range f.mcpLister {
 		i.Machineconfiguration().V1().MachineConfigPools().Informer().GetIndexer().Add(c)
 	}
+	for _, c := range f.mcLister {
+		i.Machineconfiguration().V1().MachineConfigs().Informer().GetIndexer().Add(c)
+	}
 
 	for _, m := range f.nodeLister {
 		k8sI.Core().V1().Nodes().Informer().GetIndexer().Add(m)
@@ -241,7 +250,13 @@ func filterInformerActions(actions []core.Action) []core.Action {
 				action.Matches("list", "nodes") ||
 				action.Matches("watch", "nodes") ||
 				action.Matches("list", "pods") ||
-				action.Matches("watch", "pods")) {
+				action.Matches("watch", "pods") ||
+				action.Matches("list", "configmaps") ||
+				action.Matches("watch", "configmaps")) {
+			continue
+		}
+		// Pipeline ConfigMaps are written on every sync; see TestSyncPipeline.
+		if isPipelineAction(action) {
 			continue
 		}
 		ret = append(ret, action)
@@ -250,6 +265,21 @@ func filterInformerActions(actions []core.Action) []core.Action {
 	return ret
 }
 
+// isPipelineAction determines whether the action creates or updates a pipeline ConfigMap.
+func isPipelineAction(action core.Action) bool {
+	if !action.Matches("create", "configmaps") && !action.Matches("update", "configmaps") {
+		return false
+	}
+
+	accessor, err := meta.Accessor(action.(core.CreateAction).GetObject())
+	if err != nil {
+		return false
+	}
+
+	_, ok := accessor.GetLabels()[ctrlcommon.PipelineLabelKey]
+	return ok
+}
+
 func (f *fixture) expectUpdateMachineConfigPoolStatus(pool *mcfgv1.MachineConfigPool) {
 	f.actions = append(f.actions, core.NewRootUpdateSubresourceAction(schema.GroupVersionResource{Resource: "machineconfigpools"}, "status", pool))
 }
@@ -951,6 +981,149 @@ func TestUpdateCandidates(t *testing.T) {
 	}
 }
 
+// Tests that built images are only rolled out when they are referenced by digest.
+func TestCanLayeredPoolContinueRequiresDigest(t *testing.T) {
+	t.Parallel()
+
+	newPool := func(image string) *mcfgv1.MachineConfigPool {
+		return helpers.NewMachineConfigPoolBuilder("worker").WithMachineConfig(machineConfigV1).WithCondition(mcfgv1.MachineConfigPoolBuildSuccess, corev1.ConditionTrue, "", "").WithImage(image).MachineConfigPool()
+	}
+
+	ctrl := &Controller{}
+
+	_, canContinue, err := ctrl.canLayeredPoolContinue(newPool(imageV1))
+	assert.NoError(t, err)
+	assert.True(t, canContinue)
+
+	_, canContinue, err = ctrl.canLayeredPoolContinue(newPool("registry.com/org/repo:latest"))
+	assert.Error(t, err)
+	assert.False(t, canContinue)
+}
+
+// Tests that a pool rolled back to a retained image rolls it out without
+// waiting for its build, and that other images are refused, including those
+// built from a different rendered MachineConfig than the pool's current one.
+func TestCanLayeredPoolContinueRollback(t *testing.T) {
+	t.Parallel()
+
+	olderImage := "registry.com/org/repo@sha256:3333333333333333333333333333333333333333333333333333333333333333"
+
+	newPool := func(rollback string) *mcfgv1.MachineConfigPool {
+		return helpers.NewMachineConfigPoolBuilder("worker").WithMachineConfig(machineConfigV1).WithCondition(mcfgv1.MachineConfigPoolBuilding, corev1.ConditionTrue, "", "").WithImage(imageV1).WithAnnotations(map[string]string{
+			ctrlcommon.RetainedImagePullspecsAnnotationKey:      imageV1 + "," + imageV0 + "," + olderImage,
+			ctrlcommon.RetainedImageMachineConfigsAnnotationKey: imageV1 + "=" + machineConfigV1 + "," + imageV0 + "=" + machineConfigV1 + "," + olderImage + "=" + machineConfigV0,
+			ctrlcommon.RollbackImageAnnotationKey:               rollback,
+		}).MachineConfigPool()
+	}
+
+	ctrl := &Controller{}
+
+	_, canContinue, err := ctrl.canLayeredPoolContinue(newPool(imageV0))
+	assert.NoError(t, err)
+	assert.True(t, canContinue)
+
+	_, canContinue, err = ctrl.canLayeredPoolContinue(newPool(olderImage))
+	assert.Error(t, err)
+	assert.False(t, canContinue)
+
+	_, canContinue, err = ctrl.canLayeredPoolContinue(newPool("registry.com/org/repo@sha256:9999999999999999999999999999999999999999999999999999999999999999"))
+	assert.Error(t, err)
+	assert.False(t, canContinue)
+}
+
+// Tests that the public key of a signed image is taken from its build status,
+// and that an image whose signature cannot be found is not rolled out while
+// image signing is enabled.
+func TestGetImageSigningPublicKey(t *testing.T) {
+	t.Parallel()
+
+	pool := helpers.NewMachineConfigPoolBuilder("worker").WithMachineConfig(machineConfigV1).WithImage(imageV1).WithLabels(map[string]string{
+		ctrlcommon.LayeringEnabledPoolLabel: "",
+	}).MachineConfigPool()
+
+	onClusterBuildConfig := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: ctrlcommon.OnClusterBuildConfigMapName, Namespace: ctrlcommon.MCONamespace},
+		Data:       map[string]string{ctrlcommon.ImageSigningSecretNameConfigKey: "image-signing-key"},
+	}
+
+	newBuildStatus := func(status ctrlcommon.ImageBuildStatus) *corev1.ConfigMap {
+		out, err := json.Marshal(status)
+		require.NoError(t, err)
+
+		return &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: ctrlcommon.GetBuildStatusConfigMapName(pool), Namespace: ctrlcommon.MCONamespace},
+			Data:       map[string]string{ctrlcommon.BuildStatusConfigMapKey: string(out)},
+		}
+	}
+
+	signed := newBuildStatus(ctrlcommon.ImageBuildStatus{Image: imageV1, Signature: &ctrlcommon.ImageSignatureStatus{SecretName: "image-signing-key", PublicKey: "public-key"}})
+	unsigned := newBuildStatus(ctrlcommon.ImageBuildStatus{Image: imageV1})
+	otherImage := newBuildStatus(ctrlcommon.ImageBuildStatus{Image: imageV0, Signature: &ctrlcommon.ImageSignatureStatus{SecretName: "image-signing-key", PublicKey: "public-key"}})
+
+	testCases := []struct {
+		name        string
+		configMaps  []*corev1.ConfigMap
+		expected    string
+		errExpected bool
+	}{
+		{
+			name:       "signed image",
+			configMaps: []*corev1.ConfigMap{onClusterBuildConfig, signed},
+			expected:   "public-key",
+		},
+		{
+			name:       "signed image after signing was disabled",
+			configMaps: []*corev1.ConfigMap{signed},
+			expected:   "public-key",
+		},
+		{
+			name:       "signing is disabled",
+			configMaps: []*corev1.ConfigMap{unsigned},
+		},
+		{
+			name: "signing is disabled and the build status is missing",
+		},
+		{
+			name:        "build status is missing",
+			configMaps:  []*corev1.ConfigMap{onClusterBuildConfig},
+			errExpected: true,
+		},
+		{
+			name:        "image is not signed",
+			configMaps:  []*corev1.ConfigMap{onClusterBuildConfig, unsigned},
+			errExpected: true,
+		},
+		{
+			name:        "build status is for another image",
+			configMaps:  []*corev1.ConfigMap{onClusterBuildConfig, otherImage},
+			errExpected: true,
+		},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+			for _, cm := range testCase.configMaps {
+				require.NoError(t, indexer.Add(cm))
+			}
+
+			ctrl := &Controller{cmLister: corelisterv1.NewConfigMapLister(indexer)}
+
+			publicKey, err := ctrl.getImageSigningPublicKey(pool)
+			if testCase.errExpected {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+
+			assert.Equal(t, testCase.expected, publicKey)
+		})
+	}
+}
+
 func TestShouldMakeProgress(t *testing.T) {
 	t.Parallel()
 	// nodeWithDesiredConfigTaints is at desired config, so need to do a get on the nodeWithDesiredConfigTaints to check for the taint status
@@ -973,6 +1146,7 @@ func TestShouldMakeProgress(t *testing.T) {
 		expectTaintsRemovePatch bool
 		expectTaintsGet         bool
 		expectedNodeGet         int
+		expectedCandidateReason string
 	}{
 		{
 			description:           "node at desired config no patch on annotation or taints",
@@ -981,10 +1155,11 @@ func TestShouldMakeProgress(t *testing.T) {
 			expectTaintsAddPatch:  false,
 		},
 		{
-			description:           "node not at desired config, patch on annotation and taints",
-			node:                  newNodeWithLabel("nodeNeedingUpdates", machineConfigV0, machineConfigV0, map[string]string{"node-role/worker": "", "node-role/infra": ""}),
-			expectAnnotationPatch: true,
-			expectTaintsAddPatch:  true,
+			description:             "node not at desired config, patch on annotation and taints",
+			node:                    newNodeWithLabel("nodeNeedingUpdates", machineConfigV0, machineConfigV0, map[string]string{"node-role/worker": "", "node-role/infra": ""}),
+			expectAnnotationPatch:   true,
+			expectTaintsAddPatch:    true,
+			expectedCandidateReason: "selected for update",
 		},
 		{
 			description:             "node at desired config, no patch on annotation but taint should be removed",
@@ -995,36 +1170,48 @@ func TestShouldMakeProgress(t *testing.T) {
 			expectedNodeGet:         1,
 		},
 		{
-			description:           "node not at desired config, patch on annotation but not on taint",
-			node:                  nodeWithNoDesiredConfigButTaints,
-			expectAnnotationPatch: true,
-			expectTaintsAddPatch:  false,
+			description:             "node not at desired config, patch on annotation but not on taint",
+			node:                    nodeWithNoDesiredConfigButTaints,
+			expectAnnotationPatch:   true,
+			expectTaintsAddPatch:    false,
+			expectedCandidateReason: "selected for update",
+		},
+		{
+			description:             "node not at desired image, will not proceed because image is still building",
+			node:                    helpers.NewNodeBuilder("layered-node").WithEqualConfigsAndImages(machineConfigV1, imageV0).WithLabels(map[string]string{"node-role/worker": "", "node-role/infra": ""}).Node(),
+			workerPool:              helpers.NewMachineConfigPoolBuilder("worker").WithNodeSelector(helpers.WorkerSelector).WithMachineConfig(machineConfigV1).WithCondition(mcfgv1.MachineConfigPoolBuilding, corev1.ConditionTrue, "", "").MachineConfigPool(),
+			infraPool:               helpers.NewMachineConfigPoolBuilder("test-cluster-infra").WithNodeSelector(helpers.InfraSelector).WithMachineConfig(machineConfigV1).WithMaxUnavailable(1).WithCondition(mcfgv1.MachineConfigPoolBuilding, corev1.ConditionTrue, "", "").MachineConfigPool(),
+			expectedCandidateReason: "waiting for the pool's OS image: Image annotation machineconfiguration.openshift.io/newestImageEquivalentConfig is not set",
 		},
 		{
-			description: "node not at desired image, will not proceed because image is still building",
-			node:        helpers.NewNodeBuilder("layered-node").WithEqualConfigsAndImages(machineConfigV1, imageV0).WithLabels(map[string]string{"node-role/worker": "", "node-role/infra": ""}).Node(),
-			workerPool:  helpers.NewMachineConfigPoolBuilder("worker").WithNodeSelector(helpers.WorkerSelector).WithMachineConfig(machineConfigV1).WithCondition(mcfgv1.MachineConfigPoolBuilding, corev1.ConditionTrue, "", "").MachineConfigPool(),
-			infraPool:   helpers.NewMachineConfigPoolBuilder("test-cluster-infra").WithNodeSelector(helpers.InfraSelector).WithMachineConfig(machineConfigV1).WithMaxUnavailable(1).WithCondition(mcfgv1.MachineConfigPoolBuilding, corev1.ConditionTrue, "", "").MachineConfigPool(),
+			description:             "node not at desired image, will not proceed because image is built but yet not populated",
+			node:                    helpers.NewNodeBuilder("layered-node").WithEqualConfigsAndImages(machineConfigV1, imageV0).WithLabels(map[string]string{"node-role/worker": "", "node-role/infra": ""}).Node(),
+			workerPool:              helpers.NewMachineConfigPoolBuilder("worker").WithNodeSelector(helpers.WorkerSelector).WithMachineConfig(machineConfigV1).WithCondition(mcfgv1.MachineConfigPoolBuildSuccess, corev1.ConditionTrue, "", "").MachineConfigPool(),
+			infraPool:               helpers.NewMachineConfigPoolBuilder("test-cluster-infra").WithNodeSelector(helpers.InfraSelector).WithMachineConfig(machineConfigV1).WithMaxUnavailable(1).WithCondition(mcfgv1.MachineConfigPoolBuildSuccess, corev1.ConditionTrue, "", "").MachineConfigPool(),
+			expectedCandidateReason: "waiting for the pool's OS image: Image annotation machineconfiguration.openshift.io/newestImageEquivalentConfig is not set",
 		},
 		{
-			description: "node not at desired image, will not proceed because image is built but yet not populated",
-			node:        helpers.NewNodeBuilder("layered-node").WithEqualConfigsAndImages(machineConfigV1, imageV0).WithLabels(map[string]string{"node-role/worker": "", "node-role/infra": ""}).Node(),
-			workerPool:  helpers.NewMachineConfigPoolBuilder("worker").WithNodeSelector(helpers.WorkerSelector).WithMachineConfig(machineConfigV1).WithCondition(mcfgv1.MachineConfigPoolBuildSuccess, corev1.ConditionTrue, "", "").MachineConfigPool(),
-			infraPool:   helpers.NewMachineConfigPoolBuilder("test-cluster-infra").WithNodeSelector(helpers.InfraSelector).WithMachineConfig(machineConfigV1).WithMaxUnavailable(1).WithCondition(mcfgv1.MachineConfigPoolBuildSuccess, corev1.ConditionTrue, "", "").MachineConfigPool(),
+			description:             "node not at desired image, should proceed because image is built and populated",
+			node:                    helpers.NewNodeBuilder("layered-node").WithEqualConfigsAndImages(machineConfigV1, imageV0).WithLabels(map[string]string{"node-role/worker": "", "node-role/infra": ""}).Node(),
+			workerPool:              helpers.NewMachineConfigPoolBuilder("worker").WithNodeSelector(helpers.WorkerSelector).WithMachineConfig(machineConfigV1).WithCondition(mcfgv1.MachineConfigPoolBuildSuccess, corev1.ConditionTrue, "", "").WithImage(imageV1).MachineConfigPool(),
+			infraPool:               helpers.NewMachineConfigPoolBuilder("test-cluster-infra").WithNodeSelector(helpers.InfraSelector).WithMachineConfig(machineConfigV1).WithMaxUnavailable(1).WithCondition(mcfgv1.MachineConfigPoolBuildSuccess, corev1.ConditionTrue, "", "").WithImage(imageV1).MachineConfigPool(),
+			expectAnnotationPatch:   true,
+			expectTaintsAddPatch:    true,
+			expectedCandidateReason: "selected for update",
 		},
 		{
-			description:           "node not at desired image, should proceed because image is built and populated",
-			node:                  helpers.NewNodeBuilder("layered-node").WithEqualConfigsAndImages(machineConfigV1, imageV0).WithLabels(map[string]string{"node-role/worker": "", "node-role/infra": ""}).Node(),
-			workerPool:            helpers.NewMachineConfigPoolBuilder("worker").WithNodeSelector(helpers.WorkerSelector).WithMachineConfig(machineConfigV1).WithCondition(mcfgv1.MachineConfigPoolBuildSuccess, corev1.ConditionTrue, "", "").WithImage(imageV1).MachineConfigPool(),
-			infraPool:             helpers.NewMachineConfigPoolBuilder("test-cluster-infra").WithNodeSelector(helpers.InfraSelector).WithMachineConfig(machineConfigV1).WithMaxUnavailable(1).WithCondition(mcfgv1.MachineConfigPoolBuildSuccess, corev1.ConditionTrue, "", "").WithImage(imageV1).MachineConfigPool(),
-			expectAnnotationPatch: true,
-			expectTaintsAddPatch:  true,
+			description:             "node not at desired image, will not proceed because pool is in validate-only mode",
+			node:                    helpers.NewNodeBuilder("layered-node").WithEqualConfigsAndImages(machineConfigV1, imageV0).WithLabels(map[string]string{"node-role/worker": "", "node-role/infra": ""}).Node(),
+			workerPool:              helpers.NewMachineConfigPoolBuilder("worker").WithNodeSelector(helpers.WorkerSelector).WithMachineConfig(machineConfigV1).WithCondition(mcfgv1.MachineConfigPoolBuildSuccess, corev1.ConditionTrue, "", "").WithImage(imageV1).MachineConfigPool(),
+			infraPool:               helpers.NewMachineConfigPoolBuilder("test-cluster-infra").WithNodeSelector(helpers.InfraSelector).WithMachineConfig(machineConfigV1).WithMaxUnavailable(1).WithCondition(mcfgv1.MachineConfigPoolBuildSuccess, corev1.ConditionTrue, "", "").WithImage(imageV1).WithAnnotations(map[string]string{ctrlcommon.BuildValidateOnlyAnnotationKey: "true"}).MachineConfigPool(),
+			expectedCandidateReason: "waiting for the pool's OS image: Image built successfully in validate-only mode, not rolling out pullspec: " + imageV1,
 		},
 		{
-			description:           "layered node should go back to unlayered if pool loses layering",
-			node:                  helpers.NewNodeBuilder("layered-node").WithEqualConfigsAndImages(machineConfigV1, imageV1).WithLabels(map[string]string{"node-role/worker": "", "node-role/infra": ""}).Node(),
-			expectAnnotationPatch: true,
-			expectTaintsAddPatch:  true,
+			description:             "layered node should go back to unlayered if pool loses layering",
+			node:                    helpers.NewNodeBuilder("layered-node").WithEqualConfigsAndImages(machineConfigV1, imageV1).WithLabels(map[string]string{"node-role/worker": "", "node-role/infra": ""}).Node(),
+			expectAnnotationPatch:   true,
+			expectTaintsAddPatch:    true,
+			expectedCandidateReason: "selected for update",
 		},
 	}
 
@@ -1141,6 +1328,13 @@ func TestShouldMakeProgress(t *testing.T) {
 				t.Logf("not expecting annotation")
 			}
 			expStatus := calculateStatus(cc, mcp, nodes)
+			// A pool which lost layering reports the progress of the revert.
+			(&Controller{eventRecorder: record.NewFakeRecorder(1)}).setRevertingFromLayeringCondition(mcp, nodes, &expStatus)
+			// Nodes which are not targeted at the pool's config yet report why.
+			if test.expectedCandidateReason != "" {
+				msg := getUpdateCandidatesMessage(map[string]string{test.node.Name: test.expectedCandidateReason})
+				apihelpers.SetMachineConfigPoolCondition(&expStatus, *apihelpers.NewMachineConfigPoolCondition(MachineConfigPoolUpdateCandidates, corev1.ConditionTrue, nodesWaitingForUpdateReason, msg))
+			}
 			expMcp := mcp.DeepCopy()
 			expMcp.Status = expStatus
 			f.expectUpdateMachineConfigPoolStatus(expMcp)
@@ -1193,6 +1387,8 @@ func TestPaused(t *testing.T) {
 	}
 
 	expStatus := calculateStatus(cc, mcp, nodes)
+	// The node which is not updated yet reports that the pool is paused.
+	apihelpers.SetMachineConfigPoolCondition(&expStatus, *apihelpers.NewMachineConfigPoolCondition(MachineConfigPoolUpdateCandidates, corev1.ConditionTrue, nodesWaitingForUpdateReason, "1 nodes are waiting for an update, 0 of which were selected: node-1: pool is paused"))
 	expMcp := mcp.DeepCopy()
 	expMcp.Status = expStatus
 	f.expectUpdateMachineConfigPoolStatus(expMcp)
@@ -1375,6 +1571,315 @@ func TestSortNodeList(t *testing.T) {
 	}
 }
 
+func TestGetCriticalWindowMaxDefer(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		expected    time.Duration
+		err         bool
+	}{
+		{
+			name:     "default",
+			expected: defaultCriticalWindowMaxDefer,
+		},
+		{
+			name:        "configured",
+			annotations: map[string]string{ctrlcommon.CriticalWindowMaxDeferAnnotationKey: "30m"},
+			expected:    30 * time.Minute,
+		},
+		{
+			name:        "disabled",
+			annotations: map[string]string{ctrlcommon.CriticalWindowMaxDeferAnnotationKey: "0s"},
+			expected:    0,
+		},
+		{
+			name:        "invalid",
+			annotations: map[string]string{ctrlcommon.CriticalWindowMaxDeferAnnotationKey: "thirty minutes"},
+			err:         true,
+		},
+		{
+			name:        "negative",
+			annotations: map[string]string{ctrlcommon.CriticalWindowMaxDeferAnnotationKey: "-5m"},
+			err:         true,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			pool := helpers.NewMachineConfigPool("worker", nil, helpers.WorkerSelector, machineConfigV1)
+			pool.Annotations = test.annotations
+
+			got, err := getCriticalWindowMaxDefer(pool)
+			if test.err {
+				assert.Error(t, err)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, test.expected, got)
+		})
+	}
+}
+
+func TestGetSafeModeBlockedChanges(t *testing.T) {
+	t.Parallel()
+
+	oldConfig := helpers.NewMachineConfig(machineConfigV0, nil, "", []ign3types.File{ctrlcommon.NewIgnFile("/etc/containers/policy.json", "{}")})
+	liveConfig := helpers.NewMachineConfig(machineConfigV1, nil, "", []ign3types.File{ctrlcommon.NewIgnFile("/etc/containers/policy.json", `{"default": []}`)})
+	rebootConfig := helpers.NewMachineConfig("rendered-machine-config-v2", nil, "", []ign3types.File{ctrlcommon.NewIgnFile("/etc/sysctl.d/99-tuning.conf", "vm.swappiness = 10")})
+
+	safeMode := map[string]string{ctrlcommon.SafeModeAnnotationKey: "true"}
+
+	tests := []struct {
+		name     string
+		pool     *mcfgv1.MachineConfigPool
+		node     *corev1.Node
+		expected []string
+	}{
+		{
+			name:     "not in safe mode",
+			pool:     helpers.NewMachineConfigPoolBuilder("worker").WithMachineConfig(rebootConfig.Name).MachineConfigPool(),
+			node:     helpers.NewNodeBuilder("node-0").WithEqualConfigs(machineConfigV0).Node(),
+			expected: nil,
+		},
+		{
+			name:     "live change",
+			pool:     helpers.NewMachineConfigPoolBuilder("worker").WithMachineConfig(liveConfig.Name).WithAnnotations(safeMode).MachineConfigPool(),
+			node:     helpers.NewNodeBuilder("node-0").WithEqualConfigs(machineConfigV0).Node(),
+			expected: []string{},
+		},
+		{
+			name:     "disruptive change",
+			pool:     helpers.NewMachineConfigPoolBuilder("worker").WithMachineConfig(rebootConfig.Name).WithAnnotations(safeMode).MachineConfigPool(),
+			node:     helpers.NewNodeBuilder("node-0").WithEqualConfigs(machineConfigV0).Node(),
+			expected: []string{"file /etc/sysctl.d/99-tuning.conf"},
+		},
+		{
+			name:     "new layered image",
+			pool:     helpers.NewMachineConfigPoolBuilder("worker").WithMachineConfig(machineConfigV0).WithImage(imageV1).WithAnnotations(safeMode).MachineConfigPool(),
+			node:     helpers.NewNodeBuilder("node-0").WithEqualConfigsAndImages(machineConfigV0, imageV0).Node(),
+			expected: []string{"layered OS image"},
+		},
+		{
+			name:     "layered image already rolled out",
+			pool:     helpers.NewMachineConfigPoolBuilder("worker").WithMachineConfig(machineConfigV0).WithImage(imageV1).WithAnnotations(safeMode).MachineConfigPool(),
+			node:     helpers.NewNodeBuilder("node-0").WithEqualConfigsAndImages(machineConfigV0, imageV1).Node(),
+			expected: []string{},
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			// The pool last completed updating to the old config.
+			test.pool.Status.Configuration.Name = machineConfigV0
+
+			f := newFixture(t)
+			f.mcLister = append(f.mcLister, oldConfig, liveConfig, rebootConfig)
+			c := f.newController()
+
+			changes, err := c.getSafeModeBlockedChanges(test.pool, []*corev1.Node{test.node})
+			assert.NoError(t, err)
+			assert.Equal(t, test.expected, changes)
+		})
+	}
+}
+
+func TestFilterCriticalWindowCandidates(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+
+	newPod := func(name, nodeName string, critical bool, phase corev1.PodPhase) *corev1.Pod {
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "app"},
+			Spec:       corev1.PodSpec{NodeName: nodeName},
+			Status:     corev1.PodStatus{Phase: phase},
+		}
+		if critical {
+			pod.Annotations = map[string]string{ctrlcommon.CriticalWindowPodAnnotationKey: "true"}
+		}
+		return pod
+	}
+
+	candidates := func() []*corev1.Node {
+		return []*corev1.Node{
+			newNode("node-0", machineConfigV0, machineConfigV0),
+			newNode("node-1", machineConfigV0, machineConfigV0),
+			newNode("node-2", machineConfigV0, machineConfigV0),
+		}
+	}
+
+	tests := []struct {
+		name              string
+		pods              []*corev1.Pod
+		updatingSince     time.Time
+		maxDefer          time.Duration
+		expected          []string
+		expectedDeferred  map[string][]string
+		expectedRemaining time.Duration
+	}{
+		{
+			name:          "no pods",
+			updatingSince: now,
+			maxDefer:      time.Hour,
+			expected:      []string{"node-0", "node-1", "node-2"},
+		},
+		{
+			name: "no critical pods",
+			pods: []*corev1.Pod{
+				newPod("db-0", "node-0", false, corev1.PodRunning),
+			},
+			updatingSince: now,
+			maxDefer:      time.Hour,
+			expected:      []string{"node-0", "node-1", "node-2"},
+		},
+		{
+			name: "critical pod defers its node",
+			pods: []*corev1.Pod{
+				newPod("db-1", "node-1", true, corev1.PodRunning),
+				newPod("db-0", "node-1", true, corev1.PodRunning),
+				newPod("web-0", "node-2", false, corev1.PodRunning),
+			},
+			updatingSince:     now.Add(-20 * time.Minute),
+			maxDefer:          time.Hour,
+			expected:          []string{"node-0", "node-2"},
+			expectedDeferred:  map[string][]string{"node-1": {"app/db-0", "app/db-1"}},
+			expectedRemaining: 40 * time.Minute,
+		},
+		{
+			name: "completed critical pod does not defer",
+			pods: []*corev1.Pod{
+				newPod("backup-0", "node-1", true, corev1.PodSucceeded),
+			},
+			updatingSince: now,
+			maxDefer:      time.Hour,
+			expected:      []string{"node-0", "node-1", "node-2"},
+		},
+		{
+			name: "critical pod on non-candidate node",
+			pods: []*corev1.Pod{
+				newPod("db-0", "node-9", true, corev1.PodRunning),
+			},
+			updatingSince: now,
+			maxDefer:      time.Hour,
+			expected:      []string{"node-0", "node-1", "node-2"},
+		},
+		{
+			name: "max defer time elapsed",
+			pods: []*corev1.Pod{
+				newPod("db-0", "node-1", true, corev1.PodRunning),
+			},
+			updatingSince: now.Add(-2 * time.Hour),
+			maxDefer:      time.Hour,
+			expected:      []string{"node-0", "node-1", "node-2"},
+		},
+		{
+			name: "deferral disabled",
+			pods: []*corev1.Pod{
+				newPod("db-0", "node-1", true, corev1.PodRunning),
+			},
+			updatingSince: now,
+			maxDefer:      0,
+			expected:      []string{"node-0", "node-1", "node-2"},
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, deferred, remaining := filterCriticalWindowCandidates(candidates(), test.pods, test.updatingSince, now, test.maxDefer)
+
+			var gotNames []string
+			for _, node := range got {
+				gotNames = append(gotNames, node.Name)
+			}
+
+			assert.Equal(t, test.expected, gotNames)
+			assert.Equal(t, test.expectedDeferred, deferred)
+			assert.Equal(t, test.expectedRemaining, remaining)
+		})
+	}
+}
+
+// Tests that a node deferred by critical window pods is only reported in an event when it becomes
+// deferred, not on every recheck of the pool.
+func TestFilterCriticalWindowCandidateNodesEvents(t *testing.T) {
+	t.Parallel()
+
+	pool := helpers.NewMachineConfigPool("worker", nil, helpers.WorkerSelector, machineConfigV1)
+	nodes := []*corev1.Node{
+		newNode("node-0", machineConfigV0, machineConfigV0),
+		newNode("node-1", machineConfigV0, machineConfigV0),
+	}
+
+	criticalPod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "batch",
+			Namespace:   "app",
+			Annotations: map[string]string{ctrlcommon.CriticalWindowPodAnnotationKey: "true"},
+		},
+		Spec:   corev1.PodSpec{NodeName: "node-0"},
+		Status: corev1.PodStatus{Phase: corev1.PodRunning},
+	}
+
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	require.NoError(t, indexer.Add(criticalPod))
+
+	recorder := record.NewFakeRecorder(10)
+	ctrl := &Controller{
+		eventRecorder: recorder,
+		podLister:     corelisterv1.NewPodLister(indexer),
+		queue:         workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "test"),
+	}
+	defer ctrl.queue.ShutDown()
+
+	filter := func() []string {
+		t.Helper()
+
+		candidates, err := ctrl.filterCriticalWindowCandidateNodes(pool, nodes, &candidateSelection{nodes: map[string]string{}})
+		require.NoError(t, err)
+
+		names := []string{}
+		for _, node := range candidates {
+			names = append(names, node.Name)
+		}
+		return names
+	}
+
+	events := func() int {
+		count := 0
+		for {
+			select {
+			case <-recorder.Events:
+				count++
+			default:
+				return count
+			}
+		}
+	}
+
+	// The node is reported once, however often the pool is rechecked.
+	assert.Equal(t, []string{"node-1"}, filter())
+	assert.Equal(t, []string{"node-1"}, filter())
+	assert.Equal(t, []string{"node-1"}, filter())
+	assert.Equal(t, 1, events())
+
+	// Once the window closes, a new one is reported again.
+	require.NoError(t, indexer.Delete(criticalPod))
+	assert.Equal(t, []string{"node-0", "node-1"}, filter())
+	require.NoError(t, indexer.Add(criticalPod))
+	assert.Equal(t, []string{"node-1"}, filter())
+	assert.Equal(t, 1, events())
+}
+
 func TestControlPlaneTopology(t *testing.T) {
 	t.Parallel()
 	f := newFixture(t)
@@ -1437,3 +1942,913 @@ func filterLastTransitionTime(obj runtime.Object) runtime.Object {
 	}
 	return o
 }
+
+func TestGetMinFreeDisk(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		expected    int64
+		err         bool
+	}{
+		{
+			name:     "not set",
+			expected: 0,
+		},
+		{
+			name:        "configured",
+			annotations: map[string]string{ctrlcommon.MinFreeDiskAnnotationKey: "10Gi"},
+			expected:    10 * 1024 * 1024 * 1024,
+		},
+		{
+			name:        "invalid",
+			annotations: map[string]string{ctrlcommon.MinFreeDiskAnnotationKey: "ten gigs"},
+			err:         true,
+		},
+		{
+			name:        "negative",
+			annotations: map[string]string{ctrlcommon.MinFreeDiskAnnotationKey: "-1Gi"},
+			err:         true,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			pool := helpers.NewMachineConfigPool("worker", nil, helpers.WorkerSelector, machineConfigV1)
+			pool.Annotations = test.annotations
+
+			got, err := getMinFreeDisk(pool)
+			if test.err {
+				assert.Error(t, err)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, test.expected, got)
+		})
+	}
+}
+
+func TestGetMaxConcurrentImagePulls(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		expected    int
+		err         bool
+	}{
+		{
+			name:     "not set",
+			expected: 0,
+		},
+		{
+			name:        "configured",
+			annotations: map[string]string{ctrlcommon.MaxConcurrentImagePullsAnnotationKey: "10"},
+			expected:    10,
+		},
+		{
+			name:        "invalid",
+			annotations: map[string]string{ctrlcommon.MaxConcurrentImagePullsAnnotationKey: "10%"},
+			err:         true,
+		},
+		{
+			name:        "zero",
+			annotations: map[string]string{ctrlcommon.MaxConcurrentImagePullsAnnotationKey: "0"},
+			err:         true,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			pool := helpers.NewMachineConfigPool("worker", nil, helpers.WorkerSelector, machineConfigV1)
+			pool.Annotations = test.annotations
+
+			got, err := getMaxConcurrentImagePulls(pool)
+			if test.err {
+				assert.Error(t, err)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, test.expected, got)
+		})
+	}
+}
+
+func TestGetLowDiskNodes(t *testing.T) {
+	t.Parallel()
+
+	const gib = 1024 * 1024 * 1024
+
+	newDiskNode := func(name, freeDisk string) *corev1.Node {
+		node := newNode(name, machineConfigV0, machineConfigV0)
+		if freeDisk != "" {
+			node.Annotations[daemonconsts.FreeDiskAnnotationKey] = freeDisk
+		}
+		return node
+	}
+
+	nodes := []*corev1.Node{
+		newDiskNode("node-0", fmt.Sprintf(`{"/sysroot":%d,"/var":%d}`, 20*gib, 20*gib)),
+		newDiskNode("node-1", fmt.Sprintf(`{"/sysroot":%d,"/var":%d}`, 5*gib, 20*gib)),
+		newDiskNode("node-2", fmt.Sprintf(`{"/sysroot":%d,"/var":%d}`, 1*gib, 2*gib)),
+		// not reporting yet
+		newDiskNode("node-3", ""),
+		// unparseable
+		newDiskNode("node-4", "10Gi"),
+	}
+
+	assert.Equal(t, map[string][]string{
+		"node-1": {"/sysroot has 5120Mi free"},
+		"node-2": {"/sysroot has 1024Mi free", "/var has 2048Mi free"},
+	}, getLowDiskNodes(nodes, 10*gib))
+
+	assert.Empty(t, getLowDiskNodes(nodes, 0))
+}
+
+func TestUpdateCandidateMachinesDefersLowDiskNodes(t *testing.T) {
+	t.Parallel()
+
+	const gib = 1024 * 1024 * 1024
+
+	f := newFixture(t)
+	mcp := helpers.NewMachineConfigPool(ctrlcommon.MachineConfigPoolWorker, nil, helpers.WorkerSelector, machineConfigV1)
+	mcp.Annotations = map[string]string{ctrlcommon.MinFreeDiskAnnotationKey: "10Gi"}
+	mcp.Spec.MaxUnavailable = intStrPtr(intstr.FromInt(2))
+
+	lowDisk := newNodeWithLabel("node-0", machineConfigV0, machineConfigV0, map[string]string{"node-role/worker": "", "node-role/infra": ""})
+	lowDisk.Annotations[daemonconsts.FreeDiskAnnotationKey] = fmt.Sprintf(`{"/sysroot":%d,"/var":%d}`, 1*gib, 20*gib)
+	enoughDisk := newNodeWithLabel("node-1", machineConfigV0, machineConfigV0, map[string]string{"node-role/worker": "", "node-role/infra": ""})
+	enoughDisk.Annotations[daemonconsts.FreeDiskAnnotationKey] = fmt.Sprintf(`{"/sysroot":%d,"/var":%d}`, 20*gib, 20*gib)
+
+	f.ccLister = append(f.ccLister, newControllerConfig(ctrlcommon.ControllerConfigName, configv1.TopologyMode("")))
+	f.mcpLister = append(f.mcpLister, mcp)
+	f.objects = append(f.objects, mcp)
+	f.nodeLister = append(f.nodeLister, lowDisk, enoughDisk)
+	f.kubeobjects = append(f.kubeobjects, lowDisk, enoughDisk)
+
+	c := f.newController()
+	selection := &candidateSelection{nodes: map[string]string{}}
+	err := c.updateCandidateMachines(mcp, []*corev1.Node{lowDisk, enoughDisk}, 2, selection)
+	require.NoError(t, err)
+
+	updated := map[string]bool{}
+	for _, action := range filterInformerActions(f.kubeclient.Actions()) {
+		if action.Matches("patch", "nodes") {
+			updated[action.(core.PatchAction).GetName()] = true
+		}
+	}
+
+	assert.False(t, updated["node-0"])
+	assert.True(t, updated["node-1"])
+
+	assert.Equal(t, "deferred until 10Gi is free: /sysroot has 1024Mi free", selection.reasonFor(lowDisk))
+	assert.Equal(t, "selected for update", selection.reasonFor(enoughDisk))
+}
+
+func TestFilterHeldCandidateNodes(t *testing.T) {
+	t.Parallel()
+
+	f := newFixture(t)
+	mcp := helpers.NewMachineConfigPool(ctrlcommon.MachineConfigPoolWorker, nil, helpers.WorkerSelector, machineConfigV1)
+
+	held := newNodeWithLabel("node-0", machineConfigV0, machineConfigV0, map[string]string{"node-role/worker": ""})
+	held.Annotations[daemonconsts.HoldAnnotationKey] = "true"
+	notHeld := newNodeWithLabel("node-1", machineConfigV0, machineConfigV0, map[string]string{"node-role/worker": ""})
+	notHeld.Annotations[daemonconsts.HoldAnnotationKey] = "false"
+
+	c := f.newController()
+	selection := &candidateSelection{nodes: map[string]string{}}
+	candidates := c.filterHeldCandidateNodes(mcp, []*corev1.Node{held, notHeld}, selection)
+
+	assert.Equal(t, []*corev1.Node{notHeld}, candidates)
+	assert.Equal(t, "held by the machineconfiguration.openshift.io/hold annotation", selection.reasonFor(held))
+	assert.Equal(t, "", selection.reasonFor(notHeld))
+}
+
+func TestUpdateCandidateMachinesLimitsConcurrentImagePulls(t *testing.T) {
+	t.Parallel()
+
+	f := newFixture(t)
+	mcp := helpers.NewMachineConfigPool(ctrlcommon.MachineConfigPoolWorker, nil, helpers.WorkerSelector, machineConfigV1)
+	mcp.Annotations = map[string]string{ctrlcommon.MaxConcurrentImagePullsAnnotationKey: "1"}
+	mcp.Spec.MaxUnavailable = intStrPtr(intstr.FromInt(3))
+
+	oldImage := helpers.NewMachineConfig(machineConfigV0, nil, "registry.example.com/os@sha256:old", nil)
+	newImage := helpers.NewMachineConfig(machineConfigV1, nil, "registry.example.com/os@sha256:new", nil)
+	sameImage := helpers.NewMachineConfig(machineConfigV2, nil, "registry.example.com/os@sha256:new", nil)
+
+	labels := map[string]string{"node-role/worker": "", "node-role/infra": ""}
+	// Already updating to the new OS image.
+	pulling := newNodeWithLabel("node-0", machineConfigV0, machineConfigV1, labels)
+	needsPull := newNodeWithLabel("node-1", machineConfigV0, machineConfigV0, labels)
+	hasImage := newNodeWithLabel("node-2", machineConfigV2, machineConfigV2, labels)
+
+	f.ccLister = append(f.ccLister, newControllerConfig(ctrlcommon.ControllerConfigName, configv1.TopologyMode("")))
+	f.mcLister = append(f.mcLister, oldImage, newImage, sameImage)
+	f.mcpLister = append(f.mcpLister, mcp)
+	f.objects = append(f.objects, mcp)
+	f.nodeLister = append(f.nodeLister, pulling, needsPull, hasImage)
+	f.kubeobjects = append(f.kubeobjects, pulling, needsPull, hasImage)
+
+	c := f.newController()
+	selection := &candidateSelection{nodes: map[string]string{}}
+	err := c.updateCandidateMachines(mcp, []*corev1.Node{needsPull, hasImage}, 3, selection)
+	require.NoError(t, err)
+
+	updated := map[string]bool{}
+	for _, action := range filterInformerActions(f.kubeclient.Actions()) {
+		if action.Matches("patch", "nodes") {
+			updated[action.(core.PatchAction).GetName()] = true
+		}
+	}
+
+	assert.False(t, updated["node-1"])
+	assert.True(t, updated["node-2"])
+
+	assert.Equal(t, "deferred until fewer than 1 nodes are pulling OS image registry.example.com/os@sha256:new", selection.reasonFor(needsPull))
+}
+
+func TestGetCanaryStatus(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	soak := 30 * time.Minute
+	pool := helpers.NewMachineConfigPoolBuilder("worker").WithMachineConfig(machineConfigV1).WithImage(imageV1).MachineConfigPool()
+
+	readyFor := func(nb *helpers.NodeBuilder, status corev1.ConditionStatus, since time.Duration) *corev1.Node {
+		return nb.WithStatus(corev1.NodeStatus{Conditions: []corev1.NodeCondition{{Type: corev1.NodeReady, Status: status, LastTransitionTime: metav1.NewTime(now.Add(-since))}}}).Node()
+	}
+
+	oldImageNode := helpers.NewNodeBuilder("node-0").WithEqualConfigsAndImages(machineConfigV1, imageV0).Node()
+
+	tests := []struct {
+		name      string
+		canary    *corev1.Node
+		passed    bool
+		failed    bool
+		remaining time.Duration
+	}{
+		{
+			name: "no canary yet",
+		},
+		{
+			name:   "canary updating",
+			canary: helpers.NewNodeBuilder("node-1").WithConfigs(machineConfigV1, machineConfigV1).WithImages(imageV0, imageV1).Node(),
+		},
+		{
+			name:   "canary failed to update",
+			canary: helpers.NewNodeBuilder("node-1").WithConfigs(machineConfigV1, machineConfigV1).WithImages(imageV0, imageV1).WithMCDState(daemonconsts.MachineConfigDaemonStateDegraded).Node(),
+			failed: true,
+		},
+		{
+			name:      "canary soaking",
+			canary:    readyFor(helpers.NewNodeBuilder("node-1").WithEqualConfigsAndImages(machineConfigV1, imageV1), corev1.ConditionTrue, 10*time.Minute),
+			remaining: 20 * time.Minute,
+		},
+		{
+			name:   "canary passed",
+			canary: readyFor(helpers.NewNodeBuilder("node-1").WithEqualConfigsAndImages(machineConfigV1, imageV1), corev1.ConditionTrue, time.Hour),
+			passed: true,
+		},
+		{
+			name:   "canary briefly not ready",
+			canary: readyFor(helpers.NewNodeBuilder("node-1").WithEqualConfigsAndImages(machineConfigV1, imageV1), corev1.ConditionFalse, time.Minute),
+		},
+		{
+			name:   "canary not ready for longer than the soak period",
+			canary: readyFor(helpers.NewNodeBuilder("node-1").WithEqualConfigsAndImages(machineConfigV1, imageV1), corev1.ConditionFalse, time.Hour),
+			failed: true,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			nodes := []*corev1.Node{oldImageNode}
+			if test.canary != nil {
+				nodes = append(nodes, test.canary)
+			}
+
+			status := getCanaryStatus(pool, nodes, soak, now)
+			assert.Equal(t, test.passed, status.passed)
+			assert.Equal(t, test.failed, status.failure != "", status.failure)
+			assert.Equal(t, test.remaining, status.remaining)
+
+			if test.canary != nil {
+				assert.Equal(t, test.canary.Name, status.node.Name)
+			} else {
+				assert.Nil(t, status.node)
+			}
+		})
+	}
+}
+
+func TestUpdateCandidateMachinesCanary(t *testing.T) {
+	t.Parallel()
+
+	labels := map[string]string{"node-role/worker": "", "node-role/infra": ""}
+
+	tests := []struct {
+		name     string
+		nodes    []*corev1.Node
+		expected []string
+	}{
+		{
+			name: "designated canary node is updated alone",
+			nodes: []*corev1.Node{
+				helpers.NewNodeBuilder("node-0").WithEqualConfigsAndImages(machineConfigV1, imageV0).WithLabels(labels).Node(),
+				helpers.NewNodeBuilder("node-1").WithEqualConfigsAndImages(machineConfigV1, imageV0).WithLabels(labels).WithAnnotations(map[string]string{ctrlcommon.CanaryNodeAnnotationKey: "true"}).Node(),
+				helpers.NewNodeBuilder("node-2").WithEqualConfigsAndImages(machineConfigV1, imageV0).WithLabels(labels).Node(),
+			},
+			expected: []string{"node-1"},
+		},
+		{
+			name: "rollout is halted when the canary node failed",
+			nodes: []*corev1.Node{
+				helpers.NewNodeBuilder("node-0").WithEqualConfigsAndImages(machineConfigV1, imageV0).WithLabels(labels).Node(),
+				helpers.NewNodeBuilder("node-1").WithConfigs(machineConfigV1, machineConfigV1).WithImages(imageV0, imageV1).WithLabels(labels).WithMCDState(daemonconsts.MachineConfigDaemonStateDegraded).Node(),
+				helpers.NewNodeBuilder("node-2").WithEqualConfigsAndImages(machineConfigV1, imageV0).WithLabels(labels).Node(),
+			},
+		},
+		{
+			name: "rollout continues once the canary node passed",
+			nodes: []*corev1.Node{
+				helpers.NewNodeBuilder("node-0").WithEqualConfigsAndImages(machineConfigV1, imageV0).WithLabels(labels).Node(),
+				helpers.NewNodeBuilder("node-1").WithEqualConfigsAndImages(machineConfigV1, imageV1).WithLabels(labels).WithNodeReady().Node(),
+				helpers.NewNodeBuilder("node-2").WithEqualConfigsAndImages(machineConfigV1, imageV0).WithLabels(labels).Node(),
+			},
+			expected: []string{"node-0", "node-2"},
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			f := newFixture(t)
+			mcp := helpers.NewMachineConfigPoolBuilder(ctrlcommon.MachineConfigPoolWorker).WithNodeSelector(helpers.WorkerSelector).WithMachineConfig(machineConfigV1).WithImage(imageV1).WithMaxUnavailable(3).WithAnnotations(map[string]string{ctrlcommon.CanarySoakAnnotationKey: "0s"}).MachineConfigPool()
+
+			f.ccLister = append(f.ccLister, newControllerConfig(ctrlcommon.ControllerConfigName, configv1.TopologyMode("")))
+			f.mcpLister = append(f.mcpLister, mcp)
+			f.objects = append(f.objects, mcp)
+			f.nodeLister = append(f.nodeLister, test.nodes...)
+			for _, node := range test.nodes {
+				f.kubeobjects = append(f.kubeobjects, node)
+			}
+
+			candidates := []*corev1.Node{}
+			for _, node := range test.nodes {
+				if !ctrlcommon.NewLayeredNodeState(node).IsDesiredEqualToPool(mcp) {
+					candidates = append(candidates, node)
+				}
+			}
+
+			c := f.newController()
+			err := c.updateCandidateMachines(mcp, candidates, 3, &candidateSelection{nodes: map[string]string{}})
+			require.NoError(t, err)
+
+			updated := []string{}
+			for _, action := range filterInformerActions(f.kubeclient.Actions()) {
+				if action.Matches("patch", "nodes") {
+					updated = append(updated, action.(core.PatchAction).GetName())
+				}
+			}
+
+			assert.ElementsMatch(t, test.expected, updated)
+		})
+	}
+}
+
+func TestParseRebootWindow(t *testing.T) {
+	t.Parallel()
+
+	window, err := parseRebootWindow("22:00-04:00")
+	require.NoError(t, err)
+	assert.Equal(t, "22:00-04:00", window.String())
+
+	day := time.Date(2023, time.October, 20, 0, 0, 0, 0, time.UTC)
+	assert.True(t, window.contains(day.Add(23*time.Hour)))
+	assert.True(t, window.contains(day.Add(2*time.Hour)))
+	assert.False(t, window.contains(day.Add(4*time.Hour)))
+	assert.False(t, window.contains(day.Add(12*time.Hour)))
+	assert.Equal(t, 10*time.Hour, window.untilStart(day.Add(12*time.Hour)))
+	assert.Equal(t, 23*time.Hour, window.untilStart(day.Add(23*time.Hour)))
+
+	window, err = parseRebootWindow("01:30-05:00")
+	require.NoError(t, err)
+	assert.True(t, window.contains(day.Add(90*time.Minute)))
+	assert.False(t, window.contains(day.Add(23*time.Hour)))
+
+	for _, val := range []string{"22:00", "22:00-25:00", "10pm-4am", "04:00-04:00"} {
+		_, err := parseRebootWindow(val)
+		assert.Error(t, err, val)
+	}
+}
+
+func TestRequestPeriodicReboots(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	bootedAgo := func(d time.Duration) map[string]string {
+		return map[string]string{daemonconsts.LastBootTimeAnnotationKey: now.Add(-d).UTC().Format(time.RFC3339)}
+	}
+	newNode := func(name string, annos map[string]string) *corev1.Node {
+		return helpers.NewNodeBuilder(name).WithEqualConfigs(machineConfigV1).WithMCDState(daemonconsts.MachineConfigDaemonStateDone).WithLabels(map[string]string{"node-role/worker": ""}).WithAnnotations(annos).Node()
+	}
+
+	// A window which is closed now.
+	closedWindow := fmt.Sprintf("%s-%s", now.UTC().Add(2*time.Hour).Format("15:04"), now.UTC().Add(3*time.Hour).Format("15:04"))
+
+	tests := []struct {
+		name     string
+		annos    map[string]string
+		nodes    []*corev1.Node
+		capacity uint
+		expected []string
+	}{
+		{
+			name:     "nodes which are due are rebooted oldest boot first",
+			nodes:    []*corev1.Node{newNode("node-0", bootedAgo(40*24*time.Hour)), newNode("node-1", bootedAgo(50*24*time.Hour)), newNode("node-2", bootedAgo(24*time.Hour))},
+			capacity: 1,
+			expected: []string{"node-1"},
+		},
+		{
+			name:     "nodes without a boot time are not rebooted",
+			nodes:    []*corev1.Node{newNode("node-0", nil), newNode("node-1", bootedAgo(50*24*time.Hour))},
+			capacity: 2,
+			expected: []string{"node-1"},
+		},
+		{
+			name: "nodes whose updated packages require a reboot do not wait for the interval",
+			nodes: []*corev1.Node{
+				newNode("node-0", map[string]string{daemonconsts.LastBootTimeAnnotationKey: now.Add(-24 * time.Hour).UTC().Format(time.RFC3339), daemonconsts.RebootRequiredAnnotationKey: "true"}),
+				newNode("node-1", bootedAgo(2*24*time.Hour)),
+			},
+			capacity: 2,
+			expected: []string{"node-0"},
+		},
+		{
+			name: "pending reboot requests count against the capacity",
+			nodes: []*corev1.Node{
+				newNode("node-0", map[string]string{daemonconsts.LastBootTimeAnnotationKey: now.Add(-40 * 24 * time.Hour).UTC().Format(time.RFC3339), daemonconsts.RebootRequestAnnotationKey: now.UTC().Format(time.RFC3339)}),
+				newNode("node-1", bootedAgo(50*24*time.Hour)),
+			},
+			capacity: 1,
+		},
+		{
+			name: "nodes are not rebooted while the pool is updating",
+			nodes: []*corev1.Node{
+				newNode("node-0", bootedAgo(40*24*time.Hour)),
+				helpers.NewNodeBuilder("node-1").WithConfigs(machineConfigV0, machineConfigV1).WithLabels(map[string]string{"node-role/worker": ""}).Node(),
+			},
+			capacity: 1,
+		},
+		{
+			name:     "nodes are not rebooted outside of the reboot window",
+			annos:    map[string]string{ctrlcommon.PeriodicRebootWindowAnnotationKey: closedWindow},
+			nodes:    []*corev1.Node{newNode("node-0", bootedAgo(40*24*time.Hour))},
+			capacity: 1,
+		},
+		{
+			name:     "nodes are not rebooted with an invalid policy",
+			annos:    map[string]string{ctrlcommon.PeriodicRebootIntervalAnnotationKey: ""},
+			nodes:    []*corev1.Node{newNode("node-0", bootedAgo(40*24*time.Hour))},
+			capacity: 1,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			annos := map[string]string{ctrlcommon.PeriodicRebootIntervalAnnotationKey: "720h"}
+			for k, v := range test.annos {
+				annos[k] = v
+			}
+
+			f := newFixture(t)
+			mcp := helpers.NewMachineConfigPoolBuilder(ctrlcommon.MachineConfigPoolWorker).WithNodeSelector(helpers.WorkerSelector).WithMachineConfig(machineConfigV1).WithMaxUnavailable(2).WithAnnotations(annos).MachineConfigPool()
+
+			f.ccLister = append(f.ccLister, newControllerConfig(ctrlcommon.ControllerConfigName, configv1.TopologyMode("")))
+			f.mcpLister = append(f.mcpLister, mcp)
+			f.objects = append(f.objects, mcp)
+			f.nodeLister = append(f.nodeLister, test.nodes...)
+			for _, node := range test.nodes {
+				f.kubeobjects = append(f.kubeobjects, node)
+			}
+
+			c := f.newController()
+			err := c.requestPeriodicReboots(mcp, test.nodes, test.capacity)
+			require.NoError(t, err)
+
+			requested := []string{}
+			for _, action := range filterInformerActions(f.kubeclient.Actions()) {
+				if action.Matches("patch", "nodes") {
+					requested = append(requested, action.(core.PatchAction).GetName())
+				}
+			}
+
+			assert.ElementsMatch(t, test.expected, requested)
+		})
+	}
+}
+
+func TestRequestStagedUpdateFinalizations(t *testing.T) {
+	t.Parallel()
+
+	stagedAt := func(d time.Duration) map[string]string {
+		staged := fmt.Sprintf(`{"config":%q,"from":%q,"finalization":"Locked","time":%q}`, machineConfigV1, machineConfigV0, time.Now().Add(-d).UTC().Format(time.RFC3339))
+		return map[string]string{daemonconsts.StagedUpdateAnnotationKey: staged}
+	}
+	newNode := func(name string, annos map[string]string) *corev1.Node {
+		return helpers.NewNodeBuilder(name).WithConfigs(machineConfigV0, machineConfigV1).WithMCDState(daemonconsts.MachineConfigDaemonStateDone).WithLabels(map[string]string{"node-role/worker": ""}).WithAnnotations(annos).Node()
+	}
+
+	openWindow := "Sun,Mon,Tue,Wed,Thu,Fri,Sat 00:00-24:00"
+	// A window on the day after tomorrow, which is closed now.
+	closedWindow := fmt.Sprintf("%s 00:00-24:00", time.Now().UTC().Add(48 * time.Hour).Weekday().String()[:3])
+
+	tests := []struct {
+		name     string
+		annos    map[string]string
+		nodes    []*corev1.Node
+		capacity uint
+		expected []string
+	}{
+		{
+			name:     "updates staged first are finalized first",
+			annos:    map[string]string{ctrlcommon.MaintenanceWindowsAnnotationKey: openWindow},
+			nodes:    []*corev1.Node{newNode("node-0", stagedAt(time.Hour)), newNode("node-1", stagedAt(2*time.Hour)), newNode("node-2", stagedAt(time.Minute))},
+			capacity: 2,
+			expected: []string{"node-0", "node-1"},
+		},
+		{
+			name:     "nodes without a staged update are left alone",
+			annos:    map[string]string{ctrlcommon.MaintenanceWindowsAnnotationKey: openWindow},
+			nodes:    []*corev1.Node{newNode("node-0", nil), newNode("node-1", stagedAt(time.Hour))},
+			capacity: 2,
+			expected: []string{"node-1"},
+		},
+		{
+			name:  "pending finalize requests count against the capacity",
+			annos: map[string]string{ctrlcommon.MaintenanceWindowsAnnotationKey: openWindow},
+			nodes: []*corev1.Node{
+				newNode("node-0", map[string]string{
+					daemonconsts.StagedUpdateAnnotationKey:    stagedAt(time.Hour)[daemonconsts.StagedUpdateAnnotationKey],
+					daemonconsts.FinalizeRequestAnnotationKey: time.Now().UTC().Format(time.RFC3339),
+				}),
+				newNode("node-1", stagedAt(time.Hour)),
+			},
+			capacity: 1,
+		},
+		{
+			name:     "staged updates are not finalized outside of maintenance windows",
+			annos:    map[string]string{ctrlcommon.MaintenanceWindowsAnnotationKey: closedWindow},
+			nodes:    []*corev1.Node{newNode("node-0", stagedAt(time.Hour))},
+			capacity: 1,
+		},
+		{
+			name:     "staged updates are not finalized without maintenance windows",
+			nodes:    []*corev1.Node{newNode("node-0", stagedAt(time.Hour))},
+			capacity: 1,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			annos := map[string]string{ctrlcommon.StagedUpdatesAnnotationKey: `{}`}
+			for k, v := range test.annos {
+				annos[k] = v
+			}
+
+			f := newFixture(t)
+			mcp := helpers.NewMachineConfigPoolBuilder(ctrlcommon.MachineConfigPoolWorker).WithNodeSelector(helpers.WorkerSelector).WithMachineConfig(machineConfigV1).WithMaxUnavailable(2).WithAnnotations(annos).MachineConfigPool()
+
+			f.ccLister = append(f.ccLister, newControllerConfig(ctrlcommon.ControllerConfigName, configv1.TopologyMode("")))
+			f.mcpLister = append(f.mcpLister, mcp)
+			f.objects = append(f.objects, mcp)
+			f.nodeLister = append(f.nodeLister, test.nodes...)
+			for _, node := range test.nodes {
+				f.kubeobjects = append(f.kubeobjects, node)
+			}
+
+			c := f.newController()
+			err := c.requestStagedUpdateFinalizations(mcp, test.nodes, test.capacity)
+			require.NoError(t, err)
+
+			requested := []string{}
+			for _, action := range filterInformerActions(f.kubeclient.Actions()) {
+				if action.Matches("patch", "nodes") {
+					requested = append(requested, action.(core.PatchAction).GetName())
+				}
+			}
+
+			assert.ElementsMatch(t, test.expected, requested)
+		})
+	}
+}
+
+func TestSetUnmanagedPathsAnnotations(t *testing.T) {
+	t.Parallel()
+
+	newNode := func(name string, annos map[string]string) *corev1.Node {
+		return helpers.NewNodeBuilder(name).WithEqualConfigs(machineConfigV1).WithLabels(map[string]string{"node-role/worker": ""}).WithAnnotations(annos).Node()
+	}
+
+	tests := []struct {
+		name     string
+		annos    map[string]string
+		nodes    []*corev1.Node
+		expected []string
+	}{
+		{
+			name:  "nodes are given the unmanaged paths",
+			annos: map[string]string{ctrlcommon.UnmanagedPathsAnnotationKey: "/etc/agent.d/,/etc/agent.conf"},
+			nodes: []*corev1.Node{
+				newNode("node-0", nil),
+				newNode("node-1", map[string]string{daemonconsts.UnmanagedPathsAnnotationKey: "/etc/agent.conf,/etc/agent.d/"}),
+				newNode("node-2", map[string]string{daemonconsts.UnmanagedPathsAnnotationKey: "/etc/agent.conf"}),
+			},
+			expected: []string{"node-0", "node-2"},
+		},
+		{
+			name: "unmanaged paths are removed from nodes",
+			nodes: []*corev1.Node{
+				newNode("node-0", nil),
+				newNode("node-1", map[string]string{daemonconsts.UnmanagedPathsAnnotationKey: "/etc/agent.conf"}),
+			},
+			expected: []string{"node-1"},
+		},
+		{
+			name:  "nodes are left alone with invalid unmanaged paths",
+			annos: map[string]string{ctrlcommon.UnmanagedPathsAnnotationKey: "/var/lib/agent"},
+			nodes: []*corev1.Node{
+				newNode("node-0", nil),
+				newNode("node-1", map[string]string{daemonconsts.UnmanagedPathsAnnotationKey: "/etc/agent.conf"}),
+			},
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			f := newFixture(t)
+			mcp := helpers.NewMachineConfigPoolBuilder(ctrlcommon.MachineConfigPoolWorker).WithNodeSelector(helpers.WorkerSelector).WithMachineConfig(machineConfigV1).WithAnnotations(test.annos).MachineConfigPool()
+
+			f.mcpLister = append(f.mcpLister, mcp)
+			f.objects = append(f.objects, mcp)
+			f.nodeLister = append(f.nodeLister, test.nodes...)
+			for _, node := range test.nodes {
+				f.kubeobjects = append(f.kubeobjects, node)
+			}
+
+			c := f.newController()
+			err := c.setPoolNodeAnnotations(mcp, test.nodes)
+			require.NoError(t, err)
+
+			updated := []string{}
+			for _, action := range filterInformerActions(f.kubeclient.Actions()) {
+				if action.Matches("patch", "nodes") {
+					updated = append(updated, action.(core.PatchAction).GetName())
+				}
+			}
+
+			assert.ElementsMatch(t, test.expected, updated)
+		})
+	}
+}
+
+func TestSetConfigDriftRemediationAnnotations(t *testing.T) {
+	t.Parallel()
+
+	newNode := func(name string, annos map[string]string) *corev1.Node {
+		return helpers.NewNodeBuilder(name).WithEqualConfigs(machineConfigV1).WithLabels(map[string]string{"node-role/worker": ""}).WithAnnotations(annos).Node()
+	}
+
+	remediate := map[string]string{daemonconsts.ConfigDriftRemediationAnnotationKey: ctrlcommon.ConfigDriftRemediationRemediate}
+
+	tests := []struct {
+		name     string
+		annos    map[string]string
+		nodes    []*corev1.Node
+		expected []string
+	}{
+		{
+			name:  "nodes are told to remediate config drift",
+			annos: map[string]string{ctrlcommon.ConfigDriftRemediationAnnotationKey: ctrlcommon.ConfigDriftRemediationRemediate},
+			nodes: []*corev1.Node{
+				newNode("node-0", nil),
+				newNode("node-1", remediate),
+			},
+			expected: []string{"node-0"},
+		},
+		{
+			name:  "nodes are told to degrade on config drift",
+			annos: map[string]string{ctrlcommon.ConfigDriftRemediationAnnotationKey: ctrlcommon.ConfigDriftRemediationDegrade},
+			nodes: []*corev1.Node{
+				newNode("node-0", nil),
+				newNode("node-1", remediate),
+			},
+			expected: []string{"node-1"},
+		},
+		{
+			name: "nodes degrade on config drift by default",
+			nodes: []*corev1.Node{
+				newNode("node-0", remediate),
+			},
+			expected: []string{"node-0"},
+		},
+		{
+			name:  "nodes are left alone with an invalid mode",
+			annos: map[string]string{ctrlcommon.ConfigDriftRemediationAnnotationKey: "Ignore"},
+			nodes: []*corev1.Node{
+				newNode("node-0", nil),
+				newNode("node-1", remediate),
+			},
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			f := newFixture(t)
+			mcp := helpers.NewMachineConfigPoolBuilder(ctrlcommon.MachineConfigPoolWorker).WithNodeSelector(helpers.WorkerSelector).WithMachineConfig(machineConfigV1).WithAnnotations(test.annos).MachineConfigPool()
+
+			f.mcpLister = append(f.mcpLister, mcp)
+			f.objects = append(f.objects, mcp)
+			f.nodeLister = append(f.nodeLister, test.nodes...)
+			for _, node := range test.nodes {
+				f.kubeobjects = append(f.kubeobjects, node)
+			}
+
+			c := f.newController()
+			err := c.setPoolNodeAnnotations(mcp, test.nodes)
+			require.NoError(t, err)
+
+			updated := []string{}
+			for _, action := range filterInformerActions(f.kubeclient.Actions()) {
+				if action.Matches("patch", "nodes") {
+					updated = append(updated, action.(core.PatchAction).GetName())
+				}
+			}
+
+			assert.ElementsMatch(t, test.expected, updated)
+		})
+	}
+}
+
+func TestSetRebootStrategyAnnotations(t *testing.T) {
+	t.Parallel()
+
+	newNode := func(name string, annos map[string]string) *corev1.Node {
+		return helpers.NewNodeBuilder(name).WithEqualConfigs(machineConfigV1).WithLabels(map[string]string{"node-role/worker": ""}).WithAnnotations(annos).Node()
+	}
+
+	kexec := map[string]string{daemonconsts.RebootStrategyAnnotationKey: ctrlcommon.RebootStrategyKexec}
+	softReboot := map[string]string{daemonconsts.RebootStrategyAnnotationKey: ctrlcommon.RebootStrategySoftReboot}
+
+	tests := []struct {
+		name     string
+		annos    map[string]string
+		nodes    []*corev1.Node
+		expected []string
+	}{
+		{
+			name:  "nodes are told to kexec",
+			annos: map[string]string{ctrlcommon.RebootStrategyAnnotationKey: ctrlcommon.RebootStrategyKexec},
+			nodes: []*corev1.Node{
+				newNode("node-0", nil),
+				newNode("node-1", kexec),
+				newNode("node-2", softReboot),
+			},
+			expected: []string{"node-0", "node-2"},
+		},
+		{
+			name:  "nodes are told to fully reboot",
+			annos: map[string]string{ctrlcommon.RebootStrategyAnnotationKey: ctrlcommon.RebootStrategyReboot},
+			nodes: []*corev1.Node{
+				newNode("node-0", nil),
+				newNode("node-1", softReboot),
+			},
+			expected: []string{"node-1"},
+		},
+		{
+			name: "nodes fully reboot by default",
+			nodes: []*corev1.Node{
+				newNode("node-0", kexec),
+			},
+			expected: []string{"node-0"},
+		},
+		{
+			name:  "nodes are left alone with an invalid strategy",
+			annos: map[string]string{ctrlcommon.RebootStrategyAnnotationKey: "Halt"},
+			nodes: []*corev1.Node{
+				newNode("node-0", nil),
+				newNode("node-1", kexec),
+			},
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			f := newFixture(t)
+			mcp := helpers.NewMachineConfigPoolBuilder(ctrlcommon.MachineConfigPoolWorker).WithNodeSelector(helpers.WorkerSelector).WithMachineConfig(machineConfigV1).WithAnnotations(test.annos).MachineConfigPool()
+
+			f.mcpLister = append(f.mcpLister, mcp)
+			f.objects = append(f.objects, mcp)
+			f.nodeLister = append(f.nodeLister, test.nodes...)
+			for _, node := range test.nodes {
+				f.kubeobjects = append(f.kubeobjects, node)
+			}
+
+			c := f.newController()
+			err := c.setPoolNodeAnnotations(mcp, test.nodes)
+			require.NoError(t, err)
+
+			updated := []string{}
+			for _, action := range filterInformerActions(f.kubeclient.Actions()) {
+				if action.Matches("patch", "nodes") {
+					updated = append(updated, action.(core.PatchAction).GetName())
+				}
+			}
+
+			assert.ElementsMatch(t, test.expected, updated)
+		})
+	}
+}
+
+// Tests that the UpdateCandidates condition message counts the nodes and only lists a few of them,
+// starting with the ones which were not selected.
+func TestGetUpdateCandidatesMessage(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, "2 nodes are waiting for an update, 1 of which were selected: node-1: pool is paused; node-0: selected for update",
+		getUpdateCandidatesMessage(map[string]string{
+			"node-0": selectedForUpdateReason,
+			"node-1": "pool is paused",
+		}))
+
+	reasons := map[string]string{}
+	for i := 0; i < 100; i++ {
+		reasons[fmt.Sprintf("node-%02d", i)] = "waiting for capacity"
+	}
+	reasons["node-00"] = selectedForUpdateReason
+
+	assert.Equal(t, "100 nodes are waiting for an update, 1 of which were selected: node-01: waiting for capacity; node-02: waiting for capacity; node-03: waiting for capacity; node-04: waiting for capacity; node-05: waiting for capacity; and 95 more",
+		getUpdateCandidatesMessage(reasons))
+}
+
+// Tests that a node whose pool changed several settings at once is only written once.
+func TestSetPoolNodeAnnotationsWritesNodeOnce(t *testing.T) {
+	t.Parallel()
+
+	f := newFixture(t)
+	mcp := helpers.NewMachineConfigPoolBuilder(ctrlcommon.MachineConfigPoolWorker).WithNodeSelector(helpers.WorkerSelector).WithMachineConfig(machineConfigV1).WithAnnotations(map[string]string{
+		ctrlcommon.UnmanagedPathsAnnotationKey:         "/etc/agent.conf",
+		ctrlcommon.ConfigDriftRemediationAnnotationKey: ctrlcommon.ConfigDriftRemediationRemediate,
+		ctrlcommon.RebootStrategyAnnotationKey:         ctrlcommon.RebootStrategyKexec,
+	}).MachineConfigPool()
+
+	node := helpers.NewNodeBuilder("node-0").WithEqualConfigs(machineConfigV1).WithLabels(map[string]string{"node-role/worker": ""}).Node()
+
+	f.mcpLister = append(f.mcpLister, mcp)
+	f.objects = append(f.objects, mcp)
+	f.nodeLister = append(f.nodeLister, node)
+	f.kubeobjects = append(f.kubeobjects, node)
+
+	c := f.newController()
+	require.NoError(t, c.setPoolNodeAnnotations(mcp, []*corev1.Node{node}))
+
+	patches := 0
+	for _, action := range filterInformerActions(f.kubeclient.Actions()) {
+		if action.Matches("patch", "nodes") {
+			patches++
+		}
+	}
+	assert.Equal(t, 1, patches)
+
+	updated, err := f.kubeclient.CoreV1().Nodes().Get(context.TODO(), node.Name, metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "/etc/agent.conf", updated.Annotations[daemonconsts.UnmanagedPathsAnnotationKey])
+	assert.Equal(t, ctrlcommon.ConfigDriftRemediationRemediate, updated.Annotations[daemonconsts.ConfigDriftRemediationAnnotationKey])
+	assert.Equal(t, ctrlcommon.RebootStrategyKexec, updated.Annotations[daemonconsts.RebootStrategyAnnotationKey])
+}
@@ -0,0 +1,257 @@
+package node
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	"github.com/openshift/machine-config-operator/internal"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	daemonconsts "github.com/openshift/machine-config-operator/pkg/daemon/constants"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+)
+
+// rebootWindow is a daily time window in UTC. The window wraps around midnight when its end is
+// before its start.
+type rebootWindow struct {
+	start time.Duration
+	end   time.Duration
+}
+
+// parseTimeOfDay parses a time of day such as "22:00" into the offset from midnight.
+func parseTimeOfDay(val string) (time.Duration, error) {
+	t, err := time.Parse("15:04", val)
+	if err != nil {
+		return 0, err
+	}
+
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// parseRebootWindow parses a daily time window in UTC such as "22:00-04:00".
+func parseRebootWindow(val string) (*rebootWindow, error) {
+	startVal, endVal, ok := strings.Cut(val, "-")
+	if !ok {
+		return nil, fmt.Errorf("invalid %s annotation %q: must be of the form HH:MM-HH:MM", ctrlcommon.PeriodicRebootWindowAnnotationKey, val)
+	}
+
+	start, err := parseTimeOfDay(strings.TrimSpace(startVal))
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s annotation %q: %w", ctrlcommon.PeriodicRebootWindowAnnotationKey, val, err)
+	}
+
+	end, err := parseTimeOfDay(strings.TrimSpace(endVal))
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s annotation %q: %w", ctrlcommon.PeriodicRebootWindowAnnotationKey, val, err)
+	}
+
+	if start == end {
+		return nil, fmt.Errorf("invalid %s annotation %q: window must not be empty", ctrlcommon.PeriodicRebootWindowAnnotationKey, val)
+	}
+
+	return &rebootWindow{start: start, end: end}, nil
+}
+
+// sinceMidnight returns how much of the UTC day of t has passed.
+func sinceMidnight(t time.Time) time.Duration {
+	t = t.UTC()
+	return t.Sub(time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC))
+}
+
+// contains returns whether t is within the window.
+func (w *rebootWindow) contains(t time.Time) bool {
+	now := sinceMidnight(t)
+
+	if w.start < w.end {
+		return now >= w.start && now < w.end
+	}
+
+	return now >= w.start || now < w.end
+}
+
+// untilStart returns how long it is from t until the window next opens.
+func (w *rebootWindow) untilStart(t time.Time) time.Duration {
+	until := w.start - sinceMidnight(t)
+	if until < 0 {
+		until += 24 * time.Hour
+	}
+
+	return until
+}
+
+// String returns the window in the format of the periodic reboot window annotation.
+func (w *rebootWindow) String() string {
+	format := func(d time.Duration) string {
+		return fmt.Sprintf("%02d:%02d", int(d.Hours()), int(d.Minutes())%60)
+	}
+
+	return fmt.Sprintf("%s-%s", format(w.start), format(w.end))
+}
+
+// periodicRebootPolicy describes how often the nodes of a pool are rebooted without a config
+// change, and when.
+type periodicRebootPolicy struct {
+	interval time.Duration
+	// window is nil when reboots may start at any time of day.
+	window *rebootWindow
+}
+
+// getPeriodicRebootPolicy returns the periodic reboot policy of the pool, or nil if the pool's
+// nodes are not rebooted periodically.
+func getPeriodicRebootPolicy(pool *mcfgv1.MachineConfigPool) (*periodicRebootPolicy, error) {
+	val, ok := pool.Annotations[ctrlcommon.PeriodicRebootIntervalAnnotationKey]
+	if !ok {
+		return nil, nil
+	}
+
+	interval, err := time.ParseDuration(val)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s annotation %q: %w", ctrlcommon.PeriodicRebootIntervalAnnotationKey, val, err)
+	}
+
+	// Anything shorter would keep part of the pool rebooting all the time.
+	if interval < time.Hour {
+		return nil, fmt.Errorf("invalid %s annotation %q: must be at least 1h", ctrlcommon.PeriodicRebootIntervalAnnotationKey, val)
+	}
+
+	policy := &periodicRebootPolicy{interval: interval}
+
+	if val, ok := pool.Annotations[ctrlcommon.PeriodicRebootWindowAnnotationKey]; ok {
+		if policy.window, err = parseRebootWindow(val); err != nil {
+			return nil, err
+		}
+	}
+
+	return policy, nil
+}
+
+// getNodeLastBootTime returns when the node last booted, as reported by the MCD.
+func getNodeLastBootTime(node *corev1.Node) (time.Time, bool) {
+	val, ok := ctrlcommon.GetNodeStatus(node, daemonconsts.LastBootTimeAnnotationKey)
+	if !ok {
+		return time.Time{}, false
+	}
+
+	t, err := time.Parse(time.RFC3339, val)
+	if err != nil {
+		klog.V(4).Infof("Could not parse last boot time %q of node %s: %v", val, node.Name, err)
+		return time.Time{}, false
+	}
+
+	return t, true
+}
+
+// isNodeRebootRequired returns whether the MCD of a package-based node reports that the packages
+// updated on it require a reboot.
+func isNodeRebootRequired(node *corev1.Node) bool {
+	val, _ := ctrlcommon.GetNodeStatus(node, daemonconsts.RebootRequiredAnnotationKey)
+	return val == "true"
+}
+
+// hasPendingRebootRequest returns whether the MCD has not handled the reboot request of the node yet.
+func hasPendingRebootRequest(node *corev1.Node) bool {
+	request := node.Annotations[daemonconsts.RebootRequestAnnotationKey]
+	return request != "" && request != node.Annotations[daemonconsts.LastAppliedRebootRequestAnnotationKey]
+}
+
+// requestPeriodicReboots asks the MCD to drain and reboot the nodes of the pool which have not
+// rebooted for the pool's periodic reboot interval. It only does so while the pool is not
+// updating, within the pool's reboot window and up to the given capacity, which is what is left
+// of maxUnavailable.
+func (ctrl *Controller) requestPeriodicReboots(pool *mcfgv1.MachineConfigPool, nodes []*corev1.Node, capacity uint) error {
+	// An invalid policy is reported by the EffectiveUpdatePolicy condition.
+	policy, err := getPeriodicRebootPolicy(pool)
+	if err != nil || policy == nil {
+		return nil
+	}
+
+	now := time.Now()
+	pending := uint(0)
+	due := []*corev1.Node{}
+	var nextDue time.Duration
+
+	for _, node := range nodes {
+		// Held nodes are neither updated nor rebooted.
+		if ctrlcommon.IsNodeHeld(node) {
+			continue
+		}
+
+		// Config updates reboot the nodes anyway, so wait for them to finish.
+		if !ctrlcommon.NewLayeredNodeState(node).IsDoneAt(pool) {
+			return nil
+		}
+
+		if hasPendingRebootRequest(node) {
+			pending++
+			continue
+		}
+
+		lastBoot, ok := getNodeLastBootTime(node)
+		if !ok {
+			continue
+		}
+
+		// Nodes whose updated packages require a reboot do not wait for the interval.
+		if until := lastBoot.Add(policy.interval).Sub(now); until > 0 && !isNodeRebootRequired(node) {
+			if nextDue == 0 || until < nextDue {
+				nextDue = until
+			}
+			continue
+		}
+
+		due = append(due, node)
+	}
+
+	if len(due) == 0 {
+		if nextDue > 0 {
+			ctrl.enqueueAfter(pool, nextDue)
+		}
+		return nil
+	}
+
+	if policy.window != nil && !policy.window.contains(now) {
+		ctrl.logPool(pool, "%d nodes are due for a periodic reboot, waiting for reboot window %s UTC", len(due), policy.window)
+		ctrl.enqueueAfter(pool, policy.window.untilStart(now))
+		return nil
+	}
+
+	// Requested reboots which the MCD has not started yet do not count as unavailable.
+	if pending >= capacity {
+		return nil
+	}
+
+	sort.SliceStable(due, func(i, j int) bool {
+		iBoot, _ := getNodeLastBootTime(due[i])
+		jBoot, _ := getNodeLastBootTime(due[j])
+		return iBoot.Before(jBoot)
+	})
+
+	if uint(len(due)) > capacity-pending {
+		due = due[:capacity-pending]
+	}
+
+	request := now.UTC().Format(time.RFC3339)
+
+	for _, node := range due {
+		lastBoot, _ := getNodeLastBootTime(node)
+
+		_, err := internal.UpdateNodeRetry(ctrl.kubeClient.CoreV1().Nodes(), ctrl.nodeLister, node.Name, func(node *corev1.Node) {
+			node.Annotations[daemonconsts.RebootRequestAnnotationKey] = request
+		})
+		if err != nil {
+			return fmt.Errorf("could not request reboot of node %s: %w", node.Name, err)
+		}
+
+		ctrl.logPool(pool, "Requested periodic reboot of node %s, which last booted at %s", node.Name, lastBoot.UTC().Format(time.RFC3339))
+		if isNodeRebootRequired(node) {
+			ctrl.eventRecorder.Eventf(pool, corev1.EventTypeNormal, "PeriodicReboot", "Requested reboot of node %s, whose updated packages require a reboot", node.Name)
+			continue
+		}
+		ctrl.eventRecorder.Eventf(pool, corev1.EventTypeNormal, "PeriodicReboot", "Requested reboot of node %s, which has not rebooted for %s", node.Name, now.Sub(lastBoot).Round(time.Minute))
+	}
+
+	return nil
+}
@@ -0,0 +1,316 @@
+package node
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	"github.com/openshift/machine-config-operator/pkg/apihelpers"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+)
+
+// pipelineKey is the key of pipeline ConfigMaps which holds the pipeline.
+const pipelineKey = "pipeline.json"
+
+// The states of a pipeline stage.
+const (
+	pipelineStagePending    = "Pending"
+	pipelineStageInProgress = "InProgress"
+	pipelineStageSucceeded  = "Succeeded"
+	pipelineStageFailed     = "Failed"
+	pipelineStagePaused     = "Paused"
+)
+
+// poolPipeline describes each stage a pool's config passes through on its way to the nodes, so that
+// the console can render it without joining the pool, its build, its MachineConfigs and its nodes.
+type poolPipeline struct {
+	// Pool is the name of the MachineConfigPool.
+	Pool string `json:"pool"`
+	// Render is the rendering of the pool's MachineConfigs into a rendered MachineConfig.
+	Render pipelineRenderStage `json:"render"`
+	// Build is the on-cluster build of the OS image, only present for layered pools.
+	Build *pipelineBuildStage `json:"build,omitempty"`
+	// Image is the OS image the nodes are updated to.
+	Image pipelineImageStage `json:"image"`
+	// Rollout is the update of the nodes.
+	Rollout pipelineRolloutStage `json:"rollout"`
+}
+
+// pipelineStage holds what all stages of a pipeline have in common.
+type pipelineStage struct {
+	// State is one of Pending, InProgress, Succeeded, Failed or Paused.
+	State string `json:"state"`
+	// Message explains the state, if needed.
+	Message string `json:"message,omitempty"`
+}
+
+type pipelineRenderStage struct {
+	pipelineStage
+	// RenderedConfig is the name of the rendered MachineConfig.
+	RenderedConfig string `json:"renderedConfig,omitempty"`
+	// MachineConfigs are the names of the MachineConfigs it was rendered from.
+	MachineConfigs []string `json:"machineConfigs,omitempty"`
+}
+
+type pipelineBuildStage struct {
+	pipelineStage
+	// Name is the name of the build object, if there is one.
+	Name string `json:"name,omitempty"`
+	// Kind is the kind of the build object, if there is one.
+	Kind string `json:"kind,omitempty"`
+}
+
+type pipelineImageStage struct {
+	pipelineStage
+	// Pullspec is the pullspec of the OS image.
+	Pullspec string `json:"pullspec,omitempty"`
+	// Digest is the digest of the OS image, if the pullspec has one.
+	Digest string `json:"digest,omitempty"`
+}
+
+type pipelineRolloutStage struct {
+	pipelineStage
+	MachineCount            int32 `json:"machineCount"`
+	UpdatedMachineCount     int32 `json:"updatedMachineCount"`
+	UpdatingMachineCount    int32 `json:"updatingMachineCount"`
+	DegradedMachineCount    int32 `json:"degradedMachineCount"`
+	UnavailableMachineCount int32 `json:"unavailableMachineCount"`
+	// UpdatingNodes are the names of the nodes which are being updated.
+	UpdatingNodes []string `json:"updatingNodes,omitempty"`
+	// DegradedNodes are the names of the nodes which failed to update.
+	DegradedNodes []string `json:"degradedNodes,omitempty"`
+}
+
+// getPipelineName returns the name of the pipeline ConfigMap of the pool.
+func getPipelineName(pool *mcfgv1.MachineConfigPool) string {
+	return fmt.Sprintf("%s-pipeline", pool.Name)
+}
+
+// getConditionMessage returns the message of the given condition, if it is set.
+func getConditionMessage(status mcfgv1.MachineConfigPoolStatus, condType mcfgv1.MachineConfigPoolConditionType) string {
+	cond := apihelpers.GetMachineConfigPoolCondition(status, condType)
+	if cond == nil {
+		return ""
+	}
+
+	if cond.Message == "" {
+		return cond.Reason
+	}
+
+	return cond.Message
+}
+
+func getPipelineRenderStage(pool *mcfgv1.MachineConfigPool) pipelineRenderStage {
+	stage := pipelineRenderStage{
+		RenderedConfig: pool.Spec.Configuration.Name,
+	}
+
+	for _, src := range pool.Spec.Configuration.Source {
+		if src.Kind == "MachineConfig" {
+			stage.MachineConfigs = append(stage.MachineConfigs, src.Name)
+		}
+	}
+
+	switch {
+	case apihelpers.IsMachineConfigPoolConditionTrue(pool.Status.Conditions, mcfgv1.MachineConfigPoolRenderDegraded):
+		stage.State = pipelineStageFailed
+		stage.Message = getConditionMessage(pool.Status, mcfgv1.MachineConfigPoolRenderDegraded)
+	case stage.RenderedConfig == "":
+		stage.State = pipelineStagePending
+	default:
+		stage.State = pipelineStageSucceeded
+	}
+
+	return stage
+}
+
+func getPipelineBuildStage(pool *mcfgv1.MachineConfigPool) *pipelineBuildStage {
+	stage := &pipelineBuildStage{}
+
+	for _, src := range pool.Spec.Configuration.Source {
+		if src.Kind == "Pod" || src.Kind == "Build" {
+			stage.Name = src.Name
+			stage.Kind = src.Kind
+		}
+	}
+
+	lps := ctrlcommon.NewLayeredPoolState(pool)
+
+	switch {
+	case lps.IsBuildFailure():
+		stage.State = pipelineStageFailed
+		stage.Message = getConditionMessage(pool.Status, mcfgv1.MachineConfigPoolBuildFailed)
+	case lps.IsBuilding():
+		stage.State = pipelineStageInProgress
+		stage.Message = getConditionMessage(pool.Status, mcfgv1.MachineConfigPoolBuilding)
+	case lps.IsBuildPending():
+		stage.State = pipelineStagePending
+		stage.Message = getConditionMessage(pool.Status, mcfgv1.MachineConfigPoolBuildPending)
+	case lps.IsBuildSuccess():
+		stage.State = pipelineStageSucceeded
+		stage.Message = getConditionMessage(pool.Status, mcfgv1.MachineConfigPoolBuildSuccess)
+	default:
+		stage.State = pipelineStagePending
+		stage.Message = "Waiting for the build to be started"
+	}
+
+	return stage
+}
+
+// getPipelineImageStage describes the OS image of the pool, which is the built image for layered
+// pools and the OS image of the rendered MachineConfig otherwise.
+func getPipelineImageStage(pool *mcfgv1.MachineConfigPool, osImageURL string) pipelineImageStage {
+	stage := pipelineImageStage{}
+
+	if lps := ctrlcommon.NewLayeredPoolState(pool); lps.IsLayered() {
+		if !lps.HasOSImage() {
+			stage.State = pipelineStagePending
+			stage.Message = "Waiting for the image to be built"
+			return stage
+		}
+
+		stage.Pullspec = lps.GetOSImage()
+	} else {
+		if osImageURL == "" {
+			stage.State = pipelineStagePending
+			return stage
+		}
+
+		stage.Pullspec = osImageURL
+	}
+
+	stage.State = pipelineStageSucceeded
+
+	if _, digest, ok := strings.Cut(stage.Pullspec, "@"); ok {
+		stage.Digest = digest
+	}
+
+	return stage
+}
+
+// getPipelineRolloutStage describes how far the update of the nodes to the pool's config has
+// progressed, given the pool's newly calculated status.
+func getPipelineRolloutStage(pool *mcfgv1.MachineConfigPool, nodes []*corev1.Node, status *mcfgv1.MachineConfigPoolStatus) pipelineRolloutStage {
+	stage := pipelineRolloutStage{
+		MachineCount:            status.MachineCount,
+		UpdatedMachineCount:     status.UpdatedMachineCount,
+		DegradedMachineCount:    status.DegradedMachineCount,
+		UnavailableMachineCount: status.UnavailableMachineCount,
+		DegradedNodes:           getNamesFromNodes(getDegradedMachines(nodes)),
+	}
+
+	for _, node := range nodes {
+		lns := ctrlcommon.NewLayeredNodeState(node)
+		if lns.IsDesiredEqualToPool(pool) && !lns.IsDoneAt(pool) {
+			stage.UpdatingNodes = append(stage.UpdatingNodes, node.Name)
+		}
+	}
+
+	stage.UpdatingMachineCount = int32(len(stage.UpdatingNodes))
+
+	lps := ctrlcommon.NewLayeredPoolState(pool)
+
+	switch {
+	case stage.UpdatedMachineCount == stage.MachineCount && stage.UnavailableMachineCount == 0:
+		stage.State = pipelineStageSucceeded
+	case stage.DegradedMachineCount > 0:
+		stage.State = pipelineStageFailed
+		stage.Message = getConditionMessage(*status, mcfgv1.MachineConfigPoolNodeDegraded)
+	case pool.Spec.Paused:
+		stage.State = pipelineStagePaused
+		stage.Message = "Pool is paused"
+	case lps.IsLayered() && !lps.HasOSImage():
+		stage.State = pipelineStagePending
+		stage.Message = "Waiting for the image to be built"
+	case stage.UpdatingMachineCount > 0:
+		stage.State = pipelineStageInProgress
+	default:
+		stage.State = pipelineStagePending
+		stage.Message = "Waiting for nodes to be selected for an update"
+	}
+
+	return stage
+}
+
+// getPoolPipeline assembles the pipeline of the pool from the pool, its nodes, its newly calculated
+// status and the OS image of its rendered MachineConfig.
+func getPoolPipeline(pool *mcfgv1.MachineConfigPool, nodes []*corev1.Node, status *mcfgv1.MachineConfigPoolStatus, osImageURL string) *poolPipeline {
+	pipeline := &poolPipeline{
+		Pool:    pool.Name,
+		Render:  getPipelineRenderStage(pool),
+		Image:   getPipelineImageStage(pool, osImageURL),
+		Rollout: getPipelineRolloutStage(pool, nodes, status),
+	}
+
+	if ctrlcommon.IsLayeredPool(pool) {
+		pipeline.Build = getPipelineBuildStage(pool)
+	}
+
+	return pipeline
+}
+
+// syncPipeline publishes the pipeline of the pool in its pipeline ConfigMap. The ConfigMap is owned
+// by the pool so that it is garbage collected along with it.
+func (ctrl *Controller) syncPipeline(pool *mcfgv1.MachineConfigPool, nodes []*corev1.Node, status *mcfgv1.MachineConfigPoolStatus) error {
+	osImageURL := ""
+	if pool.Spec.Configuration.Name != "" {
+		mc, err := ctrl.mcLister.Get(pool.Spec.Configuration.Name)
+		if err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+		if mc != nil {
+			osImageURL = mc.Spec.OSImageURL
+		}
+	}
+
+	out, err := json.Marshal(getPoolPipeline(pool, nodes, status, osImageURL))
+	if err != nil {
+		return err
+	}
+
+	name := getPipelineName(pool)
+
+	current, err := ctrl.cmLister.ConfigMaps(ctrlcommon.MCONamespace).Get(name)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+
+	if current != nil && current.Data[pipelineKey] == string(out) {
+		return nil
+	}
+
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: ctrlcommon.MCONamespace,
+			Labels: map[string]string{
+				ctrlcommon.PipelineLabelKey: pool.Name,
+			},
+			OwnerReferences: []metav1.OwnerReference{
+				*metav1.NewControllerRef(pool, mcfgv1.SchemeGroupVersion.WithKind("MachineConfigPool")),
+			},
+		},
+		Data: map[string]string{
+			pipelineKey: string(out),
+		},
+	}
+
+	if current == nil {
+		_, err = ctrl.kubeClient.CoreV1().ConfigMaps(ctrlcommon.MCONamespace).Create(context.TODO(), cm, metav1.CreateOptions{})
+	} else {
+		cm.ResourceVersion = current.ResourceVersion
+		_, err = ctrl.kubeClient.CoreV1().ConfigMaps(ctrlcommon.MCONamespace).Update(context.TODO(), cm, metav1.UpdateOptions{})
+	}
+	if err != nil {
+		return fmt.Errorf("could not write pipeline %s: %w", name, err)
+	}
+
+	klog.V(4).Infof("Updated pipeline %s of pool %s", name, pool.Name)
+	return nil
+}
@@ -0,0 +1,151 @@
+package node
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	daemonconsts "github.com/openshift/machine-config-operator/pkg/daemon/constants"
+	"github.com/openshift/machine-config-operator/test/helpers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corelisterv1 "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestGetPoolPipeline(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Rolling out", func(t *testing.T) {
+		t.Parallel()
+
+		pool := helpers.NewMachineConfigPoolBuilder("worker").WithMachineConfig(machineConfigV1).MachineConfigPool()
+		pool.Spec.Configuration.Source = []corev1.ObjectReference{{Kind: "MachineConfig", Name: "00-worker"}}
+
+		nodes := []*corev1.Node{
+			newNodeWithReady("node-0", machineConfigV1, machineConfigV1, corev1.ConditionTrue),
+			newNodeWithReady("node-1", machineConfigV0, machineConfigV1, corev1.ConditionTrue),
+			newNodeWithReady("node-2", machineConfigV0, machineConfigV0, corev1.ConditionTrue),
+		}
+		status := calculateStatus(nil, pool, nodes)
+
+		pipeline := getPoolPipeline(pool, nodes, &status, imageV1)
+
+		assert.Equal(t, "worker", pipeline.Pool)
+		assert.Equal(t, pipelineStageSucceeded, pipeline.Render.State)
+		assert.Equal(t, machineConfigV1, pipeline.Render.RenderedConfig)
+		assert.Equal(t, []string{"00-worker"}, pipeline.Render.MachineConfigs)
+		assert.Nil(t, pipeline.Build)
+		assert.Equal(t, pipelineStageSucceeded, pipeline.Image.State)
+		assert.Equal(t, imageV1, pipeline.Image.Pullspec)
+		assert.Equal(t, "sha256:2222222222222222222222222222222222222222222222222222222222222222", pipeline.Image.Digest)
+		assert.Equal(t, pipelineStageInProgress, pipeline.Rollout.State)
+		assert.Equal(t, int32(3), pipeline.Rollout.MachineCount)
+		assert.Equal(t, int32(1), pipeline.Rollout.UpdatedMachineCount)
+		assert.Equal(t, int32(1), pipeline.Rollout.UpdatingMachineCount)
+		assert.Equal(t, []string{"node-1"}, pipeline.Rollout.UpdatingNodes)
+	})
+
+	t.Run("Degraded node", func(t *testing.T) {
+		t.Parallel()
+
+		pool := helpers.NewMachineConfigPoolBuilder("worker").WithMachineConfig(machineConfigV1).MachineConfigPool()
+		nodes := []*corev1.Node{
+			newNodeWithDaemonState("node-0", machineConfigV0, machineConfigV1, daemonconsts.MachineConfigDaemonStateDegraded),
+		}
+		status := calculateStatus(nil, pool, nodes)
+
+		pipeline := getPoolPipeline(pool, nodes, &status, "")
+
+		assert.Equal(t, pipelineStagePending, pipeline.Image.State)
+		assert.Equal(t, pipelineStageFailed, pipeline.Rollout.State)
+		assert.Equal(t, []string{"node-0"}, pipeline.Rollout.DegradedNodes)
+	})
+
+	t.Run("Layered pool building", func(t *testing.T) {
+		t.Parallel()
+
+		pool := helpers.NewMachineConfigPoolBuilder("worker").
+			WithMachineConfig(machineConfigV1).
+			WithLayeringEnabled().
+			WithCondition(mcfgv1.MachineConfigPoolBuilding, corev1.ConditionTrue, "", "Building image").
+			MachineConfigPool()
+		pool.Spec.Configuration.Source = []corev1.ObjectReference{{Kind: "Pod", Name: "build-rendered-machine-config-v1"}}
+
+		nodes := []*corev1.Node{
+			newNodeWithReady("node-0", machineConfigV0, machineConfigV0, corev1.ConditionTrue),
+		}
+		status := calculateStatus(nil, pool, nodes)
+
+		pipeline := getPoolPipeline(pool, nodes, &status, imageV0)
+
+		require.NotNil(t, pipeline.Build)
+		assert.Equal(t, pipelineStageInProgress, pipeline.Build.State)
+		assert.Equal(t, "Building image", pipeline.Build.Message)
+		assert.Equal(t, "Pod", pipeline.Build.Kind)
+		assert.Equal(t, "build-rendered-machine-config-v1", pipeline.Build.Name)
+		assert.Equal(t, pipelineStagePending, pipeline.Image.State)
+		assert.Empty(t, pipeline.Image.Pullspec)
+		assert.Equal(t, pipelineStagePending, pipeline.Rollout.State)
+	})
+
+	t.Run("Paused", func(t *testing.T) {
+		t.Parallel()
+
+		pool := helpers.NewMachineConfigPoolBuilder("worker").WithMachineConfig(machineConfigV1).WithPaused().MachineConfigPool()
+		nodes := []*corev1.Node{
+			newNodeWithReady("node-0", machineConfigV0, machineConfigV0, corev1.ConditionTrue),
+		}
+		status := calculateStatus(nil, pool, nodes)
+
+		pipeline := getPoolPipeline(pool, nodes, &status, imageV1)
+
+		assert.Equal(t, pipelineStagePaused, pipeline.Rollout.State)
+	})
+}
+
+func TestSyncPipeline(t *testing.T) {
+	f := newFixture(t)
+	f.mcLister = append(f.mcLister, helpers.NewMachineConfig(machineConfigV1, nil, imageV1, nil))
+
+	pool := helpers.NewMachineConfigPoolBuilder("worker").WithMachineConfig(machineConfigV1).MachineConfigPool()
+	nodes := []*corev1.Node{
+		newNodeWithReady("node-0", machineConfigV1, machineConfigV1, corev1.ConditionTrue),
+	}
+	status := calculateStatus(nil, pool, nodes)
+
+	c := f.newController()
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	c.cmLister = corelisterv1.NewConfigMapLister(indexer)
+
+	require.NoError(t, c.syncPipeline(pool, nodes, &status))
+
+	cm, err := f.kubeclient.CoreV1().ConfigMaps(ctrlcommon.MCONamespace).Get(context.TODO(), "worker-pipeline", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "worker", cm.Labels[ctrlcommon.PipelineLabelKey])
+	require.Len(t, cm.OwnerReferences, 1)
+	assert.Equal(t, "MachineConfigPool", cm.OwnerReferences[0].Kind)
+
+	pipeline := &poolPipeline{}
+	require.NoError(t, json.Unmarshal([]byte(cm.Data[pipelineKey]), pipeline))
+	assert.Equal(t, imageV1, pipeline.Image.Pullspec)
+	assert.Equal(t, pipelineStageSucceeded, pipeline.Rollout.State)
+
+	// An unchanged pipeline is not written again.
+	require.NoError(t, indexer.Add(cm))
+	f.kubeclient.ClearActions()
+	require.NoError(t, c.syncPipeline(pool, nodes, &status))
+	assert.Empty(t, f.kubeclient.Actions())
+
+	// A changed pipeline updates the ConfigMap.
+	nodes = append(nodes, newNodeWithReady("node-1", machineConfigV0, machineConfigV1, corev1.ConditionTrue))
+	status = calculateStatus(nil, pool, nodes)
+	require.NoError(t, c.syncPipeline(pool, nodes, &status))
+	actions := f.kubeclient.Actions()
+	require.Len(t, actions, 1)
+	assert.True(t, actions[0].Matches("update", "configmaps"))
+}
@@ -0,0 +1,153 @@
+package node
+
+import (
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	"github.com/openshift/machine-config-operator/internal"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	daemonconsts "github.com/openshift/machine-config-operator/pkg/daemon/constants"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+)
+
+// poolNodeAnnotation is a node annotation through which the node controller hands a setting of the
+// pool to the MCD on each of the pool's nodes.
+type poolNodeAnnotation struct {
+	// key is the node annotation.
+	key string
+	// what describes the setting in log messages.
+	what string
+	// reportedBy is the pool condition which reports an invalid setting, if any.
+	reportedBy mcfgv1.MachineConfigPoolConditionType
+	// desired returns the value of the annotation, or "" if it is to be removed. Nodes keep the
+	// value they were given before when the pool's setting is invalid.
+	desired func(*mcfgv1.MachineConfigPool) (string, error)
+}
+
+// getPoolNodeAnnotations returns all of the node annotations derived from the pool's settings.
+func getPoolNodeAnnotations() []poolNodeAnnotation {
+	return []poolNodeAnnotation{
+		{
+			key:        daemonconsts.UnmanagedPathsAnnotationKey,
+			what:       "unmanaged paths",
+			reportedBy: MachineConfigPoolUnmanagedPaths,
+			desired:    getUnmanagedPathsNodeAnnotation,
+		},
+		{
+			key:        daemonconsts.MaintenanceWindowsAnnotationKey,
+			what:       "maintenance windows",
+			reportedBy: MachineConfigPoolMaintenanceWindows,
+			desired:    getMaintenanceWindowsNodeAnnotation,
+		},
+		{
+			key:        daemonconsts.PreflightChecksAnnotationKey,
+			what:       "preflight checks",
+			reportedBy: MachineConfigPoolPreflightChecksFailed,
+			desired:    getPreflightChecksNodeAnnotation,
+		},
+		{
+			key:        daemonconsts.PostUpdateVerificationAnnotationKey,
+			what:       "post-update verification",
+			reportedBy: MachineConfigPoolPostUpdateVerificationFailed,
+			desired:    getPostUpdateVerificationNodeAnnotation,
+		},
+		{
+			key:     daemonconsts.ConfigDriftRemediationAnnotationKey,
+			what:    "config drift remediation",
+			desired: getConfigDriftRemediationNodeAnnotation,
+		},
+		{
+			key:     daemonconsts.RebootStrategyAnnotationKey,
+			what:    "reboot strategy",
+			desired: getRebootStrategy,
+		},
+		{
+			key:        daemonconsts.DisruptionPolicyAnnotationKey,
+			what:       "disruption policy",
+			reportedBy: MachineConfigPoolDisruptionPolicy,
+			desired:    getDisruptionPolicyNodeAnnotation,
+		},
+		{
+			key:        daemonconsts.SwapAnnotationKey,
+			what:       "swap",
+			reportedBy: MachineConfigPoolSwap,
+			desired:    getSwapNodeAnnotation,
+		},
+		{
+			key:        daemonconsts.StagedUpdatesAnnotationKey,
+			what:       "staged updates",
+			reportedBy: MachineConfigPoolStagedUpdates,
+			desired:    getStagedUpdatesNodeAnnotation,
+		},
+	}
+}
+
+// setPoolNodeAnnotations hands the pool's settings and config generations to the MCD on each of its
+// nodes. All of the annotations which changed on a node are written at once, so that each node is
+// updated at most once per sync.
+func (ctrl *Controller) setPoolNodeAnnotations(pool *mcfgv1.MachineConfigPool, nodes []*corev1.Node) error {
+	annotations := getPoolNodeAnnotations()
+
+	desired := map[string]string{}
+	for _, annotation := range annotations {
+		val, err := annotation.desired(pool)
+		if err != nil {
+			if annotation.reportedBy != "" {
+				klog.V(4).Infof("Not updating %s of nodes in pool %s: %v", annotation.what, pool.Name, err)
+			} else {
+				klog.Warningf("Not updating %s of nodes in pool %s: %v", annotation.what, pool.Name, err)
+			}
+			continue
+		}
+		desired[annotation.key] = val
+	}
+
+	for _, node := range nodes {
+		// An empty value removes the annotation.
+		changes := map[string]string{}
+		for _, annotation := range annotations {
+			val, ok := desired[annotation.key]
+			if !ok {
+				continue
+			}
+
+			current, exists := node.Annotations[annotation.key]
+			if current == val && (exists || val == "") {
+				continue
+			}
+
+			changes[annotation.key] = val
+			klog.Infof("Updating %s of node %s from %q to %q", annotation.what, node.Name, current, val)
+		}
+
+		generations := ctrlcommon.GetConfigGenerationAnnotationUpdates(node, pool)
+		for k, v := range generations {
+			changes[k] = v
+		}
+
+		if len(changes) == 0 {
+			continue
+		}
+
+		_, err := internal.UpdateNodeRetry(ctrl.kubeClient.CoreV1().Nodes(), ctrl.nodeLister, node.Name, func(node *corev1.Node) {
+			if node.Annotations == nil {
+				node.Annotations = map[string]string{}
+			}
+			for k, v := range changes {
+				if v == "" {
+					delete(node.Annotations, k)
+					continue
+				}
+				node.Annotations[k] = v
+			}
+		})
+		if err != nil {
+			return err
+		}
+
+		if generations != nil {
+			klog.V(4).Infof("Updated config generation annotations of node %s: %v", node.Name, generations)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,85 @@
+package node
+
+import (
+	"fmt"
+	"strings"
+
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	"github.com/openshift/machine-config-operator/pkg/apihelpers"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// MachineConfigPoolPostUpdateVerificationFailed is true when the MCD rolled nodes of the pool back
+// to their previous config because the post-update verification failed on them, or when the pool's
+// post-update verification is invalid.
+const MachineConfigPoolPostUpdateVerificationFailed mcfgv1.MachineConfigPoolConditionType = "PostUpdateVerificationFailed"
+
+const (
+	// postUpdateVerificationFailedReason is the reason of the PostUpdateVerificationFailed
+	// condition when nodes were rolled back.
+	postUpdateVerificationFailedReason = "NodesRolledBack"
+
+	// invalidPostUpdateVerificationReason is the reason of the PostUpdateVerificationFailed
+	// condition when the pool's post-update verification cannot be parsed.
+	invalidPostUpdateVerificationReason = "InvalidPostUpdateVerification"
+)
+
+// getPostUpdateVerification returns the probes which must pass on the pool's nodes after they
+// rebooted into a new config, or nil if there are none.
+func getPostUpdateVerification(pool *mcfgv1.MachineConfigPool) (*ctrlcommon.PostUpdateVerification, error) {
+	val := pool.Annotations[ctrlcommon.PostUpdateVerificationAnnotationKey]
+
+	verification, err := ctrlcommon.ParsePostUpdateVerification(val)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s annotation %q: %w", ctrlcommon.PostUpdateVerificationAnnotationKey, val, err)
+	}
+
+	return verification, nil
+}
+
+// getPostUpdateVerificationNodeAnnotation returns the value of the node annotation which hands the pool's post-update verification to
+// the MCD, or "" if the annotation is to be removed.
+func getPostUpdateVerificationNodeAnnotation(pool *mcfgv1.MachineConfigPool) (string, error) {
+	verification, err := getPostUpdateVerification(pool)
+	if err != nil || verification == nil {
+		return "", err
+	}
+
+	return verification.String(), nil
+}
+
+// setPostUpdateVerificationCondition reports the nodes which the MCD rolled back because the
+// pool's post-update verification failed on them, along with the output of the failed probes.
+func setPostUpdateVerificationCondition(pool *mcfgv1.MachineConfigPool, nodes []*corev1.Node, status *mcfgv1.MachineConfigPoolStatus) {
+	verification, err := getPostUpdateVerification(pool)
+	if err != nil {
+		cond := apihelpers.NewMachineConfigPoolCondition(MachineConfigPoolPostUpdateVerificationFailed, corev1.ConditionTrue, invalidPostUpdateVerificationReason, err.Error())
+		apihelpers.SetMachineConfigPoolCondition(status, *cond)
+		return
+	}
+
+	rolledBack := []string{}
+	for _, node := range nodes {
+		if ctrlcommon.HasRolledBackUpdate(node) {
+			failure := ctrlcommon.GetPostUpdateVerificationFailure(node)
+			rolledBack = append(rolledBack, fmt.Sprintf("%s from %s to %s (%s)", node.Name, failure.Config, failure.RolledBackTo, strings.Join(failure.Output, "; ")))
+		}
+	}
+
+	// Nodes rolled back before the verification was removed are still reported.
+	if verification == nil && len(rolledBack) == 0 {
+		apihelpers.RemoveMachineConfigPoolCondition(status, MachineConfigPoolPostUpdateVerificationFailed)
+		return
+	}
+
+	if len(rolledBack) == 0 {
+		cond := apihelpers.NewMachineConfigPoolCondition(MachineConfigPoolPostUpdateVerificationFailed, corev1.ConditionFalse, "", "")
+		apihelpers.SetMachineConfigPoolCondition(status, *cond)
+		return
+	}
+
+	msg := fmt.Sprintf("Post-update verification failed on %d nodes, which were rolled back: %s", len(rolledBack), strings.Join(rolledBack, ", "))
+	cond := apihelpers.NewMachineConfigPoolCondition(MachineConfigPoolPostUpdateVerificationFailed, corev1.ConditionTrue, postUpdateVerificationFailedReason, msg)
+	apihelpers.SetMachineConfigPoolCondition(status, *cond)
+}
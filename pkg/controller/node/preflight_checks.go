@@ -0,0 +1,91 @@
+package node
+
+import (
+	"fmt"
+	"strings"
+
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	"github.com/openshift/machine-config-operator/pkg/apihelpers"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// MachineConfigPoolPreflightChecksFailed is true when the MCD skipped updating nodes of the pool
+// because its preflight checks failed on them, or when the pool's preflight checks are invalid.
+const MachineConfigPoolPreflightChecksFailed mcfgv1.MachineConfigPoolConditionType = "PreflightChecksFailed"
+
+const (
+	// preflightChecksFailedReason is the reason of the PreflightChecksFailed condition when nodes
+	// were skipped.
+	preflightChecksFailedReason = "NodesSkipped"
+
+	// invalidPreflightChecksReason is the reason of the PreflightChecksFailed condition when the
+	// pool's preflight checks cannot be parsed.
+	invalidPreflightChecksReason = "InvalidPreflightChecks"
+)
+
+// getPreflightChecks returns the checks which must pass on the pool's nodes before they are
+// updated, or nil if there are none. The free disk space the pool requires is checked along with
+// them.
+func getPreflightChecks(pool *mcfgv1.MachineConfigPool) (*ctrlcommon.PreflightChecks, error) {
+	val := pool.Annotations[ctrlcommon.PreflightChecksAnnotationKey]
+	if val == "" {
+		return nil, nil
+	}
+
+	if _, err := getMinFreeDisk(pool); err != nil {
+		return nil, err
+	}
+
+	checks, err := ctrlcommon.ParsePoolPreflightChecks(val, pool.Annotations[ctrlcommon.MinFreeDiskAnnotationKey])
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s annotation %q: %w", ctrlcommon.PreflightChecksAnnotationKey, val, err)
+	}
+
+	return checks, nil
+}
+
+// getPreflightChecksNodeAnnotation returns the value of the node annotation which hands the pool's preflight checks to
+// the MCD, or "" if the annotation is to be removed.
+func getPreflightChecksNodeAnnotation(pool *mcfgv1.MachineConfigPool) (string, error) {
+	checks, err := getPreflightChecks(pool)
+	if err != nil || checks == nil {
+		return "", err
+	}
+
+	return checks.String(), nil
+}
+
+// setPreflightChecksCondition reports the nodes which the MCD skipped because the pool's preflight
+// checks failed on them, along with why.
+func setPreflightChecksCondition(pool *mcfgv1.MachineConfigPool, nodes []*corev1.Node, status *mcfgv1.MachineConfigPoolStatus) {
+	checks, err := getPreflightChecks(pool)
+	if err != nil {
+		cond := apihelpers.NewMachineConfigPoolCondition(MachineConfigPoolPreflightChecksFailed, corev1.ConditionTrue, invalidPreflightChecksReason, err.Error())
+		apihelpers.SetMachineConfigPoolCondition(status, *cond)
+		return
+	}
+
+	if checks == nil {
+		apihelpers.RemoveMachineConfigPoolCondition(status, MachineConfigPoolPreflightChecksFailed)
+		return
+	}
+
+	skipped := []string{}
+	for _, node := range nodes {
+		if ctrlcommon.HasFailedPreflightChecks(node) {
+			failure := ctrlcommon.GetPreflightCheckFailure(node)
+			skipped = append(skipped, fmt.Sprintf("%s (%s)", node.Name, strings.Join(failure.Failures, "; ")))
+		}
+	}
+
+	if len(skipped) == 0 {
+		cond := apihelpers.NewMachineConfigPoolCondition(MachineConfigPoolPreflightChecksFailed, corev1.ConditionFalse, "", "")
+		apihelpers.SetMachineConfigPoolCondition(status, *cond)
+		return
+	}
+
+	msg := fmt.Sprintf("Preflight checks failed on %d nodes, which were not updated and will be retried: %s", len(skipped), strings.Join(skipped, ", "))
+	cond := apihelpers.NewMachineConfigPoolCondition(MachineConfigPoolPreflightChecksFailed, corev1.ConditionTrue, preflightChecksFailedReason, msg)
+	apihelpers.SetMachineConfigPoolCondition(status, *cond)
+}
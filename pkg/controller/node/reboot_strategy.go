@@ -0,0 +1,22 @@
+package node
+
+import (
+	"fmt"
+
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+)
+
+// getRebootStrategy returns how the MCD reboots the pool's nodes on userspace-only updates, or an
+// empty string if it fully reboots them.
+func getRebootStrategy(pool *mcfgv1.MachineConfigPool) (string, error) {
+	switch strategy := pool.Annotations[ctrlcommon.RebootStrategyAnnotationKey]; strategy {
+	case ctrlcommon.RebootStrategyKexec, ctrlcommon.RebootStrategySoftReboot:
+		return strategy, nil
+	case "", ctrlcommon.RebootStrategyReboot:
+		return "", nil
+	default:
+		return "", fmt.Errorf("invalid %s annotation %q: must be %q, %q or %q", ctrlcommon.RebootStrategyAnnotationKey, strategy,
+			ctrlcommon.RebootStrategyReboot, ctrlcommon.RebootStrategyKexec, ctrlcommon.RebootStrategySoftReboot)
+	}
+}
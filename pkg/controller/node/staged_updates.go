@@ -0,0 +1,184 @@
+package node
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	"github.com/openshift/machine-config-operator/internal"
+	"github.com/openshift/machine-config-operator/pkg/apihelpers"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	daemonconsts "github.com/openshift/machine-config-operator/pkg/daemon/constants"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// MachineConfigPoolStagedUpdates describes what finalizes the updates staged on the pool's nodes
+// in its message, along with the nodes whose staged update waits to be finalized. It is false
+// when the pool's staged updates are invalid, in which case the message describes the problem
+// instead.
+const MachineConfigPoolStagedUpdates mcfgv1.MachineConfigPoolConditionType = "StagedUpdates"
+
+// invalidStagedUpdatesReason is the reason of the StagedUpdates condition when the pool's staged
+// updates cannot be parsed.
+const invalidStagedUpdatesReason = "InvalidStagedUpdates"
+
+// getStagedUpdates returns how updates which reboot the pool's nodes are staged, or nil if they
+// are applied right away.
+func getStagedUpdates(pool *mcfgv1.MachineConfigPool) (*ctrlcommon.StagedUpdatesConfig, error) {
+	val := pool.Annotations[ctrlcommon.StagedUpdatesAnnotationKey]
+
+	config, err := ctrlcommon.ParseStagedUpdatesConfig(val)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s annotation %q: %w", ctrlcommon.StagedUpdatesAnnotationKey, val, err)
+	}
+
+	return config, nil
+}
+
+// hasPendingFinalizeRequest returns whether the MCD has not handled the finalize request of the
+// node yet.
+func hasPendingFinalizeRequest(node *corev1.Node) bool {
+	request := node.Annotations[daemonconsts.FinalizeRequestAnnotationKey]
+	return request != "" && request != node.Annotations[daemonconsts.LastAppliedFinalizeRequestAnnotationKey]
+}
+
+// getStagedNodes returns the nodes of the pool whose update to the pool's config is staged.
+func getStagedNodes(pool *mcfgv1.MachineConfigPool, nodes []*corev1.Node) []*corev1.Node {
+	staged := []*corev1.Node{}
+	for _, node := range nodes {
+		if ctrlcommon.IsUpdateStaged(node) && node.Annotations[daemonconsts.DesiredMachineConfigAnnotationKey] == pool.Spec.Configuration.Name {
+			staged = append(staged, node)
+		}
+	}
+
+	return staged
+}
+
+// getStagedUpdatesNodeAnnotation returns the value of the node annotation which hands the pool's staged updates to
+// the MCD, or "" if the annotation is to be removed.
+func getStagedUpdatesNodeAnnotation(pool *mcfgv1.MachineConfigPool) (string, error) {
+	config, err := getStagedUpdates(pool)
+	if err != nil || config == nil {
+		return "", err
+	}
+
+	return config.String(), nil
+}
+
+// requestStagedUpdateFinalizations asks the MCD to finalize the updates staged on the pool's
+// nodes while one of the pool's maintenance windows is open, up to the given capacity, which is
+// what is left of maxUnavailable. Without maintenance windows, staged updates are only finalized
+// on request or when the nodes reboot.
+func (ctrl *Controller) requestStagedUpdateFinalizations(pool *mcfgv1.MachineConfigPool, nodes []*corev1.Node, capacity uint) error {
+	// Invalid staged updates or maintenance windows are reported by their conditions.
+	config, err := getStagedUpdates(pool)
+	if err != nil || config == nil {
+		return nil
+	}
+
+	windows, err := getMaintenanceWindows(pool)
+	if err != nil || len(windows) == 0 {
+		return nil
+	}
+
+	pending := uint(0)
+	due := []*corev1.Node{}
+	for _, node := range getStagedNodes(pool, nodes) {
+		if hasPendingFinalizeRequest(node) {
+			pending++
+			continue
+		}
+		// Held nodes keep their staged update until the hold is lifted.
+		if ctrlcommon.IsNodeHeld(node) {
+			continue
+		}
+		due = append(due, node)
+	}
+
+	if len(due) == 0 {
+		return nil
+	}
+
+	now := time.Now()
+
+	if !ctrlcommon.InMaintenanceWindow(windows, now) {
+		until := ctrlcommon.UntilMaintenanceWindow(windows, now)
+		ctrl.logPool(pool, "%d nodes have a staged update, waiting %s for the next maintenance window", len(due), until.Round(time.Second))
+		ctrl.enqueueAfter(pool, until)
+		return nil
+	}
+
+	// Requested finalizations which the MCD has not started yet do not count as unavailable.
+	if pending >= capacity {
+		return nil
+	}
+
+	// The updates which were staged first are finalized first.
+	sort.SliceStable(due, func(i, j int) bool {
+		return ctrlcommon.GetStagedUpdate(due[i]).Time < ctrlcommon.GetStagedUpdate(due[j]).Time
+	})
+
+	if uint(len(due)) > capacity-pending {
+		due = due[:capacity-pending]
+	}
+
+	request := now.UTC().Format(time.RFC3339)
+
+	for _, node := range due {
+		_, err := internal.UpdateNodeRetry(ctrl.kubeClient.CoreV1().Nodes(), ctrl.nodeLister, node.Name, func(node *corev1.Node) {
+			node.Annotations[daemonconsts.FinalizeRequestAnnotationKey] = request
+		})
+		if err != nil {
+			return fmt.Errorf("could not request finalization of the update staged on node %s: %w", node.Name, err)
+		}
+
+		ctrl.logPool(pool, "Requested finalization of the update to %s staged on node %s", pool.Spec.Configuration.Name, node.Name)
+		ctrl.eventRecorder.Eventf(pool, corev1.EventTypeNormal, "StagedUpdateFinalization", "Requested finalization of the update to %s staged on node %s", pool.Spec.Configuration.Name, node.Name)
+	}
+
+	return nil
+}
+
+// setStagedUpdatesCondition reports what finalizes the updates staged on the pool's nodes along
+// with the nodes whose staged update waits to be finalized.
+func setStagedUpdatesCondition(pool *mcfgv1.MachineConfigPool, nodes []*corev1.Node, status *mcfgv1.MachineConfigPoolStatus) {
+	config, err := getStagedUpdates(pool)
+	if err != nil {
+		cond := apihelpers.NewMachineConfigPoolCondition(MachineConfigPoolStagedUpdates, corev1.ConditionFalse, invalidStagedUpdatesReason, err.Error())
+		apihelpers.SetMachineConfigPoolCondition(status, *cond)
+		return
+	}
+
+	if config == nil {
+		apihelpers.RemoveMachineConfigPoolCondition(status, MachineConfigPoolStagedUpdates)
+		return
+	}
+
+	finalizedBy := []string{"on request"}
+	if windows, err := getMaintenanceWindows(pool); err == nil && len(windows) != 0 {
+		finalizedBy = append(finalizedBy, "within maintenance windows")
+	}
+	if config.Finalization == ctrlcommon.StagedUpdateFinalizationOnReboot {
+		finalizedBy = append(finalizedBy, "when the node reboots")
+	}
+
+	staged := []string{}
+	for _, node := range getStagedNodes(pool, nodes) {
+		staged = append(staged, node.Name)
+	}
+
+	last := len(finalizedBy) - 1
+	if last > 0 {
+		finalizedBy = []string{strings.Join(finalizedBy[:last], ", ") + " or " + finalizedBy[last]}
+	}
+
+	msg := fmt.Sprintf("Updates which reboot nodes are staged and finalized %s", finalizedBy[0])
+	if len(staged) != 0 {
+		msg = fmt.Sprintf("%s; waiting to be finalized on %d nodes: %s", msg, len(staged), strings.Join(staged, ", "))
+	}
+
+	cond := apihelpers.NewMachineConfigPoolCondition(MachineConfigPoolStagedUpdates, corev1.ConditionTrue, "", msg)
+	apihelpers.SetMachineConfigPoolCondition(status, *cond)
+}
@@ -2,18 +2,23 @@ package node
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"sort"
 	"strings"
+	"time"
 
 	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
 	v1 "github.com/openshift/api/machineconfiguration/v1"
 	"github.com/openshift/machine-config-operator/pkg/apihelpers"
+	"github.com/openshift/machine-config-operator/pkg/apihelpers/conditions"
 	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
 	"github.com/openshift/machine-config-operator/pkg/daemon/constants"
 	daemonconsts "github.com/openshift/machine-config-operator/pkg/daemon/constants"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/equality"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/klog/v2"
 )
 
@@ -28,6 +33,17 @@ func (ctrl *Controller) syncStatusOnly(pool *mcfgv1.MachineConfigPool) error {
 	}
 
 	newStatus := calculateStatus(cc, pool, nodes)
+	ctrl.requeueForDegradedGracePeriod(pool, &newStatus)
+	ctrl.setStaleProvisionedMachinesCondition(pool, nodes, &newStatus)
+	ctrl.setSafeModeBlockedCondition(pool, nodes, &newStatus)
+	ctrl.setComponentVersionSkewCondition(pool, nodes, &newStatus)
+	ctrl.setLowDiskNodesCondition(pool, nodes, &newStatus)
+	ctrl.setRevertingFromLayeringCondition(pool, nodes, &newStatus)
+	ctrl.setCanaryFailedCondition(pool, nodes, &newStatus)
+	ctrl.setUpdateCandidatesCondition(pool, nodes, &newStatus)
+	if err := ctrl.syncPipeline(pool, nodes, &newStatus); err != nil {
+		return fmt.Errorf("could not publish pipeline of pool %s: %w", pool.Name, err)
+	}
 	if equality.Semantic.DeepEqual(pool.Status, newStatus) {
 		return nil
 	}
@@ -118,17 +134,19 @@ func calculateStatus(cconfig *v1.ControllerConfig, pool *mcfgv1.MachineConfigPoo
 			supdating := apihelpers.NewMachineConfigPoolCondition(mcfgv1.MachineConfigPoolUpdating, corev1.ConditionFalse, "", fmt.Sprintf("Pool is paused; will not update to %s", getPoolUpdateLine(pool)))
 			apihelpers.SetMachineConfigPoolCondition(&status, *supdating)
 		} else {
-			supdating := apihelpers.NewMachineConfigPoolCondition(mcfgv1.MachineConfigPoolUpdating, corev1.ConditionTrue, "", fmt.Sprintf("All nodes are updating to %s", getPoolUpdateLine(pool)))
+			updatingMsg := fmt.Sprintf("All nodes are updating to %s", getPoolUpdateLine(pool))
+			if held := getOutdatedHeldNodes(pool, nodes); len(held) != 0 {
+				updatingMsg = fmt.Sprintf("%s, except %d held nodes", updatingMsg, len(held))
+			}
+			supdating := apihelpers.NewMachineConfigPoolCondition(mcfgv1.MachineConfigPoolUpdating, corev1.ConditionTrue, "", updatingMsg)
 			apihelpers.SetMachineConfigPoolCondition(&status, *supdating)
 		}
 	}
 
-	var nodeDegraded bool
 	for _, m := range degradedMachines {
 		klog.Infof("Degraded Machine: %v and Degraded Reason: %v", m.Name, m.Annotations[constants.MachineConfigDaemonReasonAnnotationKey])
 	}
 	if degradedMachineCount > 0 {
-		nodeDegraded = true
 		sdegraded := apihelpers.NewMachineConfigPoolCondition(mcfgv1.MachineConfigPoolNodeDegraded, corev1.ConditionTrue, fmt.Sprintf("%d nodes are reporting degraded status on sync", len(degradedMachines)), strings.Join(degradedReasons, ", "))
 		apihelpers.SetMachineConfigPoolCondition(&status, *sdegraded)
 	} else {
@@ -138,18 +156,278 @@ func calculateStatus(cconfig *v1.ControllerConfig, pool *mcfgv1.MachineConfigPoo
 
 	// here we now set the MCP Degraded field, the node_controller is the one making the call right now
 	// but we might have a dedicated controller or control loop somewhere else that understands how to
-	// set Degraded. For now, the node_controller understand NodeDegraded & RenderDegraded = Degraded.
-	renderDegraded := apihelpers.IsMachineConfigPoolConditionTrue(pool.Status.Conditions, mcfgv1.MachineConfigPoolRenderDegraded)
-	if nodeDegraded || renderDegraded {
-		sdegraded := apihelpers.NewMachineConfigPoolCondition(mcfgv1.MachineConfigPoolDegraded, corev1.ConditionTrue, "", "")
-		apihelpers.SetMachineConfigPoolCondition(&status, *sdegraded)
+	// set Degraded. For now, the node_controller understand NodeDegraded & RenderDegraded = Degraded,
+	// once they have been true for longer than the pool's grace period.
+	setDegradedConditions(pool, &status, time.Now())
+
+	setEffectiveUpdatePolicyCondition(pool, nodes, &status)
+	setUnmanagedPathsCondition(pool, nodes, &status)
+	setMaintenanceWindowsCondition(pool, nodes, &status)
+	setPreflightChecksCondition(pool, nodes, &status)
+	setPostUpdateVerificationCondition(pool, nodes, &status)
+	setDisruptionPolicyCondition(pool, nodes, &status)
+	setSwapCondition(pool, nodes, &status)
+	setStagedUpdatesCondition(pool, nodes, &status)
+	setHeldNodesCondition(pool, nodes, &status)
 
-	} else {
-		sdegraded := apihelpers.NewMachineConfigPoolCondition(mcfgv1.MachineConfigPoolDegraded, corev1.ConditionFalse, "", "")
-		apihelpers.SetMachineConfigPoolCondition(&status, *sdegraded)
+	return status
+}
+
+// setStaleProvisionedMachinesCondition flags recently provisioned machines which
+// were served an older rendered MachineConfig than the pool's.
+func (ctrl *Controller) setStaleProvisionedMachinesCondition(pool *mcfgv1.MachineConfigPool, nodes []*corev1.Node, status *mcfgv1.MachineConfigPoolStatus) {
+	mc, err := ctrl.mcLister.Get(pool.Status.Configuration.Name)
+	if err != nil {
+		klog.V(4).Infof("Could not get MachineConfig %s for pool %s: %v", pool.Status.Configuration.Name, pool.Name, err)
+		return
 	}
 
-	return status
+	staleMachines := getStaleProvisionedMachines(pool, nodes, mc.CreationTimestamp.Time, time.Now())
+	if len(staleMachines) == 0 {
+		cond := apihelpers.NewMachineConfigPoolCondition(MachineConfigPoolStaleProvisionedMachines, corev1.ConditionFalse, "", "")
+		apihelpers.SetMachineConfigPoolCondition(status, *cond)
+		return
+	}
+
+	staleConfigs := []string{}
+	for _, node := range staleMachines {
+		staleConfigs = append(staleConfigs, fmt.Sprintf("%s (%s)", node.Name, node.Annotations[daemonconsts.InitialMachineConfigAnnotationKey]))
+	}
+
+	msg := fmt.Sprintf("%d recently provisioned nodes were served an older config than %s: %s. The machine-config-server may be serving a stale config, e.g. because a load balancer routes to an outdated machine-config-server or the machine-config-server pods are not running the latest version.",
+		len(staleMachines), pool.Status.Configuration.Name, strings.Join(staleConfigs, ", "))
+
+	if !apihelpers.IsMachineConfigPoolConditionTrue(pool.Status.Conditions, MachineConfigPoolStaleProvisionedMachines) {
+		ctrl.eventRecorder.Eventf(pool, corev1.EventTypeWarning, staleProvisionedMachinesReason, msg)
+	}
+
+	cond := apihelpers.NewMachineConfigPoolCondition(MachineConfigPoolStaleProvisionedMachines, corev1.ConditionTrue, staleProvisionedMachinesReason, msg)
+	apihelpers.SetMachineConfigPoolCondition(status, *cond)
+}
+
+// setSafeModeBlockedCondition sets the SafeModeBlocked condition on the given status when the
+// pool is in safe mode, emitting an event when its update starts being deferred.
+func (ctrl *Controller) setSafeModeBlockedCondition(pool *mcfgv1.MachineConfigPool, nodes []*corev1.Node, status *mcfgv1.MachineConfigPoolStatus) {
+	if !isSafeModePool(pool) {
+		apihelpers.RemoveMachineConfigPoolCondition(status, MachineConfigPoolSafeModeBlocked)
+		return
+	}
+
+	changes, err := ctrl.getSafeModeBlockedChanges(pool, nodes)
+	if err != nil {
+		klog.V(4).Infof("Could not check safe mode for pool %s: %v", pool.Name, err)
+		return
+	}
+
+	if len(changes) == 0 {
+		cond := apihelpers.NewMachineConfigPoolCondition(MachineConfigPoolSafeModeBlocked, corev1.ConditionFalse, "", "")
+		apihelpers.SetMachineConfigPoolCondition(status, *cond)
+		return
+	}
+
+	msg := fmt.Sprintf("Pool is in safe mode and the update to %s requires draining or rebooting nodes due to changes to: %s. Remove the %s annotation to allow the update.",
+		pool.Spec.Configuration.Name, strings.Join(changes, ", "), ctrlcommon.SafeModeAnnotationKey)
+
+	if !apihelpers.IsMachineConfigPoolConditionTrue(pool.Status.Conditions, MachineConfigPoolSafeModeBlocked) {
+		ctrl.eventRecorder.Eventf(pool, corev1.EventTypeWarning, safeModeBlockedReason, msg)
+	}
+
+	cond := apihelpers.NewMachineConfigPoolCondition(MachineConfigPoolSafeModeBlocked, corev1.ConditionTrue, safeModeBlockedReason, msg)
+	apihelpers.SetMachineConfigPoolCondition(status, *cond)
+}
+
+// setComponentVersionSkewCondition summarizes the component versions reported by the nodes in
+// the ComponentVersionSkew condition, emitting an event when nodes on the same rendered
+// MachineConfig start reporting different versions.
+func (ctrl *Controller) setComponentVersionSkewCondition(pool *mcfgv1.MachineConfigPool, nodes []*corev1.Node, status *mcfgv1.MachineConfigPoolStatus) {
+	summary, skewed := getComponentVersionSummary(nodes)
+	if len(summary) == 0 {
+		apihelpers.RemoveMachineConfigPoolCondition(status, MachineConfigPoolComponentVersionSkew)
+		return
+	}
+
+	if len(skewed) == 0 {
+		cond := apihelpers.NewMachineConfigPoolCondition(MachineConfigPoolComponentVersionSkew, corev1.ConditionFalse, "", strings.Join(summary, "; "))
+		apihelpers.SetMachineConfigPoolCondition(status, *cond)
+		return
+	}
+
+	msg := fmt.Sprintf("Nodes on the same rendered MachineConfig report different versions of: %s. Reported versions: %s",
+		strings.Join(skewed, ", "), strings.Join(summary, "; "))
+
+	if !apihelpers.IsMachineConfigPoolConditionTrue(pool.Status.Conditions, MachineConfigPoolComponentVersionSkew) {
+		ctrl.eventRecorder.Eventf(pool, corev1.EventTypeWarning, componentVersionSkewReason, msg)
+	}
+
+	cond := apihelpers.NewMachineConfigPoolCondition(MachineConfigPoolComponentVersionSkew, corev1.ConditionTrue, componentVersionSkewReason, msg)
+	apihelpers.SetMachineConfigPoolCondition(status, *cond)
+}
+
+// setLowDiskNodesCondition sets the LowDiskNodes condition on the given status when nodes which
+// still need to be updated do not have the free disk space required by the pool.
+func (ctrl *Controller) setLowDiskNodesCondition(pool *mcfgv1.MachineConfigPool, nodes []*corev1.Node, status *mcfgv1.MachineConfigPoolStatus) {
+	minFree, err := getMinFreeDisk(pool)
+	if err != nil {
+		klog.V(4).Infof("Could not check free disk space for pool %s: %v", pool.Name, err)
+	}
+
+	if err != nil || minFree == 0 {
+		apihelpers.RemoveMachineConfigPoolCondition(status, MachineConfigPoolLowDiskNodes)
+		return
+	}
+
+	var pending []*corev1.Node
+	for _, node := range nodes {
+		if !ctrlcommon.NewLayeredNodeState(node).IsDesiredEqualToPool(pool) {
+			pending = append(pending, node)
+		}
+	}
+
+	lowDisk := getLowDiskNodes(pending, minFree)
+	if len(lowDisk) == 0 {
+		cond := apihelpers.NewMachineConfigPoolCondition(MachineConfigPoolLowDiskNodes, corev1.ConditionFalse, "", "")
+		apihelpers.SetMachineConfigPoolCondition(status, *cond)
+		return
+	}
+
+	lowDiskNodes := []string{}
+	for _, node := range pending {
+		if filesystems, ok := lowDisk[node.Name]; ok {
+			lowDiskNodes = append(lowDiskNodes, fmt.Sprintf("%s (%s)", node.Name, strings.Join(filesystems, ", ")))
+		}
+	}
+
+	msg := fmt.Sprintf("%d nodes are not updated until %s is free on /sysroot and /var: %s",
+		len(lowDiskNodes), pool.Annotations[ctrlcommon.MinFreeDiskAnnotationKey], strings.Join(lowDiskNodes, ", "))
+
+	cond := apihelpers.NewMachineConfigPoolCondition(MachineConfigPoolLowDiskNodes, corev1.ConditionTrue, lowDiskNodesReason, msg)
+	apihelpers.SetMachineConfigPoolCondition(status, *cond)
+}
+
+// setRevertingFromLayeringCondition sets the RevertingFromLayering condition on the given status
+// while nodes of a pool which is not layered are still on a layered OS image. Once all of them
+// are back on the stock OS image, the condition is kept as false until the pool is layered again.
+func (ctrl *Controller) setRevertingFromLayeringCondition(pool *mcfgv1.MachineConfigPool, nodes []*corev1.Node, status *mcfgv1.MachineConfigPoolStatus) {
+	if ctrlcommon.IsLayeredPool(pool) {
+		apihelpers.RemoveMachineConfigPoolCondition(status, MachineConfigPoolRevertingFromLayering)
+		return
+	}
+
+	layeredNodes := []string{}
+	for _, node := range nodes {
+		if node.Annotations[daemonconsts.CurrentImageAnnotationKey] != "" {
+			layeredNodes = append(layeredNodes, node.Name)
+		}
+	}
+
+	if len(layeredNodes) == 0 {
+		if apihelpers.GetMachineConfigPoolCondition(*status, MachineConfigPoolRevertingFromLayering) != nil {
+			cond := apihelpers.NewMachineConfigPoolCondition(MachineConfigPoolRevertingFromLayering, corev1.ConditionFalse, "", fmt.Sprintf("All nodes are on the stock OS image of %s", pool.Spec.Configuration.Name))
+			apihelpers.SetMachineConfigPoolCondition(status, *cond)
+		}
+		return
+	}
+
+	sort.Strings(layeredNodes)
+
+	msg := fmt.Sprintf("%d of %d nodes are still on a layered OS image and are being rolled back onto the stock OS image of %s: %s",
+		len(layeredNodes), len(nodes), pool.Spec.Configuration.Name, strings.Join(layeredNodes, ", "))
+
+	if !apihelpers.IsMachineConfigPoolConditionTrue(pool.Status.Conditions, MachineConfigPoolRevertingFromLayering) {
+		ctrl.eventRecorder.Eventf(pool, corev1.EventTypeNormal, revertingFromLayeringReason, msg)
+	}
+
+	cond := apihelpers.NewMachineConfigPoolCondition(MachineConfigPoolRevertingFromLayering, corev1.ConditionTrue, revertingFromLayeringReason, msg)
+	apihelpers.SetMachineConfigPoolCondition(status, *cond)
+}
+
+// getComponentVersionSummary aggregates the component versions reported by the MCD on each
+// node. It returns how many nodes report each version of each component, and the components
+// for which nodes on the same rendered MachineConfig report different versions, since those
+// should have been installed from the same OS image.
+func getComponentVersionSummary(nodes []*corev1.Node) ([]string, []string) {
+	// counts maps a component to the number of nodes reporting each version of it.
+	counts := map[string]map[string]int{}
+	// versionsByConfig maps a component and the node's current config to the versions reported for them.
+	versionsByConfig := map[string]map[string]sets.Set[string]{}
+
+	for _, node := range nodes {
+		val, ok := ctrlcommon.GetNodeStatus(node, daemonconsts.ComponentVersionsAnnotationKey)
+		if !ok {
+			continue
+		}
+
+		versions := map[string]string{}
+		if err := json.Unmarshal([]byte(val), &versions); err != nil {
+			klog.V(4).Infof("Could not parse component versions of node %s: %v", node.Name, err)
+			continue
+		}
+
+		currentConfig := node.Annotations[daemonconsts.CurrentMachineConfigAnnotationKey]
+
+		for component, version := range versions {
+			if version == "" {
+				continue
+			}
+
+			if _, ok := counts[component]; !ok {
+				counts[component] = map[string]int{}
+				versionsByConfig[component] = map[string]sets.Set[string]{}
+			}
+			counts[component][version]++
+
+			if _, ok := versionsByConfig[component][currentConfig]; !ok {
+				versionsByConfig[component][currentConfig] = sets.New[string]()
+			}
+			versionsByConfig[component][currentConfig].Insert(version)
+		}
+	}
+
+	summary := []string{}
+	skewed := []string{}
+
+	for _, component := range sets.List(sets.KeySet(counts)) {
+		versions := []string{}
+		for _, version := range sets.List(sets.KeySet(counts[component])) {
+			versions = append(versions, fmt.Sprintf("%s (%d)", version, counts[component][version]))
+		}
+		summary = append(summary, fmt.Sprintf("%s: %s", component, strings.Join(versions, ", ")))
+
+		for _, configVersions := range versionsByConfig[component] {
+			if configVersions.Len() > 1 {
+				skewed = append(skewed, component)
+				break
+			}
+		}
+	}
+
+	return summary, skewed
+}
+
+// getStaleProvisionedMachines returns the machines provisioned within the
+// stale machine window which were served a MachineConfig other than the
+// pool's current or target one, even though the current one already existed
+// when they were provisioned.
+func getStaleProvisionedMachines(pool *mcfgv1.MachineConfigPool, nodes []*corev1.Node, currentConfigCreated, now time.Time) []*corev1.Node {
+	var stale []*corev1.Node
+	for _, node := range nodes {
+		initialConfig := node.Annotations[daemonconsts.InitialMachineConfigAnnotationKey]
+		if initialConfig == "" {
+			continue
+		}
+
+		if initialConfig == pool.Status.Configuration.Name || initialConfig == pool.Spec.Configuration.Name {
+			continue
+		}
+
+		created := node.CreationTimestamp.Time
+		if created.Before(currentConfigCreated) || now.Sub(created) > staleProvisionedMachineWindow {
+			continue
+		}
+
+		stale = append(stale, node)
+	}
+	return stale
 }
 
 func getPoolUpdateLine(pool *mcfgv1.MachineConfigPool) string {
@@ -216,13 +494,15 @@ func isNodeMCDState(node *corev1.Node, state string) bool {
 	return dstate == state
 }
 
-// isNodeMCDFailing checks if the MCD has unsuccessfully applied an update
+// isNodeMCDFailing checks if the MCD has unsuccessfully applied an update, or
+// skipped it because the preflight checks failed
 func isNodeMCDFailing(node *corev1.Node) bool {
 	if node.Annotations[daemonconsts.CurrentMachineConfigAnnotationKey] == node.Annotations[daemonconsts.DesiredMachineConfigAnnotationKey] {
 		return false
 	}
 	return isNodeMCDState(node, daemonconsts.MachineConfigDaemonStateDegraded) ||
-		isNodeMCDState(node, daemonconsts.MachineConfigDaemonStateUnreconcilable)
+		isNodeMCDState(node, daemonconsts.MachineConfigDaemonStateUnreconcilable) ||
+		ctrlcommon.HasFailedPreflightChecks(node)
 }
 
 // getUpdatedMachines filters the provided nodes to return the nodes whose
@@ -253,21 +533,18 @@ func getReadyMachines(pool *mcfgv1.MachineConfigPool, nodes []*corev1.Node) []*c
 }
 
 func checkNodeReady(node *corev1.Node) error {
-	for i := range node.Status.Conditions {
-		cond := &node.Status.Conditions[i]
-		// We consider the node for scheduling only when its:
-		// - NodeReady condition status is ConditionTrue,
-		// - NodeDiskPressure condition status is ConditionFalse,
-		// - NodeNetworkUnavailable condition status is ConditionFalse.
-		if cond.Type == corev1.NodeReady && cond.Status != corev1.ConditionTrue {
-			return fmt.Errorf("node %s is reporting NotReady=%v", node.Name, cond.Status)
-		}
-		if cond.Type == corev1.NodeDiskPressure && cond.Status != corev1.ConditionFalse {
-			return fmt.Errorf("node %s is reporting OutOfDisk=%v", node.Name, cond.Status)
-		}
-		if cond.Type == corev1.NodeNetworkUnavailable && cond.Status != corev1.ConditionFalse {
-			return fmt.Errorf("node %s is reporting NetworkUnavailable=%v", node.Name, cond.Status)
-		}
+	// We consider the node for scheduling only when its:
+	// - NodeReady condition status is ConditionTrue,
+	// - NodeDiskPressure condition status is ConditionFalse,
+	// - NodeNetworkUnavailable condition status is ConditionFalse.
+	if cond := conditions.Node.Get(node.Status.Conditions, corev1.NodeReady); cond != nil && cond.Status != corev1.ConditionTrue {
+		return fmt.Errorf("node %s is reporting NotReady=%v", node.Name, cond.Status)
+	}
+	if cond := conditions.Node.Get(node.Status.Conditions, corev1.NodeDiskPressure); cond != nil && cond.Status != corev1.ConditionFalse {
+		return fmt.Errorf("node %s is reporting OutOfDisk=%v", node.Name, cond.Status)
+	}
+	if cond := conditions.Node.Get(node.Status.Conditions, corev1.NodeNetworkUnavailable); cond != nil && cond.Status != corev1.ConditionFalse {
+		return fmt.Errorf("node %s is reporting NetworkUnavailable=%v", node.Name, cond.Status)
 	}
 	// Ignore nodes that are marked unschedulable
 	if node.Spec.Unschedulable {
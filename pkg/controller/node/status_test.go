@@ -4,14 +4,19 @@ import (
 	"fmt"
 	"reflect"
 	"testing"
+	"time"
 
 	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
 	"github.com/openshift/machine-config-operator/pkg/apihelpers"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
 	daemonconsts "github.com/openshift/machine-config-operator/pkg/daemon/constants"
 	"github.com/openshift/machine-config-operator/test/helpers"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/tools/record"
 )
 
 func TestIsNodeReady(t *testing.T) {
@@ -884,3 +889,692 @@ func TestCalculateStatus(t *testing.T) {
 		})
 	}
 }
+
+func TestGetStaleProvisionedMachines(t *testing.T) {
+	now := time.Now()
+	configCreated := now.Add(-2 * time.Hour)
+
+	newProvisionedNode := func(name, initialConfig string, age time.Duration) *corev1.Node {
+		node := newNode(name, "v1", "v1")
+		node.CreationTimestamp = metav1.NewTime(now.Add(-age))
+		if initialConfig != "" {
+			node.Annotations[daemonconsts.InitialMachineConfigAnnotationKey] = initialConfig
+		}
+		return node
+	}
+
+	pool := helpers.NewMachineConfigPool("worker", nil, helpers.WorkerSelector, "v1")
+
+	nodes := []*corev1.Node{
+		// provisioned with the current config
+		newProvisionedNode("node-0", "v1", time.Hour),
+		// provisioned with a stale config after the current config was created
+		newProvisionedNode("node-1", "v0", time.Hour),
+		// provisioned before the current config was created
+		newProvisionedNode("node-2", "v0", 3*time.Hour),
+		// provisioned outside of the window
+		newProvisionedNode("node-3", "v0", 48*time.Hour),
+		// provisioned before the initial config was recorded
+		newProvisionedNode("node-4", "", time.Hour),
+	}
+
+	stale := getStaleProvisionedMachines(pool, nodes, configCreated, now)
+	assert.Len(t, stale, 1)
+	assert.Equal(t, "node-1", stale[0].Name)
+
+	// A node provisioned with the pool's target config is not stale.
+	pool.Spec.Configuration.Name = "v0"
+	assert.Empty(t, getStaleProvisionedMachines(pool, nodes, configCreated, now))
+}
+
+func TestGetComponentVersionSummary(t *testing.T) {
+	newVersionedNode := func(name, currentConfig, versions string) *corev1.Node {
+		node := newNode(name, currentConfig, currentConfig)
+		if versions != "" {
+			node.Annotations[daemonconsts.ComponentVersionsAnnotationKey] = versions
+		}
+		return node
+	}
+
+	nodes := []*corev1.Node{
+		newVersionedNode("node-0", "v1", `{"kubelet":"v1.28.3","crio":"1.28.2"}`),
+		newVersionedNode("node-1", "v1", `{"kubelet":"v1.28.3","crio":"1.28.1"}`),
+		newVersionedNode("node-2", "v0", `{"kubelet":"v1.27.6","crio":"1.28.2"}`),
+		// not reporting yet
+		newVersionedNode("node-3", "v1", ""),
+		// unparseable
+		newVersionedNode("node-4", "v1", "kubelet=v1.26.0"),
+	}
+
+	summary, skewed := getComponentVersionSummary(nodes)
+	assert.Equal(t, []string{
+		"crio: 1.28.1 (1), 1.28.2 (2)",
+		"kubelet: v1.27.6 (1), v1.28.3 (2)",
+	}, summary)
+	// The kubelet versions only differ across rendered MachineConfigs.
+	assert.Equal(t, []string{"crio"}, skewed)
+
+	summary, skewed = getComponentVersionSummary([]*corev1.Node{newVersionedNode("node-0", "v1", "")})
+	assert.Empty(t, summary)
+	assert.Empty(t, skewed)
+}
+
+func TestSetEffectiveUpdatePolicyCondition(t *testing.T) {
+	nodes := []*corev1.Node{
+		newNode("node-0", "v1", "v1"),
+		newNode("node-1", "v1", "v1"),
+		newNode("node-2", "v1", "v1"),
+		newNode("node-3", "v1", "v1"),
+	}
+
+	getPolicy := func(t *testing.T, pool *mcfgv1.MachineConfigPool) *mcfgv1.MachineConfigPoolCondition {
+		status := pool.Status.DeepCopy()
+		setEffectiveUpdatePolicyCondition(pool, nodes, status)
+		cond := apihelpers.GetMachineConfigPoolCondition(*status, MachineConfigPoolEffectiveUpdatePolicy)
+		if !assert.NotNil(t, cond) {
+			t.FailNow()
+		}
+		return cond
+	}
+
+	pool := helpers.NewMachineConfigPool("worker", nil, helpers.WorkerSelector, "v1")
+	cond := getPolicy(t, pool)
+	assert.Equal(t, corev1.ConditionTrue, cond.Status)
+	assert.JSONEq(t, `{
+		"paused": false,
+		"maxUnavailable": "1",
+		"maxUnavailableNodes": 1,
+		"updateOrder": "zone",
+		"safeMode": false,
+		"criticalWindowMaxDefer": "1h0m0s",
+		"drainTimeout": "1h0m0s",
+		"layered": false
+	}`, cond.Message)
+
+	maxUnavailable := intstr.FromString("50%")
+	pool.Spec.MaxUnavailable = &maxUnavailable
+	pool.Spec.Paused = true
+	pool.Annotations = map[string]string{
+		ctrlcommon.SafeModeAnnotationKey:               "true",
+		ctrlcommon.CriticalWindowMaxDeferAnnotationKey: "30m",
+		ctrlcommon.MinFreeDiskAnnotationKey:            "10Gi",
+	}
+
+	cond = getPolicy(t, pool)
+	assert.Equal(t, corev1.ConditionTrue, cond.Status)
+	assert.JSONEq(t, `{
+		"paused": true,
+		"maxUnavailable": "50%",
+		"maxUnavailableNodes": 2,
+		"updateOrder": "zone",
+		"safeMode": true,
+		"criticalWindowMaxDefer": "30m0s",
+		"minFreeDisk": "10Gi",
+		"drainTimeout": "1h0m0s",
+		"layered": false
+	}`, cond.Message)
+
+	pool.Annotations[ctrlcommon.MinFreeDiskAnnotationKey] = "ten gigs"
+	cond = getPolicy(t, pool)
+	assert.Equal(t, corev1.ConditionFalse, cond.Status)
+	assert.Equal(t, invalidUpdatePolicyReason, cond.Reason)
+	assert.Contains(t, cond.Message, ctrlcommon.MinFreeDiskAnnotationKey)
+}
+
+func TestSetRevertingFromLayeringCondition(t *testing.T) {
+	recorder := record.NewFakeRecorder(10)
+	ctrl := &Controller{eventRecorder: recorder}
+
+	setCondition := func(pool *mcfgv1.MachineConfigPool, nodes []*corev1.Node) *mcfgv1.MachineConfigPoolCondition {
+		status := pool.Status.DeepCopy()
+		ctrl.setRevertingFromLayeringCondition(pool, nodes, status)
+		pool.Status = *status
+		return apihelpers.GetMachineConfigPoolCondition(*status, MachineConfigPoolRevertingFromLayering)
+	}
+
+	pool := helpers.NewMachineConfigPool("worker", nil, helpers.WorkerSelector, "v1")
+
+	// A pool which was never layered does not get the condition.
+	assert.Nil(t, setCondition(pool, []*corev1.Node{newNode("node-0", "v1", "v1")}))
+
+	cond := setCondition(pool, []*corev1.Node{
+		newLayeredNode("node-0", "v1", "v1", "image-1", ""),
+		newNode("node-1", "v1", "v1"),
+		newLayeredNode("node-2", "v1", "v1", "image-1", "image-1"),
+	})
+	if assert.NotNil(t, cond) {
+		assert.Equal(t, corev1.ConditionTrue, cond.Status)
+		assert.Equal(t, revertingFromLayeringReason, cond.Reason)
+		assert.Contains(t, cond.Message, "2 of 3 nodes")
+		assert.Contains(t, cond.Message, "node-0, node-2")
+	}
+	assert.Len(t, recorder.Events, 1)
+
+	// The event is only emitted when the revert starts.
+	setCondition(pool, []*corev1.Node{newLayeredNode("node-0", "v1", "v1", "image-1", "")})
+	assert.Len(t, recorder.Events, 1)
+
+	cond = setCondition(pool, []*corev1.Node{newNode("node-0", "v1", "v1")})
+	if assert.NotNil(t, cond) {
+		assert.Equal(t, corev1.ConditionFalse, cond.Status)
+	}
+
+	// The condition is removed once the pool is layered again.
+	pool.Labels = map[string]string{ctrlcommon.LayeringEnabledPoolLabel: ""}
+	assert.Nil(t, setCondition(pool, []*corev1.Node{newLayeredNode("node-0", "v1", "v1", "image-1", "image-1")}))
+}
+
+func TestSetUnmanagedPathsCondition(t *testing.T) {
+	getCondition := func(pool *mcfgv1.MachineConfigPool, nodes []*corev1.Node) *mcfgv1.MachineConfigPoolCondition {
+		status := pool.Status.DeepCopy()
+		setUnmanagedPathsCondition(pool, nodes, status)
+		return apihelpers.GetMachineConfigPoolCondition(*status, MachineConfigPoolUnmanagedPaths)
+	}
+
+	nodes := []*corev1.Node{
+		newNodeWithAnnotations("node-0", map[string]string{daemonconsts.UnmanagedPathsAnnotationKey: "/etc/agent.conf,/etc/agent.d/"}),
+		newNodeWithAnnotations("node-1", nil),
+	}
+
+	pool := helpers.NewMachineConfigPool("worker", nil, helpers.WorkerSelector, "v1")
+	assert.Nil(t, getCondition(pool, nodes))
+
+	pool.Annotations = map[string]string{ctrlcommon.UnmanagedPathsAnnotationKey: "/etc/agent.d/,/etc/agent.conf"}
+	cond := getCondition(pool, nodes)
+	if !assert.NotNil(t, cond) {
+		t.FailNow()
+	}
+	assert.Equal(t, corev1.ConditionTrue, cond.Status)
+	assert.Equal(t, "Not managed by the MCO: /etc/agent.conf, /etc/agent.d/; not yet applied on 1 nodes: node-1", cond.Message)
+
+	cond = getCondition(pool, nodes[:1])
+	if !assert.NotNil(t, cond) {
+		t.FailNow()
+	}
+	assert.Equal(t, "Not managed by the MCO: /etc/agent.conf, /etc/agent.d/", cond.Message)
+
+	pool.Annotations[ctrlcommon.UnmanagedPathsAnnotationKey] = "/var/lib/agent"
+	cond = getCondition(pool, nodes)
+	if !assert.NotNil(t, cond) {
+		t.FailNow()
+	}
+	assert.Equal(t, corev1.ConditionFalse, cond.Status)
+	assert.Equal(t, invalidUnmanagedPathsReason, cond.Reason)
+	assert.Contains(t, cond.Message, "not below /etc")
+}
+
+func TestSetDisruptionPolicyCondition(t *testing.T) {
+	getCondition := func(pool *mcfgv1.MachineConfigPool, nodes []*corev1.Node) *mcfgv1.MachineConfigPoolCondition {
+		status := pool.Status.DeepCopy()
+		setDisruptionPolicyCondition(pool, nodes, status)
+		return apihelpers.GetMachineConfigPoolCondition(*status, MachineConfigPoolDisruptionPolicy)
+	}
+
+	policy := `{"files":[{"path":"/etc/agent.conf","actions":[{"type":"RunUnit","unit":"agent-reload.service"}]},{"path":"/etc/agent.d/","actions":[{"type":"DaemonReexec"}]}]}`
+
+	nodes := []*corev1.Node{
+		newNodeWithAnnotations("node-0", map[string]string{daemonconsts.DisruptionPolicyAnnotationKey: policy}),
+		newNodeWithAnnotations("node-1", nil),
+	}
+
+	pool := helpers.NewMachineConfigPool("worker", nil, helpers.WorkerSelector, "v1")
+	assert.Nil(t, getCondition(pool, nodes))
+
+	pool.Annotations = map[string]string{ctrlcommon.DisruptionPolicyAnnotationKey: policy}
+	cond := getCondition(pool, nodes)
+	if !assert.NotNil(t, cond) {
+		t.FailNow()
+	}
+	assert.Equal(t, corev1.ConditionTrue, cond.Status)
+	assert.Equal(t, "Applied without a reboot: /etc/agent.conf, /etc/agent.d/; not yet applied on 1 nodes: node-1", cond.Message)
+
+	cond = getCondition(pool, nodes[:1])
+	if !assert.NotNil(t, cond) {
+		t.FailNow()
+	}
+	assert.Equal(t, "Applied without a reboot: /etc/agent.conf, /etc/agent.d/", cond.Message)
+
+	pool.Annotations[ctrlcommon.DisruptionPolicyAnnotationKey] = `{"files":[{"path":"/etc/agent.conf","actions":[{"type":"Reboot"}]}]}`
+	cond = getCondition(pool, nodes)
+	if !assert.NotNil(t, cond) {
+		t.FailNow()
+	}
+	assert.Equal(t, corev1.ConditionFalse, cond.Status)
+	assert.Equal(t, invalidDisruptionPolicyReason, cond.Reason)
+	assert.Contains(t, cond.Message, "unknown action")
+}
+
+func TestSetSwapCondition(t *testing.T) {
+	getCondition := func(pool *mcfgv1.MachineConfigPool, nodes []*corev1.Node) *mcfgv1.MachineConfigPoolCondition {
+		status := pool.Status.DeepCopy()
+		setSwapCondition(pool, nodes, status)
+		return apihelpers.GetMachineConfigPoolCondition(*status, MachineConfigPoolSwap)
+	}
+
+	swap := `{"type":"Zram","size":"4Gi","swappiness":10}`
+
+	newNode := func(name, swapStatus string) *corev1.Node {
+		node := newNodeWithAnnotations(name, nil)
+		if swapStatus != "" {
+			require.NoError(t, ctrlcommon.SetNodeStatus(node, map[string]string{daemonconsts.SwapStatusAnnotationKey: swapStatus}))
+		}
+		return node
+	}
+
+	nodes := []*corev1.Node{
+		newNode("node-0", fmt.Sprintf(`{"config":%q}`, swap)),
+		newNode("node-1", ""),
+	}
+
+	pool := helpers.NewMachineConfigPool("worker", nil, helpers.WorkerSelector, "v1")
+	assert.Nil(t, getCondition(pool, nodes))
+
+	pool.Annotations = map[string]string{ctrlcommon.SwapAnnotationKey: swap}
+	cond := getCondition(pool, nodes)
+	if !assert.NotNil(t, cond) {
+		t.FailNow()
+	}
+	assert.Equal(t, corev1.ConditionTrue, cond.Status)
+	assert.Equal(t, "Zram swap of 4Gi with swappiness 10; not yet applied on 1 nodes: node-1", cond.Message)
+
+	cond = getCondition(pool, nodes[:1])
+	if !assert.NotNil(t, cond) {
+		t.FailNow()
+	}
+	assert.Equal(t, "Zram swap of 4Gi with swappiness 10", cond.Message)
+
+	nodes = append(nodes, newNode("node-2", fmt.Sprintf(`{"config":%q,"error":"the kubelet does not allow swap"}`, swap)))
+	cond = getCondition(pool, nodes)
+	if !assert.NotNil(t, cond) {
+		t.FailNow()
+	}
+	assert.Equal(t, corev1.ConditionFalse, cond.Status)
+	assert.Equal(t, swapFailedReason, cond.Reason)
+	assert.Equal(t, "Swap could not be set up on 1 nodes: node-2 (the kubelet does not allow swap)", cond.Message)
+
+	pool.Annotations[ctrlcommon.SwapAnnotationKey] = `{"type":"Partition","size":"4Gi"}`
+	cond = getCondition(pool, nodes)
+	if !assert.NotNil(t, cond) {
+		t.FailNow()
+	}
+	assert.Equal(t, corev1.ConditionFalse, cond.Status)
+	assert.Equal(t, invalidSwapReason, cond.Reason)
+	assert.Contains(t, cond.Message, "unknown type")
+}
+
+func TestSetStagedUpdatesCondition(t *testing.T) {
+	getCondition := func(pool *mcfgv1.MachineConfigPool, nodes []*corev1.Node) *mcfgv1.MachineConfigPoolCondition {
+		status := pool.Status.DeepCopy()
+		setStagedUpdatesCondition(pool, nodes, status)
+		return apihelpers.GetMachineConfigPoolCondition(*status, MachineConfigPoolStagedUpdates)
+	}
+
+	newNode := func(name, desiredConfig, stagedConfig string) *corev1.Node {
+		node := newNodeWithAnnotations(name, map[string]string{daemonconsts.DesiredMachineConfigAnnotationKey: desiredConfig})
+		if stagedConfig != "" {
+			staged := fmt.Sprintf(`{"config":%q,"from":"v1","finalization":"Locked","time":"2024-01-01T00:00:00Z"}`, stagedConfig)
+			require.NoError(t, ctrlcommon.SetNodeStatus(node, map[string]string{daemonconsts.StagedUpdateAnnotationKey: staged}))
+		}
+		return node
+	}
+
+	nodes := []*corev1.Node{
+		newNode("node-0", "v2", "v2"),
+		// The update staged on node-1 was superseded.
+		newNode("node-1", "v3", "v2"),
+		newNode("node-2", "v2", ""),
+	}
+
+	pool := helpers.NewMachineConfigPool("worker", nil, helpers.WorkerSelector, "v2")
+	assert.Nil(t, getCondition(pool, nodes))
+
+	pool.Annotations = map[string]string{ctrlcommon.StagedUpdatesAnnotationKey: `{}`}
+	cond := getCondition(pool, nodes)
+	if !assert.NotNil(t, cond) {
+		t.FailNow()
+	}
+	assert.Equal(t, corev1.ConditionTrue, cond.Status)
+	assert.Equal(t, "Updates which reboot nodes are staged and finalized on request; waiting to be finalized on 1 nodes: node-0", cond.Message)
+
+	pool.Annotations[ctrlcommon.StagedUpdatesAnnotationKey] = `{"finalization":"OnReboot"}`
+	pool.Annotations[ctrlcommon.MaintenanceWindowsAnnotationKey] = "Sat,Sun 00:00-24:00"
+	cond = getCondition(pool, nodes[1:])
+	if !assert.NotNil(t, cond) {
+		t.FailNow()
+	}
+	assert.Equal(t, "Updates which reboot nodes are staged and finalized on request, within maintenance windows or when the node reboots", cond.Message)
+
+	pool.Annotations[ctrlcommon.StagedUpdatesAnnotationKey] = `{"finalization":"Never"}`
+	cond = getCondition(pool, nodes)
+	if !assert.NotNil(t, cond) {
+		t.FailNow()
+	}
+	assert.Equal(t, corev1.ConditionFalse, cond.Status)
+	assert.Equal(t, invalidStagedUpdatesReason, cond.Reason)
+	assert.Contains(t, cond.Message, "unknown finalization")
+}
+
+func TestSetHeldNodesCondition(t *testing.T) {
+	getCondition := func(pool *mcfgv1.MachineConfigPool, nodes []*corev1.Node) *mcfgv1.MachineConfigPoolCondition {
+		status := pool.Status.DeepCopy()
+		setHeldNodesCondition(pool, nodes, status)
+		return apihelpers.GetMachineConfigPoolCondition(*status, MachineConfigPoolHeldNodes)
+	}
+
+	hold := map[string]string{daemonconsts.HoldAnnotationKey: "true"}
+
+	pool := helpers.NewMachineConfigPool("worker", nil, helpers.WorkerSelector, "v2")
+	nodes := []*corev1.Node{
+		helpers.NewNodeBuilder("node-0").WithEqualConfigs("v1").WithMCDState(daemonconsts.MachineConfigDaemonStateDone).Node(),
+		helpers.NewNodeBuilder("node-1").WithEqualConfigs("v2").WithMCDState(daemonconsts.MachineConfigDaemonStateDone).Node(),
+	}
+	assert.Nil(t, getCondition(pool, nodes))
+
+	nodes = []*corev1.Node{
+		helpers.NewNodeBuilder("node-0").WithEqualConfigs("v1").WithMCDState(daemonconsts.MachineConfigDaemonStateDone).WithAnnotations(hold).Node(),
+		helpers.NewNodeBuilder("node-1").WithEqualConfigs("v2").WithMCDState(daemonconsts.MachineConfigDaemonStateDone).WithAnnotations(hold).Node(),
+		helpers.NewNodeBuilder("node-2").WithEqualConfigs("v1").WithMCDState(daemonconsts.MachineConfigDaemonStateDone).Node(),
+	}
+	cond := getCondition(pool, nodes)
+	if !assert.NotNil(t, cond) {
+		t.FailNow()
+	}
+	assert.Equal(t, corev1.ConditionTrue, cond.Status)
+	assert.Equal(t, nodesHeldReason, cond.Reason)
+	assert.Equal(t, "2 nodes are held at their current config, 1 of which are not updated to MachineConfig v2: node-0, node-1", cond.Message)
+
+	// The pool is not reported as updating its held nodes.
+	status := calculateStatus(nil, pool, nodes)
+	updating := apihelpers.GetMachineConfigPoolCondition(status, mcfgv1.MachineConfigPoolUpdating)
+	if !assert.NotNil(t, updating) {
+		t.FailNow()
+	}
+	assert.Equal(t, "All nodes are updating to MachineConfig v2, except 1 held nodes", updating.Message)
+}
+
+func TestSetMaintenanceWindowsCondition(t *testing.T) {
+	getCondition := func(pool *mcfgv1.MachineConfigPool, nodes []*corev1.Node) *mcfgv1.MachineConfigPoolCondition {
+		status := pool.Status.DeepCopy()
+		setMaintenanceWindowsCondition(pool, nodes, status)
+		return apihelpers.GetMachineConfigPoolCondition(*status, MachineConfigPoolMaintenanceWindows)
+	}
+
+	newNode := func(name, desiredConfig, pendingConfig string) *corev1.Node {
+		node := newNodeWithAnnotations(name, map[string]string{daemonconsts.DesiredMachineConfigAnnotationKey: desiredConfig})
+		if pendingConfig != "" {
+			pending := fmt.Sprintf(`{"config":%q,"actions":["reboot"],"windowOpens":"2024-01-01T22:00:00Z"}`, pendingConfig)
+			require.NoError(t, ctrlcommon.SetNodeStatus(node, map[string]string{daemonconsts.PendingUpdateAnnotationKey: pending}))
+		}
+		return node
+	}
+
+	nodes := []*corev1.Node{
+		newNode("node-0", "v2", "v2"),
+		// The update waited for by node-1 was superseded.
+		newNode("node-1", "v3", "v2"),
+		newNode("node-2", "v2", ""),
+	}
+
+	pool := helpers.NewMachineConfigPool("worker", nil, helpers.WorkerSelector, "v2")
+	assert.Nil(t, getCondition(pool, nodes))
+
+	pool.Annotations = map[string]string{ctrlcommon.MaintenanceWindowsAnnotationKey: "Sat,Sun 00:00-06:00"}
+	cond := getCondition(pool, nodes)
+	if !assert.NotNil(t, cond) {
+		t.FailNow()
+	}
+	assert.Equal(t, corev1.ConditionTrue, cond.Status)
+	assert.Equal(t, "Updates which reboot nodes are applied during: Sun,Sat 00:00-06:00 UTC; waiting for the next window on 1 nodes: node-0", cond.Message)
+
+	cond = getCondition(pool, nodes[1:])
+	if !assert.NotNil(t, cond) {
+		t.FailNow()
+	}
+	assert.Equal(t, "Updates which reboot nodes are applied during: Sun,Sat 00:00-06:00 UTC", cond.Message)
+
+	pool.Annotations[ctrlcommon.MaintenanceWindowsAnnotationKey] = "Sat 06:00"
+	cond = getCondition(pool, nodes)
+	if !assert.NotNil(t, cond) {
+		t.FailNow()
+	}
+	assert.Equal(t, corev1.ConditionFalse, cond.Status)
+	assert.Equal(t, invalidMaintenanceWindowsReason, cond.Reason)
+}
+
+func TestSetPreflightChecksCondition(t *testing.T) {
+	getCondition := func(pool *mcfgv1.MachineConfigPool, nodes []*corev1.Node) *mcfgv1.MachineConfigPoolCondition {
+		status := pool.Status.DeepCopy()
+		setPreflightChecksCondition(pool, nodes, status)
+		return apihelpers.GetMachineConfigPoolCondition(*status, MachineConfigPoolPreflightChecksFailed)
+	}
+
+	newNode := func(name, currentConfig, desiredConfig, failedConfig string) *corev1.Node {
+		node := newNodeWithAnnotations(name, map[string]string{
+			daemonconsts.CurrentMachineConfigAnnotationKey: currentConfig,
+			daemonconsts.DesiredMachineConfigAnnotationKey: desiredConfig,
+		})
+		if failedConfig != "" {
+			failure := fmt.Sprintf(`{"config":%q,"failures":["/var has 1024 bytes free, less than the required 2048"],"time":"2024-01-01T22:00:00Z"}`, failedConfig)
+			require.NoError(t, ctrlcommon.SetNodeStatus(node, map[string]string{daemonconsts.PreflightCheckFailureAnnotationKey: failure}))
+		}
+		return node
+	}
+
+	nodes := []*corev1.Node{
+		newNode("node-0", "v1", "v2", "v2"),
+		// The update skipped by node-1 was superseded.
+		newNode("node-1", "v1", "v3", "v2"),
+		newNode("node-2", "v1", "v2", ""),
+	}
+
+	pool := helpers.NewMachineConfigPool("worker", nil, helpers.WorkerSelector, "v2")
+	assert.Nil(t, getCondition(pool, nodes))
+
+	pool.Annotations = map[string]string{
+		ctrlcommon.PreflightChecksAnnotationKey: `{"ostreeDeployments":true}`,
+		ctrlcommon.MinFreeDiskAnnotationKey:     "10Gi",
+	}
+	cond := getCondition(pool, nodes)
+	if !assert.NotNil(t, cond) {
+		t.FailNow()
+	}
+	assert.Equal(t, corev1.ConditionTrue, cond.Status)
+	assert.Equal(t, preflightChecksFailedReason, cond.Reason)
+	assert.Equal(t, "Preflight checks failed on 1 nodes, which were not updated and will be retried: node-0 (/var has 1024 bytes free, less than the required 2048)", cond.Message)
+
+	cond = getCondition(pool, nodes[1:])
+	if !assert.NotNil(t, cond) {
+		t.FailNow()
+	}
+	assert.Equal(t, corev1.ConditionFalse, cond.Status)
+
+	pool.Annotations[ctrlcommon.PreflightChecksAnnotationKey] = `{"script":"check.sh"}`
+	cond = getCondition(pool, nodes)
+	if !assert.NotNil(t, cond) {
+		t.FailNow()
+	}
+	assert.Equal(t, corev1.ConditionTrue, cond.Status)
+	assert.Equal(t, invalidPreflightChecksReason, cond.Reason)
+
+	// The free disk space is set with the min-free-disk annotation.
+	pool.Annotations[ctrlcommon.PreflightChecksAnnotationKey] = `{"minFreeDisk":"10Gi"}`
+	cond = getCondition(pool, nodes)
+	if !assert.NotNil(t, cond) {
+		t.FailNow()
+	}
+	assert.Equal(t, invalidPreflightChecksReason, cond.Reason)
+	assert.Contains(t, cond.Message, ctrlcommon.MinFreeDiskAnnotationKey)
+
+	pool.Annotations[ctrlcommon.PreflightChecksAnnotationKey] = `{"ostreeDeployments":true}`
+	pool.Annotations[ctrlcommon.MinFreeDiskAnnotationKey] = "ten gigs"
+	cond = getCondition(pool, nodes)
+	if !assert.NotNil(t, cond) {
+		t.FailNow()
+	}
+	assert.Equal(t, invalidPreflightChecksReason, cond.Reason)
+}
+
+func TestSetPostUpdateVerificationCondition(t *testing.T) {
+	getCondition := func(pool *mcfgv1.MachineConfigPool, nodes []*corev1.Node) *mcfgv1.MachineConfigPoolCondition {
+		status := pool.Status.DeepCopy()
+		setPostUpdateVerificationCondition(pool, nodes, status)
+		return apihelpers.GetMachineConfigPoolCondition(*status, MachineConfigPoolPostUpdateVerificationFailed)
+	}
+
+	newNode := func(name, currentConfig, desiredConfig, failedConfig string) *corev1.Node {
+		node := newNodeWithAnnotations(name, map[string]string{
+			daemonconsts.CurrentMachineConfigAnnotationKey: currentConfig,
+			daemonconsts.DesiredMachineConfigAnnotationKey: desiredConfig,
+		})
+		if failedConfig != "" {
+			failure := fmt.Sprintf(`{"config":%q,"rolledBackTo":%q,"output":["unit kubelet.service is failed"],"time":"2024-01-01T22:00:00Z"}`, failedConfig, currentConfig)
+			require.NoError(t, ctrlcommon.SetNodeStatus(node, map[string]string{daemonconsts.PostUpdateVerificationFailureAnnotationKey: failure}))
+		}
+		return node
+	}
+
+	nodes := []*corev1.Node{
+		newNode("node-0", "v1", "v2", "v2"),
+		// The update rolled back on node-1 was superseded.
+		newNode("node-1", "v1", "v3", "v2"),
+		newNode("node-2", "v1", "v2", ""),
+	}
+
+	pool := helpers.NewMachineConfigPool("worker", nil, helpers.WorkerSelector, "v2")
+	assert.Nil(t, getCondition(pool, nodes[1:]))
+
+	// Rolled back nodes are reported even once the verification is removed.
+	cond := getCondition(pool, nodes)
+	if !assert.NotNil(t, cond) {
+		t.FailNow()
+	}
+	assert.Equal(t, corev1.ConditionTrue, cond.Status)
+	assert.Equal(t, postUpdateVerificationFailedReason, cond.Reason)
+	assert.Equal(t, "Post-update verification failed on 1 nodes, which were rolled back: node-0 from v2 to v1 (unit kubelet.service is failed)", cond.Message)
+
+	pool.Annotations = map[string]string{ctrlcommon.PostUpdateVerificationAnnotationKey: `{"units":["kubelet.service"]}`}
+	cond = getCondition(pool, nodes[1:])
+	if !assert.NotNil(t, cond) {
+		t.FailNow()
+	}
+	assert.Equal(t, corev1.ConditionFalse, cond.Status)
+
+	pool.Annotations[ctrlcommon.PostUpdateVerificationAnnotationKey] = `{"httpGet":"localhost:8080"}`
+	cond = getCondition(pool, nodes)
+	if !assert.NotNil(t, cond) {
+		t.FailNow()
+	}
+	assert.Equal(t, corev1.ConditionTrue, cond.Status)
+	assert.Equal(t, invalidPostUpdateVerificationReason, cond.Reason)
+}
+
+func TestSetDegradedConditions(t *testing.T) {
+	now := time.Date(2023, time.November, 2, 12, 0, 0, 0, time.UTC)
+
+	newStatus := func(conds ...mcfgv1.MachineConfigPoolCondition) *mcfgv1.MachineConfigPoolStatus {
+		return &mcfgv1.MachineConfigPoolStatus{Conditions: conds}
+	}
+
+	trueSince := func(condType mcfgv1.MachineConfigPoolConditionType, ago time.Duration) mcfgv1.MachineConfigPoolCondition {
+		return mcfgv1.MachineConfigPoolCondition{
+			Type:               condType,
+			Status:             corev1.ConditionTrue,
+			LastTransitionTime: metav1.NewTime(now.Add(-ago)),
+		}
+	}
+
+	newPool := func(grace string) *mcfgv1.MachineConfigPool {
+		pool := helpers.NewMachineConfigPool("worker", nil, helpers.WorkerSelector, "v1")
+		if grace != "" {
+			pool.Annotations = map[string]string{ctrlcommon.DegradedGracePeriodAnnotationKey: grace}
+		}
+		return pool
+	}
+
+	testCases := []struct {
+		name            string
+		grace           string
+		status          *mcfgv1.MachineConfigPoolStatus
+		degraded        bool
+		pending         *corev1.ConditionStatus
+		pendingReason   string
+		pendingContains []string
+	}{
+		{
+			name:     "No grace period",
+			status:   newStatus(trueSince(mcfgv1.MachineConfigPoolNodeDegraded, 0)),
+			degraded: true,
+		},
+		{
+			name:   "Not degraded",
+			status: newStatus(),
+		},
+		{
+			name:            "Within grace period",
+			grace:           `{"NodeDegraded":"10m"}`,
+			status:          newStatus(trueSince(mcfgv1.MachineConfigPoolNodeDegraded, 5*time.Minute)),
+			pending:         conditionStatusPtr(corev1.ConditionTrue),
+			pendingReason:   degradedGracePeriodReason,
+			pendingContains: []string{"NodeDegraded has been true since 2023-11-02T11:55:00Z", "Degraded at 2023-11-02T12:05:00Z"},
+		},
+		{
+			name:     "Grace period elapsed",
+			grace:    `{"NodeDegraded":"10m"}`,
+			status:   newStatus(trueSince(mcfgv1.MachineConfigPoolNodeDegraded, 10*time.Minute)),
+			degraded: true,
+			pending:  conditionStatusPtr(corev1.ConditionFalse),
+		},
+		{
+			name:            "Grace periods per condition type",
+			grace:           `{"NodeDegraded":"10m"}`,
+			status:          newStatus(trueSince(mcfgv1.MachineConfigPoolNodeDegraded, 5*time.Minute), trueSince(mcfgv1.MachineConfigPoolRenderDegraded, time.Minute)),
+			degraded:        true,
+			pending:         conditionStatusPtr(corev1.ConditionTrue),
+			pendingReason:   degradedGracePeriodReason,
+			pendingContains: []string{"NodeDegraded"},
+		},
+		{
+			name:          "Unknown condition type",
+			grace:         `{"Updating":"10m"}`,
+			status:        newStatus(trueSince(mcfgv1.MachineConfigPoolNodeDegraded, 0)),
+			degraded:      true,
+			pending:       conditionStatusPtr(corev1.ConditionFalse),
+			pendingReason: invalidDegradedGracePeriodReason,
+		},
+		{
+			name:          "Invalid duration",
+			grace:         `{"NodeDegraded":"10 minutes"}`,
+			status:        newStatus(trueSince(mcfgv1.MachineConfigPoolNodeDegraded, 0)),
+			degraded:      true,
+			pending:       conditionStatusPtr(corev1.ConditionFalse),
+			pendingReason: invalidDegradedGracePeriodReason,
+		},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+		t.Run(testCase.name, func(t *testing.T) {
+			setDegradedConditions(newPool(testCase.grace), testCase.status, now)
+
+			assert.Equal(t, testCase.degraded, apihelpers.IsMachineConfigPoolConditionTrue(testCase.status.Conditions, mcfgv1.MachineConfigPoolDegraded))
+
+			pending := apihelpers.GetMachineConfigPoolCondition(*testCase.status, MachineConfigPoolDegradedPending)
+			if testCase.pending == nil {
+				assert.Nil(t, pending)
+				return
+			}
+
+			if assert.NotNil(t, pending) {
+				assert.Equal(t, *testCase.pending, pending.Status)
+				assert.Equal(t, testCase.pendingReason, pending.Reason)
+				for _, s := range testCase.pendingContains {
+					assert.Contains(t, pending.Message, s)
+				}
+			}
+		})
+	}
+}
+
+func conditionStatusPtr(status corev1.ConditionStatus) *corev1.ConditionStatus {
+	return &status
+}
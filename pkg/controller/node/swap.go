@@ -0,0 +1,96 @@
+package node
+
+import (
+	"fmt"
+	"strings"
+
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	"github.com/openshift/machine-config-operator/pkg/apihelpers"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// MachineConfigPoolSwap describes the swap set up on the pool's nodes in its message, along with
+// the nodes which have not set it up yet. It is false when the pool's swap is invalid or could not
+// be set up on some nodes, e.g. because their kubelet does not allow swap, in which case the
+// message describes the problem instead.
+const MachineConfigPoolSwap mcfgv1.MachineConfigPoolConditionType = "Swap"
+
+const (
+	// invalidSwapReason is the reason of the Swap condition when the pool's swap cannot be parsed.
+	invalidSwapReason = "InvalidSwap"
+	// swapFailedReason is the reason of the Swap condition when the MCD could not set up the
+	// pool's swap on some nodes.
+	swapFailedReason = "SwapFailed"
+)
+
+// getSwap returns the swap set up on the pool's nodes, or nil if there is none.
+func getSwap(pool *mcfgv1.MachineConfigPool) (*ctrlcommon.SwapConfig, error) {
+	val := pool.Annotations[ctrlcommon.SwapAnnotationKey]
+
+	config, err := ctrlcommon.ParseSwapConfig(val)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s annotation %q: %w", ctrlcommon.SwapAnnotationKey, val, err)
+	}
+
+	return config, nil
+}
+
+// getSwapNodeAnnotation returns the value of the node annotation which hands the pool's swap to
+// the MCD, or "" if the annotation is to be removed.
+func getSwapNodeAnnotation(pool *mcfgv1.MachineConfigPool) (string, error) {
+	config, err := getSwap(pool)
+	if err != nil || config == nil {
+		return "", err
+	}
+
+	return config.String(), nil
+}
+
+// setSwapCondition reports the swap set up on the pool's nodes along with the nodes which have
+// not set it up yet, or could not.
+func setSwapCondition(pool *mcfgv1.MachineConfigPool, nodes []*corev1.Node, status *mcfgv1.MachineConfigPoolStatus) {
+	config, err := getSwap(pool)
+	if err != nil {
+		cond := apihelpers.NewMachineConfigPoolCondition(MachineConfigPoolSwap, corev1.ConditionFalse, invalidSwapReason, err.Error())
+		apihelpers.SetMachineConfigPoolCondition(status, *cond)
+		return
+	}
+
+	if config == nil {
+		apihelpers.RemoveMachineConfigPoolCondition(status, MachineConfigPoolSwap)
+		return
+	}
+
+	desired := config.String()
+
+	pending := []string{}
+	failed := []string{}
+	for _, node := range nodes {
+		swapStatus := ctrlcommon.GetSwapStatus(node)
+		switch {
+		case swapStatus == nil || swapStatus.Config != desired:
+			pending = append(pending, node.Name)
+		case swapStatus.Error != "":
+			failed = append(failed, fmt.Sprintf("%s (%s)", node.Name, swapStatus.Error))
+		}
+	}
+
+	if len(failed) != 0 {
+		msg := fmt.Sprintf("Swap could not be set up on %d nodes: %s", len(failed), strings.Join(failed, ", "))
+		cond := apihelpers.NewMachineConfigPoolCondition(MachineConfigPoolSwap, corev1.ConditionFalse, swapFailedReason, msg)
+		apihelpers.SetMachineConfigPoolCondition(status, *cond)
+		return
+	}
+
+	msg := fmt.Sprintf("%s swap of %s", config.Type, config.Size)
+	if config.Swappiness != nil {
+		msg = fmt.Sprintf("%s with swappiness %d", msg, *config.Swappiness)
+	}
+	if len(pending) != 0 {
+		msg = fmt.Sprintf("%s; not yet applied on %d nodes: %s", msg, len(pending), strings.Join(pending, ", "))
+	}
+
+	cond := apihelpers.NewMachineConfigPoolCondition(MachineConfigPoolSwap, corev1.ConditionTrue, "", msg)
+	apihelpers.SetMachineConfigPoolCondition(status, *cond)
+}
@@ -0,0 +1,75 @@
+package node
+
+import (
+	"fmt"
+	"strings"
+
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	"github.com/openshift/machine-config-operator/pkg/apihelpers"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	daemonconsts "github.com/openshift/machine-config-operator/pkg/daemon/constants"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// MachineConfigPoolUnmanagedPaths lists the paths which the pool excludes from MCO management in
+// its message. It is false when the pool's unmanaged paths are invalid, in which case the message
+// describes the problem instead and the nodes keep the paths they were given before.
+const MachineConfigPoolUnmanagedPaths mcfgv1.MachineConfigPoolConditionType = "UnmanagedPaths"
+
+// invalidUnmanagedPathsReason is the reason of the UnmanagedPaths condition when the pool's
+// unmanaged paths cannot be parsed.
+const invalidUnmanagedPathsReason = "InvalidUnmanagedPaths"
+
+// getUnmanagedPaths returns the paths which the pool excludes from MCO management.
+func getUnmanagedPaths(pool *mcfgv1.MachineConfigPool) ([]string, error) {
+	paths, err := ctrlcommon.ParseUnmanagedPaths(pool.Annotations[ctrlcommon.UnmanagedPathsAnnotationKey])
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s annotation: %w", ctrlcommon.UnmanagedPathsAnnotationKey, err)
+	}
+
+	return paths, nil
+}
+
+// getUnmanagedPathsNodeAnnotation returns the value of the node annotation which hands the pool's unmanaged paths to
+// the MCD, or "" if the annotation is to be removed.
+func getUnmanagedPathsNodeAnnotation(pool *mcfgv1.MachineConfigPool) (string, error) {
+	paths, err := getUnmanagedPaths(pool)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.Join(paths, ","), nil
+}
+
+// setUnmanagedPathsCondition reports the paths which the pool excludes from MCO management along
+// with the nodes which have not been handed them yet.
+func setUnmanagedPathsCondition(pool *mcfgv1.MachineConfigPool, nodes []*corev1.Node, status *mcfgv1.MachineConfigPoolStatus) {
+	paths, err := getUnmanagedPaths(pool)
+	if err != nil {
+		cond := apihelpers.NewMachineConfigPoolCondition(MachineConfigPoolUnmanagedPaths, corev1.ConditionFalse, invalidUnmanagedPathsReason, err.Error())
+		apihelpers.SetMachineConfigPoolCondition(status, *cond)
+		return
+	}
+
+	if len(paths) == 0 {
+		apihelpers.RemoveMachineConfigPoolCondition(status, MachineConfigPoolUnmanagedPaths)
+		return
+	}
+
+	desired := strings.Join(paths, ",")
+
+	pending := []string{}
+	for _, node := range nodes {
+		if node.Annotations[daemonconsts.UnmanagedPathsAnnotationKey] != desired {
+			pending = append(pending, node.Name)
+		}
+	}
+
+	msg := fmt.Sprintf("Not managed by the MCO: %s", strings.Join(paths, ", "))
+	if len(pending) != 0 {
+		msg = fmt.Sprintf("%s; not yet applied on %d nodes: %s", msg, len(pending), strings.Join(pending, ", "))
+	}
+
+	cond := apihelpers.NewMachineConfigPoolCondition(MachineConfigPoolUnmanagedPaths, corev1.ConditionTrue, "", msg)
+	apihelpers.SetMachineConfigPoolCondition(status, *cond)
+}
@@ -0,0 +1,154 @@
+package node
+
+import (
+	"encoding/json"
+	"fmt"
+
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	"github.com/openshift/machine-config-operator/pkg/apihelpers"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	"github.com/openshift/machine-config-operator/pkg/controller/drain"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// MachineConfigPoolEffectiveUpdatePolicy carries the effective update policy of the pool as a JSON
+// document in its message. It is false when the pool's update settings are invalid, in which
+// case the message describes the problem instead.
+const MachineConfigPoolEffectiveUpdatePolicy mcfgv1.MachineConfigPoolConditionType = "EffectiveUpdatePolicy"
+
+// invalidUpdatePolicyReason is the reason of the EffectiveUpdatePolicy condition when one of the
+// pool's update settings cannot be parsed.
+const invalidUpdatePolicyReason = "InvalidUpdatePolicy"
+
+// effectiveUpdatePolicy combines all of the settings which determine how the node controller
+// rolls out updates to the nodes in a pool, with defaults applied.
+type effectiveUpdatePolicy struct {
+	// Paused is whether updates are rolled out at all.
+	Paused bool `json:"paused"`
+	// MaxUnavailable is the configured number or percentage of nodes which may be updating at once.
+	MaxUnavailable string `json:"maxUnavailable"`
+	// MaxUnavailableNodes is MaxUnavailable resolved against the current number of nodes.
+	MaxUnavailableNodes int `json:"maxUnavailableNodes"`
+	// UpdateOrder is the order in which nodes are selected for an update.
+	UpdateOrder string `json:"updateOrder"`
+	// SafeMode is whether changes which require draining or rebooting nodes are deferred.
+	SafeMode bool `json:"safeMode"`
+	// CriticalWindowMaxDefer is how long pods in a critical window may defer a node update.
+	CriticalWindowMaxDefer string `json:"criticalWindowMaxDefer"`
+	// MinFreeDisk is the free disk space a node needs before it is updated, if any.
+	MinFreeDisk string `json:"minFreeDisk,omitempty"`
+	// MaxConcurrentImagePulls is how many nodes may be pulling a new OS image at once, if limited.
+	MaxConcurrentImagePulls int `json:"maxConcurrentImagePulls,omitempty"`
+	// DrainTimeout is how long a node drain is retried before the node is reported as degraded.
+	DrainTimeout string `json:"drainTimeout"`
+	// Layered is whether nodes are updated to an image built by on-cluster builds.
+	Layered bool `json:"layered"`
+	// BuildValidateOnly is whether built images are kept from being rolled out.
+	BuildValidateOnly bool `json:"buildValidateOnly,omitempty"`
+	// CanarySoak is how long a canary node must be Ready on a new image before the rest of the
+	// pool is updated to it, if canary rollouts are enabled.
+	CanarySoak string `json:"canarySoak,omitempty"`
+	// PeriodicRebootInterval is how long a node may run without a reboot before it is drained and
+	// rebooted, if periodic reboots are enabled.
+	PeriodicRebootInterval string `json:"periodicRebootInterval,omitempty"`
+	// PeriodicRebootWindow is the daily window in UTC in which periodic reboots may start, if limited.
+	PeriodicRebootWindow string `json:"periodicRebootWindow,omitempty"`
+}
+
+// getEffectiveUpdatePolicy computes the effective update policy of the pool from its spec and
+// annotations.
+func getEffectiveUpdatePolicy(pool *mcfgv1.MachineConfigPool, nodes []*corev1.Node) (*effectiveUpdatePolicy, error) {
+	maxUnavail, err := maxUnavailable(pool, nodes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid maxUnavailable: %w", err)
+	}
+
+	maxDefer, err := getCriticalWindowMaxDefer(pool)
+	if err != nil {
+		return nil, err
+	}
+
+	minFree, err := getMinFreeDisk(pool)
+	if err != nil {
+		return nil, err
+	}
+
+	maxPulls, err := getMaxConcurrentImagePulls(pool)
+	if err != nil {
+		return nil, err
+	}
+
+	canarySoak, canary, err := getCanarySoak(pool)
+	if err != nil {
+		return nil, err
+	}
+
+	reboots, err := getPeriodicRebootPolicy(pool)
+	if err != nil {
+		return nil, err
+	}
+
+	policy := &effectiveUpdatePolicy{
+		Paused:                  pool.Spec.Paused,
+		MaxUnavailable:          "1",
+		MaxUnavailableNodes:     maxUnavail,
+		UpdateOrder:             "zone",
+		SafeMode:                isSafeModePool(pool),
+		CriticalWindowMaxDefer:  maxDefer.String(),
+		DrainTimeout:            drain.DefaultConfig().DrainTimeoutDuration.String(),
+		Layered:                 ctrlcommon.IsLayeredPool(pool),
+		MaxConcurrentImagePulls: maxPulls,
+	}
+
+	if pool.Spec.MaxUnavailable != nil {
+		policy.MaxUnavailable = pool.Spec.MaxUnavailable.String()
+	}
+
+	if minFree != 0 {
+		policy.MinFreeDisk = resource.NewQuantity(minFree, resource.BinarySI).String()
+	}
+
+	if policy.Layered {
+		policy.BuildValidateOnly = ctrlcommon.NewLayeredPoolState(pool).IsValidateOnly()
+
+		if canary {
+			policy.CanarySoak = canarySoak.String()
+		}
+	}
+
+	if reboots != nil {
+		policy.PeriodicRebootInterval = reboots.interval.String()
+
+		if reboots.window != nil {
+			policy.PeriodicRebootWindow = reboots.window.String()
+		}
+	}
+
+	// The control plane node running the machine-config-operator is updated last.
+	if pool.Name == ctrlcommon.MachineConfigPoolMaster {
+		policy.UpdateOrder = "zone, machine-config-operator node last"
+	}
+
+	return policy, nil
+}
+
+// setEffectiveUpdatePolicyCondition publishes the effective update policy of the pool so that
+// the complete rollout behavior can be seen in one place.
+func setEffectiveUpdatePolicyCondition(pool *mcfgv1.MachineConfigPool, nodes []*corev1.Node, status *mcfgv1.MachineConfigPoolStatus) {
+	policy, err := getEffectiveUpdatePolicy(pool, nodes)
+	if err != nil {
+		cond := apihelpers.NewMachineConfigPoolCondition(MachineConfigPoolEffectiveUpdatePolicy, corev1.ConditionFalse, invalidUpdatePolicyReason, err.Error())
+		apihelpers.SetMachineConfigPoolCondition(status, *cond)
+		return
+	}
+
+	out, err := json.Marshal(policy)
+	if err != nil {
+		apihelpers.RemoveMachineConfigPoolCondition(status, MachineConfigPoolEffectiveUpdatePolicy)
+		return
+	}
+
+	cond := apihelpers.NewMachineConfigPoolCondition(MachineConfigPoolEffectiveUpdatePolicy, corev1.ConditionTrue, "", string(out))
+	apihelpers.SetMachineConfigPoolCondition(status, *cond)
+}
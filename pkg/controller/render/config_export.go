@@ -0,0 +1,453 @@
+package render
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	ign3types "github.com/coreos/ignition/v2/config/v3_4/types"
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	mcoResourceApply "github.com/openshift/machine-config-operator/lib/resourceapply"
+	"github.com/openshift/machine-config-operator/pkg/apihelpers"
+	"github.com/openshift/machine-config-operator/pkg/controller/build"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+// MachineConfigPoolImportedConfigDrift is true when the rendered config of a pool does not match the
+// config it imported from another cluster, e.g. because a local MachineConfig overrides an imported
+// file. The message lists the differences.
+const MachineConfigPoolImportedConfigDrift mcfgv1.MachineConfigPoolConditionType = "ImportedConfigDrift"
+
+// configExportKey is the key of config export and import ConfigMaps which holds the config export.
+const configExportKey = "config-export.json"
+
+// configExport is a pool's desired config as published in its config export ConfigMap. Only the
+// MachineConfigs which were not generated by the MCO are exported, since the generated ones hold
+// cluster specific settings such as the kubelet CA and are generated in every cluster anyway.
+type configExport struct {
+	// Pool is the name of the exported MachineConfigPool.
+	Pool string `json:"pool"`
+	// RenderedConfig is the name of the rendered MachineConfig the export was made from.
+	RenderedConfig string `json:"renderedConfig"`
+	// MachineConfig is the merged spec of the pool's MachineConfigs which were not generated by the MCO.
+	MachineConfig mcfgv1.MachineConfigSpec `json:"machineConfig"`
+	// Containerfile is the pool's custom Containerfile for on-cluster builds, if any.
+	Containerfile string `json:"containerfile,omitempty"`
+	// Image is the pullspec of the pool's on-cluster built image, if any.
+	Image string `json:"image,omitempty"`
+}
+
+// getConfigExportName returns the name of the config export ConfigMap of the pool.
+func getConfigExportName(pool *mcfgv1.MachineConfigPool) string {
+	return fmt.Sprintf("%s-config-export", pool.Name)
+}
+
+// getImportedMachineConfigName returns the name of the MachineConfig holding the config imported into the pool.
+func getImportedMachineConfigName(pool *mcfgv1.MachineConfigPool) string {
+	return fmt.Sprintf("99-%s-imported", pool.Name)
+}
+
+// getPoolContainerfile returns the pool's custom Containerfile for on-cluster builds, if any.
+func (ctrl *Controller) getPoolContainerfile(pool *mcfgv1.MachineConfigPool) (string, error) {
+	cm, err := ctrl.cmLister.ConfigMaps(ctrlcommon.MCONamespace).Get(build.CustomDockerfileConfigMapName)
+	if apierrors.IsNotFound(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return cm.Data[pool.Name], nil
+}
+
+// newConfigExport packages the MachineConfigs of the pool which were not generated by the MCO, along
+// with its custom Containerfile and built image.
+func newConfigExport(pool *mcfgv1.MachineConfigPool, rendered string, configs []*mcfgv1.MachineConfig, cconfig *mcfgv1.ControllerConfig, containerfile string) (*configExport, error) {
+	userConfigs := []*mcfgv1.MachineConfig{}
+	for _, config := range configs {
+		if _, ok := config.Annotations[ctrlcommon.GeneratedByControllerVersionAnnotationKey]; !ok {
+			userConfigs = append(userConfigs, config)
+		}
+	}
+
+	export := &configExport{
+		Pool:           pool.Name,
+		RenderedConfig: rendered,
+		Containerfile:  containerfile,
+	}
+
+	if lps := ctrlcommon.NewLayeredPoolState(pool); lps.IsLayered() && lps.HasOSImage() {
+		export.Image = lps.GetOSImage()
+	}
+
+	if len(userConfigs) == 0 {
+		return export, nil
+	}
+
+	merged, err := ctrlcommon.MergeMachineConfigs(userConfigs, cconfig)
+	if err != nil {
+		return nil, err
+	}
+
+	export.MachineConfig = merged.Spec
+
+	// Merging fills in the cluster's defaults, which must not be carried over to clusters which may
+	// run a different release. FIPS can only be enabled at install time.
+	if export.MachineConfig.OSImageURL == ctrlcommon.GetDefaultBaseImageContainer(&cconfig.Spec) {
+		export.MachineConfig.OSImageURL = ""
+	}
+	if export.MachineConfig.BaseOSExtensionsContainerImage == cconfig.Spec.BaseOSExtensionsContainerImage {
+		export.MachineConfig.BaseOSExtensionsContainerImage = ""
+	}
+	if export.MachineConfig.KernelType == ctrlcommon.KernelTypeDefault {
+		export.MachineConfig.KernelType = ""
+	}
+	export.MachineConfig.FIPS = false
+
+	return export, nil
+}
+
+// syncConfigExport publishes the config of the pool in its config export ConfigMap if the pool opted
+// in, and deletes the ConfigMap otherwise.
+func (ctrl *Controller) syncConfigExport(pool *mcfgv1.MachineConfigPool, rendered string, configs []*mcfgv1.MachineConfig, cconfig *mcfgv1.ControllerConfig) error {
+	name := getConfigExportName(pool)
+
+	current, err := ctrl.cmLister.ConfigMaps(ctrlcommon.MCONamespace).Get(name)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+
+	if pool.Annotations[ctrlcommon.ExportConfigAnnotationKey] != "true" {
+		if current == nil || current.Labels[ctrlcommon.ConfigExportLabelKey] != pool.Name {
+			return nil
+		}
+
+		err := ctrl.kubeClient.CoreV1().ConfigMaps(ctrlcommon.MCONamespace).Delete(context.TODO(), name, metav1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("could not delete config export %s: %w", name, err)
+		}
+
+		klog.V(2).Infof("Deleted config export %s of pool %s", name, pool.Name)
+		return nil
+	}
+
+	containerfile, err := ctrl.getPoolContainerfile(pool)
+	if err != nil {
+		return err
+	}
+
+	export, err := newConfigExport(pool, rendered, configs, cconfig, containerfile)
+	if err != nil {
+		return fmt.Errorf("could not export config of pool %s: %w", pool.Name, err)
+	}
+
+	out, err := json.Marshal(export)
+	if err != nil {
+		return err
+	}
+
+	if current != nil && current.Data[configExportKey] == string(out) {
+		return nil
+	}
+
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: ctrlcommon.MCONamespace,
+			Labels: map[string]string{
+				ctrlcommon.ConfigExportLabelKey: pool.Name,
+			},
+		},
+		Data: map[string]string{
+			configExportKey: string(out),
+		},
+	}
+
+	if current == nil {
+		_, err = ctrl.kubeClient.CoreV1().ConfigMaps(ctrlcommon.MCONamespace).Create(context.TODO(), cm, metav1.CreateOptions{})
+	} else {
+		cm.ResourceVersion = current.ResourceVersion
+		_, err = ctrl.kubeClient.CoreV1().ConfigMaps(ctrlcommon.MCONamespace).Update(context.TODO(), cm, metav1.UpdateOptions{})
+	}
+	if err != nil {
+		return fmt.Errorf("could not write config export %s: %w", name, err)
+	}
+
+	klog.V(2).Infof("Exported config %s of pool %s to %s", rendered, pool.Name, name)
+	return nil
+}
+
+// getConfigImport returns the config export which is imported into the pool, if any.
+func (ctrl *Controller) getConfigImport(pool *mcfgv1.MachineConfigPool) (*configExport, error) {
+	selector := labels.SelectorFromSet(labels.Set{ctrlcommon.ConfigImportLabelKey: pool.Name})
+
+	cms, err := ctrl.cmLister.ConfigMaps(ctrlcommon.MCONamespace).List(selector)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(cms) == 0 {
+		return nil, nil
+	}
+
+	if len(cms) > 1 {
+		names := []string{}
+		for _, cm := range cms {
+			names = append(names, cm.Name)
+		}
+		return nil, fmt.Errorf("only one config import is allowed per pool, found %s", strings.Join(names, ", "))
+	}
+
+	export := &configExport{}
+	if err := json.Unmarshal([]byte(cms[0].Data[configExportKey]), export); err != nil {
+		return nil, fmt.Errorf("could not parse %s in config import %s: %w", configExportKey, cms[0].Name, err)
+	}
+
+	return export, nil
+}
+
+// syncConfigImport applies the config imported into the pool as a MachineConfig, which the pool then
+// renders like any other MachineConfig. The MachineConfig is deleted once the import is removed.
+func (ctrl *Controller) syncConfigImport(pool *mcfgv1.MachineConfigPool) error {
+	export, err := ctrl.getConfigImport(pool)
+	if err != nil {
+		return err
+	}
+
+	name := getImportedMachineConfigName(pool)
+
+	if export == nil {
+		current, err := ctrl.mcLister.Get(name)
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		// Do not delete a MachineConfig which happens to have the same name.
+		if _, ok := current.Annotations[ctrlcommon.ImportedFromAnnotationKey]; !ok {
+			return nil
+		}
+
+		if err := ctrl.client.MachineconfigurationV1().MachineConfigs().Delete(context.TODO(), name, metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("could not delete imported MachineConfig %s: %w", name, err)
+		}
+
+		klog.V(2).Infof("Deleted imported MachineConfig %s of pool %s", name, pool.Name)
+		return nil
+	}
+
+	if pool.Spec.MachineConfigSelector == nil || len(pool.Spec.MachineConfigSelector.MatchLabels) == 0 {
+		return fmt.Errorf("cannot import config into pool %s: its machineConfigSelector has no matchLabels to label the imported MachineConfig with", pool.Name)
+	}
+
+	if err := ctrlcommon.ValidateMachineConfig(export.MachineConfig); err != nil {
+		return fmt.Errorf("invalid config imported from %s: %w", export.RenderedConfig, err)
+	}
+
+	mc := &mcfgv1.MachineConfig{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   name,
+			Labels: map[string]string{},
+			Annotations: map[string]string{
+				ctrlcommon.ImportedFromAnnotationKey: export.RenderedConfig,
+			},
+		},
+		Spec: export.MachineConfig,
+	}
+
+	for k, v := range pool.Spec.MachineConfigSelector.MatchLabels {
+		mc.Labels[k] = v
+	}
+
+	if mc.Spec.Config.Raw == nil {
+		ignCfg := ctrlcommon.NewIgnConfig()
+		raw, err := json.Marshal(ignCfg)
+		if err != nil {
+			return err
+		}
+		mc.Spec.Config.Raw = raw
+	}
+
+	_, updated, err := mcoResourceApply.ApplyMachineConfig(ctrl.client.MachineconfigurationV1(), mc)
+	if err != nil {
+		return fmt.Errorf("could not apply imported MachineConfig %s: %w", name, err)
+	}
+
+	if updated {
+		klog.V(2).Infof("Imported config %s into pool %s as %s", export.RenderedConfig, pool.Name, name)
+		ctrl.eventRecorder.Eventf(pool, corev1.EventTypeNormal, "ConfigImported", "Imported config %s as MachineConfig %s", export.RenderedConfig, name)
+	}
+
+	return nil
+}
+
+// getConfigDrift lists how the rendered config differs from the imported config.
+func getConfigDrift(export *configExport, rendered *mcfgv1.MachineConfig, containerfile string) ([]string, error) {
+	drift := []string{}
+
+	if export.MachineConfig.Config.Raw != nil {
+		importedIgn, err := ctrlcommon.ParseAndConvertConfig(export.MachineConfig.Config.Raw)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse imported Ignition config: %w", err)
+		}
+
+		renderedIgn, err := ctrlcommon.ParseAndConvertConfig(rendered.Spec.Config.Raw)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse rendered Ignition config: %w", err)
+		}
+
+		renderedFiles := map[string]ign3types.File{}
+		for _, file := range renderedIgn.Storage.Files {
+			renderedFiles[file.Path] = file
+		}
+
+		for _, file := range importedIgn.Storage.Files {
+			renderedFile, ok := renderedFiles[file.Path]
+			switch {
+			case !ok:
+				drift = append(drift, fmt.Sprintf("file %s is missing", file.Path))
+			case !reflect.DeepEqual(file.Contents.Source, renderedFile.Contents.Source):
+				drift = append(drift, fmt.Sprintf("file %s has different contents", file.Path))
+			case !reflect.DeepEqual(file.Mode, renderedFile.Mode):
+				drift = append(drift, fmt.Sprintf("file %s has a different mode", file.Path))
+			}
+		}
+
+		renderedUnits := map[string]ign3types.Unit{}
+		for _, unit := range renderedIgn.Systemd.Units {
+			renderedUnits[unit.Name] = unit
+		}
+
+		for _, unit := range importedIgn.Systemd.Units {
+			renderedUnit, ok := renderedUnits[unit.Name]
+			switch {
+			case !ok:
+				drift = append(drift, fmt.Sprintf("unit %s is missing", unit.Name))
+			case !reflect.DeepEqual(unit, renderedUnit):
+				drift = append(drift, fmt.Sprintf("unit %s differs", unit.Name))
+			}
+		}
+	}
+
+	for _, karg := range export.MachineConfig.KernelArguments {
+		if !ctrlcommon.InSlice(karg, rendered.Spec.KernelArguments) {
+			drift = append(drift, fmt.Sprintf("kernel argument %s is missing", karg))
+		}
+	}
+
+	for _, ext := range export.MachineConfig.Extensions {
+		if !ctrlcommon.InSlice(ext, rendered.Spec.Extensions) {
+			drift = append(drift, fmt.Sprintf("extension %s is missing", ext))
+		}
+	}
+
+	if export.MachineConfig.KernelType != "" && export.MachineConfig.KernelType != rendered.Spec.KernelType {
+		drift = append(drift, fmt.Sprintf("kernel type is %s instead of %s", rendered.Spec.KernelType, export.MachineConfig.KernelType))
+	}
+
+	if export.MachineConfig.OSImageURL != "" && export.MachineConfig.OSImageURL != rendered.Spec.OSImageURL {
+		drift = append(drift, fmt.Sprintf("OS image is %s instead of %s", rendered.Spec.OSImageURL, export.MachineConfig.OSImageURL))
+	}
+
+	if export.Containerfile != containerfile {
+		drift = append(drift, "custom Containerfile differs")
+	}
+
+	return drift, nil
+}
+
+// syncImportedConfigDriftStatus reports whether the rendered config of the pool matches the config
+// it imported, if any.
+func (ctrl *Controller) syncImportedConfigDriftStatus(pool *mcfgv1.MachineConfigPool, rendered *mcfgv1.MachineConfig) error {
+	export, err := ctrl.getConfigImport(pool)
+	if err != nil {
+		return err
+	}
+
+	newStatus := pool.Status.DeepCopy()
+
+	if export == nil {
+		apihelpers.RemoveMachineConfigPoolCondition(newStatus, MachineConfigPoolImportedConfigDrift)
+	} else {
+		containerfile, err := ctrl.getPoolContainerfile(pool)
+		if err != nil {
+			return err
+		}
+
+		drift, err := getConfigDrift(export, rendered, containerfile)
+		if err != nil {
+			return err
+		}
+
+		var cond *mcfgv1.MachineConfigPoolCondition
+		if len(drift) == 0 {
+			cond = apihelpers.NewMachineConfigPoolCondition(MachineConfigPoolImportedConfigDrift, corev1.ConditionFalse, "InSync", fmt.Sprintf("Rendered config %s matches imported config %s", rendered.Name, export.RenderedConfig))
+		} else {
+			cond = apihelpers.NewMachineConfigPoolCondition(MachineConfigPoolImportedConfigDrift, corev1.ConditionTrue, "Drifted", fmt.Sprintf("Rendered config %s differs from imported config %s: %s", rendered.Name, export.RenderedConfig, strings.Join(drift, "; ")))
+		}
+		apihelpers.SetMachineConfigPoolCondition(newStatus, *cond)
+	}
+
+	if reflect.DeepEqual(newStatus.Conditions, pool.Status.Conditions) {
+		return nil
+	}
+
+	pool.Status = *newStatus
+	_, err = ctrl.client.MachineconfigurationV1().MachineConfigPools().UpdateStatus(context.TODO(), pool, metav1.UpdateOptions{})
+	return err
+}
+
+// Enqueues the pools a config import ConfigMap is for, and all pools when the custom Containerfiles
+// change, since those are part of config exports.
+func (ctrl *Controller) enqueuePoolsForConfigImport(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		utilruntime.HandleError(fmt.Errorf("Couldn't get object metadata %#v: %w", obj, err))
+		return
+	}
+
+	if accessor.GetNamespace() != ctrlcommon.MCONamespace {
+		return
+	}
+
+	if poolName, ok := accessor.GetLabels()[ctrlcommon.ConfigImportLabelKey]; ok {
+		pool, err := ctrl.mcpLister.Get(poolName)
+		if err != nil {
+			klog.V(4).Infof("Could not get pool %s of config import %s: %v", poolName, accessor.GetName(), err)
+			return
+		}
+
+		ctrl.enqueueMachineConfigPool(pool)
+		return
+	}
+
+	if accessor.GetName() != build.CustomDockerfileConfigMapName {
+		return
+	}
+
+	pools, err := ctrl.mcpLister.List(labels.Everything())
+	if err != nil {
+		utilruntime.HandleError(fmt.Errorf("Couldn't list MachineConfigPools: %w", err))
+		return
+	}
+
+	for _, pool := range pools {
+		ctrl.enqueueMachineConfigPool(pool)
+	}
+}
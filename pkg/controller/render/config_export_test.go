@@ -0,0 +1,159 @@
+package render
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	ign3types "github.com/coreos/ignition/v2/config/v3_4/types"
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	"github.com/openshift/machine-config-operator/pkg/controller/build"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	"github.com/openshift/machine-config-operator/pkg/version"
+	"github.com/openshift/machine-config-operator/test/helpers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	core "k8s.io/client-go/testing"
+)
+
+func newConfigExportMachineConfigs() []*mcfgv1.MachineConfig {
+	generated := helpers.NewMachineConfig("00-master", map[string]string{"node-role/master": ""}, "dummy", []ign3types.File{
+		ctrlcommon.NewIgnFile("/etc/kubernetes/kubelet-ca.crt", "cluster specific"),
+	})
+	generated.Annotations = map[string]string{ctrlcommon.GeneratedByControllerVersionAnnotationKey: version.Hash}
+
+	user := helpers.NewMachineConfig("50-user", map[string]string{"node-role/master": ""}, "", []ign3types.File{
+		ctrlcommon.NewIgnFile("/etc/user.conf", "user"),
+	})
+	user.Spec.KernelArguments = []string{"nosmt"}
+
+	return []*mcfgv1.MachineConfig{generated, user}
+}
+
+func TestNewConfigExport(t *testing.T) {
+	pool := helpers.NewMachineConfigPool("master", helpers.MasterSelector, nil, "rendered-master-1")
+	cc := newControllerConfig(ctrlcommon.ControllerConfigName)
+
+	export, err := newConfigExport(pool, "rendered-master-1", newConfigExportMachineConfigs(), cc, "FROM configs AS final")
+	require.NoError(t, err)
+
+	assert.Equal(t, "master", export.Pool)
+	assert.Equal(t, "rendered-master-1", export.RenderedConfig)
+	assert.Equal(t, "FROM configs AS final", export.Containerfile)
+	assert.Equal(t, []string{"nosmt"}, export.MachineConfig.KernelArguments)
+
+	// The cluster's defaults are not exported.
+	assert.Empty(t, export.MachineConfig.OSImageURL)
+	assert.Empty(t, export.MachineConfig.KernelType)
+
+	// Only the MachineConfigs which were not generated by the MCO are exported.
+	ignCfg, err := ctrlcommon.ParseAndConvertConfig(export.MachineConfig.Config.Raw)
+	require.NoError(t, err)
+	require.Len(t, ignCfg.Storage.Files, 1)
+	assert.Equal(t, "/etc/user.conf", ignCfg.Storage.Files[0].Path)
+}
+
+func TestGetConfigDrift(t *testing.T) {
+	pool := helpers.NewMachineConfigPool("master", helpers.MasterSelector, nil, "rendered-master-1")
+	cc := newControllerConfig(ctrlcommon.ControllerConfigName)
+	configs := newConfigExportMachineConfigs()
+
+	export, err := newConfigExport(pool, "rendered-master-1", configs, cc, "")
+	require.NoError(t, err)
+
+	rendered, err := ctrlcommon.MergeMachineConfigs(configs, cc)
+	require.NoError(t, err)
+
+	drift, err := getConfigDrift(export, rendered, "")
+	require.NoError(t, err)
+	assert.Empty(t, drift)
+
+	// A local MachineConfig overrides an imported file and the kernel argument is gone.
+	override := helpers.NewMachineConfig("99-override", map[string]string{"node-role/master": ""}, "", []ign3types.File{
+		ctrlcommon.NewIgnFile("/etc/user.conf", "local"),
+	})
+	rendered, err = ctrlcommon.MergeMachineConfigs([]*mcfgv1.MachineConfig{configs[0], override}, cc)
+	require.NoError(t, err)
+
+	drift, err = getConfigDrift(export, rendered, "FROM configs AS final")
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"file /etc/user.conf has different contents",
+		"kernel argument nosmt is missing",
+		"custom Containerfile differs",
+	}, drift)
+}
+
+func TestSyncConfigExport(t *testing.T) {
+	f := newFixture(t)
+	pool := helpers.NewMachineConfigPool("master", helpers.MasterSelector, nil, "rendered-master-1")
+	pool.Annotations = map[string]string{ctrlcommon.ExportConfigAnnotationKey: "true"}
+	f.kubeobjects = append(f.kubeobjects, &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: build.CustomDockerfileConfigMapName, Namespace: ctrlcommon.MCONamespace},
+		Data:       map[string]string{"master": "FROM configs AS final"},
+	})
+	c := f.newController()
+
+	err := c.syncConfigExport(pool, "rendered-master-1", newConfigExportMachineConfigs(), newControllerConfig(ctrlcommon.ControllerConfigName))
+	require.NoError(t, err)
+
+	cm, err := c.kubeClient.CoreV1().ConfigMaps(ctrlcommon.MCONamespace).Get(context.TODO(), "master-config-export", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "master", cm.Labels[ctrlcommon.ConfigExportLabelKey])
+
+	export := &configExport{}
+	require.NoError(t, json.Unmarshal([]byte(cm.Data[configExportKey]), export))
+	assert.Equal(t, "rendered-master-1", export.RenderedConfig)
+	assert.Equal(t, "FROM configs AS final", export.Containerfile)
+}
+
+func TestSyncConfigImport(t *testing.T) {
+	pool := helpers.NewMachineConfigPool("master", helpers.MasterSelector, nil, "rendered-master-1")
+	cc := newControllerConfig(ctrlcommon.ControllerConfigName)
+
+	export, err := newConfigExport(pool, "rendered-master-source", newConfigExportMachineConfigs(), cc, "")
+	require.NoError(t, err)
+	out, err := json.Marshal(export)
+	require.NoError(t, err)
+
+	t.Run("import is applied as a MachineConfig", func(t *testing.T) {
+		f := newFixture(t)
+		f.mcpLister = append(f.mcpLister, pool)
+		f.kubeobjects = append(f.kubeobjects, &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "source-master",
+				Namespace: ctrlcommon.MCONamespace,
+				Labels:    map[string]string{ctrlcommon.ConfigImportLabelKey: "master"},
+			},
+			Data: map[string]string{configExportKey: string(out)},
+		})
+		c := f.newController()
+
+		require.NoError(t, c.syncConfigImport(pool))
+
+		mc, err := f.client.MachineconfigurationV1().MachineConfigs().Get(context.TODO(), "99-master-imported", metav1.GetOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, map[string]string{"node-role/master": ""}, mc.Labels)
+		assert.Equal(t, "rendered-master-source", mc.Annotations[ctrlcommon.ImportedFromAnnotationKey])
+		assert.Equal(t, []string{"nosmt"}, mc.Spec.KernelArguments)
+	})
+
+	t.Run("imported MachineConfig is deleted with the import", func(t *testing.T) {
+		f := newFixture(t)
+		imported := helpers.NewMachineConfig("99-master-imported", map[string]string{"node-role/master": ""}, "", nil)
+		imported.Annotations = map[string]string{ctrlcommon.ImportedFromAnnotationKey: "rendered-master-source"}
+		f.mcpLister = append(f.mcpLister, pool)
+		f.mcLister = append(f.mcLister, imported)
+		f.objects = append(f.objects, imported)
+		c := f.newController()
+
+		require.NoError(t, c.syncConfigImport(pool))
+
+		actions := filterInformerActions(f.client.Actions())
+		require.Len(t, actions, 1)
+		assert.True(t, actions[0].Matches("delete", "machineconfigs"))
+		assert.Equal(t, "99-master-imported", actions[0].(core.DeleteAction).GetName())
+	})
+}
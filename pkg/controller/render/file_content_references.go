@@ -0,0 +1,268 @@
+package render
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+
+	ign3types "github.com/coreos/ignition/v2/config/v3_4/types"
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	"github.com/vincent-petithory/dataurl"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/labels"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+// fileContentReference points the contents of a file in a MachineConfig at a
+// key in a ConfigMap or Secret in the MCO namespace. This allows values such as
+// registry hostnames or tokens to be rotated without editing the
+// MachineConfig. The file must still be present in the MachineConfig (e.g.,
+// with empty contents) so that its mode, owner and overwrite settings are
+// known.
+type fileContentReference struct {
+	// Path of the file in the MachineConfig.
+	Path string `json:"path"`
+	// Name of the ConfigMap to read the contents from.
+	ConfigMap string `json:"configMap,omitempty"`
+	// Name of the Secret to read the contents from.
+	Secret string `json:"secret,omitempty"`
+	// Key in the ConfigMap or Secret.
+	Key string `json:"key"`
+}
+
+// Gets the file content references from the MachineConfig annotation, if any.
+func getFileContentReferences(mc *mcfgv1.MachineConfig) ([]fileContentReference, error) {
+	val, ok := mc.Annotations[ctrlcommon.FileContentReferencesAnnotationKey]
+	if !ok || val == "" {
+		return nil, nil
+	}
+
+	refs := []fileContentReference{}
+	if err := json.Unmarshal([]byte(val), &refs); err != nil {
+		return nil, fmt.Errorf("could not parse %s on MachineConfig %s: %w", ctrlcommon.FileContentReferencesAnnotationKey, mc.Name, err)
+	}
+
+	for _, ref := range refs {
+		if !filepath.IsAbs(ref.Path) {
+			return nil, fmt.Errorf("file content reference path %q on MachineConfig %s must be absolute", ref.Path, mc.Name)
+		}
+
+		if (ref.ConfigMap == "") == (ref.Secret == "") {
+			return nil, fmt.Errorf("file content reference for %q on MachineConfig %s must set exactly one of configMap or secret", ref.Path, mc.Name)
+		}
+
+		if ref.Key == "" {
+			return nil, fmt.Errorf("file content reference for %q on MachineConfig %s must set a key", ref.Path, mc.Name)
+		}
+	}
+
+	return refs, nil
+}
+
+// Determines whether the MachineConfig references the given ConfigMap or Secret.
+func referencesFileContentSource(mc *mcfgv1.MachineConfig, name string, isSecret bool) bool {
+	refs, err := getFileContentReferences(mc)
+	if err != nil {
+		return false
+	}
+
+	for _, ref := range refs {
+		if isSecret && ref.Secret == name {
+			return true
+		}
+
+		if !isSecret && ref.ConfigMap == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Reads the contents that a file content reference points at.
+func (ctrl *Controller) getFileContentReferenceData(ref fileContentReference) ([]byte, error) {
+	if ref.Secret != "" {
+		secret, err := ctrl.secretLister.Secrets(ctrlcommon.MCONamespace).Get(ref.Secret)
+		if err != nil {
+			return nil, fmt.Errorf("could not get Secret %s: %w", ref.Secret, err)
+		}
+
+		data, ok := secret.Data[ref.Key]
+		if !ok {
+			return nil, fmt.Errorf("key %q not found in Secret %s", ref.Key, ref.Secret)
+		}
+
+		return data, nil
+	}
+
+	cm, err := ctrl.cmLister.ConfigMaps(ctrlcommon.MCONamespace).Get(ref.ConfigMap)
+	if err != nil {
+		return nil, fmt.Errorf("could not get ConfigMap %s: %w", ref.ConfigMap, err)
+	}
+
+	if data, ok := cm.Data[ref.Key]; ok {
+		return []byte(data), nil
+	}
+
+	if data, ok := cm.BinaryData[ref.Key]; ok {
+		return data, nil
+	}
+
+	return nil, fmt.Errorf("key %q not found in ConfigMap %s", ref.Key, ref.ConfigMap)
+}
+
+// Returns the given MachineConfigs with the contents of any referenced files
+// filled in. MachineConfigs without file content references are returned
+// as-is; the others are copied so that the lister cache is not mutated.
+func (ctrl *Controller) resolveFileContentReferences(configs []*mcfgv1.MachineConfig) ([]*mcfgv1.MachineConfig, error) {
+	out := []*mcfgv1.MachineConfig{}
+
+	for _, config := range configs {
+		refs, err := getFileContentReferences(config)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(refs) == 0 {
+			out = append(out, config)
+			continue
+		}
+
+		ignCfg, err := ctrlcommon.ParseAndConvertConfig(config.Spec.Config.Raw)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse Ignition config of MachineConfig %s: %w", config.Name, err)
+		}
+
+		for _, ref := range refs {
+			data, err := ctrl.getFileContentReferenceData(ref)
+			if err != nil {
+				return nil, fmt.Errorf("could not resolve file content reference for %q on MachineConfig %s: %w", ref.Path, config.Name, err)
+			}
+
+			if err := setIgnFileContents(&ignCfg, ref.Path, data); err != nil {
+				return nil, fmt.Errorf("could not resolve file content reference on MachineConfig %s: %w", config.Name, err)
+			}
+		}
+
+		raw, err := json.Marshal(ignCfg)
+		if err != nil {
+			return nil, fmt.Errorf("could not encode Ignition config of MachineConfig %s: %w", config.Name, err)
+		}
+
+		resolved := config.DeepCopy()
+		resolved.Spec.Config.Raw = raw
+		out = append(out, resolved)
+	}
+
+	return out, nil
+}
+
+// Replaces the contents of the file at the given path in the Ignition config.
+func setIgnFileContents(ignCfg *ign3types.Config, path string, data []byte) error {
+	for i := range ignCfg.Storage.Files {
+		file := &ignCfg.Storage.Files[i]
+		if file.Path != path {
+			continue
+		}
+
+		source := dataurl.EncodeBytes(data)
+		file.Contents = ign3types.Resource{
+			Source: &source,
+		}
+		return nil
+	}
+
+	return fmt.Errorf("file %q not found", path)
+}
+
+// Enqueues the MachineConfigPools of every MachineConfig which references the
+// given ConfigMap or Secret so that the change is rendered.
+func (ctrl *Controller) enqueuePoolsForFileContentSource(obj interface{}, isSecret bool) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		utilruntime.HandleError(fmt.Errorf("Couldn't get object metadata %#v: %w", obj, err))
+		return
+	}
+
+	if accessor.GetNamespace() != ctrlcommon.MCONamespace {
+		return
+	}
+
+	mcs, err := ctrl.mcLister.List(labels.Everything())
+	if err != nil {
+		utilruntime.HandleError(fmt.Errorf("Couldn't list MachineConfigs: %w", err))
+		return
+	}
+
+	for _, mc := range mcs {
+		if !referencesFileContentSource(mc, accessor.GetName(), isSecret) {
+			continue
+		}
+
+		pools, err := ctrl.getPoolsForMachineConfig(mc)
+		if err != nil {
+			klog.Errorf("error finding pools for machineconfig: %v", err)
+			continue
+		}
+
+		klog.V(4).Infof("File content source %s referenced by MachineConfig %s changed", accessor.GetName(), mc.Name)
+		for _, p := range pools {
+			ctrl.enqueueMachineConfigPool(p)
+		}
+	}
+}
+
+func (ctrl *Controller) addConfigMap(obj interface{}) {
+	ctrl.enqueuePoolsForFileContentSource(obj, false)
+	ctrl.enqueuePoolsForConfigImport(obj)
+}
+
+func (ctrl *Controller) updateConfigMap(old, cur interface{}) {
+	if hasResourceVersionChanged(old, cur) {
+		ctrl.enqueuePoolsForFileContentSource(cur, false)
+		// The config import may have moved to another pool.
+		ctrl.enqueuePoolsForConfigImport(old)
+		ctrl.enqueuePoolsForConfigImport(cur)
+	}
+}
+
+func (ctrl *Controller) deleteConfigMap(obj interface{}) {
+	ctrl.enqueuePoolsForFileContentSource(obj, false)
+	ctrl.enqueuePoolsForConfigImport(obj)
+}
+
+func (ctrl *Controller) addSecret(obj interface{}) {
+	ctrl.enqueuePoolsForFileContentSource(obj, true)
+}
+
+func (ctrl *Controller) updateSecret(old, cur interface{}) {
+	if hasResourceVersionChanged(old, cur) {
+		ctrl.enqueuePoolsForFileContentSource(cur, true)
+	}
+}
+
+func (ctrl *Controller) deleteSecret(obj interface{}) {
+	ctrl.enqueuePoolsForFileContentSource(obj, true)
+}
+
+// Periodic resyncs deliver updates for unchanged objects, which can be ignored.
+func hasResourceVersionChanged(old, cur interface{}) bool {
+	oldAccessor, err := meta.Accessor(old)
+	if err != nil {
+		return true
+	}
+
+	curAccessor, err := meta.Accessor(cur)
+	if err != nil {
+		return true
+	}
+
+	return oldAccessor.GetResourceVersion() != curAccessor.GetResourceVersion()
+}
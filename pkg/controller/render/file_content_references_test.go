@@ -0,0 +1,199 @@
+package render
+
+import (
+	"testing"
+
+	ign3types "github.com/coreos/ignition/v2/config/v3_4/types"
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	"github.com/openshift/machine-config-operator/test/helpers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func newFileContentReferencesMachineConfig(refs string) *mcfgv1.MachineConfig {
+	mc := helpers.NewMachineConfig("50-registry", map[string]string{"node-role/master": ""}, "", []ign3types.File{
+		ctrlcommon.NewIgnFile("/etc/registry.conf", ""),
+		ctrlcommon.NewIgnFile("/etc/registry-token", ""),
+		ctrlcommon.NewIgnFile("/etc/unrelated", "unchanged"),
+	})
+	mc.Annotations = map[string]string{ctrlcommon.FileContentReferencesAnnotationKey: refs}
+	return mc
+}
+
+func newFileContentSources() []*corev1.ConfigMap {
+	return []*corev1.ConfigMap{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "registry-config", Namespace: ctrlcommon.MCONamespace},
+			Data:       map[string]string{"registry.conf": "registry.example.com"},
+		},
+	}
+}
+
+func newFileContentSecret() *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "registry-token", Namespace: ctrlcommon.MCONamespace},
+		Data:       map[string][]byte{"token": []byte("s3cr3t")},
+	}
+}
+
+func getIgnFileContents(t *testing.T, mc *mcfgv1.MachineConfig, path string) string {
+	t.Helper()
+
+	ignCfg, err := ctrlcommon.ParseAndConvertConfig(mc.Spec.Config.Raw)
+	require.NoError(t, err)
+
+	for _, file := range ignCfg.Storage.Files {
+		if file.Path == path {
+			contents, err := ctrlcommon.DecodeIgnitionFileContents(file.Contents.Source, file.Contents.Compression)
+			require.NoError(t, err)
+			return string(contents)
+		}
+	}
+
+	t.Fatalf("file %s not found in MachineConfig %s", path, mc.Name)
+	return ""
+}
+
+func TestGetFileContentReferences(t *testing.T) {
+	testCases := []struct {
+		name        string
+		refs        string
+		expected    []fileContentReference
+		errExpected bool
+	}{
+		{
+			name: "no references",
+		},
+		{
+			name: "configmap and secret",
+			refs: `[{"path":"/etc/registry.conf","configMap":"registry-config","key":"registry.conf"},{"path":"/etc/registry-token","secret":"registry-token","key":"token"}]`,
+			expected: []fileContentReference{
+				{Path: "/etc/registry.conf", ConfigMap: "registry-config", Key: "registry.conf"},
+				{Path: "/etc/registry-token", Secret: "registry-token", Key: "token"},
+			},
+		},
+		{
+			name:        "invalid JSON",
+			refs:        `{"path":`,
+			errExpected: true,
+		},
+		{
+			name:        "relative path",
+			refs:        `[{"path":"etc/registry.conf","configMap":"registry-config","key":"registry.conf"}]`,
+			errExpected: true,
+		},
+		{
+			name:        "both configmap and secret",
+			refs:        `[{"path":"/etc/registry.conf","configMap":"registry-config","secret":"registry-token","key":"registry.conf"}]`,
+			errExpected: true,
+		},
+		{
+			name:        "neither configmap nor secret",
+			refs:        `[{"path":"/etc/registry.conf","key":"registry.conf"}]`,
+			errExpected: true,
+		},
+		{
+			name:        "missing key",
+			refs:        `[{"path":"/etc/registry.conf","configMap":"registry-config"}]`,
+			errExpected: true,
+		},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+		t.Run(testCase.name, func(t *testing.T) {
+			refs, err := getFileContentReferences(newFileContentReferencesMachineConfig(testCase.refs))
+			if testCase.errExpected {
+				assert.Error(t, err)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, testCase.expected, refs)
+		})
+	}
+}
+
+func TestResolveFileContentReferences(t *testing.T) {
+	f := newFixture(t)
+	for _, cm := range newFileContentSources() {
+		f.kubeobjects = append(f.kubeobjects, cm)
+	}
+	f.kubeobjects = append(f.kubeobjects, newFileContentSecret())
+	c := f.newController()
+
+	plain := helpers.NewMachineConfig("00-plain", map[string]string{"node-role/master": ""}, "", nil)
+	mc := newFileContentReferencesMachineConfig(`[{"path":"/etc/registry.conf","configMap":"registry-config","key":"registry.conf"},{"path":"/etc/registry-token","secret":"registry-token","key":"token"}]`)
+	original := mc.DeepCopy()
+
+	resolved, err := c.resolveFileContentReferences([]*mcfgv1.MachineConfig{plain, mc})
+	require.NoError(t, err)
+	require.Len(t, resolved, 2)
+
+	assert.Same(t, plain, resolved[0])
+	assert.Equal(t, "registry.example.com", getIgnFileContents(t, resolved[1], "/etc/registry.conf"))
+	assert.Equal(t, "s3cr3t", getIgnFileContents(t, resolved[1], "/etc/registry-token"))
+	assert.Equal(t, "unchanged", getIgnFileContents(t, resolved[1], "/etc/unrelated"))
+
+	// The original MachineConfig (e.g., from the lister cache) is not mutated.
+	assert.Equal(t, original, mc)
+
+	errCases := map[string]string{
+		"missing ConfigMap": `[{"path":"/etc/registry.conf","configMap":"missing","key":"registry.conf"}]`,
+		"missing key":       `[{"path":"/etc/registry.conf","configMap":"registry-config","key":"missing"}]`,
+		"missing Secret":    `[{"path":"/etc/registry-token","secret":"missing","key":"token"}]`,
+		"missing file":      `[{"path":"/etc/missing","configMap":"registry-config","key":"registry.conf"}]`,
+	}
+
+	for name, refs := range errCases {
+		_, err := c.resolveFileContentReferences([]*mcfgv1.MachineConfig{newFileContentReferencesMachineConfig(refs)})
+		assert.Error(t, err, name)
+	}
+}
+
+func TestFileContentSourceChangeEnqueuesPools(t *testing.T) {
+	f := newFixture(t)
+	mcp := helpers.NewMachineConfigPool("test-cluster-master", helpers.MasterSelector, nil, "")
+	mc := newFileContentReferencesMachineConfig(`[{"path":"/etc/registry.conf","configMap":"registry-config","key":"registry.conf"}]`)
+	f.mcpLister = append(f.mcpLister, mcp)
+	f.mcLister = append(f.mcLister, mc)
+	c := f.newController()
+
+	enqueued := []string{}
+	c.enqueueMachineConfigPool = func(pool *mcfgv1.MachineConfigPool) {
+		enqueued = append(enqueued, pool.Name)
+	}
+
+	old := newFileContentSources()[0]
+	old.ResourceVersion = "1"
+	cur := old.DeepCopy()
+
+	// Periodic resync.
+	c.updateConfigMap(old, cur)
+	assert.Empty(t, enqueued)
+
+	cur.ResourceVersion = "2"
+	c.updateConfigMap(old, cur)
+	assert.Equal(t, []string{mcp.Name}, enqueued)
+
+	// Secrets with the same name are not referenced.
+	secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "registry-config", Namespace: ctrlcommon.MCONamespace}}
+	c.addSecret(secret)
+	assert.Equal(t, []string{mcp.Name}, enqueued)
+
+	c.deleteConfigMap(cur)
+	assert.Equal(t, []string{mcp.Name, mcp.Name}, enqueued)
+}
+
+func TestRunBootstrapRejectsFileContentReferences(t *testing.T) {
+	mcp := helpers.NewMachineConfigPool("master", helpers.MasterSelector, nil, "")
+	mc := newFileContentReferencesMachineConfig(`[{"path":"/etc/registry.conf","configMap":"registry-config","key":"registry.conf"}]`)
+	mc.Labels = map[string]string{"node-role/master": ""}
+
+	_, _, err := RunBootstrap([]*mcfgv1.MachineConfigPool{mcp}, []*mcfgv1.MachineConfig{mc}, newControllerConfig(ctrlcommon.ControllerConfigName))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), ctrlcommon.FileContentReferencesAnnotationKey)
+}
@@ -2,10 +2,13 @@ package render
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"reflect"
+	"sort"
 	"time"
 
+	ign3types "github.com/coreos/ignition/v2/config/v3_4/types"
 	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
 	mcfgclientset "github.com/openshift/client-go/machineconfiguration/clientset/versioned"
 	"github.com/openshift/client-go/machineconfiguration/clientset/versioned/scheme"
@@ -23,8 +26,10 @@ import (
 	"k8s.io/apimachinery/pkg/labels"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	"k8s.io/apimachinery/pkg/util/wait"
+	coreinformersv1 "k8s.io/client-go/informers/core/v1"
 	clientset "k8s.io/client-go/kubernetes"
 	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+	corelistersv1 "k8s.io/client-go/listers/core/v1"
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/record"
 	"k8s.io/client-go/util/workqueue"
@@ -54,6 +59,7 @@ var (
 // Controller defines the render controller.
 type Controller struct {
 	client        mcfgclientset.Interface
+	kubeClient    clientset.Interface
 	eventRecorder record.EventRecorder
 
 	syncHandler              func(mcp string) error
@@ -68,6 +74,11 @@ type Controller struct {
 	ccLister       mcfglistersv1.ControllerConfigLister
 	ccListerSynced cache.InformerSynced
 
+	cmLister           corelistersv1.ConfigMapLister
+	cmListerSynced     cache.InformerSynced
+	secretLister       corelistersv1.SecretLister
+	secretListerSynced cache.InformerSynced
+
 	queue workqueue.RateLimitingInterface
 }
 
@@ -76,6 +87,8 @@ func New(
 	mcpInformer mcfginformersv1.MachineConfigPoolInformer,
 	mcInformer mcfginformersv1.MachineConfigInformer,
 	ccInformer mcfginformersv1.ControllerConfigInformer,
+	cmInformer coreinformersv1.ConfigMapInformer,
+	secretInformer coreinformersv1.SecretInformer,
 	kubeClient clientset.Interface,
 	mcfgClient mcfgclientset.Interface,
 ) *Controller {
@@ -85,6 +98,7 @@ func New(
 
 	ctrl := &Controller{
 		client:        mcfgClient,
+		kubeClient:    kubeClient,
 		eventRecorder: ctrlcommon.NamespacedEventRecorder(eventBroadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: "machineconfigcontroller-rendercontroller"})),
 		queue:         workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "machineconfigcontroller-rendercontroller"),
 	}
@@ -99,6 +113,16 @@ func New(
 		UpdateFunc: ctrl.updateMachineConfig,
 		DeleteFunc: ctrl.deleteMachineConfig,
 	})
+	cmInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    ctrl.addConfigMap,
+		UpdateFunc: ctrl.updateConfigMap,
+		DeleteFunc: ctrl.deleteConfigMap,
+	})
+	secretInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    ctrl.addSecret,
+		UpdateFunc: ctrl.updateSecret,
+		DeleteFunc: ctrl.deleteSecret,
+	})
 
 	ctrl.syncHandler = ctrl.syncMachineConfigPool
 	ctrl.enqueueMachineConfigPool = ctrl.enqueueDefault
@@ -109,6 +133,10 @@ func New(
 	ctrl.mcListerSynced = mcInformer.Informer().HasSynced
 	ctrl.ccLister = ccInformer.Lister()
 	ctrl.ccListerSynced = ccInformer.Informer().HasSynced
+	ctrl.cmLister = cmInformer.Lister()
+	ctrl.cmListerSynced = cmInformer.Informer().HasSynced
+	ctrl.secretLister = secretInformer.Lister()
+	ctrl.secretListerSynced = secretInformer.Informer().HasSynced
 
 	return ctrl
 }
@@ -118,7 +146,7 @@ func (ctrl *Controller) Run(workers int, stopCh <-chan struct{}) {
 	defer utilruntime.HandleCrash()
 	defer ctrl.queue.ShutDown()
 
-	if !cache.WaitForCacheSync(stopCh, ctrl.mcpListerSynced, ctrl.mcListerSynced, ctrl.ccListerSynced) {
+	if !cache.WaitForCacheSync(stopCh, ctrl.mcpListerSynced, ctrl.mcListerSynced, ctrl.ccListerSynced, ctrl.cmListerSynced, ctrl.secretListerSynced) {
 		return
 	}
 
@@ -427,6 +455,11 @@ func (ctrl *Controller) syncMachineConfigPool(key string) error {
 		return err
 	}
 
+	// The imported MachineConfig is rendered once the MachineConfig informer sees it.
+	if err := ctrl.syncConfigImport(pool); err != nil {
+		return ctrl.syncFailingStatus(pool, err)
+	}
+
 	mcs, err := ctrl.mcLister.List(selector)
 	if err != nil {
 		return err
@@ -435,10 +468,26 @@ func (ctrl *Controller) syncMachineConfigPool(key string) error {
 		return ctrl.syncFailingStatus(pool, fmt.Errorf("no MachineConfigs found matching selector %v", selector))
 	}
 
-	if err := ctrl.syncGeneratedMachineConfig(pool, mcs); err != nil {
+	generated, err := ctrl.syncGeneratedMachineConfig(pool, mcs)
+	if err != nil {
 		return ctrl.syncFailingStatus(pool, err)
 	}
 
+	if generated != nil {
+		cc, err := ctrl.ccLister.Get(ctrlcommon.ControllerConfigName)
+		if err != nil {
+			return err
+		}
+
+		if err := ctrl.syncConfigExport(pool, generated.Name, mcs, cc); err != nil {
+			return err
+		}
+
+		if err := ctrl.syncImportedConfigDriftStatus(pool, generated); err != nil {
+			return err
+		}
+	}
+
 	return ctrl.syncAvailableStatus(pool)
 }
 
@@ -473,19 +522,30 @@ func (ctrl *Controller) garbageCollectRenderedConfigs(_ *mcfgv1.MachineConfigPoo
 	return nil
 }
 
-func (ctrl *Controller) syncGeneratedMachineConfig(pool *mcfgv1.MachineConfigPool, configs []*mcfgv1.MachineConfig) error {
+func (ctrl *Controller) syncGeneratedMachineConfig(pool *mcfgv1.MachineConfigPool, configs []*mcfgv1.MachineConfig) (*mcfgv1.MachineConfig, error) {
 	if len(configs) == 0 {
-		return nil
+		return nil, nil
 	}
 
 	cc, err := ctrl.ccLister.Get(ctrlcommon.ControllerConfigName)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	generated, err := generateRenderedMachineConfig(pool, configs, cc)
+	// The lister returns the configs in no particular order. They are merged
+	// in name order, which the source of the pool must follow as well so that
+	// it does not change between syncs.
+	sort.SliceStable(configs, func(i, j int) bool { return configs[i].Name < configs[j].Name })
+
+	// Fill in any file contents which come from ConfigMaps or Secrets.
+	resolved, err := ctrl.resolveFileContentReferences(configs)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	generated, err := generateRenderedMachineConfig(pool, resolved, cc)
+	if err != nil {
+		return nil, err
 	}
 
 	// Emit event and collect metric when OSImageURL was overridden.
@@ -506,14 +566,14 @@ func (ctrl *Controller) syncGeneratedMachineConfig(pool *mcfgv1.MachineConfigPoo
 	if apierrors.IsNotFound(err) {
 		_, err = ctrl.client.MachineconfigurationV1().MachineConfigs().Create(context.TODO(), generated, metav1.CreateOptions{})
 		if err != nil {
-			return err
+			return nil, err
 		}
 		klog.V(2).Infof("Generated machineconfig %s from %d configs: %s", generated.Name, len(source), source)
 		ctrl.eventRecorder.Eventf(pool, corev1.EventTypeNormal, "RenderedConfigGenerated", "%s successfully generated (release version: %s, controller version: %s)",
 			generated.Name, generated.Annotations[ctrlcommon.ReleaseImageVersionAnnotationKey], generated.Annotations[ctrlcommon.GeneratedByControllerVersionAnnotationKey])
 	}
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	newPool := pool.DeepCopy()
@@ -522,21 +582,22 @@ func (ctrl *Controller) syncGeneratedMachineConfig(pool *mcfgv1.MachineConfigPoo
 	if pool.Spec.Configuration.Name == generated.Name {
 		_, _, err = mcoResourceApply.ApplyMachineConfig(ctrl.client.MachineconfigurationV1(), generated)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		_, err = ctrl.client.MachineconfigurationV1().MachineConfigPools().Update(context.TODO(), newPool, metav1.UpdateOptions{})
-		return err
+		return generated, err
 	}
 
 	newPool.Spec.Configuration.Name = generated.Name
+	generation := ctrlcommon.IncrementPoolConfigGeneration(newPool)
 	// TODO(walters) Use subresource or JSON patch, but the latter isn't supported by the unit test mocks
 	pool, err = ctrl.client.MachineconfigurationV1().MachineConfigPools().Update(context.TODO(), newPool, metav1.UpdateOptions{})
 	if err != nil {
-		return err
+		return nil, err
 	}
-	klog.V(2).Infof("Pool %s: now targeting: %s", pool.Name, pool.Spec.Configuration.Name)
+	klog.V(2).Infof("Pool %s: now targeting: %s (config generation %d)", pool.Name, pool.Spec.Configuration.Name, generation)
 
-	return ctrl.garbageCollectRenderedConfigs(pool)
+	return generated, ctrl.garbageCollectRenderedConfigs(pool)
 }
 
 // generateRenderedMachineConfig takes all MCs for a given pool and returns a single rendered MC. For ex master-XXXX or worker-XXXX
@@ -569,6 +630,21 @@ func generateRenderedMachineConfig(pool *mcfgv1.MachineConfigPool, configs []*mc
 		if err := ctrlcommon.ValidateMachineConfig(config.Spec); err != nil {
 			return nil, err
 		}
+
+		// The MCD only installs allowlisted extensions on RHCOS, so reject
+		// others here instead of failing on the nodes. On FCOS, extensions are
+		// installed as packages of the same name.
+		if !version.IsFCOS() {
+			if err := ctrlcommon.ValidateExtensions(config.Spec.Extensions); err != nil {
+				return nil, fmt.Errorf("MachineConfig %s: %w", config.Name, err)
+			}
+		}
+	}
+
+	// Kernel arguments are concatenated when merging, so make sure the MachineConfigs do not disagree on
+	// arguments that only take a single value.
+	if err := ctrlcommon.ValidateKernelArgumentConflicts(configs); err != nil {
+		return nil, err
 	}
 
 	merged, err := ctrlcommon.MergeMachineConfigs(configs, cconfig)
@@ -576,6 +652,18 @@ func generateRenderedMachineConfig(pool *mcfgv1.MachineConfigPool, configs []*mc
 	if err != nil {
 		return nil, err
 	}
+
+	// The merged config is not necessarily valid Ignition on its own (e.g.
+	// overwrite without a source), so decode it without validating it again.
+	mergedIgn := ign3types.Config{}
+	if err := json.Unmarshal(merged.Spec.Config.Raw, &mergedIgn); err != nil {
+		return nil, fmt.Errorf("could not decode merged Ignition config: %w", err)
+	}
+
+	if err := ctrlcommon.ValidateSystemdUnits(mergedIgn); err != nil {
+		return nil, fmt.Errorf("invalid systemd units: %w", err)
+	}
+
 	hashedName, err := getMachineConfigHashedName(pool, merged)
 	if err != nil {
 		return nil, err
@@ -614,6 +702,14 @@ func RunBootstrap(pools []*mcfgv1.MachineConfigPool, configs []*mcfgv1.MachineCo
 			return nil, nil, err
 		}
 
+		// ConfigMaps and Secrets cannot be read during bootstrap, so the rendered
+		// config would not match the one rendered in-cluster.
+		for _, pc := range pcs {
+			if _, ok := pc.Annotations[ctrlcommon.FileContentReferencesAnnotationKey]; ok {
+				return nil, nil, fmt.Errorf("MachineConfig %s uses %s, which is not supported during bootstrap", pc.Name, ctrlcommon.FileContentReferencesAnnotationKey)
+			}
+		}
+
 		generated, err := generateRenderedMachineConfig(pool, pcs, cconfig)
 		if err != nil {
 			return nil, nil, err
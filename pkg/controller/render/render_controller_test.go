@@ -19,6 +19,7 @@ import (
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/diff"
 	utilrand "k8s.io/apimachinery/pkg/util/rand"
+	kubeinformers "k8s.io/client-go/informers"
 	k8sfake "k8s.io/client-go/kubernetes/fake"
 	core "k8s.io/client-go/testing"
 	"k8s.io/client-go/tools/cache"
@@ -49,7 +50,8 @@ type fixture struct {
 
 	actions []core.Action
 
-	objects []runtime.Object
+	objects     []runtime.Object
+	kubeobjects []runtime.Object
 }
 
 func newFixture(t *testing.T) *fixture {
@@ -63,19 +65,25 @@ func (f *fixture) newController() *Controller {
 	f.client = fake.NewSimpleClientset(f.objects...)
 
 	i := informers.NewSharedInformerFactory(f.client, noResyncPeriodFunc())
+	k8sI := kubeinformers.NewSharedInformerFactory(k8sfake.NewSimpleClientset(f.kubeobjects...), noResyncPeriodFunc())
 
 	c := New(i.Machineconfiguration().V1().MachineConfigPools(), i.Machineconfiguration().V1().MachineConfigs(),
-		i.Machineconfiguration().V1().ControllerConfigs(), k8sfake.NewSimpleClientset(), f.client)
+		i.Machineconfiguration().V1().ControllerConfigs(), k8sI.Core().V1().ConfigMaps(), k8sI.Core().V1().Secrets(),
+		k8sfake.NewSimpleClientset(), f.client)
 
 	c.mcpListerSynced = alwaysReady
 	c.mcListerSynced = alwaysReady
 	c.ccListerSynced = alwaysReady
+	c.cmListerSynced = alwaysReady
+	c.secretListerSynced = alwaysReady
 	c.eventRecorder = ctrlcommon.NamespacedEventRecorder(&record.FakeRecorder{})
 
 	stopCh := make(chan struct{})
 	defer close(stopCh)
 	i.Start(stopCh)
 	i.WaitForCacheSync(stopCh)
+	k8sI.Start(stopCh)
+	k8sI.WaitForCacheSync(stopCh)
 
 	for _, c := range f.ccLister {
 		i.Machineconfiguration().V1().ControllerConfigs().Informer().GetIndexer().Add(c)
@@ -316,6 +324,77 @@ func TestIgnValidationGenerateRenderedMachineConfig(t *testing.T) {
 
 }
 
+func TestKernelArgumentConflictsGenerateRenderedMachineConfig(t *testing.T) {
+	mcp := helpers.NewMachineConfigPool("test-cluster-worker", helpers.WorkerSelector, nil, "")
+	mcs := []*mcfgv1.MachineConfig{
+		helpers.NewMachineConfig("00-test-cluster-worker", map[string]string{"node-role/worker": ""}, "dummy://", []ign3types.File{}),
+		helpers.NewMachineConfig("50-hugepages-1g", map[string]string{"node-role/worker": ""}, "dummy://", []ign3types.File{}),
+		helpers.NewMachineConfig("60-hugepages-2m", map[string]string{"node-role/worker": ""}, "dummy://", []ign3types.File{}),
+	}
+	cc := newControllerConfig(ctrlcommon.ControllerConfigName)
+
+	mcs[1].Spec.KernelArguments = []string{"default_hugepagesz=1G hugepagesz=1G hugepages=4"}
+	mcs[2].Spec.KernelArguments = []string{"hugepagesz=2M", "hugepages=512"}
+
+	_, err := generateRenderedMachineConfig(mcp, mcs, cc)
+	require.Nil(t, err)
+
+	mcs[2].Spec.KernelArguments = append(mcs[2].Spec.KernelArguments, "default_hugepagesz=2M")
+
+	_, err = generateRenderedMachineConfig(mcp, mcs, cc)
+	require.NotNil(t, err)
+	assert.Contains(t, err.Error(), "50-hugepages-1g")
+	assert.Contains(t, err.Error(), "60-hugepages-2m")
+}
+
+func TestSystemdUnitValidationGenerateRenderedMachineConfig(t *testing.T) {
+	mcp := helpers.NewMachineConfigPool("test-cluster-worker", helpers.WorkerSelector, nil, "")
+	units := []ign3types.Unit{
+		{
+			Name:     "foo.service",
+			Contents: helpers.StrToPtr("[Unit]\nRequires=bar.service\n[Service]\nExecStart=/usr/bin/foo\n"),
+		},
+	}
+	mcs := []*mcfgv1.MachineConfig{
+		helpers.NewMachineConfig("00-test-cluster-worker", map[string]string{"node-role/worker": ""}, "dummy://", []ign3types.File{}),
+		helpers.NewMachineConfigExtended("50-foo", map[string]string{"node-role/worker": ""}, nil, []ign3types.File{}, units, []ign3types.SSHAuthorizedKey{}, []string{}, false, []string{}, "", "dummy://"),
+	}
+	cc := newControllerConfig(ctrlcommon.ControllerConfigName)
+
+	_, err := generateRenderedMachineConfig(mcp, mcs, cc)
+	require.Nil(t, err)
+
+	// A dropin from another config is checked together with the unit it
+	// applies to.
+	units = []ign3types.Unit{
+		{
+			Name:     "baz.service",
+			Contents: helpers.StrToPtr("[Unit]\nDescription=baz\n"),
+		},
+	}
+	dropins := []ign3types.Unit{
+		{
+			Name:    "baz.service",
+			Dropins: []ign3types.Dropin{{Name: "10-exec.conf", Contents: helpers.StrToPtr("[Service]\nExecStart=/usr/bin/baz\n")}},
+		},
+	}
+	mcs = append(mcs,
+		helpers.NewMachineConfigExtended("50-baz", map[string]string{"node-role/worker": ""}, nil, []ign3types.File{}, units, []ign3types.SSHAuthorizedKey{}, []string{}, false, []string{}, "", "dummy://"),
+		helpers.NewMachineConfigExtended("55-baz-exec", map[string]string{"node-role/worker": ""}, nil, []ign3types.File{}, dropins, []ign3types.SSHAuthorizedKey{}, []string{}, false, []string{}, "", "dummy://"),
+	)
+
+	_, err = generateRenderedMachineConfig(mcp, mcs, cc)
+	require.Nil(t, err)
+
+	// Masking a unit which another config depends on fails the render.
+	masked := []ign3types.Unit{{Name: "bar.service", Mask: helpers.BoolToPtr(true)}}
+	mcs = append(mcs, helpers.NewMachineConfigExtended("60-mask-bar", map[string]string{"node-role/worker": ""}, nil, []ign3types.File{}, masked, []ign3types.SSHAuthorizedKey{}, []string{}, false, []string{}, "", "dummy://"))
+
+	_, err = generateRenderedMachineConfig(mcp, mcs, cc)
+	require.NotNil(t, err)
+	assert.Contains(t, err.Error(), `systemd unit "foo.service": Requires=bar.service refers to a masked unit`)
+}
+
 func TestUpdatesGeneratedMachineConfig(t *testing.T) {
 	f := newFixture(t)
 	mcp := helpers.NewMachineConfigPool("test-cluster-master", helpers.MasterSelector, nil, "")
@@ -386,6 +465,28 @@ func TestGenerateMachineConfigOverrideOSImageURL(t *testing.T) {
 	assert.Equal(t, "dummy-change", gmc.Spec.OSImageURL)
 }
 
+// Testing that generateRenderedMachineConfig() rejects extensions which are not available instead of
+// leaving it to the nodes.
+func TestGenerateMachineConfigInvalidExtensions(t *testing.T) {
+	mcp := helpers.NewMachineConfigPool("test-cluster-master", helpers.MasterSelector, nil, "")
+	mcs := []*mcfgv1.MachineConfig{
+		helpers.NewMachineConfig("00-test-cluster-master", map[string]string{"node-role/master": ""}, "dummy-test-1", []ign3types.File{}),
+		helpers.NewMachineConfig("99-test-cluster-master-extensions", map[string]string{"node-role/master": ""}, "", []ign3types.File{}),
+	}
+	mcs[1].Spec.Extensions = []string{"usbguard", "foo"}
+
+	cc := newControllerConfig(ctrlcommon.ControllerConfigName)
+
+	_, err := generateRenderedMachineConfig(mcp, mcs, cc)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "MachineConfig 99-test-cluster-master-extensions: invalid extensions found: [foo]")
+	assert.Contains(t, err.Error(), "available extensions are:")
+
+	mcs[1].Spec.Extensions = []string{"usbguard"}
+	_, err = generateRenderedMachineConfig(mcp, mcs, cc)
+	require.NoError(t, err)
+}
+
 func TestVersionSkew(t *testing.T) {
 	mcp := helpers.NewMachineConfigPool("test-cluster-master", helpers.MasterSelector, nil, "")
 	mcs := []*mcfgv1.MachineConfig{
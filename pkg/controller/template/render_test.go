@@ -429,6 +429,9 @@ func TestGenerateMachineConfigs(t *testing.T) {
 			if err != nil {
 				t.Errorf("Failed to parse Ignition config for %s, %s, error: %v", config, cfg.Name, err)
 			}
+			if err := ctrlcommon.ValidateSystemdUnits(ign); err != nil {
+				t.Errorf("Invalid systemd units for %s, %s, error: %v", config, cfg.Name, err)
+			}
 			if role == "master" {
 				if !foundPullSecretMaster {
 					foundPullSecretMaster = findIgnFile(ign.Storage.Files, "/var/lib/kubelet/config.json", t)
@@ -10,6 +10,7 @@ import (
 	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
 	mcfgclientv1 "github.com/openshift/client-go/machineconfiguration/clientset/versioned/typed/machineconfiguration/v1"
 	"github.com/openshift/machine-config-operator/pkg/apihelpers"
+	"github.com/openshift/machine-config-operator/pkg/apihelpers/conditions"
 	"github.com/openshift/machine-config-operator/pkg/version"
 	"k8s.io/apimachinery/pkg/api/equality"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -27,7 +28,7 @@ func (ctrl *Controller) syncRunningStatus(ctrlconfig *mcfgv1.ControllerConfig) e
 			acond := apihelpers.NewControllerConfigStatusCondition(mcfgv1.TemplateControllerCompleted, corev1.ConditionFalse, "", fmt.Sprintf("%s due to change in Generation", reason))
 			apihelpers.SetControllerConfigStatusCondition(&cfg.Status, *acond)
 		}
-		cfg.Status.ObservedGeneration = ctrlconfig.GetGeneration()
+		conditions.SetObservedGeneration(ctrlconfig, &cfg.Status.ObservedGeneration)
 		return nil
 	}
 	return updateControllerConfigStatus(ctrlconfig.GetName(), ctrl.ccLister.Get, ctrl.client.MachineconfigurationV1().ControllerConfigs(), updateFunc)
@@ -48,7 +49,7 @@ func (ctrl *Controller) syncFailingStatus(ctrlconfig *mcfgv1.ControllerConfig, o
 		apihelpers.SetControllerConfigStatusCondition(&cfg.Status, *acond)
 		rcond := apihelpers.NewControllerConfigStatusCondition(mcfgv1.TemplateControllerRunning, corev1.ConditionFalse, "", "")
 		apihelpers.SetControllerConfigStatusCondition(&cfg.Status, *rcond)
-		cfg.Status.ObservedGeneration = ctrlconfig.GetGeneration()
+		conditions.SetObservedGeneration(ctrlconfig, &cfg.Status.ObservedGeneration)
 		return nil
 	}
 	if err := updateControllerConfigStatus(ctrlconfig.GetName(), ctrl.ccLister.Get, ctrl.client.MachineconfigurationV1().ControllerConfigs(), updateFunc); err != nil {
@@ -69,7 +70,7 @@ func (ctrl *Controller) syncCompletedStatus(ctrlconfig *mcfgv1.ControllerConfig)
 		apihelpers.SetControllerConfigStatusCondition(&cfg.Status, *rcond)
 		fcond := apihelpers.NewControllerConfigStatusCondition(mcfgv1.TemplateControllerFailing, corev1.ConditionFalse, "", "")
 		apihelpers.SetControllerConfigStatusCondition(&cfg.Status, *fcond)
-		cfg.Status.ObservedGeneration = ctrlconfig.GetGeneration()
+		conditions.SetObservedGeneration(ctrlconfig, &cfg.Status.ObservedGeneration)
 		return nil
 	}
 	return updateControllerConfigStatus(ctrlconfig.GetName(), ctrl.ccLister.Get, ctrl.client.MachineconfigurationV1().ControllerConfigs(), updateFunc)
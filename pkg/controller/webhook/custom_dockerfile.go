@@ -0,0 +1,99 @@
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	mcfginformersv1 "github.com/openshift/client-go/machineconfiguration/informers/externalversions/machineconfiguration/v1"
+	mcfglistersv1 "github.com/openshift/client-go/machineconfiguration/listers/machineconfiguration/v1"
+	"github.com/openshift/machine-config-operator/pkg/controller/build"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+// CustomDockerfilePath is the path the custom Dockerfile ConfigMap webhook is
+// served on.
+const CustomDockerfilePath = "/validate-custom-dockerfile"
+
+// CustomDockerfileWebhook rejects on-cluster-build-custom-dockerfile
+// ConfigMaps with entries which would otherwise only fail once a build
+// starts: keys which do not name a MachineConfigPool, pools set more than
+// once, and empty or invalid custom Containerfiles. Other ConfigMaps are
+// always allowed.
+type CustomDockerfileWebhook struct {
+	mcpLister       mcfglistersv1.MachineConfigPoolLister
+	mcpListerSynced cache.InformerSynced
+}
+
+// NewCustomDockerfileWebhook returns a new custom Dockerfile ConfigMap webhook.
+func NewCustomDockerfileWebhook(mcpInformer mcfginformersv1.MachineConfigPoolInformer) *CustomDockerfileWebhook {
+	return &CustomDockerfileWebhook{
+		mcpLister:       mcpInformer.Lister(),
+		mcpListerSynced: mcpInformer.Informer().HasSynced,
+	}
+}
+
+// ServeHTTP handles AdmissionReview requests for ConfigMap creations and updates.
+func (w *CustomDockerfileWebhook) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	serveAdmissionReview(rw, req, w.review)
+}
+
+func (w *CustomDockerfileWebhook) review(req *admissionv1.AdmissionRequest) *admissionv1.AdmissionResponse {
+	if req.Namespace != ctrlcommon.MCONamespace || req.Name != build.CustomDockerfileConfigMapName {
+		return &admissionv1.AdmissionResponse{Allowed: true}
+	}
+
+	if req.Operation != admissionv1.Create && req.Operation != admissionv1.Update {
+		return &admissionv1.AdmissionResponse{Allowed: true}
+	}
+
+	cm := &corev1.ConfigMap{}
+	if err := json.Unmarshal(req.Object.Raw, cm); err != nil {
+		return &admissionv1.AdmissionResponse{
+			Allowed: false,
+			Result: &metav1.Status{
+				Status:  metav1.StatusFailure,
+				Code:    http.StatusBadRequest,
+				Message: fmt.Sprintf("could not decode ConfigMap %s: %v", req.Name, err),
+			},
+		}
+	}
+
+	if err := w.validate(cm); err != nil {
+		klog.Infof("Rejected %s of ConfigMap %s by %s: %v", req.Operation, cm.Name, req.UserInfo.Username, err)
+
+		return &admissionv1.AdmissionResponse{
+			Allowed: false,
+			Result: &metav1.Status{
+				Status:  metav1.StatusFailure,
+				Code:    http.StatusUnprocessableEntity,
+				Reason:  metav1.StatusReasonInvalid,
+				Message: err.Error(),
+			},
+		}
+	}
+
+	return &admissionv1.AdmissionResponse{Allowed: true}
+}
+
+// validate returns an error if the given custom Dockerfile ConfigMap has an
+// invalid entry.
+func (w *CustomDockerfileWebhook) validate(cm *corev1.ConfigMap) error {
+	pools, err := w.mcpLister.List(labels.Everything())
+	if err != nil {
+		return fmt.Errorf("could not list MachineConfigPools: %w", err)
+	}
+
+	poolNames := []string{}
+	for _, pool := range pools {
+		poolNames = append(poolNames, pool.Name)
+	}
+
+	return build.ValidateCustomDockerfileConfigMap(cm, poolNames)
+}
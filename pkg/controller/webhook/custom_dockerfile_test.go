@@ -0,0 +1,95 @@
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	fakemcfgclientset "github.com/openshift/client-go/machineconfiguration/clientset/versioned/fake"
+	mcfginformers "github.com/openshift/client-go/machineconfiguration/informers/externalversions"
+	"github.com/openshift/machine-config-operator/pkg/controller/build"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func newTestCustomDockerfileWebhook(t *testing.T) *CustomDockerfileWebhook {
+	t.Helper()
+
+	mcpInformer := mcfginformers.NewSharedInformerFactory(fakemcfgclientset.NewSimpleClientset(), 0).Machineconfiguration().V1().MachineConfigPools()
+	for _, name := range []string{"master", "worker"} {
+		require.NoError(t, mcpInformer.Informer().GetIndexer().Add(&mcfgv1.MachineConfigPool{ObjectMeta: metav1.ObjectMeta{Name: name}}))
+	}
+
+	return NewCustomDockerfileWebhook(mcpInformer)
+}
+
+func TestCustomDockerfileServeHTTP(t *testing.T) {
+	t.Parallel()
+
+	w := newTestCustomDockerfileWebhook(t)
+
+	doReview := func(t *testing.T, name string, data map[string]string) *admissionv1.AdmissionResponse {
+		t.Helper()
+
+		cm := &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: ctrlcommon.MCONamespace},
+			Data:       data,
+		}
+
+		raw, err := json.Marshal(cm)
+		require.NoError(t, err)
+
+		review := admissionv1.AdmissionReview{
+			TypeMeta: metav1.TypeMeta{
+				APIVersion: admissionv1.SchemeGroupVersion.String(),
+				Kind:       "AdmissionReview",
+			},
+			Request: &admissionv1.AdmissionRequest{
+				UID:       types.UID("request"),
+				Name:      cm.Name,
+				Namespace: cm.Namespace,
+				Operation: admissionv1.Update,
+				Object:    runtime.RawExtension{Raw: raw},
+			},
+		}
+
+		body, err := json.Marshal(review)
+		require.NoError(t, err)
+
+		rec := httptest.NewRecorder()
+		w.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, CustomDockerfilePath, bytes.NewReader(body)))
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		out := &admissionv1.AdmissionReview{}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), out))
+		require.NotNil(t, out.Response)
+		assert.Equal(t, types.UID("request"), out.Response.UID)
+
+		return out.Response
+	}
+
+	resp := doReview(t, build.CustomDockerfileConfigMapName, map[string]string{"worker": "FROM configs AS final\nRUN dnf install -y python3"})
+	assert.True(t, resp.Allowed)
+
+	resp = doReview(t, build.CustomDockerfileConfigMapName, map[string]string{"workers": "FROM configs AS final"})
+	assert.False(t, resp.Allowed)
+	assert.Equal(t, int32(http.StatusUnprocessableEntity), resp.Result.Code)
+	assert.Contains(t, resp.Result.Message, `key "workers" does not name a MachineConfigPool`)
+
+	resp = doReview(t, build.CustomDockerfileConfigMapName, map[string]string{"master": ""})
+	assert.False(t, resp.Allowed)
+	assert.Contains(t, resp.Result.Message, "custom Containerfile for pool master is empty")
+
+	// Other ConfigMaps are not validated.
+	resp = doReview(t, "some-other-configmap", map[string]string{"workers": ""})
+	assert.True(t, resp.Allowed)
+}
@@ -0,0 +1,155 @@
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	mcfginformersv1 "github.com/openshift/client-go/machineconfiguration/informers/externalversions/machineconfiguration/v1"
+	mcfglistersv1 "github.com/openshift/client-go/machineconfiguration/listers/machineconfiguration/v1"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	daemonconsts "github.com/openshift/machine-config-operator/pkg/daemon/constants"
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	coreinformersv1 "k8s.io/client-go/informers/core/v1"
+	corelisterv1 "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// MachineConfigDeletionPath is the path the MachineConfig deletion webhook
+	// is served on.
+	MachineConfigDeletionPath = "/validate-machineconfig-deletion"
+
+	// The garbage collector deletes rendered MachineConfigs once their
+	// MachineConfigPool is gone, which we do not want to block.
+	garbageCollectorUser = "system:serviceaccount:kube-system:generic-garbage-collector"
+)
+
+// MachineConfigDeletionWebhook rejects deleting MachineConfigs which would
+// break a pool: rendered MachineConfigs which a pool or node still uses, and
+// the base MachineConfigs generated by the template controller. Deletion is
+// allowed when the MachineConfig has the force delete annotation.
+type MachineConfigDeletionWebhook struct {
+	mcpLister  mcfglistersv1.MachineConfigPoolLister
+	nodeLister corelisterv1.NodeLister
+
+	mcpListerSynced  cache.InformerSynced
+	nodeListerSynced cache.InformerSynced
+}
+
+// NewMachineConfigDeletionWebhook returns a new MachineConfig deletion webhook.
+func NewMachineConfigDeletionWebhook(
+	mcpInformer mcfginformersv1.MachineConfigPoolInformer,
+	nodeInformer coreinformersv1.NodeInformer,
+) *MachineConfigDeletionWebhook {
+	return &MachineConfigDeletionWebhook{
+		mcpLister:        mcpInformer.Lister(),
+		nodeLister:       nodeInformer.Lister(),
+		mcpListerSynced:  mcpInformer.Informer().HasSynced,
+		nodeListerSynced: nodeInformer.Informer().HasSynced,
+	}
+}
+
+// ServeHTTP handles AdmissionReview requests for MachineConfig deletions.
+func (w *MachineConfigDeletionWebhook) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	serveAdmissionReview(rw, req, w.review)
+}
+
+func (w *MachineConfigDeletionWebhook) review(req *admissionv1.AdmissionRequest) *admissionv1.AdmissionResponse {
+	if req.Operation != admissionv1.Delete || req.UserInfo.Username == garbageCollectorUser {
+		return &admissionv1.AdmissionResponse{Allowed: true}
+	}
+
+	mc := &mcfgv1.MachineConfig{}
+	if err := json.Unmarshal(req.OldObject.Raw, mc); err != nil {
+		return &admissionv1.AdmissionResponse{
+			Allowed: false,
+			Result: &metav1.Status{
+				Status:  metav1.StatusFailure,
+				Code:    http.StatusBadRequest,
+				Message: fmt.Sprintf("could not decode MachineConfig %s: %v", req.Name, err),
+			},
+		}
+	}
+
+	if err := w.validateDeletion(mc); err != nil {
+		klog.Infof("Rejected deletion of MachineConfig %s by %s: %v", mc.Name, req.UserInfo.Username, err)
+
+		return &admissionv1.AdmissionResponse{
+			Allowed: false,
+			Result: &metav1.Status{
+				Status:  metav1.StatusFailure,
+				Code:    http.StatusForbidden,
+				Reason:  metav1.StatusReasonForbidden,
+				Message: err.Error(),
+			},
+		}
+	}
+
+	return &admissionv1.AdmissionResponse{Allowed: true}
+}
+
+// validateDeletion returns an error if the given MachineConfig must not be
+// deleted.
+func (w *MachineConfigDeletionWebhook) validateDeletion(mc *mcfgv1.MachineConfig) error {
+	if mc.Annotations[ctrlcommon.ForceDeleteAnnotationKey] == "true" {
+		return nil
+	}
+
+	owner := metav1.GetControllerOf(mc)
+	if owner == nil {
+		return nil
+	}
+
+	force := fmt.Sprintf("set the %s=true annotation on it to delete it anyway", ctrlcommon.ForceDeleteAnnotationKey)
+
+	switch owner.Kind {
+	case "ControllerConfig":
+		return fmt.Errorf("MachineConfig %s is a base MachineConfig generated by the MCO; %s", mc.Name, force)
+	case "MachineConfigPool":
+		users, err := w.getRenderedConfigUsers(mc.Name)
+		if err != nil {
+			return err
+		}
+
+		if len(users) != 0 {
+			return fmt.Errorf("rendered MachineConfig %s is in use by %v; %s", mc.Name, users, force)
+		}
+	}
+
+	return nil
+}
+
+// getRenderedConfigUsers returns the pools and nodes which currently use or
+// are updating to the given rendered MachineConfig.
+func (w *MachineConfigDeletionWebhook) getRenderedConfigUsers(name string) ([]string, error) {
+	users := []string{}
+
+	pools, err := w.mcpLister.List(labels.Everything())
+	if err != nil {
+		return nil, fmt.Errorf("could not list MachineConfigPools: %w", err)
+	}
+
+	for _, pool := range pools {
+		if pool.Spec.Configuration.Name == name || pool.Status.Configuration.Name == name {
+			users = append(users, "MachineConfigPool/"+pool.Name)
+		}
+	}
+
+	nodes, err := w.nodeLister.List(labels.Everything())
+	if err != nil {
+		return nil, fmt.Errorf("could not list nodes: %w", err)
+	}
+
+	for _, node := range nodes {
+		if node.Annotations[daemonconsts.CurrentMachineConfigAnnotationKey] == name || node.Annotations[daemonconsts.DesiredMachineConfigAnnotationKey] == name {
+			users = append(users, "Node/"+node.Name)
+		}
+	}
+
+	return users, nil
+}
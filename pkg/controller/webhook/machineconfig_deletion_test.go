@@ -0,0 +1,203 @@
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	fakemcfgclientset "github.com/openshift/client-go/machineconfiguration/clientset/versioned/fake"
+	mcfginformers "github.com/openshift/client-go/machineconfiguration/informers/externalversions"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	daemonconsts "github.com/openshift/machine-config-operator/pkg/daemon/constants"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	admissionv1 "k8s.io/api/admission/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/informers"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+)
+
+func newMachineConfig(name, ownerKind string, annotations map[string]string) *mcfgv1.MachineConfig {
+	mc := &mcfgv1.MachineConfig{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Annotations: annotations,
+		},
+	}
+
+	if ownerKind != "" {
+		controller := true
+		mc.OwnerReferences = []metav1.OwnerReference{
+			{
+				APIVersion: mcfgv1.SchemeGroupVersion.String(),
+				Kind:       ownerKind,
+				Name:       "owner",
+				UID:        types.UID("owner"),
+				Controller: &controller,
+			},
+		}
+	}
+
+	return mc
+}
+
+func newTestWebhook(t *testing.T) *MachineConfigDeletionWebhook {
+	t.Helper()
+
+	pool := &mcfgv1.MachineConfigPool{
+		ObjectMeta: metav1.ObjectMeta{Name: "worker"},
+		Spec: mcfgv1.MachineConfigPoolSpec{
+			Configuration: mcfgv1.MachineConfigPoolStatusConfiguration{
+				ObjectReference: corev1.ObjectReference{Name: "rendered-worker-2"},
+			},
+		},
+		Status: mcfgv1.MachineConfigPoolStatus{
+			Configuration: mcfgv1.MachineConfigPoolStatusConfiguration{
+				ObjectReference: corev1.ObjectReference{Name: "rendered-worker-2"},
+			},
+		},
+	}
+
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "node-0",
+			Annotations: map[string]string{
+				daemonconsts.CurrentMachineConfigAnnotationKey: "rendered-worker-1",
+				daemonconsts.DesiredMachineConfigAnnotationKey: "rendered-worker-2",
+			},
+		},
+	}
+
+	mcfgInformerFactory := mcfginformers.NewSharedInformerFactory(fakemcfgclientset.NewSimpleClientset(), 0)
+	kubeInformerFactory := informers.NewSharedInformerFactory(k8sfake.NewSimpleClientset(), 0)
+
+	mcpInformer := mcfgInformerFactory.Machineconfiguration().V1().MachineConfigPools()
+	nodeInformer := kubeInformerFactory.Core().V1().Nodes()
+
+	require.NoError(t, mcpInformer.Informer().GetIndexer().Add(pool))
+	require.NoError(t, nodeInformer.Informer().GetIndexer().Add(node))
+
+	return NewMachineConfigDeletionWebhook(mcpInformer, nodeInformer)
+}
+
+func TestValidateDeletion(t *testing.T) {
+	t.Parallel()
+
+	w := newTestWebhook(t)
+
+	testCases := []struct {
+		name          string
+		mc            *mcfgv1.MachineConfig
+		errExpected   bool
+		errorContains string
+	}{
+		{
+			name: "User-provided MachineConfig",
+			mc:   newMachineConfig("99-worker-ssh", "", nil),
+		},
+		{
+			name:          "Base MachineConfig",
+			mc:            newMachineConfig("00-worker", "ControllerConfig", nil),
+			errExpected:   true,
+			errorContains: "base MachineConfig",
+		},
+		{
+			name: "Base MachineConfig with force annotation",
+			mc:   newMachineConfig("00-worker", "ControllerConfig", map[string]string{ctrlcommon.ForceDeleteAnnotationKey: "true"}),
+		},
+		{
+			name:          "Rendered MachineConfig used by pool and node",
+			mc:            newMachineConfig("rendered-worker-2", "MachineConfigPool", nil),
+			errExpected:   true,
+			errorContains: "[MachineConfigPool/worker Node/node-0]",
+		},
+		{
+			name:          "Rendered MachineConfig used by node",
+			mc:            newMachineConfig("rendered-worker-1", "MachineConfigPool", nil),
+			errExpected:   true,
+			errorContains: "[Node/node-0]",
+		},
+		{
+			name: "Unused rendered MachineConfig",
+			mc:   newMachineConfig("rendered-worker-0", "MachineConfigPool", nil),
+		},
+		{
+			name: "Generated kubelet MachineConfig",
+			mc:   newMachineConfig("99-worker-generated-kubelet", "KubeletConfig", nil),
+		},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			err := w.validateDeletion(testCase.mc)
+			if !testCase.errExpected {
+				assert.NoError(t, err)
+				return
+			}
+
+			assert.ErrorContains(t, err, testCase.errorContains)
+			assert.ErrorContains(t, err, ctrlcommon.ForceDeleteAnnotationKey)
+		})
+	}
+}
+
+func TestServeHTTP(t *testing.T) {
+	t.Parallel()
+
+	w := newTestWebhook(t)
+
+	doReview := func(t *testing.T, mc *mcfgv1.MachineConfig, username string) *admissionv1.AdmissionResponse {
+		t.Helper()
+
+		raw, err := json.Marshal(mc)
+		require.NoError(t, err)
+
+		review := admissionv1.AdmissionReview{
+			TypeMeta: metav1.TypeMeta{
+				APIVersion: admissionv1.SchemeGroupVersion.String(),
+				Kind:       "AdmissionReview",
+			},
+			Request: &admissionv1.AdmissionRequest{
+				UID:       types.UID("request"),
+				Name:      mc.Name,
+				Operation: admissionv1.Delete,
+				OldObject: runtime.RawExtension{Raw: raw},
+				UserInfo:  authenticationv1.UserInfo{Username: username},
+			},
+		}
+
+		body, err := json.Marshal(review)
+		require.NoError(t, err)
+
+		rec := httptest.NewRecorder()
+		w.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, MachineConfigDeletionPath, bytes.NewReader(body)))
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		out := &admissionv1.AdmissionReview{}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), out))
+		require.NotNil(t, out.Response)
+		assert.Equal(t, types.UID("request"), out.Response.UID)
+
+		return out.Response
+	}
+
+	resp := doReview(t, newMachineConfig("rendered-worker-2", "MachineConfigPool", nil), "kube:admin")
+	assert.False(t, resp.Allowed)
+	assert.Equal(t, int32(http.StatusForbidden), resp.Result.Code)
+
+	resp = doReview(t, newMachineConfig("rendered-worker-2", "MachineConfigPool", nil), garbageCollectorUser)
+	assert.True(t, resp.Allowed)
+
+	resp = doReview(t, newMachineConfig("rendered-worker-0", "MachineConfigPool", nil), "kube:admin")
+	assert.True(t, resp.Allowed)
+}
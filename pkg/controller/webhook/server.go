@@ -0,0 +1,89 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"time"
+
+	mcfginformersv1 "github.com/openshift/client-go/machineconfiguration/informers/externalversions/machineconfiguration/v1"
+	admissionv1 "k8s.io/api/admission/v1"
+	coreinformersv1 "k8s.io/client-go/informers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+const maxAdmissionReviewSize = 3 << 20
+
+// Server serves the admission webhooks of the MachineConfigController.
+type Server struct {
+	deletion         *MachineConfigDeletionWebhook
+	customDockerfile *CustomDockerfileWebhook
+}
+
+// NewServer returns a new webhook server.
+func NewServer(
+	mcpInformer mcfginformersv1.MachineConfigPoolInformer,
+	nodeInformer coreinformersv1.NodeInformer,
+) *Server {
+	return &Server{
+		deletion:         NewMachineConfigDeletionWebhook(mcpInformer, nodeInformer),
+		customDockerfile: NewCustomDockerfileWebhook(mcpInformer),
+	}
+}
+
+// Run serves the webhooks over TLS on the given address until stopCh is
+// closed, using the tls.crt and tls.key files in certDir.
+func (s *Server) Run(addr, certDir string, stopCh <-chan struct{}) {
+	if !cache.WaitForCacheSync(stopCh, s.deletion.mcpListerSynced, s.deletion.nodeListerSynced, s.customDockerfile.mcpListerSynced) {
+		return
+	}
+
+	klog.Infof("Starting webhooks on %s", addr)
+	mux := http.NewServeMux()
+	mux.Handle(MachineConfigDeletionPath, s.deletion)
+	mux.Handle(CustomDockerfilePath, s.customDockerfile)
+	srv := http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+
+	go func() {
+		if err := srv.ListenAndServeTLS(filepath.Join(certDir, "tls.crt"), filepath.Join(certDir, "tls.key")); err != nil && err != http.ErrServerClosed {
+			klog.Errorf("Webhook server exited with error: %v", err)
+		}
+	}()
+	<-stopCh
+	if err := srv.Shutdown(context.Background()); err != nil && err != http.ErrServerClosed {
+		klog.Errorf("error stopping webhook server: %v", err)
+	}
+}
+
+// serveAdmissionReview decodes an AdmissionReview request, answers it with
+// the response of the given review function and writes it back.
+func serveAdmissionReview(rw http.ResponseWriter, req *http.Request, review func(*admissionv1.AdmissionRequest) *admissionv1.AdmissionResponse) {
+	body, err := io.ReadAll(io.LimitReader(req.Body, maxAdmissionReviewSize))
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ar := &admissionv1.AdmissionReview{}
+	if err := json.Unmarshal(body, ar); err != nil || ar.Request == nil {
+		http.Error(rw, fmt.Sprintf("could not decode AdmissionReview: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	ar.Response = review(ar.Request)
+	ar.Response.UID = ar.Request.UID
+	ar.Request = nil
+
+	out, err := json.Marshal(ar)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.Write(out)
+}
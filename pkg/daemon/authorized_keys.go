@@ -0,0 +1,227 @@
+package daemon
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"syscall"
+
+	ign3types "github.com/coreos/ignition/v2/config/v3_4/types"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	"github.com/openshift/machine-config-operator/pkg/daemon/constants"
+	"k8s.io/klog/v2"
+)
+
+const (
+	sshDirName             = ".ssh"
+	authorizedKeysName     = "authorized_keys"
+	authorizedKeysDirName  = "authorized_keys.d"
+	ignitionAuthorizedKeys = "ignition"
+
+	// The range of UIDs of regular users, as set by UID_MIN and UID_MAX in
+	// login.defs. Users outside of it, e.g. root or nobody, are system users,
+	// which the MCD never writes SSH keys for.
+	minRegularUserUID = 1000
+	maxRegularUserUID = 60000
+)
+
+// getAuthorizedKeysFilePaths returns the paths of the authorized keys files
+// among the files.
+func getAuthorizedKeysFilePaths(files []ign3types.File) []string {
+	paths := []string{}
+	for _, file := range files {
+		if ctrlcommon.IsAuthorizedKeysPath(file.Path) {
+			paths = append(paths, file.Path)
+		}
+	}
+	return paths
+}
+
+// getUserAuthorizedKeysPath returns where Ignition writes the SSH keys of a
+// user with the given home directory.
+func (dn *Daemon) getUserAuthorizedKeysPath(homeDir string) string {
+	if dn.useNewSSHKeyPath() {
+		return filepath.Join(homeDir, sshDirName, authorizedKeysDirName, ignitionAuthorizedKeys)
+	}
+	return filepath.Join(homeDir, sshDirName, authorizedKeysName)
+}
+
+// lookupExistingUser looks up the user, and returns nil if it does not exist
+// since the MCD does not create users.
+func lookupExistingUser(name string) (*user.User, error) {
+	var uErr user.UnknownUserError
+	switch u, err := user.Lookup(name); {
+	case err == nil:
+		return u, nil
+	case errors.As(err, &uErr):
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("failed to check if user %s exists: %w", name, err)
+	}
+}
+
+// restoreSELinuxContext resets the SELinux context of the path and everything
+// below it to the default of the policy, e.g. ssh_home_t for ~/.ssh, which
+// sshd needs to read authorized keys. Hosts without SELinux are left alone.
+func restoreSELinuxContext(path string) error {
+	if _, err := exec.LookPath("restorecon"); err != nil {
+		klog.V(4).Infof("Not restoring SELinux context of %s: restorecon not found", path)
+		return nil
+	}
+
+	if err := runCmdSync("restorecon", "-R", path); err != nil {
+		return fmt.Errorf("failed to restore SELinux context of %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// chownSSHDir hands the directories from the .ssh directory down to dir to
+// the user and makes them private, since sshd refuses to read authorized keys
+// which others could have written.
+func chownSSHDir(sshDir, dir string, uid, gid int) error {
+	for {
+		if err := os.Chown(dir, uid, gid); err != nil {
+			return fmt.Errorf("failed to chown %s: %w", dir, err)
+		}
+		if err := os.Chmod(dir, 0o700); err != nil {
+			return fmt.Errorf("failed to chmod %s: %w", dir, err)
+		}
+		if dir == sshDir || dir == filepath.Dir(dir) {
+			return nil
+		}
+		dir = filepath.Dir(dir)
+	}
+}
+
+// fixAuthorizedKeysFiles makes the authorized keys files among the files
+// which were just written readable by sshd: files without an explicit owner
+// and the directories above them up to ~/.ssh are handed to the owner of the
+// home directory, and their SELinux contexts are restored.
+func fixAuthorizedKeysFiles(files []ign3types.File) error {
+	for _, file := range files {
+		sshDir, ok := ctrlcommon.GetSSHDirOfAuthorizedKeysPath(file.Path)
+		if !ok {
+			continue
+		}
+
+		homeInfo, err := os.Stat(filepath.Dir(sshDir))
+		if err != nil {
+			return fmt.Errorf("failed to stat home directory of %s: %w", file.Path, err)
+		}
+		stat := homeInfo.Sys().(*syscall.Stat_t)
+		uid, gid := int(stat.Uid), int(stat.Gid)
+
+		if file.User.ID == nil && file.User.Name == nil && file.Group.ID == nil && file.Group.Name == nil {
+			if err := os.Chown(file.Path, uid, gid); err != nil {
+				return fmt.Errorf("failed to chown %s: %w", file.Path, err)
+			}
+		}
+
+		if err := chownSSHDir(sshDir, filepath.Dir(file.Path), uid, gid); err != nil {
+			return err
+		}
+
+		if err := restoreSELinuxContext(sshDir); err != nil {
+			return err
+		}
+
+		klog.V(2).Infof("Fixed ownership and SELinux context of authorized keys %s", file.Path)
+	}
+
+	return nil
+}
+
+// isSystemUser returns whether the user is root or another system user.
+func isSystemUser(u *user.User) bool {
+	uid, err := strconv.Atoi(u.Uid)
+	return err != nil || uid < minRegularUserUID || uid > maxRegularUserUID
+}
+
+// writeUserSSHKeys writes the SSH keys of a user other than core to where
+// Ignition wrote them when it provisioned the node. Users which do not exist
+// on the node are skipped, since the MCD does not create users. System users
+// are refused, even if the controller did not recognize them by name.
+func (dn *Daemon) writeUserSSHKeys(name string, keys []ign3types.SSHAuthorizedKey) error {
+	u, err := lookupExistingUser(name)
+	if err != nil {
+		return err
+	}
+	if u == nil {
+		klog.Infof("User %s does not exist, and creating users is not supported, so ignoring its SSH keys", name)
+		return nil
+	}
+	if isSystemUser(u) {
+		return fmt.Errorf("refusing to write SSH keys of system user %s with UID %s", name, u.Uid)
+	}
+
+	uid, _ := strconv.Atoi(u.Uid)
+	gid, _ := strconv.Atoi(u.Gid)
+
+	var concatSSHKeys string
+	for _, k := range keys {
+		concatSSHKeys = concatSSHKeys + string(k) + "\n"
+	}
+
+	authKeyPath := dn.getUserAuthorizedKeysPath(u.HomeDir)
+	sshDir := filepath.Join(u.HomeDir, sshDirName)
+
+	klog.Infof("Writing SSH keys of user %s to %q", name, authKeyPath)
+
+	if err := writeFileAtomically(authKeyPath, []byte(concatSSHKeys), os.FileMode(0o700), os.FileMode(0o600), uid, gid); err != nil {
+		return err
+	}
+
+	if err := chownSSHDir(sshDir, filepath.Dir(authKeyPath), uid, gid); err != nil {
+		return err
+	}
+
+	return restoreSELinuxContext(sshDir)
+}
+
+// removeUserSSHKeys removes the SSH keys the MCD or Ignition wrote for a user
+// other than core which is no longer in the config. The user itself is kept,
+// and the keys of system users are left alone.
+func (dn *Daemon) removeUserSSHKeys(name string) error {
+	u, err := lookupExistingUser(name)
+	if err != nil || u == nil || isSystemUser(u) {
+		return err
+	}
+
+	authKeyPath := dn.getUserAuthorizedKeysPath(u.HomeDir)
+
+	klog.Infof("Removing SSH keys of user %s from %q", name, authKeyPath)
+
+	if err := os.Remove(authKeyPath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove SSH keys of user %s: %w", name, err)
+	}
+
+	return nil
+}
+
+// updateOtherUsersSSHKeys updates the SSH keys of the users other than core.
+func (dn *Daemon) updateOtherUsersSSHKeys(newUsers, oldUsers []ign3types.PasswdUser) error {
+	for _, oldUser := range oldUsers {
+		if oldUser.Name == constants.CoreUserName || isUserPresent(oldUser, newUsers) {
+			continue
+		}
+		if err := dn.removeUserSSHKeys(oldUser.Name); err != nil {
+			return err
+		}
+	}
+
+	for _, u := range newUsers {
+		if u.Name == constants.CoreUserName {
+			continue
+		}
+		if err := dn.writeUserSSHKeys(u.Name, u.SSHAuthorizedKeys); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,49 @@
+package daemon
+
+import (
+	"os"
+	"os/user"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	ign3types "github.com/coreos/ignition/v2/config/v3_4/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFixAuthorizedKeysFiles(t *testing.T) {
+	home := t.TempDir()
+	keysPath := filepath.Join(home, ".ssh", "authorized_keys.d", "bastion")
+
+	// The directories are created with the default permissions of writeFiles.
+	require.NoError(t, writeFileAtomically(keysPath, []byte("ssh-ed25519 AAAA\n"), defaultDirectoryPermissions, 0o600, -1, -1))
+
+	require.NoError(t, fixAuthorizedKeysFiles([]ign3types.File{
+		{Node: ign3types.Node{Path: keysPath}},
+		{Node: ign3types.Node{Path: filepath.Join(home, "agent.conf")}},
+	}))
+
+	homeInfo, err := os.Stat(home)
+	require.NoError(t, err)
+	homeStat := homeInfo.Sys().(*syscall.Stat_t)
+
+	for _, path := range []string{keysPath, filepath.Dir(keysPath), filepath.Join(home, ".ssh")} {
+		info, err := os.Stat(path)
+		require.NoError(t, err)
+		stat := info.Sys().(*syscall.Stat_t)
+		assert.Equal(t, homeStat.Uid, stat.Uid, path)
+		assert.Equal(t, homeStat.Gid, stat.Gid, path)
+		if info.IsDir() {
+			assert.Equal(t, os.FileMode(0o700), info.Mode().Perm(), path)
+		}
+	}
+}
+
+func TestIsSystemUser(t *testing.T) {
+	assert.True(t, isSystemUser(&user.User{Username: "root", Uid: "0"}))
+	assert.True(t, isSystemUser(&user.User{Username: "sshd", Uid: "74"}))
+	assert.True(t, isSystemUser(&user.User{Username: "nobody", Uid: "65534"}))
+	assert.False(t, isSystemUser(&user.User{Username: "core", Uid: "1000"}))
+	assert.False(t, isSystemUser(&user.User{Username: "admin", Uid: "1001"}))
+}
@@ -0,0 +1,153 @@
+package daemon
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"k8s.io/klog/v2"
+)
+
+const (
+	// Set by systemd-stub when the node was booted from a unified kernel
+	// image (UKI), whose kernel command line is embedded in the signed image.
+	stubInfoEFIVar = "sys/firmware/efi/efivars/StubInfo-4a67b082-0a4c-41cf-b6c7-440b29bb8c4f"
+	// The EFI global variable which reports whether Secure Boot is enforced.
+	secureBootEFIVar = "sys/firmware/efi/efivars/SecureBoot-8be4df61-93ca-11d2-aa0d-00e098032b8c"
+)
+
+// bootSetup describes how a node boots, as far as it restricts how the MCD can
+// change kernel arguments.
+type bootSetup struct {
+	// The node booted from a unified kernel image, so the kernel arguments
+	// come from the image and not from the bootloader entries.
+	UKI bool
+	// Secure Boot is enforced.
+	SecureBoot bool
+	// The block device holding /boot is read-only, so the bootloader entries
+	// cannot be rewritten.
+	ReadOnlyBoot bool
+}
+
+// kargsFromImage returns whether the kernel arguments of the node can only be
+// changed by booting an OS image which carries them, instead of with
+// rpm-ostree kargs.
+func (b bootSetup) kargsFromImage() bool {
+	return b.UKI || b.ReadOnlyBoot
+}
+
+func (b bootSetup) String() string {
+	parts := []string{}
+	if b.UKI {
+		parts = append(parts, "unified kernel image")
+	}
+	if b.SecureBoot {
+		parts = append(parts, "Secure Boot")
+	}
+	if b.ReadOnlyBoot {
+		parts = append(parts, "read-only /boot")
+	}
+	if len(parts) == 0 {
+		return "bootloader entries"
+	}
+	return strings.Join(parts, ", ")
+}
+
+// detectBootSetup inspects the EFI variables and the /boot mount below the
+// given root directory.
+func detectBootSetup(root string) (bootSetup, error) {
+	setup := bootSetup{}
+
+	if _, err := os.Stat(filepath.Join(root, stubInfoEFIVar)); err == nil {
+		setup.UKI = true
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return setup, fmt.Errorf("could not determine whether the node booted from a unified kernel image: %w", err)
+	}
+
+	// EFI variables start with 4 bytes of attributes, followed by the value.
+	secureBoot, err := os.ReadFile(filepath.Join(root, secureBootEFIVar))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return setup, fmt.Errorf("could not determine whether Secure Boot is enforced: %w", err)
+	}
+	setup.SecureBoot = len(secureBoot) == 5 && secureBoot[4] == 1
+
+	readOnly, err := isBootDeviceReadOnly(root)
+	if err != nil {
+		return setup, err
+	}
+	setup.ReadOnlyBoot = readOnly
+
+	return setup, nil
+}
+
+// isBootDeviceReadOnly returns whether /boot is a separate mount whose block
+// device is read-only. /boot being mounted read-only is not enough, since
+// rpm-ostree remounts it read-write while it updates the bootloader entries.
+func isBootDeviceReadOnly(root string) (bool, error) {
+	f, err := os.Open(filepath.Join(root, "proc/self/mountinfo"))
+	if err != nil {
+		return false, fmt.Errorf("could not read mounts: %w", err)
+	}
+	defer f.Close()
+
+	device := ""
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// See proc(5): the third field is the device number, the fifth the mount point.
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 5 && fields[4] == "/boot" {
+			device = fields[2]
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return false, fmt.Errorf("could not read mounts: %w", err)
+	}
+
+	if device == "" {
+		return false, nil
+	}
+
+	ro, err := os.ReadFile(filepath.Join(root, "sys/dev/block", device, "ro"))
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("could not determine whether the /boot device %s is read-only: %w", device, err)
+	}
+
+	return strings.TrimSpace(string(ro)) == "1", nil
+}
+
+// getBootSetup detects the boot setup of the node.
+func (dn *Daemon) getBootSetup() (bootSetup, error) {
+	root := dn.bootSetupRoot
+	if root == "" {
+		root = "/"
+	}
+	return detectBootSetup(root)
+}
+
+// checkKernelArgumentsUpdatable returns an error before the node is drained if
+// the kernel arguments need to change but the boot setup of the node does not
+// allow it. Where the kernel arguments come from the OS image, a change is
+// only possible along with a new OS image which carries them.
+func (dn *Daemon) checkKernelArgumentsUpdatable(osUpdate bool) error {
+	setup, err := dn.getBootSetup()
+	if err != nil {
+		return err
+	}
+
+	if !setup.kargsFromImage() {
+		return nil
+	}
+
+	if osUpdate {
+		klog.Infof("Node boots with %s; expecting the new OS image to carry the kernel arguments", setup)
+		return nil
+	}
+
+	return fmt.Errorf("cannot change kernel arguments on a node which boots with %s: the kernel arguments are part of the OS image, so change them in the image instead", setup)
+}
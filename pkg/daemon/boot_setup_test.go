@@ -0,0 +1,86 @@
+package daemon
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeBootSetupFile(t *testing.T, root, path string, contents []byte) {
+	t.Helper()
+
+	path = filepath.Join(root, path)
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+	require.NoError(t, os.WriteFile(path, contents, 0o644))
+}
+
+func TestDetectBootSetup(t *testing.T) {
+	mountinfo := `22 1 253:0 / / rw,relatime shared:1 - xfs /dev/vda4 rw
+40 22 253:3 / /boot ro,relatime shared:60 - ext4 /dev/vda3 ro
+`
+
+	t.Run("bootloader entries", func(t *testing.T) {
+		root := t.TempDir()
+		writeBootSetupFile(t, root, "proc/self/mountinfo", []byte(mountinfo))
+		writeBootSetupFile(t, root, "sys/dev/block/253:3/ro", []byte("0\n"))
+
+		setup, err := detectBootSetup(root)
+		require.NoError(t, err)
+		assert.Equal(t, bootSetup{}, setup)
+		assert.False(t, setup.kargsFromImage())
+		assert.Equal(t, "bootloader entries", setup.String())
+	})
+
+	t.Run("unified kernel image with Secure Boot", func(t *testing.T) {
+		root := t.TempDir()
+		writeBootSetupFile(t, root, "proc/self/mountinfo", []byte(mountinfo))
+		writeBootSetupFile(t, root, stubInfoEFIVar, []byte{0x06, 0, 0, 0})
+		writeBootSetupFile(t, root, secureBootEFIVar, []byte{0x06, 0, 0, 0, 1})
+
+		setup, err := detectBootSetup(root)
+		require.NoError(t, err)
+		assert.Equal(t, bootSetup{UKI: true, SecureBoot: true}, setup)
+		assert.True(t, setup.kargsFromImage())
+		assert.Equal(t, "unified kernel image, Secure Boot", setup.String())
+	})
+
+	t.Run("Secure Boot alone", func(t *testing.T) {
+		root := t.TempDir()
+		writeBootSetupFile(t, root, "proc/self/mountinfo", []byte(mountinfo))
+		writeBootSetupFile(t, root, secureBootEFIVar, []byte{0x06, 0, 0, 0, 1})
+
+		setup, err := detectBootSetup(root)
+		require.NoError(t, err)
+		assert.True(t, setup.SecureBoot)
+		assert.False(t, setup.kargsFromImage())
+	})
+
+	t.Run("read-only /boot device", func(t *testing.T) {
+		root := t.TempDir()
+		writeBootSetupFile(t, root, "proc/self/mountinfo", []byte(mountinfo))
+		writeBootSetupFile(t, root, "sys/dev/block/253:3/ro", []byte("1\n"))
+
+		setup, err := detectBootSetup(root)
+		require.NoError(t, err)
+		assert.Equal(t, bootSetup{ReadOnlyBoot: true}, setup)
+		assert.True(t, setup.kargsFromImage())
+	})
+}
+
+func TestCheckKernelArgumentsUpdatable(t *testing.T) {
+	root := t.TempDir()
+	writeBootSetupFile(t, root, "proc/self/mountinfo", []byte("22 1 253:0 / / rw,relatime shared:1 - xfs /dev/vda4 rw\n"))
+
+	dn := &Daemon{bootSetupRoot: root}
+	assert.NoError(t, dn.checkKernelArgumentsUpdatable(false))
+
+	writeBootSetupFile(t, root, stubInfoEFIVar, []byte{0x06, 0, 0, 0})
+	assert.NoError(t, dn.checkKernelArgumentsUpdatable(true))
+
+	err := dn.checkKernelArgumentsUpdatable(false)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "boots with unified kernel image")
+}
@@ -0,0 +1,119 @@
+package daemon
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"k8s.io/klog/v2"
+)
+
+// The bootc binary; hosts without it are updated with rpm-ostree.
+const bootcPath = "/usr/bin/bootc"
+
+// bootcHost is the subset of `bootc status --json` the MCD uses.
+type bootcHost struct {
+	Status struct {
+		Booted *bootcBootEntry `json:"booted"`
+		Staged *bootcBootEntry `json:"staged"`
+	} `json:"status"`
+}
+
+// bootcBootEntry is a deployment as reported by bootc.
+type bootcBootEntry struct {
+	Image *struct {
+		Image struct {
+			Image     string `json:"image"`
+			Transport string `json:"transport"`
+		} `json:"image"`
+		ImageDigest string `json:"imageDigest"`
+	} `json:"image"`
+	// Incompatible is set when the deployment has local changes, e.g.
+	// packages layered with rpm-ostree, which bootc cannot manage.
+	Incompatible bool `json:"incompatible"`
+}
+
+// parseBootcStatus parses the output of `bootc status --json`.
+func parseBootcStatus(out []byte) (*bootcHost, error) {
+	host := &bootcHost{}
+	if err := json.Unmarshal(out, host); err != nil {
+		return nil, fmt.Errorf("could not parse bootc status: %w", err)
+	}
+	return host, nil
+}
+
+// isManaged returns whether the host booted a container image which bootc
+// can update.
+func (h *bootcHost) isManaged() bool {
+	booted := h.Status.Booted
+	return booted != nil && booted.Image != nil && booted.Image.Image.Image != "" && !booted.Incompatible
+}
+
+// bootedImage returns the image the host booted, or "" if it did not boot
+// one.
+func (h *bootcHost) bootedImage() string {
+	if h.Status.Booted == nil || h.Status.Booted.Image == nil {
+		return ""
+	}
+	return h.Status.Booted.Image.Image.Image
+}
+
+// BootcClient updates the OS of hosts which booted a container image managed
+// by bootc. The rest of the deployment handling, like reading the booted
+// deployment or kernel arguments, still goes through rpm-ostree, which
+// understands the same ostree deployments.
+type BootcClient struct{}
+
+// NewBootcClient returns a BootcClient if the host is managed by bootc, and
+// false otherwise.
+func NewBootcClient() (*BootcClient, bool) {
+	if _, err := os.Stat(bootcPath); err != nil {
+		return nil, false
+	}
+
+	c := &BootcClient{}
+	host, err := c.getStatus()
+	if err != nil {
+		klog.Warningf("Updating the OS with rpm-ostree: %v", err)
+		return nil, false
+	}
+
+	if !host.isManaged() {
+		klog.Infof("Updating the OS with rpm-ostree: the booted deployment is not managed by bootc")
+		return nil, false
+	}
+
+	return c, true
+}
+
+func (c *BootcClient) getStatus() (*bootcHost, error) {
+	out, err := runGetOut("bootc", "status", "--json")
+	if err != nil {
+		return nil, err
+	}
+	return parseBootcStatus(out)
+}
+
+// getSwitchArgs returns the bootc command which stages the image: an upgrade
+// when the host already tracks it, e.g. a tag which moved, and a switch
+// otherwise.
+func getBootcSwitchArgs(host *bootcHost, imgURL string) []string {
+	if host.bootedImage() == imgURL {
+		return []string{"upgrade"}
+	}
+	return []string{"switch", "--transport", "registry", imgURL}
+}
+
+// SwitchImage stages the image to be booted next. Each line of progress bootc
+// prints is handed to onProgress.
+func (c *BootcClient) SwitchImage(imgURL string, onProgress func(string)) error {
+	host, err := c.getStatus()
+	if err != nil {
+		return err
+	}
+
+	// Try to re-link the merged pull secrets if they exist, since it could have been populated without a daemon reboot
+	useMergedPullSecrets()
+	klog.Infof("Executing bootc switch to %s", imgURL)
+	return runCmdSyncWithProgress(onProgress, "bootc", getBootcSwitchArgs(host, imgURL)...)
+}
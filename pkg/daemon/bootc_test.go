@@ -0,0 +1,49 @@
+package daemon
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseBootcStatus(t *testing.T) {
+	const image = "registry.example.com/rhcos@sha256:0123"
+
+	managed := `{
+  "apiVersion": "org.containers.bootc/v1",
+  "kind": "BootcHost",
+  "spec": {"image": {"image": "` + image + `", "transport": "registry"}},
+  "status": {
+    "staged": null,
+    "booted": {
+      "image": {"image": {"image": "` + image + `", "transport": "registry"}, "imageDigest": "sha256:0123"},
+      "incompatible": false,
+      "pinned": false
+    },
+    "rollback": null,
+    "type": "bootcHost"
+  }
+}`
+
+	host, err := parseBootcStatus([]byte(managed))
+	require.NoError(t, err)
+	assert.True(t, host.isManaged())
+	assert.Equal(t, image, host.bootedImage())
+	assert.Equal(t, []string{"upgrade"}, getBootcSwitchArgs(host, image))
+	assert.Equal(t, []string{"switch", "--transport", "registry", "registry.example.com/rhcos@sha256:4567"}, getBootcSwitchArgs(host, "registry.example.com/rhcos@sha256:4567"))
+
+	// Deployments with packages layered by rpm-ostree cannot be updated with bootc.
+	host, err = parseBootcStatus([]byte(`{"status":{"booted":{"image":{"image":{"image":"` + image + `","transport":"registry"}},"incompatible":true}}}`))
+	require.NoError(t, err)
+	assert.False(t, host.isManaged())
+
+	// Hosts which were not booted from a container image.
+	host, err = parseBootcStatus([]byte(`{"status":{"booted":{"image":null,"incompatible":false}}}`))
+	require.NoError(t, err)
+	assert.False(t, host.isManaged())
+	assert.Equal(t, "", host.bootedImage())
+
+	_, err = parseBootcStatus([]byte("error: not a bootc host"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,47 @@
+package daemon
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	"github.com/openshift/machine-config-operator/pkg/daemon/constants"
+	"k8s.io/klog/v2"
+)
+
+// reportKernelArguments records the kernel arguments the node booted with in
+// its node status under KernelArgumentsAnnotationKey, so that the effective
+// result of merging the kernel arguments of the MachineConfigs can be checked
+// without logging into the node.
+func (dn *Daemon) reportKernelArguments() error {
+	if dn.mock || dn.nodeWriter == nil {
+		return nil
+	}
+
+	cmdline, err := os.ReadFile(CmdLineFile)
+	if err != nil {
+		return fmt.Errorf("could not read %s: %w", CmdLineFile, err)
+	}
+
+	kargs := ctrlcommon.SplitKernelArguments(strings.TrimSpace(string(cmdline)))
+	if kargs == nil {
+		kargs = []string{}
+	}
+
+	out, err := json.Marshal(kargs)
+	if err != nil {
+		return err
+	}
+
+	if dn.node != nil {
+		if reported, _ := ctrlcommon.GetNodeStatus(dn.node, constants.KernelArgumentsAnnotationKey); reported == string(out) {
+			return nil
+		}
+	}
+
+	klog.Infof("Reporting kernel arguments: %s", out)
+	_, err = dn.nodeWriter.SetNodeStatus(map[string]string{constants.KernelArgumentsAnnotationKey: string(out)})
+	return err
+}
@@ -0,0 +1,109 @@
+package daemon
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	"github.com/openshift/machine-config-operator/pkg/daemon/constants"
+	"k8s.io/klog/v2"
+)
+
+// componentVersions are the versions of the node components which the MCD
+// reports in the ComponentVersionsAnnotationKey annotation. Versions which
+// could not be determined are omitted.
+type componentVersions struct {
+	Kubelet   string `json:"kubelet,omitempty"`
+	CRIO      string `json:"crio,omitempty"`
+	RpmOstree string `json:"rpm-ostree,omitempty"`
+	OS        string `json:"os,omitempty"`
+}
+
+// parseKubeletVersion parses the output of `kubelet --version`, e.g.
+// "Kubernetes v1.28.3+20a2ae5".
+func parseKubeletVersion(out string) (string, error) {
+	fields := strings.Fields(out)
+	if len(fields) != 2 || fields[0] != "Kubernetes" {
+		return "", fmt.Errorf("unexpected output of kubelet --version: %q", out)
+	}
+
+	return fields[1], nil
+}
+
+// parseCRIOVersion parses the output of `crio --version`, which either has a
+// "Version:" line or, in older releases, starts with "crio version X".
+func parseCRIOVersion(out string) (string, error) {
+	for _, line := range strings.Split(out, "\n") {
+		line = strings.TrimSpace(line)
+
+		if version, ok := strings.CutPrefix(line, "Version:"); ok {
+			return strings.TrimSpace(version), nil
+		}
+
+		if version, ok := strings.CutPrefix(line, "crio version "); ok {
+			return strings.TrimSpace(version), nil
+		}
+	}
+
+	return "", fmt.Errorf("unexpected output of crio --version: %q", out)
+}
+
+// getComponentVersions determines the versions of the components installed on
+// the node. Failures are logged and leave the version out, since a missing
+// binary should not prevent the others from being reported.
+func (dn *Daemon) getComponentVersions() componentVersions {
+	versions := componentVersions{}
+
+	if out, err := runGetOut("kubelet", "--version"); err != nil {
+		klog.Warningf("Could not get kubelet version: %v", err)
+	} else if versions.Kubelet, err = parseKubeletVersion(string(out)); err != nil {
+		klog.Warningf("Could not get kubelet version: %v", err)
+	}
+
+	if out, err := runGetOut("crio", "--version"); err != nil {
+		klog.Warningf("Could not get CRI-O version: %v", err)
+	} else if versions.CRIO, err = parseCRIOVersion(string(out)); err != nil {
+		klog.Warningf("Could not get CRI-O version: %v", err)
+	}
+
+	if dn.os.IsCoreOSVariant() && dn.NodeUpdaterClient != nil {
+		if verdata, err := rpmOstreeVersion(); err != nil {
+			klog.Warningf("Could not get rpm-ostree version: %v", err)
+		} else {
+			versions.RpmOstree = verdata.Version
+		}
+
+		if _, osVersion, _, err := dn.NodeUpdaterClient.GetBootedOSImageURL(); err != nil {
+			klog.Warningf("Could not get booted OS version: %v", err)
+		} else {
+			versions.OS = osVersion
+		}
+	}
+
+	return versions
+}
+
+// reportComponentVersions records the versions of the components installed on
+// the node in its node status under ComponentVersionsAnnotationKey so that the
+// node controller can aggregate them for the pool.
+func (dn *Daemon) reportComponentVersions() error {
+	if dn.mock || dn.nodeWriter == nil {
+		return nil
+	}
+
+	out, err := json.Marshal(dn.getComponentVersions())
+	if err != nil {
+		return err
+	}
+
+	if dn.node != nil {
+		if reported, _ := ctrlcommon.GetNodeStatus(dn.node, constants.ComponentVersionsAnnotationKey); reported == string(out) {
+			return nil
+		}
+	}
+
+	klog.Infof("Reporting component versions: %s", out)
+	_, err = dn.nodeWriter.SetNodeStatus(map[string]string{constants.ComponentVersionsAnnotationKey: string(out)})
+	return err
+}
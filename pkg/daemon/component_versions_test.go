@@ -0,0 +1,34 @@
+package daemon
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseKubeletVersion(t *testing.T) {
+	version, err := parseKubeletVersion("Kubernetes v1.28.3+20a2ae5\n")
+	assert.NoError(t, err)
+	assert.Equal(t, "v1.28.3+20a2ae5", version)
+
+	_, err = parseKubeletVersion("kubelet: command not found")
+	assert.Error(t, err)
+}
+
+func TestParseCRIOVersion(t *testing.T) {
+	out := `INFO[2023-11-02 12:00:00.000000000Z] Starting CRI-O, version: 1.28.2-2.rhaos4.15.git9b3e4a0.el9, git: ()
+Version:        1.28.2-2.rhaos4.15.git9b3e4a0.el9
+GitCommit:      unknown
+GoVersion:      go1.20.10
+`
+	version, err := parseCRIOVersion(out)
+	assert.NoError(t, err)
+	assert.Equal(t, "1.28.2-2.rhaos4.15.git9b3e4a0.el9", version)
+
+	version, err = parseCRIOVersion("crio version 1.21.0\n")
+	assert.NoError(t, err)
+	assert.Equal(t, "1.21.0", version)
+
+	_, err = parseCRIOVersion("")
+	assert.Error(t, err)
+}
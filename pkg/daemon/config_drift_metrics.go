@@ -0,0 +1,135 @@
+package daemon
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+
+	ign3types "github.com/coreos/ignition/v2/config/v3_4/types"
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	"k8s.io/klog/v2"
+)
+
+// What drifted from the MachineConfig, as reported in the mode label of the
+// config drift metrics.
+const (
+	// The contents of a file or unit differ, or it is missing.
+	configDriftModeContent = "content"
+	// The mode of a file differs.
+	configDriftModePermissions = "permissions"
+	// The live value of a sysctl differs.
+	configDriftModeSysctl = "sysctl"
+)
+
+// configDrift is a path which drifted from the MachineConfig.
+type configDrift struct {
+	Path string
+	Mode string
+}
+
+// getFileConfigDrift returns how the file drifted from the config, if it
+// did. A file whose contents and mode both differ drifted in both ways.
+func getFileConfigDrift(file ign3types.File) ([]configDrift, error) {
+	info, err := os.Lstat(file.Path)
+	if err != nil {
+		return []configDrift{{Path: file.Path, Mode: configDriftModeContent}}, nil
+	}
+
+	drifts := []configDrift{}
+
+	mode := defaultFilePermissions
+	if file.Mode != nil {
+		mode = os.FileMode(*file.Mode)
+	}
+	if info.Mode() != mode {
+		drifts = append(drifts, configDrift{Path: file.Path, Mode: configDriftModePermissions})
+	}
+
+	expected, err := ctrlcommon.DecodeIgnitionFileContents(file.Contents.Source, file.Contents.Compression)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't decode file %q: %w", file.Path, err)
+	}
+
+	contents, err := os.ReadFile(file.Path)
+	if err != nil || !bytes.Equal(contents, expected) {
+		drifts = append(drifts, configDrift{Path: file.Path, Mode: configDriftModeContent})
+	}
+
+	return drifts, nil
+}
+
+// getConfigDrift returns the files, units and sysctls which drifted from the
+// MachineConfig, skipping the unmanaged paths. Like checkV3Files, the CA
+// bundle is skipped.
+func getConfigDrift(mc *mcfgv1.MachineConfig, systemdPath, procSysPath string, unmanagedPaths []string) ([]configDrift, error) {
+	ignConfig, err := ctrlcommon.ParseAndConvertConfig(mc.Spec.Config.Raw)
+	if err != nil {
+		return nil, err
+	}
+
+	drifts := []configDrift{}
+
+	for _, file := range filterUnmanagedV3Files(ignConfig.Storage.Files, unmanagedPaths) {
+		if file.Path == caBundleFilePath {
+			continue
+		}
+
+		fileDrifts, err := getFileConfigDrift(file)
+		if err != nil {
+			return nil, err
+		}
+		drifts = append(drifts, fileDrifts...)
+	}
+
+	for _, unit := range getDriftedV3Units(ignConfig.Systemd.Units, systemdPath) {
+		drifts = append(drifts, configDrift{Path: getIgn3SystemdUnitPath(systemdPath, unit), Mode: configDriftModeContent})
+	}
+
+	sysctls, err := getSysctlsFromIgn3Config(filterUnmanagedV3Files(ignConfig.Storage.Files, unmanagedPaths))
+	if err != nil {
+		return nil, err
+	}
+
+	driftedSysctls, _, err := getDriftedSysctls(sysctls, procSysPath)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, s := range driftedSysctls {
+		drifts = append(drifts, configDrift{Path: getSysctlProcPath(procSysPath, s.Key), Mode: configDriftModeSysctl})
+	}
+
+	return drifts, nil
+}
+
+// recordConfigDrift replaces the drifted paths in the mcd_config_drift gauge
+// with the given ones, and counts their detection in
+// mcd_config_drift_events_total.
+func recordConfigDrift(drifts []configDrift) {
+	mcdConfigDrift.Reset()
+
+	for _, drift := range drifts {
+		mcdConfigDrift.WithLabelValues(drift.Path, drift.Mode).Set(1)
+		mcdConfigDriftEvents.WithLabelValues(drift.Path, drift.Mode).Inc()
+	}
+}
+
+// clearConfigDrift empties the mcd_config_drift gauge once the node no longer
+// drifts from its MachineConfig.
+func clearConfigDrift() {
+	mcdConfigDrift.Reset()
+}
+
+// recordConfigDriftOf records the paths which drifted from the MachineConfig
+// in the config drift metrics. Failing to find them is only logged, since the
+// drift itself is handled regardless.
+func (dn *Daemon) recordConfigDriftOf(mc *mcfgv1.MachineConfig) {
+	drifts, err := getConfigDrift(mc, pathSystemd, pathProcSys, dn.getUnmanagedPaths())
+	if err != nil {
+		klog.Warningf("Could not record config drift metrics: %v", err)
+		return
+	}
+
+	recordConfigDrift(drifts)
+}
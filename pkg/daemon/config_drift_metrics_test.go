@@ -0,0 +1,83 @@
+package daemon
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	ign3types "github.com/coreos/ignition/v2/config/v3_4/types"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	"github.com/openshift/machine-config-operator/test/helpers"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetConfigDrift(t *testing.T) {
+	tmpDir := t.TempDir()
+	procSysPath := filepath.Join(tmpDir, "proc-sys")
+
+	contentPath := filepath.Join(tmpDir, "content")
+	permissionsPath := filepath.Join(tmpDir, "permissions")
+	bothPath := filepath.Join(tmpDir, "both")
+	missingPath := filepath.Join(tmpDir, "missing")
+	intactPath := filepath.Join(tmpDir, "intact")
+	unmanagedPath := filepath.Join(tmpDir, "unmanaged")
+
+	files := []ign3types.File{}
+	for _, path := range []string{contentPath, permissionsPath, bothPath, missingPath, intactPath, unmanagedPath} {
+		files = append(files, helpers.CreateEncodedIgn3File(path, "thefilecontents", int(defaultFilePermissions)))
+		require.NoError(t, os.WriteFile(path, []byte("thefilecontents"), defaultFilePermissions))
+	}
+
+	require.NoError(t, os.WriteFile(contentPath, []byte("drifted"), defaultFilePermissions))
+	require.NoError(t, os.Chmod(permissionsPath, 0o600))
+	require.NoError(t, os.WriteFile(bothPath, []byte("drifted"), defaultFilePermissions))
+	require.NoError(t, os.Chmod(bothPath, 0o600))
+	require.NoError(t, os.Remove(missingPath))
+	require.NoError(t, os.WriteFile(unmanagedPath, []byte("unmanaged"), defaultFilePermissions))
+
+	// The sysctl files are not written, since only the live values matter.
+	files = append(files, helpers.CreateEncodedIgn3File("/etc/sysctl.d/99-custom.conf", "net.core.somaxconn = 4096\n", 0o644))
+	somaxconnPath := getSysctlProcPath(procSysPath, "net.core.somaxconn")
+	require.NoError(t, os.MkdirAll(filepath.Dir(somaxconnPath), 0o755))
+	require.NoError(t, os.WriteFile(somaxconnPath, []byte("128\n"), 0o644))
+
+	ignConfig := ctrlcommon.NewIgnConfig()
+	ignConfig.Storage.Files = files
+	mc := helpers.CreateMachineConfigFromIgnition(ignConfig)
+
+	drifts, err := getConfigDrift(mc, tmpDir, procSysPath, []string{unmanagedPath, "/etc/sysctl.d/99-custom.conf"})
+	require.NoError(t, err)
+	assert.Equal(t, []configDrift{
+		{Path: contentPath, Mode: configDriftModeContent},
+		{Path: permissionsPath, Mode: configDriftModePermissions},
+		{Path: bothPath, Mode: configDriftModePermissions},
+		{Path: bothPath, Mode: configDriftModeContent},
+		{Path: missingPath, Mode: configDriftModeContent},
+	}, drifts)
+
+	drifts, err = getConfigDrift(mc, tmpDir, procSysPath, []string{unmanagedPath})
+	require.NoError(t, err)
+	assert.Contains(t, drifts, configDrift{Path: somaxconnPath, Mode: configDriftModeSysctl})
+}
+
+func TestRecordConfigDrift(t *testing.T) {
+	defer clearConfigDrift()
+
+	recordConfigDrift([]configDrift{
+		{Path: "/etc/a", Mode: configDriftModeContent},
+		{Path: "/etc/b", Mode: configDriftModePermissions},
+	})
+	assert.Equal(t, 2, testutil.CollectAndCount(mcdConfigDrift))
+	assert.Equal(t, 1.0, testutil.ToFloat64(mcdConfigDrift.WithLabelValues("/etc/a", configDriftModeContent)))
+
+	// The gauge only holds the latest drift, while the counters keep counting.
+	recordConfigDrift([]configDrift{{Path: "/etc/a", Mode: configDriftModeContent}})
+	assert.Equal(t, 1, testutil.CollectAndCount(mcdConfigDrift))
+	assert.Equal(t, 2.0, testutil.ToFloat64(mcdConfigDriftEvents.WithLabelValues("/etc/a", configDriftModeContent)))
+	assert.Equal(t, 1.0, testutil.ToFloat64(mcdConfigDriftEvents.WithLabelValues("/etc/b", configDriftModePermissions)))
+
+	clearConfigDrift()
+	assert.Equal(t, 0, testutil.CollectAndCount(mcdConfigDrift))
+}
@@ -6,6 +6,7 @@ import (
 	"os"
 	"path/filepath"
 	"sync"
+	"time"
 
 	ign2types "github.com/coreos/ignition/config/v2_2/types"
 	ign3types "github.com/coreos/ignition/v2/config/v3_4/types"
@@ -48,6 +49,15 @@ type ConfigDriftMonitorOpts struct {
 	SystemdPath string
 	// Channel to report unknown errors
 	ErrChan chan<- error
+	// Returns the paths which are excluded from MCO management. Called for
+	// every file event so that changes take effect right away. Optional.
+	UnmanagedPaths func() []string
+	// The path below which the kernel exposes sysctls.
+	// Defaults to /proc/sys
+	ProcSysPath string
+	// How often the live values of the sysctls set by the MachineConfig are
+	// checked. Defaults to 5 minutes.
+	SysctlCheckInterval time.Duration
 }
 
 // Holds the Config Drift Watcher and ensures we only have a single instance
@@ -65,6 +75,9 @@ type configDriftWatcher struct {
 	filePaths sets.Set[string]
 	wg        sync.WaitGroup
 	stopCh    chan struct{}
+	// The last sysctl drift reported, so that it is not reported again on
+	// every check.
+	lastSysctlDrift string
 }
 
 // Holds a single Config Drift Watcher and starts / stops it as necessary while
@@ -157,6 +170,14 @@ func newConfigDriftWatcher(opts ConfigDriftMonitorOpts) (*configDriftWatcher, er
 		opts.SystemdPath = pathSystemd
 	}
 
+	if opts.ProcSysPath == "" {
+		opts.ProcSysPath = pathProcSys
+	}
+
+	if opts.SysctlCheckInterval == 0 {
+		opts.SysctlCheckInterval = defaultSysctlCheckInterval
+	}
+
 	c := &configDriftWatcher{
 		ConfigDriftMonitorOpts: opts,
 		stopCh:                 make(chan struct{}),
@@ -222,10 +243,19 @@ func (c *configDriftWatcher) start() {
 	c.wg = sync.WaitGroup{}
 	c.wg.Add(1)
 
+	// Changes to sysctls do not show up as file events, so their live values
+	// are checked periodically.
+	sysctlTicker := time.NewTicker(c.SysctlCheckInterval)
+
 	go func() {
 		defer c.wg.Done()
+		defer sysctlTicker.Stop()
 		for {
 			select {
+			case <-sysctlTicker.C:
+				if err := c.handleSysctlCheck(); err != nil {
+					c.ErrChan <- err
+				}
 			case event := <-c.watcher.Events:
 				// Our watcher is reporting an event that we should look at.
 				if err := c.handleFileEvent(event); err != nil {
@@ -274,6 +304,37 @@ func (c *configDriftWatcher) handleFileEvent(event fsnotify.Event) error {
 	return fmt.Errorf("unknown config drift error: %w", err)
 }
 
+// Validates the live values of the sysctls set by the MachineConfig and
+// reports new drift to the provided callback.
+func (c *configDriftWatcher) handleSysctlCheck() error {
+	var unmanagedPaths []string
+	if c.UnmanagedPaths != nil {
+		unmanagedPaths = c.UnmanagedPaths()
+	}
+
+	err := checkSysctls(c.MachineConfig, c.ProcSysPath, unmanagedPaths)
+	if err == nil {
+		c.lastSysctlDrift = ""
+		return nil
+	}
+
+	var scErr *sysctlConfigDriftErr
+	if !errors.As(err, &scErr) {
+		return fmt.Errorf("unknown config drift error: %w", err)
+	}
+
+	// The node stays degraded until the drift is gone, so the same drift is
+	// only reported once.
+	if err.Error() == c.lastSysctlDrift {
+		return nil
+	}
+	c.lastSysctlDrift = err.Error()
+
+	c.OnDrift(&configDriftErr{err})
+
+	return nil
+}
+
 // Validates on disk state for potential config drift.
 func (c *configDriftWatcher) checkMachineConfigForEvent(event fsnotify.Event) error {
 	// Ignore events for files not found in the MachineConfig.
@@ -281,7 +342,17 @@ func (c *configDriftWatcher) checkMachineConfigForEvent(event fsnotify.Event) er
 		return nil
 	}
 
-	if err := validateOnDiskState(c.MachineConfig, c.SystemdPath); err != nil {
+	var unmanagedPaths []string
+	if c.UnmanagedPaths != nil {
+		unmanagedPaths = c.UnmanagedPaths()
+	}
+
+	// Ignore events for files which something other than the MCO manages.
+	if ctrlcommon.IsUnmanagedPath(event.Name, unmanagedPaths) {
+		return nil
+	}
+
+	if err := validateOnDiskState(c.MachineConfig, c.SystemdPath, unmanagedPaths); err != nil {
 		return &configDriftErr{err}
 	}
 
@@ -99,6 +99,25 @@ func TestConfigDriftMonitor(t *testing.T) {
 			expectedErr: fileErr,
 			mutateFile:  chmodFile,
 		},
+		// Unmanaged Paths
+		// Drift in files covered by the unmanaged paths is ignored, while
+		// drift in other files is still detected.
+		{
+			name:           "unmanaged ign file content drift",
+			mutateFile:     changeFileContent,
+			unmanagedPaths: []string{"/etc/a-config-file"},
+		},
+		{
+			name:           "unmanaged dir ign file delete",
+			mutateFile:     os.Remove,
+			unmanagedPaths: []string{"/etc/"},
+		},
+		{
+			name:                 "ign compressed file content drift with unmanaged file",
+			expectedErr:          fileErr,
+			mutateCompressedFile: changeFileContent,
+			unmanagedPaths:       []string{"/etc/a-config-file"},
+		},
 		// Compressed Ignition File
 		// These target the file called /etc/a-compressed-file defined by the test
 		// fixture.
@@ -245,6 +264,8 @@ type configDriftMonitorTestCase struct {
 	mutateUnit func(string) error
 	// The mutation to apply to the systemd dropin file
 	mutateDropin func(string) error
+	// The unmanaged paths, relative to the tmpdir
+	unmanagedPaths []string
 	// Mutex to ensure that parallel tests do not stomp on one another
 	testMutex *sync.Mutex
 }
@@ -313,6 +334,16 @@ func (tc configDriftMonitorTestCase) run(t *testing.T) {
 		},
 	}
 
+	if tc.unmanagedPaths != nil {
+		opts.UnmanagedPaths = func() []string {
+			out := []string{}
+			for _, path := range tc.unmanagedPaths {
+				out = append(out, tc.tmpDir+path)
+			}
+			return out
+		}
+	}
+
 	// Start the config drift monitor
 	require.Nil(t, cdm.Start(opts))
 
@@ -0,0 +1,173 @@
+package daemon
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	ign3types "github.com/coreos/ignition/v2/config/v3_4/types"
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	"github.com/openshift/machine-config-operator/pkg/daemon/constants"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+)
+
+var (
+	// How many times the MCD tries to remediate config drift before degrading the node.
+	configDriftRemediationAttempts = 3
+	// How long the MCD waits between attempts to remediate config drift.
+	configDriftRemediationInterval = 5 * time.Second
+)
+
+// isConfigDriftRemediationEnabled returns whether the node controller told
+// the MCD to remediate config drift instead of degrading the node. Like
+// getUnmanagedPaths, the node is read from the lister so that this can be
+// called from the Config Drift Monitor.
+func (dn *Daemon) isConfigDriftRemediationEnabled() bool {
+	if dn.nodeLister == nil {
+		return false
+	}
+
+	node, err := dn.nodeLister.Get(dn.name)
+	if err != nil {
+		klog.Warningf("Could not get node %s for its config drift remediation: %v", dn.name, err)
+		return false
+	}
+
+	return node.Annotations[constants.ConfigDriftRemediationAnnotationKey] == ctrlcommon.ConfigDriftRemediationRemediate
+}
+
+// remediateConfigDrift tries to rewrite the drifted files and units back to
+// the contents of the given MachineConfig. Each failed attempt is reported in
+// an event and the last error is returned once all attempts have failed.
+func (dn *Daemon) remediateConfigDrift(mc *mcfgv1.MachineConfig) error {
+	var err error
+
+	for attempt := 1; attempt <= configDriftRemediationAttempts; attempt++ {
+		var remediated []string
+		remediated, err = remediateConfigDrift(mc, pathSystemd, pathProcSys, dn.getUnmanagedPaths(), dn.os.IsCoreOSVariant())
+		if err == nil {
+			dn.nodeWriter.Eventf(corev1.EventTypeNormal, "ConfigDriftRemediated",
+				"Remediated %s to match %s", strings.Join(remediated, ", "), mc.Name)
+			return nil
+		}
+
+		klog.Errorf("Config drift remediation attempt %d of %d failed: %v", attempt, configDriftRemediationAttempts, err)
+		dn.nodeWriter.Eventf(corev1.EventTypeWarning, "ConfigDriftRemediationFailed",
+			"Attempt %d of %d to remediate config drift failed: %v", attempt, configDriftRemediationAttempts, err)
+
+		if attempt < configDriftRemediationAttempts {
+			time.Sleep(configDriftRemediationInterval)
+		}
+	}
+
+	return fmt.Errorf("could not remediate config drift after %d attempts: %w", configDriftRemediationAttempts, err)
+}
+
+// remediateConfigDrift rewrites the files and units which drifted from the
+// given MachineConfig, skipping the unmanaged paths, and sets the sysctls
+// whose live values drifted back to their configured values. It returns the
+// paths of the files and units and the keys of the sysctls. Unlike an update,
+// no .orig files are created since the drifted contents are not worth keeping.
+func remediateConfigDrift(mc *mcfgv1.MachineConfig, systemdPath, procSysPath string, unmanagedPaths []string, isCoreOSVariant bool) ([]string, error) {
+	ignConfig, err := ctrlcommon.ParseAndConvertConfig(mc.Spec.Config.Raw)
+	if err != nil {
+		return nil, err
+	}
+
+	remediated := []string{}
+
+	for _, file := range getDriftedV3Files(filterUnmanagedV3Files(ignConfig.Storage.Files, unmanagedPaths)) {
+		klog.Infof("Remediating config drift of file %q", file.Path)
+
+		contents, err := ctrlcommon.DecodeIgnitionFileContents(file.Contents.Source, file.Contents.Compression)
+		if err != nil {
+			return nil, fmt.Errorf("could not decode file %q: %w", file.Path, err)
+		}
+
+		mode := defaultFilePermissions
+		if file.Mode != nil {
+			mode = os.FileMode(*file.Mode)
+		}
+
+		uid, gid, err := getFileOwnership(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to retrieve file ownership for file %q: %w", file.Path, err)
+		}
+
+		if err := writeFileAtomically(file.Path, contents, defaultDirectoryPermissions, mode, uid, gid); err != nil {
+			return nil, err
+		}
+
+		remediated = append(remediated, file.Path)
+	}
+
+	units := getDriftedV3Units(ignConfig.Systemd.Units, systemdPath)
+	for _, unit := range units {
+		klog.Infof("Remediating config drift of systemd unit %q", unit.Name)
+
+		if err := writeUnit(unit, systemdPath, isCoreOSVariant); err != nil {
+			return nil, err
+		}
+
+		remediated = append(remediated, getIgn3SystemdUnitPath(systemdPath, unit))
+	}
+
+	if len(units) != 0 {
+		if err := runCmdSync("systemctl", "daemon-reload"); err != nil {
+			return nil, err
+		}
+	}
+
+	// The files are rewritten first, since the sysctls are set to the values
+	// of the rewritten files.
+	sysctls, err := remediateSysctls(mc, procSysPath, unmanagedPaths)
+	if err != nil {
+		return nil, err
+	}
+	remediated = append(remediated, sysctls...)
+
+	if err := validateOnDiskState(mc, systemdPath, unmanagedPaths); err != nil {
+		return nil, fmt.Errorf("config drift remains after rewriting %s: %w", strings.Join(remediated, ", "), err)
+	}
+
+	if err := checkSysctls(mc, procSysPath, unmanagedPaths); err != nil {
+		return nil, fmt.Errorf("config drift remains after setting %s: %w", strings.Join(sysctls, ", "), err)
+	}
+
+	return remediated, nil
+}
+
+// getDriftedV3Files returns the files whose contents or mode differ from the
+// config. Like checkV3Files, the CA bundle is skipped.
+func getDriftedV3Files(files []ign3types.File) []ign3types.File {
+	out := []ign3types.File{}
+
+	for _, file := range files {
+		if file.Path == caBundleFilePath {
+			continue
+		}
+
+		if err := checkV3Files([]ign3types.File{file}); err != nil {
+			out = append(out, file)
+		}
+	}
+
+	return out
+}
+
+// getDriftedV3Units returns the units whose contents, dropins or mask differ
+// from the config.
+func getDriftedV3Units(units []ign3types.Unit, systemdPath string) []ign3types.Unit {
+	out := []ign3types.Unit{}
+
+	for _, unit := range units {
+		if err := checkV3Unit(unit, systemdPath); err != nil {
+			out = append(out, unit)
+		}
+	}
+
+	return out
+}
@@ -0,0 +1,61 @@
+package daemon
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	ign3types "github.com/coreos/ignition/v2/config/v3_4/types"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	"github.com/openshift/machine-config-operator/test/helpers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Tests that only the drifted files are rewritten and that unmanaged files
+// are left alone.
+func TestRemediateConfigDrift(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	driftedPath := filepath.Join(tmpDir, "drifted")
+	chmodedPath := filepath.Join(tmpDir, "chmoded")
+	intactPath := filepath.Join(tmpDir, "intact")
+	unmanagedPath := filepath.Join(tmpDir, "unmanaged")
+
+	files := []ign3types.File{}
+	for _, path := range []string{driftedPath, chmodedPath, intactPath, unmanagedPath} {
+		file := setDefaultUIDandGID(helpers.CreateEncodedIgn3File(path, "thefilecontents", int(defaultFilePermissions)))
+		require.NoError(t, os.WriteFile(path, []byte("thefilecontents"), defaultFilePermissions))
+		files = append(files, file)
+	}
+
+	require.NoError(t, os.WriteFile(driftedPath, []byte("drifted"), defaultFilePermissions))
+	require.NoError(t, os.Chmod(chmodedPath, 0o600))
+	require.NoError(t, os.WriteFile(unmanagedPath, []byte("unmanaged"), defaultFilePermissions))
+
+	unmanagedPaths := []string{unmanagedPath}
+
+	drifted := getDriftedV3Files(filterUnmanagedV3Files(files, unmanagedPaths))
+	require.Len(t, drifted, 2)
+	assert.Equal(t, driftedPath, drifted[0].Path)
+	assert.Equal(t, chmodedPath, drifted[1].Path)
+
+	ignConfig := ctrlcommon.NewIgnConfig()
+	ignConfig.Storage.Files = files
+	mc := helpers.CreateMachineConfigFromIgnition(ignConfig)
+
+	remediated, err := remediateConfigDrift(mc, tmpDir, tmpDir, unmanagedPaths, false)
+	require.NoError(t, err)
+	assert.Equal(t, []string{driftedPath, chmodedPath}, remediated)
+
+	assert.Empty(t, getDriftedV3Files(files[:3]))
+
+	contents, err := os.ReadFile(unmanagedPath)
+	require.NoError(t, err)
+	assert.Equal(t, "unmanaged", string(contents))
+
+	// Nothing is rewritten once the drift is remediated.
+	remediated, err = remediateConfigDrift(mc, tmpDir, tmpDir, unmanagedPaths, false)
+	require.NoError(t, err)
+	assert.Empty(t, remediated)
+}
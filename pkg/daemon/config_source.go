@@ -0,0 +1,202 @@
+package daemon
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	"github.com/openshift/machine-config-operator/pkg/daemon/constants"
+	"github.com/openshift/machine-config-operator/pkg/server"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// The root CA which signs the MachineConfigServer's serving certificate.
+	rootCAFilePath = "/etc/kubernetes/ca.crt"
+
+	// The Accept header sent to the MachineConfigServer, which serves the
+	// Ignition spec version it names.
+	mcsFetchAcceptHeader = "application/vnd.coreos.ignition+json;version=3.4.0"
+
+	mcsFetchTimeout = 30 * time.Second
+
+	configSourceAPIServer = "apiserver"
+	configSourceMCS       = "machine-config-server"
+)
+
+// Fetches rendered MachineConfigs from the MachineConfigServer, which serves
+// the newest rendered MachineConfig of each pool within the Ignition config
+// for new nodes. This allows the MCD to get its config when the apiserver is
+// unreachable, such as during early boot.
+type mcsConfigFetcher struct {
+	// The base URL of the MachineConfigServer, e.g.,
+	// https://api-int.cluster.example.com:22623.
+	url    string
+	client *http.Client
+}
+
+// Creates a fetcher for the MachineConfigServer behind the given apiserver
+// URL. The MachineConfigServer listens on the same host as the apiserver,
+// which is the api-int load balancer on nodes.
+func newMCSConfigFetcher(apiURL, rootCAPath string) (*mcsConfigFetcher, error) {
+	u, err := url.Parse(apiURL)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse apiserver URL %q: %w", apiURL, err)
+	}
+
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("apiserver URL %q has no host", apiURL)
+	}
+
+	rootCA, err := os.ReadFile(rootCAPath)
+	if err != nil {
+		return nil, fmt.Errorf("could not read root CA: %w", err)
+	}
+
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(rootCA) {
+		return nil, fmt.Errorf("no certificates found in %s", rootCAPath)
+	}
+
+	return &mcsConfigFetcher{
+		url: (&url.URL{Scheme: "https", Host: net.JoinHostPort(u.Hostname(), strconv.Itoa(server.SecurePort))}).String(),
+		client: &http.Client{
+			Timeout: mcsFetchTimeout,
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{
+					MinVersion: tls.VersionTLS12,
+					RootCAs:    roots,
+				},
+			},
+		},
+	}, nil
+}
+
+// Gets the name of the pool which the given rendered MachineConfig belongs to
+// from its name, which is rendered-<pool>-<hash>.
+func getPoolNameFromRenderedConfigName(name string) (string, error) {
+	trimmed := strings.TrimPrefix(name, "rendered-")
+	idx := strings.LastIndex(trimmed, "-")
+	if trimmed == name || idx <= 0 {
+		return "", fmt.Errorf("%q is not a rendered MachineConfig name", name)
+	}
+
+	return trimmed[:idx], nil
+}
+
+// Fetches the given rendered MachineConfig from the MachineConfigServer. Only
+// the newest rendered MachineConfig of each pool is served, so this fails for
+// any other.
+func (f *mcsConfigFetcher) fetch(ctx context.Context, name string) (*mcfgv1.MachineConfig, error) {
+	pool, err := getPoolNameFromRenderedConfigName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	configURL := f.url + "/config/" + pool
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, configURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Accept", mcsFetchAcceptHeader)
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned status %d", configURL, resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("could not read %s: %w", configURL, err)
+	}
+
+	ignCfg, err := ctrlcommon.ParseAndConvertConfig(body)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse Ignition config from %s: %w", configURL, err)
+	}
+
+	data, err := ctrlcommon.GetIgnitionFileDataByPath(&ignCfg, constants.MachineConfigEncapsulatedPath)
+	if err != nil {
+		return nil, fmt.Errorf("could not get %s from %s: %w", constants.MachineConfigEncapsulatedPath, configURL, err)
+	}
+
+	if data == nil {
+		return nil, fmt.Errorf("%s did not serve %s", configURL, constants.MachineConfigEncapsulatedPath)
+	}
+
+	mc := &mcfgv1.MachineConfig{}
+	if err := json.Unmarshal(data, mc); err != nil {
+		return nil, fmt.Errorf("could not parse MachineConfig from %s: %w", configURL, err)
+	}
+
+	if mc.Name != name {
+		return nil, fmt.Errorf("%s serves MachineConfig %s, not %s", configURL, mc.Name, name)
+	}
+
+	return mc, nil
+}
+
+// EnableMachineConfigServerFallback allows the daemon to fetch rendered
+// MachineConfigs from the MachineConfigServer behind the given apiserver URL
+// when they cannot be gotten from the apiserver.
+func (dn *Daemon) EnableMachineConfigServerFallback(apiURL string) error {
+	fetcher, err := newMCSConfigFetcher(apiURL, rootCAFilePath)
+	if err != nil {
+		return err
+	}
+
+	dn.mcsFetcher = fetcher
+	klog.Infof("Falling back to the MachineConfigServer at %s for MachineConfigs the apiserver does not provide", fetcher.url)
+
+	return nil
+}
+
+// getMachineConfig gets the given rendered MachineConfig from the apiserver,
+// falling back to the MachineConfigServer when the apiserver is unreachable
+// or has not provided it yet, and logs which of them it came from.
+func (dn *Daemon) getMachineConfig(name string) (*mcfgv1.MachineConfig, error) {
+	mc, apiErr := dn.mcLister.Get(name)
+	if apiErr == nil {
+		klog.V(4).Infof("Got MachineConfig %s from the %s", name, configSourceAPIServer)
+		return mc, nil
+	}
+
+	if dn.mcsFetcher == nil {
+		return nil, apiErr
+	}
+
+	klog.Warningf("Could not get MachineConfig %s from the %s, falling back to the %s: %v", name, configSourceAPIServer, configSourceMCS, apiErr)
+
+	ctx, cancel := context.WithTimeout(context.Background(), mcsFetchTimeout)
+	defer cancel()
+
+	mc, mcsErr := dn.mcsFetcher.fetch(ctx, name)
+	if mcsErr != nil {
+		return nil, fmt.Errorf("could not get MachineConfig %s from the %s (%v) or the %s: %w", name, configSourceAPIServer, apiErr, configSourceMCS, mcsErr)
+	}
+
+	klog.Infof("Got MachineConfig %s from the %s", name, configSourceMCS)
+	mcdConfigSourceFallbacks.Inc()
+
+	return mc, nil
+}
@@ -0,0 +1,135 @@
+package daemon
+
+import (
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	ign3types "github.com/coreos/ignition/v2/config/v3_4/types"
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	mcfglistersv1 "github.com/openshift/client-go/machineconfiguration/listers/machineconfiguration/v1"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	"github.com/openshift/machine-config-operator/pkg/daemon/constants"
+	"github.com/openshift/machine-config-operator/test/helpers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestGetPoolNameFromRenderedConfigName(t *testing.T) {
+	testCases := []struct {
+		name        string
+		expected    string
+		errExpected bool
+	}{
+		{name: "rendered-worker-0123456789abcdef", expected: "worker"},
+		{name: "rendered-infra-gpu-0123456789abcdef", expected: "infra-gpu"},
+		{name: "00-worker", errExpected: true},
+		{name: "rendered-worker", errExpected: true},
+	}
+
+	for _, testCase := range testCases {
+		pool, err := getPoolNameFromRenderedConfigName(testCase.name)
+		if testCase.errExpected {
+			assert.Error(t, err, testCase.name)
+			continue
+		}
+
+		assert.NoError(t, err, testCase.name)
+		assert.Equal(t, testCase.expected, pool, testCase.name)
+	}
+}
+
+// Serves the given MachineConfig the way the MachineConfigServer does, within
+// the Ignition config for the pool.
+func newFakeMCS(t *testing.T, servedMC *mcfgv1.MachineConfig) *httptest.Server {
+	t.Helper()
+
+	serialized, err := json.Marshal(servedMC)
+	require.NoError(t, err)
+
+	ignCfg := ctrlcommon.NewIgnConfig()
+	ignCfg.Storage.Files = append(ignCfg.Storage.Files, ctrlcommon.NewIgnFile(constants.MachineConfigEncapsulatedPath, string(serialized)))
+	rawIgn, err := json.Marshal(ignCfg)
+	require.NoError(t, err)
+
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/config/worker" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		assert.Equal(t, mcsFetchAcceptHeader, r.Header.Get("Accept"))
+		w.Write(rawIgn)
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+// Creates a fetcher which trusts the fake MachineConfigServer.
+func newTestMCSConfigFetcher(t *testing.T, srv *httptest.Server) *mcsConfigFetcher {
+	t.Helper()
+
+	rootCAPath := filepath.Join(t.TempDir(), "ca.crt")
+	require.NoError(t, os.WriteFile(rootCAPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}), 0o644))
+
+	fetcher, err := newMCSConfigFetcher("https://api-int.cluster.example.com:6443", rootCAPath)
+	require.NoError(t, err)
+	assert.Equal(t, "https://api-int.cluster.example.com:22623", fetcher.url)
+
+	fetcher.url = srv.URL
+
+	return fetcher
+}
+
+func TestGetMachineConfigFallsBackToMCS(t *testing.T) {
+	cachedMC := helpers.NewMachineConfig("rendered-worker-1", nil, "", []ign3types.File{})
+	servedMC := helpers.NewMachineConfig("rendered-worker-2", nil, "quay.io/openshift/os@sha256:abc", []ign3types.File{})
+
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	require.NoError(t, indexer.Add(cachedMC))
+
+	dn := &Daemon{
+		mcLister: mcfglistersv1.NewMachineConfigLister(indexer),
+	}
+
+	// Without the fallback, only the apiserver is used.
+	_, err := dn.getMachineConfig(servedMC.Name)
+	assert.Error(t, err)
+
+	dn.mcsFetcher = newTestMCSConfigFetcher(t, newFakeMCS(t, servedMC))
+
+	// The apiserver is preferred.
+	mc, err := dn.getMachineConfig(cachedMC.Name)
+	require.NoError(t, err)
+	assert.Equal(t, cachedMC, mc)
+
+	// The MachineConfigServer provides what the apiserver does not.
+	mc, err = dn.getMachineConfig(servedMC.Name)
+	require.NoError(t, err)
+	assert.Equal(t, servedMC.Name, mc.Name)
+	assert.Equal(t, servedMC.Spec.OSImageURL, mc.Spec.OSImageURL)
+
+	// The MachineConfigServer only serves the newest rendered MachineConfig.
+	_, err = dn.getMachineConfig("rendered-worker-3")
+	assert.ErrorContains(t, err, "serves MachineConfig rendered-worker-2, not rendered-worker-3")
+
+	// And only serves pools which exist.
+	_, err = dn.getMachineConfig("rendered-infra-1")
+	assert.ErrorContains(t, err, "returned status 404")
+}
+
+func TestNewMCSConfigFetcherRequiresRootCA(t *testing.T) {
+	_, err := newMCSConfigFetcher("https://api-int.cluster.example.com:6443", filepath.Join(t.TempDir(), "ca.crt"))
+	assert.Error(t, err)
+
+	rootCAPath := filepath.Join(t.TempDir(), "ca.crt")
+	require.NoError(t, os.WriteFile(rootCAPath, []byte("not a certificate"), 0o644))
+	_, err = newMCSConfigFetcher("https://api-int.cluster.example.com:6443", rootCAPath)
+	assert.Error(t, err)
+}
@@ -12,11 +12,20 @@ const (
 	CurrentImageAnnotationKey = "machineconfiguration.openshift.io/currentImage"
 	// DesiredImageAnnotationKey is used to specify the desired OS image pullspec for a machine
 	DesiredImageAnnotationKey = "machineconfiguration.openshift.io/desiredImage"
+	// DesiredImageSigningPublicKeyAnnotationKey is used to specify the public key the desired OS image signature must be verified with
+	DesiredImageSigningPublicKeyAnnotationKey = "machineconfiguration.openshift.io/desiredImageSigningPublicKey"
 
 	// CurrentMachineConfigAnnotationKey is used to fetch current MachineConfig for a machine
 	CurrentMachineConfigAnnotationKey = "machineconfiguration.openshift.io/currentConfig"
+	// InitialMachineConfigAnnotationKey records the MachineConfig the Machine Config Server served to a machine when it
+	// was provisioned, so that the node controller can detect machines which were provisioned with a stale config.
+	InitialMachineConfigAnnotationKey = "machineconfiguration.openshift.io/initialConfig"
 	// DesiredMachineConfigAnnotationKey is used to specify the desired MachineConfig for a machine
 	DesiredMachineConfigAnnotationKey = "machineconfiguration.openshift.io/desiredConfig"
+	// CurrentConfigGenerationAnnotationKey is used to fetch the pool config generation of the current MachineConfig for a machine
+	CurrentConfigGenerationAnnotationKey = "machineconfiguration.openshift.io/currentConfigGeneration"
+	// DesiredConfigGenerationAnnotationKey is used to specify the pool config generation of the desired MachineConfig for a machine
+	DesiredConfigGenerationAnnotationKey = "machineconfiguration.openshift.io/desiredConfigGeneration"
 	// MachineConfigDaemonStateAnnotationKey is used to fetch the state of the daemon on the machine.
 	MachineConfigDaemonStateAnnotationKey = "machineconfiguration.openshift.io/state"
 	// DesiredDrainerAnnotationKey is set by the MCD to indicate drain/uncordon requests
@@ -27,6 +36,109 @@ const (
 	DrainerStateDrain = "drain"
 	// DrainerStateUncordon is used for drainer annotation as a value to indicate needing an uncordon
 	DrainerStateUncordon = "uncordon"
+	// ResyncRequestAnnotationKey may be set on a node by an admin (e.g., to a timestamp) to have the MCD immediately
+	// re-apply the desired config, for example after repairing the node by hand
+	ResyncRequestAnnotationKey = "machineconfiguration.openshift.io/resyncRequest"
+	// LastAppliedResyncRequestAnnotationKey is set by the MCD to the last resync request it handled
+	LastAppliedResyncRequestAnnotationKey = "machineconfiguration.openshift.io/lastAppliedResyncRequest"
+	// RollbackRequestAnnotationKey may be set on a node by an admin (e.g., to a timestamp) to have the MCD roll the node
+	// back to the config and OS image it was on before its last update
+	RollbackRequestAnnotationKey = "machineconfiguration.openshift.io/rollback"
+	// LastAppliedRollbackRequestAnnotationKey is set by the MCD to the last rollback request it handled
+	LastAppliedRollbackRequestAnnotationKey = "machineconfiguration.openshift.io/lastAppliedRollback"
+	// DryRunRequestAnnotationKey may be set on a node by an admin (e.g., to a timestamp) to have the MCD report what updating the node
+	// to its desired config would do, without applying anything
+	DryRunRequestAnnotationKey = "machineconfiguration.openshift.io/dryRunRequest"
+	// LastAppliedDryRunRequestAnnotationKey is set by the MCD to the last dry run request it handled
+	LastAppliedDryRunRequestAnnotationKey = "machineconfiguration.openshift.io/lastAppliedDryRunRequest"
+	// RebootRequestAnnotationKey is set on a node by the node controller to the time (RFC 3339) at which it requested
+	// the MCD to drain and reboot the node, e.g. because the node is due for a periodic reboot
+	RebootRequestAnnotationKey = "machineconfiguration.openshift.io/rebootRequest"
+	// LastAppliedRebootRequestAnnotationKey is set by the MCD to the last reboot request it handled
+	LastAppliedRebootRequestAnnotationKey = "machineconfiguration.openshift.io/lastAppliedRebootRequest"
+	// FinalizeRequestAnnotationKey may be set on a node by an admin (e.g., to a timestamp) to have the MCD finalize the update staged on
+	// the node right away. The node controller sets it to the time (RFC 3339) at which it requested finalization within a maintenance window
+	FinalizeRequestAnnotationKey = "machineconfiguration.openshift.io/finalizeRequest"
+	// LastAppliedFinalizeRequestAnnotationKey is set by the MCD to the last finalize request it handled
+	LastAppliedFinalizeRequestAnnotationKey = "machineconfiguration.openshift.io/lastAppliedFinalizeRequest"
+	// HoldAnnotationKey may be set to "true" on a node by an admin to hold it at its current config, e.g. while it runs a critical workload.
+	// The node controller does not select a held node for updates and the MCD does not start updating it
+	HoldAnnotationKey = "machineconfiguration.openshift.io/hold"
+	// NodeStatusAnnotationKey is set by the MCD to a JSON object of the informational status it reports on the node, keyed by
+	// the names of the annotations it supersedes (e.g. "lastBootTime"). Use ctrlcommon.GetNodeStatus to read these values so
+	// that nodes whose MCD still sets the separate annotations are handled
+	NodeStatusAnnotationKey = "machineconfiguration.openshift.io/nodeStatus"
+	// LastBootTimeAnnotationKey holds the time (RFC 3339) at which the node last booted. Superseded by NodeStatusAnnotationKey
+	LastBootTimeAnnotationKey = "machineconfiguration.openshift.io/lastBootTime"
+	// RebootRequiredAnnotationKey is set to "true" by the MCD of a package-based host, e.g. a traditional RHEL worker, when the packages
+	// updated on it require a reboot, as found by needs-restarting. Only reported in NodeStatusAnnotationKey
+	RebootRequiredAnnotationKey = "machineconfiguration.openshift.io/rebootRequired"
+	// RpmOstreeRecoveryAnnotationKey holds a JSON description of the MCD's last attempt to recover from a hung rpm-ostree
+	// transaction. Superseded by NodeStatusAnnotationKey
+	RpmOstreeRecoveryAnnotationKey = "machineconfiguration.openshift.io/rpmOstreeRecovery"
+	// ComponentVersionsAnnotationKey holds a JSON object of the versions of the kubelet, CRI-O, rpm-ostree and OS installed
+	// on the node. Superseded by NodeStatusAnnotationKey
+	ComponentVersionsAnnotationKey = "machineconfiguration.openshift.io/componentVersions"
+	// FreeDiskAnnotationKey holds a JSON object of the bytes available on the filesystems of /sysroot and /var. Superseded
+	// by NodeStatusAnnotationKey
+	FreeDiskAnnotationKey = "machineconfiguration.openshift.io/freeDisk"
+	// PostConfigChangeActionsAnnotationKey holds a JSON object of the rendered config the MCD last updated the node to
+	// and the post config change actions (e.g. "reboot" or "restart chronyd") it chose for it. Only reported in
+	// NodeStatusAnnotationKey
+	PostConfigChangeActionsAnnotationKey = "machineconfiguration.openshift.io/postConfigChangeActions"
+	// OSUpdateProgressAnnotationKey holds a JSON object of the progress of the OS image pull and deployment the MCD is running,
+	// e.g. the stage and the layers and bytes fetched so far. Only reported in NodeStatusAnnotationKey
+	OSUpdateProgressAnnotationKey = "machineconfiguration.openshift.io/osUpdateProgress"
+	// KernelArgumentsAnnotationKey holds a JSON array of the kernel arguments the node booted with, which include the ones merged from
+	// the MachineConfigs of its pool. Only reported in NodeStatusAnnotationKey
+	KernelArgumentsAnnotationKey = "machineconfiguration.openshift.io/kernelArguments"
+	// PreviousConfigAnnotationKey holds a JSON object of the config and OS image the node was on before its last update, which a
+	// rollback request returns it to. Only reported in NodeStatusAnnotationKey
+	PreviousConfigAnnotationKey = "machineconfiguration.openshift.io/previousConfig"
+	// LastRollbackAnnotationKey holds a JSON object of the last rollback the MCD performed in response to a rollback request. Only
+	// reported in NodeStatusAnnotationKey
+	LastRollbackAnnotationKey = "machineconfiguration.openshift.io/lastRollback"
+	// DryRunAnnotationKey holds a JSON object of what updating the node to its desired config would do, as found by the MCD for the last
+	// dry run request. Only reported in NodeStatusAnnotationKey
+	DryRunAnnotationKey = "machineconfiguration.openshift.io/dryRun"
+	// UnmanagedPathsAnnotationKey is set by the node controller to the comma-separated paths which the pool of the node excludes from MCO management
+	UnmanagedPathsAnnotationKey = "machineconfiguration.openshift.io/unmanagedPaths"
+	// MaintenanceWindowsAnnotationKey is set by the node controller to the maintenance windows of the pool of the node, outside of which updates which reboot the node wait
+	MaintenanceWindowsAnnotationKey = "machineconfiguration.openshift.io/maintenanceWindows"
+	// PendingUpdateAnnotationKey holds a JSON object of the update which waits for the next maintenance window of the node, if any. Only reported in
+	// NodeStatusAnnotationKey
+	PendingUpdateAnnotationKey = "machineconfiguration.openshift.io/pendingUpdate"
+	// HeldUpdateAnnotationKey holds a JSON object of the update the MCD does not start because an admin holds the node with
+	// HoldAnnotationKey, if any. Only reported in NodeStatusAnnotationKey
+	HeldUpdateAnnotationKey = "machineconfiguration.openshift.io/heldUpdate"
+	// PreflightChecksAnnotationKey is set by the node controller to the checks which must pass before the MCD starts updating the node
+	PreflightChecksAnnotationKey = "machineconfiguration.openshift.io/preflightChecks"
+	// PreflightCheckFailureAnnotationKey holds a JSON object of the config the MCD did not update the node to because the preflight checks
+	// failed, and why. Only reported in NodeStatusAnnotationKey
+	PreflightCheckFailureAnnotationKey = "machineconfiguration.openshift.io/preflightCheckFailure"
+	// PostUpdateVerificationAnnotationKey is set by the node controller to the probes which must pass after the node rebooted into a new config
+	PostUpdateVerificationAnnotationKey = "machineconfiguration.openshift.io/postUpdateVerification"
+	// PostUpdateVerificationFailureAnnotationKey holds a JSON object of the config the MCD rolled the node back from because the post-update
+	// verification failed, and the output of the failed probes. Only reported in NodeStatusAnnotationKey
+	PostUpdateVerificationFailureAnnotationKey = "machineconfiguration.openshift.io/postUpdateVerificationFailure"
+	// DisruptionPolicyAnnotationKey is set by the node controller to the disruption policy of the pool of the node, which declares how
+	// changes to some files are applied without a reboot
+	DisruptionPolicyAnnotationKey = "machineconfiguration.openshift.io/disruptionPolicy"
+	// SwapAnnotationKey is set by the node controller to the swap the MCD sets up on the node
+	SwapAnnotationKey = "machineconfiguration.openshift.io/swap"
+	// SwapStatusAnnotationKey holds a JSON object of the swap the MCD last set up on the node, or why it could not. Only reported in
+	// NodeStatusAnnotationKey
+	SwapStatusAnnotationKey = "machineconfiguration.openshift.io/swapStatus"
+	// StagedUpdatesAnnotationKey is set by the node controller to how the pool of the node stages updates which reboot the node
+	StagedUpdatesAnnotationKey = "machineconfiguration.openshift.io/stagedUpdates"
+	// StagedUpdateAnnotationKey holds a JSON object of the update the MCD staged on the node, which waits to be finalized. Only reported in
+	// NodeStatusAnnotationKey
+	StagedUpdateAnnotationKey = "machineconfiguration.openshift.io/stagedUpdate"
+	// ConfigDriftRemediationAnnotationKey is set by the node controller to "Remediate" when the pool of the node has the MCD remediate config drift
+	ConfigDriftRemediationAnnotationKey = "machineconfiguration.openshift.io/configDriftRemediation"
+	// RebootStrategyAnnotationKey is set by the node controller to "Kexec" or "SoftReboot" when the pool of the node skips the firmware
+	// on userspace-only updates
+	RebootStrategyAnnotationKey = "machineconfiguration.openshift.io/rebootStrategy"
 	// ClusterControlPlaneTopologyAnnotationKey is set by the node controller by reading value from
 	// controllerConfig. MCD uses the annotation value to decide drain action on the node.
 	ClusterControlPlaneTopologyAnnotationKey = "machineconfiguration.openshift.io/controlPlaneTopology"
@@ -89,6 +201,13 @@ const (
 	// changes to registries.conf will cause a crio reload and require extra logic about whether to drain
 	ContainerRegistryConfPath = "/etc/containers/registries.conf"
 
+	// changes to storage.conf will cause a crio restart if they do not affect existing images and containers
+	ContainerStorageConfPath = "/etc/containers/storage.conf"
+
+	// the NetworkManager DNS configuration which is rendered from the DNS configuration of a pool; changes to it
+	// only require NetworkManager to be reloaded
+	NetworkManagerDNSConfPath = "/etc/NetworkManager/conf.d/99-mco-dns.conf"
+
 	// SSH Keys for user "core" will only be written at /home/core/.ssh
 	CoreUserSSHPath = "/home/" + CoreUserName + "/.ssh"
 
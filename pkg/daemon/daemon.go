@@ -60,6 +60,10 @@ type Daemon struct {
 	// NodeUpdaterClient wraps rpm-ostree and will eventually be removed with a direct rpmostreeclient value
 	NodeUpdaterClient *RpmOstreeClient
 
+	// bootcClient stages new OS images instead of rpm-ostree when the host is
+	// managed by bootc; nil otherwise.
+	bootcClient *BootcClient
+
 	// bootID is a unique value per boot (generated by the kernel)
 	bootID string
 
@@ -69,6 +73,10 @@ type Daemon struct {
 	// bootedOScommit is the commit hash of the currently booted operating system
 	bootedOSCommit string
 
+	// bootSetupRoot is the directory below which the boot setup of the node is
+	// detected; "/" if empty.
+	bootSetupRoot string
+
 	// previousFinalizationFailure caches a failure of ostree-finalize-staged.service
 	// we may have seen from the previous boot.
 	previousFinalizationFailure string
@@ -83,6 +91,10 @@ type Daemon struct {
 	mcLister       mcfglistersv1.MachineConfigLister
 	mcListerSynced cache.InformerSynced
 
+	// Fetches MachineConfigs from the MachineConfigServer when the apiserver
+	// does not provide them. Nil when the fallback is disabled.
+	mcsFetcher *mcsConfigFetcher
+
 	ccLister       mcfglistersv1.ControllerConfigLister
 	ccListerSynced cache.InformerSynced
 
@@ -123,6 +135,9 @@ type Daemon struct {
 	booting bool
 	// rebootQueued is true when the node is waiting for graceful shutdown
 	rebootQueued bool
+	// lastRebootRequiredCheck is when the MCD last checked whether a
+	// package-based host needs a reboot
+	lastRebootRequiredCheck time.Time
 
 	currentConfigPath string
 	currentImagePath  string
@@ -186,7 +201,7 @@ const (
 	imageCAFilePath = "/etc/docker/certs.d"
 
 	// used for certificate syncing
-	caBundleFilePath      = "/etc/kubernetes/kubelet-ca.crt"
+	caBundleFilePath      = ctrlcommon.KubeletCABundlePath
 	cloudCABundleFilePath = "/etc/kubernetes/static-pod-resources/configmaps/cloud-config/ca-bundle.pem"
 	userCABundleFilePath  = "/etc/pki/ca-trust/source/anchors/openshift-config-user-ca-bundle.crt"
 
@@ -213,9 +228,10 @@ var (
 // pods when `GracefulNodeShutdown` feature gate is enabled.
 // kubelet uses systemd inhibitor locks to delay node shutdown to terminate pods.
 // https://kubernetes.io/docs/concepts/architecture/nodes/#graceful-node-shutdown
-func rebootCommand(rationale string) *exec.Cmd {
+// The strategy is one of the ctrlcommon.RebootStrategy strategies.
+func rebootCommand(rationale, strategy string) *exec.Cmd {
 	return exec.Command("systemd-run", "--unit", "machine-config-daemon-reboot",
-		"--description", fmt.Sprintf("machine-config-daemon: %s", rationale), "/bin/sh", "-c", "systemctl reboot")
+		"--description", fmt.Sprintf("machine-config-daemon: %s", rationale), "/bin/sh", "-c", rebootStrategyCommand(strategy))
 }
 
 // getBootID loads the unique "boot id" which is generated by the Linux kernel.
@@ -254,6 +270,7 @@ func New(
 	}
 
 	var nodeUpdaterClient *RpmOstreeClient
+	var bootcClient *BootcClient
 
 	// Only pull the osImageURL from OSTree when we are on RHCOS or FCOS
 	if hostos.IsCoreOSVariant() {
@@ -268,6 +285,11 @@ func New(
 			return nil, fmt.Errorf("error reading osImageURL from rpm-ostree: %w", err)
 		}
 		klog.Infof("Booted osImageURL: %s (%s) %s", osImageURL, osVersion, osCommit)
+
+		if client, ok := NewBootcClient(); ok {
+			bootcClient = client
+			klog.Infof("Host is managed by bootc, updating the OS with bootc")
+		}
 	}
 
 	bootID := ""
@@ -286,6 +308,7 @@ func New(
 		rebootQueued:       false,
 		os:                 hostos,
 		NodeUpdaterClient:  nodeUpdaterClient,
+		bootcClient:        bootcClient,
 		bootedOSImageURL:   osImageURL,
 		bootedOSCommit:     osCommit,
 		bootID:             bootID,
@@ -693,6 +716,16 @@ func (dn *Daemon) syncNode(key string) error {
 		if err := dn.checkStateOnFirstRun(); err != nil {
 			return err
 		}
+		// The kubelet, CRI-O and OS versions only change across reboots.
+		if err := dn.reportComponentVersions(); err != nil {
+			klog.Warningf("Could not report component versions: %v", err)
+		}
+		if err := dn.reportLastBootTime(); err != nil {
+			klog.Warningf("Could not report last boot time: %v", err)
+		}
+		if err := dn.reportKernelArguments(); err != nil {
+			klog.Warningf("Could not report kernel arguments: %v", err)
+		}
 		// finished syncing node for the first time;
 		// currently we return immediately here, although
 		// I think we should change this to continue.
@@ -713,6 +746,31 @@ func (dn *Daemon) syncNode(key string) error {
 		return nil
 	}
 
+	// An admin asked us to re-apply the desired config.
+	if request, ok := getPendingResyncRequest(dn.node); ok {
+		return dn.handleResyncRequest(request)
+	}
+
+	// The node controller asked us to reboot, e.g. for a periodic reboot.
+	if request, ok := getPendingRebootRequest(dn.node); ok {
+		return dn.handleRebootRequest(request)
+	}
+
+	// An admin asked us to roll back the last update.
+	if request, ok := getPendingRollbackRequest(dn.node); ok {
+		return dn.handleRollbackRequest(request)
+	}
+
+	// An admin asked what updating the node would do.
+	if request, ok := getPendingDryRunRequest(dn.node); ok {
+		return dn.handleDryRunRequest(request)
+	}
+
+	// An admin or the node controller asked us to finalize the staged update.
+	if request, ok := getPendingFinalizeRequest(dn.node); ok {
+		return dn.handleFinalizeRequest(request)
+	}
+
 	// Pass to the shared update prep method
 	ufc, err := dn.prepUpdateFromCluster()
 	if err != nil {
@@ -720,20 +778,115 @@ func (dn *Daemon) syncNode(key string) error {
 	}
 
 	if ufc != nil {
+		// A node rolled back on request stays where it is.
+		if dn.isRolledBack(ufc.desiredConfig.GetName(), ufc.desiredImage) {
+			return dn.completeRollback()
+		}
+
+		// A staged update waits to be finalized.
+		if wait, err := dn.checkStagedUpdate(ufc); err != nil || wait {
+			return err
+		}
+
+		// A node held by an admin does not start updating.
+		if held, err := dn.checkHold(ufc); err != nil || held {
+			return err
+		}
+
+		// Updates which reboot the node are staged if its pool asks for it,
+		// and otherwise wait for its next maintenance window.
+		staging := dn.getUpdateStaging(ufc)
+		if staging == nil {
+			if wait, err := dn.waitForMaintenanceWindow(ufc); err != nil || wait {
+				return err
+			}
+		}
+
+		if skip, err := dn.runPreflightChecks(ufc); err != nil || skip {
+			return err
+		}
+
 		// Only check for config drift if we need to update.
 		if err := dn.runPreflightConfigDriftCheck(); err != nil {
 			return err
 		}
 
+		if staging != nil {
+			return dn.stageUpdate(ufc, staging)
+		}
+
 		if err := dn.triggerUpdate(ufc.currentConfig, ufc.desiredConfig, ufc.currentImage, ufc.desiredImage); err != nil {
 			return err
 		}
 
 	}
+
+	// The pool of the node may change its swap without changing its config.
+	if ufc == nil {
+		if err := dn.syncSwap(); err != nil {
+			return fmt.Errorf("syncing swap: %w", err)
+		}
+		// Packages may be updated on package-based hosts at any time.
+		if !dn.os.IsCoreOSVariant() {
+			packageDaemon := PackageDaemon{dn}
+			if err := packageDaemon.reportRebootRequired(); err != nil {
+				klog.Warningf("Could not report whether the node requires a reboot: %v", err)
+			}
+		}
+	}
 	klog.V(2).Infof("Node %s is already synced", node.Name)
 	return nil
 }
 
+// getPendingResyncRequest returns the resync request set on the node if it
+// has not been handled yet.
+func getPendingResyncRequest(node *corev1.Node) (string, bool) {
+	request := node.Annotations[constants.ResyncRequestAnnotationKey]
+	if request == "" || request == node.Annotations[constants.LastAppliedResyncRequestAnnotationKey] {
+		return "", false
+	}
+
+	return request, true
+}
+
+// handleResyncRequest re-applies the desired config in response to an admin
+// setting the resync request annotation on the node, e.g. after repairing the
+// node by hand. This is the equivalent of creating the forcefile and
+// restarting the MCD: the on-disk state is not validated, since the point is
+// to overwrite whatever is on disk.
+func (dn *Daemon) handleResyncRequest(request string) error {
+	logSystem("Resync requested via %s=%s", constants.ResyncRequestAnnotationKey, request)
+
+	state, err := dn.getStateAndConfigs()
+	if err != nil {
+		return err
+	}
+
+	// The on-disk config is the last config we successfully applied.
+	odc, err := dn.getCurrentConfigOnDisk()
+	if err != nil {
+		return fmt.Errorf("could not get on-disk config: %w", err)
+	}
+
+	// Mark the request as handled before updating so that it is not handled
+	// again if the update reboots the node.
+	if _, err := dn.nodeWriter.SetAnnotations(map[string]string{constants.LastAppliedResyncRequestAnnotationKey: request}); err != nil {
+		return fmt.Errorf("could not acknowledge resync request: %w", err)
+	}
+
+	// A resync retries an update which was rolled back.
+	if err := dn.reportPostUpdateVerificationFailure(nil); err != nil {
+		return err
+	}
+	if err := dn.reportLastRollback(nil); err != nil {
+		return err
+	}
+
+	dn.nodeWriter.Eventf(corev1.EventTypeNormal, "ResyncRequested", fmt.Sprintf("Re-applying config %s in response to resync request %s", state.desiredConfig.GetName(), request))
+
+	return dn.triggerUpdate(odc.currentConfig, state.desiredConfig, odc.currentImage, state.desiredImage)
+}
+
 // Validates that the on-disk state matches the currently applied machineconfig
 // before an update occurs.
 func (dn *Daemon) runPreflightConfigDriftCheck() error {
@@ -754,9 +907,12 @@ func (dn *Daemon) runPreflightConfigDriftCheck() error {
 	if err := dn.validateOnDiskStateOrImage(currentOnDisk.currentConfig, currentOnDisk.currentImage); err != nil {
 		dn.nodeWriter.Eventf(corev1.EventTypeWarning, "PreflightConfigDriftCheckFailed", err.Error())
 		klog.Errorf("Preflight config drift check failed: %v", err)
+		dn.recordConfigDriftOf(currentOnDisk.currentConfig)
 		return &configDriftErr{err}
 	}
 
+	clearConfigDrift()
+
 	klog.Infof("Preflight config drift check successful (took %s)", time.Since(start))
 
 	return nil
@@ -934,13 +1090,20 @@ func (dn *Daemon) syncNodeHypershift(key string) error {
 		return fmt.Errorf("parsing new Ignition config failed: %w", err)
 	}
 	diffFileSet := ctrlcommon.CalculateConfigFileDiffs(&oldIgnConfig, &newIgnConfig)
-	actions, err := calculatePostConfigChangeAction(mcDiff, diffFileSet)
+	// The node controller does not hand hypershift nodes a disruption policy.
+	actions, err := calculatePostConfigChangeAction(mcDiff, diffFileSet, nil)
+	if err != nil {
+		return err
+	}
+	actions, err = ctrlcommon.CheckContainerStorageConfChanges(actions, diffFileSet, oldIgnConfig, newIgnConfig)
 	if err != nil {
 		return err
 	}
 
+	dn.reportPostConfigChangeActions(desiredConfig.Name, actions)
+
 	// Check and perform node drain if required
-	drain, err := isDrainRequired(actions, diffFileSet, oldIgnConfig, newIgnConfig)
+	drain, err := ctrlcommon.IsDrainRequired(actions, diffFileSet, oldIgnConfig, newIgnConfig)
 	if err != nil {
 		return err
 	}
@@ -994,6 +1157,24 @@ func (dn *Daemon) syncNodeHypershift(key string) error {
 		klog.Infof("%s config reloaded successfully! Desired config %s has been applied, skipping reboot", serviceName, desiredConfig.Name)
 	}
 
+	if ctrlcommon.InSlice(postConfigChangeActionRestartCrio, actions) {
+		serviceName := "crio"
+		if err := restartService(serviceName); err != nil {
+			return fmt.Errorf("could not apply update: restarting %s failed. Error: %w", serviceName, err)
+		}
+		klog.Infof("%s restarted successfully! Desired config %s has been applied, skipping reboot", serviceName, desiredConfig.Name)
+	}
+
+	for _, serviceAction := range postConfigChangeServiceActions {
+		if !ctrlcommon.InSlice(serviceAction.action, actions) {
+			continue
+		}
+		if err := serviceAction.run(); err != nil {
+			return fmt.Errorf("could not apply update: %s failed. Error: %w", serviceAction.action, err)
+		}
+		klog.Infof("%s %s successfully! Desired config %s has been applied, skipping reboot", serviceAction.service, serviceAction.describe(), desiredConfig.Name)
+	}
+
 	// We are here, which means reboot was not needed to apply the configuration.
 	// Complete the update and return. Future syncs should see the update has completed.
 	annos := map[string]string{
@@ -1053,7 +1234,7 @@ func (dn *Daemon) RunFirstbootCompleteMachineconfig() error {
 		if err := dn.InplaceUpdateViaNewContainer(mc.Spec.OSImageURL); err != nil {
 			return err
 		}
-		rebootCmd := rebootCommand("extra reboot for in-place update")
+		rebootCmd := rebootCommand("extra reboot for in-place update", ctrlcommon.RebootStrategyReboot)
 		if err := rebootCmd.Run(); err != nil {
 			logSystem("failed to run reboot: %v", err)
 			return err
@@ -1168,6 +1349,12 @@ func (dn *Daemon) Run(stopCh <-chan struct{}, exitCh <-chan error) error {
 	go wait.Until(dn.worker, time.Second, stopCh)
 	go wait.Until(dn.controllerConfigWorker, time.Second, stopCh)
 
+	if dn.os.IsCoreOSVariant() && dn.NodeUpdaterClient != nil {
+		go newRpmOstreeWatchdog(dn).run(stopCh)
+	}
+
+	go dn.runFreeDiskReporter(stopCh)
+
 	for {
 		select {
 		case <-stopCh:
@@ -1182,9 +1369,18 @@ func (dn *Daemon) Run(stopCh <-chan struct{}, exitCh <-chan error) error {
 }
 
 // Called whenever the on-disk config has drifted from the current machineconfig.
-func (dn *Daemon) onConfigDrift(err error) {
+// When the pool has the MCD remediate config drift, the node is only degraded
+// if the drift could not be remediated.
+func (dn *Daemon) onConfigDrift(mc *mcfgv1.MachineConfig, err error) {
 	dn.nodeWriter.Eventf(corev1.EventTypeWarning, "ConfigDriftDetected", err.Error())
 	klog.Error(err)
+	dn.recordConfigDriftOf(mc)
+	if dn.isConfigDriftRemediationEnabled() {
+		if err = dn.remediateConfigDrift(mc); err == nil {
+			clearConfigDrift()
+			return
+		}
+	}
 	if err := dn.updateErrorState(err); err != nil {
 		klog.Errorf("Could not update annotation: %v", err)
 	}
@@ -1207,10 +1403,13 @@ func (dn *Daemon) startConfigDriftMonitor() {
 	}
 
 	opts := ConfigDriftMonitorOpts{
-		OnDrift:       dn.onConfigDrift,
-		SystemdPath:   pathSystemd,
-		ErrChan:       dn.exitCh,
-		MachineConfig: odc.currentConfig,
+		OnDrift: func(err error) {
+			dn.onConfigDrift(odc.currentConfig, err)
+		},
+		SystemdPath:    pathSystemd,
+		ErrChan:        dn.exitCh,
+		MachineConfig:  odc.currentConfig,
+		UnmanagedPaths: dn.getUnmanagedPaths,
 	}
 
 	if err := dn.configDriftMonitor.Start(opts); err != nil {
@@ -1218,6 +1417,9 @@ func (dn *Daemon) startConfigDriftMonitor() {
 		return
 	}
 
+	// The monitor starts on a config which was just applied or validated.
+	clearConfigDrift()
+
 	dn.nodeWriter.Eventf(corev1.EventTypeNormal, "ConfigDriftMonitorStarted",
 		"Config Drift Monitor started, watching against %s", odc.currentConfig.Name)
 
@@ -1385,7 +1587,7 @@ func (dn *Daemon) getStateAndConfigs() (*stateAndConfigs, error) {
 	if err != nil {
 		return nil, err
 	}
-	currentConfig, err := dn.mcLister.Get(currentConfigName)
+	currentConfig, err := dn.getMachineConfig(currentConfigName)
 	if err != nil {
 		return nil, err
 	}
@@ -1431,7 +1633,7 @@ func (dn *Daemon) getStateAndConfigs() (*stateAndConfigs, error) {
 		desiredConfig = currentConfig
 		klog.Infof("Current+desired config: %s", currentConfigName)
 	} else {
-		desiredConfig, err = dn.mcLister.Get(desiredConfigName)
+		desiredConfig, err = dn.getMachineConfig(desiredConfigName)
 		if err != nil {
 			return nil, err
 		}
@@ -1736,7 +1938,7 @@ func (dn *Daemon) updateSSHKeyLocation(cfg *mcfgv1.MachineConfig) error {
 		return fmt.Errorf("ignition failure when updating SSH key location: %w", err)
 	}
 
-	if err := dn.updateSSHKeys(ignConfig.Passwd.Users, ignConfig.Passwd.Users); err != nil {
+	if err := dn.updateSSHKeys(ignConfig, ignConfig); err != nil {
 		return fmt.Errorf("could not write SSH keys to new location: %w", err)
 	}
 
@@ -1781,10 +1983,18 @@ func (dn *Daemon) checkStateOnFirstRun() error {
 		return err
 	}
 
-	if err := dn.removeRollback(); err != nil {
-		return fmt.Errorf("failed to remove rollback: %w", err)
+	// The rollback deployment is kept until the update the node rebooted into
+	// has been verified.
+	verification := dn.getPostUpdateVerification(state)
+	if verification == nil {
+		if err := dn.removeRollback(); err != nil {
+			return fmt.Errorf("failed to remove rollback: %w", err)
+		}
 	}
 
+	// The config and image the node updated from, if it rebooted into an update.
+	annotatedConfig, annotatedImage := state.currentConfig, state.currentImage
+
 	// Bootstrapping state is when we have the node annotations file
 	if state.bootstrapping {
 		targetOSImageURL := state.currentConfig.Spec.OSImageURL
@@ -1808,6 +2018,14 @@ func (dn *Daemon) checkStateOnFirstRun() error {
 		}
 	}
 
+	// An update staged on the node leaves it between the config it was
+	// staged from and the staged config until it is finalized.
+	if !state.bootstrapping {
+		if staged, err := dn.checkStagedUpdateOnFirstRun(); err != nil || staged {
+			return err
+		}
+	}
+
 	var odc *onDiskConfig
 	if !state.bootstrapping {
 		var err error
@@ -1861,6 +2079,15 @@ func (dn *Daemon) checkStateOnFirstRun() error {
 
 	logSystem("Validated on-disk state")
 
+	if verification != nil {
+		if err := dn.verifyPostUpdate(verification, annotatedConfig, state.currentConfig, annotatedImage, state.currentImage); err != nil {
+			return err
+		}
+		if err := dn.removeRollback(); err != nil {
+			return fmt.Errorf("failed to remove rollback: %w", err)
+		}
+	}
+
 	// We've validated state. Now, ensure that node is in desired state
 	var inDesiredConfig bool
 	if inDesiredConfig, err = dn.updateConfigAndState(state); err != nil {
@@ -1924,6 +2151,11 @@ func (dn *Daemon) updateConfigAndState(state *stateAndConfigs) (bool, error) {
 			return inDesiredConfig, err
 		}
 
+		// The node annotations still name the config the node updated from.
+		if err := dn.reportPreviousConfig(state); err != nil {
+			klog.Warningf("Could not report previous config: %v", err)
+		}
+
 		// We update the node annotation, and pop an event saying we're done.
 		if dn.nodeWriter != nil {
 			dn.nodeWriter.Eventf(corev1.EventTypeNormal, "NodeDone", fmt.Sprintf("Setting node %s, currentConfig %s to Done", dn.node.Name, state.currentConfig.GetName()))
@@ -1943,6 +2175,11 @@ func (dn *Daemon) updateConfigAndState(state *stateAndConfigs) (bool, error) {
 			}
 		}
 
+		// The OS update which got the node here is done.
+		if err := dn.reportOSUpdateProgress(nil); err != nil {
+			klog.Warningf("Could not clear OS update progress: %v", err)
+		}
+
 		klog.Infof("In desired state %s", state.getCurrentName())
 		UpdateStateMetric(mcdUpdateState, state.getCurrentName(), "")
 	}
@@ -2030,7 +2267,7 @@ func (dn *Daemon) prepUpdateFromCluster() (*updateFromCluster, error) {
 		return nil, err
 	}
 
-	desiredConfig, err := dn.mcLister.Get(desiredConfigName)
+	desiredConfig, err := dn.getMachineConfig(desiredConfigName)
 	if err != nil {
 		return nil, err
 	}
@@ -2040,7 +2277,7 @@ func (dn *Daemon) prepUpdateFromCluster() (*updateFromCluster, error) {
 	if err != nil {
 		return nil, err
 	}
-	currentConfig, err := dn.mcLister.Get(currentConfigName)
+	currentConfig, err := dn.getMachineConfig(currentConfigName)
 	if err != nil {
 		return nil, err
 	}
@@ -2135,27 +2372,34 @@ func (dn *Daemon) completeUpdate(desiredConfigName string) error {
 }
 
 func (dn *Daemon) triggerUpdate(currentConfig, desiredConfig *mcfgv1.MachineConfig, currentImage, desiredImage string) error {
+	if err := dn.checkRolledBackUpdate(desiredConfig.GetName(), desiredImage); err != nil {
+		return err
+	}
+
+	// A node rolled back on request stays where it is.
+	if dn.isRolledBack(desiredConfig.GetName(), desiredImage) {
+		return dn.completeRollback()
+	}
+
 	// If both of the image annotations are empty, this is a regular MachineConfig update.
 	if desiredImage == "" && currentImage == "" {
 		return dn.triggerUpdateWithMachineConfig(currentConfig, desiredConfig, true)
 	}
 
-	// If the desired image annotation is empty, but the current image is not
-	// empty, this should be a regular MachineConfig update.
-	//
-	// However, the node will not roll back from a layered config to a
-	// non-layered config without admin intervention; so we should emit an error
-	// for now.
-	if desiredImage == "" && currentImage != "" {
-		return fmt.Errorf("rolling back from a layered to non-layered configuration is not currently supported")
-	}
-
 	// Shut down the Config Drift Monitor since we'll be performing an update
 	// and the config will "drift" while the update is occurring.
 	dn.stopConfigDriftMonitor()
 
+	// If the desired image annotation is empty, but the current image is not
+	// empty, the pool was opted out of layering and the node has to be rolled
+	// back onto the stock OS image.
+	if desiredImage == "" && currentImage != "" {
+		klog.Infof("Reverting from layered OS image")
+		return dn.revertLayeredImage(currentConfig, desiredConfig, currentImage)
+	}
+
 	klog.Infof("Performing layered OS update")
-	return dn.updateImage(desiredConfig, currentImage, desiredImage)
+	return dn.updateImage(currentConfig, desiredConfig, currentImage, desiredImage)
 }
 
 // triggerUpdateWithMachineConfig starts the update. It queries the cluster for
@@ -2166,7 +2410,7 @@ func (dn *Daemon) triggerUpdateWithMachineConfig(currentConfig, desiredConfig *m
 		if err != nil {
 			return err
 		}
-		currentConfig, err = dn.mcLister.Get(ccAnnotation)
+		currentConfig, err = dn.getMachineConfig(ccAnnotation)
 		if err != nil {
 			return err
 		}
@@ -2177,12 +2421,21 @@ func (dn *Daemon) triggerUpdateWithMachineConfig(currentConfig, desiredConfig *m
 		if err != nil {
 			return err
 		}
-		desiredConfig, err = dn.mcLister.Get(dcAnnotation)
+		desiredConfig, err = dn.getMachineConfig(dcAnnotation)
 		if err != nil {
 			return err
 		}
 	}
 
+	if err := dn.checkRolledBackUpdate(desiredConfig.GetName(), ""); err != nil {
+		return err
+	}
+
+	// A node rolled back on request stays where it is.
+	if dn.node != nil && dn.isRolledBack(desiredConfig.GetName(), dn.node.Annotations[constants.DesiredImageAnnotationKey]) {
+		return dn.completeRollback()
+	}
+
 	// Shut down the Config Drift Monitor since we'll be performing an update
 	// and the config will "drift" while the update is occurring.
 	dn.stopConfigDriftMonitor()
@@ -2194,7 +2447,19 @@ func (dn *Daemon) triggerUpdateWithMachineConfig(currentConfig, desiredConfig *m
 // validateKernelArguments checks that the current boot has all arguments specified
 // in the target machineconfig.
 func (dn *CoreOSDaemon) validateKernelArguments(currentConfig *mcfgv1.MachineConfig) error {
-	rpmostreeKargsBytes, err := runGetOut("rpm-ostree", "kargs")
+	setup, err := dn.getBootSetup()
+	if err != nil {
+		return err
+	}
+
+	// Where the kernel arguments come from the OS image, the bootloader
+	// entries which rpm-ostree reports are not what the node booted with.
+	var rpmostreeKargsBytes []byte
+	if setup.kargsFromImage() {
+		rpmostreeKargsBytes, err = os.ReadFile(CmdLineFile)
+	} else {
+		rpmostreeKargsBytes, err = runGetOut("rpm-ostree", "kargs")
+	}
 	if err != nil {
 		return err
 	}
@@ -2220,6 +2485,9 @@ func (dn *CoreOSDaemon) validateKernelArguments(currentConfig *mcfgv1.MachineCon
 		}
 		klog.Infof("Current ostree kargs: %s", rpmostreeKargs)
 		klog.Infof("Expected MachineConfig kargs: %v", expected)
+		if setup.kargsFromImage() {
+			return fmt.Errorf("missing expected kernel arguments: %v; the node boots with %s, so the OS image must carry them", missing, setup)
+		}
 		return fmt.Errorf("missing expected kernel arguments: %v", missing)
 	}
 	return nil
@@ -2238,9 +2506,14 @@ func (dn *Daemon) validateOnDiskStateImpl(currentConfig *mcfgv1.MachineConfig, i
 		if err := coreOSDaemon.validateKernelArguments(currentConfig); err != nil {
 			return err
 		}
+	} else {
+		packageDaemon := PackageDaemon{dn}
+		if err := packageDaemon.validateKernelArguments(currentConfig); err != nil {
+			return err
+		}
 	}
 
-	return validateOnDiskState(currentConfig, pathSystemd)
+	return validateOnDiskState(currentConfig, pathSystemd, dn.getUnmanagedPaths())
 }
 
 // validateOnDiskState compares the on-disk state against what a configuration
@@ -531,3 +531,117 @@ func TestPrepUpdateFromClusterOnDiskDrift(t *testing.T) {
 		})
 	}
 }
+
+func TestGetPendingResyncRequest(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		request     string
+		pending     bool
+	}{
+		{
+			name: "no request",
+		},
+		{
+			name:        "new request",
+			annotations: map[string]string{constants.ResyncRequestAnnotationKey: "2023-10-01T00:00:00Z"},
+			request:     "2023-10-01T00:00:00Z",
+			pending:     true,
+		},
+		{
+			name: "handled request",
+			annotations: map[string]string{
+				constants.ResyncRequestAnnotationKey:            "2023-10-01T00:00:00Z",
+				constants.LastAppliedResyncRequestAnnotationKey: "2023-10-01T00:00:00Z",
+			},
+		},
+		{
+			name: "another request",
+			annotations: map[string]string{
+				constants.ResyncRequestAnnotationKey:            "2023-10-02T00:00:00Z",
+				constants.LastAppliedResyncRequestAnnotationKey: "2023-10-01T00:00:00Z",
+			},
+			request: "2023-10-02T00:00:00Z",
+			pending: true,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Annotations: test.annotations}}
+			request, pending := getPendingResyncRequest(node)
+			assert.Equal(t, test.request, request)
+			assert.Equal(t, test.pending, pending)
+		})
+	}
+}
+
+// Tests that a node rolled back to a retained image passes the on-disk
+// validation against its desired config, which stays the pool's rendered
+// MachineConfig, and that images whose files would not match are never
+// rolled out.
+func TestValidateOnDiskStateAfterRollback(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rolled-back-file")
+
+	newMC := func(name, contents string) *mcfgv1.MachineConfig {
+		ignConfig := ctrlcommon.NewIgnConfig()
+		ignConfig.Storage.Files = []ign3types.File{helpers.CreateEncodedIgn3File(path, contents, int(defaultFilePermissions))}
+		mc := helpers.CreateMachineConfigFromIgnition(ignConfig)
+		mc.Name = name
+		return mc
+	}
+
+	contents := map[string]string{
+		"rendered-worker-0": "v0",
+		"rendered-worker-1": "v1",
+	}
+
+	newest := "registry.host.com/org/repo@sha256:3333333333333333333333333333333333333333333333333333333333333333"
+	rebuilt := "registry.host.com/org/repo@sha256:2222222222222222222222222222222222222222222222222222222222222222"
+	older := "registry.host.com/org/repo@sha256:1111111111111111111111111111111111111111111111111111111111111111"
+
+	builtFrom := map[string]string{
+		newest:  "rendered-worker-1",
+		rebuilt: "rendered-worker-1",
+		older:   "rendered-worker-0",
+	}
+
+	newPool := func(rollback string) *mcfgv1.MachineConfigPool {
+		return helpers.NewMachineConfigPoolBuilder("worker").WithMachineConfig("rendered-worker-1").WithImage(newest).WithAnnotations(map[string]string{
+			ctrlcommon.RetainedImagePullspecsAnnotationKey:      newest + "," + rebuilt + "," + older,
+			ctrlcommon.RetainedImageMachineConfigsAnnotationKey: newest + "=rendered-worker-1," + rebuilt + "=rendered-worker-1," + older + "=rendered-worker-0",
+			ctrlcommon.RollbackImageAnnotationKey:               rollback,
+		}).MachineConfigPool()
+	}
+
+	dn := newMockDaemon()
+
+	for _, rollback := range []string{rebuilt, older} {
+		pool := newPool(rollback)
+
+		// The image carries the files of the MachineConfig it was built from.
+		require.NoError(t, os.WriteFile(path, []byte(contents[builtFrom[rollback]]), defaultFilePermissions))
+
+		// The node's desired config stays the pool's rendered MachineConfig.
+		desiredConfig := newMC(pool.Spec.Configuration.Name, contents[pool.Spec.Configuration.Name])
+		onDiskErr := dn.validateOnDiskStateOrImage(desiredConfig, rollback)
+
+		image, err := ctrlcommon.NewLayeredPoolState(pool).GetRollbackImage()
+		if rollback == older {
+			// The node would be degraded, so the image is refused.
+			assert.Error(t, onDiskErr)
+			assert.Error(t, err)
+			assert.Empty(t, image)
+			continue
+		}
+
+		assert.NoError(t, onDiskErr)
+		assert.NoError(t, err)
+		assert.Equal(t, rollback, image)
+	}
+}
@@ -0,0 +1,90 @@
+package daemon
+
+import (
+	"fmt"
+	"strings"
+
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	"github.com/openshift/machine-config-operator/pkg/daemon/constants"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+)
+
+const (
+	postConfigChangeActionDaemonReexec  = ctrlcommon.PostConfigChangeActionDaemonReexec
+	postConfigChangeActionRunUnitPrefix = ctrlcommon.PostConfigChangeActionRunUnitPrefix
+)
+
+// getNodeDisruptionPolicy returns the disruption policy which the node
+// controller handed to the node in the DisruptionPolicyAnnotationKey
+// annotation, or nil if there is none.
+func getNodeDisruptionPolicy(node *corev1.Node) *ctrlcommon.DisruptionPolicy {
+	if node == nil {
+		return nil
+	}
+
+	policy, err := ctrlcommon.ParseDisruptionPolicy(node.Annotations[constants.DisruptionPolicyAnnotationKey])
+	if err != nil {
+		klog.Warningf("Ignoring invalid %s annotation: %v", constants.DisruptionPolicyAnnotationKey, err)
+		return nil
+	}
+
+	return policy
+}
+
+// getRunUnitActions returns the units to restart for the "run <unit>" actions,
+// in order.
+func getRunUnitActions(actions []string) []string {
+	units := []string{}
+	for _, action := range actions {
+		if unit, ok := strings.CutPrefix(action, postConfigChangeActionRunUnitPrefix); ok {
+			units = append(units, unit)
+		}
+	}
+	return units
+}
+
+// performDisruptionPolicyActions re-executes systemd and restarts the units
+// the disruption policy asks for. systemd is re-executed first, so that the
+// units run with its new configuration.
+func (dn *Daemon) performDisruptionPolicyActions(postConfigChangeActions []string, configName string) error {
+	if ctrlcommon.InSlice(postConfigChangeActionDaemonReexec, postConfigChangeActions) {
+		if err := runCmdSync("systemctl", "daemon-reexec"); err != nil {
+			if dn.nodeWriter != nil {
+				dn.nodeWriter.Eventf(corev1.EventTypeWarning, "FailedDaemonReexec", fmt.Sprintf("Re-executing systemd failed. Error: %v", err))
+			}
+			return fmt.Errorf("could not apply update: re-executing systemd failed. Error: %w", err)
+		}
+
+		if dn.nodeWriter != nil {
+			dn.nodeWriter.Eventf(corev1.EventTypeNormal, "SkipReboot", "Config changes do not require reboot. systemd was re-executed.")
+		}
+		logSystem("systemd re-executed successfully! Desired config %s has been applied, skipping reboot", configName)
+	}
+
+	units := getRunUnitActions(postConfigChangeActions)
+	if len(units) == 0 {
+		return nil
+	}
+
+	// The units may have been written by the update.
+	if err := runCmdSync("systemctl", "daemon-reload"); err != nil {
+		return fmt.Errorf("could not apply update: reloading systemd units failed. Error: %w", err)
+	}
+
+	for _, unit := range units {
+		if err := restartService(unit); err != nil {
+			if dn.nodeWriter != nil {
+				dn.nodeWriter.Eventf(corev1.EventTypeWarning, "FailedServiceRestart", fmt.Sprintf("Running %s failed. Error: %v", unit, err))
+			}
+			return fmt.Errorf("could not apply update: running %s failed. Error: %w", unit, err)
+		}
+
+		if dn.nodeWriter != nil {
+			dn.nodeWriter.Eventf(corev1.EventTypeNormal, "SkipReboot", "Config changes do not require reboot. Unit %s was run.", unit)
+		}
+		logSystem("%s run successfully! Desired config %s has been applied, skipping reboot", unit, configName)
+	}
+
+	return nil
+}
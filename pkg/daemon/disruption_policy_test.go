@@ -0,0 +1,100 @@
+package daemon
+
+import (
+	"testing"
+
+	ign3types "github.com/coreos/ignition/v2/config/v3_4/types"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	"github.com/openshift/machine-config-operator/pkg/daemon/constants"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestCalculatePostConfigChangeActionWithDisruptionPolicy(t *testing.T) {
+	policy, err := ctrlcommon.ParseDisruptionPolicy(`{"files":[
+		{"path":"/etc/agent.conf","actions":[{"type":"None"}]},
+		{"path":"/etc/agent.d/","actions":[{"type":"RunUnit","unit":"agent-reload.service"}]},
+		{"path":"/etc/systemd/system.conf.d/","actions":[{"type":"DaemonReexec"},{"type":"RunUnit","unit":"agent-reload.service"}]},
+		{"path":"/etc/crio/crio.conf.d/99-custom","actions":[{"type":"RestartCrio"}]},
+		{"path":"/etc/kubernetes/kubelet-env.conf","actions":[{"type":"RestartKubelet"}]}
+	]}`)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name        string
+		diffFileSet []string
+		expected    []string
+	}{
+		{
+			name:        "without action",
+			diffFileSet: []string{"/etc/agent.conf"},
+			expected:    []string{postConfigChangeActionNone},
+		},
+		{
+			name:        "run unit",
+			diffFileSet: []string{"/etc/agent.d/agent.conf", "/etc/agent.d/tls.pem"},
+			expected:    []string{postConfigChangeActionRunUnitPrefix + "agent-reload.service"},
+		},
+		{
+			name:        "reexec before running units",
+			diffFileSet: []string{"/etc/agent.d/agent.conf", "/etc/systemd/system.conf.d/10-timeout.conf"},
+			expected:    []string{postConfigChangeActionDaemonReexec, postConfigChangeActionRunUnitPrefix + "agent-reload.service"},
+		},
+		{
+			name:        "restart crio",
+			diffFileSet: []string{"/etc/crio/crio.conf.d/99-custom", constants.ContainerRegistryConfPath},
+			expected:    []string{postConfigChangeActionRestartCrio},
+		},
+		{
+			name:        "restart kubelet along with crio",
+			diffFileSet: []string{"/etc/kubernetes/kubelet-env.conf", constants.ContainerRegistryConfPath},
+			expected:    []string{postConfigChangeActionReloadCrio, postConfigChangeActionRestartKubelet},
+		},
+		{
+			name:        "not covered",
+			diffFileSet: []string{"/etc/agent.d/agent.conf", "/etc/other.conf"},
+			expected:    []string{postConfigChangeActionReboot},
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, ctrlcommon.CalculatePostConfigChangeActions(test.diffFileSet, policy))
+		})
+	}
+
+	// Without a policy, the same changes reboot the node.
+	assert.Equal(t, []string{postConfigChangeActionReboot}, ctrlcommon.CalculatePostConfigChangeActions([]string{"/etc/agent.d/agent.conf"}, nil))
+}
+
+func TestDisruptionPolicyActionsSkipDrain(t *testing.T) {
+	ignConfig := ign3types.Config{}
+
+	for _, actions := range [][]string{
+		{postConfigChangeActionDaemonReexec},
+		{postConfigChangeActionRunUnitPrefix + "agent-reload.service"},
+	} {
+		drain, err := ctrlcommon.IsDrainRequired(actions, []string{"/etc/agent.d/agent.conf"}, ignConfig, ignConfig)
+		require.NoError(t, err)
+		assert.False(t, drain, actions)
+	}
+}
+
+func TestGetNodeDisruptionPolicy(t *testing.T) {
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{}}}
+	assert.Nil(t, getNodeDisruptionPolicy(node))
+
+	node.Annotations[constants.DisruptionPolicyAnnotationKey] = `{"files":[{"path":"/etc/agent.conf","actions":[{"type":"Reboot"}]}]}`
+	assert.Nil(t, getNodeDisruptionPolicy(node))
+
+	node.Annotations[constants.DisruptionPolicyAnnotationKey] = `{"files":[{"path":"/etc/agent.conf","actions":[{"type":"DaemonReexec"}]}]}`
+	policy := getNodeDisruptionPolicy(node)
+	if assert.NotNil(t, policy) {
+		actions, ok := policy.GetActions("/etc/agent.conf")
+		assert.True(t, ok)
+		assert.Equal(t, []ctrlcommon.DisruptionPolicyAction{{Type: ctrlcommon.DisruptionPolicyActionDaemonReexec}}, actions)
+	}
+}
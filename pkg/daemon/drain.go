@@ -3,13 +3,8 @@ package daemon
 import (
 	"context"
 	"fmt"
-	"reflect"
 	"time"
 
-	"github.com/BurntSushi/toml"
-	"github.com/containers/image/v5/pkg/sysregistriesv2"
-	ign3types "github.com/coreos/ignition/v2/config/v3_4/types"
-	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
 	"github.com/openshift/machine-config-operator/pkg/daemon/constants"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -96,189 +91,3 @@ func (dn *Daemon) performDrain() error {
 
 	return nil
 }
-
-// isDrainRequired determines whether node drain is required or not to apply config changes.
-func isDrainRequired(actions, diffFileSet []string, oldIgnConfig, newIgnConfig ign3types.Config) (bool, error) {
-	if ctrlcommon.InSlice(postConfigChangeActionReboot, actions) {
-		// Node is going to reboot, we definitely want to perform drain
-		return true, nil
-	} else if ctrlcommon.InSlice(postConfigChangeActionReloadCrio, actions) {
-		// Drain may or may not be necessary in case of container registry config changes.
-		if ctrlcommon.InSlice(constants.ContainerRegistryConfPath, diffFileSet) {
-			isSafe, err := isSafeContainerRegistryConfChanges(oldIgnConfig, newIgnConfig)
-			if err != nil {
-				return false, err
-			}
-			return !isSafe, nil
-		}
-		return false, nil
-	} else if ctrlcommon.InSlice(postConfigChangeActionNone, actions) {
-		return false, nil
-	}
-	// For any unhandled cases, default to drain
-	return true, nil
-}
-
-// isSafeContainerRegistryConfChanges looks inside old and new versions of registries.conf file.
-// It compares the content and determines whether changes made are safe or not. This will
-// help MCD to decide whether we can skip node drain for applied changes into container
-// registry.
-// Currently, we consider following container registry config changes as safe to skip node drain:
-// 1. A new mirror that has 'pull-from-mirror=digest-only' is added
-// 2. A new registry has been added that has all mirrors with 'pull-from-mirror=digest-only'
-// See https://bugzilla.redhat.com/show_bug.cgi?id=1943315
-//
-//nolint:gocyclo
-func isSafeContainerRegistryConfChanges(oldIgnConfig, newIgnConfig ign3types.Config) (bool, error) {
-	// /etc/containers/registries.conf contains config in toml format. Parse the file
-	oldData, err := ctrlcommon.GetIgnitionFileDataByPath(&oldIgnConfig, constants.ContainerRegistryConfPath)
-	if err != nil {
-		return false, fmt.Errorf("failed decoding Data URL scheme string: %w", err)
-	}
-
-	newData, err := ctrlcommon.GetIgnitionFileDataByPath(&newIgnConfig, constants.ContainerRegistryConfPath)
-	if err != nil {
-		return false, fmt.Errorf("failed decoding Data URL scheme string %w", err)
-	}
-
-	tomlConfOldReg := sysregistriesv2.V2RegistriesConf{}
-	if _, err := toml.Decode(string(oldData), &tomlConfOldReg); err != nil {
-		return false, fmt.Errorf("failed decoding TOML content from file %s: %w", constants.ContainerRegistryConfPath, err)
-	}
-
-	tomlConfNewReg := sysregistriesv2.V2RegistriesConf{}
-	if _, err := toml.Decode(string(newData), &tomlConfNewReg); err != nil {
-		return false, fmt.Errorf("failed decoding TOML content from file %s: %w", constants.ContainerRegistryConfPath, err)
-	}
-
-	// Ensure that any unqualified-search-registries has not been deleted
-	if len(tomlConfOldReg.UnqualifiedSearchRegistries) > len(tomlConfNewReg.UnqualifiedSearchRegistries) {
-		return false, nil
-	}
-	for i, regURL := range tomlConfOldReg.UnqualifiedSearchRegistries {
-		// Order of UnqualifiedSearchRegistries matters since image lookup occurs in order
-		if tomlConfNewReg.UnqualifiedSearchRegistries[i] != regURL {
-			return false, nil
-		}
-	}
-
-	oldRegHashMap := make(map[string]sysregistriesv2.Registry)
-	for _, reg := range tomlConfOldReg.Registries {
-		scope := reg.Location
-		if reg.Prefix != "" {
-			scope = reg.Prefix
-		}
-		oldRegHashMap[scope] = reg
-	}
-
-	newRegHashMap := make(map[string]sysregistriesv2.Registry)
-	for _, reg := range tomlConfNewReg.Registries {
-		scope := reg.Location
-		if reg.Prefix != "" {
-			scope = reg.Prefix
-		}
-		newRegHashMap[scope] = reg
-	}
-
-	// Check for removed registry
-	for regLoc := range oldRegHashMap {
-		_, ok := newRegHashMap[regLoc]
-		if !ok {
-			klog.Infof("%s: registry %s has been removed", constants.ContainerRegistryConfPath, regLoc)
-			return false, nil
-		}
-	}
-
-	// Check for modified registry
-	for regLoc, newReg := range newRegHashMap {
-		oldReg, ok := oldRegHashMap[regLoc]
-		if ok {
-			// Registry is available in both old and new config.
-			if !reflect.DeepEqual(oldReg, newReg) {
-				// Registry has been changed in the new config.
-				// Check that changes made are safe or not.
-				if oldReg.Prefix != newReg.Prefix {
-					klog.Infof("%s: prefix value for registry %s has changed from %s to %s",
-						constants.ContainerRegistryConfPath, regLoc, oldReg.Prefix, newReg.Prefix)
-					return false, nil
-				}
-				if oldReg.Location != newReg.Location {
-					klog.Infof("%s: location value for registry %s has changed from %s to %s",
-						constants.ContainerRegistryConfPath, regLoc, oldReg.Location, newReg.Location)
-					return false, nil
-				}
-				if oldReg.Blocked != newReg.Blocked {
-					klog.Infof("%s: blocked value for registry %s has changed from %t to %t",
-						constants.ContainerRegistryConfPath, regLoc, oldReg.Blocked, newReg.Blocked)
-					return false, nil
-				}
-				if oldReg.Insecure != newReg.Insecure {
-					klog.Infof("%s: insecure value for registry %s has changed from %t to %t",
-						constants.ContainerRegistryConfPath, regLoc, oldReg.Insecure, newReg.Insecure)
-					return false, nil
-				}
-
-				// Ensure that all the old mirrors are present
-				for _, m := range oldReg.Mirrors {
-					if found, _ := searchRegistryMirror(m.Location, newReg.Mirrors); !found {
-						klog.Infof("%s: mirror %s has been removed in registry %s",
-							constants.ContainerRegistryConfPath, m.Location, regLoc)
-						return false, nil
-					}
-				}
-				for _, m := range newReg.Mirrors {
-					// Ensure that any change to current does not unset pull-from-mirror="digest-only"
-					if found, oldMirror := searchRegistryMirror(m.Location, oldReg.Mirrors); found {
-						if m.PullFromMirror != oldMirror.PullFromMirror && m.PullFromMirror != sysregistriesv2.MirrorByDigestOnly {
-							klog.Infof("%s: pull-from-mirror value for mirror %s has changed from %s to %s ",
-								constants.ContainerRegistryConfPath, m.Location, oldMirror.PullFromMirror, m.PullFromMirror)
-							return false, nil
-						}
-					}
-					// Ensure that any added mirror has set pull-from-mirror="digest-only"
-					if found, _ := searchRegistryMirror(m.Location, oldReg.Mirrors); !found {
-						if m.PullFromMirror != sysregistriesv2.MirrorByDigestOnly && !newReg.MirrorByDigestOnly {
-							klog.Infof("%s: mirror %s has been added in registry %s that has pull-from-mirror set to %s ",
-								constants.ContainerRegistryConfPath, m.Location, regLoc, m.PullFromMirror)
-							return false, nil
-						}
-
-					}
-				}
-			}
-		} else if !allDigestOnlyMirror(newReg) {
-			// Ensure that each mirror under the newReg has pull-from-mirror=digest-only
-			klog.Infof("%s: registry %s has been added with mirror does not set pull-from-mirror=digest-only",
-				constants.ContainerRegistryConfPath, regLoc)
-			return false, nil
-		}
-	}
-
-	klog.Infof("%s: changes made are safe to skip drain", constants.ContainerRegistryConfPath)
-	return true, nil
-}
-
-// searchRegistryMirror does lookup of a mirror in the mirrorList specified for a registry
-// Returns true if found
-func searchRegistryMirror(loc string, mirrors []sysregistriesv2.Endpoint) (bool, sysregistriesv2.Endpoint) {
-	found := false
-	for _, m := range mirrors {
-		if m.Location == loc {
-			found = true
-			return found, m
-		}
-	}
-	return found, sysregistriesv2.Endpoint{}
-}
-
-func allDigestOnlyMirror(reg sysregistriesv2.Registry) bool {
-	if len(reg.Mirrors) == 0 {
-		return reg.MirrorByDigestOnly
-	}
-	for _, m := range reg.Mirrors {
-		if m.PullFromMirror != sysregistriesv2.MirrorByDigestOnly {
-			return false
-		}
-	}
-	return true
-}
@@ -356,6 +356,27 @@ location = "mirror.com/repo/test-img-14"
 			newConfig:      machineConfigs["mc1"],
 			expectedAction: true,
 		},
+		{
+			// skip drain: NetworkManager reload for DNS changes
+			actions:        []string{postConfigChangeActionReloadNetworkManager},
+			oldConfig:      machineConfigs["mc1"],
+			newConfig:      machineConfigs["mc1"],
+			expectedAction: false,
+		},
+		{
+			// skip drain: chronyd and kubelet restarts for chrony and kubelet log level changes
+			actions:        []string{postConfigChangeActionRestartChronyd, postConfigChangeActionRestartKubelet},
+			oldConfig:      machineConfigs["mc1"],
+			newConfig:      machineConfigs["mc1"],
+			expectedAction: false,
+		},
+		{
+			// skip drain: crio restart for live storage.conf changes
+			actions:        []string{postConfigChangeActionRestartCrio},
+			oldConfig:      machineConfigs["mc1"],
+			newConfig:      machineConfigs["mc1"],
+			expectedAction: false,
+		},
 		// below tests are run when only crio reload action is present
 		{
 			// skip drain: no changes in registry config
@@ -468,7 +489,7 @@ location = "mirror.com/repo/test-img-14"
 				t.Errorf("parsing new Ignition config failed: %v", err)
 			}
 			diffFileSet := ctrlcommon.CalculateConfigFileDiffs(&oldIgnConfig, &newIgnConfig)
-			drain, err := isDrainRequired(test.actions, diffFileSet, oldIgnConfig, newIgnConfig)
+			drain, err := ctrlcommon.IsDrainRequired(test.actions, diffFileSet, oldIgnConfig, newIgnConfig)
 			if !reflect.DeepEqual(test.expectedAction, drain) {
 				t.Errorf("Failed determining drain behavior: expected: %v but result is: %v. Error: %v", test.expectedAction, drain, err)
 			}
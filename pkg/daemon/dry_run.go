@@ -0,0 +1,218 @@
+package daemon
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/clarketm/json"
+	ign3types "github.com/coreos/ignition/v2/config/v3_4/types"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	"github.com/openshift/machine-config-operator/pkg/daemon/constants"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// dryRunReport is what the MCD reports under DryRunAnnotationKey: what
+// updating the node to its desired config would do.
+type dryRunReport struct {
+	Request       string `json:"request"`
+	Config        string `json:"config"`
+	Image         string `json:"image,omitempty"`
+	DesiredConfig string `json:"desiredConfig"`
+	DesiredImage  string `json:"desiredImage,omitempty"`
+	// Error is why the update could not be applied, e.g. because it has
+	// unreconcilable changes. Nothing else is reported then.
+	Error string `json:"error,omitempty"`
+	// FilesWritten are the files which are added or changed.
+	FilesWritten []string `json:"filesWritten,omitempty"`
+	// FilesRemoved are the files which are no longer in the desired config.
+	FilesRemoved []string `json:"filesRemoved,omitempty"`
+	// UnitsChanged are the systemd units which are added, changed or removed.
+	UnitsChanged []string `json:"unitsChanged,omitempty"`
+	// OSChanges describes the changes to the OS, e.g. "Changing kernel
+	// arguments".
+	OSChanges string `json:"osChanges,omitempty"`
+	// Actions are the post config change actions, e.g. "reboot" or
+	// "restart crio".
+	Actions []string `json:"actions,omitempty"`
+	Drain   bool     `json:"drain"`
+	Reboot  bool     `json:"reboot"`
+	Time    string   `json:"time"`
+}
+
+// getPendingDryRunRequest returns the dry run request set on the node if it
+// has not been handled yet.
+func getPendingDryRunRequest(node *corev1.Node) (string, bool) {
+	request := node.Annotations[constants.DryRunRequestAnnotationKey]
+	if request == "" || request == node.Annotations[constants.LastAppliedDryRunRequestAnnotationKey] {
+		return "", false
+	}
+
+	return request, true
+}
+
+// getFileChanges splits the files which differ between the configs into the
+// ones the update writes and the ones it removes.
+func getFileChanges(diffFileSet []string, newIgnConfig ign3types.Config) ([]string, []string) {
+	newFiles := map[string]bool{}
+	for _, file := range newIgnConfig.Storage.Files {
+		newFiles[file.Path] = true
+	}
+
+	written, removed := []string{}, []string{}
+	for _, path := range diffFileSet {
+		if newFiles[path] {
+			written = append(written, path)
+		} else {
+			removed = append(removed, path)
+		}
+	}
+
+	sort.Strings(written)
+	sort.Strings(removed)
+
+	return written, removed
+}
+
+// getUnitChanges returns the names of the units the update adds, changes or
+// removes, including changes to their dropins.
+func getUnitChanges(oldIgnConfig, newIgnConfig ign3types.Config) []string {
+	oldUnits := map[string]ign3types.Unit{}
+	for _, unit := range oldIgnConfig.Systemd.Units {
+		oldUnits[unit.Name] = unit
+	}
+
+	changed := []string{}
+	for _, unit := range newIgnConfig.Systemd.Units {
+		if oldUnit, ok := oldUnits[unit.Name]; !ok || !reflect.DeepEqual(oldUnit, unit) {
+			changed = append(changed, unit.Name)
+		}
+		delete(oldUnits, unit.Name)
+	}
+
+	for name := range oldUnits {
+		changed = append(changed, name)
+	}
+
+	sort.Strings(changed)
+
+	return changed
+}
+
+// getDryRunReport finds what updating the node from the current to the
+// desired config and image would do with the node's disruption policy,
+// without changing anything.
+func getDryRunReport(ufc *updateFromCluster, policy *ctrlcommon.DisruptionPolicy) *dryRunReport {
+	report := &dryRunReport{
+		Config:        ufc.currentConfig.GetName(),
+		Image:         ufc.currentImage,
+		DesiredConfig: ufc.desiredConfig.GetName(),
+		DesiredImage:  ufc.desiredImage,
+	}
+
+	diff, err := reconcilable(ufc.currentConfig, ufc.desiredConfig)
+	if err != nil {
+		report.Error = err.Error()
+		return report
+	}
+
+	oldIgnConfig, err := ctrlcommon.ParseAndConvertConfig(ufc.currentConfig.Spec.Config.Raw)
+	if err != nil {
+		report.Error = fmt.Sprintf("parsing old Ignition config failed: %v", err)
+		return report
+	}
+	newIgnConfig, err := ctrlcommon.ParseAndConvertConfig(ufc.desiredConfig.Spec.Config.Raw)
+	if err != nil {
+		report.Error = fmt.Sprintf("parsing new Ignition config failed: %v", err)
+		return report
+	}
+
+	actions, err := getUpdateActions(ufc, policy)
+	if err != nil {
+		report.Error = err.Error()
+		return report
+	}
+
+	diffFileSet := ctrlcommon.CalculateConfigFileDiffs(&oldIgnConfig, &newIgnConfig)
+
+	drain, err := ctrlcommon.IsDrainRequired(actions, diffFileSet, oldIgnConfig, newIgnConfig)
+	if err != nil {
+		report.Error = err.Error()
+		return report
+	}
+
+	report.FilesWritten, report.FilesRemoved = getFileChanges(diffFileSet, newIgnConfig)
+	report.UnitsChanged = getUnitChanges(oldIgnConfig, newIgnConfig)
+	report.OSChanges = diff.osChangesString()
+	report.Actions = actions
+	report.Drain = drain
+	report.Reboot = ctrlcommon.InSlice(postConfigChangeActionReboot, actions)
+
+	return report
+}
+
+// handleDryRunRequest reports what updating the node to its desired config
+// would do in response to an admin setting the dry run request annotation on
+// the node: the files and units it would change, the post config change
+// actions it would take and whether it would drain and reboot the node.
+// Nothing is applied, and the node is synced as usual afterwards.
+func (dn *Daemon) handleDryRunRequest(request string) error {
+	logSystem("Dry run requested via %s=%s", constants.DryRunRequestAnnotationKey, request)
+
+	// The on-disk config is the last config we successfully applied.
+	odc, err := dn.getCurrentConfigOnDisk()
+	if err != nil {
+		return fmt.Errorf("could not get on-disk config: %w", err)
+	}
+
+	ufc := &updateFromCluster{
+		currentConfig: odc.currentConfig,
+		currentImage:  odc.currentImage,
+		desiredImage:  dn.node.Annotations[constants.DesiredImageAnnotationKey],
+	}
+
+	desiredConfigName := dn.node.Annotations[constants.DesiredMachineConfigAnnotationKey]
+
+	var report *dryRunReport
+	if ufc.desiredConfig, err = dn.getMachineConfig(desiredConfigName); err != nil {
+		report = &dryRunReport{
+			Config:        odc.currentConfig.GetName(),
+			Image:         odc.currentImage,
+			DesiredConfig: desiredConfigName,
+			DesiredImage:  ufc.desiredImage,
+			Error:         fmt.Sprintf("could not get desired config: %v", err),
+		}
+	} else {
+		report = getDryRunReport(ufc, getNodeDisruptionPolicy(dn.node))
+	}
+
+	report.Request = request
+	report.Time = time.Now().UTC().Format(time.RFC3339)
+
+	out, err := json.Marshal(report)
+	if err != nil {
+		return fmt.Errorf("could not encode dry run: %w", err)
+	}
+
+	// The report is written before the request is acknowledged, so that the
+	// request is handled again if reporting fails.
+	if _, err := dn.nodeWriter.SetNodeStatus(map[string]string{constants.DryRunAnnotationKey: string(out)}); err != nil {
+		return fmt.Errorf("could not report dry run: %w", err)
+	}
+
+	if _, err := dn.nodeWriter.SetAnnotations(map[string]string{constants.LastAppliedDryRunRequestAnnotationKey: request}); err != nil {
+		return fmt.Errorf("could not acknowledge dry run request: %w", err)
+	}
+
+	if report.Error != "" {
+		dn.nodeWriter.Eventf(corev1.EventTypeWarning, "DryRunFailed", "Update from %s to %s could not be applied: %s", report.Config, report.DesiredConfig, report.Error)
+		return nil
+	}
+
+	dn.nodeWriter.Eventf(corev1.EventTypeNormal, "DryRunCompleted", "Update from %s to %s would write %d files, remove %d files, change %d units and take actions %s (drain: %t, reboot: %t)",
+		report.Config, report.DesiredConfig, len(report.FilesWritten), len(report.FilesRemoved), len(report.UnitsChanged), strings.Join(report.Actions, ", "), report.Drain, report.Reboot)
+
+	return nil
+}
@@ -0,0 +1,88 @@
+package daemon
+
+import (
+	"testing"
+
+	ign3types "github.com/coreos/ignition/v2/config/v3_4/types"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	"github.com/openshift/machine-config-operator/pkg/daemon/constants"
+	"github.com/openshift/machine-config-operator/test/helpers"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestGetPendingDryRunRequest(t *testing.T) {
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{}}}
+	_, ok := getPendingDryRunRequest(node)
+	assert.False(t, ok)
+
+	node.Annotations[constants.DryRunRequestAnnotationKey] = "2024-01-01T00:00:00Z"
+	request, ok := getPendingDryRunRequest(node)
+	assert.True(t, ok)
+	assert.Equal(t, "2024-01-01T00:00:00Z", request)
+
+	node.Annotations[constants.LastAppliedDryRunRequestAnnotationKey] = "2024-01-01T00:00:00Z"
+	_, ok = getPendingDryRunRequest(node)
+	assert.False(t, ok)
+}
+
+func TestGetDryRunReport(t *testing.T) {
+	oldIgnCfg := ctrlcommon.NewIgnConfig()
+	oldIgnCfg.Storage.Files = []ign3types.File{
+		helpers.CreateEncodedIgn3File("/etc/agent.conf", "old", 0o644),
+		helpers.CreateEncodedIgn3File("/etc/removed.conf", "removed", 0o644),
+	}
+	oldIgnCfg.Systemd.Units = []ign3types.Unit{
+		{Name: "agent.service", Contents: helpers.StrToPtr("[Service]\nExecStart=/usr/bin/agent\n")},
+		{Name: "removed.service", Contents: helpers.StrToPtr("[Service]\nExecStart=/usr/bin/true\n")},
+	}
+
+	newIgnCfg := ctrlcommon.NewIgnConfig()
+	newIgnCfg.Storage.Files = []ign3types.File{
+		helpers.CreateEncodedIgn3File("/etc/agent.conf", "new", 0o644),
+		helpers.CreateEncodedIgn3File("/etc/added.conf", "added", 0o644),
+	}
+	newIgnCfg.Systemd.Units = []ign3types.Unit{
+		{Name: "agent.service", Contents: helpers.StrToPtr("[Service]\nExecStart=/usr/bin/agent --verbose\n")},
+	}
+
+	oldConfig := helpers.CreateMachineConfigFromIgnition(oldIgnCfg)
+	oldConfig.Name = "rendered-worker-1"
+	newConfig := helpers.CreateMachineConfigFromIgnition(newIgnCfg)
+	newConfig.Name = "rendered-worker-2"
+
+	ufc := &updateFromCluster{currentConfig: oldConfig, desiredConfig: newConfig}
+
+	report := getDryRunReport(ufc, nil)
+	assert.Empty(t, report.Error)
+	assert.Equal(t, "rendered-worker-1", report.Config)
+	assert.Equal(t, "rendered-worker-2", report.DesiredConfig)
+	assert.Equal(t, []string{"/etc/added.conf", "/etc/agent.conf"}, report.FilesWritten)
+	assert.Equal(t, []string{"/etc/removed.conf"}, report.FilesRemoved)
+	assert.Equal(t, []string{"agent.service", "removed.service"}, report.UnitsChanged)
+	assert.Equal(t, []string{postConfigChangeActionReboot}, report.Actions)
+	assert.True(t, report.Drain)
+	assert.True(t, report.Reboot)
+
+	// The disruption policy is taken into account.
+	policy, err := ctrlcommon.ParseDisruptionPolicy(`{"files":[{"path":"/etc/","actions":[{"type":"None"}]}]}`)
+	assert.NoError(t, err)
+	newIgnCfg.Systemd.Units = oldIgnCfg.Systemd.Units
+	ufc.desiredConfig = helpers.CreateMachineConfigFromIgnition(newIgnCfg)
+
+	report = getDryRunReport(ufc, policy)
+	assert.Empty(t, report.Error)
+	assert.Empty(t, report.UnitsChanged)
+	assert.Equal(t, []string{postConfigChangeActionNone}, report.Actions)
+	assert.False(t, report.Drain)
+	assert.False(t, report.Reboot)
+
+	// Unreconcilable changes are reported instead.
+	newIgnCfg.Storage.Disks = []ign3types.Disk{{Device: "/dev/sdb"}}
+	ufc.desiredConfig = helpers.CreateMachineConfigFromIgnition(newIgnCfg)
+
+	report = getDryRunReport(ufc, nil)
+	assert.NotEmpty(t, report.Error)
+	assert.Empty(t, report.Actions)
+}
@@ -0,0 +1,137 @@
+package daemon
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	"github.com/openshift/machine-config-operator/pkg/daemon/constants"
+	"golang.org/x/sys/unix"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// How often the free disk space of the node is checked.
+	freeDiskReportInterval = 5 * time.Minute
+	// How much the free disk space of a filesystem has to change before it is
+	// reported again, so that the node object is not updated constantly.
+	freeDiskReportGranularity = 256 * 1024 * 1024
+)
+
+// The filesystems an update writes to: OS images are pulled into /sysroot and
+// container images into /var.
+var freeDiskPaths = []string{"/sysroot", "/var"}
+
+// getFreeDisk returns the number of bytes available to unprivileged users on
+// the filesystem of each path. Paths which do not exist, e.g. /sysroot on
+// non-CoreOS nodes, are skipped.
+func getFreeDisk(paths []string) (map[string]int64, error) {
+	free := map[string]int64{}
+
+	for _, path := range paths {
+		var stat unix.Statfs_t
+		if err := unix.Statfs(path, &stat); err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return nil, fmt.Errorf("could not stat filesystem of %s: %w", path, err)
+		}
+
+		free[path] = int64(stat.Bavail) * int64(stat.Bsize) //nolint:unconvert // Bsize is not int64 on all architectures
+	}
+
+	return free, nil
+}
+
+// getFreeInodes returns the number of free inodes on the filesystem of each
+// path. Like getFreeDisk, paths which do not exist are skipped.
+func getFreeInodes(paths []string) (map[string]int64, error) {
+	free := map[string]int64{}
+
+	for _, path := range paths {
+		var stat unix.Statfs_t
+		if err := unix.Statfs(path, &stat); err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return nil, fmt.Errorf("could not stat filesystem of %s: %w", path, err)
+		}
+
+		free[path] = int64(stat.Ffree)
+	}
+
+	return free, nil
+}
+
+// shouldReportFreeDisk determines whether the free disk space changed enough
+// since it was last reported.
+func shouldReportFreeDisk(reported, current map[string]int64) bool {
+	if len(reported) != len(current) {
+		return true
+	}
+
+	for path, free := range current {
+		old, ok := reported[path]
+		if !ok {
+			return true
+		}
+
+		if diff := free - old; diff >= freeDiskReportGranularity || diff <= -freeDiskReportGranularity {
+			return true
+		}
+	}
+
+	return false
+}
+
+// reportFreeDisk records the free disk space of the node in its node status
+// under FreeDiskAnnotationKey so that the node controller can hold off on
+// updating nodes which would run out of space.
+func (dn *Daemon) reportFreeDisk() error {
+	if dn.nodeWriter == nil {
+		return nil
+	}
+
+	node, err := dn.nodeLister.Get(dn.name)
+	if err != nil {
+		return fmt.Errorf("could not get node %s: %w", dn.name, err)
+	}
+
+	current, err := getFreeDisk(freeDiskPaths)
+	if err != nil {
+		return err
+	}
+
+	reported := map[string]int64{}
+	if val, ok := ctrlcommon.GetNodeStatus(node, constants.FreeDiskAnnotationKey); ok {
+		if err := json.Unmarshal([]byte(val), &reported); err != nil {
+			klog.V(4).Infof("Could not parse reported free disk space %q: %v", val, err)
+			reported = map[string]int64{}
+		}
+	}
+
+	if !shouldReportFreeDisk(reported, current) {
+		return nil
+	}
+
+	out, err := json.Marshal(current)
+	if err != nil {
+		return err
+	}
+
+	_, err = dn.nodeWriter.SetNodeStatus(map[string]string{constants.FreeDiskAnnotationKey: string(out)})
+	return err
+}
+
+// runFreeDiskReporter periodically reports the free disk space until stopped.
+func (dn *Daemon) runFreeDiskReporter(stopCh <-chan struct{}) {
+	wait.Until(func() {
+		if err := dn.reportFreeDisk(); err != nil {
+			klog.Warningf("Could not report free disk space: %v", err)
+		}
+	}, freeDiskReportInterval, stopCh)
+}
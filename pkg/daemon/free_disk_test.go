@@ -0,0 +1,31 @@
+package daemon
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShouldReportFreeDisk(t *testing.T) {
+	const mib = 1024 * 1024
+
+	reported := map[string]int64{"/sysroot": 10240 * mib, "/var": 20480 * mib}
+
+	assert.False(t, shouldReportFreeDisk(reported, map[string]int64{"/sysroot": 10200 * mib, "/var": 20600 * mib}))
+	assert.True(t, shouldReportFreeDisk(reported, map[string]int64{"/sysroot": 9984 * mib, "/var": 20480 * mib}))
+	assert.True(t, shouldReportFreeDisk(reported, map[string]int64{"/sysroot": 10240 * mib, "/var": 20736 * mib}))
+	assert.True(t, shouldReportFreeDisk(reported, map[string]int64{"/var": 20480 * mib}))
+	assert.True(t, shouldReportFreeDisk(map[string]int64{}, map[string]int64{"/var": 20480 * mib}))
+}
+
+func TestGetFreeDisk(t *testing.T) {
+	free, err := getFreeDisk([]string{t.TempDir(), "/nonexistent"})
+	assert.NoError(t, err)
+	assert.Len(t, free, 1)
+}
+
+func TestGetFreeInodes(t *testing.T) {
+	free, err := getFreeInodes([]string{t.TempDir(), "/nonexistent"})
+	assert.NoError(t, err)
+	assert.Len(t, free, 1)
+}
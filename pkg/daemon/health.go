@@ -0,0 +1,226 @@
+package daemon
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	rpmostreeclient "github.com/coreos/rpmostree-client-go/pkg/client"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	"github.com/openshift/machine-config-operator/pkg/daemon/constants"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clientset "k8s.io/client-go/kubernetes"
+)
+
+// HealthCheck is a single check run by `machine-config-daemon health`. Check
+// returns an error describing the root cause when the check fails.
+type HealthCheck struct {
+	Name  string
+	Check func(ctx context.Context) error
+}
+
+// RunHealthChecks runs the given checks concurrently, each with the given
+// timeout, so that a hung check cannot hide the results of the others. It
+// returns an error listing every failed check, or nil if all of them passed.
+func RunHealthChecks(ctx context.Context, checks []HealthCheck, timeout time.Duration) error {
+	results := make([]chan error, len(checks))
+
+	for i, check := range checks {
+		results[i] = make(chan error, 1)
+
+		go func(check HealthCheck, result chan<- error) {
+			checkCtx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+
+			done := make(chan error, 1)
+			go func() {
+				done <- check.Check(checkCtx)
+			}()
+
+			select {
+			case err := <-done:
+				result <- err
+			case <-checkCtx.Done():
+				result <- fmt.Errorf("did not respond within %s", timeout)
+			}
+		}(check, results[i])
+	}
+
+	failures := []string{}
+	for i, check := range checks {
+		if err := <-results[i]; err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %s", check.Name, err))
+		}
+	}
+
+	if len(failures) == 0 {
+		return nil
+	}
+
+	return errors.New(strings.Join(failures, "\n"))
+}
+
+// NewAPIHealthCheck checks that the API server is reachable by getting the
+// node the daemon manages.
+func NewAPIHealthCheck(kubeClient clientset.Interface, nodeName string) HealthCheck {
+	return HealthCheck{
+		Name: "api",
+		Check: func(ctx context.Context) error {
+			if _, err := kubeClient.CoreV1().Nodes().Get(ctx, nodeName, metav1.GetOptions{}); err != nil {
+				return fmt.Errorf("could not reach the API server: %w", err)
+			}
+			return nil
+		},
+	}
+}
+
+// NewAnnotationsHealthCheck checks that the daemon keeps the annotations on
+// its node up to date.
+func NewAnnotationsHealthCheck(kubeClient clientset.Interface, nodeName string) HealthCheck {
+	return HealthCheck{
+		Name: "annotations",
+		Check: func(ctx context.Context) error {
+			node, err := kubeClient.CoreV1().Nodes().Get(ctx, nodeName, metav1.GetOptions{})
+			if err != nil {
+				return fmt.Errorf("could not get node %s: %w", nodeName, err)
+			}
+			return checkNodeAnnotations(node)
+		},
+	}
+}
+
+// isUpdateWaiting returns whether the daemon deliberately leaves the node at
+// its current config: the node is held by an admin, its update is staged,
+// waits for a maintenance window or was skipped by the preflight checks.
+func isUpdateWaiting(node *corev1.Node) bool {
+	if ctrlcommon.IsNodeHeld(node) || ctrlcommon.IsUpdateStaged(node) || ctrlcommon.HasFailedPreflightChecks(node) {
+		return true
+	}
+
+	val, ok := ctrlcommon.GetNodeStatus(node, constants.PendingUpdateAnnotationKey)
+	if !ok || val == "" {
+		return false
+	}
+
+	pending := &pendingUpdateReport{}
+	if err := json.Unmarshal([]byte(val), pending); err != nil {
+		return false
+	}
+
+	return pending.Config == node.Annotations[constants.DesiredMachineConfigAnnotationKey]
+}
+
+// checkNodeAnnotations determines whether the daemon has reported its state
+// and is acting on the node's desired config. A degraded daemon is still
+// healthy in this sense, since it reports why it is degraded, and so is one
+// which deliberately leaves the node at its current config.
+func checkNodeAnnotations(node *corev1.Node) error {
+	state := node.Annotations[constants.MachineConfigDaemonStateAnnotationKey]
+	if state == "" {
+		return fmt.Errorf("node %s has no %s annotation; the daemon has not reported its state yet", node.Name, constants.MachineConfigDaemonStateAnnotationKey)
+	}
+
+	current := node.Annotations[constants.CurrentMachineConfigAnnotationKey]
+	if current == "" {
+		return fmt.Errorf("node %s has no %s annotation; the daemon has not reported its current config yet", node.Name, constants.CurrentMachineConfigAnnotationKey)
+	}
+
+	desired := node.Annotations[constants.DesiredMachineConfigAnnotationKey]
+	// A node rolled back on request is held at its current config.
+	if r := getLastRollback(node); r != nil && r.Config == desired && r.Image == node.Annotations[constants.DesiredImageAnnotationKey] {
+		return nil
+	}
+
+	if isUpdateWaiting(node) {
+		return nil
+	}
+
+	if desired != "" && desired != current && state == constants.MachineConfigDaemonStateDone {
+		return fmt.Errorf("node %s is %s on %s but its desired config is %s; the daemon is not acting on the update", node.Name, state, current, desired)
+	}
+
+	return nil
+}
+
+// NewRpmOstreeHealthCheck checks that rpm-ostreed responds, by querying the
+// booted deployment from the host mounted at rootMount. Returns false if the
+// host is not booted from an ostree deployment.
+func NewRpmOstreeHealthCheck(rootMount string) (HealthCheck, bool) {
+	if _, err := os.Stat(filepath.Join(rootMount, "/run/ostree-booted")); err != nil {
+		return HealthCheck{}, false
+	}
+
+	return HealthCheck{
+		Name: "rpm-ostree",
+		Check: func(ctx context.Context) error {
+			out, err := runHostCommand(ctx, rootMount, "rpm-ostree", "status", "--booted", "--json")
+			if err != nil {
+				return err
+			}
+			return checkRpmOstreeStatus(out)
+		},
+	}, true
+}
+
+// checkRpmOstreeStatus checks that the output of `rpm-ostree status --json`
+// has a booted deployment.
+func checkRpmOstreeStatus(out []byte) error {
+	var status rpmostreeclient.Status
+	if err := json.Unmarshal(out, &status); err != nil {
+		return fmt.Errorf("could not parse rpm-ostree status: %w", err)
+	}
+
+	if _, err := status.GetBootedDeployment(); err != nil {
+		return fmt.Errorf("rpm-ostree reports no booted deployment: %w", err)
+	}
+
+	return nil
+}
+
+// NewBootcHealthCheck checks that bootc responds, if it is installed on the
+// host mounted at rootMount. Returns false if it is not.
+func NewBootcHealthCheck(rootMount string) (HealthCheck, bool) {
+	if _, err := os.Stat(filepath.Join(rootMount, "/usr/bin/bootc")); err != nil {
+		return HealthCheck{}, false
+	}
+
+	return HealthCheck{
+		Name: "bootc",
+		Check: func(ctx context.Context) error {
+			out, err := runHostCommand(ctx, rootMount, "bootc", "status", "--json")
+			if err != nil {
+				return err
+			}
+
+			if !json.Valid(out) {
+				return fmt.Errorf("could not parse bootc status: %s", truncate(string(out), 256))
+			}
+
+			return nil
+		},
+	}, true
+}
+
+// runHostCommand runs a command in the host mounted at rootMount, returning
+// its output or an error which includes the end of its stderr.
+func runHostCommand(ctx context.Context, rootMount, command string, args ...string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, "chroot", append([]string{rootMount, command}, args...)...)
+	out, err := cmd.Output()
+	if err != nil {
+		errtext := ""
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			errtext = fmt.Sprintf(": %s", truncate(strings.TrimSpace(string(exitErr.Stderr)), 256))
+		}
+		return nil, fmt.Errorf("error running %s %s: %s%s", command, strings.Join(args, " "), err, errtext)
+	}
+
+	return out, nil
+}
@@ -0,0 +1,128 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/openshift/machine-config-operator/pkg/daemon/constants"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestRunHealthChecks(t *testing.T) {
+	pass := HealthCheck{Name: "pass", Check: func(context.Context) error { return nil }}
+	fail := HealthCheck{Name: "fail", Check: func(context.Context) error { return errors.New("root cause") }}
+	hang := HealthCheck{Name: "hang", Check: func(context.Context) error {
+		time.Sleep(time.Second)
+		return nil
+	}}
+
+	assert.NoError(t, RunHealthChecks(context.TODO(), []HealthCheck{pass}, time.Second))
+	assert.NoError(t, RunHealthChecks(context.TODO(), nil, time.Second))
+
+	err := RunHealthChecks(context.TODO(), []HealthCheck{fail, pass, hang}, 10*time.Millisecond)
+	assert.EqualError(t, err, "fail: root cause\nhang: did not respond within 10ms")
+}
+
+func TestCheckNodeAnnotations(t *testing.T) {
+	newNode := func(annotations map[string]string) *corev1.Node {
+		return &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-0", Annotations: annotations}}
+	}
+
+	assert.NoError(t, checkNodeAnnotations(newNode(map[string]string{
+		constants.MachineConfigDaemonStateAnnotationKey: constants.MachineConfigDaemonStateDone,
+		constants.CurrentMachineConfigAnnotationKey:     "rendered-worker-1",
+		constants.DesiredMachineConfigAnnotationKey:     "rendered-worker-1",
+	})))
+
+	assert.NoError(t, checkNodeAnnotations(newNode(map[string]string{
+		constants.MachineConfigDaemonStateAnnotationKey: constants.MachineConfigDaemonStateWorking,
+		constants.CurrentMachineConfigAnnotationKey:     "rendered-worker-1",
+		constants.DesiredMachineConfigAnnotationKey:     "rendered-worker-2",
+	})))
+
+	// A degraded daemon reports why it is degraded, so it is not unhealthy.
+	assert.NoError(t, checkNodeAnnotations(newNode(map[string]string{
+		constants.MachineConfigDaemonStateAnnotationKey: constants.MachineConfigDaemonStateDegraded,
+		constants.CurrentMachineConfigAnnotationKey:     "rendered-worker-1",
+		constants.DesiredMachineConfigAnnotationKey:     "rendered-worker-2",
+	})))
+
+	assert.ErrorContains(t, checkNodeAnnotations(newNode(nil)), "has not reported its state yet")
+
+	assert.ErrorContains(t, checkNodeAnnotations(newNode(map[string]string{
+		constants.MachineConfigDaemonStateAnnotationKey: constants.MachineConfigDaemonStateDone,
+	})), "has not reported its current config yet")
+
+	assert.ErrorContains(t, checkNodeAnnotations(newNode(map[string]string{
+		constants.MachineConfigDaemonStateAnnotationKey: constants.MachineConfigDaemonStateDone,
+		constants.CurrentMachineConfigAnnotationKey:     "rendered-worker-1",
+		constants.DesiredMachineConfigAnnotationKey:     "rendered-worker-2",
+	})), "the daemon is not acting on the update")
+
+	// A node rolled back on request is held at its current config.
+	assert.NoError(t, checkNodeAnnotations(newNode(map[string]string{
+		constants.MachineConfigDaemonStateAnnotationKey: constants.MachineConfigDaemonStateDone,
+		constants.CurrentMachineConfigAnnotationKey:     "rendered-worker-1",
+		constants.DesiredMachineConfigAnnotationKey:     "rendered-worker-2",
+		constants.NodeStatusAnnotationKey:               `{"lastRollback":{"request":"1","config":"rendered-worker-2","rolledBackTo":"rendered-worker-1","time":"2024-01-01T12:00:00Z"}}`,
+	})))
+
+	// Updates which the daemon deliberately does not start yet.
+	waiting := map[string]map[string]string{
+		"held": {
+			constants.HoldAnnotationKey: "true",
+		},
+		"staged": {
+			constants.NodeStatusAnnotationKey: `{"stagedUpdate":{"config":"rendered-worker-2","finalization":"Locked","time":"2024-01-01T12:00:00Z"}}`,
+		},
+		"waiting for a maintenance window": {
+			constants.NodeStatusAnnotationKey: `{"pendingUpdate":{"config":"rendered-worker-2","actions":["reboot"],"windowOpens":"2024-01-02T02:00:00Z"}}`,
+		},
+		"skipped by preflight checks": {
+			constants.NodeStatusAnnotationKey: `{"preflightCheckFailure":{"config":"rendered-worker-2","failures":["/var has 1024 bytes free, less than the required 2048"],"time":"2024-01-01T12:00:00Z"}}`,
+		},
+	}
+
+	for name, annotations := range waiting {
+		annotations[constants.MachineConfigDaemonStateAnnotationKey] = constants.MachineConfigDaemonStateDone
+		annotations[constants.CurrentMachineConfigAnnotationKey] = "rendered-worker-1"
+		annotations[constants.DesiredMachineConfigAnnotationKey] = "rendered-worker-2"
+		assert.NoError(t, checkNodeAnnotations(newNode(annotations)), name)
+	}
+
+	// A pending update for an older config does not count.
+	assert.ErrorContains(t, checkNodeAnnotations(newNode(map[string]string{
+		constants.MachineConfigDaemonStateAnnotationKey: constants.MachineConfigDaemonStateDone,
+		constants.CurrentMachineConfigAnnotationKey:     "rendered-worker-1",
+		constants.DesiredMachineConfigAnnotationKey:     "rendered-worker-3",
+		constants.NodeStatusAnnotationKey:               `{"pendingUpdate":{"config":"rendered-worker-2","actions":["reboot"],"windowOpens":"2024-01-02T02:00:00Z"}}`,
+	})), "the daemon is not acting on the update")
+}
+
+func TestNodeHealthChecks(t *testing.T) {
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-0"}}
+	kubeClient := fake.NewSimpleClientset(node)
+
+	assert.NoError(t, NewAPIHealthCheck(kubeClient, "node-0").Check(context.TODO()))
+	assert.ErrorContains(t, NewAPIHealthCheck(kubeClient, "node-1").Check(context.TODO()), "could not reach the API server")
+	assert.ErrorContains(t, NewAnnotationsHealthCheck(kubeClient, "node-0").Check(context.TODO()), "has not reported its state yet")
+}
+
+func TestCheckRpmOstreeStatus(t *testing.T) {
+	assert.NoError(t, checkRpmOstreeStatus([]byte(`{"deployments":[{"id":"a","booted":false},{"id":"b","booted":true}]}`)))
+	assert.ErrorContains(t, checkRpmOstreeStatus([]byte(`{"deployments":[{"id":"a","booted":false}]}`)), "no booted deployment")
+	assert.ErrorContains(t, checkRpmOstreeStatus([]byte(`error: not an ostree system`)), "could not parse rpm-ostree status")
+}
+
+func TestNewRpmOstreeHealthCheck(t *testing.T) {
+	_, ok := NewRpmOstreeHealthCheck(t.TempDir())
+	assert.False(t, ok)
+
+	_, ok = NewBootcHealthCheck(t.TempDir())
+	assert.False(t, ok)
+}
@@ -0,0 +1,69 @@
+package daemon
+
+import (
+	"fmt"
+
+	"github.com/clarketm/json"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	"github.com/openshift/machine-config-operator/pkg/daemon/constants"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+)
+
+// heldUpdateReport is what the MCD reports under HeldUpdateAnnotationKey.
+type heldUpdateReport struct {
+	Config string `json:"config"`
+	Image  string `json:"image,omitempty"`
+}
+
+// checkHold returns whether the update is held because an admin holds the
+// node with HoldAnnotationKey, e.g. since the node controller selected it
+// before the hold was set. The held update is reported in the node status,
+// and the node is synced again when the hold is lifted, since that updates
+// the node.
+func (dn *Daemon) checkHold(ufc *updateFromCluster) (bool, error) {
+	if !ctrlcommon.IsNodeHeld(dn.node) {
+		return false, dn.reportHeldUpdate(nil)
+	}
+
+	held := &heldUpdateReport{
+		Config: ufc.desiredConfig.GetName(),
+		Image:  ufc.desiredImage,
+	}
+
+	klog.Infof("Not updating to %s, the node is held by the %s annotation", held.Config, constants.HoldAnnotationKey)
+
+	return true, dn.reportHeldUpdate(held)
+}
+
+// reportHeldUpdate records the held update in the node status under
+// HeldUpdateAnnotationKey, or clears it when nil.
+func (dn *Daemon) reportHeldUpdate(held *heldUpdateReport) error {
+	if dn.nodeWriter == nil {
+		return nil
+	}
+
+	val := ""
+	if held != nil {
+		out, err := json.Marshal(held)
+		if err != nil {
+			return fmt.Errorf("could not encode held update: %w", err)
+		}
+		val = string(out)
+	}
+
+	reported, ok := ctrlcommon.GetNodeStatus(dn.node, constants.HeldUpdateAnnotationKey)
+	if reported == val && (ok || val == "") {
+		return nil
+	}
+
+	if _, err := dn.nodeWriter.SetNodeStatus(map[string]string{constants.HeldUpdateAnnotationKey: val}); err != nil {
+		return fmt.Errorf("could not report held update: %w", err)
+	}
+
+	if held != nil {
+		dn.nodeWriter.Eventf(corev1.EventTypeNormal, "UpdateHeld", "Update to %s is held by the %s annotation", held.Config, constants.HoldAnnotationKey)
+	}
+
+	return nil
+}
@@ -0,0 +1,101 @@
+package daemon
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/containers/image/v5/docker"
+	"github.com/containers/image/v5/image"
+	"github.com/containers/image/v5/signature"
+	"github.com/containers/image/v5/types"
+	"github.com/openshift/machine-config-operator/pkg/daemon/constants"
+	"k8s.io/klog/v2"
+)
+
+// Tells containers/image to look for sigstore signatures attached to the image
+// in the registry, which is where the build controller stores them.
+const sigstoreAttachmentsRegistriesConfig = "default-docker:\n  use-sigstore-attachments: true\n"
+
+// verifyImageSignature ensures the given image has a sigstore signature which
+// can be verified with the public key the build controller signed it with, if
+// any. Unsigned images are allowed when no public key is set on the node.
+func (dn *Daemon) verifyImageSignature(imageName string) error {
+	if dn.node == nil {
+		return nil
+	}
+
+	publicKey := dn.node.Annotations[constants.DesiredImageSigningPublicKeyAnnotationKey]
+	if publicKey == "" {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	if err := verifySigstoreSignature(ctx, imageName, []byte(publicKey), &types.SystemContext{AuthFilePath: ostreeAuthFile}); err != nil {
+		return fmt.Errorf("could not verify signature of image %s: %w", imageName, err)
+	}
+
+	logSystem("Verified signature of image %s", imageName)
+	return nil
+}
+
+// verifySigstoreSignature checks the image against a policy which only accepts
+// sigstore signatures made by the given public key.
+func verifySigstoreSignature(ctx context.Context, imageName string, publicKey []byte, sys *types.SystemContext) error {
+	requirement, err := signature.NewPRSigstoreSignedKeyData(publicKey, signature.NewPRMMatchRepoDigestOrExact())
+	if err != nil {
+		return fmt.Errorf("could not create signature policy: %w", err)
+	}
+
+	policyContext, err := signature.NewPolicyContext(&signature.Policy{Default: signature.PolicyRequirements{requirement}})
+	if err != nil {
+		return fmt.Errorf("could not create signature policy context: %w", err)
+	}
+
+	defer func() {
+		if err := policyContext.Destroy(); err != nil {
+			klog.Warningf("Could not destroy signature policy context: %v", err)
+		}
+	}()
+
+	registriesDir, err := os.MkdirTemp("", "mcd-registries.d")
+	if err != nil {
+		return err
+	}
+
+	defer os.RemoveAll(registriesDir)
+
+	if err := os.WriteFile(filepath.Join(registriesDir, "sigstore-attachments.yaml"), []byte(sigstoreAttachmentsRegistriesConfig), 0o644); err != nil {
+		return err
+	}
+
+	copied := *sys
+	copied.RegistriesDirPath = registriesDir
+
+	ref, err := docker.ParseReference("//" + imageName)
+	if err != nil {
+		return fmt.Errorf("could not parse image %q: %w", imageName, err)
+	}
+
+	src, err := ref.NewImageSource(ctx, &copied)
+	if err != nil {
+		return err
+	}
+
+	defer src.Close()
+
+	allowed, err := policyContext.IsRunningImageAllowed(ctx, image.UnparsedInstance(src, nil))
+	if err != nil {
+		return err
+	}
+
+	if !allowed {
+		return fmt.Errorf("image %s was rejected by the signature policy", imageName)
+	}
+
+	return nil
+}
@@ -0,0 +1,390 @@
+package daemon
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+
+	ign3types "github.com/coreos/ignition/v2/config/v3_4/types"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	"k8s.io/klog/v2"
+)
+
+// luksKeyDir is where the MCD briefly stores LUKS keys while changing them.
+// It is a tmpfs, so that the keys never reach a disk.
+const luksKeyDir = "/run"
+
+// clevisBindingRegexp matches a binding in the output of "clevis luks list",
+// e.g. 1: sss '{"t":1,"pins":{"tang":[{"url":"http://tang.example.com"}]}}'
+var clevisBindingRegexp = regexp.MustCompile(`^(\d+): (\S+) '(.*)'$`)
+
+// clevisTang is a Tang server in the configuration of the Clevis sss pin.
+type clevisTang struct {
+	URL           string          `json:"url"`
+	Thumbprint    string          `json:"thp,omitempty"`
+	Advertisement json.RawMessage `json:"adv,omitempty"`
+}
+
+// clevisPins are the pins of the Clevis sss pin.
+type clevisPins struct {
+	Tang []clevisTang `json:"tang,omitempty"`
+	Tpm2 *struct{}    `json:"tpm2,omitempty"`
+}
+
+// clevisConfig is the configuration of the Clevis sss pin, which Ignition
+// binds LUKS volumes with.
+type clevisConfig struct {
+	Threshold int        `json:"t"`
+	Pins      clevisPins `json:"pins"`
+}
+
+// clevisBinding is a Clevis binding of a LUKS volume.
+type clevisBinding struct {
+	slot   string
+	pin    string
+	config string
+}
+
+// hasClevisPins returns whether the Clevis config binds the volume to Tang
+// servers or the TPM.
+func hasClevisPins(c ign3types.Clevis) bool {
+	return len(c.Tang) != 0 || (c.Tpm2 != nil && *c.Tpm2)
+}
+
+// getClevisConfig returns the configuration of the sss pin which Ignition
+// binds a LUKS volume with for the Clevis config.
+func getClevisConfig(c ign3types.Clevis) (string, error) {
+	cfg := clevisConfig{Threshold: 1}
+	if c.Threshold != nil {
+		cfg.Threshold = *c.Threshold
+	}
+
+	for _, tang := range c.Tang {
+		t := clevisTang{URL: tang.URL}
+		if tang.Thumbprint != nil {
+			t.Thumbprint = *tang.Thumbprint
+		}
+		if tang.Advertisement != nil && *tang.Advertisement != "" {
+			t.Advertisement = json.RawMessage(*tang.Advertisement)
+		}
+		cfg.Pins.Tang = append(cfg.Pins.Tang, t)
+	}
+
+	if c.Tpm2 != nil && *c.Tpm2 {
+		cfg.Pins.Tpm2 = &struct{}{}
+	}
+
+	out, err := json.Marshal(cfg)
+	if err != nil {
+		return "", fmt.Errorf("could not encode Clevis config: %w", err)
+	}
+
+	return string(out), nil
+}
+
+// getLUKSVolumePairs returns the LUKS volumes of the new config along with the
+// volume of the same name in the old config. Ignition only sets up volumes
+// when it provisions the node, so volumes which are added to or removed from
+// the config are left alone.
+func getLUKSVolumePairs(oldLuks, newLuks []ign3types.Luks) [][2]ign3types.Luks {
+	pairs := [][2]ign3types.Luks{}
+	for _, newVolume := range newLuks {
+		for _, oldVolume := range oldLuks {
+			if oldVolume.Name == newVolume.Name {
+				pairs = append(pairs, [2]ign3types.Luks{oldVolume, newVolume})
+				break
+			}
+		}
+	}
+	return pairs
+}
+
+// isLUKSProvisioningChange returns whether the volumes differ in more than
+// their Tang servers, Clevis threshold, TPM binding and key file, which only
+// apply when Ignition provisions the node.
+func isLUKSProvisioningChange(oldVolume, newVolume ign3types.Luks) bool {
+	for _, volume := range []*ign3types.Luks{&oldVolume, &newVolume} {
+		volume.Clevis.Tang = nil
+		volume.Clevis.Threshold = nil
+		volume.Clevis.Tpm2 = nil
+		volume.KeyFile = ign3types.Resource{}
+	}
+	return !reflect.DeepEqual(oldVolume, newVolume)
+}
+
+// verifyLUKSChanges returns nil if the changes to the Tang servers, Clevis
+// threshold, TPM binding and key files of the LUKS volumes can be applied in
+// place: the Clevis binding of a volume can be changed but not added or
+// removed, and a volume without one has to keep a key file.
+func verifyLUKSChanges(oldLuks, newLuks []ign3types.Luks) error {
+	for _, pair := range getLUKSVolumePairs(oldLuks, newLuks) {
+		oldVolume, newVolume := pair[0], pair[1]
+
+		if hasClevisPins(oldVolume.Clevis) != hasClevisPins(newVolume.Clevis) {
+			return fmt.Errorf("ignition luks section contains changes: the Clevis binding of LUKS volume %s cannot be added or removed", newVolume.Name)
+		}
+
+		if !hasClevisPins(newVolume.Clevis) && oldVolume.KeyFile.Source != nil && newVolume.KeyFile.Source == nil {
+			return fmt.Errorf("ignition luks section contains changes: the key file of LUKS volume %s cannot be removed without a Clevis binding", newVolume.Name)
+		}
+	}
+
+	return nil
+}
+
+// parseClevisBindings parses the output of "clevis luks list".
+func parseClevisBindings(out []byte) []clevisBinding {
+	bindings := []clevisBinding{}
+
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		if m := clevisBindingRegexp.FindStringSubmatch(scanner.Text()); m != nil {
+			bindings = append(bindings, clevisBinding{slot: m[1], pin: m[2], config: m[3]})
+		}
+	}
+
+	return bindings
+}
+
+// getTangURLs returns the sorted URLs of the Tang servers of the sss pin
+// configuration.
+func getTangURLs(config string) ([]string, bool, error) {
+	cfg := clevisConfig{}
+	if err := json.Unmarshal([]byte(config), &cfg); err != nil {
+		return nil, false, err
+	}
+
+	urls := []string{}
+	for _, tang := range cfg.Pins.Tang {
+		urls = append(urls, tang.URL)
+	}
+	sort.Strings(urls)
+
+	return urls, cfg.Pins.Tpm2 != nil, nil
+}
+
+// findClevisSlot returns the key slot of the binding Ignition created for the
+// Clevis config, which binds to the same Tang servers and TPM. A volume with
+// a single binding is assumed to be bound by Ignition.
+func findClevisSlot(bindings []clevisBinding, c ign3types.Clevis) (string, error) {
+	config, err := getClevisConfig(c)
+	if err != nil {
+		return "", err
+	}
+
+	wantURLs, wantTpm2, err := getTangURLs(config)
+	if err != nil {
+		return "", err
+	}
+
+	for _, binding := range bindings {
+		if binding.pin != "sss" {
+			continue
+		}
+		urls, tpm2, err := getTangURLs(binding.config)
+		if err != nil {
+			klog.Warningf("Ignoring Clevis binding in slot %s: %v", binding.slot, err)
+			continue
+		}
+		if reflect.DeepEqual(urls, wantURLs) && tpm2 == wantTpm2 {
+			return binding.slot, nil
+		}
+	}
+
+	if len(bindings) == 1 {
+		return bindings[0].slot, nil
+	}
+
+	return "", fmt.Errorf("none of the %d Clevis bindings matches the previous config %s", len(bindings), config)
+}
+
+// writeLUKSKey writes a LUKS key to a private file in luksKeyDir. The caller
+// removes it.
+func writeLUKSKey(key []byte) (string, error) {
+	f, err := os.CreateTemp(luksKeyDir, "mco-luks-key-")
+	if err != nil {
+		return "", fmt.Errorf("could not create LUKS key file: %w", err)
+	}
+	defer f.Close()
+
+	if err := f.Chmod(0o600); err != nil {
+		os.Remove(f.Name())
+		return "", fmt.Errorf("could not create LUKS key file: %w", err)
+	}
+	if _, err := f.Write(key); err != nil {
+		os.Remove(f.Name())
+		return "", fmt.Errorf("could not write LUKS key file: %w", err)
+	}
+
+	return f.Name(), nil
+}
+
+// decodeLUKSKeyFile returns the key of the key file of a LUKS volume, or nil
+// if it has none.
+func decodeLUKSKeyFile(keyFile ign3types.Resource) ([]byte, error) {
+	if keyFile.Source == nil {
+		return nil, nil
+	}
+
+	return ctrlcommon.DecodeIgnitionFileContents(keyFile.Source, keyFile.Compression)
+}
+
+// updateLUKSClevisBinding rebinds the volume to the new Tang servers, Clevis
+// threshold or TPM binding, and checks that the new binding unlocks it.
+// "clevis luks edit" binds the new config before it removes the old one, so
+// that the volume can be unlocked throughout. Returns the slot of the binding.
+func updateLUKSClevisBinding(device string, oldClevis, newClevis ign3types.Clevis) (string, error) {
+	out, err := runGetOut("clevis", "luks", "list", "-d", device)
+	if err != nil {
+		return "", fmt.Errorf("could not list Clevis bindings of %s: %w", device, err)
+	}
+
+	slot, err := findClevisSlot(parseClevisBindings(out), oldClevis)
+	if err != nil {
+		return "", fmt.Errorf("could not find Clevis binding of %s: %w", device, err)
+	}
+
+	if reflect.DeepEqual(oldClevis, newClevis) {
+		return slot, nil
+	}
+
+	config, err := getClevisConfig(newClevis)
+	if err != nil {
+		return "", err
+	}
+
+	logSystem("Rebinding LUKS volume %s in slot %s to %s", device, slot, config)
+
+	if err := runCmdSync("clevis", "luks", "edit", "-f", "-d", device, "-s", slot, "-c", config); err != nil {
+		return "", fmt.Errorf("could not rebind %s: %w", device, err)
+	}
+
+	// The slot of the binding may change, so look it up again.
+	out, err = runGetOut("clevis", "luks", "list", "-d", device)
+	if err != nil {
+		return "", fmt.Errorf("could not list Clevis bindings of %s: %w", device, err)
+	}
+
+	if slot, err = findClevisSlot(parseClevisBindings(out), newClevis); err != nil {
+		return "", fmt.Errorf("could not find new Clevis binding of %s: %w", device, err)
+	}
+
+	// The output is the key, which must not be logged.
+	if _, err := runGetOut("clevis", "luks", "pass", "-d", device, "-s", slot); err != nil {
+		return "", fmt.Errorf("new Clevis binding of %s does not unlock it: %w", device, err)
+	}
+
+	return slot, nil
+}
+
+// updateLUKSKeyFile replaces the key of the old key file of the volume with
+// the key of the new one. The new key is added before the old one is removed,
+// unlocking the volume with the old key file or, if it had none, with its
+// Clevis binding in the slot.
+func updateLUKSKeyFile(device, clevisSlot string, oldKeyFile, newKeyFile ign3types.Resource) error {
+	if reflect.DeepEqual(oldKeyFile, newKeyFile) {
+		return nil
+	}
+
+	oldKey, err := decodeLUKSKeyFile(oldKeyFile)
+	if err != nil {
+		return fmt.Errorf("could not decode previous key file of %s: %w", device, err)
+	}
+	newKey, err := decodeLUKSKeyFile(newKeyFile)
+	if err != nil {
+		return fmt.Errorf("could not decode key file of %s: %w", device, err)
+	}
+
+	if newKey != nil {
+		unlockKey := oldKey
+		if unlockKey == nil {
+			if unlockKey, err = runGetOut("clevis", "luks", "pass", "-d", device, "-s", clevisSlot); err != nil {
+				return fmt.Errorf("could not unlock %s with its Clevis binding: %w", device, err)
+			}
+		}
+
+		unlockKeyPath, err := writeLUKSKey(unlockKey)
+		if err != nil {
+			return err
+		}
+		defer os.Remove(unlockKeyPath)
+
+		newKeyPath, err := writeLUKSKey(newKey)
+		if err != nil {
+			return err
+		}
+		defer os.Remove(newKeyPath)
+
+		logSystem("Adding new key to LUKS volume %s", device)
+		if err := runCmdSync("cryptsetup", "luksAddKey", "--batch-mode", "--key-file", unlockKeyPath, device, newKeyPath); err != nil {
+			return fmt.Errorf("could not add key to %s: %w", device, err)
+		}
+
+		if err := runCmdSync("cryptsetup", "open", "--test-passphrase", "--key-file", newKeyPath, device); err != nil {
+			return fmt.Errorf("new key does not unlock %s: %w", device, err)
+		}
+	}
+
+	if oldKey != nil {
+		oldKeyPath, err := writeLUKSKey(oldKey)
+		if err != nil {
+			return err
+		}
+		defer os.Remove(oldKeyPath)
+
+		logSystem("Removing previous key from LUKS volume %s", device)
+		if err := runCmdSync("cryptsetup", "luksRemoveKey", "--batch-mode", device, oldKeyPath); err != nil {
+			return fmt.Errorf("could not remove previous key from %s: %w", device, err)
+		}
+	}
+
+	return nil
+}
+
+// updateLUKS applies the changes to the Clevis bindings and key files of the
+// LUKS volumes, which reconcilable checked. The volumes are unlocked with them
+// on the next boot, so that the node does not have to reboot now.
+func (dn *Daemon) updateLUKS(oldIgnConfig, newIgnConfig ign3types.Config) error {
+	if dn.mock {
+		return nil
+	}
+
+	for _, pair := range getLUKSVolumePairs(oldIgnConfig.Storage.Luks, newIgnConfig.Storage.Luks) {
+		oldVolume, newVolume := pair[0], pair[1]
+		if reflect.DeepEqual(oldVolume, newVolume) {
+			continue
+		}
+
+		if isLUKSProvisioningChange(oldVolume, newVolume) {
+			klog.Warningf("Ignoring changes to LUKS volume %s other than to its Clevis binding and key file, they only apply when the node is provisioned", newVolume.Name)
+		}
+
+		if newVolume.Device == nil {
+			return fmt.Errorf("LUKS volume %s has no device", newVolume.Name)
+		}
+		device := *newVolume.Device
+
+		klog.Infof("Updating LUKS volume %s on %s", newVolume.Name, device)
+
+		slot := ""
+		if hasClevisPins(newVolume.Clevis) {
+			var err error
+			if slot, err = updateLUKSClevisBinding(device, oldVolume.Clevis, newVolume.Clevis); err != nil {
+				return err
+			}
+		}
+
+		if err := updateLUKSKeyFile(device, slot, oldVolume.KeyFile, newVolume.KeyFile); err != nil {
+			return err
+		}
+
+		logSystem("Updated LUKS volume %s on %s", newVolume.Name, device)
+	}
+
+	return nil
+}
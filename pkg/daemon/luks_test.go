@@ -0,0 +1,149 @@
+package daemon
+
+import (
+	"testing"
+
+	ign3types "github.com/coreos/ignition/v2/config/v3_4/types"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	"github.com/openshift/machine-config-operator/test/helpers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newRootLuks(tangURLs ...string) ign3types.Luks {
+	luks := ign3types.Luks{
+		Name:       "root",
+		Device:     helpers.StrToPtr("/dev/disk/by-partlabel/root"),
+		Label:      helpers.StrToPtr("luks-root"),
+		WipeVolume: helpers.BoolToPtr(true),
+	}
+	for _, url := range tangURLs {
+		luks.Clevis.Tang = append(luks.Clevis.Tang, ign3types.Tang{URL: url, Thumbprint: helpers.StrToPtr("thp-" + url)})
+	}
+	return luks
+}
+
+func TestGetClevisConfig(t *testing.T) {
+	config, err := getClevisConfig(newRootLuks("http://tang1", "http://tang2").Clevis)
+	require.NoError(t, err)
+	assert.Equal(t, `{"t":1,"pins":{"tang":[{"url":"http://tang1","thp":"thp-http://tang1"},{"url":"http://tang2","thp":"thp-http://tang2"}]}}`, config)
+
+	config, err = getClevisConfig(ign3types.Clevis{Tpm2: helpers.BoolToPtr(true), Threshold: helpers.IntToPtr(2), Tang: []ign3types.Tang{{URL: "http://tang1"}}})
+	require.NoError(t, err)
+	assert.Equal(t, `{"t":2,"pins":{"tang":[{"url":"http://tang1"}],"tpm2":{}}}`, config)
+}
+
+func TestVerifyLUKSChanges(t *testing.T) {
+	withKeyFile := func(luks ign3types.Luks, key string) ign3types.Luks {
+		luks.KeyFile = ign3types.Resource{Source: helpers.StrToPtr("data:," + key)}
+		return luks
+	}
+	withoutClevis := func(luks ign3types.Luks) ign3types.Luks {
+		luks.Clevis = ign3types.Clevis{}
+		return luks
+	}
+
+	tests := []struct {
+		name        string
+		oldLuks     []ign3types.Luks
+		newLuks     []ign3types.Luks
+		errExpected bool
+	}{
+		{
+			name:    "no volumes",
+			oldLuks: nil,
+			newLuks: []ign3types.Luks{},
+		},
+		{
+			name:    "Tang servers rotated",
+			oldLuks: []ign3types.Luks{newRootLuks("http://tang1")},
+			newLuks: []ign3types.Luks{newRootLuks("http://tang2", "http://tang3")},
+		},
+		{
+			name:    "key file rotated",
+			oldLuks: []ign3types.Luks{withKeyFile(newRootLuks("http://tang1"), "old")},
+			newLuks: []ign3types.Luks{withKeyFile(newRootLuks("http://tang1"), "new")},
+		},
+		{
+			name:    "key file rotated without Clevis",
+			oldLuks: []ign3types.Luks{withKeyFile(withoutClevis(newRootLuks()), "old")},
+			newLuks: []ign3types.Luks{withKeyFile(withoutClevis(newRootLuks()), "new")},
+		},
+		{
+			name:        "key file removed without Clevis",
+			oldLuks:     []ign3types.Luks{withKeyFile(withoutClevis(newRootLuks()), "old")},
+			newLuks:     []ign3types.Luks{withoutClevis(newRootLuks())},
+			errExpected: true,
+		},
+		{
+			name:        "Clevis binding removed",
+			oldLuks:     []ign3types.Luks{withKeyFile(newRootLuks("http://tang1"), "old")},
+			newLuks:     []ign3types.Luks{withKeyFile(withoutClevis(newRootLuks()), "old")},
+			errExpected: true,
+		},
+		{
+			name:    "volume added",
+			oldLuks: []ign3types.Luks{},
+			newLuks: []ign3types.Luks{newRootLuks("http://tang1")},
+		},
+		{
+			name:    "volume removed",
+			oldLuks: []ign3types.Luks{newRootLuks("http://tang1")},
+			newLuks: nil,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			err := verifyLUKSChanges(test.oldLuks, test.newLuks)
+			if test.errExpected {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestIsLUKSProvisioningChange(t *testing.T) {
+	assert.False(t, isLUKSProvisioningChange(newRootLuks("http://tang1"), newRootLuks("http://tang2")))
+
+	relabeled := newRootLuks("http://tang1")
+	relabeled.Label = helpers.StrToPtr("root")
+	assert.True(t, isLUKSProvisioningChange(newRootLuks("http://tang1"), relabeled))
+}
+
+func TestFindClevisSlot(t *testing.T) {
+	out := []byte(`1: sss '{"t":1,"pins":{"tang":[{"url":"http://tang1"}]}}'
+2: sss '{"t":1,"pins":{"tang":[{"url":"http://tang2"},{"url":"http://tang3"}]}}'
+3: tpm2 '{"hash":"sha256","key":"ecc"}'
+`)
+
+	bindings := parseClevisBindings(out)
+	assert.Len(t, bindings, 3)
+
+	slot, err := findClevisSlot(bindings, newRootLuks("http://tang3", "http://tang2").Clevis)
+	require.NoError(t, err)
+	assert.Equal(t, "2", slot)
+
+	_, err = findClevisSlot(bindings, newRootLuks("http://tang4").Clevis)
+	assert.Error(t, err)
+
+	// A single binding is the one Ignition created.
+	slot, err = findClevisSlot(bindings[:1], newRootLuks("http://tang4").Clevis)
+	require.NoError(t, err)
+	assert.Equal(t, "1", slot)
+}
+
+func TestLUKSChangesSkipReboot(t *testing.T) {
+	oldIgnCfg := ctrlcommon.NewIgnConfig()
+	oldIgnCfg.Storage.Luks = []ign3types.Luks{newRootLuks("http://tang1")}
+	newIgnCfg := ctrlcommon.NewIgnConfig()
+	newIgnCfg.Storage.Luks = []ign3types.Luks{newRootLuks("http://tang2")}
+
+	diff, err := reconcilable(helpers.CreateMachineConfigFromIgnition(oldIgnCfg), helpers.CreateMachineConfigFromIgnition(newIgnCfg))
+	require.NoError(t, err)
+	assert.True(t, diff.luks)
+	assert.Equal(t, []string{postConfigChangeActionNone}, calculatePostConfigChangeActionFromDiff(diff, nil, nil))
+}
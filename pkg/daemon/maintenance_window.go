@@ -0,0 +1,140 @@
+package daemon
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/clarketm/json"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	"github.com/openshift/machine-config-operator/pkg/daemon/constants"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+)
+
+// pendingUpdateReport is what the MCD reports under PendingUpdateAnnotationKey.
+type pendingUpdateReport struct {
+	Config      string   `json:"config"`
+	Image       string   `json:"image,omitempty"`
+	Actions     []string `json:"actions"`
+	WindowOpens string   `json:"windowOpens"`
+}
+
+// getNodeMaintenanceWindows returns the maintenance windows which the node
+// controller handed to the node in the MaintenanceWindowsAnnotationKey
+// annotation.
+func getNodeMaintenanceWindows(node *corev1.Node) []ctrlcommon.MaintenanceWindow {
+	windows, err := ctrlcommon.ParseMaintenanceWindows(node.Annotations[constants.MaintenanceWindowsAnnotationKey])
+	if err != nil {
+		klog.Warningf("Ignoring invalid %s annotation: %v", constants.MaintenanceWindowsAnnotationKey, err)
+		return nil
+	}
+
+	return windows
+}
+
+// getUpdateActions returns the post config change actions the update would
+// take with the node's disruption policy. Updates to a new layered OS image
+// always reboot the node.
+func getUpdateActions(ufc *updateFromCluster, policy *ctrlcommon.DisruptionPolicy) ([]string, error) {
+	if ufc.currentImage != ufc.desiredImage {
+		return []string{postConfigChangeActionReboot}, nil
+	}
+
+	diff, err := reconcilable(ufc.currentConfig, ufc.desiredConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	oldIgnConfig, err := ctrlcommon.ParseAndConvertConfig(ufc.currentConfig.Spec.Config.Raw)
+	if err != nil {
+		return nil, fmt.Errorf("parsing old Ignition config failed: %w", err)
+	}
+	newIgnConfig, err := ctrlcommon.ParseAndConvertConfig(ufc.desiredConfig.Spec.Config.Raw)
+	if err != nil {
+		return nil, fmt.Errorf("parsing new Ignition config failed: %w", err)
+	}
+
+	diffFileSet := ctrlcommon.CalculateConfigFileDiffs(&oldIgnConfig, &newIgnConfig)
+	return ctrlcommon.CheckContainerStorageConfChanges(calculatePostConfigChangeActionFromDiff(diff, diffFileSet, policy), diffFileSet, oldIgnConfig, newIgnConfig)
+}
+
+// waitForMaintenanceWindow returns whether the update has to wait for the
+// next maintenance window of the node, because it reboots the node and no
+// window is open. The waiting update is reported in the node status and the
+// node is synced again once the window opens. Updates which cannot be applied
+// are not held back, so that update() reports why. The forcefile skips the
+// wait since it asks for the update to be applied right away.
+func (dn *Daemon) waitForMaintenanceWindow(ufc *updateFromCluster) (bool, error) {
+	windows := getNodeMaintenanceWindows(dn.node)
+
+	wait := false
+	var actions []string
+
+	if len(windows) != 0 && !forceFileExists() {
+		var err error
+		if actions, err = getUpdateActions(ufc, getNodeDisruptionPolicy(dn.node)); err != nil {
+			klog.Warningf("Could not tell whether the update to %s reboots the node: %v", ufc.desiredConfig.GetName(), err)
+		} else {
+			wait = ctrlcommon.InSlice(postConfigChangeActionReboot, actions)
+		}
+	}
+
+	now := time.Now()
+
+	if !wait || ctrlcommon.InMaintenanceWindow(windows, now) {
+		return false, dn.reportPendingUpdate(nil)
+	}
+
+	until := ctrlcommon.UntilMaintenanceWindow(windows, now)
+
+	pending := &pendingUpdateReport{
+		Config:      ufc.desiredConfig.GetName(),
+		Image:       ufc.desiredImage,
+		Actions:     actions,
+		WindowOpens: now.Add(until).UTC().Format(time.RFC3339),
+	}
+
+	klog.Infof("Update to %s reboots the node, waiting %s for the next maintenance window at %s", pending.Config, until.Round(time.Second), pending.WindowOpens)
+
+	if err := dn.reportPendingUpdate(pending); err != nil {
+		return false, err
+	}
+
+	dn.queue.AddAfter(dn.name, until)
+
+	return true, nil
+}
+
+// reportPendingUpdate records the update which waits for the next
+// maintenance window in the node status, or clears it when pending is nil.
+// An event is emitted when an update starts waiting.
+func (dn *Daemon) reportPendingUpdate(pending *pendingUpdateReport) error {
+	if dn.nodeWriter == nil {
+		return nil
+	}
+
+	val := ""
+	if pending != nil {
+		out, err := json.Marshal(pending)
+		if err != nil {
+			return fmt.Errorf("could not encode pending update: %w", err)
+		}
+		val = string(out)
+	}
+
+	reported, ok := ctrlcommon.GetNodeStatus(dn.node, constants.PendingUpdateAnnotationKey)
+	if reported == val && (ok || val == "") {
+		return nil
+	}
+
+	if _, err := dn.nodeWriter.SetNodeStatus(map[string]string{constants.PendingUpdateAnnotationKey: val}); err != nil {
+		return fmt.Errorf("could not report pending update: %w", err)
+	}
+
+	if pending != nil {
+		dn.nodeWriter.Eventf(corev1.EventTypeNormal, "UpdateWaitingForMaintenanceWindow",
+			"Update to %s reboots the node and waits for the next maintenance window at %s", pending.Config, pending.WindowOpens)
+	}
+
+	return nil
+}
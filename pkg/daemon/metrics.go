@@ -50,6 +50,34 @@ var (
 			Name: "mcd_update_state",
 			Help: "completed update config or error",
 		}, []string{"config", "err"})
+
+	// mcdRpmOstreeRecoveries tallys attempts to recover from hung rpm-ostree transactions
+	mcdRpmOstreeRecoveries = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "mcd_rpm_ostree_recoveries_total",
+			Help: "Total number of attempts to recover from hung rpm-ostree transactions.",
+		}, []string{"action"})
+
+	// mcdConfigDrift flags the paths which drifted from the current MachineConfig, by what drifted
+	mcdConfigDrift = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "mcd_config_drift",
+			Help: "Paths which drifted from the current MachineConfig, by what drifted (content, permissions or sysctl).",
+		}, []string{"path", "mode"})
+
+	// mcdConfigDriftEvents tallys detected drift of each path from the current MachineConfig
+	mcdConfigDriftEvents = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "mcd_config_drift_events_total",
+			Help: "Total number of times drift of each path from the current MachineConfig was detected.",
+		}, []string{"path", "mode"})
+
+	// mcdConfigSourceFallbacks tallys MachineConfigs fetched from the MachineConfigServer instead of the apiserver
+	mcdConfigSourceFallbacks = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "mcd_config_source_fallbacks_total",
+			Help: "Total number of MachineConfigs fetched from the MachineConfigServer because the apiserver did not provide them.",
+		})
 )
 
 // Updates metric with new labels & timestamp, deletes any existing
@@ -70,6 +98,10 @@ func RegisterMCDMetrics() error {
 		kubeletHealthState,
 		mcdRebootErr,
 		mcdUpdateState,
+		mcdRpmOstreeRecoveries,
+		mcdConfigSourceFallbacks,
+		mcdConfigDrift,
+		mcdConfigDriftEvents,
 	})
 
 	if err != nil {
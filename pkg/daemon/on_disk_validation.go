@@ -14,8 +14,9 @@ import (
 	"k8s.io/klog/v2"
 )
 
-// Validates that the on-disk state matches a given MachineConfig.
-func validateOnDiskState(currentConfig *mcfgv1.MachineConfig, systemdPath string) error {
+// Validates that the on-disk state matches a given MachineConfig. Files covered
+// by the given unmanaged paths are not validated.
+func validateOnDiskState(currentConfig *mcfgv1.MachineConfig, systemdPath string, unmanagedPaths []string) error {
 	// And the rest of the disk state
 	// We want to verify the disk state in the spec version that it was created with,
 	// to remove possibilities of behaviour changes due to translation
@@ -26,7 +27,7 @@ func validateOnDiskState(currentConfig *mcfgv1.MachineConfig, systemdPath string
 
 	switch typedConfig := ignconfigi.(type) {
 	case ign3types.Config:
-		if err := checkV3Files(ignconfigi.(ign3types.Config).Storage.Files); err != nil {
+		if err := checkV3Files(filterUnmanagedV3Files(ignconfigi.(ign3types.Config).Storage.Files, unmanagedPaths)); err != nil {
 			return &fileConfigDriftErr{err}
 		}
 		if err := checkV3Units(ignconfigi.(ign3types.Config).Systemd.Units, systemdPath); err != nil {
@@ -34,7 +35,7 @@ func validateOnDiskState(currentConfig *mcfgv1.MachineConfig, systemdPath string
 		}
 		return nil
 	case ign2types.Config:
-		if err := checkV2Files(ignconfigi.(ign2types.Config).Storage.Files); err != nil {
+		if err := checkV2Files(filterUnmanagedV2Files(ignconfigi.(ign2types.Config).Storage.Files, unmanagedPaths)); err != nil {
 			return &fileConfigDriftErr{err}
 		}
 		if err := checkV2Units(ignconfigi.(ign2types.Config).Systemd.Units, systemdPath); err != nil {
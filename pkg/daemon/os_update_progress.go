@@ -0,0 +1,269 @@
+package daemon
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/clarketm/json"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	"github.com/openshift/machine-config-operator/pkg/daemon/constants"
+	"k8s.io/klog/v2"
+)
+
+// How often the progress of an OS update is reported at most. Stage changes
+// are reported right away.
+const osUpdateProgressReportInterval = 10 * time.Second
+
+const (
+	osUpdateStagePulling  = "Pulling manifest"
+	osUpdateStageImport   = "Importing"
+	osUpdateStageFetching = "Fetching layers"
+	osUpdateStageStaging  = "Staging deployment"
+	osUpdateStageStaged   = "Staged"
+	osUpdateStageFailed   = "Failed"
+)
+
+var (
+	// e.g. "ostree chunk layers needed: 41 (1.1 GB)" from rpm-ostree or
+	// "layers already present: 0; layers needed: 65 (1.4 GB)" from bootc.
+	layersNeededRegex = regexp.MustCompile(`layers needed: (\d+) \(([^)]+)\)`)
+	// e.g. "Fetching ostree chunk sha256:5a3d (40.9 MB)...done" or
+	// "Fetching layer sha256:9f1c (45.0 MB)...done".
+	fetchingLayerRegex = regexp.MustCompile(`^Fetching (?:ostree chunk|layer) \S+ \(([^)]+)\)`)
+	// e.g. "40.9 MB", "512 bytes" or "1.2 GiB".
+	sizeRegex = regexp.MustCompile(`^([\d.]+) ?([a-zA-Z]+)$`)
+)
+
+var sizeUnits = map[string]float64{
+	"B":     1,
+	"byte":  1,
+	"bytes": 1,
+	"kB":    1e3,
+	"KB":    1e3,
+	"MB":    1e6,
+	"GB":    1e9,
+	"TB":    1e12,
+	"KiB":   1 << 10,
+	"MiB":   1 << 20,
+	"GiB":   1 << 30,
+	"TiB":   1 << 40,
+}
+
+// osUpdateProgress is what the MCD reports under
+// OSUpdateProgressAnnotationKey while rpm-ostree or bootc pulls and deploys
+// an OS image.
+type osUpdateProgress struct {
+	Image         string `json:"image"`
+	Stage         string `json:"stage"`
+	LayersFetched int    `json:"layersFetched"`
+	LayersNeeded  int    `json:"layersNeeded"`
+	BytesFetched  int64  `json:"bytesFetched"`
+	BytesNeeded   int64  `json:"bytesNeeded"`
+	Started       string `json:"started"`
+	Updated       string `json:"updated"`
+}
+
+// parseSize parses a size as printed by rpm-ostree and bootc.
+func parseSize(size string) (int64, error) {
+	match := sizeRegex.FindStringSubmatch(strings.TrimSpace(size))
+	if match == nil {
+		return 0, fmt.Errorf("invalid size %q", size)
+	}
+
+	unit, ok := sizeUnits[match[2]]
+	if !ok {
+		return 0, fmt.Errorf("invalid size %q: unknown unit %s", size, match[2])
+	}
+
+	val, err := strconv.ParseFloat(match[1], 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q: %w", size, err)
+	}
+
+	return int64(val * unit), nil
+}
+
+// parseLine updates the progress from a line of output of rpm-ostree or
+// bootc. Lines it does not know are ignored.
+func (p *osUpdateProgress) parseLine(line string) {
+	line = strings.TrimSpace(line)
+
+	switch {
+	case strings.HasPrefix(line, "Pulling manifest"):
+		p.Stage = osUpdateStagePulling
+	case strings.HasPrefix(line, "Importing"):
+		p.Stage = osUpdateStageImport
+	case strings.HasPrefix(line, "Staging deployment"):
+		p.Stage = osUpdateStageStaging
+	}
+
+	for _, match := range layersNeededRegex.FindAllStringSubmatch(line, -1) {
+		layers, err := strconv.Atoi(match[1])
+		if err != nil {
+			continue
+		}
+		size, err := parseSize(match[2])
+		if err != nil {
+			klog.V(4).Infof("Ignoring OS update progress %q: %v", line, err)
+			continue
+		}
+		p.LayersNeeded += layers
+		p.BytesNeeded += size
+	}
+
+	if match := fetchingLayerRegex.FindStringSubmatch(line); match != nil {
+		p.Stage = osUpdateStageFetching
+		// The line is only complete once the layer was fetched.
+		if strings.HasSuffix(line, "done") {
+			size, err := parseSize(match[1])
+			if err != nil {
+				klog.V(4).Infof("Ignoring OS update progress %q: %v", line, err)
+				return
+			}
+			p.LayersFetched++
+			p.BytesFetched += size
+		}
+	}
+}
+
+// osUpdateProgressReporter tracks the progress of an OS update from the
+// output of rpm-ostree or bootc and reports it, at most once per
+// osUpdateProgressReportInterval unless the stage changes.
+type osUpdateProgressReporter struct {
+	progress     osUpdateProgress
+	lastReported time.Time
+	lastStage    string
+	now          func() time.Time
+	report       func(*osUpdateProgress) error
+}
+
+func newOSUpdateProgressReporter(image string, report func(*osUpdateProgress) error) *osUpdateProgressReporter {
+	r := &osUpdateProgressReporter{
+		now:    time.Now,
+		report: report,
+	}
+	r.progress = osUpdateProgress{
+		Image:   image,
+		Started: r.now().UTC().Format(time.RFC3339),
+	}
+	return r
+}
+
+// handleLine updates the progress from a line of output and reports it if
+// it is due.
+func (r *osUpdateProgressReporter) handleLine(line string) {
+	r.progress.parseLine(line)
+
+	now := r.now()
+	if r.progress.Stage == r.lastStage && now.Sub(r.lastReported) < osUpdateProgressReportInterval {
+		return
+	}
+
+	r.publish(now)
+}
+
+// finish reports the final stage of the update.
+func (r *osUpdateProgressReporter) finish(err error) {
+	r.progress.Stage = osUpdateStageStaged
+	if err != nil {
+		r.progress.Stage = osUpdateStageFailed
+	}
+
+	r.publish(r.now())
+}
+
+func (r *osUpdateProgressReporter) publish(now time.Time) {
+	r.progress.Updated = now.UTC().Format(time.RFC3339)
+	r.lastReported = now
+	r.lastStage = r.progress.Stage
+
+	// The update does not fail because its progress cannot be reported.
+	if err := r.report(&r.progress); err != nil {
+		klog.Warningf("Could not report OS update progress: %v", err)
+	}
+}
+
+// scanLines splits output into lines which end in either a newline or a
+// carriage return, which progress bars use to redraw themselves.
+func scanLines(data []byte, atEOF bool) (int, []byte, error) {
+	if i := bytes.IndexAny(data, "\r\n"); i >= 0 {
+		return i + 1, data[:i], nil
+	}
+	if atEOF && len(data) > 0 {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
+
+// runCmdSyncWithProgress is runCmdSync, but also hands each line of stdout to
+// onLine as it is written.
+func runCmdSyncWithProgress(onLine func(string), cmdName string, args ...string) error {
+	klog.Infof("Running: %s %s", cmdName, strings.Join(args, " "))
+	cmd := exec.Command(cmdName, args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("error running %s %s: %w", cmdName, strings.Join(args, " "), err)
+	}
+
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("error running %s %s: %w", cmdName, strings.Join(args, " "), err)
+	}
+
+	out := io.TeeReader(stdout, os.Stdout)
+	scanner := bufio.NewScanner(out)
+	scanner.Split(scanLines)
+	for scanner.Scan() {
+		if line := scanner.Text(); line != "" {
+			onLine(line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		// Keep draining stdout so that the command does not block.
+		klog.Warningf("Could not read output of %s: %v", cmdName, err)
+		io.Copy(io.Discard, out) //nolint:errcheck // the command's exit status is what matters
+	}
+
+	if err := cmd.Wait(); err != nil {
+		return fmt.Errorf("error running %s %s: %s: %w", cmdName, strings.Join(args, " "), stderr.String(), err)
+	}
+
+	return nil
+}
+
+// reportOSUpdateProgress records the progress of the OS update in the node
+// status, or clears it when progress is nil.
+func (dn *Daemon) reportOSUpdateProgress(progress *osUpdateProgress) error {
+	if dn.nodeWriter == nil {
+		return nil
+	}
+
+	val := ""
+	if progress != nil {
+		out, err := json.Marshal(progress)
+		if err != nil {
+			return fmt.Errorf("could not encode OS update progress: %w", err)
+		}
+		val = string(out)
+	} else if dn.node != nil {
+		if reported, ok := ctrlcommon.GetNodeStatus(dn.node, constants.OSUpdateProgressAnnotationKey); !ok || reported == "" {
+			return nil
+		}
+	}
+
+	if _, err := dn.nodeWriter.SetNodeStatus(map[string]string{constants.OSUpdateProgressAnnotationKey: val}); err != nil {
+		return fmt.Errorf("could not report OS update progress: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,115 @@
+package daemon
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSize(t *testing.T) {
+	testCases := map[string]int64{
+		"512 bytes": 512,
+		"40.9 MB":   40900000,
+		"1.1 GB":    1100000000,
+		"2 GiB":     2 << 30,
+	}
+
+	for size, expected := range testCases {
+		parsed, err := parseSize(size)
+		require.NoError(t, err)
+		assert.Equal(t, expected, parsed, size)
+	}
+
+	_, err := parseSize("lots")
+	assert.Error(t, err)
+	_, err = parseSize("1.2 parsecs")
+	assert.Error(t, err)
+}
+
+func TestOSUpdateProgressParseLine(t *testing.T) {
+	rpmOstreeOutput := `Pulling manifest: ostree-unverified-registry:registry.example.com/os@sha256:1234
+Importing: ostree-unverified-registry:registry.example.com/os@sha256:1234 (digest: sha256:1234)
+ostree chunk layers already present: 10
+ostree chunk layers needed: 2 (1.5 GB)
+custom layers needed: 1 (45.0 MB)
+Fetching ostree chunk sha256:5a3d (1.0 GB)...done
+Fetching ostree chunk sha256:7b2e (500.0 MB)...`
+
+	progress := &osUpdateProgress{}
+	for _, line := range strings.Split(rpmOstreeOutput, "\n") {
+		progress.parseLine(line)
+	}
+
+	assert.Equal(t, osUpdateProgress{
+		Stage:         osUpdateStageFetching,
+		LayersFetched: 1,
+		LayersNeeded:  3,
+		BytesFetched:  1000000000,
+		BytesNeeded:   1545000000,
+	}, *progress)
+
+	progress.parseLine("Fetching ostree chunk sha256:7b2e (500.0 MB)...done")
+	progress.parseLine("Fetching layer sha256:9f1c (45.0 MB)...done")
+	progress.parseLine("Staging deployment...done")
+	assert.Equal(t, osUpdateStageStaging, progress.Stage)
+	assert.Equal(t, progress.LayersNeeded, progress.LayersFetched)
+	assert.Equal(t, progress.BytesNeeded, progress.BytesFetched)
+
+	bootc := &osUpdateProgress{}
+	bootc.parseLine("layers already present: 0; layers needed: 65 (1.4 GB)")
+	assert.Equal(t, 65, bootc.LayersNeeded)
+	assert.Equal(t, int64(1400000000), bootc.BytesNeeded)
+}
+
+func TestOSUpdateProgressReporter(t *testing.T) {
+	now := time.Date(2024, time.January, 1, 12, 0, 0, 0, time.UTC)
+
+	reported := []osUpdateProgress{}
+	r := newOSUpdateProgressReporter("registry.example.com/os@sha256:1234", func(p *osUpdateProgress) error {
+		reported = append(reported, *p)
+		return nil
+	})
+	r.now = func() time.Time { return now }
+
+	r.handleLine("Pulling manifest: ostree-unverified-registry:registry.example.com/os@sha256:1234")
+	r.handleLine("ostree chunk layers needed: 2 (1.5 GB)")
+	require.Len(t, reported, 1)
+	assert.Equal(t, osUpdateStagePulling, reported[0].Stage)
+
+	// Stage changes are reported right away.
+	r.handleLine("Fetching ostree chunk sha256:5a3d (1.0 GB)...done")
+	require.Len(t, reported, 2)
+	assert.Equal(t, 1, reported[1].LayersFetched)
+
+	// Other progress is reported once the interval passed.
+	now = now.Add(osUpdateProgressReportInterval / 2)
+	r.handleLine("Fetching ostree chunk sha256:7b2e (500.0 MB)...done")
+	require.Len(t, reported, 2)
+
+	now = now.Add(osUpdateProgressReportInterval)
+	r.handleLine("Fetching layer sha256:9f1c (45.0 MB)...")
+	require.Len(t, reported, 3)
+	assert.Equal(t, 2, reported[2].LayersFetched)
+	assert.Equal(t, "2024-01-01T12:00:15Z", reported[2].Updated)
+
+	r.finish(nil)
+	require.Len(t, reported, 4)
+	assert.Equal(t, osUpdateStageStaged, reported[3].Stage)
+	assert.Equal(t, "registry.example.com/os@sha256:1234", reported[3].Image)
+	assert.NotEmpty(t, reported[3].Started)
+}
+
+func TestRunCmdSyncWithProgress(t *testing.T) {
+	lines := []string{}
+	err := runCmdSyncWithProgress(func(line string) {
+		lines = append(lines, line)
+	}, "sh", "-c", `printf 'Fetching 1/2\rFetching 2/2\ndone\n'`)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Fetching 1/2", "Fetching 2/2", "done"}, lines)
+
+	err = runCmdSyncWithProgress(func(string) {}, "sh", "-c", `echo failed >&2; exit 1`)
+	assert.ErrorContains(t, err, "failed")
+}
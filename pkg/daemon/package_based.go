@@ -0,0 +1,278 @@
+package daemon
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	"github.com/openshift/machine-config-operator/pkg/daemon/constants"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/klog/v2"
+)
+
+// How often the MCD checks whether a package-based host needs a reboot.
+var rebootRequiredCheckInterval = 10 * time.Minute
+
+// PackageDaemon manages hosts whose packages are installed with dnf or yum
+// rather than rpm-ostree, such as traditional RHEL workers. Files, units and
+// SSH keys are written as on CoreOS. Kernel arguments are set with grubby on
+// all the boot entries, and updates which change them go through the usual
+// drain and reboot. The OS image, kernel type and extensions of the config
+// are left to the package manager of the host.
+type PackageDaemon struct {
+	*Daemon
+}
+
+// isPositionalKernelArgument returns whether the meaning of the kernel
+// argument depends on its position, e.g. hugepages= sets the number of pages
+// of the size set by the hugepagesz= before it.
+func isPositionalKernelArgument(arg string) bool {
+	switch strings.SplitN(arg, "=", 2)[0] {
+	case "hugepagesz", "hugepages", "default_hugepagesz":
+		return true
+	}
+	return false
+}
+
+// splitPositionalKernelArguments splits the kernel arguments into the
+// positional ones, in their order, and the others.
+func splitPositionalKernelArguments(kargs []string) (positional, others []string) {
+	for _, arg := range kargs {
+		if isPositionalKernelArgument(arg) {
+			positional = append(positional, arg)
+		} else {
+			others = append(others, arg)
+		}
+	}
+	return positional, others
+}
+
+// generateGrubbyKargs returns the kernel arguments to remove and to add with
+// grubby to go from the old to the new MC kernelArguments. Only the kernel
+// arguments which differ between the configs are removed or added, so that
+// the ones both configs share stay where they are. Positional kernel
+// arguments are replaced as a whole when any of them change, since grubby
+// appends the added ones after all the others.
+func generateGrubbyKargs(oldKernelArguments, newKernelArguments []string) (remove, add string) {
+	oldPositional, oldOthers := splitPositionalKernelArguments(parseKernelArguments(oldKernelArguments))
+	newPositional, newOthers := splitPositionalKernelArguments(parseKernelArguments(newKernelArguments))
+
+	removeArgs := []string{}
+	addArgs := []string{}
+
+	// Arguments in both configs are kept, and each one is only removed or
+	// added once.
+	removed := sets.NewString(newOthers...)
+	for _, arg := range oldOthers {
+		if !removed.Has(arg) {
+			removeArgs = append(removeArgs, arg)
+			removed.Insert(arg)
+		}
+	}
+	added := sets.NewString(oldOthers...)
+	for _, arg := range newOthers {
+		if !added.Has(arg) {
+			addArgs = append(addArgs, arg)
+			added.Insert(arg)
+		}
+	}
+
+	if strings.Join(oldPositional, " ") != strings.Join(newPositional, " ") {
+		removeArgs = append(removeArgs, oldPositional...)
+		addArgs = append(addArgs, newPositional...)
+	}
+
+	return strings.Join(removeArgs, " "), strings.Join(addArgs, " ")
+}
+
+// checkKernelArgumentsUpdatable returns an error if grubby, which sets the
+// kernel arguments, is not installed on the host.
+func (dn *PackageDaemon) checkKernelArgumentsUpdatable() error {
+	if _, err := exec.LookPath("grubby"); err != nil {
+		return fmt.Errorf("cannot update kernel arguments on %s without grubby, install it with the package manager of the host: %w", dn.os.OSRelease().PRETTY_NAME, err)
+	}
+
+	return nil
+}
+
+// updateKernelArguments sets the kernel arguments on all the boot entries,
+// so that they apply whichever kernel the host boots next. The removal and
+// the addition run separately, since a kernel argument can be in both.
+func (dn *PackageDaemon) updateKernelArguments(oldKernelArguments, newKernelArguments []string) error {
+	remove, add := generateGrubbyKargs(oldKernelArguments, newKernelArguments)
+
+	if remove != "" {
+		logSystem("Removing kernel arguments %q", remove)
+		if err := runCmdSync("grubby", "--update-kernel=ALL", "--remove-args="+remove); err != nil {
+			return fmt.Errorf("could not remove kernel arguments: %w", err)
+		}
+	}
+
+	if add != "" {
+		logSystem("Adding kernel arguments %q", add)
+		if err := runCmdSync("grubby", "--update-kernel=ALL", "--args="+add); err != nil {
+			return fmt.Errorf("could not add kernel arguments: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// applyPackageBasedChanges applies the parts of the diff which go beyond
+// files and units on a package-based host.
+func (dn *PackageDaemon) applyPackageBasedChanges(diff *machineConfigDiff, oldConfig, newConfig *mcfgv1.MachineConfig) error {
+	if diff.osUpdate || diff.kernelType || diff.extensions {
+		logSystem("Not updating the OS image, kernel type or extensions of package-based host %s, they are managed with its package manager", dn.os.OSRelease().PRETTY_NAME)
+	}
+
+	if diff.kargs {
+		return dn.updateKernelArguments(oldConfig.Spec.KernelArguments, newConfig.Spec.KernelArguments)
+	}
+
+	return nil
+}
+
+// getMissingKernelArguments returns the expected kernel arguments which are
+// not on the command line.
+func getMissingKernelArguments(cmdline string, expected []string) []string {
+	found := map[string]bool{}
+	for _, arg := range ctrlcommon.SplitKernelArguments(strings.TrimSpace(cmdline)) {
+		found[arg] = true
+	}
+
+	missing := []string{}
+	for _, arg := range parseKernelArguments(expected) {
+		if !found[arg] {
+			missing = append(missing, arg)
+		}
+	}
+
+	return missing
+}
+
+// getBootEntryKernelArguments returns the kernel arguments of the default
+// boot entry, as reported by grubby.
+func getBootEntryKernelArguments() (string, error) {
+	out, err := runGetOut("grubby", "--info=DEFAULT")
+	if err != nil {
+		return "", err
+	}
+
+	return parseGrubbyInfoArgs(string(out))
+}
+
+// parseGrubbyInfoArgs returns the kernel arguments in the output of
+// `grubby --info`.
+func parseGrubbyInfoArgs(info string) (string, error) {
+	for _, line := range strings.Split(info, "\n") {
+		if val, ok := strings.CutPrefix(strings.TrimSpace(line), "args="); ok {
+			return strings.Trim(val, `"`), nil
+		}
+	}
+
+	return "", fmt.Errorf("no kernel arguments in grubby output: %s", truncate(info, 256))
+}
+
+// validateKernelArguments checks that the host booted with all the kernel
+// arguments of the config. MCDs before this one did not set kernel arguments
+// on package-based hosts, so the ones of configs they applied can be missing.
+// Rather than degrading the node, those are added to the boot entries and
+// take effect when the host next reboots.
+func (dn *PackageDaemon) validateKernelArguments(currentConfig *mcfgv1.MachineConfig) error {
+	cmdline, err := os.ReadFile(CmdLineFile)
+	if err != nil {
+		return fmt.Errorf("could not read %s: %w", CmdLineFile, err)
+	}
+
+	missing := getMissingKernelArguments(string(cmdline), currentConfig.Spec.KernelArguments)
+	if len(missing) == 0 {
+		return nil
+	}
+
+	klog.Infof("Booted command line: %s", strings.TrimSpace(string(cmdline)))
+
+	if err := dn.checkKernelArgumentsUpdatable(); err != nil {
+		return fmt.Errorf("missing expected kernel arguments: %v: %w", missing, err)
+	}
+
+	bootArgs, err := getBootEntryKernelArguments()
+	if err != nil {
+		return fmt.Errorf("missing expected kernel arguments: %v: could not read boot entry: %w", missing, err)
+	}
+
+	if unset := getMissingKernelArguments(bootArgs, missing); len(unset) > 0 {
+		logSystem("Adding missing kernel arguments %q of %s to the boot entries", strings.Join(unset, " "), currentConfig.GetName())
+		if err := runCmdSync("grubby", "--update-kernel=ALL", "--args="+strings.Join(unset, " ")); err != nil {
+			return fmt.Errorf("could not add missing kernel arguments: %w", err)
+		}
+
+		if dn.nodeWriter != nil {
+			dn.nodeWriter.Eventf(corev1.EventTypeNormal, "KernelArgumentsAdded",
+				"Added missing kernel arguments %v of %s to the boot entries, they take effect on the next reboot", unset, currentConfig.GetName())
+		}
+	}
+
+	klog.Warningf("Booted without kernel arguments %v of %s, they take effect on the next reboot", missing, currentConfig.GetName())
+
+	return nil
+}
+
+// isRebootRequired returns whether the host needs a reboot to use the
+// packages updated on it, e.g. a new kernel or glibc, as found by
+// needs-restarting.
+func isRebootRequired() (bool, error) {
+	err := exec.Command("needs-restarting", "-r").Run()
+	if err == nil {
+		return false, nil
+	}
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+		return true, nil
+	}
+
+	return false, fmt.Errorf("could not run needs-restarting: %w", err)
+}
+
+// reportRebootRequired records in the node status under
+// RebootRequiredAnnotationKey whether the host needs a reboot to use its
+// updated packages, so that the node controller can reboot it. The check
+// runs at most every rebootRequiredCheckInterval.
+func (dn *PackageDaemon) reportRebootRequired() error {
+	if dn.mock || dn.nodeWriter == nil {
+		return nil
+	}
+
+	if time.Since(dn.lastRebootRequiredCheck) < rebootRequiredCheckInterval {
+		return nil
+	}
+	dn.lastRebootRequiredCheck = time.Now()
+
+	required, err := isRebootRequired()
+	if err != nil {
+		return err
+	}
+
+	val := ""
+	if required {
+		val = "true"
+	}
+
+	reported, ok := ctrlcommon.GetNodeStatus(dn.node, constants.RebootRequiredAnnotationKey)
+	if reported == val && (ok || val == "") {
+		return nil
+	}
+
+	if required {
+		klog.Infof("Updated packages require a reboot of the node")
+	}
+
+	_, err = dn.nodeWriter.SetNodeStatus(map[string]string{constants.RebootRequiredAnnotationKey: val})
+	return err
+}
@@ -0,0 +1,52 @@
+package daemon
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGenerateGrubbyKargs(t *testing.T) {
+	remove, add := generateGrubbyKargs([]string{"foo", "bar=1 hello=world"}, []string{"hello=world", "baz"})
+	assert.Equal(t, "foo bar=1", remove)
+	assert.Equal(t, "baz", add)
+
+	// Unchanged configs change nothing.
+	remove, add = generateGrubbyKargs([]string{"nosmt hugepagesz=1G hugepages=4"}, []string{"nosmt", "hugepagesz=1G hugepages=4"})
+	assert.Empty(t, remove)
+	assert.Empty(t, add)
+
+	// Positional arguments are replaced in their order when any of them
+	// change.
+	remove, add = generateGrubbyKargs(
+		[]string{"nosmt hugepagesz=1G hugepages=4 hugepagesz=2M hugepages=512"},
+		[]string{"nosmt hugepagesz=1G hugepages=8 hugepagesz=2M hugepages=512", "mitigations=off"},
+	)
+	assert.Equal(t, "hugepagesz=1G hugepages=4 hugepagesz=2M hugepages=512", remove)
+	assert.Equal(t, "mitigations=off hugepagesz=1G hugepages=8 hugepagesz=2M hugepages=512", add)
+
+	remove, add = generateGrubbyKargs(nil, nil)
+	assert.Empty(t, remove)
+	assert.Empty(t, add)
+}
+
+func TestParseGrubbyInfoArgs(t *testing.T) {
+	info := `index=0
+kernel="/boot/vmlinuz-5.14.0-362.8.1.el9_3.x86_64"
+args="ro crashkernel=1G-4G:192M resume=/dev/mapper/rhel-swap nosmt"
+root="/dev/mapper/rhel-root"
+`
+	args, err := parseGrubbyInfoArgs(info)
+	assert.NoError(t, err)
+	assert.Equal(t, "ro crashkernel=1G-4G:192M resume=/dev/mapper/rhel-swap nosmt", args)
+
+	_, err = parseGrubbyInfoArgs("index=0\n")
+	assert.ErrorContains(t, err, "no kernel arguments")
+}
+
+func TestGetMissingKernelArguments(t *testing.T) {
+	cmdline := "BOOT_IMAGE=(hd0,msdos1)/vmlinuz-5.14.0 root=/dev/mapper/rhel-root ro hugepagesz=1G hugepages=4 nosmt\n"
+
+	assert.Empty(t, getMissingKernelArguments(cmdline, []string{"hugepagesz=1G hugepages=4", "nosmt"}))
+	assert.Equal(t, []string{"hugepages=8", "mitigations=off"}, getMissingKernelArguments(cmdline, []string{"hugepagesz=1G hugepages=8", "mitigations=off"}))
+}
@@ -0,0 +1,130 @@
+package daemon
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	"github.com/openshift/machine-config-operator/pkg/daemon/constants"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+)
+
+// parseBootTime parses the boot time out of the contents of /proc/stat, whose
+// btime line holds the boot time in seconds since the epoch.
+func parseBootTime(stat string) (time.Time, error) {
+	for _, line := range strings.Split(stat, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 || fields[0] != "btime" {
+			continue
+		}
+
+		secs, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("could not parse btime %q: %w", fields[1], err)
+		}
+
+		return time.Unix(secs, 0).UTC(), nil
+	}
+
+	return time.Time{}, fmt.Errorf("no btime in /proc/stat")
+}
+
+// getBootTime returns when the node booted.
+func getBootTime() (time.Time, error) {
+	stat, err := os.ReadFile("/proc/stat")
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return parseBootTime(string(stat))
+}
+
+// reportLastBootTime records when the node booted in its node status under
+// LastBootTimeAnnotationKey so that the node controller can tell when a node is due for a
+// periodic reboot.
+func (dn *Daemon) reportLastBootTime() error {
+	if dn.mock || dn.nodeWriter == nil {
+		return nil
+	}
+
+	bootTime, err := getBootTime()
+	if err != nil {
+		return err
+	}
+
+	val := bootTime.Format(time.RFC3339)
+	if dn.node != nil {
+		if reported, _ := ctrlcommon.GetNodeStatus(dn.node, constants.LastBootTimeAnnotationKey); reported == val {
+			return nil
+		}
+	}
+
+	klog.Infof("Reporting last boot time: %s", val)
+	_, err = dn.nodeWriter.SetNodeStatus(map[string]string{constants.LastBootTimeAnnotationKey: val})
+	return err
+}
+
+// getPendingRebootRequest returns the reboot request set on the node if it
+// has not been handled yet.
+func getPendingRebootRequest(node *corev1.Node) (string, bool) {
+	request := node.Annotations[constants.RebootRequestAnnotationKey]
+	if request == "" || request == node.Annotations[constants.LastAppliedRebootRequestAnnotationKey] {
+		return "", false
+	}
+
+	return request, true
+}
+
+// isRebootRequestSatisfied returns whether the node has booted since the
+// reboot request was made, in which case there is no need to reboot again.
+// Requests which cannot be parsed are considered satisfied, since they would
+// otherwise be retried forever.
+func isRebootRequestSatisfied(request string, bootTime time.Time) bool {
+	requested, err := time.Parse(time.RFC3339, request)
+	if err != nil {
+		klog.Warningf("Ignoring invalid reboot request %q: %v", request, err)
+		return true
+	}
+
+	return !bootTime.Before(requested)
+}
+
+// handleRebootRequest drains and reboots the node in response to the node
+// controller setting the reboot request annotation on the node, e.g. because
+// the node is due for a periodic reboot. The config is not changed: once the
+// node is back, it finds itself in its desired config and is uncordoned as
+// after any update.
+func (dn *Daemon) handleRebootRequest(request string) error {
+	bootTime, err := getBootTime()
+	if err != nil {
+		return fmt.Errorf("could not get boot time: %w", err)
+	}
+
+	// The request is only acknowledged right before rebooting, so a reboot may
+	// already have happened without the acknowledgement making it.
+	if isRebootRequestSatisfied(request, bootTime) {
+		klog.Infof("Node booted at %s, after reboot request %s", bootTime.Format(time.RFC3339), request)
+		_, err := dn.nodeWriter.SetAnnotations(map[string]string{constants.LastAppliedRebootRequestAnnotationKey: request})
+		return err
+	}
+
+	logSystem("Reboot requested via %s=%s", constants.RebootRequestAnnotationKey, request)
+
+	if err := dn.nodeWriter.SetWorking(); err != nil {
+		return fmt.Errorf("error setting node's state to Working: %w", err)
+	}
+
+	if err := dn.performDrain(); err != nil {
+		return err
+	}
+
+	if _, err := dn.nodeWriter.SetAnnotations(map[string]string{constants.LastAppliedRebootRequestAnnotationKey: request}); err != nil {
+		return fmt.Errorf("could not acknowledge reboot request: %w", err)
+	}
+
+	return dn.reboot(fmt.Sprintf("Node will reboot in response to reboot request %s, it last booted at %s", request, bootTime.Format(time.RFC3339)))
+}
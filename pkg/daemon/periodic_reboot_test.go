@@ -0,0 +1,53 @@
+package daemon
+
+import (
+	"testing"
+	"time"
+
+	"github.com/openshift/machine-config-operator/pkg/daemon/constants"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestParseBootTime(t *testing.T) {
+	stat := `cpu  10132153 290696 3084719 46828483 16683 0 25195 0 0 0
+intr 1462898 0 9 0 0 0 0 0 0 1 0 0 0 0 0 0 0
+ctxt 1990473
+btime 1697803200
+processes 2915
+`
+	bootTime, err := parseBootTime(stat)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2023, time.October, 20, 12, 0, 0, 0, time.UTC), bootTime)
+
+	_, err = parseBootTime("ctxt 1990473\n")
+	assert.Error(t, err)
+
+	_, err = parseBootTime("btime soon\n")
+	assert.Error(t, err)
+}
+
+func TestGetPendingRebootRequest(t *testing.T) {
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{}}}
+
+	_, ok := getPendingRebootRequest(node)
+	assert.False(t, ok)
+
+	node.Annotations[constants.RebootRequestAnnotationKey] = "2023-10-20T12:00:00Z"
+	request, ok := getPendingRebootRequest(node)
+	assert.True(t, ok)
+	assert.Equal(t, "2023-10-20T12:00:00Z", request)
+
+	node.Annotations[constants.LastAppliedRebootRequestAnnotationKey] = "2023-10-20T12:00:00Z"
+	_, ok = getPendingRebootRequest(node)
+	assert.False(t, ok)
+}
+
+func TestIsRebootRequestSatisfied(t *testing.T) {
+	request := "2023-10-20T12:00:00Z"
+
+	assert.False(t, isRebootRequestSatisfied(request, time.Date(2023, time.October, 1, 0, 0, 0, 0, time.UTC)))
+	assert.True(t, isRebootRequestSatisfied(request, time.Date(2023, time.October, 20, 12, 5, 0, 0, time.UTC)))
+	assert.True(t, isRebootRequestSatisfied("tomorrow", time.Date(2023, time.October, 1, 0, 0, 0, 0, time.UTC)))
+}
@@ -0,0 +1,238 @@
+package daemon
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/clarketm/json"
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	"github.com/openshift/machine-config-operator/pkg/daemon/constants"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// How long a single HTTP probe may take.
+	postUpdateHTTPProbeTimeout = 10 * time.Second
+)
+
+// How long to wait between attempts to verify an update.
+var postUpdateVerificationInterval = 10 * time.Second
+
+// getPostUpdateVerification returns the probes which have to pass before the
+// update the node just rebooted into is completed, or nil if the node did not
+// reboot into an update or the node controller handed it no probes.
+func (dn *Daemon) getPostUpdateVerification(state *stateAndConfigs) *ctrlcommon.PostUpdateVerification {
+	if state.bootstrapping {
+		return nil
+	}
+
+	verification, err := ctrlcommon.ParsePostUpdateVerification(dn.node.Annotations[constants.PostUpdateVerificationAnnotationKey])
+	if err != nil {
+		klog.Warningf("Ignoring invalid %s annotation: %v", constants.PostUpdateVerificationAnnotationKey, err)
+		return nil
+	}
+	if verification == nil {
+		return nil
+	}
+
+	// The update stores the new config on disk before it reboots the node,
+	// while the node annotations still name the config it updated from.
+	odc, err := dn.getCurrentConfigOnDisk()
+	if err != nil {
+		if !os.IsNotExist(err) {
+			klog.Warningf("Not verifying update: could not get on-disk config: %v", err)
+		}
+		return nil
+	}
+
+	if odc.currentConfig.GetName() == state.currentConfig.GetName() && odc.currentImage == state.currentImage {
+		return nil
+	}
+
+	// A rollback requested by an admin is not verified, nor rolled back.
+	if r := getLastRollback(dn.node); r != nil && r.RolledBackTo == odc.currentConfig.GetName() && r.RolledBackToImage == odc.currentImage {
+		return nil
+	}
+
+	return verification
+}
+
+// checkUnitActive checks that the systemd unit is active.
+func checkUnitActive(unit string) error {
+	// systemctl exits non-zero for units which are not active, with the
+	// state on stdout.
+	out, _ := exec.Command("systemctl", "is-active", unit).Output()
+	if state := strings.TrimSpace(string(out)); state != "active" {
+		if state == "" {
+			state = "unknown"
+		}
+		return fmt.Errorf("unit %s is %s", unit, state)
+	}
+
+	return nil
+}
+
+// checkHTTPGet checks that a GET request to the URL is answered with a 2xx
+// status code.
+func checkHTTPGet(url string) error {
+	client := &http.Client{Timeout: postUpdateHTTPProbeTimeout}
+
+	resp, err := client.Get(url)
+	if err != nil {
+		return fmt.Errorf("GET %s failed: %w", url, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("GET %s returned %s: %s", url, resp.Status, truncate(strings.TrimSpace(string(body)), 256))
+	}
+
+	return nil
+}
+
+// runPostUpdateProbes runs each probe once and returns the output of those
+// which failed.
+func runPostUpdateProbes(verification *ctrlcommon.PostUpdateVerification, mc *mcfgv1.MachineConfig, scriptTimeout time.Duration) []string {
+	output := []string{}
+
+	for _, unit := range verification.Units {
+		if err := checkUnitActive(unit); err != nil {
+			output = append(output, err.Error())
+		}
+	}
+
+	if verification.Script != "" {
+		if err := runMachineConfigScript(verification.Script, scriptTimeout, mc); err != nil {
+			output = append(output, err.Error())
+		}
+	}
+
+	if verification.HTTPGet != "" {
+		if err := checkHTTPGet(verification.HTTPGet); err != nil {
+			output = append(output, err.Error())
+		}
+	}
+
+	return output
+}
+
+// waitForProbes runs the probes every interval until they pass or the
+// timeout expires, and returns the output of the probes which failed last.
+func waitForProbes(probe func() []string, timeout, interval time.Duration) []string {
+	deadline := time.Now().Add(timeout)
+
+	for {
+		output := probe()
+		if len(output) == 0 || time.Now().Add(interval).After(deadline) {
+			return output
+		}
+
+		klog.Infof("Post-update verification has not passed yet, retrying in %s: %s", interval, strings.Join(output, "; "))
+		time.Sleep(interval)
+	}
+}
+
+// verifyPostUpdate runs the post-update verification of the update from
+// oldConfig to newConfig which the node just rebooted into. When the probes
+// do not pass within the timeout, the node is rolled back to oldConfig with
+// the regular update flow, which rebases onto the OS image of the rollback
+// deployment kept until now, and the update is not applied again unless a
+// resync is requested.
+func (dn *Daemon) verifyPostUpdate(verification *ctrlcommon.PostUpdateVerification, oldConfig, newConfig *mcfgv1.MachineConfig, oldImage, newImage string) error {
+	// Validated by ParsePostUpdateVerification.
+	timeout, _ := verification.GetTimeout()
+
+	logSystem("Verifying update to %s for up to %s", newConfig.GetName(), timeout)
+
+	output := waitForProbes(func() []string {
+		return runPostUpdateProbes(verification, newConfig, timeout)
+	}, timeout, postUpdateVerificationInterval)
+
+	if len(output) == 0 {
+		logSystem("Post-update verification of %s passed", newConfig.GetName())
+		return dn.reportPostUpdateVerificationFailure(nil)
+	}
+
+	failure := &ctrlcommon.PostUpdateVerificationFailure{
+		Config:       newConfig.GetName(),
+		Image:        newImage,
+		RolledBackTo: oldConfig.GetName(),
+		Output:       output,
+		Time:         time.Now().UTC().Format(time.RFC3339),
+	}
+
+	logSystem("Post-update verification of %s failed, rolling back to %s: %s", failure.Config, failure.RolledBackTo, strings.Join(output, "; "))
+
+	if err := dn.reportPostUpdateVerificationFailure(failure); err != nil {
+		return err
+	}
+
+	if dn.nodeWriter != nil {
+		dn.nodeWriter.Eventf(corev1.EventTypeWarning, "PostUpdateVerificationFailed",
+			"Rolling back from %s to %s, post-update verification failed: %s", failure.Config, failure.RolledBackTo, strings.Join(output, "; "))
+	}
+
+	if err := dn.triggerUpdate(newConfig, oldConfig, newImage, oldImage); err != nil {
+		return fmt.Errorf("could not roll back to %s after post-update verification failed: %w", oldConfig.GetName(), err)
+	}
+
+	return nil
+}
+
+// checkRolledBackUpdate returns an error when the update to the config and
+// image was rolled back because the post-update verification failed, so that
+// the node is degraded instead of applying it again.
+func (dn *Daemon) checkRolledBackUpdate(desiredConfig, desiredImage string) error {
+	if dn.node == nil {
+		return nil
+	}
+
+	failure := ctrlcommon.GetPostUpdateVerificationFailure(dn.node)
+	if failure == nil || failure.Config != desiredConfig || failure.Image != desiredImage {
+		return nil
+	}
+
+	return fmt.Errorf("update to %s was rolled back to %s because the post-update verification failed: %s; request a resync or roll out a new config to retry",
+		failure.Config, failure.RolledBackTo, strings.Join(failure.Output, "; "))
+}
+
+// reportPostUpdateVerificationFailure records the failed post-update
+// verification in the node status, or clears it when failure is nil.
+func (dn *Daemon) reportPostUpdateVerificationFailure(failure *ctrlcommon.PostUpdateVerificationFailure) error {
+	if dn.nodeWriter == nil {
+		return nil
+	}
+
+	if failure == nil && ctrlcommon.GetPostUpdateVerificationFailure(dn.node) == nil {
+		return nil
+	}
+
+	val := ""
+	if failure != nil {
+		out, err := json.Marshal(failure)
+		if err != nil {
+			return fmt.Errorf("could not encode post-update verification failure: %w", err)
+		}
+		val = string(out)
+	}
+
+	node, err := dn.nodeWriter.SetNodeStatus(map[string]string{constants.PostUpdateVerificationFailureAnnotationKey: val})
+	if err != nil {
+		return fmt.Errorf("could not report post-update verification failure: %w", err)
+	}
+
+	// The rollback checks the reported failure right away.
+	if node != nil {
+		dn.node = node
+	}
+
+	return nil
+}
@@ -0,0 +1,64 @@
+package daemon
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	"github.com/openshift/machine-config-operator/pkg/daemon/constants"
+	"github.com/openshift/machine-config-operator/test/helpers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWaitForProbes(t *testing.T) {
+	attempts := 0
+	output := waitForProbes(func() []string {
+		attempts++
+		if attempts < 3 {
+			return []string{"unit kubelet.service is activating"}
+		}
+		return []string{}
+	}, time.Minute, time.Millisecond)
+	assert.Empty(t, output)
+	assert.Equal(t, 3, attempts)
+
+	attempts = 0
+	output = waitForProbes(func() []string {
+		attempts++
+		return []string{"unit kubelet.service is failed"}
+	}, 50*time.Millisecond, 10*time.Millisecond)
+	assert.Equal(t, []string{"unit kubelet.service is failed"}, output)
+	assert.Greater(t, attempts, 1)
+}
+
+func TestCheckHTTPGet(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/healthz" {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		http.Error(w, "not ready", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	assert.NoError(t, checkHTTPGet(server.URL+"/healthz"))
+	assert.ErrorContains(t, checkHTTPGet(server.URL+"/readyz"), "503 Service Unavailable: not ready")
+}
+
+func TestCheckRolledBackUpdate(t *testing.T) {
+	node := helpers.NewNodeBuilder("node").WithCurrentConfig("v1").WithDesiredConfig("v2").Node()
+	require.NoError(t, ctrlcommon.SetNodeStatus(node, map[string]string{
+		constants.PostUpdateVerificationFailureAnnotationKey: `{"config":"v2","rolledBackTo":"v1","output":["unit kubelet.service is failed"],"time":"2024-01-01T22:00:00Z"}`,
+	}))
+
+	dn := &Daemon{node: node}
+
+	assert.ErrorContains(t, dn.checkRolledBackUpdate("v2", ""), "update to v2 was rolled back to v1 because the post-update verification failed: unit kubelet.service is failed")
+	// Rolling back and updating to other configs or images is not held back.
+	assert.NoError(t, dn.checkRolledBackUpdate("v1", ""))
+	assert.NoError(t, dn.checkRolledBackUpdate("v3", ""))
+	assert.NoError(t, dn.checkRolledBackUpdate("v2", "registry/os@sha256:2"))
+}
@@ -0,0 +1,366 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/clarketm/json"
+	"github.com/containers/image/v5/types"
+	rpmostreeclient "github.com/coreos/rpmostree-client-go/pkg/client"
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	"github.com/openshift/machine-config-operator/pkg/daemon/constants"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+)
+
+// How long to wait before running the preflight checks of a skipped update
+// again.
+var preflightCheckRetryInterval = 5 * time.Minute
+
+// getNodePreflightChecks returns the preflight checks which the node
+// controller handed to the node in the PreflightChecksAnnotationKey
+// annotation, or nil if there are none.
+func getNodePreflightChecks(node *corev1.Node) *ctrlcommon.PreflightChecks {
+	checks, err := ctrlcommon.ParsePreflightChecks(node.Annotations[constants.PreflightChecksAnnotationKey])
+	if err != nil {
+		klog.Warningf("Ignoring invalid %s annotation: %v", constants.PreflightChecksAnnotationKey, err)
+		return nil
+	}
+
+	return checks
+}
+
+// OS images are pulled as compressed layers and unpacked into the ostree
+// repository, which takes up about twice as much space as the layers.
+const osImageUnpackFactor = 2
+
+// checkFreeDisk describes each filesystem with less than minFree bytes
+// available.
+func checkFreeDisk(free map[string]int64, minFree int64) []string {
+	return checkFree(free, minFree, "bytes")
+}
+
+// checkFreeInodes describes each filesystem with less than minFree inodes
+// free.
+func checkFreeInodes(free map[string]int64, minFree int64) []string {
+	return checkFree(free, minFree, "inodes")
+}
+
+func checkFree(free map[string]int64, minFree int64, unit string) []string {
+	failures := []string{}
+
+	paths := make([]string, 0, len(free))
+	for path := range free {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		if free[path] < minFree {
+			failures = append(failures, fmt.Sprintf("%s has %d %s free, less than the required %d", path, free[path], unit, minFree))
+		}
+	}
+
+	return failures
+}
+
+// getUpdateOSImage returns the OS image the update switches the node to, or ""
+// if the update keeps the OS image of the node.
+func getUpdateOSImage(ufc *updateFromCluster) string {
+	if ufc.desiredImage != "" {
+		if ufc.desiredImage != ufc.currentImage {
+			return ufc.desiredImage
+		}
+		return ""
+	}
+
+	// Leaving a layered image always switches to the OS image of the config.
+	desired := ufc.desiredConfig.Spec.OSImageURL
+	if ufc.currentImage != "" || desired != ufc.currentConfig.Spec.OSImageURL {
+		return desired
+	}
+
+	return ""
+}
+
+// estimateImageSize estimates how much disk space pulling the image takes up
+// from the sizes of its layers.
+func estimateImageSize(info *types.ImageInspectInfo) (int64, error) {
+	var size int64
+
+	for _, layer := range info.LayersData {
+		if layer.Size < 0 {
+			return 0, fmt.Errorf("size of layer %s is unknown", layer.Digest)
+		}
+		size += layer.Size
+	}
+
+	return size * osImageUnpackFactor, nil
+}
+
+// getImageSize estimates how much disk space pulling the image takes up.
+func getImageSize(image string) (int64, error) {
+	info, _, err := imageInspect(image)
+	if err != nil {
+		return 0, err
+	}
+
+	return estimateImageSize(info)
+}
+
+// checkDiskSpace checks the free disk space and inodes of /sysroot and /var.
+// imageSize is the disk space the OS image of the update takes up, which is
+// required on top of the minimum free disk space.
+func checkDiskSpace(checks *ctrlcommon.PreflightChecks, imageSize int64) []string {
+	failures := []string{}
+
+	// Validated by ParsePreflightChecks.
+	minFree, _ := checks.GetMinFreeDisk()
+	if minFree+imageSize > 0 {
+		free, err := getFreeDisk(freeDiskPaths)
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("could not check free disk space: %v", err))
+		} else {
+			failures = append(failures, checkFreeDisk(free, minFree+imageSize)...)
+		}
+	}
+
+	if checks.MinFreeInodes > 0 {
+		free, err := getFreeInodes(freeDiskPaths)
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("could not check free inodes: %v", err))
+		} else {
+			failures = append(failures, checkFreeInodes(free, checks.MinFreeInodes)...)
+		}
+	}
+
+	return failures
+}
+
+// pruneImages removes the container images which no container of the node
+// uses.
+func pruneImages() error {
+	_, err := runGetOut("crictl", "rmi", "--prune")
+	return err
+}
+
+// getDiskSpaceCheckFailures checks that the node has enough free disk space
+// and inodes for the update. The space the new OS image takes up is only
+// required on CoreOS nodes, which pull it. When the checks fail and images
+// are to be pruned, the unused container images are pruned and the checks
+// run again.
+func (dn *Daemon) getDiskSpaceCheckFailures(checks *ctrlcommon.PreflightChecks, ufc *updateFromCluster) []string {
+	failures := []string{}
+
+	var imageSize int64
+	if checks.ImageDiskSpace && dn.os.IsCoreOSVariant() {
+		if image := getUpdateOSImage(ufc); image != "" {
+			size, err := getImageSize(image)
+			if err != nil {
+				failures = append(failures, fmt.Sprintf("could not estimate the size of OS image %s: %v", image, err))
+			} else {
+				klog.Infof("OS image %s takes up about %d bytes", image, size)
+				imageSize = size
+			}
+		}
+	}
+
+	spaceFailures := checkDiskSpace(checks, imageSize)
+	if len(spaceFailures) != 0 && checks.PruneImages {
+		klog.Infof("Pruning unused container images: %s", strings.Join(spaceFailures, "; "))
+		if err := pruneImages(); err != nil {
+			failures = append(failures, fmt.Sprintf("could not prune container images: %v", err))
+		} else {
+			spaceFailures = checkDiskSpace(checks, imageSize)
+		}
+	}
+
+	return append(failures, spaceFailures...)
+}
+
+// checkOSTreeStatus checks that rpm-ostree is idle and the booted deployment
+// has not been unlocked, e.g. by `rpm-ostree usroverlay`.
+func checkOSTreeStatus(status *rpmostreeclient.Status) error {
+	if status.Transaction != nil && len(*status.Transaction) != 0 {
+		return fmt.Errorf("rpm-ostree transaction %s is in progress", strings.Join(*status.Transaction, " "))
+	}
+
+	booted, err := status.GetBootedDeployment()
+	if err != nil {
+		return fmt.Errorf("rpm-ostree reports no booted deployment: %w", err)
+	}
+
+	if booted.Unlocked != nil && *booted.Unlocked != "" && *booted.Unlocked != "none" {
+		return fmt.Errorf("booted deployment %s is unlocked (%s)", booted.ID, *booted.Unlocked)
+	}
+
+	return nil
+}
+
+// isMachineConfigFile returns whether the MachineConfig writes the file at
+// path. Only such scripts are run, so that the preflight checks cannot run
+// arbitrary binaries found on the node.
+func isMachineConfigFile(mc *mcfgv1.MachineConfig, path string) (bool, error) {
+	ignConfig, err := ctrlcommon.ParseAndConvertConfig(mc.Spec.Config.Raw)
+	if err != nil {
+		return false, fmt.Errorf("parsing Ignition config failed: %w", err)
+	}
+
+	for _, f := range ignConfig.Storage.Files {
+		if f.Path == path {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// runPreflightScript runs the script, killing it after the timeout. The
+// script fails when it does not exit successfully.
+func runPreflightScript(path string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	out, err := exec.CommandContext(ctx, path).CombinedOutput()
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("script %s did not finish within %s", path, timeout)
+	}
+	if err != nil {
+		return fmt.Errorf("script %s failed: %w: %s", path, err, truncate(strings.TrimSpace(string(out)), 256))
+	}
+
+	return nil
+}
+
+// getPreflightCheckFailures runs the preflight checks and describes each one
+// which failed. Checks which do not apply to the node, like the ostree check
+// on non-CoreOS nodes, are skipped.
+func (dn *Daemon) getPreflightCheckFailures(checks *ctrlcommon.PreflightChecks, ufc *updateFromCluster) []string {
+	failures := []string{}
+
+	if checks.ChecksDiskSpace() {
+		failures = append(failures, dn.getDiskSpaceCheckFailures(checks, ufc)...)
+	}
+
+	if checks.OSTreeDeployments && dn.os.IsCoreOSVariant() && dn.NodeUpdaterClient != nil {
+		status, err := dn.NodeUpdaterClient.client.QueryStatus()
+		if err == nil {
+			err = checkOSTreeStatus(status)
+		}
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("ostree: %v", err))
+		}
+	}
+
+	if checks.Script != "" {
+		timeout, _ := checks.GetScriptTimeout()
+		if err := runMachineConfigScript(checks.Script, timeout, ufc.currentConfig); err != nil {
+			failures = append(failures, err.Error())
+		}
+	}
+
+	return failures
+}
+
+// runMachineConfigScript runs the script if the MachineConfig provides it.
+func runMachineConfigScript(path string, timeout time.Duration, mc *mcfgv1.MachineConfig) error {
+	provided, err := isMachineConfigFile(mc, path)
+	if err != nil {
+		return fmt.Errorf("could not look up script %s: %w", path, err)
+	}
+	if !provided {
+		return fmt.Errorf("script %s is not provided by MachineConfig %s", path, mc.GetName())
+	}
+
+	if _, err := os.Stat(path); err != nil {
+		return fmt.Errorf("could not find script: %w", err)
+	}
+
+	return runPreflightScript(path, timeout)
+}
+
+// runPreflightChecks returns whether the update has to be skipped because
+// the preflight checks of the node failed. The checks run before the node is
+// cordoned, so a skipped node keeps running its workloads on its current
+// config. Failures are reported in the node status and the checks are run
+// again after preflightCheckRetryInterval. The forcefile skips the checks.
+func (dn *Daemon) runPreflightChecks(ufc *updateFromCluster) (bool, error) {
+	checks := getNodePreflightChecks(dn.node)
+	if checks == nil {
+		return false, dn.reportPreflightCheckFailure(nil)
+	}
+
+	if forceFileExists() {
+		klog.Infof("Skipping preflight checks; %s present", constants.MachineConfigDaemonForceFile)
+		return false, dn.reportPreflightCheckFailure(nil)
+	}
+
+	failures := dn.getPreflightCheckFailures(checks, ufc)
+	if len(failures) == 0 {
+		klog.Infof("Preflight checks passed for update to %s", ufc.desiredConfig.GetName())
+		return false, dn.reportPreflightCheckFailure(nil)
+	}
+
+	failure := &ctrlcommon.PreflightCheckFailure{
+		Config:   ufc.desiredConfig.GetName(),
+		Failures: failures,
+		Time:     time.Now().UTC().Format(time.RFC3339),
+	}
+
+	klog.Warningf("Skipping update to %s, preflight checks failed: %s; retrying in %s", failure.Config, strings.Join(failures, "; "), preflightCheckRetryInterval)
+
+	if err := dn.reportPreflightCheckFailure(failure); err != nil {
+		return false, err
+	}
+
+	dn.queue.AddAfter(dn.name, preflightCheckRetryInterval)
+
+	return true, nil
+}
+
+// reportPreflightCheckFailure records the failed preflight checks in the node
+// status, or clears them when failure is nil. An event is emitted when the
+// failed checks change.
+func (dn *Daemon) reportPreflightCheckFailure(failure *ctrlcommon.PreflightCheckFailure) error {
+	if dn.nodeWriter == nil {
+		return nil
+	}
+
+	reported := ctrlcommon.GetPreflightCheckFailure(dn.node)
+	if failure == nil && reported == nil {
+		return nil
+	}
+
+	// The time of the failure alone does not need to be reported again.
+	if failure != nil && reported != nil && failure.Config == reported.Config &&
+		strings.Join(failure.Failures, "\n") == strings.Join(reported.Failures, "\n") {
+		return nil
+	}
+
+	val := ""
+	if failure != nil {
+		out, err := json.Marshal(failure)
+		if err != nil {
+			return fmt.Errorf("could not encode preflight check failure: %w", err)
+		}
+		val = string(out)
+	}
+
+	if _, err := dn.nodeWriter.SetNodeStatus(map[string]string{constants.PreflightCheckFailureAnnotationKey: val}); err != nil {
+		return fmt.Errorf("could not report preflight check failure: %w", err)
+	}
+
+	if failure != nil {
+		dn.nodeWriter.Eventf(corev1.EventTypeWarning, "PreflightChecksFailed",
+			"Skipped update to %s, preflight checks failed: %s", failure.Config, strings.Join(failure.Failures, "; "))
+	}
+
+	return nil
+}
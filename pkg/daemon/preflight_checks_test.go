@@ -0,0 +1,138 @@
+package daemon
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/containers/image/v5/types"
+	ign3types "github.com/coreos/ignition/v2/config/v3_4/types"
+	rpmostreeclient "github.com/coreos/rpmostree-client-go/pkg/client"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	"github.com/openshift/machine-config-operator/test/helpers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckFreeDisk(t *testing.T) {
+	free := map[string]int64{"/var": 1024, "/sysroot": 4096}
+
+	assert.Empty(t, checkFreeDisk(free, 1024))
+	assert.Equal(t, []string{"/var has 1024 bytes free, less than the required 2048"}, checkFreeDisk(free, 2048))
+	assert.Len(t, checkFreeDisk(free, 8192), 2)
+}
+
+func TestCheckFreeInodes(t *testing.T) {
+	free := map[string]int64{"/var": 1000, "/sysroot": 5000}
+
+	assert.Empty(t, checkFreeInodes(free, 1000))
+	assert.Equal(t, []string{"/var has 1000 inodes free, less than the required 2000"}, checkFreeInodes(free, 2000))
+	assert.Len(t, checkFreeInodes(free, 10000), 2)
+}
+
+func TestGetUpdateOSImage(t *testing.T) {
+	oldConfig := helpers.NewMachineConfig("old", nil, "quay.io/openshift/os@sha256:old", nil)
+	newConfig := helpers.NewMachineConfig("new", nil, "quay.io/openshift/os@sha256:new", nil)
+
+	testCases := []struct {
+		name     string
+		ufc      *updateFromCluster
+		expected string
+	}{
+		{
+			name:     "Config update keeping the OS image",
+			ufc:      &updateFromCluster{currentConfig: oldConfig, desiredConfig: oldConfig},
+			expected: "",
+		},
+		{
+			name:     "Config update switching the OS image",
+			ufc:      &updateFromCluster{currentConfig: oldConfig, desiredConfig: newConfig},
+			expected: "quay.io/openshift/os@sha256:new",
+		},
+		{
+			name:     "Switching to a layered image",
+			ufc:      &updateFromCluster{currentConfig: oldConfig, desiredConfig: oldConfig, desiredImage: "registry/layered@sha256:a"},
+			expected: "registry/layered@sha256:a",
+		},
+		{
+			name:     "Keeping the layered image",
+			ufc:      &updateFromCluster{currentConfig: oldConfig, desiredConfig: newConfig, currentImage: "registry/layered@sha256:a", desiredImage: "registry/layered@sha256:a"},
+			expected: "",
+		},
+		{
+			name:     "Leaving the layered image",
+			ufc:      &updateFromCluster{currentConfig: oldConfig, desiredConfig: oldConfig, currentImage: "registry/layered@sha256:a"},
+			expected: "quay.io/openshift/os@sha256:old",
+		},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+		t.Run(testCase.name, func(t *testing.T) {
+			assert.Equal(t, testCase.expected, getUpdateOSImage(testCase.ufc))
+		})
+	}
+}
+
+func TestEstimateImageSize(t *testing.T) {
+	size, err := estimateImageSize(&types.ImageInspectInfo{
+		LayersData: []types.ImageInspectLayer{{Digest: "sha256:a", Size: 1024}, {Digest: "sha256:b", Size: 2048}},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3072*osImageUnpackFactor), size)
+
+	_, err = estimateImageSize(&types.ImageInspectInfo{
+		LayersData: []types.ImageInspectLayer{{Digest: "sha256:a", Size: 1024}, {Digest: "sha256:b", Size: -1}},
+	})
+	assert.ErrorContains(t, err, "size of layer sha256:b is unknown")
+}
+
+func TestCheckOSTreeStatus(t *testing.T) {
+	none := "none"
+	transient := "transient"
+
+	assert.NoError(t, checkOSTreeStatus(&rpmostreeclient.Status{
+		Deployments: []rpmostreeclient.Deployment{{ID: "a", Booted: true, Unlocked: &none}},
+	}))
+	assert.ErrorContains(t, checkOSTreeStatus(&rpmostreeclient.Status{
+		Deployments: []rpmostreeclient.Deployment{{ID: "a", Booted: true}},
+		Transaction: &[]string{"deploy"},
+	}), "transaction deploy is in progress")
+	assert.ErrorContains(t, checkOSTreeStatus(&rpmostreeclient.Status{
+		Deployments: []rpmostreeclient.Deployment{{ID: "a", Booted: false}},
+	}), "no booted deployment")
+	assert.ErrorContains(t, checkOSTreeStatus(&rpmostreeclient.Status{
+		Deployments: []rpmostreeclient.Deployment{{ID: "a", Booted: true, Unlocked: &transient}},
+	}), "is unlocked (transient)")
+}
+
+func TestRunMachineConfigScript(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	writeScript := func(name, contents string) string {
+		path := filepath.Join(tmpDir, name)
+		require.NoError(t, os.WriteFile(path, []byte("#!/bin/sh\n"+contents+"\n"), 0o755))
+		return path
+	}
+
+	passing := writeScript("passing", "exit 0")
+	failing := writeScript("failing", "echo not ready; exit 1")
+	hanging := writeScript("hanging", "exec sleep 10")
+	unmanaged := writeScript("unmanaged", "exit 0")
+
+	ignConfig := ctrlcommon.NewIgnConfig()
+	for _, path := range []string{passing, failing, hanging, filepath.Join(tmpDir, "missing")} {
+		ignConfig.Storage.Files = append(ignConfig.Storage.Files, helpers.CreateEncodedIgn3File(path, "", 0o755))
+	}
+	mc := helpers.CreateMachineConfigFromIgnition(ignConfig)
+
+	assert.NoError(t, runMachineConfigScript(passing, time.Minute, mc))
+	assert.ErrorContains(t, runMachineConfigScript(failing, time.Minute, mc), "not ready")
+	assert.ErrorContains(t, runMachineConfigScript(hanging, 100*time.Millisecond, mc), "did not finish within")
+	assert.ErrorContains(t, runMachineConfigScript(unmanaged, time.Minute, mc), "is not provided by MachineConfig")
+	assert.ErrorContains(t, runMachineConfigScript(filepath.Join(tmpDir, "missing"), time.Minute, mc), "could not find script")
+
+	// Only the files of the Ignition config count.
+	assert.ErrorContains(t, runMachineConfigScript(passing, time.Minute, helpers.CreateMachineConfigFromIgnition(ign3types.Config{Ignition: ignConfig.Ignition})), "is not provided")
+}
@@ -0,0 +1,74 @@
+package daemon
+
+import (
+	"fmt"
+
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	"github.com/openshift/machine-config-operator/pkg/daemon/constants"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+)
+
+// isUserspaceOnly returns whether the diff leaves the OS, the kernel and its
+// arguments alone, so that the node does not need to go through its firmware
+// and bootloader to apply it.
+func (mcDiff *machineConfigDiff) isUserspaceOnly() bool {
+	return !mcDiff.osUpdate && !mcDiff.kargs && !mcDiff.fips && !mcDiff.kernelType && !mcDiff.extensions && !mcDiff.luks
+}
+
+// getNodeRebootStrategy returns how the node controller told the MCD to
+// reboot the node on userspace-only updates. Unknown strategies fully reboot
+// the node.
+func getNodeRebootStrategy(node *corev1.Node) string {
+	if node == nil {
+		return ctrlcommon.RebootStrategyReboot
+	}
+
+	switch strategy := node.Annotations[constants.RebootStrategyAnnotationKey]; strategy {
+	case ctrlcommon.RebootStrategyKexec, ctrlcommon.RebootStrategySoftReboot:
+		return strategy
+	case "":
+	default:
+		klog.Warningf("Ignoring unknown reboot strategy %q", strategy)
+	}
+
+	return ctrlcommon.RebootStrategyReboot
+}
+
+// rebootStrategyCommand returns the shell command rebooting the node with the
+// given strategy. Should kexec or soft-reboot not be available on the node,
+// it is fully rebooted instead.
+func rebootStrategyCommand(strategy string) string {
+	switch strategy {
+	case ctrlcommon.RebootStrategyKexec:
+		return "systemctl kexec || systemctl reboot"
+	case ctrlcommon.RebootStrategySoftReboot:
+		return "systemctl soft-reboot || systemctl reboot"
+	default:
+		return "systemctl reboot"
+	}
+}
+
+// getRebootStrategy returns how to reboot the node to apply the diff. Only
+// userspace-only updates skip the firmware, and only as long as no OS
+// deployment is pending, e.g. from kernel tuning arguments, since it would
+// not be booted otherwise.
+func (dn *Daemon) getRebootStrategy(diff *machineConfigDiff) (string, error) {
+	strategy := getNodeRebootStrategy(dn.node)
+	if strategy == ctrlcommon.RebootStrategyReboot || !diff.isUserspaceOnly() {
+		return ctrlcommon.RebootStrategyReboot, nil
+	}
+
+	if dn.os.IsCoreOSVariant() {
+		_, staged, err := dn.NodeUpdaterClient.GetBootedAndStagedDeployment()
+		if err != nil {
+			return "", fmt.Errorf("could not get OS deployments: %w", err)
+		}
+		if staged != nil {
+			klog.Infof("Not using reboot strategy %s, OS deployment %s is pending", strategy, staged.ID)
+			return ctrlcommon.RebootStrategyReboot, nil
+		}
+	}
+
+	return strategy, nil
+}
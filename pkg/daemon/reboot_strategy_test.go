@@ -0,0 +1,54 @@
+package daemon
+
+import (
+	"testing"
+
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	"github.com/openshift/machine-config-operator/pkg/daemon/constants"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestIsUserspaceOnly(t *testing.T) {
+	testCases := []struct {
+		name     string
+		diff     machineConfigDiff
+		expected bool
+	}{
+		{name: "files and units", diff: machineConfigDiff{files: true, units: true, passwd: true}, expected: true},
+		{name: "OS update", diff: machineConfigDiff{files: true, osUpdate: true}},
+		{name: "kernel arguments", diff: machineConfigDiff{kargs: true}},
+		{name: "kernel type", diff: machineConfigDiff{kernelType: true}},
+		{name: "extensions", diff: machineConfigDiff{extensions: true}},
+		{name: "FIPS", diff: machineConfigDiff{fips: true}},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			assert.Equal(t, testCase.expected, testCase.diff.isUserspaceOnly())
+		})
+	}
+}
+
+func TestGetNodeRebootStrategy(t *testing.T) {
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{}}}
+	assert.Equal(t, ctrlcommon.RebootStrategyReboot, getNodeRebootStrategy(node))
+	assert.Equal(t, ctrlcommon.RebootStrategyReboot, getNodeRebootStrategy(nil))
+
+	node.Annotations[constants.RebootStrategyAnnotationKey] = ctrlcommon.RebootStrategyKexec
+	assert.Equal(t, ctrlcommon.RebootStrategyKexec, getNodeRebootStrategy(node))
+
+	node.Annotations[constants.RebootStrategyAnnotationKey] = ctrlcommon.RebootStrategySoftReboot
+	assert.Equal(t, ctrlcommon.RebootStrategySoftReboot, getNodeRebootStrategy(node))
+
+	// Unknown strategies fully reboot the node.
+	node.Annotations[constants.RebootStrategyAnnotationKey] = "Halt"
+	assert.Equal(t, ctrlcommon.RebootStrategyReboot, getNodeRebootStrategy(node))
+}
+
+func TestRebootStrategyCommand(t *testing.T) {
+	assert.Equal(t, "systemctl reboot", rebootStrategyCommand(ctrlcommon.RebootStrategyReboot))
+	assert.Equal(t, "systemctl kexec || systemctl reboot", rebootStrategyCommand(ctrlcommon.RebootStrategyKexec))
+	assert.Equal(t, "systemctl soft-reboot || systemctl reboot", rebootStrategyCommand(ctrlcommon.RebootStrategySoftReboot))
+}
@@ -0,0 +1,262 @@
+package daemon
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/clarketm/json"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	"github.com/openshift/machine-config-operator/pkg/daemon/constants"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+)
+
+// previousConfig is what the MCD reports under PreviousConfigAnnotationKey
+// when it completes an update: the config and OS image the node updated from.
+type previousConfig struct {
+	Config string `json:"config"`
+	Image  string `json:"image,omitempty"`
+}
+
+// rollback is what the MCD reports under LastRollbackAnnotationKey when it
+// rolls a node back in response to a rollback request.
+type rollback struct {
+	// Request is the value of the rollback request.
+	Request string `json:"request"`
+	// Config and Image are what the node was rolled back from.
+	Config string `json:"config"`
+	Image  string `json:"image,omitempty"`
+	// RolledBackTo and RolledBackToImage are what the node was rolled back to.
+	RolledBackTo      string `json:"rolledBackTo"`
+	RolledBackToImage string `json:"rolledBackToImage,omitempty"`
+	// Time is when the rollback was requested.
+	Time string `json:"time"`
+}
+
+// getPreviousConfig returns the config and OS image the node was on before
+// its last update, if the MCD recorded them.
+func getPreviousConfig(node *corev1.Node) *previousConfig {
+	val, ok := ctrlcommon.GetNodeStatus(node, constants.PreviousConfigAnnotationKey)
+	if !ok || val == "" {
+		return nil
+	}
+
+	previous := &previousConfig{}
+	if err := json.Unmarshal([]byte(val), previous); err != nil || previous.Config == "" {
+		klog.Warningf("Ignoring invalid previous config %q: %v", val, err)
+		return nil
+	}
+
+	return previous
+}
+
+// getLastRollback returns the last rollback the MCD performed in response to
+// a rollback request, if any.
+func getLastRollback(node *corev1.Node) *rollback {
+	val, ok := ctrlcommon.GetNodeStatus(node, constants.LastRollbackAnnotationKey)
+	if !ok || val == "" {
+		return nil
+	}
+
+	r := &rollback{}
+	if err := json.Unmarshal([]byte(val), r); err != nil {
+		klog.Warningf("Ignoring invalid last rollback %q: %v", val, err)
+		return nil
+	}
+
+	return r
+}
+
+// getPendingRollbackRequest returns the rollback request set on the node if
+// it has not been handled yet.
+func getPendingRollbackRequest(node *corev1.Node) (string, bool) {
+	request := node.Annotations[constants.RollbackRequestAnnotationKey]
+	if request == "" || request == node.Annotations[constants.LastAppliedRollbackRequestAnnotationKey] {
+		return "", false
+	}
+
+	return request, true
+}
+
+// reportPreviousConfig records the config and OS image the node updated from
+// when it completes an update to the state's current config and image. Until
+// then, the node's current config annotations still name what it updated
+// from.
+func (dn *Daemon) reportPreviousConfig(state *stateAndConfigs) error {
+	if dn.nodeWriter == nil || dn.node == nil {
+		return nil
+	}
+
+	previous := previousConfig{
+		Config: dn.node.Annotations[constants.CurrentMachineConfigAnnotationKey],
+		Image:  dn.node.Annotations[constants.CurrentImageAnnotationKey],
+	}
+	if previous.Config == "" || (previous.Config == state.currentConfig.GetName() && previous.Image == state.currentImage) {
+		return nil
+	}
+
+	out, err := json.Marshal(previous)
+	if err != nil {
+		return fmt.Errorf("could not encode previous config: %w", err)
+	}
+
+	if _, err := dn.nodeWriter.SetNodeStatus(map[string]string{constants.PreviousConfigAnnotationKey: string(out)}); err != nil {
+		return fmt.Errorf("could not report previous config: %w", err)
+	}
+
+	return nil
+}
+
+// reportLastRollback records the rollback in the node status, or clears it
+// when r is nil.
+func (dn *Daemon) reportLastRollback(r *rollback) error {
+	if dn.nodeWriter == nil {
+		return nil
+	}
+
+	if r == nil && getLastRollback(dn.node) == nil {
+		return nil
+	}
+
+	val := ""
+	if r != nil {
+		out, err := json.Marshal(r)
+		if err != nil {
+			return fmt.Errorf("could not encode rollback: %w", err)
+		}
+		val = string(out)
+	}
+
+	node, err := dn.nodeWriter.SetNodeStatus(map[string]string{constants.LastRollbackAnnotationKey: val})
+	if err != nil {
+		return fmt.Errorf("could not report rollback: %w", err)
+	}
+
+	// The rollback is checked right away to hold the node.
+	if node != nil {
+		dn.node = node
+	}
+
+	return nil
+}
+
+// rejectRollbackRequest acknowledges a rollback request which cannot be
+// performed, so that it is not retried, and explains why in an event.
+func (dn *Daemon) rejectRollbackRequest(request, reason string) error {
+	klog.Warningf("Not handling rollback request %s: %s", request, reason)
+
+	if _, err := dn.nodeWriter.SetAnnotations(map[string]string{constants.LastAppliedRollbackRequestAnnotationKey: request}); err != nil {
+		return fmt.Errorf("could not acknowledge rollback request: %w", err)
+	}
+
+	dn.nodeWriter.Eventf(corev1.EventTypeWarning, "RollbackFailed", "Not rolling back in response to rollback request %s: %s", request, reason)
+
+	return nil
+}
+
+// handleRollbackRequest rolls the node back to the config and OS image it was
+// on before its last update in response to an admin setting the rollback
+// request annotation on the node. The rollback deployment of rpm-ostree is
+// removed once an update is verified, so the node is rolled back with the
+// regular update flow instead, which rebases onto the previous OS image and
+// writes the files and units of the previous config. The node is then held
+// there until a resync is requested or the node is handed a new config.
+func (dn *Daemon) handleRollbackRequest(request string) error {
+	logSystem("Rollback requested via %s=%s", constants.RollbackRequestAnnotationKey, request)
+
+	// The on-disk config is the last config we successfully applied.
+	odc, err := dn.getCurrentConfigOnDisk()
+	if err != nil {
+		return fmt.Errorf("could not get on-disk config: %w", err)
+	}
+
+	previous := getPreviousConfig(dn.node)
+	if previous == nil || (previous.Config == odc.currentConfig.GetName() && previous.Image == odc.currentImage) {
+		return dn.rejectRollbackRequest(request, fmt.Sprintf("no update of the node to roll back, it is on %s", odc.currentConfig.GetName()))
+	}
+
+	previousMC, err := dn.getMachineConfig(previous.Config)
+	if err != nil {
+		return dn.rejectRollbackRequest(request, fmt.Sprintf("could not get previous config: %v", err))
+	}
+
+	// Mark the request as handled before updating so that it is not handled
+	// again if the update reboots the node.
+	if _, err := dn.nodeWriter.SetAnnotations(map[string]string{constants.LastAppliedRollbackRequestAnnotationKey: request}); err != nil {
+		return fmt.Errorf("could not acknowledge rollback request: %w", err)
+	}
+
+	r := &rollback{
+		Request:           request,
+		Config:            odc.currentConfig.GetName(),
+		Image:             odc.currentImage,
+		RolledBackTo:      previous.Config,
+		RolledBackToImage: previous.Image,
+		Time:              time.Now().UTC().Format(time.RFC3339),
+	}
+
+	if err := dn.reportLastRollback(r); err != nil {
+		return err
+	}
+
+	dn.nodeWriter.Eventf(corev1.EventTypeNormal, "RollbackRequested", "Rolling back from %s to %s in response to rollback request %s", r.Config, r.RolledBackTo, request)
+
+	return dn.triggerUpdate(odc.currentConfig, previousMC, odc.currentImage, previous.Image)
+}
+
+// isRolledBack returns whether the node was rolled back from the config and
+// image in response to a rollback request, in which case it is not updated
+// to them again.
+func (dn *Daemon) isRolledBack(desiredConfig, desiredImage string) bool {
+	if dn.node == nil {
+		return false
+	}
+
+	r := getLastRollback(dn.node)
+	return r != nil && r.Config == desiredConfig && r.Image == desiredImage
+}
+
+// completeRollback completes the rollback the node is held at instead of
+// updating it: the node is uncordoned and marked Done at the config and image
+// it was rolled back to.
+func (dn *Daemon) completeRollback() error {
+	state, err := dn.getStateAndConfigs()
+	if err != nil {
+		return err
+	}
+
+	odc, err := dn.getCurrentConfigOnDisk()
+	if err != nil {
+		return fmt.Errorf("could not get on-disk config: %w", err)
+	}
+	state.currentConfig = odc.currentConfig
+	state.currentImage = odc.currentImage
+
+	r := getLastRollback(dn.node)
+
+	if state.state == constants.MachineConfigDaemonStateDone &&
+		dn.node.Annotations[constants.CurrentMachineConfigAnnotationKey] == state.currentConfig.GetName() &&
+		dn.node.Annotations[constants.CurrentImageAnnotationKey] == state.currentImage {
+		klog.V(2).Infof("Node is held at %s, rolled back from %s", state.getCurrentName(), r.Config)
+		return nil
+	}
+
+	logSystem("Completing rollback to %s from %s", state.getCurrentName(), r.Config)
+
+	if err := dn.completeUpdate(state.currentConfig.GetName()); err != nil {
+		return err
+	}
+
+	if err := dn.nodeWriter.SetDone(state); err != nil {
+		return fmt.Errorf("error setting node's state to Done: %w", err)
+	}
+
+	if err := dn.reportOSUpdateProgress(nil); err != nil {
+		klog.Warningf("Could not clear OS update progress: %v", err)
+	}
+
+	dn.nodeWriter.Eventf(corev1.EventTypeNormal, "RolledBack",
+		"Rolled back from %s to %s in response to rollback request %s; request a resync or roll out a new config to update the node again", r.Config, state.getCurrentName(), r.Request)
+
+	return nil
+}
@@ -0,0 +1,61 @@
+package daemon
+
+import (
+	"testing"
+
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	"github.com/openshift/machine-config-operator/pkg/daemon/constants"
+	"github.com/openshift/machine-config-operator/test/helpers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestGetPendingRollbackRequest(t *testing.T) {
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{}}}
+
+	_, ok := getPendingRollbackRequest(node)
+	assert.False(t, ok)
+
+	node.Annotations[constants.RollbackRequestAnnotationKey] = "2024-01-01T12:00:00Z"
+	request, ok := getPendingRollbackRequest(node)
+	assert.True(t, ok)
+	assert.Equal(t, "2024-01-01T12:00:00Z", request)
+
+	node.Annotations[constants.LastAppliedRollbackRequestAnnotationKey] = "2024-01-01T12:00:00Z"
+	_, ok = getPendingRollbackRequest(node)
+	assert.False(t, ok)
+}
+
+func TestGetPreviousConfig(t *testing.T) {
+	node := helpers.NewNodeBuilder("node").Node()
+	assert.Nil(t, getPreviousConfig(node))
+
+	require.NoError(t, ctrlcommon.SetNodeStatus(node, map[string]string{
+		constants.PreviousConfigAnnotationKey: `{"config":"v1","image":"registry/os@sha256:1"}`,
+	}))
+	assert.Equal(t, &previousConfig{Config: "v1", Image: "registry/os@sha256:1"}, getPreviousConfig(node))
+
+	require.NoError(t, ctrlcommon.SetNodeStatus(node, map[string]string{
+		constants.PreviousConfigAnnotationKey: `{"image":"registry/os@sha256:1"}`,
+	}))
+	assert.Nil(t, getPreviousConfig(node))
+}
+
+func TestIsRolledBack(t *testing.T) {
+	node := helpers.NewNodeBuilder("node").WithCurrentConfig("v1").WithDesiredConfig("v2").Node()
+	dn := &Daemon{node: node}
+
+	assert.False(t, dn.isRolledBack("v2", ""))
+
+	require.NoError(t, ctrlcommon.SetNodeStatus(node, map[string]string{
+		constants.LastRollbackAnnotationKey: `{"request":"2024-01-01T12:00:00Z","config":"v2","rolledBackTo":"v1","time":"2024-01-01T12:00:05Z"}`,
+	}))
+
+	assert.True(t, dn.isRolledBack("v2", ""))
+	// Rolling back and updating to other configs or images is not held back.
+	assert.False(t, dn.isRolledBack("v1", ""))
+	assert.False(t, dn.isRolledBack("v3", ""))
+	assert.False(t, dn.isRolledBack("v2", "registry/os@sha256:2"))
+}
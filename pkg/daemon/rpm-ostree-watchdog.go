@@ -0,0 +1,187 @@
+package daemon
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/openshift/machine-config-operator/pkg/daemon/constants"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// How often the watchdog checks for an active rpm-ostree transaction.
+	rpmOstreeWatchdogPollingInterval = time.Minute
+	// How long the same transaction may be active before it is considered hung.
+	// Pulling a large OS image over a slow link can legitimately take a while.
+	rpmOstreeTransactionTimeout = time.Hour
+	// How many times the watchdog tries to recover from a single hung transaction
+	// before giving up and leaving it to an admin.
+	rpmOstreeMaxRecoveryAttempts = 3
+
+	// Recovery actions, in the order they are attempted.
+	rpmOstreeRecoveryCancel  = "cancel"
+	rpmOstreeRecoveryRestart = "restart"
+)
+
+// rpmOstreeRecoveryStatus is written as JSON to the node status under
+// RpmOstreeRecoveryAnnotationKey so that admins can see what the watchdog did.
+type rpmOstreeRecoveryStatus struct {
+	// The hung transaction, as reported by rpm-ostree status.
+	Transaction string `json:"transaction"`
+	// How many recovery attempts were made for the transaction.
+	Attempts int `json:"attempts"`
+	// The last recovery action taken.
+	LastAction string `json:"lastAction"`
+	// When the last recovery action was taken.
+	LastAttempt time.Time `json:"lastAttempt"`
+	// The error from the last recovery action, if any.
+	LastError string `json:"lastError,omitempty"`
+}
+
+// rpmOstreeWatchdog detects rpm-ostree transactions which have been active for
+// too long and tries to recover from them, first by cancelling the
+// transaction, then by restarting rpm-ostreed.
+type rpmOstreeWatchdog struct {
+	timeout     time.Duration
+	maxAttempts int
+	now         func() time.Time
+
+	// Returns the active transaction, or nil if there is none.
+	getTransaction func() (*[]string, error)
+	cancel         func() error
+	restart        func() error
+	report         func(rpmOstreeRecoveryStatus) error
+
+	transaction string
+	since       time.Time
+	attempts    int
+}
+
+func newRpmOstreeWatchdog(dn *Daemon) *rpmOstreeWatchdog {
+	return &rpmOstreeWatchdog{
+		timeout:     rpmOstreeTransactionTimeout,
+		maxAttempts: rpmOstreeMaxRecoveryAttempts,
+		now:         time.Now,
+		getTransaction: func() (*[]string, error) {
+			status, err := dn.NodeUpdaterClient.client.QueryStatus()
+			if err != nil {
+				return nil, err
+			}
+			return status.Transaction, nil
+		},
+		cancel: func() error {
+			return runRpmOstree("cancel")
+		},
+		restart: func() error {
+			return runCmdSync("systemctl", "restart", "rpm-ostreed")
+		},
+		report: dn.reportRpmOstreeRecovery,
+	}
+}
+
+// check looks at the active transaction and takes a recovery action if it has
+// been active for longer than the timeout.
+func (w *rpmOstreeWatchdog) check() {
+	txn, err := w.getTransaction()
+	if err != nil {
+		klog.Warningf("rpm-ostree watchdog could not get status: %v", err)
+		return
+	}
+
+	if txn == nil || len(*txn) == 0 {
+		w.transaction = ""
+		w.attempts = 0
+		return
+	}
+
+	current := strings.Join(*txn, " ")
+	if current != w.transaction {
+		w.transaction = current
+		w.since = w.now()
+		w.attempts = 0
+		return
+	}
+
+	if w.now().Sub(w.since) < w.timeout {
+		return
+	}
+
+	if w.attempts >= w.maxAttempts {
+		klog.V(2).Infof("rpm-ostree transaction %q still active after %d recovery attempts", current, w.attempts)
+		return
+	}
+
+	w.attempts++
+
+	// Cancelling the transaction is the least disruptive option, so try it
+	// first. If the transaction is still there next time, restart the daemon.
+	action := rpmOstreeRecoveryCancel
+	recoverFn := w.cancel
+	if w.attempts > 1 {
+		action = rpmOstreeRecoveryRestart
+		recoverFn = w.restart
+	}
+
+	logSystem("rpm-ostree transaction %q has been active since %s, attempting recovery %d/%d: %s", current, w.since.Format(time.RFC3339), w.attempts, w.maxAttempts, action)
+
+	status := rpmOstreeRecoveryStatus{
+		Transaction: current,
+		Attempts:    w.attempts,
+		LastAction:  action,
+		LastAttempt: w.now(),
+	}
+
+	if err := recoverFn(); err != nil {
+		klog.Errorf("rpm-ostree recovery action %s failed: %v", action, err)
+		status.LastError = err.Error()
+	}
+
+	// Give the next action the full timeout to take effect.
+	w.since = w.now()
+
+	if err := w.report(status); err != nil {
+		klog.Warningf("Could not report rpm-ostree recovery: %v", err)
+	}
+}
+
+// run periodically checks for hung transactions until stopped.
+func (w *rpmOstreeWatchdog) run(stopCh <-chan struct{}) {
+	for {
+		select {
+		case <-stopCh:
+			return
+		case <-time.After(rpmOstreeWatchdogPollingInterval):
+			w.check()
+		}
+	}
+}
+
+// reportRpmOstreeRecovery records the recovery attempt on the node and emits
+// an event for it.
+func (dn *Daemon) reportRpmOstreeRecovery(status rpmOstreeRecoveryStatus) error {
+	mcdRpmOstreeRecoveries.WithLabelValues(status.LastAction).Inc()
+
+	if dn.nodeWriter == nil {
+		return nil
+	}
+
+	eventType := corev1.EventTypeNormal
+	msg := fmt.Sprintf("Attempted to recover from hung rpm-ostree transaction %q (attempt %d): %s", status.Transaction, status.Attempts, status.LastAction)
+	if status.LastError != "" {
+		eventType = corev1.EventTypeWarning
+		msg = fmt.Sprintf("%s failed: %s", msg, status.LastError)
+	}
+
+	dn.nodeWriter.Eventf(eventType, "RpmOstreeRecovery", "%s", msg)
+
+	out, err := json.Marshal(status)
+	if err != nil {
+		return err
+	}
+
+	_, err = dn.nodeWriter.SetNodeStatus(map[string]string{constants.RpmOstreeRecoveryAnnotationKey: string(out)})
+	return err
+}
@@ -0,0 +1,87 @@
+package daemon
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRpmOstreeWatchdog(t *testing.T) {
+	now := time.Date(2023, 10, 15, 12, 0, 0, 0, time.UTC)
+
+	var txn *[]string
+	actions := []string{}
+	reports := []rpmOstreeRecoveryStatus{}
+
+	w := &rpmOstreeWatchdog{
+		timeout:     time.Hour,
+		maxAttempts: 3,
+		now:         func() time.Time { return now },
+		getTransaction: func() (*[]string, error) {
+			return txn, nil
+		},
+		cancel: func() error {
+			actions = append(actions, rpmOstreeRecoveryCancel)
+			return nil
+		},
+		restart: func() error {
+			actions = append(actions, rpmOstreeRecoveryRestart)
+			return fmt.Errorf("restart failed")
+		},
+		report: func(status rpmOstreeRecoveryStatus) error {
+			reports = append(reports, status)
+			return nil
+		},
+	}
+
+	// Advances the clock and runs a check.
+	checkAfter := func(d time.Duration) {
+		now = now.Add(d)
+		w.check()
+	}
+
+	// No transaction, nothing to do.
+	checkAfter(0)
+	assert.Empty(t, actions)
+
+	// A transaction which finishes within the timeout is left alone.
+	txn = &[]string{"rebase", "client(id:machine-config-operator)", "/org/projectatomic/rpmostree1/rhcos/1"}
+	checkAfter(0)
+	checkAfter(30 * time.Minute)
+	assert.Empty(t, actions)
+
+	// A different transaction resets the clock.
+	txn = &[]string{"rebase", "client(id:machine-config-operator)", "/org/projectatomic/rpmostree1/rhcos/2"}
+	checkAfter(45 * time.Minute)
+	checkAfter(45 * time.Minute)
+	assert.Empty(t, actions)
+
+	// Once it has been active for too long, it is cancelled first.
+	checkAfter(15 * time.Minute)
+	assert.Equal(t, []string{rpmOstreeRecoveryCancel}, actions)
+
+	// Each further action waits for the timeout again.
+	checkAfter(30 * time.Minute)
+	assert.Equal(t, []string{rpmOstreeRecoveryCancel}, actions)
+
+	// Then rpm-ostreed is restarted, up to the maximum number of attempts.
+	checkAfter(30 * time.Minute)
+	checkAfter(time.Hour)
+	checkAfter(time.Hour)
+	assert.Equal(t, []string{rpmOstreeRecoveryCancel, rpmOstreeRecoveryRestart, rpmOstreeRecoveryRestart}, actions)
+
+	assert.Len(t, reports, 3)
+	assert.Equal(t, 1, reports[0].Attempts)
+	assert.Empty(t, reports[0].LastError)
+	assert.Equal(t, 3, reports[2].Attempts)
+	assert.Equal(t, rpmOstreeRecoveryRestart, reports[2].LastAction)
+	assert.Equal(t, "restart failed", reports[2].LastError)
+	assert.Equal(t, "rebase client(id:machine-config-operator) /org/projectatomic/rpmostree1/rhcos/2", reports[2].Transaction)
+
+	// Once the transaction goes away, the attempts are reset.
+	txn = nil
+	checkAfter(time.Minute)
+	assert.Equal(t, 0, w.attempts)
+}
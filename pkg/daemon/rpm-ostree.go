@@ -12,6 +12,7 @@ import (
 
 	rpmostreeclient "github.com/coreos/rpmostree-client-go/pkg/client"
 	"github.com/opencontainers/go-digest"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
 	pivotutils "github.com/openshift/machine-config-operator/pkg/daemon/pivot/utils"
 	"gopkg.in/yaml.v2"
 	"k8s.io/klog/v2"
@@ -23,9 +24,9 @@ const (
 	// Default ostreeAuthFile location
 	ostreeAuthFile = "/run/ostree/auth.json"
 	// Pull secret.  Written by the machine-config-operator
-	kubeletAuthFile = "/var/lib/kubelet/config.json"
+	kubeletAuthFile = ctrlcommon.KubeletPullSecretPath
 	// Internal Registry Pull secret + Global Pull secret.  Written by the machine-config-operator.
-	imageRegistryAuthFile = "/etc/mco/internal-registry-pull-secret.json"
+	imageRegistryAuthFile = ctrlcommon.InternalRegistryPullSecretPath
 )
 
 // imageInspection is a public implementation of
@@ -236,12 +237,13 @@ func (r *RpmOstreeClient) IsNewEnoughForLayering() (bool, error) {
 	return false, nil
 }
 
-// RebaseLayered rebases system or errors if already rebased
-func (r *RpmOstreeClient) RebaseLayered(imgURL string) (err error) {
+// RebaseLayered rebases system or errors if already rebased. Each line of
+// progress rpm-ostree prints is handed to onProgress.
+func (r *RpmOstreeClient) RebaseLayered(imgURL string, onProgress func(string)) (err error) {
 	// Try to re-link the merged pull secrets if they exist, since it could have been populated without a daemon reboot
 	useMergedPullSecrets()
 	klog.Infof("Executing rebase to %s", imgURL)
-	return runRpmOstree("rebase", "--experimental", "ostree-unverified-registry:"+imgURL)
+	return runCmdSyncWithProgress(onProgress, "rpm-ostree", "rebase", "--experimental", "ostree-unverified-registry:"+imgURL)
 }
 
 // linkOstreeAuthFile gives the rpm-ostree client access to secrets in the file located at `path` by symlinking so that
@@ -0,0 +1,396 @@
+package daemon
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/clarketm/json"
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	"github.com/openshift/machine-config-operator/pkg/daemon/constants"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+)
+
+// What became of an update staged on the node, as found by
+// getStagedUpdateState.
+type stagedUpdateState int
+
+const (
+	// The update is staged and waits to be finalized.
+	stagedUpdateWaiting stagedUpdateState = iota
+	// The node rebooted into the update.
+	stagedUpdateFinalized
+	// The staged OS deployment is gone, e.g. because the node rebooted
+	// with its finalization locked.
+	stagedUpdateDropped
+)
+
+// getNodeStagedUpdates returns how the node controller asked the MCD to stage
+// updates which reboot the node in the StagedUpdatesAnnotationKey annotation,
+// or nil if they are applied right away.
+func getNodeStagedUpdates(node *corev1.Node) *ctrlcommon.StagedUpdatesConfig {
+	config, err := ctrlcommon.ParseStagedUpdatesConfig(node.Annotations[constants.StagedUpdatesAnnotationKey])
+	if err != nil {
+		klog.Warningf("Ignoring invalid %s annotation: %v", constants.StagedUpdatesAnnotationKey, err)
+		return nil
+	}
+
+	return config
+}
+
+// getPendingFinalizeRequest returns the finalize request set on the node if it
+// has not been handled yet.
+func getPendingFinalizeRequest(node *corev1.Node) (string, bool) {
+	request := node.Annotations[constants.FinalizeRequestAnnotationKey]
+	if request == "" || request == node.Annotations[constants.LastAppliedFinalizeRequestAnnotationKey] {
+		return "", false
+	}
+
+	return request, true
+}
+
+// getStagedUpdateState finds what became of the staged update from when the
+// node booted, the ID of the deployment it booted and the ID of the OS
+// deployment which is staged now, if any. An update without OS changes is
+// finalized by any reboot, since its files are already written.
+func getStagedUpdateState(staged *ctrlcommon.StagedUpdate, bootTime time.Time, bootedDeployment, stagedDeployment string) stagedUpdateState {
+	stagedAt, err := time.Parse(time.RFC3339, staged.Time)
+	if err != nil {
+		klog.Warningf("Could not parse time %q of the staged update: %v", staged.Time, err)
+		return stagedUpdateDropped
+	}
+
+	if bootTime.Before(stagedAt) {
+		if staged.Deployment == "" || staged.Deployment == stagedDeployment {
+			return stagedUpdateWaiting
+		}
+		return stagedUpdateDropped
+	}
+
+	if staged.Deployment == "" || staged.Deployment == bootedDeployment {
+		return stagedUpdateFinalized
+	}
+
+	return stagedUpdateDropped
+}
+
+// lockFinalization keeps the staged OS deployment from being finalized when
+// the node shuts down, like the --lock-finalization option of rpm-ostree.
+func lockFinalization() error {
+	return runCmdSync("ostree", "admin", "lock-finalization")
+}
+
+// unlockFinalization has the staged OS deployment finalized when the node
+// shuts down again.
+func unlockFinalization() error {
+	return runCmdSync("ostree", "admin", "lock-finalization", "--unlock")
+}
+
+// getDeploymentIDs returns the IDs of the booted and the staged OS
+// deployments. The staged ID is empty without a staged deployment.
+func (dn *Daemon) getDeploymentIDs() (string, string, error) {
+	booted, staged, err := dn.NodeUpdaterClient.GetBootedAndStagedDeployment()
+	if err != nil {
+		return "", "", fmt.Errorf("could not get OS deployments: %w", err)
+	}
+
+	stagedID := ""
+	if staged != nil {
+		stagedID = staged.ID
+	}
+
+	return booted.ID, stagedID, nil
+}
+
+// getStagedUpdateState finds what became of the update staged on the node.
+func (dn *Daemon) getStagedUpdateState(staged *ctrlcommon.StagedUpdate) (stagedUpdateState, error) {
+	bootTime, err := getBootTime()
+	if err != nil {
+		return stagedUpdateWaiting, fmt.Errorf("could not get boot time: %w", err)
+	}
+
+	booted, stagedID, err := dn.getDeploymentIDs()
+	if err != nil {
+		return stagedUpdateWaiting, err
+	}
+
+	return getStagedUpdateState(staged, bootTime, booted, stagedID), nil
+}
+
+// reportStagedUpdate records the update staged on the node in the node
+// status, or clears it when staged is nil.
+func (dn *Daemon) reportStagedUpdate(staged *ctrlcommon.StagedUpdate) error {
+	val := ""
+	if staged != nil {
+		out, err := json.Marshal(staged)
+		if err != nil {
+			return fmt.Errorf("could not encode staged update: %w", err)
+		}
+		val = string(out)
+	}
+
+	node, err := dn.nodeWriter.SetNodeStatus(map[string]string{constants.StagedUpdateAnnotationKey: val})
+	if err != nil {
+		return fmt.Errorf("could not report staged update: %w", err)
+	}
+	if node != nil {
+		dn.node = node
+	}
+
+	return nil
+}
+
+// getUpdateStaging returns how the update is staged, or nil if it is applied
+// right away. Only updates of the config which reboot the node are staged:
+// updates to a layered OS image are applied right away, and so are updates
+// which cannot be applied, so that update() reports why. The forcefile skips
+// staging since it asks for the update to be applied right away.
+func (dn *Daemon) getUpdateStaging(ufc *updateFromCluster) *ctrlcommon.StagedUpdatesConfig {
+	config := getNodeStagedUpdates(dn.node)
+	if config == nil || forceFileExists() {
+		return nil
+	}
+
+	if !dn.os.IsCoreOSVariant() || dn.NodeUpdaterClient == nil || ufc.currentImage != "" || ufc.desiredImage != "" {
+		return nil
+	}
+
+	actions, err := getUpdateActions(ufc, getNodeDisruptionPolicy(dn.node))
+	if err != nil {
+		klog.Warningf("Could not tell whether the update to %s reboots the node: %v", ufc.desiredConfig.GetName(), err)
+		return nil
+	}
+
+	if !ctrlcommon.InSlice(postConfigChangeActionReboot, actions) {
+		return nil
+	}
+
+	return config
+}
+
+// stageUpdate writes the files of the update and stages its OS deployment,
+// without draining or rebooting the node.
+func (dn *Daemon) stageUpdate(ufc *updateFromCluster, staging *ctrlcommon.StagedUpdatesConfig) error {
+	// A staged update does not wait for the next maintenance window itself.
+	if err := dn.reportPendingUpdate(nil); err != nil {
+		return err
+	}
+
+	// The files of the update are written, so the node drifts from the
+	// current config until the update is finalized.
+	dn.stopConfigDriftMonitor()
+
+	return dn.applyUpdate(ufc.currentConfig, ufc.desiredConfig, true, staging)
+}
+
+// completeStaging locks the finalization of the staged OS deployment unless
+// a reboot should finalize it, and reports the staged update.
+func (dn *Daemon) completeStaging(oldConfig, newConfig *mcfgv1.MachineConfig, staging *ctrlcommon.StagedUpdatesConfig) error {
+	_, deployment, err := dn.getDeploymentIDs()
+	if err != nil {
+		return err
+	}
+
+	if deployment != "" && staging.Finalization == ctrlcommon.StagedUpdateFinalizationLocked {
+		if err := lockFinalization(); err != nil {
+			return fmt.Errorf("could not lock finalization of the staged deployment: %w", err)
+		}
+	}
+
+	staged := &ctrlcommon.StagedUpdate{
+		Config:       newConfig.GetName(),
+		From:         oldConfig.GetName(),
+		Deployment:   deployment,
+		Finalization: staging.Finalization,
+		Time:         time.Now().UTC().Format(time.RFC3339),
+	}
+
+	if err := dn.reportStagedUpdate(staged); err != nil {
+		return err
+	}
+
+	logSystem("Update to %s staged, waiting for it to be finalized", staged.Config)
+	dn.nodeWriter.Eventf(corev1.EventTypeNormal, "UpdateStaged", "Update from %s to %s staged, waiting for it to be finalized", staged.From, staged.Config)
+
+	return nil
+}
+
+// discardStagedUpdate removes the staged OS deployment and writes the files
+// of the config the update was staged from back, returning that config.
+func (dn *Daemon) discardStagedUpdate(staged *ctrlcommon.StagedUpdate) (*mcfgv1.MachineConfig, error) {
+	logSystem("Discarding update to %s staged at %s", staged.Config, staged.Time)
+
+	fromConfig, err := dn.getMachineConfig(staged.From)
+	if err != nil {
+		return nil, fmt.Errorf("could not get config %s the update was staged from: %w", staged.From, err)
+	}
+	stagedConfig, err := dn.getMachineConfig(staged.Config)
+	if err != nil {
+		return nil, fmt.Errorf("could not get staged config %s: %w", staged.Config, err)
+	}
+
+	fromIgnConfig, err := ctrlcommon.ParseAndConvertConfig(fromConfig.Spec.Config.Raw)
+	if err != nil {
+		return nil, fmt.Errorf("parsing Ignition config failed: %w", err)
+	}
+	stagedIgnConfig, err := ctrlcommon.ParseAndConvertConfig(stagedConfig.Spec.Config.Raw)
+	if err != nil {
+		return nil, fmt.Errorf("parsing staged Ignition config failed: %w", err)
+	}
+
+	if err := removePendingDeployment(); err != nil {
+		return nil, fmt.Errorf("failed to remove staged deployment: %w", err)
+	}
+
+	if err := dn.updateFiles(stagedIgnConfig, fromIgnConfig, true); err != nil {
+		return nil, err
+	}
+	if err := dn.updateSSHKeys(fromIgnConfig, stagedIgnConfig); err != nil {
+		return nil, err
+	}
+	if err := dn.SetPasswordHash(fromIgnConfig.Passwd.Users, stagedIgnConfig.Passwd.Users); err != nil {
+		return nil, err
+	}
+	if err := dn.reconcileSwap(fromIgnConfig); err != nil {
+		return nil, err
+	}
+
+	if err := dn.storeCurrentConfigOnDisk(&onDiskConfig{currentConfig: fromConfig}); err != nil {
+		return nil, err
+	}
+
+	if err := dn.reportStagedUpdate(nil); err != nil {
+		return nil, err
+	}
+
+	dn.nodeWriter.Eventf(corev1.EventTypeNormal, "StagedUpdateDiscarded", "Discarded update to %s staged at %s", staged.Config, staged.Time)
+
+	return fromConfig, nil
+}
+
+// checkStagedUpdate returns whether the update of the node is staged and
+// waits to be finalized. An update staged to a config the node no longer
+// desires, or whose OS deployment is gone, is discarded so that the update is
+// staged or applied again from the config the node runs.
+func (dn *Daemon) checkStagedUpdate(ufc *updateFromCluster) (bool, error) {
+	staged := ctrlcommon.GetStagedUpdate(dn.node)
+	if staged == nil {
+		return false, nil
+	}
+
+	if staged.Config == ufc.desiredConfig.GetName() {
+		state, err := dn.getStagedUpdateState(staged)
+		if err != nil {
+			return false, err
+		}
+		if state == stagedUpdateWaiting {
+			klog.V(2).Infof("Update to %s is staged, waiting for it to be finalized", staged.Config)
+			return true, nil
+		}
+	}
+
+	fromConfig, err := dn.discardStagedUpdate(staged)
+	if err != nil {
+		return false, fmt.Errorf("could not discard staged update: %w", err)
+	}
+
+	ufc.currentConfig = fromConfig
+
+	return false, nil
+}
+
+// checkStagedUpdateOnFirstRun handles the update staged on the node when the
+// MCD starts. An update the node rebooted into completes like any other
+// update. It returns whether the on-disk state must not be validated because
+// an update is staged: the files of the update are written while the node
+// still runs the OS of the config it was staged from.
+func (dn *Daemon) checkStagedUpdateOnFirstRun() (bool, error) {
+	staged := ctrlcommon.GetStagedUpdate(dn.node)
+	if staged == nil {
+		return false, nil
+	}
+
+	state, err := dn.getStagedUpdateState(staged)
+	if err != nil {
+		return false, err
+	}
+
+	switch state {
+	case stagedUpdateFinalized:
+		logSystem("Booted into the update to %s staged at %s", staged.Config, staged.Time)
+		return false, dn.reportStagedUpdate(nil)
+	case stagedUpdateWaiting:
+		logSystem("Update to %s is staged, waiting for it to be finalized; skipping on-disk validation", staged.Config)
+		return true, nil
+	default:
+		// The update is staged again once the node is synced.
+		_, err := dn.discardStagedUpdate(staged)
+		return true, err
+	}
+}
+
+// handleFinalizeRequest drains the node and reboots it into the update staged
+// on it in response to an admin or the node controller setting the finalize
+// request annotation on the node. An update whose OS deployment is gone is
+// applied right away instead.
+func (dn *Daemon) handleFinalizeRequest(request string) error {
+	logSystem("Finalization requested via %s=%s", constants.FinalizeRequestAnnotationKey, request)
+
+	// The request is acknowledged before rebooting so that it is not handled
+	// again once the node is back.
+	ack := func() error {
+		if _, err := dn.nodeWriter.SetAnnotations(map[string]string{constants.LastAppliedFinalizeRequestAnnotationKey: request}); err != nil {
+			return fmt.Errorf("could not acknowledge finalize request: %w", err)
+		}
+		return nil
+	}
+
+	staged := ctrlcommon.GetStagedUpdate(dn.node)
+	if staged == nil || staged.Config != dn.node.Annotations[constants.DesiredMachineConfigAnnotationKey] {
+		klog.Infof("No update is staged, ignoring finalize request %s", request)
+		return ack()
+	}
+
+	state, err := dn.getStagedUpdateState(staged)
+	if err != nil {
+		return err
+	}
+
+	if state != stagedUpdateWaiting {
+		fromConfig, err := dn.discardStagedUpdate(staged)
+		if err != nil {
+			return fmt.Errorf("could not discard staged update: %w", err)
+		}
+		desiredConfig, err := dn.getMachineConfig(staged.Config)
+		if err != nil {
+			return err
+		}
+		if err := ack(); err != nil {
+			return err
+		}
+		return dn.triggerUpdateWithMachineConfig(fromConfig, desiredConfig, true)
+	}
+
+	if err := dn.nodeWriter.SetWorking(); err != nil {
+		return fmt.Errorf("error setting node's state to Working: %w", err)
+	}
+
+	if err := dn.performDrain(); err != nil {
+		return err
+	}
+
+	if staged.Deployment != "" && staged.Finalization == ctrlcommon.StagedUpdateFinalizationLocked {
+		if err := unlockFinalization(); err != nil {
+			return fmt.Errorf("could not unlock finalization of the staged deployment: %w", err)
+		}
+	}
+
+	if err := ack(); err != nil {
+		return err
+	}
+
+	dn.nodeWriter.Eventf(corev1.EventTypeNormal, "StagedUpdateFinalizing", "Finalizing update to %s staged at %s in response to finalize request %s", staged.Config, staged.Time, request)
+
+	return dn.reboot(fmt.Sprintf("Node will reboot into config %s to finalize the staged update", staged.Config))
+}
@@ -0,0 +1,115 @@
+package daemon
+
+import (
+	"testing"
+	"time"
+
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	"github.com/openshift/machine-config-operator/pkg/daemon/constants"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestGetPendingFinalizeRequest(t *testing.T) {
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{}}}
+	_, ok := getPendingFinalizeRequest(node)
+	assert.False(t, ok)
+
+	node.Annotations[constants.FinalizeRequestAnnotationKey] = "2024-01-01T00:00:00Z"
+	request, ok := getPendingFinalizeRequest(node)
+	assert.True(t, ok)
+	assert.Equal(t, "2024-01-01T00:00:00Z", request)
+
+	node.Annotations[constants.LastAppliedFinalizeRequestAnnotationKey] = "2024-01-01T00:00:00Z"
+	_, ok = getPendingFinalizeRequest(node)
+	assert.False(t, ok)
+}
+
+func TestGetNodeStagedUpdates(t *testing.T) {
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{}}}
+	assert.Nil(t, getNodeStagedUpdates(node))
+
+	node.Annotations[constants.StagedUpdatesAnnotationKey] = `{"finalization":"OnReboot"}`
+	assert.Equal(t, &ctrlcommon.StagedUpdatesConfig{Finalization: ctrlcommon.StagedUpdateFinalizationOnReboot}, getNodeStagedUpdates(node))
+
+	// Invalid staged updates apply updates right away.
+	node.Annotations[constants.StagedUpdatesAnnotationKey] = `{"finalization":"Never"}`
+	assert.Nil(t, getNodeStagedUpdates(node))
+}
+
+func TestGetStagedUpdateState(t *testing.T) {
+	stagedAt := time.Date(2024, time.January, 1, 12, 0, 0, 0, time.UTC)
+	before := stagedAt.Add(-24 * time.Hour)
+	after := stagedAt.Add(time.Hour)
+
+	withOS := &ctrlcommon.StagedUpdate{Config: "rendered-worker-2", From: "rendered-worker-1", Deployment: "rhcos-abc.1", Time: stagedAt.Format(time.RFC3339)}
+	withoutOS := &ctrlcommon.StagedUpdate{Config: "rendered-worker-2", From: "rendered-worker-1", Time: stagedAt.Format(time.RFC3339)}
+
+	testCases := []struct {
+		name             string
+		staged           *ctrlcommon.StagedUpdate
+		bootTime         time.Time
+		bootedDeployment string
+		stagedDeployment string
+		expected         stagedUpdateState
+	}{
+		{
+			name:             "staged deployment waits to be finalized",
+			staged:           withOS,
+			bootTime:         before,
+			bootedDeployment: "rhcos-def.0",
+			stagedDeployment: "rhcos-abc.1",
+			expected:         stagedUpdateWaiting,
+		},
+		{
+			name:             "staged deployment was replaced",
+			staged:           withOS,
+			bootTime:         before,
+			bootedDeployment: "rhcos-def.0",
+			stagedDeployment: "rhcos-ghi.1",
+			expected:         stagedUpdateDropped,
+		},
+		{
+			name:             "node rebooted into the staged deployment",
+			staged:           withOS,
+			bootTime:         after,
+			bootedDeployment: "rhcos-abc.1",
+			expected:         stagedUpdateFinalized,
+		},
+		{
+			name:             "node rebooted with locked finalization",
+			staged:           withOS,
+			bootTime:         after,
+			bootedDeployment: "rhcos-def.0",
+			expected:         stagedUpdateDropped,
+		},
+		{
+			name:             "update without OS changes waits for a reboot",
+			staged:           withoutOS,
+			bootTime:         before,
+			bootedDeployment: "rhcos-def.0",
+			expected:         stagedUpdateWaiting,
+		},
+		{
+			name:             "update without OS changes is finalized by any reboot",
+			staged:           withoutOS,
+			bootTime:         after,
+			bootedDeployment: "rhcos-def.0",
+			expected:         stagedUpdateFinalized,
+		},
+		{
+			name:             "update with an invalid time is dropped",
+			staged:           &ctrlcommon.StagedUpdate{Config: "rendered-worker-2", Time: "yesterday"},
+			bootTime:         before,
+			bootedDeployment: "rhcos-def.0",
+			expected:         stagedUpdateDropped,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			assert.Equal(t, testCase.expected, getStagedUpdateState(testCase.staged, testCase.bootTime, testCase.bootedDeployment, testCase.stagedDeployment))
+		})
+	}
+}
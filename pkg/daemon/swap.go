@@ -0,0 +1,389 @@
+package daemon
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	ign3types "github.com/coreos/ignition/v2/config/v3_4/types"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	"github.com/openshift/machine-config-operator/pkg/daemon/constants"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+	kubeletconfigv1beta1 "k8s.io/kubelet/config/v1beta1"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// kubeletConfigPath is where the rendered config writes the config of the kubelet.
+	kubeletConfigPath = "/etc/kubernetes/kubelet.conf"
+
+	// zramGeneratorConfigPath is read by zram-generator, which sets up
+	// zram0 as swap on boot with zramSetupUnit.
+	zramGeneratorConfigPath = "/etc/systemd/zram-generator.conf"
+	zramSetupUnit           = "systemd-zram-setup@zram0.service"
+	zramSwapUnit            = "dev-zram0.swap"
+
+	swapFilePath     = "/var/swapfile"
+	swapFileUnit     = "var-swapfile.swap"
+	swapFileUnitPath = "/etc/systemd/system/" + swapFileUnit
+
+	swappinessSysctlPath = "/etc/sysctl.d/90-machine-config-swap.conf"
+	// defaultSwappiness is the kernel default of vm.swappiness, restored when
+	// the pool no longer sets it.
+	defaultSwappiness = 60
+)
+
+// getNodeSwapConfig returns the swap which the node controller handed to the
+// node in the SwapAnnotationKey annotation, or nil if there is none.
+func getNodeSwapConfig(node *corev1.Node) *ctrlcommon.SwapConfig {
+	if node == nil {
+		return nil
+	}
+
+	config, err := ctrlcommon.ParseSwapConfig(node.Annotations[constants.SwapAnnotationKey])
+	if err != nil {
+		klog.Warningf("Ignoring invalid %s annotation: %v", constants.SwapAnnotationKey, err)
+		return nil
+	}
+
+	return config
+}
+
+// kubeletAllowsSwap returns whether the kubelet config of the config sets
+// failSwapOn to false. Otherwise the kubelet refuses to start on a node with
+// swap.
+func kubeletAllowsSwap(ignConfig ign3types.Config) (bool, error) {
+	for _, file := range ignConfig.Storage.Files {
+		if file.Path != kubeletConfigPath {
+			continue
+		}
+
+		contents, err := ctrlcommon.DecodeIgnitionFileContents(file.Contents.Source, file.Contents.Compression)
+		if err != nil {
+			return false, fmt.Errorf("could not decode %s: %w", kubeletConfigPath, err)
+		}
+
+		kubeletConfig := &kubeletconfigv1beta1.KubeletConfiguration{}
+		if err := yaml.Unmarshal(contents, kubeletConfig); err != nil {
+			return false, fmt.Errorf("could not parse %s: %w", kubeletConfigPath, err)
+		}
+
+		return kubeletConfig.FailSwapOn != nil && !*kubeletConfig.FailSwapOn, nil
+	}
+
+	return false, nil
+}
+
+// renderZramGeneratorConfig returns the zram-generator config of a zram
+// device of the given size, which it takes in MiB.
+func renderZramGeneratorConfig(size int64) string {
+	return fmt.Sprintf("# Managed by the machine-config-daemon\n[zram0]\nzram-size = %d\n", size/(1024*1024))
+}
+
+// renderSwapFileUnit returns the swap unit which turns on swapFilePath on
+// boot.
+func renderSwapFileUnit() string {
+	return fmt.Sprintf(`# Managed by the machine-config-daemon
+[Unit]
+Description=Swap file managed by the machine-config-daemon
+
+[Swap]
+What=%s
+
+[Install]
+WantedBy=swap.target
+`, swapFilePath)
+}
+
+// renderSwappinessSysctl returns the sysctl.d config which sets
+// vm.swappiness on boot.
+func renderSwappinessSysctl(swappiness int) string {
+	return fmt.Sprintf("# Managed by the machine-config-daemon\nvm.swappiness = %d\n", swappiness)
+}
+
+// writeFileIfChanged writes the file unless it already has the contents, and
+// returns whether it wrote it.
+func writeFileIfChanged(path, contents string) (bool, error) {
+	if current, err := os.ReadFile(path); err == nil && string(current) == contents {
+		return false, nil
+	}
+
+	if err := writeFileAtomicallyWithDefaults(path, []byte(contents)); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// removeFileIfExists removes the file, and returns whether it existed.
+func removeFileIfExists(path string) (bool, error) {
+	if err := os.Remove(path); err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("could not remove %s: %w", path, err)
+	}
+
+	return true, nil
+}
+
+// setUpZramSwap turns on a zram device of the given size as swap, and has
+// zram-generator set it up again on boot.
+func setUpZramSwap(size int64) error {
+	changed, err := writeFileIfChanged(zramGeneratorConfigPath, renderZramGeneratorConfig(size))
+	if err != nil {
+		return err
+	}
+
+	if changed {
+		if err := runCmdSync("systemctl", "daemon-reload"); err != nil {
+			return err
+		}
+		// Also stops the swap unit, which requires the device.
+		if err := runCmdSync("systemctl", "restart", zramSetupUnit); err != nil {
+			return err
+		}
+	}
+
+	return runCmdSync("systemctl", "start", zramSwapUnit)
+}
+
+// removeZramSwap turns off the zram swap set up by setUpZramSwap.
+func removeZramSwap() error {
+	if _, err := os.Stat(zramGeneratorConfigPath); os.IsNotExist(err) {
+		return nil
+	}
+
+	// Also stops the swap unit, which requires the device.
+	if err := runCmdSync("systemctl", "stop", zramSetupUnit); err != nil {
+		return err
+	}
+
+	if _, err := removeFileIfExists(zramGeneratorConfigPath); err != nil {
+		return err
+	}
+
+	return runCmdSync("systemctl", "daemon-reload")
+}
+
+// setUpSwapFile turns on a swap file of the given size, and has systemd turn
+// it on again on boot. An existing swap file of another size is replaced.
+func setUpSwapFile(size int64) error {
+	info, err := os.Stat(swapFilePath)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("could not stat %s: %w", swapFilePath, err)
+	}
+
+	if info == nil || info.Size() != size {
+		if err := removeSwapFile(); err != nil {
+			return err
+		}
+
+		// Swap files must not be sparse, so the space is allocated up front.
+		if err := runCmdSync("fallocate", "--length", fmt.Sprintf("%d", size), swapFilePath); err != nil {
+			return err
+		}
+		if err := os.Chmod(swapFilePath, 0o600); err != nil {
+			return fmt.Errorf("could not chmod %s: %w", swapFilePath, err)
+		}
+		if err := runCmdSync("mkswap", swapFilePath); err != nil {
+			return err
+		}
+	}
+
+	changed, err := writeFileIfChanged(swapFileUnitPath, renderSwapFileUnit())
+	if err != nil {
+		return err
+	}
+
+	if changed {
+		if err := runCmdSync("systemctl", "daemon-reload"); err != nil {
+			return err
+		}
+	}
+
+	return runCmdSync("systemctl", "enable", "--now", swapFileUnit)
+}
+
+// removeSwapFile turns off and removes the swap file set up by
+// setUpSwapFile.
+func removeSwapFile() error {
+	if _, err := os.Stat(swapFileUnitPath); err == nil {
+		if err := runCmdSync("systemctl", "disable", "--now", swapFileUnit); err != nil {
+			return err
+		}
+		if _, err := removeFileIfExists(swapFileUnitPath); err != nil {
+			return err
+		}
+		if err := runCmdSync("systemctl", "daemon-reload"); err != nil {
+			return err
+		}
+	}
+
+	_, err := removeFileIfExists(swapFilePath)
+	return err
+}
+
+// setSwappiness sets vm.swappiness now and on boot, or restores the kernel
+// default when swappiness is nil. Other sysctl.d configs setting it take
+// precedence then.
+func setSwappiness(swappiness *int) error {
+	if swappiness != nil {
+		changed, err := writeFileIfChanged(swappinessSysctlPath, renderSwappinessSysctl(*swappiness))
+		if err != nil || !changed {
+			return err
+		}
+		return runCmdSync("sysctl", "--quiet", "--load", swappinessSysctlPath)
+	}
+
+	removed, err := removeFileIfExists(swappinessSysctlPath)
+	if err != nil || !removed {
+		return err
+	}
+
+	if err := runCmdSync("sysctl", "--quiet", "--write", fmt.Sprintf("vm.swappiness=%d", defaultSwappiness)); err != nil {
+		return err
+	}
+
+	return runCmdSync("sysctl", "--quiet", "--system")
+}
+
+// applySwap sets up the swap on the node, or removes the swap the MCD set up
+// when config is nil. Swap set up by other means is left alone.
+func applySwap(config *ctrlcommon.SwapConfig) error {
+	if config == nil || config.Type != ctrlcommon.SwapTypeZram {
+		if err := removeZramSwap(); err != nil {
+			return fmt.Errorf("could not remove zram swap: %w", err)
+		}
+	}
+
+	if config == nil || config.Type != ctrlcommon.SwapTypeFile {
+		if err := removeSwapFile(); err != nil {
+			return fmt.Errorf("could not remove swap file: %w", err)
+		}
+	}
+
+	if config == nil {
+		return setSwappiness(nil)
+	}
+
+	// Validated by ParseSwapConfig.
+	size, err := config.GetSize()
+	if err != nil {
+		return err
+	}
+
+	switch config.Type {
+	case ctrlcommon.SwapTypeZram:
+		if err := setUpZramSwap(size); err != nil {
+			return fmt.Errorf("could not set up zram swap: %w", err)
+		}
+	case ctrlcommon.SwapTypeFile:
+		if err := setUpSwapFile(size); err != nil {
+			return fmt.Errorf("could not set up swap file: %w", err)
+		}
+	}
+
+	return setSwappiness(config.Swappiness)
+}
+
+// getSwapStatus returns the swap the node should have with the given config,
+// i.e. the swap of its pool if its kubelet allows swap, and none otherwise.
+func getSwapStatus(config *ctrlcommon.SwapConfig, ignConfig ign3types.Config) (*ctrlcommon.SwapConfig, *ctrlcommon.SwapStatus) {
+	if config == nil {
+		return nil, &ctrlcommon.SwapStatus{}
+	}
+
+	status := &ctrlcommon.SwapStatus{Config: config.String()}
+
+	allowed, err := kubeletAllowsSwap(ignConfig)
+	if err != nil {
+		status.Error = err.Error()
+		return nil, status
+	}
+
+	if !allowed {
+		status.Error = "the kubelet does not allow swap, failSwapOn must be set to false by a KubeletConfig"
+		return nil, status
+	}
+
+	return config, status
+}
+
+// reconcileSwap sets up the swap of the pool of the node, or removes it, as
+// allowed by the kubelet of the given config, and reports the result in the
+// node status under SwapStatusAnnotationKey. Nothing is done when the result
+// was already reported.
+func (dn *Daemon) reconcileSwap(ignConfig ign3types.Config) error {
+	if dn.nodeWriter == nil || dn.node == nil {
+		return nil
+	}
+
+	config, status := getSwapStatus(getNodeSwapConfig(dn.node), ignConfig)
+
+	reported := ctrlcommon.GetSwapStatus(dn.node)
+	if reported == nil && status.Config == "" {
+		// The MCD never set up swap on the node.
+		return nil
+	}
+	if reported != nil && *reported == *status {
+		return nil
+	}
+
+	if !dn.mock {
+		if err := applySwap(config); err != nil {
+			return err
+		}
+	}
+
+	out, err := json.Marshal(status)
+	if err != nil {
+		return fmt.Errorf("could not encode swap status: %w", err)
+	}
+
+	node, err := dn.nodeWriter.SetNodeStatus(map[string]string{constants.SwapStatusAnnotationKey: string(out)})
+	if err != nil {
+		return fmt.Errorf("could not report swap status: %w", err)
+	}
+	if node != nil {
+		dn.node = node
+	}
+
+	switch {
+	case status.Error != "":
+		logSystem("Not setting up swap %s: %s", status.Config, status.Error)
+		dn.nodeWriter.Eventf(corev1.EventTypeWarning, "SwapFailed", "Could not set up swap %s: %s", status.Config, status.Error)
+	case config != nil:
+		logSystem("Set up swap %s", status.Config)
+		dn.nodeWriter.Eventf(corev1.EventTypeNormal, "SwapConfigured", "Set up swap %s", status.Config)
+	default:
+		logSystem("Removed swap")
+		dn.nodeWriter.Eventf(corev1.EventTypeNormal, "SwapRemoved", "Removed swap")
+	}
+
+	return nil
+}
+
+// syncSwap reconciles the swap of the node with the kubelet of the config it
+// is on, when its pool changes the swap without changing the config.
+func (dn *Daemon) syncSwap() error {
+	if dn.nodeWriter == nil || dn.node == nil {
+		return nil
+	}
+
+	odc, err := dn.getCurrentConfigOnDisk()
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	ignConfig, err := ctrlcommon.ParseAndConvertConfig(odc.currentConfig.Spec.Config.Raw)
+	if err != nil {
+		return fmt.Errorf("parsing current config: %w", err)
+	}
+
+	return dn.reconcileSwap(ignConfig)
+}
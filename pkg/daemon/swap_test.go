@@ -0,0 +1,113 @@
+package daemon
+
+import (
+	"testing"
+
+	ign3types "github.com/coreos/ignition/v2/config/v3_4/types"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	"github.com/openshift/machine-config-operator/pkg/daemon/constants"
+	"github.com/openshift/machine-config-operator/test/helpers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func newKubeletConfigIgnConfig(kubeletConfig string) ign3types.Config {
+	ignCfg := ctrlcommon.NewIgnConfig()
+	ignCfg.Storage.Files = append(ignCfg.Storage.Files, helpers.CreateEncodedIgn3File(kubeletConfigPath, kubeletConfig, 0o644))
+	return ignCfg
+}
+
+func TestKubeletAllowsSwap(t *testing.T) {
+	tests := []struct {
+		name        string
+		ignConfig   ign3types.Config
+		expected    bool
+		errExpected bool
+	}{
+		{
+			name:      "no kubelet config",
+			ignConfig: ctrlcommon.NewIgnConfig(),
+		},
+		{
+			name:      "failSwapOn defaults to true",
+			ignConfig: newKubeletConfigIgnConfig(`{"kind":"KubeletConfiguration","apiVersion":"kubelet.config.k8s.io/v1beta1"}`),
+		},
+		{
+			name:      "failSwapOn true",
+			ignConfig: newKubeletConfigIgnConfig(`{"kind":"KubeletConfiguration","failSwapOn":true}`),
+		},
+		{
+			name:      "failSwapOn false",
+			ignConfig: newKubeletConfigIgnConfig(`{"kind":"KubeletConfiguration","failSwapOn":false,"memorySwap":{"swapBehavior":"LimitedSwap"}}`),
+			expected:  true,
+		},
+		{
+			name:      "YAML",
+			ignConfig: newKubeletConfigIgnConfig("kind: KubeletConfiguration\nfailSwapOn: false\n"),
+			expected:  true,
+		},
+		{
+			name:        "invalid",
+			ignConfig:   newKubeletConfigIgnConfig(`{"failSwapOn":"no"}`),
+			errExpected: true,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			allowed, err := kubeletAllowsSwap(test.ignConfig)
+			if test.errExpected {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, allowed)
+		})
+	}
+}
+
+func TestGetSwapStatus(t *testing.T) {
+	swap, err := ctrlcommon.ParseSwapConfig(`{"type":"File","size":"2Gi","swappiness":10}`)
+	require.NoError(t, err)
+
+	allowed := newKubeletConfigIgnConfig(`{"failSwapOn":false}`)
+	disallowed := newKubeletConfigIgnConfig(`{"failSwapOn":true}`)
+
+	config, status := getSwapStatus(swap, allowed)
+	assert.Equal(t, swap, config)
+	assert.Equal(t, &ctrlcommon.SwapStatus{Config: swap.String()}, status)
+
+	// The swap is removed when the kubelet does not allow it.
+	config, status = getSwapStatus(swap, disallowed)
+	assert.Nil(t, config)
+	assert.Equal(t, swap.String(), status.Config)
+	assert.Contains(t, status.Error, "failSwapOn")
+
+	config, status = getSwapStatus(nil, disallowed)
+	assert.Nil(t, config)
+	assert.Equal(t, &ctrlcommon.SwapStatus{}, status)
+}
+
+func TestGetNodeSwapConfig(t *testing.T) {
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{}}}
+	assert.Nil(t, getNodeSwapConfig(node))
+
+	node.Annotations[constants.SwapAnnotationKey] = `{"type":"Partition","size":"4Gi"}`
+	assert.Nil(t, getNodeSwapConfig(node))
+
+	node.Annotations[constants.SwapAnnotationKey] = `{"type":"Zram","size":"4Gi"}`
+	config := getNodeSwapConfig(node)
+	if assert.NotNil(t, config) {
+		assert.Equal(t, ctrlcommon.SwapTypeZram, config.Type)
+		assert.Nil(t, config.Swappiness)
+	}
+}
+
+func TestRenderSwapConfigs(t *testing.T) {
+	assert.Equal(t, "# Managed by the machine-config-daemon\n[zram0]\nzram-size = 4096\n", renderZramGeneratorConfig(4*1024*1024*1024))
+	assert.Equal(t, "# Managed by the machine-config-daemon\nvm.swappiness = 10\n", renderSwappinessSysctl(10))
+	assert.Contains(t, renderSwapFileUnit(), "What=/var/swapfile\n")
+}
@@ -0,0 +1,229 @@
+package daemon
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	ign3types "github.com/coreos/ignition/v2/config/v3_4/types"
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// The kernel exposes each sysctl as a file below this path.
+	pathProcSys = "/proc/sys"
+
+	sysctlConfPath = "/etc/sysctl.conf"
+	sysctlDir      = "/etc/sysctl.d"
+
+	// How often the Config Drift Monitor compares the live values of the
+	// sysctls set by the MachineConfig with their configured values.
+	defaultSysctlCheckInterval = 5 * time.Minute
+)
+
+// Error type for sysctl config drifts
+type sysctlConfigDriftErr struct {
+	error
+}
+
+// sysctl is a kernel parameter set by a file of the MachineConfig.
+type sysctl struct {
+	Key   string
+	Value string
+	// Path is the file which sets the parameter.
+	Path string
+}
+
+// isSysctlConfPath returns whether systemd-sysctl reads the file on boot.
+func isSysctlConfPath(path string) bool {
+	return path == sysctlConfPath || (filepath.Dir(path) == sysctlDir && strings.HasSuffix(path, ".conf"))
+}
+
+// parseSysctlConf parses a file in the format of sysctl.d(5). Keys with globs
+// are skipped, since which parameters they cover depends on the node.
+func parseSysctlConf(path string, contents []byte) []sysctl {
+	sysctls := []sysctl{}
+
+	scanner := bufio.NewScanner(bytes.NewReader(contents))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+
+		// A leading "-" has systemd-sysctl ignore failures to set the key.
+		key = strings.TrimPrefix(strings.TrimSpace(key), "-")
+		if key == "" || strings.ContainsAny(key, "*?[") {
+			continue
+		}
+
+		sysctls = append(sysctls, sysctl{Key: key, Value: strings.TrimSpace(value), Path: path})
+	}
+
+	return sysctls
+}
+
+// getSysctlProcPath returns the file below procSysPath of the sysctl. Like
+// sysctl(8), keys using "/" as the separator are taken as they are, so that
+// they may contain dots, e.g. net/ipv4/conf/eth0.100/forwarding.
+func getSysctlProcPath(procSysPath, key string) string {
+	if !strings.Contains(key, "/") {
+		key = strings.ReplaceAll(key, ".", "/")
+	}
+	return filepath.Join(procSysPath, key)
+}
+
+// getSysctlsFromIgn3Config returns the sysctls set by the files of the config,
+// with the value systemd-sysctl ends up setting each of them to, i.e. the one
+// of the file which sorts last by its name, and /etc/sysctl.conf after all of
+// them.
+func getSysctlsFromIgn3Config(files []ign3types.File) ([]sysctl, error) {
+	confFiles := []ign3types.File{}
+	for _, file := range files {
+		if isSysctlConfPath(file.Path) {
+			confFiles = append(confFiles, file)
+		}
+	}
+
+	sort.SliceStable(confFiles, func(i, j int) bool {
+		if confFiles[j].Path == sysctlConfPath {
+			return confFiles[i].Path != sysctlConfPath
+		}
+		if confFiles[i].Path == sysctlConfPath {
+			return false
+		}
+		return filepath.Base(confFiles[i].Path) < filepath.Base(confFiles[j].Path)
+	})
+
+	sysctls := map[string]sysctl{}
+	keys := []string{}
+
+	for _, file := range confFiles {
+		contents, err := ctrlcommon.DecodeIgnitionFileContents(file.Contents.Source, file.Contents.Compression)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't decode file %q: %w", file.Path, err)
+		}
+
+		for _, s := range parseSysctlConf(file.Path, contents) {
+			if _, ok := sysctls[s.Key]; !ok {
+				keys = append(keys, s.Key)
+			}
+			sysctls[s.Key] = s
+		}
+	}
+
+	out := make([]sysctl, 0, len(keys))
+	for _, key := range keys {
+		out = append(out, sysctls[key])
+	}
+
+	return out, nil
+}
+
+// normalizeSysctlValue collapses the whitespace of a value, since the kernel
+// separates the fields of values such as net.ipv4.tcp_rmem with tabs.
+func normalizeSysctlValue(value string) string {
+	return strings.Join(strings.Fields(value), " ")
+}
+
+// getDriftedSysctls returns the sysctls whose live value differs from the
+// configured one. Parameters the kernel does not have, e.g. because their
+// module is not loaded, are skipped like systemd-sysctl does.
+func getDriftedSysctls(sysctls []sysctl, procSysPath string) ([]sysctl, []string, error) {
+	drifted := []sysctl{}
+	live := []string{}
+
+	for _, s := range sysctls {
+		out, err := os.ReadFile(getSysctlProcPath(procSysPath, s.Key))
+		if os.IsNotExist(err) {
+			klog.V(4).Infof("Skipping sysctl %s set by %s: not present", s.Key, s.Path)
+			continue
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("could not read sysctl %s: %w", s.Key, err)
+		}
+
+		if value := normalizeSysctlValue(string(out)); value != normalizeSysctlValue(s.Value) {
+			drifted = append(drifted, s)
+			live = append(live, value)
+		}
+	}
+
+	return drifted, live, nil
+}
+
+// getMachineConfigSysctls returns the sysctls set by the MachineConfig,
+// skipping the files covered by the unmanaged paths.
+func getMachineConfigSysctls(mc *mcfgv1.MachineConfig, unmanagedPaths []string) ([]sysctl, error) {
+	ignConfig, err := ctrlcommon.ParseAndConvertConfig(mc.Spec.Config.Raw)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse Ignition for sysctl validation: %w", err)
+	}
+
+	return getSysctlsFromIgn3Config(filterUnmanagedV3Files(ignConfig.Storage.Files, unmanagedPaths))
+}
+
+// checkSysctls validates that the live values of the sysctls set by the
+// MachineConfig match their configured values.
+func checkSysctls(mc *mcfgv1.MachineConfig, procSysPath string, unmanagedPaths []string) error {
+	sysctls, err := getMachineConfigSysctls(mc, unmanagedPaths)
+	if err != nil {
+		return err
+	}
+
+	drifted, live, err := getDriftedSysctls(sysctls, procSysPath)
+	if err != nil {
+		return err
+	}
+
+	if len(drifted) == 0 {
+		return nil
+	}
+
+	mismatches := make([]string, 0, len(drifted))
+	for i, s := range drifted {
+		mismatches = append(mismatches, fmt.Sprintf("%s is %q instead of %q set by %s", s.Key, live[i], normalizeSysctlValue(s.Value), s.Path))
+	}
+
+	return &sysctlConfigDriftErr{fmt.Errorf("sysctl mismatch: %s", strings.Join(mismatches, "; "))}
+}
+
+// remediateSysctls sets the sysctls set by the MachineConfig whose live
+// values drifted back to their configured values, and returns their keys.
+func remediateSysctls(mc *mcfgv1.MachineConfig, procSysPath string, unmanagedPaths []string) ([]string, error) {
+	sysctls, err := getMachineConfigSysctls(mc, unmanagedPaths)
+	if err != nil {
+		return nil, err
+	}
+
+	drifted, _, err := getDriftedSysctls(sysctls, procSysPath)
+	if err != nil {
+		return nil, err
+	}
+
+	remediated := []string{}
+	for _, s := range drifted {
+		klog.Infof("Remediating config drift of sysctl %s", s.Key)
+
+		// Like sysctl -w, which writes the value to the file of the parameter.
+		if err := os.WriteFile(getSysctlProcPath(procSysPath, s.Key), []byte(s.Value+"\n"), 0o644); err != nil {
+			return nil, fmt.Errorf("could not set sysctl %s: %w", s.Key, err)
+		}
+
+		remediated = append(remediated, "sysctl "+s.Key)
+	}
+
+	return remediated, nil
+}
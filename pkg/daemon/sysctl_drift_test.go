@@ -0,0 +1,137 @@
+package daemon
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	ign3types "github.com/coreos/ignition/v2/config/v3_4/types"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	"github.com/openshift/machine-config-operator/test/helpers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSysctlConf(t *testing.T) {
+	contents := `# comment
+; also a comment
+net.ipv4.ip_forward = 1
+-net.core.somaxconn=4096
+net.ipv4.tcp_rmem = 4096	87380   6291456
+net/ipv4/conf/eth0.100/forwarding = 1
+net.ipv4.conf.*.rp_filter = 2
+not a sysctl
+`
+
+	assert.Equal(t, []sysctl{
+		{Key: "net.ipv4.ip_forward", Value: "1", Path: "/etc/sysctl.d/99-custom.conf"},
+		{Key: "net.core.somaxconn", Value: "4096", Path: "/etc/sysctl.d/99-custom.conf"},
+		{Key: "net.ipv4.tcp_rmem", Value: "4096	87380   6291456", Path: "/etc/sysctl.d/99-custom.conf"},
+		{Key: "net/ipv4/conf/eth0.100/forwarding", Value: "1", Path: "/etc/sysctl.d/99-custom.conf"},
+	}, parseSysctlConf("/etc/sysctl.d/99-custom.conf", []byte(contents)))
+
+	assert.Equal(t, "/proc/sys/net/ipv4/ip_forward", getSysctlProcPath("/proc/sys", "net.ipv4.ip_forward"))
+	assert.Equal(t, "/proc/sys/net/ipv4/conf/eth0.100/forwarding", getSysctlProcPath("/proc/sys", "net/ipv4/conf/eth0.100/forwarding"))
+}
+
+func TestGetSysctlsFromIgn3Config(t *testing.T) {
+	files := []ign3types.File{
+		helpers.CreateEncodedIgn3File(sysctlConfPath, "vm.max_map_count = 262144\n", 0o644),
+		helpers.CreateEncodedIgn3File("/etc/sysctl.d/99-custom.conf", "net.core.somaxconn = 8192\nvm.max_map_count = 65530\n", 0o644),
+		helpers.CreateEncodedIgn3File("/etc/sysctl.d/10-custom.conf", "net.core.somaxconn = 4096\nkernel.pid_max = 4194304\n", 0o644),
+		helpers.CreateEncodedIgn3File("/etc/sysctl.d/README", "net.core.somaxconn = 1\n", 0o644),
+		helpers.CreateEncodedIgn3File("/etc/agent.conf", "net.core.somaxconn = 1\n", 0o644),
+	}
+
+	sysctls, err := getSysctlsFromIgn3Config(files)
+	require.NoError(t, err)
+
+	// Later files override earlier ones, and /etc/sysctl.conf comes last.
+	assert.Equal(t, []sysctl{
+		{Key: "net.core.somaxconn", Value: "8192", Path: "/etc/sysctl.d/99-custom.conf"},
+		{Key: "kernel.pid_max", Value: "4194304", Path: "/etc/sysctl.d/10-custom.conf"},
+		{Key: "vm.max_map_count", Value: "262144", Path: sysctlConfPath},
+	}, sysctls)
+}
+
+func TestSysctlDrift(t *testing.T) {
+	procSysPath := t.TempDir()
+
+	writeSysctl := func(key, value string) {
+		path := getSysctlProcPath(procSysPath, key)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		require.NoError(t, os.WriteFile(path, []byte(value+"\n"), 0o644))
+	}
+
+	writeSysctl("net.ipv4.tcp_rmem", "4096\t87380\t6291456")
+	writeSysctl("net.core.somaxconn", "4096")
+	writeSysctl("kernel.pid_max", "4194304")
+
+	ignConfig := ctrlcommon.NewIgnConfig()
+	ignConfig.Storage.Files = []ign3types.File{
+		// The module of the missing parameter is not loaded.
+		helpers.CreateEncodedIgn3File("/etc/sysctl.d/99-custom.conf", "net.ipv4.tcp_rmem = 4096 87380 6291456\nnet.core.somaxconn = 4096\nnet.netfilter.nf_conntrack_max = 1048576\n", 0o644),
+		helpers.CreateEncodedIgn3File("/etc/sysctl.d/99-unmanaged.conf", "kernel.pid_max = 65536\n", 0o644),
+	}
+	mc := helpers.CreateMachineConfigFromIgnition(ignConfig)
+	unmanagedPaths := []string{"/etc/sysctl.d/99-unmanaged.conf"}
+
+	require.NoError(t, checkSysctls(mc, procSysPath, unmanagedPaths))
+
+	writeSysctl("net.core.somaxconn", "128")
+
+	err := checkSysctls(mc, procSysPath, unmanagedPaths)
+	var scErr *sysctlConfigDriftErr
+	require.True(t, errors.As(err, &scErr))
+	assert.Contains(t, err.Error(), `net.core.somaxconn is "128" instead of "4096"`)
+
+	remediated, err := remediateSysctls(mc, procSysPath, unmanagedPaths)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"sysctl net.core.somaxconn"}, remediated)
+	assert.NoError(t, checkSysctls(mc, procSysPath, unmanagedPaths))
+
+	// The unmanaged sysctl is left alone.
+	out, err := os.ReadFile(getSysctlProcPath(procSysPath, "kernel.pid_max"))
+	require.NoError(t, err)
+	assert.Equal(t, "4194304\n", string(out))
+}
+
+func TestConfigDriftWatcherSysctlCheck(t *testing.T) {
+	procSysPath := t.TempDir()
+	path := getSysctlProcPath(procSysPath, "net.core.somaxconn")
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+	require.NoError(t, os.WriteFile(path, []byte("128\n"), 0o644))
+
+	ignConfig := ctrlcommon.NewIgnConfig()
+	ignConfig.Storage.Files = []ign3types.File{
+		helpers.CreateEncodedIgn3File("/etc/sysctl.d/99-custom.conf", "net.core.somaxconn = 4096\n", 0o644),
+	}
+
+	drifts := []error{}
+	c := &configDriftWatcher{
+		ConfigDriftMonitorOpts: ConfigDriftMonitorOpts{
+			MachineConfig: helpers.CreateMachineConfigFromIgnition(ignConfig),
+			ProcSysPath:   procSysPath,
+			OnDrift: func(err error) {
+				drifts = append(drifts, err)
+			},
+		},
+	}
+
+	require.NoError(t, c.handleSysctlCheck())
+	require.Len(t, drifts, 1)
+	var cdErr *configDriftErr
+	assert.True(t, errors.As(drifts[0], &cdErr))
+
+	// The same drift is only reported once.
+	require.NoError(t, c.handleSysctlCheck())
+	assert.Len(t, drifts, 1)
+
+	// Drift which comes back after it was gone is reported again.
+	require.NoError(t, os.WriteFile(path, []byte("4096\n"), 0o644))
+	require.NoError(t, c.handleSysctlCheck())
+	require.NoError(t, os.WriteFile(path, []byte("128\n"), 0o644))
+	require.NoError(t, c.handleSysctlCheck())
+	assert.Len(t, drifts, 2)
+}
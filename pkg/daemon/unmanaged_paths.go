@@ -0,0 +1,101 @@
+package daemon
+
+import (
+	"os"
+
+	ign2types "github.com/coreos/ignition/config/v2_2/types"
+	ign3types "github.com/coreos/ignition/v2/config/v3_4/types"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	"github.com/openshift/machine-config-operator/pkg/daemon/constants"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+)
+
+// getNodeUnmanagedPaths returns the paths which the node controller handed to
+// the node in the UnmanagedPathsAnnotationKey annotation.
+func getNodeUnmanagedPaths(node *corev1.Node) []string {
+	if node == nil {
+		return nil
+	}
+
+	paths, err := ctrlcommon.ParseUnmanagedPaths(node.Annotations[constants.UnmanagedPathsAnnotationKey])
+	if err != nil {
+		klog.Warningf("Ignoring invalid %s annotation: %v", constants.UnmanagedPathsAnnotationKey, err)
+		return nil
+	}
+
+	return paths
+}
+
+// getUnmanagedPaths returns the paths which are excluded from MCO management
+// on this node. The node is read from the lister rather than dn.node so that
+// this can be called from the Config Drift Monitor, and so that a changed
+// allowlist takes effect without restarting it.
+func (dn *Daemon) getUnmanagedPaths() []string {
+	if dn.nodeLister == nil {
+		return nil
+	}
+
+	node, err := dn.nodeLister.Get(dn.name)
+	if err != nil {
+		klog.Warningf("Could not get node %s for its unmanaged paths: %v", dn.name, err)
+		return nil
+	}
+
+	return getNodeUnmanagedPaths(node)
+}
+
+// filterUnmanagedV3Files removes the files covered by the unmanaged paths.
+func filterUnmanagedV3Files(files []ign3types.File, unmanagedPaths []string) []ign3types.File {
+	if len(unmanagedPaths) == 0 {
+		return files
+	}
+
+	out := []ign3types.File{}
+	for _, f := range files {
+		if !ctrlcommon.IsUnmanagedPath(f.Path, unmanagedPaths) {
+			out = append(out, f)
+		}
+	}
+
+	return out
+}
+
+// filterUnmanagedV2Files removes the files covered by the unmanaged paths.
+func filterUnmanagedV2Files(files []ign2types.File, unmanagedPaths []string) []ign2types.File {
+	if len(unmanagedPaths) == 0 {
+		return files
+	}
+
+	out := []ign2types.File{}
+	for _, f := range files {
+		if !ctrlcommon.IsUnmanagedPath(f.Path, unmanagedPaths) {
+			out = append(out, f)
+		}
+	}
+
+	return out
+}
+
+// skipExistingUnmanagedFiles removes the files covered by the unmanaged paths
+// which already exist on disk, so that they are not overwritten. Unmanaged
+// files which do not exist yet are still written so that the MachineConfig
+// can provide their initial contents.
+func skipExistingUnmanagedFiles(files []ign3types.File, unmanagedPaths []string) []ign3types.File {
+	if len(unmanagedPaths) == 0 {
+		return files
+	}
+
+	out := []ign3types.File{}
+	for _, f := range files {
+		if ctrlcommon.IsUnmanagedPath(f.Path, unmanagedPaths) {
+			if _, err := os.Lstat(f.Path); err == nil {
+				klog.Infof("Not overwriting unmanaged file %q", f.Path)
+				continue
+			}
+		}
+		out = append(out, f)
+	}
+
+	return out
+}
@@ -0,0 +1,45 @@
+package daemon
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	ign3types "github.com/coreos/ignition/v2/config/v3_4/types"
+	"github.com/openshift/machine-config-operator/pkg/daemon/constants"
+	"github.com/openshift/machine-config-operator/test/helpers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetNodeUnmanagedPaths(t *testing.T) {
+	assert.Nil(t, getNodeUnmanagedPaths(nil))
+
+	node := helpers.NewNodeBuilder("node-0").WithAnnotations(map[string]string{constants.UnmanagedPathsAnnotationKey: "/etc/agent.conf,/etc/agent.d/"}).Node()
+	assert.Equal(t, []string{"/etc/agent.conf", "/etc/agent.d/"}, getNodeUnmanagedPaths(node))
+
+	node.Annotations[constants.UnmanagedPathsAnnotationKey] = "/var/lib/agent"
+	assert.Nil(t, getNodeUnmanagedPaths(node))
+}
+
+func TestSkipExistingUnmanagedFiles(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	existing := filepath.Join(tmpDir, "existing")
+	require.NoError(t, os.WriteFile(existing, []byte("written by the agent"), defaultFilePermissions))
+
+	missing := filepath.Join(tmpDir, "missing")
+	managed := filepath.Join(tmpDir, "managed")
+	require.NoError(t, os.WriteFile(managed, []byte("written by the MCO"), defaultFilePermissions))
+
+	files := []ign3types.File{
+		helpers.CreateEncodedIgn3File(existing, "contents", int(defaultFilePermissions)),
+		helpers.CreateEncodedIgn3File(missing, "contents", int(defaultFilePermissions)),
+		helpers.CreateEncodedIgn3File(managed, "contents", int(defaultFilePermissions)),
+	}
+
+	assert.Equal(t, files, skipExistingUnmanagedFiles(files, nil))
+
+	filtered := skipExistingUnmanagedFiles(files, []string{existing, missing})
+	assert.Equal(t, []ign3types.File{files[1], files[2]}, filtered)
+}
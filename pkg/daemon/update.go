@@ -22,6 +22,7 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	kubeErrs "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/apimachinery/pkg/util/uuid"
 	"k8s.io/klog/v2"
 
@@ -42,20 +43,72 @@ const (
 	extensionsRepo             = "/etc/yum.repos.d/coreos-extensions.repo"
 	osExtensionsContentBaseDir = "/run/mco-extensions/"
 
-	// These are the actions for a node to take after applying config changes. (e.g. a new machineconfig is applied)
-	// "None" means no special action needs to be taken
-	// This happens for example when ssh keys or the pull secret (/var/lib/kubelet/config.json) is changed
-	postConfigChangeActionNone = "none"
-	// The "reload crio" action will run "systemctl reload crio"
-	postConfigChangeActionReloadCrio = "reload crio"
-	// Rebooting is still the default scenario for any other change
-	postConfigChangeActionReboot = "reboot"
+	// These are the actions for a node to take after applying config changes (e.g. a new machineconfig is applied),
+	// see ctrlcommon.CalculatePostConfigChangeActions
+	postConfigChangeActionNone                 = ctrlcommon.PostConfigChangeActionNone
+	postConfigChangeActionReloadCrio           = ctrlcommon.PostConfigChangeActionReloadCrio
+	postConfigChangeActionRestartCrio          = ctrlcommon.PostConfigChangeActionRestartCrio
+	postConfigChangeActionReloadNetworkManager = ctrlcommon.PostConfigChangeActionReloadNetworkManager
+	postConfigChangeActionRestartChronyd       = ctrlcommon.PostConfigChangeActionRestartChronyd
+	postConfigChangeActionRestartKubelet       = ctrlcommon.PostConfigChangeActionRestartKubelet
+	postConfigChangeActionReboot               = ctrlcommon.PostConfigChangeActionReboot
 
 	// GPGNoRebootPath is the path MCO expects will contain GPG key updates. MCO will attempt to only reload crio for
 	// changes to this path. Note that other files added to the parent directory will not be handled specially
-	GPGNoRebootPath = "/etc/machine-config-daemon/no-reboot/containers-gpg.pub"
+	GPGNoRebootPath = ctrlcommon.GPGNoRebootPath
 )
 
+// postConfigChangeServiceAction is a post config change action which reloads or restarts a single service to apply
+// changes to its config files. These are performed after, and in addition to, anything done for crio.
+type postConfigChangeServiceAction struct {
+	action  string
+	service string
+	// Whether the service is restarted rather than reloaded
+	restart bool
+	// Whether systemd reloads its units before the service is reloaded or restarted
+	daemonReload bool
+}
+
+// postConfigChangeServiceActions are the service actions in the order of ctrlcommon.PostConfigChangeServiceActions
+var postConfigChangeServiceActions = []postConfigChangeServiceAction{
+	{
+		action:  postConfigChangeActionReloadNetworkManager,
+		service: "NetworkManager",
+	},
+	{
+		action:  postConfigChangeActionRestartChronyd,
+		service: "chronyd",
+		restart: true,
+	},
+	{
+		action:       postConfigChangeActionRestartKubelet,
+		service:      "kubelet",
+		restart:      true,
+		daemonReload: true,
+	},
+}
+
+// run reloads or restarts the service.
+func (a postConfigChangeServiceAction) run() error {
+	if a.daemonReload {
+		if err := runCmdSync("systemctl", "daemon-reload"); err != nil {
+			return err
+		}
+	}
+	if a.restart {
+		return restartService(a.service)
+	}
+	return reloadService(a.service)
+}
+
+// describe returns the past tense of the action for events and logs, e.g. "restarted".
+func (a postConfigChangeServiceAction) describe() string {
+	if a.restart {
+		return "restarted"
+	}
+	return "reloaded"
+}
+
 func getNodeRef(node *corev1.Node) *corev1.ObjectReference {
 	return &corev1.ObjectReference{
 		Kind: "Node",
@@ -68,14 +121,19 @@ func reloadService(name string) error {
 	return runCmdSync("systemctl", "reload", name)
 }
 
+func restartService(name string) error {
+	return runCmdSync("systemctl", "restart", name)
+}
+
 // performPostConfigChangeAction takes action based on what postConfigChangeAction has been asked.
 // For non-reboot action, it applies configuration, updates node's config and state.
 // In the end uncordon node to schedule workload.
 // If at any point an error occurs, we reboot the node so that node has correct configuration.
-func (dn *Daemon) performPostConfigChangeAction(postConfigChangeActions []string, configName string) error {
+// Reboots use the given strategy, see getRebootStrategy.
+func (dn *Daemon) performPostConfigChangeAction(postConfigChangeActions []string, configName, rebootStrategy string) error {
 	if ctrlcommon.InSlice(postConfigChangeActionReboot, postConfigChangeActions) {
 		logSystem("Rebooting node")
-		return dn.reboot(fmt.Sprintf("Node will reboot into config %s", configName))
+		return dn.rebootWithStrategy(fmt.Sprintf("Node will reboot into config %s", configName), rebootStrategy)
 	}
 
 	if ctrlcommon.InSlice(postConfigChangeActionNone, postConfigChangeActions) {
@@ -101,6 +159,48 @@ func (dn *Daemon) performPostConfigChangeAction(postConfigChangeActions []string
 		logSystem("%s config reloaded successfully! Desired config %s has been applied, skipping reboot", serviceName, configName)
 	}
 
+	if ctrlcommon.InSlice(postConfigChangeActionRestartCrio, postConfigChangeActions) {
+		serviceName := "crio"
+
+		if err := restartService(serviceName); err != nil {
+			if dn.nodeWriter != nil {
+				dn.nodeWriter.Eventf(corev1.EventTypeWarning, "FailedServiceRestart", fmt.Sprintf("Restarting %s service failed. Error: %v", serviceName, err))
+			}
+			return fmt.Errorf("could not apply update: restarting %s failed. Error: %w", serviceName, err)
+		}
+
+		if dn.nodeWriter != nil {
+			dn.nodeWriter.Eventf(corev1.EventTypeNormal, "SkipReboot", "Config changes do not require reboot. Service %s was restarted.", serviceName)
+		}
+		logSystem("%s restarted successfully! Desired config %s has been applied, skipping reboot", serviceName, configName)
+	}
+
+	for _, serviceAction := range postConfigChangeServiceActions {
+		if !ctrlcommon.InSlice(serviceAction.action, postConfigChangeActions) {
+			continue
+		}
+
+		if err := serviceAction.run(); err != nil {
+			if dn.nodeWriter != nil {
+				if serviceAction.restart {
+					dn.nodeWriter.Eventf(corev1.EventTypeWarning, "FailedServiceRestart", fmt.Sprintf("Restarting %s service failed. Error: %v", serviceAction.service, err))
+				} else {
+					dn.nodeWriter.Eventf(corev1.EventTypeWarning, "FailedServiceReload", fmt.Sprintf("Reloading %s service failed. Error: %v", serviceAction.service, err))
+				}
+			}
+			return fmt.Errorf("could not apply update: %s failed. Error: %w", serviceAction.action, err)
+		}
+
+		if dn.nodeWriter != nil {
+			dn.nodeWriter.Eventf(corev1.EventTypeNormal, "SkipReboot", "Config changes do not require reboot. Service %s was %s.", serviceAction.service, serviceAction.describe())
+		}
+		logSystem("%s %s successfully! Desired config %s has been applied, skipping reboot", serviceAction.service, serviceAction.describe(), configName)
+	}
+
+	if err := dn.performDisruptionPolicyActions(postConfigChangeActions, configName); err != nil {
+		return err
+	}
+
 	// We are here, which means reboot was not needed to apply the configuration.
 
 	// Get current state of node, in case of an error reboot
@@ -124,7 +224,7 @@ func (dn *Daemon) performPostConfigChangeAction(postConfigChangeActions []string
 }
 
 func setRunningKargsWithCmdline(config *mcfgv1.MachineConfig, requestedKargs []string, cmdline []byte) error {
-	splits := splitKernelArguments(strings.TrimSpace(string(cmdline)))
+	splits := ctrlcommon.SplitKernelArguments(strings.TrimSpace(string(cmdline)))
 	config.Spec.KernelArguments = nil
 	for _, split := range splits {
 		for _, reqKarg := range requestedKargs {
@@ -332,33 +432,7 @@ func (dn *CoreOSDaemon) applyOSChanges(mcDiff machineConfigDiff, oldConfig, newC
 	return nil
 }
 
-func calculatePostConfigChangeActionFromFileDiffs(diffFileSet []string) (actions []string) {
-	filesPostConfigChangeActionNone := []string{
-		caBundleFilePath,
-		imageRegistryAuthFile,
-		"/var/lib/kubelet/config.json",
-	}
-	filesPostConfigChangeActionReloadCrio := []string{
-		constants.ContainerRegistryConfPath,
-		GPGNoRebootPath,
-		"/etc/containers/policy.json",
-	}
-
-	actions = []string{postConfigChangeActionNone}
-	for _, path := range diffFileSet {
-		if ctrlcommon.InSlice(path, filesPostConfigChangeActionNone) {
-			continue
-		} else if ctrlcommon.InSlice(path, filesPostConfigChangeActionReloadCrio) {
-			actions = []string{postConfigChangeActionReloadCrio}
-		} else {
-			actions = []string{postConfigChangeActionReboot}
-			return
-		}
-	}
-	return
-}
-
-func calculatePostConfigChangeAction(diff *machineConfigDiff, diffFileSet []string) ([]string, error) {
+func calculatePostConfigChangeAction(diff *machineConfigDiff, diffFileSet []string, policy *ctrlcommon.DisruptionPolicy) ([]string, error) {
 	// If a machine-config-daemon-force file is present, it means the user wants to
 	// move to desired state without additional validation. We will reboot the node in
 	// this case regardless of what MachineConfig diff is.
@@ -370,16 +444,48 @@ func calculatePostConfigChangeAction(diff *machineConfigDiff, diffFileSet []stri
 		return []string{postConfigChangeActionReboot}, nil
 	}
 
+	return calculatePostConfigChangeActionFromDiff(diff, diffFileSet, policy), nil
+}
+
+// calculatePostConfigChangeActionFromDiff is calculatePostConfigChangeAction
+// without the forcefile, so that it can also be used to find out what an
+// update would do before applying it.
+func calculatePostConfigChangeActionFromDiff(diff *machineConfigDiff, diffFileSet []string, policy *ctrlcommon.DisruptionPolicy) []string {
 	if diff.osUpdate || diff.kargs || diff.fips || diff.units || diff.kernelType || diff.extensions {
 		// must reboot
-		return []string{postConfigChangeActionReboot}, nil
+		return []string{postConfigChangeActionReboot}
 	}
 
 	// We don't actually have to consider ssh keys changes, which is the only section of passwd that is allowed to change
-	return calculatePostConfigChangeActionFromFileDiffs(diffFileSet), nil
+	return ctrlcommon.CalculatePostConfigChangeActions(diffFileSet, policy)
 }
 
-func (dn *Daemon) updateImage(newConfig *mcfgv1.MachineConfig, oldImage, newImage string) error {
+// postConfigChangeActionsReport is what the MCD reports under PostConfigChangeActionsAnnotationKey.
+type postConfigChangeActionsReport struct {
+	Config  string   `json:"config"`
+	Actions []string `json:"actions"`
+}
+
+// reportPostConfigChangeActions records the post config change actions chosen for the update to the given config in
+// the node status, so that it is visible why the node was or was not drained and rebooted. This is informational, so
+// failures are only logged.
+func (dn *Daemon) reportPostConfigChangeActions(configName string, actions []string) {
+	if dn.nodeWriter == nil {
+		return
+	}
+
+	out, err := json.Marshal(postConfigChangeActionsReport{Config: configName, Actions: actions})
+	if err != nil {
+		klog.Warningf("Could not encode post config change actions: %v", err)
+		return
+	}
+
+	if _, err := dn.nodeWriter.SetNodeStatus(map[string]string{constants.PostConfigChangeActionsAnnotationKey: string(out)}); err != nil {
+		klog.Warningf("Could not report post config change actions: %v", err)
+	}
+}
+
+func (dn *Daemon) updateImage(oldConfig, newConfig *mcfgv1.MachineConfig, oldImage, newImage string) error {
 	if dn.nodeWriter != nil {
 		state, err := getNodeAnnotationExt(dn.node, constants.MachineConfigDaemonStateAnnotationKey, true)
 		if err != nil {
@@ -398,6 +504,18 @@ func (dn *Daemon) updateImage(newConfig *mcfgv1.MachineConfig, oldImage, newImag
 		logSystem("Starting transition from %q to %q", oldImage, newImage)
 	}
 
+	// Verify the image before draining so that an image which fails
+	// verification does not disrupt the node. Only images referenced by digest
+	// are applied, so that the image which is verified and checked for after
+	// the reboot is the one which was built, even if its tag has been moved.
+	if err := ctrlcommon.ValidateDigestedPullspec(newImage); err != nil {
+		return fmt.Errorf("refusing to update to image %s: %w", newImage, err)
+	}
+
+	if err := dn.verifyImageSignature(newImage); err != nil {
+		return err
+	}
+
 	if err := dn.performDrain(); err != nil {
 		return err
 	}
@@ -406,6 +524,10 @@ func (dn *Daemon) updateImage(newConfig *mcfgv1.MachineConfig, oldImage, newImag
 		return err
 	}
 
+	if err := dn.applyPerNodeLayeredChanges(oldConfig, newConfig); err != nil {
+		return err
+	}
+
 	odc := &onDiskConfig{
 		currentImage:  newImage,
 		currentConfig: newConfig,
@@ -418,13 +540,99 @@ func (dn *Daemon) updateImage(newConfig *mcfgv1.MachineConfig, oldImage, newImag
 	return dn.reboot(fmt.Sprintf("Node will reboot into image %s", newImage))
 }
 
+// revertLayeredImage rolls a layered node back onto the stock OS image and the
+// files, units and OS changes of the given rendered MachineConfig. The layered
+// image is treated as the old OS image, so that the regular update path rebases
+// onto the stock OS image and writes the files and units which the layered
+// image carried.
+func (dn *Daemon) revertLayeredImage(oldConfig, newConfig *mcfgv1.MachineConfig, oldImage string) error {
+	logSystem("Starting transition from %q to the stock OS image %q", oldImage, newConfig.Spec.OSImageURL)
+
+	oldConfig = canonicalizeEmptyMC(oldConfig).DeepCopy()
+	oldConfig.Spec.OSImageURL = oldImage
+
+	return dn.update(oldConfig, newConfig, true)
+}
+
+// applyPerNodeLayeredChanges applies the parts of a MachineConfig which a
+// layered OS image cannot carry: files under the paths ostree keeps on the node
+// (e.g., the pull secret), SSH keys, which live in /var/home, and kernel
+// arguments, which are part of the boot configuration. The kernel arguments are
+// applied to the deployment staged by the rebase.
+func (dn *Daemon) applyPerNodeLayeredChanges(oldConfig, newConfig *mcfgv1.MachineConfig) error {
+	oldConfig = canonicalizeEmptyMC(oldConfig)
+
+	diff, err := newMachineConfigDiff(oldConfig, newConfig)
+	if err != nil {
+		return err
+	}
+
+	oldIgnConfig, err := ctrlcommon.ParseAndConvertConfig(oldConfig.Spec.Config.Raw)
+	if err != nil {
+		return fmt.Errorf("parsing old Ignition config failed: %w", err)
+	}
+	newIgnConfig, err := ctrlcommon.ParseAndConvertConfig(newConfig.Spec.Config.Raw)
+	if err != nil {
+		return fmt.Errorf("parsing new Ignition config failed: %w", err)
+	}
+
+	if diff.files {
+		perNodeFiles := []ign3types.File{}
+		for _, file := range newIgnConfig.Storage.Files {
+			if ctrlcommon.IsPerNodePath(file.Path) {
+				perNodeFiles = append(perNodeFiles, file)
+			}
+		}
+
+		if len(perNodeFiles) != 0 {
+			logSystem("Writing %d per-node files which are not part of the OS image", len(perNodeFiles))
+			if err := dn.writeFiles(perNodeFiles, false); err != nil {
+				return err
+			}
+			if err := fixAuthorizedKeysFiles(perNodeFiles); err != nil {
+				return err
+			}
+		}
+	}
+
+	if diff.passwd {
+		if err := dn.updateSSHKeys(newIgnConfig, oldIgnConfig); err != nil {
+			return err
+		}
+	}
+
+	if diff.luks {
+		if err := dn.updateLUKS(oldIgnConfig, newIgnConfig); err != nil {
+			return err
+		}
+	}
+
+	if diff.kargs && dn.os.IsCoreOSVariant() {
+		coreOSDaemon := CoreOSDaemon{dn}
+		if err := coreOSDaemon.updateKernelArguments(oldConfig.Spec.KernelArguments, newConfig.Spec.KernelArguments); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 // update the node to the provided node configuration.
 //
 //nolint:gocyclo
-func (dn *Daemon) update(oldConfig, newConfig *mcfgv1.MachineConfig, skipCertificateWrite bool) (retErr error) {
+func (dn *Daemon) update(oldConfig, newConfig *mcfgv1.MachineConfig, skipCertificateWrite bool) error {
+	return dn.applyUpdate(oldConfig, newConfig, skipCertificateWrite, nil)
+}
+
+// applyUpdate applies the update, or stages it when staging is set: the node
+// is neither drained nor rebooted, and the OS deployment is left staged until
+// the update is finalized.
+func (dn *Daemon) applyUpdate(oldConfig, newConfig *mcfgv1.MachineConfig, skipCertificateWrite bool, staging *ctrlcommon.StagedUpdatesConfig) (retErr error) {
 	oldConfig = canonicalizeEmptyMC(oldConfig)
 
-	if dn.nodeWriter != nil {
+	// Staging does not disrupt the node, so it is not working on an update
+	// until the update is finalized.
+	if dn.nodeWriter != nil && staging == nil {
 		state, err := getNodeAnnotationExt(dn.node, constants.MachineConfigDaemonStateAnnotationKey, true)
 		if err != nil {
 			return err
@@ -468,24 +676,51 @@ func (dn *Daemon) update(oldConfig, newConfig *mcfgv1.MachineConfig, skipCertifi
 		return &unreconcilableErr{wrappedErr}
 	}
 
+	// Kernel arguments cannot always be changed with rpm-ostree, so find out
+	// before the node is drained.
+	if diff.kargs {
+		var err error
+		if dn.os.IsCoreOSVariant() {
+			err = dn.checkKernelArgumentsUpdatable(diff.osUpdate)
+		} else {
+			packageDaemon := PackageDaemon{dn}
+			err = packageDaemon.checkKernelArgumentsUpdatable()
+		}
+		if err != nil {
+			if dn.nodeWriter != nil {
+				dn.nodeWriter.Eventf(corev1.EventTypeWarning, "KernelArgumentsNotUpdatable", err.Error())
+			}
+			return err
+		}
+	}
+
 	logSystem("Starting update from %s to %s: %+v", oldConfigName, newConfigName, diff)
 
 	diffFileSet := ctrlcommon.CalculateConfigFileDiffs(&oldIgnConfig, &newIgnConfig)
-	actions, err := calculatePostConfigChangeAction(diff, diffFileSet)
+	actions, err := calculatePostConfigChangeAction(diff, diffFileSet, getNodeDisruptionPolicy(dn.node))
+	if err != nil {
+		return err
+	}
+	actions, err = ctrlcommon.CheckContainerStorageConfChanges(actions, diffFileSet, oldIgnConfig, newIgnConfig)
 	if err != nil {
 		return err
 	}
 
+	dn.reportPostConfigChangeActions(newConfigName, actions)
+
 	// Check and perform node drain if required
-	drain, err := isDrainRequired(actions, diffFileSet, oldIgnConfig, newIgnConfig)
+	drain, err := ctrlcommon.IsDrainRequired(actions, diffFileSet, oldIgnConfig, newIgnConfig)
 	if err != nil {
 		return err
 	}
-	if drain {
+	switch {
+	case staging != nil:
+		klog.Info("Staging the update, the node is drained when it is finalized.")
+	case drain:
 		if err := dn.performDrain(); err != nil {
 			return err
 		}
-	} else {
+	default:
 		klog.Info("Changes do not require drain, skipping.")
 	}
 
@@ -512,13 +747,13 @@ func (dn *Daemon) update(oldConfig, newConfig *mcfgv1.MachineConfig, skipCertifi
 	// only update passwd if it has changed (do not nullify)
 	// we do not need to include SetPasswordHash in this, since only updateSSHKeys has issues on firstboot.
 	if diff.passwd {
-		if err := dn.updateSSHKeys(newIgnConfig.Passwd.Users, oldIgnConfig.Passwd.Users); err != nil {
+		if err := dn.updateSSHKeys(newIgnConfig, oldIgnConfig); err != nil {
 			return err
 		}
 
 		defer func() {
 			if retErr != nil {
-				if err := dn.updateSSHKeys(newIgnConfig.Passwd.Users, oldIgnConfig.Passwd.Users); err != nil {
+				if err := dn.updateSSHKeys(newIgnConfig, oldIgnConfig); err != nil {
 					errs := kubeErrs.NewAggregate([]error{err, retErr})
 					retErr = fmt.Errorf("error rolling back SSH keys updates: %w", errs)
 					return
@@ -542,6 +777,36 @@ func (dn *Daemon) update(oldConfig, newConfig *mcfgv1.MachineConfig, skipCertifi
 		}
 	}()
 
+	if diff.luks {
+		if err := dn.updateLUKS(oldIgnConfig, newIgnConfig); err != nil {
+			return err
+		}
+
+		defer func() {
+			if retErr != nil {
+				if err := dn.updateLUKS(newIgnConfig, oldIgnConfig); err != nil {
+					errs := kubeErrs.NewAggregate([]error{err, retErr})
+					retErr = fmt.Errorf("error rolling back LUKS updates: %w", errs)
+					return
+				}
+			}
+		}()
+	}
+
+	// The kubelet of the new config may allow swap, or no longer allow it.
+	if err := dn.reconcileSwap(newIgnConfig); err != nil {
+		return err
+	}
+	defer func() {
+		if retErr != nil {
+			if err := dn.reconcileSwap(oldIgnConfig); err != nil {
+				errs := kubeErrs.NewAggregate([]error{err, retErr})
+				retErr = fmt.Errorf("error rolling back swap: %w", errs)
+				return
+			}
+		}
+	}()
+
 	if dn.os.IsCoreOSVariant() {
 		coreOSDaemon := CoreOSDaemon{dn}
 		if err := coreOSDaemon.applyOSChanges(*diff, oldConfig, newConfig); err != nil {
@@ -558,7 +823,20 @@ func (dn *Daemon) update(oldConfig, newConfig *mcfgv1.MachineConfig, skipCertifi
 			}
 		}()
 	} else {
-		klog.Info("updating the OS on non-CoreOS nodes is not supported")
+		packageDaemon := PackageDaemon{dn}
+		if err := packageDaemon.applyPackageBasedChanges(diff, oldConfig, newConfig); err != nil {
+			return err
+		}
+
+		defer func() {
+			if retErr != nil {
+				if err := packageDaemon.applyPackageBasedChanges(diff, newConfig, oldConfig); err != nil {
+					errs := kubeErrs.NewAggregate([]error{err, retErr})
+					retErr = fmt.Errorf("error rolling back changes to kernel arguments: %w", errs)
+					return
+				}
+			}
+		}()
 	}
 
 	// Ideally we would want to update kernelArguments only via MachineConfigs.
@@ -588,7 +866,16 @@ func (dn *Daemon) update(oldConfig, newConfig *mcfgv1.MachineConfig, skipCertifi
 		}
 	}()
 
-	return dn.performPostConfigChangeAction(actions, newConfig.GetName())
+	if staging != nil {
+		return dn.completeStaging(oldConfig, newConfig, staging)
+	}
+
+	rebootStrategy, err := dn.getRebootStrategy(diff)
+	if err != nil {
+		return err
+	}
+
+	return dn.performPostConfigChangeAction(actions, newConfig.GetName(), rebootStrategy)
 }
 
 // This is currently a subsection copied over from update() since we need to be more nuanced. Should eventually
@@ -619,13 +906,13 @@ func (dn *Daemon) updateHypershift(oldConfig, newConfig *mcfgv1.MachineConfig, d
 		}
 	}()
 
-	if err := dn.updateSSHKeys(newIgnConfig.Passwd.Users, oldIgnConfig.Passwd.Users); err != nil {
+	if err := dn.updateSSHKeys(newIgnConfig, oldIgnConfig); err != nil {
 		return err
 	}
 
 	defer func() {
 		if retErr != nil {
-			if err := dn.updateSSHKeys(newIgnConfig.Passwd.Users, oldIgnConfig.Passwd.Users); err != nil {
+			if err := dn.updateSSHKeys(newIgnConfig, oldIgnConfig); err != nil {
 				errs := kubeErrs.NewAggregate([]error{err, retErr})
 				retErr = fmt.Errorf("error rolling back SSH keys updates: %w", errs)
 				return
@@ -687,6 +974,7 @@ type machineConfigDiff struct {
 	units      bool
 	kernelType bool
 	extensions bool
+	luks       bool
 }
 
 // isEmpty returns true if the machineConfigDiff has no changes, or
@@ -752,6 +1040,7 @@ func newMachineConfigDiff(oldConfig, newConfig *mcfgv1.MachineConfig) (*machineC
 		units:      !reflect.DeepEqual(oldIgn.Systemd.Units, newIgn.Systemd.Units),
 		kernelType: canonicalizeKernelType(oldConfig.Spec.KernelType) != canonicalizeKernelType(newConfig.Spec.KernelType),
 		extensions: !(extensionsEmpty || reflect.DeepEqual(oldConfig.Spec.Extensions, newConfig.Spec.Extensions)),
+		luks:       !reflect.DeepEqual(oldIgn.Storage.Luks, newIgn.Storage.Luks),
 	}, nil
 }
 
@@ -783,7 +1072,7 @@ func reconcilable(oldConfig, newConfig *mcfgv1.MachineConfig) (*machineConfigDif
 	// Passwd section
 
 	// we don't currently configure Groups in place. we don't configure Users except
-	// for setting/updating SSHAuthorizedKeys for the only allowed user "core".
+	// for setting/updating SSHAuthorizedKeys, and the PasswordHash of the user "core".
 	// otherwise we can't fix it if something changed here.
 	passwdChanged := !reflect.DeepEqual(oldIgn.Passwd, newIgn.Passwd)
 
@@ -793,16 +1082,11 @@ func reconcilable(oldConfig, newConfig *mcfgv1.MachineConfig) (*machineConfigDif
 		}
 		if !reflect.DeepEqual(oldIgn.Passwd.Users, newIgn.Passwd.Users) {
 			// there is an update to Users, we must verify that it is ONLY making an acceptable
-			// change to the SSHAuthorizedKeys for the user "core"
+			// change to the SSHAuthorizedKeys of the users, or the PasswordHash of the user "core".
+			// The absence of a user here does not mean "remove the user from the system"
 			for _, user := range newIgn.Passwd.Users {
-				if user.Name != constants.CoreUserName {
-					return nil, fmt.Errorf("ignition passwd user section contains unsupported changes: non-core user")
-				}
-			}
-			// We don't want to panic if the "new" users is empty, and it's still reconcilable because the absence of a user here does not mean "remove the user from the system"
-			if len(newIgn.Passwd.Users) != 0 {
-				klog.Infof("user data to be verified before ssh update: %v", newIgn.Passwd.Users[len(newIgn.Passwd.Users)-1])
-				if err := verifyUserFields(newIgn.Passwd.Users[len(newIgn.Passwd.Users)-1]); err != nil {
+				klog.Infof("user data to be verified before ssh update: %v", user)
+				if err := ctrlcommon.VerifyUserFields(user); err != nil {
 					return nil, err
 				}
 			}
@@ -829,6 +1113,10 @@ func reconcilable(oldConfig, newConfig *mcfgv1.MachineConfig) (*machineConfigDif
 	if !reflect.DeepEqual(oldIgn.Storage.Raid, newIgn.Storage.Raid) {
 		return nil, fmt.Errorf("ignition raid section contains changes")
 	}
+	// we can rebind LUKS volumes to other Tang servers and change their keys
+	if err := verifyLUKSChanges(oldIgn.Storage.Luks, newIgn.Storage.Luks); err != nil {
+		return nil, err
+	}
 	if !reflect.DeepEqual(oldIgn.Storage.Directories, newIgn.Storage.Directories) {
 		return nil, fmt.Errorf("ignition directories section contains changes")
 	}
@@ -872,28 +1160,6 @@ func reconcilable(oldConfig, newConfig *mcfgv1.MachineConfig) (*machineConfigDif
 	return mcDiff, nil
 }
 
-// verifyUserFields returns nil for the user Name = "core" if 1 or more SSHKeys exist for
-// this user or if a password exists for this user and if all other fields in User are empty.
-// Otherwise, an error will be returned and the proposed config will not be reconcilable.
-// At this time we do not support non-"core" users or any changes to the "core" user
-// outside of SSHAuthorizedKeys and passwordHash.
-func verifyUserFields(pwdUser ign3types.PasswdUser) error {
-	emptyUser := ign3types.PasswdUser{}
-	tempUser := pwdUser
-	if tempUser.Name == constants.CoreUserName && ((tempUser.PasswordHash) != nil || len(tempUser.SSHAuthorizedKeys) >= 1) {
-		tempUser.Name = ""
-		tempUser.SSHAuthorizedKeys = nil
-		tempUser.PasswordHash = nil
-		if !reflect.DeepEqual(emptyUser, tempUser) {
-			return fmt.Errorf("SSH keys and password hash are not reconcilable")
-		}
-		klog.Info("SSH Keys reconcilable")
-	} else {
-		return fmt.Errorf("ignition passwd user section contains unsupported changes: user must be core and have 1 or more sshKeys")
-	}
-	return nil
-}
-
 // checkFIPS verifies the state of FIPS on the system before an update.
 // Our new thought around this is that really FIPS should be a "day 1"
 // operation, and we don't want to make it editable after the fact.
@@ -926,56 +1192,12 @@ func checkFIPS(current, desired *mcfgv1.MachineConfig) error {
 	return fmt.Errorf("detected change to FIPS flag; refusing to modify FIPS on a running cluster")
 }
 
-// checks for white-space characters in "C" and "POSIX" locales.
-func isSpace(b byte) bool {
-	return b == ' ' || b == '\f' || b == '\n' || b == '\r' || b == '\t' || b == '\v'
-}
-
-// You can use " around spaces, but can't escape ". See next_arg() in kernel code /lib/cmdline.c
-// Gives the start and stop index for the next arg in the string, beyond the provided `begin` index
-func nextArg(args string, begin int) (int, int) {
-	var (
-		start, stop int
-		inQuote     bool
-	)
-	// Skip leading spaces
-	for start = begin; start < len(args) && isSpace(args[start]); {
-		start++
-	}
-	stop = start
-	for ; stop < len(args); stop++ {
-		if isSpace(args[stop]) && !inQuote {
-			break
-		}
-
-		if args[stop] == '"' {
-			inQuote = !inQuote
-		}
-	}
-
-	return start, stop
-}
-
-func splitKernelArguments(args string) []string {
-	var (
-		start, stop int
-		split       []string
-	)
-	for stop < len(args) {
-		start, stop = nextArg(args, stop)
-		if start != stop {
-			split = append(split, args[start:stop])
-		}
-	}
-	return split
-}
-
 // parseKernelArguments separates out kargs from each entry and returns it as a map for
 // easy comparison
 func parseKernelArguments(kargs []string) []string {
 	parsed := []string{}
 	for _, k := range kargs {
-		for _, arg := range splitKernelArguments(k) {
+		for _, arg := range ctrlcommon.SplitKernelArguments(k) {
 			parsed = append(parsed, strings.TrimSpace(arg))
 		}
 	}
@@ -1012,51 +1234,36 @@ func (dn *CoreOSDaemon) updateKernelArguments(oldKernelArguments, newKernelArgum
 		return nil
 	}
 
+	setup, err := dn.getBootSetup()
+	if err != nil {
+		return err
+	}
+
+	// rpm-ostree would fail to rewrite the bootloader entries, or rewrite
+	// entries which are not used to boot. The kernel arguments are verified
+	// against the booted command line after the reboot instead.
+	if setup.kargsFromImage() {
+		logSystem("Node boots with %s; not running rpm-ostree kargs %v, the OS image must carry the kernel arguments", setup, kargs)
+		return nil
+	}
+
 	args := append([]string{"kargs"}, kargs...)
 	logSystem("Running rpm-ostree %v", args)
 	return runRpmOstree(args...)
 }
 
-func (dn *Daemon) generateExtensionsArgs(oldConfig, newConfig *mcfgv1.MachineConfig) []string {
-	removed := []string{}
-	added := []string{}
-
-	oldExt := make(map[string]bool)
-	for _, ext := range oldConfig.Spec.Extensions {
-		oldExt[ext] = true
-	}
-	newExt := make(map[string]bool)
-	for _, ext := range newConfig.Spec.Extensions {
-		newExt[ext] = true
-	}
-
-	for ext := range oldExt {
-		if !newExt[ext] {
-			removed = append(removed, ext)
-		}
-	}
-	for ext := range newExt {
-		if !oldExt[ext] {
-			added = append(added, ext)
-		}
-	}
+// getExtensionPackages returns the packages enabling the given extensions,
+// sorted and without duplicates. Extensions only have packages on RHCOS, SCOS
+// and FCOS.
+func (dn *Daemon) getExtensionPackages(exts []string) []string {
+	pkgs := sets.NewString()
 
 	// Supported extensions has package list info that is required
 	// to enable an extension
-
-	extArgs := []string{"update"}
-
 	if dn.os.IsEL() {
 		extensions := getSupportedExtensions()
-		for _, ext := range added {
-			for _, pkg := range extensions[ext] {
-				extArgs = append(extArgs, "--install", pkg)
-			}
-		}
-		for _, ext := range removed {
-			for _, pkg := range extensions[ext] {
-				extArgs = append(extArgs, "--uninstall", pkg)
-			}
+		for _, ext := range exts {
+			pkgs.Insert(extensions[ext]...)
 		}
 	}
 
@@ -1066,47 +1273,51 @@ func (dn *Daemon) generateExtensionsArgs(oldConfig, newConfig *mcfgv1.MachineCon
 	// TODO: Once the package list has been stabilized, we can make use of the group and add
 	// all the packages required to enable OKD as a single extension.
 	if dn.os.IsFCOS() {
-		for _, ext := range added {
-			extArgs = append(extArgs, "--install", ext)
-		}
-		for _, ext := range removed {
-			extArgs = append(extArgs, "--uninstall", ext)
-		}
+		pkgs.Insert(exts...)
 	}
 
-	return extArgs
+	return pkgs.List()
 }
 
-// Returns list of extensions possible to install on a CoreOS based system.
-func getSupportedExtensions() map[string][]string {
-	// In future when list of extensions grow, it will make
-	// more sense to populate it in a dynamic way.
+// getExtensionPackageChanges returns the packages to install and uninstall
+// to go from the old to the new extension packages, given the packages
+// requested in the deployment being updated. Only requested packages are
+// uninstalled and packages are only installed when not requested yet, so that
+// an update which was partially applied, or a package shared by a removed and
+// a remaining extension, does not fail the transaction. The dependencies of
+// the uninstalled packages are removed along with them by rpm-ostree, unless
+// another requested package still needs them.
+func getExtensionPackageChanges(oldPkgs, newPkgs, requestedPkgs []string) (install, uninstall []string) {
+	requested := sets.NewString(requestedPkgs...)
+	desired := sets.NewString(newPkgs...)
 
-	// These are RHCOS supported extensions.
-	// Each extension keeps a list of packages required to get enabled on host.
-	return map[string][]string{
-		"wasm":                 {"crun-wasm"},
-		"ipsec":                {"NetworkManager-libreswan", "libreswan"},
-		"usbguard":             {"usbguard"},
-		"kerberos":             {"krb5-workstation", "libkadm5"},
-		"kernel-devel":         {"kernel-devel", "kernel-headers"},
-		"sandboxed-containers": {"kata-containers"},
-	}
+	install = desired.Difference(requested).List()
+	uninstall = sets.NewString(oldPkgs...).Difference(desired).Intersection(requested).List()
+
+	return install, uninstall
 }
 
-func validateExtensions(exts []string) error {
-	supportedExtensions := getSupportedExtensions()
-	invalidExts := []string{}
-	for _, ext := range exts {
-		if _, ok := supportedExtensions[ext]; !ok {
-			invalidExts = append(invalidExts, ext)
-		}
+// generateExtensionsArgs returns the rpm-ostree arguments applying the given
+// package changes.
+func generateExtensionsArgs(install, uninstall []string) []string {
+	extArgs := []string{"update"}
+	for _, pkg := range install {
+		extArgs = append(extArgs, "--install", pkg)
 	}
-	if len(invalidExts) != 0 {
-		return fmt.Errorf("invalid extensions found: %v", invalidExts)
+	for _, pkg := range uninstall {
+		extArgs = append(extArgs, "--uninstall", pkg)
 	}
-	return nil
 
+	return extArgs
+}
+
+// Returns list of extensions possible to install on a CoreOS based system.
+func getSupportedExtensions() map[string][]string {
+	return ctrlcommon.SupportedExtensions()
+}
+
+func validateExtensions(exts []string) error {
+	return ctrlcommon.ValidateExtensions(exts)
 }
 
 func (dn *CoreOSDaemon) applyExtensions(oldConfig, newConfig *mcfgv1.MachineConfig) error {
@@ -1121,9 +1332,39 @@ func (dn *CoreOSDaemon) applyExtensions(oldConfig, newConfig *mcfgv1.MachineConf
 		return err
 	}
 
-	args := dn.generateExtensionsArgs(oldConfig, newConfig)
+	// The pending deployment, if any, carries the packages requested in the
+	// booted one along with those requested by e.g. the kernel switch.
+	booted, staged, err := dn.NodeUpdaterClient.GetBootedAndStagedDeployment()
+	if err != nil {
+		return fmt.Errorf("could not get OS deployments: %w", err)
+	}
+	deployment := booted
+	if staged != nil {
+		deployment = staged
+	}
+
+	oldPkgs := dn.getExtensionPackages(oldConfig.Spec.Extensions)
+	install, uninstall := getExtensionPackageChanges(oldPkgs, dn.getExtensionPackages(newConfig.Spec.Extensions), deployment.RequestedPackages)
+	for _, pkg := range oldPkgs {
+		if !ctrlcommon.InSlice(pkg, deployment.RequestedPackages) && !ctrlcommon.InSlice(pkg, install) {
+			klog.Infof("Package %s of the previous extensions is not layered on deployment %s, nothing to uninstall", pkg, deployment.ID)
+		}
+	}
+
+	args := generateExtensionsArgs(install, uninstall)
 	klog.Infof("Applying extensions : %+q", args)
-	return runRpmOstree(args...)
+	if err := runRpmOstree(args...); err != nil {
+		if len(uninstall) == 0 {
+			return err
+		}
+		return fmt.Errorf("could not uninstall packages %v of the removed extensions from deployment %s, they may be required by other layered packages: %w", uninstall, deployment.ID, err)
+	}
+
+	if len(uninstall) != 0 && dn.nodeWriter != nil {
+		dn.nodeWriter.Eventf(corev1.EventTypeNormal, "ExtensionsRemoved", "Uninstalled packages %v of the removed extensions", uninstall)
+	}
+
+	return nil
 }
 
 // switchKernel updates kernel on host with the kernelType specified in MachineConfig.
@@ -1192,6 +1433,9 @@ func (dn *Daemon) updateFiles(oldIgnConfig, newIgnConfig ign3types.Config, skipC
 	if err := dn.writeFiles(newIgnConfig.Storage.Files, skipCertificateWrite); err != nil {
 		return err
 	}
+	if err := fixAuthorizedKeysFiles(newIgnConfig.Storage.Files); err != nil {
+		return err
+	}
 	if err := dn.writeUnits(newIgnConfig.Systemd.Units); err != nil {
 		return err
 	}
@@ -1264,10 +1508,15 @@ func (dn *Daemon) deleteStaleData(oldIgnConfig, newIgnConfig ign3types.Config) e
 		caBundleFilePath,
 		cloudCABundleFilePath,
 	}
+	unmanagedPaths := dn.getUnmanagedPaths()
 	for _, f := range oldIgnConfig.Storage.Files {
 		if _, ok := newFileSet[f.Path]; ok {
 			continue
 		}
+		if ctrlcommon.IsUnmanagedPath(f.Path, unmanagedPaths) {
+			klog.Infof("Not removing unmanaged file %q", f.Path)
+			continue
+		}
 		skipBecauseCert := false
 		for _, cert := range certsToSkip {
 			if cert == f.Path {
@@ -1539,8 +1788,9 @@ func (dn *Daemon) writeUnits(units []ign3types.Unit) error {
 
 // writeFiles writes the given files to disk.
 // it doesn't fetch remote files and expects a flattened config file.
+// existing files covered by the node's unmanaged paths are not overwritten.
 func (dn *Daemon) writeFiles(files []ign3types.File, skipCertificateWrite bool) error {
-	return writeFiles(files, skipCertificateWrite)
+	return writeFiles(skipExistingUnmanagedFiles(files, dn.getUnmanagedPaths()), skipCertificateWrite)
 }
 
 // Ensures that both the SSH root directory (/home/core/.ssh) as well as any
@@ -1638,8 +1888,12 @@ func (dn *Daemon) SetPasswordHash(newUsers, oldUsers []ign3types.PasswdUser) err
 		return fmt.Errorf("failed to check if user core exists: %w", err)
 	}
 
-	// SetPasswordHash sets the password hash of the specified user.
+	// SetPasswordHash sets the password hash of the specified user. Passwords of
+	// other users are not managed by the MCO.
 	for _, u := range newUsers {
+		if u.Name != constants.CoreUserName {
+			continue
+		}
 		pwhash := "*"
 		if u.PasswordHash != nil && *u.PasswordHash != "" {
 			pwhash = *u.PasswordHash
@@ -1678,13 +1932,22 @@ func (dn *Daemon) useNewSSHKeyPath() bool {
 	return dn.os.IsEL9() || dn.os.IsFCOS() || dn.os.IsSCOS()
 }
 
-// Update a given PasswdUser's SSHKey
-func (dn *Daemon) updateSSHKeys(newUsers, oldUsers []ign3types.PasswdUser) error {
+// Update the SSHKeys of the PasswdUsers of the config. Authorized keys files
+// which the config provides as files are kept.
+func (dn *Daemon) updateSSHKeys(newIgnConfig, oldIgnConfig ign3types.Config) error {
 	klog.Info("updating SSH keys")
 
+	newUsers, oldUsers := newIgnConfig.Passwd.Users, oldIgnConfig.Passwd.Users
+
 	// Checking to see if absent users need to be deconfigured
 	deconfigureAbsentUsers(newUsers, oldUsers)
 
+	if !dn.mock {
+		if err := dn.updateOtherUsersSSHKeys(newUsers, oldUsers); err != nil {
+			return err
+		}
+	}
+
 	var uErr user.UnknownUserError
 	switch _, err := user.Lookup(constants.CoreUserName); {
 	case err == nil:
@@ -1695,16 +1958,20 @@ func (dn *Daemon) updateSSHKeys(newUsers, oldUsers []ign3types.PasswdUser) error
 		return fmt.Errorf("failed to check if user core exists: %w", err)
 	}
 
-	// we're also appending all keys for any user to core, so for now
-	// we pass this to atomicallyWriteSSHKeys to write.
-	// we know these users are "core" ones also cause this slice went through Reconcilable
+	// the keys of all entries for core are concatenated and passed to
+	// atomicallyWriteSSHKeys to write.
 	var concatSSHKeys string
 	for _, u := range newUsers {
+		if u.Name != constants.CoreUserName {
+			continue
+		}
 		for _, k := range u.SSHAuthorizedKeys {
 			concatSSHKeys = concatSSHKeys + string(k) + "\n"
 		}
 	}
 
+	keepPaths := getAuthorizedKeysFilePaths(newIgnConfig.Storage.Files)
+
 	authKeyPath := constants.RHCOS8SSHKeyPath
 
 	if !dn.mock {
@@ -1716,17 +1983,20 @@ func (dn *Daemon) updateSSHKeys(newUsers, oldUsers []ign3types.PasswdUser) error
 		if dn.useNewSSHKeyPath() {
 			authKeyPath = constants.RHCOS9SSHKeyPath
 
-			if err := cleanSSHKeyPaths(); err != nil {
+			if err := cleanSSHKeyPaths(keepPaths); err != nil {
 				return err
 			}
 
-			if err := removeNonIgnitionKeyPathFragments(); err != nil {
+			if err := removeNonIgnitionKeyPathFragments(keepPaths); err != nil {
 				return err
 			}
 		}
 
-		// Note we write keys only for the core user and so this ignores the user list
-		return dn.atomicallyWriteSSHKey(authKeyPath, concatSSHKeys)
+		if err := dn.atomicallyWriteSSHKey(authKeyPath, concatSSHKeys); err != nil {
+			return err
+		}
+
+		return restoreSELinuxContext(constants.CoreUserSSHPath)
 	}
 
 	return nil
@@ -1734,7 +2004,8 @@ func (dn *Daemon) updateSSHKeys(newUsers, oldUsers []ign3types.PasswdUser) error
 
 func deconfigureAbsentUsers(newUsers, oldUsers []ign3types.PasswdUser) {
 	for _, oldUser := range oldUsers {
-		if !isUserPresent(oldUser, newUsers) {
+		// Only the password of core is managed by the MCO
+		if oldUser.Name == constants.CoreUserName && !isUserPresent(oldUser, newUsers) {
 			klog.Infof("Absent user detected, deconfiguring the password for user %s\n", oldUser.Name)
 			deconfigureUser(oldUser)
 		}
@@ -1779,8 +2050,13 @@ func fileExists(path string) (bool, error) {
 	return false, fmt.Errorf("cannot stat file: %w", err)
 }
 
-// Removes the old SSH key path (/home/core/.ssh/authorized_keys), if found.
-func cleanSSHKeyPaths() error {
+// Removes the old SSH key path (/home/core/.ssh/authorized_keys), if found and
+// not among the paths to keep.
+func cleanSSHKeyPaths(keepPaths []string) error {
+	if ctrlcommon.InSlice(constants.RHCOS8SSHKeyPath, keepPaths) {
+		return nil
+	}
+
 	oldKeyExists, err := fileExists(constants.RHCOS8SSHKeyPath)
 	if err != nil {
 		return err
@@ -1797,8 +2073,9 @@ func cleanSSHKeyPaths() error {
 	return nil
 }
 
-// Ensures authorized_keys.d/ignition is the only fragment that exists within the /home/core/.ssh dir.
-func removeNonIgnitionKeyPathFragments() error {
+// Ensures authorized_keys.d/ignition and the fragments among the paths to keep
+// are the only fragments that exist within the /home/core/.ssh dir.
+func removeNonIgnitionKeyPathFragments(keepPaths []string) error {
 	// /home/core/.ssh/authorized_keys.d
 	authKeyFragmentDirPath := filepath.Dir(constants.RHCOS9SSHKeyPath)
 	// ignition
@@ -1807,8 +2084,8 @@ func removeNonIgnitionKeyPathFragments() error {
 	keyFragmentsDir, err := ctrlcommon.ReadDir(authKeyFragmentDirPath)
 	if err == nil {
 		for _, fragment := range keyFragmentsDir {
-			if fragment.Name() != authKeyFragmentBasename {
-				keyPath := filepath.Join(authKeyFragmentDirPath, fragment.Name())
+			keyPath := filepath.Join(authKeyFragmentDirPath, fragment.Name())
+			if fragment.Name() != authKeyFragmentBasename && !ctrlcommon.InSlice(keyPath, keepPaths) {
 				err := os.RemoveAll(keyPath)
 				if err != nil {
 					return fmt.Errorf("failed to remove path '%s': %w", keyPath, err)
@@ -1936,6 +2213,18 @@ func (dn *Daemon) updateLayeredOS(config *mcfgv1.MachineConfig) error {
 }
 
 func (dn *Daemon) updateLayeredOSToPullspec(newURL string) error {
+	// Hosts which booted a container image managed by bootc are updated with
+	// bootc instead of rpm-ostree.
+	if dn.bootcClient != nil {
+		progress := newOSUpdateProgressReporter(newURL, dn.reportOSUpdateProgress)
+		err := dn.bootcClient.SwitchImage(newURL, progress.handleLine)
+		progress.finish(err)
+		if err != nil {
+			return fmt.Errorf("failed to update OS to %s with bootc: %w", newURL, err)
+		}
+		return nil
+	}
+
 	newEnough, err := dn.NodeUpdaterClient.IsNewEnoughForLayering()
 	if err != nil {
 		return err
@@ -1948,8 +2237,14 @@ func (dn *Daemon) updateLayeredOSToPullspec(newURL string) error {
 		if err := dn.InplaceUpdateViaNewContainer(newURL); err != nil {
 			return err
 		}
-	} else if err := dn.NodeUpdaterClient.RebaseLayered(newURL); err != nil {
-		return fmt.Errorf("failed to update OS to %s : %w", newURL, err)
+	} else {
+		// Long image pulls are reported in the node status as they progress.
+		progress := newOSUpdateProgressReporter(newURL, dn.reportOSUpdateProgress)
+		err := dn.NodeUpdaterClient.RebaseLayered(newURL, progress.handleLine)
+		progress.finish(err)
+		if err != nil {
+			return fmt.Errorf("failed to update OS to %s : %w", newURL, err)
+		}
 	}
 
 	return nil
@@ -2013,6 +2308,12 @@ func (dn *Daemon) cancelSIGTERM() {
 // cleans up the agent's connections
 // on failure to reboot, it throws an error and waits for the operator to try again
 func (dn *Daemon) reboot(rationale string) error {
+	return dn.rebootWithStrategy(rationale, ctrlcommon.RebootStrategyReboot)
+}
+
+// rebootWithStrategy is reboot with one of the ctrlcommon.RebootStrategy
+// strategies, e.g. to skip the firmware with kexec.
+func (dn *Daemon) rebootWithStrategy(rationale, strategy string) error {
 	// Now that everything is done, avoid delaying shutdown.
 	dn.cancelSIGTERM()
 	dn.Close()
@@ -2025,14 +2326,14 @@ func (dn *Daemon) reboot(rationale string) error {
 	if dn.nodeWriter != nil {
 		dn.nodeWriter.Eventf(corev1.EventTypeNormal, "Reboot", rationale)
 	}
-	logSystem("initiating reboot: %s", rationale)
+	logSystem("initiating reboot (%s): %s", strategy, rationale)
 
 	// reboot, executed async via systemd-run so that the reboot command is executed
 	// in the context of the host asynchronously from us
 	// We're not returning the error from the reboot command as it can be terminated by
 	// the system itself with signal: terminated. We can't catch the subprocess termination signal
 	// either, we just have one for the MCD itself.
-	rebootCmd := rebootCommand(rationale)
+	rebootCmd := rebootCommand(rationale, strategy)
 	if err := rebootCmd.Run(); err != nil {
 		logSystem("failed to run reboot: %v", err)
 		mcdRebootErr.Inc()
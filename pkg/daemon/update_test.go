@@ -17,6 +17,7 @@ import (
 	ign3types "github.com/coreos/ignition/v2/config/v3_4/types"
 	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
 	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	"github.com/openshift/machine-config-operator/pkg/daemon/constants"
 	"github.com/openshift/machine-config-operator/pkg/daemon/osrelease"
 	"github.com/openshift/machine-config-operator/test/helpers"
 	"github.com/stretchr/testify/assert"
@@ -401,6 +402,65 @@ func TestKernelAguments(t *testing.T) {
 	}
 }
 
+func TestExtensionPackageChanges(t *testing.T) {
+	tests := []struct {
+		name      string
+		oldPkgs   []string
+		newPkgs   []string
+		requested []string
+		install   []string
+		uninstall []string
+	}{
+		{
+			name:    "extension added",
+			newPkgs: []string{"usbguard"},
+			install: []string{"usbguard"},
+		},
+		{
+			name:      "extension removed",
+			oldPkgs:   []string{"krb5-workstation", "libkadm5", "usbguard"},
+			newPkgs:   []string{"usbguard"},
+			requested: []string{"krb5-workstation", "libkadm5", "usbguard"},
+			uninstall: []string{"krb5-workstation", "libkadm5"},
+		},
+		{
+			name:      "removed package which is not layered anymore",
+			oldPkgs:   []string{"krb5-workstation", "libkadm5"},
+			requested: []string{"krb5-workstation"},
+			uninstall: []string{"krb5-workstation"},
+		},
+		{
+			name:      "package shared with a remaining extension",
+			oldPkgs:   []string{"kata-containers", "usbguard"},
+			newPkgs:   []string{"usbguard"},
+			requested: []string{"kata-containers", "usbguard"},
+			uninstall: []string{"kata-containers"},
+		},
+		{
+			name:      "added package which is already layered",
+			newPkgs:   []string{"NetworkManager-libreswan", "libreswan"},
+			requested: []string{"libreswan"},
+			install:   []string{"NetworkManager-libreswan"},
+		},
+		{
+			name:      "packages layered by the admin are left alone",
+			oldPkgs:   []string{"usbguard"},
+			requested: []string{"htop", "usbguard"},
+			uninstall: []string{"usbguard"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			install, uninstall := getExtensionPackageChanges(test.oldPkgs, test.newPkgs, test.requested)
+			assert.ElementsMatch(t, test.install, install)
+			assert.ElementsMatch(t, test.uninstall, uninstall)
+		})
+	}
+
+	assert.Equal(t, []string{"update", "--install", "usbguard", "--uninstall", "libkadm5"}, generateExtensionsArgs([]string{"usbguard"}, []string{"libkadm5"}))
+}
+
 func TestReconcilableSSH(t *testing.T) {
 	// Check that updating SSH Key of user core supported
 	oldIgnCfg := ctrlcommon.NewIgnConfig()
@@ -412,7 +472,7 @@ func TestReconcilableSSH(t *testing.T) {
 	_, errMsg := reconcilable(oldMcfg, newMcfg)
 	checkReconcilableResults(t, "SSH", errMsg)
 
-	// 	Check that updating User with User that is not core is not supported
+	// 	Check that updating the SSH keys of a user that is not core is supported
 	tempUser2 := ign3types.PasswdUser{Name: "core", SSHAuthorizedKeys: []ign3types.SSHAuthorizedKey{"1234"}}
 	oldIgnCfg.Passwd.Users = append(oldIgnCfg.Passwd.Users, tempUser2)
 	oldMcfg = helpers.CreateMachineConfigFromIgnition(oldIgnCfg)
@@ -420,6 +480,13 @@ func TestReconcilableSSH(t *testing.T) {
 	newIgnCfg.Passwd.Users[0] = tempUser3
 	newMcfg = helpers.CreateMachineConfigFromIgnition(newIgnCfg)
 	_, errMsg = reconcilable(oldMcfg, newMcfg)
+	checkReconcilableResults(t, "SSH", errMsg)
+
+	// check that we cannot change the password hash of a user that is not core
+	tempUser3.PasswordHash = helpers.StrToPtr("hash")
+	newIgnCfg.Passwd.Users[0] = tempUser3
+	newMcfg = helpers.CreateMachineConfigFromIgnition(newIgnCfg)
+	_, errMsg = reconcilable(oldMcfg, newMcfg)
 	checkIrreconcilableResults(t, "SSH", errMsg)
 
 	// check that we cannot make updates if any other Passwd.User field is changed.
@@ -429,12 +496,12 @@ func TestReconcilableSSH(t *testing.T) {
 	_, errMsg = reconcilable(oldMcfg, newMcfg)
 	checkIrreconcilableResults(t, "SSH", errMsg)
 
-	// check that we cannot add a user or have len(Passwd.Users)> 1
+	// check that we can add the SSH keys of another user
 	tempUser5 := ign3types.PasswdUser{Name: "some user", SSHAuthorizedKeys: []ign3types.SSHAuthorizedKey{"5678"}}
-	newIgnCfg.Passwd.Users = append(newIgnCfg.Passwd.Users, tempUser5)
+	newIgnCfg.Passwd.Users = []ign3types.PasswdUser{tempUser2, tempUser5}
 	newMcfg = helpers.CreateMachineConfigFromIgnition(newIgnCfg)
 	_, errMsg = reconcilable(oldMcfg, newMcfg)
-	checkIrreconcilableResults(t, "SSH", errMsg)
+	checkReconcilableResults(t, "SSH", errMsg)
 
 	// check that user is not attempting to remove the only sshkey from core user
 	tempUser6 := ign3types.PasswdUser{Name: "core", SSHAuthorizedKeys: []ign3types.SSHAuthorizedKey{}}
@@ -543,7 +610,7 @@ func TestUpdateSSHKeys(t *testing.T) {
 	newIgnCfg := ctrlcommon.NewIgnConfig()
 	oldIgnConfig := ctrlcommon.NewIgnConfig()
 	newIgnCfg.Passwd.Users = []ign3types.PasswdUser{tempUser}
-	err := d.updateSSHKeys(newIgnCfg.Passwd.Users, oldIgnConfig.Passwd.Users)
+	err := d.updateSSHKeys(newIgnCfg, oldIgnConfig)
 	if err != nil {
 		t.Errorf("Expected no error. Got %s.", err)
 
@@ -552,7 +619,7 @@ func TestUpdateSSHKeys(t *testing.T) {
 	// if Users is empty, nothing should happen and no error should ever be generated
 	newIgnCfg2 := ctrlcommon.NewIgnConfig()
 	newIgnCfg2.Passwd.Users = []ign3types.PasswdUser{}
-	err = d.updateSSHKeys(newIgnCfg2.Passwd.Users, oldIgnConfig.Passwd.Users)
+	err = d.updateSSHKeys(newIgnCfg2, oldIgnConfig)
 	if err != nil {
 		t.Errorf("Expected no error. Got: %s", err)
 	}
@@ -666,6 +733,14 @@ func TestCalculatePostConfigChangeAction(t *testing.T) {
 		"policy2":         ctrlcommon.NewIgnFile("/etc/containers/policy.json", "policy2"),
 		"containers-gpg1": ctrlcommon.NewIgnFile("/etc/machine-config-daemon/no-reboot/containers-gpg.pub", "containers-gpg1"),
 		"containers-gpg2": ctrlcommon.NewIgnFile("/etc/machine-config-daemon/no-reboot/containers-gpg.pub", "containers-gpg2"),
+		"storage1":        ctrlcommon.NewIgnFile("/etc/containers/storage.conf", "storage content 1\n"),
+		"storage2":        ctrlcommon.NewIgnFile("/etc/containers/storage.conf", "storage content 2\n"),
+		"dns1":            ctrlcommon.NewIgnFile("/etc/NetworkManager/conf.d/99-mco-dns.conf", "dns content 1\n"),
+		"dns2":            ctrlcommon.NewIgnFile("/etc/NetworkManager/conf.d/99-mco-dns.conf", "dns content 2\n"),
+		"chrony1":         ctrlcommon.NewIgnFile("/etc/chrony.conf", "pool 0.example.com iburst\n"),
+		"chrony2":         ctrlcommon.NewIgnFile("/etc/chrony.conf", "pool 1.example.com iburst\n"),
+		"kubeletLog1":     ctrlcommon.NewIgnFile("/etc/systemd/system/kubelet.service.d/20-logging.conf", "[Service]\nEnvironment=\"KUBELET_LOG_LEVEL=2\"\n"),
+		"kubeletLog2":     ctrlcommon.NewIgnFile("/etc/systemd/system/kubelet.service.d/20-logging.conf", "[Service]\nEnvironment=\"KUBELET_LOG_LEVEL=4\"\n"),
 	}
 
 	tests := []struct {
@@ -745,6 +820,66 @@ func TestCalculatePostConfigChangeAction(t *testing.T) {
 			newConfig:      helpers.NewMachineConfig("01-test", nil, "dummy://", []ign3types.File{files["containers-gpg2"]}),
 			expectedAction: []string{postConfigChangeActionReloadCrio},
 		},
+		{
+			// test that updating storage.conf is crio restart
+			oldConfig:      helpers.NewMachineConfig("00-test", nil, "dummy://", []ign3types.File{files["storage1"]}),
+			newConfig:      helpers.NewMachineConfig("01-test", nil, "dummy://", []ign3types.File{files["storage2"]}),
+			expectedAction: []string{postConfigChangeActionRestartCrio},
+		},
+		{
+			// test that a storage.conf change (restart) overwrites registries (reload)
+			oldConfig:      helpers.NewMachineConfig("00-test", nil, "dummy://", []ign3types.File{files["storage1"], files["registries1"]}),
+			newConfig:      helpers.NewMachineConfig("01-test", nil, "dummy://", []ign3types.File{files["storage2"], files["registries2"]}),
+			expectedAction: []string{postConfigChangeActionRestartCrio},
+		},
+		{
+			// test that updating the DNS config is NetworkManager reload
+			oldConfig:      helpers.NewMachineConfig("00-test", nil, "dummy://", []ign3types.File{files["dns1"]}),
+			newConfig:      helpers.NewMachineConfig("01-test", nil, "dummy://", []ign3types.File{files["dns2"]}),
+			expectedAction: []string{postConfigChangeActionReloadNetworkManager},
+		},
+		{
+			// test that removing the DNS config is NetworkManager reload
+			oldConfig:      helpers.NewMachineConfig("00-test", nil, "dummy://", []ign3types.File{files["dns1"]}),
+			newConfig:      helpers.NewMachineConfig("01-test", nil, "dummy://", []ign3types.File{}),
+			expectedAction: []string{postConfigChangeActionReloadNetworkManager},
+		},
+		{
+			// test that a DNS config change is combined with a crio reload
+			oldConfig:      helpers.NewMachineConfig("00-test", nil, "dummy://", []ign3types.File{files["dns1"], files["registries1"]}),
+			newConfig:      helpers.NewMachineConfig("01-test", nil, "dummy://", []ign3types.File{files["dns2"], files["registries2"]}),
+			expectedAction: []string{postConfigChangeActionReloadCrio, postConfigChangeActionReloadNetworkManager},
+		},
+		{
+			// test that updating the chrony config is chronyd restart
+			oldConfig:      helpers.NewMachineConfig("00-test", nil, "dummy://", []ign3types.File{files["chrony1"]}),
+			newConfig:      helpers.NewMachineConfig("01-test", nil, "dummy://", []ign3types.File{files["chrony2"]}),
+			expectedAction: []string{postConfigChangeActionRestartChronyd},
+		},
+		{
+			// test that updating the kubelet log level is kubelet restart
+			oldConfig:      helpers.NewMachineConfig("00-test", nil, "dummy://", []ign3types.File{files["kubeletLog1"]}),
+			newConfig:      helpers.NewMachineConfig("01-test", nil, "dummy://", []ign3types.File{files["kubeletLog2"]}),
+			expectedAction: []string{postConfigChangeActionRestartKubelet},
+		},
+		{
+			// test that service actions are combined with a crio reload in a fixed order, and SSH keys (none) are dropped
+			oldConfig:      helpers.NewMachineConfigExtended("00-test", nil, nil, []ign3types.File{files["kubeletLog1"], files["chrony1"], files["dns1"], files["registries1"]}, []ign3types.Unit{}, []ign3types.SSHAuthorizedKey{"key1"}, []string{}, false, []string{}, "default", "dummy://"),
+			newConfig:      helpers.NewMachineConfigExtended("01-test", nil, nil, []ign3types.File{files["kubeletLog2"], files["chrony2"], files["dns2"], files["registries2"]}, []ign3types.Unit{}, []ign3types.SSHAuthorizedKey{"key2"}, []string{}, false, []string{}, "default", "dummy://"),
+			expectedAction: []string{postConfigChangeActionReloadCrio, postConfigChangeActionReloadNetworkManager, postConfigChangeActionRestartChronyd, postConfigChangeActionRestartKubelet},
+		},
+		{
+			// test that a chrony config change is part of a reboot
+			oldConfig:      helpers.NewMachineConfig("00-test", nil, "dummy://", []ign3types.File{files["chrony1"], files["randomfile1"]}),
+			newConfig:      helpers.NewMachineConfig("01-test", nil, "dummy://", []ign3types.File{files["chrony2"], files["randomfile2"]}),
+			expectedAction: []string{postConfigChangeActionReboot},
+		},
+		{
+			// test that a DNS config change is part of a reboot
+			oldConfig:      helpers.NewMachineConfig("00-test", nil, "dummy://", []ign3types.File{files["dns1"], files["randomfile1"]}),
+			newConfig:      helpers.NewMachineConfig("01-test", nil, "dummy://", []ign3types.File{files["dns2"], files["randomfile2"]}),
+			expectedAction: []string{postConfigChangeActionReboot},
+		},
 	}
 
 	for idx, test := range tests {
@@ -762,7 +897,7 @@ func TestCalculatePostConfigChangeAction(t *testing.T) {
 				t.Errorf("error creating machineConfigDiff: %v", err)
 			}
 			diffFileSet := ctrlcommon.CalculateConfigFileDiffs(&oldIgnConfig, &newIgnConfig)
-			calculatedAction, err := calculatePostConfigChangeAction(mcDiff, diffFileSet)
+			calculatedAction, err := calculatePostConfigChangeAction(mcDiff, diffFileSet, nil)
 
 			if !reflect.DeepEqual(test.expectedAction, calculatedAction) {
 				t.Errorf("Failed calculating config change action: expected: %v but result is: %v. Error: %v", test.expectedAction, calculatedAction, err)
@@ -771,6 +906,70 @@ func TestCalculatePostConfigChangeAction(t *testing.T) {
 	}
 }
 
+func TestCheckContainerStorageConfChanges(t *testing.T) {
+	storageConf := func(extra string) ign3types.File {
+		return ctrlcommon.NewIgnFile(constants.ContainerStorageConfPath, `[storage]
+driver = "overlay"
+graphroot = "/var/lib/containers/storage"
+
+[storage.options]
+`+extra)
+	}
+
+	tests := []struct {
+		name           string
+		oldFiles       []ign3types.File
+		newFiles       []ign3types.File
+		expectedAction []string
+	}{
+		{
+			name:           "pull options",
+			oldFiles:       []ign3types.File{storageConf("")},
+			newFiles:       []ign3types.File{storageConf("pull_options = {enable_partial_images = \"true\"}\n")},
+			expectedAction: []string{postConfigChangeActionRestartCrio},
+		},
+		{
+			name:           "additional image stores",
+			oldFiles:       []ign3types.File{storageConf("additionalimagestores = []\n")},
+			newFiles:       []ign3types.File{storageConf("additionalimagestores = [\"/mnt/shared-images\"]\n")},
+			expectedAction: []string{postConfigChangeActionRestartCrio},
+		},
+		{
+			name:           "image store",
+			oldFiles:       []ign3types.File{storageConf("")},
+			newFiles:       []ign3types.File{storageConf("imagestore = \"/var/lib/images\"\n")},
+			expectedAction: []string{postConfigChangeActionReboot},
+		},
+		{
+			name:           "overlay mount options",
+			oldFiles:       []ign3types.File{storageConf("")},
+			newFiles:       []ign3types.File{storageConf("[storage.options.overlay]\nmountopt = \"nodev\"\n")},
+			expectedAction: []string{postConfigChangeActionReboot},
+		},
+		{
+			name:           "added storage.conf",
+			oldFiles:       []ign3types.File{},
+			newFiles:       []ign3types.File{storageConf("")},
+			expectedAction: []string{postConfigChangeActionReboot},
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			oldIgnConfig := ctrlcommon.NewIgnConfig()
+			oldIgnConfig.Storage.Files = test.oldFiles
+			newIgnConfig := ctrlcommon.NewIgnConfig()
+			newIgnConfig.Storage.Files = test.newFiles
+
+			diffFileSet := ctrlcommon.CalculateConfigFileDiffs(&oldIgnConfig, &newIgnConfig)
+			actions, err := ctrlcommon.CheckContainerStorageConfChanges(ctrlcommon.CalculatePostConfigChangeActions(diffFileSet, nil), diffFileSet, oldIgnConfig, newIgnConfig)
+			require.NoError(t, err)
+			assert.Equal(t, test.expectedAction, actions)
+		})
+	}
+}
+
 // checkReconcilableResults is a shortcut for verifying results that should be reconcilable
 func checkReconcilableResults(t *testing.T, key string, reconcilableError error) {
 	if reconcilableError != nil {
@@ -38,6 +38,7 @@ type response struct {
 type message struct {
 	annos           map[string]string
 	annosToDelete   []string
+	nodeStatus      map[string]string
 	responseChannel chan response
 }
 
@@ -62,6 +63,7 @@ type NodeWriter interface {
 	SetUnreconcilable(err error) error
 	SetDegraded(err error) error
 	SetAnnotations(annos map[string]string) (*corev1.Node, error)
+	SetNodeStatus(status map[string]string) (*corev1.Node, error)
 	SetDesiredDrainer(value string) error
 	Eventf(eventtype, reason, messageFmt string, args ...interface{})
 }
@@ -127,7 +129,7 @@ func (nw *clusterNodeWriter) Run(stop <-chan struct{}) {
 		case <-stop:
 			return
 		case msg := <-nw.writer:
-			r := implSetNodeAnnotations(nw.client, nw.lister, nw.nodeName, msg.annos, msg.annosToDelete)
+			r := implSetNodeAnnotations(nw.client, nw.lister, nw.nodeName, msg.annos, msg.annosToDelete, msg.nodeStatus)
 			msg.responseChannel <- r
 		}
 	}
@@ -254,6 +256,19 @@ func (nw *clusterNodeWriter) SetAnnotations(annos map[string]string) (*corev1.No
 	return resp.node, resp.err
 }
 
+// SetNodeStatus sets the given informational annotation values (e.g. for
+// constants.LastBootTimeAnnotationKey) in the compact
+// constants.NodeStatusAnnotationKey annotation, which supersedes them.
+func (nw *clusterNodeWriter) SetNodeStatus(status map[string]string) (*corev1.Node, error) {
+	respChan := make(chan response, 1)
+	nw.writer <- message{
+		nodeStatus:      status,
+		responseChannel: respChan,
+	}
+	resp := <-respChan
+	return resp.node, resp.err
+}
+
 func (nw *clusterNodeWriter) SetDesiredDrainer(value string) error {
 	annos := map[string]string{
 		constants.DesiredDrainerAnnotationKey: value,
@@ -274,7 +289,8 @@ func (nw *clusterNodeWriter) Eventf(eventtype, reason, messageFmt string, args .
 	nw.recorder.Eventf(getNodeRef(nw.node), eventtype, reason, messageFmt, args...)
 }
 
-func implSetNodeAnnotations(client corev1client.NodeInterface, lister corev1lister.NodeLister, nodeName string, m map[string]string, toDel []string) response {
+func implSetNodeAnnotations(client corev1client.NodeInterface, lister corev1lister.NodeLister, nodeName string, m map[string]string, toDel []string, nodeStatus map[string]string) response {
+	var statusErr error
 	node, err := internal.UpdateNodeRetry(client, lister, nodeName, func(node *corev1.Node) {
 		if toDel != nil {
 			for _, anno := range toDel {
@@ -288,7 +304,16 @@ func implSetNodeAnnotations(client corev1client.NodeInterface, lister corev1list
 		for k, v := range m {
 			node.Annotations[k] = v
 		}
+
+		// The node status is merged into the node here, rather than by each
+		// reporter, so that the reporters do not overwrite each other's values.
+		if nodeStatus != nil {
+			statusErr = ctrlcommon.SetNodeStatus(node, nodeStatus)
+		}
 	})
+	if err == nil {
+		err = statusErr
+	}
 	return response{
 		node: node,
 		err:  err,
@@ -0,0 +1,91 @@
+package operator
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// How often the operator measures the objects it stores in etcd. The
+	// objects only change with new rendered configs, so this can be slow.
+	capacityMetricsInterval = 5 * time.Minute
+
+	machineConfigTypeRendered = "rendered"
+	machineConfigTypeSource   = "source"
+)
+
+// Gets the size of an object as stored in etcd, which stores custom resources
+// as JSON.
+func getObjectSize(obj interface{}) (int, error) {
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return 0, err
+	}
+
+	return len(data), nil
+}
+
+// Gets whether a MachineConfig is rendered from the others by the render
+// controller, as opposed to being a source of rendered MachineConfigs.
+func getMachineConfigType(mc *mcfgv1.MachineConfig) string {
+	if strings.HasPrefix(mc.Name, "rendered-") {
+		return machineConfigTypeRendered
+	}
+
+	return machineConfigTypeSource
+}
+
+// syncCapacityMetrics reports the number and size of the objects the MCO
+// stores, so that admins see when rendered configs approach the etcd request
+// size limit and how many old rendered configs accumulate.
+func (optr *Operator) syncCapacityMetrics() {
+	mcs, err := optr.mcLister.List(labels.Everything())
+	if err != nil {
+		klog.Errorf("Could not list MachineConfigs for capacity metrics: %v", err)
+		return
+	}
+
+	pools, err := optr.mcpLister.List(labels.Everything())
+	if err != nil {
+		klog.Errorf("Could not list pools for capacity metrics: %v", err)
+		return
+	}
+
+	mcSizes := map[string]int{}
+	counts := map[string]int{machineConfigTypeRendered: 0, machineConfigTypeSource: 0}
+	bytes := map[string]int{machineConfigTypeRendered: 0, machineConfigTypeSource: 0}
+
+	for _, mc := range mcs {
+		size, err := getObjectSize(mc)
+		if err != nil {
+			klog.Errorf("Could not measure MachineConfig %s: %v", mc.Name, err)
+			continue
+		}
+
+		mcType := getMachineConfigType(mc)
+		mcSizes[mc.Name] = size
+		counts[mcType]++
+		bytes[mcType] += size
+	}
+
+	for mcType, count := range counts {
+		mcoMachineConfigCount.WithLabelValues(mcType).Set(float64(count))
+		mcoMachineConfigBytes.WithLabelValues(mcType).Set(float64(bytes[mcType]))
+	}
+
+	mcoPoolRenderedConfigBytes.Reset()
+	mcoPoolMachineConfigCount.Reset()
+
+	for _, pool := range pools {
+		mcoPoolMachineConfigCount.WithLabelValues(pool.Name).Set(float64(len(pool.Spec.Configuration.Source)))
+
+		if size, ok := mcSizes[pool.Spec.Configuration.Name]; ok {
+			mcoPoolRenderedConfigBytes.WithLabelValues(pool.Name).Set(float64(size))
+		}
+	}
+}
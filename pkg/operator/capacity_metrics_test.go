@@ -0,0 +1,50 @@
+package operator
+
+import (
+	"testing"
+
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	mcfglistersv1 "github.com/openshift/client-go/machineconfiguration/listers/machineconfiguration/v1"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/openshift/machine-config-operator/test/helpers"
+)
+
+func TestSyncCapacityMetrics(t *testing.T) {
+	mcs := []*mcfgv1.MachineConfig{
+		helpers.NewMachineConfig("00-worker", nil, "", nil),
+		helpers.NewMachineConfig("99-worker-ssh", nil, "", nil),
+		helpers.NewMachineConfig("rendered-worker-1", nil, "", nil),
+		helpers.NewMachineConfig("rendered-worker-2", nil, "", nil),
+	}
+
+	mcIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	sizes := map[string]int{}
+	for _, mc := range mcs {
+		require.NoError(t, mcIndexer.Add(mc))
+		size, err := getObjectSize(mc)
+		require.NoError(t, err)
+		sizes[mc.Name] = size
+	}
+
+	pool := helpers.NewMachineConfigPool("worker", nil, helpers.WorkerSelector, "rendered-worker-2")
+	pool.Spec.Configuration.Source = []corev1.ObjectReference{{Name: "00-worker"}, {Name: "99-worker-ssh"}}
+
+	optr := &Operator{
+		mcLister:  mcfglistersv1.NewMachineConfigLister(mcIndexer),
+		mcpLister: &mockMCPLister{pools: []*mcfgv1.MachineConfigPool{pool}},
+	}
+
+	optr.syncCapacityMetrics()
+
+	assert.Equal(t, float64(2), testutil.ToFloat64(mcoMachineConfigCount.WithLabelValues(machineConfigTypeRendered)))
+	assert.Equal(t, float64(2), testutil.ToFloat64(mcoMachineConfigCount.WithLabelValues(machineConfigTypeSource)))
+	assert.Equal(t, float64(sizes["rendered-worker-1"]+sizes["rendered-worker-2"]), testutil.ToFloat64(mcoMachineConfigBytes.WithLabelValues(machineConfigTypeRendered)))
+	assert.Equal(t, float64(sizes["00-worker"]+sizes["99-worker-ssh"]), testutil.ToFloat64(mcoMachineConfigBytes.WithLabelValues(machineConfigTypeSource)))
+	assert.Equal(t, float64(sizes["rendered-worker-2"]), testutil.ToFloat64(mcoPoolRenderedConfigBytes.WithLabelValues("worker")))
+	assert.Equal(t, float64(2), testutil.ToFloat64(mcoPoolMachineConfigCount.WithLabelValues("worker")))
+}
@@ -0,0 +1,246 @@
+package operator
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	"github.com/vincent-petithory/dataurl"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/klog/v2"
+
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	daemonconsts "github.com/openshift/machine-config-operator/pkg/daemon/constants"
+	"github.com/openshift/machine-config-operator/pkg/server"
+)
+
+const (
+	// How often the operator fetches Ignition from the MachineConfigServer
+	// the way a new machine would.
+	mcsProbeInterval = 10 * time.Minute
+	mcsProbeTimeout  = 30 * time.Second
+
+	// The Accept header sent by the Ignition versions which current boot
+	// images ship.
+	mcsProbeAcceptHeader = "application/vnd.coreos.ignition+json;version=3.2.0, */*;q=0.1"
+
+	// The endpoint label value for requests which go through the load balancer
+	// in the pointer Ignition config.
+	mcsProbeLoadBalancerEndpoint = "load-balancer"
+)
+
+// Gets the source URL and the trusted root CAs from the pointer Ignition config
+// in the user-data Secret that new machines of a pool boot with.
+func parsePointerIgnition(userData []byte) (string, *x509.CertPool, error) {
+	pointer, err := ctrlcommon.ParseAndConvertConfig(userData)
+	if err != nil {
+		return "", nil, fmt.Errorf("could not parse pointer Ignition config: %w", err)
+	}
+
+	if len(pointer.Ignition.Config.Merge) == 0 || pointer.Ignition.Config.Merge[0].Source == nil {
+		return "", nil, fmt.Errorf("pointer Ignition config has no config source")
+	}
+
+	roots := x509.NewCertPool()
+	for _, ca := range pointer.Ignition.Security.TLS.CertificateAuthorities {
+		if ca.Source == nil {
+			continue
+		}
+
+		decoded, err := dataurl.DecodeString(*ca.Source)
+		if err != nil {
+			return "", nil, fmt.Errorf("could not decode certificate authority: %w", err)
+		}
+
+		if !roots.AppendCertsFromPEM(decoded.Data) {
+			return "", nil, fmt.Errorf("pointer Ignition config has no valid certificate authority")
+		}
+	}
+
+	return *pointer.Ignition.Config.Merge[0].Source, roots, nil
+}
+
+// Gets the rendered MachineConfig which the MachineConfigServer should serve
+// for the given pool. This mirrors the MachineConfigServer, which serves the
+// desired config once at least one node has updated to it.
+func getExpectedServedConfig(pool *mcfgv1.MachineConfigPool) string {
+	if pool.Status.UpdatedMachineCount > 0 {
+		return pool.Spec.Configuration.Name
+	}
+
+	return pool.Status.Configuration.Name
+}
+
+// Fetches Ignition from the given source URL, trusting only the given root
+// CAs as a new machine would. If dialAddr is set, the request goes to that
+// address instead of the host of the URL, so that each MachineConfigServer
+// behind the load balancer can be checked individually. Returns when the
+// serving certificate expires, the size of the served Ignition config and an
+// error if the served config is not the expected rendered MachineConfig.
+func probeIgnition(source string, roots *x509.CertPool, dialAddr, expectedConfig string) (time.Time, int, error) {
+	u, err := url.Parse(source)
+	if err != nil {
+		return time.Time{}, 0, fmt.Errorf("could not parse config source %q: %w", source, err)
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.TLSClientConfig = &tls.Config{
+		RootCAs:    roots,
+		ServerName: u.Hostname(),
+	}
+	if dialAddr != "" {
+		dialer := &net.Dialer{Timeout: mcsProbeTimeout}
+		transport.DialContext = func(ctx context.Context, network, _ string) (net.Conn, error) {
+			return dialer.DialContext(ctx, network, dialAddr)
+		}
+	}
+	defer transport.CloseIdleConnections()
+
+	client := &http.Client{Transport: transport, Timeout: mcsProbeTimeout}
+
+	req, err := http.NewRequest(http.MethodGet, source, nil)
+	if err != nil {
+		return time.Time{}, 0, err
+	}
+	req.Header.Set("Accept", mcsProbeAcceptHeader)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return time.Time{}, 0, fmt.Errorf("could not fetch Ignition: %w", err)
+	}
+	defer resp.Body.Close()
+
+	notAfter := time.Time{}
+	if resp.TLS != nil && len(resp.TLS.PeerCertificates) != 0 {
+		notAfter = resp.TLS.PeerCertificates[0].NotAfter
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return notAfter, 0, fmt.Errorf("could not fetch Ignition: %s", resp.Status)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return notAfter, 0, fmt.Errorf("could not read Ignition: %w", err)
+	}
+
+	servedConfig, err := getServedConfig(body)
+	if err != nil {
+		return notAfter, len(body), err
+	}
+
+	if servedConfig != expectedConfig {
+		return notAfter, len(body), fmt.Errorf("served rendered config %q, expected %q", servedConfig, expectedConfig)
+	}
+
+	return notAfter, len(body), nil
+}
+
+// Gets the name of the rendered MachineConfig from the initial node
+// annotations which the MachineConfigServer embeds in the served Ignition.
+func getServedConfig(rawIgn []byte) (string, error) {
+	ignConfig, err := ctrlcommon.ParseAndConvertConfig(rawIgn)
+	if err != nil {
+		return "", fmt.Errorf("could not parse served Ignition: %w", err)
+	}
+
+	contents, err := ctrlcommon.GetIgnitionFileDataByPath(&ignConfig, daemonconsts.InitialNodeAnnotationsFilePath)
+	if err != nil {
+		return "", fmt.Errorf("could not decode %s: %w", daemonconsts.InitialNodeAnnotationsFilePath, err)
+	}
+
+	if contents == nil {
+		return "", fmt.Errorf("served Ignition does not contain %s", daemonconsts.InitialNodeAnnotationsFilePath)
+	}
+
+	annotations := map[string]string{}
+	if err := json.Unmarshal(contents, &annotations); err != nil {
+		return "", fmt.Errorf("could not parse %s: %w", daemonconsts.InitialNodeAnnotationsFilePath, err)
+	}
+
+	return annotations[daemonconsts.CurrentMachineConfigAnnotationKey], nil
+}
+
+// probeMachineConfigServer fetches Ignition for every pool with a user-data
+// Secret, both through the load balancer and from every MachineConfigServer
+// pod, and reports whether new machines would be able to boot in metrics.
+func (optr *Operator) probeMachineConfigServer() {
+	pools, err := optr.mcpLister.List(labels.Everything())
+	if err != nil {
+		klog.Errorf("Could not list pools for the MachineConfigServer probe: %v", err)
+		return
+	}
+
+	// The MachineConfigServer pods run in the host network.
+	dialAddrs := map[string]string{}
+	pods, err := optr.kubeClient.CoreV1().Pods(ctrlcommon.MCONamespace).List(context.TODO(), metav1.ListOptions{LabelSelector: "k8s-app=machine-config-server"})
+	if err != nil {
+		klog.Errorf("Could not list MachineConfigServer pods: %v", err)
+	} else {
+		for _, pod := range pods.Items {
+			if pod.Status.HostIP != "" {
+				dialAddrs[pod.Spec.NodeName] = net.JoinHostPort(pod.Status.HostIP, strconv.Itoa(server.SecurePort))
+			}
+		}
+	}
+
+	mcoMCSProbeSuccess.Reset()
+	mcoMCSCertificateExpiry.Reset()
+	mcoMCSIgnitionPayloadBytes.Reset()
+
+	for _, pool := range pools {
+		secret, err := optr.maoSecretLister.Secrets("openshift-machine-api").Get(fmt.Sprintf("%s-user-data-managed", pool.Name))
+		if apierrors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			klog.Errorf("Could not get user-data secret for pool %s: %v", pool.Name, err)
+			continue
+		}
+
+		source, roots, err := parsePointerIgnition(secret.Data["userData"])
+		if err != nil {
+			klog.Errorf("Could not probe MachineConfigServer for pool %s: %v", pool.Name, err)
+			mcoMCSProbeSuccess.WithLabelValues(pool.Name, mcsProbeLoadBalancerEndpoint).Set(0)
+			continue
+		}
+
+		expectedConfig := getExpectedServedConfig(pool)
+
+		endpoints := map[string]string{mcsProbeLoadBalancerEndpoint: ""}
+		for node, addr := range dialAddrs {
+			endpoints[node] = addr
+		}
+
+		for endpoint, dialAddr := range endpoints {
+			notAfter, payloadSize, err := probeIgnition(source, roots, dialAddr, expectedConfig)
+			if !notAfter.IsZero() {
+				mcoMCSCertificateExpiry.WithLabelValues(endpoint).Set(float64(notAfter.Unix()))
+			}
+			if endpoint == mcsProbeLoadBalancerEndpoint && payloadSize != 0 {
+				mcoMCSIgnitionPayloadBytes.WithLabelValues(pool.Name).Set(float64(payloadSize))
+			}
+
+			if err != nil {
+				klog.Warningf("MachineConfigServer probe for pool %s through %s failed: %v", pool.Name, endpoint, err)
+				mcoMCSProbeSuccess.WithLabelValues(pool.Name, endpoint).Set(0)
+				continue
+			}
+
+			klog.V(4).Infof("MachineConfigServer probe for pool %s through %s succeeded", pool.Name, endpoint)
+			mcoMCSProbeSuccess.WithLabelValues(pool.Name, endpoint).Set(1)
+		}
+	}
+}
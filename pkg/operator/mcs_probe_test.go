@@ -0,0 +1,121 @@
+package operator
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	ign3types "github.com/coreos/ignition/v2/config/v3_4/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vincent-petithory/dataurl"
+
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	daemonconsts "github.com/openshift/machine-config-operator/pkg/daemon/constants"
+	"github.com/openshift/machine-config-operator/test/helpers"
+)
+
+// newMCSProbeServer serves Ignition with the initial node annotations of the
+// given rendered config, like the MachineConfigServer does.
+func newMCSProbeServer(t *testing.T, renderedConfig string) *httptest.Server {
+	t.Helper()
+
+	annotations, err := json.Marshal(map[string]string{daemonconsts.CurrentMachineConfigAnnotationKey: renderedConfig})
+	require.NoError(t, err)
+
+	ignConfig := ctrlcommon.NewIgnConfig()
+	ignConfig.Storage.Files = append(ignConfig.Storage.Files, helpers.CreateIgn3File(daemonconsts.InitialNodeAnnotationsFilePath, "data:,"+dataurl.EscapeString(string(annotations)), 420))
+	rawIgn, err := json.Marshal(ignConfig)
+	require.NoError(t, err)
+
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/config/worker" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write(rawIgn)
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+// newPointerIgnition creates the pointer Ignition config of the user-data
+// Secret for the given config source, trusting the CA of the given server.
+func newPointerIgnition(t *testing.T, srv *httptest.Server, source string) []byte {
+	t.Helper()
+
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	u, err := url.Parse(source)
+	require.NoError(t, err)
+
+	pointer, err := ctrlcommon.PointerConfig(u.Host, caPEM)
+	require.NoError(t, err)
+	pointer.Ignition.Config.Merge = []ign3types.Resource{{Source: &source}}
+
+	userData, err := json.Marshal(pointer)
+	require.NoError(t, err)
+
+	return userData
+}
+
+func TestProbeIgnition(t *testing.T) {
+	srv := newMCSProbeServer(t, "rendered-worker-1")
+	addr := srv.Listener.Addr().String()
+
+	// The test certificate is also valid for example.com, which stands in
+	// for the load balancer name.
+	_, port, err := net.SplitHostPort(addr)
+	require.NoError(t, err)
+	source := "https://example.com:" + port + "/config/worker"
+
+	userData := newPointerIgnition(t, srv, source)
+	parsedSource, roots, err := parsePointerIgnition(userData)
+	require.NoError(t, err)
+	assert.Equal(t, source, parsedSource)
+
+	t.Run("Fresh config", func(t *testing.T) {
+		notAfter, payloadSize, err := probeIgnition(parsedSource, roots, addr, "rendered-worker-1")
+		assert.NoError(t, err)
+		assert.Equal(t, srv.Certificate().NotAfter, notAfter)
+		assert.NotZero(t, payloadSize)
+	})
+
+	t.Run("Stale config", func(t *testing.T) {
+		_, _, err := probeIgnition(parsedSource, roots, addr, "rendered-worker-2")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), `served rendered config "rendered-worker-1", expected "rendered-worker-2"`)
+	})
+
+	t.Run("Unknown pool", func(t *testing.T) {
+		_, _, err := probeIgnition("https://example.com:"+port+"/config/infra", roots, addr, "rendered-infra-1")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "404")
+	})
+
+	t.Run("Untrusted certificate", func(t *testing.T) {
+		_, _, err := probeIgnition(parsedSource, x509.NewCertPool(), addr, "rendered-worker-1")
+		assert.Error(t, err)
+	})
+
+	t.Run("Certificate not valid for the host", func(t *testing.T) {
+		_, _, err := probeIgnition("https://api-int.example.org:"+port+"/config/worker", roots, addr, "rendered-worker-1")
+		assert.Error(t, err)
+	})
+}
+
+func TestGetExpectedServedConfig(t *testing.T) {
+	pool := helpers.NewMachineConfigPool("worker", nil, helpers.WorkerSelector, "rendered-worker-2")
+	pool.Status.Configuration.Name = "rendered-worker-1"
+
+	// Until a node updated, new nodes get the current config.
+	assert.Equal(t, "rendered-worker-1", getExpectedServedConfig(pool))
+
+	pool.Status.UpdatedMachineCount = 1
+	assert.Equal(t, "rendered-worker-2", getExpectedServedConfig(pool))
+}
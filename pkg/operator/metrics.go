@@ -43,8 +43,58 @@ var (
 			Name: "mco_unavailable_machine_count",
 			Help: "total number of unavailable machines in specified pool",
 		}, []string{"pool"})
+	// mcoMCSProbeSuccess is whether a new machine of the pool would be able to
+	// fetch its Ignition config from the MachineConfigServer endpoint
+	mcoMCSProbeSuccess = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "mco_mcs_ignition_probe_success",
+			Help: "whether Ignition for a specified pool could be fetched from a MachineConfigServer endpoint",
+		}, []string{"pool", "endpoint"})
+	// mcoMCSCertificateExpiry is when the serving certificate of the
+	// MachineConfigServer endpoint expires
+	mcoMCSCertificateExpiry = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "mco_mcs_certificate_expiry_timestamp_seconds",
+			Help: "expiry of the serving certificate of a MachineConfigServer endpoint in seconds since the epoch",
+		}, []string{"endpoint"})
+	// mcoMCSIgnitionPayloadBytes is the size of the Ignition config which the
+	// MachineConfigServer serves to new machines of the pool
+	mcoMCSIgnitionPayloadBytes = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "mco_mcs_ignition_payload_bytes",
+			Help: "size of the Ignition config served by the MachineConfigServer for a specified pool",
+		}, []string{"pool"})
+	// mcoPoolRenderedConfigBytes is the size of the rendered MachineConfig of
+	// the pool, which has to stay below the etcd request size limit
+	mcoPoolRenderedConfigBytes = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "mco_pool_rendered_config_bytes",
+			Help: "size of the desired rendered MachineConfig of a specified pool",
+		}, []string{"pool"})
+	// mcoPoolMachineConfigCount is the number of MachineConfigs rendered into
+	// the pool's config
+	mcoPoolMachineConfigCount = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "mco_pool_machineconfig_count",
+			Help: "number of MachineConfigs rendered into the desired config of a specified pool",
+		}, []string{"pool"})
+	// mcoMachineConfigCount is the number of MachineConfigs stored, either
+	// rendered or source
+	mcoMachineConfigCount = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "mco_machineconfig_count",
+			Help: "number of rendered and source MachineConfigs",
+		}, []string{"type"})
+	// mcoMachineConfigBytes is the total size of the MachineConfigs stored,
+	// either rendered or source
+	mcoMachineConfigBytes = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "mco_machineconfig_bytes",
+			Help: "total size of rendered and source MachineConfigs",
+		}, []string{"type"})
 )
 
 func RegisterMCOMetrics() error {
-	return ctrlcommon.RegisterMetrics([]prometheus.Collector{mcoState, mcoMachineCount, mcoUpdatedMachineCount, mcoDegradedMachineCount, mcoUnavailableMachineCount})
+	return ctrlcommon.RegisterMetrics([]prometheus.Collector{mcoState, mcoMachineCount, mcoUpdatedMachineCount, mcoDegradedMachineCount, mcoUnavailableMachineCount, mcoMCSProbeSuccess, mcoMCSCertificateExpiry,
+		mcoMCSIgnitionPayloadBytes, mcoPoolRenderedConfigBytes, mcoPoolMachineConfigCount, mcoMachineConfigCount, mcoMachineConfigBytes})
 }
@@ -89,6 +89,7 @@ type Operator struct {
 	dnsLister        configlistersv1.DNSLister
 	mcoSALister      corelisterv1.ServiceAccountLister
 	mcoSecretLister  corelisterv1.SecretLister
+	maoSecretLister  corelisterv1.SecretLister
 	ocSecretLister   corelisterv1.SecretLister
 	mcoCOLister      configlistersv1.ClusterOperatorLister
 
@@ -224,6 +225,7 @@ func New(
 	optr.nodeListerSynced = nodeInformer.Informer().HasSynced
 
 	optr.imgListerSynced = imgInformer.Informer().HasSynced
+	optr.maoSecretLister = maoSecretInformer.Lister()
 	optr.maoSecretInformerSynced = maoSecretInformer.Informer().HasSynced
 	optr.serviceAccountInformerSynced = serviceAccountInfomer.Informer().HasSynced
 	optr.clusterRoleInformerSynced = clusterRoleInformer.Informer().HasSynced
@@ -318,6 +320,9 @@ func (optr *Operator) Run(workers int, stopCh <-chan struct{}) {
 		go wait.Until(optr.worker, time.Second, stopCh)
 	}
 
+	go wait.Until(optr.probeMachineConfigServer, mcsProbeInterval, stopCh)
+	go wait.Until(optr.syncCapacityMetrics, capacityMetricsInterval, stopCh)
+
 	<-stopCh
 }
 
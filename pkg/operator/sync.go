@@ -41,6 +41,7 @@ import (
 	mcoResourceRead "github.com/openshift/machine-config-operator/lib/resourceread"
 	"github.com/openshift/machine-config-operator/manifests"
 	"github.com/openshift/machine-config-operator/pkg/apihelpers"
+	"github.com/openshift/machine-config-operator/pkg/apihelpers/conditions"
 	"github.com/openshift/machine-config-operator/pkg/controller/build"
 	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
 	templatectrl "github.com/openshift/machine-config-operator/pkg/controller/template"
@@ -85,6 +86,8 @@ const (
 	mccKubeRbacProxyConfigMapPath             = "manifests/machineconfigcontroller/kube-rbac-proxy-config.yaml"
 	mccKubeRbacProxyPrometheusRolePath        = "manifests/machineconfigcontroller/prometheus-rbac.yaml"
 	mccKubeRbacProxyPrometheusRoleBindingPath = "manifests/machineconfigcontroller/prometheus-rolebinding-target.yaml"
+	mccDeletionWebhookManifestPath            = "manifests/machineconfigcontroller/machineconfig-deletion-webhook.yaml"
+	mccCustomDockerfileWebhookManifestPath    = "manifests/machineconfigcontroller/custom-dockerfile-webhook.yaml"
 
 	// Machine OS Builder manifest paths
 	mobClusterRoleManifestPath                      = "manifests/machineosbuilder/clusterrole.yaml"
@@ -890,6 +893,20 @@ func (optr *Operator) syncMachineConfigController(config *renderConfig) error {
 			return err
 		}
 	}
+
+	// The webhooks are served by the controller, so only register them once
+	// the controller is running.
+	for _, path := range []string{mccDeletionWebhookManifestPath, mccCustomDockerfileWebhookManifestPath} {
+		webhookBytes, err := renderAsset(config, path)
+		if err != nil {
+			return err
+		}
+		webhook := resourceread.ReadValidatingWebhookConfigurationV1OrDie(webhookBytes)
+		if _, _, err := resourceapply.ApplyValidatingWebhookConfigurationImproved(context.TODO(), optr.kubeClient.AdmissionregistrationV1(), optr.libgoRecorder, webhook, resourceapply.NewResourceCache()); err != nil {
+			return fmt.Errorf("failed to apply webhook %s: %w", webhook.Name, err)
+		}
+	}
+
 	return optr.syncControllerConfig(config)
 }
 
@@ -1531,12 +1548,7 @@ func mergeCertWithCABundle(initialBundle, newBundle []byte, subject string) []by
 }
 
 func isPoolStatusConditionTrue(pool *mcfgv1.MachineConfigPool, conditionType mcfgv1.MachineConfigPoolConditionType) bool {
-	for _, condition := range pool.Status.Conditions {
-		if condition.Type == conditionType {
-			return condition.Status == corev1.ConditionTrue
-		}
-	}
-	return false
+	return conditions.MachineConfigPool.IsTrue(pool.Status.Conditions, conditionType)
 }
 
 // getImageRegistryPullSecrets fetches the image registry's pull secrets and merges them with the
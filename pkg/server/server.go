@@ -167,6 +167,7 @@ func getNodeAnnotation(conf string) (string, error) {
 		daemonconsts.CurrentMachineConfigAnnotationKey:     conf,
 		daemonconsts.DesiredMachineConfigAnnotationKey:     conf,
 		daemonconsts.MachineConfigDaemonStateAnnotationKey: daemonconsts.MachineConfigDaemonStateDone,
+		daemonconsts.InitialMachineConfigAnnotationKey:     conf,
 	}
 	contents, err := json.Marshal(nodeAnnotations)
 	if err != nil {
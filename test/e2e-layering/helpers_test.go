@@ -2,13 +2,18 @@ package e2e_layering
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"io"
+	"net/http"
 	"strings"
 	"testing"
 	"time"
 
 	imagev1 "github.com/openshift/api/image/v1"
 	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	"github.com/openshift/machine-config-operator/pkg/controller/build"
+	"github.com/openshift/machine-config-operator/pkg/controller/build/clients"
 	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
 	"github.com/openshift/machine-config-operator/test/framework"
 	"github.com/openshift/machine-config-operator/test/helpers"
@@ -66,27 +71,38 @@ func createImagestream(t *testing.T, cs *framework.ClientSet, name string) func(
 	})
 }
 
+// Constructs a build client from the e2e framework ClientSet.
+func newBuildClient(cs *framework.ClientSet) *clients.BuildClient {
+	return clients.NewBuildClient(cs.CoreV1Interface, cs.MachineconfigurationV1Interface)
+}
+
+// Creates the on-cluster-build-config ConfigMap and registers a cleanup function.
+func configureOnClusterBuilds(t *testing.T, cs *framework.ClientSet, cfg clients.OnClusterBuildConfig) func() {
+	require.NoError(t, newBuildClient(cs).ConfigureOnClusterBuilds(context.TODO(), cfg))
+
+	t.Logf("Created ConfigMap %q", build.OnClusterBuildConfigMapName)
+
+	return deleteConfigMapOnCleanup(t, cs, build.OnClusterBuildConfigMapName)
+}
+
 // Creates the on-cluster-build-custom-dockerfile ConfigMap and registers a cleanup function.
 func createCustomDockerfileConfigMap(t *testing.T, cs *framework.ClientSet, customDockerfiles map[string]string) func() {
-	return createConfigMap(t, cs, &corev1.ConfigMap{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      "on-cluster-build-custom-dockerfile",
-			Namespace: ctrlcommon.MCONamespace,
-		},
-		Data: customDockerfiles,
-	})
-}
+	buildClient := newBuildClient(cs)
 
-// Creates a given ConfigMap and registers a cleanup function to delete it.
-func createConfigMap(t *testing.T, cs *framework.ClientSet, cm *corev1.ConfigMap) func() {
-	_, err := cs.CoreV1Interface.ConfigMaps(ctrlcommon.MCONamespace).Create(context.TODO(), cm, metav1.CreateOptions{})
-	require.NoError(t, err)
+	for poolName, dockerfile := range customDockerfiles {
+		require.NoError(t, buildClient.SetCustomDockerfile(context.TODO(), poolName, dockerfile))
+	}
 
-	t.Logf("Created ConfigMap %q", cm.Name)
+	t.Logf("Created ConfigMap %q", build.CustomDockerfileConfigMapName)
 
+	return deleteConfigMapOnCleanup(t, cs, build.CustomDockerfileConfigMapName)
+}
+
+// Registers a cleanup function to delete the given ConfigMap.
+func deleteConfigMapOnCleanup(t *testing.T, cs *framework.ClientSet, name string) func() {
 	return makeIdempotentAndRegister(t, func() {
-		require.NoError(t, cs.CoreV1Interface.ConfigMaps(ctrlcommon.MCONamespace).Delete(context.TODO(), cm.Name, metav1.DeleteOptions{}))
-		klog.Infof("Deleted ConfigMap %q", cm.Name)
+		require.NoError(t, cs.CoreV1Interface.ConfigMaps(ctrlcommon.MCONamespace).Delete(context.TODO(), name, metav1.DeleteOptions{}))
+		klog.Infof("Deleted ConfigMap %q", name)
 	})
 }
 
@@ -103,6 +119,84 @@ func createSecret(t *testing.T, cs *framework.ClientSet, secret *corev1.Secret)
 	})
 }
 
+// The CentOS Stream 9 and EPEL 9 repositories, which the build controller
+// installs into the image so that custom Dockerfiles can install packages such
+// as cowsay from them.
+var rpmRepositories = map[string]string{
+	"centos.repo": `[baseos]
+name=CentOS Stream 9 - BaseOS
+metalink=https://mirrors.centos.org/metalink?repo=centos-baseos-9-stream&arch=$basearch&protocol=https,http
+gpgkey=file:///etc/pki/rpm-gpg/RPM-GPG-KEY-centosofficial
+gpgcheck=1
+enabled=1
+
+[appstream]
+name=CentOS Stream 9 - AppStream
+metalink=https://mirrors.centos.org/metalink?repo=centos-appstream-9-stream&arch=$basearch&protocol=https,http
+gpgkey=file:///etc/pki/rpm-gpg/RPM-GPG-KEY-centosofficial
+gpgcheck=1
+enabled=1
+`,
+	"epel.repo": `[epel]
+name=Extra Packages for Enterprise Linux 9 - $basearch
+metalink=https://mirrors.fedoraproject.org/metalink?repo=epel-9&arch=$basearch
+gpgkey=file:///etc/pki/rpm-gpg/RPM-GPG-KEY-EPEL-9
+gpgcheck=1
+enabled=1
+`,
+}
+
+// Where the GPG keys of the above repositories are downloaded from, keyed by
+// the filename the repositories expect.
+var rpmGPGKeyURLs = map[string]string{
+	"RPM-GPG-KEY-centosofficial": "https://www.centos.org/keys/RPM-GPG-KEY-CentOS-Official",
+	"RPM-GPG-KEY-EPEL-9":         "https://dl.fedoraproject.org/pub/epel/RPM-GPG-KEY-EPEL-9",
+}
+
+// Creates the ConfigMap of RPM repositories and the Secret of their GPG keys
+// for the build controller to install into the image and registers a cleanup
+// function to delete them.
+func createRPMRepositories(t *testing.T, cs *framework.ClientSet, configMapName, secretName string) func() {
+	keys := map[string][]byte{}
+	for name, url := range rpmGPGKeyURLs {
+		resp, err := http.Get(url)
+		require.NoError(t, err)
+
+		key, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode, "could not download GPG key %s", url)
+
+		keys[name] = key
+	}
+
+	deleteSecret := createSecret(t, cs, &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      secretName,
+			Namespace: ctrlcommon.MCONamespace,
+		},
+		Data: keys,
+	})
+
+	_, err := cs.CoreV1Interface.ConfigMaps(ctrlcommon.MCONamespace).Create(context.TODO(), &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      configMapName,
+			Namespace: ctrlcommon.MCONamespace,
+		},
+		Data: rpmRepositories,
+	}, metav1.CreateOptions{})
+	require.NoError(t, err)
+
+	t.Logf("Created ConfigMap %q", configMapName)
+
+	deleteConfigMap := deleteConfigMapOnCleanup(t, cs, configMapName)
+
+	return makeIdempotentAndRegister(t, func() {
+		deleteConfigMap()
+		deleteSecret()
+	})
+}
+
 // Copies the global pull secret from openshift-config/pull-secret into the MCO
 // namespace so that it can be used by the build processes.
 func copyGlobalPullSecret(t *testing.T, cs *framework.ClientSet) func() {
@@ -127,6 +221,121 @@ func copyGlobalPullSecret(t *testing.T, cs *framework.ClientSet) func() {
 	})
 }
 
+// Creates a pull secret in the MCO namespace which merges the global pull
+// secret with the credentials that the builder service account uses for the
+// internal image registry, so that base images can be pulled both from their
+// source registries and from ImageStreams acting as their mirrors.
+func createMirrorPullSecret(t *testing.T, cs *framework.ClientSet, name string) func() {
+	globalPullSecret, err := cs.CoreV1Interface.Secrets("openshift-config").Get(context.TODO(), "pull-secret", metav1.GetOptions{})
+	require.NoError(t, err)
+
+	builderSecretName, err := getBuilderPushSecretName(cs)
+	require.NoError(t, err)
+
+	builderSecret, err := cs.CoreV1Interface.Secrets(ctrlcommon.MCONamespace).Get(context.TODO(), builderSecretName, metav1.GetOptions{})
+	require.NoError(t, err)
+
+	merged := struct {
+		Auths map[string]json.RawMessage `json:"auths"`
+	}{}
+	require.NoError(t, json.Unmarshal(globalPullSecret.Data[corev1.DockerConfigJsonKey], &merged))
+
+	// The builder secret is in the legacy format, which lacks the auths key.
+	builderAuths := map[string]json.RawMessage{}
+	require.NoError(t, json.Unmarshal(builderSecret.Data[corev1.DockerConfigKey], &builderAuths))
+
+	for registry, auth := range builderAuths {
+		merged.Auths[registry] = auth
+	}
+
+	out, err := json.Marshal(merged)
+	require.NoError(t, err)
+
+	return createSecret(t, cs, &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: ctrlcommon.MCONamespace,
+		},
+		Data: map[string][]byte{
+			corev1.DockerConfigJsonKey: out,
+		},
+		Type: corev1.SecretTypeDockerConfigJson,
+	})
+}
+
+// Creates an ImageStream in the MCO namespace which imports the given image by
+// digest and serves it from the internal image registry, then waits for the
+// import to complete. Returns the repository of the ImageStream, which may be
+// used as a mirror for the repository of the given image, and registers a
+// cleanup function.
+func createImagestreamMirror(t *testing.T, cs *framework.ClientSet, name, pullspec string) (string, func()) {
+	is := &imagev1.ImageStream{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: ctrlcommon.MCONamespace,
+		},
+		Spec: imagev1.ImageStreamSpec{
+			Tags: []imagev1.TagReference{
+				{
+					Name: "latest",
+					From: &corev1.ObjectReference{
+						Kind: "DockerImage",
+						Name: pullspec,
+					},
+					ReferencePolicy: imagev1.TagReferencePolicy{
+						Type: imagev1.LocalTagReferencePolicy,
+					},
+				},
+			},
+		},
+	}
+
+	_, err := cs.ImageV1Interface.ImageStreams(ctrlcommon.MCONamespace).Create(context.TODO(), is, metav1.CreateOptions{})
+	require.NoError(t, err)
+
+	t.Logf("Created ImageStream %q to mirror image %s", name, pullspec)
+
+	cleanup := makeIdempotentAndRegister(t, func() {
+		require.NoError(t, cs.ImageV1Interface.ImageStreams(ctrlcommon.MCONamespace).Delete(context.TODO(), name, metav1.DeleteOptions{}))
+		t.Logf("Deleted ImageStream %q", name)
+	})
+
+	var repository string
+
+	err = wait.PollImmediate(2*time.Second, 5*time.Minute, func() (bool, error) {
+		is, err := cs.ImageV1Interface.ImageStreams(ctrlcommon.MCONamespace).Get(context.TODO(), name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+
+		for _, tag := range is.Status.Tags {
+			if tag.Tag == "latest" && len(tag.Items) != 0 {
+				repository = is.Status.DockerImageRepository
+				return repository != "", nil
+			}
+		}
+
+		return false, nil
+	})
+
+	require.NoError(t, err, "ImageStream %q did not import image %s", name, pullspec)
+
+	t.Logf("ImageStream %q has imported image %s", name, pullspec)
+
+	return repository, cleanup
+}
+
+// Gets the base OS image pullspec from the machine-config-osimageurl ConfigMap.
+func getBaseOSImagePullspec(t *testing.T, cs *framework.ClientSet) string {
+	cm, err := cs.CoreV1Interface.ConfigMaps(ctrlcommon.MCONamespace).Get(context.TODO(), "machine-config-osimageurl", metav1.GetOptions{})
+	require.NoError(t, err)
+
+	pullspec := cm.Data["baseOSContainerImage"]
+	require.NotEmpty(t, pullspec, "no base OS image in ConfigMap %q", cm.Name)
+
+	return pullspec
+}
+
 // Waits for the target MachineConfigPool to reach a state defined in a supplied function.
 func waitForPoolToReachState(t *testing.T, cs *framework.ClientSet, poolName string, condFunc func(*mcfgv1.MachineConfigPool) bool) {
 	err := wait.PollImmediate(1*time.Second, 10*time.Minute, func() (bool, error) {
@@ -152,3 +361,92 @@ func makeIdempotentAndRegister(t *testing.T, cleanupFunc func()) func() {
 	t.Cleanup(out)
 	return out
 }
+
+// Waits for all of the build objects (pods and / or OpenShift Image Builder
+// Builds) targeting the given MachineConfigPool to be deleted.
+func waitForBuildObjectsToBeDeleted(t *testing.T, cs *framework.ClientSet, poolName string) {
+	selector := fmt.Sprintf("machineconfiguration.openshift.io/targetMachineConfigPool=%s", poolName)
+
+	err := wait.PollImmediate(1*time.Second, 5*time.Minute, func() (bool, error) {
+		pods, err := cs.CoreV1Interface.Pods(ctrlcommon.MCONamespace).List(context.TODO(), metav1.ListOptions{LabelSelector: selector})
+		if err != nil {
+			return false, err
+		}
+
+		builds, err := cs.BuildV1Interface.Builds(ctrlcommon.MCONamespace).List(context.TODO(), metav1.ListOptions{LabelSelector: selector})
+		if err != nil {
+			return false, err
+		}
+
+		return len(pods.Items) == 0 && len(builds.Items) == 0, nil
+	})
+
+	require.NoError(t, err, "build objects for MachineConfigPool %q were not deleted", poolName)
+}
+
+// Gets the nodes to target for a test. Explicitly provided target nodes take
+// precedence over the target node selector. Only worker nodes are considered
+// since they are the only nodes which may be moved into the layered pool.
+func getTargetNodes(t *testing.T, cs *framework.ClientSet, testOpts onClusterBuildTestOpts) []*corev1.Node {
+	if len(testOpts.targetNodes) != 0 || testOpts.targetNodeSelector == "" {
+		return testOpts.targetNodes
+	}
+
+	nodes, err := helpers.GetNodesByLabelSelector(cs, testOpts.targetNodeSelector)
+	require.NoError(t, err)
+
+	targetNodes := []*corev1.Node{}
+	for _, node := range nodes {
+		node := node
+		if _, ok := node.Labels[helpers.MCPNameToRole("worker")]; ok {
+			targetNodes = append(targetNodes, &node)
+		}
+	}
+
+	require.NotEmpty(t, targetNodes, "no worker nodes match target node selector %q", testOpts.targetNodeSelector)
+
+	return targetNodes
+}
+
+// Moves the given node into the given MachineConfigPool by labeling it with
+// the pool's role. Registers and returns an idempotent function which returns
+// the node to the worker pool and waits for it to be done at the worker pool's
+// config and image. This allows one to target individual nodes without
+// opting the whole worker pool into layering.
+func addNodeToPool(t *testing.T, cs *framework.ClientSet, node *corev1.Node, poolName string) func() {
+	unlabel := helpers.LabelNode(t, cs, *node, helpers.MCPNameToRole(poolName))
+	t.Logf("Added node %s to MachineConfigPool %s", node.Name, poolName)
+
+	return makeIdempotentAndRegister(t, func() {
+		unlabel()
+		t.Logf("Returning node %s to MachineConfigPool worker", node.Name)
+		require.NoError(t, waitForNodeToBeDoneAtPool(t, cs, node, "worker"))
+	})
+}
+
+// Waits for the given node to be done at the current config and image of the
+// given MachineConfigPool.
+func waitForNodeToBeDoneAtPool(t *testing.T, cs *framework.ClientSet, node *corev1.Node, poolName string) error {
+	startTime := time.Now()
+
+	err := wait.PollImmediate(2*time.Second, 20*time.Minute, func() (bool, error) {
+		mcp, err := cs.MachineconfigurationV1Interface.MachineConfigPools().Get(context.TODO(), poolName, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+
+		n, err := cs.CoreV1Interface.Nodes().Get(context.TODO(), node.Name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+
+		return ctrlcommon.NewLayeredNodeState(n).IsDoneAt(mcp), nil
+	})
+
+	if err != nil {
+		return fmt.Errorf("node %s did not become done at MachineConfigPool %s (waited %v): %w", node.Name, poolName, time.Since(startTime), err)
+	}
+
+	t.Logf("Node %s is done at MachineConfigPool %s (waited %v)", node.Name, poolName, time.Since(startTime))
+	return nil
+}
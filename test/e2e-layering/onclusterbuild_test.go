@@ -3,19 +3,23 @@ package e2e_layering
 import (
 	"context"
 	"flag"
+	"fmt"
+	"strings"
 	"testing"
+	"time"
 
 	corev1 "k8s.io/api/core/v1"
-	"k8s.io/client-go/util/retry"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
+	configv1 "github.com/openshift/api/config/v1"
 	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
 	"github.com/openshift/machine-config-operator/pkg/controller/build"
+	"github.com/openshift/machine-config-operator/pkg/controller/build/clients"
 	"github.com/openshift/machine-config-operator/test/framework"
 	"github.com/openshift/machine-config-operator/test/helpers"
 	"github.com/stretchr/testify/require"
 
 	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 const (
@@ -29,20 +33,42 @@ const (
 	globalPullSecretCloneName string = "global-pull-secret-copy"
 
 	// The custom Dockerfile content to build for the tests.
-	cowsayDockerfile string = `FROM quay.io/centos/centos:stream9 AS centos
-RUN dnf install -y epel-release
+	// The CentOS Stream and EPEL repositories it installs from are injected by
+	// the build controller; see createRPMRepositories().
+	cowsayDockerfile string = `FROM configs AS final
+RUN rpm-ostree install cowsay`
+
+	// The names of the ConfigMap and Secret containing the RPM repositories and
+	// GPG keys to install into the image.
+	rpmRepositoriesConfigMapName string = "e2e-rpm-repositories"
+	rpmGPGKeysSecretName         string = "e2e-rpm-gpg-keys"
+
+	// The name of the pull secret which can also pull from the internal image
+	// registry.
+	mirrorPullSecretName string = "mirror-pull-secret"
+
+	// A source repository which does not exist and whose images can only be
+	// pulled through its mirror.
+	disconnectedSourceRepository string = "registry.disconnected.invalid/openshift/rhel-coreos"
+
+	// The custom Dockerfile content to build for the disconnected test; it is
+	// formatted with the source repository and the digest of the mirrored image.
+	disconnectedDockerfile string = `FROM %s@%s AS mirrored
 FROM configs AS final
-COPY --from=centos /etc/yum.repos.d /etc/yum.repos.d
-COPY --from=centos /etc/pki/rpm-gpg/RPM-GPG-KEY-* /etc/pki/rpm-gpg/
-RUN sed -i 's/\$stream/9-stream/g' /etc/yum.repos.d/centos*.repo && \
-    rpm-ostree install cowsay`
+COPY --from=mirrored /etc/os-release /etc/mirrored-os-release`
 )
 
 var skipCleanup bool
 
+var targetNodeSelector string
+
 func init() {
 	// Skips running the cleanup functions. Useful for debugging tests.
 	flag.BoolVar(&skipCleanup, "skip-cleanup", false, "Skips running the cleanup functions")
+	// Targets specific worker nodes instead of a random one for tests which roll
+	// out an image. Useful for running against clusters whose nodes cannot be
+	// destroyed.
+	flag.StringVar(&targetNodeSelector, "target-node-selector", "", "Label selector for the worker nodes to roll the built image out to; they are returned to the worker pool afterward")
 }
 
 // Holds elements common for each on-cluster build tests.
@@ -56,8 +82,19 @@ type onClusterBuildTestOpts struct {
 	// What node(s) should be targeted for the test.
 	targetNodes []*corev1.Node
 
+	// A label selector for the worker node(s) that should be targeted for the
+	// test. Ignored if targetNodes is set.
+	targetNodeSelector string
+
 	// What MachineConfigPool name to use for the test.
 	poolName string
+
+	// The Secret used to pull the base image(s). Defaults to the global pull
+	// secret copy.
+	baseImagePullSecretName string
+
+	// Whether to install the CentOS Stream and EPEL repositories into the image.
+	rpmRepositories bool
 }
 
 // Tests that an on-cluster build can be performed with the OpenShift Image Builder.
@@ -68,6 +105,7 @@ func TestOnClusterBuildsOpenshiftImageBuilder(t *testing.T) {
 		customDockerfiles: map[string]string{
 			layeredMCPName: cowsayDockerfile,
 		},
+		rpmRepositories: true,
 	})
 }
 
@@ -79,105 +117,201 @@ func TestOnClusterBuildsCustomPodBuilder(t *testing.T) {
 		customDockerfiles: map[string]string{
 			layeredMCPName: cowsayDockerfile,
 		},
+		rpmRepositories: true,
 	})
 }
 
 // Tests that an on-cluster build can be performed and that the resulting image
 // is rolled out to an opted-in node.
 func TestOnClusterBuildRollsOutImage(t *testing.T) {
-	imagePullspec := runOnClusterBuildTest(t, onClusterBuildTestOpts{
+	testOpts := onClusterBuildTestOpts{
 		imageBuilderType: build.OpenshiftImageBuilder,
 		poolName:         layeredMCPName,
 		customDockerfiles: map[string]string{
 			layeredMCPName: cowsayDockerfile,
 		},
-	})
+		rpmRepositories:    true,
+		targetNodeSelector: targetNodeSelector,
+	}
+
+	imagePullspec := runOnClusterBuildTest(t, testOpts)
 
 	cs := framework.NewClientSet("")
-	node := helpers.GetRandomNode(t, cs, "worker")
-	t.Cleanup(makeIdempotentAndRegister(t, func() {
-		helpers.DeleteNodeAndMachine(t, node)
-	}))
-	helpers.LabelNode(t, cs, node, helpers.MCPNameToRole(layeredMCPName))
-	helpers.WaitForNodeImageChange(t, cs, node, imagePullspec)
 
-	t.Log(helpers.ExecCmdOnNode(t, cs, node, "chroot", "/rootfs", "cowsay", "Moo!"))
+	for _, node := range rollOutImageToTargetNodes(t, cs, testOpts, imagePullspec) {
+		t.Log(helpers.ExecCmdOnNode(t, cs, *node, "chroot", "/rootfs", "cowsay", "Moo!"))
+	}
 }
 
-// Sets up and performs an on-cluster build for a given set of parameters.
-// Returns the built image pullspec for later consumption.
-func runOnClusterBuildTest(t *testing.T, testOpts onClusterBuildTestOpts) string {
+// Tests that an on-cluster build whose Dockerfile refers to an image which can
+// only be pulled through an ImageDigestMirrorSet succeeds and that the
+// resulting image is rolled out. The ImageDigestMirrorSet points a source
+// registry which does not exist at an ImageStream in the internal image
+// registry and never contacts the source, as on a disconnected cluster.
+func TestOnClusterBuildDisconnected(t *testing.T) {
 	cs := framework.NewClientSet("")
 
-	t.Logf("Running with ImageBuilder type: %s", testOpts.imageBuilderType)
+	baseImagePullspec := getBaseOSImagePullspec(t, cs)
+	digest := baseImagePullspec[strings.Index(baseImagePullspec, "@")+1:]
+	require.True(t, strings.HasPrefix(digest, "sha256:"), "base OS image %s is not referenced by digest", baseImagePullspec)
+
+	t.Cleanup(createMirrorPullSecret(t, cs, mirrorPullSecretName))
+
+	mirror, cleanup := createImagestreamMirror(t, cs, "os-image-mirror", baseImagePullspec)
+	t.Cleanup(cleanup)
+
+	idms := &configv1.ImageDigestMirrorSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "e2e-layering-disconnected",
+		},
+		Spec: configv1.ImageDigestMirrorSetSpec{
+			ImageDigestMirrors: []configv1.ImageDigestMirrors{
+				{
+					Source:             disconnectedSourceRepository,
+					Mirrors:            []configv1.ImageMirror{configv1.ImageMirror(mirror)},
+					MirrorSourcePolicy: configv1.NeverContactSource,
+				},
+			},
+		},
+	}
+
+	t.Cleanup(makeIdempotentAndRegister(t, helpers.ApplyImageDigestMirrorSet(t, cs, idms, "master", "worker")))
+
+	testOpts := onClusterBuildTestOpts{
+		imageBuilderType: build.CustomPodImageBuilder,
+		poolName:         layeredMCPName,
+		customDockerfiles: map[string]string{
+			layeredMCPName: fmt.Sprintf(disconnectedDockerfile, disconnectedSourceRepository, digest),
+		},
+		targetNodeSelector:      targetNodeSelector,
+		baseImagePullSecretName: mirrorPullSecretName,
+	}
+
+	imagePullspec := runOnClusterBuildTest(t, testOpts)
+
+	for _, node := range rollOutImageToTargetNodes(t, cs, testOpts, imagePullspec) {
+		helpers.AssertFileOnNode(t, cs, *node, "/etc/mirrored-os-release")
+	}
+}
+
+// Moves the target nodes into the MachineConfigPool under test and waits for
+// them to roll out the given image. Returns the target nodes.
+func rollOutImageToTargetNodes(t *testing.T, cs *framework.ClientSet, testOpts onClusterBuildTestOpts, imagePullspec string) []*corev1.Node {
+	// Without a target node selector, we use a random worker node which is
+	// destroyed afterward instead of being returned to the worker pool.
+	if testOpts.targetNodeSelector == "" {
+		node := helpers.GetRandomNode(t, cs, "worker")
+		t.Cleanup(makeIdempotentAndRegister(t, func() {
+			helpers.DeleteNodeAndMachine(t, node)
+		}))
+		testOpts.targetNodes = []*corev1.Node{&node}
+	}
+
+	targetNodes := getTargetNodes(t, cs, testOpts)
+
+	for _, node := range targetNodes {
+		if testOpts.targetNodeSelector == "" {
+			helpers.LabelNode(t, cs, *node, helpers.MCPNameToRole(testOpts.poolName))
+		} else {
+			addNodeToPool(t, cs, node, testOpts.poolName)
+		}
+	}
+
+	for _, node := range targetNodes {
+		require.NoError(t, helpers.WaitForNodeImageChange(t, cs, *node, imagePullspec))
+	}
+
+	return targetNodes
+}
+
+// Tests that opting a MachineConfigPool out of layering while its build is
+// running cancels the build, removes its build objects, and returns the pool
+// to a clean non-layered state.
+func TestOnClusterBuildOptOutMidBuild(t *testing.T) {
+	cs := framework.NewClientSet("")
+
+	testOpts := onClusterBuildTestOpts{
+		imageBuilderType: build.OpenshiftImageBuilder,
+		poolName:         layeredMCPName,
+		customDockerfiles: map[string]string{
+			layeredMCPName: cowsayDockerfile,
+		},
+		rpmRepositories: true,
+	}
 
 	prepareForTest(t, cs, testOpts)
 
-	optPoolIntoLayering(t, cs, testOpts.poolName)
+	optOut := optPoolIntoLayering(t, cs, testOpts.poolName)
 
 	t.Logf("Wait for build to start")
 	waitForPoolToReachState(t, cs, testOpts.poolName, func(mcp *mcfgv1.MachineConfigPool) bool {
 		return ctrlcommon.NewLayeredPoolState(mcp).IsBuilding()
 	})
 
-	t.Logf("Build started! Waiting for completion...")
-	imagePullspec := ""
+	t.Logf("Build started! Opting out of layering...")
+	optOut()
+
 	waitForPoolToReachState(t, cs, testOpts.poolName, func(mcp *mcfgv1.MachineConfigPool) bool {
 		lps := ctrlcommon.NewLayeredPoolState(mcp)
-		if lps.HasOSImage() && lps.IsBuildSuccess() {
-			imagePullspec = lps.GetOSImage()
-			return true
-		}
-
-		if lps.IsBuildFailure() {
-			t.Fatalf("Build unexpectedly failed.")
-		}
-
-		return false
+		return !lps.IsLayered() &&
+			!lps.HasOSImage() &&
+			!lps.HasBuildConditions() &&
+			!lps.IsAnyDegraded()
 	})
 
-	t.Logf("MachineConfigPool %q has finished building. Got image: %s", testOpts.poolName, imagePullspec)
+	waitForBuildObjectsToBeDeleted(t, cs, testOpts.poolName)
 
-	return imagePullspec
+	t.Logf("MachineConfigPool %q has returned to a non-layered state", testOpts.poolName)
+
+	require.NoError(t, helpers.WaitForPoolCompleteAny(t, cs, testOpts.poolName))
 }
 
-// Adds the layeringEnabled label to the target MachineConfigPool and registers
-// / returns a function to unlabel it.
-func optPoolIntoLayering(t *testing.T, cs *framework.ClientSet, pool string) func() {
-	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
-		mcp, err := cs.MachineconfigurationV1Interface.MachineConfigPools().Get(context.TODO(), pool, metav1.GetOptions{})
-		require.NoError(t, err)
+// Sets up and performs an on-cluster build for a given set of parameters.
+// Returns the built image pullspec for later consumption.
+func runOnClusterBuildTest(t *testing.T, testOpts onClusterBuildTestOpts) string {
+	cs := framework.NewClientSet("")
 
-		if mcp.Labels == nil {
-			mcp.Labels = map[string]string{}
-		}
+	t.Logf("Running with ImageBuilder type: %s", testOpts.imageBuilderType)
 
-		mcp.Labels[ctrlcommon.LayeringEnabledPoolLabel] = ""
+	prepareForTest(t, cs, testOpts)
 
-		_, err = cs.MachineconfigurationV1Interface.MachineConfigPools().Update(context.TODO(), mcp, metav1.UpdateOptions{})
-		if err == nil {
-			t.Logf("Added label %q to MachineConfigPool %s to opt into layering", ctrlcommon.LayeringEnabledPoolLabel, pool)
-		}
-		return err
+	optPoolIntoLayering(t, cs, testOpts.poolName)
+
+	t.Logf("Wait for build to start")
+	waitForPoolToReachState(t, cs, testOpts.poolName, func(mcp *mcfgv1.MachineConfigPool) bool {
+		return ctrlcommon.NewLayeredPoolState(mcp).IsBuilding()
 	})
 
+	t.Logf("Build started! Waiting for completion...")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+
+	status, err := newBuildClient(cs).WaitForBuild(ctx, testOpts.poolName)
+	require.NoError(t, err, "Build unexpectedly failed.")
+	imagePullspec := status.Image
+
+	t.Logf("MachineConfigPool %q has finished building. Got image: %s", testOpts.poolName, imagePullspec)
+
+	builds, err := newBuildClient(cs).GetBuilds(ctx, testOpts.poolName)
 	require.NoError(t, err)
+	require.NotEmpty(t, builds, "expected a build status for MachineConfigPool %q", testOpts.poolName)
+	require.Equal(t, ctrlcommon.ImageBuildPhaseSucceeded, builds[0].Phase)
+	require.Equal(t, imagePullspec, builds[0].Image)
 
-	return makeIdempotentAndRegister(t, func() {
-		err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
-			mcp, err := cs.MachineconfigurationV1Interface.MachineConfigPools().Get(context.TODO(), pool, metav1.GetOptions{})
-			require.NoError(t, err)
+	return imagePullspec
+}
 
-			delete(mcp.Labels, ctrlcommon.LayeringEnabledPoolLabel)
+// Opts the target MachineConfigPool into layering, which starts a build, and
+// registers / returns a function to opt it back out.
+func optPoolIntoLayering(t *testing.T, cs *framework.ClientSet, pool string) func() {
+	buildClient := newBuildClient(cs)
 
-			_, err = cs.MachineconfigurationV1Interface.MachineConfigPools().Update(context.TODO(), mcp, metav1.UpdateOptions{})
-			if err == nil {
-				t.Logf("Removed label %q to MachineConfigPool %s to opt out of layering", ctrlcommon.LayeringEnabledPoolLabel, pool)
-			}
-			return err
-		})
+	require.NoError(t, buildClient.TriggerBuild(context.TODO(), pool))
+	t.Logf("Added label %q to MachineConfigPool %s to opt into layering", ctrlcommon.LayeringEnabledPoolLabel, pool)
 
-		require.NoError(t, err)
+	return makeIdempotentAndRegister(t, func() {
+		require.NoError(t, buildClient.CancelBuild(context.TODO(), pool))
+		t.Logf("Removed label %q to MachineConfigPool %s to opt out of layering", ctrlcommon.LayeringEnabledPoolLabel, pool)
 	})
 }
 
@@ -185,6 +319,7 @@ func optPoolIntoLayering(t *testing.T, cs *framework.ClientSet, pool string) fun
 // - Gets the Docker Builder secret name from the MCO namespace.
 // - Creates the imagestream to use for the test.
 // - Clones the global pull secret into the MCO namespace.
+// - Creates the RPM repositories ConfigMap and GPG keys Secret, if requested.
 // - Creates the on-cluster-build-config ConfigMap.
 // - Creates the target MachineConfigPool and waits for it to get a rendered config.
 // - Creates the on-cluster-build-custom-dockerfile ConfigMap.
@@ -203,20 +338,25 @@ func prepareForTest(t *testing.T, cs *framework.ClientSet, testOpts onClusterBui
 	finalPullspec, err := getImagestreamPullspec(cs, imagestreamName)
 	require.NoError(t, err)
 
-	cmCleanup := createConfigMap(t, cs, &corev1.ConfigMap{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      build.OnClusterBuildConfigMapName,
-			Namespace: ctrlcommon.MCONamespace,
-		},
-		Data: map[string]string{
-			build.BaseImagePullSecretNameConfigKey:  globalPullSecretCloneName,
-			build.FinalImagePushSecretNameConfigKey: pushSecretName,
-			build.FinalImagePullspecConfigKey:       finalPullspec,
-			build.ImageBuilderTypeConfigMapKey:      testOpts.imageBuilderType,
-		},
-	})
-
-	t.Cleanup(cmCleanup)
+	baseImagePullSecretName := testOpts.baseImagePullSecretName
+	if baseImagePullSecretName == "" {
+		baseImagePullSecretName = globalPullSecretCloneName
+	}
+
+	additionalConfig := map[string]string{}
+	if testOpts.rpmRepositories {
+		t.Cleanup(createRPMRepositories(t, cs, rpmRepositoriesConfigMapName, rpmGPGKeysSecretName))
+		additionalConfig[build.RPMRepositoriesConfigMapNameConfigKey] = rpmRepositoriesConfigMapName
+		additionalConfig[build.RPMGPGKeysSecretNameConfigKey] = rpmGPGKeysSecretName
+	}
+
+	t.Cleanup(configureOnClusterBuilds(t, cs, clients.OnClusterBuildConfig{
+		BaseImagePullSecretName:  baseImagePullSecretName,
+		FinalImagePushSecretName: pushSecretName,
+		FinalImagePullspec:       finalPullspec,
+		ImageBuilderType:         testOpts.imageBuilderType,
+		AdditionalConfig:         additionalConfig,
+	}))
 
 	t.Cleanup(makeIdempotentAndRegister(t, helpers.CreateMCP(t, cs, testOpts.poolName)))
 
@@ -20,6 +20,7 @@ import (
 
 	ign3types "github.com/coreos/ignition/v2/config/v3_4/types"
 	"github.com/davecgh/go-spew/spew"
+	configv1 "github.com/openshift/api/config/v1"
 	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
 	"github.com/openshift/machine-config-operator/pkg/apihelpers"
 	"github.com/openshift/machine-config-operator/pkg/daemon/constants"
@@ -82,6 +83,56 @@ func MakeIdempotent(f func()) func() {
 	}
 }
 
+// Creates the given ImageDigestMirrorSet and waits for the given
+// MachineConfigPools to roll out the registries config rendered from it.
+// Returns an idempotent function which deletes the ImageDigestMirrorSet and
+// waits for the pools to roll out the registries config without it.
+func ApplyImageDigestMirrorSet(t *testing.T, cs *framework.ClientSet, idms *configv1.ImageDigestMirrorSet, pools ...string) func() {
+	waitForNewRenderedConfigs(t, cs, pools, func() {
+		_, err := cs.ImageDigestMirrorSets().Create(context.TODO(), idms, metav1.CreateOptions{})
+		require.NoError(t, err)
+		t.Logf("Created ImageDigestMirrorSet %q", idms.Name)
+	})
+
+	return MakeIdempotent(func() {
+		waitForNewRenderedConfigs(t, cs, pools, func() {
+			require.NoError(t, cs.ImageDigestMirrorSets().Delete(context.TODO(), idms.Name, metav1.DeleteOptions{}))
+			t.Logf("Deleted ImageDigestMirrorSet %q", idms.Name)
+		})
+	})
+}
+
+// Runs the given function, which is expected to change the rendered
+// MachineConfig of each of the given MachineConfigPools, and waits for each
+// pool to get a new rendered MachineConfig and complete its rollout.
+func waitForNewRenderedConfigs(t *testing.T, cs *framework.ClientSet, pools []string, changeFunc func()) {
+	previous := map[string]string{}
+	for _, pool := range pools {
+		mcp, err := cs.MachineConfigPools().Get(context.TODO(), pool, metav1.GetOptions{})
+		require.NoError(t, err)
+		previous[pool] = mcp.Spec.Configuration.Name
+	}
+
+	changeFunc()
+
+	for _, pool := range pools {
+		var current string
+
+		err := wait.PollUntilContextTimeout(context.TODO(), 2*time.Second, 5*time.Minute, true, func(ctx context.Context) (bool, error) {
+			mcp, err := cs.MachineConfigPools().Get(ctx, pool, metav1.GetOptions{})
+			if err != nil {
+				return false, err
+			}
+
+			current = mcp.Spec.Configuration.Name
+			return current != previous[pool], nil
+		})
+		require.NoError(t, err, "MachineConfigPool %q did not get a new rendered MachineConfig after %q", pool, previous[pool])
+
+		require.NoError(t, WaitForPoolComplete(t, cs, pool, current))
+	}
+}
+
 // Applies a MachineConfig to a given MachineConfigPool, if a MachineConfig is
 // provided. If a MachineConfig is not provided (i.e., nil), it will skip the
 // apply process and wait for the MachineConfigPool to include the "00-worker"
@@ -639,6 +690,19 @@ func GetNodesByRole(cs *framework.ClientSet, role string) ([]corev1.Node, error)
 	return nodes.Items, nil
 }
 
+// GetNodesByLabelSelector gets all nodes matching the given label selector
+func GetNodesByLabelSelector(cs *framework.ClientSet, selector string) ([]corev1.Node, error) {
+	if _, err := labels.Parse(selector); err != nil {
+		return nil, fmt.Errorf("invalid label selector %q: %w", selector, err)
+	}
+
+	nodes, err := cs.CoreV1Interface.Nodes().List(context.TODO(), metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return nil, err
+	}
+	return nodes.Items, nil
+}
+
 // CreateMCP create a machine config pool with name mcpName
 // it will also use mcpName as the label selector, so any node you want to be included
 // in the pool should have a label node-role.kubernetes.io/mcpName = ""